@@ -0,0 +1,57 @@
+#!/usr/bin/env bash
+#
+# Generates the Python and JavaScript clients of the OpenObserve HTTP API from
+# its OpenAPI specification.
+#
+#   sdk/generate.sh                 export the specification with cargo
+#   sdk/generate.sh openapi.json    use an existing specification file
+#   sdk/generate.sh http://localhost:5080/api-doc/openapi.json
+#
+# Requires node (npx) and java for openapi-generator.
+
+set -euo pipefail
+
+SDK_DIR="$(cd "$(dirname "$0")" && pwd)"
+ROOT_DIR="$(dirname "$SDK_DIR")"
+SPEC="$SDK_DIR/openapi.json"
+GENERATOR_VERSION="${OPENAPI_GENERATOR_VERSION:-7.5.0}"
+
+case "${1:-}" in
+  "")
+    mkdir -p "$ROOT_DIR/web/dist"
+    (cd "$ROOT_DIR" && cargo run --quiet -- openapi -o "$SPEC")
+    ;;
+  http://* | https://*)
+    curl -fsSL "$1" -o "$SPEC"
+    ;;
+  *)
+    cp "$1" "$SPEC"
+    ;;
+esac
+
+VERSION="${SDK_VERSION:-$(node -p "require('$SPEC').info.version")}"
+
+generate() {
+  npx --yes "@openapitools/openapi-generator-cli@2.13.4" version-manager set "$GENERATOR_VERSION" >/dev/null
+  npx --yes "@openapitools/openapi-generator-cli@2.13.4" generate \
+    --input-spec "$SPEC" \
+    --skip-validate-spec \
+    "$@"
+}
+
+rm -rf "$SDK_DIR/python/openobserve_api" "$SDK_DIR/js/src/generated"
+
+generate \
+  --generator-name python \
+  --config "$SDK_DIR/python/openapi-generator.yaml" \
+  --output "$SDK_DIR/python" \
+  --additional-properties "packageVersion=$VERSION"
+
+generate \
+  --generator-name typescript-fetch \
+  --config "$SDK_DIR/js/openapi-generator.yaml" \
+  --output "$SDK_DIR/js/src/generated"
+
+(cd "$SDK_DIR/js" && npm pkg set version="$VERSION")
+
+echo "generated the clients of OpenObserve $VERSION"
@@ -0,0 +1,225 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package openobserve is the Go client of the OpenObserve HTTP API, for
+// ingesting logs, forwarding OTLP payloads, searching and managing streams.
+package openobserve
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryWait  = 500 * time.Millisecond
+	maxRetryWait      = 10 * time.Second
+)
+
+// Client calls the API of one organization. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	org        string
+	auth       string
+	httpClient *http.Client
+	maxRetries int
+	retryWait  time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBasicAuth authenticates with the email of the user and its password or
+// ingestion token.
+func WithBasicAuth(user, password string) Option {
+	return func(c *Client) {
+		c.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+}
+
+// WithHTTPClient replaces the default http client, with a 60s timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetry sets how many times a request failing with a network error, 429
+// or 5xx status is retried, and the wait before the first retry, doubled on
+// each retry.
+func WithRetry(maxRetries int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// NewClient returns a client of the organization org of the server at
+// baseURL, like http://localhost:5080.
+func NewClient(baseURL, org string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		org:        org,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+		maxRetries: defaultMaxRetries,
+		retryWait:  defaultRetryWait,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Org returns the organization of the client.
+func (c *Client) Org() string {
+	return c.org
+}
+
+// APIError is returned for the responses with an error status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("openobserve: status %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *APIError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// orgPath returns the path of an endpoint of the organization, the segments
+// are escaped.
+func (c *Client) orgPath(segments ...string) string {
+	var b strings.Builder
+	b.WriteString("/api/")
+	b.WriteString(url.PathEscape(c.org))
+	for _, s := range segments {
+		b.WriteByte('/')
+		b.WriteString(url.PathEscape(s))
+	}
+	return b.String()
+}
+
+// doJSON sends in as the JSON body, when not nil, and decodes the response
+// into out, when not nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	header := http.Header{}
+	if body != nil {
+		header.Set("Content-Type", "application/json")
+	}
+	return c.do(ctx, method, path, query, header, body, out)
+}
+
+// do sends the request, retried on network errors, 429 and 5xx statuses.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte, out any) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	wait := c.retryWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, u, header, body)
+		if err == nil {
+			err = decodeResponse(resp, out)
+		}
+		if err == nil || attempt >= c.maxRetries || !retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxRetryWait)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, u string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+	return c.httpClient.Do(req)
+}
+
+func decodeResponse(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return &APIError{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// errorMessage extracts the message of the error responses of the server,
+// which are {"code": 400, "message": "..."}.
+func errorMessage(data []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(data, &resp) == nil {
+		if resp.Message != "" {
+			return resp.Message
+		}
+		if resp.Error != "" {
+			return resp.Error
+		}
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.retryable()
+	}
+	// network errors, but not the cancellation of the caller
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package openobserve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "root@example.com" || pass != "secret" {
+			t.Errorf("unexpected auth %q %q", user, pass)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"list": [{"name": "default", "stream_type": "logs", "stats": {"doc_num": 10}}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "default", WithBasicAuth("root@example.com", "secret"), WithRetry(3, time.Millisecond))
+	streams, err := c.ListStreams(context.Background(), StreamLogs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 3 || len(streams) != 1 || streams[0].Stats.DocNum != 10 {
+		t.Fatalf("unexpected result after %d calls: %+v", calls.Load(), streams)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code": 400, "message": "Stream not found"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "default", WithRetry(3, time.Millisecond))
+	err := c.DeleteStream(context.Background(), StreamLogs, "missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || apiErr.Message != "Stream not found" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("client errors should not be retried, got %d calls", calls.Load())
+	}
+}
+
+func TestSearch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/default/_search" || r.URL.Query().Get("type") != "logs" {
+			t.Errorf("unexpected url %s", r.URL)
+		}
+		var req searchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if req.Query.StartTime != 1_000_000 || req.Query.Size != 2 {
+			t.Errorf("unexpected request %+v", req)
+		}
+		hits := `[{"message": "a"}, {"message": "b"}]`
+		if req.Query.From > 0 {
+			hits = `[{"message": "c"}]`
+		}
+		w.Write([]byte(`{"took": 1, "total": 3, "hits": ` + hits + `}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "default")
+	q := Query{
+		SQL:       `SELECT * FROM "default"`,
+		StartTime: time.Unix(1, 0),
+		EndTime:   time.Unix(2, 0),
+		Size:      2,
+	}
+	type hit struct {
+		Message string `json:"message"`
+	}
+	var messages []string
+	err := c.SearchAll(context.Background(), q, func(resp *SearchResponse) bool {
+		hits, err := DecodeHits[hit](resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range hits {
+			messages = append(messages, h.Message)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || messages[2] != "c" {
+		t.Fatalf("unexpected hits %v", messages)
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/default/app/_json" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var records []map[string]any
+		if err := json.Unmarshal(body, &records); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		batches = append(batches, len(records))
+		mu.Unlock()
+		w.Write([]byte(`{"code": 200, "status": [{"name": "app", "successful": 1, "failed": 0}]}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "default")
+	b := c.NewBatcher("app", BatcherConfig{MaxRecords: 2, FlushInterval: time.Hour})
+	for i := 0; i < 5; i++ {
+		if err := b.Add(map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Close()
+	if err := b.Add(map[string]any{}); !errors.Is(err, ErrBatcherClosed) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	total := 0
+	for _, n := range batches {
+		if n > 2 {
+			t.Fatalf("batch of %d records", n)
+		}
+		total += n
+	}
+	if total != 5 {
+		t.Fatalf("%d records sent", total)
+	}
+}
+
+func TestOTLPHeaders(t *testing.T) {
+	c := NewClient("http://localhost:5080/", "my org", WithBasicAuth("a", "b"))
+	if got := c.OTLPEndpoint(SignalTraces); got != "http://localhost:5080/api/my%20org/v1/traces" {
+		t.Fatalf("unexpected endpoint %s", got)
+	}
+	headers := c.OTLPHeaders("app")
+	if headers["organization"] != "my org" || headers["stream-name"] != "app" || headers["Authorization"] != "Basic YTpi" {
+		t.Fatalf("unexpected headers %v", headers)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/openobserve/openobserve/sdk/go
+
+go 1.21
@@ -0,0 +1,201 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package openobserve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IngestResponse is the result of an ingestion, by stream.
+type IngestResponse struct {
+	Code   int            `json:"code"`
+	Status []StreamStatus `json:"status"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// StreamStatus counts the records ingested into a stream.
+type StreamStatus struct {
+	Name       string `json:"name"`
+	Successful int    `json:"successful"`
+	Failed     int    `json:"failed"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Failed returns the number of records which were not ingested.
+func (r *IngestResponse) Failed() int {
+	failed := 0
+	for _, s := range r.Status {
+		failed += s.Failed
+	}
+	return failed
+}
+
+// Ingest sends the log records to the stream in one request. Records are
+// marshalled to JSON objects, their `_timestamp` field, in microseconds, is
+// set by the server when missing.
+func (c *Client) Ingest(ctx context.Context, stream string, records []any) (*IngestResponse, error) {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	var resp IngestResponse
+	header := http.Header{"Content-Type": {"application/json"}}
+	if err := c.do(ctx, http.MethodPost, c.orgPath(stream, "_json"), nil, header, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BatcherConfig configures a Batcher, zero values use the defaults.
+type BatcherConfig struct {
+	// MaxRecords sends the batch once it has that many records, 1000 by default.
+	MaxRecords int
+	// MaxBytes sends the batch once its JSON size reaches it, 1 MB by default.
+	MaxBytes int
+	// FlushInterval sends the batch that old, 5s by default.
+	FlushInterval time.Duration
+	// OnError is called with the records of the batches which failed after
+	// the retries or were partly rejected, they are dropped otherwise.
+	OnError func(records []json.RawMessage, err error)
+}
+
+// Batcher buffers log records of a stream and ingests them in batches from a
+// background goroutine. It is safe for concurrent use, Close must be called
+// to send the last batch.
+type Batcher struct {
+	client *Client
+	stream string
+	cfg    BatcherConfig
+
+	mu      sync.Mutex
+	records []json.RawMessage
+	size    int
+	closed  bool
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// ErrBatcherClosed is returned when adding records to a closed Batcher.
+var ErrBatcherClosed = errors.New("openobserve: batcher closed")
+
+// NewBatcher starts a Batcher of the stream.
+func (c *Client) NewBatcher(stream string, cfg BatcherConfig) *Batcher {
+	if cfg.MaxRecords <= 0 {
+		cfg.MaxRecords = 1000
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 1 << 20
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	b := &Batcher{
+		client: c,
+		stream: stream,
+		cfg:    cfg,
+		flush:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Add buffers a record, the batch is sent once it is full.
+func (b *Batcher) Add(record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBatcherClosed
+	}
+	b.records = append(b.records, data)
+	b.size += len(data) + 1
+	if len(b.records) >= b.cfg.MaxRecords || b.size >= b.cfg.MaxBytes {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close sends the buffered records and stops the Batcher.
+func (b *Batcher) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	b.mu.Unlock()
+	close(b.done)
+	b.wg.Wait()
+}
+
+func (b *Batcher) run() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.flush:
+		case <-ticker.C:
+		case <-b.done:
+			b.send()
+			return
+		}
+		b.send()
+	}
+}
+
+// send ingests the buffered records, in several requests when more records
+// than a batch were added meanwhile.
+func (b *Batcher) send() {
+	b.mu.Lock()
+	records := b.records
+	b.records = nil
+	b.size = 0
+	b.mu.Unlock()
+
+	for len(records) > 0 {
+		n := min(len(records), b.cfg.MaxRecords)
+		batch := records[:n]
+		records = records[n:]
+		items := make([]any, len(batch))
+		for i, r := range batch {
+			items[i] = r
+		}
+		resp, err := b.client.Ingest(context.Background(), b.stream, items)
+		if err == nil && resp.Failed() > 0 {
+			err = fmt.Errorf("openobserve: %d records were not ingested", resp.Failed())
+		}
+		if err != nil && b.cfg.OnError != nil {
+			b.cfg.OnError(batch, err)
+		}
+	}
+}
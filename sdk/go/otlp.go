@@ -0,0 +1,68 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package openobserve
+
+import (
+	"context"
+	"net/http"
+)
+
+// Signal is an OTLP signal type.
+type Signal string
+
+const (
+	SignalLogs    Signal = "logs"
+	SignalMetrics Signal = "metrics"
+	SignalTraces  Signal = "traces"
+)
+
+const (
+	// ContentTypeProtobuf is the content type of binary OTLP payloads.
+	ContentTypeProtobuf = "application/x-protobuf"
+	// ContentTypeJSON is the content type of JSON OTLP payloads.
+	ContentTypeJSON = "application/json"
+)
+
+// OTLPEndpoint returns the OTLP/HTTP url of the signal, for the exporters of
+// the OpenTelemetry SDKs.
+func (c *Client) OTLPEndpoint(signal Signal) string {
+	return c.baseURL + c.orgPath("v1", string(signal))
+}
+
+// OTLPHeaders returns the headers to configure on the OTLP exporters, the
+// stream is optional and defaults to `default`.
+func (c *Client) OTLPHeaders(stream string) map[string]string {
+	headers := map[string]string{"organization": c.org}
+	if c.auth != "" {
+		headers["Authorization"] = c.auth
+	}
+	if stream != "" {
+		headers["stream-name"] = stream
+	}
+	return headers
+}
+
+// ExportOTLP forwards an already encoded OTLP export request, like the ones
+// received by a collector, with the retries of the client. The stream is
+// optional and defaults to `default`, metrics streams are named after the
+// metrics.
+func (c *Client) ExportOTLP(ctx context.Context, signal Signal, stream, contentType string, payload []byte) error {
+	header := http.Header{"Content-Type": {contentType}}
+	if stream != "" {
+		header.Set("stream-name", stream)
+	}
+	return c.do(ctx, http.MethodPost, c.orgPath("v1", string(signal)), nil, header, payload, nil)
+}
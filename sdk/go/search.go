@@ -0,0 +1,132 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package openobserve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// StreamType is the type of a stream.
+type StreamType string
+
+const (
+	StreamLogs             StreamType = "logs"
+	StreamMetrics          StreamType = "metrics"
+	StreamTraces           StreamType = "traces"
+	StreamEnrichmentTables StreamType = "enrichment_tables"
+)
+
+// Query is a SQL search, the time range is required.
+type Query struct {
+	SQL       string
+	StartTime time.Time
+	EndTime   time.Time
+	From      int64
+	// Size is the number of hits, the server default when 0
+	Size int64
+	// Type is the stream type of the query, logs when empty
+	Type StreamType
+	// Timeout of the query, the server default when 0
+	Timeout time.Duration
+}
+
+// SearchResponse is the result of a query, Hits are raw JSON objects to be
+// decoded with DecodeHits.
+type SearchResponse struct {
+	Took          int64             `json:"took"`
+	Total         int64             `json:"total"`
+	From          int64             `json:"from"`
+	Size          int64             `json:"size"`
+	Hits          []json.RawMessage `json:"hits"`
+	ScanSize      int64             `json:"scan_size"`
+	ScanRecords   int64             `json:"scan_records"`
+	CachedRatio   int64             `json:"cached_ratio"`
+	IsPartial     bool              `json:"is_partial"`
+	FunctionError string            `json:"function_error"`
+	TraceID       string            `json:"trace_id"`
+}
+
+type searchRequest struct {
+	Query   searchQuery `json:"query"`
+	Timeout int64       `json:"timeout,omitempty"`
+}
+
+type searchQuery struct {
+	SQL       string `json:"sql"`
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	From      int64  `json:"from"`
+	Size      int64  `json:"size,omitempty"`
+}
+
+// Search runs the query.
+func (c *Client) Search(ctx context.Context, q Query) (*SearchResponse, error) {
+	streamType := q.Type
+	if streamType == "" {
+		streamType = StreamLogs
+	}
+	req := searchRequest{
+		Query: searchQuery{
+			SQL:       q.SQL,
+			StartTime: q.StartTime.UnixMicro(),
+			EndTime:   q.EndTime.UnixMicro(),
+			From:      q.From,
+			Size:      q.Size,
+		},
+		Timeout: int64(q.Timeout / time.Second),
+	}
+	var resp SearchResponse
+	query := url.Values{"type": {string(streamType)}}
+	if err := c.doJSON(ctx, http.MethodPost, c.orgPath("_search"), query, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DecodeHits decodes the hits of the response into values of type T.
+func DecodeHits[T any](resp *SearchResponse) ([]T, error) {
+	hits := make([]T, 0, len(resp.Hits))
+	for _, raw := range resp.Hits {
+		var hit T
+		if err := json.Unmarshal(raw, &hit); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, nil
+}
+
+// SearchAll runs the query page by page, calling fn with the hits of each
+// page until a page is not full or fn returns false.
+func (c *Client) SearchAll(ctx context.Context, q Query, fn func(*SearchResponse) bool) error {
+	if q.Size <= 0 {
+		q.Size = 1000
+	}
+	for {
+		resp, err := c.Search(ctx, q)
+		if err != nil {
+			return err
+		}
+		if !fn(resp) || int64(len(resp.Hits)) < q.Size {
+			return nil
+		}
+		q.From += q.Size
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package openobserve
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Stream describes a stream of the organization.
+type Stream struct {
+	Name        string      `json:"name"`
+	StorageType string      `json:"storage_type"`
+	StreamType  StreamType  `json:"stream_type"`
+	Stats       StreamStats `json:"stats"`
+}
+
+// StreamStats are the statistics of a stream, times in microseconds and
+// sizes in MB.
+type StreamStats struct {
+	CreatedAt      int64   `json:"created_at"`
+	DocTimeMin     int64   `json:"doc_time_min"`
+	DocTimeMax     int64   `json:"doc_time_max"`
+	DocNum         int64   `json:"doc_num"`
+	FileNum        int64   `json:"file_num"`
+	StorageSize    float64 `json:"storage_size"`
+	CompressedSize float64 `json:"compressed_size"`
+}
+
+// Organization is an organization the user belongs to.
+type Organization struct {
+	ID         int64  `json:"id"`
+	Identifier string `json:"identifier"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+}
+
+// ListStreams returns the streams of the type, or of every type when empty.
+func (c *Client) ListStreams(ctx context.Context, streamType StreamType) ([]Stream, error) {
+	var query url.Values
+	if streamType != "" {
+		query = url.Values{"type": {string(streamType)}}
+	}
+	var resp struct {
+		List []Stream `json:"list"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, c.orgPath("streams"), query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.List, nil
+}
+
+// DeleteStream deletes the stream with its data.
+func (c *Client) DeleteStream(ctx context.Context, streamType StreamType, name string) error {
+	query := url.Values{"type": {string(streamType)}}
+	return c.doJSON(ctx, http.MethodDelete, c.orgPath("streams", name), query, nil, nil)
+}
+
+// ListOrganizations returns the organizations of the user.
+func (c *Client) ListOrganizations(ctx context.Context) ([]Organization, error) {
+	var resp struct {
+		Data []Organization `json:"data"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api/organizations", nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
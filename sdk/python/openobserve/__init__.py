@@ -0,0 +1,74 @@
+"""Client of the OpenObserve HTTP API.
+
+The API classes and models are generated from the OpenAPI specification into
+the ``openobserve_api`` package, this module only adds the authentication and
+the pagination on top of them.
+"""
+
+import base64
+from typing import Any, Dict, Iterator, Optional
+
+import openobserve_api
+from openobserve_api import ApiClient, Configuration, SearchApi, SearchQuery, SearchRequest
+
+__all__ = ["Client", "basic_auth", "openobserve_api"]
+
+
+def basic_auth(user: str, password: str) -> str:
+    """Returns the value of the Authorization header of a user."""
+    token = base64.b64encode(f"{user}:{password}".encode()).decode()
+    return f"Basic {token}"
+
+
+class Client:
+    """Holds the connection to one organization of an OpenObserve instance.
+
+    >>> client = Client("http://localhost:5080", "root@example.com", "Complexpass#123")
+    >>> streams = client.api(openobserve_api.StreamsApi).stream_list(client.org)
+    """
+
+    def __init__(
+        self,
+        url: str,
+        user: Optional[str] = None,
+        password: Optional[str] = None,
+        org: str = "default",
+        token: Optional[str] = None,
+    ):
+        config = Configuration(host=url.rstrip("/"))
+        if token is not None:
+            config.api_key["Authorization"] = token
+        elif user is not None:
+            config.api_key["Authorization"] = basic_auth(user, password or "")
+        self.api_client = ApiClient(config)
+        self.org = org
+
+    def api(self, cls):
+        """Returns an instance of a generated API class, e.g. ``openobserve_api.AlertsApi``."""
+        return cls(self.api_client)
+
+    def search(self, sql: str, start_time: int, end_time: int, offset: int = 0, size: int = 100):
+        """Runs one page of a SQL query, times are unix microseconds."""
+        query = SearchQuery(sql=sql, start_time=start_time, end_time=end_time, var_from=offset, size=size)
+        return self.api(SearchApi).search_sql(self.org, SearchRequest(query=query))
+
+    def search_all(
+        self, sql: str, start_time: int, end_time: int, page_size: int = 1000
+    ) -> Iterator[Dict[str, Any]]:
+        """Yields the hits of a SQL query, fetching them page by page."""
+        offset = 0
+        while True:
+            resp = self.search(sql, start_time, end_time, offset=offset, size=page_size)
+            yield from resp.hits
+            if len(resp.hits) < page_size:
+                return
+            offset += len(resp.hits)
+
+    def close(self):
+        self.api_client.close()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *args):
+        self.close()
@@ -17,9 +17,12 @@ use config::utils::file::set_permission;
 use infra::file_list as infra_file_list;
 
 use crate::{
-    cli::data::{
-        cli::{args as dataArgs, Cli as dataCli},
-        export, import, Context,
+    cli::{
+        data::{
+            cli::{args as dataArgs, Cli as dataCli},
+            export, import, Context,
+        },
+        generate,
     },
     common::{infra::config::USERS, meta, migration},
     service::{compact, db, file_list, users},
@@ -109,6 +112,28 @@ pub async fn cli() -> Result<bool, anyhow::Error> {
                         .help("the parquet file name"),
                 ),
             clap::Command::new("migrate-schemas").about("migrate from single row to row per schema version"),
+            clap::Command::new("synthetics-runner").about("run the synthetic checks of ZO_SYNTHETICS_LOCATION and report the results to ZO_SYNTHETICS_RUNNER_URL"),
+            clap::Command::new("generate-logs")
+                .about("send generated OTLP log records to the logs endpoint of an OpenObserve")
+                .args(generate::args(generate::Signal::Logs)),
+            clap::Command::new("generate-traces")
+                .about("send generated OTLP traces to the traces endpoint of an OpenObserve")
+                .args(generate::args(generate::Signal::Traces)),
+            clap::Command::new("generate-metrics")
+                .about("send generated OTLP counters, up/down counters, histograms and gauges to the metrics endpoint of an OpenObserve")
+                .args(generate::args(generate::Signal::Metrics)),
+            clap::Command::new("generate-correlated")
+                .about("send generated OTLP traces with the logs and the metrics of their requests, sharing their trace ids")
+                .args(generate::args(generate::Signal::Correlated)),
+            clap::Command::new("openapi")
+                .about("print the OpenAPI specification of the http api")
+                .arg(
+                    clap::Arg::new("output")
+                        .short('o')
+                        .long("output")
+                        .value_name("output")
+                        .help("write the specification to this file instead of stdout"),
+                ),
         ])
         .get_matches();
 
@@ -132,6 +157,33 @@ pub async fn cli() -> Result<bool, anyhow::Error> {
         }
         return Ok(true);
     }
+    if name == "synthetics-runner" {
+        crate::service::synthetics::runner::run().await?;
+        return Ok(true);
+    }
+    let signal = match name {
+        "generate-logs" => Some(generate::Signal::Logs),
+        "generate-traces" => Some(generate::Signal::Traces),
+        "generate-metrics" => Some(generate::Signal::Metrics),
+        "generate-correlated" => Some(generate::Signal::Correlated),
+        _ => None,
+    };
+    if let Some(signal) = signal {
+        generate::run(signal, generate::Options::from_matches(command)?).await?;
+        return Ok(true);
+    }
+    if name == "openapi" {
+        use utoipa::OpenApi;
+        let spec = crate::handler::http::router::openapi::ApiDoc::openapi().to_pretty_json()?;
+        match command.get_one::<String>("output") {
+            Some(path) => {
+                std::fs::write(path, spec)?;
+                println!("openapi specification written to {}", path);
+            }
+            None => println!("{spec}"),
+        }
+        return Ok(true);
+    }
 
     // init infra, create data dir & tables
     infra::init().await.expect("infra init failed");
@@ -71,6 +71,7 @@ impl Context for Export {
             clusters: vec![],
             timeout: 0,
             search_type,
+            search_event_context: None,
         };
 
         match SearchService::search("", &c.org, stream_type, None, &req).await {
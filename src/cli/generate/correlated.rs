@@ -0,0 +1,295 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Traces with the logs and the metrics of the same requests, to test the
+//! navigation between the signals. Every server span gets a log record with
+//! its trace and span ids, and the request duration histograms of each service
+//! carry exemplars pointing at the spans.
+
+use std::collections::BTreeMap;
+
+use opentelemetry_proto::tonic::{
+    collector::{
+        logs::v1::ExportLogsServiceRequest, metrics::v1::ExportMetricsServiceRequest,
+        trace::v1::ExportTraceServiceRequest,
+    },
+    common::v1::{any_value::Value, AnyValue, KeyValue},
+    logs::v1::{LogRecord, ResourceLogs, ScopeLogs},
+    metrics::v1::{
+        exemplar, metric::Data, AggregationTemporality, Exemplar, Histogram, HistogramDataPoint,
+        Metric, ResourceMetrics, ScopeMetrics,
+    },
+    trace::v1::{span::SpanKind, status::StatusCode, Span},
+};
+use rand::Rng;
+
+use super::{string_attr, traces};
+
+/// Name of the histogram of the durations of the requests
+pub(crate) const DURATION_METRIC: &str = "http_server_duration_milliseconds";
+
+/// Upper bounds of the buckets of the duration histograms, in milliseconds
+const BOUNDS: [f64; 10] = [
+    5.0, 10.0, 25.0, 50.0, 100.0, 250.0, 500.0, 1_000.0, 2_500.0, 5_000.0,
+];
+
+/// Generates `size` traces ending before `now` with the logs and the metrics of
+/// their server spans
+pub(crate) fn generate(
+    rng: &mut impl Rng,
+    size: usize,
+    now: i64,
+) -> (
+    ExportTraceServiceRequest,
+    ExportLogsServiceRequest,
+    ExportMetricsServiceRequest,
+) {
+    let traces = traces::generate(rng, size, now);
+    let mut resource_logs = Vec::with_capacity(traces.resource_spans.len());
+    let mut resource_metrics = Vec::with_capacity(traces.resource_spans.len());
+    for resource in traces.resource_spans.iter() {
+        let servers = resource
+            .scope_spans
+            .iter()
+            .flat_map(|s| s.spans.iter())
+            .filter(|s| s.kind == SpanKind::Server as i32)
+            .collect::<Vec<_>>();
+        if servers.is_empty() {
+            continue;
+        }
+        let scope = resource.scope_spans.first().and_then(|s| s.scope.clone());
+        let mut log_records = servers.iter().map(|s| log_record(s)).collect::<Vec<_>>();
+        log_records.sort_by_key(|r| r.time_unix_nano);
+        resource_logs.push(ResourceLogs {
+            resource: resource.resource.clone(),
+            scope_logs: vec![ScopeLogs {
+                scope: scope.clone(),
+                log_records,
+                ..Default::default()
+            }],
+            ..Default::default()
+        });
+        resource_metrics.push(ResourceMetrics {
+            resource: resource.resource.clone(),
+            scope_metrics: vec![ScopeMetrics {
+                scope,
+                metrics: vec![duration_histogram(&servers, now as u64)],
+                ..Default::default()
+            }],
+            ..Default::default()
+        });
+    }
+    (
+        traces,
+        ExportLogsServiceRequest { resource_logs },
+        ExportMetricsServiceRequest { resource_metrics },
+    )
+}
+
+/// Returns the log record written at the end of a request
+fn log_record(span: &Span) -> LogRecord {
+    let failed = is_error(span);
+    let status = if failed { 500 } else { 200 };
+    let duration = duration_ms(span);
+    let message = if failed {
+        format!(
+            "{} failed with {status} after {duration:.0}ms: upstream request failed",
+            span.name
+        )
+    } else {
+        format!("{} completed with {status} in {duration:.0}ms", span.name)
+    };
+    LogRecord {
+        time_unix_nano: span.end_time_unix_nano,
+        observed_time_unix_nano: span.end_time_unix_nano,
+        severity_number: if failed { 17 } else { 9 },
+        severity_text: if failed { "ERROR" } else { "INFO" }.to_string(),
+        body: Some(AnyValue {
+            value: Some(Value::StringValue(message)),
+        }),
+        attributes: vec![
+            string_attr("http.route", route(span)),
+            KeyValue {
+                key: "http.status_code".to_string(),
+                value: Some(AnyValue {
+                    value: Some(Value::IntValue(status)),
+                }),
+            },
+            KeyValue {
+                key: "duration_ms".to_string(),
+                value: Some(AnyValue {
+                    value: Some(Value::DoubleValue(duration)),
+                }),
+            },
+        ],
+        trace_id: span.trace_id.clone(),
+        span_id: span.span_id.clone(),
+        ..Default::default()
+    }
+}
+
+/// Returns the histogram of the durations of the requests, one data point per
+/// route and status, keeping the last span of each bucket as its exemplar
+fn duration_histogram(servers: &[&Span], now: u64) -> Metric {
+    let mut points: BTreeMap<(&str, bool), Vec<&Span>> = BTreeMap::new();
+    for span in servers {
+        points
+            .entry((route(span), is_error(span)))
+            .or_default()
+            .push(span);
+    }
+    let start = servers
+        .iter()
+        .map(|s| s.start_time_unix_nano)
+        .min()
+        .unwrap_or(now);
+    let data_points = points
+        .into_iter()
+        .map(|((route, failed), spans)| {
+            let mut bucket_counts = vec![0; BOUNDS.len() + 1];
+            let mut exemplars: Vec<Option<Exemplar>> = vec![None; BOUNDS.len() + 1];
+            let mut sum = 0.0;
+            let mut min = f64::MAX;
+            let mut max = f64::MIN;
+            for span in spans.iter() {
+                let duration = duration_ms(span);
+                let bucket = BOUNDS.partition_point(|b| *b < duration);
+                bucket_counts[bucket] += 1;
+                sum += duration;
+                min = min.min(duration);
+                max = max.max(duration);
+                let newer = exemplars[bucket]
+                    .as_ref()
+                    .map_or(true, |e| e.time_unix_nano <= span.end_time_unix_nano);
+                if newer {
+                    exemplars[bucket] = Some(Exemplar {
+                        filtered_attributes: vec![],
+                        time_unix_nano: span.end_time_unix_nano,
+                        span_id: span.span_id.clone(),
+                        trace_id: span.trace_id.clone(),
+                        value: Some(exemplar::Value::AsDouble(duration)),
+                    });
+                }
+            }
+            HistogramDataPoint {
+                attributes: vec![
+                    string_attr("http.route", route),
+                    string_attr("http.status_code", if failed { "500" } else { "200" }),
+                ],
+                start_time_unix_nano: start,
+                time_unix_nano: now,
+                count: spans.len() as u64,
+                sum: Some(sum),
+                bucket_counts,
+                explicit_bounds: BOUNDS.to_vec(),
+                exemplars: exemplars.into_iter().flatten().collect(),
+                min: Some(min),
+                max: Some(max),
+                ..Default::default()
+            }
+        })
+        .collect();
+    Metric {
+        name: DURATION_METRIC.to_string(),
+        description: "Duration of the requests served".to_string(),
+        unit: "ms".to_string(),
+        data: Some(Data::Histogram(Histogram {
+            data_points,
+            aggregation_temporality: AggregationTemporality::Delta as i32,
+        })),
+        ..Default::default()
+    }
+}
+
+fn route(span: &Span) -> &str {
+    span.attributes
+        .iter()
+        .find(|a| a.key == "http.route")
+        .and_then(|a| match a.value.as_ref().and_then(|v| v.value.as_ref()) {
+            Some(Value::StringValue(route)) => Some(route.as_str()),
+            _ => None,
+        })
+        .unwrap_or_default()
+}
+
+fn is_error(span: &Span) -> bool {
+    span.status
+        .as_ref()
+        .is_some_and(|s| s.code == StatusCode::Error as i32)
+}
+
+fn duration_ms(span: &Span) -> f64 {
+    (span.end_time_unix_nano - span.start_time_unix_nano) as f64 / 1_000_000.0
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashSet;
+
+    use super::*;
+
+    #[test]
+    fn test_generate() {
+        let now = 1_700_000_000_000_000_000;
+        let (traces, logs, metrics) = generate(&mut rand::thread_rng(), 30, now);
+        let servers = traces
+            .resource_spans
+            .iter()
+            .flat_map(|r| r.scope_spans.iter().flat_map(|s| s.spans.iter()))
+            .filter(|s| s.kind == SpanKind::Server as i32)
+            .map(|s| (s.trace_id.clone(), s.span_id.clone()))
+            .collect::<HashSet<_>>();
+
+        let records = logs
+            .resource_logs
+            .iter()
+            .flat_map(|r| r.scope_logs.iter().flat_map(|s| s.log_records.iter()))
+            .collect::<Vec<_>>();
+        assert_eq!(records.len(), servers.len());
+        for record in records {
+            assert!(servers.contains(&(record.trace_id.clone(), record.span_id.clone())));
+        }
+
+        let mut requests = 0;
+        for metric in metrics
+            .resource_metrics
+            .iter()
+            .flat_map(|r| r.scope_metrics.iter().flat_map(|s| s.metrics.iter()))
+        {
+            let Some(Data::Histogram(histogram)) = metric.data.as_ref() else {
+                panic!("the metric should be a histogram");
+            };
+            for point in histogram.data_points.iter() {
+                assert_eq!(point.bucket_counts.iter().sum::<u64>(), point.count);
+                assert!(!point.exemplars.is_empty());
+                for exemplar in point.exemplars.iter() {
+                    assert!(
+                        servers.contains(&(exemplar.trace_id.clone(), exemplar.span_id.clone()))
+                    );
+                }
+                requests += point.count;
+            }
+        }
+        assert_eq!(requests as usize, servers.len());
+    }
+
+    #[test]
+    fn test_bucket() {
+        assert_eq!(BOUNDS.partition_point(|b| *b < 1.0), 0);
+        assert_eq!(BOUNDS.partition_point(|b| *b < 5.0), 0);
+        assert_eq!(BOUNDS.partition_point(|b| *b < 7.0), 1);
+        assert_eq!(BOUNDS.partition_point(|b| *b < 9_000.0), BOUNDS.len());
+    }
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Sends the generated batches to the OTLP HTTP endpoints or to the OTLP gRPC
+//! services of OpenObserve, with the same basic auth, org and stream.
+
+use std::{fmt, time::Duration};
+
+use config::utils::base64;
+use opentelemetry_proto::tonic::collector::{
+    logs::v1::{logs_service_client::LogsServiceClient, ExportLogsServiceRequest},
+    metrics::v1::{metrics_service_client::MetricsServiceClient, ExportMetricsServiceRequest},
+    trace::v1::{trace_service_client::TraceServiceClient, ExportTraceServiceRequest},
+};
+use prost::Message;
+use tonic::{
+    metadata::{MetadataKey, MetadataValue},
+    transport::{Channel, Endpoint},
+};
+
+use super::Options;
+
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub enum Protocol {
+    Http,
+    Grpc,
+}
+
+/// OTLP export request of a batch
+pub(super) enum Export {
+    Logs(ExportLogsServiceRequest),
+    Traces(ExportTraceServiceRequest),
+    Metrics(ExportMetricsServiceRequest),
+}
+
+impl Export {
+    fn path(&self) -> &'static str {
+        match self {
+            Export::Logs(_) => "v1/logs",
+            Export::Traces(_) => "v1/traces",
+            Export::Metrics(_) => "v1/metrics",
+        }
+    }
+
+    pub(super) fn encode_to_vec(&self) -> Vec<u8> {
+        match self {
+            Export::Logs(r) => r.encode_to_vec(),
+            Export::Traces(r) => r.encode_to_vec(),
+            Export::Metrics(r) => r.encode_to_vec(),
+        }
+    }
+}
+
+/// OpenObserve couldn't be reached, as opposed to rejecting the data
+#[derive(Debug)]
+pub(super) struct Unreachable(String);
+
+impl fmt::Display for Unreachable {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "{}", self.0)
+    }
+}
+
+impl std::error::Error for Unreachable {}
+
+pub(super) enum Exporter {
+    Http(reqwest::Client),
+    Grpc(Channel),
+}
+
+impl Exporter {
+    pub(super) fn new(opts: &Options) -> Result<Self, anyhow::Error> {
+        match opts.protocol {
+            Protocol::Http => Ok(Exporter::Http(
+                reqwest::Client::builder()
+                    .timeout(Duration::from_secs(30))
+                    .build()?,
+            )),
+            // connects on the first export, and again after a failure
+            Protocol::Grpc => Ok(Exporter::Grpc(
+                Endpoint::from_shared(opts.grpc_url.clone())?
+                    .connect_timeout(Duration::from_secs(10))
+                    .timeout(Duration::from_secs(30))
+                    .connect_lazy(),
+            )),
+        }
+    }
+
+    /// Sends the requests of a batch one after the other, stopping at the
+    /// first failure
+    pub(super) async fn send(
+        &self,
+        opts: &Options,
+        requests: Vec<Export>,
+    ) -> Result<(), anyhow::Error> {
+        for export in requests {
+            match self {
+                Exporter::Http(client) => send_http(client, opts, export).await?,
+                Exporter::Grpc(channel) => send_grpc(channel, opts, export).await?,
+            }
+        }
+        Ok(())
+    }
+}
+
+async fn send_http(
+    client: &reqwest::Client,
+    opts: &Options,
+    export: Export,
+) -> Result<(), anyhow::Error> {
+    let path = export.path();
+    let resp = client
+        .post(format!("{}/api/{}/{path}", opts.url, opts.org))
+        .basic_auth(&opts.user, Some(&opts.password))
+        .header(reqwest::header::CONTENT_TYPE, "application/x-protobuf")
+        .header(
+            config::get_config().grpc.stream_header_key.as_str(),
+            opts.stream.as_str(),
+        )
+        .body(export.encode_to_vec())
+        .send()
+        .await
+        .map_err(|e| Unreachable(e.to_string()))?;
+    if !resp.status().is_success() {
+        let status = resp.status();
+        let body = resp.text().await.unwrap_or_default();
+        return Err(anyhow::anyhow!("{path} rejected: {status} {body}"));
+    }
+    Ok(())
+}
+
+async fn send_grpc(channel: &Channel, opts: &Options, export: Export) -> Result<(), anyhow::Error> {
+    let path = export.path();
+    let result = match export {
+        Export::Logs(r) => LogsServiceClient::new(channel.clone())
+            .export(request(opts, r)?)
+            .await
+            .map(|_| ()),
+        Export::Traces(r) => TraceServiceClient::new(channel.clone())
+            .export(request(opts, r)?)
+            .await
+            .map(|_| ()),
+        Export::Metrics(r) => MetricsServiceClient::new(channel.clone())
+            .export(request(opts, r)?)
+            .await
+            .map(|_| ()),
+    };
+    match result {
+        Ok(()) => Ok(()),
+        Err(status) if status.code() == tonic::Code::Unavailable => {
+            Err(Unreachable(status.message().to_string()).into())
+        }
+        Err(status) => Err(anyhow::anyhow!(
+            "{path} rejected: {:?} {}",
+            status.code(),
+            status.message()
+        )),
+    }
+}
+
+/// Wraps a message with the auth, org and stream metadata the gRPC services of
+/// OpenObserve expect
+fn request<T>(opts: &Options, message: T) -> Result<tonic::Request<T>, anyhow::Error> {
+    let cfg = config::get_config();
+    let mut req = tonic::Request::new(message);
+    let metadata = req.metadata_mut();
+    metadata.insert(
+        "authorization",
+        format!(
+            "Basic {}",
+            base64::encode(&format!("{}:{}", opts.user, opts.password))
+        )
+        .parse()?,
+    );
+    metadata.insert(
+        MetadataKey::from_bytes(cfg.grpc.org_header_key.as_bytes())?,
+        MetadataValue::try_from(opts.org.as_str())?,
+    );
+    metadata.insert(
+        MetadataKey::from_bytes(cfg.grpc.stream_header_key.as_bytes())?,
+        MetadataValue::try_from(opts.stream.as_str())?,
+    );
+    Ok(req)
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Structured log records with a realistic severity distribution, service
+//! and host attributes, and messages from templates.
+
+use std::collections::BTreeMap;
+
+use opentelemetry_proto::tonic::{
+    collector::logs::v1::ExportLogsServiceRequest,
+    common::v1::{any_value::Value, AnyValue, InstrumentationScope, KeyValue},
+    logs::v1::{LogRecord, ResourceLogs, ScopeLogs},
+    resource::v1::Resource,
+};
+use rand::{seq::SliceRandom, Rng};
+
+use super::{string_attr, ENVIRONMENTS, HOSTS, METHODS, SERVICES};
+
+/// Severities with their OTLP number and weight in percent
+const SEVERITIES: [(&str, i32, u32); 6] = [
+    ("TRACE", 1, 5),
+    ("DEBUG", 5, 15),
+    ("INFO", 9, 60),
+    ("WARN", 13, 12),
+    ("ERROR", 17, 7),
+    ("FATAL", 21, 1),
+];
+
+const LOW_TEMPLATES: [&str; 4] = [
+    "cache lookup for key {service}:{id} took {ms}us",
+    "loaded {count} rows from the {service} store",
+    "span {id} started for {method} /api/{service}",
+    "connection pool stats: {count} idle, 2 active",
+];
+
+const INFO_TEMPLATES: [&str; 5] = [
+    "{method} /api/{service}/{id} completed with {status} in {ms}ms",
+    "user {user} logged in from {ip}",
+    "processed {count} messages from the {service} queue",
+    "order {id} accepted for user {user}",
+    "health check passed in {ms}ms",
+];
+
+const WARN_TEMPLATES: [&str; 4] = [
+    "slow request {method} /api/{service}/{id} took {ms}ms",
+    "retrying the call to {service} after {ms}ms, attempt {count}",
+    "rate limit close for user {user}: {count} requests in the last minute",
+    "disk usage of /var/lib/{service} above 80%",
+];
+
+const ERROR_TEMPLATES: [&str; 4] = [
+    "{method} /api/{service}/{id} failed with {status}: upstream timed out after {ms}ms",
+    "payment {id} declined for user {user}: insufficient funds",
+    "connection to {service}-db refused, {count} pending queries dropped",
+    "panic recovered in the {service} worker: index out of range",
+];
+
+/// Generates `size` records spread over the last second before `now`, with
+/// one resource per service and host
+pub(super) fn generate(rng: &mut impl Rng, size: usize, now: i64) -> ExportLogsServiceRequest {
+    let mut resources: BTreeMap<(usize, &str, &str), Vec<LogRecord>> = BTreeMap::new();
+    for _ in 0..size {
+        let service = rng.gen_range(0..SERVICES.len());
+        let host = *HOSTS.choose(rng).unwrap();
+        let env = *ENVIRONMENTS.choose(rng).unwrap();
+        let record = generate_record(rng, SERVICES[service].0, now);
+        resources
+            .entry((service, host, env))
+            .or_default()
+            .push(record);
+    }
+    let resource_logs = resources
+        .into_iter()
+        .map(|((service, host, env), mut log_records)| {
+            let (name, version) = SERVICES[service];
+            log_records.sort_by_key(|r| r.time_unix_nano);
+            ResourceLogs {
+                resource: Some(Resource {
+                    attributes: vec![
+                        string_attr("service.name", name),
+                        string_attr("service.version", version),
+                        string_attr("host.name", host),
+                        string_attr("deployment.environment", env),
+                    ],
+                    dropped_attributes_count: 0,
+                }),
+                scope_logs: vec![ScopeLogs {
+                    scope: Some(InstrumentationScope {
+                        name: "openobserve-generator".to_string(),
+                        version: env!("CARGO_PKG_VERSION").to_string(),
+                        ..Default::default()
+                    }),
+                    log_records,
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }
+        })
+        .collect();
+    ExportLogsServiceRequest { resource_logs }
+}
+
+fn generate_record(rng: &mut impl Rng, service: &str, now: i64) -> LogRecord {
+    let (severity_text, severity_number) = pick_severity(rng.gen_range(0..100));
+    let templates: &[&str] = match severity_number {
+        0..=8 => &LOW_TEMPLATES,
+        9..=12 => &INFO_TEMPLATES,
+        13..=16 => &WARN_TEMPLATES,
+        _ => &ERROR_TEMPLATES,
+    };
+    let method = *METHODS.choose(rng).unwrap();
+    let status = match severity_number {
+        17.. => *[500, 502, 503, 504].choose(rng).unwrap(),
+        13..=16 => *[200, 404, 429].choose(rng).unwrap(),
+        _ => *[200, 200, 201, 204].choose(rng).unwrap(),
+    };
+    let duration = match severity_number {
+        13.. => rng.gen_range(800..30_000),
+        _ => rng.gen_range(2..400),
+    };
+    let user = format!("user{}", rng.gen_range(1..500));
+    let id = format!("{:08x}", rng.gen::<u32>());
+    let message = templates
+        .choose(rng)
+        .unwrap()
+        .replace("{service}", service)
+        .replace("{method}", method)
+        .replace("{status}", &status.to_string())
+        .replace("{ms}", &duration.to_string())
+        .replace("{user}", &user)
+        .replace("{id}", &id)
+        .replace("{count}", &rng.gen_range(1..100).to_string())
+        .replace(
+            "{ip}",
+            &format!(
+                "10.{}.{}.{}",
+                rng.gen::<u8>(),
+                rng.gen::<u8>(),
+                rng.gen::<u8>()
+            ),
+        );
+    let time = (now - rng.gen_range(0..1_000_000_000)) as u64;
+    LogRecord {
+        time_unix_nano: time,
+        observed_time_unix_nano: now as u64,
+        severity_number,
+        severity_text: severity_text.to_string(),
+        body: Some(AnyValue {
+            value: Some(Value::StringValue(message)),
+        }),
+        attributes: vec![
+            string_attr("http.method", method),
+            KeyValue {
+                key: "http.status_code".to_string(),
+                value: Some(AnyValue {
+                    value: Some(Value::IntValue(status)),
+                }),
+            },
+            KeyValue {
+                key: "duration_ms".to_string(),
+                value: Some(AnyValue {
+                    value: Some(Value::IntValue(duration)),
+                }),
+            },
+            string_attr("user.id", &user),
+        ],
+        trace_id: rng.gen::<[u8; 16]>().to_vec(),
+        span_id: rng.gen::<[u8; 8]>().to_vec(),
+        ..Default::default()
+    }
+}
+
+/// Returns the severity of a percentile, following the weights of
+/// [`SEVERITIES`]
+fn pick_severity(percentile: u32) -> (&'static str, i32) {
+    let mut acc = 0;
+    for (text, number, weight) in SEVERITIES {
+        acc += weight;
+        if percentile < acc {
+            return (text, number);
+        }
+    }
+    ("INFO", 9)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pick_severity() {
+        assert_eq!(SEVERITIES.iter().map(|s| s.2).sum::<u32>(), 100);
+        assert_eq!(pick_severity(0), ("TRACE", 1));
+        assert_eq!(pick_severity(5), ("DEBUG", 5));
+        assert_eq!(pick_severity(50), ("INFO", 9));
+        assert_eq!(pick_severity(85), ("WARN", 13));
+        assert_eq!(pick_severity(95), ("ERROR", 17));
+        assert_eq!(pick_severity(99), ("FATAL", 21));
+    }
+
+    #[test]
+    fn test_generate() {
+        let now = 1_700_000_000_000_000_000;
+        let request = generate(&mut rand::thread_rng(), 200, now);
+        let records = request
+            .resource_logs
+            .iter()
+            .flat_map(|r| r.scope_logs.iter().flat_map(|s| s.log_records.iter()))
+            .collect::<Vec<_>>();
+        assert_eq!(records.len(), 200);
+        for record in records {
+            assert!(record.time_unix_nano <= now as u64);
+            let Some(Value::StringValue(body)) = record.body.as_ref().and_then(|b| b.value.clone())
+            else {
+                panic!("the body should be a string");
+            };
+            assert!(!body.contains('{'), "unreplaced placeholder in {body}");
+        }
+        for resource in request.resource_logs {
+            let attrs = resource.resource.unwrap().attributes;
+            assert!(attrs.iter().any(|a| a.key == "service.name"));
+            assert!(attrs.iter().any(|a| a.key == "host.name"));
+        }
+    }
+}
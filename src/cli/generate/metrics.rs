@@ -0,0 +1,583 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Metrics built from a spec describing their names, types, units, values and
+//! label sets, so the generated data can follow the schemas of production.
+//! Without a spec file the metrics of `DEFAULT_SPEC` are generated. The
+//! counters, up/down counters and histograms are cumulative and keep growing
+//! from one batch to the next, so rate(), increase() and
+//! histogram_quantile() give meaningful results.
+
+use std::collections::{BTreeMap, HashSet};
+
+use opentelemetry_proto::tonic::{
+    collector::metrics::v1::ExportMetricsServiceRequest,
+    common::v1::{InstrumentationScope, KeyValue},
+    metrics::v1::{
+        metric::Data, number_data_point, AggregationTemporality, Gauge, Histogram,
+        HistogramDataPoint, Metric, NumberDataPoint, ResourceMetrics, ScopeMetrics, Sum,
+    },
+    resource::v1::Resource,
+};
+use rand::Rng;
+use serde::Deserialize;
+
+use super::string_attr;
+
+/// Upper bounds of the buckets of the histograms without buckets in the spec
+pub(super) const DEFAULT_BUCKETS: &str = "5,10,25,50,100,250,500,1000,2500,5000";
+
+/// Series of a metric, the product of the sizes of its label sets
+const MAX_SERIES: usize = 10_000;
+
+/// Metrics generated without a spec file, also an example of spec
+pub(super) const DEFAULT_SPEC: &str = r#"
+resource:
+  service.name: openobserve-generator
+metrics:
+  - name: http_server_requests_total
+    type: counter
+    description: Requests served
+    labels:
+      service: [frontend, checkout, payments, inventory, auth]
+      status: ["200", "500"]
+    value: { min: 0, max: 100 }
+  - name: http_server_active_requests
+    type: updown_counter
+    description: Requests in progress
+    labels:
+      service: [frontend, checkout, payments, inventory, auth]
+    value: { min: 0, max: 50 }
+  - name: http_server_request_duration_milliseconds
+    type: histogram
+    description: Duration of the requests served
+    unit: ms
+    labels:
+      service: [frontend, checkout, payments, inventory, auth]
+    value: { min: 2, max: 3000, distribution: exponential }
+  - name: process_memory_usage_bytes
+    type: gauge
+    description: Resident memory of the process
+    unit: By
+    labels:
+      service: [frontend, checkout, payments, inventory, auth]
+    value: { min: 150000000, max: 250000000, distribution: normal }
+"#;
+
+#[derive(Clone, Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct Spec {
+    /// Attributes of the resource of all the metrics
+    #[serde(default)]
+    pub resource: BTreeMap<String, String>,
+    pub metrics: Vec<MetricSpec>,
+}
+
+#[derive(Clone, Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct MetricSpec {
+    pub name: String,
+    #[serde(rename = "type")]
+    pub kind: MetricKind,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    pub unit: String,
+    /// Values of each label, a series is generated for every combination
+    #[serde(default)]
+    pub labels: BTreeMap<String, Vec<String>>,
+    pub value: ValueSpec,
+    /// Upper bounds of the buckets of a histogram, `--buckets` when empty
+    #[serde(default)]
+    pub buckets: Vec<f64>,
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum MetricKind {
+    /// Grows by a value of the range every batch
+    Counter,
+    /// Moves randomly within the range
+    UpdownCounter,
+    /// Takes a value of the range every batch
+    Gauge,
+    /// Observes values of the range, batch size observations every batch
+    Histogram,
+}
+
+#[derive(Clone, Copy, Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+pub struct ValueSpec {
+    pub min: f64,
+    pub max: f64,
+    #[serde(default)]
+    pub distribution: Distribution,
+}
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Distribution {
+    #[default]
+    Uniform,
+    /// Centered in the range, which holds 3 standard deviations each side
+    Normal,
+    /// Mostly close to the minimum with a long tail, like latencies
+    Exponential,
+}
+
+impl ValueSpec {
+    /// Returns a value of the range following the distribution
+    fn sample(&self, rng: &mut impl Rng) -> f64 {
+        if self.max <= self.min {
+            return self.min;
+        }
+        let width = self.max - self.min;
+        let v = match self.distribution {
+            Distribution::Uniform => rng.gen_range(self.min..self.max),
+            Distribution::Normal => {
+                // Box-Muller transform
+                let u1: f64 = rng.gen_range(f64::EPSILON..1.0);
+                let u2: f64 = rng.gen_range(0.0..1.0);
+                let z = (-2.0 * u1.ln()).sqrt() * (2.0 * std::f64::consts::PI * u2).cos();
+                self.min + width / 2.0 + z * width / 6.0
+            }
+            Distribution::Exponential => {
+                let u: f64 = rng.gen_range(f64::EPSILON..1.0);
+                self.min - u.ln() * width / 8.0
+            }
+        };
+        v.clamp(self.min, self.max)
+    }
+}
+
+impl Default for Spec {
+    fn default() -> Self {
+        Self::parse(DEFAULT_SPEC).expect("the default spec is valid")
+    }
+}
+
+impl Spec {
+    /// Reads and checks the spec file
+    pub(super) fn load(path: &str) -> Result<Self, String> {
+        let data = std::fs::read_to_string(path).map_err(|e| format!("read {path}: {e}"))?;
+        Self::parse(&data)
+    }
+
+    fn parse(data: &str) -> Result<Self, String> {
+        let spec: Spec = serde_yaml::from_str(data).map_err(|e| e.to_string())?;
+        spec.validate()?;
+        Ok(spec)
+    }
+
+    fn validate(&self) -> Result<(), String> {
+        if self.metrics.is_empty() {
+            return Err("the spec has no metrics".to_string());
+        }
+        let mut names = HashSet::new();
+        for m in self.metrics.iter() {
+            if m.name.trim().is_empty() {
+                return Err("metric name is required".to_string());
+            }
+            if !names.insert(m.name.as_str()) {
+                return Err(format!("metric {} is defined twice", m.name));
+            }
+            if !m.value.min.is_finite() || !m.value.max.is_finite() || m.value.min > m.value.max {
+                return Err(format!("metric {}: invalid value range", m.name));
+            }
+            if m.kind == MetricKind::Counter && m.value.min < 0.0 {
+                return Err(format!("metric {}: a counter can't decrease", m.name));
+            }
+            if !m.buckets.is_empty() && m.kind != MetricKind::Histogram {
+                return Err(format!("metric {}: only histograms have buckets", m.name));
+            }
+            check_buckets(&m.buckets).map_err(|e| format!("metric {}: {e}", m.name))?;
+            if m.labels.values().any(|v| v.is_empty()) {
+                return Err(format!("metric {}: a label has no value", m.name));
+            }
+            let series = m
+                .labels
+                .values()
+                .try_fold(1usize, |n, v| n.checked_mul(v.len()))
+                .unwrap_or(usize::MAX);
+            if series > MAX_SERIES {
+                return Err(format!(
+                    "metric {}: {series} series, the limit is {MAX_SERIES}",
+                    m.name
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Cumulative values of a series
+struct Series {
+    attributes: Vec<KeyValue>,
+    value: f64,
+    bucket_counts: Vec<u64>,
+    count: u64,
+    sum: f64,
+    min: Option<f64>,
+    max: Option<f64>,
+}
+
+struct State {
+    spec: MetricSpec,
+    buckets: Vec<f64>,
+    series: Vec<Series>,
+}
+
+/// Keeps the cumulative values of the metrics between the batches
+pub(super) struct Generator {
+    resource: Vec<KeyValue>,
+    metrics: Vec<State>,
+    /// Start of the cumulative series, set by the first batch
+    start: Option<u64>,
+}
+
+impl Generator {
+    pub(super) fn new(rng: &mut impl Rng, spec: Spec, default_buckets: Vec<f64>) -> Self {
+        let mut resource = spec.resource;
+        resource
+            .entry("service.name".to_string())
+            .or_insert_with(|| "openobserve-generator".to_string());
+        let metrics = spec
+            .metrics
+            .into_iter()
+            .map(|m| {
+                let buckets = if m.buckets.is_empty() {
+                    default_buckets.clone()
+                } else {
+                    m.buckets.clone()
+                };
+                let series = label_sets(&m.labels)
+                    .into_iter()
+                    .map(|attributes| Series {
+                        attributes,
+                        // the up/down counters start anywhere in their range
+                        value: match m.kind {
+                            MetricKind::UpdownCounter => m.value.sample(rng),
+                            _ => 0.0,
+                        },
+                        bucket_counts: vec![0; buckets.len() + 1],
+                        count: 0,
+                        sum: 0.0,
+                        min: None,
+                        max: None,
+                    })
+                    .collect();
+                State {
+                    spec: m,
+                    buckets,
+                    series,
+                }
+            })
+            .collect();
+        Self {
+            resource: resource.iter().map(|(k, v)| string_attr(k, v)).collect(),
+            metrics,
+            start: None,
+        }
+    }
+
+    /// Moves the metrics forward by one batch with `size` observations per
+    /// histogram and returns their values at `now`
+    pub(super) fn generate(
+        &mut self,
+        rng: &mut impl Rng,
+        size: usize,
+        now: i64,
+    ) -> ExportMetricsServiceRequest {
+        let now = now as u64;
+        let start = *self.start.get_or_insert(now.saturating_sub(1_000_000_000));
+        let mut metrics = Vec::with_capacity(self.metrics.len());
+        for state in self.metrics.iter_mut() {
+            let value = state.spec.value;
+            match state.spec.kind {
+                MetricKind::Counter => {
+                    for s in state.series.iter_mut() {
+                        s.value += value.sample(rng);
+                    }
+                }
+                MetricKind::UpdownCounter => {
+                    let step = (value.max - value.min) / 10.0;
+                    for s in state.series.iter_mut() {
+                        let delta = if step > 0.0 {
+                            rng.gen_range(-step..=step)
+                        } else {
+                            0.0
+                        };
+                        s.value = (s.value + delta).clamp(value.min, value.max);
+                    }
+                }
+                MetricKind::Gauge => {
+                    for s in state.series.iter_mut() {
+                        s.value = value.sample(rng);
+                    }
+                }
+                MetricKind::Histogram => {
+                    for _ in 0..size {
+                        let i = rng.gen_range(0..state.series.len());
+                        let s = &mut state.series[i];
+                        let v = value.sample(rng);
+                        s.bucket_counts[state.buckets.partition_point(|b| *b < v)] += 1;
+                        s.count += 1;
+                        s.sum += v;
+                        s.min = Some(s.min.map_or(v, |m| m.min(v)));
+                        s.max = Some(s.max.map_or(v, |m| m.max(v)));
+                    }
+                }
+            }
+            metrics.push(state.metric(start, now));
+        }
+        ExportMetricsServiceRequest {
+            resource_metrics: vec![ResourceMetrics {
+                resource: Some(Resource {
+                    attributes: self.resource.clone(),
+                    dropped_attributes_count: 0,
+                }),
+                scope_metrics: vec![ScopeMetrics {
+                    scope: Some(InstrumentationScope {
+                        name: "openobserve-generator".to_string(),
+                        version: env!("CARGO_PKG_VERSION").to_string(),
+                        ..Default::default()
+                    }),
+                    metrics,
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }],
+        }
+    }
+}
+
+impl State {
+    fn metric(&self, start: u64, now: u64) -> Metric {
+        let number_points = || {
+            self.series
+                .iter()
+                .map(|s| NumberDataPoint {
+                    attributes: s.attributes.clone(),
+                    start_time_unix_nano: if self.spec.kind == MetricKind::Gauge {
+                        0
+                    } else {
+                        start
+                    },
+                    time_unix_nano: now,
+                    value: Some(number_data_point::Value::AsDouble(s.value)),
+                    ..Default::default()
+                })
+                .collect()
+        };
+        let data = match self.spec.kind {
+            MetricKind::Counter | MetricKind::UpdownCounter => Data::Sum(Sum {
+                data_points: number_points(),
+                aggregation_temporality: AggregationTemporality::Cumulative as i32,
+                is_monotonic: self.spec.kind == MetricKind::Counter,
+            }),
+            MetricKind::Gauge => Data::Gauge(Gauge {
+                data_points: number_points(),
+            }),
+            MetricKind::Histogram => Data::Histogram(Histogram {
+                data_points: self
+                    .series
+                    .iter()
+                    .map(|s| HistogramDataPoint {
+                        attributes: s.attributes.clone(),
+                        start_time_unix_nano: start,
+                        time_unix_nano: now,
+                        count: s.count,
+                        sum: Some(s.sum),
+                        bucket_counts: s.bucket_counts.clone(),
+                        explicit_bounds: self.buckets.clone(),
+                        min: s.min,
+                        max: s.max,
+                        ..Default::default()
+                    })
+                    .collect(),
+                aggregation_temporality: AggregationTemporality::Cumulative as i32,
+            }),
+        };
+        Metric {
+            name: self.spec.name.clone(),
+            description: self.spec.description.clone(),
+            unit: self.spec.unit.clone(),
+            data: Some(data),
+            ..Default::default()
+        }
+    }
+}
+
+/// Returns the attributes of every combination of the label values
+fn label_sets(labels: &BTreeMap<String, Vec<String>>) -> Vec<Vec<KeyValue>> {
+    let mut sets = vec![vec![]];
+    for (name, values) in labels.iter() {
+        sets = sets
+            .into_iter()
+            .flat_map(|set: Vec<KeyValue>| {
+                values.iter().map(move |v| {
+                    let mut set = set.clone();
+                    set.push(string_attr(name, v));
+                    set
+                })
+            })
+            .collect();
+    }
+    sets
+}
+
+/// Parses the comma separated upper bounds of the histogram buckets
+pub(super) fn parse_buckets(value: &str) -> Result<Vec<f64>, String> {
+    let buckets = value
+        .split(',')
+        .map(|b| {
+            b.trim()
+                .parse::<f64>()
+                .ok()
+                .filter(|b| b.is_finite())
+                .ok_or_else(|| format!("invalid bucket boundary: {b}"))
+        })
+        .collect::<Result<Vec<_>, _>>()?;
+    check_buckets(&buckets)?;
+    Ok(buckets)
+}
+
+pub(super) fn check_buckets(buckets: &[f64]) -> Result<(), String> {
+    if buckets.windows(2).any(|w| w[0] >= w[1]) {
+        return Err("bucket boundaries should be increasing".to_string());
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_buckets() {
+        assert_eq!(parse_buckets("0.5, 1,2.5").unwrap(), vec![0.5, 1.0, 2.5]);
+        assert_eq!(parse_buckets(DEFAULT_BUCKETS).unwrap().len(), 10);
+        assert!(parse_buckets("1,a").is_err());
+        assert!(parse_buckets("").is_err());
+        assert!(parse_buckets("5,1").is_err());
+        assert!(parse_buckets("1,1").is_err());
+    }
+
+    #[test]
+    fn test_parse_spec() {
+        let spec = Spec::parse(DEFAULT_SPEC).unwrap();
+        assert_eq!(spec.metrics.len(), 4);
+        assert_eq!(spec.metrics[2].kind, MetricKind::Histogram);
+        assert_eq!(
+            spec.metrics[2].value.distribution,
+            Distribution::Exponential
+        );
+
+        let err = |yaml: &str| Spec::parse(yaml).unwrap_err();
+        err("metrics: []");
+        err("metrics: [{name: a, type: summary, value: {min: 0, max: 1}}]");
+        err("metrics: [{name: a, type: gauge, value: {min: 2, max: 1}}]");
+        err("metrics: [{name: a, type: counter, value: {min: -1, max: 1}}]");
+        err("metrics: [{name: a, type: gauge, value: {min: 0, max: 1}, buckets: [1]}]");
+        err("metrics: [{name: a, type: histogram, value: {min: 0, max: 1}, buckets: [2, 1]}]");
+        err("metrics: [{name: a, type: gauge, value: {min: 0, max: 1}, labels: {x: []}}]");
+        err(
+            "metrics: [{name: a, type: gauge, value: {min: 0, max: 1}}, {name: a, type: gauge, value: {min: 0, max: 1}}]",
+        );
+        let many = (0..200)
+            .map(|i| i.to_string())
+            .collect::<Vec<_>>()
+            .join(",");
+        err(&format!(
+            "metrics: [{{name: a, type: gauge, value: {{min: 0, max: 1}}, labels: {{x: [{many}], y: [{many}]}}}}]"
+        ));
+    }
+
+    #[test]
+    fn test_sample() {
+        let mut rng = rand::thread_rng();
+        for distribution in [
+            Distribution::Uniform,
+            Distribution::Normal,
+            Distribution::Exponential,
+        ] {
+            let value = ValueSpec {
+                min: 10.0,
+                max: 20.0,
+                distribution,
+            };
+            for _ in 0..1000 {
+                let v = value.sample(&mut rng);
+                assert!((10.0..=20.0).contains(&v), "{v} out of range");
+            }
+        }
+    }
+
+    #[test]
+    fn test_label_sets() {
+        let labels = BTreeMap::from([
+            ("a".to_string(), vec!["1".to_string(), "2".to_string()]),
+            (
+                "b".to_string(),
+                vec!["x".to_string(), "y".to_string(), "z".to_string()],
+            ),
+        ]);
+        let sets = label_sets(&labels);
+        assert_eq!(sets.len(), 6);
+        assert!(sets.iter().all(|s| s.len() == 2));
+        assert_eq!(label_sets(&BTreeMap::new()), vec![vec![]]);
+    }
+
+    #[test]
+    fn test_generate_cumulative() {
+        let now = 1_700_000_000_000_000_000;
+        let spec = Spec::parse(DEFAULT_SPEC).unwrap();
+        let mut rng = rand::thread_rng();
+        let mut generator = Generator::new(&mut rng, spec, vec![10.0, 100.0]);
+        let first = generator.generate(&mut rng, 100, now);
+        let second = generator.generate(&mut rng, 50, now + 1_000_000_000);
+
+        let counter = |request: &ExportMetricsServiceRequest| -> f64 {
+            let metric = &request.resource_metrics[0].scope_metrics[0].metrics[0];
+            let Some(Data::Sum(sum)) = metric.data.as_ref() else {
+                panic!("the counter should be a sum");
+            };
+            assert!(sum.is_monotonic);
+            sum.data_points
+                .iter()
+                .map(|p| match p.value {
+                    Some(number_data_point::Value::AsDouble(v)) => v,
+                    _ => panic!("the counter should be a double"),
+                })
+                .sum()
+        };
+        assert!(counter(&second) >= counter(&first));
+
+        let metrics = &second.resource_metrics[0].scope_metrics[0].metrics;
+        assert_eq!(metrics.len(), 4);
+        let Some(Data::Histogram(h)) = metrics[2].data.as_ref() else {
+            panic!("the third metric should be a histogram");
+        };
+        assert_eq!(h.data_points.len(), 5);
+        let mut count = 0;
+        for point in h.data_points.iter() {
+            assert_eq!(point.start_time_unix_nano, now as u64 - 1_000_000_000);
+            assert_eq!(point.explicit_bounds, vec![10.0, 100.0]);
+            assert_eq!(point.bucket_counts.iter().sum::<u64>(), point.count);
+            count += point.count;
+        }
+        assert_eq!(count, 150);
+    }
+}
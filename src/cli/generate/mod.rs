@@ -0,0 +1,498 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Generators of realistic telemetry for UI tests and demos, started with
+//! `openobserve generate-logs`, `openobserve generate-traces`,
+//! `openobserve generate-metrics` or `openobserve generate-correlated`. The
+//! data is sent as OTLP protobuf to the OTLP HTTP endpoints or the OTLP gRPC
+//! services of a running OpenObserve, either as a fixed number of batches or
+//! continuously until stopped. The settings also come from a `--config` file
+//! and from the `O2_INGEST_*` environment variables, see [`settings`]. With a
+//! `--seed` and a `--start-time` two runs send the same bytes.
+
+use std::{future::Future, time::Duration};
+
+use chrono::Utc;
+use clap::parser::ValueSource;
+pub use export::Protocol;
+use export::{Export, Exporter, Unreachable};
+use opentelemetry_proto::tonic::common::v1::{any_value::Value, AnyValue, KeyValue};
+use rand::{rngs::StdRng, Rng, SeedableRng};
+use settings::Settings;
+
+pub(crate) mod correlated;
+mod export;
+mod logs;
+mod metrics;
+mod settings;
+mod traces;
+
+const SERVICES: [(&str, &str); 5] = [
+    ("frontend", "2.4.1"),
+    ("checkout", "1.9.0"),
+    ("payments", "3.0.2"),
+    ("inventory", "1.2.7"),
+    ("auth", "5.1.0"),
+];
+
+const HOSTS: [&str; 6] = [
+    "node-a1", "node-a2", "node-b1", "node-b2", "node-c1", "node-c2",
+];
+
+const ENVIRONMENTS: [&str; 2] = ["production", "staging"];
+
+const METHODS: [&str; 4] = ["GET", "GET", "POST", "PUT"];
+
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub enum Signal {
+    Logs,
+    Traces,
+    /// Counters, up/down counters, histograms and gauges, of a spec file or
+    /// of the requests of the services
+    Metrics,
+    /// Traces with the logs and the metrics of their requests, sharing their
+    /// trace ids
+    Correlated,
+}
+
+impl Signal {
+    fn unit(&self) -> &'static str {
+        match self {
+            Signal::Logs => "log records",
+            Signal::Traces => "traces",
+            Signal::Metrics => "histogram observations",
+            Signal::Correlated => "correlated traces",
+        }
+    }
+
+    /// Generates the export requests of a batch of `size` log records,
+    /// traces or histogram observations ending at `now`, the metrics keep their
+    /// cumulative values in `metrics`
+    fn generate(
+        &self,
+        rng: &mut impl Rng,
+        size: usize,
+        now: i64,
+        metrics: &mut metrics::Generator,
+    ) -> Vec<Export> {
+        match self {
+            Signal::Logs => vec![Export::Logs(logs::generate(rng, size, now))],
+            Signal::Traces => vec![Export::Traces(traces::generate(rng, size, now))],
+            Signal::Metrics => vec![Export::Metrics(metrics.generate(rng, size, now))],
+            Signal::Correlated => {
+                let (traces, logs, metrics) = correlated::generate(rng, size, now);
+                // the traces first, so the links of the logs and the exemplars
+                // lead somewhere
+                vec![
+                    Export::Traces(traces),
+                    Export::Logs(logs),
+                    Export::Metrics(metrics),
+                ]
+            }
+        }
+    }
+}
+
+#[derive(Clone, Debug)]
+pub struct Options {
+    pub protocol: Protocol,
+    pub url: String,
+    pub grpc_url: String,
+    pub org: String,
+    pub stream: String,
+    pub user: String,
+    pub password: String,
+    pub batch_size: usize,
+    /// Runs until stopped when 0
+    pub batches: usize,
+    pub interval: Duration,
+    /// Upper bounds of the buckets of the histograms of `generate-metrics`
+    /// without buckets in the spec
+    pub buckets: Vec<f64>,
+    /// Metrics of `generate-metrics`, the default ones when None
+    pub spec: Option<metrics::Spec>,
+    /// Seed of the random values and ids, a random one when None
+    pub seed: Option<u64>,
+    /// End of the first batch in nanoseconds, the next batches end `interval`
+    /// apart. The batches end at the time they are sent when None.
+    pub start_time: Option<i64>,
+}
+
+pub fn args(signal: Signal) -> Vec<clap::Arg> {
+    let mut args = vec![
+        clap::Arg::new("protocol")
+            .short('p')
+            .long("protocol")
+            .value_name("protocol")
+            .default_value("http")
+            .value_parser(["http", "grpc"])
+            .help("OTLP protocol, grpc sends to the grpc-url"),
+        clap::Arg::new("url")
+            .short('u')
+            .long("url")
+            .value_name("url")
+            .default_value("http://localhost:5080")
+            .help("url of OpenObserve"),
+        clap::Arg::new("grpc-url")
+            .long("grpc-url")
+            .value_name("grpc-url")
+            .default_value("http://localhost:5081")
+            .help("url of the gRPC services of OpenObserve"),
+        clap::Arg::new("org")
+            .short('o')
+            .long("org")
+            .value_name("org")
+            .default_value("default")
+            .help("organization of the stream"),
+        clap::Arg::new("stream")
+            .short('s')
+            .long("stream")
+            .value_name("stream")
+            .default_value("default")
+            .help(match signal {
+                Signal::Logs => "logs stream receiving the records",
+                Signal::Traces => "traces stream receiving the spans",
+                Signal::Metrics => "unused, the metrics go to streams named after them",
+                Signal::Correlated => {
+                    "logs and traces stream receiving the records and the spans, the metrics go to streams named after them"
+                }
+            }),
+        clap::Arg::new("user")
+            .long("user")
+            .value_name("user")
+            .help("user email, O2_INGEST_USER or ZO_ROOT_USER_EMAIL by default"),
+        clap::Arg::new("password")
+            .long("password")
+            .value_name("password")
+            .help("user password, O2_INGEST_PASSWORD or ZO_ROOT_USER_PASSWORD by default, prefer them to keep the password out of the process list"),
+        clap::Arg::new("batch-size")
+            .short('b')
+            .long("batch-size")
+            .value_name("batch-size")
+            .default_value("100")
+            .value_parser(clap::value_parser!(usize))
+            .help(match signal {
+                Signal::Logs => "log records per batch",
+                Signal::Traces | Signal::Correlated => "traces per batch",
+                Signal::Metrics => "observations of each histogram per batch",
+            }),
+        clap::Arg::new("batches")
+            .short('n')
+            .long("batches")
+            .value_name("batches")
+            .default_value("1")
+            .value_parser(clap::value_parser!(usize))
+            .help("batches to send, 0 to send continuously until stopped"),
+        clap::Arg::new("interval")
+            .short('i')
+            .long("interval")
+            .value_name("interval")
+            .default_value("1000")
+            .value_parser(clap::value_parser!(u64))
+            .help("milliseconds between two batches"),
+        clap::Arg::new("seed")
+            .long("seed")
+            .value_name("seed")
+            .value_parser(clap::value_parser!(u64))
+            .help("seed of the random values and ids, the runs with the same seed send the same data, and the same bytes with a start-time"),
+        clap::Arg::new("start-time")
+            .long("start-time")
+            .value_name("start-time")
+            .help("end of the first batch, a timestamp or a RFC 3339 date, the next batches end interval apart instead of following the clock"),
+        clap::Arg::new("config")
+            .short('c')
+            .long("config")
+            .value_name("config")
+            .help("yaml or json file with the settings, named like the flags with underscores, e.g. grpc_url, overridden by the O2_INGEST_* environment variables, e.g. O2_INGEST_GRPC_URL, and by the flags"),
+    ];
+    if signal == Signal::Metrics {
+        args.push(
+            clap::Arg::new("buckets")
+                .long("buckets")
+                .value_name("buckets")
+                .default_value(metrics::DEFAULT_BUCKETS)
+                .value_parser(metrics::parse_buckets)
+                .help("upper bounds of the buckets of the histograms without buckets in the spec, as comma separated values"),
+        );
+        args.push(
+            clap::Arg::new("spec")
+                .long("spec")
+                .value_name("spec")
+                .value_parser(metrics::Spec::load)
+                .help("yaml file describing the names, types, units, values and labels of the metrics, built-in metrics of requests by default"),
+        );
+    }
+    args
+}
+
+impl Options {
+    /// Builds the options from the flags, the `O2_INGEST_*` environment
+    /// variables and the `--config` file, in this order of precedence
+    pub fn from_matches(matches: &clap::ArgMatches) -> Result<Self, anyhow::Error> {
+        let cfg = config::get_config();
+        let flag = |name: &str| cli_value::<String>(matches, name);
+        let flags = Settings {
+            protocol: flag("protocol"),
+            url: flag("url"),
+            grpc_url: flag("grpc-url"),
+            org: flag("org"),
+            stream: flag("stream"),
+            user: flag("user"),
+            password: flag("password"),
+            batch_size: cli_value(matches, "batch-size"),
+            batches: cli_value(matches, "batches"),
+            interval: cli_value(matches, "interval"),
+            buckets: cli_value(matches, "buckets"),
+            spec: None,
+            seed: cli_value(matches, "seed"),
+            start_time: flag("start-time"),
+        };
+        let file = match matches.get_one::<String>("config") {
+            Some(path) => Settings::load(path).map_err(|e| anyhow::anyhow!(e))?,
+            None => Settings::default(),
+        };
+        let settings = flags
+            .or(Settings::from_env().map_err(|e| anyhow::anyhow!(e))?)
+            .or(file);
+
+        let default = |name: &str| matches.get_one::<String>(name).cloned().unwrap_or_default();
+        let protocol = match settings
+            .protocol
+            .unwrap_or_else(|| default("protocol"))
+            .as_str()
+        {
+            "http" => Protocol::Http,
+            "grpc" => Protocol::Grpc,
+            v => return Err(anyhow::anyhow!("unknown protocol {v}, http or grpc")),
+        };
+        // the spec of the command line is already loaded, the one of the
+        // environment or of the file is a path
+        let spec = match cli_value::<metrics::Spec>(matches, "spec") {
+            Some(spec) => Some(spec),
+            None => settings
+                .spec
+                .map(|path| metrics::Spec::load(&path))
+                .transpose()
+                .map_err(|e| anyhow::anyhow!(e))?,
+        };
+        Ok(Self {
+            protocol,
+            url: settings
+                .url
+                .unwrap_or_else(|| default("url"))
+                .trim_end_matches('/')
+                .to_string(),
+            grpc_url: settings
+                .grpc_url
+                .unwrap_or_else(|| default("grpc-url"))
+                .trim_end_matches('/')
+                .to_string(),
+            org: settings.org.unwrap_or_else(|| default("org")),
+            stream: settings.stream.unwrap_or_else(|| default("stream")),
+            user: settings
+                .user
+                .unwrap_or_else(|| cfg.auth.root_user_email.clone()),
+            password: settings
+                .password
+                .unwrap_or_else(|| cfg.auth.root_user_password.clone()),
+            batch_size: settings.batch_size.unwrap_or(100),
+            batches: settings.batches.unwrap_or(1),
+            interval: Duration::from_millis(settings.interval.unwrap_or(1000)),
+            buckets: settings
+                .buckets
+                .unwrap_or_else(|| metrics::parse_buckets(metrics::DEFAULT_BUCKETS).unwrap()),
+            spec,
+            seed: settings.seed,
+            start_time: settings
+                .start_time
+                .map(|v| parse_start_time(&v))
+                .transpose()?,
+        })
+    }
+}
+
+/// Parses a timestamp or a RFC 3339 date into nanoseconds
+fn parse_start_time(v: &str) -> Result<i64, anyhow::Error> {
+    match config::utils::time::parse_str_to_timestamp_micros(v.trim()) {
+        Ok(micros) if micros > 0 => Ok(micros * 1000),
+        _ => Err(anyhow::anyhow!(
+            "invalid start-time {v}, a timestamp or a RFC 3339 date"
+        )),
+    }
+}
+
+/// Returns the value of the argument when given on the command line, none
+/// when defaulted or when the command has no such argument
+fn cli_value<T: Clone + Send + Sync + 'static>(
+    matches: &clap::ArgMatches,
+    name: &str,
+) -> Option<T> {
+    // value_source panics on the arguments of the other commands, check them
+    // first
+    let value = matches.try_get_one::<T>(name).ok().flatten()?;
+    (matches.value_source(name) == Some(ValueSource::CommandLine)).then(|| value.clone())
+}
+
+pub async fn run(signal: Signal, opts: Options) -> Result<(), anyhow::Error> {
+    if opts.batch_size == 0 {
+        return Err(anyhow::anyhow!("batch-size should be greater than 0"));
+    }
+    let exporter = Exporter::new(&opts)?;
+    let sent = send_batches(signal, &opts, |requests| exporter.send(&opts, requests)).await?;
+    println!(
+        "sent {sent} {} to {}/{}",
+        signal.unit(),
+        opts.org,
+        opts.stream
+    );
+    Ok(())
+}
+
+/// Generates the batches and hands them to `send`, returns how many log
+/// records, traces or observations were sent
+async fn send_batches<F, Fut>(
+    signal: Signal,
+    opts: &Options,
+    mut send: F,
+) -> Result<usize, anyhow::Error>
+where
+    F: FnMut(Vec<Export>) -> Fut,
+    Fut: Future<Output = Result<(), anyhow::Error>>,
+{
+    // log the seed so a run can be replayed
+    let seed = opts.seed.unwrap_or_else(rand::random);
+    log::info!("seed {seed}");
+    let mut rng = StdRng::seed_from_u64(seed);
+    let mut metrics = metrics::Generator::new(
+        &mut rng,
+        opts.spec.clone().unwrap_or_default(),
+        opts.buckets.clone(),
+    );
+    let mut interval = tokio::time::interval(opts.interval);
+    let mut sent = 0;
+    let mut batch = 0;
+    while opts.batches == 0 || batch < opts.batches {
+        interval.tick().await;
+        let now = match opts.start_time {
+            Some(start) => start + opts.interval.as_nanos() as i64 * batch as i64,
+            None => Utc::now().timestamp_nanos_opt().unwrap_or_default(),
+        };
+        batch += 1;
+        let requests = signal.generate(&mut rng, opts.batch_size, now, &mut metrics);
+        match send(requests).await {
+            Ok(()) => {
+                sent += opts.batch_size;
+                log::info!(
+                    "batch {batch}: sent {} {}, {sent} in total",
+                    opts.batch_size,
+                    signal.unit()
+                );
+            }
+            // keep going when continuous, the server may be restarting, but
+            // stop when it rejects the data
+            Err(e) if opts.batches == 0 && e.is::<Unreachable>() => {
+                log::error!("batch {batch} failed: {e}")
+            }
+            Err(e) => return Err(anyhow::anyhow!("batch {batch} failed: {e}")),
+        }
+    }
+    Ok(sent)
+}
+
+fn string_attr(key: &str, value: &str) -> KeyValue {
+    KeyValue {
+        key: key.to_string(),
+        value: Some(AnyValue {
+            value: Some(Value::StringValue(value.to_string())),
+        }),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_seed() {
+        let now = 1_700_000_000_000_000_000;
+        let run = |seed: u64| {
+            let mut rng = StdRng::seed_from_u64(seed);
+            let mut metrics = metrics::Generator::new(
+                &mut rng,
+                metrics::Spec::default(),
+                metrics::parse_buckets(metrics::DEFAULT_BUCKETS).unwrap(),
+            );
+            [
+                Signal::Logs,
+                Signal::Traces,
+                Signal::Metrics,
+                Signal::Correlated,
+            ]
+            .iter()
+            .flat_map(|signal| signal.generate(&mut rng, 20, now, &mut metrics))
+            .map(|export| export.encode_to_vec())
+            .collect::<Vec<_>>()
+        };
+        assert_eq!(run(42), run(42));
+        assert_ne!(run(42), run(43));
+    }
+
+    #[tokio::test]
+    async fn test_send_batches() {
+        let run = |start_time: i64| async move {
+            let opts = Options {
+                protocol: Protocol::Http,
+                url: "http://localhost:5080".to_string(),
+                grpc_url: "http://localhost:5081".to_string(),
+                org: "default".to_string(),
+                stream: "default".to_string(),
+                user: "root@example.com".to_string(),
+                password: "".to_string(),
+                batch_size: 10,
+                batches: 3,
+                interval: Duration::from_millis(1),
+                buckets: metrics::parse_buckets(metrics::DEFAULT_BUCKETS).unwrap(),
+                spec: None,
+                seed: Some(42),
+                start_time: Some(start_time),
+            };
+            let mut out = Vec::new();
+            let sent = send_batches(Signal::Correlated, &opts, |requests| {
+                out.extend(requests.iter().map(|export| export.encode_to_vec()));
+                std::future::ready(Ok(()))
+            })
+            .await
+            .unwrap();
+            assert_eq!(sent, 30);
+            out
+        };
+        let start_time = 1_700_000_000_000_000_000;
+        assert_eq!(run(start_time).await, run(start_time).await);
+        assert_ne!(run(start_time).await, run(start_time + 1).await);
+    }
+
+    #[test]
+    fn test_parse_start_time() {
+        assert_eq!(
+            parse_start_time("2023-11-14T22:13:20Z").unwrap(),
+            1_700_000_000_000_000_000
+        );
+        assert_eq!(
+            parse_start_time("1700000000000000").unwrap(),
+            1_700_000_000_000_000_000
+        );
+        assert!(parse_start_time("yesterday").is_err());
+    }
+}
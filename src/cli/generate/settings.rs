@@ -0,0 +1,191 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Settings of the generators read from a yaml or json file given with
+//! `--config` and from the `O2_INGEST_*` environment variables, e.g.
+//! `O2_INGEST_PASSWORD`, so the credentials stay out of the command lines and
+//! the CI logs. The flags take precedence over the environment variables,
+//! which take precedence over the file.
+
+use std::{fmt::Display, str::FromStr};
+
+use serde::Deserialize;
+
+use super::metrics;
+
+const ENV_PREFIX: &str = "O2_INGEST_";
+
+#[derive(Clone, Debug, Default, PartialEq, Deserialize)]
+#[serde(default, deny_unknown_fields)]
+pub(super) struct Settings {
+    pub protocol: Option<String>,
+    pub url: Option<String>,
+    pub grpc_url: Option<String>,
+    pub org: Option<String>,
+    pub stream: Option<String>,
+    pub user: Option<String>,
+    pub password: Option<String>,
+    pub batch_size: Option<usize>,
+    pub batches: Option<usize>,
+    /// Milliseconds between two batches
+    pub interval: Option<u64>,
+    pub buckets: Option<Vec<f64>>,
+    /// Path of the spec file of `generate-metrics`
+    pub spec: Option<String>,
+    pub seed: Option<u64>,
+    /// End of the first batch, a timestamp or a RFC 3339 date
+    pub start_time: Option<String>,
+}
+
+impl Settings {
+    /// Reads the config file, yaml or json
+    pub fn load(path: &str) -> Result<Self, String> {
+        let data = std::fs::read_to_string(path).map_err(|e| format!("read {path}: {e}"))?;
+        Self::parse(&data).map_err(|e| format!("parse {path}: {e}"))
+    }
+
+    fn parse(data: &str) -> Result<Self, String> {
+        // json is valid yaml
+        let settings: Settings = serde_yaml::from_str(data).map_err(|e| e.to_string())?;
+        if let Some(buckets) = settings.buckets.as_ref() {
+            metrics::check_buckets(buckets)?;
+        }
+        Ok(settings)
+    }
+
+    /// Reads the `O2_INGEST_*` environment variables
+    pub fn from_env() -> Result<Self, String> {
+        Self::from_vars(|name| std::env::var(format!("{ENV_PREFIX}{name}")).ok())
+    }
+
+    fn from_vars(var: impl Fn(&str) -> Option<String>) -> Result<Self, String> {
+        let var = |name: &str| var(name).filter(|v| !v.trim().is_empty());
+        Ok(Self {
+            protocol: var("PROTOCOL"),
+            url: var("URL"),
+            grpc_url: var("GRPC_URL"),
+            org: var("ORG"),
+            stream: var("STREAM"),
+            user: var("USER"),
+            password: var("PASSWORD"),
+            batch_size: parse_var("BATCH_SIZE", var("BATCH_SIZE"))?,
+            batches: parse_var("BATCHES", var("BATCHES"))?,
+            interval: parse_var("INTERVAL", var("INTERVAL"))?,
+            buckets: var("BUCKETS")
+                .map(|v| metrics::parse_buckets(&v))
+                .transpose()
+                .map_err(|e| format!("{ENV_PREFIX}BUCKETS: {e}"))?,
+            spec: var("SPEC"),
+            seed: parse_var("SEED", var("SEED"))?,
+            start_time: var("START_TIME"),
+        })
+    }
+
+    /// Fills the settings missing here with the ones of `other`
+    pub fn or(self, other: Settings) -> Settings {
+        Settings {
+            protocol: self.protocol.or(other.protocol),
+            url: self.url.or(other.url),
+            grpc_url: self.grpc_url.or(other.grpc_url),
+            org: self.org.or(other.org),
+            stream: self.stream.or(other.stream),
+            user: self.user.or(other.user),
+            password: self.password.or(other.password),
+            batch_size: self.batch_size.or(other.batch_size),
+            batches: self.batches.or(other.batches),
+            interval: self.interval.or(other.interval),
+            buckets: self.buckets.or(other.buckets),
+            spec: self.spec.or(other.spec),
+            seed: self.seed.or(other.seed),
+            start_time: self.start_time.or(other.start_time),
+        }
+    }
+}
+
+fn parse_var<T>(name: &str, value: Option<String>) -> Result<Option<T>, String>
+where
+    T: FromStr,
+    T::Err: Display,
+{
+    value
+        .map(|v| v.trim().parse::<T>())
+        .transpose()
+        .map_err(|e| format!("{ENV_PREFIX}{name}: {e}"))
+}
+
+#[cfg(test)]
+mod tests {
+    use hashbrown::HashMap;
+
+    use super::*;
+
+    #[test]
+    fn test_parse() {
+        let yaml = "url: https://o2.example.com\nuser: ci@example.com\nbatches: 5\nbuckets: [1, 10, 100]\n";
+        let settings = Settings::parse(yaml).unwrap();
+        assert_eq!(settings.url.as_deref(), Some("https://o2.example.com"));
+        assert_eq!(settings.batches, Some(5));
+        assert_eq!(settings.buckets, Some(vec![1.0, 10.0, 100.0]));
+        assert_eq!(settings.password, None);
+
+        let json = r#"{"org": "ci", "batch_size": 10}"#;
+        let settings = Settings::parse(json).unwrap();
+        assert_eq!(settings.org.as_deref(), Some("ci"));
+        assert_eq!(settings.batch_size, Some(10));
+
+        assert!(Settings::parse("passwd: secret").is_err());
+        assert!(Settings::parse("buckets: [10, 1]").is_err());
+    }
+
+    #[test]
+    fn test_from_vars() {
+        let vars = HashMap::from([
+            ("PASSWORD", "secret"),
+            ("BATCHES", "0"),
+            ("BUCKETS", "1,2,3"),
+            ("ORG", " "),
+        ]);
+        let settings = Settings::from_vars(|name| vars.get(name).map(|v| v.to_string())).unwrap();
+        assert_eq!(settings.password.as_deref(), Some("secret"));
+        assert_eq!(settings.batches, Some(0));
+        assert_eq!(settings.buckets, Some(vec![1.0, 2.0, 3.0]));
+        assert_eq!(settings.org, None);
+
+        let err = Settings::from_vars(|name| (name == "INTERVAL").then(|| "1s".to_string()));
+        assert!(err.unwrap_err().starts_with("O2_INGEST_INTERVAL"));
+    }
+
+    #[test]
+    fn test_or() {
+        let flags = Settings {
+            url: Some("http://flag".to_string()),
+            ..Default::default()
+        };
+        let env = Settings {
+            url: Some("http://env".to_string()),
+            password: Some("env".to_string()),
+            ..Default::default()
+        };
+        let file = Settings {
+            password: Some("file".to_string()),
+            org: Some("file".to_string()),
+            ..Default::default()
+        };
+        let settings = flags.or(env).or(file);
+        assert_eq!(settings.url.as_deref(), Some("http://flag"));
+        assert_eq!(settings.password.as_deref(), Some("env"));
+        assert_eq!(settings.org.as_deref(), Some("file"));
+    }
+}
@@ -0,0 +1,375 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Traces of requests going through the services, a server span per service
+//! with the client spans of its calls to the other services and the database,
+//! realistic durations, error statuses propagated to the callers, and span
+//! events.
+
+use std::collections::BTreeMap;
+
+use opentelemetry_proto::tonic::{
+    collector::trace::v1::ExportTraceServiceRequest,
+    common::v1::{any_value::Value, AnyValue, InstrumentationScope, KeyValue},
+    resource::v1::Resource,
+    trace::v1::{
+        span::{Event, SpanKind},
+        status::StatusCode,
+        ResourceSpans, ScopeSpans, Span, Status,
+    },
+};
+use rand::{seq::SliceRandom, Rng};
+
+use super::{string_attr, ENVIRONMENTS, HOSTS, METHODS, SERVICES};
+
+/// Services called by each service of [`SERVICES`], by index
+const CALLS: [&[usize]; 5] = [&[1, 3, 4], &[2, 3], &[], &[], &[]];
+
+/// Operations served by each service of [`SERVICES`]
+const OPERATIONS: [&[&str]; 5] = [
+    &["/api/cart", "/api/products", "/api/checkout"],
+    &["/checkout", "/checkout/quote"],
+    &["/charge", "/refund"],
+    &["/stock", "/reserve"],
+    &["/session", "/token"],
+];
+
+const QUERIES: [&str; 4] = [
+    "SELECT * FROM orders WHERE user_id = ?",
+    "UPDATE stock SET quantity = quantity - ? WHERE sku = ?",
+    "SELECT price FROM products WHERE sku IN (?)",
+    "INSERT INTO payments (order_id, amount) VALUES (?, ?)",
+];
+
+/// Levels of services below the root one
+const MAX_DEPTH: usize = 3;
+/// Percent of the spans failing by themselves
+const ERROR_RATE: u32 = 3;
+const MILLI: u64 = 1_000_000;
+
+/// Generates `size` traces ending before `now`, with one resource per service
+/// and host
+pub(super) fn generate(rng: &mut impl Rng, size: usize, now: i64) -> ExportTraceServiceRequest {
+    let mut spans = Vec::new();
+    for _ in 0..size {
+        let mut trace = TraceBuilder {
+            trace_id: rng.gen::<[u8; 16]>().to_vec(),
+            spans: Vec::new(),
+        };
+        let duration = rng.gen_range(20..1_500) * MILLI;
+        let start = now as u64 - duration - rng.gen_range(0..1_000) * MILLI;
+        trace.server_span(rng, 0, &[], start, duration, 0);
+        spans.append(&mut trace.spans);
+    }
+
+    let mut resources: BTreeMap<(usize, &str, &str), Vec<Span>> = BTreeMap::new();
+    for (service, span) in spans {
+        let host = *HOSTS.choose(rng).unwrap();
+        let env = *ENVIRONMENTS.choose(rng).unwrap();
+        resources
+            .entry((service, host, env))
+            .or_default()
+            .push(span);
+    }
+    let resource_spans = resources
+        .into_iter()
+        .map(|((service, host, env), spans)| {
+            let (name, version) = SERVICES[service];
+            ResourceSpans {
+                resource: Some(Resource {
+                    attributes: vec![
+                        string_attr("service.name", name),
+                        string_attr("service.version", version),
+                        string_attr("host.name", host),
+                        string_attr("deployment.environment", env),
+                    ],
+                    dropped_attributes_count: 0,
+                }),
+                scope_spans: vec![ScopeSpans {
+                    scope: Some(InstrumentationScope {
+                        name: "openobserve-generator".to_string(),
+                        version: env!("CARGO_PKG_VERSION").to_string(),
+                        ..Default::default()
+                    }),
+                    spans,
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }
+        })
+        .collect();
+    ExportTraceServiceRequest { resource_spans }
+}
+
+struct TraceBuilder {
+    trace_id: Vec<u8>,
+    /// Spans with the index of their service
+    spans: Vec<(usize, Span)>,
+}
+
+impl TraceBuilder {
+    /// Adds the span of `service` serving a request, with the spans of its
+    /// calls, and returns true when the request failed
+    fn server_span(
+        &mut self,
+        rng: &mut impl Rng,
+        service: usize,
+        parent_span_id: &[u8],
+        start: u64,
+        duration: u64,
+        depth: usize,
+    ) -> bool {
+        let span_id = rng.gen::<[u8; 8]>().to_vec();
+        let method = *METHODS.choose(rng).unwrap();
+        let route = *OPERATIONS[service].choose(rng).unwrap();
+
+        // the calls share the duration of the request, one after the other
+        let mut callees: Vec<usize> = if depth < MAX_DEPTH {
+            CALLS[service]
+                .iter()
+                .copied()
+                .filter(|_| rng.gen_bool(0.7))
+                .collect()
+        } else {
+            vec![]
+        };
+        callees.shuffle(rng);
+        let with_query = service != 0 && rng.gen_bool(0.6);
+        let calls = callees.len() + usize::from(with_query);
+        let slot = duration / (calls as u64 + 1);
+        let mut failed = rng.gen_range(0..100) < ERROR_RATE;
+        let mut events = Vec::new();
+        let mut offset = slot / 4;
+        for callee in callees {
+            let call_duration = slot * rng.gen_range(50..90) / 100;
+            let call_start = start + offset;
+            offset += slot;
+            if self.client_span(
+                rng,
+                service,
+                callee,
+                &span_id,
+                call_start,
+                call_duration,
+                depth,
+            ) {
+                failed = true;
+            }
+        }
+        if with_query {
+            let query_duration = slot * rng.gen_range(10..80) / 100;
+            self.query_span(rng, service, &span_id, start + offset, query_duration);
+        }
+        if rng.gen_bool(0.2) {
+            events.push(event(
+                start + duration / 10,
+                "cache miss",
+                vec![string_attr(
+                    "cache.key",
+                    &format!("{route}:{:x}", rng.gen::<u16>()),
+                )],
+            ));
+        }
+        let status_code = if failed { 500 } else { 200 };
+        if failed {
+            events.push(exception_event(start + duration - MILLI.min(duration)));
+        }
+        self.spans.push((
+            service,
+            Span {
+                trace_id: self.trace_id.clone(),
+                span_id,
+                parent_span_id: parent_span_id.to_vec(),
+                name: format!("{method} {route}"),
+                kind: SpanKind::Server as i32,
+                start_time_unix_nano: start,
+                end_time_unix_nano: start + duration,
+                attributes: vec![
+                    string_attr("http.method", method),
+                    string_attr("http.route", route),
+                    int_attr("http.status_code", status_code),
+                ],
+                events,
+                status: Some(status(failed)),
+                ..Default::default()
+            },
+        ));
+        failed
+    }
+
+    /// Adds the span of `service` calling `callee`, with the server span of the
+    /// callee a bit shorter for the network, and returns true when the call
+    /// failed
+    #[allow(clippy::too_many_arguments)]
+    fn client_span(
+        &mut self,
+        rng: &mut impl Rng,
+        service: usize,
+        callee: usize,
+        parent_span_id: &[u8],
+        start: u64,
+        duration: u64,
+        depth: usize,
+    ) -> bool {
+        let span_id = rng.gen::<[u8; 8]>().to_vec();
+        let network = (duration / 20).min(2 * MILLI);
+        let failed = self.server_span(
+            rng,
+            callee,
+            &span_id,
+            start + network,
+            duration - 2 * network,
+            depth + 1,
+        );
+        let callee_name = SERVICES[callee].0;
+        self.spans.push((
+            service,
+            Span {
+                trace_id: self.trace_id.clone(),
+                span_id,
+                parent_span_id: parent_span_id.to_vec(),
+                name: format!("call {callee_name}"),
+                kind: SpanKind::Client as i32,
+                start_time_unix_nano: start,
+                end_time_unix_nano: start + duration,
+                attributes: vec![
+                    string_attr("peer.service", callee_name),
+                    int_attr("http.status_code", if failed { 500 } else { 200 }),
+                ],
+                status: Some(status(failed)),
+                ..Default::default()
+            },
+        ));
+        failed
+    }
+
+    fn query_span(
+        &mut self,
+        rng: &mut impl Rng,
+        service: usize,
+        parent_span_id: &[u8],
+        start: u64,
+        duration: u64,
+    ) {
+        let query = *QUERIES.choose(rng).unwrap();
+        let operation = query.split(' ').next().unwrap_or_default();
+        self.spans.push((
+            service,
+            Span {
+                trace_id: self.trace_id.clone(),
+                span_id: rng.gen::<[u8; 8]>().to_vec(),
+                parent_span_id: parent_span_id.to_vec(),
+                name: format!("{operation} {}", SERVICES[service].0),
+                kind: SpanKind::Client as i32,
+                start_time_unix_nano: start,
+                end_time_unix_nano: start + duration,
+                attributes: vec![
+                    string_attr("db.system", "postgresql"),
+                    string_attr("db.statement", query),
+                    int_attr("db.rows", rng.gen_range(0..200)),
+                ],
+                status: Some(status(false)),
+                ..Default::default()
+            },
+        ));
+    }
+}
+
+fn status(failed: bool) -> Status {
+    if failed {
+        Status {
+            message: "internal error".to_string(),
+            code: StatusCode::Error as i32,
+        }
+    } else {
+        Status {
+            message: "".to_string(),
+            code: StatusCode::Ok as i32,
+        }
+    }
+}
+
+fn event(time: u64, name: &str, attributes: Vec<KeyValue>) -> Event {
+    Event {
+        time_unix_nano: time,
+        name: name.to_string(),
+        attributes,
+        dropped_attributes_count: 0,
+    }
+}
+
+fn exception_event(time: u64) -> Event {
+    event(
+        time,
+        "exception",
+        vec![
+            string_attr("exception.type", "UpstreamError"),
+            string_attr("exception.message", "upstream request failed"),
+        ],
+    )
+}
+
+fn int_attr(key: &str, value: i64) -> KeyValue {
+    KeyValue {
+        key: key.to_string(),
+        value: Some(AnyValue {
+            value: Some(Value::IntValue(value)),
+        }),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashMap;
+
+    use super::*;
+
+    #[test]
+    fn test_generate() {
+        let now = 1_700_000_000_000_000_000;
+        let request = generate(&mut rand::thread_rng(), 50, now);
+        let spans = request
+            .resource_spans
+            .iter()
+            .flat_map(|r| r.scope_spans.iter().flat_map(|s| s.spans.iter()))
+            .collect::<Vec<_>>();
+        let by_id = spans
+            .iter()
+            .map(|s| (s.span_id.clone(), *s))
+            .collect::<HashMap<_, _>>();
+        let roots = spans.iter().filter(|s| s.parent_span_id.is_empty()).count();
+        assert_eq!(roots, 50);
+        for span in spans.iter() {
+            assert!(span.start_time_unix_nano < span.end_time_unix_nano);
+            assert!(span.end_time_unix_nano <= now as u64);
+            if span.parent_span_id.is_empty() {
+                continue;
+            }
+            let parent = by_id[&span.parent_span_id];
+            assert_eq!(parent.trace_id, span.trace_id);
+            assert!(span.start_time_unix_nano >= parent.start_time_unix_nano);
+            assert!(span.end_time_unix_nano <= parent.end_time_unix_nano);
+            // the failures are propagated to the callers
+            if span.status.as_ref().unwrap().code == StatusCode::Error as i32
+                && span.kind == SpanKind::Server as i32
+            {
+                assert_eq!(
+                    parent.status.as_ref().unwrap().code,
+                    StatusCode::Error as i32
+                );
+            }
+        }
+    }
+}
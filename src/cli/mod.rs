@@ -15,3 +15,4 @@
 
 pub mod basic;
 pub mod data;
+pub mod generate;
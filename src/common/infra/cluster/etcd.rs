@@ -26,6 +26,8 @@ use infra::{
     errors::{Error, Result},
 };
 
+use crate::common::infra::tls;
+
 /// Register and keepalive the node to cluster
 pub(crate) async fn register_and_keepalive() -> Result<()> {
     if let Err(e) = register().await {
@@ -138,7 +140,12 @@ async fn register() -> Result<()> {
         id: new_node_id,
         uuid: LOCAL_NODE_UUID.clone(),
         name: cfg.common.instance_name.clone(),
-        http_addr: format!("http://{}:{}", get_local_http_ip(), cfg.http.port),
+        http_addr: format!(
+            "{}://{}:{}",
+            tls::http_scheme(),
+            get_local_http_ip(),
+            cfg.http.port
+        ),
         grpc_addr: format!("http://{}:{}", get_local_grpc_ip(), cfg.grpc.port),
         role: LOCAL_NODE_ROLE.clone(),
         cpu_num: cfg.limit.cpu_num as u64,
@@ -212,7 +219,12 @@ pub(crate) async fn set_status(status: NodeStatus, new_lease_id: bool) -> Result
             id: unsafe { LOCAL_NODE_ID },
             uuid: LOCAL_NODE_UUID.clone(),
             name: cfg.common.instance_name.clone(),
-            http_addr: format!("http://{}:{}", get_local_node_ip(), cfg.http.port),
+            http_addr: format!(
+                "{}://{}:{}",
+                tls::http_scheme(),
+                get_local_node_ip(),
+                cfg.http.port
+            ),
             grpc_addr: format!("http://{}:{}", get_local_node_ip(), cfg.grpc.port),
             role: LOCAL_NODE_ROLE.clone(),
             cpu_num: cfg.limit.cpu_num as u64,
@@ -27,12 +27,12 @@ use config::{
 };
 use infra::{
     db::{get_coordinator, Event},
-    errors::Result,
+    errors::{Error, Result},
 };
 use once_cell::sync::Lazy;
 use tokio::time;
 
-use crate::service::db as db_service;
+use crate::{common::infra::tls, service::db as db_service};
 
 mod etcd;
 mod nats;
@@ -131,9 +131,10 @@ pub async fn register_and_keepalive() -> Result<()> {
     };
 
     // check node heatbeat
+    let client = health_check_client()
+        .map_err(|e| Error::Message(format!("health check client init failed: {e}")))?;
     tokio::task::spawn(async move {
         let ttl_keep_alive = min(10, (cfg.limit.node_heartbeat_ttl / 2) as u64);
-        let client = reqwest::Client::new();
         loop {
             time::sleep(time::Duration::from_secs(ttl_keep_alive)).await;
             if let Err(e) = check_nodes_status(&client).await {
@@ -320,6 +321,17 @@ async fn watch_node_list() -> Result<()> {
     Ok(())
 }
 
+/// Client of the health checks, trusting the cluster CA when the nodes serve
+/// https
+fn health_check_client() -> std::result::Result<reqwest::Client, anyhow::Error> {
+    if !get_config().tls.http_enabled {
+        return Ok(reqwest::Client::new());
+    }
+    Ok(reqwest::Client::builder()
+        .use_preconfigured_tls(tls::http_client_config()?)
+        .build()?)
+}
+
 async fn check_nodes_status(client: &reqwest::Client) -> Result<()> {
     let cfg = get_config();
     let nodes = get_cached_online_nodes().await.unwrap_or_default();
@@ -383,7 +395,7 @@ pub fn load_local_mode_node() -> Node {
         id: 1,
         uuid: LOCAL_NODE_UUID.clone(),
         name: cfg.common.instance_name.clone(),
-        http_addr: format!("http://127.0.0.1:{}", cfg.http.port),
+        http_addr: format!("{}://127.0.0.1:{}", tls::http_scheme(), cfg.http.port),
         grpc_addr: format!("http://127.0.0.1:{}", cfg.grpc.port),
         role: [Role::All].to_vec(),
         cpu_num: cfg.limit.cpu_num as u64,
@@ -411,6 +423,23 @@ pub async fn get_cached_online_ingester_nodes() -> Option<Vec<Node>> {
     .await
 }
 
+/// Returns the ingester responsible for the key, the keys are spread evenly
+/// over the online ingesters
+pub async fn get_ingester_by_key(key: &str) -> Option<String> {
+    let mut nodes = get_cached_online_ingester_nodes()
+        .await?
+        .into_iter()
+        .map(|node| node.uuid)
+        .collect::<Vec<_>>();
+    if nodes.is_empty() {
+        return None;
+    }
+    nodes.sort();
+    let hash = config::utils::hash::gxhash::new().sum64(key);
+    let idx = (hash % nodes.len() as u64) as usize;
+    Some(nodes.swap_remove(idx))
+}
+
 #[inline]
 pub async fn get_cached_online_querier_nodes() -> Option<Vec<Node>> {
     get_cached_nodes(|node| {
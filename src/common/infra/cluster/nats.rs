@@ -28,6 +28,8 @@ use infra::{
 };
 use tokio::{task, time};
 
+use crate::common::infra::tls;
+
 /// Register and keepalive the node to cluster
 pub(crate) async fn register_and_keepalive() -> Result<()> {
     if let Err(e) = register().await {
@@ -130,7 +132,12 @@ async fn register() -> Result<()> {
         id: new_node_id,
         uuid: LOCAL_NODE_UUID.clone(),
         name: cfg.common.instance_name.clone(),
-        http_addr: format!("http://{}:{}", get_local_http_ip(), cfg.http.port),
+        http_addr: format!(
+            "{}://{}:{}",
+            tls::http_scheme(),
+            get_local_http_ip(),
+            cfg.http.port
+        ),
         grpc_addr: format!("http://{}:{}", get_local_grpc_ip(), cfg.grpc.port),
         role: LOCAL_NODE_ROLE.clone(),
         cpu_num: cfg.limit.cpu_num as u64,
@@ -191,7 +198,12 @@ pub(crate) async fn set_status(status: NodeStatus) -> Result<()> {
             id: unsafe { LOCAL_NODE_ID },
             uuid: LOCAL_NODE_UUID.clone(),
             name: cfg.common.instance_name.clone(),
-            http_addr: format!("http://{}:{}", get_local_node_ip(), cfg.http.port),
+            http_addr: format!(
+                "{}://{}:{}",
+                tls::http_scheme(),
+                get_local_node_ip(),
+                cfg.http.port
+            ),
             grpc_addr: format!("http://{}:{}", get_local_node_ip(), cfg.grpc.port),
             role: LOCAL_NODE_ROLE.clone(),
             cpu_num: cfg.limit.cpu_num as u64,
@@ -27,12 +27,16 @@ use crate::{
         alerts,
         dashboards::reports,
         functions::{StreamFunctionsList, Transform},
+        ingest_keys::IngestKey,
         maxmind::MaxmindClient,
-        organization::OrganizationSetting,
+        organization::{NetworkPolicy, OrgResidency, OrganizationSetting},
         pipelines::PipeLine,
+        plugins::Plugin,
         prom::ClusterLeader,
+        schema_contract::SchemaContract,
+        subscriptions::StreamSubscription,
         syslog::SyslogRoute,
-        user::User,
+        user::{User, UserSession},
     },
     service::{
         db::scheduler as db_scheduler, enrichment::StreamTable, enrichment_table::geoip::Geoip,
@@ -86,4 +90,13 @@ pub static GEOIP_ASN_TABLE: Lazy<Arc<RwLock<Option<Geoip>>>> =
     Lazy::new(|| Arc::new(RwLock::new(None)));
 
 pub static USER_SESSIONS: Lazy<RwHashMap<String, String>> = Lazy::new(Default::default);
+pub static ORG_NETWORK_POLICIES: Lazy<RwHashMap<String, NetworkPolicy>> =
+    Lazy::new(Default::default);
+pub static ORG_RESIDENCY: Lazy<RwHashMap<String, OrgResidency>> = Lazy::new(Default::default);
+pub static INGEST_KEYS: Lazy<RwHashMap<String, IngestKey>> = Lazy::new(Default::default);
+pub static PLUGINS: Lazy<RwHashMap<String, Plugin>> = Lazy::new(Default::default);
+pub static STREAM_SUBSCRIPTIONS: Lazy<RwHashMap<String, StreamSubscription>> =
+    Lazy::new(Default::default);
+pub static USER_LOGIN_SESSIONS: Lazy<RwHashMap<String, UserSession>> = Lazy::new(Default::default);
 pub static STREAM_PIPELINES: Lazy<RwHashMap<String, PipeLine>> = Lazy::new(DashMap::default);
+pub static SCHEMA_CONTRACTS: Lazy<RwHashMap<String, SchemaContract>> = Lazy::new(DashMap::default);
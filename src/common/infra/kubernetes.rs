@@ -0,0 +1,126 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Minimal client of the Kubernetes API, authenticated with the service
+//! account of the pod OpenObserve runs in.
+
+use config::utils::json;
+
+const SERVICE_ACCOUNT_DIR: &str = "/var/run/secrets/kubernetes.io/serviceaccount";
+
+pub struct Client {
+    base_url: String,
+    token: String,
+    http: reqwest::Client,
+}
+
+impl Client {
+    pub fn in_cluster() -> Result<Self, anyhow::Error> {
+        let host = std::env::var("KUBERNETES_SERVICE_HOST")
+            .map_err(|_| anyhow::anyhow!("not running inside a kubernetes cluster"))?;
+        let port = std::env::var("KUBERNETES_SERVICE_PORT").unwrap_or("443".to_string());
+        let token = std::fs::read_to_string(format!("{SERVICE_ACCOUNT_DIR}/token"))?;
+        let ca = std::fs::read(format!("{SERVICE_ACCOUNT_DIR}/ca.crt"))?;
+        let http = reqwest::Client::builder()
+            .add_root_certificate(reqwest::Certificate::from_pem(&ca)?)
+            .build()?;
+        let host = if host.contains(':') {
+            format!("[{host}]") // ipv6
+        } else {
+            host
+        };
+        Ok(Self {
+            base_url: format!("https://{host}:{port}"),
+            token: token.trim().to_string(),
+            http,
+        })
+    }
+
+    /// Sends a GET request to the API path, e.g. `/api/v1/pods`
+    pub async fn get(
+        &self,
+        path: &str,
+        query: &[(&str, &str)],
+    ) -> Result<json::Value, anyhow::Error> {
+        let resp = self
+            .http
+            .get(format!("{}{path}", self.base_url))
+            .bearer_auth(&self.token)
+            .query(query)
+            .send()
+            .await?;
+        let status = resp.status();
+        let body = resp.bytes().await?;
+        if !status.is_success() {
+            return Err(anyhow::anyhow!(
+                "kubernetes api {path} error: {status} {}",
+                String::from_utf8_lossy(&body)
+            ));
+        }
+        Ok(json::from_slice(&body)?)
+    }
+
+    /// Watches the changes of the resources listed by the API path, starting
+    /// after the given resource version
+    pub async fn watch(&self, path: &str, resource_version: &str) -> Result<Watch, anyhow::Error> {
+        let resp = self
+            .http
+            .get(format!("{}{path}", self.base_url))
+            .bearer_auth(&self.token)
+            .query(&[
+                ("watch", "true"),
+                ("allowWatchBookmarks", "true"),
+                ("resourceVersion", resource_version),
+            ])
+            .send()
+            .await?;
+        if !resp.status().is_success() {
+            return Err(anyhow::anyhow!(
+                "kubernetes api {path} watch error: {}",
+                resp.status()
+            ));
+        }
+        Ok(Watch {
+            resp,
+            buf: Vec::new(),
+        })
+    }
+}
+
+/// Stream of the watch events, one JSON document per line like
+/// `{"type": "MODIFIED", "object": {...}}`
+pub struct Watch {
+    resp: reqwest::Response,
+    buf: Vec<u8>,
+}
+
+impl Watch {
+    /// Returns the next event, `None` once the server closed the watch
+    pub async fn next(&mut self) -> Result<Option<json::Value>, anyhow::Error> {
+        loop {
+            if let Some(pos) = self.buf.iter().position(|b| *b == b'\n') {
+                let line = self.buf.drain(..=pos).collect::<Vec<_>>();
+                if line.iter().all(|b| b.is_ascii_whitespace()) {
+                    continue;
+                }
+                return Ok(Some(json::from_slice(&line)?));
+            }
+            match self.resp.chunk().await? {
+                Some(chunk) => self.buf.extend_from_slice(&chunk),
+                None => return Ok(None),
+            }
+        }
+    }
+}
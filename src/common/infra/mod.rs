@@ -19,7 +19,9 @@ use crate::service::db::instance;
 
 pub mod cluster;
 pub mod config;
+pub mod kubernetes;
 pub mod ofga;
+pub mod tls;
 pub mod wal;
 
 pub async fn init() -> Result<(), anyhow::Error> {
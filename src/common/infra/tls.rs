@@ -0,0 +1,633 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! TLS of the http and gRPC listeners and mutual TLS between the nodes of the
+//! cluster.
+//!
+//! One policy, the minimum version and the allowed cipher suites, applies to
+//! every listener and to the gRPC clients of the other nodes and the plugins.
+//! Certificates are read from PEM files which are checked for changes every
+//! `ZO_TLS_RELOAD_INTERVAL` seconds, so they can be rotated without a restart:
+//! new connections use the new certificates, established ones keep theirs.
+//!
+//! The gRPC listener also serves external clients such as OTLP exporters, so
+//! a client certificate is optional at the handshake, but it is verified when
+//! given and the internal services require one, see
+//! [crate::handler::grpc::auth::check_auth].
+
+use std::{
+    collections::HashMap,
+    fmt, fs,
+    future::Future,
+    net::SocketAddr,
+    pin::Pin,
+    sync::Arc,
+    task::Poll,
+    time::{Duration, SystemTime},
+};
+
+use anyhow::{anyhow, Context, Result};
+use arc_swap::{ArcSwap, ArcSwapOption};
+use config::get_config;
+use once_cell::sync::{Lazy, OnceCell};
+use rustls::{
+    client::{
+        danger::{HandshakeSignatureValid, ServerCertVerified, ServerCertVerifier},
+        WantsClientCert, WebPkiServerVerifier,
+    },
+    crypto::{ring, CryptoProvider},
+    pki_types::{CertificateDer, PrivateKeyDer, ServerName, UnixTime},
+    server::{ClientHello, ResolvesServerCert, WebPkiClientVerifier},
+    sign::CertifiedKey,
+    version, CipherSuite, ClientConfig, ConfigBuilder, DigitallySignedStruct, NamedGroup,
+    RootCertStore, ServerConfig, SignatureScheme, SupportedProtocolVersion,
+};
+use tokio::net::{TcpListener, TcpStream};
+use tokio_rustls::{client, server::TlsStream, TlsAcceptor, TlsConnector};
+use tokio_stream::wrappers::ReceiverStream;
+use tonic::transport::{Channel, Endpoint, Uri};
+
+static TLS13_ONLY: &[&SupportedProtocolVersion] = &[&version::TLS13];
+
+/// FIPS 140 approved cipher suites supported by the crypto provider
+const FIPS_CIPHER_SUITES: [CipherSuite; 6] = [
+    CipherSuite::TLS13_AES_128_GCM_SHA256,
+    CipherSuite::TLS13_AES_256_GCM_SHA384,
+    CipherSuite::TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+    CipherSuite::TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+    CipherSuite::TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+    CipherSuite::TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+];
+
+/// FIPS 140 approved key exchange groups supported by the crypto provider
+const FIPS_KX_GROUPS: [NamedGroup; 2] = [NamedGroup::secp256r1, NamedGroup::secp384r1];
+
+const HANDSHAKE_TIMEOUT: Duration = Duration::from_secs(10);
+
+static HTTP_CERT: OnceCell<Arc<CertResolver>> = OnceCell::new();
+static GRPC_SERVER_CONFIG: Lazy<ArcSwapOption<ServerConfig>> = Lazy::new(Default::default);
+static GRPC_CLIENT_CONFIG: Lazy<ArcSwapOption<ClientConfig>> = Lazy::new(Default::default);
+
+/// Serves the current http certificate, swapped when the files change.
+struct CertResolver(ArcSwap<CertifiedKey>);
+
+impl fmt::Debug for CertResolver {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("CertResolver")
+    }
+}
+
+impl ResolvesServerCert for CertResolver {
+    fn resolve(&self, _client_hello: ClientHello) -> Option<Arc<CertifiedKey>> {
+        Some(self.0.load_full())
+    }
+}
+
+/// Verifies the certificates of the other nodes against the cluster CA,
+/// expecting `ZO_TLS_DOMAIN_NAME` instead of the address of the node, which
+/// changes whenever the node is rescheduled.
+#[derive(Debug)]
+struct ClusterCertVerifier {
+    inner: Arc<WebPkiServerVerifier>,
+    domain_name: ServerName<'static>,
+}
+
+impl ServerCertVerifier for ClusterCertVerifier {
+    fn verify_server_cert(
+        &self,
+        end_entity: &CertificateDer<'_>,
+        intermediates: &[CertificateDer<'_>],
+        _server_name: &ServerName<'_>,
+        ocsp_response: &[u8],
+        now: UnixTime,
+    ) -> Result<ServerCertVerified, rustls::Error> {
+        self.inner.verify_server_cert(
+            end_entity,
+            intermediates,
+            &self.domain_name,
+            ocsp_response,
+            now,
+        )
+    }
+
+    fn verify_tls12_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> Result<HandshakeSignatureValid, rustls::Error> {
+        self.inner.verify_tls12_signature(message, cert, dss)
+    }
+
+    fn verify_tls13_signature(
+        &self,
+        message: &[u8],
+        cert: &CertificateDer<'_>,
+        dss: &DigitallySignedStruct,
+    ) -> Result<HandshakeSignatureValid, rustls::Error> {
+        self.inner.verify_tls13_signature(message, cert, dss)
+    }
+
+    fn supported_verify_schemes(&self) -> Vec<SignatureScheme> {
+        self.inner.supported_verify_schemes()
+    }
+}
+
+/// Loads the certificates, the node doesn't start when they are not valid.
+pub fn init() -> Result<()> {
+    let cfg = get_config();
+    if cfg.tls.http_enabled {
+        reload_http()?;
+    }
+    if cfg.tls.grpc_enabled {
+        reload_grpc()?;
+    }
+    Ok(())
+}
+
+/// Scheme of the http address the node registers in the cluster
+pub fn http_scheme() -> &'static str {
+    if get_config().tls.http_enabled {
+        "https"
+    } else {
+        "http"
+    }
+}
+
+/// Config of the https listener
+pub fn http_server_config() -> Result<ServerConfig> {
+    let resolver = HTTP_CERT
+        .get()
+        .ok_or_else(|| anyhow!("http tls is not initialized"))?
+        .clone();
+    let (provider, versions) = policy()?;
+    Ok(ServerConfig::builder_with_provider(Arc::new(provider))
+        .with_protocol_versions(versions)?
+        .with_no_client_auth()
+        .with_cert_resolver(resolver))
+}
+
+/// Config of the clients calling the http endpoints of the other nodes
+pub fn http_client_config() -> Result<ClientConfig> {
+    Ok(cluster_client_builder()?.with_no_client_auth())
+}
+
+/// Endpoint of the gRPC server of another node, see [grpc_endpoint]
+pub struct GrpcEndpoint {
+    endpoint: Endpoint,
+    tls: Option<TlsConnector>,
+    connect_timeout: Duration,
+}
+
+impl GrpcEndpoint {
+    pub fn connect_timeout(mut self, connect_timeout: Duration) -> Self {
+        self.endpoint = self.endpoint.connect_timeout(connect_timeout);
+        self.connect_timeout = connect_timeout;
+        self
+    }
+
+    pub fn timeout(mut self, timeout: Duration) -> Self {
+        self.endpoint = self.endpoint.timeout(timeout);
+        self
+    }
+
+    pub async fn connect(self) -> Result<Channel, tonic::transport::Error> {
+        match self.tls {
+            Some(connector) => {
+                self.endpoint
+                    .connect_with_connector(TlsConnect {
+                        connector,
+                        connect_timeout: self.connect_timeout,
+                    })
+                    .await
+            }
+            None => self.endpoint.connect().await,
+        }
+    }
+
+    pub fn connect_lazy(self) -> Channel {
+        match self.tls {
+            Some(connector) => self.endpoint.connect_with_connector_lazy(TlsConnect {
+                connector,
+                connect_timeout: self.connect_timeout,
+            }),
+            None => self.endpoint.connect_lazy(),
+        }
+    }
+}
+
+/// Endpoint to connect to the gRPC server of another node. When gRPC TLS is
+/// enabled the connection is made with the TLS policy of the node and
+/// authenticated with its certificate.
+pub fn grpc_endpoint(addr: impl Into<String>) -> Result<GrpcEndpoint> {
+    let addr = addr.into();
+    let connect_timeout = Duration::from_secs(get_config().grpc.connect_timeout);
+    let Some(config) = GRPC_CLIENT_CONFIG.load_full() else {
+        return Ok(GrpcEndpoint {
+            endpoint: Endpoint::from_shared(addr)?,
+            tls: None,
+            connect_timeout,
+        });
+    };
+    // tonic only sees a plain connection, the TLS is done by the connector
+    let addr = match addr.strip_prefix("https://") {
+        Some(host) => format!("http://{host}"),
+        None => addr,
+    };
+    Ok(GrpcEndpoint {
+        endpoint: Endpoint::from_shared(addr)?,
+        tls: Some(TlsConnector::from(config)),
+        connect_timeout,
+    })
+}
+
+/// Channel to a gRPC server outside of the cluster, e.g. a plugin. An https
+/// endpoint is connected to with the TLS policy of the node, its certificate
+/// verified against the CAs of `ca_file`.
+pub fn external_grpc_channel(
+    addr: &str,
+    ca_file: &str,
+    connect_timeout: Duration,
+) -> Result<Channel> {
+    let Some(authority) = addr.strip_prefix("https://") else {
+        return Ok(Endpoint::from_shared(addr.to_string())?
+            .connect_timeout(connect_timeout)
+            .connect_lazy());
+    };
+    if ca_file.is_empty() {
+        return Err(anyhow!("no CA certificates to verify {addr} against"));
+    }
+    let (provider, versions) = policy()?;
+    let mut config = ClientConfig::builder_with_provider(Arc::new(provider))
+        .with_protocol_versions(versions)?
+        .with_root_certificates(load_roots(ca_file)?)
+        .with_no_client_auth();
+    config.alpn_protocols = vec![b"h2".to_vec()];
+    // tonic only sees a plain connection, the TLS is done by the connector
+    let endpoint = Endpoint::from_shared(format!("http://{authority}"))?;
+    Ok(endpoint.connect_with_connector_lazy(TlsConnect {
+        connector: TlsConnector::from(Arc::new(config)),
+        connect_timeout,
+    }))
+}
+
+/// Connects to the gRPC servers over TLS, tonic's own TLS can't restrict the
+/// versions and the cipher suites
+#[derive(Clone)]
+struct TlsConnect {
+    connector: TlsConnector,
+    connect_timeout: Duration,
+}
+
+impl tower::Service<Uri> for TlsConnect {
+    type Response = client::TlsStream<TcpStream>;
+    type Error = std::io::Error;
+    type Future = Pin<Box<dyn Future<Output = std::io::Result<Self::Response>> + Send>>;
+
+    fn poll_ready(&mut self, _cx: &mut std::task::Context<'_>) -> Poll<std::io::Result<()>> {
+        Poll::Ready(Ok(()))
+    }
+
+    fn call(&mut self, uri: Uri) -> Self::Future {
+        Box::pin(connect_tls(
+            self.connector.clone(),
+            uri,
+            self.connect_timeout,
+        ))
+    }
+}
+
+async fn connect_tls(
+    connector: TlsConnector,
+    uri: Uri,
+    connect_timeout: Duration,
+) -> std::io::Result<client::TlsStream<TcpStream>> {
+    let invalid = |e: String| std::io::Error::new(std::io::ErrorKind::InvalidInput, e);
+    let host = uri
+        .host()
+        .ok_or_else(|| invalid(format!("no host in {uri}")))?;
+    let host = host
+        .trim_start_matches('[')
+        .trim_end_matches(']')
+        .to_string();
+    let port = uri.port_u16().unwrap_or(443);
+    let server_name = ServerName::try_from(host.clone()).map_err(|e| invalid(e.to_string()))?;
+    let stream = tokio::time::timeout(connect_timeout, TcpStream::connect((host.as_str(), port)))
+        .await
+        .map_err(|_| std::io::Error::new(std::io::ErrorKind::TimedOut, "connect timed out"))??;
+    stream.set_nodelay(true)?;
+    connector.connect(server_name, stream).await
+}
+
+/// Accepts the TLS connections of the gRPC server. Handshakes run in their own
+/// tasks so a slow client doesn't hold the others back, the failed ones are
+/// only logged.
+pub async fn grpc_incoming(
+    addr: SocketAddr,
+) -> Result<ReceiverStream<Result<TlsStream<TcpStream>, std::io::Error>>> {
+    let listener = TcpListener::bind(addr).await?;
+    let (tx, rx) = tokio::sync::mpsc::channel(1024);
+    tokio::task::spawn(async move {
+        loop {
+            let (stream, peer) = match listener.accept().await {
+                Ok(v) => v,
+                Err(e) => {
+                    log::error!("[TLS] gRPC accept error: {e}");
+                    tokio::time::sleep(Duration::from_millis(100)).await;
+                    continue;
+                }
+            };
+            if tx.is_closed() {
+                break;
+            }
+            let Some(config) = GRPC_SERVER_CONFIG.load_full() else {
+                log::error!("[TLS] gRPC tls is not initialized");
+                break;
+            };
+            let tx = tx.clone();
+            tokio::task::spawn(async move {
+                let _ = stream.set_nodelay(true);
+                let accept = TlsAcceptor::from(config).accept(stream);
+                match tokio::time::timeout(HANDSHAKE_TIMEOUT, accept).await {
+                    Ok(Ok(stream)) => {
+                        let _ = tx.send(Ok(stream)).await;
+                    }
+                    Ok(Err(e)) => log::warn!("[TLS] gRPC handshake with {peer} failed: {e}"),
+                    Err(_) => log::warn!("[TLS] gRPC handshake with {peer} timed out"),
+                }
+            });
+        }
+    });
+    Ok(ReceiverStream::new(rx))
+}
+
+/// Reloads the certificates whose files changed. A certificate failing to
+/// load is reported and the current one is kept.
+pub async fn run_reloader() {
+    let cfg = get_config();
+    if cfg.tls.reload_interval == 0 || !(cfg.tls.http_enabled || cfg.tls.grpc_enabled) {
+        return;
+    }
+    let http_files = [&cfg.tls.http_cert_file, &cfg.tls.http_key_file];
+    let grpc_files = [
+        &cfg.tls.grpc_cert_file,
+        &cfg.tls.grpc_key_file,
+        &cfg.tls.ca_file,
+    ];
+    let files = http_files
+        .iter()
+        .chain(grpc_files.iter())
+        .copied()
+        .collect::<Vec<_>>();
+    let mut modified = modified_times(&files);
+    let mut interval = tokio::time::interval(Duration::from_secs(cfg.tls.reload_interval));
+    interval.tick().await;
+    loop {
+        interval.tick().await;
+        let current = modified_times(&files);
+        let changed = |files: &[&String]| files.iter().any(|f| current.get(*f) != modified.get(*f));
+        if cfg.tls.http_enabled && changed(&http_files) {
+            match reload_http() {
+                Ok(()) => log::info!("[TLS] http certificate reloaded"),
+                Err(e) => log::error!("[TLS] reload http certificate error: {e}"),
+            }
+        }
+        if cfg.tls.grpc_enabled && changed(&grpc_files) {
+            match reload_grpc() {
+                Ok(()) => log::info!("[TLS] gRPC certificates reloaded"),
+                Err(e) => log::error!("[TLS] reload gRPC certificates error: {e}"),
+            }
+        }
+        modified = current;
+    }
+}
+
+fn modified_times(files: &[&String]) -> HashMap<String, SystemTime> {
+    files
+        .iter()
+        .filter_map(|f| {
+            let time = fs::metadata(f).and_then(|m| m.modified()).ok()?;
+            Some((f.to_string(), time))
+        })
+        .collect()
+}
+
+fn reload_http() -> Result<()> {
+    let cfg = get_config();
+    let key = load_certified_key(&cfg.tls.http_cert_file, &cfg.tls.http_key_file)?;
+    match HTTP_CERT.get() {
+        Some(resolver) => resolver.0.store(Arc::new(key)),
+        None => {
+            let _ = HTTP_CERT.set(Arc::new(CertResolver(ArcSwap::from_pointee(key))));
+        }
+    }
+    Ok(())
+}
+
+fn reload_grpc() -> Result<()> {
+    let server = grpc_server_config()?;
+    let client = grpc_client_config()?;
+    GRPC_SERVER_CONFIG.store(Some(Arc::new(server)));
+    GRPC_CLIENT_CONFIG.store(Some(Arc::new(client)));
+    Ok(())
+}
+
+fn grpc_server_config() -> Result<ServerConfig> {
+    let cfg = get_config();
+    let (provider, versions) = policy()?;
+    let provider = Arc::new(provider);
+    let roots = Arc::new(load_roots(&cfg.tls.ca_file)?);
+    let verifier = WebPkiClientVerifier::builder_with_provider(roots, provider.clone())
+        .allow_unauthenticated()
+        .build()?;
+    let mut config = ServerConfig::builder_with_provider(provider)
+        .with_protocol_versions(versions)?
+        .with_client_cert_verifier(verifier)
+        .with_single_cert(
+            load_certs(&cfg.tls.grpc_cert_file)?,
+            load_key(&cfg.tls.grpc_key_file)?,
+        )?;
+    config.alpn_protocols = vec![b"h2".to_vec()];
+    Ok(config)
+}
+
+fn grpc_client_config() -> Result<ClientConfig> {
+    let cfg = get_config();
+    let mut config = cluster_client_builder()?.with_client_auth_cert(
+        load_certs(&cfg.tls.grpc_cert_file)?,
+        load_key(&cfg.tls.grpc_key_file)?,
+    )?;
+    config.alpn_protocols = vec![b"h2".to_vec()];
+    Ok(config)
+}
+
+/// Client config verifying the certificates of the other nodes against the
+/// cluster CA, with the TLS policy of the node
+fn cluster_client_builder() -> Result<ConfigBuilder<ClientConfig, WantsClientCert>> {
+    let cfg = get_config();
+    let (provider, versions) = policy()?;
+    let provider = Arc::new(provider);
+    let roots = Arc::new(load_roots(&cfg.tls.ca_file)?);
+    let builder =
+        ClientConfig::builder_with_provider(provider.clone()).with_protocol_versions(versions)?;
+    if cfg.tls.domain_name.is_empty() {
+        return Ok(builder.with_root_certificates(roots));
+    }
+    let verifier = ClusterCertVerifier {
+        inner: WebPkiServerVerifier::builder_with_provider(roots, provider).build()?,
+        domain_name: ServerName::try_from(cfg.tls.domain_name.clone())?,
+    };
+    Ok(builder
+        .dangerous()
+        .with_custom_certificate_verifier(Arc::new(verifier)))
+}
+
+fn policy() -> Result<(CryptoProvider, &'static [&'static SupportedProtocolVersion])> {
+    let cfg = get_config();
+    let versions = protocol_versions(&cfg.tls.min_version)?;
+    let provider = crypto_provider(&cfg.tls.cipher_suites, cfg.tls.fips_mode, versions)?;
+    Ok((provider, versions))
+}
+
+fn protocol_versions(min_version: &str) -> Result<&'static [&'static SupportedProtocolVersion]> {
+    match min_version {
+        "1.2" => Ok(rustls::ALL_VERSIONS),
+        "1.3" => Ok(TLS13_ONLY),
+        _ => Err(anyhow!("unsupported minimum TLS version {min_version}")),
+    }
+}
+
+/// Restricts the cipher suites and key exchange groups of the provider to the
+/// configured ones.
+fn crypto_provider(
+    cipher_suites: &str,
+    fips_mode: bool,
+    versions: &[&SupportedProtocolVersion],
+) -> Result<CryptoProvider> {
+    let mut provider = ring::default_provider();
+    if fips_mode {
+        provider
+            .cipher_suites
+            .retain(|s| FIPS_CIPHER_SUITES.contains(&s.suite()));
+        provider
+            .kx_groups
+            .retain(|g| FIPS_KX_GROUPS.contains(&g.name()));
+    }
+
+    let names = cipher_suites
+        .split(',')
+        .map(|s| s.trim())
+        .filter(|s| !s.is_empty())
+        .collect::<Vec<_>>();
+    if let Some(name) = names.iter().find(|name| {
+        !provider
+            .cipher_suites
+            .iter()
+            .any(|s| s.suite().as_str() == Some(**name))
+    }) {
+        return Err(anyhow!(
+            "cipher suite {name} is not supported{}",
+            if fips_mode { " in FIPS mode" } else { "" }
+        ));
+    }
+    if !names.is_empty() {
+        provider
+            .cipher_suites
+            .retain(|s| s.suite().as_str().is_some_and(|n| names.contains(&n)));
+    }
+
+    if !provider
+        .cipher_suites
+        .iter()
+        .any(|s| versions.iter().any(|v| v.version == s.version().version))
+    {
+        return Err(anyhow!(
+            "none of the allowed cipher suites can be used with the allowed TLS versions"
+        ));
+    }
+    Ok(provider)
+}
+
+fn load_certs(path: &str) -> Result<Vec<CertificateDer<'static>>> {
+    let pem = fs::read(path).with_context(|| format!("read {path}"))?;
+    let certs = rustls_pemfile::certs(&mut pem.as_slice()).collect::<Result<Vec<_>, _>>()?;
+    if certs.is_empty() {
+        return Err(anyhow!("no certificate found in {path}"));
+    }
+    Ok(certs)
+}
+
+fn load_key(path: &str) -> Result<PrivateKeyDer<'static>> {
+    let pem = fs::read(path).with_context(|| format!("read {path}"))?;
+    rustls_pemfile::private_key(&mut pem.as_slice())?
+        .ok_or_else(|| anyhow!("no private key found in {path}"))
+}
+
+fn load_certified_key(cert_file: &str, key_file: &str) -> Result<CertifiedKey> {
+    let key = ring::sign::any_supported_type(&load_key(key_file)?)?;
+    Ok(CertifiedKey::new(load_certs(cert_file)?, key))
+}
+
+fn load_roots(path: &str) -> Result<RootCertStore> {
+    let mut roots = RootCertStore::empty();
+    for cert in load_certs(path)? {
+        roots.add(cert)?;
+    }
+    Ok(roots)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_protocol_versions() {
+        assert_eq!(protocol_versions("1.2").unwrap().len(), 2);
+        assert_eq!(protocol_versions("1.3").unwrap().len(), 1);
+        assert!(protocol_versions("1.1").is_err());
+    }
+
+    #[test]
+    fn test_crypto_provider() {
+        let all = rustls::ALL_VERSIONS;
+        let default_suites = ring::default_provider().cipher_suites.len();
+        let provider = crypto_provider("", false, all).unwrap();
+        assert_eq!(provider.cipher_suites.len(), default_suites);
+
+        let provider = crypto_provider("", true, all).unwrap();
+        assert!(provider
+            .cipher_suites
+            .iter()
+            .all(|s| FIPS_CIPHER_SUITES.contains(&s.suite())));
+        assert!(provider
+            .kx_groups
+            .iter()
+            .all(|g| g.name() != NamedGroup::X25519));
+
+        let provider = crypto_provider(
+            "TLS13_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+            false,
+            all,
+        )
+        .unwrap();
+        assert_eq!(provider.cipher_suites.len(), 2);
+
+        assert!(crypto_provider("TLS13_CHACHA20_POLY1305_SHA256", true, all).is_err());
+        assert!(crypto_provider("NOT_A_SUITE", false, all).is_err());
+        assert!(
+            crypto_provider("TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", false, TLS13_ONLY).is_err()
+        );
+    }
+}
@@ -20,6 +20,7 @@ use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 
 use super::templates::Template;
+use crate::common::meta::plugins::PluginTarget;
 
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
 pub struct Destination {
@@ -42,6 +43,88 @@ pub struct Destination {
     #[serde(rename = "type")]
     #[serde(default)]
     pub destination_type: DestinationType,
+    /// Required when `destination_type` is `Plugin`
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub plugin: Option<PluginTarget>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rate_limit: Option<RateLimit>,
+    /// Windows in which no notification is sent
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub quiet_hours: Vec<QuietHours>,
+    /// What happens to the notifications over the rate limit or in quiet hours
+    #[serde(default)]
+    pub overflow: OverflowAction,
+    /// Destination notified instead when `overflow` is `fallback`, it is not
+    /// limited itself
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub fallback_destination: String,
+    /// Minutes between two digests when `overflow` is `digest`
+    #[serde(default = "default_digest_interval")]
+    pub digest_interval: i64,
+}
+
+fn default_digest_interval() -> i64 {
+    60
+}
+
+/// At most `max_notifications` notifications every `interval` minutes
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct RateLimit {
+    pub max_notifications: u32,
+    pub interval: i64,
+}
+
+/// Daily window, `start` and `end` are `HH:MM` and the window can cross
+/// midnight, e.g. `22:00` to `07:00`
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct QuietHours {
+    pub start: String,
+    pub end: String,
+    /// Days the window starts on, `mon` to `sun`, every day when empty
+    #[serde(default)]
+    pub days: Vec<String>,
+    /// Timezone offset in minutes
+    #[serde(default)]
+    pub tz_offset: i32,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum OverflowAction {
+    #[default]
+    Drop,
+    /// Sends a summary of the held notifications every digest interval, once
+    /// the quiet hours are over
+    Digest,
+    /// Sends the notification to the fallback destination
+    Fallback,
+}
+
+/// Notifications held for the digest of a destination, by alert
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct DigestEntry {
+    pub alert_name: String,
+    pub stream_type: String,
+    pub stream_name: String,
+    pub count: u64,
+    /// unix timestamp in microseconds
+    pub first_at: i64,
+    /// unix timestamp in microseconds
+    pub last_at: i64,
+}
+
+/// Notifications sent within the rate limit interval and the digest pending
+/// for a destination
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct ThrottleState {
+    /// unix timestamps in microseconds
+    #[serde(default)]
+    pub sent: Vec<i64>,
+    #[serde(default)]
+    pub digest: Vec<DigestEntry>,
+    /// unix timestamp in microseconds of the first entry of the digest
+    #[serde(default)]
+    pub digest_started_at: i64,
 }
 
 #[derive(Serialize, Debug, Default, PartialEq, Eq, Deserialize, Clone, ToSchema)]
@@ -51,6 +134,9 @@ pub enum DestinationType {
     Http,
     #[serde(rename = "email")]
     Email,
+    /// Destination plugin, see `service::plugins`
+    #[serde(rename = "plugin")]
+    Plugin,
 }
 
 impl Destination {
@@ -64,6 +150,12 @@ impl Destination {
             template,
             emails: self.emails.clone(),
             destination_type: self.destination_type.clone(),
+            plugin: self.plugin.clone(),
+            rate_limit: self.rate_limit.clone(),
+            quiet_hours: self.quiet_hours.clone(),
+            overflow: self.overflow.clone(),
+            fallback_destination: self.fallback_destination.clone(),
+            digest_interval: self.digest_interval,
         }
     }
 }
@@ -80,6 +172,18 @@ pub struct DestinationWithTemplate {
     pub template: Template,
     pub emails: Vec<String>,
     pub destination_type: DestinationType,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub plugin: Option<PluginTarget>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rate_limit: Option<RateLimit>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub quiet_hours: Vec<QuietHours>,
+    #[serde(default)]
+    pub overflow: OverflowAction,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub fallback_destination: String,
+    #[serde(default = "default_digest_interval")]
+    pub digest_interval: i64,
 }
 
 #[derive(Clone, Debug, Default, Eq, PartialEq, Serialize, Deserialize, ToSchema)]
@@ -21,6 +21,10 @@ use utoipa::ToSchema;
 pub mod destinations;
 pub mod templates;
 
+/// Logs stream of each organization holding the state transitions of its
+/// alerts
+pub const ALERT_HISTORY_STREAM: &str = "alert_history";
+
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
 pub struct Alert {
     #[serde(default)]
@@ -52,6 +56,139 @@ pub struct Alert {
     pub tz_offset: i32,
 }
 
+/// A recent firing of an alert, kept to correlate alerts firing together
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct AlertFiring {
+    pub alert_name: String,
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    /// services the alerting data came from
+    #[serde(default)]
+    pub services: Vec<String>,
+    /// unix timestamp in microseconds
+    pub fired_at: i64,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub hint: Option<RootCauseHint>,
+}
+
+impl AlertFiring {
+    pub fn is_same_alert(&self, other: &AlertFiring) -> bool {
+        self.alert_name == other.alert_name
+            && self.stream_type == other.stream_type
+            && self.stream_name == other.stream_name
+    }
+}
+
+/// Likely cause of an alert, found from the alerts firing with it and the
+/// dependencies between their services
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct RootCauseHint {
+    /// alert of an upstream service which most likely caused this one
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub upstream_cause: Option<String>,
+    /// alerts of the services depending on the alerting service
+    #[serde(default)]
+    pub downstream: Vec<String>,
+    /// every alert grouped with this one
+    #[serde(default)]
+    pub related: Vec<String>,
+    pub message: String,
+}
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum AlertStatus {
+    #[default]
+    Resolved,
+    /// The condition is met but no destination was notified, e.g. they were
+    /// rate limited or failed
+    Pending,
+    Firing,
+}
+
+impl std::fmt::Display for AlertStatus {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            AlertStatus::Resolved => write!(f, "resolved"),
+            AlertStatus::Pending => write!(f, "pending"),
+            AlertStatus::Firing => write!(f, "firing"),
+        }
+    }
+}
+
+/// Current status of an alert, the transitions are written to the
+/// [ALERT_HISTORY_STREAM]
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct AlertState {
+    pub status: AlertStatus,
+    /// unix timestamp in microseconds the current incident started, when the
+    /// alert left the resolved status
+    #[serde(default)]
+    pub started_at: i64,
+    /// unix timestamp in microseconds of the last transition
+    #[serde(default)]
+    pub changed_at: i64,
+}
+
+/// Firings and resolutions of one alert over a time range
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct AlertHistoryStat {
+    pub alert_name: String,
+    pub stream_type: String,
+    pub stream_name: String,
+    pub fire_count: i64,
+    pub resolved_count: i64,
+    /// seconds, of the resolved incidents
+    pub mean_time_to_resolve: f64,
+    /// seconds, of the resolved incidents
+    pub max_time_to_resolve: f64,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct AlertHistoryStats {
+    pub fire_count: i64,
+    pub resolved_count: i64,
+    /// seconds, of all the resolved incidents
+    pub mean_time_to_resolve: f64,
+    /// alerts by fire count, the noisiest first
+    pub alerts: Vec<AlertHistoryStat>,
+}
+
+/// Evaluations of a scheduled alert, report or pipeline, to debug the missed
+/// ones
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct TriggerRunStats {
+    pub runs: i64,
+    pub failures: i64,
+    /// evaluations missed because they started more than an interval late
+    pub skipped: i64,
+    /// microseconds
+    pub last_run_at: i64,
+    /// microseconds, between the time the last evaluation was due and its start
+    pub last_delay: i64,
+    /// microseconds
+    pub max_delay: i64,
+    /// microseconds
+    pub last_duration: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_error: Option<String>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ScheduledTrigger {
+    /// alert, report or etl
+    pub module: String,
+    pub module_key: String,
+    /// microseconds
+    pub next_run_at: i64,
+    pub status: String,
+    pub retries: i32,
+    pub is_silenced: bool,
+    /// none until the first evaluation is accounted
+    pub stats: Option<TriggerRunStats>,
+}
+
 impl PartialEq for Alert {
     fn eq(&self, other: &Self) -> bool {
         self.name == other.name
@@ -82,6 +219,8 @@ impl Default for Alert {
 
 #[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
 pub struct TriggerCondition {
+    /// Minutes of data queried, the minutes without data before a series is
+    /// missing for deadman alerts
     pub period: i64, // 10 minutes
     #[serde(default)]
     pub operator: Operator, // >=
@@ -116,6 +255,228 @@ pub struct QueryCondition {
     pub promql: Option<String>,              // (cpu usage / cpu total)
     pub promql_condition: Option<Condition>, // value >= 80
     pub aggregation: Option<Aggregation>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deadman: Option<DeadmanCondition>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub quality: Option<QualityCondition>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub expected_set: Option<ExpectedSetCondition>,
+    /// VRL function applied to every row of the query result before the
+    /// threshold is evaluated, rows for which it aborts are dropped
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub vrl_function: Option<String>,
+    /// Sample records of the logs alerts sent in the notifications, with the
+    /// records logged around them
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub context: Option<LogContext>,
+}
+
+/// Fires when a series of the stream stops receiving data for the period of
+/// the trigger condition, and notifies again once it resumes
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct DeadmanCondition {
+    /// Fields telling the series apart, the whole stream is one series when
+    /// empty
+    #[serde(default)]
+    pub group_by: Vec<String>,
+    /// Minutes without data after which a series is forgotten, e.g. a host
+    /// which was decommissioned, 0 never forgets
+    #[serde(default = "default_forget_after")]
+    pub forget_after: i64,
+    /// Notify when a missing series receives data again
+    #[serde(default = "default_notify_resolved")]
+    pub notify_resolved: bool,
+}
+
+impl Default for DeadmanCondition {
+    fn default() -> Self {
+        Self {
+            group_by: vec![],
+            forget_after: default_forget_after(),
+            notify_resolved: default_notify_resolved(),
+        }
+    }
+}
+
+fn default_forget_after() -> i64 {
+    24 * 60 // 1 day
+}
+
+fn default_notify_resolved() -> bool {
+    true
+}
+
+/// Last data seen of the series of a deadman alert
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct DeadmanState {
+    /// unix timestamp in microseconds of the last evaluation
+    pub evaluated_at: i64,
+    /// series by the values of their group by fields joined with `/`
+    #[serde(default)]
+    pub series: HashMap<String, DeadmanSeries>,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct DeadmanSeries {
+    #[serde(default)]
+    pub labels: HashMap<String, Value>,
+    /// unix timestamp in microseconds
+    pub last_seen: i64,
+    #[serde(default)]
+    pub missing: bool,
+}
+
+/// Data quality monitor of the stream, fires while its check fails so broken
+/// pipelines are caught before the dashboards go blank
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct QualityCondition {
+    #[serde(default)]
+    pub check: QualityCheck,
+    /// Freshness: minutes since the last event after which the stream is
+    /// stale
+    #[serde(default = "default_max_age")]
+    pub max_age: i64,
+    /// Volume: days whose same window of the period makes the baseline, the
+    /// median of their counts
+    #[serde(default = "default_baseline_days")]
+    pub baseline_days: i64,
+    /// Volume: fires when the count of the period is below this percentage of
+    /// the baseline
+    #[serde(default = "default_min_percent")]
+    pub min_percent: f64,
+    /// Volume: fires when the count of the period is above this percentage of
+    /// the baseline, 0 disables it
+    #[serde(default)]
+    pub max_percent: f64,
+    /// Schema drift: only the removed fields and the type changes fire
+    #[serde(default)]
+    pub ignore_added: bool,
+}
+
+impl Default for QualityCondition {
+    fn default() -> Self {
+        Self {
+            check: QualityCheck::default(),
+            max_age: default_max_age(),
+            baseline_days: default_baseline_days(),
+            min_percent: default_min_percent(),
+            max_percent: 0.0,
+            ignore_added: false,
+        }
+    }
+}
+
+fn default_max_age() -> i64 {
+    60 // 1 hour
+}
+
+fn default_baseline_days() -> i64 {
+    7
+}
+
+fn default_min_percent() -> f64 {
+    50.0
+}
+
+/// Fires while members of a reference list, e.g. all the production hosts of
+/// an enrichment table, have no data in the period of the alert, with one row
+/// per missing member so `{<field>}` lists them in the notifications
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ExpectedSetCondition {
+    /// Field of the stream holding the members, e.g. `host`
+    pub field: String,
+    /// Members listed in the alert
+    #[serde(default)]
+    pub members: Vec<String>,
+    /// Enrichment table listing more members
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub enrichment_table: Option<String>,
+    /// Column of the enrichment table holding the members, the field when
+    /// empty
+    #[serde(default)]
+    pub column: String,
+    /// Only the rows of the enrichment table with these values, e.g.
+    /// `env: production`
+    #[serde(default)]
+    pub filters: HashMap<String, String>,
+}
+
+/// Matching records of a logs alert, with the records logged around them,
+/// rendered into the `{alert_context}` variable of the notification templates
+/// so responders see the actual error and its neighborhood
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct LogContext {
+    /// Matching records sent
+    #[serde(default = "default_context_samples")]
+    pub samples: usize,
+    /// Records sent before and after each of them
+    #[serde(default = "default_context_lines")]
+    pub lines: usize,
+    /// Seconds before and after a record its context is taken from
+    #[serde(default = "default_context_window")]
+    pub window: i64,
+    /// Fields the context records share with the matching one, e.g. the host
+    #[serde(default)]
+    pub same_fields: Vec<String>,
+    /// Fields rendered for each record, the whole record when empty
+    #[serde(default)]
+    pub fields: Vec<String>,
+}
+
+impl Default for LogContext {
+    fn default() -> Self {
+        Self {
+            samples: default_context_samples(),
+            lines: default_context_lines(),
+            window: default_context_window(),
+            same_fields: vec![],
+            fields: vec![],
+        }
+    }
+}
+
+fn default_context_samples() -> usize {
+    3
+}
+
+fn default_context_lines() -> usize {
+    5
+}
+
+fn default_context_window() -> i64 {
+    30
+}
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum QualityCheck {
+    /// The age of the last event of the stream
+    #[default]
+    Freshness,
+    /// The count of events of the period compared to the same window of the
+    /// previous days
+    Volume,
+    /// The fields added to or removed from the schema, and their type changes
+    SchemaDrift,
+}
+
+impl std::fmt::Display for QualityCheck {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            QualityCheck::Freshness => write!(f, "freshness"),
+            QualityCheck::Volume => write!(f, "volume"),
+            QualityCheck::SchemaDrift => write!(f, "schema_drift"),
+        }
+    }
+}
+
+/// Fields of the stream by their type when a schema drift monitor last ran
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize)]
+pub struct QualityState {
+    /// unix timestamp in microseconds of the last evaluation
+    pub evaluated_at: i64,
+    #[serde(default)]
+    pub fields: HashMap<String, String>,
 }
 
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
@@ -194,6 +555,12 @@ pub enum QueryType {
     SQL,
     #[serde(rename = "promql")]
     PromQL,
+    #[serde(rename = "deadman")]
+    Deadman,
+    #[serde(rename = "quality")]
+    Quality,
+    #[serde(rename = "expected_set")]
+    ExpectedSet,
 }
 
 impl std::fmt::Display for QueryType {
@@ -202,6 +569,9 @@ impl std::fmt::Display for QueryType {
             QueryType::Custom => write!(f, "custom"),
             QueryType::SQL => write!(f, "sql"),
             QueryType::PromQL => write!(f, "promql"),
+            QueryType::Deadman => write!(f, "deadman"),
+            QueryType::Quality => write!(f, "quality"),
+            QueryType::ExpectedSet => write!(f, "expected_set"),
         }
     }
 }
@@ -212,6 +582,9 @@ impl From<&str> for QueryType {
             "custom" => QueryType::Custom,
             "sql" => QueryType::SQL,
             "promql" => QueryType::PromQL,
+            "deadman" => QueryType::Deadman,
+            "quality" => QueryType::Quality,
+            "expected_set" => QueryType::ExpectedSet,
             _ => QueryType::Custom,
         }
     }
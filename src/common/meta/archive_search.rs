@@ -0,0 +1,117 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ArchiveSearchRequest {
+    pub sql: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Start of the time range in microseconds
+    pub start_time: i64,
+    /// End of the time range in microseconds
+    pub end_time: i64,
+    #[serde(default = "default_size")]
+    pub size: i64,
+    /// Alert destination notified when the results are ready
+    #[serde(default)]
+    pub destination: String,
+}
+
+fn default_size() -> i64 {
+    100
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum ArchiveSearchStatus {
+    /// Looking for the archived objects of the time range
+    #[default]
+    Pending,
+    /// Waiting for the archived objects to be restored
+    Restoring,
+    /// Searching the restored objects
+    Running,
+    Completed,
+    Failed,
+}
+
+impl std::fmt::Display for ArchiveSearchStatus {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            ArchiveSearchStatus::Pending => write!(f, "pending"),
+            ArchiveSearchStatus::Restoring => write!(f, "restoring"),
+            ArchiveSearchStatus::Running => write!(f, "running"),
+            ArchiveSearchStatus::Completed => write!(f, "completed"),
+            ArchiveSearchStatus::Failed => write!(f, "failed"),
+        }
+    }
+}
+
+/// Search including objects of archival storage classes, restored before the
+/// query runs
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ArchiveSearchJob {
+    pub id: String,
+    pub org_id: String,
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    pub sql: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    pub size: i64,
+    #[serde(default)]
+    pub destination: String,
+    pub status: ArchiveSearchStatus,
+    /// Archived objects still being restored
+    #[serde(default)]
+    pub restoring_files: Vec<String>,
+    /// Archived objects already restored
+    #[serde(default)]
+    pub restored_files: usize,
+    #[serde(default)]
+    pub total: usize,
+    /// Hits of the query
+    #[serde(default)]
+    pub hits_count: usize,
+    /// Kept in the object storage, only returned with the job itself
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(value_type = Vec<Object>)]
+    pub hits: Vec<json::Value>,
+    #[serde(default)]
+    pub error: String,
+    #[serde(default)]
+    pub created_by: String,
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+impl ArchiveSearchJob {
+    pub fn is_done(&self) -> bool {
+        matches!(
+            self.status,
+            ArchiveSearchStatus::Completed | ArchiveSearchStatus::Failed
+        )
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ArchiveSearchJobList {
+    pub list: Vec<ArchiveSearchJob>,
+}
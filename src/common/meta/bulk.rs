@@ -0,0 +1,211 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::governance::GovernedKind;
+
+/// Objects changed, exported or imported in one call
+pub const MAX_BULK_ITEMS: usize = 500;
+
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkAction {
+    Enable,
+    Disable,
+    /// Replaces the tags of the objects
+    Tag,
+    /// Moves the dashboards to another folder
+    Move,
+}
+
+/// Change applied to all the objects or to none of them. The ids are the ones
+/// of the governance, `{folder}/{dashboard_id}` of a dashboard and
+/// `{stream_type}/{stream_name}/{name}` of an alert.
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct BulkRequest {
+    pub action: BulkAction,
+    pub kind: GovernedKind,
+    pub ids: Vec<String>,
+    /// New tags of the `tag` action
+    #[serde(default)]
+    pub tags: Vec<String>,
+    /// Destination folder of the `move` action
+    #[serde(default)]
+    pub folder: String,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct BulkExportRequest {
+    pub kind: GovernedKind,
+    pub ids: Vec<String>,
+}
+
+/// Object as exported, the data is the alert or the dashboard as stored
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct BulkObject {
+    pub kind: GovernedKind,
+    pub id: String,
+    #[schema(value_type = Object)]
+    pub data: json::Value,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct BulkImportRequest {
+    pub objects: Vec<BulkObject>,
+    /// Replaces the existing objects instead of failing
+    #[serde(default)]
+    pub overwrite: bool,
+}
+
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum BulkItemStatus {
+    Ok,
+    Failed,
+    /// Not applied because another object failed
+    Skipped,
+    /// Applied, then reverted because another object failed
+    RolledBack,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct BulkItemResult {
+    pub id: String,
+    pub status: BulkItemStatus,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Result of each object, the changes are committed only when all of them
+/// succeeded
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct BulkResponse {
+    pub committed: bool,
+    pub items: Vec<BulkItemResult>,
+    /// Exported objects, importable as they are
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub objects: Vec<BulkObject>,
+}
+
+impl BulkResponse {
+    /// Report of a change committed for all the ids
+    pub fn committed(ids: &[String]) -> Self {
+        Self {
+            committed: true,
+            items: ids
+                .iter()
+                .map(|id| BulkItemResult {
+                    id: id.clone(),
+                    status: BulkItemStatus::Ok,
+                    error: None,
+                })
+                .collect(),
+            objects: vec![],
+        }
+    }
+
+    /// Report of a change aborted because of the errors of some ids, the
+    /// `applied` ones were rolled back and the others skipped
+    pub fn aborted(ids: &[String], errors: &[(usize, String)], applied: usize) -> Self {
+        let items = ids
+            .iter()
+            .enumerate()
+            .map(|(i, id)| match errors.iter().find(|(idx, _)| *idx == i) {
+                Some((_, e)) => BulkItemResult {
+                    id: id.clone(),
+                    status: BulkItemStatus::Failed,
+                    error: Some(e.clone()),
+                },
+                None => BulkItemResult {
+                    id: id.clone(),
+                    status: if i < applied {
+                        BulkItemStatus::RolledBack
+                    } else {
+                        BulkItemStatus::Skipped
+                    },
+                    error: None,
+                },
+            })
+            .collect();
+        Self {
+            committed: false,
+            items,
+            objects: vec![],
+        }
+    }
+}
+
+/// Checks the number of ids and that none is given twice
+pub fn validate_ids(ids: &[String]) -> Result<(), String> {
+    if ids.is_empty() {
+        return Err("No object given".to_string());
+    }
+    if ids.len() > MAX_BULK_ITEMS {
+        return Err(format!(
+            "At most {MAX_BULK_ITEMS} objects can be changed in one call"
+        ));
+    }
+    for (i, id) in ids.iter().enumerate() {
+        if ids[..i].contains(id) {
+            return Err(format!("{id} is given twice"));
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ids() -> Vec<String> {
+        vec!["a".to_string(), "b".to_string(), "c".to_string()]
+    }
+
+    #[test]
+    fn test_aborted() {
+        let resp = BulkResponse::aborted(&ids(), &[(1, "boom".to_string())], 1);
+        assert!(!resp.committed);
+        let status = resp.items.iter().map(|i| i.status).collect::<Vec<_>>();
+        assert_eq!(
+            status,
+            vec![
+                BulkItemStatus::RolledBack,
+                BulkItemStatus::Failed,
+                BulkItemStatus::Skipped
+            ]
+        );
+        assert_eq!(resp.items[1].error.as_deref(), Some("boom"));
+        assert!(BulkResponse::committed(&ids())
+            .items
+            .iter()
+            .all(|i| i.status == BulkItemStatus::Ok));
+    }
+
+    #[test]
+    fn test_validate_ids() {
+        assert!(validate_ids(&ids()).is_ok());
+        assert!(validate_ids(&[]).is_err());
+        assert!(validate_ids(&["a".to_string(), "a".to_string()]).is_err());
+        let many = (0..=MAX_BULK_ITEMS)
+            .map(|i| i.to_string())
+            .collect::<Vec<_>>();
+        assert!(validate_ids(&many).is_err());
+    }
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{fmt, str::FromStr};
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Maximum length of the text of a comment, in bytes
+pub const MAX_COMMENT_LEN: usize = 10 * 1024;
+
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum CommentTarget {
+    SavedQueries,
+    Dashboards,
+}
+
+impl fmt::Display for CommentTarget {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            CommentTarget::SavedQueries => write!(f, "saved_queries"),
+            CommentTarget::Dashboards => write!(f, "dashboards"),
+        }
+    }
+}
+
+impl FromStr for CommentTarget {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "saved_queries" => Ok(CommentTarget::SavedQueries),
+            "dashboards" => Ok(CommentTarget::Dashboards),
+            _ => Err(format!("Comments are not supported on {s}")),
+        }
+    }
+}
+
+/// Comment or annotation left on a saved query or a dashboard
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct Comment {
+    pub id: String,
+    pub target: CommentTarget,
+    /// Name of the saved query or id of the dashboard
+    pub target_id: String,
+    pub text: String,
+    /// Panel of the dashboard the comment is about
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub panel_id: Option<String>,
+    /// Point in time annotated by the comment, unix timestamp in microseconds
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub timestamp: Option<i64>,
+    pub author: String,
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct CommentRequest {
+    pub text: String,
+    #[serde(default)]
+    pub panel_id: Option<String>,
+    #[serde(default)]
+    pub timestamp: Option<i64>,
+}
+
+impl CommentRequest {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.text.trim().is_empty() {
+            return Err("Comment text is required".to_string());
+        }
+        if self.text.len() > MAX_COMMENT_LEN {
+            return Err(format!(
+                "Comment text should be at most {MAX_COMMENT_LEN} bytes"
+            ));
+        }
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct CommentList {
+    pub list: Vec<Comment>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_target() {
+        for target in [CommentTarget::SavedQueries, CommentTarget::Dashboards] {
+            assert_eq!(target.to_string().parse::<CommentTarget>(), Ok(target));
+        }
+        assert!("alerts".parse::<CommentTarget>().is_err());
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut req = CommentRequest {
+            text: "spike caused by the deploy of 14:02".to_string(),
+            ..Default::default()
+        };
+        assert!(req.validate().is_ok());
+        req.text = " ".to_string();
+        assert!(req.validate().is_err());
+        req.text = "a".repeat(MAX_COMMENT_LEN + 1);
+        assert!(req.validate().is_err());
+    }
+}
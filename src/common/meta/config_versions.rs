@@ -0,0 +1,33 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// A saved definition of a function or pipeline, kept to roll back to
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ConfigVersion {
+    pub version: u64,
+    /// unix timestamp in microseconds
+    pub created_at: i64,
+    #[schema(value_type = Object)]
+    pub definition: json::Value,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ConfigVersionList {
+    pub list: Vec<ConfigVersion>,
+}
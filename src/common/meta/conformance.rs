@@ -0,0 +1,147 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Run of the built-in OTLP conformance corpus through the OTLP endpoints of
+/// this node
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ConformanceRequest {
+    /// Stream receiving the logs and the traces, the metrics go to streams
+    /// named after them, prefixed with `otlp_conformance_`
+    #[serde(default = "default_stream")]
+    pub stream: String,
+    /// Runs only the cases with names containing it
+    #[serde(default)]
+    pub filter: Option<String>,
+    /// Transports to run the cases through, all of them when empty
+    #[serde(default)]
+    pub transports: Vec<Transport>,
+}
+
+impl Default for ConformanceRequest {
+    fn default() -> Self {
+        Self {
+            stream: default_stream(),
+            filter: None,
+            transports: vec![],
+        }
+    }
+}
+
+impl ConformanceRequest {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.stream.trim().is_empty() {
+            return Err("Stream name is required".to_string());
+        }
+        Ok(())
+    }
+
+    /// Returns the transports to run, all of them when none is given
+    pub fn transports(&self) -> Vec<Transport> {
+        if self.transports.is_empty() {
+            Transport::ALL.to_vec()
+        } else {
+            self.transports.clone()
+        }
+    }
+}
+
+/// Encoding and protocol of the OTLP requests
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Transport {
+    HttpProtobuf,
+    HttpJson,
+    Grpc,
+}
+
+impl Transport {
+    pub const ALL: [Transport; 3] = [
+        Transport::HttpProtobuf,
+        Transport::HttpJson,
+        Transport::Grpc,
+    ];
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct CaseResult {
+    pub case: String,
+    /// logs, traces or metrics
+    pub signal: String,
+    pub transport: Transport,
+    pub passed: bool,
+    /// Milliseconds taken by the ingestion
+    pub took: u64,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ConformanceReport {
+    pub passed: usize,
+    pub failed: usize,
+    pub results: Vec<CaseResult>,
+}
+
+impl ConformanceReport {
+    pub fn new(results: Vec<CaseResult>) -> Self {
+        let passed = results.iter().filter(|r| r.passed).count();
+        Self {
+            passed,
+            failed: results.len() - passed,
+            results,
+        }
+    }
+}
+
+fn default_stream() -> String {
+    "otlp_conformance".to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_conformance_request() {
+        let req: ConformanceRequest = config::utils::json::from_str("{}").unwrap();
+        assert_eq!(req.stream, "otlp_conformance");
+        assert_eq!(req.transports(), Transport::ALL.to_vec());
+        assert!(req.validate().is_ok());
+
+        let req: ConformanceRequest =
+            config::utils::json::from_str(r#"{"stream": " ", "transports": ["http_json"]}"#)
+                .unwrap();
+        assert_eq!(req.transports(), vec![Transport::HttpJson]);
+        assert!(req.validate().is_err());
+    }
+
+    #[test]
+    fn test_conformance_report() {
+        let result = |passed| CaseResult {
+            case: "logs_minimal".to_string(),
+            signal: "logs".to_string(),
+            transport: Transport::Grpc,
+            passed,
+            took: 1,
+            error: (!passed).then(|| "rejected".to_string()),
+        };
+        let report = ConformanceReport::new(vec![result(true), result(false), result(true)]);
+        assert_eq!(report.passed, 2);
+        assert_eq!(report.failed, 1);
+    }
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// How much of a Grafana panel or variable survived the import
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ImportStatus {
+    #[default]
+    Converted,
+    /// imported, but some settings were dropped, see the notes
+    Partial,
+    /// left out of the imported dashboard
+    Unsupported,
+}
+
+/// Compatibility of one Grafana panel or template variable
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ImportItem {
+    pub name: String,
+    /// panel or variable type in Grafana
+    pub grafana_type: String,
+    /// panel or variable type it was imported as
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub imported_type: Option<String>,
+    pub status: ImportStatus,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub notes: Vec<String>,
+}
+
+impl ImportItem {
+    pub fn note(&mut self, note: impl Into<String>) {
+        self.notes.push(note.into());
+        if self.status == ImportStatus::Converted {
+            self.status = ImportStatus::Partial;
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct GrafanaImportReport {
+    pub converted: usize,
+    pub partial: usize,
+    pub unsupported: usize,
+    pub panels: Vec<ImportItem>,
+    pub variables: Vec<ImportItem>,
+    /// settings of the dashboard itself which were dropped
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub notes: Vec<String>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct GrafanaImport {
+    pub title: String,
+    /// empty on a dry run
+    #[serde(default)]
+    pub dashboard_id: String,
+    /// the converted dashboard, in the latest version
+    #[schema(value_type = Object)]
+    pub dashboard: json::Value,
+    pub report: GrafanaImportReport,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct GrafanaImportList {
+    pub list: Vec<GrafanaImport>,
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use chrono::{DateTime, FixedOffset};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::{datetime_now, v4};
+
+/// A panel defined once and shown by many dashboards. The dashboard panels
+/// reference it with their `libraryPanelId`.
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct LibraryPanel {
+    #[serde(default)]
+    pub panel_id: String,
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    /// its id and layout are ignored, they are the ones of each dashboard
+    pub panel: v4::Panel,
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default = "datetime_now")]
+    #[schema(value_type = String, format = DateTime)]
+    pub created: DateTime<FixedOffset>,
+    #[serde(default = "datetime_now")]
+    #[schema(value_type = String, format = DateTime)]
+    pub updated: DateTime<FixedOffset>,
+    /// number of dashboard panels referencing it, set when listed
+    #[serde(default)]
+    pub usage_count: usize,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct LibraryPanelList {
+    pub list: Vec<LibraryPanel>,
+}
+
+/// The panels of a dashboard referencing a library panel
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct LibraryPanelUsage {
+    pub dashboard_id: String,
+    pub folder_id: String,
+    pub title: String,
+    pub panel_ids: Vec<String>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct LibraryPanelUsageList {
+    pub list: Vec<LibraryPanelUsage>,
+}
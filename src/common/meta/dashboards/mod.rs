@@ -41,19 +41,66 @@ pub struct Dashboards {
     pub dashboards: Vec<Dashboard>,
 }
 
+pub mod grafana;
+pub mod library;
 pub mod reports;
 pub mod v1;
 pub mod v2;
 pub mod v3;
 pub mod v4;
 
-#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, ToSchema)]
 #[serde(rename_all = "camelCase")]
 pub struct Folder {
     #[serde(default)]
     pub folder_id: String,
     pub name: String,
     pub description: String,
+    /// Folder containing this one, a top level folder when empty
+    #[serde(default)]
+    pub parent_id: String,
+    /// Who can view or edit the folder, its subfolders and their dashboards,
+    /// saved queries and reports. The permissions of the parent apply when
+    /// empty, and every member of the organization can edit a top level
+    /// folder without permissions.
+    #[serde(default)]
+    pub permissions: Vec<FolderPermission>,
+}
+
+#[derive(
+    Clone, Copy, Debug, Default, PartialEq, Eq, PartialOrd, Ord, Serialize, Deserialize, ToSchema,
+)]
+#[serde(rename_all = "snake_case")]
+pub enum FolderAccess {
+    #[default]
+    View,
+    /// Also allows to create, change and delete the objects of the folder
+    Edit,
+}
+
+impl std::fmt::Display for FolderAccess {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            FolderAccess::View => write!(f, "view"),
+            FolderAccess::Edit => write!(f, "edit"),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct FolderPermission {
+    /// Email of the user
+    pub user: String,
+    #[serde(default)]
+    pub access: FolderAccess,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "camelCase")]
+pub struct MoveFolder {
+    /// New parent folder, empty to move the folder to the top level
+    #[serde(default)]
+    pub parent_id: String,
 }
 
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
@@ -69,6 +116,8 @@ pub struct FolderList {
 }
 
 pub const DEFAULT_FOLDER: &str = "default";
+/// Levels of folders below the top level ones
+pub const MAX_FOLDER_DEPTH: usize = 8;
 
 pub fn datetime_now() -> DateTime<FixedOffset> {
     Utc::now().with_timezone(&FixedOffset::east_opt(0).expect(
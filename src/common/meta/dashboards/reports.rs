@@ -168,6 +168,10 @@ pub struct Report {
     pub updated_at: Option<DateTime<FixedOffset>>,
     pub owner: String,
     pub last_edited_by: String,
+    /// Folder the report is filed in, its permissions also apply to the report
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub folder_id: String,
 }
 
 impl Default for Report {
@@ -193,6 +197,7 @@ impl Default for Report {
             updated_at: None,
             owner: "".to_string(),
             last_edited_by: "".to_string(),
+            folder_id: "".to_string(),
         }
     }
 }
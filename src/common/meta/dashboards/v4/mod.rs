@@ -79,6 +79,10 @@ pub struct Panel {
     pub html_content: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub markdown_content: Option<String>,
+    /// The library panel this panel shows, its definition is replaced with the
+    /// one of the library when the dashboard is read
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub library_panel_id: Option<String>,
 }
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
 #[serde(rename_all = "camelCase")]
@@ -190,6 +194,49 @@ pub struct PanelConfig {
     no_value_replacement: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     wrap_table_cells: Option<bool>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    table_rules: Option<Vec<TableRule>>,
+}
+
+/// Conditional formatting of a table panel, the first matching rule of a cell
+/// or row applies
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct TableRule {
+    /// column whose value is tested
+    pub column: String,
+    pub operator: TableRuleOperator,
+    pub value: String,
+    #[serde(default)]
+    pub scope: TableRuleScope,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub background_color: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub text_color: Option<String>,
+    /// link of the cell, templated with `${row.field.name}`
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub link: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum TableRuleOperator {
+    Gt,
+    Gte,
+    Lt,
+    Lte,
+    Eq,
+    Ne,
+    Contains,
+}
+
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum TableRuleScope {
+    /// formats the tested cell
+    #[default]
+    Cell,
+    /// formats the whole row
+    Row,
 }
 
 #[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
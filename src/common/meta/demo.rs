@@ -0,0 +1,160 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Batches of traces generated in one seeding call
+pub const MAX_SEED_BATCHES: usize = 100;
+/// Traces generated in one batch
+pub const MAX_SEED_BATCH_SIZE: usize = 1_000;
+
+/// Generated data ingested by the seeding of an organization, the traces are
+/// spread over the last `minutes` and each request served comes with its log
+/// and a point of the duration histogram
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SeedRequest {
+    /// Stream receiving the logs and the traces
+    #[serde(default = "default_stream")]
+    pub stream: String,
+    #[serde(default = "default_batches")]
+    pub batches: usize,
+    /// Traces of each batch
+    #[serde(default = "default_batch_size")]
+    pub batch_size: usize,
+    /// Minutes back the data starts, up to `ZO_INGEST_ALLOWED_UPTO`
+    #[serde(default = "default_minutes")]
+    pub minutes: i64,
+    /// Creates a dashboard over the stream
+    #[serde(default = "default_true")]
+    pub dashboard: bool,
+    /// Creates a disabled alert on the error logs of the stream, with its
+    /// template and destination
+    #[serde(default = "default_true")]
+    pub alert: bool,
+}
+
+impl Default for SeedRequest {
+    fn default() -> Self {
+        Self {
+            stream: default_stream(),
+            batches: default_batches(),
+            batch_size: default_batch_size(),
+            minutes: default_minutes(),
+            dashboard: true,
+            alert: true,
+        }
+    }
+}
+
+impl SeedRequest {
+    /// Checks the request against the limits, `max_minutes` is the oldest data
+    /// the ingestion accepts
+    pub fn validate(&self, max_minutes: i64) -> Result<(), String> {
+        if self.stream.trim().is_empty() {
+            return Err("Stream name is required".to_string());
+        }
+        if self.batches == 0 || self.batches > MAX_SEED_BATCHES {
+            return Err(format!("batches must be between 1 and {MAX_SEED_BATCHES}"));
+        }
+        if self.batch_size == 0 || self.batch_size > MAX_SEED_BATCH_SIZE {
+            return Err(format!(
+                "batch_size must be between 1 and {MAX_SEED_BATCH_SIZE}"
+            ));
+        }
+        if self.minutes < 1 || self.minutes > max_minutes {
+            return Err(format!("minutes must be between 1 and {max_minutes}"));
+        }
+        Ok(())
+    }
+}
+
+fn default_stream() -> String {
+    "demo".to_string()
+}
+
+fn default_batches() -> usize {
+    10
+}
+
+fn default_batch_size() -> usize {
+    100
+}
+
+fn default_minutes() -> i64 {
+    60
+}
+
+fn default_true() -> bool {
+    true
+}
+
+/// What the seeding ingested and created
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct SeedResponse {
+    pub stream: String,
+    pub spans: usize,
+    pub logs: usize,
+    /// Data points of the duration histograms
+    pub metrics: usize,
+    /// Metrics stream of the duration histograms
+    pub metric_name: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub dashboard_id: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub alert: Option<String>,
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    #[test]
+    fn test_seed_request_defaults() {
+        let req: SeedRequest = json::from_str("{}").unwrap();
+        assert_eq!(req.stream, "demo");
+        assert_eq!(req.batches, 10);
+        assert_eq!(req.batch_size, 100);
+        assert_eq!(req.minutes, 60);
+        assert!(req.dashboard && req.alert);
+        assert!(req.validate(300).is_ok());
+    }
+
+    #[test]
+    fn test_seed_request_validate() {
+        let req = SeedRequest {
+            minutes: 400,
+            ..Default::default()
+        };
+        assert!(req.validate(300).is_err());
+        let req = SeedRequest {
+            batches: MAX_SEED_BATCHES + 1,
+            ..Default::default()
+        };
+        assert!(req.validate(300).is_err());
+        let req = SeedRequest {
+            batch_size: 0,
+            ..Default::default()
+        };
+        assert!(req.validate(300).is_err());
+        let req = SeedRequest {
+            stream: " ".to_string(),
+            ..Default::default()
+        };
+        assert!(req.validate(300).is_err());
+    }
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::BTreeMap, fmt};
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Kind of an entity discovered from OTLP resource attributes
+#[derive(
+    Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq, Hash, PartialOrd, Ord,
+)]
+#[serde(rename_all = "lowercase")]
+pub enum EntityKind {
+    Host,
+    Service,
+    Pod,
+    Container,
+}
+
+impl fmt::Display for EntityKind {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            EntityKind::Host => write!(f, "host"),
+            EntityKind::Service => write!(f, "service"),
+            EntityKind::Pod => write!(f, "pod"),
+            EntityKind::Container => write!(f, "container"),
+        }
+    }
+}
+
+impl std::str::FromStr for EntityKind {
+    type Err = anyhow::Error;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s.to_lowercase().as_str() {
+            "host" => Ok(EntityKind::Host),
+            "service" => Ok(EntityKind::Service),
+            "pod" => Ok(EntityKind::Pod),
+            "container" => Ok(EntityKind::Container),
+            _ => Err(anyhow::anyhow!("Invalid entity kind: {s}")),
+        }
+    }
+}
+
+/// A stream the entity sent telemetry to
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct EntityStream {
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    /// Field identifying the entity in the records of the stream
+    pub field: String,
+    /// Query returning the telemetry of the entity in the stream
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub query: String,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct Entity {
+    pub kind: EntityKind,
+    /// Unique name within the kind, e.g. `namespace/pod` for pods
+    pub name: String,
+    /// Value of the identifying field in the records
+    pub value: String,
+    #[serde(default)]
+    pub attributes: BTreeMap<String, String>,
+    #[serde(default)]
+    pub streams: Vec<EntityStream>,
+    /// Services called by this service, found in its client spans
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub depends_on: Vec<String>,
+    pub first_seen: i64,
+    pub last_seen: i64,
+}
+
+impl Entity {
+    pub fn id(&self) -> String {
+        format!("{}/{}", self.kind, self.name)
+    }
+
+    /// Merges what another node saw of the same entity
+    pub fn merge(&mut self, other: &Entity, max_streams: usize) {
+        if other.first_seen > 0 && (self.first_seen == 0 || other.first_seen < self.first_seen) {
+            self.first_seen = other.first_seen;
+        }
+        if other.last_seen > self.last_seen {
+            self.last_seen = other.last_seen;
+            // the latest values win
+            for (k, v) in other.attributes.iter() {
+                self.attributes.insert(k.to_string(), v.to_string());
+            }
+        } else {
+            for (k, v) in other.attributes.iter() {
+                self.attributes
+                    .entry(k.to_string())
+                    .or_insert_with(|| v.to_string());
+            }
+        }
+        for stream in other.streams.iter() {
+            if self.streams.len() >= max_streams {
+                break;
+            }
+            self.add_stream(stream.clone());
+        }
+        for dep in other.depends_on.iter() {
+            if self.depends_on.len() >= max_streams {
+                break;
+            }
+            self.add_dependency(dep);
+        }
+    }
+
+    pub fn add_dependency(&mut self, service: &str) -> bool {
+        if service.is_empty() || self.depends_on.iter().any(|v| v == service) {
+            return false;
+        }
+        self.depends_on.push(service.to_string());
+        true
+    }
+
+    pub fn add_stream(&mut self, stream: EntityStream) -> bool {
+        if self
+            .streams
+            .iter()
+            .any(|s| s.stream_type == stream.stream_type && s.stream_name == stream.stream_name)
+        {
+            return false;
+        }
+        self.streams.push(stream);
+        true
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct EntityList {
+    pub list: Vec<Entity>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entity(first_seen: i64, last_seen: i64, stream: &str) -> Entity {
+        Entity {
+            kind: EntityKind::Host,
+            name: "node-1".to_string(),
+            value: "node-1".to_string(),
+            attributes: BTreeMap::from([("os_type".to_string(), last_seen.to_string())]),
+            streams: vec![EntityStream {
+                stream_type: StreamType::Logs,
+                stream_name: stream.to_string(),
+                field: "host_name".to_string(),
+                query: "".to_string(),
+            }],
+            depends_on: vec![stream.to_string()],
+            first_seen,
+            last_seen,
+        }
+    }
+
+    #[test]
+    fn test_entity_merge() {
+        let mut a = entity(10, 20, "default");
+        a.merge(&entity(5, 30, "default"), 10);
+        assert_eq!(a.first_seen, 5);
+        assert_eq!(a.last_seen, 30);
+        assert_eq!(a.attributes["os_type"], "30");
+        assert_eq!(a.streams.len(), 1);
+
+        a.merge(&entity(1, 2, "k8s"), 10);
+        assert_eq!(a.first_seen, 1);
+        assert_eq!(a.last_seen, 30);
+        assert_eq!(a.attributes["os_type"], "30");
+        assert_eq!(a.streams.len(), 2);
+        assert_eq!(a.depends_on, vec!["default", "k8s"]);
+
+        a.merge(&entity(1, 2, "other"), 2);
+        assert_eq!(a.streams.len(), 2);
+        assert_eq!(a.id(), "host/node-1");
+    }
+
+    #[test]
+    fn test_entity_kind() {
+        assert_eq!("Pod".parse::<EntityKind>().unwrap(), EntityKind::Pod);
+        assert!("vm".parse::<EntityKind>().is_err());
+        assert_eq!(EntityKind::Container.to_string(), "container");
+    }
+}
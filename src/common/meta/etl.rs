@@ -0,0 +1,261 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use chrono::{TimeZone, Utc};
+use config::meta::{sql::Sql, stream::StreamType};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Minimum interval between two runs of a job, in seconds
+pub const MIN_FREQUENCY: i64 = 60;
+
+const SECONDS_PER_DAY: i64 = 86400;
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum EtlWriteMode {
+    /// Results are appended to the destination stream
+    #[default]
+    Append,
+    /// The daily partitions of the window are deleted from the destination
+    /// stream before the results are written, so re-running a window
+    /// replaces its data instead of duplicating it
+    OverwritePartition,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct EtlSource {
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// SQL transformation over the source stream, e.g.
+    /// `SELECT user_id, count(*) AS requests FROM "web" GROUP BY user_id`
+    pub query: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct EtlRun {
+    /// Start of the processed window in microseconds
+    pub start_time: i64,
+    /// End of the processed window in microseconds
+    pub end_time: i64,
+    pub run_at: i64,
+    pub records: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+/// Progress of a window written in append mode, a failed run resumes after
+/// the last page written instead of writing the pages of the window again
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct EtlCheckpoint {
+    /// Start of the window in microseconds
+    pub start_time: i64,
+    /// Queries the window started with, a changed job applies from the next
+    /// window on
+    pub sources: Vec<EtlSource>,
+    /// Index of the source being written
+    pub source: usize,
+    /// Rows of the source already written
+    pub from: i64,
+    /// Records of the window written so far
+    pub written: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct EtlJob {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    /// Queries whose results are written to the destination, one per source
+    /// stream
+    pub sources: Vec<EtlSource>,
+    /// Logs stream the results are written to
+    pub destination: String,
+    #[serde(default)]
+    pub write_mode: EtlWriteMode,
+    /// Size of the processed time window and interval between runs, in
+    /// seconds
+    pub frequency: i64,
+    /// Seconds to wait after the end of a window before processing it, to
+    /// leave time for late data
+    #[serde(default)]
+    pub delay: i64,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+    /// End of the last window written to the destination, in microseconds
+    #[serde(default)]
+    pub last_end_time: i64,
+    /// Start of the window whose partitions were requested to be deleted
+    /// from the destination, in microseconds
+    #[serde(default)]
+    pub pending_overwrite: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub checkpoint: Option<EtlCheckpoint>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_run: Option<EtlRun>,
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+impl EtlJob {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid job name".to_string());
+        }
+        if self.destination.trim().is_empty() {
+            return Err("Destination stream is required".to_string());
+        }
+        if self.sources.is_empty() {
+            return Err("At least one source is required".to_string());
+        }
+        for source in self.sources.iter() {
+            let sql = Sql::new(&source.query).map_err(|e| format!("Invalid query: {e}"))?;
+            if source.stream_type == StreamType::Logs && sql.source == self.destination {
+                return Err("Destination stream can not be one of the sources".to_string());
+            }
+        }
+        if self.frequency < MIN_FREQUENCY {
+            return Err(format!(
+                "Frequency should be at least {MIN_FREQUENCY} seconds"
+            ));
+        }
+        if self.delay < 0 {
+            return Err("Delay should not be negative".to_string());
+        }
+        if self.write_mode == EtlWriteMode::OverwritePartition
+            && self.frequency % SECONDS_PER_DAY != 0
+        {
+            return Err(
+                "Frequency should be a multiple of a day to overwrite partitions".to_string(),
+            );
+        }
+        Ok(())
+    }
+
+    /// Returns the next window to process, or `None` when it is not complete
+    /// yet. Windows are aligned to the frequency, so daily jobs process UTC
+    /// days.
+    pub fn next_window(&self, now: i64) -> Option<(i64, i64)> {
+        let frequency = self.frequency * 1_000_000;
+        let end = (now - self.delay * 1_000_000).div_euclid(frequency) * frequency;
+        let start = if self.last_end_time > 0 {
+            self.last_end_time
+        } else {
+            end - frequency
+        };
+        if start + frequency > end {
+            return None;
+        }
+        Some((start, start + frequency))
+    }
+
+    /// Returns the time at which the window following the last processed one
+    /// is complete
+    pub fn next_run_at(&self, now: i64) -> i64 {
+        let frequency = self.frequency * 1_000_000;
+        let window_end = if self.last_end_time > 0 {
+            self.last_end_time + frequency
+        } else {
+            (now.div_euclid(frequency) + 1) * frequency
+        };
+        window_end + self.delay * 1_000_000
+    }
+}
+
+/// Returns the daily partitions covering the window, in the format expected
+/// by the stream data deletion
+pub fn partition_dates(start_time: i64, end_time: i64) -> (String, String) {
+    let format = |t: i64| Utc.timestamp_nanos(t * 1000).format("%Y-%m-%d").to_string();
+    (format(start_time), format(end_time))
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct EtlJobList {
+    pub list: Vec<EtlJob>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn job() -> EtlJob {
+        EtlJob {
+            name: "daily_requests".to_string(),
+            sources: vec![EtlSource {
+                stream_type: StreamType::Logs,
+                query: "SELECT user_id, count(*) AS requests FROM \"web\" GROUP BY user_id"
+                    .to_string(),
+            }],
+            destination: "web_daily".to_string(),
+            frequency: 86400,
+            enabled: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(job().validate().is_ok());
+
+        let mut invalid = job();
+        invalid.destination = "web".to_string();
+        assert!(invalid.validate().is_err());
+
+        let mut invalid = job();
+        invalid.sources[0].query = "SELEC".to_string();
+        assert!(invalid.validate().is_err());
+
+        let mut hourly = job();
+        hourly.frequency = 3600;
+        assert!(hourly.validate().is_ok());
+        hourly.write_mode = EtlWriteMode::OverwritePartition;
+        assert!(hourly.validate().is_err());
+    }
+
+    #[test]
+    fn test_next_window() {
+        let day = 86_400_000_000;
+        let mut job = job();
+        job.delay = 600;
+        // 2024-01-02T00:05:00Z, yesterday is not complete because of the delay
+        let now = 19724 * day + 300_000_000;
+        assert_eq!(job.next_window(now), None);
+        assert_eq!(job.next_run_at(now), 19725 * day + 600_000_000);
+
+        let now = 19724 * day + 700_000_000;
+        assert_eq!(job.next_window(now), Some((19723 * day, 19724 * day)));
+
+        // catching up from the last processed window
+        job.last_end_time = 19720 * day;
+        assert_eq!(job.next_window(now), Some((19720 * day, 19721 * day)));
+        assert_eq!(job.next_run_at(now), 19721 * day + 600_000_000);
+
+        assert_eq!(
+            partition_dates(19723 * day, 19724 * day),
+            ("2024-01-01".to_string(), "2024-01-02".to_string())
+        );
+    }
+}
@@ -13,7 +13,7 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use config::meta::stream::StreamType;
+use config::{meta::stream::StreamType, utils::json};
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 use vrl::{
@@ -36,6 +36,11 @@ pub struct Transform {
     #[serde(default)]
     #[serde(skip_serializing_if = "Option::is_none")]
     pub streams: Option<Vec<StreamOrder>>,
+    /// Candidate version of the function, evaluated next to the active one
+    /// without being applied until it is promoted
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub shadow: Option<String>,
 }
 
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
@@ -95,7 +100,10 @@ impl Transform {
 
 impl PartialEq for Transform {
     fn eq(&self, other: &Self) -> bool {
-        self.name == other.name && self.function == other.function && self.params == other.params
+        self.name == other.name
+            && self.function == other.function
+            && self.params == other.params
+            && self.shadow == other.shadow
     }
 }
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
@@ -114,6 +122,39 @@ pub struct StreamFunctionsList {
     pub list: Vec<StreamTransform>,
 }
 
+/// Dry run of a function against sample records, either the given events or
+/// the latest records of the stream
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct TestFunctionRequest {
+    pub function: String,
+    #[serde(default)]
+    pub stream_name: Option<String>,
+    #[serde(default)]
+    pub stream_type: Option<StreamType>,
+    /// number of latest records to take from the stream
+    #[serde(default)]
+    pub size: Option<usize>,
+    #[serde(default)]
+    #[schema(value_type = Vec<Object>)]
+    pub events: Vec<json::Value>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct TestFunctionResult {
+    #[schema(value_type = Object)]
+    pub event: json::Value,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(value_type = Object)]
+    pub result: Option<json::Value>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct TestFunctionResponse {
+    pub results: Vec<TestFunctionResult>,
+}
+
 #[derive(Clone, Debug, Serialize, Deserialize)]
 pub struct VRLConfig {
     pub runtime: VrlRuntime,
@@ -160,6 +201,7 @@ mod tests {
                 stream_type: StreamType::Logs,
                 is_removed: false,
             }]),
+            shadow: None,
         };
 
         let mod_trans = Transform {
@@ -169,6 +211,7 @@ mod tests {
             params: "row".to_string(),
             num_args: 1,
             streams: None,
+            shadow: None,
         };
         assert_eq!(trans, mod_trans);
 
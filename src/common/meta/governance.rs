@@ -0,0 +1,223 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{fmt, str::FromStr};
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Tags of an object at most
+pub const MAX_TAGS: usize = 20;
+/// Length of a tag at most, in bytes
+pub const MAX_TAG_LEN: usize = 64;
+/// Days without review after which an active object is reported as stale
+pub const DEFAULT_STALE_DAYS: i64 = 90;
+
+/// Kind of the objects governed by an owner, tags and a lifecycle state
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq, Hash)]
+#[serde(rename_all = "snake_case")]
+pub enum GovernedKind {
+    Dashboards,
+    Alerts,
+    Pipelines,
+}
+
+impl fmt::Display for GovernedKind {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            GovernedKind::Dashboards => write!(f, "dashboards"),
+            GovernedKind::Alerts => write!(f, "alerts"),
+            GovernedKind::Pipelines => write!(f, "pipelines"),
+        }
+    }
+}
+
+impl FromStr for GovernedKind {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "dashboards" => Ok(GovernedKind::Dashboards),
+            "alerts" => Ok(GovernedKind::Alerts),
+            "pipelines" => Ok(GovernedKind::Pipelines),
+            _ => Err(format!("Ownership is not supported on {s}")),
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum LifecycleState {
+    /// Being built, not relied upon yet
+    Draft,
+    #[default]
+    Active,
+    /// Kept for a while before being deleted, nothing new should rely on it
+    Deprecated,
+}
+
+impl fmt::Display for LifecycleState {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            LifecycleState::Draft => write!(f, "draft"),
+            LifecycleState::Active => write!(f, "active"),
+            LifecycleState::Deprecated => write!(f, "deprecated"),
+        }
+    }
+}
+
+impl FromStr for LifecycleState {
+    type Err = String;
+
+    fn from_str(s: &str) -> Result<Self, Self::Err> {
+        match s {
+            "draft" => Ok(LifecycleState::Draft),
+            "active" => Ok(LifecycleState::Active),
+            "deprecated" => Ok(LifecycleState::Deprecated),
+            _ => Err(format!("Invalid lifecycle state: {s}")),
+        }
+    }
+}
+
+/// Owner, team tags and lifecycle state of a dashboard, an alert or a pipeline
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct Governance {
+    pub kind: GovernedKind,
+    /// `{folder}/{dashboard_id}` of a dashboard, `{stream_type}/{stream_name}/{name}`
+    /// of an alert or a pipeline
+    pub object_id: String,
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default)]
+    pub tags: Vec<String>,
+    #[serde(default)]
+    pub state: LifecycleState,
+    pub updated_by: String,
+    /// Last review of the object, unix timestamp in microseconds
+    pub updated_at: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct GovernanceRequest {
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default)]
+    pub tags: Vec<String>,
+    #[serde(default)]
+    pub state: LifecycleState,
+}
+
+impl GovernanceRequest {
+    /// Trims and deduplicates the tags, then checks their number and length
+    pub fn validate(&mut self) -> Result<(), String> {
+        self.owner = self.owner.trim().to_string();
+        let mut tags = Vec::with_capacity(self.tags.len());
+        for tag in self.tags.iter().map(|tag| tag.trim()) {
+            if tag.is_empty() {
+                return Err("Tags can't be empty".to_string());
+            }
+            if tag.len() > MAX_TAG_LEN {
+                return Err(format!("Tags should be at most {MAX_TAG_LEN} bytes"));
+            }
+            if !tags.iter().any(|t| t == tag) {
+                tags.push(tag.to_string());
+            }
+        }
+        if tags.len() > MAX_TAGS {
+            return Err(format!("An object should have at most {MAX_TAGS} tags"));
+        }
+        self.tags = tags;
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct GovernanceList {
+    pub list: Vec<Governance>,
+}
+
+/// Why an object needs attention
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum StaleReason {
+    /// Nobody owns the object
+    NoOwner,
+    /// The owner is no longer a member of the organization
+    OwnerLeft,
+    /// Still there after the stale days since it was deprecated
+    Deprecated,
+    /// Still a draft after the stale days
+    Draft,
+    /// Not reviewed for the stale days
+    NotReviewed,
+    /// A disabled alert
+    Disabled,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct StaleObject {
+    pub kind: GovernedKind,
+    pub object_id: String,
+    pub name: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub governance: Option<Governance>,
+    pub reasons: Vec<StaleReason>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct StaleReport {
+    pub stale_days: i64,
+    pub list: Vec<StaleObject>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_kind_and_state() {
+        for kind in [
+            GovernedKind::Dashboards,
+            GovernedKind::Alerts,
+            GovernedKind::Pipelines,
+        ] {
+            assert_eq!(kind.to_string().parse::<GovernedKind>(), Ok(kind));
+        }
+        assert!("reports".parse::<GovernedKind>().is_err());
+        for state in [
+            LifecycleState::Draft,
+            LifecycleState::Active,
+            LifecycleState::Deprecated,
+        ] {
+            assert_eq!(state.to_string().parse::<LifecycleState>(), Ok(state));
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut req = GovernanceRequest {
+            owner: " alice@example.com ".to_string(),
+            tags: vec!["payments".to_string(), " payments ".to_string()],
+            ..Default::default()
+        };
+        assert!(req.validate().is_ok());
+        assert_eq!(req.owner, "alice@example.com");
+        assert_eq!(req.tags, vec!["payments".to_string()]);
+        req.tags = vec![" ".to_string()];
+        assert!(req.validate().is_err());
+        req.tags = (0..=MAX_TAGS).map(|i| i.to_string()).collect();
+        assert!(req.validate().is_err());
+    }
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Apache Iceberg table metadata, format version 2, as written to
+//! `metadata/v{N}.metadata.json`. Only what OpenObserve writes is modelled:
+//! the tables are unpartitioned and unsorted.
+
+use std::collections::BTreeMap;
+
+use arrow_schema::{DataType, Schema};
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+pub const FORMAT_VERSION: i32 = 2;
+/// Table property telling the readers how to match the parquet columns,
+/// written without field ids, to the fields of the schema
+pub const NAME_MAPPING_PROPERTY: &str = "schema.name-mapping.default";
+/// last-partition-id of a table without partition fields
+const UNPARTITIONED_LAST_PARTITION_ID: i32 = 999;
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct TableMetadata {
+    pub format_version: i32,
+    pub table_uuid: String,
+    pub location: String,
+    pub last_sequence_number: i64,
+    pub last_updated_ms: i64,
+    pub last_column_id: i32,
+    pub schemas: Vec<IcebergSchema>,
+    pub current_schema_id: i32,
+    pub partition_specs: Vec<PartitionSpec>,
+    pub default_spec_id: i32,
+    pub last_partition_id: i32,
+    #[serde(default)]
+    pub properties: BTreeMap<String, String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub current_snapshot_id: Option<i64>,
+    #[serde(default)]
+    pub snapshots: Vec<Snapshot>,
+    #[serde(default)]
+    pub snapshot_log: Vec<SnapshotLogEntry>,
+    #[serde(default)]
+    pub metadata_log: Vec<MetadataLogEntry>,
+    pub sort_orders: Vec<SortOrder>,
+    pub default_sort_order_id: i32,
+    #[serde(default)]
+    pub refs: BTreeMap<String, SnapshotRef>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct IcebergSchema {
+    #[serde(rename = "type")]
+    pub schema_type: String,
+    pub schema_id: i32,
+    pub fields: Vec<SchemaField>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+pub struct SchemaField {
+    pub id: i32,
+    pub name: String,
+    pub required: bool,
+    #[serde(rename = "type")]
+    pub field_type: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct PartitionSpec {
+    pub spec_id: i32,
+    pub fields: Vec<json::Value>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct SortOrder {
+    pub order_id: i32,
+    pub fields: Vec<json::Value>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct Snapshot {
+    pub snapshot_id: i64,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub parent_snapshot_id: Option<i64>,
+    pub sequence_number: i64,
+    pub timestamp_ms: i64,
+    pub manifest_list: String,
+    pub summary: BTreeMap<String, String>,
+    pub schema_id: i32,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct SnapshotLogEntry {
+    pub timestamp_ms: i64,
+    pub snapshot_id: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct MetadataLogEntry {
+    pub timestamp_ms: i64,
+    pub metadata_file: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+#[serde(rename_all = "kebab-case")]
+pub struct SnapshotRef {
+    pub snapshot_id: i64,
+    #[serde(rename = "type")]
+    pub ref_type: String,
+}
+
+impl TableMetadata {
+    pub fn new(table_uuid: &str, location: &str) -> Self {
+        Self {
+            format_version: FORMAT_VERSION,
+            table_uuid: table_uuid.to_string(),
+            location: location.to_string(),
+            current_schema_id: -1,
+            partition_specs: vec![PartitionSpec::default()],
+            last_partition_id: UNPARTITIONED_LAST_PARTITION_ID,
+            sort_orders: vec![SortOrder::default()],
+            ..Default::default()
+        }
+    }
+
+    pub fn current_schema(&self) -> Option<&IcebergSchema> {
+        self.schemas
+            .iter()
+            .find(|s| s.schema_id == self.current_schema_id)
+    }
+
+    /// Makes the arrow schema the current schema, adding a schema when its
+    /// fields changed. The fields keep their id while their type doesn't
+    /// change, the other fields get new ids. Returns the current schema id.
+    pub fn update_schema(&mut self, schema: &Schema) -> i32 {
+        let current = self.current_schema().cloned().unwrap_or_default();
+        let mut fields = Vec::with_capacity(schema.fields().len());
+        for field in schema.fields() {
+            let Some(field_type) = iceberg_type(field.data_type()) else {
+                continue;
+            };
+            let id = match current
+                .fields
+                .iter()
+                .find(|f| f.name == *field.name() && f.field_type == field_type)
+            {
+                Some(f) => f.id,
+                None => {
+                    self.last_column_id += 1;
+                    self.last_column_id
+                }
+            };
+            fields.push(SchemaField {
+                id,
+                name: field.name().to_string(),
+                required: false,
+                field_type: field_type.to_string(),
+            });
+        }
+        if self.current_schema_id >= 0 && current.fields == fields {
+            return self.current_schema_id;
+        }
+        let schema_id = self.schemas.iter().map(|s| s.schema_id).max().unwrap_or(-1) + 1;
+        let mapping: Vec<json::Value> = fields
+            .iter()
+            .map(|f| json::json!({"field-id": f.id, "names": [f.name]}))
+            .collect();
+        self.properties.insert(
+            NAME_MAPPING_PROPERTY.to_string(),
+            json::Value::Array(mapping).to_string(),
+        );
+        self.schemas.push(IcebergSchema {
+            schema_type: "struct".to_string(),
+            schema_id,
+            fields,
+        });
+        self.current_schema_id = schema_id;
+        schema_id
+    }
+}
+
+/// Returns the Iceberg type of an arrow type, None for the types without one,
+/// which are left out of the table
+pub fn iceberg_type(data_type: &DataType) -> Option<&'static str> {
+    match data_type {
+        DataType::Boolean => Some("boolean"),
+        DataType::Int8 | DataType::Int16 | DataType::Int32 | DataType::UInt8 | DataType::UInt16 => {
+            Some("int")
+        }
+        DataType::Int64 | DataType::UInt32 | DataType::UInt64 => Some("long"),
+        DataType::Float32 => Some("float"),
+        DataType::Float64 => Some("double"),
+        DataType::Utf8 | DataType::LargeUtf8 => Some("string"),
+        DataType::Binary | DataType::LargeBinary => Some("binary"),
+        _ => None,
+    }
+}
+
+/// Iceberg table of a stream, its metadata is also written to the storage on
+/// every commit
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct IcebergTable {
+    /// Location of the table, e.g. `s3://bucket/iceberg/default/logs/app`
+    pub location: String,
+    /// Location of the current metadata file, to register the table in a
+    /// catalog
+    pub metadata_location: String,
+    /// Version of the current metadata file
+    pub version: i64,
+    /// Hash of the file keys of the last commit, no commit is made while the
+    /// files don't change
+    #[serde(default)]
+    pub files_hash: u64,
+    #[schema(value_type = Object)]
+    pub metadata: TableMetadata,
+}
+
+#[cfg(test)]
+mod tests {
+    use arrow_schema::Field;
+
+    use super::*;
+
+    #[test]
+    fn test_update_schema() {
+        let mut metadata = TableMetadata::new("uuid", "s3://bucket/iceberg/default/logs/app");
+        let schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("log", DataType::Utf8, true),
+            Field::new("tags", DataType::new_list(DataType::Utf8, true), true),
+        ]);
+        assert_eq!(metadata.update_schema(&schema), 0);
+        let fields = &metadata.current_schema().unwrap().fields;
+        assert_eq!(fields.len(), 2);
+        assert_eq!((fields[0].id, fields[0].field_type.as_str()), (1, "long"));
+        assert_eq!((fields[1].id, fields[1].field_type.as_str()), (2, "string"));
+
+        // unchanged
+        assert_eq!(metadata.update_schema(&schema), 0);
+        assert_eq!(metadata.schemas.len(), 1);
+
+        // a new field and a changed type
+        let schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("log", DataType::Int64, true),
+            Field::new("code", DataType::Int64, true),
+        ]);
+        assert_eq!(metadata.update_schema(&schema), 1);
+        let ids: Vec<i32> = metadata
+            .current_schema()
+            .unwrap()
+            .fields
+            .iter()
+            .map(|f| f.id)
+            .collect();
+        assert_eq!(ids, vec![1, 3, 4]);
+        assert!(metadata.properties[NAME_MAPPING_PROPERTY].contains("\"code\""));
+    }
+}
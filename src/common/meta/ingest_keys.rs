@@ -0,0 +1,54 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Scheme of the `Authorization` header of HMAC signed ingestion requests, the
+/// full form is
+/// `HMAC-SHA256 Credential=<key id>, Timestamp=<unix seconds>, Signature=<hex>`.
+pub const HMAC_AUTH_SCHEME: &str = "HMAC-SHA256";
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct IngestKeyRequest {
+    #[serde(default)]
+    pub description: String,
+    /// Streams the key can ingest into, empty allows all of them
+    #[serde(default)]
+    pub streams: Vec<String>,
+}
+
+/// Key signing ingestion requests. The requests are ingested as the user who
+/// created the key, only into the streams of the key.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct IngestKey {
+    pub id: String,
+    pub org_id: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    pub streams: Vec<String>,
+    pub user_email: String,
+    /// Only returned when the key is created
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub secret: String,
+    pub created_at: i64,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct IngestKeyList {
+    pub list: Vec<IngestKey>,
+}
@@ -81,6 +81,15 @@ pub struct StreamSchemaChk {
     pub has_metadata: bool,
 }
 
+/// Request header to bypass the stream sampling rules, used for emergency full capture, only
+/// honored for the admins of the org
+pub const SAMPLING_OVERRIDE_HEADER: &str = "o2-sampling-override";
+
+/// Whether the value of the sampling override header asks for a full capture
+pub fn is_sampling_override(value: &str) -> bool {
+    value.eq_ignore_ascii_case("true") || value.eq_ignore_ascii_case("full")
+}
+
 pub const INGESTION_EP: [&str; 14] = [
     "_bulk",
     "_json",
@@ -0,0 +1,115 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::BTreeMap;
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+pub const DEFAULT_MONTHS: u32 = 12;
+pub const MAX_MONTHS: u32 = 60;
+
+fn default_months() -> u32 {
+    DEFAULT_MONTHS
+}
+
+/// Projection of the storage of streams under the given ingest rates and
+/// lifecycle settings
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct LifecycleEstimateRequest {
+    /// Months to project, of 30 days
+    #[serde(default = "default_months")]
+    pub months: u32,
+    /// Price of the storage by the age of the data, the data is billed at the
+    /// last tier its age reached. A single tier at the object store standard
+    /// price when empty
+    #[serde(default)]
+    pub tiers: Vec<StorageTier>,
+    pub streams: Vec<StreamLifecycle>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct StorageTier {
+    pub name: String,
+    /// Age in days from which the data is in the tier
+    #[serde(default)]
+    pub after_days: i64,
+    /// Price per compressed GB stored for a month
+    pub price_per_gb_month: f64,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct StreamLifecycle {
+    pub stream_name: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Uncompressed GB ingested per day, the rate observed on the stream when
+    /// not set
+    #[serde(default)]
+    pub ingest_gb_per_day: Option<f64>,
+    /// Growth of the ingest rate per month, in percent
+    #[serde(default)]
+    pub monthly_growth: f64,
+    /// Compressed size over ingested size, the ratio observed on the stream
+    /// when not set
+    #[serde(default)]
+    pub compression_ratio: Option<f64>,
+    /// Days the data is kept, 0 keeps it forever. The retention of the stream
+    /// when not set
+    #[serde(default)]
+    pub retention_days: Option<i64>,
+    #[serde(default)]
+    pub rollup: Option<Rollup>,
+}
+
+/// Rolls up the data once it is old enough, keeping a fraction of its size
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct Rollup {
+    pub after_days: i64,
+    /// Size of the rolled up data over the size of the raw data, in (0, 1]
+    pub size_ratio: f64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct LifecycleEstimateResponse {
+    pub streams: Vec<StreamEstimate>,
+    /// All the streams together
+    pub total: Vec<MonthEstimate>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct StreamEstimate {
+    pub stream_name: String,
+    pub stream_type: StreamType,
+    /// Settings the projection used, after filling in the observed ones
+    pub ingest_gb_per_day: f64,
+    pub compression_ratio: f64,
+    pub retention_days: i64,
+    /// Compressed GB stored now
+    pub current_gb: f64,
+    pub months: Vec<MonthEstimate>,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct MonthEstimate {
+    pub month: u32,
+    /// Compressed GB stored at the end of the month
+    pub stored_gb: f64,
+    /// Compressed GB stored in each tier at the end of the month
+    pub tiers: BTreeMap<String, f64>,
+    /// Storage cost of the month
+    pub cost: f64,
+}
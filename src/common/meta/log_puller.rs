@@ -0,0 +1,390 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::fmt;
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::{plugins::PluginTarget, secrets::is_secret_ref};
+
+/// Value returned in place of the credentials, sending it back on update
+/// keeps the stored credential
+pub const SECRET_MASK: &str = "******";
+/// Minimum seconds between two pulls of an integration
+pub const MIN_INTERVAL: u64 = 10;
+/// Maximum length of the time window pulled in one run, in microseconds, a
+/// puller behind catches up window by window
+pub const MAX_WINDOW: i64 = 3600 * 1_000_000;
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq, Hash)]
+#[serde(rename_all = "snake_case")]
+pub enum LogPullerProvider {
+    /// Amazon CloudWatch Logs log group
+    #[default]
+    Cloudwatch,
+    /// Google Cloud Logging sink routed to a Pub/Sub subscription
+    GcpPubsub,
+    /// Azure Monitor Logs (Log Analytics workspace) table
+    AzureMonitor,
+    /// Source plugin, see `service::plugins`
+    Plugin,
+}
+
+impl fmt::Display for LogPullerProvider {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            LogPullerProvider::Cloudwatch => write!(f, "cloudwatch"),
+            LogPullerProvider::GcpPubsub => write!(f, "gcp_pubsub"),
+            LogPullerProvider::AzureMonitor => write!(f, "azure_monitor"),
+            LogPullerProvider::Plugin => write!(f, "plugin"),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct CloudwatchSource {
+    pub region: String,
+    pub access_key_id: String,
+    pub secret_access_key: String,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub session_token: String,
+    pub log_group_name: String,
+    /// Only the log streams starting with the prefix are pulled
+    #[serde(default)]
+    pub log_stream_prefix: String,
+    /// CloudWatch filter pattern, all the events when empty
+    #[serde(default)]
+    pub filter_pattern: String,
+    /// Seconds to wait for the events to be searchable in CloudWatch
+    #[serde(default = "default_cloudwatch_delay")]
+    pub delay: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct GcpPubsubSource {
+    /// Subscription as `projects/{project}/subscriptions/{subscription}`
+    pub subscription: String,
+    /// Content of the JSON key of the service account
+    pub service_account_key: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct AzureMonitorSource {
+    pub tenant_id: String,
+    pub client_id: String,
+    pub client_secret: String,
+    pub workspace_id: String,
+    /// Table or KQL query the time filter is appended to, e.g. `AzureActivity`
+    pub query: String,
+    /// Seconds to wait for the records to be queryable in the workspace
+    #[serde(default = "default_azure_delay")]
+    pub delay: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct LogPuller {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    pub provider: LogPullerProvider,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cloudwatch: Option<CloudwatchSource>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub gcp_pubsub: Option<GcpPubsubSource>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub azure_monitor: Option<AzureMonitorSource>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub plugin: Option<PluginTarget>,
+    /// Logs stream the pulled records are written to
+    pub stream_name: String,
+    /// Seconds between two pulls
+    #[serde(default = "default_interval")]
+    pub interval: u64,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+fn default_cloudwatch_delay() -> i64 {
+    60
+}
+
+fn default_azure_delay() -> i64 {
+    300
+}
+
+fn default_interval() -> u64 {
+    60
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+impl LogPuller {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid log puller name".to_string());
+        }
+        if self.stream_name.trim().is_empty() {
+            return Err("Destination stream is required".to_string());
+        }
+        if self.interval < MIN_INTERVAL {
+            return Err(format!(
+                "Interval should be at least {MIN_INTERVAL} seconds"
+            ));
+        }
+        let ok = match self.provider {
+            LogPullerProvider::Cloudwatch => self.cloudwatch.as_ref().is_some_and(|s| {
+                !s.region.is_empty()
+                    && !s.access_key_id.is_empty()
+                    && !s.secret_access_key.is_empty()
+                    && !s.log_group_name.is_empty()
+                    && s.delay >= 0
+            }),
+            LogPullerProvider::GcpPubsub => self.gcp_pubsub.as_ref().is_some_and(|s| {
+                s.subscription.starts_with("projects/")
+                    && s.subscription.contains("/subscriptions/")
+                    && !s.service_account_key.is_empty()
+            }),
+            LogPullerProvider::AzureMonitor => self.azure_monitor.as_ref().is_some_and(|s| {
+                !s.tenant_id.is_empty()
+                    && !s.client_id.is_empty()
+                    && !s.client_secret.is_empty()
+                    && !s.workspace_id.is_empty()
+                    && !s.query.trim().is_empty()
+                    && s.delay >= 0
+            }),
+            LogPullerProvider::Plugin => self
+                .plugin
+                .as_ref()
+                .is_some_and(|s| !s.plugin.trim().is_empty()),
+        };
+        if !ok {
+            return Err(format!(
+                "Missing or invalid {} settings for the provider",
+                self.provider
+            ));
+        }
+        Ok(())
+    }
+
+    /// Hides the credentials before returning the integration
+    pub fn mask_secrets(&mut self) {
+        // references to the secrets store carry no credential, keep them visible
+        let mask = |value: &mut String| {
+            if !is_secret_ref(value) {
+                *value = SECRET_MASK.to_string();
+            }
+        };
+        if let Some(s) = self.cloudwatch.as_mut() {
+            mask(&mut s.secret_access_key);
+            if !s.session_token.is_empty() {
+                mask(&mut s.session_token);
+            }
+        }
+        if let Some(s) = self.gcp_pubsub.as_mut() {
+            mask(&mut s.service_account_key);
+        }
+        if let Some(s) = self.azure_monitor.as_mut() {
+            mask(&mut s.client_secret);
+        }
+    }
+
+    /// Replaces the masked credentials of an update with the stored ones
+    pub fn keep_secrets(&mut self, stored: &LogPuller) {
+        if let (Some(s), Some(old)) = (self.cloudwatch.as_mut(), stored.cloudwatch.as_ref()) {
+            if s.secret_access_key == SECRET_MASK {
+                s.secret_access_key = old.secret_access_key.clone();
+            }
+            if s.session_token == SECRET_MASK {
+                s.session_token = old.session_token.clone();
+            }
+        }
+        if let (Some(s), Some(old)) = (self.gcp_pubsub.as_mut(), stored.gcp_pubsub.as_ref()) {
+            if s.service_account_key == SECRET_MASK {
+                s.service_account_key = old.service_account_key.clone();
+            }
+        }
+        if let (Some(s), Some(old)) = (self.azure_monitor.as_mut(), stored.azure_monitor.as_ref()) {
+            if s.client_secret == SECRET_MASK {
+                s.client_secret = old.client_secret.clone();
+            }
+        }
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct LogPullerList {
+    pub list: Vec<LogPuller>,
+}
+
+/// Checkpoint and health of an integration
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct LogPullerState {
+    /// Everything before this time has been pulled, in microseconds
+    #[serde(default)]
+    pub checkpoint: i64,
+    /// End of the window being pulled when a run stopped in the middle of it
+    #[serde(default)]
+    pub window_end: i64,
+    /// Page to resume the window from
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub next_token: Option<String>,
+    #[serde(default)]
+    pub last_run_at: i64,
+    #[serde(default)]
+    pub last_success_at: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_error: Option<String>,
+    #[serde(default)]
+    pub consecutive_failures: u32,
+    /// Records pulled since the integration was created
+    #[serde(default)]
+    pub records: i64,
+}
+
+impl LogPullerState {
+    /// Returns the window to pull, resuming an unfinished one first. The first
+    /// window of an integration covers the last interval. `delay` and
+    /// `interval` are in seconds.
+    pub fn next_window(&self, now: i64, delay: i64, interval: u64) -> Option<(i64, i64)> {
+        if self.window_end > self.checkpoint {
+            return Some((self.checkpoint, self.window_end));
+        }
+        // windows end on a whole second
+        let end = (now - delay * 1_000_000) / 1_000_000 * 1_000_000;
+        let start = if self.checkpoint > 0 {
+            self.checkpoint
+        } else {
+            end - interval as i64 * 1_000_000
+        };
+        let end = end.min(start + MAX_WINDOW);
+        if end <= start {
+            return None;
+        }
+        Some((start, end))
+    }
+
+    /// Marks the window as fully pulled
+    pub fn complete_window(&mut self, end: i64) {
+        self.checkpoint = end;
+        self.window_end = 0;
+        self.next_token = None;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn cloudwatch_puller() -> LogPuller {
+        LogPuller {
+            name: "lambda".to_string(),
+            provider: LogPullerProvider::Cloudwatch,
+            cloudwatch: Some(CloudwatchSource {
+                region: "us-east-1".to_string(),
+                access_key_id: "AKID".to_string(),
+                secret_access_key: "secret".to_string(),
+                log_group_name: "/aws/lambda/api".to_string(),
+                delay: 60,
+                ..Default::default()
+            }),
+            stream_name: "lambda".to_string(),
+            interval: 60,
+            enabled: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut puller = cloudwatch_puller();
+        assert!(puller.validate().is_ok());
+        puller.interval = 1;
+        assert!(puller.validate().is_err());
+        puller.interval = 60;
+        puller.provider = LogPullerProvider::GcpPubsub;
+        assert!(puller.validate().is_err());
+        puller.gcp_pubsub = Some(GcpPubsubSource {
+            subscription: "projects/p/subscriptions/logs".to_string(),
+            service_account_key: "{}".to_string(),
+        });
+        assert!(puller.validate().is_ok());
+    }
+
+    #[test]
+    fn test_secrets() {
+        let stored = cloudwatch_puller();
+        let mut puller = stored.clone();
+        puller.mask_secrets();
+        assert_eq!(
+            puller.cloudwatch.as_ref().unwrap().secret_access_key,
+            SECRET_MASK
+        );
+        assert!(puller.cloudwatch.as_ref().unwrap().session_token.is_empty());
+        puller.keep_secrets(&stored);
+        assert_eq!(puller, stored);
+
+        // references to the secrets store are shown as they are
+        let mut puller = stored.clone();
+        puller.cloudwatch.as_mut().unwrap().secret_access_key = "{{secret:aws}}".to_string();
+        puller.mask_secrets();
+        assert_eq!(
+            puller.cloudwatch.as_ref().unwrap().secret_access_key,
+            "{{secret:aws}}"
+        );
+    }
+
+    #[test]
+    fn test_next_window() {
+        let now = 10_000_500_000; // 10000.5s
+        let mut state = LogPullerState::default();
+        assert_eq!(
+            state.next_window(now, 60, 60),
+            Some((9_880_000_000, 9_940_000_000))
+        );
+        state.complete_window(9_940_000_000);
+        assert_eq!(state.next_window(now, 60, 60), None);
+        assert_eq!(
+            state.next_window(now + 10_000_000, 60, 60),
+            Some((9_940_000_000, 9_950_000_000))
+        );
+        // unfinished window is resumed
+        state.window_end = 9_945_000_000;
+        state.next_token = Some("page".to_string());
+        assert_eq!(
+            state.next_window(now + 10_000_000, 60, 60),
+            Some((9_940_000_000, 9_945_000_000))
+        );
+        // catches up one window at a time
+        state.complete_window(1_000_000);
+        assert_eq!(
+            state.next_window(now, 60, 60),
+            Some((1_000_000, 1_000_000 + MAX_WINDOW))
+        );
+    }
+}
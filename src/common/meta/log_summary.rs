@@ -0,0 +1,67 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct LogSummaryRequest {
+    /// Query of the result window, e.g. `SELECT * FROM "app" WHERE level = 'error'`
+    pub sql: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Start of the window in microseconds, the previous window is the same
+    /// duration right before it
+    pub start_time: i64,
+    /// End of the window in microseconds
+    pub end_time: i64,
+    /// Field holding the log message, detected when empty
+    #[serde(default)]
+    pub message_field: String,
+}
+
+/// Messages sharing the same text once the variable parts, like numbers,
+/// ids and addresses, are replaced by `<*>`
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct LogPattern {
+    pub pattern: String,
+    pub count: usize,
+    pub previous_count: usize,
+    pub error: bool,
+    /// One of the messages of the pattern
+    pub example: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct LogSummaryResponse {
+    pub message_field: String,
+    /// Records read in the window, up to ZO_AI_SUMMARY_MAX_RECORDS
+    pub records: usize,
+    pub previous_records: usize,
+    pub errors: usize,
+    pub previous_errors: usize,
+    /// Most frequent patterns of the window
+    pub patterns: Vec<LogPattern>,
+    /// Error patterns absent from the previous window
+    pub new_errors: Vec<LogPattern>,
+    /// Patterns whose count changed the most compared to the previous window
+    pub changes: Vec<LogPattern>,
+    /// Written by the language model when ZO_AI_ENABLED is set
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub summary: Option<String>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
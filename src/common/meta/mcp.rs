@@ -0,0 +1,209 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Version of the Model Context Protocol spoken at `/mcp/{org_id}`
+pub const PROTOCOL_VERSION: &str = "2024-11-05";
+
+/// Access of an AI assistant to the tools of an organization. The limits
+/// left to 0 use the ZO_MCP_MAX_* defaults and can't exceed them.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct McpTokenRequest {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    /// Streams the token can read, `*` wildcards allowed, all when empty
+    #[serde(default)]
+    pub streams: Vec<String>,
+    /// Stream types the token can read, all when empty
+    #[serde(default)]
+    pub stream_types: Vec<StreamType>,
+    #[serde(default)]
+    pub max_rows: i64,
+    /// Hours
+    #[serde(default)]
+    pub max_time_range: i64,
+    /// Compressed MB
+    #[serde(default)]
+    pub max_scan_size: i64,
+    /// Unix microseconds, never expires when 0
+    #[serde(default)]
+    pub expires_at: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct McpToken {
+    pub name: String,
+    pub org_id: String,
+    pub description: String,
+    pub streams: Vec<String>,
+    pub stream_types: Vec<StreamType>,
+    pub max_rows: i64,
+    pub max_time_range: i64,
+    pub max_scan_size: i64,
+    pub expires_at: i64,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    #[schema(read_only)]
+    pub salt: String,
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    #[schema(read_only)]
+    pub token_hash: String,
+    pub created_by: String,
+    pub created_at: i64,
+    /// Unix microseconds of the last tool call
+    #[serde(default)]
+    pub last_used_at: i64,
+}
+
+impl McpToken {
+    /// Returns whether the token can read the stream
+    pub fn allows(&self, stream_type: StreamType, stream_name: &str) -> bool {
+        if !self.stream_types.is_empty() && !self.stream_types.contains(&stream_type) {
+            return false;
+        }
+        self.streams.is_empty()
+            || self
+                .streams
+                .iter()
+                .any(|pattern| wildcard_match(pattern, stream_name))
+    }
+
+    pub fn is_expired(&self, now: i64) -> bool {
+        self.expires_at > 0 && self.expires_at <= now
+    }
+}
+
+/// Matches `*` against any sequence of characters, the rest literally
+pub fn wildcard_match(pattern: &str, value: &str) -> bool {
+    let parts = pattern.split('*').collect::<Vec<_>>();
+    if parts.len() == 1 {
+        return pattern == value;
+    }
+    let (first, last) = (parts[0], parts[parts.len() - 1]);
+    if value.len() < first.len() + last.len() || !value.starts_with(first) || !value.ends_with(last)
+    {
+        return false;
+    }
+    let mut rest = &value[first.len()..value.len() - last.len()];
+    for part in &parts[1..parts.len() - 1] {
+        match rest.find(part) {
+            Some(pos) => rest = &rest[pos + part.len()..],
+            None => return false,
+        }
+    }
+    true
+}
+
+/// Returned once at the creation, only the hash of the token is stored
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct McpTokenCreated {
+    pub name: String,
+    /// Bearer token of the `Authorization` header of `/mcp/{org_id}`
+    pub token: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct McpTokenList {
+    pub list: Vec<McpToken>,
+}
+
+#[derive(Clone, Debug, Deserialize)]
+pub struct JsonRpcRequest {
+    #[serde(default)]
+    pub jsonrpc: String,
+    /// Absent for the notifications, which get no response
+    #[serde(default)]
+    pub id: Option<json::Value>,
+    pub method: String,
+    #[serde(default)]
+    pub params: json::Value,
+}
+
+#[derive(Clone, Debug, Serialize)]
+pub struct JsonRpcResponse {
+    pub jsonrpc: &'static str,
+    pub id: json::Value,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub result: Option<json::Value>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<JsonRpcError>,
+}
+
+impl JsonRpcResponse {
+    pub fn result(id: json::Value, result: json::Value) -> Self {
+        Self {
+            jsonrpc: "2.0",
+            id,
+            result: Some(result),
+            error: None,
+        }
+    }
+
+    pub fn error(id: json::Value, code: i64, message: impl ToString) -> Self {
+        Self {
+            jsonrpc: "2.0",
+            id,
+            result: None,
+            error: Some(JsonRpcError {
+                code,
+                message: message.to_string(),
+            }),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Serialize)]
+pub struct JsonRpcError {
+    pub code: i64,
+    pub message: String,
+}
+
+pub const PARSE_ERROR: i64 = -32700;
+pub const INVALID_REQUEST: i64 = -32600;
+pub const METHOD_NOT_FOUND: i64 = -32601;
+pub const INVALID_PARAMS: i64 = -32602;
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_wildcard_match() {
+        assert!(wildcard_match("app", "app"));
+        assert!(!wildcard_match("app", "apps"));
+        assert!(wildcard_match("*", "anything"));
+        assert!(wildcard_match("k8s_*", "k8s_logs"));
+        assert!(wildcard_match("*_prod", "web_prod"));
+        assert!(wildcard_match("a*b*c", "aXbYc"));
+        assert!(!wildcard_match("a*b*c", "aXcYb"));
+        assert!(!wildcard_match("ab*ba", "aba"));
+    }
+
+    #[test]
+    fn test_token_allows() {
+        let token = McpToken {
+            streams: vec!["web_*".to_string()],
+            stream_types: vec![StreamType::Logs],
+            ..Default::default()
+        };
+        assert!(token.allows(StreamType::Logs, "web_access"));
+        assert!(!token.allows(StreamType::Logs, "billing"));
+        assert!(!token.allows(StreamType::Metrics, "web_access"));
+        assert!(McpToken::default().allows(StreamType::Traces, "any"));
+    }
+}
@@ -14,22 +14,56 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 pub mod alerts;
+pub mod archive_search;
 pub mod authz;
+pub mod bulk;
+pub mod comments;
+pub mod config_versions;
+pub mod conformance;
 pub mod dashboards;
+pub mod demo;
+pub mod entity;
+pub mod etl;
 pub mod functions;
+pub mod governance;
 pub mod http;
+pub mod iceberg;
+pub mod ingest_keys;
 pub mod ingestion;
+pub mod lifecycle;
+pub mod log_puller;
+pub mod log_summary;
 pub mod maxmind;
+pub mod mcp;
 pub mod middleware_data;
+pub mod nl_query;
 pub mod organization;
+pub mod parquet_import;
 pub mod pipelines;
+pub mod plugins;
 pub mod prom;
 pub mod proxy;
+pub mod query_analyze;
+pub mod query_history;
+pub mod rehydration;
+pub mod saved_query;
 pub mod saved_view;
+pub mod schema_contract;
+pub mod scrape;
 pub mod search;
+pub mod search_jobs;
+pub mod secrets;
 pub mod service;
+pub mod snapshot;
 pub mod stream;
+pub mod stream_access;
+pub mod stream_partitions;
+pub mod stream_profile;
+pub mod subscriptions;
+pub mod synthetics;
 pub mod syslog;
+pub mod table_query;
 pub mod telemetry;
 pub mod traces;
 pub mod user;
+pub mod webhook;
@@ -0,0 +1,53 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::query_analyze::{QueryAnalyzeResponse, QueryLanguage};
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct NlQueryRequest {
+    /// Question in natural language, e.g. "error rate of the checkout service"
+    pub question: String,
+    #[serde(default)]
+    pub query_type: QueryLanguage,
+    /// Stream type of SQL queries, default logs
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Stream to query, chosen among the streams of the type when empty
+    #[serde(default)]
+    pub stream_name: String,
+    /// Time range of the cost estimate, microseconds
+    #[serde(default)]
+    pub start_time: i64,
+    #[serde(default)]
+    pub end_time: i64,
+}
+
+/// Candidate query, returned for confirmation and not run
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct NlQueryResponse {
+    pub query: String,
+    pub query_type: QueryLanguage,
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    pub explanation: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub cost: Option<QueryAnalyzeResponse>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
@@ -13,6 +13,9 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+use std::net::IpAddr;
+
+use ipnetwork::IpNetwork;
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 
@@ -121,3 +124,89 @@ impl Default for OrganizationSetting {
 pub struct OrganizationSettingResponse {
     pub data: OrganizationSetting,
 }
+
+/// Group of endpoints a network policy applies to.
+#[derive(Serialize, Deserialize, ToSchema, Clone, Copy, Debug, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum NetworkScope {
+    /// Console and management APIs
+    Ui,
+    /// Search and PromQL query APIs
+    Query,
+    /// Ingestion endpoints
+    Ingest,
+}
+
+impl std::fmt::Display for NetworkScope {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            NetworkScope::Ui => write!(f, "ui"),
+            NetworkScope::Query => write!(f, "query"),
+            NetworkScope::Ingest => write!(f, "ingest"),
+        }
+    }
+}
+
+/// CIDR allowlists of an org, one per group of endpoints. An empty list allows
+/// every address.
+#[derive(Serialize, Deserialize, ToSchema, Clone, Debug, Default, PartialEq)]
+pub struct NetworkPolicy {
+    #[serde(default)]
+    #[schema(value_type = Vec<String>)]
+    pub ui: Vec<IpNetwork>,
+    #[serde(default)]
+    #[schema(value_type = Vec<String>)]
+    pub query: Vec<IpNetwork>,
+    #[serde(default)]
+    #[schema(value_type = Vec<String>)]
+    pub ingest: Vec<IpNetwork>,
+}
+
+impl NetworkPolicy {
+    pub fn allows(&self, scope: NetworkScope, ip: IpAddr) -> bool {
+        let allowlist = match scope {
+            NetworkScope::Ui => &self.ui,
+            NetworkScope::Query => &self.query,
+            NetworkScope::Ingest => &self.ingest,
+        };
+        allowlist.is_empty() || allowlist.iter().any(|net| net.contains(ip))
+    }
+}
+
+#[derive(Serialize, ToSchema, Deserialize, Debug, Clone)]
+pub struct NetworkPolicyResponse {
+    pub data: NetworkPolicy,
+}
+
+/// Region an org's data is pinned to. Ingestion and queries of the org are
+/// only served by clusters of that region.
+#[derive(Serialize, Deserialize, ToSchema, Clone, Debug, Default, PartialEq)]
+pub struct OrgResidency {
+    pub region: String,
+}
+
+#[derive(Serialize, ToSchema, Deserialize, Debug, Clone)]
+pub struct OrgResidencyResponse {
+    /// Region of the cluster serving the request
+    pub local_region: String,
+    pub data: Option<OrgResidency>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_network_policy_allows() {
+        let policy: NetworkPolicy = serde_json::from_str(
+            r#"{"ui": ["10.0.0.0/8", "192.168.1.10/32"], "ingest": ["2001:db8::/32"]}"#,
+        )
+        .unwrap();
+        assert!(policy.allows(NetworkScope::Ui, "10.1.2.3".parse().unwrap()));
+        assert!(policy.allows(NetworkScope::Ui, "192.168.1.10".parse().unwrap()));
+        assert!(!policy.allows(NetworkScope::Ui, "192.168.1.11".parse().unwrap()));
+        assert!(policy.allows(NetworkScope::Query, "8.8.8.8".parse().unwrap()));
+        assert!(policy.allows(NetworkScope::Ingest, "2001:db8::1".parse().unwrap()));
+        assert!(!policy.allows(NetworkScope::Ingest, "10.1.2.3".parse().unwrap()));
+    }
+}
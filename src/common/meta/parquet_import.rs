@@ -0,0 +1,54 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Parquet files already in the bucket to register into a stream
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ImportParquetRequest {
+    /// Keys of the files in the bucket, under the import prefix of the
+    /// organization, e.g. `import/{org_id}/app/2024-05-01.parquet`
+    pub files: Vec<String>,
+    /// Delete the source files once they are copied into the stream
+    #[serde(default)]
+    pub delete_source: bool,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ImportParquetResponse {
+    pub imported: Vec<ImportedFile>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub failed: Vec<FailedFile>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ImportedFile {
+    /// Key in the bucket or name of the uploaded file
+    pub source: String,
+    /// Key of the file in the file list of the stream
+    pub key: String,
+    pub min_ts: i64,
+    pub max_ts: i64,
+    pub records: i64,
+    pub original_size: i64,
+    pub compressed_size: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct FailedFile {
+    pub source: String,
+    pub reason: String,
+}
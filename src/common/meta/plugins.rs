@@ -0,0 +1,218 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{fmt, net::IpAddr};
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Separator of the plugin and the function in the name of a plugin query
+/// function, e.g. `geo/enrich`.
+pub const FUNCTION_SEPARATOR: char = '/';
+
+/// Plugin registered by the root user, shared by all the organizations. The
+/// plugin is a gRPC server implementing `openobserve.plugin.v1.PluginService`.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct Plugin {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    /// gRPC endpoint of the sidecar, e.g. `http://localhost:50051`. The
+    /// configs of the targets, with their secrets resolved, are sent to the
+    /// plugin, so only a sidecar on the same host can be reached over plain
+    /// http, the others need https.
+    pub endpoint: String,
+    /// Seconds to wait for a call
+    #[serde(default = "default_timeout")]
+    pub timeout: u64,
+    /// Calls in flight to the plugin from one node, the extra calls fail
+    /// instead of queueing behind a slow plugin
+    #[serde(default = "default_max_concurrency")]
+    pub max_concurrency: usize,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    /// Capabilities declared by the plugin when it was registered
+    #[serde(default)]
+    pub capabilities: PluginCapabilities,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+fn default_timeout() -> u64 {
+    10
+}
+
+fn default_max_concurrency() -> usize {
+    16
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct PluginCapabilities {
+    #[serde(default)]
+    pub version: String,
+    /// Can be used by log pullers
+    #[serde(default)]
+    pub source: bool,
+    /// Can be used by alert destinations
+    #[serde(default)]
+    pub destination: bool,
+    /// Query functions, called as `<plugin>/<function>`
+    #[serde(default)]
+    pub functions: Vec<String>,
+}
+
+impl Plugin {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty()
+            || self.name.contains(FUNCTION_SEPARATOR)
+            || self.name.contains(char::is_whitespace)
+        {
+            return Err("Invalid plugin name".to_string());
+        }
+        match self.endpoint.split_once("://") {
+            Some(("https", host)) if !host.is_empty() => {}
+            Some(("http", host)) if is_loopback(host) => {}
+            Some(("http", _)) => {
+                return Err(
+                    "Plugin endpoint should be an https:// url, http:// is only allowed for \
+                     localhost"
+                        .to_string(),
+                );
+            }
+            _ => return Err("Plugin endpoint should be an https:// url".to_string()),
+        }
+        if self.timeout == 0 {
+            return Err("Plugin timeout should be at least 1 second".to_string());
+        }
+        if self.max_concurrency == 0 {
+            return Err("Plugin max_concurrency should be at least 1".to_string());
+        }
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct PluginList {
+    pub list: Vec<PluginWithStatus>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct PluginWithStatus {
+    #[serde(flatten)]
+    pub plugin: Plugin,
+    pub status: PluginStatus,
+}
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum PluginState {
+    /// Not checked yet by this node
+    #[default]
+    Unknown,
+    Healthy,
+    /// Failed too many calls in a row, calls fail fast until `suspended_until`
+    Suspended,
+    Disabled,
+}
+
+impl fmt::Display for PluginState {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            PluginState::Unknown => write!(f, "unknown"),
+            PluginState::Healthy => write!(f, "healthy"),
+            PluginState::Suspended => write!(f, "suspended"),
+            PluginState::Disabled => write!(f, "disabled"),
+        }
+    }
+}
+
+/// Health of a plugin as seen by the node answering the request
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct PluginStatus {
+    pub state: PluginState,
+    #[serde(default)]
+    pub consecutive_failures: u32,
+    /// unix timestamp in microseconds
+    #[serde(default)]
+    pub suspended_until: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_error: Option<String>,
+    /// unix timestamp in microseconds
+    #[serde(default)]
+    pub last_checked_at: i64,
+}
+
+/// Settings of a log puller or an alert destination backed by a plugin
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct PluginTarget {
+    pub plugin: String,
+    /// Passed to the plugin as is, can reference secrets
+    #[serde(default)]
+    #[schema(value_type = Object)]
+    pub config: json::Value,
+}
+
+/// Returns true when the authority of an url, `host[:port][/path]`, is the
+/// local host.
+fn is_loopback(authority: &str) -> bool {
+    let authority = authority.split('/').next().unwrap_or_default();
+    let host = match authority.strip_prefix('[') {
+        Some(v6) => v6.split(']').next().unwrap_or_default(),
+        None => authority.split(':').next().unwrap_or_default(),
+    };
+    host.eq_ignore_ascii_case("localhost")
+        || host.parse::<IpAddr>().is_ok_and(|ip| ip.is_loopback())
+}
+
+/// Splits a query function name into the plugin and the function.
+pub fn parse_function_name(name: &str) -> Option<(&str, &str)> {
+    let (plugin, function) = name.trim().split_once(FUNCTION_SEPARATOR)?;
+    if plugin.is_empty() || function.is_empty() {
+        return None;
+    }
+    Some((plugin, function))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_validate_endpoint() {
+        let plugin = |endpoint: &str| Plugin {
+            name: "geo".to_string(),
+            endpoint: endpoint.to_string(),
+            timeout: 1,
+            max_concurrency: 1,
+            ..Default::default()
+        };
+        assert!(plugin("https://geo.plugins.svc:50051").validate().is_ok());
+        assert!(plugin("http://localhost:50051").validate().is_ok());
+        assert!(plugin("http://127.0.0.1:50051").validate().is_ok());
+        assert!(plugin("http://[::1]:50051").validate().is_ok());
+        assert!(plugin("http://geo.plugins.svc:50051").validate().is_err());
+        assert!(plugin("http://localhost.example.com").validate().is_err());
+        assert!(plugin("grpc://localhost:50051").validate().is_err());
+        assert!(plugin("https://").validate().is_err());
+    }
+}
@@ -93,12 +93,15 @@ impl From<&str> for MetricType {
     }
 }
 
-#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, ToSchema)]
 pub struct Metadata {
     pub metric_type: MetricType,
     pub metric_family_name: String,
     pub help: String,
     pub unit: String,
+    /// Unit reported by the source, when `unit` was normalized on ingestion
+    #[serde(default, skip_serializing_if = "String::is_empty")]
+    pub original_unit: String,
 }
 
 #[derive(Debug, Serialize)]
@@ -142,6 +145,30 @@ pub struct RequestMetadata {
     /// A metric name to filter metadata for. All metric metadata is retrieved
     /// if left empty.
     pub metric: Option<String>,
+    /// Case insensitive text to look for in the metric names and descriptions.
+    pub search: Option<String>,
+    /// Only return metrics of this type, e.g. `counter`.
+    #[serde(rename = "type")]
+    pub metric_type: Option<String>,
+}
+
+impl RequestMetadata {
+    /// Checks the metric matches the `search` and `type` filters of the request.
+    pub fn matches(&self, metric_name: &str, md: &MetadataObject) -> bool {
+        if let Some(typ) = self.metric_type.as_deref() {
+            if !typ.is_empty() && !md.typ.eq_ignore_ascii_case(typ) {
+                return false;
+            }
+        }
+        match self.search.as_deref() {
+            Some(search) if !search.is_empty() => {
+                let search = search.to_lowercase();
+                metric_name.to_lowercase().contains(&search)
+                    || md.help.to_lowercase().contains(&search)
+            }
+            _ => true,
+        }
+    }
 }
 
 // key - metric name
@@ -153,6 +180,8 @@ pub struct MetadataObject {
     typ: String, // counter, gauge, histogram, summary
     help: String,
     unit: String,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    original_unit: String,
 }
 
 impl From<Metadata> for MetadataObject {
@@ -161,6 +190,7 @@ impl From<Metadata> for MetadataObject {
             typ: md.metric_type.to_string(),
             help: md.help,
             unit: md.unit,
+            original_unit: md.original_unit,
         }
     }
 }
@@ -217,6 +247,56 @@ pub struct RequestFormatQuery {
     pub query: String,
 }
 
+/// Number of distinct values of a label in a metric stream
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct LabelCardinality {
+    pub name: String,
+    pub values: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct CardinalityResponse {
+    pub stream_name: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    /// distinct series in the time range
+    pub series: u64,
+    /// labels sorted by descending cardinality
+    pub labels: Vec<LabelCardinality>,
+}
+
+/// A label value and the number of series carrying it
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct LabelValueCount {
+    pub value: String,
+    pub series: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct LabelValuesResponse {
+    pub stream_name: String,
+    pub label: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    /// values sorted by descending series count
+    pub values: Vec<LabelValueCount>,
+}
+
+/// A metric stream and the number of its series
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct StreamSeriesCount {
+    pub stream_name: String,
+    pub series: u64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize, ToSchema)]
+pub struct TopStreamsResponse {
+    pub start_time: i64,
+    pub end_time: i64,
+    /// streams sorted by descending series count
+    pub streams: Vec<StreamSeriesCount>,
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -233,4 +313,27 @@ mod tests {
         assert_eq!(format!("{}", MetricType::Unknown), "unknown");
         assert_eq!(MetricType::Unknown.to_string(), "unknown");
     }
+
+    #[test]
+    fn test_request_metadata_matches() {
+        let md: MetadataObject = Metadata {
+            metric_type: MetricType::Counter,
+            metric_family_name: "http_requests_total".to_string(),
+            help: "Number of HTTP requests".to_string(),
+            unit: "".to_string(),
+            original_unit: "".to_string(),
+        }
+        .into();
+        let req = |search: Option<&str>, typ: Option<&str>| RequestMetadata {
+            limit: None,
+            metric: None,
+            search: search.map(|v| v.to_string()),
+            metric_type: typ.map(|v| v.to_string()),
+        };
+        assert!(req(None, None).matches("http_requests_total", &md));
+        assert!(req(Some("REQUESTS"), None).matches("http_requests_total", &md));
+        assert!(req(Some("number of http"), Some("counter")).matches("http_requests_total", &md));
+        assert!(!req(Some("latency"), None).matches("http_requests_total", &md));
+        assert!(!req(None, Some("gauge")).matches("http_requests_total", &md));
+    }
 }
@@ -0,0 +1,84 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum QueryLanguage {
+    #[default]
+    Sql,
+    Promql,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct QueryAnalyzeRequest {
+    pub query: String,
+    #[serde(default)]
+    pub query_type: QueryLanguage,
+    /// microseconds
+    #[serde(default)]
+    pub start_time: i64,
+    /// microseconds
+    #[serde(default)]
+    pub end_time: i64,
+    /// evaluation step of range PromQL queries, seconds
+    #[serde(default)]
+    pub step: i64,
+}
+
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct LintWarning {
+    pub rule: String,
+    pub message: String,
+}
+
+impl LintWarning {
+    pub fn new(rule: &str, message: impl ToString) -> Self {
+        Self {
+            rule: rule.to_string(),
+            message: message.to_string(),
+        }
+    }
+}
+
+/// Estimated data read from a stream
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct StreamCost {
+    pub stream_name: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    pub files: usize,
+    pub records: i64,
+    pub compressed_size: i64,
+    /// only estimated for metric streams, based on the default scrape interval
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub series: Option<i64>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct QueryAnalyzeResponse {
+    pub files: usize,
+    pub records: i64,
+    pub compressed_size: i64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub series: Option<i64>,
+    /// PromQL evaluation points, series * steps
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub samples_evaluated: Option<i64>,
+    pub streams: Vec<StreamCost>,
+    pub warnings: Vec<LintWarning>,
+}
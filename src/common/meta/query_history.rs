@@ -0,0 +1,77 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+
+/// Stream of every organization keeping the searches run by its users
+pub const QUERY_HISTORY_STREAM: &str = "query_history";
+
+/// One search of a user with its execution stats, as written to the
+/// [QUERY_HISTORY_STREAM]
+#[derive(Clone, Debug, Default, Serialize, Deserialize, PartialEq)]
+pub struct QueryHistoryRecord {
+    #[serde(rename = "_timestamp")]
+    pub timestamp: i64,
+    pub user_email: String,
+    pub stream_type: String,
+    pub stream_name: String,
+    pub sql: String,
+    /// Searched time range, in microseconds
+    pub start_time: i64,
+    pub end_time: i64,
+    /// What ran the search: ui, dashboards, reports, alerts, ...
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub search_type: String,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub dashboard_id: Option<String>,
+    /// Milliseconds
+    pub took: usize,
+    pub hits: usize,
+    /// MB
+    pub scan_size: usize,
+    pub scan_records: usize,
+    /// Percentage of the result served by the result cache
+    pub cached_ratio: usize,
+    pub trace_id: String,
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    #[test]
+    fn test_record_fields() {
+        let record = QueryHistoryRecord {
+            timestamp: 1_700_000_000_000_000,
+            user_email: "alice@example.com".to_string(),
+            stream_type: "logs".to_string(),
+            stream_name: "default".to_string(),
+            sql: "SELECT * FROM \"default\"".to_string(),
+            ..Default::default()
+        };
+        let value = json::to_value(&record).unwrap();
+        assert_eq!(value["_timestamp"], json::json!(1_700_000_000_000_000i64));
+        assert!(value.get("search_type").is_none());
+        assert!(value.get("dashboard_id").is_none());
+        assert_eq!(
+            json::from_value::<QueryHistoryRecord>(value).unwrap(),
+            record
+        );
+    }
+}
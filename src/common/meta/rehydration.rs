@@ -0,0 +1,138 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+pub const DEFAULT_TTL_HOURS: i64 = 24;
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct RehydrationRequest {
+    /// Archived stream to read the records from
+    pub source_stream: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Temporary stream to write the records to, generated when empty
+    #[serde(default)]
+    pub target_stream: String,
+    /// Start of the time range in microseconds
+    pub start_time: i64,
+    /// End of the time range in microseconds
+    pub end_time: i64,
+    /// SQL predicate used as the `WHERE` clause, e.g. `level = 'error'`
+    #[serde(default)]
+    pub filter: String,
+    /// Hours after which the temporary stream is deleted
+    #[serde(default = "default_ttl_hours")]
+    pub ttl_hours: i64,
+}
+
+fn default_ttl_hours() -> i64 {
+    DEFAULT_TTL_HOURS
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum RehydrationStatus {
+    #[default]
+    Pending,
+    Running,
+    Completed,
+    Failed,
+    Expired,
+}
+
+impl std::fmt::Display for RehydrationStatus {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            RehydrationStatus::Pending => write!(f, "pending"),
+            RehydrationStatus::Running => write!(f, "running"),
+            RehydrationStatus::Completed => write!(f, "completed"),
+            RehydrationStatus::Failed => write!(f, "failed"),
+            RehydrationStatus::Expired => write!(f, "expired"),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct RehydrationJob {
+    pub id: String,
+    pub org_id: String,
+    pub source_stream: String,
+    pub stream_type: StreamType,
+    pub target_stream: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    #[serde(default)]
+    pub filter: String,
+    pub status: RehydrationStatus,
+    /// Records copied so far, a job taken over from a dead node resumes after
+    /// them
+    #[serde(default)]
+    pub records: i64,
+    #[serde(default)]
+    pub error: String,
+    /// Node which is running the job
+    #[serde(default)]
+    pub node: String,
+    #[serde(default)]
+    pub created_by: String,
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+    /// The temporary stream is deleted after this time, in microseconds
+    pub expires_at: i64,
+}
+
+impl RehydrationJob {
+    /// Returns the SQL used to read the records from the source stream
+    pub fn sql(&self) -> String {
+        let filter = self.filter.trim();
+        if filter.is_empty() {
+            format!("SELECT * FROM \"{}\"", self.source_stream)
+        } else {
+            format!("SELECT * FROM \"{}\" WHERE {}", self.source_stream, filter)
+        }
+    }
+
+    pub fn is_expired(&self, now: i64) -> bool {
+        self.expires_at > 0 && self.expires_at <= now
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct RehydrationJobList {
+    pub list: Vec<RehydrationJob>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rehydration_job_sql() {
+        let mut job = RehydrationJob {
+            source_stream: "archive".to_string(),
+            expires_at: 10,
+            ..Default::default()
+        };
+        assert_eq!(job.sql(), "SELECT * FROM \"archive\"");
+        job.filter = " level = 'error' ".to_string();
+        assert_eq!(job.sql(), "SELECT * FROM \"archive\" WHERE level = 'error'");
+        assert!(!job.is_expired(9));
+        assert!(job.is_expired(10));
+    }
+}
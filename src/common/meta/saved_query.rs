@@ -0,0 +1,338 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, fmt};
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Prefix of the parameters in the SQL of a saved query, e.g. `:service`
+pub const PARAMETER_PREFIX: char = ':';
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum ParameterType {
+    /// Substituted as a quoted SQL string
+    #[default]
+    String,
+    Number,
+    Boolean,
+}
+
+impl fmt::Display for ParameterType {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            ParameterType::String => write!(f, "string"),
+            ParameterType::Number => write!(f, "number"),
+            ParameterType::Boolean => write!(f, "boolean"),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct QueryParameter {
+    /// Name used in the SQL after a colon, e.g. `service` for `:service`
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    #[serde(rename = "type")]
+    pub param_type: ParameterType,
+    /// Used when the parameter is not given, the parameter is required
+    /// without a default
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    #[schema(value_type = Option<Object>)]
+    pub default: Option<json::Value>,
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum QuerySharing {
+    /// Only the owner can see and run the query
+    #[default]
+    Private,
+    /// Every member of the organization can see and run the query
+    Org,
+}
+
+/// Named SQL query kept in the library of an organization, run by name with
+/// its parameters.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct SavedQuery {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    pub tags: Vec<String>,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// SQL with the parameters as `:name`
+    pub query: String,
+    #[serde(default)]
+    pub parameters: Vec<QueryParameter>,
+    /// Minutes searched when the time range is not given on run
+    #[serde(default = "default_period")]
+    pub period: i64,
+    #[serde(default)]
+    pub sharing: QuerySharing,
+    /// Folder the query is filed in, its permissions also apply to the query
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub folder_id: String,
+    /// Only the owner and the admins can change or delete the query
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+    #[serde(default)]
+    pub updated_by: String,
+}
+
+fn default_period() -> i64 {
+    15
+}
+
+impl SavedQuery {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid saved query name".to_string());
+        }
+        if self.query.trim().is_empty() {
+            return Err("Query is required".to_string());
+        }
+        if self.period <= 0 {
+            return Err("Period should be at least 1 minute".to_string());
+        }
+        let mut names = Vec::with_capacity(self.parameters.len());
+        for param in self.parameters.iter() {
+            if param.name.is_empty() || !param.name.chars().all(is_parameter_char) {
+                return Err(format!("Invalid parameter name {}", param.name));
+            }
+            if names.contains(&param.name.as_str()) {
+                return Err(format!("Parameter {} is declared twice", param.name));
+            }
+            if let Some(value) = param.default.as_ref() {
+                render_value(param, value)?;
+            }
+            names.push(param.name.as_str());
+        }
+        for (_, _, name) in placeholders(&self.query) {
+            if !names.contains(&name) {
+                return Err(format!("Parameter :{name} is not declared"));
+            }
+        }
+        Ok(())
+    }
+
+    /// Returns the SQL with the parameters replaced by the given values or
+    /// their defaults.
+    pub fn render(&self, values: &HashMap<String, json::Value>) -> Result<String, String> {
+        if let Some(name) = values
+            .keys()
+            .find(|name| !self.parameters.iter().any(|p| &p.name == *name))
+        {
+            return Err(format!("Unknown parameter {name}"));
+        }
+        let mut sql = String::with_capacity(self.query.len());
+        let mut last = 0;
+        for (start, end, name) in placeholders(&self.query) {
+            let Some(param) = self.parameters.iter().find(|p| p.name == name) else {
+                return Err(format!("Parameter :{name} is not declared"));
+            };
+            let Some(value) = values.get(name).or(param.default.as_ref()) else {
+                return Err(format!("Parameter {name} is required"));
+            };
+            sql.push_str(&self.query[last..start]);
+            sql.push_str(&render_value(param, value)?);
+            last = end;
+        }
+        sql.push_str(&self.query[last..]);
+        Ok(sql)
+    }
+}
+
+fn is_parameter_char(c: char) -> bool {
+    c.is_ascii_alphanumeric() || c == '_'
+}
+
+/// Returns the byte range and the name of every `:name` of the SQL, skipping
+/// the string literals, the quoted identifiers and the `::` casts.
+fn placeholders(sql: &str) -> Vec<(usize, usize, &str)> {
+    let mut items = Vec::new();
+    let bytes = sql.as_bytes();
+    let mut quote = None;
+    let mut i = 0;
+    while i < bytes.len() {
+        let c = bytes[i];
+        match quote {
+            Some(q) if c == q => quote = None,
+            Some(_) => {}
+            None if c == b'\'' || c == b'"' => quote = Some(c),
+            None if c == PARAMETER_PREFIX as u8 => {
+                if bytes.get(i + 1) == Some(&(PARAMETER_PREFIX as u8)) {
+                    // cast, skip both colons
+                    i += 2;
+                    continue;
+                }
+                let end = sql[i + 1..]
+                    .find(|c| !is_parameter_char(c))
+                    .map_or(sql.len(), |n| i + 1 + n);
+                if end > i + 1 {
+                    items.push((i, end, &sql[i + 1..end]));
+                    i = end;
+                    continue;
+                }
+            }
+            None => {}
+        }
+        i += 1;
+    }
+    items
+}
+
+/// Renders the value as a SQL literal of the type of the parameter
+fn render_value(param: &QueryParameter, value: &json::Value) -> Result<String, String> {
+    let invalid = || format!("Parameter {} should be a {}", param.name, param.param_type);
+    match param.param_type {
+        ParameterType::String => match value {
+            json::Value::String(v) => Ok(format!("'{}'", v.replace('\'', "''"))),
+            json::Value::Number(v) => Ok(format!("'{v}'")),
+            _ => Err(invalid()),
+        },
+        ParameterType::Number => match value {
+            json::Value::Number(v) => Ok(v.to_string()),
+            json::Value::String(v) => v
+                .trim()
+                .parse::<f64>()
+                .ok()
+                .filter(|v| v.is_finite())
+                .map(|_| v.trim().to_string())
+                .ok_or_else(invalid),
+            _ => Err(invalid()),
+        },
+        ParameterType::Boolean => match value {
+            json::Value::Bool(v) => Ok(v.to_string()),
+            json::Value::String(v) => v
+                .trim()
+                .parse::<bool>()
+                .map(|v| v.to_string())
+                .map_err(|_| invalid()),
+            _ => Err(invalid()),
+        },
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SavedQueryList {
+    pub list: Vec<SavedQuery>,
+}
+
+/// Parameters and time range of a run of a saved query
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct RunSavedQueryRequest {
+    #[serde(default)]
+    #[schema(value_type = Object)]
+    pub params: HashMap<String, json::Value>,
+    /// unix timestamp in microseconds, the last `period` minutes when not
+    /// given
+    #[serde(default)]
+    pub start_time: i64,
+    #[serde(default)]
+    pub end_time: i64,
+    #[serde(default)]
+    pub from: i64,
+    #[serde(default = "default_size")]
+    pub size: i64,
+}
+
+fn default_size() -> i64 {
+    100
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn errors_query() -> SavedQuery {
+        SavedQuery {
+            name: "service_errors".to_string(),
+            query: "SELECT * FROM \"default\" WHERE service = :service AND code >= :threshold \
+                    AND msg != 'a:b' AND took::int > 0"
+                .to_string(),
+            parameters: vec![
+                QueryParameter {
+                    name: "service".to_string(),
+                    ..Default::default()
+                },
+                QueryParameter {
+                    name: "threshold".to_string(),
+                    param_type: ParameterType::Number,
+                    default: Some(json::json!(500)),
+                    ..Default::default()
+                },
+            ],
+            period: 15,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_placeholders() {
+        let query = errors_query();
+        let names: Vec<&str> = placeholders(&query.query)
+            .into_iter()
+            .map(|(_, _, name)| name)
+            .collect();
+        assert_eq!(names, vec!["service", "threshold"]);
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut query = errors_query();
+        assert!(query.validate().is_ok());
+        query.parameters.pop();
+        assert!(query.validate().is_err());
+        let mut query = errors_query();
+        query.parameters[1].default = Some(json::json!("high"));
+        assert!(query.validate().is_err());
+    }
+
+    #[test]
+    fn test_render() {
+        let query = errors_query();
+        let values = HashMap::from([("service".to_string(), json::json!("o'brien"))]);
+        assert_eq!(
+            query.render(&values).unwrap(),
+            "SELECT * FROM \"default\" WHERE service = 'o''brien' AND code >= 500 \
+             AND msg != 'a:b' AND took::int > 0"
+        );
+        // required parameter
+        assert!(query.render(&HashMap::new()).is_err());
+        // unknown parameter
+        let mut values = values.clone();
+        values.insert("other".to_string(), json::json!(1));
+        assert!(query.render(&values).is_err());
+        // numbers are checked instead of quoted
+        let values = HashMap::from([
+            ("service".to_string(), json::json!("api")),
+            ("threshold".to_string(), json::json!("1 OR 1=1")),
+        ]);
+        assert!(query.render(&values).is_err());
+    }
+}
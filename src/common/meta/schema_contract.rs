@@ -0,0 +1,307 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Expected type of a field in a schema contract
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum ContractFieldType {
+    #[default]
+    Any,
+    String,
+    Int,
+    Float,
+    Bool,
+}
+
+impl ContractFieldType {
+    pub fn matches(&self, value: &json::Value) -> bool {
+        match self {
+            ContractFieldType::Any => true,
+            ContractFieldType::String => value.is_string(),
+            ContractFieldType::Int => value.is_i64() || value.is_u64(),
+            ContractFieldType::Float => value.is_number(),
+            ContractFieldType::Bool => value.is_boolean(),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ContractField {
+    pub name: String,
+    #[serde(rename = "type")]
+    #[serde(default)]
+    pub field_type: ContractFieldType,
+    #[serde(default)]
+    pub required: bool,
+}
+
+/// What the ingester does with a record which violates the contract
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum ContractEnforcement {
+    /// Ingest the record and only record the violation in the producer report
+    #[default]
+    Report,
+    /// Reject the record and record the violation in the producer report
+    Reject,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct SchemaContract {
+    #[serde(default)]
+    pub stream_name: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    #[serde(default)]
+    pub description: String,
+    pub fields: Vec<ContractField>,
+    /// Whether fields which are not declared in the contract are accepted
+    #[serde(default = "default_allow_extra_fields")]
+    pub allow_extra_fields: bool,
+    #[serde(default)]
+    pub enforcement: ContractEnforcement,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+fn default_allow_extra_fields() -> bool {
+    true
+}
+
+#[derive(Clone, Copy, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum ViolationKind {
+    MissingField,
+    TypeMismatch,
+    UnexpectedField,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+pub struct Violation {
+    pub kind: ViolationKind,
+    pub field: String,
+}
+
+impl std::fmt::Display for Violation {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self.kind {
+            ViolationKind::MissingField => write!(f, "missing required field [{}]", self.field),
+            ViolationKind::TypeMismatch => write!(f, "field [{}] has unexpected type", self.field),
+            ViolationKind::UnexpectedField => {
+                write!(f, "field [{}] is not declared in contract", self.field)
+            }
+        }
+    }
+}
+
+impl SchemaContract {
+    /// Validates a flattened record against the contract, the timestamp column
+    /// is always accepted.
+    pub fn validate(
+        &self,
+        record: &json::Map<String, json::Value>,
+        timestamp_col: &str,
+    ) -> Vec<Violation> {
+        let mut violations = Vec::new();
+        for field in self.fields.iter() {
+            match record.get(&field.name) {
+                None | Some(json::Value::Null) => {
+                    if field.required {
+                        violations.push(Violation {
+                            kind: ViolationKind::MissingField,
+                            field: field.name.clone(),
+                        });
+                    }
+                }
+                Some(v) => {
+                    if !field.field_type.matches(v) {
+                        violations.push(Violation {
+                            kind: ViolationKind::TypeMismatch,
+                            field: field.name.clone(),
+                        });
+                    }
+                }
+            }
+        }
+        if !self.allow_extra_fields {
+            for key in record.keys() {
+                if key == timestamp_col || self.fields.iter().any(|f| f.name.eq(key)) {
+                    continue;
+                }
+                violations.push(Violation {
+                    kind: ViolationKind::UnexpectedField,
+                    field: key.to_string(),
+                });
+            }
+        }
+        violations
+    }
+}
+
+/// Validation stats of a single producer (the user or token used for ingestion)
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ProducerReport {
+    pub producer: String,
+    pub records: i64,
+    pub violated_records: i64,
+    pub rejected_records: i64,
+    pub missing_field: i64,
+    pub type_mismatch: i64,
+    pub unexpected_field: i64,
+    /// Fields involved in violations with their occurrence count
+    #[serde(default)]
+    pub fields: HashMap<String, i64>,
+    #[serde(default)]
+    pub last_violation: String,
+    #[serde(default)]
+    pub last_violation_at: i64,
+    pub last_seen_at: i64,
+}
+
+impl ProducerReport {
+    pub fn new(producer: &str) -> Self {
+        Self {
+            producer: producer.to_string(),
+            ..Default::default()
+        }
+    }
+
+    pub fn add(&mut self, violations: &[Violation], rejected: bool, ts: i64) {
+        self.records += 1;
+        self.last_seen_at = ts;
+        if violations.is_empty() {
+            return;
+        }
+        self.violated_records += 1;
+        if rejected {
+            self.rejected_records += 1;
+        }
+        for v in violations.iter() {
+            match v.kind {
+                ViolationKind::MissingField => self.missing_field += 1,
+                ViolationKind::TypeMismatch => self.type_mismatch += 1,
+                ViolationKind::UnexpectedField => self.unexpected_field += 1,
+            }
+            *self.fields.entry(v.field.clone()).or_default() += 1;
+        }
+        self.last_violation = violations[0].to_string();
+        self.last_violation_at = ts;
+    }
+
+    pub fn merge(&mut self, other: &ProducerReport) {
+        self.records += other.records;
+        self.violated_records += other.violated_records;
+        self.rejected_records += other.rejected_records;
+        self.missing_field += other.missing_field;
+        self.type_mismatch += other.type_mismatch;
+        self.unexpected_field += other.unexpected_field;
+        for (field, count) in other.fields.iter() {
+            *self.fields.entry(field.clone()).or_default() += count;
+        }
+        if other.last_violation_at > self.last_violation_at {
+            self.last_violation = other.last_violation.clone();
+            self.last_violation_at = other.last_violation_at;
+        }
+        self.last_seen_at = std::cmp::max(self.last_seen_at, other.last_seen_at);
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ContractReport {
+    pub stream_name: String,
+    pub stream_type: StreamType,
+    pub producers: Vec<ProducerReport>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn contract() -> SchemaContract {
+        SchemaContract {
+            stream_name: "default".to_string(),
+            stream_type: StreamType::Logs,
+            description: "".to_string(),
+            fields: vec![
+                ContractField {
+                    name: "service".to_string(),
+                    field_type: ContractFieldType::String,
+                    required: true,
+                },
+                ContractField {
+                    name: "latency".to_string(),
+                    field_type: ContractFieldType::Float,
+                    required: false,
+                },
+            ],
+            allow_extra_fields: false,
+            enforcement: ContractEnforcement::Report,
+            updated_at: 0,
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        let c = contract();
+        let record = json::json!({"_timestamp": 1, "service": "api", "latency": 12});
+        assert!(c
+            .validate(record.as_object().unwrap(), "_timestamp")
+            .is_empty());
+
+        let record = json::json!({"_timestamp": 1, "latency": "slow", "host": "a"});
+        let violations = c.validate(record.as_object().unwrap(), "_timestamp");
+        assert_eq!(violations.len(), 3);
+        assert_eq!(violations[0].kind, ViolationKind::MissingField);
+        assert_eq!(violations[1].kind, ViolationKind::TypeMismatch);
+        assert_eq!(violations[2].kind, ViolationKind::UnexpectedField);
+        assert_eq!(violations[2].field, "host");
+    }
+
+    #[test]
+    fn test_producer_report_merge() {
+        let mut a = ProducerReport::new("a@example.com");
+        a.add(&[], false, 10);
+        a.add(
+            &[Violation {
+                kind: ViolationKind::MissingField,
+                field: "service".to_string(),
+            }],
+            true,
+            20,
+        );
+        let mut b = ProducerReport::new("a@example.com");
+        b.add(
+            &[Violation {
+                kind: ViolationKind::TypeMismatch,
+                field: "service".to_string(),
+            }],
+            false,
+            30,
+        );
+        a.merge(&b);
+        assert_eq!(a.records, 3);
+        assert_eq!(a.violated_records, 2);
+        assert_eq!(a.rejected_records, 1);
+        assert_eq!(a.fields.get("service"), Some(&2));
+        assert_eq!(a.last_violation_at, 30);
+        assert_eq!(a.last_seen_at, 30);
+    }
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Pod annotations used to opt in the pods discovered in kubernetes
+pub const ANNOTATION_SCRAPE: &str = "prometheus.io/scrape";
+pub const ANNOTATION_PORT: &str = "prometheus.io/port";
+pub const ANNOTATION_PATH: &str = "prometheus.io/path";
+pub const ANNOTATION_SCHEME: &str = "prometheus.io/scheme";
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct KubernetesDiscovery {
+    /// Namespace of the pods, all the namespaces when empty
+    #[serde(default)]
+    pub namespace: String,
+    /// Label selector of the pods, e.g. `app=api`
+    #[serde(default)]
+    pub label_selector: String,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ScrapeConfig {
+    /// Name of the scrape job, added to the series as the `job` label
+    pub name: String,
+    /// Targets as `host:port`
+    #[serde(default)]
+    pub static_targets: Vec<String>,
+    /// Discovers the pods annotated with `prometheus.io/scrape: "true"`
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub kubernetes: Option<KubernetesDiscovery>,
+    #[serde(default = "default_metrics_path")]
+    pub metrics_path: String,
+    #[serde(default = "default_scheme")]
+    pub scheme: String,
+    /// Seconds between two scrapes of a target
+    #[serde(default = "default_interval")]
+    pub interval: u64,
+    /// Seconds to wait for a target to answer
+    #[serde(default = "default_timeout")]
+    pub timeout: u64,
+    /// Labels added to all the series of the job
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+}
+
+fn default_metrics_path() -> String {
+    "/metrics".to_string()
+}
+
+fn default_scheme() -> String {
+    "http".to_string()
+}
+
+fn default_interval() -> u64 {
+    30
+}
+
+fn default_timeout() -> u64 {
+    10
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+impl ScrapeConfig {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid scrape job name".to_string());
+        }
+        if self.static_targets.is_empty() && self.kubernetes.is_none() {
+            return Err("Either static targets or kubernetes discovery is required".to_string());
+        }
+        if self.static_targets.iter().any(|t| t.contains('/')) {
+            return Err("Static targets should be host:port".to_string());
+        }
+        if self.scheme != "http" && self.scheme != "https" {
+            return Err("Scheme should be http or https".to_string());
+        }
+        if !self.metrics_path.starts_with('/') {
+            return Err("Metrics path should start with /".to_string());
+        }
+        if self.interval == 0 || self.timeout == 0 || self.timeout > self.interval {
+            return Err("Timeout should be between 1 second and the interval".to_string());
+        }
+        if let Some(sd) = self.kubernetes.as_ref() {
+            if !sd.namespace.is_empty() && !is_dns_label(&sd.namespace) {
+                return Err("Kubernetes namespace should be a DNS label".to_string());
+            }
+        }
+        Ok(())
+    }
+}
+
+/// Returns true for a RFC 1123 label, the format of the kubernetes namespaces
+pub fn is_dns_label(v: &str) -> bool {
+    !v.is_empty()
+        && v.len() <= 63
+        && v.bytes()
+            .all(|b| b.is_ascii_lowercase() || b.is_ascii_digit() || b == b'-')
+        && !v.starts_with('-')
+        && !v.ends_with('-')
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ScrapeConfigList {
+    pub list: Vec<ScrapeConfig>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ScrapeTarget {
+    /// `host:port` of the target, added to the series as the `instance` label
+    pub instance: String,
+    pub url: String,
+    /// Labels of the discovered target, e.g. namespace and pod
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+    /// Ingester scraping the target
+    #[serde(default)]
+    pub node: String,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ScrapeTargetList {
+    pub list: Vec<ScrapeTarget>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_validate() {
+        let mut cfg: ScrapeConfig =
+            config::utils::json::from_str(r#"{"name":"node","static_targets":["node:9100"]}"#)
+                .unwrap();
+        assert_eq!(cfg.metrics_path, "/metrics");
+        assert!(cfg.validate().is_ok());
+        cfg.timeout = 60;
+        assert!(cfg.validate().is_err());
+        cfg.timeout = 10;
+        cfg.static_targets = vec!["http://node:9100/metrics".to_string()];
+        assert!(cfg.validate().is_err());
+        cfg.static_targets = vec![];
+        assert!(cfg.validate().is_err());
+        cfg.kubernetes = Some(KubernetesDiscovery::default());
+        assert!(cfg.validate().is_ok());
+        cfg.kubernetes.as_mut().unwrap().namespace = "prod-1".to_string();
+        assert!(cfg.validate().is_ok());
+        cfg.kubernetes.as_mut().unwrap().namespace = "../../secrets".to_string();
+        assert!(cfg.validate().is_err());
+        cfg.kubernetes.as_mut().unwrap().namespace = "Prod".to_string();
+        assert!(cfg.validate().is_err());
+    }
+}
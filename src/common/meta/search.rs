@@ -13,7 +13,10 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use config::meta::search::Response;
+use config::{
+    meta::search::{Query, Response},
+    utils::json,
+};
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
 
@@ -34,3 +37,74 @@ pub struct QueryDelta {
     pub delta_end_time: i64,
     pub delta_removed_hits: bool,
 }
+
+/// A query run across several organizations
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct FederatedSearchRequest {
+    /// Organizations to query, all the organizations the user can federate
+    /// when empty
+    #[serde(default)]
+    pub orgs: Vec<String>,
+    pub query: Query,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct FederatedSearchResponse {
+    pub took: usize,
+    pub total: usize,
+    /// Hits of all the organizations, with their organization in `_org`
+    #[schema(value_type = Vec<Object>)]
+    pub hits: Vec<json::Value>,
+    /// Whether the query failed in some of the organizations
+    pub is_partial: bool,
+    pub orgs: Vec<FederatedOrgResult>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct FederatedOrgResult {
+    pub org_id: String,
+    pub took: usize,
+    pub total: usize,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    /// Set when the time range was shortened to the max query range of the
+    /// stream in the organization
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub warning: Option<String>,
+}
+
+/// The panels of a dashboard run across several organizations
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct FederatedDashboardRequest {
+    /// Organizations to query, all the organizations the user can federate
+    /// when empty
+    #[serde(default)]
+    pub orgs: Vec<String>,
+    pub start_time: i64,
+    pub end_time: i64,
+    /// Max hits of each panel query, default 100
+    #[serde(default)]
+    pub size: Option<i64>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct FederatedDashboardResponse {
+    pub dashboard_id: String,
+    pub title: String,
+    pub panels: Vec<FederatedPanelResult>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct FederatedPanelResult {
+    pub tab_id: String,
+    pub panel_id: String,
+    pub title: String,
+    /// One result per query of the panel
+    pub results: Vec<FederatedSearchResponse>,
+    /// Why the panel couldn't be federated, its queries are then not run
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
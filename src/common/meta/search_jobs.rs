@@ -0,0 +1,121 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SearchJobRequest {
+    pub sql: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    /// Start of the time range in microseconds
+    pub start_time: i64,
+    /// End of the time range in microseconds
+    pub end_time: i64,
+    #[serde(default = "default_size")]
+    pub size: i64,
+}
+
+fn default_size() -> i64 {
+    100
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "lowercase")]
+pub enum SearchJobStatus {
+    /// Waiting for a querier
+    #[default]
+    Pending,
+    Running,
+    Completed,
+    Failed,
+}
+
+impl std::fmt::Display for SearchJobStatus {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            SearchJobStatus::Pending => write!(f, "pending"),
+            SearchJobStatus::Running => write!(f, "running"),
+            SearchJobStatus::Completed => write!(f, "completed"),
+            SearchJobStatus::Failed => write!(f, "failed"),
+        }
+    }
+}
+
+/// Search running in the background. Its progress is saved after every
+/// partition of the time range, so another querier resumes it when the one
+/// running it leaves the cluster.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct SearchJob {
+    pub id: String,
+    pub org_id: String,
+    pub stream_type: StreamType,
+    pub sql: String,
+    pub start_time: i64,
+    pub end_time: i64,
+    pub size: i64,
+    pub status: SearchJobStatus,
+    /// Querier running the job, empty while it waits for one
+    #[serde(default)]
+    pub node: String,
+    /// Time ranges searched one after the other, newest first
+    #[serde(default)]
+    pub partitions: Vec<[i64; 2]>,
+    /// Partitions already searched
+    #[serde(default)]
+    pub completed_partitions: usize,
+    /// Times the job was taken over from a querier which left the cluster
+    #[serde(default)]
+    pub failovers: usize,
+    /// The hits don't cover the whole time range yet
+    #[serde(default)]
+    pub partial: bool,
+    #[serde(default)]
+    pub total: usize,
+    #[serde(default)]
+    pub scan_size: usize,
+    #[serde(default)]
+    pub scan_records: usize,
+    /// Hits saved so far
+    #[serde(default)]
+    pub hits_count: usize,
+    /// Kept in the object storage, only returned with the job itself
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    #[schema(value_type = Vec<Object>)]
+    pub hits: Vec<json::Value>,
+    #[serde(default)]
+    pub error: String,
+    #[serde(default)]
+    pub created_by: String,
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+impl SearchJob {
+    pub fn is_done(&self) -> bool {
+        matches!(
+            self.status,
+            SearchJobStatus::Completed | SearchJobStatus::Failed
+        )
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SearchJobList {
+    pub list: Vec<SearchJob>,
+}
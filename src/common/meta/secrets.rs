@@ -0,0 +1,181 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Prefix of a reference to a secret in the definition of a destination or an
+/// integration, the full form is `{{secret:<name>}}`.
+pub const SECRET_REF_PREFIX: &str = "{{secret:";
+pub const SECRET_REF_SUFFIX: &str = "}}";
+
+/// Kinds of definitions that can reference a secret.
+pub const SECRET_CONSUMERS: [&str; 3] = ["destinations", "subscriptions", "log_pullers"];
+
+/// Secret as written through the API, the value is never returned.
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SecretRequest {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    pub value: String,
+    /// Kinds of definitions allowed to reference the secret, see
+    /// `SECRET_CONSUMERS`. Empty allows none of them.
+    #[serde(default)]
+    pub consumers: Vec<String>,
+}
+
+/// Secret as stored, the value is sealed with the key of the secrets store.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct Secret {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub sealed_value: String,
+    #[serde(default)]
+    pub consumers: Vec<String>,
+    /// Incremented every time the value is rotated
+    pub version: u32,
+    pub created_at: i64,
+    pub updated_at: i64,
+}
+
+impl Secret {
+    /// Returns true when definitions of the given kind can reference the
+    /// secret.
+    pub fn allows(&self, consumer: &str) -> bool {
+        self.consumers.iter().any(|c| c == consumer)
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SecretList {
+    pub list: Vec<Secret>,
+}
+
+/// Returns the reference to the secret to put in a definition.
+pub fn secret_ref(name: &str) -> String {
+    format!("{SECRET_REF_PREFIX}{name}{SECRET_REF_SUFFIX}")
+}
+
+/// Returns true when the whole value is a reference to a secret.
+pub fn is_secret_ref(value: &str) -> bool {
+    value
+        .trim()
+        .strip_prefix(SECRET_REF_PREFIX)
+        .and_then(|v| v.strip_suffix(SECRET_REF_SUFFIX))
+        .is_some_and(|name| !name.is_empty() && !name.contains(SECRET_REF_SUFFIX))
+}
+
+/// Lists the names of the secrets referenced in the value.
+pub fn referenced_names(value: &str) -> Vec<&str> {
+    let mut names = Vec::new();
+    let mut rest = value;
+    while let Some(start) = rest.find(SECRET_REF_PREFIX) {
+        let after = &rest[start + SECRET_REF_PREFIX.len()..];
+        let Some(end) = after.find(SECRET_REF_SUFFIX) else {
+            break;
+        };
+        let name = after[..end].trim();
+        if !name.is_empty() {
+            names.push(name);
+        }
+        rest = &after[end + SECRET_REF_SUFFIX.len()..];
+    }
+    names
+}
+
+/// Replaces the references in the value with the output of `lookup`, the
+/// first failing lookup is returned as the error.
+pub fn substitute<E>(
+    value: &str,
+    mut lookup: impl FnMut(&str) -> Result<String, E>,
+) -> Result<String, E> {
+    let mut out = String::with_capacity(value.len());
+    let mut rest = value;
+    while let Some(start) = rest.find(SECRET_REF_PREFIX) {
+        let after = &rest[start + SECRET_REF_PREFIX.len()..];
+        let Some(end) = after.find(SECRET_REF_SUFFIX) else {
+            break;
+        };
+        out.push_str(&rest[..start]);
+        let name = after[..end].trim();
+        if name.is_empty() {
+            out.push_str(&rest[start..start + SECRET_REF_PREFIX.len() + end + 2]);
+        } else {
+            out.push_str(&lookup(name)?);
+        }
+        rest = &after[end + SECRET_REF_SUFFIX.len()..];
+    }
+    out.push_str(rest);
+    Ok(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_referenced_names() {
+        assert_eq!(
+            referenced_names("Bearer {{secret:token}} and {{ secret:other }}"),
+            vec!["token"]
+        );
+        assert_eq!(referenced_names("{{secret:a}}{{secret:b}}"), vec!["a", "b"]);
+        assert!(referenced_names("{{secret:unclosed").is_empty());
+        assert!(is_secret_ref(" {{secret:aws_key}} "));
+        assert!(!is_secret_ref("prefix {{secret:aws_key}}"));
+        assert!(!is_secret_ref("{{secret:}}"));
+    }
+
+    #[test]
+    fn test_secret_allows() {
+        let secret = Secret {
+            consumers: vec!["destinations".to_string()],
+            ..Default::default()
+        };
+        assert!(secret.allows("destinations"));
+        assert!(!secret.allows("subscriptions"));
+        assert!(!Secret::default().allows("destinations"));
+    }
+
+    #[test]
+    fn test_substitute() {
+        let lookup = |name: &str| -> Result<String, String> {
+            match name {
+                "token" => Ok("abc".to_string()),
+                _ => Err(format!("secret {name} not found")),
+            }
+        };
+        assert_eq!(
+            substitute("Bearer {{secret:token}}", lookup).unwrap(),
+            "Bearer abc"
+        );
+        assert_eq!(
+            substitute("{{secret:token}}:{{secret:token}}", lookup).unwrap(),
+            "abc:abc"
+        );
+        assert_eq!(substitute("no refs", lookup).unwrap(), "no refs");
+        assert_eq!(
+            substitute("{{secret:}} x", lookup).unwrap(),
+            "{{secret:}} x"
+        );
+        assert_eq!(
+            substitute("{{secret:missing}}", lookup).unwrap_err(),
+            "secret missing not found"
+        );
+    }
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{
+    meta::stream::{StreamSettings, StreamType},
+    utils::json,
+};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::{
+    alerts::{destinations::Destination, templates::Template, Alert},
+    dashboards::Folder,
+    functions::Transform,
+};
+
+/// Version of the snapshot format, a snapshot of another version is rejected
+pub const SNAPSHOT_VERSION: u32 = 1;
+/// Records sampled from each stream
+pub const MAX_SAMPLE_SIZE: i64 = 10_000;
+/// Value of the destination headers masked in the snapshots
+pub const MASKED_VALUE: &str = "<masked>";
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SnapshotRequest {
+    /// Latest records kept from each logs and metrics stream
+    #[serde(default = "default_sample_size")]
+    pub sample_size: i64,
+    /// Streams to keep, all of them when empty
+    #[serde(default)]
+    pub streams: Vec<String>,
+}
+
+impl Default for SnapshotRequest {
+    fn default() -> Self {
+        Self {
+            sample_size: default_sample_size(),
+            streams: vec![],
+        }
+    }
+}
+
+fn default_sample_size() -> i64 {
+    100
+}
+
+/// State of an organization, restored as it is into another one. The traces
+/// streams are kept without sample, their spans can't be ingested back from
+/// the stored records. The values of the destination headers are masked, a
+/// restore keeps the ones of the destination it replaces.
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct Snapshot {
+    pub version: u32,
+    /// Organization the snapshot was taken from
+    pub org_id: String,
+    /// Unix timestamp in microseconds
+    pub created_at: i64,
+    #[serde(default)]
+    pub streams: Vec<StreamSnapshot>,
+    #[serde(default)]
+    pub folders: Vec<Folder>,
+    #[serde(default)]
+    pub dashboards: Vec<DashboardSnapshot>,
+    #[serde(default)]
+    pub templates: Vec<Template>,
+    #[serde(default)]
+    pub destinations: Vec<Destination>,
+    #[serde(default)]
+    pub alerts: Vec<Alert>,
+    #[serde(default)]
+    pub functions: Vec<Transform>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct StreamSnapshot {
+    pub name: String,
+    pub stream_type: StreamType,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub settings: Option<StreamSettings>,
+    /// Latest records of the stream, the oldest first
+    #[serde(default)]
+    #[schema(value_type = Vec<Object>)]
+    pub sample: Vec<json::Value>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct DashboardSnapshot {
+    pub folder_id: String,
+    pub dashboard_id: String,
+    /// Dashboard as stored, of any version
+    #[schema(value_type = Object)]
+    pub data: json::Value,
+}
+
+/// Objects restored, the objects which failed are listed in the errors and do
+/// not stop the restore of the others
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct RestoreResponse {
+    pub streams: usize,
+    pub records: usize,
+    pub folders: usize,
+    pub dashboards: usize,
+    pub templates: usize,
+    pub destinations: usize,
+    pub alerts: usize,
+    pub functions: usize,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub errors: Vec<String>,
+    /// Objects restored with some of their settings left out
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
@@ -99,6 +99,53 @@ pub struct StreamDeleteFields {
     pub fields: Vec<String>,
 }
 
+/// Rewrite of the existing files of a stream so they are sorted by its current
+/// clustering keys, processed by the compactor a partition at a time
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ReclusterJob {
+    pub org_id: String,
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    /// Start of the time range in microseconds
+    pub start_time: i64,
+    /// End of the time range in microseconds
+    pub end_time: i64,
+    /// Start of the next partition to rewrite, in microseconds
+    pub offset: i64,
+    pub created_at: i64,
+}
+
+/// Deletion of whole days of a stream, or all its data when truncating, done
+/// by the compactor on the file list without rewriting any file
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct StreamDeleteJob {
+    pub org_id: String,
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    /// First day deleted, eg: 2023-01-02, not set when truncating
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub start: Option<String>,
+    /// Day the deletion stops before, eg: 2024-01-01, not set when truncating
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub end: Option<String>,
+    pub status: StreamDeleteJobStatus,
+    /// Compactor node processing the job
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub node: Option<String>,
+}
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum StreamDeleteJobStatus {
+    Pending,
+    Running,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ListStreamDeleteJob {
+    pub list: Vec<StreamDeleteJob>,
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
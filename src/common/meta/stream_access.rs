@@ -0,0 +1,176 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Shortest retention the suggestions and the apply can set
+pub const MIN_RETENTION_DAYS: i64 = 3;
+
+/// Searches seen on a stream
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+pub struct StreamAccess {
+    /// unix timestamp in microseconds of the last search
+    pub last_queried_at: i64,
+    pub queries: u64,
+}
+
+impl StreamAccess {
+    pub fn merge(&mut self, other: &StreamAccess) {
+        self.last_queried_at = self.last_queried_at.max(other.last_queried_at);
+        self.queries += other.queries;
+    }
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum ArchivalAction {
+    /// Keep only the data still searched, the last idle days
+    #[default]
+    ShortenRetention,
+    /// Never searched since it was created
+    Delete,
+}
+
+/// Stream not searched in the idle days
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ArchivalSuggestion {
+    pub stream_name: String,
+    pub stream_type: StreamType,
+    /// unix timestamp in microseconds, not set when never searched
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub last_queried_at: Option<i64>,
+    pub queries: u64,
+    /// days since the last search, or since the creation when never searched
+    pub idle_days: i64,
+    /// bytes
+    pub storage_size: f64,
+    /// bytes
+    pub compressed_size: f64,
+    /// retention applied now, the global one when the stream has none
+    pub retention_days: i64,
+    pub suggested_retention_days: i64,
+    pub action: ArchivalAction,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ArchivalSuggestions {
+    pub idle_days: i64,
+    /// compressed bytes of the suggested streams
+    pub reclaimable_size: f64,
+    pub suggestions: Vec<ArchivalSuggestion>,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ArchivalStream {
+    pub stream_name: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+}
+
+/// Retention to set on streams, a stream is only changed when it shortens
+/// its retention
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct ApplyArchivalRequest {
+    pub streams: Vec<ArchivalStream>,
+    pub retention_days: i64,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct ApplyArchivalResponse {
+    pub updated: Vec<String>,
+    pub skipped: Vec<SkippedStream>,
+}
+
+/// Stream left as it is by an apply
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SkippedStream {
+    pub stream_name: String,
+    pub reason: String,
+}
+
+/// Returns the suggestion for a stream idle for `idle_days` days when it
+/// reaches the threshold, None otherwise.
+///
+/// A stream searched before keeps the threshold days of data, the data older
+/// than that was not searched in that time. A stream never searched since it
+/// was created is suggested for deletion.
+pub fn suggest(
+    idle_days: i64,
+    threshold_days: i64,
+    retention_days: i64,
+    never_queried: bool,
+) -> Option<(ArchivalAction, i64)> {
+    if idle_days < threshold_days {
+        return None;
+    }
+    if never_queried {
+        return Some((ArchivalAction::Delete, MIN_RETENTION_DAYS));
+    }
+    let suggested = threshold_days.max(MIN_RETENTION_DAYS);
+    // retention 0 keeps the data forever
+    if retention_days > 0 && retention_days <= suggested {
+        return None;
+    }
+    Some((ArchivalAction::ShortenRetention, suggested))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_merge() {
+        let mut access = StreamAccess {
+            last_queried_at: 10,
+            queries: 2,
+        };
+        access.merge(&StreamAccess {
+            last_queried_at: 5,
+            queries: 3,
+        });
+        assert_eq!(
+            access,
+            StreamAccess {
+                last_queried_at: 10,
+                queries: 5
+            }
+        );
+    }
+
+    #[test]
+    fn test_suggest() {
+        assert_eq!(suggest(10, 30, 90, false), None);
+        assert_eq!(
+            suggest(45, 30, 90, false),
+            Some((ArchivalAction::ShortenRetention, 30))
+        );
+        assert_eq!(
+            suggest(45, 30, 0, false),
+            Some((ArchivalAction::ShortenRetention, 30))
+        );
+        // already at or below the suggested retention
+        assert_eq!(suggest(45, 30, 14, false), None);
+        assert_eq!(
+            suggest(45, 30, 90, true),
+            Some((ArchivalAction::Delete, MIN_RETENTION_DAYS))
+        );
+        assert_eq!(
+            suggest(5, 1, 90, false),
+            Some((ArchivalAction::ShortenRetention, MIN_RETENTION_DAYS))
+        );
+    }
+}
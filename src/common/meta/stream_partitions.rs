@@ -0,0 +1,149 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::BTreeMap;
+
+use config::{
+    meta::stream::{PartitionTimeLevel, StreamType},
+    utils::json,
+};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Names of the time partition levels of the file keys, in path order
+pub const TIME_PARTITIONS: [&str; 4] = ["year", "month", "day", "hour"];
+
+/// Files of a stream grouped by partition, with what an external engine
+/// needs to read the parquet files directly: their location, their time
+/// range, their sizes and the statistics of their columns.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct StreamPartitions {
+    pub stream_name: String,
+    pub stream_type: StreamType,
+    /// Base url of the file keys, e.g. `s3://bucket/prefix/` or
+    /// `file:///data/openobserve/stream/`
+    pub location: String,
+    pub partition_time_level: PartitionTimeLevel,
+    /// Fields partitioned on after the time, as `field=value` directories
+    pub partition_keys: Vec<String>,
+    pub fields: Vec<PartitionField>,
+    pub start_time: i64,
+    pub end_time: i64,
+    pub records: i64,
+    pub files: usize,
+    /// bytes
+    pub original_size: i64,
+    /// bytes
+    pub compressed_size: i64,
+    pub partitions: Vec<Partition>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct PartitionField {
+    pub name: String,
+    /// Arrow data type
+    pub data_type: String,
+}
+
+/// Directory of files sharing the same time and partition key values
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct Partition {
+    /// Key prefix of the files, e.g.
+    /// `files/default/logs/app/2024/05/01/10/service=api/`
+    pub path: String,
+    /// Values of the time partitions and of the partition keys
+    pub values: BTreeMap<String, String>,
+    pub min_ts: i64,
+    pub max_ts: i64,
+    pub records: i64,
+    pub original_size: i64,
+    pub compressed_size: i64,
+    pub files: Vec<DataFile>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct DataFile {
+    pub key: String,
+    /// `location` joined with the key
+    pub url: String,
+    pub min_ts: i64,
+    pub max_ts: i64,
+    pub records: i64,
+    pub original_size: i64,
+    pub compressed_size: i64,
+    /// Read from the parquet footer when asked for
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub columns: Vec<ColumnStatistics>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ColumnStatistics {
+    pub name: String,
+    /// Not set when the file has no statistics for the column
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub null_count: Option<i64>,
+    #[schema(value_type = Object)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub min: Option<json::Value>,
+    #[schema(value_type = Object)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub max: Option<json::Value>,
+}
+
+/// Returns the partition path and the partition values of a file key, e.g.
+/// `files/default/logs/app/2024/05/01/10/service=api/7_1.parquet`
+pub fn parse_partition(key: &str) -> Option<(String, BTreeMap<String, String>)> {
+    let (path, _file) = key.rsplit_once('/')?;
+    let columns: Vec<&str> = path.split('/').collect();
+    // files/{org_id}/{stream_type}/{stream_name}/{year}/{month}/{day}/{hour}
+    if columns.len() < 8 || columns[0] != "files" {
+        return None;
+    }
+    let mut values = BTreeMap::new();
+    for (name, value) in TIME_PARTITIONS.iter().zip(&columns[4..8]) {
+        values.insert(name.to_string(), value.to_string());
+    }
+    for column in &columns[8..] {
+        let (name, value) = column.split_once('=')?;
+        values.insert(name.to_string(), value.to_string());
+    }
+    Some((format!("{path}/"), values))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_partition() {
+        let (path, values) =
+            parse_partition("files/default/logs/app/2024/05/01/10/service=api/7_1.parquet")
+                .unwrap();
+        assert_eq!(path, "files/default/logs/app/2024/05/01/10/service=api/");
+        assert_eq!(values["year"], "2024");
+        assert_eq!(values["hour"], "10");
+        assert_eq!(values["service"], "api");
+
+        let (path, values) =
+            parse_partition("files/default/logs/app/2024/05/01/00/7_1.parquet").unwrap();
+        assert_eq!(path, "files/default/logs/app/2024/05/01/00/");
+        assert_eq!(values.len(), 4);
+
+        assert!(parse_partition("files/default/logs/app/7_1.parquet").is_none());
+        assert!(parse_partition("files/default/logs/app/2024/05/01/00/x/7.parquet").is_none());
+    }
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Statistics of the fields of a stream over a time window. The record and
+/// null counts, the minimums and the maximums come from the parquet metadata
+/// of the files, the other statistics from a sample of the records.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct StreamProfile {
+    pub stream_name: String,
+    pub stream_type: StreamType,
+    pub start_time: i64,
+    pub end_time: i64,
+    /// Records of the window according to the file list
+    pub records: i64,
+    pub files: usize,
+    /// Files whose parquet metadata was read, up to ZO_PROFILE_MAX_FILES
+    pub files_scanned: usize,
+    /// Records sampled, up to ZO_PROFILE_SAMPLE_SIZE
+    pub sampled: usize,
+    pub fields: Vec<FieldProfile>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub warnings: Vec<String>,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct FieldProfile {
+    pub name: String,
+    pub data_type: String,
+    /// Percentage of the records without a value
+    pub null_percent: f64,
+    /// Distinct values in the sample
+    pub distinct_count: usize,
+    /// True when every sampled value is distinct, like ids
+    pub high_cardinality: bool,
+    /// Most frequent values in the sample
+    pub top_values: Vec<ValueCount>,
+    #[schema(value_type = Object)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub min: Option<json::Value>,
+    #[schema(value_type = Object)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub max: Option<json::Value>,
+    /// Length distribution of the string values in the sample
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub length: Option<LengthStats>,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ValueCount {
+    pub value: String,
+    pub count: usize,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct LengthStats {
+    pub min: usize,
+    pub max: usize,
+    pub avg: f64,
+    pub buckets: Vec<LengthBucket>,
+}
+
+/// Values whose length is at most `le`, and more than the previous bucket,
+/// the last bucket has no upper bound
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct LengthBucket {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub le: Option<usize>,
+    pub count: usize,
+}
@@ -0,0 +1,426 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, fmt};
+
+use config::meta::stream::StreamType;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::{alerts::Condition, secrets::is_secret_ref};
+
+/// Value returned in place of the credentials, sending it back on update
+/// keeps the stored credential
+pub const SECRET_MASK: &str = "******";
+/// Maximum records sent to the sink in one request
+pub const MAX_BATCH_SIZE: usize = 10000;
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq, Hash)]
+#[serde(rename_all = "snake_case")]
+pub enum SubscriptionSink {
+    /// JSON array of the records posted to an HTTP endpoint
+    #[default]
+    Webhook,
+    /// Kafka topic, produced through a Kafka REST Proxy
+    Kafka,
+    /// Stream of another OpenObserve instance
+    Openobserve,
+    /// ClickHouse table, inserted through the HTTP interface as JSONEachRow
+    Clickhouse,
+    /// Elasticsearch or OpenSearch index, written with the bulk API
+    Elasticsearch,
+}
+
+impl fmt::Display for SubscriptionSink {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            SubscriptionSink::Webhook => write!(f, "webhook"),
+            SubscriptionSink::Kafka => write!(f, "kafka"),
+            SubscriptionSink::Openobserve => write!(f, "openobserve"),
+            SubscriptionSink::Clickhouse => write!(f, "clickhouse"),
+            SubscriptionSink::Elasticsearch => write!(f, "elasticsearch"),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct WebhookSink {
+    pub url: String,
+    /// Sent with every request, e.g. `Authorization`
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct KafkaSink {
+    /// Base url of the Kafka REST Proxy, e.g. `http://kafka-rest:8082`
+    pub url: String,
+    pub topic: String,
+    /// Field of the record used as the message key, no key when empty
+    #[serde(default)]
+    pub key_field: String,
+    /// Sent with every request to the proxy, e.g. `Authorization`
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct OpenobserveSink {
+    /// Base url of the instance, e.g. `https://api.openobserve.ai`
+    pub url: String,
+    pub org_id: String,
+    pub stream_name: String,
+    pub user: String,
+    pub password: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ClickhouseSink {
+    /// Url of the HTTP interface, e.g. `http://clickhouse:8123`
+    pub url: String,
+    #[serde(default = "default_clickhouse_database")]
+    pub database: String,
+    /// Existing table, the fields without a column are skipped
+    pub table: String,
+    pub user: String,
+    #[serde(default)]
+    pub password: String,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct ElasticsearchSink {
+    /// Base url of the cluster, e.g. `https://es.example.com:9200`
+    pub url: String,
+    pub index: String,
+    /// Basic authentication, none when empty
+    #[serde(default)]
+    pub user: String,
+    #[serde(default)]
+    pub password: String,
+    /// Sent with every request, e.g. `Authorization` for an API key
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+}
+
+/// Forwards the records of a stream matching the conditions to a sink as
+/// they are ingested.
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct StreamSubscription {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    #[serde(default)]
+    pub stream_type: StreamType,
+    pub stream_name: String,
+    /// All the conditions must match, every record is forwarded when empty
+    #[serde(default)]
+    pub conditions: Vec<Condition>,
+    #[serde(default)]
+    pub sink: SubscriptionSink,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub webhook: Option<WebhookSink>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub kafka: Option<KafkaSink>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub openobserve: Option<OpenobserveSink>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub clickhouse: Option<ClickhouseSink>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub elasticsearch: Option<ElasticsearchSink>,
+    /// Maximum records sent to the sink in one request
+    #[serde(default = "default_batch_size")]
+    pub batch_size: usize,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    /// Time the forwarding stops, in microseconds, e.g. the end of the
+    /// migration to the sink; forwards until disabled when 0
+    #[serde(default)]
+    pub expires_at: i64,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+fn default_batch_size() -> usize {
+    100
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+fn default_clickhouse_database() -> String {
+    "default".to_string()
+}
+
+fn is_http_url(url: &str) -> bool {
+    url.starts_with("http://") || url.starts_with("https://")
+}
+
+/// Whether the host is one of the allowed hosts or one of their subdomains
+pub fn is_allowed_host(host: &str, allowed: &[&str]) -> bool {
+    let host = host.to_lowercase();
+    allowed.iter().any(|v| {
+        let v = v.trim().to_lowercase();
+        !v.is_empty()
+            && (host == v
+                || host
+                    .strip_suffix(&v)
+                    .is_some_and(|prefix| prefix.ends_with('.')))
+    })
+}
+
+impl StreamSubscription {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid subscription name".to_string());
+        }
+        if self.stream_name.trim().is_empty() {
+            return Err("Stream is required".to_string());
+        }
+        if self.batch_size == 0 || self.batch_size > MAX_BATCH_SIZE {
+            return Err(format!(
+                "Batch size should be between 1 and {MAX_BATCH_SIZE}"
+            ));
+        }
+        let ok = match self.sink {
+            SubscriptionSink::Webhook => self.webhook.as_ref().is_some_and(|s| is_http_url(&s.url)),
+            SubscriptionSink::Kafka => self
+                .kafka
+                .as_ref()
+                .is_some_and(|s| is_http_url(&s.url) && !s.topic.trim().is_empty()),
+            SubscriptionSink::Openobserve => self.openobserve.as_ref().is_some_and(|s| {
+                is_http_url(&s.url)
+                    && !s.org_id.is_empty()
+                    && !s.stream_name.is_empty()
+                    && !s.user.is_empty()
+                    && !s.password.is_empty()
+            }),
+            SubscriptionSink::Clickhouse => self.clickhouse.as_ref().is_some_and(|s| {
+                is_http_url(&s.url)
+                    && !s.database.trim().is_empty()
+                    && !s.table.trim().is_empty()
+                    && !s.user.is_empty()
+            }),
+            SubscriptionSink::Elasticsearch => self
+                .elasticsearch
+                .as_ref()
+                .is_some_and(|s| is_http_url(&s.url) && !s.index.trim().is_empty()),
+        };
+        if !ok {
+            return Err(format!(
+                "Missing or invalid {} settings for the sink",
+                self.sink
+            ));
+        }
+        Ok(())
+    }
+
+    /// Url the records are sent to
+    pub fn sink_url(&self) -> Option<&str> {
+        match self.sink {
+            SubscriptionSink::Webhook => self.webhook.as_ref().map(|s| s.url.as_str()),
+            SubscriptionSink::Kafka => self.kafka.as_ref().map(|s| s.url.as_str()),
+            SubscriptionSink::Openobserve => self.openobserve.as_ref().map(|s| s.url.as_str()),
+            SubscriptionSink::Clickhouse => self.clickhouse.as_ref().map(|s| s.url.as_str()),
+            SubscriptionSink::Elasticsearch => self.elasticsearch.as_ref().map(|s| s.url.as_str()),
+        }
+    }
+
+    /// Returns whether the subscription forwards the records ingested at
+    /// `now`, in microseconds
+    pub fn is_active(&self, now: i64) -> bool {
+        self.enabled && (self.expires_at == 0 || now < self.expires_at)
+    }
+
+    /// Hides the credentials before returning the subscription
+    pub fn mask_secrets(&mut self) {
+        // references to the secrets store carry no credential, keep them visible
+        let mask = |value: &mut String| {
+            if !is_secret_ref(value) {
+                *value = SECRET_MASK.to_string();
+            }
+        };
+        if let Some(s) = self.webhook.as_mut() {
+            s.headers.values_mut().for_each(mask);
+        }
+        if let Some(s) = self.kafka.as_mut() {
+            s.headers.values_mut().for_each(mask);
+        }
+        if let Some(s) = self.openobserve.as_mut() {
+            mask(&mut s.password);
+        }
+        if let Some(s) = self.clickhouse.as_mut() {
+            mask(&mut s.password);
+        }
+        if let Some(s) = self.elasticsearch.as_mut() {
+            mask(&mut s.password);
+            s.headers.values_mut().for_each(mask);
+        }
+    }
+
+    /// Replaces the masked credentials of an update with the stored ones
+    pub fn keep_secrets(&mut self, stored: &StreamSubscription) {
+        let keep = |headers: &mut HashMap<String, String>, old: &HashMap<String, String>| {
+            for (name, value) in headers.iter_mut() {
+                if value == SECRET_MASK {
+                    if let Some(old) = old.get(name) {
+                        *value = old.clone();
+                    }
+                }
+            }
+        };
+        if let (Some(s), Some(old)) = (self.webhook.as_mut(), stored.webhook.as_ref()) {
+            keep(&mut s.headers, &old.headers);
+        }
+        if let (Some(s), Some(old)) = (self.kafka.as_mut(), stored.kafka.as_ref()) {
+            keep(&mut s.headers, &old.headers);
+        }
+        if let (Some(s), Some(old)) = (self.openobserve.as_mut(), stored.openobserve.as_ref()) {
+            if s.password == SECRET_MASK {
+                s.password = old.password.clone();
+            }
+        }
+        if let (Some(s), Some(old)) = (self.clickhouse.as_mut(), stored.clickhouse.as_ref()) {
+            if s.password == SECRET_MASK {
+                s.password = old.password.clone();
+            }
+        }
+        if let (Some(s), Some(old)) = (self.elasticsearch.as_mut(), stored.elasticsearch.as_ref()) {
+            if s.password == SECRET_MASK {
+                s.password = old.password.clone();
+            }
+            keep(&mut s.headers, &old.headers);
+        }
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct StreamSubscriptionList {
+    pub list: Vec<StreamSubscription>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn webhook_subscription() -> StreamSubscription {
+        StreamSubscription {
+            name: "soar".to_string(),
+            stream_name: "security".to_string(),
+            sink: SubscriptionSink::Webhook,
+            webhook: Some(WebhookSink {
+                url: "https://soar.example.com/events".to_string(),
+                headers: HashMap::from([("Authorization".to_string(), "Bearer abc".to_string())]),
+            }),
+            batch_size: 100,
+            enabled: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_is_allowed_host() {
+        let allowed = ["example.com", " Kafka-Rest "];
+        assert!(is_allowed_host("example.com", &allowed));
+        assert!(is_allowed_host("soar.Example.com", &allowed));
+        assert!(is_allowed_host("kafka-rest", &allowed));
+        assert!(!is_allowed_host("badexample.com", &allowed));
+        assert!(!is_allowed_host("example.com.evil.io", &allowed));
+        assert!(!is_allowed_host("example.com", &[""]));
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut sub = webhook_subscription();
+        assert!(sub.validate().is_ok());
+        sub.batch_size = 0;
+        assert!(sub.validate().is_err());
+        sub.batch_size = 100;
+        sub.sink = SubscriptionSink::Kafka;
+        assert!(sub.validate().is_err());
+        sub.kafka = Some(KafkaSink {
+            url: "http://kafka-rest:8082".to_string(),
+            topic: "events".to_string(),
+            ..Default::default()
+        });
+        assert!(sub.validate().is_ok());
+        sub.name = "a/b".to_string();
+        assert!(sub.validate().is_err());
+        sub.name = "soar".to_string();
+        sub.sink = SubscriptionSink::Clickhouse;
+        assert!(sub.validate().is_err());
+        sub.clickhouse = Some(ClickhouseSink {
+            url: "http://clickhouse:8123".to_string(),
+            database: default_clickhouse_database(),
+            table: "security".to_string(),
+            user: "default".to_string(),
+            ..Default::default()
+        });
+        assert!(sub.validate().is_ok());
+        sub.sink = SubscriptionSink::Elasticsearch;
+        sub.elasticsearch = Some(ElasticsearchSink {
+            url: "https://es.example.com:9200".to_string(),
+            ..Default::default()
+        });
+        assert!(sub.validate().is_err());
+        sub.elasticsearch.as_mut().unwrap().index = "security".to_string();
+        assert!(sub.validate().is_ok());
+    }
+
+    #[test]
+    fn test_is_active() {
+        let mut sub = webhook_subscription();
+        assert!(sub.is_active(100));
+        sub.expires_at = 100;
+        assert!(sub.is_active(99));
+        assert!(!sub.is_active(100));
+        sub.expires_at = 0;
+        sub.enabled = false;
+        assert!(!sub.is_active(100));
+    }
+
+    #[test]
+    fn test_mask_and_keep_secrets() {
+        let stored = webhook_subscription();
+        let mut sub = stored.clone();
+        sub.mask_secrets();
+        assert_eq!(
+            sub.webhook.as_ref().unwrap().headers["Authorization"],
+            SECRET_MASK
+        );
+        sub.keep_secrets(&stored);
+        assert_eq!(sub, stored);
+
+        // references to the secrets store are shown as they are
+        let mut sub = stored.clone();
+        sub.webhook
+            .as_mut()
+            .unwrap()
+            .headers
+            .insert("Authorization".to_string(), "{{secret:soar}}".to_string());
+        sub.mask_secrets();
+        assert_eq!(
+            sub.webhook.as_ref().unwrap().headers["Authorization"],
+            "{{secret:soar}}"
+        );
+    }
+}
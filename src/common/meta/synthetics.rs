@@ -0,0 +1,475 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, fmt};
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Minimum seconds between two runs of a check
+pub const MIN_INTERVAL: u64 = 10;
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum CheckType {
+    /// Requests the url of the target
+    #[default]
+    Http,
+    /// Opens a connection to the `host:port` target
+    Tcp,
+    /// Resolves the A/AAAA records of the host target
+    Dns,
+    /// Pings the host target with the `ping` command of the runner
+    Icmp,
+    /// Runs the Playwright script of the check, starting at the url target
+    Browser,
+}
+
+impl fmt::Display for CheckType {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            CheckType::Http => write!(f, "http"),
+            CheckType::Tcp => write!(f, "tcp"),
+            CheckType::Dns => write!(f, "dns"),
+            CheckType::Icmp => write!(f, "icmp"),
+            CheckType::Browser => write!(f, "browser"),
+        }
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct HttpSettings {
+    #[serde(default = "default_method")]
+    pub method: String,
+    #[serde(default)]
+    pub headers: HashMap<String, String>,
+    #[serde(default)]
+    pub body: String,
+    #[serde(default = "default_true")]
+    pub follow_redirects: bool,
+}
+
+impl Default for HttpSettings {
+    fn default() -> Self {
+        Self {
+            method: default_method(),
+            headers: HashMap::new(),
+            body: String::new(),
+            follow_redirects: true,
+        }
+    }
+}
+
+/// Settings of a browser check. The script is the body of an async function
+/// called with the Playwright `page` and `context`, the `target` url and a
+/// `step(name, async () => {...})` helper timing each step of the journey:
+///
+/// ```js
+/// await step("home", async () => { await page.goto(target); });
+/// await step("login", async () => {
+///   await page.fill("#email", "synthetics@example.com");
+///   await page.click("text=Sign in");
+///   await page.waitForURL("**/dashboard");
+/// });
+/// ```
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct BrowserSettings {
+    pub script: String,
+    #[serde(default = "default_viewport_width")]
+    pub viewport_width: u32,
+    #[serde(default = "default_viewport_height")]
+    pub viewport_height: u32,
+    /// Takes a screenshot at the end of every step, failed steps always get
+    /// one
+    #[serde(default = "default_true")]
+    pub screenshots: bool,
+}
+
+impl Default for BrowserSettings {
+    fn default() -> Self {
+        Self {
+            script: String::new(),
+            viewport_width: default_viewport_width(),
+            viewport_height: default_viewport_height(),
+            screenshots: true,
+        }
+    }
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum AssertionSource {
+    #[default]
+    StatusCode,
+    /// Milliseconds
+    ResponseTime,
+    Body,
+    /// Header named by the property of the assertion
+    Header,
+    /// Addresses resolved by a DNS check
+    ResolvedAddress,
+}
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum AssertionOperator {
+    #[default]
+    Eq,
+    NotEq,
+    Lt,
+    Gt,
+    Contains,
+    NotContains,
+    Matches,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct Assertion {
+    pub source: AssertionSource,
+    #[serde(default)]
+    pub property: String,
+    pub operator: AssertionOperator,
+    pub target: String,
+}
+
+impl fmt::Display for Assertion {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let source = json::to_value(self.source).unwrap_or_default();
+        let operator = json::to_value(self.operator).unwrap_or_default();
+        let source = source.as_str().unwrap_or_default();
+        if self.property.is_empty() {
+            write!(
+                f,
+                "{source} {} {}",
+                operator.as_str().unwrap_or_default(),
+                self.target
+            )
+        } else {
+            write!(
+                f,
+                "{source}[{}] {} {}",
+                self.property,
+                operator.as_str().unwrap_or_default(),
+                self.target
+            )
+        }
+    }
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct SyntheticCheck {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    pub check_type: CheckType,
+    /// Url for http and browser, `host:port` for tcp and host for dns and
+    /// icmp
+    pub target: String,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub http: Option<HttpSettings>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub browser: Option<BrowserSettings>,
+    /// Seconds between two runs
+    #[serde(default = "default_interval")]
+    pub interval: u64,
+    /// Seconds to wait for the target
+    #[serde(default = "default_timeout")]
+    pub timeout: u64,
+    /// All of them should pass, an http check without assertions passes when
+    /// the status code is below 400
+    #[serde(default)]
+    pub assertions: Vec<Assertion>,
+    /// Locations running the check, the built-in location of the ingesters
+    /// when empty
+    #[serde(default)]
+    pub locations: Vec<String>,
+    /// Labels added to the results
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+    #[serde(default = "default_true")]
+    pub enabled: bool,
+    #[serde(default)]
+    pub owner: String,
+}
+
+fn default_method() -> String {
+    "GET".to_string()
+}
+
+fn default_interval() -> u64 {
+    60
+}
+
+fn default_timeout() -> u64 {
+    10
+}
+
+fn default_viewport_width() -> u32 {
+    1280
+}
+
+fn default_viewport_height() -> u32 {
+    720
+}
+
+fn default_true() -> bool {
+    true
+}
+
+impl SyntheticCheck {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid check name".to_string());
+        }
+        if self.target.trim().is_empty() {
+            return Err("Target is required".to_string());
+        }
+        match self.check_type {
+            CheckType::Http | CheckType::Browser => {
+                if !self.target.starts_with("http://") && !self.target.starts_with("https://") {
+                    return Err(format!(
+                        "Target of an {} check should be an http(s) url",
+                        self.check_type
+                    ));
+                }
+            }
+            CheckType::Tcp => {
+                if self
+                    .target
+                    .rsplit_once(':')
+                    .and_then(|(_, port)| port.parse::<u16>().ok())
+                    .is_none()
+                {
+                    return Err("Target of a tcp check should be host:port".to_string());
+                }
+            }
+            CheckType::Dns | CheckType::Icmp => {
+                if self.target.contains('/') || self.target.contains(' ') {
+                    return Err(format!(
+                        "Target of a {} check should be a host",
+                        self.check_type
+                    ));
+                }
+            }
+        }
+        if self.check_type == CheckType::Browser
+            && !self
+                .browser
+                .as_ref()
+                .is_some_and(|browser| !browser.script.trim().is_empty())
+        {
+            return Err("Browser checks need a script".to_string());
+        }
+        if self.interval < MIN_INTERVAL {
+            return Err(format!(
+                "Interval should be at least {MIN_INTERVAL} seconds"
+            ));
+        }
+        if self.timeout == 0 || self.timeout > self.interval {
+            return Err("Timeout should be between 1 second and the interval".to_string());
+        }
+        for assertion in self.assertions.iter() {
+            let supported = match assertion.source {
+                AssertionSource::StatusCode | AssertionSource::Body | AssertionSource::Header => {
+                    self.check_type == CheckType::Http
+                }
+                AssertionSource::ResolvedAddress => self.check_type == CheckType::Dns,
+                AssertionSource::ResponseTime => true,
+            };
+            if !supported {
+                return Err(format!(
+                    "Assertion {assertion} is not supported by {} checks",
+                    self.check_type
+                ));
+            }
+            if assertion.source == AssertionSource::Header && assertion.property.is_empty() {
+                return Err("Header assertions need the header name as property".to_string());
+            }
+            if assertion.operator == AssertionOperator::Matches
+                && regex::Regex::new(&assertion.target).is_err()
+            {
+                return Err(format!("Invalid regex of the assertion {assertion}"));
+            }
+        }
+        Ok(())
+    }
+
+    /// Whether the location runs the check
+    pub fn runs_at(&self, location: &str, default_location: &str) -> bool {
+        if self.locations.is_empty() {
+            location == default_location
+        } else {
+            self.locations.iter().any(|l| l == location)
+        }
+    }
+
+    /// Checks a browser check only runs at remote locations: its script has
+    /// the full access of node, it never runs on the nodes of OpenObserve
+    pub fn validate_location(&self, builtin_location: &str) -> Result<(), String> {
+        if self.check_type == CheckType::Browser && self.runs_at(builtin_location, builtin_location)
+        {
+            return Err(format!(
+                "Browser checks can only run at the locations of a synthetics-runner, not at the built-in location {builtin_location}"
+            ));
+        }
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct SyntheticCheckList {
+    pub list: Vec<SyntheticCheck>,
+}
+
+/// Outcome of one step of a browser check
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct StepResult {
+    pub name: String,
+    pub duration_ms: f64,
+    pub success: bool,
+    /// Url of the page at the end of the step
+    #[serde(default)]
+    pub url: String,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    /// Base64 png sent by the runner, replaced by the path of the stored
+    /// screenshot once saved
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub screenshot: Option<String>,
+}
+
+/// Outcome of one run of a check at one location
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct CheckResult {
+    /// Microseconds
+    pub timestamp: i64,
+    /// Set when the result is saved, names the stored screenshots of the run
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub run_id: String,
+    pub check: String,
+    pub check_type: CheckType,
+    pub target: String,
+    pub location: String,
+    pub success: bool,
+    pub response_time_ms: f64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub status_code: Option<u16>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub resolved_addresses: Vec<String>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub failed_assertions: Vec<String>,
+    /// Steps of a browser check, in the order they ran
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub steps: Vec<StepResult>,
+    #[serde(default)]
+    pub labels: HashMap<String, String>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn http_check() -> SyntheticCheck {
+        SyntheticCheck {
+            name: "homepage".to_string(),
+            check_type: CheckType::Http,
+            target: "https://example.com".to_string(),
+            interval: 60,
+            timeout: 10,
+            enabled: true,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_validate() {
+        let mut check = http_check();
+        assert!(check.validate().is_ok());
+        check.assertions.push(Assertion {
+            source: AssertionSource::ResolvedAddress,
+            operator: AssertionOperator::Contains,
+            target: "1.2.3.4".to_string(),
+            ..Default::default()
+        });
+        assert!(check.validate().is_err());
+        check.assertions.clear();
+        check.check_type = CheckType::Tcp;
+        assert!(check.validate().is_err());
+        check.target = "db.internal:5432".to_string();
+        assert!(check.validate().is_ok());
+        check.timeout = 120;
+        assert!(check.validate().is_err());
+    }
+
+    #[test]
+    fn test_validate_browser() {
+        let mut check = http_check();
+        check.check_type = CheckType::Browser;
+        assert!(check.validate().is_err());
+        check.browser = Some(BrowserSettings {
+            script: "await step('home', async () => { await page.goto(target); });".to_string(),
+            ..Default::default()
+        });
+        assert!(check.validate().is_ok());
+        check.target = "example.com".to_string();
+        assert!(check.validate().is_err());
+    }
+
+    #[test]
+    fn test_validate_location() {
+        let mut check = http_check();
+        assert!(check.validate_location("default").is_ok());
+        check.check_type = CheckType::Browser;
+        assert!(check.validate_location("default").is_err());
+        check.locations = vec!["eu-west".to_string(), "default".to_string()];
+        assert!(check.validate_location("default").is_err());
+        check.locations = vec!["eu-west".to_string()];
+        assert!(check.validate_location("default").is_ok());
+    }
+
+    #[test]
+    fn test_runs_at() {
+        let mut check = http_check();
+        assert!(check.runs_at("default", "default"));
+        assert!(!check.runs_at("eu-west", "default"));
+        check.locations = vec!["eu-west".to_string()];
+        assert!(check.runs_at("eu-west", "default"));
+        assert!(!check.runs_at("default", "default"));
+    }
+
+    #[test]
+    fn test_assertion_display() {
+        let assertion = Assertion {
+            source: AssertionSource::Header,
+            property: "content-type".to_string(),
+            operator: AssertionOperator::Contains,
+            target: "json".to_string(),
+        };
+        assert_eq!(assertion.to_string(), "header[content-type] contains json");
+    }
+}
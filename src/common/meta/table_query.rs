@@ -0,0 +1,97 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use config::utils::json;
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+use super::dashboards::v4::TableRule;
+
+/// Rows fetched per page when the request doesn't set a size
+pub const DEFAULT_PAGE_SIZE: i64 = 100;
+pub const MAX_PAGE_SIZE: i64 = 10000;
+
+fn default_page_size() -> i64 {
+    DEFAULT_PAGE_SIZE
+}
+
+/// A page of the rows of a table panel, sorted by the server
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct TableQueryRequest {
+    pub sql: String,
+    /// microseconds
+    pub start_time: i64,
+    /// microseconds
+    pub end_time: i64,
+    /// replaces the ORDER BY of the sql
+    #[serde(default)]
+    pub sort: Vec<TableSort>,
+    #[serde(default = "default_page_size")]
+    pub size: i64,
+    /// the next_cursor of the previous page, the first page without
+    #[serde(default)]
+    pub cursor: Option<String>,
+    /// conditional formatting evaluated on the returned rows
+    #[serde(default)]
+    pub rules: Vec<TableRule>,
+}
+
+#[derive(Clone, Debug, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct TableSort {
+    pub column: String,
+    #[serde(default)]
+    pub desc: bool,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
+pub struct TableQueryResponse {
+    pub took: usize,
+    #[schema(value_type = Vec<Object>)]
+    pub hits: Vec<json::Value>,
+    /// formatting of each hit, in the same order
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub formats: Vec<RowFormat>,
+    /// offset of the first hit in the sorted rows
+    pub from: i64,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub next_cursor: Option<String>,
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct RowFormat {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub row: Option<CellFormat>,
+    /// by column
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub cells: HashMap<String, CellFormat>,
+}
+
+impl RowFormat {
+    pub fn is_empty(&self) -> bool {
+        self.row.is_none() && self.cells.is_empty()
+    }
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct CellFormat {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub background_color: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub text_color: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub link: Option<String>,
+}
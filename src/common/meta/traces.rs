@@ -41,6 +41,7 @@ pub struct Span {
     #[serde(skip_serializing_if = "HashMap::is_empty")]
     pub service: HashMap<String, json::Value>,
     pub events: String,
+    pub links: String,
 }
 
 #[derive(Clone, Copy, Debug, Default, Eq, PartialEq, Serialize, Deserialize)]
@@ -61,6 +62,20 @@ pub struct Event {
     pub attributes: HashMap<String, json::Value>,
 }
 
+/// A link from a span to a span of the same or another trace, e.g. the producer
+/// span of a message consumed in a batch.
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize)]
+pub struct SpanLink {
+    pub trace_id: String,
+    pub span_id: String,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub trace_state: String,
+    #[serde(flatten)]
+    #[serde(skip_serializing_if = "HashMap::is_empty")]
+    pub attributes: HashMap<String, json::Value>,
+}
+
 #[derive(Debug, Default, Serialize, Deserialize, ToSchema)]
 pub struct ExportTraceServiceResponse {
     // The details of a partially successful export request.
@@ -298,6 +298,10 @@ pub struct UserList {
 pub struct SignInUser {
     pub name: String,
     pub password: String,
+    /// One-time code from the user's authenticator app, required once MFA is
+    /// enabled for the user.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub totp: Option<String>,
 }
 
 #[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
@@ -457,6 +461,62 @@ pub struct AuthTokens {
     pub refresh_token: String,
 }
 
+/// Prefix of the access token stored in the `auth_tokens` cookie for native
+/// logins backed by a server-side session.
+pub const NATIVE_SESSION_PREFIX: &str = "native_session ";
+
+/// Message returned by the login endpoint when the password was accepted but a
+/// one-time code is still needed.
+pub const MFA_REQUIRED: &str = "mfa_required";
+
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct UserSession {
+    pub id: String,
+    pub user_email: String,
+    pub created_at: i64,
+    pub expires_at: i64,
+    #[serde(default)]
+    pub ip: String,
+    #[serde(default)]
+    pub user_agent: String,
+}
+
+impl UserSession {
+    pub fn is_expired(&self, now: i64) -> bool {
+        self.expires_at <= now
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct UserSessionList {
+    pub data: Vec<UserSession>,
+}
+
+#[derive(Clone, Debug, Default, Serialize, Deserialize)]
+pub struct UserMfa {
+    pub secret: String,
+    /// Set once the user confirmed the enrollment with a valid code; until then
+    /// the secret is pending and not enforced at login.
+    #[serde(default)]
+    pub enabled: bool,
+    pub created_at: i64,
+    /// Time step of the last code accepted, the codes of the steps up to it
+    /// are refused
+    #[serde(default)]
+    pub last_step: u64,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct MfaEnrollResponse {
+    pub secret: String,
+    pub otpauth_url: String,
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct MfaVerifyRequest {
+    pub code: String,
+}
+
 #[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema)]
 pub struct AuthTokensExt {
     pub auth_ext: String,
@@ -0,0 +1,114 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::fmt;
+
+use serde::{Deserialize, Serialize};
+use utoipa::ToSchema;
+
+/// Value returned in place of the secret, sending it back on update keeps the
+/// stored secret
+pub const SECRET_MASK: &str = "******";
+
+#[derive(Clone, Copy, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum WebhookProvider {
+    /// Validated with the `X-Hub-Signature-256` HMAC of the payload
+    #[default]
+    Github,
+    /// Validated with the `X-Gitlab-Token` secret token
+    Gitlab,
+}
+
+impl fmt::Display for WebhookProvider {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            WebhookProvider::Github => write!(f, "github"),
+            WebhookProvider::Gitlab => write!(f, "gitlab"),
+        }
+    }
+}
+
+/// Endpoint receiving the events of a repository hosting service, the events
+/// are normalized into the same fields for all the providers
+#[derive(Clone, Debug, Default, Serialize, Deserialize, ToSchema, PartialEq)]
+pub struct WebhookSource {
+    pub name: String,
+    #[serde(default)]
+    pub description: String,
+    pub provider: WebhookProvider,
+    /// Secret configured on the webhook of the provider
+    pub secret: String,
+    /// Logs stream the events are written to
+    pub stream_name: String,
+    /// Keeps the original payload, as a string, in the `payload` field
+    #[serde(default)]
+    pub keep_payload: bool,
+    #[serde(default = "default_enabled")]
+    pub enabled: bool,
+    #[serde(default)]
+    pub owner: String,
+    #[serde(default)]
+    pub created_at: i64,
+    #[serde(default)]
+    pub updated_at: i64,
+}
+
+fn default_enabled() -> bool {
+    true
+}
+
+impl WebhookSource {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.name.trim().is_empty() || self.name.contains('/') {
+            return Err("Invalid webhook name".to_string());
+        }
+        if self.secret.is_empty() || self.secret == SECRET_MASK {
+            return Err("Secret is required".to_string());
+        }
+        if self.stream_name.trim().is_empty() {
+            return Err("Destination stream is required".to_string());
+        }
+        Ok(())
+    }
+}
+
+#[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
+pub struct WebhookSourceList {
+    pub list: Vec<WebhookSource>,
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_validate() {
+        let mut source = WebhookSource {
+            name: "repos".to_string(),
+            provider: WebhookProvider::Github,
+            secret: "s3cret".to_string(),
+            stream_name: "cicd_events".to_string(),
+            enabled: true,
+            ..Default::default()
+        };
+        assert!(source.validate().is_ok());
+        source.secret = SECRET_MASK.to_string();
+        assert!(source.validate().is_err());
+        source.secret = "s3cret".to_string();
+        source.name = "a/b".to_string();
+        assert!(source.validate().is_err());
+    }
+}
@@ -28,7 +28,7 @@ use crate::common::{
     meta::{
         authz::Authz,
         organization::DEFAULT_ORG,
-        user::{AuthTokens, UserRole},
+        user::{AuthTokens, UserRole, NATIVE_SESSION_PREFIX},
     },
 };
 
@@ -457,7 +457,10 @@ impl FromRequest for AuthExtractor {
         let auth_str = if let Some(cookie) = req.cookie("auth_tokens") {
             let auth_tokens: AuthTokens = json::from_str(cookie.value()).unwrap_or_default();
             let access_token = auth_tokens.access_token;
-            if access_token.starts_with("Basic") || access_token.starts_with("Bearer") {
+            if access_token.starts_with("Basic")
+                || access_token.starts_with("Bearer")
+                || access_token.starts_with(NATIVE_SESSION_PREFIX)
+            {
                 access_token
             } else {
                 format!("Bearer {}", access_token)
@@ -505,7 +508,10 @@ pub fn extract_auth_str(req: &HttpRequest) -> String {
             // If cookie was set but access token is still empty
             // we check auth_ext cookie to get the token.
             auth_ext_cookie(req)
-        } else if access_token.starts_with("Basic") || access_token.starts_with("Bearer") {
+        } else if access_token.starts_with("Basic")
+            || access_token.starts_with("Bearer")
+            || access_token.starts_with(NATIVE_SESSION_PREFIX)
+        {
             access_token
         } else if access_token.starts_with("session") {
             let session_key = access_token.strip_prefix("session ").unwrap().to_string();
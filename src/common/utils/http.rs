@@ -19,12 +19,14 @@ use std::{
 };
 
 use actix_http::header::HeaderName;
-use actix_web::web::Query;
+use actix_web::{web::Query, HttpRequest};
 use awc::http::header::HeaderMap;
 use config::meta::{search::SearchEventType, stream::StreamType};
 use opentelemetry::{global, propagation::Extractor, trace::TraceContextExt};
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 
+use crate::common::meta::ingestion::{is_sampling_override, SAMPLING_OVERRIDE_HEADER};
+
 #[inline(always)]
 pub(crate) fn get_stream_type_from_request(
     query: &Query<HashMap<String, String>>,
@@ -50,6 +52,25 @@ pub(crate) fn get_stream_type_from_request(
     Ok(stream_type)
 }
 
+/// Whether the request asks to skip the stream sampling rules, only honored
+/// for the admins of the org
+pub(crate) async fn is_sampling_overridden(req: &HttpRequest, org_id: &str) -> bool {
+    if !req
+        .headers()
+        .get(SAMPLING_OVERRIDE_HEADER)
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(is_sampling_override)
+    {
+        return false;
+    }
+    let user_id = req
+        .headers()
+        .get("user_id")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or_default();
+    crate::service::ingestion::can_override_sampling(org_id, user_id).await
+}
+
 #[inline(always)]
 pub(crate) fn get_search_type_from_request(
     query: &Query<HashMap<String, String>>,
@@ -120,17 +141,12 @@ pub(crate) fn get_or_create_trace_id_and_span(
             (trace_id, span)
         } else {
             // manually parse trace_id
-            if let Ok(traceparent_str) = traceparent.to_str() {
-                let parts: Vec<&str> = traceparent_str.split('-').collect();
-                if parts.len() >= 3 {
-                    let trace_id = parts[1].to_string();
-                    // If the trace-id value is invalid (for example if it contains non-allowed
-                    // characters or all zeros), vendors MUST ignore the traceparent.
-                    // https://www.w3.org/TR/trace-context/#traceparent-header
-                    if trace_id.len() == 32 && !trace_id.chars().all(|c| c == '0') {
-                        return (trace_id, None);
-                    }
-                }
+            if let Some(ctx) = traceparent
+                .to_str()
+                .ok()
+                .and_then(super::trace_context::TraceContext::parse)
+            {
+                return (ctx.trace_id, None);
             }
             // If parsing fails or trace_id is invalid, generate a new one
             log::warn!("Failed to parse valid trace_id from received [Traceparent] header");
@@ -14,8 +14,11 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 pub mod auth;
+pub mod aws;
 pub mod functions;
 pub mod http;
 pub mod jwt;
 pub mod stream;
+pub mod totp;
+pub mod trace_context;
 pub mod zo_logger;
@@ -0,0 +1,136 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! W3C trace context of the request being served, so that usage and request
+//! logs written deep in the ingestion and search paths can be correlated with
+//! the caller's trace without threading the ids through every function.
+
+use std::future::Future;
+
+use awc::http::header::HeaderMap;
+use tonic::metadata::MetadataMap;
+
+pub const TRACEPARENT_HEADER: &str = "traceparent";
+
+tokio::task_local! {
+    static CURRENT: TraceContext;
+}
+
+#[derive(Clone, Debug, Default, PartialEq, Eq)]
+pub struct TraceContext {
+    pub trace_id: String,
+    pub parent_span_id: String,
+}
+
+impl TraceContext {
+    /// Parses a `traceparent` value, e.g.
+    /// `00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01`. Invalid
+    /// values are ignored as the spec requires.
+    /// https://www.w3.org/TR/trace-context/#traceparent-header
+    pub fn parse(traceparent: &str) -> Option<Self> {
+        let parts = traceparent.trim().split('-').collect::<Vec<_>>();
+        if parts.len() < 4 {
+            return None;
+        }
+        let (version, trace_id, span_id, flags) = (parts[0], parts[1], parts[2], parts[3]);
+        // version ff is forbidden, version 00 has exactly four fields
+        if !is_hex(version, 2) || version == "ff" || (version == "00" && parts.len() != 4) {
+            return None;
+        }
+        if !is_hex(trace_id, 32) || !is_hex(span_id, 16) || !is_hex(flags, 2) {
+            return None;
+        }
+        if trace_id.bytes().all(|c| c == b'0') || span_id.bytes().all(|c| c == b'0') {
+            return None;
+        }
+        Some(Self {
+            trace_id: trace_id.to_lowercase(),
+            parent_span_id: span_id.to_lowercase(),
+        })
+    }
+
+    pub fn from_http_headers(headers: &HeaderMap) -> Option<Self> {
+        headers
+            .get(TRACEPARENT_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .and_then(Self::parse)
+    }
+
+    pub fn from_grpc_metadata(metadata: &MetadataMap) -> Option<Self> {
+        metadata
+            .get(TRACEPARENT_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .and_then(Self::parse)
+    }
+}
+
+fn is_hex(v: &str, len: usize) -> bool {
+    v.len() == len && v.bytes().all(|c| c.is_ascii_hexdigit())
+}
+
+/// Runs `f` with the given trace context as the current one, a missing
+/// context leaves `f` untouched.
+pub async fn scope<F: Future>(ctx: Option<TraceContext>, f: F) -> F::Output {
+    match ctx {
+        Some(ctx) => CURRENT.scope(ctx, f).await,
+        None => f.await,
+    }
+}
+
+/// The trace context of the request the current task is serving, if the
+/// caller sent one.
+pub fn current() -> Option<TraceContext> {
+    CURRENT.try_with(|ctx| ctx.clone()).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse() {
+        let ctx =
+            TraceContext::parse("00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01").unwrap();
+        assert_eq!(ctx.trace_id, "0af7651916cd43dd8448eb211c80319c");
+        assert_eq!(ctx.parent_span_id, "b7ad6b7169203331");
+        // future versions may append fields
+        assert!(
+            TraceContext::parse("01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-x")
+                .is_some()
+        );
+        for invalid in [
+            "",
+            "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+            "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-x",
+            "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+            "00-00000000000000000000000000000000-b7ad6b7169203331-01",
+            "00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+            "00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+            "00-0af7651916cd43dd8448eb211c80319z-b7ad6b7169203331-01",
+        ] {
+            assert!(TraceContext::parse(invalid).is_none(), "{invalid}");
+        }
+    }
+
+    #[tokio::test]
+    async fn test_scope() {
+        assert!(current().is_none());
+        let ctx = TraceContext::parse("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01");
+        let inner = scope(ctx.clone(), async { current() }).await;
+        assert_eq!(inner, ctx);
+        assert!(scope(None, async { current() }).await.is_none());
+        assert!(current().is_none());
+    }
+}
@@ -37,6 +37,10 @@ pub struct Session {
     pub storage_type: StorageType,
     pub search_type: SearchType,
     pub work_group: Option<String>,
+    /// Data in the files is sorted by `_timestamp` desc, false for streams with clustering keys
+    pub sorted_by_time: bool,
+    /// Primary key of an upsert stream, only the latest version of each key is visible
+    pub primary_key: Option<String>,
 }
 
 #[derive(Clone, Debug, PartialEq, Eq)]
@@ -62,6 +66,9 @@ pub struct Request {
     pub timeout: i64,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub search_type: Option<SearchEventType>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub search_event_context: Option<SearchEventContext>,
 }
 
 #[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
@@ -218,6 +225,14 @@ pub struct Response {
     pub new_start_time: Option<i64>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub new_end_time: Option<i64>,
+    /// cursor of the next page, only set when the request asked for cursor pagination
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub next_cursor: Option<String>,
+    /// status and scan stats of every cluster queried by a super cluster search
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    pub clusters: Vec<ResponseClusterStats>,
 }
 
 #[derive(Clone, Debug, Serialize, Deserialize, Default, ToSchema)]
@@ -238,6 +253,31 @@ pub struct ResponseNodeTook {
     pub took: usize,
 }
 
+#[derive(Clone, Debug, Serialize, Deserialize, Default, ToSchema)]
+pub struct ResponseClusterStats {
+    pub cluster: String,
+    pub region: String,
+    pub status: ClusterSearchStatus,
+    pub took: usize,
+    pub files: i64,
+    pub records: i64,
+    pub scan_size: i64,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub error: String,
+}
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum ClusterSearchStatus {
+    #[default]
+    Ok,
+    Failed,
+    Timeout,
+    /// not queried, the cluster failed too many times recently
+    Skipped,
+}
+
 impl Response {
     pub fn new(from: i64, size: i64) -> Self {
         Response {
@@ -260,6 +300,8 @@ impl Response {
             histogram_interval: None,
             new_start_time: None,
             new_end_time: None,
+            next_cursor: None,
+            clusters: Vec::new(),
         }
     }
 
@@ -475,6 +517,7 @@ impl From<Request> for cluster_rpc::SearchRequest {
             timeout: req.timeout,
             work_group: "".to_string(),
             user_id: None,
+            priority: QueryPriority::from(req.search_type).to_string(),
         }
     }
 }
@@ -507,7 +550,7 @@ impl From<&cluster_rpc::ScanStats> for ScanStats {
     }
 }
 
-#[derive(Hash, Clone, Copy, Debug, Eq, PartialEq, Serialize, Deserialize)]
+#[derive(Hash, Clone, Copy, Debug, Eq, PartialEq, Serialize, Deserialize, ToSchema)]
 pub enum SearchEventType {
     UI,
     Dashboards,
@@ -532,6 +575,116 @@ impl std::fmt::Display for SearchEventType {
     }
 }
 
+/// What ran a query, reported with its usage so that the scanned bytes and the
+/// latency can be attributed to the dashboard panel, alert or report behind it
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+pub struct SearchEventContext {
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub dashboard_id: Option<String>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub panel_id: Option<String>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub alert_key: Option<String>,
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub report_key: Option<String>,
+}
+
+impl SearchEventContext {
+    /// `alert_key` is the scheduler key of the alert,
+    /// `{stream_type}/{stream_name}/{alert_name}`
+    pub fn with_alert(alert_key: String) -> Self {
+        Self {
+            alert_key: Some(alert_key),
+            ..Default::default()
+        }
+    }
+
+    /// Reads the origin sent by the caller along with `search_type`, the
+    /// ids that don't belong to the search type are ignored
+    pub fn from_params(
+        search_type: Option<SearchEventType>,
+        params: &HashMap<String, String>,
+    ) -> Option<Self> {
+        let param = |name: &str| {
+            params
+                .get(name)
+                .map(|v| v.trim())
+                .filter(|v| !v.is_empty())
+                .map(|v| v.to_string())
+        };
+        let ctx = match search_type? {
+            SearchEventType::Dashboards => Self {
+                dashboard_id: param("dashboard_id"),
+                panel_id: param("panel_id"),
+                ..Default::default()
+            },
+            SearchEventType::Alerts => Self {
+                alert_key: param("alert_key"),
+                ..Default::default()
+            },
+            SearchEventType::Reports => Self {
+                report_key: param("report_key"),
+                ..Default::default()
+            },
+            _ => return None,
+        };
+        if ctx == Self::default() {
+            None
+        } else {
+            Some(ctx)
+        }
+    }
+}
+
+/// Priority class of a query, each class has its own concurrency pool on the
+/// queriers and the background queries give way to the interactive ones
+#[derive(Hash, Clone, Copy, Debug, Default, Eq, PartialEq, Serialize, Deserialize)]
+#[serde(rename_all = "lowercase")]
+pub enum QueryPriority {
+    #[default]
+    Interactive,
+    Dashboard,
+    Background,
+}
+
+impl From<Option<SearchEventType>> for QueryPriority {
+    fn from(search_type: Option<SearchEventType>) -> Self {
+        match search_type {
+            Some(SearchEventType::Dashboards) | Some(SearchEventType::RUM) => {
+                QueryPriority::Dashboard
+            }
+            Some(SearchEventType::Reports)
+            | Some(SearchEventType::Alerts)
+            | Some(SearchEventType::Other) => QueryPriority::Background,
+            _ => QueryPriority::Interactive,
+        }
+    }
+}
+
+impl From<&str> for QueryPriority {
+    fn from(s: &str) -> Self {
+        match s.to_lowercase().as_str() {
+            "dashboard" => QueryPriority::Dashboard,
+            "background" => QueryPriority::Background,
+            _ => QueryPriority::Interactive,
+        }
+    }
+}
+
+impl std::fmt::Display for QueryPriority {
+    fn fmt(&self, f: &mut std::fmt::Formatter) -> std::fmt::Result {
+        match self {
+            QueryPriority::Interactive => write!(f, "interactive"),
+            QueryPriority::Dashboard => write!(f, "dashboard"),
+            QueryPriority::Background => write!(f, "background"),
+        }
+    }
+}
+
 #[derive(Clone, Debug, Serialize, Deserialize, ToSchema)]
 pub struct MultiSearchPartitionRequest {
     pub sql: Vec<String>,
@@ -621,6 +774,7 @@ impl MultiStreamRequest {
                 encoding: self.encoding,
                 timeout: self.timeout,
                 search_type: self.search_type,
+                search_event_context: None,
             });
         }
         res
@@ -719,6 +873,7 @@ mod tests {
             clusters: vec![],
             timeout: 0,
             search_type: None,
+            search_event_context: None,
         };
         req.aggs
             .insert("test".to_string(), "SELECT * FROM test".to_string());
@@ -727,5 +882,47 @@ mod tests {
 
         assert_eq!(rpc_req.query.as_ref().unwrap().sql, req.query.sql);
         assert_eq!(rpc_req.query.as_ref().unwrap().size, req.query.size as i32);
+        assert_eq!(rpc_req.priority, "interactive");
+    }
+
+    #[test]
+    fn test_query_priority() {
+        assert_eq!(QueryPriority::from(None), QueryPriority::Interactive);
+        assert_eq!(
+            QueryPriority::from(Some(SearchEventType::UI)),
+            QueryPriority::Interactive
+        );
+        assert_eq!(
+            QueryPriority::from(Some(SearchEventType::Dashboards)),
+            QueryPriority::Dashboard
+        );
+        assert_eq!(
+            QueryPriority::from(Some(SearchEventType::Reports)),
+            QueryPriority::Background
+        );
+        for priority in [
+            QueryPriority::Interactive,
+            QueryPriority::Dashboard,
+            QueryPriority::Background,
+        ] {
+            assert_eq!(QueryPriority::from(priority.to_string().as_str()), priority);
+        }
+    }
+
+    #[test]
+    fn test_search_event_context_from_params() {
+        let params = HashMap::from([
+            ("dashboard_id".to_string(), "7112".to_string()),
+            ("panel_id".to_string(), "Panel_ID1".to_string()),
+            ("alert_key".to_string(), " ".to_string()),
+        ]);
+        let ctx =
+            SearchEventContext::from_params(Some(SearchEventType::Dashboards), &params).unwrap();
+        assert_eq!(ctx.dashboard_id.as_deref(), Some("7112"));
+        assert_eq!(ctx.panel_id.as_deref(), Some("Panel_ID1"));
+        assert!(ctx.alert_key.is_none());
+        assert!(SearchEventContext::from_params(Some(SearchEventType::Alerts), &params).is_none());
+        assert!(SearchEventContext::from_params(Some(SearchEventType::UI), &params).is_none());
+        assert!(SearchEventContext::from_params(None, &params).is_none());
     }
 }
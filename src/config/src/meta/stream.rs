@@ -435,6 +435,174 @@ pub struct StreamSettings {
     pub defined_schema_fields: Option<Vec<String>>,
     #[serde(default)]
     pub max_query_range: i64,
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    #[serde(default)]
+    pub sampling_rules: Vec<SamplingRule>,
+    /// Maximum columns of the stream, fields beyond it are kept in `_overflow`. 0 means no limit
+    #[serde(default)]
+    pub max_columns: usize,
+    /// Parquet writing of the stream, the global defaults when not set
+    #[serde(default)]
+    pub parquet: Option<ParquetSettings>,
+    /// Columns compaction sorts the data of a file by, before `_timestamp`
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    #[serde(default)]
+    pub clustering_keys: Vec<String>,
+    /// Field identifying the entity of a record. When set, searches only see
+    /// the latest version by `_timestamp` of each entity, and can only filter
+    /// by this field and `_timestamp`, without aggregations
+    #[serde(skip_serializing_if = "Option::None")]
+    pub primary_key: Option<String>,
+    /// Collapses the repeated records of the stream into one, logs only
+    #[serde(skip_serializing_if = "Option::None")]
+    pub dedup: Option<DedupSettings>,
+    /// Table format maintained over the parquet files of the stream
+    #[serde(default)]
+    pub table_format: TableFormat,
+    /// Metrics only, resolution of the samples in milliseconds. The sample
+    /// timestamps are aligned down to it and it is the minimal step of the
+    /// queries. 0 keeps the timestamps as sent, with the default minimal step
+    #[serde(default)]
+    pub resolution_ms: i64,
+    /// Fields of the query results each role can see, every field for the
+    /// roles without a rule
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    #[serde(default)]
+    pub field_access: Vec<FieldAccessRule>,
+}
+
+/// Metadata kept alongside the parquet files of a stream so other engines
+/// can read them as a table
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize, ToSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum TableFormat {
+    /// Only the file list of OpenObserve
+    #[default]
+    Native,
+    /// Apache Iceberg table, committed periodically by the compactor
+    Iceberg,
+}
+
+/// Buckets of the default trace_id partition of the traces. It can't change
+/// as the searches hash the trace_id of their filter the same way to find the
+/// files of a trace.
+pub const TRACE_ID_PARTITIONS: u64 = 16;
+
+impl StreamSettings {
+    /// Partition keys the filters of a search are matched against, the traces
+    /// may also be partitioned by a hash of their trace_id
+    pub fn search_partition_keys(&self, stream_type: StreamType) -> Vec<StreamPartition> {
+        let mut keys = self.partition_keys.clone();
+        if stream_type == StreamType::Traces && !keys.iter().any(|k| k.field == "trace_id") {
+            keys.push(StreamPartition::new_hash("trace_id", TRACE_ID_PARTITIONS));
+        }
+        keys
+    }
+}
+
+/// Field counting the records a record of a deduplicated stream stands for
+pub const REPEAT_COUNT_FIELD: &str = "repeat_count";
+
+/// Collapses the consecutive records of an ingestion request repeating each
+/// other into the first one, which counts them in its [REPEAT_COUNT_FIELD]
+/// like syslog's "last message repeated N times"
+#[derive(Clone, Debug, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct DedupSettings {
+    /// Fields compared, every field but `_timestamp` when empty. The values
+    /// of the other fields of the collapsed records are lost.
+    #[serde(default)]
+    pub fields: Vec<String>,
+    /// Seconds after the first record beyond which a repeat starts a new
+    /// record
+    #[serde(default = "default_dedup_window")]
+    pub window: i64,
+}
+
+impl Default for DedupSettings {
+    fn default() -> Self {
+        Self {
+            fields: vec![],
+            window: default_dedup_window(),
+        }
+    }
+}
+
+fn default_dedup_window() -> i64 {
+    10
+}
+
+impl DedupSettings {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.window <= 0 {
+            return Err("window should be at least 1 second".to_string());
+        }
+        if self.fields.iter().any(|f| f.trim().is_empty()) {
+            return Err("fields can't be empty".to_string());
+        }
+        Ok(())
+    }
+
+    /// Returns true when the record repeats the first record of a series
+    /// within the window
+    pub fn is_repeat(&self, first: &Map<String, Value>, record: &Map<String, Value>) -> bool {
+        let column_timestamp = &get_config().common.column_timestamp;
+        let timestamp = |r: &Map<String, Value>| {
+            r.get(column_timestamp)
+                .and_then(|v| v.as_i64())
+                .unwrap_or_default()
+        };
+        if (timestamp(record) - timestamp(first)).abs() > self.window * 1_000_000 {
+            return false;
+        }
+        if !self.fields.is_empty() {
+            return self.fields.iter().all(|f| first.get(f) == record.get(f));
+        }
+        let ignored = |k: &String| k == column_timestamp || k == REPEAT_COUNT_FIELD;
+        first.keys().filter(|k| !ignored(k)).count()
+            == record.keys().filter(|k| !ignored(k)).count()
+            && record
+                .iter()
+                .all(|(k, v)| ignored(k) || first.get(k) == Some(v))
+    }
+}
+
+/// Trades CPU for storage when writing the parquet files of a stream, the
+/// zero values keep the global defaults
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct ParquetSettings {
+    /// zstd compression level between 1 and 22
+    #[serde(default)]
+    pub zstd_level: i32,
+    /// Maximum rows of a row group
+    #[serde(default)]
+    pub max_row_group_size: usize,
+    /// Columns written without dictionary encoding, e.g. high cardinality ids
+    #[serde(default)]
+    pub dictionary_disabled_fields: Vec<String>,
+    /// Float columns written with the byte stream split encoding, which
+    /// compresses much better the slowly changing values, e.g. metric samples
+    #[serde(default)]
+    pub byte_stream_split_fields: Vec<String>,
+    /// False positive probability of the bloom filters of the stream
+    #[serde(default)]
+    pub bloom_filter_fpp: Option<f64>,
+}
+
+impl ParquetSettings {
+    pub fn validate(&self) -> Result<(), String> {
+        if !(0..=22).contains(&self.zstd_level) {
+            return Err("zstd_level should be between 1 and 22".to_string());
+        }
+        if self.max_row_group_size > 0 && self.max_row_group_size < 1024 {
+            return Err("max_row_group_size should be at least 1024".to_string());
+        }
+        if let Some(fpp) = self.bloom_filter_fpp {
+            if fpp <= 0.0 || fpp >= 1.0 {
+                return Err("bloom_filter_fpp should be between 0 and 1".to_string());
+            }
+        }
+        Ok(())
+    }
 }
 
 impl Serialize for StreamSettings {
@@ -477,6 +645,60 @@ impl Serialize for StreamSettings {
                 state.skip_field("flatten_level")?;
             }
         }
+        if !self.sampling_rules.is_empty() {
+            state.serialize_field("sampling_rules", &self.sampling_rules)?;
+        } else {
+            state.skip_field("sampling_rules")?;
+        }
+        if self.max_columns > 0 {
+            state.serialize_field("max_columns", &self.max_columns)?;
+        } else {
+            state.skip_field("max_columns")?;
+        }
+        match self.parquet.as_ref() {
+            Some(parquet) => {
+                state.serialize_field("parquet", parquet)?;
+            }
+            None => {
+                state.skip_field("parquet")?;
+            }
+        }
+        if !self.clustering_keys.is_empty() {
+            state.serialize_field("clustering_keys", &self.clustering_keys)?;
+        } else {
+            state.skip_field("clustering_keys")?;
+        }
+        match self.primary_key.as_ref() {
+            Some(primary_key) => {
+                state.serialize_field("primary_key", primary_key)?;
+            }
+            None => {
+                state.skip_field("primary_key")?;
+            }
+        }
+        match self.dedup.as_ref() {
+            Some(dedup) => {
+                state.serialize_field("dedup", dedup)?;
+            }
+            None => {
+                state.skip_field("dedup")?;
+            }
+        }
+        if self.table_format != TableFormat::Native {
+            state.serialize_field("table_format", &self.table_format)?;
+        } else {
+            state.skip_field("table_format")?;
+        }
+        if self.resolution_ms > 0 {
+            state.serialize_field("resolution_ms", &self.resolution_ms)?;
+        } else {
+            state.skip_field("resolution_ms")?;
+        }
+        if !self.field_access.is_empty() {
+            state.serialize_field("field_access", &self.field_access)?;
+        } else {
+            state.skip_field("field_access")?;
+        }
         state.end()
     }
 }
@@ -552,6 +774,50 @@ impl From<&str> for StreamSettings {
 
         let flatten_level = settings.get("flatten_level").map(|v| v.as_i64().unwrap());
 
+        let sampling_rules = settings
+            .get("sampling_rules")
+            .and_then(|v| json::from_value(v.clone()).ok())
+            .unwrap_or_default();
+
+        let max_columns = settings
+            .get("max_columns")
+            .and_then(|v| v.as_u64())
+            .unwrap_or_default() as usize;
+
+        let parquet = settings
+            .get("parquet")
+            .and_then(|v| json::from_value(v.clone()).ok());
+
+        let clustering_keys = settings
+            .get("clustering_keys")
+            .and_then(|v| json::from_value(v.clone()).ok())
+            .unwrap_or_default();
+
+        let primary_key = settings
+            .get("primary_key")
+            .and_then(|v| v.as_str())
+            .filter(|v| !v.is_empty())
+            .map(|v| v.to_string());
+
+        let dedup = settings
+            .get("dedup")
+            .and_then(|v| json::from_value(v.clone()).ok());
+
+        let table_format = settings
+            .get("table_format")
+            .and_then(|v| json::from_value(v.clone()).ok())
+            .unwrap_or_default();
+
+        let resolution_ms = settings
+            .get("resolution_ms")
+            .and_then(|v| v.as_i64())
+            .unwrap_or_default();
+
+        let field_access = settings
+            .get("field_access")
+            .and_then(|v| json::from_value(v.clone()).ok())
+            .unwrap_or_default();
+
         Self {
             partition_keys,
             partition_time_level,
@@ -561,10 +827,78 @@ impl From<&str> for StreamSettings {
             max_query_range,
             flatten_level,
             defined_schema_fields,
+            sampling_rules,
+            max_columns,
+            parquet,
+            clustering_keys,
+            primary_key,
+            dedup,
+            table_format,
+            resolution_ms,
+            field_access,
         }
     }
 }
 
+/// Fields of the query results a role can see, so a sensitive stream can
+/// serve the security analysts with every field and the developers without
+/// the personal data. `*` in the patterns matches any characters.
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct FieldAccessRule {
+    /// Role of the users the rule applies to, e.g. `member` or `viewer`. The
+    /// admins always see every field.
+    pub role: String,
+    /// Patterns of the visible fields, every field when empty
+    #[serde(default)]
+    pub allow: Vec<String>,
+    /// Patterns of the fields hidden even when allowed
+    #[serde(default)]
+    pub deny: Vec<String>,
+}
+
+impl FieldAccessRule {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.role.trim().is_empty() {
+            return Err("role can't be empty".to_string());
+        }
+        if self
+            .allow
+            .iter()
+            .chain(self.deny.iter())
+            .any(|p| p.trim().is_empty())
+        {
+            return Err("field patterns can't be empty".to_string());
+        }
+        if self.allow.is_empty() && self.deny.is_empty() {
+            return Err("allow or deny should have a pattern".to_string());
+        }
+        Ok(())
+    }
+}
+
+/// Ingest time sampling rule, the first rule matching a record decides the
+/// rate at which it is kept. Records not matching any rule are always kept.
+#[derive(Clone, Debug, Default, PartialEq, Serialize, Deserialize, ToSchema)]
+pub struct SamplingRule {
+    pub name: String,
+    /// All the conditions should match, a rule without conditions matches every record
+    #[serde(default)]
+    pub conditions: Vec<RoutingCondition>,
+    /// Fraction of the matching records to keep, between 0.0 and 1.0
+    pub rate: f64,
+}
+
+impl SamplingRule {
+    pub async fn matches(&self, row: &Map<String, Value>) -> bool {
+        for condition in self.conditions.iter() {
+            if !condition.evaluate(row).await {
+                return false;
+            }
+        }
+        true
+    }
+}
+
 #[derive(Clone, Debug, Default, Hash, PartialEq, Serialize, Deserialize, ToSchema)]
 pub struct StreamPartition {
     pub field: String,
@@ -772,6 +1106,266 @@ mod tests {
         assert_eq!(file_meta, resp);
     }
 
+    #[test]
+    fn test_stream_settings_sampling_rules() {
+        let settings = StreamSettings {
+            sampling_rules: vec![SamplingRule {
+                name: "debug".to_string(),
+                conditions: vec![RoutingCondition {
+                    column: "level".to_string(),
+                    operator: Operator::EqualTo,
+                    value: Value::String("debug".to_string()),
+                    ignore_case: false,
+                }],
+                rate: 0.1,
+            }],
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.sampling_rules, settings.sampling_rules);
+
+        let resp = StreamSettings::from(r#"{"data_retention":1}"#);
+        assert!(resp.sampling_rules.is_empty());
+    }
+
+    #[test]
+    fn test_stream_settings_max_columns() {
+        let settings = StreamSettings {
+            max_columns: 200,
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        assert!(data.contains("\"max_columns\":200"));
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.max_columns, 200);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("max_columns"));
+    }
+
+    #[test]
+    fn test_stream_settings_parquet() {
+        let settings = StreamSettings {
+            parquet: Some(ParquetSettings {
+                zstd_level: 9,
+                dictionary_disabled_fields: vec!["request_id".to_string()],
+                ..Default::default()
+            }),
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.parquet, settings.parquet);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("parquet"));
+        assert!(StreamSettings::from(data.as_str()).parquet.is_none());
+    }
+
+    #[test]
+    fn test_stream_settings_clustering_keys() {
+        let settings = StreamSettings {
+            clustering_keys: vec!["service".to_string(), "level".to_string()],
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.clustering_keys, settings.clustering_keys);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("clustering_keys"));
+    }
+
+    #[test]
+    fn test_stream_settings_primary_key() {
+        let settings = StreamSettings {
+            primary_key: Some("asset_id".to_string()),
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.primary_key.as_deref(), Some("asset_id"));
+
+        let resp = StreamSettings::from(r#"{"primary_key":""}"#);
+        assert!(resp.primary_key.is_none());
+    }
+
+    #[test]
+    fn test_search_partition_keys() {
+        let settings = StreamSettings {
+            partition_keys: vec![StreamPartition::new("service_name")],
+            ..Default::default()
+        };
+        assert_eq!(
+            settings.search_partition_keys(StreamType::Logs),
+            settings.partition_keys
+        );
+        let keys = settings.search_partition_keys(StreamType::Traces);
+        assert_eq!(keys.len(), 2);
+        assert_eq!(
+            keys[1],
+            StreamPartition::new_hash("trace_id", TRACE_ID_PARTITIONS)
+        );
+
+        let settings = StreamSettings {
+            partition_keys: vec![StreamPartition::new("trace_id")],
+            ..Default::default()
+        };
+        assert_eq!(
+            settings.search_partition_keys(StreamType::Traces),
+            settings.partition_keys
+        );
+    }
+
+    #[test]
+    fn test_stream_settings_dedup() {
+        let settings = StreamSettings {
+            dedup: Some(DedupSettings {
+                fields: vec!["message".to_string()],
+                window: 30,
+            }),
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.dedup, settings.dedup);
+
+        let resp = StreamSettings::from(r#"{"dedup":{}}"#);
+        assert_eq!(resp.dedup, Some(DedupSettings::default()));
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("dedup"));
+    }
+
+    #[test]
+    fn test_stream_settings_table_format() {
+        let settings = StreamSettings {
+            table_format: TableFormat::Iceberg,
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.table_format, TableFormat::Iceberg);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("table_format"));
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.table_format, TableFormat::Native);
+    }
+
+    #[test]
+    fn test_stream_settings_resolution() {
+        let settings = StreamSettings {
+            resolution_ms: 100,
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.resolution_ms, 100);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("resolution_ms"));
+    }
+
+    #[test]
+    fn test_stream_settings_field_access() {
+        let settings = StreamSettings {
+            field_access: vec![FieldAccessRule {
+                role: "member".to_string(),
+                allow: vec![],
+                deny: vec!["user_*".to_string()],
+            }],
+            ..Default::default()
+        };
+        let data = json::to_string(&settings).unwrap();
+        let resp = StreamSettings::from(data.as_str());
+        assert_eq!(resp.field_access, settings.field_access);
+
+        let data = json::to_string(&StreamSettings::default()).unwrap();
+        assert!(!data.contains("field_access"));
+
+        assert!(settings.field_access[0].validate().is_ok());
+        let rule = FieldAccessRule {
+            role: "member".to_string(),
+            ..Default::default()
+        };
+        assert!(rule.validate().is_err());
+    }
+
+    #[test]
+    fn test_dedup_is_repeat() {
+        let record = |ts: i64, message: &str, host: &str| {
+            json::json!({"_timestamp": ts, "message": message, "host": host})
+                .as_object()
+                .unwrap()
+                .clone()
+        };
+        let dedup = DedupSettings::default();
+        let first = record(1_000_000, "disk full", "a");
+        assert!(dedup.is_repeat(&first, &record(5_000_000, "disk full", "a")));
+        assert!(!dedup.is_repeat(&first, &record(12_000_000, "disk full", "a")));
+        assert!(!dedup.is_repeat(&first, &record(2_000_000, "disk full", "b")));
+        assert!(!dedup.is_repeat(&first, &record(2_000_000, "disk ok", "a")));
+        // the count of the first record doesn't matter
+        let mut counted = first.clone();
+        counted.insert(REPEAT_COUNT_FIELD.to_string(), 3.into());
+        assert!(dedup.is_repeat(&counted, &record(2_000_000, "disk full", "a")));
+        let mut extra = record(2_000_000, "disk full", "a");
+        extra.insert("code".to_string(), 1.into());
+        assert!(!dedup.is_repeat(&first, &extra));
+
+        let dedup = DedupSettings {
+            fields: vec!["message".to_string()],
+            ..Default::default()
+        };
+        assert!(dedup.is_repeat(&first, &record(2_000_000, "disk full", "b")));
+
+        assert!(DedupSettings::default().validate().is_ok());
+        assert!(DedupSettings {
+            window: 0,
+            ..Default::default()
+        }
+        .validate()
+        .is_err());
+    }
+
+    #[test]
+    fn test_parquet_settings_validate() {
+        assert!(ParquetSettings::default().validate().is_ok());
+        let settings = |zstd_level, max_row_group_size, bloom_filter_fpp| ParquetSettings {
+            zstd_level,
+            max_row_group_size,
+            bloom_filter_fpp,
+            ..Default::default()
+        };
+        assert!(settings(22, 8192, Some(0.05)).validate().is_ok());
+        assert!(settings(23, 0, None).validate().is_err());
+        assert!(settings(0, 100, None).validate().is_err());
+        assert!(settings(0, 0, Some(1.0)).validate().is_err());
+    }
+
+    #[tokio::test]
+    async fn test_sampling_rule_matches() {
+        let rule = SamplingRule {
+            name: "health".to_string(),
+            conditions: vec![RoutingCondition {
+                column: "path".to_string(),
+                operator: Operator::Contains,
+                value: Value::String("/healthz".to_string()),
+                ignore_case: false,
+            }],
+            rate: 0.01,
+        };
+        let mut row = Map::new();
+        row.insert(
+            "path".to_string(),
+            Value::String("/api/healthz".to_string()),
+        );
+        assert!(rule.matches(&row).await);
+        row.insert("path".to_string(), Value::String("/api/users".to_string()));
+        assert!(!rule.matches(&row).await);
+    }
+
     #[cfg(feature = "gxhash")]
     #[test]
     fn test_hash_partition() {
@@ -15,7 +15,7 @@
 
 use serde::{Deserialize, Serialize};
 
-use super::search::SearchEventType;
+use super::search::{SearchEventContext, SearchEventType};
 use crate::{
     meta::stream::{FileMeta, StreamType},
     SIZE_IN_MB,
@@ -41,6 +41,8 @@ pub enum TriggerDataType {
     Report,
     #[serde(rename = "alert")]
     Alert,
+    #[serde(rename = "etl")]
+    Etl,
 }
 
 #[derive(Clone, Debug, PartialEq, Serialize, Deserialize)]
@@ -78,6 +80,10 @@ pub struct UsageData {
     pub stream_name: String,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub trace_id: Option<String>,
+    /// The span of the caller that sent the request, from its `traceparent`
+    #[serde(default)]
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub parent_span_id: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub cached_ratio: Option<usize>,
     #[serde(skip_serializing_if = "Option::is_none")]
@@ -88,6 +94,8 @@ pub struct UsageData {
     pub max_ts: Option<i64>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub search_type: Option<SearchEventType>,
+    #[serde(flatten)]
+    pub search_event_context: Option<SearchEventContext>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub took_wait_in_queue: Option<usize>,
 }
@@ -231,6 +239,8 @@ pub struct RequestStats {
     #[serde(skip_serializing_if = "Option::is_none")]
     pub search_type: Option<SearchEventType>,
     #[serde(skip_serializing_if = "Option::is_none")]
+    pub search_event_context: Option<SearchEventContext>,
+    #[serde(skip_serializing_if = "Option::is_none")]
     pub trace_id: Option<String>,
     #[serde(skip_serializing_if = "Option::is_none")]
     pub took_wait_in_queue: Option<usize>,
@@ -248,6 +258,7 @@ impl Default for RequestStats {
             max_ts: None,
             user_email: None,
             search_type: None,
+            search_event_context: None,
             trace_id: None,
             took_wait_in_queue: None,
         }
@@ -267,6 +278,7 @@ impl From<FileMeta> for RequestStats {
             max_ts: Some(meta.max_ts),
             user_email: None,
             search_type: None,
+            search_event_context: None,
             trace_id: None,
             took_wait_in_queue: None,
         }
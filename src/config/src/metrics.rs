@@ -123,6 +123,80 @@ pub static INGEST_BYTES: Lazy<IntCounterVec> = Lazy::new(|| {
     )
     .expect("Metric created")
 });
+pub static INGEST_SCHEMA_VIOLATIONS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_schema_violations",
+            "Ingested records violating the stream schema contract. ".to_owned() + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type", "producer"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_SAMPLED_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_sampled_records",
+            "Records dropped by ingest sampling rules. ".to_owned() + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type", "rule"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_DEDUP_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_dedup_records",
+            "Repeated records collapsed by stream deduplication. ".to_owned() + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_OVERFLOW_FIELDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_overflow_fields",
+            "Fields moved to the overflow field by the stream column limit. ".to_owned()
+                + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_STREAM_COLUMNS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "ingest_stream_columns",
+            "Columns in the stream schema. ".to_owned() + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_FUNCTION_SHADOW_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_function_shadow_records",
+            "Records evaluated by the shadow version of a function, by whether the output matched the active version. ".to_owned()
+                + HELP_SUFFIX,
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "function", "result"],
+    )
+    .expect("Metric created")
+});
 pub static INGEST_WAL_USED_BYTES: Lazy<IntGaugeVec> = Lazy::new(|| {
     IntGaugeVec::new(
         Opts::new(
@@ -195,6 +269,69 @@ pub static INGEST_MEMTABLE_FILES: Lazy<IntGaugeVec> = Lazy::new(|| {
     )
     .expect("Metric created")
 });
+pub static INGEST_MEMTABLE_STREAM_BYTES: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "ingest_memtable_stream_bytes",
+            "Ingestor arrow format in memory bytes of a stream.".to_owned(),
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_MEMTABLE_STREAM_RECORDS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "ingest_memtable_stream_records",
+            "Ingestor in memory records of a stream.".to_owned(),
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_MEMTABLE_STREAM_AGE_SECONDS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "ingest_memtable_stream_age_seconds",
+            "Seconds since the oldest in memory records of a stream were ingested.".to_owned(),
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream", "stream_type"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_WATERMARK_LATENCY_SECONDS: Lazy<HistogramVec> = Lazy::new(|| {
+    HistogramVec::new(
+        HistogramOpts::new(
+            "ingest_watermark_latency_seconds",
+            "Seconds between the ingestion of a watermark record and the time it was queryable.",
+        )
+        .namespace(NAMESPACE)
+        .buckets(vec![
+            1.0, 2.0, 5.0, 10.0, 20.0, 30.0, 60.0, 120.0, 300.0, 600.0,
+        ])
+        .const_labels(create_const_labels()),
+        &["organization", "stream"],
+    )
+    .expect("Metric created")
+});
+pub static INGEST_WATERMARK_LOST: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "ingest_watermark_lost",
+            "Watermark records not queryable within the timeout.".to_owned(),
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "stream"],
+    )
+    .expect("Metric created")
+});
 
 pub static INGEST_MEMTABLE_LOCK_TIME: Lazy<HistogramVec> = Lazy::new(|| {
     HistogramVec::new(
@@ -554,6 +691,89 @@ pub static META_NUM_DASHBOARDS: Lazy<IntGaugeVec> = Lazy::new(|| {
     .expect("Metric created")
 });
 
+// log pullers
+pub static LOG_PULLER_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "log_puller_records",
+            "Records pulled from the cloud providers",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "puller", "provider"],
+    )
+    .expect("Metric created")
+});
+pub static LOG_PULLER_ERRORS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new("log_puller_errors", "Failed runs of the log pullers")
+            .namespace(NAMESPACE)
+            .const_labels(create_const_labels()),
+        &["organization", "puller", "provider"],
+    )
+    .expect("Metric created")
+});
+pub static LOG_PULLER_LAG_SECONDS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "log_puller_lag_seconds",
+            "Seconds between now and the checkpoint of the log pullers",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "puller", "provider"],
+    )
+    .expect("Metric created")
+});
+pub static SUBSCRIPTION_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "subscription_records",
+            "Records forwarded by the stream subscriptions",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "subscription", "sink"],
+    )
+    .expect("Metric created")
+});
+pub static SUBSCRIPTION_DROPPED_RECORDS: Lazy<IntCounterVec> = Lazy::new(|| {
+    IntCounterVec::new(
+        Opts::new(
+            "subscription_dropped_records",
+            "Matched records the stream subscriptions could not forward",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "subscription", "sink"],
+    )
+    .expect("Metric created")
+});
+pub static SUBSCRIPTION_PENDING_RECORDS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "subscription_pending_records",
+            "Matched records waiting in the queue of the stream subscriptions",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "subscription", "sink"],
+    )
+    .expect("Metric created")
+});
+pub static SUBSCRIPTION_LAG_SECONDS: Lazy<IntGaugeVec> = Lazy::new(|| {
+    IntGaugeVec::new(
+        Opts::new(
+            "subscription_lag_seconds",
+            "Seconds between the ingestion and the delivery of the oldest record of the last batch forwarded by the stream subscriptions",
+        )
+        .namespace(NAMESPACE)
+        .const_labels(create_const_labels()),
+        &["organization", "subscription", "sink"],
+    )
+    .expect("Metric created")
+});
+
 pub static MEMORY_USAGE: Lazy<IntGaugeVec> = Lazy::new(|| {
     IntGaugeVec::new(
         Opts::new("memory_usage", "Process memory usage")
@@ -588,6 +808,24 @@ fn register_metrics(registry: &Registry) {
     registry
         .register(Box::new(INGEST_BYTES.clone()))
         .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_SCHEMA_VIOLATIONS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_SAMPLED_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_DEDUP_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_OVERFLOW_FIELDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_STREAM_COLUMNS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_FUNCTION_SHADOW_RECORDS.clone()))
+        .expect("Metric registered");
     registry
         .register(Box::new(INGEST_WAL_USED_BYTES.clone()))
         .expect("Metric registered");
@@ -606,6 +844,21 @@ fn register_metrics(registry: &Registry) {
     registry
         .register(Box::new(INGEST_MEMTABLE_FILES.clone()))
         .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_MEMTABLE_STREAM_BYTES.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_MEMTABLE_STREAM_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_MEMTABLE_STREAM_AGE_SECONDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_WATERMARK_LATENCY_SECONDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(INGEST_WATERMARK_LOST.clone()))
+        .expect("Metric registered");
     registry
         .register(Box::new(INGEST_MEMTABLE_LOCK_TIME.clone()))
         .expect("Metric registered");
@@ -706,6 +959,27 @@ fn register_metrics(registry: &Registry) {
     registry
         .register(Box::new(META_NUM_DASHBOARDS.clone()))
         .expect("Metric registered");
+    registry
+        .register(Box::new(LOG_PULLER_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(LOG_PULLER_ERRORS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(LOG_PULLER_LAG_SECONDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(SUBSCRIPTION_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(SUBSCRIPTION_DROPPED_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(SUBSCRIPTION_PENDING_RECORDS.clone()))
+        .expect("Metric registered");
+    registry
+        .register(Box::new(SUBSCRIPTION_LAG_SECONDS.clone()))
+        .expect("Metric registered");
     registry
         .register(Box::new(MEMORY_USAGE.clone()))
         .expect("Metric registered");
@@ -0,0 +1,140 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Faults injected on this node by the resilience tests, see
+//! `ZO_FAULT_INJECTION_ENABLED`: delayed object storage calls, failed wal
+//! writes and dropped RPCs from the other nodes.
+//!
+//! They are kept in the memory of the node, not in the meta store, so a test
+//! can break a single node of the cluster, and are lost on restart.
+
+use std::{
+    sync::atomic::{AtomicU64, Ordering},
+    time::Duration,
+};
+
+use serde::{Deserialize, Serialize};
+
+/// Longest delay of the object storage calls, in milliseconds
+pub const MAX_STORAGE_DELAY_MS: u64 = 60_000;
+
+static STORAGE_DELAY_MS: AtomicU64 = AtomicU64::new(0);
+static WAL_WRITE_FAILURE_PERCENT: AtomicU64 = AtomicU64::new(0);
+static RPC_DROP_PERCENT: AtomicU64 = AtomicU64::new(0);
+
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Faults {
+    /// Delay added to every object storage call, in milliseconds
+    #[serde(default)]
+    pub storage_delay_ms: u64,
+    /// Percent of the wal writes failing
+    #[serde(default)]
+    pub wal_write_failure_percent: u64,
+    /// Percent of the RPCs from the other nodes dropped
+    #[serde(default)]
+    pub rpc_drop_percent: u64,
+}
+
+impl Faults {
+    pub fn validate(&self) -> Result<(), String> {
+        if self.storage_delay_ms > MAX_STORAGE_DELAY_MS {
+            return Err(format!(
+                "storage_delay_ms should be at most {MAX_STORAGE_DELAY_MS}"
+            ));
+        }
+        if self.wal_write_failure_percent > 100 || self.rpc_drop_percent > 100 {
+            return Err("the percents should be between 0 and 100".to_string());
+        }
+        Ok(())
+    }
+
+    pub fn is_empty(&self) -> bool {
+        *self == Faults::default()
+    }
+}
+
+/// Returns the faults injected on this node
+pub fn get() -> Faults {
+    Faults {
+        storage_delay_ms: STORAGE_DELAY_MS.load(Ordering::Relaxed),
+        wal_write_failure_percent: WAL_WRITE_FAILURE_PERCENT.load(Ordering::Relaxed),
+        rpc_drop_percent: RPC_DROP_PERCENT.load(Ordering::Relaxed),
+    }
+}
+
+/// Replaces the faults injected on this node, none with the default
+pub fn set(faults: Faults) {
+    STORAGE_DELAY_MS.store(faults.storage_delay_ms, Ordering::Relaxed);
+    WAL_WRITE_FAILURE_PERCENT.store(faults.wal_write_failure_percent, Ordering::Relaxed);
+    RPC_DROP_PERCENT.store(faults.rpc_drop_percent, Ordering::Relaxed);
+}
+
+/// Returns the delay to add to an object storage call
+#[inline(always)]
+pub fn storage_delay() -> Option<Duration> {
+    match STORAGE_DELAY_MS.load(Ordering::Relaxed) {
+        0 => None,
+        ms => Some(Duration::from_millis(ms)),
+    }
+}
+
+/// Returns true when the wal write should fail
+#[inline(always)]
+pub fn fail_wal_write() -> bool {
+    roll(WAL_WRITE_FAILURE_PERCENT.load(Ordering::Relaxed))
+}
+
+/// Returns true when the RPC from another node should be dropped
+#[inline(always)]
+pub fn drop_rpc() -> bool {
+    roll(RPC_DROP_PERCENT.load(Ordering::Relaxed))
+}
+
+fn roll(percent: u64) -> bool {
+    match percent {
+        0 => false,
+        100.. => true,
+        p => rand::random::<u64>() % 100 < p,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_roll() {
+        assert!(!roll(0));
+        assert!(roll(100));
+        let hits = (0..10_000).filter(|_| roll(30)).count();
+        assert!((2_500..3_500).contains(&hits), "{hits} hits of 30%");
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(Faults::default().validate().is_ok());
+        assert!(Faults::default().is_empty());
+        let faults = Faults {
+            rpc_drop_percent: 101,
+            ..Default::default()
+        };
+        assert!(faults.validate().is_err());
+        let faults = Faults {
+            storage_delay_ms: MAX_STORAGE_DELAY_MS + 1,
+            ..Default::default()
+        };
+        assert!(faults.validate().is_err());
+    }
+}
@@ -17,6 +17,7 @@ pub mod arrow;
 pub mod asynchronism;
 pub mod base64;
 pub mod cgroup;
+pub mod faults;
 pub mod file;
 pub mod flatten;
 pub mod hash;
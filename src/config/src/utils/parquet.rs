@@ -25,11 +25,15 @@ use arrow_schema::Schema;
 use futures::TryStreamExt;
 use parquet::{
     arrow::{arrow_reader::ArrowReaderMetadata, AsyncArrowWriter, ParquetRecordBatchStreamBuilder},
-    basic::{Compression, Encoding},
+    basic::{Compression, Encoding, ZstdLevel},
     file::{metadata::KeyValue, properties::WriterProperties},
 };
 
-use crate::{config::*, ider, meta::stream::FileMeta};
+use crate::{
+    config::*,
+    ider,
+    meta::stream::{FileMeta, ParquetSettings},
+};
 
 pub fn new_parquet_writer<'a>(
     buf: &'a mut Vec<u8>,
@@ -37,18 +41,26 @@ pub fn new_parquet_writer<'a>(
     bloom_filter_fields: &'a [String],
     full_text_search_fields: &'a [String],
     metadata: &'a FileMeta,
+    parquet_settings: Option<&'a ParquetSettings>,
 ) -> AsyncArrowWriter<&'a mut Vec<u8>> {
     let cfg = get_config();
-    let row_group_size = if cfg.limit.parquet_max_row_group_size > 0 {
+    let parquet_settings = parquet_settings.cloned().unwrap_or_default();
+    let row_group_size = if parquet_settings.max_row_group_size > 0 {
+        parquet_settings.max_row_group_size
+    } else if cfg.limit.parquet_max_row_group_size > 0 {
         cfg.limit.parquet_max_row_group_size
     } else {
         PARQUET_MAX_ROW_GROUP_SIZE
     };
+    let zstd_level = ZstdLevel::try_new(parquet_settings.zstd_level).unwrap_or_default();
+    let bf_fpp = parquet_settings
+        .bloom_filter_fpp
+        .unwrap_or(DEFAULT_BLOOM_FILTER_FPP);
     let mut writer_props = WriterProperties::builder()
         .set_write_batch_size(PARQUET_BATCH_SIZE) // in bytes
         .set_data_page_size_limit(PARQUET_PAGE_SIZE) // maximum size of a data page in bytes
         .set_max_row_group_size(row_group_size) // maximum number of rows in a row group
-        .set_compression(Compression::ZSTD(Default::default()))
+        .set_compression(Compression::ZSTD(zstd_level))
         .set_column_dictionary_enabled(
             cfg.common.column_timestamp.as_str().into(),
             false,
@@ -72,6 +84,23 @@ pub fn new_parquet_writer<'a>(
     for field in full_text_search_fields.iter() {
         writer_props = writer_props.set_column_dictionary_enabled(field.as_str().into(), false);
     }
+    for field in parquet_settings.dictionary_disabled_fields.iter() {
+        writer_props = writer_props.set_column_dictionary_enabled(field.as_str().into(), false);
+    }
+    for field in parquet_settings.byte_stream_split_fields.iter() {
+        // the encoding only applies to the float columns
+        let is_float = schema.field_with_name(field).is_ok_and(|f| {
+            matches!(
+                f.data_type(),
+                arrow::datatypes::DataType::Float32 | arrow::datatypes::DataType::Float64
+            )
+        });
+        if is_float {
+            writer_props = writer_props
+                .set_column_dictionary_enabled(field.as_str().into(), false)
+                .set_column_encoding(field.as_str().into(), Encoding::BYTE_STREAM_SPLIT);
+        }
+    }
     // Bloom filter stored by row_group, set NDV to reduce the memory usage.
     // In this link, it says that the optimal number of NDV is 1000, here we use rg_size / NDV_RATIO
     // refer: https://www.influxdata.com/blog/using-parquets-bloom-filters/
@@ -102,7 +131,7 @@ pub fn new_parquet_writer<'a>(
         for field in fields {
             writer_props = writer_props
                 .set_column_bloom_filter_enabled(field.as_str().into(), true)
-                .set_column_bloom_filter_fpp(field.as_str().into(), DEFAULT_BLOOM_FILTER_FPP)
+                .set_column_bloom_filter_fpp(field.as_str().into(), bf_fpp)
                 .set_column_bloom_filter_ndv(field.into(), bf_ndv); // take the field ownership
         }
     }
@@ -116,6 +145,7 @@ pub async fn write_recordbatch_to_parquet(
     bloom_filter_fields: &[String],
     full_text_search_fields: &[String],
     metadata: &FileMeta,
+    parquet_settings: Option<&ParquetSettings>,
 ) -> Result<Vec<u8>, anyhow::Error> {
     let mut buf = Vec::new();
     let mut writer = new_parquet_writer(
@@ -124,6 +154,7 @@ pub async fn write_recordbatch_to_parquet(
         bloom_filter_fields,
         full_text_search_fields,
         metadata,
+        parquet_settings,
     );
     for batch in record_batches {
         writer.write(batch).await?;
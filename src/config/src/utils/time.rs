@@ -13,6 +13,8 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+use std::sync::atomic::{AtomicI64, Ordering};
+
 use chrono::{DateTime, NaiveDateTime, TimeZone, Utc};
 use once_cell::sync::Lazy;
 
@@ -44,6 +46,32 @@ pub fn now_micros() -> i64 {
     Utc::now().timestamp_micros()
 }
 
+// MOCK_NOW is the time pinned by the mock clock in microseconds, 0 when the
+// clock is not mocked
+static MOCK_NOW: AtomicI64 = AtomicI64::new(0);
+
+/// Returns the "now" of the default time ranges of the queries, which the mock
+/// clock of the UI tests can pin, see `ZO_MOCK_CLOCK_ENABLED`. The internal
+/// jobs keep using the real time.
+#[inline(always)]
+pub fn query_now_micros() -> i64 {
+    mock_now_micros().unwrap_or_else(now_micros)
+}
+
+/// Returns the time pinned by the mock clock
+#[inline(always)]
+pub fn mock_now_micros() -> Option<i64> {
+    match MOCK_NOW.load(Ordering::Relaxed) {
+        0 => None,
+        v => Some(v),
+    }
+}
+
+/// Pins the mock clock, or resets it to the real time with None
+pub fn set_mock_now(v: Option<i64>) {
+    MOCK_NOW.store(v.unwrap_or_default(), Ordering::Relaxed);
+}
+
 #[inline(always)]
 pub fn parse_i64_to_timestamp_micros(v: i64) -> i64 {
     if v == 0 {
@@ -80,8 +108,13 @@ pub fn parse_str_to_timestamp_micros(v: &str) -> Result<i64, anyhow::Error> {
 #[inline(always)]
 pub fn parse_str_to_time(s: &str) -> Result<DateTime<Utc>, anyhow::Error> {
     if let Ok(v) = s.parse::<f64>() {
-        let v = parse_i64_to_timestamp_micros(v as i64);
-        return Ok(Utc.timestamp_nanos(v * 1000));
+        let secs = v.trunc() as i64;
+        let mut micros = parse_i64_to_timestamp_micros(secs);
+        // keep the fraction of the timestamps in seconds, e.g. 1700000000.5
+        if micros == secs * 1_000_000 {
+            micros += (v.fract() * 1_000_000.0).round() as i64;
+        }
+        return Ok(Utc.timestamp_nanos(micros * 1000));
     }
 
     let ret = if s.contains(' ') && s.len() == 19 {
@@ -129,6 +162,13 @@ pub fn parse_milliseconds(s: &str) -> Result<u64, anyhow::Error> {
     if chars.iter().all(|c| c.is_ascii_digit()) {
         return Ok(s.parse::<u64>().unwrap_or(0) * 1000);
     }
+    // float seconds, e.g. 0.5
+    if chars.contains(&'.') {
+        return match s.parse::<f64>() {
+            Ok(v) if v >= 0.0 => Ok((v * 1000.0).round() as u64),
+            _ => Err(anyhow::anyhow!("Invalid time format: {s}")),
+        };
+    }
 
     let mut unit_pos = TIME_UNITS.len();
     let mut start = 0;
@@ -217,6 +257,16 @@ pub fn parse_str_to_timestamp_micros_as_option(v: &str) -> Option<i64> {
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_mock_now() {
+        assert_eq!(mock_now_micros(), None);
+        set_mock_now(Some(1609459200000000));
+        assert_eq!(query_now_micros(), 1609459200000000);
+        set_mock_now(None);
+        assert_eq!(mock_now_micros(), None);
+        assert!(query_now_micros() > 1609459200000000);
+    }
+
     #[test]
     fn test_parse_i64_to_timestamp_micros() {
         let v = 1609459200000000000;
@@ -353,6 +403,22 @@ mod tests {
         assert!(parse_milliseconds("abc").is_err());
     }
 
+    #[test]
+    fn test_parse_milliseconds_float() {
+        assert_eq!(parse_milliseconds("0.5").unwrap(), 500);
+        assert_eq!(parse_milliseconds("1.25").unwrap(), 1250);
+        assert!(parse_milliseconds("-0.5").is_err());
+        assert!(parse_milliseconds("0.5.1").is_err());
+    }
+
+    #[test]
+    fn test_parse_str_to_time_fraction() {
+        let t = parse_str_to_time("1609459200.5").unwrap();
+        assert_eq!(t.timestamp_micros(), 1609459200500000);
+        let t = parse_str_to_timestamp_micros("1609459200.123").unwrap();
+        assert_eq!(t, 1609459200123000);
+    }
+
     #[test]
     fn test_parse_milliseconds_with_unit() {
         assert_eq!(parse_milliseconds("1s").unwrap(), 1000);
@@ -24,7 +24,7 @@ use crate::common::{
     utils::auth::{get_hash, is_root_user},
 };
 
-pub fn check_auth(req: Request<()>) -> Result<Request<()>, Status> {
+pub fn check_auth(mut req: Request<()>) -> Result<Request<()>, Status> {
     let cfg = config::get_config();
     let metadata = req.metadata();
     if !metadata.contains_key(&cfg.grpc.org_header_key) && !metadata.contains_key("authorization") {
@@ -39,8 +39,21 @@ pub fn check_auth(req: Request<()>) -> Result<Request<()>, Status> {
         .unwrap()
         .to_string();
     if token.eq(get_internal_grpc_token().as_str()) {
+        if config::utils::faults::drop_rpc() {
+            return Err(Status::unavailable("RPC dropped by the fault injection"));
+        }
+        // with mutual tls the other nodes must also present a certificate
+        // signed by the cluster CA, verified during the handshake
+        if cfg.tls.grpc_enabled && req.peer_certs().is_none() {
+            return Err(Status::unauthenticated(
+                "Internal requests require a client certificate",
+            ));
+        }
         Ok(req)
     } else {
+        // the user_id is only ever the one verified below, never the client's
+        req.metadata_mut().remove("user_id");
+        let metadata = req.metadata();
         let org_id = metadata.get(&cfg.grpc.org_header_key);
         if org_id.is_none() {
             return Err(Status::invalid_argument(format!(
@@ -70,16 +83,14 @@ pub fn check_auth(req: Request<()>) -> Result<Request<()>, Status> {
             return Err(Status::unauthenticated("No valid auth token"));
         };
 
-        if user.token.eq(&credentials.password) {
-            return Ok(req);
-        }
         let in_pass = get_hash(&credentials.password, &user.salt);
-        if user_id.eq(&user.email)
-            && (credentials.password.eq(&user.password) || in_pass.eq(&user.password))
+        if user.token.eq(&credentials.password)
+            || (user_id.eq(&user.email)
+                && (credentials.password.eq(&user.password) || in_pass.eq(&user.password)))
         {
-            let mut req = req;
-            let user_id_metadata = MetadataValue::try_from(&user_id).unwrap();
-            req.metadata_mut().append("user_id", user_id_metadata);
+            let user_id_metadata = MetadataValue::try_from(&user_id)
+                .map_err(|_| Status::unauthenticated("No valid auth token"))?;
+            req.metadata_mut().insert("user_id", user_id_metadata);
 
             Ok(req)
         } else {
@@ -183,4 +194,36 @@ mod tests {
         let res = check_auth(request);
         assert!(res.is_err())
     }
+
+    #[tokio::test]
+    async fn test_check_auth_user_id() {
+        cache_instance_id("instance");
+        ROOT_USER.insert(
+            "root".to_string(),
+            User {
+                email: "root@example.com".to_string(),
+                password: "Complexpass#123".to_string(),
+                role: crate::common::meta::user::UserRole::Root,
+                salt: "Complexpass#123".to_string(),
+                first_name: "root".to_owned(),
+                last_name: "".to_owned(),
+                token: "token".to_string(),
+                rum_token: Some("rum_token".to_string()),
+                org: "dummy".to_owned(),
+                is_external: false,
+                password_ext: Some("Complexpass#123".to_string()),
+            },
+        );
+        let mut request = tonic::Request::new(());
+
+        let token: MetadataValue<_> = "basic cm9vdEBleGFtcGxlLmNvbTp0b2tlbg==".parse().unwrap();
+        let meta: &mut tonic::metadata::MetadataMap = request.metadata_mut();
+        meta.insert("authorization", token.clone());
+        meta.insert("organization", "default".parse().unwrap());
+        meta.insert("user_id", "admin@example.com".parse().unwrap());
+
+        let res = check_auth(request).unwrap();
+        let user_ids: Vec<_> = res.metadata().get_all("user_id").iter().collect();
+        assert_eq!(user_ids, vec!["root@example.com"]);
+    }
 }
@@ -0,0 +1,251 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Public gRPC api, see `proto/openobserve/v1`
+
+use std::collections::HashMap;
+
+use actix_web::web;
+use config::{
+    get_config,
+    meta::{
+        cluster::NodeStatus,
+        search::{Query, Request as SearchRequest, RequestEncoding},
+        stream::StreamType,
+    },
+    utils::json,
+};
+use infra::errors;
+use proto::openobserve_v1::{
+    cluster_service_server::ClusterService, ingest_service_server::IngestService,
+    search_service_server::SearchService, GetInfoRequest, GetInfoResponse, IngestRequest,
+    IngestResponse, ListNodesRequest, ListNodesResponse, Node, SearchRequest as ApiSearchRequest,
+    SearchResponse as ApiSearchResponse, API_VERSION,
+};
+use tonic::{Request, Response, Status};
+
+use crate::{
+    common::{
+        infra::{
+            cluster,
+            config::{BUILD_DATE, COMMIT_HASH, VERSION},
+        },
+        meta::ingestion::IngestionRequest,
+        utils::auth::is_root_user,
+    },
+    service::{
+        logs,
+        search::{self as SearchService, access},
+    },
+};
+
+#[derive(Default)]
+pub struct ApiSearcher;
+
+#[derive(Default)]
+pub struct ApiIngester;
+
+#[derive(Default)]
+pub struct ApiCluster;
+
+#[tonic::async_trait]
+impl SearchService for ApiSearcher {
+    async fn search(
+        &self,
+        req: Request<ApiSearchRequest>,
+    ) -> Result<Response<ApiSearchResponse>, Status> {
+        let org_id = get_org_id(&req)?;
+        let user_id = get_user_id(&req);
+        let in_req = req.into_inner();
+        let stream_type = if in_req.stream_type.is_empty() {
+            StreamType::Logs
+        } else {
+            StreamType::from(in_req.stream_type.as_str())
+        };
+        let size = if in_req.size > 0 {
+            in_req.size
+        } else {
+            get_config().limit.query_default_limit
+        };
+        let stream_name = match config::meta::sql::Sql::new(&in_req.sql) {
+            Ok(sql) => sql.source,
+            Err(e) => return Err(Status::invalid_argument(e.to_string())),
+        };
+        if let Some(user_id) = user_id.as_deref() {
+            if !access::can_read(&org_id, user_id, stream_type, &stream_name).await {
+                return Err(Status::permission_denied("Unauthorized Access"));
+            }
+        }
+        let mut search_req = SearchRequest {
+            query: Query {
+                sql: in_req.sql,
+                from: in_req.from,
+                size,
+                start_time: in_req.start_time,
+                end_time: in_req.end_time,
+                ..Default::default()
+            },
+            aggs: HashMap::new(),
+            encoding: RequestEncoding::Empty,
+            regions: vec![],
+            clusters: vec![],
+            timeout: in_req.timeout,
+            search_type: None,
+            search_event_context: None,
+        };
+        if let Some(warning) =
+            access::clamp_query_range(&org_id, stream_type, &stream_name, &mut search_req.query)
+                .await
+        {
+            log::info!("[grpc api] {warning}");
+        }
+
+        let trace_id = config::ider::uuid();
+        let res = SearchService::search(&trace_id, &org_id, stream_type, user_id, &search_req)
+            .await
+            .map_err(|e| match e {
+                errors::Error::ErrorCode(code) => Status::invalid_argument(code.to_json()),
+                e => Status::internal(e.to_string()),
+            })?;
+        let hits = json::to_vec(&res.hits).map_err(|e| Status::internal(e.to_string()))?;
+        Ok(Response::new(ApiSearchResponse {
+            took: res.took as i64,
+            total: res.total as i64,
+            hits,
+            scan_size: res.scan_size as i64,
+            scan_records: res.scan_records as i64,
+            is_partial: res.is_partial,
+            function_error: res.function_error,
+            trace_id,
+        }))
+    }
+}
+
+#[tonic::async_trait]
+impl IngestService for ApiIngester {
+    async fn ingest(
+        &self,
+        req: Request<IngestRequest>,
+    ) -> Result<Response<IngestResponse>, Status> {
+        let org_id = get_org_id(&req)?;
+        let user_id = get_user_id(&req).unwrap_or_default();
+        let in_req = req.into_inner();
+        if !in_req.stream_type.is_empty()
+            && StreamType::from(in_req.stream_type.as_str()) != StreamType::Logs
+        {
+            return Err(Status::invalid_argument(
+                "only logs can be ingested, use the OTLP services for metrics and traces",
+            ));
+        }
+        if in_req.stream_name.is_empty() {
+            return Err(Status::invalid_argument("stream_name is required"));
+        }
+
+        let data = web::Bytes::from(in_req.data);
+        let res = logs::ingest::ingest(
+            &org_id,
+            &in_req.stream_name,
+            IngestionRequest::JSON(&data),
+            &user_id,
+            false,
+        )
+        .await
+        .map_err(|e| Status::invalid_argument(e.to_string()))?;
+        if res.code == 503 {
+            return Err(Status::unavailable(res.error.unwrap_or_default()));
+        }
+        let mut resp = IngestResponse::default();
+        for status in res.status {
+            resp.successful += status.status.successful as i64;
+            resp.failed += status.status.failed as i64;
+            if resp.error.is_empty() {
+                resp.error = status.status.error;
+            }
+        }
+        if let Some(error) = res.error {
+            resp.error = error;
+        }
+        Ok(Response::new(resp))
+    }
+}
+
+#[tonic::async_trait]
+impl ClusterService for ApiCluster {
+    async fn get_info(
+        &self,
+        _req: Request<GetInfoRequest>,
+    ) -> Result<Response<GetInfoResponse>, Status> {
+        Ok(Response::new(GetInfoResponse {
+            version: VERSION.to_string(),
+            commit_hash: COMMIT_HASH.to_string(),
+            build_date: BUILD_DATE.to_string(),
+            cluster: config::get_cluster_name(),
+            api_version: API_VERSION.to_string(),
+        }))
+    }
+
+    async fn list_nodes(
+        &self,
+        req: Request<ListNodesRequest>,
+    ) -> Result<Response<ListNodesResponse>, Status> {
+        if get_user_id(&req).is_some_and(|user_id| !is_root_user(&user_id)) {
+            return Err(Status::permission_denied(
+                "only the root user can list the nodes",
+            ));
+        }
+        let nodes = cluster::get_cached_nodes(|_| true)
+            .await
+            .unwrap_or_default()
+            .into_iter()
+            .map(|node| Node {
+                uuid: node.uuid,
+                name: node.name,
+                http_addr: node.http_addr,
+                grpc_addr: node.grpc_addr,
+                roles: node.role.iter().map(|r| r.to_string()).collect(),
+                status: match node.status {
+                    NodeStatus::Prepare => "prepare",
+                    NodeStatus::Online => "online",
+                    NodeStatus::Offline => "offline",
+                }
+                .to_string(),
+            })
+            .collect();
+        Ok(Response::new(ListNodesResponse { nodes }))
+    }
+}
+
+fn get_org_id<T>(req: &Request<T>) -> Result<String, Status> {
+    let cfg = get_config();
+    req.metadata()
+        .get(&cfg.grpc.org_header_key)
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.to_string())
+        .ok_or_else(|| {
+            Status::invalid_argument(format!(
+                "Please specify organization id with header key '{}' ",
+                &cfg.grpc.org_header_key
+            ))
+        })
+}
+
+/// Set by the auth interceptor to the verified user, replacing any value sent
+/// by the client, missing for the internal token
+fn get_user_id<T>(req: &Request<T>) -> Option<String> {
+    req.metadata()
+        .get("user_id")
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.to_string())
+}
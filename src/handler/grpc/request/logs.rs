@@ -17,8 +17,17 @@ use async_trait::async_trait;
 use opentelemetry_proto::tonic::collector::logs::v1::{
     logs_service_server::LogsService, ExportLogsServiceRequest, ExportLogsServiceResponse,
 };
+use prost::Message;
 use tonic::{Response, Status};
 
+use crate::{
+    common::{
+        meta::ingestion::{is_sampling_override, SAMPLING_OVERRIDE_HEADER},
+        utils::trace_context::{self, TraceContext},
+    },
+    service::{ingestion::can_override_sampling, residency},
+};
+
 #[derive(Default)]
 pub struct LogsServer;
 
@@ -43,6 +52,10 @@ impl LogsService for LogsServer {
         if org_id.is_none() {
             return Err(Status::invalid_argument(msg));
         }
+        // orgs pinned to another region can not ingest into this cluster
+        if let Err(e) = residency::check_local(org_id.unwrap().to_str().unwrap_or_default()) {
+            return Err(Status::permission_denied(e));
+        }
         let stream_name = metadata.get(&cfg.grpc.stream_header_key);
         let mut in_stream_name: Option<&str> = None;
         if let Some(stream_name) = stream_name {
@@ -55,18 +68,37 @@ impl LogsService for LogsServer {
             user_email = user_id.to_str().unwrap();
         };
 
-        match crate::service::logs::otlp_grpc::handle_grpc_request(
-            org_id.unwrap().to_str().unwrap(),
-            in_req,
-            true,
-            in_stream_name,
-            user_email,
+        // the sampling rules of the stream are skipped on request of an admin
+        let skip_sampling = metadata
+            .get(SAMPLING_OVERRIDE_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .is_some_and(is_sampling_override)
+            && can_override_sampling(org_id.unwrap().to_str().unwrap_or_default(), user_email)
+                .await;
+
+        let trace_ctx = TraceContext::from_grpc_metadata(&metadata);
+        match trace_context::scope(
+            trace_ctx,
+            crate::service::logs::otlp_grpc::handle_grpc_request(
+                org_id.unwrap().to_str().unwrap(),
+                in_req,
+                true,
+                in_stream_name,
+                user_email,
+                skip_sampling,
+            ),
         )
         .await
         {
-            Ok(_) => Ok(Response::new(ExportLogsServiceResponse {
-                partial_success: None,
-            })),
+            // forward the records rejected by the ingester
+            Ok(res) => {
+                let partial_success = actix_web::body::to_bytes(res.into_body())
+                    .await
+                    .ok()
+                    .and_then(|body| ExportLogsServiceResponse::decode(body).ok())
+                    .and_then(|res| res.partial_success);
+                Ok(Response::new(ExportLogsServiceResponse { partial_success }))
+            }
             Err(e) => Err(Status::internal(e.to_string())),
         }
     }
@@ -20,6 +20,11 @@ use opentelemetry_proto::tonic::collector::metrics::v1::{
 };
 use tonic::{Response, Status};
 
+use crate::{
+    common::utils::trace_context::{self, TraceContext},
+    service::residency,
+};
+
 #[derive(Default)]
 pub struct Ingester;
 
@@ -44,11 +49,19 @@ impl MetricsService for Ingester {
         if org_id.is_none() {
             return Err(Status::invalid_argument(msg));
         }
+        // orgs pinned to another region can not ingest into this cluster
+        if let Err(e) = residency::check_local(org_id.unwrap().to_str().unwrap_or_default()) {
+            return Err(Status::permission_denied(e));
+        }
 
-        let resp = crate::service::metrics::otlp_grpc::handle_grpc_request(
-            org_id.unwrap().to_str().unwrap(),
-            in_req,
-            true,
+        let trace_ctx = TraceContext::from_grpc_metadata(&metadata);
+        let resp = trace_context::scope(
+            trace_ctx,
+            crate::service::metrics::otlp_grpc::handle_grpc_request(
+                org_id.unwrap().to_str().unwrap(),
+                in_req,
+                true,
+            ),
         )
         .await;
         if resp.is_ok() {
@@ -16,9 +16,16 @@
 use opentelemetry_proto::tonic::collector::trace::v1::{
     trace_service_server::TraceService, ExportTraceServiceRequest, ExportTraceServiceResponse,
 };
+use prost::Message;
 use tonic::{codegen::*, Response, Status};
 
-use crate::service::traces::handle_trace_request;
+use crate::{
+    common::{
+        meta::ingestion::{is_sampling_override, SAMPLING_OVERRIDE_HEADER},
+        utils::trace_context::{self, TraceContext},
+    },
+    service::{ingestion::can_override_sampling, residency, traces::handle_trace_request},
+};
 
 #[derive(Default)]
 pub struct TraceServer {}
@@ -44,6 +51,10 @@ impl TraceService for TraceServer {
         if org_id.is_none() {
             return Err(Status::invalid_argument(msg));
         }
+        // orgs pinned to another region can not ingest into this cluster
+        if let Err(e) = residency::check_local(org_id.unwrap().to_str().unwrap_or_default()) {
+            return Err(Status::permission_denied(e));
+        }
 
         let stream_name = metadata.get(&cfg.grpc.stream_header_key);
         let mut in_stream_name: Option<&str> = None;
@@ -51,19 +62,42 @@ impl TraceService for TraceServer {
             in_stream_name = Some(stream_name.to_str().unwrap());
         };
 
-        let resp = handle_trace_request(
-            org_id.unwrap().to_str().unwrap(),
-            in_req,
-            true,
-            in_stream_name,
+        // the sampling rules of the stream are skipped on request of an admin
+        let user_id = metadata
+            .get("user_id")
+            .and_then(|v| v.to_str().ok())
+            .unwrap_or_default();
+        let skip_sampling = metadata
+            .get(SAMPLING_OVERRIDE_HEADER)
+            .and_then(|v| v.to_str().ok())
+            .is_some_and(is_sampling_override)
+            && can_override_sampling(org_id.unwrap().to_str().unwrap_or_default(), user_id).await;
+
+        let trace_ctx = TraceContext::from_grpc_metadata(&metadata);
+        let resp = trace_context::scope(
+            trace_ctx,
+            handle_trace_request(
+                org_id.unwrap().to_str().unwrap(),
+                in_req,
+                true,
+                in_stream_name,
+                skip_sampling,
+            ),
         )
         .await;
-        if resp.is_ok() {
-            return Ok(Response::new(ExportTraceServiceResponse {
-                partial_success: None,
-            }));
-        } else {
-            Err(Status::internal(resp.err().unwrap().to_string()))
+        match resp {
+            // forward the spans rejected by the ingester
+            Ok(res) => {
+                let partial_success = actix_web::body::to_bytes(res.into_body())
+                    .await
+                    .ok()
+                    .and_then(|body| ExportTraceServiceResponse::decode(body).ok())
+                    .and_then(|res| res.partial_success);
+                Ok(Response::new(ExportTraceServiceResponse {
+                    partial_success,
+                }))
+            }
+            Err(e) => Err(Status::internal(e.to_string())),
         }
     }
 }
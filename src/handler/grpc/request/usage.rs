@@ -17,6 +17,8 @@ use async_trait::async_trait;
 use proto::cluster_rpc::{usage_server::Usage, UsageRequest, UsageResponse};
 use tonic::{Request, Response, Status};
 
+use crate::service::usage::ingestion_service;
+
 #[derive(Debug, Default)]
 pub struct UsageServerImpl;
 
@@ -30,11 +32,13 @@ impl Usage for UsageServerImpl {
         let req = request.into_inner();
         let report_to_stream = req.stream_name;
         let report_to_org_id = metadata.get(&config::get_config().grpc.org_header_key);
+        let historical = metadata.contains_key(ingestion_service::HISTORICAL_HEADER);
         let in_data = req.data.unwrap_or_default();
         let resp = crate::service::logs::otlp_grpc::usage_ingest(
             report_to_org_id.unwrap().to_str().unwrap(),
             &report_to_stream,
             in_data.data.into(),
+            historical,
         )
         .await;
 
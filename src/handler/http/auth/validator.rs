@@ -13,27 +13,31 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+use actix_http::h1::Payload;
 use actix_web::{
     dev::ServiceRequest,
-    error::{ErrorForbidden, ErrorUnauthorized},
+    error::{ErrorForbidden, ErrorPayloadTooLarge, ErrorServiceUnavailable, ErrorUnauthorized},
     http::{header, Method},
-    web, Error,
+    web::{self, BytesMut},
+    Error,
 };
 use actix_web_httpauth::extractors::basic::BasicAuth;
 use config::{get_config, utils::base64};
+use futures::StreamExt;
 
 use crate::{
     common::{
         meta::{
+            ingest_keys::HMAC_AUTH_SCHEME,
             ingestion::INGESTION_EP,
             user::{
                 AuthTokensExt, DBUser, TokenValidationResponse, TokenValidationResponseBuilder,
-                UserRole,
+                User, UserRole, NATIVE_SESSION_PREFIX,
             },
         },
         utils::auth::{get_hash, is_root_user, AuthExtractor},
     },
-    service::{db, users},
+    service::{db, ingest_keys, mfa, user_sessions, users},
 };
 
 pub const PKCE_STATE_ORG: &str = "o2_pkce_state";
@@ -64,36 +68,162 @@ pub async fn validator(
     } else {
         validate_credentials(user_id, password.trim(), path).await
     } {
-        Ok(res) => {
-            if res.is_valid {
-                // / Hack for prometheus, need support POST and check the header
-                let mut req = req;
-                if req.method().eq(&Method::POST) && !req.headers().contains_key("content-type") {
-                    req.headers_mut().insert(
-                        header::CONTENT_TYPE,
-                        header::HeaderValue::from_static("application/x-www-form-urlencoded"),
-                    );
-                }
-                req.headers_mut().insert(
-                    header::HeaderName::from_static("user_id"),
-                    header::HeaderValue::from_str(&res.user_email).unwrap(),
-                );
+        Ok(res) => authorize(req, user_id, res, auth_info).await,
+        Err(err) => Err((err, req)),
+    }
+}
 
-                if auth_info.bypass_check
-                    || check_permissions(user_id, auth_info, res.user_role).await
-                {
-                    Ok(req)
-                } else {
-                    Err((ErrorForbidden("Unauthorized Access"), req))
-                }
-            } else {
-                Err((ErrorUnauthorized("Unauthorized Access"), req))
-            }
+/// Validates a request carrying a native login session created by `/auth/login`.
+pub async fn session_validator(
+    req: ServiceRequest,
+    session_id: &str,
+    auth_info: AuthExtractor,
+    path_prefix: &str,
+) -> Result<ServiceRequest, (Error, ServiceRequest)> {
+    let Some(session) = user_sessions::get_valid(session_id).await else {
+        return Err((ErrorUnauthorized("Unauthorized Access"), req));
+    };
+    let cfg = get_config();
+    let path = match req
+        .request()
+        .path()
+        .strip_prefix(format!("{}{}", cfg.common.base_uri, path_prefix).as_str())
+    {
+        Some(path) => path,
+        None => req.request().path(),
+    };
+    match validate_session_user(&session.user_email, path).await {
+        Ok(res) => authorize(req, &session.user_email, res, auth_info).await,
+        Err(err) => Err((err, req)),
+    }
+}
+
+/// Validates an ingestion request signed with an ingest key. The signature
+/// covers the body, so the payload is read here and put back for the handler.
+pub async fn ingest_key_validator(
+    mut req: ServiceRequest,
+    auth_info: AuthExtractor,
+    path_prefix: &str,
+) -> Result<ServiceRequest, (Error, ServiceRequest)> {
+    let Some(signed) = ingest_keys::parse_authorization(&auth_info.auth) else {
+        return Err((ErrorUnauthorized("Malformed HMAC authorization"), req));
+    };
+    let cfg = get_config();
+    let path = req
+        .path()
+        .strip_prefix(format!("{}{}", cfg.common.base_uri, path_prefix).as_str())
+        .unwrap_or(req.path())
+        .to_string();
+    let columns = path_columns(&path);
+    if !req.method().eq(&Method::POST)
+        || !columns.last().is_some_and(|ep| INGESTION_EP.contains(ep))
+    {
+        return Err((
+            ErrorUnauthorized("HMAC signed requests are only accepted by ingestion endpoints"),
+            req,
+        ));
+    }
+    let key = match db::ingest_keys::get(&signed.key_id) {
+        Some(key) if columns.first().is_some_and(|org_id| key.org_id.eq(org_id)) => key,
+        _ => return Err((ErrorUnauthorized("Unauthorized Access"), req)),
+    };
+    let now = chrono::Utc::now().timestamp();
+    if !ingest_keys::within_skew(signed.timestamp, now) {
+        return Err((
+            ErrorUnauthorized("Request timestamp is outside the allowed clock skew"),
+            req,
+        ));
+    }
+
+    let mut body = BytesMut::new();
+    let mut payload_stream = req.take_payload();
+    while let Some(chunk) = payload_stream.next().await {
+        let chunk = match chunk {
+            Ok(chunk) => chunk,
+            Err(e) => return Err((e.into(), req)),
+        };
+        if body.len() + chunk.len() > cfg.limit.req_payload_limit {
+            return Err((ErrorPayloadTooLarge("Payload too large"), req));
         }
+        body.extend_from_slice(&chunk);
+    }
+    let string_to_sign = ingest_keys::string_to_sign(
+        signed.timestamp,
+        req.method().as_str(),
+        req.uri()
+            .path_and_query()
+            .map(|v| v.as_str())
+            .unwrap_or(req.path()),
+        &body,
+    );
+    let (_, mut payload) = Payload::create(true);
+    payload.unread_data(body.freeze());
+    req.set_payload(payload.into());
+
+    if !ingest_keys::verify(&key.secret, &string_to_sign, &signed.signature) {
+        return Err((ErrorUnauthorized("Invalid request signature"), req));
+    }
+    match ingest_keys::record_signature(&signed.signature, signed.timestamp, now).await {
+        Ok(true) => {}
+        Ok(false) => {
+            return Err((ErrorUnauthorized("Request signature was already used"), req));
+        }
+        Err(e) => {
+            log::error!(
+                "Error recording the signature of ingest key {}: {}",
+                key.id,
+                e
+            );
+            return Err((
+                ErrorServiceUnavailable("Request signature could not be checked"),
+                req,
+            ));
+        }
+    }
+    let header_stream = req
+        .headers()
+        .get(&cfg.grpc.stream_header_key)
+        .and_then(|v| v.to_str().ok());
+    let stream = ingest_keys::request_stream(&columns, header_stream);
+    if let Err(e) = ingest_keys::check_stream(&key, stream.as_deref()) {
+        return Err((ErrorForbidden(e), req));
+    }
+
+    match validate_session_user(&key.user_email, &path).await {
+        Ok(res) => authorize(req, &key.user_email, res, auth_info).await,
         Err(err) => Err((err, req)),
     }
 }
 
+async fn authorize(
+    req: ServiceRequest,
+    user_id: &str,
+    res: TokenValidationResponse,
+    auth_info: AuthExtractor,
+) -> Result<ServiceRequest, (Error, ServiceRequest)> {
+    if !res.is_valid {
+        return Err((ErrorUnauthorized("Unauthorized Access"), req));
+    }
+    // / Hack for prometheus, need support POST and check the header
+    let mut req = req;
+    if req.method().eq(&Method::POST) && !req.headers().contains_key("content-type") {
+        req.headers_mut().insert(
+            header::CONTENT_TYPE,
+            header::HeaderValue::from_static("application/x-www-form-urlencoded"),
+        );
+    }
+    req.headers_mut().insert(
+        header::HeaderName::from_static("user_id"),
+        header::HeaderValue::from_str(&res.user_email).unwrap(),
+    );
+
+    if auth_info.bypass_check || check_permissions(user_id, auth_info, res.user_role).await {
+        Ok(req)
+    } else {
+        Err((ErrorForbidden("Unauthorized Access"), req))
+    }
+}
+
 /// `validate_token` validates the endpoints which are token only.
 /// This includes endpoints like `rum` etc.
 ///
@@ -107,36 +237,25 @@ pub async fn validate_token(token: &str, org_id: &str) -> Result<(), Error> {
     }
 }
 
-pub async fn validate_credentials(
-    user_id: &str,
-    user_password: &str,
-    path: &str,
-) -> Result<TokenValidationResponse, Error> {
-    let user;
+fn path_columns(path: &str) -> Vec<&str> {
     let mut path_columns = path.split('/').collect::<Vec<&str>>();
     if let Some(v) = path_columns.last() {
         if v.is_empty() {
             path_columns.pop();
         }
     }
+    path_columns
+}
 
+/// Looks up the user the request is made as, scoped to the org in the path.
+async fn get_user_for_path(user_id: &str, path: &str) -> Option<User> {
+    let path_columns = path_columns(path);
     // this is only applicable for super admin user
     if is_root_user(user_id) {
-        user = users::get_user(None, user_id).await;
-        if user.is_none() {
-            return Ok(TokenValidationResponse {
-                is_valid: false,
-                user_email: "".to_string(),
-                is_internal_user: false,
-                user_role: None,
-                user_name: "".to_string(),
-                family_name: "".to_string(),
-                given_name: "".to_string(),
-            });
-        }
+        users::get_user(None, user_id).await
     } else if path_columns.last().unwrap_or(&"").eq(&"organizations") {
         let db_user = db::user::get_db_user(user_id).await;
-        user = match db_user {
+        match db_user {
             Ok(user) => {
                 let all_users = user.get_all_users();
                 if all_users.is_empty() {
@@ -148,14 +267,69 @@ pub async fn validate_credentials(
             Err(_) => None,
         }
     } else {
-        user = match path.find('/') {
+        match path.find('/') {
             Some(index) => {
                 let org_id = &path[0..index];
                 users::get_user(Some(org_id), user_id).await
             }
             None => users::get_user(None, user_id).await,
         }
-    };
+    }
+}
+
+/// Users can only manage other users of the org when they are admins.
+fn user_path_response(
+    user_id: &str,
+    user: User,
+    path: &str,
+) -> Result<TokenValidationResponse, Error> {
+    if !path.contains("/user")
+        || (path.contains("/user")
+            && (user.role.eq(&UserRole::Admin)
+                || user.role.eq(&UserRole::Root)
+                || user.email.eq(user_id)))
+    {
+        Ok(TokenValidationResponse {
+            is_valid: true,
+            user_email: user.email,
+            is_internal_user: !user.is_external,
+            user_role: Some(user.role),
+            user_name: user.first_name.to_owned(),
+            family_name: user.last_name,
+            given_name: user.first_name,
+        })
+    } else {
+        Err(ErrorForbidden("Not allowed"))
+    }
+}
+
+/// Validates the user of a native login session, the session itself already
+/// proved the password (and MFA code) at login.
+pub async fn validate_session_user(
+    user_id: &str,
+    path: &str,
+) -> Result<TokenValidationResponse, Error> {
+    match get_user_for_path(user_id, path).await {
+        Some(user) => user_path_response(user_id, user, path),
+        None => Ok(TokenValidationResponse {
+            is_valid: false,
+            user_email: "".to_string(),
+            is_internal_user: false,
+            user_role: None,
+            user_name: "".to_string(),
+            family_name: "".to_string(),
+            given_name: "".to_string(),
+        }),
+    }
+}
+
+pub async fn validate_credentials(
+    user_id: &str,
+    user_password: &str,
+    path: &str,
+) -> Result<TokenValidationResponse, Error> {
+    let path_columns = path_columns(path);
+    let user = get_user_for_path(user_id, path).await;
 
     if user.is_none() {
         return Ok(TokenValidationResponse {
@@ -169,10 +343,10 @@ pub async fn validate_credentials(
         });
     }
     let user = user.unwrap();
+    let is_ingestion =
+        path_columns.len() == 1 || INGESTION_EP.iter().any(|s| path_columns.contains(s));
 
-    if (path_columns.len() == 1 || INGESTION_EP.iter().any(|s| path_columns.contains(s)))
-        && user.token.eq(&user_password)
-    {
+    if is_ingestion && user.token.eq(&user_password) {
         return Ok(TokenValidationResponse {
             is_valid: true,
             user_email: user.email,
@@ -201,24 +375,24 @@ pub async fn validate_credentials(
             given_name: "".to_string(),
         });
     }
-    if !path.contains("/user")
-        || (path.contains("/user")
-            && (user.role.eq(&UserRole::Admin)
-                || user.role.eq(&UserRole::Root)
-                || user.email.eq(user_id)))
-    {
-        Ok(TokenValidationResponse {
-            is_valid: true,
-            user_email: user.email,
-            is_internal_user: !user.is_external,
-            user_role: Some(user.role),
-            user_name: user.first_name.to_owned(),
-            family_name: user.last_name,
-            given_name: user.first_name,
-        })
-    } else {
-        Err(ErrorForbidden("Not allowed"))
+
+    // the password alone skips the second factor, outside ingestion users
+    // with MFA have to log in for a session or use their token
+    if !is_ingestion {
+        let mfa_enabled = match mfa::is_enabled(&user.email).await {
+            Ok(enabled) => enabled,
+            Err(e) => {
+                log::error!("Error checking MFA of {}: {}", user.email, e);
+                true
+            }
+        };
+        if mfa_enabled {
+            return Err(ErrorUnauthorized(
+                "MFA is enabled for the user, log in with a one-time code or use a token",
+            ));
+        }
     }
+    user_path_response(user_id, user, path)
 }
 
 #[cfg(feature = "enterprise")]
@@ -551,6 +725,11 @@ async fn oo_validator_internal(
         validator(req, &username, &password, auth_info, path_prefix).await
     } else if auth_info.auth.starts_with("Bearer") {
         super::token::token_validator(req, auth_info).await
+    } else if let Some(session_id) = auth_info.auth.strip_prefix(NATIVE_SESSION_PREFIX) {
+        let session_id = session_id.trim().to_string();
+        session_validator(req, &session_id, auth_info, path_prefix).await
+    } else if auth_info.auth.starts_with(HMAC_AUTH_SCHEME) {
+        ingest_key_validator(req, auth_info, path_prefix).await
     } else if auth_info.auth.starts_with("{\"auth_ext\":") {
         let auth_tokens: AuthTokensExt =
             config::utils::json::from_str(&auth_info.auth).unwrap_or_default();
@@ -588,6 +767,10 @@ pub async fn get_user_email_from_auth_str(auth_str: &str) -> Option<String> {
         }
     } else if auth_str.starts_with("Bearer") {
         super::token::get_user_name_from_token(auth_str).await
+    } else if let Some(session_id) = auth_str.strip_prefix(NATIVE_SESSION_PREFIX) {
+        user_sessions::get_valid(session_id.trim())
+            .await
+            .map(|s| s.user_email)
     } else if auth_str.starts_with("{\"auth_ext\":") {
         let auth_tokens: AuthTokensExt =
             config::utils::json::from_str(auth_str).unwrap_or_default();
@@ -887,5 +1070,34 @@ mod tests {
                 .is_valid
         );
         assert!(validate_user(init_user, pwd).await.unwrap().is_valid);
+
+        // with MFA the password only works for ingestion
+        db::user_mfa::set(
+            user_id,
+            &crate::common::meta::user::UserMfa {
+                secret: "JBSWY3DPEHPK3PXP".to_string(),
+                enabled: true,
+                created_at: 0,
+                last_step: 0,
+            },
+        )
+        .await
+        .unwrap();
+        assert!(validate_credentials(user_id, pwd, "default/user")
+            .await
+            .is_err());
+        assert!(
+            validate_credentials(user_id, pwd, "default/_bulk")
+                .await
+                .unwrap()
+                .is_valid
+        );
+        db::user_mfa::delete(user_id).await.unwrap();
+        assert!(
+            validate_credentials(user_id, pwd, "default/user")
+                .await
+                .unwrap()
+                .is_valid
+        );
     }
 }
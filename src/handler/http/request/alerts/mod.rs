@@ -19,10 +19,13 @@ use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse};
 
 use crate::{
     common::{
-        meta::{alerts::Alert, http::HttpResponse as MetaHttpResponse},
-        utils::http::get_stream_type_from_request,
+        meta::{
+            alerts::{Alert, AlertFiring, AlertHistoryStats, ScheduledTrigger},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::{auth::UserEmail, http::get_stream_type_from_request},
     },
-    service::alerts,
+    service::{alerts, search::field_access},
 };
 
 pub mod destinations;
@@ -50,12 +53,23 @@ pub mod templates;
 pub async fn save_alert(
     path: web::Path<(String, String)>,
     alert: web::Json<Alert>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, stream_name) = path.into_inner();
 
     // Hack for frequency: convert minutes to seconds
     let mut alert = alert.into_inner();
     alert.trigger_condition.frequency *= 60;
+    if let Err(e) = field_access::check_alert(
+        &org_id,
+        alert.stream_type,
+        &stream_name,
+        &user_email.user_id,
+    )
+    .await
+    {
+        return Ok(MetaHttpResponse::forbidden(e));
+    }
 
     match alerts::save(&org_id, &stream_name, "", alert, true).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Alert saved")),
@@ -86,12 +100,23 @@ pub async fn save_alert(
 pub async fn update_alert(
     path: web::Path<(String, String, String)>,
     alert: web::Json<Alert>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, stream_name, name) = path.into_inner();
 
     // Hack for frequency: convert minutes to seconds
     let mut alert = alert.into_inner();
     alert.trigger_condition.frequency *= 60;
+    if let Err(e) = field_access::check_alert(
+        &org_id,
+        alert.stream_type,
+        &stream_name,
+        &user_email.user_id,
+    )
+    .await
+    {
+        return Ok(MetaHttpResponse::forbidden(e));
+    }
     match alerts::save(&org_id, &stream_name, &name, alert, false).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Alert Updated")),
         Err(e) => Ok(MetaHttpResponse::bad_request(e)),
@@ -202,6 +227,177 @@ async fn list_alerts(path: web::Path<String>, _req: HttpRequest) -> Result<HttpR
     }
 }
 
+/// ListFiringAlerts
+///
+/// Lists the alerts which fired recently, with hints about their likely root
+/// cause.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Alerts",
+    operation_id = "ListFiringAlerts",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Vec<AlertFiring>),
+    )
+)]
+#[get("/{org_id}/alerts/firing")]
+async fn list_firing_alerts(path: web::Path<String>) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let data = alerts::correlation::list(&org_id).await;
+    let mut mapdata = HashMap::new();
+    mapdata.insert("list", data);
+    Ok(MetaHttpResponse::json(mapdata))
+}
+
+/// ListAlertHistory
+///
+/// Lists the state transitions of the alerts, the latest first. The time
+/// range defaults to the last 30 days.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Alerts",
+    operation_id = "ListAlertHistory",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("alert_name" = Option<String>, Query, description = "Alert name"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds"),
+        ("size" = Option<i64>, Query, description = "Maximum number of transitions, default 100"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Object),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/alerts/history")]
+async fn list_alert_history(
+    path: web::Path<String>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let (start_time, end_time) = history_time_range(&query);
+    let size = query
+        .get("size")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or(100);
+    match alerts::history::list(
+        &org_id,
+        query.get("alert_name").map(|v| v.as_str()),
+        start_time,
+        end_time,
+        size,
+    )
+    .await
+    {
+        Ok(data) => {
+            let mut mapdata = HashMap::new();
+            mapdata.insert("list", data);
+            Ok(MetaHttpResponse::json(mapdata))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// GetAlertHistoryStats
+///
+/// Returns the fire counts and times to resolve of the alerts, the noisiest
+/// first. The time range defaults to the last 30 days.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Alerts",
+    operation_id = "GetAlertHistoryStats",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds"),
+        ("limit" = Option<usize>, Query, description = "Maximum number of alerts, default 20"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = AlertHistoryStats),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/alerts/history/stats")]
+async fn get_alert_history_stats(
+    path: web::Path<String>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let (start_time, end_time) = history_time_range(&query);
+    let limit = query
+        .get("limit")
+        .and_then(|v| v.parse::<usize>().ok())
+        .unwrap_or(20);
+    match alerts::history::stats(&org_id, start_time, end_time, limit).await {
+        Ok(data) => Ok(MetaHttpResponse::json(data)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// ListScheduledTriggers
+///
+/// Lists the scheduled alerts, reports and pipelines of the organization, the
+/// next due first, with the delays, durations and skips of their evaluations
+/// to debug the missed ones.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Alerts",
+    operation_id = "ListScheduledTriggers",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Vec<ScheduledTrigger>),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/alerts/schedule")]
+async fn list_scheduled_triggers(path: web::Path<String>) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    match alerts::scheduler::list(&org_id).await {
+        Ok(data) => {
+            let mut mapdata = HashMap::new();
+            mapdata.insert("list", data);
+            Ok(MetaHttpResponse::json(mapdata))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+fn history_time_range(query: &HashMap<String, String>) -> (i64, i64) {
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_else(config::utils::time::query_now_micros);
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_else(|| {
+            end_time
+                - chrono::Duration::try_days(30)
+                    .unwrap()
+                    .num_microseconds()
+                    .unwrap()
+        });
+    (start_time, end_time)
+}
+
 /// GetAlertByName
 #[utoipa::path(
     context_path = "/api",
@@ -0,0 +1,134 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::archive_search::ArchiveSearchRequest;
+
+/// CreateArchiveSearchJob
+///
+/// Searches data stored in archival storage classes like S3 Glacier and Deep
+/// Archive. The archived objects of the time range are restored first, then the
+/// query runs and the destination, if any, is notified.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "CreateArchiveSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = ArchiveSearchRequest, description = "Archive search job data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ArchiveSearchJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/archive_search")]
+pub async fn create_job(
+    org_id: web::Path<String>,
+    body: web::Json<ArchiveSearchRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::archive_search::create_job(&org_id, user_email, body.into_inner()).await
+}
+
+/// ListArchiveSearchJobs
+///
+/// Lists the jobs of the user, the admins get every job of the organization.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "ListArchiveSearchJobs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ArchiveSearchJobList),
+    )
+)]
+#[get("/{org_id}/archive_search")]
+pub async fn list_jobs(org_id: web::Path<String>, req: HttpRequest) -> Result<HttpResponse, Error> {
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::archive_search::list_jobs(&org_id.into_inner(), user_id).await
+}
+
+/// GetArchiveSearchJob
+///
+/// Returns the job with its hits once completed, the jobs of the other users
+/// are only found by the admins.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "GetArchiveSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Archive search job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ArchiveSearchJob),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/archive_search/{id}")]
+pub async fn get_job(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::archive_search::get_job(&org_id, &id, user_id).await
+}
+
+/// DeleteArchiveSearchJob
+///
+/// Deletes the job, the restored copies of the objects expire on their own.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "DeleteArchiveSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Archive search job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/archive_search/{id}")]
+pub async fn delete_job(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::archive_search::delete_job(&org_id, &id, user_id).await
+}
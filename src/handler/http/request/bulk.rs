@@ -0,0 +1,119 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{post, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::bulk::{BulkExportRequest, BulkImportRequest, BulkRequest},
+        utils::auth::UserEmail,
+    },
+    service::bulk,
+};
+
+/// BulkApply
+///
+/// Enables, disables, tags or moves many alerts or dashboards at once. The
+/// change is committed only when it succeeds on all of them, the report gives
+/// the status of each object. The id of a dashboard is `{folder}/{dashboard_id}`,
+/// the id of an alert is `{stream_type}/{stream_name}/{name}`.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Bulk",
+    operation_id = "BulkApply",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = BulkRequest, description = "Action and objects", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = BulkResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = BulkResponse),
+        (status = 500, description = "Failure, rolled back", content_type = "application/json", body = BulkResponse),
+    )
+)]
+#[post("/{org_id}/bulk/actions")]
+pub async fn apply(
+    path: web::Path<String>,
+    body: web::Json<BulkRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    bulk::apply(&org_id, &user_email.user_id, body.into_inner()).await
+}
+
+/// BulkExport
+///
+/// Exports many alerts or dashboards, ready to be imported as they are.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Bulk",
+    operation_id = "BulkExport",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = BulkExportRequest, description = "Objects to export", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = BulkResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = BulkResponse),
+    )
+)]
+#[post("/{org_id}/bulk/export")]
+pub async fn export(
+    path: web::Path<String>,
+    body: web::Json<BulkExportRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    bulk::export(&org_id, &user_email.user_id, body.into_inner()).await
+}
+
+/// BulkImport
+///
+/// Imports exported alerts and dashboards, all of them or none. Existing
+/// objects are replaced only when `overwrite` is set.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Bulk",
+    operation_id = "BulkImport",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = BulkImportRequest, description = "Objects to import", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = BulkResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = BulkResponse),
+        (status = 500, description = "Failure, rolled back", content_type = "application/json", body = BulkResponse),
+    )
+)]
+#[post("/{org_id}/bulk/import")]
+pub async fn import(
+    path: web::Path<String>,
+    body: web::Json<BulkImportRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    bulk::import(&org_id, &user_email.user_id, body.into_inner()).await
+}
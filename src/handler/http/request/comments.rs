@@ -0,0 +1,195 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, post, put, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            comments::{CommentRequest, CommentTarget},
+            dashboards::DEFAULT_FOLDER,
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::UserEmail,
+    },
+    service::comments,
+};
+
+/// CreateComment
+///
+/// Comments a saved query or a dashboard. A comment on a dashboard can point
+/// at a panel and annotate a point in time. The folder of a dashboard is
+/// given by `folder`, `default` when not given.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Comments",
+    operation_id = "CreateComment",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("target" = String, Path, description = "saved_queries or dashboards"),
+        ("target_id" = String, Path, description = "Saved query name or dashboard id"),
+        ("folder" = Option<String>, Query, description = "Folder of the dashboard"),
+    ),
+    request_body(content = CommentRequest, description = "Comment data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Comment),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/comments/{target}/{target_id}")]
+pub async fn create_comment(
+    path: web::Path<(String, String, String)>,
+    query: web::Query<HashMap<String, String>>,
+    body: web::Json<CommentRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, target, target_id) = path.into_inner();
+    let target = match target.parse::<CommentTarget>() {
+        Ok(target) => target,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let folder = query.get("folder").map_or(DEFAULT_FOLDER, |v| v.as_str());
+    comments::create_comment(
+        &org_id,
+        &user_email.user_id,
+        target,
+        &target_id,
+        folder,
+        body.into_inner(),
+    )
+    .await
+}
+
+/// ListComments
+///
+/// Lists the comments of a saved query or a dashboard, the oldest first.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Comments",
+    operation_id = "ListComments",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("target" = String, Path, description = "saved_queries or dashboards"),
+        ("target_id" = String, Path, description = "Saved query name or dashboard id"),
+        ("folder" = Option<String>, Query, description = "Folder of the dashboard"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = CommentList),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/comments/{target}/{target_id}")]
+pub async fn list_comments(
+    path: web::Path<(String, String, String)>,
+    query: web::Query<HashMap<String, String>>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, target, target_id) = path.into_inner();
+    let target = match target.parse::<CommentTarget>() {
+        Ok(target) => target,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let folder = query.get("folder").map_or(DEFAULT_FOLDER, |v| v.as_str());
+    comments::list_comments(&org_id, &user_email.user_id, target, &target_id, folder).await
+}
+
+/// UpdateComment
+///
+/// Only the author of the comment and the admins can change it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Comments",
+    operation_id = "UpdateComment",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("target" = String, Path, description = "saved_queries or dashboards"),
+        ("target_id" = String, Path, description = "Saved query name or dashboard id"),
+        ("id" = String, Path, description = "Comment id"),
+    ),
+    request_body(content = CommentRequest, description = "Comment data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Comment),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/comments/{target}/{target_id}/{id}")]
+pub async fn update_comment(
+    path: web::Path<(String, String, String, String)>,
+    body: web::Json<CommentRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, target, target_id, id) = path.into_inner();
+    let target = match target.parse::<CommentTarget>() {
+        Ok(target) => target,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    comments::update_comment(
+        &org_id,
+        &user_email.user_id,
+        target,
+        &target_id,
+        &id,
+        body.into_inner(),
+    )
+    .await
+}
+
+/// DeleteComment
+///
+/// Only the author of the comment and the admins can delete it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Comments",
+    operation_id = "DeleteComment",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("target" = String, Path, description = "saved_queries or dashboards"),
+        ("target_id" = String, Path, description = "Saved query name or dashboard id"),
+        ("id" = String, Path, description = "Comment id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/comments/{target}/{target_id}/{id}")]
+pub async fn delete_comment(
+    path: web::Path<(String, String, String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, target, target_id, id) = path.into_inner();
+    let target = match target.parse::<CommentTarget>() {
+        Ok(target) => target,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    comments::delete_comment(&org_id, &user_email.user_id, target, &target_id, &id).await
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{http::header, post, web, HttpRequest, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{conformance::ConformanceRequest, http::HttpResponse as MetaHttpResponse},
+        utils::auth::UserEmail,
+    },
+    service::{conformance, users},
+};
+
+/// RunOtlpConformance
+///
+/// Runs the built-in OTLP conformance corpus, every data point type and the
+/// edge cases of the attributes, through the OTLP endpoints of this node with
+/// the protobuf and the json encodings over HTTP and through the gRPC
+/// services, and reports which cases pass. The cases are sent with the
+/// authorization header of the request, basic auth to cover the gRPC services,
+/// and the data is ingested into the organization. Only the admins can run it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Conformance",
+    operation_id = "RunOtlpConformance",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = ConformanceRequest, description = "Cases and transports to run", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ConformanceReport),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/otlp/conformance")]
+pub async fn run(
+    path: web::Path<String>,
+    body: web::Json<ConformanceRequest>,
+    user_email: UserEmail,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the admins can run the conformance suite",
+        ));
+    }
+    let Some(auth) = req
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+    else {
+        return Ok(MetaHttpResponse::bad_request(
+            "The conformance suite sends the cases with the Authorization header of the request",
+        ));
+    };
+    conformance::run(&org_id, auth, body.into_inner()).await
+}
@@ -13,13 +13,22 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::io::Error;
+use std::{collections::HashMap, io::Error};
 
 use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
 
-use crate::{common::meta::dashboards::Folder, service::dashboards::folders};
+use crate::{
+    common::{
+        meta::dashboards::{Folder, FolderAccess, FolderPermission, MoveFolder},
+        utils::auth::UserEmail,
+    },
+    service::dashboards::folders::{self, check_access},
+};
 
 /// CreateFolder
+///
+/// Creates a top level folder, or a subfolder of `parentId` which needs the
+/// edit permission on the parent.
 #[utoipa::path(
     context_path = "/api",
     tag = "Dashboards",
@@ -47,8 +56,21 @@ use crate::{common::meta::dashboards::Folder, service::dashboards::folders};
 pub async fn create_folder(
     path: web::Path<String>,
     folder: web::Json<Folder>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let org_id = path.into_inner();
+    if !folder.parent_id.is_empty() {
+        if let Some(resp) = check_access(
+            &org_id,
+            &folder.parent_id,
+            &user_email.user_id,
+            FolderAccess::Edit,
+        )
+        .await
+        {
+            return Ok(resp);
+        }
+    }
     folders::save_folder(&org_id, folder.into_inner(), false).await
 }
 
@@ -81,12 +103,21 @@ pub async fn create_folder(
 pub async fn update_folder(
     path: web::Path<(String, String)>,
     folder: web::Json<Folder>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, folder_id) = path.into_inner();
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     folders::update_folder(&org_id, &folder_id, folder.into_inner()).await
 }
 
 /// ListFolders
+///
+/// Lists the folders the user can view, only the subfolders of `parent` when
+/// given, the top level folders when it is empty.
 #[utoipa::path(
     context_path = "/api",
     tag = "Dashboards",
@@ -96,6 +127,7 @@ pub async fn update_folder(
     ),
     params(
         ("org_id" = String, Path, description = "Organization name"),
+        ("parent" = Option<String>, Query, description = "Parent folder ID"),
     ),
     responses(
         (status = StatusCode::OK, body = FolderList),
@@ -104,9 +136,12 @@ pub async fn update_folder(
 #[get("/{org_id}/folders")]
 pub async fn list_folders(
     path: web::Path<String>,
+    query: web::Query<HashMap<String, String>>,
     _req: HttpRequest,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let org_id = path.into_inner();
+    let parent = query.get("parent").map(|v| v.as_str());
 
     let mut _permitted = None;
     // Get List of allowed objects
@@ -133,7 +168,7 @@ pub async fn list_folders(
         // Get List of allowed objects ends
     }
 
-    folders::list_folders(&org_id, _permitted).await
+    folders::list_folders(&org_id, &user_email.user_id, parent, _permitted).await
 }
 
 /// GetFolder
@@ -154,8 +189,16 @@ pub async fn list_folders(
     ),
 )]
 #[get("/{org_id}/folders/{folder_id}")]
-pub async fn get_folder(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+pub async fn get_folder(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, folder_id) = path.into_inner();
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::View).await
+    {
+        return Ok(resp);
+    }
     folders::get_folder(&org_id, &folder_id).await
 }
 
@@ -178,7 +221,113 @@ pub async fn get_folder(path: web::Path<(String, String)>) -> Result<HttpRespons
     ),
 )]
 #[delete("/{org_id}/folders/{folder_id}")]
-async fn delete_folder(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+async fn delete_folder(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, folder_id) = path.into_inner();
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     folders::delete_folder(&org_id, &folder_id).await
 }
+
+/// MoveFolder
+///
+/// Moves the folder with its content into another folder, to the top level
+/// when `parentId` is empty. Needs the edit permission on both folders.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "MoveFolder",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("folder_id" = String, Path, description = "Folder ID"),
+    ),
+    request_body(
+        content = MoveFolder,
+        description = "New parent folder",
+        example = json!({
+            "parentId": "Parent folder ID",
+        }),
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Folder moved", body = Folder),
+        (status = StatusCode::BAD_REQUEST, description = "Invalid parent", body = HttpResponse),
+        (status = StatusCode::FORBIDDEN, description = "Forbidden", body = HttpResponse),
+        (status = StatusCode::NOT_FOUND, description = "NotFound", body = HttpResponse),
+    ),
+)]
+#[put("/{org_id}/folders/{folder_id}/move")]
+pub async fn move_folder(
+    path: web::Path<(String, String)>,
+    body: web::Json<MoveFolder>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, folder_id) = path.into_inner();
+    let parent_id = body.into_inner().parent_id;
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
+    if !parent_id.is_empty() {
+        if let Some(resp) =
+            check_access(&org_id, &parent_id, &user_email.user_id, FolderAccess::Edit).await
+        {
+            return Ok(resp);
+        }
+    }
+    folders::move_folder(&org_id, &folder_id, &parent_id).await
+}
+
+/// SetFolderPermissions
+///
+/// Replaces who can view or edit the folder, its subfolders and their
+/// dashboards, saved queries and reports. The permissions of the parent
+/// apply when the list is empty. Admins can always edit every folder.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "SetFolderPermissions",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("folder_id" = String, Path, description = "Folder ID"),
+    ),
+    request_body(
+        content = Vec<FolderPermission>,
+        description = "Folder permissions",
+        example = json!([
+            {"user": "alice@example.com", "access": "edit"},
+            {"user": "bob@example.com", "access": "view"},
+        ]),
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Permissions saved", body = Folder),
+        (status = StatusCode::BAD_REQUEST, description = "Invalid permissions", body = HttpResponse),
+        (status = StatusCode::FORBIDDEN, description = "Forbidden", body = HttpResponse),
+        (status = StatusCode::NOT_FOUND, description = "NotFound", body = HttpResponse),
+    ),
+)]
+#[put("/{org_id}/folders/{folder_id}/permissions")]
+pub async fn set_folder_permissions(
+    path: web::Path<(String, String)>,
+    body: web::Json<Vec<FolderPermission>>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, folder_id) = path.into_inner();
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
+    folders::set_folder_permissions(&org_id, &folder_id, body.into_inner()).await
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
+
+use crate::{common::meta::dashboards::library::LibraryPanel, service::dashboards::library};
+
+/// CreateLibraryPanel
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "CreateLibraryPanel",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(
+        content = LibraryPanel,
+        description = "Library panel details",
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Library panel created", body = LibraryPanel),
+        (status = StatusCode::BAD_REQUEST, description = "Invalid library panel", body = HttpResponse),
+    ),
+)]
+#[post("/{org_id}/dashboards/_library")]
+pub async fn create_library_panel(
+    path: web::Path<String>,
+    panel: web::Json<LibraryPanel>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    library::save_library_panel(&org_id, None, panel.into_inner(), user_email).await
+}
+
+/// UpdateLibraryPanel
+///
+/// Updates the library panel, every dashboard referencing it shows the new
+/// version.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "UpdateLibraryPanel",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("panel_id" = String, Path, description = "Library panel ID"),
+    ),
+    request_body(
+        content = LibraryPanel,
+        description = "Library panel details",
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Library panel updated", body = LibraryPanel),
+        (status = StatusCode::NOT_FOUND, description = "Library panel not found", body = HttpResponse),
+    ),
+)]
+#[put("/{org_id}/dashboards/_library/{panel_id}")]
+pub async fn update_library_panel(
+    path: web::Path<(String, String)>,
+    panel: web::Json<LibraryPanel>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, panel_id) = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    library::save_library_panel(&org_id, Some(&panel_id), panel.into_inner(), user_email).await
+}
+
+/// ListLibraryPanels
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "ListLibraryPanels",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = StatusCode::OK, body = LibraryPanelList),
+    ),
+)]
+#[get("/{org_id}/dashboards/_library")]
+pub async fn list_library_panels(path: web::Path<String>) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    library::list_library_panels(&org_id).await
+}
+
+/// GetLibraryPanel
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "GetLibraryPanel",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("panel_id" = String, Path, description = "Library panel ID"),
+    ),
+    responses(
+        (status = StatusCode::OK, body = LibraryPanel),
+        (status = StatusCode::NOT_FOUND, description = "Library panel not found", body = HttpResponse),
+    ),
+)]
+#[get("/{org_id}/dashboards/_library/{panel_id}")]
+pub async fn get_library_panel(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, panel_id) = path.into_inner();
+    library::get_library_panel(&org_id, &panel_id).await
+}
+
+/// GetLibraryPanelUsage
+///
+/// Lists the dashboards and their panels referencing the library panel.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "GetLibraryPanelUsage",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("panel_id" = String, Path, description = "Library panel ID"),
+    ),
+    responses(
+        (status = StatusCode::OK, body = LibraryPanelUsageList),
+        (status = StatusCode::NOT_FOUND, description = "Library panel not found", body = HttpResponse),
+    ),
+)]
+#[get("/{org_id}/dashboards/_library/{panel_id}/usage")]
+pub async fn get_library_panel_usage(
+    path: web::Path<(String, String)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, panel_id) = path.into_inner();
+    library::get_library_panel_usage(&org_id, &panel_id).await
+}
+
+/// DeleteLibraryPanel
+///
+/// A library panel used by dashboards is only deleted with `force=true`, the
+/// dashboards then keep the last version of the panel they saved.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "DeleteLibraryPanel",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("panel_id" = String, Path, description = "Library panel ID"),
+        ("force" = Option<bool>, Query, description = "Delete the panel even if dashboards use it"),
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Success", body = HttpResponse),
+        (status = StatusCode::NOT_FOUND, description = "NotFound", body = HttpResponse),
+        (status = StatusCode::CONFLICT, description = "The panel is in use", body = HttpResponse),
+    ),
+)]
+#[delete("/{org_id}/dashboards/_library/{panel_id}")]
+pub async fn delete_library_panel(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, panel_id) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let force = query
+        .get("force")
+        .is_some_and(|v| v.parse::<bool>().unwrap_or_default());
+    library::delete_library_panel(&org_id, &panel_id, force).await
+}
@@ -15,14 +15,21 @@
 
 use std::{collections::HashMap, io::Error};
 
-use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse, Responder};
+use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse};
 
 use crate::{
-    common::meta::{dashboards::MoveDashboard, http::HttpResponse as MetaHttpResponse},
-    service::dashboards,
+    common::{
+        meta::{
+            dashboards::{grafana::GrafanaImportList, FolderAccess, MoveDashboard},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::UserEmail,
+    },
+    service::dashboards::{self, folders::check_access},
 };
 
 pub mod folders;
+pub mod library;
 pub mod reports;
 
 /// CreateDashboard
@@ -54,9 +61,15 @@ pub async fn create_dashboard(
     path: web::Path<String>,
     body: web::Bytes,
     req: HttpRequest,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let org_id = path.into_inner();
     let folder = get_folder(req);
+    if let Some(resp) =
+        check_access(&org_id, &folder, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     dashboards::create_dashboard(&org_id, &folder, body).await
 }
 
@@ -87,9 +100,15 @@ async fn update_dashboard(
     path: web::Path<(String, String)>,
     body: web::Bytes,
     req: HttpRequest,
-) -> impl Responder {
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, dashboard_id) = path.into_inner();
     let folder = get_folder(req);
+    if let Some(resp) =
+        check_access(&org_id, &folder, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     dashboards::update_dashboard(&org_id, &dashboard_id, &folder, body).await
 }
 
@@ -109,9 +128,19 @@ async fn update_dashboard(
     ),
 )]
 #[get("/{org_id}/dashboards")]
-async fn list_dashboards(org_id: web::Path<String>, req: HttpRequest) -> impl Responder {
+async fn list_dashboards(
+    org_id: web::Path<String>,
+    req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
     let folder = get_folder(req);
-    dashboards::list_dashboards(&org_id.into_inner(), &folder).await
+    if let Some(resp) =
+        check_access(&org_id, &folder, &user_email.user_id, FolderAccess::View).await
+    {
+        return Ok(resp);
+    }
+    dashboards::list_dashboards(&org_id, &folder).await
 }
 
 /// GetDashboard
@@ -132,9 +161,18 @@ async fn list_dashboards(org_id: web::Path<String>, req: HttpRequest) -> impl Re
     ),
 )]
 #[get("/{org_id}/dashboards/{dashboard_id}")]
-async fn get_dashboard(path: web::Path<(String, String)>, req: HttpRequest) -> impl Responder {
+async fn get_dashboard(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, dashboard_id) = path.into_inner();
     let folder = get_folder(req);
+    if let Some(resp) =
+        check_access(&org_id, &folder, &user_email.user_id, FolderAccess::View).await
+    {
+        return Ok(resp);
+    }
     dashboards::get_dashboard(&org_id, &dashboard_id, &folder).await
 }
 
@@ -157,9 +195,18 @@ async fn get_dashboard(path: web::Path<(String, String)>, req: HttpRequest) -> i
     ),
 )]
 #[delete("/{org_id}/dashboards/{dashboard_id}")]
-async fn delete_dashboard(path: web::Path<(String, String)>, req: HttpRequest) -> impl Responder {
+async fn delete_dashboard(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, dashboard_id) = path.into_inner();
     let folder_id = get_folder(req);
+    if let Some(resp) =
+        check_access(&org_id, &folder_id, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     dashboards::delete_dashboard(&org_id, &dashboard_id, &folder_id).await
 }
 
@@ -192,6 +239,7 @@ async fn delete_dashboard(path: web::Path<(String, String)>, req: HttpRequest) -
 async fn move_dashboard(
     path: web::Path<(String, String)>,
     folder: web::Json<MoveDashboard>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, dashboard_id) = path.into_inner();
     if folder.from.is_empty() || folder.to.is_empty() {
@@ -202,10 +250,70 @@ async fn move_dashboard(
             )),
         );
     };
+    for folder_id in [&folder.from, &folder.to] {
+        if let Some(resp) =
+            check_access(&org_id, folder_id, &user_email.user_id, FolderAccess::Edit).await
+        {
+            return Ok(resp);
+        }
+    }
 
     dashboards::move_dashboard(&org_id, &dashboard_id, &folder.from, &folder.to).await
 }
 
+/// ImportGrafanaDashboards
+///
+/// Converts Grafana dashboards and saves them into the folder. The body is a
+/// dashboard JSON, as exported from Grafana, or a list of them. Each dashboard
+/// comes with a report of the panels and variables which could not be fully
+/// imported.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Dashboards",
+    operation_id = "ImportGrafanaDashboards",
+    security(
+        ("Authorization" = [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("folder" = Option<String>, Query, description = "Folder ID, default folder if not set"),
+        ("dry_run" = Option<bool>, Query, description = "Only convert the dashboards, without saving them"),
+    ),
+    request_body(
+        content = Object,
+        description = "Grafana dashboard JSON",
+    ),
+    responses(
+        (status = StatusCode::OK, description = "Dashboards imported", body = GrafanaImportList),
+        (status = StatusCode::BAD_REQUEST, description = "Invalid Grafana dashboard", body = HttpResponse),
+    ),
+)]
+#[post("/{org_id}/dashboards/_import/grafana")]
+async fn import_grafana_dashboards(
+    path: web::Path<String>,
+    body: web::Bytes,
+    req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let dry_run = query
+        .get("dry_run")
+        .is_some_and(|v| v.parse::<bool>().unwrap_or_default());
+    let folder = crate::common::utils::http::get_folder(&query);
+    if !dry_run {
+        if let Some(resp) =
+            check_access(&org_id, &folder, &user_email.user_id, FolderAccess::Edit).await
+        {
+            return Ok(resp);
+        }
+    }
+    match dashboards::grafana::import(&org_id, &folder, &body, dry_run).await {
+        Ok(list) => Ok(MetaHttpResponse::json(GrafanaImportList { list })),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
+
 fn get_folder(req: HttpRequest) -> String {
     let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
     crate::common::utils::http::get_folder(&query)
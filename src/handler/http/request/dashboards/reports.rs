@@ -18,8 +18,17 @@ use std::{collections::HashMap, io::Error};
 use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse};
 
 use crate::{
-    common::meta::{dashboards::reports::Report, http::HttpResponse as MetaHttpResponse},
-    service::dashboards::reports,
+    common::{
+        meta::{
+            dashboards::{reports::Report, FolderAccess},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::UserEmail,
+    },
+    service::dashboards::{
+        folders::{self, FolderAccessResolver},
+        reports,
+    },
 };
 
 /// CreateReport
@@ -50,8 +59,21 @@ use crate::{
 pub async fn create_report(
     path: web::Path<String>,
     report: web::Json<Report>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let org_id = path.into_inner();
+    if !report.folder_id.is_empty() {
+        if let Some(resp) = folders::check_access(
+            &org_id,
+            &report.folder_id,
+            &user_email.user_id,
+            FolderAccess::Edit,
+        )
+        .await
+        {
+            return Ok(resp);
+        }
+    }
     match reports::save(&org_id, "", report.into_inner(), true).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Report saved")),
         Err(e) => Ok(MetaHttpResponse::bad_request(e)),
@@ -84,8 +106,26 @@ pub async fn create_report(
 async fn update_report(
     path: web::Path<(String, String)>,
     report: web::Json<Report>,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, name) = path.into_inner();
+    if let Some(resp) =
+        check_report_access(&org_id, &name, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
+    if !report.folder_id.is_empty() {
+        if let Some(resp) = folders::check_access(
+            &org_id,
+            &report.folder_id,
+            &user_email.user_id,
+            FolderAccess::Edit,
+        )
+        .await
+        {
+            return Ok(resp);
+        }
+    }
     match reports::save(&org_id, &name, report.into_inner(), false).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Report saved")),
         Err(e) => Ok(MetaHttpResponse::bad_request(e)),
@@ -108,7 +148,11 @@ async fn update_report(
     ),
 )]
 #[get("/{org_id}/reports")]
-async fn list_reports(org_id: web::Path<String>, _req: HttpRequest) -> Result<HttpResponse, Error> {
+async fn list_reports(
+    org_id: web::Path<String>,
+    _req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let org_id = org_id.into_inner();
 
     let mut _permitted = None;
@@ -137,7 +181,16 @@ async fn list_reports(org_id: web::Path<String>, _req: HttpRequest) -> Result<Ht
     }
 
     match reports::list(&org_id, _permitted).await {
-        Ok(data) => Ok(MetaHttpResponse::json(data)),
+        Ok(data) => {
+            let resolver = FolderAccessResolver::load(&org_id, &user_email.user_id).await;
+            let data = data
+                .into_iter()
+                .filter(|r| {
+                    r.folder_id.is_empty() || resolver.allows(&r.folder_id, FolderAccess::View)
+                })
+                .collect::<Vec<_>>();
+            Ok(MetaHttpResponse::json(data))
+        }
         Err(e) => Ok(MetaHttpResponse::bad_request(e)),
     }
 }
@@ -160,8 +213,16 @@ async fn list_reports(org_id: web::Path<String>, _req: HttpRequest) -> Result<Ht
     ),
 )]
 #[get("/{org_id}/reports/{name}")]
-async fn get_report(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+async fn get_report(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, name) = path.into_inner();
+    if let Some(resp) =
+        check_report_access(&org_id, &name, &user_email.user_id, FolderAccess::View).await
+    {
+        return Ok(resp);
+    }
     match reports::get(&org_id, &name).await {
         Ok(data) => Ok(MetaHttpResponse::json(data)),
         Err(e) => Ok(MetaHttpResponse::bad_request(e)),
@@ -187,8 +248,16 @@ async fn get_report(path: web::Path<(String, String)>) -> Result<HttpResponse, E
     ),
 )]
 #[delete("/{org_id}/reports/{name}")]
-async fn delete_report(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+async fn delete_report(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, name) = path.into_inner();
+    if let Some(resp) =
+        check_report_access(&org_id, &name, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     match reports::delete(&org_id, &name).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Report deleted")),
         Err(e) => match e {
@@ -202,7 +271,7 @@ async fn delete_report(path: web::Path<(String, String)>) -> Result<HttpResponse
 /// EnableReport
 #[utoipa::path(
     context_path = "/api",
-    tag = "Report",
+    tag = "Reports",
     operation_id = "EnableReport",
     security(
         ("Authorization"= [])
@@ -222,8 +291,14 @@ async fn delete_report(path: web::Path<(String, String)>) -> Result<HttpResponse
 async fn enable_report(
     path: web::Path<(String, String)>,
     req: HttpRequest,
+    user_email: UserEmail,
 ) -> Result<HttpResponse, Error> {
     let (org_id, name) = path.into_inner();
+    if let Some(resp) =
+        check_report_access(&org_id, &name, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
     let enable = match query.get("value") {
         Some(v) => v.parse::<bool>().unwrap_or_default(),
@@ -259,8 +334,16 @@ async fn enable_report(
     )
 )]
 #[put("/{org_id}/reports/{name}/trigger")]
-async fn trigger_report(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+async fn trigger_report(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
     let (org_id, name) = path.into_inner();
+    if let Some(resp) =
+        check_report_access(&org_id, &name, &user_email.user_id, FolderAccess::Edit).await
+    {
+        return Ok(resp);
+    }
     match reports::trigger(&org_id, &name).await {
         Ok(_) => Ok(MetaHttpResponse::ok("Report triggered")),
         Err(e) => match e {
@@ -269,3 +352,18 @@ async fn trigger_report(path: web::Path<(String, String)>) -> Result<HttpRespons
         },
     }
 }
+
+/// Returns a forbidden response when the report is filed in a folder the user
+/// doesn't have the access to
+async fn check_report_access(
+    org_id: &str,
+    name: &str,
+    user_id: &str,
+    access: FolderAccess,
+) -> Option<HttpResponse> {
+    let report = reports::get(org_id, name).await.ok()?;
+    if report.folder_id.is_empty() {
+        return None;
+    }
+    folders::check_access(org_id, &report.folder_id, user_id, access).await
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{post, web, HttpResponse};
+use config::get_config;
+
+use crate::{
+    common::{
+        meta::{demo::SeedRequest, http::HttpResponse as MetaHttpResponse},
+        utils::auth::UserEmail,
+    },
+    service::{demo, users},
+};
+
+/// SeedDemoData
+///
+/// Ingests generated logs, traces and metrics into the organization and
+/// creates a dashboard and a disabled alert over them, for demos and e2e tests.
+/// Only the admins can seed, when `ZO_DEMO_SEED_ENABLED` is set.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Demo",
+    operation_id = "SeedDemoData",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SeedRequest, description = "Data to generate", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SeedResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/demo/seed")]
+pub async fn seed(
+    path: web::Path<String>,
+    body: web::Json<SeedRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !get_config().common.demo_seed_enabled {
+        return Ok(MetaHttpResponse::forbidden("Demo data seeding is disabled"));
+    }
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the admins can seed demo data",
+        ));
+    }
+    demo::seed(&org_id, &user_email.user_id, body.into_inner()).await
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{get, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::{
+    self,
+    entity::{Entity, EntityKind, EntityList},
+};
+
+/// ListEntities
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Entities",
+    operation_id = "ListEntities",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = Option<String>, Query, description = "Entity kind: host, service, pod or container"),
+        ("search" = Option<String>, Query, description = "Only return entities whose name contains this text"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = EntityList),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/entities")]
+pub async fn list_entities(
+    path: web::Path<String>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let kind = match query.get("kind").map(|v| v.parse::<EntityKind>()) {
+        Some(Ok(v)) => Some(v),
+        Some(Err(e)) => return Ok(meta::http::HttpResponse::bad_request(e)),
+        None => None,
+    };
+    crate::service::entities::list(&org_id, kind, query.get("search").map(|v| v.as_str())).await
+}
+
+/// GetEntity
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Entities",
+    operation_id = "GetEntity",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = String, Path, description = "Entity kind: host, service, pod or container"),
+        ("name" = String, Path, description = "Entity name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Entity),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/entities/{kind}/{name:.*}")]
+pub async fn get_entity(path: web::Path<(String, String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, kind, name) = path.into_inner();
+    let kind = match kind.parse::<EntityKind>() {
+        Ok(v) => v,
+        Err(e) => return Ok(meta::http::HttpResponse::bad_request(e)),
+    };
+    crate::service::entities::get(&org_id, kind, &name).await
+}
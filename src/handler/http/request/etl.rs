@@ -0,0 +1,154 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::etl::EtlJob;
+
+/// CreateEtlJob
+///
+/// Creates a job running SQL transformations over the source streams on a
+/// schedule and writing the results to the destination stream.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "ETL Jobs",
+    operation_id = "CreateEtlJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = EtlJob, description = "ETL job data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = EtlJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/etl")]
+pub async fn create_job(
+    org_id: web::Path<String>,
+    body: web::Json<EtlJob>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::etl::save_job(&org_id, user_email, body.into_inner(), true).await
+}
+
+/// UpdateEtlJob
+///
+/// Updates the job, the windows already written are not processed again.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "ETL Jobs",
+    operation_id = "UpdateEtlJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "ETL job name"),
+    ),
+    request_body(content = EtlJob, description = "ETL job data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = EtlJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/etl/{name}")]
+pub async fn update_job(
+    path: web::Path<(String, String)>,
+    body: web::Json<EtlJob>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    let mut job = body.into_inner();
+    job.name = name;
+    crate::service::etl::save_job(&org_id, user_email, job, false).await
+}
+
+/// ListEtlJobs
+#[utoipa::path(
+    context_path = "/api",
+    tag = "ETL Jobs",
+    operation_id = "ListEtlJobs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = EtlJobList),
+    )
+)]
+#[get("/{org_id}/etl")]
+pub async fn list_jobs(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::etl::list_jobs(&org_id.into_inner()).await
+}
+
+/// GetEtlJob
+#[utoipa::path(
+    context_path = "/api",
+    tag = "ETL Jobs",
+    operation_id = "GetEtlJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "ETL job name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = EtlJob),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/etl/{name}")]
+pub async fn get_job(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::etl::get_job(&org_id, &name).await
+}
+
+/// DeleteEtlJob
+///
+/// Deletes the job, the data already written to the destination stream is
+/// kept.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "ETL Jobs",
+    operation_id = "DeleteEtlJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "ETL job name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/etl/{name}")]
+pub async fn delete_job(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::etl::delete_job(&org_id, &name).await
+}
@@ -19,7 +19,7 @@ use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse};
 
 use crate::common::{
     meta,
-    meta::functions::{StreamOrder, Transform},
+    meta::functions::{StreamOrder, TestFunctionRequest, Transform},
     utils::http::get_stream_type_from_request,
 };
 
@@ -154,6 +154,113 @@ pub async fn update_function(
     crate::service::functions::update_function(&org_id, name, transform).await
 }
 
+/// TestFunction
+///
+/// Runs a function against the given events, or the latest records of a
+/// stream the user can read, and returns the transformed output and errors per
+/// record. Nothing is saved or ingested.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Functions",
+    operation_id = "testFunction",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = TestFunctionRequest, description = "Function and sample data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = TestFunctionResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/functions/test")]
+pub async fn test_function(
+    path: web::Path<String>,
+    req: web::Json<TestFunctionRequest>,
+    in_req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let user_id = in_req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::functions::test_function(&org_id, user_id, req.into_inner()).await
+}
+
+/// ListFunctionVersions
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Functions",
+    operation_id = "listFunctionVersions",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Function name"),
+    ),
+    responses(
+        (status = 200, description = "Success",  content_type = "application/json", body = ConfigVersionList),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/functions/{name}/versions")]
+async fn list_function_versions(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::functions::list_function_versions(&org_id, &name).await
+}
+
+/// RollbackFunction
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Functions",
+    operation_id = "rollbackFunction",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Function name"),
+        ("version" = u64, Path, description = "Version to roll back to"),
+    ),
+    responses(
+        (status = 200, description = "Success",  content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/functions/{name}/versions/{version}/rollback")]
+async fn rollback_function(path: web::Path<(String, String, u64)>) -> Result<HttpResponse, Error> {
+    let (org_id, name, version) = path.into_inner();
+    crate::service::functions::rollback_function(&org_id, &name, version).await
+}
+
+/// PromoteFunction
+///
+/// Makes the shadow version of the function the active one. Compare both
+/// versions first with the `ingest_function_shadow_records` metric.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Functions",
+    operation_id = "promoteFunction",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Function name"),
+    ),
+    responses(
+        (status = 200, description = "Success",  content_type = "application/json", body = HttpResponse),
+        (status = 400, description = "Failure",  content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/functions/{name}/promote")]
+async fn promote_function(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::functions::promote_function(&org_id, &name).await
+}
+
 /// ListStreamFunctions
 #[utoipa::path(
     context_path = "/api",
@@ -0,0 +1,219 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, put, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            governance::{GovernanceRequest, GovernedKind, DEFAULT_STALE_DAYS},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::UserEmail,
+    },
+    service::governance::{self, GovernanceFilter},
+};
+
+/// GetStaleReport
+///
+/// Lists the dashboards, the alerts and the pipelines without an owner, owned
+/// by someone who left the organization, disabled, or whose governance was not
+/// reviewed for `days` days, 90 when not given.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Governance",
+    operation_id = "GetStaleReport",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("days" = Option<i64>, Query, description = "Days without a review"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StaleReport),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/governance/stale")]
+pub async fn stale_report(
+    path: web::Path<String>,
+    query: web::Query<HashMap<String, String>>,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let days = match query.get("days").map(|v| v.parse::<i64>()) {
+        None => DEFAULT_STALE_DAYS,
+        Some(Ok(days)) if days > 0 => days,
+        _ => {
+            return Ok(MetaHttpResponse::bad_request(
+                "days should be a positive number",
+            ));
+        }
+    };
+    governance::stale_report(&org_id, days).await
+}
+
+/// ListGovernance
+///
+/// Lists the owners, tags and states of the objects, filtered by `kind`,
+/// `owner`, `tag` and `state` when given.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Governance",
+    operation_id = "ListGovernance",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = Option<String>, Query, description = "dashboards, alerts or pipelines"),
+        ("owner" = Option<String>, Query, description = "Owner email"),
+        ("tag" = Option<String>, Query, description = "Team tag"),
+        ("state" = Option<String>, Query, description = "draft, active or deprecated"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = GovernanceList),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/governance")]
+pub async fn list_governance(
+    path: web::Path<String>,
+    query: web::Query<HashMap<String, String>>,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let kind = match query.get("kind").map(|v| v.parse()).transpose() {
+        Ok(kind) => kind,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let state = match query.get("state").map(|v| v.parse()).transpose() {
+        Ok(state) => state,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let filter = GovernanceFilter {
+        kind,
+        owner: query.get("owner").cloned(),
+        tag: query.get("tag").cloned(),
+        state,
+    };
+    governance::list_governance(&org_id, filter).await
+}
+
+/// SetGovernance
+///
+/// Sets the owner, tags and state of a dashboard, an alert or a pipeline. The
+/// id of a dashboard is `{folder}/{dashboard_id}`, the id of an alert or a
+/// pipeline is `{stream_type}/{stream_name}/{name}`.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Governance",
+    operation_id = "SetGovernance",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = String, Path, description = "dashboards, alerts or pipelines"),
+        ("object_id" = String, Path, description = "Object id"),
+    ),
+    request_body(content = GovernanceRequest, description = "Governance data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Governance),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/governance/{kind}/{object_id:.*}")]
+pub async fn set_governance(
+    path: web::Path<(String, String, String)>,
+    body: web::Json<GovernanceRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, kind, object_id) = path.into_inner();
+    let kind = match kind.parse::<GovernedKind>() {
+        Ok(kind) => kind,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    governance::set_governance(
+        &org_id,
+        &user_email.user_id,
+        kind,
+        &object_id,
+        body.into_inner(),
+    )
+    .await
+}
+
+/// GetGovernance
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Governance",
+    operation_id = "GetGovernance",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = String, Path, description = "dashboards, alerts or pipelines"),
+        ("object_id" = String, Path, description = "Object id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Governance),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/governance/{kind}/{object_id:.*}")]
+pub async fn get_governance(
+    path: web::Path<(String, String, String)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, kind, object_id) = path.into_inner();
+    let kind = match kind.parse::<GovernedKind>() {
+        Ok(kind) => kind,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    governance::get_governance(&org_id, kind, &object_id).await
+}
+
+/// DeleteGovernance
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Governance",
+    operation_id = "DeleteGovernance",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("kind" = String, Path, description = "dashboards, alerts or pipelines"),
+        ("object_id" = String, Path, description = "Object id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/governance/{kind}/{object_id:.*}")]
+pub async fn delete_governance(
+    path: web::Path<(String, String, String)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, kind, object_id) = path.into_inner();
+    let kind = match kind.parse::<GovernedKind>() {
+        Ok(kind) => kind,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    governance::delete_governance(&org_id, kind, &object_id).await
+}
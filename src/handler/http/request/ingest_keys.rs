@@ -0,0 +1,112 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            ingest_keys::{IngestKeyList, IngestKeyRequest},
+        },
+        utils::auth::UserEmail,
+    },
+    service::ingest_keys,
+};
+
+/// CreateIngestKey
+///
+/// Creates a key for signing ingestion requests with HMAC-SHA256 instead of
+/// sending the credentials. The secret is only returned in this response.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "IngestKeys",
+    operation_id = "CreateIngestKey",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = IngestKeyRequest, description = "Ingest key data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = IngestKey),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/ingest_keys")]
+pub async fn create_key(
+    org_id: web::Path<String>,
+    body: web::Json<IngestKeyRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    match ingest_keys::create_key(&org_id.into_inner(), &user_email.user_id, body.into_inner())
+        .await
+    {
+        Ok(key) => Ok(HttpResponse::Ok().json(key)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// ListIngestKeys
+#[utoipa::path(
+    context_path = "/api",
+    tag = "IngestKeys",
+    operation_id = "ListIngestKeys",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = IngestKeyList),
+    )
+)]
+#[get("/{org_id}/ingest_keys")]
+pub async fn list_keys(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    Ok(HttpResponse::Ok().json(IngestKeyList {
+        list: ingest_keys::list_keys(&org_id.into_inner()),
+    }))
+}
+
+/// DeleteIngestKey
+#[utoipa::path(
+    context_path = "/api",
+    tag = "IngestKeys",
+    operation_id = "DeleteIngestKey",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("key_id" = String, Path, description = "Ingest key id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/ingest_keys/{key_id}")]
+pub async fn delete_key(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, key_id) = path.into_inner();
+    match ingest_keys::delete_key(&org_id, &key_id).await {
+        Ok(true) => Ok(MetaHttpResponse::ok("Ingest key deleted")),
+        Ok(false) => Ok(MetaHttpResponse::not_found("Ingest key not found")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
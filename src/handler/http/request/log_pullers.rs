@@ -0,0 +1,176 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpResponse};
+
+use crate::common::meta::log_puller::LogPuller;
+
+/// CreateLogPuller
+///
+/// Creates an integration periodically pulling the logs of a cloud provider
+/// into a logs stream. The credentials are never returned by the API.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "CreateLogPuller",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = LogPuller, description = "Log puller data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogPuller),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/log_pullers")]
+pub async fn create_puller(
+    org_id: web::Path<String>,
+    body: web::Json<LogPuller>,
+) -> Result<HttpResponse, Error> {
+    crate::service::log_puller::save_puller(&org_id.into_inner(), body.into_inner(), true).await
+}
+
+/// UpdateLogPuller
+///
+/// Updates the integration, the pull resumes from its checkpoint. Sending
+/// back the masked credentials keeps the stored ones.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "UpdateLogPuller",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Log puller name"),
+    ),
+    request_body(content = LogPuller, description = "Log puller data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogPuller),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/log_pullers/{name}")]
+pub async fn update_puller(
+    path: web::Path<(String, String)>,
+    body: web::Json<LogPuller>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let mut puller = body.into_inner();
+    puller.name = name;
+    crate::service::log_puller::save_puller(&org_id, puller, false).await
+}
+
+/// ListLogPullers
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "ListLogPullers",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogPullerList),
+    )
+)]
+#[get("/{org_id}/log_pullers")]
+pub async fn list_pullers(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::log_puller::list_pullers(&org_id.into_inner()).await
+}
+
+/// GetLogPuller
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "GetLogPuller",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Log puller name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogPuller),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/log_pullers/{name}")]
+pub async fn get_puller(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::log_puller::get_puller(&org_id, &name).await
+}
+
+/// GetLogPullerStatus
+///
+/// Returns the checkpoint of the integration, the time of its last run and
+/// last success, and the last error.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "GetLogPullerStatus",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Log puller name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogPullerState),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/log_pullers/{name}/status")]
+pub async fn get_status(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::log_puller::get_status(&org_id, &name).await
+}
+
+/// DeleteLogPuller
+///
+/// Deletes the integration and its checkpoint, the logs already pulled are
+/// kept.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Log Pullers",
+    operation_id = "DeleteLogPuller",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Log puller name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/log_pullers/{name}")]
+pub async fn delete_puller(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::log_puller::delete_puller(&org_id, &name).await
+}
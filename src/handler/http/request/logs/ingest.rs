@@ -13,21 +13,29 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::io::Error;
+use std::{collections::HashMap, io::Error};
 
+use actix_multipart::Multipart;
 use actix_web::{http, post, web, HttpRequest, HttpResponse};
+use config::{meta::stream::StreamType, SIZE_IN_MB};
 
 use crate::{
-    common::meta::{
-        http::HttpResponse as MetaHttpResponse,
-        ingestion::{
-            GCPIngestionRequest, IngestionRequest, KinesisFHIngestionResponse, KinesisFHRequest,
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            ingestion::{
+                GCPIngestionRequest, IngestionRequest, KinesisFHIngestionResponse, KinesisFHRequest,
+            },
+            parquet_import::ImportParquetRequest,
         },
+        utils::http::{get_stream_type_from_request, is_sampling_overridden},
     },
     handler::http::request::{CONTENT_TYPE_JSON, CONTENT_TYPE_PROTO},
     service::{
+        ingestion::limits,
         logs,
         logs::otlp_http::{logs_json_handler, logs_proto_handler},
+        parquet_import,
     },
 };
 
@@ -45,6 +53,7 @@ use crate::{
     request_body(content = String, description = "Ingest data (ndjson)", content_type = "application/json"),
     responses(
         (status = 200, description = "Success", content_type = "application/json", body = BulkResponse, example = json!({"took":2,"errors":true,"items":[{"index":{"_index":"olympics","_id":1,"status":200,"error":{"type":"Too old data, only last 5 hours data can be ingested. Data discarded.","reason":"Too old data, only last 5 hours data can be ingested. Data discarded.","index_uuid":"1","shard":"1","index":"olympics"},"original_record":{"athlete":"CHASAPIS, Spiridon","city":"BER","country":"USA","discipline":"Swimming","event":"100M Freestyle For Sailors","gender":"Men","medal":"Silver","onemore":1,"season":"summer","sport":"Aquatics","year":1986}}}]})),
+        (status = 413, description = "Over the current limits, see the o2-ingest-max-bytes and o2-ingest-max-records headers", content_type = "application/json", body = HttpResponse),
         (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
     )
 )]
@@ -56,16 +65,28 @@ pub async fn bulk(
 ) -> Result<HttpResponse, Error> {
     let org_id = org_id.into_inner();
     let user_email = in_req.headers().get("user_id").unwrap().to_str().unwrap();
-    Ok(match logs::bulk::ingest(&org_id, body, user_email).await {
-        Ok(v) => MetaHttpResponse::json(v),
-        Err(e) => {
-            log::error!("Error processing request {org_id}/_bulk: {:?}", e);
-            HttpResponse::BadRequest().json(MetaHttpResponse::error(
-                http::StatusCode::BAD_REQUEST.into(),
-                e.to_string(),
-            ))
-        }
-    })
+    let skip_sampling = is_sampling_overridden(&in_req, &org_id).await;
+    // an action line and a document line per record
+    if let Err(e) = limits::check_records(limits::count_lines(&body) / 2) {
+        return Ok(
+            HttpResponse::PayloadTooLarge().json(MetaHttpResponse::error(
+                http::StatusCode::PAYLOAD_TOO_LARGE.into(),
+                e,
+            )),
+        );
+    }
+    Ok(
+        match logs::bulk::ingest(&org_id, body, user_email, skip_sampling).await {
+            Ok(v) => MetaHttpResponse::json(v),
+            Err(e) => {
+                log::error!("Error processing request {org_id}/_bulk: {:?}", e);
+                HttpResponse::BadRequest().json(MetaHttpResponse::error(
+                    http::StatusCode::BAD_REQUEST.into(),
+                    e.to_string(),
+                ))
+            }
+        },
+    )
 }
 
 /// _multi ingestion API
@@ -83,6 +104,7 @@ pub async fn bulk(
     request_body(content = String, description = "Ingest data (multiple line json)", content_type = "application/json"),
     responses(
         (status = 200, description = "Success", content_type = "application/json", body = IngestionResponse, example = json!({"code": 200,"status": [{"name": "olympics","successful": 3,"failed": 0}]})),
+        (status = 413, description = "Over the current limits, see the o2-ingest-max-bytes and o2-ingest-max-records headers", content_type = "application/json", body = HttpResponse),
         (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
     )
 )]
@@ -100,11 +122,13 @@ pub async fn multi(
             &stream_name,
             IngestionRequest::Multi(&body),
             user_email,
+            is_sampling_overridden(&in_req, &org_id).await,
         )
         .await
         {
             Ok(v) => match v.code {
                 503 => HttpResponse::ServiceUnavailable().json(v),
+                413 => HttpResponse::PayloadTooLarge().json(v),
                 _ => MetaHttpResponse::json(v),
             },
             Err(e) => {
@@ -133,6 +157,7 @@ pub async fn multi(
     request_body(content = String, description = "Ingest data (json array)", content_type = "application/json", example = json!([{"Year": 1896, "City": "Athens", "Sport": "Aquatics", "Discipline": "Swimming", "Athlete": "Alfred", "Country": "HUN"},{"Year": 1896, "City": "Athens", "Sport": "Aquatics", "Discipline": "Swimming", "Athlete": "HERSCHMANN", "Country":"CHN"}])),
     responses(
         (status = 200, description = "Success", content_type = "application/json", body = IngestionResponse, example = json!({"code": 200,"status": [{"name": "olympics","successful": 3,"failed": 0}]})),
+        (status = 413, description = "Over the current limits, see the o2-ingest-max-bytes and o2-ingest-max-records headers", content_type = "application/json", body = HttpResponse),
         (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
     )
 )]
@@ -150,11 +175,13 @@ pub async fn json(
             &stream_name,
             IngestionRequest::JSON(&body),
             user_email,
+            is_sampling_overridden(&in_req, &org_id).await,
         )
         .await
         {
             Ok(v) => match v.code {
                 503 => HttpResponse::ServiceUnavailable().json(v),
+                413 => HttpResponse::PayloadTooLarge().json(v),
                 _ => MetaHttpResponse::json(v),
             },
             Err(e) => {
@@ -204,6 +231,7 @@ pub async fn handle_kinesis_request(
             &stream_name,
             IngestionRequest::KinesisFH(&post_data.into_inner()),
             user_email,
+            is_sampling_overridden(&in_req, &org_id).await,
         )
         .await
         {
@@ -238,6 +266,7 @@ pub async fn handle_gcp_request(
             &stream_name,
             IngestionRequest::GCP(&post_data.into_inner()),
             user_email,
+            is_sampling_overridden(&in_req, &org_id).await,
         )
         .await
         {
@@ -277,12 +306,13 @@ pub async fn otlp_logs_write(
         .headers()
         .get(&config::get_config().grpc.stream_header_key)
         .map(|header| header.to_str().unwrap());
+    let skip_sampling = is_sampling_overridden(&req, &org_id).await;
     if content_type.eq(CONTENT_TYPE_PROTO) {
         // log::info!("otlp::logs_proto_handler");
-        logs_proto_handler(&org_id, body, in_stream_name, user_email).await
+        logs_proto_handler(&org_id, body, in_stream_name, user_email, skip_sampling).await
     } else if content_type.starts_with(CONTENT_TYPE_JSON) {
         // log::info!("otlp::logs_json_handler");
-        logs_json_handler(&org_id, body, in_stream_name, user_email).await
+        logs_json_handler(&org_id, body, in_stream_name, user_email, skip_sampling).await
     } else {
         Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
             http::StatusCode::BAD_REQUEST.into(),
@@ -290,3 +320,96 @@ pub async fn otlp_logs_write(
         )))
     }
 }
+
+/// ImportParquet
+///
+/// Registers parquet files of the bucket into the stream without reprocessing
+/// their records, e.g. to backfill the output of a Spark job. The files must
+/// be under `import/{org_id}/` in the bucket. Every field of a file must be in
+/// the stream schema with the same type, the `_timestamp` column must have
+/// statistics and its records must be in one time partition of the stream.
+/// The files are copied under the stream.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Logs",
+    operation_id = "ImportParquet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    request_body(content = ImportParquetRequest, description = "Keys of the files in the bucket", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ImportParquetResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/{stream_name}/_import_parquet")]
+pub async fn import_parquet(
+    path: web::Path<(String, String)>,
+    body: web::Json<ImportParquetRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    parquet_import::import_from_storage(&org_id, &stream_name, stream_type, body.into_inner()).await
+}
+
+/// UploadParquet
+///
+/// Same as ImportParquet for parquet files uploaded as a multipart form, up
+/// to ZO_PARQUET_IMPORT_MAX_SIZE mb per request.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Logs",
+    operation_id = "UploadParquet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    request_body(content = String, description = "Parquet files", content_type = "multipart/form-data"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ImportParquetResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/{stream_name}/_import_parquet/upload")]
+pub async fn upload_parquet(
+    path: web::Path<(String, String)>,
+    payload: Multipart,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let content_length = req
+        .headers()
+        .get("content-length")
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.parse::<f64>().ok())
+        .unwrap_or_default()
+        / SIZE_IN_MB;
+    let max_size = config::get_config().limit.parquet_import_max_size;
+    if content_length > max_size as f64 {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "exceeds allowed limit of {max_size} mb"
+        )));
+    }
+    parquet_import::import_from_multipart(&org_id, &stream_name, stream_type, payload).await
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, http::header, post, web, HttpRequest, HttpResponse};
+use config::{get_config, utils::json};
+
+use crate::common::meta::{
+    http::HttpResponse as MetaHttpResponse,
+    mcp::{JsonRpcRequest, JsonRpcResponse, McpTokenRequest, INVALID_REQUEST, PARSE_ERROR},
+};
+
+/// CreateMcpToken
+///
+/// Creates the token of an AI assistant calling the tools at
+/// `/mcp/{org_id}`. The token is only returned by this call, it is limited to
+/// the streams and the limits set here.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "MCP",
+    operation_id = "CreateMcpToken",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = McpTokenRequest, description = "Token data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = McpTokenCreated),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/mcp/tokens")]
+pub async fn create_token(
+    org_id: web::Path<String>,
+    body: web::Json<McpTokenRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::mcp::create_token(&org_id, user_email, body.into_inner()).await
+}
+
+/// ListMcpTokens
+#[utoipa::path(
+    context_path = "/api",
+    tag = "MCP",
+    operation_id = "ListMcpTokens",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = McpTokenList),
+    )
+)]
+#[get("/{org_id}/mcp/tokens")]
+pub async fn list_tokens(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::mcp::list_tokens(&org_id.into_inner()).await
+}
+
+/// DeleteMcpToken
+#[utoipa::path(
+    context_path = "/api",
+    tag = "MCP",
+    operation_id = "DeleteMcpToken",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Token name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/mcp/tokens/{name}")]
+pub async fn delete_token(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::mcp::delete_token(&org_id, &name).await
+}
+
+/// Receives the JSON-RPC messages of the Model Context Protocol, single or
+/// batched, authenticated by the bearer token of an MCP token
+#[post("/{org_id}")]
+pub async fn handle_request(
+    org_id: web::Path<String>,
+    body: web::Bytes,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    if !get_config().limit.mcp_enabled {
+        return Ok(MetaHttpResponse::not_found("MCP is disabled"));
+    }
+    let bearer = req
+        .headers()
+        .get(header::AUTHORIZATION)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.strip_prefix("Bearer "))
+        .unwrap_or_default();
+    let token = match crate::service::mcp::authenticate(&org_id, bearer.trim()).await {
+        Ok(token) => token,
+        Err(e) => return Ok(MetaHttpResponse::unauthorized(e)),
+    };
+
+    let (messages, batch) = match json::from_slice::<json::Value>(&body) {
+        Ok(json::Value::Array(list)) => (list, true),
+        Ok(v) => (vec![v], false),
+        Err(e) => {
+            let resp = JsonRpcResponse::error(json::Value::Null, PARSE_ERROR, e);
+            return Ok(HttpResponse::Ok().json(resp));
+        }
+    };
+    let mut responses = Vec::with_capacity(messages.len());
+    for msg in messages {
+        let id = msg.get("id").cloned().unwrap_or_default();
+        match json::from_value::<JsonRpcRequest>(msg) {
+            Ok(msg) => {
+                if let Some(resp) = crate::service::mcp::handle(&token, msg).await {
+                    responses.push(resp);
+                }
+            }
+            Err(e) => responses.push(JsonRpcResponse::error(id, INVALID_REQUEST, e)),
+        }
+    }
+    // only notifications or responses
+    if responses.is_empty() {
+        return Ok(HttpResponse::Accepted().finish());
+    }
+    if batch {
+        Ok(HttpResponse::Ok().json(responses))
+    } else {
+        Ok(HttpResponse::Ok().json(&responses[0]))
+    }
+}
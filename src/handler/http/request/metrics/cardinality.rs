@@ -0,0 +1,155 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{get, web, HttpRequest, HttpResponse};
+
+use crate::{common::meta::http::HttpResponse as MetaHttpResponse, service::metrics::cardinality};
+
+/// Reads the time range from the request, defaults to the last hour.
+fn get_time_range(query: &HashMap<String, String>) -> (i64, i64) {
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .filter(|v| *v > 0)
+        .unwrap_or_else(config::utils::time::query_now_micros);
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .filter(|v| *v > 0)
+        .unwrap_or(end_time - 3600 * 1_000_000);
+    (start_time, end_time)
+}
+
+/// GetMetricTopStreams
+///
+/// Ranks the metric streams of the organization by their number of series in
+/// the time range, the top offenders first.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "GetMetricTopStreams",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, defaults to one hour before end_time"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, defaults to now"),
+        ("limit" = Option<usize>, Query, description = "Maximum streams to return, default 10"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = TopStreamsResponse),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/metrics/cardinality")]
+pub async fn get_top_streams(
+    path: web::Path<String>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let (start_time, end_time) = get_time_range(&query);
+    let limit = query
+        .get("limit")
+        .and_then(|v| v.parse::<usize>().ok())
+        .unwrap_or_default();
+    match cardinality::get_top_streams(&org_id, start_time, end_time, limit).await {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// GetMetricCardinality
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "GetMetricCardinality",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Metric stream name"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, defaults to one hour before end_time"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, defaults to now"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = CardinalityResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/metrics/{stream_name}/cardinality")]
+pub async fn get_cardinality(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let (start_time, end_time) = get_time_range(&query);
+    match cardinality::get_cardinality(&org_id, &stream_name, start_time, end_time).await {
+        Ok(Some(resp)) => Ok(MetaHttpResponse::json(resp)),
+        Ok(None) => Ok(MetaHttpResponse::not_found("metric stream not found")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// GetMetricLabelValues
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "GetMetricLabelValues",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Metric stream name"),
+        ("label" = String, Path, description = "Label name"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, defaults to one hour before end_time"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, defaults to now"),
+        ("limit" = Option<i64>, Query, description = "Maximum values to return, default 100"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LabelValuesResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/metrics/{stream_name}/cardinality/{label}")]
+pub async fn get_label_values(
+    path: web::Path<(String, String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name, label) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let (start_time, end_time) = get_time_range(&query);
+    let limit = query
+        .get("limit")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    match cardinality::get_label_values(&org_id, &stream_name, &label, start_time, end_time, limit)
+        .await
+    {
+        Ok(Some(resp)) => Ok(MetaHttpResponse::json(resp)),
+        Ok(None) => Ok(MetaHttpResponse::not_found(
+            "metric stream or label not found",
+        )),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
@@ -14,24 +14,46 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 pub mod alerts;
+pub mod archive_search;
 pub mod authz;
+pub mod bulk;
 pub mod clusters;
+pub mod comments;
+pub mod conformance;
 pub mod dashboards;
+pub mod demo;
 pub mod enrichment_table;
+pub mod entities;
+pub mod etl;
 pub mod functions;
+pub mod governance;
+pub mod ingest_keys;
 pub mod kv;
+pub mod log_pullers;
 pub mod logs;
+pub mod mcp;
 pub mod metrics;
 pub mod organization;
 pub mod pipelines;
+pub mod plugins;
 pub mod prom;
+pub mod query_history;
+pub mod rehydration;
 pub mod rum;
+pub mod saved_queries;
+pub mod schema_contracts;
 pub mod search;
+pub mod search_jobs;
+pub mod secrets;
+pub mod snapshot;
 pub mod status;
 pub mod stream;
+pub mod subscriptions;
+pub mod synthetics;
 pub mod syslog;
 pub mod traces;
 pub mod users;
+pub mod webhooks;
 
 pub const CONTENT_TYPE_JSON: &str = "application/json";
 pub const CONTENT_TYPE_PROTO: &str = "application/x-protobuf";
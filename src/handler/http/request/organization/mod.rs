@@ -13,5 +13,7 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 pub mod es;
+pub mod network_policy;
 pub mod org;
+pub mod residency;
 pub mod settings;
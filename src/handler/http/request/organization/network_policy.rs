@@ -0,0 +1,138 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, put, web, HttpRequest, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            organization::{NetworkPolicy, NetworkPolicyResponse, NetworkScope},
+            user::UserRole,
+        },
+        utils::auth::UserEmail,
+    },
+    service::{db, network_policy, users},
+};
+
+async fn is_org_admin(org_id: &str, user_id: &str) -> bool {
+    users::get_user(Some(org_id), user_id)
+        .await
+        .is_some_and(|u| u.role.eq(&UserRole::Admin) || u.role.eq(&UserRole::Root))
+}
+
+/// GetNetworkPolicy
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "NetworkPolicyGet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = NetworkPolicyResponse),
+    )
+)]
+#[get("/{org_id}/network_policy")]
+async fn get(path: web::Path<String>) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let data = db::network_policy::get(&org_id).unwrap_or_default();
+    Ok(HttpResponse::Ok().json(NetworkPolicyResponse { data }))
+}
+
+/// SetNetworkPolicy
+///
+/// Restricts the UI, query and ingest endpoints of the org to CIDR allowlists.
+/// An empty list leaves the endpoints open to every address.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "NetworkPolicySet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = NetworkPolicy, description = "Network policy", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/network_policy")]
+async fn set(
+    path: web::Path<String>,
+    policy: web::Json<NetworkPolicy>,
+    user_email: UserEmail,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let policy = policy.into_inner();
+    if !is_org_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only org admins can change the network policy",
+        ));
+    }
+
+    // refuse a policy that would lock the caller out of the console
+    if !policy.ui.is_empty() {
+        let client_ip = network_policy::client_ip(req.peer_addr(), req.headers());
+        if !client_ip.is_some_and(|ip| policy.allows(NetworkScope::Ui, ip)) {
+            return Ok(MetaHttpResponse::bad_request(
+                "The ui allowlist must include the address you are connecting from",
+            ));
+        }
+    }
+
+    match db::network_policy::set(&org_id, &policy).await {
+        Ok(()) => Ok(MetaHttpResponse::ok("Network policy saved")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// DeleteNetworkPolicy
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "NetworkPolicyDelete",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/network_policy")]
+async fn delete(path: web::Path<String>, user_email: UserEmail) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !is_org_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only org admins can change the network policy",
+        ));
+    }
+    match db::network_policy::delete(&org_id).await {
+        Ok(()) => Ok(MetaHttpResponse::ok("Network policy removed")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
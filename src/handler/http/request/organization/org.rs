@@ -337,11 +337,9 @@ async fn create_user_rumtoken(
     security(
         ("Authorization"= [])
     ),
-    params(
-        ("org_id" = String, Path, description = "Organization name"),
-      ),
+    request_body(content = Organization, description = "Organization data", content_type = "application/json"),
     responses(
-        (status = 200, description = "Success", content_type = "application/json", body = RumIngestionResponse),
+        (status = 200, description = "Success", content_type = "application/json", body = Organization),
     )
 )]
 #[post("/organizations")]
@@ -0,0 +1,144 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, put, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            organization::{OrgResidency, OrgResidencyResponse},
+            user::UserRole,
+        },
+        utils::auth::UserEmail,
+    },
+    service::{db, residency, users},
+};
+
+async fn is_org_admin(org_id: &str, user_id: &str) -> bool {
+    users::get_user(Some(org_id), user_id)
+        .await
+        .is_some_and(|u| u.role.eq(&UserRole::Admin) || u.role.eq(&UserRole::Root))
+}
+
+/// GetOrgResidency
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "OrgResidencyGet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = OrgResidencyResponse),
+    )
+)]
+#[get("/{org_id}/residency")]
+async fn get(path: web::Path<String>) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    Ok(HttpResponse::Ok().json(OrgResidencyResponse {
+        local_region: residency::local_region(),
+        data: db::residency::get(&org_id),
+    }))
+}
+
+/// SetOrgResidency
+///
+/// Pins the data of the org to a region. Clusters of other regions reject its
+/// ingestion and queries, and super cluster queries never fan out to them.
+/// The org can only be pinned to the region of the cluster serving the
+/// request, which is where its data is.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "OrgResidencySet",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = OrgResidency, description = "Org residency", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/residency")]
+async fn set(
+    path: web::Path<String>,
+    data: web::Json<OrgResidency>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let mut data = data.into_inner();
+    if !is_org_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only org admins can change the residency of the org",
+        ));
+    }
+
+    data.region = data.region.trim().to_string();
+    let local_region = residency::local_region();
+    if local_region.is_empty() {
+        return Ok(MetaHttpResponse::bad_request(
+            "This cluster has no region configured, set ZO_CLUSTER_REGION first",
+        ));
+    }
+    if data.region != local_region {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "The org can only be pinned to the region of this cluster [{local_region}]"
+        )));
+    }
+
+    match db::residency::set(&org_id, &data).await {
+        Ok(()) => Ok(MetaHttpResponse::ok("Org residency saved")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// DeleteOrgResidency
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Organizations",
+    operation_id = "OrgResidencyDelete",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/residency")]
+async fn delete(path: web::Path<String>, user_email: UserEmail) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !is_org_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only org admins can change the residency of the org",
+        ));
+    }
+    match db::residency::delete(&org_id).await {
+        Ok(()) => Ok(MetaHttpResponse::ok("Org residency removed")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
@@ -96,7 +96,7 @@ pub async fn save_pipeline(
         ("org_id" = String, Path, description = "Organization name"),
     ),
     responses(
-        (status = 200, description = "Success", content_type = "application/json", body = PipelineList),
+        (status = 200, description = "Success", content_type = "application/json", body = PipeLineList),
     )
 )]
 #[get("/{org_id}/pipelines")]
@@ -165,6 +165,76 @@ async fn delete_pipeline(
     crate::service::pipelines::delete_pipeline(&org_id, stream_type, &stream_name, &name).await
 }
 
+/// ListPipelineVersions
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Pipelines",
+    operation_id = "listPipelineVersions",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("name" = String, Path, description = "Pipeline name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ConfigVersionList),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/pipelines/{name}/versions")]
+async fn list_pipeline_versions(
+    path: web::Path<(String, String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name, name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(crate::common::meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    crate::service::pipelines::list_pipeline_versions(&org_id, stream_type, &stream_name, &name)
+        .await
+}
+
+/// RollbackPipeline
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Pipelines",
+    operation_id = "rollbackPipeline",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("name" = String, Path, description = "Pipeline name"),
+        ("version" = u64, Path, description = "Version to roll back to"),
+    ),
+    responses(
+        (status = 200, description = "Success",  content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/streams/{stream_name}/pipelines/{name}/versions/{version}/rollback")]
+async fn rollback_pipeline(
+    path: web::Path<(String, String, String, u64)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name, name, version) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(crate::common::meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    crate::service::pipelines::rollback_pipeline(&org_id, stream_type, &stream_name, &name, version)
+        .await
+}
+
 /// UpdatePipeline
 #[utoipa::path(
     context_path = "/api",
@@ -175,6 +245,7 @@ async fn delete_pipeline(
     ),
     params(
         ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
         ("name" = String, Path, description = "Pipeline name"),
     ),
     request_body(content = PipeLine, description = "Pipeline data", content_type = "application/json"),
@@ -0,0 +1,263 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            plugins::{Plugin, PluginList},
+        },
+        utils::auth::{is_root_user, UserEmail},
+    },
+    service::{plugins, users},
+};
+
+fn save_response(ret: Result<Plugin, (http::StatusCode, anyhow::Error)>) -> HttpResponse {
+    match ret {
+        Ok(plugin) => MetaHttpResponse::json(plugin),
+        Err((http::StatusCode::BAD_REQUEST, e)) => MetaHttpResponse::bad_request(e),
+        Err((http::StatusCode::NOT_FOUND, e)) => MetaHttpResponse::not_found(e),
+        Err((_, e)) => MetaHttpResponse::internal_error(e),
+    }
+}
+
+/// RegisterPlugin
+///
+/// Registers a gRPC plugin, shared by all the organizations. OpenObserve calls
+/// `Describe` on the plugin to record the sources, destinations and query
+/// functions it provides, so the plugin should be running. Only the root user
+/// can manage the plugins.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "RegisterPlugin",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = Plugin, description = "Plugin data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Plugin),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/plugins")]
+pub async fn register_plugin(
+    body: web::Json<Plugin>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    if !is_root_user(&user_email.user_id) {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the root user can manage plugins",
+        ));
+    }
+    Ok(save_response(plugins::save(body.into_inner(), true).await))
+}
+
+/// UpdatePlugin
+///
+/// Updates the plugin and records its capabilities again, e.g. after a new
+/// version of the plugin was deployed.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "UpdatePlugin",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Plugin name"),
+    ),
+    request_body(content = Plugin, description = "Plugin data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Plugin),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/plugins/{name}")]
+pub async fn update_plugin(
+    path: web::Path<(String, String)>,
+    body: web::Json<Plugin>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    if !is_root_user(&user_email.user_id) {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the root user can manage plugins",
+        ));
+    }
+    let (_org_id, name) = path.into_inner();
+    let mut plugin = body.into_inner();
+    plugin.name = name;
+    Ok(save_response(plugins::save(plugin, false).await))
+}
+
+/// ListPlugins
+///
+/// Lists the plugins with their health as seen by the node answering. The
+/// admins of the organization can list them to set up their log pullers and
+/// destinations.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "ListPlugins",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = PluginList),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/plugins")]
+pub async fn list_plugins(
+    org_id: web::Path<String>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+    }
+    Ok(MetaHttpResponse::json(PluginList {
+        list: plugins::list(),
+    }))
+}
+
+/// GetPlugin
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "GetPlugin",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Plugin name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = PluginWithStatus),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/plugins/{name}")]
+pub async fn get_plugin(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+    }
+    match plugins::get(&name) {
+        Some(plugin) => Ok(MetaHttpResponse::json(plugin)),
+        None => Ok(MetaHttpResponse::not_found("Plugin not found")),
+    }
+}
+
+/// EnablePlugin
+///
+/// Disabling a plugin makes its calls fail right away, the log pullers and
+/// destinations using it report the error.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "EnablePlugin",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Plugin name"),
+        ("value" = bool, Query, description = "Enable or disable the plugin"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/plugins/{name}/enable")]
+pub async fn enable_plugin(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    if !is_root_user(&user_email.user_id) {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the root user can manage plugins",
+        ));
+    }
+    let (_org_id, name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let enable = match query.get("value") {
+        Some(v) => v.parse::<bool>().unwrap_or_default(),
+        None => false,
+    };
+    match plugins::set_enabled(&name, enable).await {
+        Ok(true) => {
+            let mut resp = HashMap::new();
+            resp.insert("enabled".to_string(), enable);
+            Ok(MetaHttpResponse::json(resp))
+        }
+        Ok(false) => Ok(MetaHttpResponse::not_found("Plugin not found")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// DeletePlugin
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Plugins",
+    operation_id = "DeletePlugin",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Plugin name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/plugins/{name}")]
+pub async fn delete_plugin(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    if !is_root_user(&user_email.user_id) {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the root user can manage plugins",
+        ));
+    }
+    let (_org_id, name) = path.into_inner();
+    match plugins::delete(&name).await {
+        Ok(true) => Ok(MetaHttpResponse::ok("Plugin deleted")),
+        Ok(false) => Ok(MetaHttpResponse::not_found("Plugin not found")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
@@ -17,7 +17,7 @@ use std::collections::HashSet;
 use std::io::Error;
 
 use actix_web::{get, http, post, web, HttpRequest, HttpResponse};
-use config::utils::time::{parse_milliseconds, parse_str_to_timestamp_micros};
+use config::utils::time::{parse_milliseconds, parse_str_to_timestamp_micros, query_now_micros};
 use infra::errors;
 use promql_parser::parser;
 
@@ -26,6 +26,8 @@ use crate::{
     service::{metrics, promql, promql::MetricsQueryRequest},
 };
 
+pub mod scrape;
+
 /// prometheus remote-write endpoint for metrics
 #[utoipa::path(
     context_path = "/api",
@@ -67,6 +69,57 @@ pub async fn remote_write(
     }
 }
 
+/// prometheus remote-read endpoint for metrics
+// refer: https://prometheus.io/docs/prometheus/latest/querying/remote_read_api/
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "PrometheusRemoteRead",
+        security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = String, description = "prometheus ReadRequest", content_type = "application/x-protobuf"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/x-protobuf", body = String),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/prometheus/api/v1/read")]
+pub async fn remote_read(
+    org_id: web::Path<String>,
+    req: HttpRequest,
+    body: web::Bytes,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let content_type = req
+        .headers()
+        .get("Content-Type")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or_default();
+    if content_type != "application/x-protobuf" {
+        return Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
+            http::StatusCode::BAD_REQUEST.into(),
+            "Bad Request".to_string(),
+        )));
+    }
+    Ok(
+        match metrics::remote_read::remote_read(&org_id, body).await {
+            Ok(resp) => HttpResponse::Ok()
+                .content_type("application/x-protobuf")
+                .insert_header(("Content-Encoding", "snappy"))
+                .insert_header(("X-Prometheus-Remote-Read-Version", "0.1.0"))
+                .body(resp),
+            Err(e) => HttpResponse::BadRequest().json(MetaHttpResponse::error(
+                http::StatusCode::BAD_REQUEST.into(),
+                e.to_string(),
+            )),
+        },
+    )
+}
+
 /// prometheus instant queries
 // refer: https://prometheus.io/docs/prometheus/latest/querying/api/#instant-queries
 #[utoipa::path(
@@ -182,7 +235,7 @@ async fn query(
     }
 
     let start = match req.time {
-        None => chrono::Utc::now().timestamp_micros(),
+        None => query_now_micros(),
         Some(v) => match parse_str_to_timestamp_micros(&v) {
             Ok(v) => v,
             Err(e) => {
@@ -334,7 +387,7 @@ async fn query_range(
     }
 
     let start = match req.start {
-        None => chrono::Utc::now().timestamp_micros(),
+        None => query_now_micros(),
         Some(v) => match parse_str_to_timestamp_micros(&v) {
             Ok(v) => v,
             Err(e) => {
@@ -349,7 +402,7 @@ async fn query_range(
         },
     };
     let end = match req.end {
-        None => chrono::Utc::now().timestamp_micros(),
+        None => query_now_micros(),
         Some(v) => match parse_str_to_timestamp_micros(&v) {
             Ok(v) => v,
             Err(e) => {
@@ -382,14 +435,26 @@ async fn query_range(
         // Grafana: Time range / max data points = step
         step = (end - start) / promql::MAX_DATA_POINTS;
     }
-    if step < promql::micros(promql::MINIMAL_INTERVAL) {
-        step = promql::micros(promql::MINIMAL_INTERVAL);
+    let query = req.query.unwrap_or_default();
+    let minimal_step = promql::minimal_step(org_id, &query).await;
+    if step < minimal_step {
+        step = minimal_step;
+    }
+    if (end - start) / step > promql::MAX_POINTS_PER_SERIES {
+        return Ok(HttpResponse::BadRequest().json(promql::QueryResponse {
+            status: promql::Status::Error,
+            data: None,
+            error_type: Some("bad_data".to_string()),
+            error: Some(
+                "exceeded maximum resolution of 11,000 points per timeseries. Try decreasing the query resolution (?step=XX)".to_string(),
+            ),
+        }));
     }
 
     let timeout = search_timeout(req.timeout);
 
     let req = MetricsQueryRequest {
-        query: req.query.unwrap_or_default(),
+        query,
         start,
         end,
         step,
@@ -410,6 +475,8 @@ async fn query_range(
         ("org_id" = String, Path, description = "Organization name"),
         ("limit" = String, Query, description = "Maximum number of metrics to return"),
         ("metric" = Option<String>, Query, description = "A metric name to filter metadata for. All metric metadata is retrieved if left empty"),
+        ("search" = Option<String>, Query, description = "Only return metrics whose name or description contains this text, case insensitive"),
+        ("type" = Option<String>, Query, description = "Only return metrics of this type, e.g. counter, gauge, histogram"),
     ),
     responses(
         (status = 200, description = "Success", content_type = "application/json", body = HttpResponse, example = json!({
@@ -791,7 +858,7 @@ fn validate_metadata_params(
         parse_str_to_timestamp_micros(&start.unwrap()).map_err(|e| e.to_string())?
     };
     let end = if end.is_none() || end.as_ref().unwrap().is_empty() {
-        chrono::Utc::now().timestamp_micros()
+        query_now_micros()
     } else {
         parse_str_to_timestamp_micros(&end.unwrap()).map_err(|e| e.to_string())?
     };
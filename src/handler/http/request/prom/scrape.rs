@@ -0,0 +1,183 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpResponse};
+
+use crate::{
+    common::{meta::scrape::ScrapeConfig, utils::auth::UserEmail},
+    service::metrics::scrape,
+};
+
+/// CreateScrapeConfig
+///
+/// Configures targets exposing Prometheus/OpenMetrics metrics to be scraped by
+/// the ingesters.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "CreateScrapeConfig",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = ScrapeConfig, description = "Scrape config", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ScrapeConfig),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/prometheus/scrape_configs")]
+pub async fn create_config(
+    org_id: web::Path<String>,
+    body: web::Json<ScrapeConfig>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    scrape::save_config(
+        &org_id.into_inner(),
+        &user_email.user_id,
+        body.into_inner(),
+        true,
+    )
+    .await
+}
+
+/// UpdateScrapeConfig
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "UpdateScrapeConfig",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Scrape job name"),
+    ),
+    request_body(content = ScrapeConfig, description = "Scrape config", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ScrapeConfig),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/prometheus/scrape_configs/{name}")]
+pub async fn update_config(
+    path: web::Path<(String, String)>,
+    body: web::Json<ScrapeConfig>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let mut config = body.into_inner();
+    config.name = name;
+    scrape::save_config(&org_id, &user_email.user_id, config, false).await
+}
+
+/// ListScrapeConfigs
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "ListScrapeConfigs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ScrapeConfigList),
+    )
+)]
+#[get("/{org_id}/prometheus/scrape_configs")]
+pub async fn list_configs(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    scrape::list_configs(&org_id.into_inner()).await
+}
+
+/// GetScrapeConfig
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "GetScrapeConfig",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Scrape job name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ScrapeConfig),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/prometheus/scrape_configs/{name}")]
+pub async fn get_config(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    scrape::get_config_by_name(&org_id, &name).await
+}
+
+/// DeleteScrapeConfig
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "DeleteScrapeConfig",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Scrape job name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/prometheus/scrape_configs/{name}")]
+pub async fn delete_config(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    scrape::delete_config(&org_id, &name).await
+}
+
+/// ListScrapeTargets
+///
+/// Lists the discovered targets of the scrape job and the ingester scraping
+/// each of them.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Metrics",
+    operation_id = "ListScrapeTargets",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Scrape job name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ScrapeTargetList),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/prometheus/scrape_configs/{name}/targets")]
+pub async fn list_targets(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    scrape::list_targets(&org_id, &name).await
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{get, web, HttpRequest, HttpResponse};
+
+use crate::{
+    common::{meta::http::HttpResponse as MetaHttpResponse, utils::auth::UserEmail},
+    service::query_history,
+};
+
+/// ListQueryHistory
+///
+/// Lists the searches of the user with their execution stats, the latest
+/// first. The admins can list the searches of another user with `user`, or
+/// of everyone without it. The time range defaults to the last 7 days.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "ListQueryHistory",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("user" = Option<String>, Query, description = "Email of the user, admins only"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds"),
+        ("size" = Option<i64>, Query, description = "Maximum number of searches, default 100"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Object),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/query_history")]
+pub async fn list_history(
+    path: web::Path<String>,
+    req: HttpRequest,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_else(config::utils::time::query_now_micros);
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_else(|| {
+            end_time
+                - chrono::Duration::try_days(7)
+                    .unwrap()
+                    .num_microseconds()
+                    .unwrap()
+        });
+    let size = query
+        .get("size")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or(100);
+    match query_history::list(
+        &org_id,
+        &user_email.user_id,
+        query.get("user").map(|v| v.as_str()),
+        start_time,
+        end_time,
+        size,
+    )
+    .await
+    {
+        Ok(data) => {
+            let mut mapdata = HashMap::new();
+            mapdata.insert("list", data);
+            Ok(MetaHttpResponse::json(mapdata))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
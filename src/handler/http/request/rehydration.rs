@@ -0,0 +1,119 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::rehydration::RehydrationRequest;
+
+/// CreateRehydrationJob
+///
+/// Materializes the records of an archived stream matching the time range and
+/// filter into a temporary stream, which is deleted once its ttl expires.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "CreateRehydrationJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = RehydrationRequest, description = "Rehydration job data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = RehydrationJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/rehydration")]
+pub async fn create_job(
+    org_id: web::Path<String>,
+    body: web::Json<RehydrationRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::rehydration::create_job(&org_id, user_email, body.into_inner()).await
+}
+
+/// ListRehydrationJobs
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "ListRehydrationJobs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = RehydrationJobList),
+    )
+)]
+#[get("/{org_id}/rehydration")]
+pub async fn list_jobs(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::rehydration::list_jobs(&org_id.into_inner()).await
+}
+
+/// GetRehydrationJob
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "GetRehydrationJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Rehydration job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = RehydrationJob),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/rehydration/{id}")]
+pub async fn get_job(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    crate::service::rehydration::get_job(&org_id, &id).await
+}
+
+/// DeleteRehydrationJob
+///
+/// Deletes the job together with its temporary stream.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "DeleteRehydrationJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Rehydration job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/rehydration/{id}")]
+pub async fn delete_job(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    crate::service::rehydration::delete_job(&org_id, &id).await
+}
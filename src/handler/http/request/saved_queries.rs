@@ -0,0 +1,215 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, post, put, web, HttpResponse};
+
+use crate::common::{
+    meta::saved_query::{RunSavedQueryRequest, SavedQuery},
+    utils::auth::UserEmail,
+};
+
+/// CreateSavedQuery
+///
+/// Adds a query to the library of the organization. The parameters are
+/// written in the SQL as `:name` and declared with their type. The query is
+/// private to its creator until `sharing` is set to `org`.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "CreateSavedQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SavedQuery, description = "Saved query data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SavedQuery),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/saved_queries")]
+pub async fn create_query(
+    org_id: web::Path<String>,
+    body: web::Json<SavedQuery>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    crate::service::saved_query::save_query(
+        &org_id.into_inner(),
+        &user_email.user_id,
+        body.into_inner(),
+        true,
+    )
+    .await
+}
+
+/// UpdateSavedQuery
+///
+/// Only the owner of the query and the admins can update it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "UpdateSavedQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Saved query name"),
+    ),
+    request_body(content = SavedQuery, description = "Saved query data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SavedQuery),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/saved_queries/{name}")]
+pub async fn update_query(
+    path: web::Path<(String, String)>,
+    body: web::Json<SavedQuery>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let mut query = body.into_inner();
+    query.name = name;
+    crate::service::saved_query::save_query(&org_id, &user_email.user_id, query, false).await
+}
+
+/// ListSavedQueries
+///
+/// Lists the queries shared with the organization and the private queries of
+/// the user, `tag` keeps the queries with the tag.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "ListSavedQueries",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("tag" = Option<String>, Query, description = "Only the queries with the tag"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SavedQueryList),
+    )
+)]
+#[get("/{org_id}/saved_queries")]
+pub async fn list_queries(
+    org_id: web::Path<String>,
+    query: web::Query<HashMap<String, String>>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let tag = query.get("tag").map(|t| t.trim().to_lowercase());
+    crate::service::saved_query::list_queries(
+        &org_id.into_inner(),
+        &user_email.user_id,
+        tag.as_deref(),
+    )
+    .await
+}
+
+/// GetSavedQuery
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "GetSavedQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Saved query name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SavedQuery),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/saved_queries/{name}")]
+pub async fn get_query(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::saved_query::get_query(&org_id, &user_email.user_id, &name).await
+}
+
+/// RunSavedQuery
+///
+/// Runs the query by name with the given parameters. The parameters left out
+/// take their default, the time range defaults to the last `period` minutes.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "RunSavedQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Saved query name"),
+    ),
+    request_body(content = RunSavedQueryRequest, description = "Parameters and time range", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SearchResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/saved_queries/{name}/_run")]
+pub async fn run_query(
+    path: web::Path<(String, String)>,
+    body: web::Json<RunSavedQueryRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::saved_query::run_query(&org_id, &user_email.user_id, &name, body.into_inner())
+        .await
+}
+
+/// DeleteSavedQuery
+///
+/// Only the owner of the query and the admins can delete it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Saved Queries",
+    operation_id = "DeleteSavedQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Saved query name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/saved_queries/{name}")]
+pub async fn delete_query(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::saved_query::delete_query(&org_id, &user_email.user_id, &name).await
+}
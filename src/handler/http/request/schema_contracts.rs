@@ -0,0 +1,167 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{delete, get, put, web, HttpRequest, HttpResponse};
+
+use crate::common::{
+    meta::{self, schema_contract::SchemaContract},
+    utils::http::get_stream_type_from_request,
+};
+
+/// SaveSchemaContract
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "SaveSchemaContract",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    request_body(content = SchemaContract, description = "Schema contract data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SchemaContract),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/streams/{stream_name}/schema_contract")]
+pub async fn save_contract(
+    path: web::Path<(String, String)>,
+    contract: web::Json<SchemaContract>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    let mut contract = contract.into_inner();
+    contract.stream_name = stream_name;
+    contract.stream_type = stream_type;
+    crate::service::schema_contracts::save_contract(&org_id, contract).await
+}
+
+/// GetSchemaContract
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "GetSchemaContract",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SchemaContract),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/schema_contract")]
+pub async fn get_contract(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    crate::service::schema_contracts::get_contract_response(&org_id, stream_type, &stream_name)
+        .await
+}
+
+/// DeleteSchemaContract
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "DeleteSchemaContract",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/streams/{stream_name}/schema_contract")]
+pub async fn delete_contract(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    crate::service::schema_contracts::delete_contract(&org_id, stream_type, &stream_name).await
+}
+
+/// GetSchemaContractReport
+///
+/// Returns the per producer validation report of the stream schema contract,
+/// producers with the most violated records come first.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "GetSchemaContractReport",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ContractReport),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/schema_contract/report")]
+pub async fn get_report(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or_default(),
+        Err(e) => {
+            return Ok(meta::http::HttpResponse::bad_request(e));
+        }
+    };
+    crate::service::schema_contracts::get_report(&org_id, stream_type, &stream_name).await
+}
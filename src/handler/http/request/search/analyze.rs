@@ -0,0 +1,69 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{post, web, HttpRequest, HttpResponse};
+use config::meta::stream::StreamType;
+
+use crate::{
+    common::{
+        meta::{http::HttpResponse as MetaHttpResponse, query_analyze::QueryAnalyzeRequest},
+        utils::http::get_stream_type_from_request,
+    },
+    service::query_analyze,
+};
+
+/// AnalyzeQuery
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "AnalyzeQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("type" = Option<String>, Query, description = "Stream type of sql queries, default logs"),
+    ),
+    request_body(content = QueryAnalyzeRequest, description = "Query to analyze", content_type = "application/json", example = json!({
+        "query": "sum(rate(http_requests_total{code=~\".*\"}[5m]))",
+        "query_type": "promql",
+        "start_time": 1675182660872049i64,
+        "end_time": 1675185660872049i64,
+        "step": 60
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = QueryAnalyzeResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_query_analyze")]
+pub async fn analyze(
+    org_id: web::Path<String>,
+    in_req: HttpRequest,
+    body: web::Json<QueryAnalyzeRequest>,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    match query_analyze::analyze(&org_id, stream_type, &body.into_inner()).await {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{get, post, web, HttpRequest, HttpResponse};
+use config::meta::stream::StreamType;
+
+use crate::{
+    common::{
+        meta::{
+            dashboards::FolderAccess,
+            http::HttpResponse as MetaHttpResponse,
+            search::{FederatedDashboardRequest, FederatedSearchRequest},
+        },
+        utils::http::{get_folder, get_or_create_trace_id_and_span, get_stream_type_from_request},
+    },
+    service::{
+        dashboards::{folders::check_access, library},
+        db, search as SearchService,
+    },
+};
+
+/// SearchFederated
+///
+/// Runs a query in several organizations and merges the hits, tagged with
+/// their organization in the `_org` column. Only the root user and the roles
+/// listed in `ZO_FEDERATED_SEARCH_ROLES` can run it, in the organizations
+/// they belong to. Queries all of them when `orgs` is empty.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "SearchFederated",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("type" = Option<String>, Query, description = "Stream type, default logs"),
+    ),
+    request_body(content = FederatedSearchRequest, description = "Federated query", content_type = "application/json", example = json!({
+        "orgs": ["payments", "checkout"],
+        "query": {
+            "sql": "SELECT k8s_cluster, count(*) AS errors FROM default WHERE level = 'error' GROUP BY k8s_cluster",
+            "start_time": 1675182660872049i64,
+            "end_time": 1675185660872049i64,
+            "size": 100
+        }
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = FederatedSearchResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_search_federated")]
+pub async fn search_federated(
+    org_id: web::Path<String>,
+    in_req: HttpRequest,
+    body: web::Json<FederatedSearchRequest>,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .unwrap()
+        .to_str()
+        .unwrap()
+        .to_string();
+    let (trace_id, _http_span) = get_or_create_trace_id_and_span(
+        in_req.headers(),
+        format!("api/{org_id}/_search_federated"),
+    );
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let req = body.into_inner();
+
+    let orgs = match SearchService::federated::resolve_orgs(&user_id, &req.orgs).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::forbidden(e)),
+    };
+
+    // the stream permission and the max query range are checked in every
+    // organization, failing the organizations the user can't read
+    match SearchService::federated::search(&trace_id, &orgs, stream_type, &user_id, &req.query)
+        .await
+    {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
+
+/// SearchFederatedDashboard
+///
+/// Runs the SQL panels of a dashboard of the organization in several
+/// organizations, each panel query returning the merged hits of all of them.
+/// The panels using dashboard variables and the PromQL panels are reported as
+/// failed. Queries all the organizations the user can federate when `orgs` is
+/// empty.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "SearchFederatedDashboard",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("dashboard_id" = String, Path, description = "Dashboard ID"),
+        ("folder" = Option<String>, Query, description = "Folder of the dashboard, default folder when missing"),
+    ),
+    request_body(content = FederatedDashboardRequest, description = "Federated dashboard", content_type = "application/json", example = json!({
+        "orgs": ["payments", "checkout"],
+        "start_time": 1675182660872049i64,
+        "end_time": 1675185660872049i64
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = FederatedDashboardResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_search_federated/dashboards/{dashboard_id}")]
+pub async fn search_federated_dashboard(
+    path: web::Path<(String, String)>,
+    in_req: HttpRequest,
+    body: web::Json<FederatedDashboardRequest>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, dashboard_id) = path.into_inner();
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .unwrap()
+        .to_str()
+        .unwrap()
+        .to_string();
+    let (trace_id, _http_span) = get_or_create_trace_id_and_span(
+        in_req.headers(),
+        format!("api/{org_id}/_search_federated/dashboards/{dashboard_id}"),
+    );
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+    let folder = get_folder(&query);
+    if let Some(resp) = check_access(&org_id, &folder, &user_id, FolderAccess::View).await {
+        return Ok(resp);
+    }
+    let req = body.into_inner();
+
+    let Ok(mut dashboard) = db::dashboards::get(&org_id, &dashboard_id, &folder).await else {
+        return Ok(MetaHttpResponse::not_found("Dashboard not found"));
+    };
+    library::resolve(&org_id, std::slice::from_mut(&mut dashboard)).await;
+    let Some(dashboard) = dashboard.v4 else {
+        return Ok(MetaHttpResponse::bad_request(
+            "Only the dashboards of version 4 can be federated",
+        ));
+    };
+
+    let orgs = match SearchService::federated::resolve_orgs(&user_id, &req.orgs).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::forbidden(e)),
+    };
+    let resp =
+        SearchService::federated::search_dashboard(&trace_id, &orgs, &user_id, &dashboard, &req)
+            .await;
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// ListFederatedOrganizations
+///
+/// Lists the organizations the user can run federated queries in.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "ListFederatedOrganizations",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Vec<String>),
+    )
+)]
+#[get("/{org_id}/_search_federated/orgs")]
+pub async fn list_federated_orgs(
+    _org_id: web::Path<String>,
+    in_req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .unwrap()
+        .to_str()
+        .unwrap()
+        .to_string();
+    let orgs = SearchService::federated::federated_orgs(&user_id).await;
+    Ok(MetaHttpResponse::json(orgs))
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{post, web, HttpRequest, HttpResponse};
+
+use crate::{
+    common::meta::{http::HttpResponse as MetaHttpResponse, log_summary::LogSummaryRequest},
+    service::log_summary,
+};
+
+/// SummarizeLogs
+///
+/// Summarizes the logs returned by a query in a time window: the most
+/// frequent message patterns, the error patterns which are new and the
+/// patterns which changed the most compared to the previous window of the
+/// same duration. When the language model is enabled, it also writes a short
+/// narrative summary.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "SummarizeLogs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = LogSummaryRequest, description = "Query window", content_type = "application/json", example = json!({
+        "sql": "SELECT * FROM \"default\"",
+        "stream_type": "logs",
+        "start_time": 1675182660872049i64,
+        "end_time": 1675185660872049i64
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LogSummaryResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_summarize")]
+pub async fn summarize(
+    org_id: web::Path<String>,
+    body: web::Json<LogSummaryRequest>,
+    in_req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let req = body.into_inner();
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or_default()
+        .to_string();
+    // Check permissions on stream
+    #[cfg(feature = "enterprise")]
+    {
+        use crate::common::{
+            infra::config::USERS,
+            utils::auth::{is_root_user, AuthExtractor},
+        };
+
+        let parsed_sql = match config::meta::sql::Sql::new(&req.sql) {
+            Ok(v) => v,
+            Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+        };
+        if !is_root_user(&user_id) {
+            let user: crate::common::meta::user::User =
+                USERS.get(&format!("{org_id}/{}", user_id)).unwrap().clone();
+
+            if user.is_external
+                && !crate::handler::http::auth::validator::check_permissions(
+                    &user_id,
+                    AuthExtractor {
+                        auth: "".to_string(),
+                        method: "GET".to_string(),
+                        o2_type: format!("{}:{}", req.stream_type, parsed_sql.source),
+                        org_id: org_id.clone(),
+                        bypass_check: false,
+                        parent_id: "".to_string(),
+                    },
+                    Some(user.role),
+                )
+                .await
+            {
+                return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+            }
+        }
+    }
+
+    match log_summary::summarize(&org_id, &user_id, &req).await {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
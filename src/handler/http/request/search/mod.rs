@@ -20,7 +20,7 @@ use chrono::{Duration, Utc};
 use config::{
     get_config,
     meta::{
-        search::SearchEventType,
+        search::{SearchEventContext, SearchEventType},
         stream::StreamType,
         usage::{RequestStats, UsageType},
     },
@@ -40,6 +40,7 @@ use crate::{
         meta::{
             self,
             http::HttpResponse as MetaHttpResponse,
+            query_history::QueryHistoryRecord,
             search::{CachedQueryResponse, QueryDelta},
         },
         utils::{
@@ -51,14 +52,20 @@ use crate::{
         },
     },
     service::{
+        plugins, query_history,
         search::{self as SearchService, cache::cacher::check_cache, sql::RE_ONLY_SELECT},
         usage::report_request_usage_stats,
     },
 };
 
+pub mod analyze;
+pub mod federated;
 pub mod job;
+pub mod log_summary;
 pub mod multi_streams;
+pub mod nl_query;
 pub mod saved_view;
+pub mod table;
 
 /// SearchStreamData
 #[utoipa::path(
@@ -70,6 +77,11 @@ pub mod saved_view;
     ),
     params(
         ("org_id" = String, Path, description = "Organization name"),
+        ("search_type" = Option<String>, Query, description = "What runs the query: ui, dashboards, reports, alerts, values, rum"),
+        ("dashboard_id" = Option<String>, Query, description = "Dashboard of the query, with search_type=dashboards"),
+        ("panel_id" = Option<String>, Query, description = "Panel of the query, with search_type=dashboards"),
+        ("report_key" = Option<String>, Query, description = "Report of the query, with search_type=reports"),
+        ("plugin_fn" = Option<String>, Query, description = "Plugin query function run on the hits, as <plugin>/<function>"),
     ),
     request_body(content = SearchRequest, description = "Search query", content_type = "application/json", example = json!({
         "query": {
@@ -152,7 +164,17 @@ pub async fn search(
         Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
     };
 
-    let use_cache = get_use_cache_from_request(&query);
+    // cursor pagination, an empty cursor asks for the first page
+    let cursor_mode = query.contains_key("cursor");
+    let cursor = match query
+        .get("cursor")
+        .map(|v| SearchService::cursor::Cursor::decode(v))
+    {
+        Some(Err(e)) => return Ok(MetaHttpResponse::bad_request(e)),
+        Some(Ok(v)) => v,
+        None => None,
+    };
+    let use_cache = get_use_cache_from_request(&query) && !cursor_mode;
     // handle encoding for query and aggs
     let mut req: config::meta::search::Request = match json::from_slice(&body) {
         Ok(v) => v,
@@ -161,6 +183,12 @@ pub async fn search(
     if let Err(e) = req.decode() {
         return Ok(MetaHttpResponse::bad_request(e));
     }
+    if req.search_event_context.is_none() {
+        req.search_event_context = SearchEventContext::from_params(search_type, &query);
+    }
+    if let Some(cursor) = cursor.as_ref() {
+        cursor.apply(&mut req);
+    }
 
     let mut rpc_req: proto::cluster_rpc::SearchRequest = req.to_owned().into();
     rpc_req.org_id = org_id.to_string();
@@ -178,6 +206,22 @@ pub async fn search(
     };
 
     let stream_name = &parsed_sql.source;
+    if cursor_mode {
+        if let Err(e) = SearchService::cursor::validate(&parsed_sql) {
+            return Ok(MetaHttpResponse::bad_request(e));
+        }
+    }
+    // the result cache is shared by the org, the results of a user with
+    // hidden fields must neither come from it nor go into it
+    let use_cache = use_cache
+        && SearchService::field_access::HiddenFields::load(
+            &org_id,
+            stream_type,
+            stream_name,
+            &user_id,
+        )
+        .await
+        .is_empty();
 
     let r = STREAM_SCHEMAS_LATEST.read().await;
     let stream_schema = r.get(format!("{}/{}/{}", org_id, stream_type, stream_name).as_str());
@@ -374,6 +418,15 @@ pub async fn search(
                                         ),
                                     )
                                 }
+                                errors::ErrorCodes::SearchRegionNotAllowed(_)
+                                | errors::ErrorCodes::SearchFieldNotAllowed(_) => {
+                                    HttpResponse::Forbidden().json(
+                                        meta::http::HttpResponse::error_code_with_trace_id(
+                                            code,
+                                            Some(trace_id),
+                                        ),
+                                    )
+                                }
                                 _ => HttpResponse::InternalServerError().json(
                                     meta::http::HttpResponse::error_code_with_trace_id(
                                         code,
@@ -427,6 +480,36 @@ pub async fn search(
         res.new_start_time = Some(req.query.start_time);
         res.new_end_time = Some(req.query.end_time);
     }
+    if cursor_mode {
+        res.next_cursor = SearchService::cursor::next_cursor(&req, &res).map(|c| c.encode());
+    }
+
+    query_history::record(
+        &org_id,
+        QueryHistoryRecord {
+            timestamp: started_at,
+            user_email: user_id.to_string(),
+            stream_type: stream_type.to_string(),
+            stream_name: stream_name.to_string(),
+            sql: req.query.sql.clone(),
+            start_time: req.query.start_time,
+            end_time: req.query.end_time,
+            search_type: search_type
+                .as_ref()
+                .map(|t| t.to_string().to_lowercase())
+                .unwrap_or_default(),
+            dashboard_id: req
+                .search_event_context
+                .as_ref()
+                .and_then(|c| c.dashboard_id.clone()),
+            took: res.took,
+            hits: res.hits.len(),
+            scan_size: res.scan_size,
+            scan_records: res.scan_records,
+            cached_ratio: res.cached_ratio,
+            trace_id: trace_id.clone(),
+        },
+    );
 
     let req_stats = RequestStats {
         records: res.hits.len() as i64,
@@ -438,6 +521,7 @@ pub async fn search(
         max_ts: Some(req.query.end_time),
         cached_ratio: Some(res.cached_ratio),
         search_type,
+        search_event_context: req.search_event_context,
         trace_id: Some(trace_id.clone()),
         took_wait_in_queue: if res.took_detail.is_some() {
             let resp_took = res.took_detail.as_ref().unwrap();
@@ -479,8 +563,121 @@ pub async fn search(
     }
     // result cache save changes Ends
 
+    // plugin query function, applied after caching so the cache keeps the
+    // rows of the query. A failing plugin leaves the rows as they are.
+    if let Some(plugin_fn) = query.get("plugin_fn").filter(|v| !v.is_empty()) {
+        match plugins::call_function(&org_id, plugin_fn, &res.hits).await {
+            Ok(hits) => res.hits = hits,
+            Err(e) => {
+                log::error!("plugin function {plugin_fn} error: {e}");
+                res.function_error = if res.function_error.is_empty() {
+                    e.to_string()
+                } else {
+                    format!("{} \n {}", res.function_error, e)
+                };
+            }
+        }
+    }
+
     Ok(HttpResponse::Ok().json(res))
 }
+/// SearchStream
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "SearchStream",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("cursor" = Option<String>, Query, description = "Resume from the next_cursor of a previous page"),
+        ("plugin_fn" = Option<String>, Query, description = "Plugin query function run on each page of rows, as <plugin>/<function>"),
+    ),
+    request_body(content = SearchRequest, description = "Search query, query.size is the page size used to fetch the rows", content_type = "application/json", example = json!({
+        "query": {
+            "sql": "select * from k8s",
+            "start_time": 1675182660872049i64,
+            "end_time": 1675185660872049i64,
+            "size": 5000
+        }
+    })),
+    responses(
+        (status = 200, description = "Success, one matching row per line", content_type = "application/x-ndjson"),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_search_stream")]
+pub async fn search_stream(
+    org_id: web::Path<String>,
+    in_req: HttpRequest,
+    body: web::Bytes,
+) -> Result<HttpResponse, Error> {
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .and_then(|v| v.to_str().ok())
+        .map(|v| v.to_string());
+    let org_id = org_id.into_inner();
+    let (trace_id, _) =
+        get_or_create_trace_id_and_span(in_req.headers(), format!("api/{org_id}/_search_stream"));
+
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let mut req: config::meta::search::Request = match json::from_slice(&body) {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    if let Err(e) = req.decode() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    let parsed_sql = match config::meta::sql::Sql::new(&req.query.sql) {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    if let Err(e) = SearchService::cursor::validate(&parsed_sql) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match query
+        .get("cursor")
+        .map(|v| SearchService::cursor::Cursor::decode(v))
+    {
+        Some(Err(e)) => return Ok(MetaHttpResponse::bad_request(e)),
+        Some(Ok(Some(cursor))) => cursor.apply(&mut req),
+        _ => {}
+    }
+
+    // the same stream permission and query range as the search
+    let stream_name = &parsed_sql.source;
+    if let Some(user_id) = user_id.as_deref() {
+        if !SearchService::access::can_read(&org_id, user_id, stream_type, stream_name).await {
+            return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+        }
+    }
+    if let Some(range_error) =
+        SearchService::access::clamp_query_range(&org_id, stream_type, stream_name, &mut req.query)
+            .await
+    {
+        log::info!("[trace_id {trace_id}] {range_error}");
+    }
+
+    let plugin_fn = query.get("plugin_fn").filter(|v| !v.is_empty()).cloned();
+    let stream = SearchService::cursor::stream_ndjson(
+        trace_id,
+        org_id,
+        stream_type,
+        user_id,
+        req,
+        plugin_fn,
+    );
+    Ok(HttpResponse::Ok()
+        .content_type("application/x-ndjson")
+        .streaming(stream))
+}
+
 /// SearchAround
 #[utoipa::path(
     context_path = "/api",
@@ -652,6 +849,7 @@ pub async fn around(
         clusters: clusters.clone(),
         timeout,
         search_type: Some(SearchEventType::UI),
+        search_event_context: None,
     };
     let user_id = in_req
         .headers()
@@ -714,6 +912,7 @@ pub async fn around(
         clusters,
         timeout,
         search_type: Some(SearchEventType::UI),
+        search_event_context: None,
     };
     let search_fut = SearchService::search(&trace_id, &org_id, stream_type, user_id, &req);
     let search_res = if !cfg.common.tracing_enabled && cfg.common.tracing_search_enabled {
@@ -1087,6 +1286,7 @@ async fn values_v1(
         clusters,
         timeout,
         search_type: Some(SearchEventType::Values),
+        search_event_context: None,
     };
 
     // skip fields which aren't part of the schema
@@ -1312,6 +1512,7 @@ async fn values_v2(
         clusters,
         timeout,
         search_type: Some(SearchEventType::Values),
+        search_event_context: None,
     };
     let search_fut = SearchService::search(
         &trace_id,
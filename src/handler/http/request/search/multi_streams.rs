@@ -48,7 +48,7 @@ use crate::{
 #[utoipa::path(
     context_path = "/api",
     tag = "Search",
-    operation_id = "SearchSQL",
+    operation_id = "SearchSQLMulti",
     security(
         ("Authorization"= [])
     ),
@@ -684,6 +684,7 @@ pub async fn around_multi(
             clusters: clusters.clone(),
             timeout,
             search_type: Some(search::SearchEventType::UI),
+            search_event_context: None,
         };
         let search_fut =
             SearchService::search(&trace_id, &org_id, stream_type, user_id.clone(), &req);
@@ -761,6 +762,7 @@ pub async fn around_multi(
             clusters: clusters.clone(),
             timeout,
             search_type: Some(search::SearchEventType::UI),
+            search_event_context: None,
         };
         let search_fut =
             SearchService::search(&trace_id, &org_id, stream_type, user_id.clone(), &req);
@@ -0,0 +1,62 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{post, web, HttpResponse};
+
+use crate::{
+    common::meta::{http::HttpResponse as MetaHttpResponse, nl_query::NlQueryRequest},
+    service::nl_query,
+};
+
+/// GenerateQuery
+///
+/// Generates the SQL or PromQL query of a question in natural language,
+/// grounded on the schemas of the streams and on the saved views. The query
+/// isn't run, it is returned with an explanation and its estimated cost for
+/// the user to confirm it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "GenerateQuery",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = NlQueryRequest, description = "Question", content_type = "application/json", example = json!({
+        "question": "top 10 hosts by number of errors",
+        "query_type": "sql",
+        "stream_type": "logs",
+        "start_time": 1675182660872049i64,
+        "end_time": 1675185660872049i64
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = NlQueryResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_nl_query")]
+pub async fn generate(
+    org_id: web::Path<String>,
+    body: web::Json<NlQueryRequest>,
+) -> Result<HttpResponse, Error> {
+    match nl_query::generate(&org_id.into_inner(), &body.into_inner()).await {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{post, web, HttpRequest, HttpResponse};
+use config::meta::stream::StreamType;
+
+use crate::{
+    common::{
+        meta::{http::HttpResponse as MetaHttpResponse, table_query::TableQueryRequest},
+        utils::http::{get_or_create_trace_id_and_span, get_stream_type_from_request},
+    },
+    service::search as SearchService,
+};
+
+/// SearchTable
+///
+/// Returns a page of the rows of a table panel, sorted by the server, with the
+/// formatting of its conditional rules. Pass the `next_cursor` of a page to get
+/// the following one.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "SearchTable",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("type" = Option<String>, Query, description = "Stream type, default logs"),
+    ),
+    request_body(content = TableQueryRequest, description = "Table query", content_type = "application/json", example = json!({
+        "sql": "SELECT kubernetes_host, count(*) AS requests FROM default GROUP BY kubernetes_host",
+        "start_time": 1675182660872049i64,
+        "end_time": 1675185660872049i64,
+        "sort": [{"column": "requests", "desc": true}],
+        "size": 100,
+        "rules": [{"column": "requests", "operator": "gt", "value": "1000", "background_color": "#f44336"}]
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = TableQueryResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/_search_table")]
+pub async fn search_table(
+    org_id: web::Path<String>,
+    in_req: HttpRequest,
+    body: web::Json<TableQueryRequest>,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .unwrap()
+        .to_str()
+        .unwrap()
+        .to_string();
+    let (trace_id, _http_span) =
+        get_or_create_trace_id_and_span(in_req.headers(), format!("api/{org_id}/_search_table"));
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let table = body.into_inner();
+
+    // Check permissions on stream
+    #[cfg(feature = "enterprise")]
+    {
+        use crate::common::{
+            infra::config::USERS,
+            meta,
+            utils::auth::{is_root_user, AuthExtractor},
+        };
+
+        let stream_name = match config::meta::sql::Sql::new(&table.sql) {
+            Ok(v) => v.source,
+            Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+        };
+        if !is_root_user(&user_id) {
+            let user: meta::user::User =
+                USERS.get(&format!("{org_id}/{}", user_id)).unwrap().clone();
+
+            if user.is_external
+                && !crate::handler::http::auth::validator::check_permissions(
+                    &user_id,
+                    AuthExtractor {
+                        auth: "".to_string(),
+                        method: "GET".to_string(),
+                        o2_type: format!("{}:{}", stream_type, stream_name),
+                        org_id: org_id.clone(),
+                        bypass_check: false,
+                        parent_id: "".to_string(),
+                    },
+                    Some(user.role),
+                )
+                .await
+            {
+                return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+            }
+        }
+    }
+
+    match SearchService::table::search(&trace_id, &org_id, stream_type, Some(user_id), &table).await
+    {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::search_jobs::SearchJobRequest;
+
+/// CreateSearchJob
+///
+/// Runs the query in the background. The job survives the restart of the
+/// querier running it: another querier resumes it from the last partition of
+/// the time range searched, and the hits found so far are returned while it
+/// runs, with `partial` set.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "CreateSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SearchJobRequest, description = "Search job data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SearchJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/search_jobs")]
+pub async fn create_job(
+    org_id: web::Path<String>,
+    body: web::Json<SearchJobRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let org_id = org_id.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::search_jobs::create_job(&org_id, user_email, body.into_inner()).await
+}
+
+/// ListSearchJobs
+///
+/// Lists the jobs of the user, the admins get every job of the organization.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "ListSearchJobs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SearchJobList),
+    )
+)]
+#[get("/{org_id}/search_jobs")]
+pub async fn list_jobs(org_id: web::Path<String>, req: HttpRequest) -> Result<HttpResponse, Error> {
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::search_jobs::list_jobs(&org_id.into_inner(), user_id).await
+}
+
+/// GetSearchJob
+///
+/// Returns the job with the hits found so far, the jobs of the other users are
+/// only found by the admins.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "GetSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Search job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SearchJob),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/search_jobs/{id}")]
+pub async fn get_job(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::search_jobs::get_job(&org_id, &id, user_id).await
+}
+
+/// DeleteSearchJob
+///
+/// Deletes the job, a running job stops after the partition it is searching.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Search",
+    operation_id = "DeleteSearchJob",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("id" = String, Path, description = "Search job id"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/search_jobs/{id}")]
+pub async fn delete_job(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, id) = path.into_inner();
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::search_jobs::delete_job(&org_id, &id, user_id).await
+}
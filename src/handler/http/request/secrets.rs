@@ -0,0 +1,148 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpResponse};
+
+use crate::common::meta::secrets::SecretRequest;
+
+/// CreateSecret
+///
+/// Stores a credential that destinations, subscriptions and log pullers
+/// reference as `{{secret:<name>}}`. Only the kinds listed in `consumers` can
+/// reference the secret. The value is sealed and never returned by the API.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Secrets",
+    operation_id = "CreateSecret",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SecretRequest, description = "Secret data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Secret),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/secrets")]
+pub async fn create_secret(
+    org_id: web::Path<String>,
+    body: web::Json<SecretRequest>,
+) -> Result<HttpResponse, Error> {
+    crate::service::secrets::save_secret(&org_id.into_inner(), body.into_inner(), true).await
+}
+
+/// RotateSecret
+///
+/// Replaces the value of the secret, the consumers pick up the new value the
+/// next time they use it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Secrets",
+    operation_id = "UpdateSecret",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Secret name"),
+    ),
+    request_body(content = SecretRequest, description = "Secret data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Secret),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/secrets/{name}")]
+pub async fn update_secret(
+    path: web::Path<(String, String)>,
+    body: web::Json<SecretRequest>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let mut req = body.into_inner();
+    req.name = name;
+    crate::service::secrets::save_secret(&org_id, req, false).await
+}
+
+/// ListSecrets
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Secrets",
+    operation_id = "ListSecrets",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SecretList),
+    )
+)]
+#[get("/{org_id}/secrets")]
+pub async fn list_secrets(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::secrets::list_secrets(&org_id.into_inner()).await
+}
+
+/// GetSecret
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Secrets",
+    operation_id = "GetSecret",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Secret name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Secret),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/secrets/{name}")]
+pub async fn get_secret(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::secrets::get_secret(&org_id, &name).await
+}
+
+/// DeleteSecret
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Secrets",
+    operation_id = "DeleteSecret",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Secret name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/secrets/{name}")]
+pub async fn delete_secret(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::secrets::delete_secret(&org_id, &name).await
+}
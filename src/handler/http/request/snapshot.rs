@@ -0,0 +1,103 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{post, web, HttpResponse};
+
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            snapshot::{Snapshot, SnapshotRequest},
+        },
+        utils::auth::UserEmail,
+    },
+    service::{snapshot, users},
+};
+
+/// TakeSnapshot
+///
+/// Returns the state of the organization: its streams with their settings and
+/// a sample of their latest records, its dashboards, alerts and functions.
+/// Only the admins can take a snapshot.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Snapshots",
+    operation_id = "TakeSnapshot",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SnapshotRequest, description = "Sample size and streams", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Snapshot),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/snapshot")]
+pub async fn take(
+    path: web::Path<String>,
+    body: web::Json<SnapshotRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the admins can take a snapshot",
+        ));
+    }
+    snapshot::take(&org_id, &user_email.user_id, body.into_inner()).await
+}
+
+/// RestoreSnapshot
+///
+/// Restores a snapshot into the organization, the samples are ingested again
+/// with their timestamps moved to end now. The objects of the snapshot replace
+/// the ones of the same name. Only the admins can restore a snapshot.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Snapshots",
+    operation_id = "RestoreSnapshot",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = Snapshot, description = "Snapshot", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = RestoreResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/snapshot/restore")]
+pub async fn restore(
+    path: web::Path<String>,
+    body: web::Json<Snapshot>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let org_id = path.into_inner();
+    if !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the admins can restore a snapshot",
+        ));
+    }
+    snapshot::restore(&org_id, &user_email.user_id, body.into_inner()).await
+}
@@ -18,7 +18,7 @@ use std::{io::Error, sync::Arc};
 use actix_web::{
     cookie,
     cookie::{Cookie, SameSite},
-    get,
+    delete, get,
     http::header,
     put, web, HttpRequest, HttpResponse,
 };
@@ -27,7 +27,12 @@ use config::{
     cluster::{is_ingester, LOCAL_NODE_ROLE, LOCAL_NODE_UUID},
     get_config, get_instance_id,
     meta::cluster::NodeStatus,
-    utils::{json, schema_ext::SchemaExt},
+    utils::{
+        faults::{self, Faults},
+        json,
+        schema_ext::SchemaExt,
+        time::{mock_now_micros, parse_milliseconds, parse_str_to_timestamp_micros},
+    },
     Config, QUICK_MODEL_FIELDS, SQL_FULL_TEXT_SEARCH_FIELDS,
 };
 use hashbrown::HashMap;
@@ -65,8 +70,9 @@ use crate::{
         meta::{
             functions::ZoFunction,
             http::HttpResponse as MetaHttpResponse,
-            user::{AuthTokens, AuthTokensExt},
+            user::{AuthTokens, AuthTokensExt, NATIVE_SESSION_PREFIX},
         },
+        utils::auth::{is_root_user, UserEmail},
     },
     service::{
         db,
@@ -111,6 +117,9 @@ struct ConfigResponse<'a> {
     quick_mode_enabled: bool,
     user_defined_schemas_enabled: bool,
     all_fields_name: String,
+    /// Now of the queries pinned by the mock clock, in microseconds
+    #[serde(skip_serializing_if = "Option::is_none")]
+    mock_now: Option<i64>,
 }
 
 #[derive(Serialize)]
@@ -268,6 +277,7 @@ pub async fn zo_config() -> Result<HttpResponse, Error> {
         quick_mode_enabled: cfg.limit.quick_mode_enabled,
         user_defined_schemas_enabled: cfg.common.allow_user_defined_schemas,
         all_fields_name: cfg.common.column_all.to_string(),
+        mock_now: mock_now_micros(),
     }))
 }
 
@@ -636,7 +646,9 @@ async fn logout(req: actix_web::HttpRequest) -> HttpResponse {
         let auth_tokens: AuthTokens = json::from_str(cookie.value()).unwrap_or_default();
         let access_token = auth_tokens.access_token;
 
-        if access_token.starts_with("session") {
+        if let Some(session_id) = access_token.strip_prefix(NATIVE_SESSION_PREFIX) {
+            let _ = crate::service::db::user_login_session::delete(session_id).await;
+        } else if access_token.starts_with("session") {
             crate::service::session::remove_session(access_token.strip_prefix("session ").unwrap())
                 .await;
         }
@@ -697,6 +709,184 @@ async fn flush_node() -> Result<HttpResponse, Error> {
     }
 }
 
+/// Returns the in memory data of every stream on this node, largest first,
+/// to find the streams causing memory pressure on the ingester
+#[get("/memtable")]
+async fn memtable_status() -> Result<HttpResponse, Error> {
+    if !is_ingester(&LOCAL_NODE_ROLE) {
+        return Ok(MetaHttpResponse::not_found("local node is not an ingester"));
+    };
+    Ok(MetaHttpResponse::json(ingester::memory_usage().await))
+}
+
+#[derive(Serialize)]
+struct ClockResponse {
+    /// Now of the queries in microseconds
+    now: i64,
+    mocked: bool,
+}
+
+impl ClockResponse {
+    fn current() -> Self {
+        match mock_now_micros() {
+            Some(now) => Self { now, mocked: true },
+            None => Self {
+                now: config::utils::time::now_micros(),
+                mocked: false,
+            },
+        }
+    }
+}
+
+/// Checks that the mock clock is enabled and the user is the root user
+fn check_mock_clock(user_email: &UserEmail) -> Result<(), HttpResponse> {
+    if !get_config().common.mock_clock_enabled {
+        return Err(MetaHttpResponse::forbidden("the mock clock is disabled"));
+    }
+    if !is_root_user(&user_email.user_id) {
+        return Err(MetaHttpResponse::forbidden(
+            "only the root user can change the clock",
+        ));
+    }
+    Ok(())
+}
+
+/// Returns the now of the queries
+#[get("/clock")]
+async fn clock_status() -> Result<HttpResponse, Error> {
+    Ok(MetaHttpResponse::json(ClockResponse::current()))
+}
+
+/// Pins the now of the queries of every node to the `now` query parameter, a
+/// timestamp or a RFC 3339 date, or moves it forward by `advance`, e.g. `15m`,
+/// so the relative time ranges of the UI tests are deterministic
+#[put("/clock")]
+async fn set_clock(req: HttpRequest, user_email: UserEmail) -> Result<HttpResponse, Error> {
+    if let Err(resp) = check_mock_clock(&user_email) {
+        return Ok(resp);
+    }
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let mut now = match query.get("now") {
+        Some(v) => match parse_str_to_timestamp_micros(v) {
+            Ok(v) => v,
+            Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+        },
+        None => ClockResponse::current().now,
+    };
+    if let Some(v) = query.get("advance") {
+        match parse_milliseconds(v) {
+            Ok(ms) => now += ms as i64 * 1000,
+            Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+        }
+    }
+    if now <= 0 {
+        return Ok(MetaHttpResponse::bad_request("now should be positive"));
+    }
+    if let Err(e) = db::mock_clock::set(now).await {
+        return Ok(MetaHttpResponse::internal_error(e));
+    }
+    log::warn!("[CLOCK] the now of the queries is pinned to {now}");
+    Ok(MetaHttpResponse::json(ClockResponse::current()))
+}
+
+/// Resets the now of the queries of every node to the real time
+#[delete("/clock")]
+async fn reset_clock(user_email: UserEmail) -> Result<HttpResponse, Error> {
+    if let Err(resp) = check_mock_clock(&user_email) {
+        return Ok(resp);
+    }
+    if let Err(e) = db::mock_clock::reset().await {
+        return Ok(MetaHttpResponse::internal_error(e));
+    }
+    Ok(MetaHttpResponse::json(ClockResponse::current()))
+}
+
+fn check_fault_injection(user_email: &UserEmail) -> Result<(), HttpResponse> {
+    if !get_config().common.fault_injection_enabled {
+        return Err(MetaHttpResponse::forbidden(
+            "the fault injection is disabled",
+        ));
+    }
+    if !is_root_user(&user_email.user_id) {
+        return Err(MetaHttpResponse::forbidden(
+            "only the root user can inject faults",
+        ));
+    }
+    Ok(())
+}
+
+/// Returns the faults injected on this node
+#[get("/faults")]
+async fn faults_status(user_email: UserEmail) -> Result<HttpResponse, Error> {
+    if let Err(resp) = check_fault_injection(&user_email) {
+        return Ok(resp);
+    }
+    Ok(MetaHttpResponse::json(faults::get()))
+}
+
+/// Injects faults on this node for the resilience tests: delayed object
+/// storage calls, failed wal writes and dropped RPCs from the other nodes.
+/// The faults are per node, kept in memory until reset or restart, to inject
+/// them on the whole cluster call every node.
+#[put("/faults")]
+async fn set_faults(user_email: UserEmail, body: web::Json<Faults>) -> Result<HttpResponse, Error> {
+    if let Err(resp) = check_fault_injection(&user_email) {
+        return Ok(resp);
+    }
+    let body = body.into_inner();
+    if let Err(e) = body.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    faults::set(body);
+    log::warn!("[FAULTS] injecting {:?}", body);
+    Ok(MetaHttpResponse::json(faults::get()))
+}
+
+/// Stops injecting faults on this node
+#[delete("/faults")]
+async fn reset_faults(user_email: UserEmail) -> Result<HttpResponse, Error> {
+    if let Err(resp) = check_fault_injection(&user_email) {
+        return Ok(resp);
+    }
+    faults::set(Faults::default());
+    Ok(MetaHttpResponse::json(faults::get()))
+}
+
+/// Replays on this node the wal files replicated to the object storage by an
+/// ingester that lost its disk, only the root user can start it
+#[put("/wal/recover/{node_uuid}")]
+async fn recover_wal(
+    user_email: UserEmail,
+    path: web::Path<String>,
+) -> Result<HttpResponse, Error> {
+    if !is_root_user(&user_email.user_id) {
+        return Ok(MetaHttpResponse::forbidden(
+            "only the root user can recover the wal files",
+        ));
+    }
+    let node_uuid = path.into_inner();
+    if !is_ingester(&LOCAL_NODE_ROLE) {
+        return Ok(MetaHttpResponse::not_found("local node is not an ingester"));
+    };
+    if node_uuid == LOCAL_NODE_UUID.as_str() {
+        return Ok(MetaHttpResponse::bad_request(
+            "the wal files of the local node are replayed on start",
+        ));
+    }
+    if let Some(node) = cluster::get_node_by_uuid(&node_uuid).await {
+        if node.status == NodeStatus::Online {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "node {node_uuid} is online, it replays its own wal files"
+            )));
+        }
+    }
+
+    match ingester::recover_wal(&node_uuid).await {
+        Ok(stat) => Ok(MetaHttpResponse::json(stat)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
 #[get("/stream_fields/{org_id}/{stream_type}/{stream_name}")]
 async fn stream_fields(path: web::Path<(String, String, String)>) -> Result<HttpResponse, Error> {
     let (org_id, stream_type, stream_name) = path.into_inner();
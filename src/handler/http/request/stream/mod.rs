@@ -18,7 +18,7 @@ use std::{
     io::{Error, ErrorKind},
 };
 
-use actix_web::{delete, get, http, put, web, HttpRequest, HttpResponse, Responder};
+use actix_web::{delete, get, http, post, put, web, HttpRequest, HttpResponse, Responder};
 use config::meta::stream::{StreamSettings, StreamType};
 
 use crate::{
@@ -26,11 +26,16 @@ use crate::{
         meta::{
             self,
             http::HttpResponse as MetaHttpResponse,
+            lifecycle::LifecycleEstimateRequest,
             stream::{ListStream, StreamDeleteFields},
+            stream_access::ApplyArchivalRequest,
         },
         utils::http::get_stream_type_from_request,
     },
-    service::{format_stream_name, stream},
+    service::{
+        format_stream_name, iceberg, lifecycle, stream, stream_access, stream_partitions,
+        stream_profile,
+    },
 };
 
 /// GetSchema
@@ -313,6 +318,452 @@ async fn list(org_id: web::Path<String>, req: HttpRequest) -> impl Responder {
     Ok(HttpResponse::Ok().json(ListStream { list: indices }))
 }
 
+/// EstimateStreamLifecycle
+///
+/// Projects the storage and the monthly cost of streams under the given
+/// ingest rates, retention, rollup and storage tiers, before changing them.
+/// The settings not given are the ones observed on the streams.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "EstimateStreamLifecycle",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = LifecycleEstimateRequest, description = "Lifecycle settings", content_type = "application/json", example = json!({
+        "months": 12,
+        "tiers": [
+            {"name": "standard", "after_days": 0, "price_per_gb_month": 0.023},
+            {"name": "infrequent", "after_days": 30, "price_per_gb_month": 0.0125}
+        ],
+        "streams": [
+            {"stream_name": "default", "stream_type": "logs", "retention_days": 90, "monthly_growth": 5},
+            {"stream_name": "k8s_metrics", "stream_type": "metrics", "ingest_gb_per_day": 20, "rollup": {"after_days": 7, "size_ratio": 0.1}}
+        ]
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = LifecycleEstimateResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/streams/_lifecycle_estimate")]
+async fn lifecycle_estimate(
+    org_id: web::Path<String>,
+    body: web::Json<LifecycleEstimateRequest>,
+) -> Result<HttpResponse, Error> {
+    match lifecycle::estimate(&org_id, &body).await {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
+
+/// ArchivalSuggestions
+///
+/// Lists the streams with data not searched in the given days, the largest
+/// first. A stream searched before is suggested to keep only the idle days of
+/// data, a stream never searched since it was created is suggested for
+/// deletion.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "StreamArchivalSuggestions",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("days" = Option<i64>, Query, description = "Days without searches, ZO_ARCHIVAL_IDLE_DAYS when not set"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ArchivalSuggestions),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/_archival_suggestions")]
+async fn archival_suggestions(
+    org_id: web::Path<String>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let days = match query.get("days").map(|v| v.parse::<i64>()) {
+        Some(Ok(v)) => Some(v),
+        Some(Err(_)) => return Ok(MetaHttpResponse::bad_request("invalid days")),
+        None => None,
+    };
+    stream_access::suggestions(&org_id, days).await
+}
+
+/// ApplyArchival
+///
+/// Sets the retention of the streams, usually the ones suggested by
+/// ArchivalSuggestions. A stream is only changed when its retention gets
+/// shorter, the compactor deletes the data older than the new retention.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "StreamApplyArchival",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = ApplyArchivalRequest, description = "Streams and retention", content_type = "application/json", example = json!({
+        "streams": [
+            {"stream_name": "old_app", "stream_type": "logs"}
+        ],
+        "retention_days": 30
+    })),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ApplyArchivalResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/streams/_archival_apply")]
+async fn archival_apply(
+    org_id: web::Path<String>,
+    body: web::Json<ApplyArchivalRequest>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    stream_access::apply(&org_id, user_id, body.into_inner()).await
+}
+
+/// ReclusterStream
+///
+/// Rewrites the existing files of the stream so they are sorted by its current
+/// clustering keys. The compactor processes the time range in the background,
+/// starting with the oldest data.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "ReclusterStream",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = String, Query, description = "Stream type"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, the oldest data when not set"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, now when not set"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ReclusterJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/streams/{stream_name}/_recluster")]
+async fn recluster(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    stream::recluster_stream(&org_id, &stream_name, stream_type, start_time, end_time).await
+}
+
+/// StreamProfile
+///
+/// Returns the statistics of each field of the stream over a time window:
+/// the percentage of nulls, the distinct count, the top values, the minimum,
+/// the maximum and the length distribution of the strings. The null counts,
+/// minimums and maximums are read from the parquet metadata of the files, the
+/// rest from a sample of the most recent records of the window.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "StreamProfile",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, 24 hours before the end when not set"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, now when not set"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamProfile),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/profile")]
+async fn profile(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    let user_id = req
+        .headers()
+        .get("user_id")
+        .and_then(|v| v.to_str().ok())
+        .unwrap_or_default();
+    stream_profile::get_stream_profile(
+        &org_id,
+        &stream_name,
+        stream_type,
+        user_id,
+        start_time,
+        end_time,
+    )
+    .await
+}
+
+/// StreamPartitions
+///
+/// Returns the parquet files of the stream grouped by partition, with their
+/// location in the storage, their time range, their sizes and optionally the
+/// statistics of their columns, so engines like Spark, Trino or DuckDB can
+/// read them directly. The column statistics are read from the parquet
+/// footers, up to ZO_PARTITION_STATS_MAX_FILES files.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "StreamPartitions",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+        ("start_time" = Option<i64>, Query, description = "Start time in microseconds, the oldest data when not set"),
+        ("end_time" = Option<i64>, Query, description = "End time in microseconds, now when not set"),
+        ("stats" = Option<bool>, Query, description = "Read the column statistics of the files, false by default"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamPartitions),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/partitions")]
+async fn partitions(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let start_time = query
+        .get("start_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    let end_time = query
+        .get("end_time")
+        .and_then(|v| v.parse::<i64>().ok())
+        .unwrap_or_default();
+    let with_stats = query
+        .get("stats")
+        .and_then(|v| v.parse::<bool>().ok())
+        .unwrap_or_default();
+    stream_partitions::get_partitions(
+        &org_id,
+        &stream_name,
+        stream_type,
+        start_time,
+        end_time,
+        with_stats,
+    )
+    .await
+}
+
+/// GetStreamIcebergTable
+///
+/// Returns the Iceberg table of a stream whose table_format is iceberg: its
+/// location and its current metadata file, to register it in a catalog or
+/// read it directly with Spark, Trino or DuckDB. The compactor commits the
+/// new files every ZO_ICEBERG_COMMIT_INTERVAL seconds.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "StreamIcebergTable",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = Option<String>, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = IcebergTable),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/iceberg")]
+async fn iceberg_table(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    iceberg::get_table(&org_id, &stream_name, stream_type).await
+}
+
+/// DeleteStreamData
+///
+/// Deletes whole days of the stream, eg: everything before 2024-01-01, by
+/// removing its files from the file list instead of rewriting them. The
+/// compactor deletes the data in the background, see ListStreamDeleteJobs.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "DeleteStreamData",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = String, Query, description = "Stream type"),
+        ("start" = Option<String>, Query, description = "First day to delete, eg: 2023-01-02, the oldest data when not set"),
+        ("end" = String, Query, description = "Day to stop before, eg: 2024-01-01, today at most"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamDeleteJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/streams/{stream_name}/data")]
+async fn delete_data(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let Some(end) = query.get("end") else {
+        return Ok(MetaHttpResponse::bad_request("end is required"));
+    };
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    stream::delete_stream_data(
+        &org_id,
+        &stream_name,
+        stream_type,
+        query.get("start").map(|v| v.as_str()),
+        end,
+        user_id,
+    )
+    .await
+}
+
+/// TruncateStream
+///
+/// Deletes all the data of the stream, keeping its schema and settings. The
+/// ingestion and the search of the stream are rejected until the compactor is
+/// done, see ListStreamDeleteJobs.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "TruncateStream",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = String, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamDeleteJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/streams/{stream_name}/_truncate")]
+async fn truncate(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    stream::truncate_stream(&org_id, &stream_name, stream_type, user_id).await
+}
+
+/// ListStreamDeleteJobs
+///
+/// Lists the delete and truncate jobs of the stream not done yet, a job is
+/// removed from the list once the compactor deleted its data.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Streams",
+    operation_id = "ListStreamDeleteJobs",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("type" = String, Query, description = "Stream type"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = ListStreamDeleteJob),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/streams/{stream_name}/delete_jobs")]
+async fn list_delete_jobs(
+    path: web::Path<(String, String)>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, stream_name) = path.into_inner();
+    let query = web::Query::<HashMap<String, String>>::from_query(req.query_string()).unwrap();
+    let stream_type = match get_stream_type_from_request(&query) {
+        Ok(v) => v.unwrap_or(StreamType::Logs),
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    stream::list_delete_jobs(&org_id, &stream_name, stream_type).await
+}
+
 #[delete("/{org_id}/streams/{stream_name}/cache/results")]
 async fn delete_stream_cache(
     path: web::Path<(String, String)>,
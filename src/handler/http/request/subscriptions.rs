@@ -0,0 +1,167 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::subscriptions::StreamSubscription;
+
+/// CreateSubscription
+///
+/// Creates a subscription forwarding the records of a stream matching the
+/// conditions to a webhook, a Kafka topic, another OpenObserve instance, a
+/// ClickHouse table or an Elasticsearch index as they are ingested. Set
+/// `expires_at` to dual-write a stream only for a migration period. The
+/// credentials are never returned by the API. It takes an admin or a user who
+/// can read the stream, and the sink must be a public host or one of
+/// ZO_SUBSCRIPTION_ALLOWED_HOSTS.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Subscriptions",
+    operation_id = "CreateSubscription",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = StreamSubscription, description = "Subscription data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamSubscription),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/subscriptions")]
+pub async fn create_subscription(
+    org_id: web::Path<String>,
+    body: web::Json<StreamSubscription>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::subscriptions::save_subscription(
+        &org_id.into_inner(),
+        user_id,
+        body.into_inner(),
+        true,
+    )
+    .await
+}
+
+/// UpdateSubscription
+///
+/// Updates the subscription, the records ingested from then on are matched
+/// against the new conditions. Sending back the masked credentials keeps the
+/// stored ones.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Subscriptions",
+    operation_id = "UpdateSubscription",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Subscription name"),
+    ),
+    request_body(content = StreamSubscription, description = "Subscription data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamSubscription),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/subscriptions/{name}")]
+pub async fn update_subscription(
+    path: web::Path<(String, String)>,
+    body: web::Json<StreamSubscription>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let user_id = req.headers().get("user_id").unwrap().to_str().unwrap();
+    let mut subscription = body.into_inner();
+    subscription.name = name;
+    crate::service::subscriptions::save_subscription(&org_id, user_id, subscription, false).await
+}
+
+/// ListSubscriptions
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Subscriptions",
+    operation_id = "ListSubscriptions",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamSubscriptionList),
+    )
+)]
+#[get("/{org_id}/subscriptions")]
+pub async fn list_subscriptions(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::subscriptions::list_subscriptions(&org_id.into_inner()).await
+}
+
+/// GetSubscription
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Subscriptions",
+    operation_id = "GetSubscription",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Subscription name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = StreamSubscription),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/subscriptions/{name}")]
+pub async fn get_subscription(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::subscriptions::get_subscription(&org_id, &name).await
+}
+
+/// DeleteSubscription
+///
+/// Deletes the subscription, the records still queued for it are discarded.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Subscriptions",
+    operation_id = "DeleteSubscription",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Subscription name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/subscriptions/{name}")]
+pub async fn delete_subscription(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::subscriptions::delete_subscription(&org_id, &name).await
+}
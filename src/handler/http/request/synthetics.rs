@@ -0,0 +1,313 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::{
+    http::HttpResponse as MetaHttpResponse,
+    synthetics::{CheckResult, SyntheticCheck},
+};
+
+/// CreateSyntheticCheck
+///
+/// Creates an HTTP, TCP, DNS, ICMP or scripted browser check run on a
+/// schedule. The results are written to the synthetics logs stream and as the
+/// `synthetics_success` and `synthetics_response_time_seconds` metrics, the
+/// steps of browser checks to the RUM data stream.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "CreateSyntheticCheck",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SyntheticCheck, description = "Check data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheck),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/synthetics")]
+pub async fn create_check(
+    org_id: web::Path<String>,
+    body: web::Json<SyntheticCheck>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::synthetics::save_check(
+        &org_id.into_inner(),
+        user_email,
+        body.into_inner(),
+        true,
+    )
+    .await
+}
+
+/// UpdateSyntheticCheck
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "UpdateSyntheticCheck",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Check name"),
+    ),
+    request_body(content = SyntheticCheck, description = "Check data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheck),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/synthetics/{name}")]
+pub async fn update_check(
+    path: web::Path<(String, String)>,
+    body: web::Json<SyntheticCheck>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    let mut check = body.into_inner();
+    check.name = name;
+    crate::service::synthetics::save_check(&org_id, user_email, check, false).await
+}
+
+/// UploadSyntheticScript
+///
+/// Replaces the Playwright script of a browser check with the request body.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "UploadSyntheticScript",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Check name"),
+    ),
+    request_body(content = String, description = "Playwright script", content_type = "text/plain"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheck),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/synthetics/{name}/script")]
+pub async fn upload_script(
+    path: web::Path<(String, String)>,
+    body: web::Bytes,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let script = match String::from_utf8(body.to_vec()) {
+        Ok(script) => script,
+        Err(_) => {
+            return Ok(MetaHttpResponse::bad_request("Script should be utf-8 text"));
+        }
+    };
+    crate::service::synthetics::set_script(&org_id, &name, script).await
+}
+
+/// GetSyntheticScreenshot
+///
+/// Returns the png taken at the end of a step of a browser check run, named by
+/// the `synthetics.screenshot` field of the step in the RUM data.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "GetSyntheticScreenshot",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Check name"),
+        ("run_id" = String, Path, description = "Run of the check"),
+        ("step" = usize, Path, description = "Index of the step"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "image/png"),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/synthetics/{name}/screenshots/{run_id}/{step}")]
+pub async fn get_screenshot(
+    path: web::Path<(String, String, String, usize)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name, run_id, step) = path.into_inner();
+    crate::service::synthetics::get_screenshot(&org_id, &name, &run_id, step).await
+}
+
+/// ListSyntheticChecks
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "ListSyntheticChecks",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheckList),
+    )
+)]
+#[get("/{org_id}/synthetics")]
+pub async fn list_checks(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::synthetics::list_checks(&org_id.into_inner()).await
+}
+
+/// GetSyntheticCheck
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "GetSyntheticCheck",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Check name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheck),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/synthetics/{name}")]
+pub async fn get_check(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::synthetics::get_check(&org_id, &name).await
+}
+
+/// DeleteSyntheticCheck
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "DeleteSyntheticCheck",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Check name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/synthetics/{name}")]
+pub async fn delete_check(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::synthetics::delete_check(&org_id, &name).await
+}
+
+/// TestSyntheticCheck
+///
+/// Runs the check once from the node answering the request and returns the
+/// result without saving it.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "TestSyntheticCheck",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = SyntheticCheck, description = "Check data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = CheckResult),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/synthetics/_test")]
+pub async fn test_check(
+    _org_id: web::Path<String>,
+    body: web::Json<SyntheticCheck>,
+) -> Result<HttpResponse, Error> {
+    crate::service::synthetics::test_check(body.into_inner()).await
+}
+
+/// ListLocationChecks
+///
+/// Lists the checks the runner of the location should run.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "ListLocationChecks",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("location" = String, Path, description = "Location of the runner"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = SyntheticCheckList),
+    )
+)]
+#[get("/{org_id}/synthetics/_runner/{location}/checks")]
+pub async fn list_location_checks(
+    path: web::Path<(String, String)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, location) = path.into_inner();
+    crate::service::synthetics::list_location_checks(&org_id, &location).await
+}
+
+/// ReportCheckResults
+///
+/// Saves the results of the checks run by the runner of the location.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Synthetics",
+    operation_id = "ReportCheckResults",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("location" = String, Path, description = "Location of the runner"),
+    ),
+    request_body(content = Vec<CheckResult>, description = "Check results", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/synthetics/_runner/{location}/results")]
+pub async fn report_results(
+    path: web::Path<(String, String)>,
+    body: web::Json<Vec<CheckResult>>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, location) = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    let mut results = body.into_inner();
+    for result in results.iter_mut() {
+        result.location = location.clone();
+    }
+    crate::service::synthetics::report_results(&org_id, user_email, results).await
+}
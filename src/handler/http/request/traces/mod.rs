@@ -24,7 +24,7 @@ use tracing::Instrument;
 use crate::{
     common::{
         meta::{self, http::HttpResponse as MetaHttpResponse},
-        utils::http::get_or_create_trace_id_and_span,
+        utils::http::{get_or_create_trace_id_and_span, is_sampling_overridden},
     },
     handler::http::request::{CONTENT_TYPE_JSON, CONTENT_TYPE_PROTO},
     service::{search as SearchService, traces::otlp_http},
@@ -73,10 +73,11 @@ async fn handle_req(
         .headers()
         .get(&get_config().grpc.stream_header_key)
         .map(|header| header.to_str().unwrap());
+    let skip_sampling = is_sampling_overridden(&req, &org_id).await;
     if content_type.eq(CONTENT_TYPE_PROTO) {
-        otlp_http::traces_proto(&org_id, body, in_stream_name).await
+        otlp_http::traces_proto(&org_id, body, in_stream_name, skip_sampling).await
     } else if content_type.starts_with(CONTENT_TYPE_JSON) {
-        otlp_http::traces_json(&org_id, body, in_stream_name).await
+        otlp_http::traces_json(&org_id, body, in_stream_name, skip_sampling).await
     } else {
         Ok(
             HttpResponse::BadRequest().json(meta::http::HttpResponse::error(
@@ -253,6 +254,7 @@ pub async fn get_latest_traces(
         clusters: vec![],
         timeout,
         search_type: None,
+        search_event_context: None,
     };
     let stream_type = StreamType::Traces;
     let user_id = in_req
@@ -488,6 +490,217 @@ pub async fn get_latest_traces(
     Ok(HttpResponse::Ok().json(resp))
 }
 
+/// GetTraceDetail
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Traces",
+    operation_id = "GetTraceDetail",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("stream_name" = String, Path, description = "Stream name"),
+        ("trace_id" = String, Path, description = "Trace id"),
+        ("start_time" = i64, Query, description = "start time"),
+        ("end_time" = i64, Query, description = "end time"),
+        ("timeout" = Option<i64>, Query, description = "timeout, seconds"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = Object, example = json!({
+            "took": 12,
+            "trace_id": "4f6b2c8e9d1a3b5c7e9f0a1b2c3d4e5f",
+            "spans": [
+                {
+                    "span_id": "1a2b3c4d5e6f7a8b",
+                    "operation_name": "GET /api",
+                    "events": [{"name": "exception", "_timestamp": 1234567890, "exception.type": "IOError"}],
+                    "links": [{"trace_id": "0af7651916cd43dd8448eb211c80319c", "span_id": "b7ad6b7169203331"}]
+                }
+            ]
+        })),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 500, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/{stream_name}/traces/{trace_id}")]
+pub async fn get_trace_detail(
+    path: web::Path<(String, String, String)>,
+    in_req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let start = std::time::Instant::now();
+    let (org_id, stream_name, query_trace_id) = path.into_inner();
+    let (trace_id, http_span) = get_or_create_trace_id_and_span(
+        in_req.headers(),
+        format!("/api/{org_id}/{stream_name}/traces/{query_trace_id}"),
+    );
+
+    if query_trace_id.is_empty() || !query_trace_id.chars().all(|c| c.is_ascii_hexdigit()) {
+        return Ok(MetaHttpResponse::bad_request("invalid trace_id"));
+    }
+
+    let query = web::Query::<HashMap<String, String>>::from_query(in_req.query_string()).unwrap();
+
+    // Check permissions on stream
+
+    #[cfg(feature = "enterprise")]
+    {
+        use crate::common::{
+            infra::config::USERS,
+            utils::auth::{is_root_user, AuthExtractor},
+        };
+        let user_id = in_req.headers().get("user_id").unwrap();
+        if !is_root_user(user_id.to_str().unwrap()) {
+            let user: meta::user::User = USERS
+                .get(&format!("{org_id}/{}", user_id.to_str().unwrap()))
+                .unwrap()
+                .clone();
+
+            if user.is_external
+                && !crate::handler::http::auth::validator::check_permissions(
+                    user_id.to_str().unwrap(),
+                    AuthExtractor {
+                        auth: "".to_string(),
+                        method: "GET".to_string(),
+                        o2_type: format!("{}:{}", StreamType::Traces, stream_name),
+                        org_id: org_id.clone(),
+                        bypass_check: false,
+                        parent_id: "".to_string(),
+                    },
+                    Some(user.role),
+                )
+                .await
+            {
+                return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+            }
+        }
+        // Check permissions on stream ends
+    }
+
+    let start_time = query
+        .get("start_time")
+        .map_or(0, |v| v.parse::<i64>().unwrap_or(0));
+    if start_time == 0 {
+        return Ok(MetaHttpResponse::bad_request("start_time is empty"));
+    }
+    let end_time = query
+        .get("end_time")
+        .map_or(0, |v| v.parse::<i64>().unwrap_or(0));
+    if end_time == 0 {
+        return Ok(MetaHttpResponse::bad_request("end_time is empty"));
+    }
+    let timeout = query
+        .get("timeout")
+        .map_or(0, |v| v.parse::<i64>().unwrap_or(0));
+
+    let cfg = get_config();
+    let mut req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql: format!(
+                "SELECT * FROM {stream_name} WHERE trace_id = '{query_trace_id}' ORDER BY start_time ASC"
+            ),
+            from: 0,
+            size: 9999,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout,
+        search_type: None,
+        search_event_context: None,
+    };
+    let stream_type = StreamType::Traces;
+    let user_id = in_req
+        .headers()
+        .get("user_id")
+        .unwrap()
+        .to_str()
+        .ok()
+        .map(|v| v.to_string());
+
+    let mut spans = Vec::new();
+    loop {
+        let search_fut =
+            SearchService::search(&trace_id, &org_id, stream_type, user_id.clone(), &req);
+        let search_res = if !cfg.common.tracing_enabled && cfg.common.tracing_search_enabled {
+            search_fut.instrument(http_span.clone().unwrap()).await
+        } else {
+            search_fut.await
+        };
+        let resp_search = match search_res {
+            Ok(res) => res,
+            Err(err) => {
+                log::error!("get trace detail error: {:?}", err);
+                return Ok(match err {
+                    errors::Error::ErrorCode(code) => match code {
+                        errors::ErrorCodes::SearchCancelQuery(_) => HttpResponse::TooManyRequests()
+                            .json(meta::http::HttpResponse::error_code(code)),
+                        _ => HttpResponse::InternalServerError()
+                            .json(meta::http::HttpResponse::error_code(code)),
+                    },
+                    _ => HttpResponse::InternalServerError().json(meta::http::HttpResponse::error(
+                        http::StatusCode::INTERNAL_SERVER_ERROR.into(),
+                        err.to_string(),
+                    )),
+                });
+            }
+        };
+        let resp_size = resp_search.hits.len() as i64;
+        spans.extend(resp_search.hits.into_iter().map(expand_span_nested));
+        if resp_size < req.query.size {
+            break;
+        }
+        req.query.from += req.query.size;
+    }
+
+    let time = start.elapsed().as_secs_f64();
+    metrics::HTTP_RESPONSE_TIME
+        .with_label_values(&[
+            "/api/org/traces/detail",
+            "200",
+            &org_id,
+            &stream_name,
+            stream_type.to_string().as_str(),
+        ])
+        .observe(time);
+    metrics::HTTP_INCOMING_REQUESTS
+        .with_label_values(&[
+            "/api/org/traces/detail",
+            "200",
+            &org_id,
+            &stream_name,
+            stream_type.to_string().as_str(),
+        ])
+        .inc();
+
+    let mut resp: HashMap<&str, json::Value> = HashMap::new();
+    resp.insert("took", json::Value::from((time * 1000.0) as usize));
+    resp.insert("trace_id", json::Value::from(query_trace_id));
+    resp.insert("spans", json::Value::Array(spans));
+    Ok(HttpResponse::Ok().json(resp))
+}
+
+/// Span events and links are stored as json strings, return them as arrays
+/// so clients don't have to parse them again
+fn expand_span_nested(mut span: json::Value) -> json::Value {
+    if let Some(obj) = span.as_object_mut() {
+        for field in ["events", "links"] {
+            let Some(json::Value::String(v)) = obj.get(field) else {
+                continue;
+            };
+            if let Ok(v @ json::Value::Array(_)) = json::from_str::<json::Value>(v) {
+                obj.insert(field.to_string(), v);
+            }
+        }
+    }
+    span
+}
+
 #[derive(Debug, Serialize)]
 struct TraceResponseItem {
     trace_id: String,
@@ -36,13 +36,14 @@ use crate::{
         meta::{
             self,
             user::{
-                AuthTokens, RolesResponse, SignInResponse, SignInUser, UpdateUser, UserOrgRole,
-                UserRequest, UserRole,
+                AuthTokens, MfaVerifyRequest, RolesResponse, SignInResponse, SignInUser,
+                UpdateUser, UserOrgRole, UserRequest, UserRole, UserSessionList, MFA_REQUIRED,
+                NATIVE_SESSION_PREFIX,
             },
         },
         utils::auth::{generate_presigned_url, UserEmail},
     },
-    service::users,
+    service::{login_attempts, mfa, user_sessions, users},
 };
 
 /// ListUsers
@@ -230,6 +231,220 @@ pub async fn delete(
     users::remove_user_from_org(&org_id, &email_id, &initiator_id).await
 }
 
+/// ListUserSessions
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserSessionList",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = UserSessionList),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/users/{email_id}/sessions")]
+pub async fn list_sessions(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, email_id) = path.into_inner();
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    Ok(HttpResponse::Ok().json(UserSessionList {
+        data: user_sessions::list(&email_id),
+    }))
+}
+
+/// RevokeUserSessions
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserSessionRevokeAll",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/users/{email_id}/sessions")]
+pub async fn revoke_sessions(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, email_id) = path.into_inner();
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    match user_sessions::revoke_all(&email_id).await {
+        Ok(n) => Ok(meta::http::HttpResponse::ok(format!(
+            "{n} sessions revoked"
+        ))),
+        Err(e) => Ok(meta::http::HttpResponse::internal_error(e)),
+    }
+}
+
+/// RevokeUserSession
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserSessionRevoke",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+        ("session_id" = String, Path, description = "Session id"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/users/{email_id}/sessions/{session_id}")]
+pub async fn revoke_session(
+    path: web::Path<(String, String, String)>,
+) -> Result<HttpResponse, Error> {
+    let (org_id, email_id, session_id) = path.into_inner();
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    match user_sessions::revoke(&email_id, &session_id).await {
+        Ok(true) => Ok(meta::http::HttpResponse::ok("Session revoked")),
+        Ok(false) => Ok(meta::http::HttpResponse::not_found("Session not found")),
+        Err(e) => Ok(meta::http::HttpResponse::internal_error(e)),
+    }
+}
+
+/// EnrollUserMfa
+///
+/// Generates a new TOTP secret for the user. MFA is only enforced at login once
+/// the enrollment is activated with a valid code.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserMfaEnroll",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = MfaEnrollResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/users/{email_id}/mfa")]
+pub async fn enroll_mfa(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, email_id) = path.into_inner();
+    // the secret is only ever shown to the user enrolling
+    if !user_email.user_id.eq(&email_id) {
+        return Ok(meta::http::HttpResponse::forbidden(
+            "Users can only enroll themselves",
+        ));
+    }
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    match mfa::enroll(&email_id).await {
+        Ok(resp) => Ok(HttpResponse::Ok().json(resp)),
+        Err(e) => Ok(meta::http::HttpResponse::bad_request(e)),
+    }
+}
+
+/// ActivateUserMfa
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserMfaActivate",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+      ),
+    request_body(content = MfaVerifyRequest, description = "Code from the authenticator app", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/users/{email_id}/mfa")]
+pub async fn activate_mfa(
+    path: web::Path<(String, String)>,
+    body: web::Json<MfaVerifyRequest>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, email_id) = path.into_inner();
+    if !user_email.user_id.eq(&email_id) {
+        return Ok(meta::http::HttpResponse::forbidden(
+            "Users can only enroll themselves",
+        ));
+    }
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    match mfa::activate(&email_id, &body.code).await {
+        Ok(true) => Ok(meta::http::HttpResponse::ok("MFA enabled")),
+        Ok(false) => Ok(meta::http::HttpResponse::bad_request("Invalid code")),
+        Err(e) => Ok(meta::http::HttpResponse::bad_request(e)),
+    }
+}
+
+/// DisableUserMfa
+///
+/// Users can disable their own MFA, and admins the MFA of a user who lost
+/// access to their authenticator app.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Users",
+    operation_id = "UserMfaDisable",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("email_id" = String, Path, description = "User name"),
+      ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 403, description = "Forbidden", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/users/{email_id}/mfa")]
+pub async fn disable_mfa(
+    path: web::Path<(String, String)>,
+    user_email: UserEmail,
+) -> Result<HttpResponse, Error> {
+    let (org_id, email_id) = path.into_inner();
+    if !user_email.user_id.eq(&email_id) && !users::is_admin(&org_id, &user_email.user_id).await {
+        return Ok(meta::http::HttpResponse::forbidden(
+            "Only admins can disable the MFA of another user",
+        ));
+    }
+    if users::get_user(Some(&org_id), &email_id).await.is_none() {
+        return Ok(meta::http::HttpResponse::not_found("User not found"));
+    }
+    match mfa::disable(&email_id).await {
+        Ok(()) => Ok(meta::http::HttpResponse::ok("MFA disabled")),
+        Err(e) => Ok(meta::http::HttpResponse::internal_error(e)),
+    }
+}
+
 /// AuthenticateUser
 #[utoipa::path(
     context_path = "/auth",
@@ -284,7 +499,11 @@ pub async fn authentication(
                             auth_header,
                         )
                     {
-                        SignInUser { name, password }
+                        SignInUser {
+                            name,
+                            password,
+                            totp: None,
+                        }
                     } else {
                         audit_unauthorized_error(audit_message).await;
                         return unauthorized_error(resp);
@@ -306,29 +525,71 @@ pub async fn authentication(
         audit_message.user_email = auth.name.clone();
     }
 
+    let now = chrono::Utc::now().timestamp();
+    if let Some(wait) = login_attempts::locked_for(&auth.name, now) {
+        #[cfg(feature = "enterprise")]
+        audit_unauthorized_error(audit_message).await;
+        resp.message = format!("Too many failed logins, retry in {wait} seconds");
+        return Ok(HttpResponse::TooManyRequests().json(resp));
+    }
+
     match crate::handler::http::auth::validator::validate_user(&auth.name, &auth.password).await {
         Ok(v) => {
             if v.is_valid {
                 resp.status = true;
             } else {
+                login_attempts::record_failure(&auth.name, now);
                 #[cfg(feature = "enterprise")]
                 audit_unauthorized_error(audit_message).await;
                 return unauthorized_error(resp);
             }
         }
         Err(_e) => {
+            login_attempts::record_failure(&auth.name, now);
             #[cfg(feature = "enterprise")]
             audit_unauthorized_error(audit_message).await;
             return unauthorized_error(resp);
         }
     };
+    match crate::service::mfa::verify(&auth.name, auth.totp.as_deref()).await {
+        Ok(true) => login_attempts::record_success(&auth.name),
+        Ok(false) => {
+            // asking for the code is not a failure, a wrong code is
+            if auth.totp.is_some() {
+                login_attempts::record_failure(&auth.name, now);
+            }
+            #[cfg(feature = "enterprise")]
+            audit_unauthorized_error(audit_message).await;
+            resp.status = false;
+            resp.message = MFA_REQUIRED.to_string();
+            return Ok(HttpResponse::Unauthorized().json(resp));
+        }
+        Err(e) => {
+            log::error!("Error verifying MFA code for {}: {}", auth.name, e);
+            return Ok(HttpResponse::InternalServerError().json(resp));
+        }
+    }
     if resp.status {
         let cfg = get_config();
 
-        let access_token = format!(
-            "Basic {}",
-            base64::encode(&format!("{}:{}", auth.name, auth.password))
-        );
+        let ip = _req
+            .connection_info()
+            .realip_remote_addr()
+            .unwrap_or_default()
+            .to_string();
+        let user_agent = _req
+            .headers()
+            .get(http::header::USER_AGENT)
+            .and_then(|v| v.to_str().ok())
+            .unwrap_or_default();
+        let session = match user_sessions::create(&auth.name, &ip, user_agent).await {
+            Ok(session) => session,
+            Err(e) => {
+                log::error!("Error creating login session for {}: {}", auth.name, e);
+                return Ok(HttpResponse::InternalServerError().json(resp));
+            }
+        };
+        let access_token = format!("{NATIVE_SESSION_PREFIX}{}", session.id);
         let tokens = json::to_string(&AuthTokens {
             access_token,
             refresh_token: "".to_string(),
@@ -338,7 +599,7 @@ pub async fn authentication(
         let mut auth_cookie = cookie::Cookie::new("auth_tokens", tokens);
         auth_cookie.set_expires(
             cookie::time::OffsetDateTime::now_utc()
-                + cookie::time::Duration::seconds(cfg.auth.cookie_max_age),
+                + cookie::time::Duration::seconds(cfg.auth.session_lifetime),
         );
         auth_cookie.set_http_only(true);
         auth_cookie.set_secure(cfg.auth.cookie_secure_only);
@@ -602,7 +863,7 @@ pub async fn get_auth(_req: HttpRequest) -> Result<HttpResponse, Error> {
     }
 }
 
-/// ListUsers
+/// ListUserRoles
 #[utoipa::path(
     context_path = "/api",
     tag = "Users",
@@ -614,7 +875,7 @@ pub async fn get_auth(_req: HttpRequest) -> Result<HttpResponse, Error> {
         ("org_id" = String, Path, description = "Organization name"),
       ),
     responses(
-        (status = 200, description = "Success", content_type = "application/json", body = UserList),
+        (status = 200, description = "Success", content_type = "application/json", body = Vec<RolesResponse>),
     )
 )]
 #[get("/{org_id}/users/roles")]
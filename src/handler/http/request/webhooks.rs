@@ -0,0 +1,164 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::{delete, get, post, put, web, HttpRequest, HttpResponse};
+
+use crate::common::meta::webhook::WebhookSource;
+
+/// CreateWebhook
+///
+/// Creates an endpoint receiving the events of GitHub or GitLab webhooks at
+/// `/webhooks/{org_id}/{name}`. The deliveries are authenticated with the
+/// secret of the webhook instead of the OpenObserve credentials.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Webhooks",
+    operation_id = "CreateWebhook",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    request_body(content = WebhookSource, description = "Webhook data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = WebhookSource),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[post("/{org_id}/webhooks")]
+pub async fn create_webhook(
+    org_id: web::Path<String>,
+    body: web::Json<WebhookSource>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    crate::service::webhooks::save_source(&org_id.into_inner(), user_email, body.into_inner(), true)
+        .await
+}
+
+/// UpdateWebhook
+///
+/// Updates the webhook, sending back the masked secret keeps the stored one.
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Webhooks",
+    operation_id = "UpdateWebhook",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Webhook name"),
+    ),
+    request_body(content = WebhookSource, description = "Webhook data", content_type = "application/json"),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = WebhookSource),
+        (status = 400, description = "Failure", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[put("/{org_id}/webhooks/{name}")]
+pub async fn update_webhook(
+    path: web::Path<(String, String)>,
+    body: web::Json<WebhookSource>,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    let user_email = req.headers().get("user_id").unwrap().to_str().unwrap();
+    let mut source = body.into_inner();
+    source.name = name;
+    crate::service::webhooks::save_source(&org_id, user_email, source, false).await
+}
+
+/// ListWebhooks
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Webhooks",
+    operation_id = "ListWebhooks",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = WebhookSourceList),
+    )
+)]
+#[get("/{org_id}/webhooks")]
+pub async fn list_webhooks(org_id: web::Path<String>) -> Result<HttpResponse, Error> {
+    crate::service::webhooks::list_sources(&org_id.into_inner()).await
+}
+
+/// GetWebhook
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Webhooks",
+    operation_id = "GetWebhook",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Webhook name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = WebhookSource),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[get("/{org_id}/webhooks/{name}")]
+pub async fn get_webhook(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::webhooks::get_source(&org_id, &name).await
+}
+
+/// DeleteWebhook
+#[utoipa::path(
+    context_path = "/api",
+    tag = "Webhooks",
+    operation_id = "DeleteWebhook",
+    security(
+        ("Authorization"= [])
+    ),
+    params(
+        ("org_id" = String, Path, description = "Organization name"),
+        ("name" = String, Path, description = "Webhook name"),
+    ),
+    responses(
+        (status = 200, description = "Success", content_type = "application/json", body = HttpResponse),
+        (status = 404, description = "NotFound", content_type = "application/json", body = HttpResponse),
+    )
+)]
+#[delete("/{org_id}/webhooks/{name}")]
+pub async fn delete_webhook(path: web::Path<(String, String)>) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::webhooks::delete_source(&org_id, &name).await
+}
+
+/// Receives the deliveries of the webhook, the signature is validated by the
+/// service
+#[post("/{org_id}/{name}")]
+pub async fn receive_event(
+    path: web::Path<(String, String)>,
+    body: web::Bytes,
+    req: HttpRequest,
+) -> Result<HttpResponse, Error> {
+    let (org_id, name) = path.into_inner();
+    crate::service::webhooks::handle_event(&org_id, &name, req.headers(), body).await
+}
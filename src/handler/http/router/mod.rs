@@ -20,7 +20,10 @@ use actix_http::header::HeaderName;
 use actix_web::{
     body::MessageBody,
     dev::{Service, ServiceRequest, ServiceResponse},
-    http::header,
+    http::{
+        header::{self, HeaderName, HeaderValue},
+        StatusCode,
+    },
     middleware, web, HttpRequest, HttpResponse,
 };
 use actix_web_httpauth::middleware::HttpAuthentication;
@@ -43,7 +46,16 @@ use super::{
     auth::validator::{validator_aws, validator_gcp, validator_proxy_url, validator_rum},
     request::*,
 };
-use crate::common::meta::{middleware_data::RumExtraData, proxy::PathParamProxyURL};
+use crate::{
+    common::{
+        meta::{
+            http::HttpResponse as MetaHttpResponse, middleware_data::RumExtraData,
+            organization::NetworkScope, proxy::PathParamProxyURL,
+        },
+        utils::trace_context::{self, TraceContext},
+    },
+    service::{ingestion, network_policy, residency},
+};
 
 pub mod openapi;
 pub mod ui;
@@ -140,6 +152,159 @@ async fn audit_middleware(
     next.call(req).await
 }
 
+/// Rejects requests from addresses outside the network policy of the org in
+/// the path. Runs before authentication so blocked networks can not probe
+/// credentials.
+async fn network_policy_middleware(
+    req: ServiceRequest,
+    next: Next<impl MessageBody>,
+) -> Result<ServiceResponse<impl MessageBody>, actix_web::Error> {
+    let rejected = {
+        let base_uri = get_config().common.base_uri.clone();
+        let path = req.path().strip_prefix(&base_uri).unwrap_or(req.path());
+        match network_policy::request_scope(req.method(), path) {
+            Some((org_id, scope)) => {
+                let client_ip = network_policy::client_ip(req.peer_addr(), req.headers());
+                if network_policy::is_allowed(org_id, scope, client_ip) {
+                    None
+                } else {
+                    let client_addr = client_ip.map(|ip| ip.to_string()).unwrap_or_default();
+                    Some((org_id.to_string(), scope, client_addr))
+                }
+            }
+            None => None,
+        }
+    };
+    let Some((org_id, scope, client_addr)) = rejected else {
+        return next.call(req).await;
+    };
+
+    log::warn!(
+        "[NETWORK_POLICY] rejected {} {} from {client_addr}: not in the {scope} allowlist of org {org_id}",
+        req.method(),
+        req.path()
+    );
+    #[cfg(feature = "enterprise")]
+    audit(AuditMessage {
+        user_email: "".to_string(),
+        org_id,
+        method: req.method().to_string(),
+        path: req.path().to_string(),
+        body: format!("network policy: {scope} allowlist does not include {client_addr}"),
+        query_params: req.query_string().to_string(),
+        response_code: 403,
+        _timestamp: chrono::Utc::now().timestamp_micros(),
+    })
+    .await;
+    Err(actix_web::error::ErrorForbidden(
+        "Access from this network is not allowed",
+    ))
+}
+
+/// Rejects ingestion and queries of orgs pinned to another region, so their
+/// data is never written to or read from this cluster. Runs after
+/// authentication so anonymous callers can not probe the residency of orgs.
+async fn residency_middleware(
+    req: ServiceRequest,
+    next: Next<impl MessageBody>,
+) -> Result<ServiceResponse<impl MessageBody>, actix_web::Error> {
+    let rejected = {
+        let base_uri = get_config().common.base_uri.clone();
+        let path = req.path().strip_prefix(&base_uri).unwrap_or(req.path());
+        match network_policy::request_scope(req.method(), path) {
+            Some((_, NetworkScope::Ui)) | None => None,
+            Some((org_id, _)) => residency::check_local(org_id).err(),
+        }
+    };
+    let Some(reason) = rejected else {
+        return next.call(req).await;
+    };
+
+    log::warn!(
+        "[RESIDENCY] rejected {} {}: {reason}",
+        req.method(),
+        req.path()
+    );
+    Err(actix_web::error::ErrorForbidden(reason))
+}
+
+/// Rejects the ingestion requests larger than the current payload limit and
+/// advertises the current limits in the response headers, so the agents can
+/// adapt the size of their batches.
+async fn ingest_limits_middleware(
+    req: ServiceRequest,
+    next: Next<impl MessageBody>,
+) -> Result<ServiceResponse<impl MessageBody>, actix_web::Error> {
+    let is_ingest = {
+        let base_uri = get_config().common.base_uri.clone();
+        let path = req.path().strip_prefix(&base_uri).unwrap_or(req.path());
+        // skip the scope segment, i.e. `api`
+        let path_columns = path
+            .trim_matches('/')
+            .split('/')
+            .skip(1)
+            .collect::<Vec<_>>();
+        network_policy::endpoint_scope(req.method(), &path_columns) == NetworkScope::Ingest
+    };
+    if !is_ingest {
+        return next
+            .call(req)
+            .await
+            .map(ServiceResponse::map_into_left_body);
+    }
+
+    let limits = ingestion::limits::current();
+    let content_length = req
+        .headers()
+        .get(header::CONTENT_LENGTH)
+        .and_then(|v| v.to_str().ok())
+        .and_then(|v| v.parse::<usize>().ok())
+        .unwrap_or_default();
+    let mut res = if content_length > limits.max_bytes {
+        log::warn!(
+            "[INGEST_LIMITS] rejected {} {}: payload of {content_length} bytes over the current limit of {} bytes",
+            req.method(),
+            req.path()
+        );
+        let resp = HttpResponse::PayloadTooLarge().json(MetaHttpResponse::error(
+            StatusCode::PAYLOAD_TOO_LARGE.into(),
+            format!(
+                "payload too large: {content_length} bytes, the current limit is {} bytes",
+                limits.max_bytes
+            ),
+        ));
+        req.into_response(resp).map_into_right_body()
+    } else {
+        next.call(req).await?.map_into_left_body()
+    };
+    let headers = res.headers_mut();
+    headers.insert(
+        HeaderName::from_static(ingestion::limits::MAX_BYTES_HEADER),
+        HeaderValue::from(limits.max_bytes),
+    );
+    if limits.max_records > 0 {
+        headers.insert(
+            HeaderName::from_static(ingestion::limits::MAX_RECORDS_HEADER),
+            HeaderValue::from(limits.max_records),
+        );
+    }
+    headers.insert(
+        HeaderName::from_static(ingestion::limits::MEMORY_PRESSURE_HEADER),
+        HeaderValue::from(limits.memory_pressure),
+    );
+    Ok(res)
+}
+
+/// Makes the caller's `traceparent` available to the handler, so usage
+/// reports written while serving the request carry the caller's trace id.
+async fn trace_context_middleware(
+    req: ServiceRequest,
+    next: Next<impl MessageBody>,
+) -> Result<ServiceResponse<impl MessageBody>, actix_web::Error> {
+    let trace_ctx = TraceContext::from_http_headers(req.headers());
+    trace_context::scope(trace_ctx, next.call(req)).await
+}
+
 /// This is a very trivial proxy to overcome the cors errors while
 /// session-replay in rrweb.
 pub fn get_proxy_routes(cfg: &mut web::ServiceConfig) {
@@ -211,6 +376,14 @@ pub fn get_basic_routes(cfg: &mut web::ServiceConfig) {
             .service(status::cache_status)
             .service(status::enable_node)
             .service(status::flush_node)
+            .service(status::recover_wal)
+            .service(status::memtable_status)
+            .service(status::clock_status)
+            .service(status::set_clock)
+            .service(status::reset_clock)
+            .service(status::faults_status)
+            .service(status::set_faults)
+            .service(status::reset_faults)
             .service(status::stream_fields),
     );
 
@@ -309,10 +482,14 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
 
     cfg.service(
         web::scope("/api")
+            .wrap(from_fn(ingest_limits_middleware))
+            .wrap(from_fn(trace_context_middleware))
             .wrap(from_fn(audit_middleware))
+            .wrap(from_fn(residency_middleware))
             .wrap(HttpAuthentication::with_fn(
                 super::auth::validator::oo_validator,
             ))
+            .wrap(from_fn(network_policy_middleware))
             .wrap(cors.clone())
             .wrap(middleware::DefaultHeaders::new().add(("X-Api-Node", server)))
             .service(users::list)
@@ -320,8 +497,20 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(users::delete)
             .service(users::update)
             .service(users::add_user_to_org)
+            .service(users::list_sessions)
+            .service(users::revoke_sessions)
+            .service(users::revoke_session)
+            .service(users::enroll_mfa)
+            .service(users::activate_mfa)
+            .service(users::disable_mfa)
             .service(organization::org::organizations)
             .service(organization::settings::get)
+            .service(organization::network_policy::get)
+            .service(organization::network_policy::set)
+            .service(organization::network_policy::delete)
+            .service(organization::residency::get)
+            .service(organization::residency::set)
+            .service(organization::residency::delete)
             .service(organization::settings::create)
             .service(organization::settings::upload_logo)
             .service(organization::settings::delete_logo)
@@ -345,16 +534,39 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(stream::delete_fields)
             .service(stream::delete)
             .service(stream::list)
+            .service(stream::lifecycle_estimate)
+            .service(stream::archival_suggestions)
+            .service(stream::archival_apply)
+            .service(stream::recluster)
+            .service(stream::profile)
+            .service(stream::partitions)
+            .service(stream::iceberg_table)
+            .service(stream::delete_data)
+            .service(stream::truncate)
+            .service(stream::list_delete_jobs)
             .service(logs::ingest::bulk)
             .service(logs::ingest::multi)
             .service(logs::ingest::json)
+            .service(logs::ingest::import_parquet)
+            .service(logs::ingest::upload_parquet)
             .service(logs::ingest::otlp_logs_write)
             .service(traces::traces_write)
             .service(traces::otlp_traces_write)
             .service(traces::get_latest_traces)
+            .service(traces::get_trace_detail)
             .service(metrics::ingest::json)
+            .service(metrics::cardinality::get_top_streams)
+            .service(metrics::cardinality::get_cardinality)
+            .service(metrics::cardinality::get_label_values)
             .service(metrics::ingest::otlp_metrics_write)
             .service(prom::remote_write)
+            .service(prom::remote_read)
+            .service(prom::scrape::create_config)
+            .service(prom::scrape::update_config)
+            .service(prom::scrape::list_configs)
+            .service(prom::scrape::get_config)
+            .service(prom::scrape::delete_config)
+            .service(prom::scrape::list_targets)
             .service(prom::query_get)
             .service(prom::query_post)
             .service(prom::query_range_get)
@@ -369,6 +581,14 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(prom::format_query_post)
             .service(enrichment_table::save_enrichment_table)
             .service(search::search)
+            .service(search::search_stream)
+            .service(search::analyze::analyze)
+            .service(search::nl_query::generate)
+            .service(search::log_summary::summarize)
+            .service(search::table::search_table)
+            .service(search::federated::search_federated)
+            .service(search::federated::search_federated_dashboard)
+            .service(search::federated::list_federated_orgs)
             .service(search::job::cancel_multiple_query)
             .service(search::job::cancel_query)
             .service(search::job::query_status)
@@ -384,20 +604,33 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(functions::list_functions)
             .service(functions::delete_function)
             .service(functions::update_function)
+            .service(functions::test_function)
+            .service(functions::list_function_versions)
+            .service(functions::rollback_function)
+            .service(functions::promote_function)
             .service(functions::add_function_to_stream)
             .service(functions::list_stream_functions)
             .service(functions::delete_stream_function)
+            .service(dashboards::library::create_library_panel)
+            .service(dashboards::library::update_library_panel)
+            .service(dashboards::library::list_library_panels)
+            .service(dashboards::library::get_library_panel)
+            .service(dashboards::library::get_library_panel_usage)
+            .service(dashboards::library::delete_library_panel)
             .service(dashboards::create_dashboard)
             .service(dashboards::update_dashboard)
             .service(dashboards::list_dashboards)
             .service(dashboards::get_dashboard)
             .service(dashboards::delete_dashboard)
             .service(dashboards::move_dashboard)
+            .service(dashboards::import_grafana_dashboards)
             .service(dashboards::folders::create_folder)
             .service(dashboards::folders::list_folders)
             .service(dashboards::folders::update_folder)
             .service(dashboards::folders::get_folder)
             .service(dashboards::folders::delete_folder)
+            .service(dashboards::folders::move_folder)
+            .service(dashboards::folders::set_folder_permissions)
             .service(dashboards::reports::create_report)
             .service(dashboards::reports::update_report)
             .service(dashboards::reports::get_report)
@@ -409,6 +642,10 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(alerts::update_alert)
             .service(alerts::get_alert)
             .service(alerts::list_alerts)
+            .service(alerts::list_firing_alerts)
+            .service(alerts::list_alert_history)
+            .service(alerts::get_alert_history_stats)
+            .service(alerts::list_scheduled_triggers)
             .service(alerts::list_stream_alerts)
             .service(alerts::delete_alert)
             .service(alerts::enable_alert)
@@ -443,6 +680,7 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(dashboards::folders::delete_folder)
             .service(dashboards::move_dashboard)
             .service(traces::get_latest_traces)
+            .service(traces::get_trace_detail)
             .service(logs::ingest::multi)
             .service(logs::ingest::json)
             .service(logs::ingest::handle_kinesis_request)
@@ -467,6 +705,97 @@ pub fn get_service_routes(cfg: &mut web::ServiceConfig) {
             .service(pipelines::delete_pipeline)
             .service(pipelines::update_pipeline)
             .service(pipelines::update_pipeline)
+            .service(pipelines::list_pipeline_versions)
+            .service(pipelines::rollback_pipeline)
+            .service(schema_contracts::save_contract)
+            .service(schema_contracts::get_contract)
+            .service(schema_contracts::delete_contract)
+            .service(schema_contracts::get_report)
+            .service(rehydration::create_job)
+            .service(rehydration::list_jobs)
+            .service(rehydration::get_job)
+            .service(rehydration::delete_job)
+            .service(archive_search::create_job)
+            .service(archive_search::list_jobs)
+            .service(archive_search::get_job)
+            .service(archive_search::delete_job)
+            .service(search_jobs::create_job)
+            .service(search_jobs::list_jobs)
+            .service(search_jobs::get_job)
+            .service(search_jobs::delete_job)
+            .service(etl::create_job)
+            .service(etl::update_job)
+            .service(etl::list_jobs)
+            .service(etl::get_job)
+            .service(etl::delete_job)
+            .service(log_pullers::create_puller)
+            .service(log_pullers::update_puller)
+            .service(log_pullers::list_pullers)
+            .service(log_pullers::get_puller)
+            .service(log_pullers::get_status)
+            .service(log_pullers::delete_puller)
+            .service(secrets::create_secret)
+            .service(secrets::update_secret)
+            .service(secrets::list_secrets)
+            .service(secrets::get_secret)
+            .service(secrets::delete_secret)
+            .service(ingest_keys::create_key)
+            .service(ingest_keys::list_keys)
+            .service(ingest_keys::delete_key)
+            .service(plugins::register_plugin)
+            .service(plugins::update_plugin)
+            .service(plugins::list_plugins)
+            .service(plugins::get_plugin)
+            .service(plugins::enable_plugin)
+            .service(plugins::delete_plugin)
+            .service(subscriptions::create_subscription)
+            .service(subscriptions::update_subscription)
+            .service(subscriptions::list_subscriptions)
+            .service(subscriptions::get_subscription)
+            .service(subscriptions::delete_subscription)
+            .service(saved_queries::create_query)
+            .service(saved_queries::update_query)
+            .service(saved_queries::list_queries)
+            .service(saved_queries::get_query)
+            .service(saved_queries::run_query)
+            .service(saved_queries::delete_query)
+            .service(comments::create_comment)
+            .service(comments::list_comments)
+            .service(comments::update_comment)
+            .service(comments::delete_comment)
+            .service(governance::stale_report)
+            .service(governance::list_governance)
+            .service(governance::set_governance)
+            .service(governance::get_governance)
+            .service(governance::delete_governance)
+            .service(bulk::apply)
+            .service(bulk::export)
+            .service(bulk::import)
+            .service(demo::seed)
+            .service(snapshot::take)
+            .service(snapshot::restore)
+            .service(conformance::run)
+            .service(query_history::list_history)
+            .service(webhooks::create_webhook)
+            .service(webhooks::update_webhook)
+            .service(webhooks::list_webhooks)
+            .service(webhooks::get_webhook)
+            .service(webhooks::delete_webhook)
+            .service(mcp::create_token)
+            .service(mcp::list_tokens)
+            .service(mcp::delete_token)
+            .service(synthetics::test_check)
+            .service(synthetics::list_location_checks)
+            .service(synthetics::report_results)
+            .service(synthetics::create_check)
+            .service(synthetics::update_check)
+            .service(synthetics::list_checks)
+            .service(synthetics::get_check)
+            .service(synthetics::delete_check)
+            .service(synthetics::upload_script)
+            .service(synthetics::get_screenshot)
+            .service(entities::list_entities)
+            .service(entities::get_entity)
             .service(search::multi_streams::search_multi)
             .service(search::multi_streams::_search_partition_multi)
             .service(search::multi_streams::around_multi)
@@ -480,7 +809,10 @@ pub fn get_other_service_routes(cfg: &mut web::ServiceConfig) {
     cfg.service(
         web::scope("/aws")
             .wrap(cors.clone())
+            .wrap(from_fn(trace_context_middleware))
+            .wrap(from_fn(residency_middleware))
             .wrap(amz_auth)
+            .wrap(from_fn(network_policy_middleware))
             .service(logs::ingest::handle_kinesis_request),
     );
 
@@ -488,10 +820,27 @@ pub fn get_other_service_routes(cfg: &mut web::ServiceConfig) {
     cfg.service(
         web::scope("/gcp")
             .wrap(cors.clone())
+            .wrap(from_fn(trace_context_middleware))
+            .wrap(from_fn(residency_middleware))
             .wrap(gcp_auth)
+            .wrap(from_fn(network_policy_middleware))
             .service(logs::ingest::handle_gcp_request),
     );
 
+    // the deliveries are authenticated with the secret of the webhook
+    cfg.service(
+        web::scope("/webhooks")
+            .wrap(cors.clone())
+            .service(webhooks::receive_event),
+    );
+
+    // the tool calls are authenticated with the bearer of an mcp token
+    cfg.service(
+        web::scope("/mcp")
+            .wrap(cors.clone())
+            .service(mcp::handle_request),
+    );
+
     // NOTE: Here the order of middlewares matter. Once we consume the api-token in
     // `rum_auth`, we drop it in the RumExtraData data.
     // https://docs.rs/actix-web/latest/actix_web/middleware/index.html#ordering
@@ -500,7 +849,9 @@ pub fn get_other_service_routes(cfg: &mut web::ServiceConfig) {
         web::scope("/rum")
             .wrap(cors)
             .wrap(from_fn(RumExtraData::extractor))
+            .wrap(from_fn(residency_middleware))
             .wrap(rum_auth)
+            .wrap(from_fn(network_policy_middleware))
             .service(rum::ingest::log)
             .service(rum::ingest::sessionreplay)
             .service(rum::ingest::data),
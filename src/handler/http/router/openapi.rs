@@ -22,12 +22,22 @@ use crate::{common::meta, handler::http::request};
 #[openapi(
     paths(
         request::status::healthz,
+        request::status::schedulez,
         request::users::list,
         request::users::save,
         request::users::update,
         request::users::delete,
         request::users::add_user_to_org,
+        request::users::list_sessions,
+        request::users::revoke_sessions,
+        request::users::revoke_session,
+        request::users::enroll_mfa,
+        request::users::activate_mfa,
+        request::users::disable_mfa,
+        request::users::authentication,
+        request::users::list_roles,
         request::organization::org::organizations,
+        request::organization::org::create_org,
         request::organization::org::org_summary,
         request::organization::org::get_user_passcode,
         request::organization::org::update_user_passcode,
@@ -36,18 +46,139 @@ use crate::{common::meta, handler::http::request};
         request::organization::org::create_user_rumtoken,
         request::organization::settings::get,
         request::organization::settings::create,
+        request::organization::network_policy::get,
+        request::organization::network_policy::set,
+        request::organization::network_policy::delete,
+        request::organization::residency::get,
+        request::organization::residency::set,
+        request::organization::residency::delete,
         request::stream::list,
         request::stream::schema,
         request::stream::settings,
         request::stream::delete_fields,
         request::stream::delete,
+        request::stream::lifecycle_estimate,
+        request::stream::archival_suggestions,
+        request::stream::archival_apply,
+        request::stream::recluster,
+        request::stream::profile,
+        request::stream::partitions,
+        request::stream::iceberg_table,
+        request::stream::delete_data,
+        request::stream::truncate,
+        request::stream::list_delete_jobs,
+        request::schema_contracts::save_contract,
+        request::schema_contracts::get_contract,
+        request::schema_contracts::delete_contract,
+        request::schema_contracts::get_report,
+        request::rehydration::create_job,
+        request::rehydration::list_jobs,
+        request::rehydration::get_job,
+        request::rehydration::delete_job,
+        request::archive_search::create_job,
+        request::archive_search::list_jobs,
+        request::archive_search::get_job,
+        request::archive_search::delete_job,
+        request::search_jobs::create_job,
+        request::search_jobs::list_jobs,
+        request::search_jobs::get_job,
+        request::search_jobs::delete_job,
+        request::etl::create_job,
+        request::etl::update_job,
+        request::etl::list_jobs,
+        request::etl::get_job,
+        request::etl::delete_job,
+        request::log_pullers::create_puller,
+        request::log_pullers::update_puller,
+        request::log_pullers::list_pullers,
+        request::log_pullers::get_puller,
+        request::log_pullers::get_status,
+        request::log_pullers::delete_puller,
+        request::secrets::create_secret,
+        request::secrets::update_secret,
+        request::secrets::list_secrets,
+        request::secrets::get_secret,
+        request::secrets::delete_secret,
+        request::ingest_keys::create_key,
+        request::ingest_keys::list_keys,
+        request::ingest_keys::delete_key,
+        request::plugins::register_plugin,
+        request::plugins::update_plugin,
+        request::plugins::list_plugins,
+        request::plugins::get_plugin,
+        request::plugins::enable_plugin,
+        request::plugins::delete_plugin,
+        request::subscriptions::create_subscription,
+        request::subscriptions::update_subscription,
+        request::subscriptions::list_subscriptions,
+        request::subscriptions::get_subscription,
+        request::subscriptions::delete_subscription,
+        request::saved_queries::create_query,
+        request::saved_queries::update_query,
+        request::saved_queries::list_queries,
+        request::saved_queries::get_query,
+        request::saved_queries::run_query,
+        request::saved_queries::delete_query,
+        request::comments::create_comment,
+        request::comments::list_comments,
+        request::comments::update_comment,
+        request::comments::delete_comment,
+        request::governance::stale_report,
+        request::governance::list_governance,
+        request::governance::set_governance,
+        request::governance::get_governance,
+        request::governance::delete_governance,
+        request::bulk::apply,
+        request::bulk::export,
+        request::bulk::import,
+        request::demo::seed,
+        request::snapshot::take,
+        request::snapshot::restore,
+        request::conformance::run,
+        request::query_history::list_history,
+        request::webhooks::create_webhook,
+        request::webhooks::update_webhook,
+        request::webhooks::list_webhooks,
+        request::webhooks::get_webhook,
+        request::webhooks::delete_webhook,
+        request::mcp::create_token,
+        request::mcp::list_tokens,
+        request::mcp::delete_token,
+        request::synthetics::create_check,
+        request::synthetics::update_check,
+        request::synthetics::list_checks,
+        request::synthetics::get_check,
+        request::synthetics::delete_check,
+        request::synthetics::test_check,
+        request::synthetics::list_location_checks,
+        request::synthetics::report_results,
+        request::synthetics::upload_script,
+        request::synthetics::get_screenshot,
+        request::entities::list_entities,
+        request::entities::get_entity,
         request::logs::ingest::bulk,
         request::logs::ingest::multi,
         request::logs::ingest::json,
+        request::logs::ingest::import_parquet,
+        request::logs::ingest::upload_parquet,
+        request::logs::ingest::handle_kinesis_request,
+        request::logs::ingest::otlp_logs_write,
         request::traces::traces_write,
         request::traces::get_latest_traces,
+        request::traces::get_trace_detail,
         request::metrics::ingest::json,
+        request::metrics::ingest::otlp_metrics_write,
+        request::metrics::cardinality::get_top_streams,
+        request::metrics::cardinality::get_cardinality,
+        request::metrics::cardinality::get_label_values,
         request::prom::remote_write,
+        request::prom::remote_read,
+        request::prom::scrape::create_config,
+        request::prom::scrape::update_config,
+        request::prom::scrape::list_configs,
+        request::prom::scrape::get_config,
+        request::prom::scrape::delete_config,
+        request::prom::scrape::list_targets,
         request::prom::query_get,
         request::prom::query_range_get,
         request::prom::metadata,
@@ -60,9 +191,20 @@ use crate::{common::meta, handler::http::request};
         request::rum::ingest::data,
         request::rum::ingest::sessionreplay,
         request::search::search,
+        request::search::search_stream,
+        request::search::analyze::analyze,
+        request::search::nl_query::generate,
+        request::search::log_summary::summarize,
+        request::search::table::search_table,
+        request::search::federated::search_federated,
+        request::search::federated::search_federated_dashboard,
+        request::search::federated::list_federated_orgs,
         request::search::search_partition,
         request::search::around,
         request::search::values,
+        request::search::multi_streams::search_multi,
+        request::search::multi_streams::_search_partition_multi,
+        request::search::multi_streams::around_multi,
         request::search::saved_view::create_view,
         request::search::saved_view::delete_view,
         request::search::saved_view::get_view,
@@ -72,6 +214,10 @@ use crate::{common::meta, handler::http::request};
         request::functions::update_function,
         request::functions::save_function,
         request::functions::delete_function,
+        request::functions::test_function,
+        request::functions::list_function_versions,
+        request::functions::rollback_function,
+        request::functions::promote_function,
         request::functions::list_stream_functions,
         request::functions::add_function_to_stream,
         request::functions::delete_stream_function,
@@ -85,11 +231,37 @@ use crate::{common::meta, handler::http::request};
         request::dashboards::folders::list_folders,
         request::dashboards::folders::get_folder,
         request::dashboards::folders::update_folder,
+        request::dashboards::folders::move_folder,
+        request::dashboards::folders::set_folder_permissions,
         request::dashboards::move_dashboard,
+        request::dashboards::import_grafana_dashboards,
+        request::dashboards::library::create_library_panel,
+        request::dashboards::library::update_library_panel,
+        request::dashboards::library::list_library_panels,
+        request::dashboards::library::get_library_panel,
+        request::dashboards::library::get_library_panel_usage,
+        request::dashboards::library::delete_library_panel,
+        request::dashboards::reports::create_report,
+        request::dashboards::reports::update_report,
+        request::dashboards::reports::list_reports,
+        request::dashboards::reports::get_report,
+        request::dashboards::reports::delete_report,
+        request::dashboards::reports::enable_report,
+        request::dashboards::reports::trigger_report,
+        request::pipelines::save_pipeline,
+        request::pipelines::update_pipeline,
+        request::pipelines::list_pipelines,
+        request::pipelines::delete_pipeline,
+        request::pipelines::list_pipeline_versions,
+        request::pipelines::rollback_pipeline,
         request::alerts::save_alert,
         request::alerts::update_alert,
         request::alerts::list_stream_alerts,
         request::alerts::list_alerts,
+        request::alerts::list_firing_alerts,
+        request::alerts::list_alert_history,
+        request::alerts::get_alert_history_stats,
+        request::alerts::list_scheduled_triggers,
         request::alerts::get_alert,
         request::alerts::delete_alert,
         request::alerts::enable_alert,
@@ -122,14 +294,168 @@ use crate::{common::meta, handler::http::request};
             meta::stream::StreamProperty,
             meta::stream::StreamDeleteFields,
             meta::stream::ListStream,
+            meta::stream::ReclusterJob,
+            meta::stream::StreamDeleteJob,
+            meta::stream::StreamDeleteJobStatus,
+            meta::stream::ListStreamDeleteJob,
+            meta::stream_profile::StreamProfile,
+            meta::stream_profile::FieldProfile,
+            meta::stream_profile::ValueCount,
+            meta::stream_profile::LengthStats,
+            meta::stream_profile::LengthBucket,
+            meta::stream_partitions::StreamPartitions,
+            meta::stream_partitions::PartitionField,
+            meta::stream_partitions::Partition,
+            meta::stream_partitions::DataFile,
+            meta::stream_partitions::ColumnStatistics,
+            meta::parquet_import::ImportParquetRequest,
+            meta::parquet_import::ImportParquetResponse,
+            meta::parquet_import::ImportedFile,
+            meta::parquet_import::FailedFile,
+            meta::iceberg::IcebergTable,
+            meta::lifecycle::LifecycleEstimateRequest,
+            meta::lifecycle::LifecycleEstimateResponse,
+            meta::lifecycle::StorageTier,
+            meta::lifecycle::StreamLifecycle,
+            meta::lifecycle::Rollup,
+            meta::lifecycle::StreamEstimate,
+            meta::lifecycle::MonthEstimate,
+            meta::stream_access::ArchivalAction,
+            meta::stream_access::ArchivalSuggestion,
+            meta::stream_access::ArchivalSuggestions,
+            meta::stream_access::ArchivalStream,
+            meta::stream_access::ApplyArchivalRequest,
+            meta::stream_access::ApplyArchivalResponse,
+            meta::stream_access::SkippedStream,
             config::meta::stream::StreamSettings,
+            config::meta::stream::ParquetSettings,
+            config::meta::stream::FieldAccessRule,
+            config::meta::stream::TableFormat,
             config::meta::stream::StreamPartition,
             config::meta::stream::StreamPartitionType,
             config::meta::stream::StreamStats,
             config::meta::stream::PartitionTimeLevel,
+            config::meta::stream::SamplingRule,
+            meta::schema_contract::SchemaContract,
+            meta::schema_contract::ContractField,
+            meta::schema_contract::ContractFieldType,
+            meta::schema_contract::ContractEnforcement,
+            meta::schema_contract::ContractReport,
+            meta::schema_contract::ProducerReport,
+            meta::entity::Entity,
+            meta::entity::EntityKind,
+            meta::entity::EntityStream,
+            meta::entity::EntityList,
+            meta::rehydration::RehydrationRequest,
+            meta::rehydration::RehydrationStatus,
+            meta::rehydration::RehydrationJob,
+            meta::rehydration::RehydrationJobList,
+            meta::archive_search::ArchiveSearchRequest,
+            meta::archive_search::ArchiveSearchStatus,
+            meta::archive_search::ArchiveSearchJob,
+            meta::archive_search::ArchiveSearchJobList,
+            meta::search_jobs::SearchJobRequest,
+            meta::search_jobs::SearchJobStatus,
+            meta::search_jobs::SearchJob,
+            meta::search_jobs::SearchJobList,
+            meta::etl::EtlJob,
+            meta::etl::EtlSource,
+            meta::etl::EtlWriteMode,
+            meta::etl::EtlRun,
+            meta::etl::EtlJobList,
+            meta::log_puller::LogPuller,
+            meta::log_puller::LogPullerProvider,
+            meta::log_puller::CloudwatchSource,
+            meta::log_puller::GcpPubsubSource,
+            meta::log_puller::AzureMonitorSource,
+            meta::log_puller::LogPullerList,
+            meta::log_puller::LogPullerState,
+            meta::secrets::SecretRequest,
+            meta::secrets::Secret,
+            meta::secrets::SecretList,
+            meta::ingest_keys::IngestKeyRequest,
+            meta::ingest_keys::IngestKey,
+            meta::ingest_keys::IngestKeyList,
+            meta::plugins::Plugin,
+            meta::plugins::PluginCapabilities,
+            meta::plugins::PluginList,
+            meta::plugins::PluginWithStatus,
+            meta::plugins::PluginState,
+            meta::plugins::PluginStatus,
+            meta::plugins::PluginTarget,
+            meta::subscriptions::StreamSubscription,
+            meta::subscriptions::SubscriptionSink,
+            meta::subscriptions::WebhookSink,
+            meta::subscriptions::KafkaSink,
+            meta::subscriptions::OpenobserveSink,
+            meta::subscriptions::ClickhouseSink,
+            meta::subscriptions::ElasticsearchSink,
+            meta::subscriptions::StreamSubscriptionList,
+            meta::saved_query::SavedQuery,
+            meta::saved_query::QueryParameter,
+            meta::saved_query::ParameterType,
+            meta::saved_query::QuerySharing,
+            meta::saved_query::SavedQueryList,
+            meta::saved_query::RunSavedQueryRequest,
+            meta::comments::Comment,
+            meta::comments::CommentTarget,
+            meta::comments::CommentRequest,
+            meta::comments::CommentList,
+            meta::governance::GovernedKind,
+            meta::governance::LifecycleState,
+            meta::governance::Governance,
+            meta::governance::GovernanceRequest,
+            meta::governance::GovernanceList,
+            meta::governance::StaleReason,
+            meta::governance::StaleObject,
+            meta::governance::StaleReport,
+            meta::bulk::BulkAction,
+            meta::bulk::BulkRequest,
+            meta::bulk::BulkExportRequest,
+            meta::bulk::BulkObject,
+            meta::bulk::BulkImportRequest,
+            meta::bulk::BulkItemStatus,
+            meta::bulk::BulkItemResult,
+            meta::bulk::BulkResponse,
+            meta::demo::SeedRequest,
+            meta::demo::SeedResponse,
+            meta::snapshot::SnapshotRequest,
+            meta::snapshot::Snapshot,
+            meta::snapshot::StreamSnapshot,
+            meta::snapshot::DashboardSnapshot,
+            meta::snapshot::RestoreResponse,
+            meta::conformance::ConformanceRequest,
+            meta::conformance::Transport,
+            meta::conformance::CaseResult,
+            meta::conformance::ConformanceReport,
+            meta::webhook::WebhookSource,
+            meta::webhook::WebhookProvider,
+            meta::webhook::WebhookSourceList,
+            meta::mcp::McpTokenRequest,
+            meta::mcp::McpToken,
+            meta::mcp::McpTokenCreated,
+            meta::mcp::McpTokenList,
+            meta::synthetics::SyntheticCheck,
+            meta::synthetics::CheckType,
+            meta::synthetics::HttpSettings,
+            meta::synthetics::BrowserSettings,
+            meta::synthetics::Assertion,
+            meta::synthetics::AssertionSource,
+            meta::synthetics::AssertionOperator,
+            meta::synthetics::SyntheticCheckList,
+            meta::synthetics::CheckResult,
+            meta::synthetics::StepResult,
+            meta::scrape::ScrapeConfig,
+            meta::scrape::KubernetesDiscovery,
+            meta::scrape::ScrapeConfigList,
+            meta::scrape::ScrapeTarget,
+            meta::scrape::ScrapeTargetList,
             meta::ingestion::RecordStatus,
             meta::ingestion::StreamStatus,
             meta::ingestion::IngestionResponse,
+            meta::ingestion::KinesisFHRequest,
+            meta::ingestion::KFHRecordRequest,
+            meta::ingestion::KinesisFHIngestionResponse,
             meta::dashboards::Dashboard,
             meta::dashboards::Dashboards,
             meta::dashboards::v1::AxisItem,
@@ -146,12 +472,40 @@ use crate::{common::meta, handler::http::request};
             meta::dashboards::v1::VariableList,
             meta::dashboards::Folder,
             meta::dashboards::MoveDashboard,
+            meta::dashboards::MoveFolder,
+            meta::dashboards::FolderAccess,
+            meta::dashboards::FolderPermission,
             meta::dashboards::FolderList,
+            meta::dashboards::grafana::GrafanaImportList,
+            meta::dashboards::grafana::GrafanaImport,
+            meta::dashboards::grafana::GrafanaImportReport,
+            meta::dashboards::reports::Report,
+            meta::dashboards::reports::ReportDashboard,
+            meta::dashboards::reports::ReportDashboardVariable,
+            meta::dashboards::reports::ReportDestination,
+            meta::dashboards::reports::ReportFrequency,
+            meta::dashboards::reports::ReportFrequencyType,
+            meta::dashboards::reports::ReportMediaType,
+            meta::dashboards::reports::ReportTimerange,
+            meta::dashboards::reports::ReportTimerangeType,
+            meta::pipelines::PipeLine,
+            meta::pipelines::PipeLineResponse,
+            meta::pipelines::PipeLineList,
+            config::meta::stream::RoutingCondition,
+            meta::dashboards::grafana::ImportItem,
+            meta::dashboards::grafana::ImportStatus,
+            meta::dashboards::library::LibraryPanel,
+            meta::dashboards::library::LibraryPanelList,
+            meta::dashboards::library::LibraryPanelUsage,
+            meta::dashboards::library::LibraryPanelUsageList,
             config::meta::search::Query,
             config::meta::search::Request,
             config::meta::search::RequestEncoding,
+            config::meta::search::SearchEventType,
             config::meta::search::Response,
             config::meta::search::ResponseTook,
+            config::meta::search::ResponseClusterStats,
+            config::meta::search::ClusterSearchStatus,
             config::meta::search::ResponseNodeTook,
             config::meta::search::SearchPartitionRequest,
             config::meta::search::SearchPartitionResponse,
@@ -168,6 +522,13 @@ use crate::{common::meta, handler::http::request};
             meta::saved_view::CreateViewResponse,
             meta::saved_view::UpdateViewRequest,
             meta::alerts::Alert,
+            meta::alerts::AlertFiring,
+            meta::alerts::RootCauseHint,
+            meta::alerts::AlertStatus,
+            meta::alerts::AlertHistoryStat,
+            meta::alerts::AlertHistoryStats,
+            meta::alerts::TriggerRunStats,
+            meta::alerts::ScheduledTrigger,
             meta::alerts::Condition,
             meta::alerts::Operator,
             meta::alerts::Aggregation,
@@ -176,16 +537,29 @@ use crate::{common::meta, handler::http::request};
             meta::alerts::TriggerCondition,
             meta::alerts::AlertFrequencyType,
             meta::alerts::QueryCondition,
+            meta::alerts::DeadmanCondition,
+            meta::alerts::QualityCondition,
+            meta::alerts::ExpectedSetCondition,
+            meta::alerts::LogContext,
+            meta::alerts::QualityCheck,
             meta::alerts::destinations::Destination,
             meta::alerts::destinations::DestinationWithTemplate,
             meta::alerts::destinations::HTTPType,
             meta::alerts::destinations::DestinationType,
+            meta::alerts::destinations::RateLimit,
+            meta::alerts::destinations::QuietHours,
+            meta::alerts::destinations::OverflowAction,
             meta::alerts::templates::Template,
             meta::functions::Transform,
             meta::functions::FunctionList,
             meta::functions::StreamFunctionsList,
             meta::functions::StreamTransform,
             meta::functions::StreamOrder,
+            meta::functions::TestFunctionRequest,
+            meta::functions::TestFunctionResult,
+            meta::functions::TestFunctionResponse,
+            meta::config_versions::ConfigVersion,
+            meta::config_versions::ConfigVersionList,
             meta::user::UserRequest,
             meta::user::UpdateUser,
             meta::user::UserRole,
@@ -194,8 +568,15 @@ use crate::{common::meta, handler::http::request};
             meta::user::UserResponse,
             meta::user::UpdateUser,
             meta::user::SignInResponse,
+            meta::user::SignInUser,
+            meta::user::RolesResponse,
+            meta::user::UserSession,
+            meta::user::UserSessionList,
+            meta::user::MfaEnrollResponse,
+            meta::user::MfaVerifyRequest,
             meta::organization::OrgSummary,
             meta::organization::StreamSummary,
+            meta::organization::Organization,
             meta::organization::OrganizationResponse,
             meta::organization::OrgDetails,
             meta::organization::OrgUser,
@@ -203,6 +584,11 @@ use crate::{common::meta, handler::http::request};
             meta::organization::PasscodeResponse,
             meta::organization::OrganizationSetting,
             meta::organization::OrganizationSettingResponse,
+            meta::organization::NetworkPolicy,
+            meta::organization::NetworkPolicyResponse,
+            meta::organization::OrgResidency,
+            meta::organization::OrgResidencyResponse,
+            meta::organization::NetworkScope,
             meta::organization::RumIngestionResponse,
             meta::organization::RumIngestionToken,
             request::status::HealthzResponse,
@@ -214,6 +600,36 @@ use crate::{common::meta, handler::http::request};
             meta::syslog::SyslogRoutes,
             meta::prom::Metadata,
             meta::prom::MetricType,
+            meta::prom::CardinalityResponse,
+            meta::query_analyze::QueryAnalyzeRequest,
+            meta::query_analyze::QueryAnalyzeResponse,
+            meta::query_analyze::QueryLanguage,
+            meta::query_analyze::StreamCost,
+            meta::query_analyze::LintWarning,
+            meta::nl_query::NlQueryRequest,
+            meta::nl_query::NlQueryResponse,
+            meta::log_summary::LogSummaryRequest,
+            meta::log_summary::LogPattern,
+            meta::log_summary::LogSummaryResponse,
+            meta::search::FederatedSearchRequest,
+            meta::search::FederatedSearchResponse,
+            meta::search::FederatedOrgResult,
+            meta::search::FederatedDashboardRequest,
+            meta::search::FederatedDashboardResponse,
+            meta::search::FederatedPanelResult,
+            meta::table_query::TableQueryRequest,
+            meta::table_query::TableQueryResponse,
+            meta::table_query::TableSort,
+            meta::table_query::RowFormat,
+            meta::table_query::CellFormat,
+            meta::dashboards::v4::TableRule,
+            meta::dashboards::v4::TableRuleOperator,
+            meta::dashboards::v4::TableRuleScope,
+            meta::prom::LabelCardinality,
+            meta::prom::LabelValuesResponse,
+            meta::prom::LabelValueCount,
+            meta::prom::StreamSeriesCount,
+            meta::prom::TopStreamsResponse,
          ),
     ),
     modifiers(&SecurityAddon),
@@ -224,6 +640,13 @@ use crate::{common::meta, handler::http::request};
         (name = "Dashboards", description = "Dashboard operations"),
         (name = "Search", description = "Search/Query operations"),
         (name = "Saved Views", description = "Collection of saved search views for easy retrieval"),
+        (name = "Saved Queries", description = "Library of named SQL queries with parameters, shared with the organization and run by name"),
+        (name = "Comments", description = "Comments and annotations on saved queries and dashboards"),
+        (name = "Governance", description = "Owners, team tags and lifecycle states of dashboards, alerts and pipelines"),
+        (name = "Bulk", description = "Changes, exports and imports of many alerts and dashboards at once"),
+        (name = "Demo", description = "Seeding of generated demo data"),
+        (name = "Snapshots", description = "Snapshots of the state of an organization, restored into another one"),
+        (name = "Conformance", description = "OTLP conformance suite run through the ingestion"),
         (name = "Alerts", description = "Alerts retrieval & management operations"),
         (name = "Functions", description = "Functions retrieval & management operations"),
         (name = "Organizations", description = "Organizations retrieval & management operations"),
@@ -234,6 +657,19 @@ use crate::{common::meta, handler::http::request};
         (name = "Traces", description = "Traces data ingestion operations"),
         (name = "Syslog Routes", description = "Syslog Routes retrieval & management operations"),
         (name = "Clusters", description = "Super cluster operations"),
+        (name = "Entities", description = "Inventory of the hosts, services, pods and containers sending telemetry"),
+        (name = "ETL Jobs", description = "Scheduled SQL transformations writing from source streams to a destination stream"),
+        (name = "Log Pullers", description = "Managed integrations pulling the logs of CloudWatch Logs, GCP Logging and Azure Monitor"),
+        (name = "Secrets", description = "Sealed credentials referenced by destinations and log pullers"),
+        (name = "IngestKeys", description = "Keys signing ingestion requests with HMAC"),
+        (name = "Plugins", description = "gRPC plugins adding ingestion sources, alert destinations and query functions"),
+        (name = "Subscriptions", description = "Stream subscriptions forwarding the matched records to webhooks, Kafka, another OpenObserve instance, ClickHouse or Elasticsearch"),
+        (name = "Webhooks", description = "GitHub and GitLab webhooks writing normalized repository and CI/CD events"),
+        (name = "Pipelines", description = "Stream routing pipelines retrieval & management operations"),
+        (name = "Reports", description = "Scheduled dashboard reports retrieval & management operations"),
+        (name = "Rum", description = "Real user monitoring data ingestion operations"),
+        (name = "MCP", description = "Tokens of the AI assistants calling the tools of the Model Context Protocol at /mcp/{org_id}"),
+        (name = "Synthetics", description = "HTTP, TCP, DNS, ICMP and browser checks run on a schedule from one or more locations"),
     ),
     info(
         description = "OpenObserve API documents [https://openobserve.ai/docs/](https://openobserve.ai/docs/)",
@@ -259,3 +695,67 @@ impl Modify for SecurityAddon {
         );
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashSet;
+
+    use super::*;
+
+    fn collect_refs(value: &serde_json::Value, refs: &mut Vec<String>) {
+        match value {
+            serde_json::Value::Object(map) => {
+                for (k, v) in map {
+                    match (k.as_str(), v) {
+                        ("$ref", serde_json::Value::String(r)) => refs.push(r.to_string()),
+                        _ => collect_refs(v, refs),
+                    }
+                }
+            }
+            serde_json::Value::Array(arr) => arr.iter().for_each(|v| collect_refs(v, refs)),
+            _ => {}
+        }
+    }
+
+    #[test]
+    fn test_openapi_refs_resolve() {
+        let spec = serde_json::to_value(ApiDoc::openapi()).unwrap();
+        let schemas = spec["components"]["schemas"].as_object().unwrap();
+        let mut refs = Vec::new();
+        collect_refs(&spec, &mut refs);
+        let missing = refs
+            .iter()
+            .filter_map(|r| r.strip_prefix("#/components/schemas/"))
+            .filter(|name| !schemas.contains_key(*name))
+            .collect::<HashSet<_>>();
+        assert!(missing.is_empty(), "unresolved schemas: {missing:?}");
+    }
+
+    #[test]
+    fn test_openapi_operations() {
+        let spec = serde_json::to_value(ApiDoc::openapi()).unwrap();
+        let tags = spec["tags"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|t| t["name"].as_str().unwrap())
+            .collect::<HashSet<_>>();
+        let mut ids = HashSet::new();
+        for (path, item) in spec["paths"].as_object().unwrap() {
+            for (method, op) in item.as_object().unwrap() {
+                let id = op["operationId"].as_str().unwrap();
+                assert!(
+                    ids.insert(id),
+                    "duplicate operation id {id} on {method} {path}"
+                );
+                for tag in op["tags"].as_array().unwrap() {
+                    let tag = tag.as_str().unwrap();
+                    assert!(
+                        tags.contains(tag),
+                        "undeclared tag {tag} on {method} {path}"
+                    );
+                }
+            }
+        }
+    }
+}
@@ -55,6 +55,17 @@ pub async fn init() {
     tokio::task::spawn(async move { keepalive_connection().await });
 }
 
+/// Read options, the reads are served by any member without a quorum while
+/// the meta store is read only
+fn read_options() -> GetOptions {
+    let opt = GetOptions::new();
+    if super::failover::is_read_only() {
+        opt.with_serializable()
+    } else {
+        opt
+    }
+}
+
 pub struct Etcd {
     prefix: String,
 }
@@ -70,7 +81,7 @@ impl Etcd {
     async fn get_key_value(&self, key: &str) -> Result<(String, Bytes)> {
         let key = format!("{}{}", self.prefix, key);
         let mut client = get_etcd_client().await.clone();
-        let opt = GetOptions::new()
+        let opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Descend)
             .with_limit(1);
@@ -107,10 +118,7 @@ impl super::Db for Etcd {
         let stats = client.status().await?;
         let bytes_len = stats.db_size();
         let resp = client
-            .get(
-                "",
-                Some(GetOptions::new().with_all_keys().with_count_only()),
-            )
+            .get("", Some(read_options().with_all_keys().with_count_only()))
             .await?;
         let keys_count = resp.count();
         Ok(super::Stats {
@@ -122,7 +130,7 @@ impl super::Db for Etcd {
     async fn get(&self, key: &str) -> Result<Bytes> {
         let key = format!("{}{}", self.prefix, key);
         let mut client = get_etcd_client().await.clone();
-        let opt = GetOptions::new()
+        let opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Descend)
             .with_limit(1);
@@ -140,6 +148,7 @@ impl super::Db for Etcd {
         _need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let key = if start_dt.is_some() {
             format!("{}{}/{}", self.prefix, key, start_dt.unwrap())
         } else {
@@ -157,6 +166,7 @@ impl super::Db for Etcd {
         start_dt: Option<i64>,
         update_fn: Box<super::UpdateFn>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         // acquire lock and update
         let lock_key = format!("/meta{key}/{}", start_dt.unwrap_or_default());
         let locker = match dist_lock::lock(&lock_key, 0).await {
@@ -218,6 +228,7 @@ impl super::Db for Etcd {
         _need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let mut key = format!("{}{}", self.prefix, key);
         if start_dt.is_some() {
             key = format!("{}/{}", key, start_dt.unwrap());
@@ -233,7 +244,7 @@ impl super::Db for Etcd {
         let mut result = HashMap::default();
         let key = format!("{}{}", self.prefix, prefix);
         let mut client = get_etcd_client().await.clone();
-        let mut opt = GetOptions::new()
+        let mut opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Ascend)
             .with_limit(cfg.etcd.load_page_size);
@@ -278,7 +289,7 @@ impl super::Db for Etcd {
         let mut result = Vec::new();
         let key = format!("{}{}", self.prefix, prefix);
         let mut client = get_etcd_client().await.clone();
-        let mut opt = GetOptions::new()
+        let mut opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Ascend)
             .with_limit(cfg.etcd.load_page_size);
@@ -323,7 +334,7 @@ impl super::Db for Etcd {
         let mut result = Vec::new();
         let key = format!("{}{}", self.prefix, prefix);
         let mut client = get_etcd_client().await.clone();
-        let mut opt = GetOptions::new()
+        let mut opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Ascend)
             .with_limit(cfg.etcd.load_page_size);
@@ -377,7 +388,7 @@ impl super::Db for Etcd {
         let mut result = Vec::new();
         let key = format!("{}{}", self.prefix, prefix);
         let mut client = get_etcd_client().await.clone();
-        let mut opt = GetOptions::new()
+        let mut opt = read_options()
             .with_prefix()
             .with_sort(SortTarget::Key, SortOrder::Ascend)
             .with_limit(cfg.etcd.load_page_size);
@@ -427,7 +438,7 @@ impl super::Db for Etcd {
     async fn count(&self, prefix: &str) -> Result<i64> {
         let key = format!("{}{}", self.prefix, prefix);
         let mut client = get_etcd_client().await.clone();
-        let opt = GetOptions::new().with_prefix().with_count_only();
+        let opt = read_options().with_prefix().with_count_only();
         let resp = client.get(key.clone(), Some(opt)).await?;
         Ok(resp.count())
     }
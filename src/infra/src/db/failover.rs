@@ -0,0 +1,166 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Health checks of the primary meta store. When the primary stops answering,
+//! the meta store turns read only until it is back: the writes fail right away
+//! and the reads go to the replica of PostgreSQL and MySQL when one is set, or
+//! to any member of etcd, so the query path keeps working during a
+//! maintenance of the database instead of the whole cluster erroring.
+
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use config::{get_config, meta::meta_store::MetaStore};
+use etcd_client::GetOptions;
+
+use crate::errors::{DbError, Error, Result};
+
+static READ_ONLY: AtomicBool = AtomicBool::new(false);
+
+pub fn is_read_only() -> bool {
+    READ_ONLY.load(Ordering::Relaxed)
+}
+
+/// Fails when the meta store is read only, to call before any write
+pub fn check_writable() -> Result<()> {
+    if is_read_only() {
+        return Err(Error::from(DbError::ReadOnly));
+    }
+    Ok(())
+}
+
+/// Returns the client to read from, the replica while the meta store is read
+/// only
+pub(crate) fn read_target<'a, T>(primary: &'a T, replica: Option<&'a T>) -> &'a T {
+    match replica {
+        Some(replica) if is_read_only() => replica,
+        _ => primary,
+    }
+}
+
+pub(crate) fn init() {
+    let cfg = get_config();
+    if cfg.common.local_mode || cfg.limit.meta_health_check_interval == 0 {
+        return;
+    }
+    match cfg.common.meta_store.as_str().into() {
+        MetaStore::PostgreSQL | MetaStore::MySQL | MetaStore::Etcd => {
+            tokio::task::spawn(async move { run().await });
+        }
+        _ => {}
+    }
+}
+
+async fn run() {
+    let cfg = get_config();
+    let mut interval = tokio::time::interval(tokio::time::Duration::from_secs(
+        cfg.limit.meta_health_check_interval,
+    ));
+    interval.tick().await; // trigger the first run
+    let mut failures = 0;
+    loop {
+        interval.tick().await;
+        let timeout = tokio::time::Duration::from_secs(cfg.limit.meta_health_check_interval);
+        let ret = match tokio::time::timeout(timeout, ping()).await {
+            Ok(ret) => ret,
+            Err(_) => Err(Error::Message("health check timed out".to_string())),
+        };
+        if let Err(e) = &ret {
+            log::warn!("[META] primary meta store health check failed: {}", e);
+        }
+        record_health_check(
+            &mut failures,
+            ret.is_ok(),
+            cfg.limit.meta_health_check_failures,
+        );
+    }
+}
+
+/// Leaves the read only mode on the first successful check and enters it after
+/// `max_failures` failed checks in a row
+fn record_health_check(failures: &mut u32, ok: bool, max_failures: u32) {
+    if ok {
+        *failures = 0;
+        if READ_ONLY.swap(false, Ordering::Relaxed) {
+            log::info!("[META] primary meta store is back, leaving read only mode");
+        }
+        return;
+    }
+    *failures += 1;
+    if *failures >= max_failures && !READ_ONLY.swap(true, Ordering::Relaxed) {
+        log::error!(
+            "[META] primary meta store is down after {} failed health checks, entering read only mode",
+            failures
+        );
+    }
+}
+
+/// Checks the primary answers, etcd needs a quorum of its members
+async fn ping() -> Result<()> {
+    match get_config().common.meta_store.as_str().into() {
+        MetaStore::PostgreSQL => {
+            sqlx::query("SELECT 1;")
+                .execute(&*super::postgres::CLIENT)
+                .await?;
+        }
+        MetaStore::MySQL => {
+            sqlx::query("SELECT 1;")
+                .execute(&*super::mysql::CLIENT)
+                .await?;
+        }
+        MetaStore::Etcd => {
+            let mut client = super::etcd::get_etcd_client().await.clone();
+            let key = format!("{}/", get_config().etcd.prefix.trim_end_matches('/'));
+            client
+                .get(key, Some(GetOptions::new().with_count_only()))
+                .await?;
+        }
+        _ => {}
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    // the mode is global, so the switching is checked in a single test
+    #[test]
+    fn test_failover_switching() {
+        let mut failures = 0;
+        record_health_check(&mut failures, true, 2);
+        assert!(check_writable().is_ok());
+        assert_eq!(read_target(&"primary", Some(&"replica")), &"primary");
+
+        record_health_check(&mut failures, false, 2);
+        assert_eq!(failures, 1);
+        assert!(check_writable().is_ok());
+        assert_eq!(read_target(&"primary", Some(&"replica")), &"primary");
+
+        record_health_check(&mut failures, false, 2);
+        assert!(is_read_only());
+        assert!(matches!(
+            check_writable(),
+            Err(Error::DbError(DbError::ReadOnly))
+        ));
+        assert_eq!(read_target(&"primary", Some(&"replica")), &"replica");
+        // without a replica the reads keep going to the primary
+        assert_eq!(read_target(&"primary", None), &"primary");
+
+        record_health_check(&mut failures, true, 2);
+        assert_eq!(failures, 0);
+        assert!(check_writable().is_ok());
+        assert_eq!(read_target(&"primary", Some(&"replica")), &"primary");
+    }
+}
@@ -24,6 +24,7 @@ use tokio::sync::{mpsc, OnceCell};
 use crate::errors::{DbError, Error, Result};
 
 pub mod etcd;
+pub mod failover;
 pub mod mysql;
 pub mod nats;
 pub mod postgres;
@@ -53,6 +54,7 @@ pub async fn get_super_cluster() -> &'static Box<dyn Db> {
 pub async fn init() -> Result<()> {
     etcd::init().await;
     create_table().await?;
+    failover::init();
     Ok(())
 }
 
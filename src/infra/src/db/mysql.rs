@@ -29,6 +29,8 @@ use tokio::sync::mpsc;
 use crate::errors::*;
 
 pub static CLIENT: Lazy<Pool<MySql>> = Lazy::new(connect);
+/// Replica of the meta store, read from while the primary is down
+pub static REPLICA: Lazy<Option<Pool<MySql>>> = Lazy::new(connect_replica);
 
 fn connect() -> Pool<MySql> {
     let cfg = config::get_config();
@@ -42,6 +44,28 @@ fn connect() -> Pool<MySql> {
         .connect_lazy_with(db_opts)
 }
 
+fn connect_replica() -> Option<Pool<MySql>> {
+    let cfg = config::get_config();
+    if cfg.common.meta_mysql_replica_dsn.is_empty() {
+        return None;
+    }
+    let db_opts = MySqlConnectOptions::from_str(&cfg.common.meta_mysql_replica_dsn)
+        .expect("mysql replica connect options create failed")
+        .disable_statement_logging();
+
+    Some(
+        MySqlPoolOptions::new()
+            .min_connections(cfg.limit.sql_min_db_connections)
+            .max_connections(cfg.limit.sql_max_db_connections)
+            .connect_lazy_with(db_opts),
+    )
+}
+
+/// Returns the pool to read from, the replica while the primary is down
+pub fn read_client() -> Pool<MySql> {
+    super::failover::read_target(&*CLIENT, REPLICA.as_ref()).clone()
+}
+
 pub struct MysqlDb {}
 
 impl MysqlDb {
@@ -63,7 +87,7 @@ impl super::Db for MysqlDb {
     }
 
     async fn stats(&self) -> Result<super::Stats> {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let keys_count: i64 =
             sqlx::query_scalar(r#"SELECT CAST(COUNT(*) AS SIGNED) AS num FROM meta;"#)
                 .fetch_one(&pool)
@@ -77,7 +101,7 @@ impl super::Db for MysqlDb {
 
     async fn get(&self, key: &str) -> Result<Bytes> {
         let (module, key1, key2) = super::parse_key(key);
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let query = r#"SELECT value FROM meta WHERE module = ? AND key1 = ? AND key2 = ? ORDER BY start_dt DESC;"#;
         let value: String = match sqlx::query_scalar(query)
             .bind(module)
@@ -101,6 +125,7 @@ impl super::Db for MysqlDb {
         need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let (module, key1, key2) = super::parse_key(key);
         let pool = CLIENT.clone();
         let local_start_dt = start_dt.unwrap_or_default();
@@ -160,6 +185,7 @@ impl super::Db for MysqlDb {
         start_dt: Option<i64>,
         update_fn: Box<super::UpdateFn>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let (module, key1, key2) = super::parse_key(key);
         let lock_pool = CLIENT.clone();
         let lock_key = format!("get_for_update_{}", key);
@@ -382,6 +408,7 @@ impl super::Db for MysqlDb {
         need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         // event watch
         if need_watch {
             // find all keys then send event
@@ -451,7 +478,7 @@ impl super::Db for MysqlDb {
         }
         sql = format!("{} ORDER BY start_dt ASC", sql);
 
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -480,7 +507,7 @@ impl super::Db for MysqlDb {
         }
 
         sql = format!("{} ORDER BY start_dt ASC", sql);
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -528,7 +555,7 @@ impl super::Db for MysqlDb {
             sql, min_dt, max_dt
         );
         sql = format!("{} ORDER BY start_dt ASC", sql);
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -550,7 +577,7 @@ impl super::Db for MysqlDb {
         if !key2.is_empty() {
             sql = format!("{} AND (key2 = '{}' OR key2 LIKE '{}/%')", sql, key2, key2);
         }
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let count: i64 = sqlx::query_scalar(&sql).fetch_one(&pool).await?;
         Ok(count)
     }
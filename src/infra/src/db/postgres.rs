@@ -29,6 +29,8 @@ use tokio::sync::mpsc;
 use crate::errors::*;
 
 pub static CLIENT: Lazy<Pool<Postgres>> = Lazy::new(connect);
+/// Replica of the meta store, read from while the primary is down
+pub static REPLICA: Lazy<Option<Pool<Postgres>>> = Lazy::new(connect_replica);
 
 fn connect() -> Pool<Postgres> {
     let cfg = config::get_config();
@@ -42,6 +44,28 @@ fn connect() -> Pool<Postgres> {
         .connect_lazy_with(db_opts)
 }
 
+fn connect_replica() -> Option<Pool<Postgres>> {
+    let cfg = config::get_config();
+    if cfg.common.meta_postgres_replica_dsn.is_empty() {
+        return None;
+    }
+    let db_opts = PgConnectOptions::from_str(&cfg.common.meta_postgres_replica_dsn)
+        .expect("postgres replica connect options create failed")
+        .disable_statement_logging();
+
+    Some(
+        PgPoolOptions::new()
+            .min_connections(cfg.limit.sql_min_db_connections)
+            .max_connections(cfg.limit.sql_max_db_connections)
+            .connect_lazy_with(db_opts),
+    )
+}
+
+/// Returns the pool to read from, the replica while the primary is down
+pub fn read_client() -> Pool<Postgres> {
+    super::failover::read_target(&*CLIENT, REPLICA.as_ref()).clone()
+}
+
 pub struct PostgresDb {}
 
 impl PostgresDb {
@@ -63,7 +87,7 @@ impl super::Db for PostgresDb {
     }
 
     async fn stats(&self) -> Result<super::Stats> {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let keys_count: i64 = sqlx::query_scalar(r#"SELECT COUNT(*)::BIGINT AS num FROM meta;"#)
             .fetch_one(&pool)
             .await
@@ -76,7 +100,7 @@ impl super::Db for PostgresDb {
 
     async fn get(&self, key: &str) -> Result<Bytes> {
         let (module, key1, key2) = super::parse_key(key);
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let query = r#"SELECT value FROM meta WHERE module = $1 AND key1 = $2 AND key2 = $3 ORDER BY start_dt DESC;"#;
         let value: String = match sqlx::query_scalar(query)
             .bind(module)
@@ -100,6 +124,7 @@ impl super::Db for PostgresDb {
         need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let (module, key1, key2) = super::parse_key(key);
         let pool = CLIENT.clone();
         let local_start_dt = start_dt.unwrap_or_default();
@@ -159,6 +184,7 @@ impl super::Db for PostgresDb {
         start_dt: Option<i64>,
         update_fn: Box<super::UpdateFn>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         let (module, key1, key2) = super::parse_key(key);
         let pool = CLIENT.clone();
         let mut tx = pool.begin().await?;
@@ -320,6 +346,7 @@ impl super::Db for PostgresDb {
         need_watch: bool,
         start_dt: Option<i64>,
     ) -> Result<()> {
+        super::failover::check_writable()?;
         // event watch
         if need_watch {
             // find all keys then send event
@@ -389,7 +416,7 @@ impl super::Db for PostgresDb {
         }
         sql = format!("{} ORDER BY start_dt ASC", sql);
 
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -417,7 +444,7 @@ impl super::Db for PostgresDb {
             sql = format!("{} AND (key2 = '{}' OR key2 LIKE '{}/%')", sql, key2, key2);
         }
         sql = format!("{} ORDER BY start_dt ASC", sql);
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -465,7 +492,7 @@ impl super::Db for PostgresDb {
         );
         sql = format!("{} ORDER BY start_dt ASC", sql);
 
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::MetaRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -487,7 +514,7 @@ impl super::Db for PostgresDb {
         if !key2.is_empty() {
             sql = format!("{} AND (key2 = '{}' OR key2 LIKE '{}/%')", sql, key2, key2);
         }
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let count: i64 = sqlx::query_scalar(&sql).fetch_one(&pool).await?;
         Ok(count)
     }
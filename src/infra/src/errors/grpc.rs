@@ -58,6 +58,9 @@ impl From<DataFusionError> for Error {
         if err.contains("parquet not found") {
             return Error::ErrorCode(ErrorCodes::SearchParquetFileNotFound);
         }
+        if crate::storage::is_archived_error(&err) {
+            return Error::ErrorCode(ErrorCodes::SearchObjectArchived(err));
+        }
         if err.contains("Invalid function ") {
             let pos = err.find("Invalid function ").unwrap();
             return match get_key_from_error(&err, pos) {
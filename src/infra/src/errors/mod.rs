@@ -84,6 +84,8 @@ pub enum DbError {
     KeyNotExists(String),
     #[error("error {0} performing operation on key {1}")]
     DBOperError(String, String),
+    #[error("meta store is read only, the primary is down")]
+    ReadOnly,
 }
 
 #[derive(ThisError, Debug)]
@@ -98,6 +100,9 @@ pub enum ErrorCodes {
     SearchFieldHasNoCompatibleDataType(String),
     SearchSQLExecuteError(String),
     SearchCancelQuery(String),
+    SearchObjectArchived(String),
+    SearchRegionNotAllowed(String),
+    SearchFieldNotAllowed(String),
 }
 
 impl std::fmt::Display for ErrorCodes {
@@ -124,6 +129,9 @@ impl ErrorCodes {
             ErrorCodes::SearchFieldHasNoCompatibleDataType(_) => 20007,
             ErrorCodes::SearchSQLExecuteError(_) => 20008,
             ErrorCodes::SearchCancelQuery(_) => 429,
+            ErrorCodes::SearchObjectArchived(_) => 20009,
+            ErrorCodes::SearchRegionNotAllowed(_) => 20010,
+            ErrorCodes::SearchFieldNotAllowed(_) => 20011,
         }
     }
 
@@ -149,6 +157,15 @@ impl ErrorCodes {
             ErrorCodes::SearchCancelQuery(_) => {
                 "Search query was cancelled by the administrator".to_string()
             }
+            ErrorCodes::SearchObjectArchived(_) => {
+                "Search data is archived, restore it with an archive search".to_string()
+            }
+            ErrorCodes::SearchRegionNotAllowed(msg) => {
+                format!("Search region not allowed: {msg}")
+            }
+            ErrorCodes::SearchFieldNotAllowed(fields) => {
+                format!("Search field not allowed for your role: {fields}")
+            }
         }
     }
 
@@ -164,6 +181,9 @@ impl ErrorCodes {
             ErrorCodes::SearchFieldHasNoCompatibleDataType(field) => field.to_owned(),
             ErrorCodes::SearchSQLExecuteError(msg) => msg.to_owned(),
             ErrorCodes::SearchCancelQuery(msg) => msg.to_owned(),
+            ErrorCodes::SearchObjectArchived(file) => file.to_owned(),
+            ErrorCodes::SearchRegionNotAllowed(msg) => msg.to_owned(),
+            ErrorCodes::SearchFieldNotAllowed(fields) => fields.to_owned(),
         }
     }
 
@@ -179,6 +199,9 @@ impl ErrorCodes {
             ErrorCodes::SearchFieldHasNoCompatibleDataType(_) => "".to_string(),
             ErrorCodes::SearchSQLExecuteError(msg) => msg.to_owned(),
             ErrorCodes::SearchCancelQuery(msg) => msg.to_string(),
+            ErrorCodes::SearchObjectArchived(_) => "".to_string(),
+            ErrorCodes::SearchRegionNotAllowed(_) => "".to_string(),
+            ErrorCodes::SearchFieldNotAllowed(_) => "".to_string(),
         }
     }
 
@@ -226,6 +249,9 @@ impl ErrorCodes {
             20006 => Ok(ErrorCodes::SearchParquetFileNotFound),
             20007 => Ok(ErrorCodes::SearchFieldHasNoCompatibleDataType(message)),
             20008 => Ok(ErrorCodes::SearchSQLExecuteError(message)),
+            20009 => Ok(ErrorCodes::SearchObjectArchived(message)),
+            20010 => Ok(ErrorCodes::SearchRegionNotAllowed(message)),
+            20011 => Ok(ErrorCodes::SearchFieldNotAllowed(message)),
             _ => Ok(ErrorCodes::ServerInternalError(json.to_string())),
         }
     }
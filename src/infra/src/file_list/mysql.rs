@@ -22,7 +22,7 @@ use hashbrown::HashMap;
 use sqlx::{Executor, MySql, QueryBuilder, Row};
 
 use crate::{
-    db::mysql::CLIENT,
+    db::mysql::{read_client, CLIENT},
     errors::{DbError, Error, Result},
 };
 
@@ -211,7 +211,7 @@ impl super::FileList for MysqlFileList {
     }
 
     async fn get(&self, file: &str) -> Result<FileMeta> {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let (stream_key, date_key, file_name) =
             parse_file_key_columns(file).map_err(|e| Error::Message(e.to_string()))?;
         let ret = sqlx::query_as::<_, super::FileRecord>(
@@ -229,7 +229,7 @@ SELECT stream, date, file, deleted, min_ts, max_ts, records, original_size, comp
     }
 
     async fn contains(&self, file: &str) -> Result<bool> {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let (stream_key, date_key, file_name) =
             parse_file_key_columns(file).map_err(|e| Error::Message(e.to_string()))?;
         let ret =
@@ -282,7 +282,7 @@ SELECT stream, date, file, deleted, min_ts, max_ts, records, original_size, comp
 
         let stream_key = format!("{org_id}/{stream_type}/{stream_name}");
 
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = if flattened.is_some() {
             sqlx::query_as::<_, super::FileRecord>(
                 r#"
@@ -332,7 +332,7 @@ SELECT stream, date, file, deleted, min_ts, max_ts, records, original_size, comp
         if time_max == 0 {
             return Ok(Vec::new());
         }
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::FileDeletedRecord>(
             r#"SELECT stream, date, file, flattened FROM file_list_deleted WHERE org = ? AND created_at < ? LIMIT ?;"#,
         )
@@ -360,7 +360,7 @@ SELECT stream, date, file, deleted, min_ts, max_ts, records, original_size, comp
     ) -> Result<i64> {
         let stream_key = format!("{org_id}/{stream_type}/{stream_name}");
         let min_ts = config::utils::time::BASE_TIME.timestamp_micros();
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret: Option<i64> =
             sqlx::query_scalar(r#"SELECT MIN(min_ts) AS id FROM file_list FORCE INDEX (file_list_stream_ts_idx) WHERE stream = ? AND min_ts > ?;"#)
             .bind(stream_key)
@@ -371,7 +371,7 @@ SELECT stream, date, file, deleted, min_ts, max_ts, records, original_size, comp
     }
 
     async fn get_max_pk_value(&self) -> Result<i64> {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret: Option<i64> = sqlx::query_scalar(r#"SELECT MAX(id) AS id FROM file_list;"#)
             .fetch_one(&pool)
             .await?;
@@ -413,7 +413,7 @@ SELECT stream, MIN(min_ts) AS min_ts, MAX(max_ts) AS max_ts, CAST(COUNT(*) AS SI
                 format!("{} AND id > {} AND id <= {} GROUP BY stream", sql, min, max)
             }
         };
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::StatsRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -439,7 +439,7 @@ SELECT stream, MIN(min_ts) AS min_ts, MAX(max_ts) AS max_ts, CAST(COUNT(*) AS SI
         } else {
             format!("SELECT * FROM stream_stats WHERE org = '{}';", org_id)
         };
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = sqlx::query_as::<_, super::StatsRecord>(&sql)
             .fetch_all(&pool)
             .await?;
@@ -569,7 +569,7 @@ UPDATE stream_stats
     }
 
     async fn len(&self) -> usize {
-        let pool = CLIENT.clone();
+        let pool = read_client();
         let ret = match sqlx::query(r#"SELECT CAST(COUNT(*) AS SIGNED) AS num FROM file_list;"#)
             .fetch_one(&pool)
             .await
@@ -53,6 +53,7 @@ pub trait Scheduler: Sync + Send + 'static {
     async fn pull(
         &self,
         concurrency: i64,
+        org_quota: i64,
         alert_timeout: i64,
         report_timeout: i64,
     ) -> Result<Vec<Trigger>>;
@@ -81,6 +82,7 @@ pub enum TriggerModule {
     Report,
     #[default]
     Alert,
+    Etl,
 }
 
 impl std::fmt::Display for TriggerModule {
@@ -88,6 +90,7 @@ impl std::fmt::Display for TriggerModule {
         match self {
             TriggerModule::Alert => write!(f, "alert"),
             TriggerModule::Report => write!(f, "report"),
+            TriggerModule::Etl => write!(f, "etl"),
         }
     }
 }
@@ -164,17 +167,24 @@ pub async fn update_trigger(trigger: Trigger) -> Result<()> {
 /// - !(trigger.is_realtime && !trigger.is_silenced)
 /// - trigger.status == "Waiting"
 ///
+/// The triggers are taken in turns from each organization, the most overdue
+/// first, so that an organization with many due triggers does not delay the
+/// others.
+///
 /// `concurrency` - Defines the maximum number of jobs to pull at a time.
+/// `org_quota` - Defines the maximum number of jobs of one organization to pull
+///     at a time, no limit when 0.
 /// `timeout` - Used to set the maximum time duration the job executation can take.
 ///     This is used to calculate the `end_time` of the trigger.
 #[inline]
 pub async fn pull(
     concurrency: i64,
+    org_quota: i64,
     alert_timeout: i64,
     report_timeout: i64,
 ) -> Result<Vec<Trigger>> {
     CLIENT
-        .pull(concurrency, alert_timeout, report_timeout)
+        .pull(concurrency, org_quota, alert_timeout, report_timeout)
         .await
 }
 
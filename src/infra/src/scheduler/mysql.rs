@@ -254,6 +254,7 @@ WHERE org = ? AND module_key = ? AND module = ?;"#,
     async fn pull(
         &self,
         concurrency: i64,
+        org_quota: i64,
         alert_timeout: i64,
         report_timeout: i64,
     ) -> Result<Vec<Trigger>> {
@@ -273,12 +274,17 @@ WHERE org = ? AND module_key = ? AND module = ?;"#,
                 .unwrap();
         let mut tx = pool.begin().await?;
         let job_ids: Vec<TriggerId> = match sqlx::query_as::<_, TriggerId>(
-            r#"SELECT id
-FROM scheduled_jobs
-WHERE status = ? AND next_run_at <= ? AND retries < ? AND NOT (is_realtime = ? AND is_silenced = ?)
-ORDER BY next_run_at
+            r#"SELECT s.id
+FROM scheduled_jobs s
+JOIN (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY org ORDER BY next_run_at) AS org_rank
+    FROM scheduled_jobs
+    WHERE status = ? AND next_run_at <= ? AND retries < ? AND NOT (is_realtime = ? AND is_silenced = ?)
+) ranked ON s.id = ranked.id
+WHERE s.status = ? AND (? = 0 OR ranked.org_rank <= ?)
+ORDER BY ranked.org_rank, s.next_run_at
 LIMIT ?
-FOR UPDATE;
+FOR UPDATE OF s;
             "#,
         )
         .bind(TriggerStatus::Waiting)
@@ -286,6 +292,9 @@ FOR UPDATE;
         .bind(config::get_config().limit.scheduler_max_retries)
         .bind(true)
         .bind(false)
+        .bind(TriggerStatus::Waiting)
+        .bind(org_quota)
+        .bind(org_quota)
         .bind(concurrency)
         .fetch_all(&mut *tx)
         .await
@@ -252,6 +252,7 @@ WHERE org = $6 AND module_key = $7 AND module = $8;"#,
     async fn pull(
         &self,
         concurrency: i64,
+        org_quota: i64,
         alert_timeout: i64,
         report_timeout: i64,
     ) -> Result<Vec<Trigger>> {
@@ -275,12 +276,17 @@ SET status = $1, start_time = $2,
         ELSE $5
     END
 WHERE id IN (
-    SELECT id
-    FROM scheduled_jobs
-    WHERE status = $6 AND next_run_at <= $7 AND retries < $8 AND NOT (is_realtime = $9 AND is_silenced = $10)
-    ORDER BY next_run_at
+    SELECT s.id
+    FROM scheduled_jobs s
+    JOIN (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY org ORDER BY next_run_at) AS org_rank
+        FROM scheduled_jobs
+        WHERE status = $6 AND next_run_at <= $7 AND retries < $8 AND NOT (is_realtime = $9 AND is_silenced = $10)
+    ) ranked ON s.id = ranked.id
+    WHERE s.status = $6 AND ($12 = 0 OR ranked.org_rank <= $12)
+    ORDER BY ranked.org_rank, s.next_run_at
     LIMIT $11
-    FOR UPDATE
+    FOR UPDATE OF s
 )
 RETURNING *;"#;
 
@@ -297,6 +303,7 @@ RETURNING *;"#;
             .bind(true)
             .bind(false)
             .bind(concurrency)
+            .bind(org_quota)
             .fetch_all(&mut *tx)
             .await
         {
@@ -271,6 +271,7 @@ WHERE org = $6 AND module_key = $7 AND module = $8;"#,
     async fn pull(
         &self,
         concurrency: i64,
+        org_quota: i64,
         alert_timeout: i64,
         report_timeout: i64,
     ) -> Result<Vec<Trigger>> {
@@ -296,9 +297,13 @@ SET status = $1, start_time = $2,
     END
 WHERE id IN (
     SELECT id
-    FROM scheduled_jobs
-    WHERE status = $6 AND next_run_at <= $7 AND retries < $8 AND NOT (is_realtime = $9 AND is_silenced = $10)
-    ORDER BY next_run_at
+    FROM (
+        SELECT id, next_run_at, ROW_NUMBER() OVER (PARTITION BY org ORDER BY next_run_at) AS org_rank
+        FROM scheduled_jobs
+        WHERE status = $6 AND next_run_at <= $7 AND retries < $8 AND NOT (is_realtime = $9 AND is_silenced = $10)
+    ) ranked
+    WHERE $12 = 0 OR org_rank <= $12
+    ORDER BY org_rank, next_run_at
     LIMIT $11
 )
 RETURNING *;"#;
@@ -315,6 +320,7 @@ RETURNING *;"#;
             .bind(true)
             .bind(false)
             .bind(concurrency)
+            .bind(org_quota)
             .fetch_all(&*client)
             .await?;
         Ok(jobs)
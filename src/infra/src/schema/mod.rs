@@ -18,7 +18,7 @@ use std::collections::HashMap;
 use chrono::Utc;
 use config::{
     get_config,
-    meta::stream::{PartitionTimeLevel, StreamSettings, StreamType},
+    meta::stream::{ParquetSettings, PartitionTimeLevel, StreamSettings, StreamType},
     utils::{json, schema_ext::SchemaExt},
     RwAHashMap, BLOOM_FILTER_DEFAULT_FIELDS, SQL_FULL_TEXT_SEARCH_FIELDS,
 };
@@ -294,6 +294,10 @@ pub fn get_stream_setting_bloom_filter_fields(schema: &Schema) -> Vec<String> {
     }
 }
 
+pub fn get_stream_setting_parquet(schema: &Schema) -> Option<ParquetSettings> {
+    unwrap_stream_settings(schema).and_then(|setting| setting.parquet)
+}
+
 pub async fn merge(
     org_id: &str,
     stream_name: &str,
@@ -60,7 +60,15 @@ fn local_wal() -> Box<dyn ObjectStore> {
     Box::new(local::Local::new(&cfg.common.data_wal_dir, false))
 }
 
+/// Delays the object storage call when the fault injection asks for it
+async fn inject_delay() {
+    if let Some(delay) = config::utils::faults::storage_delay() {
+        tokio::time::sleep(delay).await;
+    }
+}
+
 pub async fn list(prefix: &str) -> Result<Vec<String>, anyhow::Error> {
+    inject_delay().await;
     let files = DEFAULT
         .list(Some(&prefix.into()))
         .map_ok(|meta| meta.location.to_string())
@@ -71,12 +79,28 @@ pub async fn list(prefix: &str) -> Result<Vec<String>, anyhow::Error> {
 }
 
 pub async fn get(file: &str) -> Result<bytes::Bytes, anyhow::Error> {
+    inject_delay().await;
     let data = DEFAULT.get(&file.into()).await?;
     let data = data.bytes().await?;
     Ok(data)
 }
 
+/// Returns the size of an object, in bytes
+pub async fn head(file: &str) -> Result<usize, anyhow::Error> {
+    inject_delay().await;
+    Ok(DEFAULT.head(&file.into()).await?.size)
+}
+
+pub async fn get_range(
+    file: &str,
+    range: std::ops::Range<usize>,
+) -> Result<bytes::Bytes, anyhow::Error> {
+    inject_delay().await;
+    Ok(DEFAULT.get_range(&file.into(), range).await?)
+}
+
 pub async fn put(file: &str, data: bytes::Bytes) -> Result<(), anyhow::Error> {
+    inject_delay().await;
     DEFAULT.put(&file.into(), data.into()).await?;
     Ok(())
 }
@@ -85,6 +109,7 @@ pub async fn del(files: &[&str]) -> Result<(), anyhow::Error> {
     if files.is_empty() {
         return Ok(());
     }
+    inject_delay().await;
 
     let start = std::time::Instant::now();
     let columns = files[0].split('/').collect::<Vec<&str>>();
@@ -116,6 +141,12 @@ pub async fn del(files: &[&str]) -> Result<(), anyhow::Error> {
     Ok(())
 }
 
+/// Reading an object of an archival storage class, like S3 Glacier Flexible
+/// Retrieval or Deep Archive, fails until a copy of it is restored
+pub fn is_archived_error(err: &str) -> bool {
+    err.contains("InvalidObjectState")
+}
+
 pub fn format_key(key: &str, with_prefix: bool) -> String {
     let cfg = get_config();
     if !is_local_disk_storage()
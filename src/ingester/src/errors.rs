@@ -104,4 +104,18 @@ pub enum Error {
         source: tokio::sync::mpsc::error::SendError<PathBuf>,
     },
     MemoryTableOverflowError {},
+    #[snafu(display("in memory data of stream {} is over its size limit", stream))]
+    StreamMemoryTableOverflowError {
+        stream: String,
+    },
+    #[snafu(display("in memory data of organization {} is over its size limit", org_id))]
+    OrgMemoryTableOverflowError {
+        org_id: String,
+    },
+    #[snafu(display("Failed to access the object storage: {}", message))]
+    StorageError {
+        message: String,
+    },
+    #[snafu(display("Failed to write the wal: fault injected"))]
+    InjectedFaultError {},
 }
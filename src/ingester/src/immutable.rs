@@ -27,9 +27,11 @@ use tokio::{
 use crate::{
     entry::PersistStat,
     errors::{DeleteFileSnafu, RenameFileSnafu, Result, TokioMpscSendSnafu, WriteDataSnafu},
+    memory,
     memtable::MemTable,
+    replication,
     rwmap::RwIndexMap,
-    writer::WriterKey,
+    writer::{isolated_pool, WriterKey},
     ReadRecordBatchEntry,
 };
 
@@ -75,6 +77,31 @@ impl Immutable {
             .persist(self.idx, &self.key.org_id, &self.key.stream_type)
             .await?;
         persist_stat.arrow_size += schema_size;
+        // keep the copy of the wal file until the parquet files are uploaded
+        let parquet_paths = paths
+            .iter()
+            .map(|(p, ..)| p.with_extension("parquet"))
+            .collect::<Vec<_>>();
+        if let Err(e) = replication::persisted(wal_path, &parquet_paths).await {
+            // the memtable is persisted again with the next round
+            let data_dir = PathBuf::from(&config::get_config().common.data_wal_dir);
+            for (path, parquet_path) in paths.iter().map(|(p, ..)| p).zip(parquet_paths.iter()) {
+                if let Err(e) = fs::remove_file(path).await {
+                    log::error!("remove file {} error: {}", path.display(), e);
+                }
+                if let Some(file_key) = parquet_path
+                    .strip_prefix(&data_dir)
+                    .ok()
+                    .and_then(|p| p.to_str())
+                {
+                    crate::WAL_PARQUET_METADATA
+                        .write()
+                        .await
+                        .remove(&file_key.replace('\\', "/"));
+                }
+            }
+            return Err(e);
+        }
         // 2. create a lock file
         let done_path = wal_path.with_extension("lock");
         let lock_data = paths
@@ -105,17 +132,44 @@ impl Immutable {
     }
 }
 
-pub(crate) async fn persist(tx: mpsc::Sender<PathBuf>) -> Result<()> {
+/// Calls `f` with the writer key and the memtable of every immutable
+pub(crate) async fn for_each_memtable(mut f: impl FnMut(&WriterKey, &MemTable)) {
+    for (_, i) in IMMUTABLES.read().await.iter() {
+        f(&i.key, &i.memtable);
+    }
+}
+
+/// Sends the immutables to the persist workers, the ones of the isolated
+/// tenants to the queues of their own workers
+pub(crate) async fn persist(
+    tx: &mpsc::Sender<PathBuf>,
+    isolated_txs: &[mpsc::Sender<PathBuf>],
+) -> Result<()> {
     let r = IMMUTABLES.read().await;
-    let n = r.len();
-    let mut paths = Vec::with_capacity(n);
-    for item in r.iter() {
-        if paths.len() >= n {
-            break;
+    let mut paths = Vec::with_capacity(r.len());
+    let mut isolated_paths = Vec::new();
+    for (path, immutable) in r.iter() {
+        match isolated_pool(immutable.idx).and_then(|pool| isolated_txs.get(pool)) {
+            Some(tx) => isolated_paths.push((path.clone(), tx)),
+            None => paths.push(path.clone()),
         }
-        paths.push(item.0.clone());
     }
     drop(r);
+    // a flood of an isolated tenant fills its own queue, the rest of its
+    // immutables wait for the next round without holding back the others
+    for (path, tx) in isolated_paths {
+        if PROCESSING_TABLES.read().await.contains(&path) {
+            continue;
+        }
+        match tx.try_send(path.clone()) {
+            Ok(()) => {}
+            Err(mpsc::error::TrySendError::Full(_)) => continue,
+            Err(mpsc::error::TrySendError::Closed(path)) => {
+                return Err(mpsc::error::SendError(path)).context(TokioMpscSendSnafu);
+            }
+        }
+        PROCESSING_TABLES.write().await.insert(path);
+    }
     for path in paths {
         // check if the file is processing
         if PROCESSING_TABLES.read().await.contains(&path) {
@@ -168,6 +222,7 @@ pub(crate) async fn persist_table(idx: usize, path: PathBuf) -> Result<()> {
     let mut rw = IMMUTABLES.write().await;
     rw.remove(&path);
     drop(rw);
+    memory::release(&immutable.key, immutable.memtable.usage());
 
     // update metrics
     metrics::INGEST_MEMTABLE_BYTES
@@ -16,20 +16,24 @@
 mod entry;
 pub mod errors;
 mod immutable;
+mod memory;
 mod memtable;
 mod partition;
+mod replication;
 mod rwmap;
 mod stream;
 mod wal;
 mod writer;
 
-use std::{path::PathBuf, sync::Arc};
+use std::{ops::Range, path::PathBuf, sync::Arc};
 
 use arrow_schema::Schema;
 use config::RwAHashMap;
 pub use entry::Entry;
 pub use immutable::read_from_immutable;
+pub use memory::{check_stream_memtable_size, memory_usage, MemoryUsage, StreamMemory};
 use once_cell::sync::Lazy;
+pub use replication::{recover as recover_wal, uploaded as wal_parquet_uploaded, RecoverStat};
 use tokio::{
     sync::{mpsc, Mutex},
     time,
@@ -62,6 +66,9 @@ pub async fn init() -> errors::Result<()> {
         }
     });
 
+    // start a job to replicate the wal files to the object storage
+    tokio::task::spawn(replication::run());
+
     // start a job to flush memtable to immutable
     tokio::task::spawn(async move {
         if let Err(e) = run().await {
@@ -75,25 +82,16 @@ async fn run() -> errors::Result<()> {
     // start persidt worker
     let cfg = config::get_config();
     let (tx, rx) = mpsc::channel::<PathBuf>(cfg.limit.mem_dump_thread_num);
-    let rx = Arc::new(Mutex::new(rx));
-    for thread_id in 0..cfg.limit.mem_dump_thread_num {
-        let rx = rx.clone();
-        tokio::spawn(async move {
-            loop {
-                let ret = rx.lock().await.recv().await;
-                match ret {
-                    None => {
-                        log::debug!("[INGESTER:MEM] Receiving memtable channel is closed");
-                        break;
-                    }
-                    Some(path) => {
-                        if let Err(e) = immutable::persist_table(thread_id, path).await {
-                            log::error!("[INGESTER:MEM:{thread_id}] Error persist memtable: {e}");
-                        }
-                    }
-                }
-            }
-        });
+    spawn_persist_workers(rx, 0..cfg.limit.mem_dump_thread_num);
+    // the isolated tenants have their own workers, so their floods don't delay
+    // the persist of the other tenants
+    let threads = cfg.limit.ingest_isolated_dump_thread_num;
+    let mut isolated_txs = Vec::with_capacity(config::INGEST_ISOLATED_TENANTS.len());
+    for pool in 0..config::INGEST_ISOLATED_TENANTS.len() {
+        let (tx, rx) = mpsc::channel::<PathBuf>(threads);
+        let first = cfg.limit.mem_dump_thread_num + pool * threads;
+        spawn_persist_workers(rx, first..first + threads);
+        isolated_txs.push(tx);
     }
 
     // start a job to dump immutable data to disk
@@ -107,13 +105,38 @@ async fn run() -> errors::Result<()> {
         }
         interval.tick().await;
         // persist immutable data to disk
-        if let Err(e) = immutable::persist(tx.clone()).await {
+        if let Err(e) = immutable::persist(&tx, &isolated_txs).await {
             log::error!("immutable persist error: {}", e);
         }
         // shrink metadata cache
         WAL_PARQUET_METADATA.write().await.shrink_to_fit();
+        // update the memory metrics of the streams
+        memory::update_metrics().await;
     }
 
     log::info!("[INGESTER:MEM] immutable persist is stopped");
     Ok(())
 }
+
+fn spawn_persist_workers(rx: mpsc::Receiver<PathBuf>, thread_ids: Range<usize>) {
+    let rx = Arc::new(Mutex::new(rx));
+    for thread_id in thread_ids {
+        let rx = rx.clone();
+        tokio::spawn(async move {
+            loop {
+                let ret = rx.lock().await.recv().await;
+                match ret {
+                    None => {
+                        log::debug!("[INGESTER:MEM] Receiving memtable channel is closed");
+                        break;
+                    }
+                    Some(path) => {
+                        if let Err(e) = immutable::persist_table(thread_id, path).await {
+                            log::error!("[INGESTER:MEM:{thread_id}] Error persist memtable: {e}");
+                        }
+                    }
+                }
+            }
+        });
+    }
+}
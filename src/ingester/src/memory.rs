@@ -0,0 +1,270 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Memory accounting of the in memory data per stream. The sizes are counted
+//! as the records are written into a memtable and released when its
+//! immutable is persisted, the age of the oldest records is read from the
+//! memtables when asked for.
+
+use std::ops::AddAssign;
+
+use chrono::Utc;
+use config::{get_config, metrics, RwHashMap, MEM_TABLE_STREAM_MAX_SIZES};
+use hashbrown::{HashMap, HashSet};
+use once_cell::sync::Lazy;
+use serde::Serialize;
+use tokio::sync::Mutex;
+
+use crate::{errors::*, immutable, writer, writer::WriterKey};
+
+/// In memory data of the streams, key: `{org_id}/{stream_type}/{stream_name}`
+static STREAMS: Lazy<RwHashMap<String, StreamUsage>> = Lazy::new(Default::default);
+
+/// In memory arrow bytes of the organizations, key: `org_id`
+static ORGS: Lazy<RwHashMap<String, i64>> = Lazy::new(Default::default);
+
+/// Streams with metrics, their labels are removed once their data is
+/// persisted
+static EXPORTED: Lazy<Mutex<HashSet<String>>> = Lazy::new(Default::default);
+
+#[derive(Clone, Copy, Debug, Default, PartialEq)]
+pub(crate) struct StreamUsage {
+    pub(crate) json_bytes: i64,
+    pub(crate) arrow_bytes: i64,
+    pub(crate) records: i64,
+    /// Time the first records were written, in microseconds
+    pub(crate) created_at: i64,
+}
+
+impl AddAssign for StreamUsage {
+    fn add_assign(&mut self, other: Self) {
+        self.json_bytes += other.json_bytes;
+        self.arrow_bytes += other.arrow_bytes;
+        self.records += other.records;
+    }
+}
+
+#[derive(Debug, Default, Serialize)]
+pub struct MemoryUsage {
+    /// Limit of the in memory data of all the streams, in bytes
+    pub max_size: usize,
+    pub json_bytes: i64,
+    pub arrow_bytes: i64,
+    /// Largest first
+    pub streams: Vec<StreamMemory>,
+}
+
+#[derive(Debug, Default, Serialize, PartialEq)]
+pub struct StreamMemory {
+    pub org_id: String,
+    pub stream_type: String,
+    pub stream_name: String,
+    pub json_bytes: i64,
+    pub arrow_bytes: i64,
+    pub records: i64,
+    /// Time the oldest in memory records were ingested, in microseconds
+    pub oldest_at: i64,
+    pub age_seconds: i64,
+    /// Limit of the in memory data of the stream, in bytes, no limit when 0
+    pub max_size: usize,
+}
+
+pub(crate) fn add(key: &WriterKey, stream_name: &str, usage: StreamUsage) {
+    *STREAMS
+        .entry(stream_key(&key.org_id, &key.stream_type, stream_name))
+        .or_default() += usage;
+    *ORGS.entry(key.org_id.to_string()).or_default() += usage.arrow_bytes;
+}
+
+/// Releases the data of a persisted memtable
+pub(crate) fn release<'a>(key: &WriterKey, streams: impl Iterator<Item = (&'a str, StreamUsage)>) {
+    let mut released = 0;
+    for (stream_name, usage) in streams {
+        released += usage.arrow_bytes;
+        let key = stream_key(&key.org_id, &key.stream_type, stream_name);
+        if let Some(mut v) = STREAMS.get_mut(&key) {
+            v.json_bytes -= usage.json_bytes;
+            v.arrow_bytes -= usage.arrow_bytes;
+            v.records -= usage.records;
+        }
+        STREAMS.remove_if(&key, |_, v| v.records <= 0);
+    }
+    if released != 0 {
+        if let Some(mut v) = ORGS.get_mut(key.org_id.as_ref()) {
+            *v -= released;
+        }
+    }
+    ORGS.remove_if(key.org_id.as_ref(), |_, v| *v <= 0);
+}
+
+/// Returns the in memory arrow bytes of an organization
+pub(crate) fn org_arrow_bytes(org_id: &str) -> i64 {
+    ORGS.get(org_id).map(|v| *v).unwrap_or_default()
+}
+
+/// Rejects the ingestion into a stream whose in memory data is over its limit
+pub fn check_stream_memtable_size(
+    org_id: &str,
+    stream_type: &str,
+    stream_name: &str,
+) -> Result<()> {
+    let max_size = stream_max_size(stream_name);
+    if max_size == 0 {
+        return Ok(());
+    }
+    let arrow_bytes = STREAMS
+        .get(&stream_key(org_id, stream_type, stream_name))
+        .map(|v| v.arrow_bytes)
+        .unwrap_or_default();
+    if arrow_bytes >= max_size as i64 {
+        return Err(Error::StreamMemoryTableOverflowError {
+            stream: stream_name.to_string(),
+        });
+    }
+    Ok(())
+}
+
+/// Returns the in memory data of every stream, largest first
+pub async fn memory_usage() -> MemoryUsage {
+    let now = Utc::now().timestamp_micros();
+    let oldest = oldest().await;
+    let mut streams: Vec<StreamMemory> = STREAMS
+        .iter()
+        .map(|v| {
+            let (org_id, stream_type, stream_name) = parse_stream_key(v.key());
+            let oldest_at = oldest.get(v.key()).copied().unwrap_or(now);
+            StreamMemory {
+                org_id: org_id.to_string(),
+                stream_type: stream_type.to_string(),
+                stream_name: stream_name.to_string(),
+                json_bytes: v.json_bytes,
+                arrow_bytes: v.arrow_bytes,
+                records: v.records,
+                oldest_at,
+                age_seconds: (now - oldest_at).max(0) / 1_000_000,
+                max_size: stream_max_size(stream_name),
+            }
+        })
+        .collect();
+    streams.sort_by(|a, b| b.arrow_bytes.cmp(&a.arrow_bytes));
+    MemoryUsage {
+        max_size: get_config().limit.mem_table_max_size,
+        json_bytes: metrics::INGEST_MEMTABLE_BYTES.with_label_values(&[]).get(),
+        arrow_bytes: metrics::INGEST_MEMTABLE_ARROW_BYTES
+            .with_label_values(&[])
+            .get(),
+        streams,
+    }
+}
+
+/// Updates the metrics of the streams, removing the ones of the streams
+/// without in memory data
+pub(crate) async fn update_metrics() {
+    let usage = memory_usage().await;
+    let mut exported = EXPORTED.lock().await;
+    let mut current = HashSet::with_capacity(usage.streams.len());
+    for s in usage.streams {
+        let labels = [
+            s.org_id.as_str(),
+            s.stream_name.as_str(),
+            s.stream_type.as_str(),
+        ];
+        metrics::INGEST_MEMTABLE_STREAM_BYTES
+            .with_label_values(&labels)
+            .set(s.arrow_bytes);
+        metrics::INGEST_MEMTABLE_STREAM_RECORDS
+            .with_label_values(&labels)
+            .set(s.records);
+        metrics::INGEST_MEMTABLE_STREAM_AGE_SECONDS
+            .with_label_values(&labels)
+            .set(s.age_seconds);
+        current.insert(stream_key(&s.org_id, &s.stream_type, &s.stream_name));
+    }
+    for key in exported.difference(&current) {
+        let (org_id, stream_type, stream_name) = parse_stream_key(key);
+        let labels = [org_id, stream_name, stream_type];
+        let _ = metrics::INGEST_MEMTABLE_STREAM_BYTES.remove_label_values(&labels);
+        let _ = metrics::INGEST_MEMTABLE_STREAM_RECORDS.remove_label_values(&labels);
+        let _ = metrics::INGEST_MEMTABLE_STREAM_AGE_SECONDS.remove_label_values(&labels);
+    }
+    *exported = current;
+}
+
+/// Returns the time the oldest in memory records of every stream were
+/// written
+async fn oldest() -> HashMap<String, i64> {
+    let mut oldest: HashMap<String, i64> = HashMap::new();
+    let mut collect = |key: &WriterKey, memtable: &crate::memtable::MemTable| {
+        for (stream_name, usage) in memtable.usage() {
+            let created_at = oldest
+                .entry(stream_key(&key.org_id, &key.stream_type, stream_name))
+                .or_insert(usage.created_at);
+            *created_at = (*created_at).min(usage.created_at);
+        }
+    };
+    writer::for_each_memtable(&mut collect).await;
+    immutable::for_each_memtable(&mut collect).await;
+    oldest
+}
+
+/// Returns the limit of the in memory data of a stream in bytes, 0 for none
+fn stream_max_size(stream_name: &str) -> usize {
+    MEM_TABLE_STREAM_MAX_SIZES
+        .get(stream_name)
+        .copied()
+        .unwrap_or(get_config().limit.mem_table_stream_max_size)
+}
+
+fn stream_key(org_id: &str, stream_type: &str, stream_name: &str) -> String {
+    format!("{org_id}/{stream_type}/{stream_name}")
+}
+
+fn parse_stream_key(key: &str) -> (&str, &str, &str) {
+    let mut columns = key.splitn(3, '/');
+    let org_id = columns.next().unwrap_or_default();
+    let stream_type = columns.next().unwrap_or_default();
+    let stream_name = columns.next().unwrap_or_default();
+    (org_id, stream_type, stream_name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_add_and_release() {
+        let key = WriterKey::new("test_memory", "logs");
+        let usage = StreamUsage {
+            json_bytes: 100,
+            arrow_bytes: 80,
+            records: 2,
+            created_at: 1,
+        };
+        add(&key, "app", usage);
+        add(&key, "app", usage);
+        let stored = *STREAMS.get("test_memory/logs/app").unwrap();
+        assert_eq!(stored.arrow_bytes, 160);
+        assert_eq!(stored.records, 4);
+
+        assert_eq!(org_arrow_bytes("test_memory"), 160);
+
+        release(&key, [("app", usage)].into_iter());
+        assert_eq!(STREAMS.get("test_memory/logs/app").unwrap().records, 2);
+        assert_eq!(org_arrow_bytes("test_memory"), 80);
+        release(&key, [("app", usage)].into_iter());
+        assert!(STREAMS.get("test_memory/logs/app").is_none());
+        assert_eq!(org_arrow_bytes("test_memory"), 0);
+    }
+}
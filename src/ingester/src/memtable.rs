@@ -28,6 +28,7 @@ use hashbrown::HashMap;
 use crate::{
     entry::{Entry, PersistStat, RecordBatchEntry},
     errors::Result,
+    memory::StreamUsage,
     stream::Stream,
     ReadRecordBatchEntry,
 };
@@ -48,12 +49,13 @@ impl MemTable {
         }
     }
 
+    /// Returns the size of the written data
     pub(crate) fn write(
         &mut self,
         schema: Arc<Schema>,
         entry: Entry,
         batch: Option<Arc<RecordBatchEntry>>,
-    ) -> Result<()> {
+    ) -> Result<StreamUsage> {
         let partitions = match self.streams.get_mut(&entry.stream) {
             Some(v) => v,
             None => self
@@ -62,12 +64,18 @@ impl MemTable {
                 .or_insert_with(Stream::new),
         };
         let json_size = entry.data_size;
+        let records = entry.data.len();
         let arrow_size = partitions.write(schema, entry, batch)?;
         self.json_bytes_written
             .fetch_add(json_size as u64, Ordering::SeqCst);
         self.arrow_bytes_written
             .fetch_add(arrow_size as u64, Ordering::SeqCst);
-        Ok(())
+        Ok(StreamUsage {
+            json_bytes: json_size as i64,
+            arrow_bytes: arrow_size as i64,
+            records: records as i64,
+            created_at: 0,
+        })
     }
 
     pub(crate) fn read(
@@ -99,6 +107,13 @@ impl MemTable {
         Ok((schema_size, paths))
     }
 
+    /// Returns the size of the data of every stream
+    pub(crate) fn usage(&self) -> impl Iterator<Item = (&str, StreamUsage)> {
+        self.streams
+            .iter()
+            .map(|(name, stream)| (name.as_ref(), stream.usage()))
+    }
+
     // Return the number of bytes written (json format size, arrow format size)
     pub(crate) fn size(&self) -> (usize, usize) {
         (
@@ -117,6 +117,7 @@ impl Partition {
                 } else {
                     (vec![], vec![])
                 };
+            let parquet_settings = infra::schema::get_stream_setting_parquet(self.schema.as_ref());
             let mut buf_parquet = Vec::new();
             let mut writer = new_parquet_writer(
                 &mut buf_parquet,
@@ -124,6 +125,7 @@ impl Partition {
                 &bloom_filter_fields,
                 &full_text_search_fields,
                 &file_meta,
+                parquet_settings.as_ref(),
             );
             for batch in data.data.iter() {
                 persist_stat.arrow_size += batch.data_arrow_size;
@@ -0,0 +1,479 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Replication of the wal files to the object storage.
+//!
+//! A copy of every wal file is kept in `wal/{node_uuid}/{idx}/{org_id}/
+//! {stream_type}/`: the active ones are copied on every run, with what was
+//! written so far, and a sealed one is copied for the last time at the latest
+//! before its memtable is persisted. The persist then writes the list of its
+//! parquet files next to the copy, as `{id}.files`, and the parquet files are
+//! removed from the list as they are uploaded. The copy is deleted with the
+//! last of them.
+//!
+//! When an ingester loses its disk, another ingester recovers its copies:
+//! they are downloaded into its own wal dir and replayed like its own wal
+//! files. Of a persisted wal file, only the entries of the parquet files left
+//! in its list are replayed, so the data uploaded before the crash is not
+//! ingested twice. The records written in the last replication interval, and
+//! the ones of a parquet file uploaded right before the crash, before it was
+//! removed from the list, can be lost or ingested twice.
+
+use std::path::{Path, PathBuf};
+
+use bytes::Bytes;
+use config::cluster::LOCAL_NODE_UUID;
+use hashbrown::{HashMap, HashSet};
+use once_cell::sync::Lazy;
+use serde::Serialize;
+use snafu::ResultExt;
+use tokio::{fs, sync::Mutex};
+
+use crate::{errors::*, immutable::IMMUTABLES, wal::replay_wal_file, writer};
+
+/// Prefix of the copies in the object storage
+const STORAGE_PREFIX: &str = "wal";
+/// Extension of the list of the parquet files of a persisted wal file
+const FILES_EXT: &str = "files";
+
+/// Replicated wal files of this node
+static REPLICATED: Lazy<Mutex<Replicated>> = Lazy::new(Default::default);
+
+#[derive(Default)]
+struct Replicated {
+    /// Active wal files, with the bytes copied
+    active: HashMap<PathBuf, usize>,
+    /// Sealed wal files copied for the last time
+    sealed: HashSet<PathBuf>,
+    /// Persisted wal files, with their parquet files not uploaded yet
+    persisted: HashMap<PathBuf, Vec<String>>,
+}
+
+#[derive(Debug, Default, Serialize)]
+pub struct RecoverStat {
+    pub node_uuid: String,
+    /// Wal files replayed on this node
+    pub files: usize,
+    pub records: usize,
+    /// Wal files already on this node, left in the object storage
+    pub skipped: usize,
+}
+
+fn is_enabled() -> bool {
+    config::get_config().common.wal_replication_enabled
+        && config::cluster::is_ingester(&config::cluster::LOCAL_NODE_ROLE)
+}
+
+pub(crate) async fn run() {
+    if !is_enabled() {
+        return;
+    }
+    if let Err(e) = load_replicated().await {
+        log::error!(
+            "[INGESTER:REPLICATION] load replicated wal files error: {}",
+            e
+        );
+    }
+    let mut interval = tokio::time::interval(tokio::time::Duration::from_secs(
+        config::get_config().limit.wal_replication_interval,
+    ));
+    interval.tick().await; // the first tick is immediate
+    loop {
+        if config::cluster::is_offline() {
+            break;
+        }
+        interval.tick().await;
+        if let Err(e) = replicate().await {
+            log::error!("[INGESTER:REPLICATION] replicate wal files error: {}", e);
+        }
+    }
+    log::info!("[INGESTER:REPLICATION] wal replication is stopped");
+}
+
+/// Tracks the copies left by the previous run of this node. The wal files
+/// still on the disk are replayed on start and copied again, the lists of
+/// the persisted ones are updated with the parquet files uploaded since
+async fn load_replicated() -> Result<()> {
+    let data_dir = PathBuf::from(&config::get_config().common.data_wal_dir);
+    let wal_dir = wal_dir();
+    let prefix = storage_prefix(&LOCAL_NODE_UUID);
+    let keys = list(&prefix).await?;
+    let mut replicated = REPLICATED.lock().await;
+    for key in keys.iter() {
+        let Some(file) = key.strip_prefix(&prefix) else {
+            continue;
+        };
+        let path = wal_dir.join(file).with_extension("wal");
+        if file.ends_with(".wal") {
+            // persisted without parquet files
+            if !path.exists() && !keys.contains(&files_key(key)) {
+                delete_copy(&path).await?;
+            }
+            continue;
+        }
+        let mut files = get_files(key).await?;
+        let len = files.len();
+        files.retain(|f| data_dir.join(f).exists());
+        if files.is_empty() {
+            delete_copy(&path).await?;
+        } else {
+            if files.len() != len {
+                put_files(&path, &files).await?;
+            }
+            replicated.persisted.insert(path, files);
+        }
+    }
+    Ok(())
+}
+
+/// Copies the active wal files written since the last run, and the sealed
+/// ones not copied since they were sealed. Deletes the copies left by a
+/// failed deletion.
+async fn replicate() -> Result<()> {
+    let active = writer::wal_files().await;
+    let sealed: Vec<PathBuf> = IMMUTABLES.read().await.keys().cloned().collect();
+    let mut replicated = REPLICATED.lock().await;
+    replicated
+        .active
+        .retain(|path, _| active.iter().any(|(p, _)| p == path));
+    for (path, size) in active {
+        if size <= wal::FILE_TYPE_IDENTIFIER_LEN || replicated.active.get(&path) == Some(&size) {
+            continue;
+        }
+        // rotated in the meantime
+        let Ok(mut data) = fs::read(&path).await else {
+            continue;
+        };
+        // the rest of the file is preallocated
+        data.truncate(size);
+        put_copy(&path, data).await?;
+        replicated.active.insert(path, size);
+    }
+    let done = replicated
+        .persisted
+        .iter()
+        .filter(|(_, files)| files.is_empty())
+        .map(|(path, _)| path.clone())
+        .collect::<Vec<_>>();
+    for path in done {
+        delete_copy(&path).await?;
+        replicated.persisted.remove(&path);
+    }
+    for path in sealed {
+        if let Err(e) = copy_sealed(&mut replicated, &path).await {
+            log::error!(
+                "[INGESTER:REPLICATION] copy wal file {} error: {}",
+                path.display(),
+                e
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Copies the whole sealed wal file, unless it was already
+async fn copy_sealed(replicated: &mut Replicated, path: &Path) -> Result<()> {
+    if replicated.sealed.contains(path) || replicated.persisted.contains_key(path) {
+        return Ok(());
+    }
+    let data = fs::read(path).await.context(ReadFileSnafu { path })?;
+    put_copy(path, data).await?;
+    replicated.active.remove(path);
+    replicated.sealed.insert(path.to_path_buf());
+    Ok(())
+}
+
+/// Makes sure the wal file is copied before its memtable is persisted into
+/// the parquet files, and lists them next to the copy. Called before the
+/// wal file is deleted, an error fails the persist.
+pub(crate) async fn persisted(wal_path: &Path, parquet_files: &[PathBuf]) -> Result<()> {
+    if !is_enabled() {
+        return Ok(());
+    }
+    let data_dir = PathBuf::from(&config::get_config().common.data_wal_dir);
+    let files: Vec<String> = parquet_files
+        .iter()
+        .filter_map(|p| relative_key(&data_dir, p))
+        .collect();
+    let mut replicated = REPLICATED.lock().await;
+    if files.is_empty() {
+        delete_copy(wal_path).await?;
+    } else {
+        copy_sealed(&mut replicated, wal_path).await?;
+        put_files(wal_path, &files).await?;
+        replicated.persisted.insert(wal_path.to_path_buf(), files);
+    }
+    replicated.sealed.remove(wal_path);
+    Ok(())
+}
+
+/// Removes the uploaded parquet files, given by their path under the data
+/// dir, from the lists of the persisted wal files, and deletes the copies
+/// whose parquet files are all uploaded. Called by the upload before the
+/// parquet files are deleted from the disk.
+pub async fn uploaded(parquet_files: &[String]) {
+    if !is_enabled() {
+        return;
+    }
+    let mut replicated = REPLICATED.lock().await;
+    let mut changed = Vec::new();
+    for (path, files) in replicated.persisted.iter_mut() {
+        let len = files.len();
+        files.retain(|f| !parquet_files.contains(f));
+        if files.len() != len {
+            changed.push(path.clone());
+        }
+    }
+    for path in changed {
+        let files = &replicated.persisted[&path];
+        let ret = if files.is_empty() {
+            delete_copy(&path).await
+        } else {
+            put_files(&path, files).await
+        };
+        match ret {
+            Ok(()) if files.is_empty() => {
+                replicated.persisted.remove(&path);
+            }
+            Ok(()) => {}
+            // a stale list only replays more entries, a copy left is deleted
+            // with the next run of the replication
+            Err(e) => log::error!(
+                "[INGESTER:REPLICATION] update the copy of {} error: {}",
+                path.display(),
+                e
+            ),
+        }
+    }
+    replicated.persisted.shrink_to_fit();
+}
+
+/// Downloads the wal files replicated by a lost node and replays them on this
+/// node, their copies are deleted once replayed
+pub async fn recover(node_uuid: &str) -> Result<RecoverStat> {
+    // two nodes recovering the same node would replay its files twice
+    let lock_key = format!("/ingester/wal_recover/{node_uuid}");
+    let locker = infra::dist_lock::lock(&lock_key, 0)
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })?;
+    let ret = recover_files(node_uuid).await;
+    if let Err(e) = infra::dist_lock::unlock(&locker).await {
+        log::error!("[INGESTER:REPLICATION] unlock {lock_key} error: {}", e);
+    }
+    ret
+}
+
+async fn recover_files(node_uuid: &str) -> Result<RecoverStat> {
+    let wal_dir = wal_dir();
+    let prefix = storage_prefix(node_uuid);
+    let keys = list(&prefix).await?;
+    let mut stat = RecoverStat {
+        node_uuid: node_uuid.to_string(),
+        ..Default::default()
+    };
+    for key in keys.iter() {
+        let Some(file) = key.strip_prefix(&prefix).filter(|f| f.ends_with(".wal")) else {
+            continue;
+        };
+        let path = wal_dir.join(file);
+        if path.exists() {
+            log::warn!("[INGESTER:REPLICATION] skip recovering existing wal file: {key}");
+            stat.skipped += 1;
+            continue;
+        }
+        // of a persisted wal file, only the entries of the parquet files not
+        // uploaded are replayed
+        let files_key = files_key(key);
+        let groups = if keys.contains(&files_key) {
+            let files = get_files(&files_key).await?;
+            Some(
+                files
+                    .iter()
+                    .filter_map(|f| parquet_group(f))
+                    .collect::<HashSet<_>>(),
+            )
+        } else {
+            None
+        };
+        let data = infra::storage::get(key)
+            .await
+            .map_err(|e| Error::StorageError {
+                message: e.to_string(),
+            })?;
+        if let Some(dir) = path.parent() {
+            fs::create_dir_all(dir)
+                .await
+                .context(OpenDirSnafu { path: dir })?;
+        }
+        fs::write(&path, &data)
+            .await
+            .context(WriteFileSnafu { path: &path })?;
+        stat.records += replay_wal_file(&wal_dir, &path, groups.as_ref()).await?;
+        stat.files += 1;
+        infra::storage::del(&[key.as_str(), files_key.as_str()])
+            .await
+            .map_err(|e| Error::StorageError {
+                message: e.to_string(),
+            })?;
+        log::warn!("[INGESTER:REPLICATION] recovered wal file: {key}");
+    }
+    Ok(stat)
+}
+
+async fn list(prefix: &str) -> Result<Vec<String>> {
+    infra::storage::list(prefix)
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })
+}
+
+async fn put_copy(path: &Path, data: Vec<u8>) -> Result<()> {
+    let Some(key) = storage_key(&wal_dir(), path, &LOCAL_NODE_UUID) else {
+        return Ok(());
+    };
+    infra::storage::put(&key, Bytes::from(data))
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })?;
+    log::debug!("[INGESTER:REPLICATION] uploaded wal file: {}", key);
+    Ok(())
+}
+
+async fn get_files(files_key: &str) -> Result<Vec<String>> {
+    let data = infra::storage::get(files_key)
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })?;
+    serde_json::from_slice(&data).context(JSONSerializationSnafu)
+}
+
+async fn put_files(path: &Path, files: &[String]) -> Result<()> {
+    let Some(key) = storage_key(&wal_dir(), path, &LOCAL_NODE_UUID) else {
+        return Ok(());
+    };
+    let data = serde_json::to_vec(files).context(JSONSerializationSnafu)?;
+    infra::storage::put(&files_key(&key), Bytes::from(data))
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })
+}
+
+/// Deletes the copy of the wal file and the list of its parquet files
+async fn delete_copy(path: &Path) -> Result<()> {
+    let Some(key) = storage_key(&wal_dir(), path, &LOCAL_NODE_UUID) else {
+        return Ok(());
+    };
+    let files_key = files_key(&key);
+    infra::storage::del(&[key.as_str(), files_key.as_str()])
+        .await
+        .map_err(|e| Error::StorageError {
+            message: e.to_string(),
+        })
+}
+
+fn wal_dir() -> PathBuf {
+    PathBuf::from(&config::get_config().common.data_wal_dir).join("logs")
+}
+
+fn storage_prefix(node_uuid: &str) -> String {
+    format!("{STORAGE_PREFIX}/{node_uuid}/")
+}
+
+/// Returns the key of the copy of a wal file, e.g.
+/// `wal/{node_uuid}/0/default/logs/7099303408192061440.wal`
+fn storage_key(wal_dir: &Path, path: &Path, node_uuid: &str) -> Option<String> {
+    Some(format!(
+        "{}{}",
+        storage_prefix(node_uuid),
+        relative_key(wal_dir, path)?
+    ))
+}
+
+/// Returns the key of the list of the parquet files next to the copy
+fn files_key(key: &str) -> String {
+    format!("{}.{FILES_EXT}", key.strip_suffix(".wal").unwrap_or(key))
+}
+
+/// Returns the path under the dir with `/` separators, as the parquet files
+/// are known to the upload, e.g. `files/default/logs/app/0/2024/10/17/08/x.parquet`
+fn relative_key(dir: &Path, path: &Path) -> Option<String> {
+    Some(
+        path.strip_prefix(dir)
+            .ok()?
+            .to_str()?
+            .replace('\\', "/")
+            .trim_start_matches('/')
+            .to_string(),
+    )
+}
+
+/// Returns the group of the wal entries written into a parquet file, see
+/// `entry_group`, from its path `files/{org_id}/{stream_type}/{stream}/{idx}/
+/// {partition_key}/{file}`
+fn parquet_group(file: &str) -> Option<String> {
+    let parts = file.split('/').collect::<Vec<_>>();
+    if parts.len() < 6 || parts[0] != "files" {
+        return None;
+    }
+    Some(entry_group(parts[3], &parts[5..parts.len() - 1].join("/")))
+}
+
+/// Returns the group of a wal entry, the entries of a group are persisted
+/// into the same parquet file
+pub(crate) fn entry_group(stream: &str, partition_key: &str) -> String {
+    format!("{stream}/{}", partition_key.trim_matches('/'))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_storage_key() {
+        let wal_dir = PathBuf::from("/data/wal/logs");
+        assert_eq!(
+            storage_key(&wal_dir, &wal_dir.join("0/default/logs/1.wal"), "n1").as_deref(),
+            Some("wal/n1/0/default/logs/1.wal")
+        );
+        assert_eq!(storage_key(&wal_dir, Path::new("/tmp/1.wal"), "n1"), None);
+        assert_eq!(
+            files_key("wal/n1/0/default/logs/1.wal"),
+            "wal/n1/0/default/logs/1.files"
+        );
+    }
+
+    #[test]
+    fn test_parquet_group() {
+        assert_eq!(
+            parquet_group("files/default/logs/app/0/2024/10/17/08/country=US/1.parquet").as_deref(),
+            Some("app/2024/10/17/08/country=US")
+        );
+        assert_eq!(
+            parquet_group("files/default/logs/app/0/2024/10/17/08/country=US/1.parquet"),
+            Some(entry_group("app", "2024/10/17/08/country=US"))
+        );
+        assert_eq!(
+            parquet_group("files/default/logs/app/0/1.parquet").as_deref(),
+            Some("app/")
+        );
+        assert_eq!(parquet_group("logs/0/default/logs/1.wal"), None);
+    }
+}
@@ -16,23 +16,30 @@
 use std::{collections::BTreeMap, path::PathBuf, sync::Arc};
 
 use arrow_schema::Schema;
+use chrono::Utc;
 use config::utils::schema_ext::SchemaExt;
 
 use crate::{
     entry::{Entry, PersistStat, RecordBatchEntry},
     errors::*,
+    memory::StreamUsage,
     partition::Partition,
     ReadRecordBatchEntry,
 };
 
 pub(crate) struct Stream {
     partitions: BTreeMap<Arc<str>, Partition>, // key: schema hash, val: partitions
+    usage: StreamUsage,
 }
 
 impl Stream {
     pub(crate) fn new() -> Self {
         Self {
             partitions: BTreeMap::default(),
+            usage: StreamUsage {
+                created_at: Utc::now().timestamp_micros(),
+                ..Default::default()
+            },
         }
     }
 
@@ -42,6 +49,8 @@ impl Stream {
         entry: Entry,
         batch: Option<Arc<RecordBatchEntry>>,
     ) -> Result<usize> {
+        let json_size = entry.data_size;
+        let records = entry.data.len();
         let mut arrow_size = 0;
         let partition = match self.partitions.get_mut(&entry.stream) {
             Some(v) => v,
@@ -53,9 +62,16 @@ impl Stream {
             }
         };
         arrow_size += partition.write(entry, batch)?;
+        self.usage.json_bytes += json_size as i64;
+        self.usage.arrow_bytes += arrow_size as i64;
+        self.usage.records += records as i64;
         Ok(arrow_size)
     }
 
+    pub(crate) fn usage(&self) -> StreamUsage {
+        self.usage
+    }
+
     pub(crate) fn read(&self, time_range: Option<(i64, i64)>) -> Result<Vec<ReadRecordBatchEntry>> {
         let mut batches = Vec::with_capacity(self.partitions.len());
         for partition in self.partitions.values() {
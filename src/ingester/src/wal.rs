@@ -16,16 +16,17 @@
 use std::{
     fs::{create_dir_all, File},
     io::{BufRead, BufReader},
-    path::PathBuf,
+    path::{Path, PathBuf},
     sync::Arc,
 };
 
 use async_walkdir::WalkDir;
 use config::utils::{schema::infer_json_schema_from_values, schema_ext::SchemaExt};
 use futures::StreamExt;
+use hashbrown::HashSet;
 use snafu::ResultExt;
 
-use crate::{errors::*, immutable, memtable, writer::WriterKey};
+use crate::{errors::*, immutable, memory, memtable, replication, writer::WriterKey};
 
 // check uncompleted parquet files
 // the wal file process have 4 steps:
@@ -112,106 +113,130 @@ pub(crate) async fn replay_wal_files() -> Result<()> {
         return Ok(());
     }
     for wal_file in wal_files.iter() {
-        log::warn!("starting replay wal file: {:?}", wal_file);
-        let file_str = wal_file
-            .strip_prefix(&wal_dir)
-            .unwrap()
-            .to_str()
-            .unwrap()
-            .replace('\\', "/")
-            .to_string();
-        let file_columns = file_str.split('/').collect::<Vec<_>>();
-        let stream_type = file_columns[file_columns.len() - 2];
-        let org_id = file_columns[file_columns.len() - 3];
-        let idx: usize = file_columns[file_columns.len() - 4]
-            .parse()
-            .unwrap_or_default();
-        let key = WriterKey::new(org_id, stream_type);
-        let mut memtable = memtable::MemTable::new();
-        let mut reader = match wal::Reader::from_path(wal_file) {
-            Ok(v) => v,
-            Err(e) => {
-                log::error!("Unable to open the wal file err: {}, skip", e);
+        replay_wal_file(&wal_dir, wal_file, None).await?;
+    }
+
+    Ok(())
+}
+
+// replay a wal file under the wal dir to create an immutable, returns the
+// number of records. when `groups` is given, only the entries of these groups
+// are replayed, see `replication::entry_group`
+pub(crate) async fn replay_wal_file(
+    wal_dir: &Path,
+    wal_file: &PathBuf,
+    groups: Option<&HashSet<String>>,
+) -> Result<usize> {
+    log::warn!("starting replay wal file: {:?}", wal_file);
+    let file_str = wal_file
+        .strip_prefix(wal_dir)
+        .unwrap()
+        .to_str()
+        .unwrap()
+        .replace('\\', "/")
+        .to_string();
+    let file_columns = file_str.split('/').collect::<Vec<_>>();
+    let stream_type = file_columns[file_columns.len() - 2];
+    let org_id = file_columns[file_columns.len() - 3];
+    let idx: usize = file_columns[file_columns.len() - 4]
+        .parse()
+        .unwrap_or_default();
+    let key = WriterKey::new(org_id, stream_type);
+    let mut memtable = memtable::MemTable::new();
+    let mut reader = match wal::Reader::from_path(wal_file) {
+        Ok(v) => v,
+        Err(e) => {
+            log::error!("Unable to open the wal file err: {}, skip", e);
+            return Ok(0);
+        }
+    };
+    let mut total = 0;
+    let mut i = 0;
+    loop {
+        if i > 0 && i % 1000 == 0 {
+            log::warn!(
+                "replay wal file: {:?}, entries: {}, records: {}",
+                wal_file,
+                i,
+                total
+            );
+        }
+        let entry = match reader.read_entry() {
+            Ok(entry) => entry,
+            Err(wal::Error::UnableToReadData { source }) => {
+                log::error!("Unable to read entry from: {}, skip the entry", source);
                 continue;
             }
-        };
-        let mut total = 0;
-        let mut i = 0;
-        loop {
-            if i > 0 && i % 1000 == 0 {
-                log::warn!(
-                    "replay wal file: {:?}, entries: {}, records: {}",
-                    wal_file,
-                    i,
-                    total
+            Err(wal::Error::LengthMismatch { expected, actual }) => {
+                log::error!(
+                    "Unable to read entry: Length mismatch: expected {}, actual {}, skip the entry",
+                    expected,
+                    actual
+                );
+                continue;
+            }
+            Err(wal::Error::ChecksumMismatch { expected, actual }) => {
+                log::error!(
+                    "Unable to read entry: Checksum mismatch: expected {}, actual {}, skip the entry",
+                    expected,
+                    actual
                 );
+                continue;
+            }
+            Err(e) => {
+                return Err(Error::WalError { source: e });
             }
-            let entry = match reader.read_entry() {
-                Ok(entry) => entry,
-                Err(wal::Error::UnableToReadData { source }) => {
-                    log::error!("Unable to read entry from: {}, skip the entry", source);
-                    continue;
-                }
-                Err(wal::Error::LengthMismatch { expected, actual }) => {
-                    log::error!(
-                        "Unable to read entry: Length mismatch: expected {}, actual {}, skip the entry",
-                        expected,
-                        actual
-                    );
-                    continue;
-                }
-                Err(wal::Error::ChecksumMismatch { expected, actual }) => {
-                    log::error!(
-                        "Unable to read entry: Checksum mismatch: expected {}, actual {}, skip the entry",
-                        expected,
-                        actual
-                    );
-                    continue;
-                }
-                Err(e) => {
-                    return Err(Error::WalError { source: e });
-                }
-            };
-            let Some(entry_bytes) = entry else {
-                break;
-            };
-            let mut entry = match super::Entry::from_bytes(&entry_bytes) {
-                Ok(v) => v,
-                Err(Error::ReadDataError { source }) => {
-                    log::error!("Unable to read entry from: {}, skip the entry", source);
-                    continue;
-                }
-                Err(e) => {
-                    return Err(e);
-                }
-            };
-            i += 1;
-            total += entry.data.len();
-            let infer_schema =
-                infer_json_schema_from_values(entry.data.iter().cloned(), stream_type)
-                    .context(InferJsonSchemaSnafu)?;
-            let infer_schema = Arc::new(infer_schema);
-            entry.schema_key = infer_schema.hash_key().into();
-            let batch = entry.into_batch(infer_schema.clone())?;
-            memtable.write(infer_schema, entry, batch)?;
+        };
+        let Some(entry_bytes) = entry else {
+            break;
+        };
+        let mut entry = match super::Entry::from_bytes(&entry_bytes) {
+            Ok(v) => v,
+            Err(Error::ReadDataError { source }) => {
+                log::error!("Unable to read entry from: {}, skip the entry", source);
+                continue;
+            }
+            Err(e) => {
+                return Err(e);
+            }
+        };
+        if groups.is_some_and(|groups| {
+            !groups.contains(&replication::entry_group(
+                &entry.stream,
+                &entry.partition_key,
+            ))
+        }) {
+            continue;
         }
-        log::warn!(
-            "replay wal file: {:?}, entries: {}, records: {}",
-            wal_file,
-            i,
-            total
-        );
-
-        immutable::IMMUTABLES.write().await.insert(
-            wal_file.to_owned(),
-            Arc::new(immutable::Immutable::new(idx, key, memtable)),
-        );
+        i += 1;
+        total += entry.data.len();
+        let infer_schema = infer_json_schema_from_values(entry.data.iter().cloned(), stream_type)
+            .context(InferJsonSchemaSnafu)?;
+        let infer_schema = Arc::new(infer_schema);
+        entry.schema_key = infer_schema.hash_key().into();
+        let batch = entry.into_batch(infer_schema.clone())?;
+        let stream = entry.stream.clone();
+        let usage = memtable.write(infer_schema, entry, batch)?;
+        memory::add(&key, &stream, usage);
     }
+    log::warn!(
+        "replay wal file: {:?}, entries: {}, records: {}",
+        wal_file,
+        i,
+        total
+    );
 
-    Ok(())
+    immutable::IMMUTABLES.write().await.insert(
+        wal_file.to_owned(),
+        Arc::new(immutable::Immutable::new(idx, key, memtable)),
+    );
+    Ok(total)
 }
 
-async fn wal_scan_files(root_dir: impl Into<PathBuf>, ext: &str) -> Result<Vec<PathBuf>> {
+pub(crate) async fn wal_scan_files(
+    root_dir: impl Into<PathBuf>,
+    ext: &str,
+) -> Result<Vec<PathBuf>> {
     Ok(WalkDir::new(root_dir.into())
         .filter_map(|entry| async move {
             let entry = entry.ok()?;
@@ -26,7 +26,7 @@ use chrono::{Duration, Utc};
 use config::{
     get_config, metrics,
     utils::hash::{gxhash, Sum64},
-    MEM_TABLE_INDIVIDUAL_STREAMS,
+    INGEST_ISOLATED_TENANTS, MEM_TABLE_INDIVIDUAL_STREAMS,
 };
 use once_cell::sync::Lazy;
 use snafu::ResultExt;
@@ -37,6 +37,7 @@ use crate::{
     entry::Entry,
     errors::*,
     immutable::{Immutable, IMMUTABLES},
+    memory,
     memtable::MemTable,
     rwmap::RwMap,
     ReadRecordBatchEntry,
@@ -44,7 +45,9 @@ use crate::{
 
 static WRITERS: Lazy<Vec<RwMap<WriterKey, Arc<Writer>>>> = Lazy::new(|| {
     let cfg = get_config();
-    let writer_num = cfg.limit.mem_table_bucket_num + MEM_TABLE_INDIVIDUAL_STREAMS.len();
+    let writer_num = cfg.limit.mem_table_bucket_num
+        + MEM_TABLE_INDIVIDUAL_STREAMS.len()
+        + INGEST_ISOLATED_TENANTS.len();
     let mut writers = Vec::with_capacity(writer_num);
     for _ in 0..writer_num {
         writers.push(RwMap::default());
@@ -61,26 +64,54 @@ pub struct Writer {
     created_at: AtomicI64,
 }
 
-// check total memory size
-pub fn check_memtable_size() -> Result<()> {
+// check total memory size and the one of the organization
+pub fn check_memtable_size(org_id: &str) -> Result<()> {
+    let cfg = get_config();
     let total_mem_size = metrics::INGEST_MEMTABLE_ARROW_BYTES
         .with_label_values(&[])
         .get();
-    if total_mem_size >= get_config().limit.mem_table_max_size as i64 {
-        Err(Error::MemoryTableOverflowError {})
-    } else {
-        Ok(())
+    if total_mem_size >= cfg.limit.mem_table_max_size as i64 {
+        return Err(Error::MemoryTableOverflowError {});
+    }
+    let org_max_size = cfg.limit.mem_table_org_max_size;
+    if org_max_size > 0 && memory::org_arrow_bytes(org_id) >= org_max_size as i64 {
+        return Err(Error::OrgMemoryTableOverflowError {
+            org_id: org_id.to_string(),
+        });
+    }
+    Ok(())
+}
+
+/// Returns the index of the writer of a stream: the dedicated one of an
+/// isolated tenant or an individual stream, else a bucket by the hash of the
+/// stream name
+fn writer_idx(org_id: &str, stream_name: &str) -> usize {
+    if let Some(idx) = INGEST_ISOLATED_TENANTS
+        .get(&format!("{org_id}/{stream_name}"))
+        .or_else(|| INGEST_ISOLATED_TENANTS.get(org_id))
+    {
+        return *idx;
     }
+    if let Some(idx) = MEM_TABLE_INDIVIDUAL_STREAMS.get(stream_name) {
+        return *idx;
+    }
+    let hash_id = gxhash::new().sum64(stream_name);
+    hash_id as usize
+        % (WRITERS.len() - MEM_TABLE_INDIVIDUAL_STREAMS.len() - INGEST_ISOLATED_TENANTS.len())
+}
+
+/// Returns the isolated tenant a writer is dedicated to, by its position in
+/// ZO_INGEST_ISOLATED_TENANTS, None for the shared writers
+pub(crate) fn isolated_pool(idx: usize) -> Option<usize> {
+    let first = *INGEST_ISOLATED_TENANTS.values().min()?;
+    (first..first + INGEST_ISOLATED_TENANTS.len())
+        .contains(&idx)
+        .then(|| idx - first)
 }
 
 /// Get a writer for a given org_id and stream_type
 pub async fn get_writer(org_id: &str, stream_type: &str, stream_name: &str) -> Arc<Writer> {
-    let idx = if let Some(idx) = MEM_TABLE_INDIVIDUAL_STREAMS.get(stream_name) {
-        *idx
-    } else {
-        let hash_id = gxhash::new().sum64(stream_name);
-        hash_id as usize % (WRITERS.len() - MEM_TABLE_INDIVIDUAL_STREAMS.len())
-    };
+    let idx = writer_idx(org_id, stream_name);
     let key = WriterKey::new(org_id, stream_type);
     let mut rw = WRITERS[idx].write().await;
     let w = rw
@@ -96,8 +127,7 @@ pub async fn read_from_memtable(
     time_range: Option<(i64, i64)>,
 ) -> Result<Vec<ReadRecordBatchEntry>> {
     let key = WriterKey::new(org_id, stream_type);
-    let hash_id = gxhash::new().sum64(stream_name);
-    let idx = hash_id as usize % WRITERS.len();
+    let idx = writer_idx(org_id, stream_name);
     let w = WRITERS[idx].read().await;
     let Some(r) = w.get(&key) else {
         return Ok(Vec::new());
@@ -117,6 +147,29 @@ pub async fn check_ttl() -> Result<()> {
     Ok(())
 }
 
+/// Calls `f` with the writer key and the memtable of every writer
+pub(crate) async fn for_each_memtable(mut f: impl FnMut(&WriterKey, &MemTable)) {
+    for w in WRITERS.iter() {
+        let w = w.read().await;
+        for r in w.values() {
+            f(&r.key, &*r.memtable.read().await);
+        }
+    }
+}
+
+/// Returns the wal file of every writer with the bytes written to it
+pub(crate) async fn wal_files() -> Vec<(PathBuf, usize)> {
+    let mut files = Vec::new();
+    for w in WRITERS.iter() {
+        let w = w.read().await;
+        for r in w.values() {
+            let wal = r.wal.lock().await;
+            files.push((wal.path().clone(), wal.size().0));
+        }
+    }
+    files
+}
+
 pub async fn flush_all() -> Result<()> {
     for w in WRITERS.iter() {
         let mut w = w.write().await;
@@ -239,10 +292,15 @@ impl Writer {
         }
 
         if !check_ttl {
+            if config::utils::faults::fail_wal_write() {
+                return Err(Error::InjectedFaultError {});
+            }
             // write into wal
             wal.write(&entry_bytes, false).context(WalSnafu)?;
             // write into memtable
-            mem.write(schema, entry, entry_batch)?;
+            let stream = entry.stream.clone();
+            let usage = mem.write(schema, entry, entry_batch)?;
+            memory::add(&self.key, &stream, usage);
         }
 
         Ok(())
@@ -271,6 +329,14 @@ impl Writer {
         wal.sync().context(WalSnafu)
     }
 
+    pub fn org_id(&self) -> &str {
+        &self.key.org_id
+    }
+
+    pub fn stream_type(&self) -> &str {
+        &self.key.stream_type
+    }
+
     pub async fn read(
         &self,
         stream_name: &str,
@@ -61,6 +61,8 @@ pub async fn run() -> Result<(), anyhow::Error> {
     tokio::task::spawn(async move { run_schedule_jobs().await });
     tokio::task::spawn(async move { clean_complete_jobs().await });
     tokio::task::spawn(async move { watch_timeout_jobs().await });
+    tokio::task::spawn(async move { flush_alert_digests().await });
+    tokio::task::spawn(async move { flush_trigger_stats().await });
 
     Ok(())
 }
@@ -101,3 +103,25 @@ async fn watch_timeout_jobs() -> Result<(), anyhow::Error> {
         }
     }
 }
+
+async fn flush_alert_digests() -> Result<(), anyhow::Error> {
+    let mut interval = time::interval(time::Duration::from_secs(60));
+    interval.tick().await; // trigger the first run
+    loop {
+        interval.tick().await;
+        if let Err(e) = service::alerts::throttle::flush_digests().await {
+            log::error!("[ALERT MANAGER] flush alert digests error: {}", e);
+        }
+    }
+}
+
+async fn flush_trigger_stats() -> Result<(), anyhow::Error> {
+    let mut interval = time::interval(time::Duration::from_secs(60));
+    interval.tick().await; // trigger the first run
+    loop {
+        interval.tick().await;
+        if let Err(e) = service::alerts::scheduler::flush().await {
+            log::error!("[ALERT MANAGER] flush trigger stats error: {}", e);
+        }
+    }
+}
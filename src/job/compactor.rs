@@ -94,6 +94,8 @@ pub async fn run() -> Result<(), anyhow::Error> {
     tokio::task::spawn(async move { run_generate_job().await });
     tokio::task::spawn(async move { run_merge(tx).await });
     tokio::task::spawn(async move { run_retention().await });
+    tokio::task::spawn(async move { run_recluster().await });
+    tokio::task::spawn(async move { run_upsert().await });
     tokio::task::spawn(async move { run_delay_deletion().await });
     tokio::task::spawn(async move { run_sync_to_db().await });
     tokio::task::spawn(async move { run_check_running_jobs().await });
@@ -132,6 +134,37 @@ async fn run_retention() -> Result<(), anyhow::Error> {
         if let Err(e) = compact::run_retention().await {
             log::error!("[COMPACTOR] run data retention error: {e}");
         }
+        if let Err(e) = compact::run_delete().await {
+            log::error!("[COMPACTOR] run data delete error: {e}");
+        }
+    }
+}
+
+/// Rewrite the existing files of the streams with a recluster job
+async fn run_recluster() -> Result<(), anyhow::Error> {
+    loop {
+        time::sleep(time::Duration::from_secs(get_config().compact.interval + 3)).await;
+        log::debug!("[COMPACTOR] Running data recluster");
+        if let Err(e) = compact::series::run().await {
+            log::error!("[COMPACTOR] run series encoding error: {e}");
+        }
+        if let Err(e) = compact::recluster::run().await {
+            log::error!("[COMPACTOR] run data recluster error: {e}");
+        }
+    }
+}
+
+/// Remove the superseded rows of the upsert streams
+async fn run_upsert() -> Result<(), anyhow::Error> {
+    loop {
+        time::sleep(time::Duration::from_secs(
+            get_config().compact.upsert_interval,
+        ))
+        .await;
+        log::debug!("[COMPACTOR] Running upsert compaction");
+        if let Err(e) = compact::upsert::run().await {
+            log::error!("[COMPACTOR] run upsert compaction error: {e}");
+        }
     }
 }
 
@@ -68,7 +68,7 @@ pub(crate) async fn write_to_disk(
 
     // write parquet file
     let mut buf_parquet = Vec::new();
-    let mut writer = new_parquet_writer(&mut buf_parquet, &schema, &[], &[], &file_meta);
+    let mut writer = new_parquet_writer(&mut buf_parquet, &schema, &[], &[], &file_meta, None);
     for batch in batches {
         writer.write(&batch).await?;
     }
@@ -403,6 +403,13 @@ async fn move_files(
             return Ok(());
         }
 
+        // the copies of the wal files are kept until their parquet files are uploaded
+        let uploaded = new_file_list
+            .iter()
+            .map(|f| f.key.clone())
+            .collect::<Vec<_>>();
+        ingester::wal_parquet_uploaded(&uploaded).await;
+
         // check if allowed to delete the file
         for file in new_file_list.iter() {
             loop {
@@ -545,6 +552,8 @@ async fn merge_files(
         .unwrap_or_default();
     let bloom_filter_fields = stream_setting.bloom_filter_fields;
     let full_text_search_fields = stream_setting.full_text_search_keys;
+    let parquet_settings = stream_setting.parquet;
+    let clustering_keys = stream_setting.clustering_keys;
     let defined_schema_fields = stream_setting.defined_schema_fields.unwrap_or_default();
     let schema = if !defined_schema_fields.is_empty() {
         let latest_schema = SchemaCache::new(latest_schema.as_ref().clone());
@@ -574,7 +583,8 @@ async fn merge_files(
 
     let start = std::time::Instant::now();
     let mut buf = Vec::new();
-    let single_file = new_file_list.len() == 1;
+    // the wal file is sorted by time, clustered streams need the file to be rewritten
+    let single_file = new_file_list.len() == 1 && clustering_keys.is_empty();
     let merge_result = if single_file {
         move_single_file(
             thread_id,
@@ -586,10 +596,16 @@ async fn merge_files(
         )
         .await
     } else if stream_type == StreamType::Logs {
-        merge_parquet_files(thread_id, tmp_dir.name(), schema.clone()).await
+        merge_parquet_files(thread_id, tmp_dir.name(), schema.clone(), &clustering_keys).await
     } else {
-        merge_parquet_files_by_datafusion(tmp_dir.name(), stream_type, &stream_name, schema.clone())
-            .await
+        merge_parquet_files_by_datafusion(
+            tmp_dir.name(),
+            stream_type,
+            &stream_name,
+            schema.clone(),
+            &clustering_keys,
+        )
+        .await
     };
     let (new_schema, new_batches) = match merge_result {
         Ok(v) => v,
@@ -615,6 +631,7 @@ async fn merge_files(
             &bloom_filter_fields,
             &full_text_search_fields,
             &new_file_meta,
+            parquet_settings.as_ref(),
         )
         .await?;
     }
@@ -0,0 +1,36 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{cluster, get_config};
+use tokio::time;
+
+use crate::service::iceberg;
+
+pub async fn run() -> Result<(), anyhow::Error> {
+    if !cluster::is_compactor(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(());
+    }
+
+    let mut interval = time::interval(time::Duration::from_secs(
+        get_config().limit.iceberg_commit_interval,
+    ));
+    interval.tick().await; // trigger the first run
+    loop {
+        interval.tick().await;
+        if let Err(e) = iceberg::commit_all().await {
+            log::error!("[ICEBERG] commit tables error: {}", e);
+        }
+    }
+}
@@ -0,0 +1,60 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{cluster, get_config};
+use tokio::{task::JoinHandle, time};
+
+use crate::{common::infra::cluster::get_ingester_by_key, service::k8s_watcher};
+
+/// Seconds between two checks of the node running the watchers
+const CHECK_INTERVAL: u64 = 30;
+/// Seconds to wait before watching again after an error
+const RETRY_INTERVAL: u64 = 5;
+
+pub async fn run() -> Result<(), anyhow::Error> {
+    if !cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(()); // not an ingester, no need to init job
+    }
+    if !get_config().k8s_watcher.enabled {
+        return Ok(());
+    }
+
+    // only one ingester watches the cluster
+    let mut watchers: Vec<JoinHandle<()>> = Vec::new();
+    let mut interval = time::interval(time::Duration::from_secs(CHECK_INTERVAL));
+    loop {
+        interval.tick().await;
+        let is_owner = cluster::is_single_node(&cluster::LOCAL_NODE_ROLE)
+            || get_ingester_by_key("k8s_watcher").await == Some(cluster::LOCAL_NODE_UUID.clone());
+        if is_owner && watchers.is_empty() {
+            log::info!("[K8S_WATCHER] start watching the kubernetes cluster");
+            for (kind, api, plural) in k8s_watcher::RESOURCES {
+                watchers.push(tokio::task::spawn(async move {
+                    loop {
+                        if let Err(e) = k8s_watcher::watch(kind, api, plural).await {
+                            log::error!("[K8S_WATCHER] watch {kind} error: {e}");
+                        }
+                        time::sleep(time::Duration::from_secs(RETRY_INTERVAL)).await;
+                    }
+                }));
+            }
+        } else if !is_owner && !watchers.is_empty() {
+            log::info!("[K8S_WATCHER] stop watching, another ingester took over");
+            for watcher in watchers.drain(..) {
+                watcher.abort();
+            }
+        }
+    }
+}
@@ -28,16 +28,31 @@ use crate::{
 };
 
 mod alert_manager;
+mod archive_search;
 mod compactor;
+mod entities;
 pub(crate) mod file_list;
 pub(crate) mod files;
 mod flatten_compactor;
+mod iceberg;
+mod ingest_keys;
+mod ingest_watermark;
+mod k8s_watcher;
+mod log_puller;
 mod metrics;
 mod mmdb_downloader;
+mod plugins;
 mod prom;
+mod rehydration;
+mod schema_contracts;
+mod scrape;
+mod search_jobs;
 mod stats;
+mod stream_access;
+mod synthetics;
 pub(crate) mod syslog_server;
 mod telemetry;
+mod user_sessions;
 
 pub async fn init() -> Result<(), anyhow::Error> {
     let email_regex = Regex::new(
@@ -82,6 +97,44 @@ pub async fn init() -> Result<(), anyhow::Error> {
         .await
         .expect("organization cache sync failed");
 
+    // cache org network policies, they are enforced by every node serving http
+    tokio::task::spawn(async move { db::network_policy::watch().await });
+    db::network_policy::cache()
+        .await
+        .expect("network policy cache failed");
+
+    // cache org residency, it is enforced by every node serving ingestion and
+    // queries
+    tokio::task::spawn(async move { db::residency::watch().await });
+    db::residency::cache()
+        .await
+        .expect("org residency cache failed");
+
+    // cache ingest keys, signed ingestion requests are verified on every node
+    tokio::task::spawn(async move { db::ingest_keys::watch().await });
+    db::ingest_keys::cache()
+        .await
+        .expect("ingest keys cache failed");
+
+    // cache the mock clock, every node serving queries uses its now
+    if cfg.common.mock_clock_enabled {
+        tokio::task::spawn(async move { db::mock_clock::watch().await });
+        db::mock_clock::cache()
+            .await
+            .expect("mock clock cache failed");
+    }
+
+    // cache plugins, every node calls them for sources, destinations and
+    // query functions
+    tokio::task::spawn(async move { db::plugins::watch().await });
+    db::plugins::cache().await.expect("plugins cache failed");
+
+    // cache stream subscriptions, the ingesters match the records against them
+    tokio::task::spawn(async move { db::subscriptions::watch().await });
+    db::subscriptions::cache()
+        .await
+        .expect("stream subscriptions cache failed");
+
     // check version
     db::version::set().await.expect("db version set failed");
 
@@ -116,6 +169,7 @@ pub async fn init() -> Result<(), anyhow::Error> {
     tokio::task::spawn(async move { db::ofga::watch().await });
     if cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
         tokio::task::spawn(async move { db::pipelines::watch().await });
+        tokio::task::spawn(async move { db::schema_contracts::watch().await });
     }
 
     #[cfg(feature = "enterprise")]
@@ -124,6 +178,11 @@ pub async fn init() -> Result<(), anyhow::Error> {
     {
         tokio::task::spawn(async move { db::session::watch().await });
     }
+    if !cluster::is_compactor(&cluster::LOCAL_NODE_ROLE)
+        || cluster::is_single_node(&cluster::LOCAL_NODE_ROLE)
+    {
+        tokio::task::spawn(async move { db::user_login_session::watch().await });
+    }
 
     tokio::task::yield_now().await; // yield let other tasks run
 
@@ -159,6 +218,9 @@ pub async fn init() -> Result<(), anyhow::Error> {
         .expect("syslog settings cache failed");
     if cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
         db::pipelines::cache().await.expect("syslog cache failed");
+        db::schema_contracts::cache()
+            .await
+            .expect("schema contracts cache failed");
     }
 
     // cache file list
@@ -198,6 +260,13 @@ pub async fn init() -> Result<(), anyhow::Error> {
             .await
             .expect("user session cache failed");
     }
+    if !cluster::is_compactor(&cluster::LOCAL_NODE_ROLE)
+        || cluster::is_single_node(&cluster::LOCAL_NODE_ROLE)
+    {
+        db::user_login_session::cache()
+            .await
+            .expect("user login session cache failed");
+    }
 
     // check wal directory
     if cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
@@ -218,6 +287,21 @@ pub async fn init() -> Result<(), anyhow::Error> {
     tokio::task::spawn(async move { metrics::run().await });
     tokio::task::spawn(async move { prom::run().await });
     tokio::task::spawn(async move { alert_manager::run().await });
+    tokio::task::spawn(async move { schema_contracts::run().await });
+    tokio::task::spawn(async move { entities::run().await });
+    tokio::task::spawn(async move { rehydration::run().await });
+    tokio::task::spawn(async move { archive_search::run().await });
+    tokio::task::spawn(async move { search_jobs::run().await });
+    tokio::task::spawn(async move { scrape::run().await });
+    tokio::task::spawn(async move { k8s_watcher::run().await });
+    tokio::task::spawn(async move { log_puller::run().await });
+    tokio::task::spawn(async move { synthetics::run().await });
+    tokio::task::spawn(async move { ingest_keys::run().await });
+    tokio::task::spawn(async move { ingest_watermark::run().await });
+    tokio::task::spawn(async move { plugins::run().await });
+    tokio::task::spawn(async move { stream_access::run().await });
+    tokio::task::spawn(async move { iceberg::run().await });
+    tokio::task::spawn(async move { user_sessions::run().await });
 
     #[cfg(feature = "enterprise")]
     o2_enterprise::enterprise::openfga::authorizer::authz::init_open_fga().await;
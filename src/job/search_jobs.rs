@@ -0,0 +1,59 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{cluster, get_config};
+use tokio::time;
+
+use crate::service::search_jobs;
+
+/// Seconds between two cleanups of the finished jobs
+const CLEANUP_INTERVAL: u64 = 3600;
+
+pub async fn run() -> Result<(), anyhow::Error> {
+    if cluster::is_compactor(&cluster::LOCAL_NODE_ROLE) {
+        tokio::task::spawn(async move { cleanup().await });
+    }
+    if !cluster::is_querier(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(());
+    }
+
+    let mut interval = time::interval(time::Duration::from_secs(
+        get_config().limit.search_job_interval,
+    ));
+    interval.tick().await; // trigger the first run
+    loop {
+        if cluster::is_offline() {
+            break;
+        }
+        interval.tick().await;
+        if let Err(e) = search_jobs::run().await {
+            log::error!("[SEARCH JOB] run jobs error: {}", e);
+        }
+    }
+    Ok(())
+}
+
+async fn cleanup() {
+    let mut interval = time::interval(time::Duration::from_secs(CLEANUP_INTERVAL));
+    interval.tick().await; // trigger the first run
+    loop {
+        interval.tick().await;
+        match search_jobs::delete_expired().await {
+            Ok(0) => {}
+            Ok(n) => log::info!("[SEARCH JOB] deleted {n} finished jobs"),
+            Err(e) => log::error!("[SEARCH JOB] delete finished jobs error: {}", e),
+        }
+    }
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::cluster;
+use tokio::time;
+
+use crate::service::user_sessions;
+
+/// Seconds between two cleanups of the expired login sessions
+const CLEANUP_INTERVAL: u64 = 3600;
+
+pub async fn run() -> Result<(), anyhow::Error> {
+    if !cluster::is_compactor(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(());
+    }
+
+    let mut interval = time::interval(time::Duration::from_secs(CLEANUP_INTERVAL));
+    interval.tick().await; // trigger the first run
+    loop {
+        interval.tick().await;
+        match user_sessions::delete_expired().await {
+            Ok(0) => {}
+            Ok(n) => log::info!("[SESSION] deleted {n} expired login sessions"),
+            Err(e) => log::error!("[SESSION] delete expired sessions error: {}", e),
+        }
+    }
+}
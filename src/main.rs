@@ -13,7 +13,9 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::{cmp::max, collections::HashMap, net::SocketAddr, str::FromStr, time::Duration};
+use std::{
+    cmp::max, collections::HashMap, net::SocketAddr, str::FromStr, sync::Arc, time::Duration,
+};
 
 use actix_web::{dev::ServerHandle, http::KeepAlive, middleware, web, App, HttpServer};
 use actix_web_opentelemetry::RequestTracing;
@@ -25,7 +27,7 @@ use log::LevelFilter;
 use openobserve::{
     cli::basic::cli,
     common::{
-        infra::{self as common_infra, cluster, config::VERSION},
+        infra::{self as common_infra, cluster, config::VERSION, tls},
         meta, migration,
         utils::zo_logger,
     },
@@ -33,6 +35,7 @@ use openobserve::{
         grpc::{
             auth::check_auth,
             request::{
+                api::{ApiCluster, ApiIngester, ApiSearcher},
                 event::Eventer,
                 file_list::Filelister,
                 logs::LogsServer,
@@ -55,9 +58,16 @@ use opentelemetry_proto::tonic::collector::{
     trace::v1::trace_service_server::TraceServiceServer,
 };
 use opentelemetry_sdk::{propagation::TraceContextPropagator, trace as sdktrace, Resource};
-use proto::cluster_rpc::{
-    event_server::EventServer, filelist_server::FilelistServer, metrics_server::MetricsServer,
-    query_cache_server::QueryCacheServer, search_server::SearchServer, usage_server::UsageServer,
+use proto::{
+    cluster_rpc::{
+        event_server::EventServer, filelist_server::FilelistServer, metrics_server::MetricsServer,
+        query_cache_server::QueryCacheServer, search_server::SearchServer,
+        usage_server::UsageServer,
+    },
+    openobserve_v1::{
+        cluster_service_server::ClusterServiceServer, ingest_service_server::IngestServiceServer,
+        search_service_server::SearchServiceServer, FILE_DESCRIPTOR_SET,
+    },
 };
 #[cfg(feature = "profiling")]
 use pyroscope::PyroscopeAgent;
@@ -166,6 +176,10 @@ async fn main() -> Result<(), anyhow::Error> {
         cfg.limit.disk_free / 1024 / 1024 / 1024,
     );
 
+    // load the certificates before any listener or inter-node connection
+    tls::init().expect("tls init failed");
+    tokio::task::spawn(async move { tls::run_reloader().await });
+
     // it must be initialized before the server starts
     cluster::register_and_keepalive()
         .await
@@ -314,10 +328,33 @@ fn init_common_grpc_server(
     let query_cache_svc = QueryCacheServer::new(QueryCacheServerImpl)
         .send_compressed(CompressionEncoding::Gzip)
         .accept_compressed(CompressionEncoding::Gzip);
+    let api_search_svc = SearchServiceServer::new(ApiSearcher)
+        .send_compressed(CompressionEncoding::Gzip)
+        .accept_compressed(CompressionEncoding::Gzip)
+        .max_decoding_message_size(cfg.grpc.max_message_size * 1024 * 1024)
+        .max_encoding_message_size(cfg.grpc.max_message_size * 1024 * 1024);
+    let api_ingest_svc = IngestServiceServer::new(ApiIngester)
+        .send_compressed(CompressionEncoding::Gzip)
+        .accept_compressed(CompressionEncoding::Gzip)
+        .max_decoding_message_size(cfg.grpc.max_message_size * 1024 * 1024)
+        .max_encoding_message_size(cfg.grpc.max_message_size * 1024 * 1024);
+    let api_cluster_svc = ClusterServiceServer::new(ApiCluster)
+        .send_compressed(CompressionEncoding::Gzip)
+        .accept_compressed(CompressionEncoding::Gzip);
+    // only the public api is described, the internal services are not part of it
+    let reflection_svc = if cfg.grpc.reflection_enabled {
+        Some(
+            tonic_reflection::server::Builder::configure()
+                .register_encoded_file_descriptor_set(FILE_DESCRIPTOR_SET)
+                .build()?,
+        )
+    } else {
+        None
+    };
 
     tokio::task::spawn(async move {
         log::info!("starting gRPC server at {}", gaddr);
-        tonic::transport::Server::builder()
+        let router = tonic::transport::Server::builder()
             .layer(tonic::service::interceptor(check_auth))
             .add_service(event_svc)
             .add_service(search_svc)
@@ -328,12 +365,23 @@ fn init_common_grpc_server(
             .add_service(usage_svc)
             .add_service(logs_svc)
             .add_service(query_cache_svc)
-            .serve_with_shutdown(gaddr, async {
-                shutdown_rx.await.ok();
-                log::info!("gRPC server starts shutting down");
-            })
-            .await
-            .expect("gRPC server init failed");
+            .add_service(api_search_svc)
+            .add_service(api_ingest_svc)
+            .add_service(api_cluster_svc)
+            .add_optional_service(reflection_svc);
+        let shutdown = async {
+            shutdown_rx.await.ok();
+            log::info!("gRPC server starts shutting down");
+        };
+        let ret = if get_config().tls.grpc_enabled {
+            let incoming = tls::grpc_incoming(gaddr)
+                .await
+                .expect("gRPC tls listener init failed");
+            router.serve_with_incoming_shutdown(incoming, shutdown).await
+        } else {
+            router.serve_with_shutdown(gaddr, shutdown).await
+        };
+        ret.expect("gRPC server init failed");
         stopped_tx.send(()).ok();
     });
     Ok(())
@@ -363,17 +411,24 @@ fn init_router_grpc_server(
 
     tokio::task::spawn(async move {
         log::info!("starting gRPC server at {}", gaddr);
-        tonic::transport::Server::builder()
+        let router = tonic::transport::Server::builder()
             .layer(tonic::service::interceptor(check_auth))
             .add_service(logs_svc)
             .add_service(metrics_svc)
-            .add_service(traces_svc)
-            .serve_with_shutdown(gaddr, async {
-                shutdown_rx.await.ok();
-                log::info!("gRPC server starts shutting down");
-            })
-            .await
-            .expect("gRPC server init failed");
+            .add_service(traces_svc);
+        let shutdown = async {
+            shutdown_rx.await.ok();
+            log::info!("gRPC server starts shutting down");
+        };
+        let ret = if get_config().tls.grpc_enabled {
+            let incoming = tls::grpc_incoming(gaddr)
+                .await
+                .expect("gRPC tls listener init failed");
+            router.serve_with_incoming_shutdown(incoming, shutdown).await
+        } else {
+            router.serve_with_shutdown(gaddr, shutdown).await
+        };
+        ret.expect("gRPC server init failed");
         stopped_tx.send(()).ok();
     });
     Ok(())
@@ -400,8 +455,15 @@ async fn init_http_server() -> Result<(), anyhow::Error> {
         log::info!("starting HTTP server at: {}", haddr);
         let mut app = App::new().wrap(prometheus.clone());
         if is_router(&LOCAL_NODE_ROLE) {
+            let mut connector = awc::Connector::new().limit(cfg.route.max_connections);
+            if cfg.tls.http_enabled {
+                // the other nodes serve https with certificates of the cluster CA
+                connector = connector.rustls_0_22(Arc::new(
+                    tls::http_client_config().expect("http tls client config failed"),
+                ));
+            }
             let client = awc::Client::builder()
-                .connector(awc::Connector::new().limit(cfg.route.max_connections))
+                .connector(connector)
                 .timeout(Duration::from_secs(cfg.route.timeout))
                 .disable_redirects()
                 .finish();
@@ -414,6 +476,7 @@ async fn init_http_server() -> Result<(), anyhow::Error> {
                         .service(router::http::api)
                         .service(router::http::aws)
                         .service(router::http::gcp)
+                        .service(router::http::webhooks)
                         .service(router::http::rum)
                         .configure(get_basic_routes)
                         .configure(get_proxy_routes),
@@ -433,7 +496,7 @@ async fn init_http_server() -> Result<(), anyhow::Error> {
             .app_data(web::PayloadConfig::new(cfg.limit.req_payload_limit)) // size is in bytes
             .wrap(middleware::Compress::default())
             .wrap(middleware::Logger::new(
-                r#"%a "%r" %s %b "%{Content-Length}i" "%{Referer}i" "%{User-Agent}i" %T"#,
+                r#"%a "%r" %s %b "%{Content-Length}i" "%{Referer}i" "%{User-Agent}i" %T "%{traceparent}i""#,
             ))
             .wrap(RequestTracing::new())
     })
@@ -442,8 +505,12 @@ async fn init_http_server() -> Result<(), anyhow::Error> {
         cfg.limit.keep_alive,
     ))))
     .client_request_timeout(Duration::from_secs(max(5, cfg.limit.request_timeout)))
-    .shutdown_timeout(max(1, cfg.limit.shutdown_timeout))
-    .bind(haddr)?;
+    .shutdown_timeout(max(1, cfg.limit.shutdown_timeout));
+    let server = if cfg.tls.http_enabled {
+        server.bind_rustls_0_22(haddr, tls::http_server_config()?)?
+    } else {
+        server.bind(haddr)?
+    };
 
     let server = server
         .workers(cfg.limit.http_worker_num)
@@ -479,8 +546,15 @@ async fn init_http_server_without_tracing() -> Result<(), anyhow::Error> {
         log::info!("starting HTTP server at: {}", haddr);
         let mut app = App::new().wrap(prometheus.clone());
         if is_router(&LOCAL_NODE_ROLE) {
+            let mut connector = awc::Connector::new().limit(cfg.route.max_connections);
+            if cfg.tls.http_enabled {
+                // the other nodes serve https with certificates of the cluster CA
+                connector = connector.rustls_0_22(Arc::new(
+                    tls::http_client_config().expect("http tls client config failed"),
+                ));
+            }
             let client = awc::Client::builder()
-                .connector(awc::Connector::new().limit(cfg.route.max_connections))
+                .connector(connector)
                 .timeout(Duration::from_secs(cfg.route.timeout))
                 .disable_redirects()
                 .finish();
@@ -493,6 +567,7 @@ async fn init_http_server_without_tracing() -> Result<(), anyhow::Error> {
                         .service(router::http::api)
                         .service(router::http::aws)
                         .service(router::http::gcp)
+                        .service(router::http::webhooks)
                         .service(router::http::rum)
                         .configure(get_basic_routes)
                         .configure(get_proxy_routes),
@@ -512,7 +587,7 @@ async fn init_http_server_without_tracing() -> Result<(), anyhow::Error> {
             .app_data(web::PayloadConfig::new(cfg.limit.req_payload_limit)) // size is in bytes
             .wrap(middleware::Compress::default())
             .wrap(middleware::Logger::new(
-                r#"%a "%r" %s %b "%{Content-Length}i" "%{Referer}i" "%{User-Agent}i" %T"#,
+                r#"%a "%r" %s %b "%{Content-Length}i" "%{Referer}i" "%{User-Agent}i" %T "%{traceparent}i""#,
             ))
     })
     .keep_alive(KeepAlive::Timeout(Duration::from_secs(max(
@@ -520,8 +595,12 @@ async fn init_http_server_without_tracing() -> Result<(), anyhow::Error> {
         cfg.limit.keep_alive,
     ))))
     .client_request_timeout(Duration::from_secs(max(5, cfg.limit.request_timeout)))
-    .shutdown_timeout(max(1, cfg.limit.shutdown_timeout))
-    .bind(haddr)?;
+    .shutdown_timeout(max(1, cfg.limit.shutdown_timeout));
+    let server = if cfg.tls.http_enabled {
+        server.bind_rustls_0_22(haddr, tls::http_server_config()?)?
+    } else {
+        server.bind(haddr)?
+    };
 
     let server = server
         .workers(cfg.limit.http_worker_num)
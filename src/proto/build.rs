@@ -61,6 +61,26 @@ fn main() -> Result<()> {
         )
         .unwrap();
 
+    // public api, its descriptors are served by the reflection service
+    let out_dir = std::path::PathBuf::from(std::env::var("OUT_DIR").unwrap());
+    tonic_build::configure()
+        .file_descriptor_set_path(out_dir.join("openobserve_v1_descriptor.bin"))
+        .compile(
+            &[
+                "proto/openobserve/v1/cluster.proto",
+                "proto/openobserve/v1/ingest.proto",
+                "proto/openobserve/v1/search.proto",
+            ],
+            &["proto"],
+        )
+        .unwrap();
+
+    // plugin protocol, OpenObserve is the client of the plugins
+    tonic_build::configure()
+        .build_server(false)
+        .compile(&["proto/openobserve/plugin/v1/plugin.proto"], &["proto"])
+        .unwrap();
+
     let mut config = prost_build::Config::new();
     config
         .type_attribute(
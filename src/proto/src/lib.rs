@@ -17,6 +17,24 @@ pub mod cluster_rpc {
     tonic::include_proto!("cluster");
 }
 
+/// Stable public api, for clients built by third parties
+pub mod openobserve_v1 {
+    tonic::include_proto!("openobserve.v1");
+
+    pub const API_VERSION: &str = "v1";
+
+    /// Encoded descriptors of the api, for the reflection service
+    pub const FILE_DESCRIPTOR_SET: &[u8] =
+        tonic::include_file_descriptor_set!("openobserve_v1_descriptor");
+}
+
+/// Protocol of the plugins adding sources, destinations and query functions
+pub mod openobserve_plugin_v1 {
+    tonic::include_proto!("openobserve.plugin.v1");
+
+    pub const API_VERSION: &str = "v1";
+}
+
 pub mod prometheus_rpc {
     include!(concat!(env!("OUT_DIR"), "/prometheus.rs"));
 }
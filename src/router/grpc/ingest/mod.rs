@@ -17,7 +17,7 @@ use config::{utils::rand::get_rand_element, RwAHashMap};
 use once_cell::sync::Lazy;
 use tonic::{transport::Channel, Status};
 
-use crate::common::infra::cluster;
+use crate::common::infra::{cluster, tls};
 
 pub mod logs;
 pub mod metrics;
@@ -35,7 +35,7 @@ pub(crate) async fn get_ingester_channel() -> Result<Channel, tonic::Status> {
     drop(r);
 
     // cache miss, connect to ingester
-    let channel = Channel::from_shared(grpc_addr.clone())
+    let channel = tls::grpc_endpoint(grpc_addr.clone())
         .unwrap()
         .connect_timeout(std::time::Duration::from_secs(
             config::get_config().grpc.connect_timeout,
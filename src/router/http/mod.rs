@@ -18,7 +18,7 @@ use actix_web::{http::Error, route, web, HttpRequest, HttpResponse};
 
 use crate::common::infra::cluster;
 
-const QUERIER_ROUTES: [&str; 18] = [
+const QUERIER_ROUTES: [&str; 19] = [
     "/config",
     "/summary",
     "/organizations",
@@ -37,6 +37,7 @@ const QUERIER_ROUTES: [&str; 18] = [
     "/prometheus/api/v1/metadata",
     "/prometheus/api/v1/labels",
     "/prometheus/api/v1/label/",
+    "/prometheus/api/v1/read",
 ];
 
 const FIXED_QUERIER_ROUTES: [&str; 3] = ["/summary", "/schema", "/streams"];
@@ -126,6 +127,15 @@ pub async fn gcp(
     dispatch(req, payload, client).await
 }
 
+#[route("/webhooks/{path:.*}", method = "POST")]
+pub async fn webhooks(
+    req: HttpRequest,
+    payload: web::Payload,
+    client: web::Data<awc::Client>,
+) -> actix_web::Result<HttpResponse, Error> {
+    dispatch(req, payload, client).await
+}
+
 #[route(
     "/rum/{path:.*}",
     // method = "GET",
@@ -0,0 +1,135 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Client of the language model configured by ZO_AI_*
+
+use std::time::Duration;
+
+use anyhow::{anyhow, Result};
+use config::{get_config, utils::json};
+
+/// Sends the chat messages, `{"role": ..., "content": ...}`, and returns the
+/// answer of the model. `json_output` asks for a JSON object when the api
+/// supports it.
+pub async fn chat(messages: &[json::Value], json_output: bool) -> Result<String> {
+    let cfg = get_config();
+    if !cfg.ai.enabled {
+        return Err(anyhow!("the language model is disabled, set ZO_AI_ENABLED"));
+    }
+    let (url, body) = request(
+        &cfg.ai.provider,
+        &cfg.ai.api_url,
+        &cfg.ai.model,
+        messages,
+        json_output,
+    );
+    let client = reqwest::Client::builder()
+        .timeout(Duration::from_secs(cfg.ai.timeout))
+        .build()?;
+    let mut req = client
+        .post(url)
+        .header("Content-Type", "application/json")
+        .body(json::to_vec(&body)?);
+    if !cfg.ai.api_key.is_empty() {
+        req = req.bearer_auth(&cfg.ai.api_key);
+    }
+    let resp = req.send().await?;
+    let status = resp.status();
+    let body = resp.bytes().await?;
+    if !status.is_success() {
+        return Err(anyhow!(
+            "language model returned {status}: {}",
+            String::from_utf8_lossy(&body)
+        ));
+    }
+    let body: json::Value = json::from_slice(&body)?;
+    answer(&cfg.ai.provider, &body).ok_or_else(|| anyhow!("language model returned no answer"))
+}
+
+fn request(
+    provider: &str,
+    api_url: &str,
+    model: &str,
+    messages: &[json::Value],
+    json_output: bool,
+) -> (String, json::Value) {
+    match provider {
+        "ollama" => {
+            let mut body = json::json!({
+                "model": model,
+                "messages": messages,
+                "stream": false,
+                "options": { "temperature": 0 },
+            });
+            if json_output {
+                body["format"] = "json".into();
+            }
+            (format!("{api_url}/api/chat"), body)
+        }
+        _ => {
+            let mut body = json::json!({
+                "model": model,
+                "messages": messages,
+                "temperature": 0,
+            });
+            if json_output {
+                body["response_format"] = json::json!({ "type": "json_object" });
+            }
+            (format!("{api_url}/chat/completions"), body)
+        }
+    }
+}
+
+fn answer(provider: &str, body: &json::Value) -> Option<String> {
+    let content = match provider {
+        "ollama" => &body["message"]["content"],
+        _ => &body["choices"][0]["message"]["content"],
+    };
+    content.as_str().map(|s| s.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_request() {
+        let messages = vec![json::json!({"role": "user", "content": "hi"})];
+        let (url, body) = request("openai", "http://llm/v1", "m", &messages, true);
+        assert_eq!(url, "http://llm/v1/chat/completions");
+        assert_eq!(body["response_format"]["type"], "json_object");
+        assert_eq!(body["messages"], json::json!(messages));
+
+        let (url, body) = request(
+            "ollama",
+            "http://localhost:11434",
+            "llama3",
+            &messages,
+            false,
+        );
+        assert_eq!(url, "http://localhost:11434/api/chat");
+        assert_eq!(body["stream"], false);
+        assert!(body.get("format").is_none());
+    }
+
+    #[test]
+    fn test_answer() {
+        let openai = json::json!({"choices": [{"message": {"role": "assistant", "content": "a"}}]});
+        assert_eq!(answer("openai", &openai).as_deref(), Some("a"));
+        let ollama = json::json!({"message": {"role": "assistant", "content": "b"}, "done": true});
+        assert_eq!(answer("ollama", &ollama).as_deref(), Some("b"));
+        assert_eq!(answer("openai", &ollama), None);
+    }
+}
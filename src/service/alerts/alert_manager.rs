@@ -26,7 +26,10 @@ use config::{
 use cron::Schedule;
 
 use crate::{
-    common::meta::{alerts::AlertFrequencyType, dashboards::reports::ReportFrequencyType},
+    common::meta::{
+        alerts::{AlertFrequencyType, QueryType},
+        dashboards::reports::ReportFrequencyType,
+    },
     service::{db, usage::publish_triggers_usage},
 };
 
@@ -37,8 +40,11 @@ pub async fn run() -> Result<(), anyhow::Error> {
     // - trigger.next_run_at <= now
     // - !(trigger.is_realtime && !trigger.is_silenced)
     // - trigger.status == "Waiting"
+    // The triggers are taken in turns from each org, at most
+    // `scheduler_org_quota` from one org
     let triggers = db::scheduler::pull(
         cfg.limit.alert_schedule_concurrency,
+        cfg.limit.scheduler_org_quota,
         cfg.limit.alert_schedule_timeout,
         cfg.limit.report_schedule_timeout,
     )
@@ -48,9 +54,12 @@ pub async fn run() -> Result<(), anyhow::Error> {
 
     for trigger in triggers {
         tokio::task::spawn(async move {
-            if let Err(e) = handle_triggers(trigger).await {
+            let started = Utc::now().timestamp_micros();
+            let ret = handle_triggers(trigger.clone()).await;
+            if let Err(e) = &ret {
                 log::error!("[ALERT_MANAGER] Error handling trigger: {}", e);
             }
+            super::scheduler::record(&trigger, started, ret.err().map(|e| e.to_string())).await;
         });
     }
     Ok(())
@@ -60,6 +69,7 @@ pub async fn handle_triggers(trigger: db::scheduler::Trigger) -> Result<(), anyh
     match trigger.module {
         db::scheduler::TriggerModule::Report => handle_report_triggers(trigger).await,
         db::scheduler::TriggerModule::Alert => handle_alert_triggers(trigger).await,
+        db::scheduler::TriggerModule::Etl => crate::service::etl::handle_trigger(trigger).await,
     }
 }
 
@@ -127,7 +137,12 @@ async fn handle_alert_triggers(trigger: db::scheduler::Trigger) -> Result<(), an
 
     // evaluate alert
     let ret = alert.evaluate(None).await?;
-    if ret.is_some() && alert.trigger_condition.silence > 0 {
+    // deadman alerts notify once per series going missing or resolving, a
+    // silence would delay the resolution
+    if ret.is_some()
+        && alert.trigger_condition.silence > 0
+        && alert.query_condition.query_type != QueryType::Deadman
+    {
         new_trigger.next_run_at += Duration::try_minutes(alert.trigger_condition.silence)
             .unwrap()
             .num_microseconds()
@@ -148,6 +163,12 @@ async fn handle_alert_triggers(trigger: db::scheduler::Trigger) -> Result<(), an
             .num_microseconds()
             .unwrap();
     }
+    // spread the evaluations of the alerts due at the same time
+    new_trigger.next_run_at += super::scheduler::jitter(
+        org_id,
+        &trigger.module_key,
+        new_trigger.next_run_at - Utc::now().timestamp_micros(),
+    );
 
     let mut trigger_data_stream = TriggerData {
         org: trigger.org,
@@ -206,6 +227,7 @@ async fn handle_alert_triggers(trigger: db::scheduler::Trigger) -> Result<(), an
             &new_trigger.org,
             &new_trigger.module_key
         );
+        super::history::record_resolved(&alert).await;
         db::scheduler::update_trigger(new_trigger).await?;
         trigger_data_stream.status = TriggerDataStatus::ConditionNotSatisfied;
     }
@@ -296,6 +318,14 @@ async fn handle_report_triggers(trigger: db::scheduler::Trigger) -> Result<(), a
                 .timestamp_micros();
         }
     }
+    if !run_once {
+        // spread the reports due at the same time
+        new_trigger.next_run_at += super::scheduler::jitter(
+            org_id,
+            report_name,
+            new_trigger.next_run_at - Utc::now().timestamp_micros(),
+        );
+    }
 
     let mut trigger_data_stream = TriggerData {
         org: trigger.org.clone(),
@@ -0,0 +1,269 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Context of the logs alerts: a few of the matching records, each with the
+//! records logged just before and after it which share its `same_fields`,
+//! e.g. the same host. They are rendered like `grep -C`, the matching record
+//! marked with `>`, into the `{alert_context}` variable of the templates.
+
+use chrono::DateTime;
+use config::{
+    get_config, ider,
+    meta::{
+        search::{SearchEventContext, SearchEventType},
+        stream::StreamType,
+    },
+    utils::json::{self, Map, Value},
+};
+
+use crate::{
+    common::meta::alerts::{Alert, LogContext},
+    service::search as SearchService,
+};
+
+/// Matching records sent at most
+const MAX_SAMPLES: usize = 20;
+/// Records sent before and after each matching record at most
+const MAX_LINES: usize = 50;
+/// Seconds before and after a matching record its context is taken from, at most
+const MAX_WINDOW: i64 = 3600;
+
+/// Checks the context settings of the alert
+pub fn validate(alert: &Alert) -> Result<(), anyhow::Error> {
+    let Some(context) = alert.query_condition.context.as_ref() else {
+        return Ok(());
+    };
+    if alert.stream_type != StreamType::Logs {
+        return Err(anyhow::anyhow!(
+            "Only the alerts of logs streams can send context records"
+        ));
+    }
+    if context.samples == 0 || context.samples > MAX_SAMPLES {
+        return Err(anyhow::anyhow!(
+            "Alert context should send between 1 and {MAX_SAMPLES} samples"
+        ));
+    }
+    if context.lines > MAX_LINES {
+        return Err(anyhow::anyhow!(
+            "Alert context should send at most {MAX_LINES} lines around a sample"
+        ));
+    }
+    if context.window <= 0 || context.window > MAX_WINDOW {
+        return Err(anyhow::anyhow!(
+            "Alert context window should be between 1 and {MAX_WINDOW} seconds"
+        ));
+    }
+    Ok(())
+}
+
+/// Returns the lines of the context of the rows the alert fired for, none
+/// when the alert sends no context
+pub async fn collect(alert: &Alert, rows: &[Map<String, Value>]) -> Vec<String> {
+    let Some(context) = alert.query_condition.context.as_ref() else {
+        return vec![];
+    };
+    if alert.stream_type != StreamType::Logs {
+        return vec![];
+    }
+    let cfg = get_config();
+    let window = context.window * 1_000_000;
+    let mut lines = Vec::new();
+    let samples = rows
+        .iter()
+        .filter(|row| row.contains_key(&cfg.common.column_timestamp))
+        .take(context.samples);
+    for row in samples {
+        let ts = json::get_int_value(&row[&cfg.common.column_timestamp]);
+        let sql = build_sql(alert, context, row);
+        let (before, after) = match tokio::try_join!(
+            query(
+                alert,
+                &format!("{sql} DESC"),
+                context.lines,
+                ts - window,
+                ts
+            ),
+            query(
+                alert,
+                &format!("{sql} ASC"),
+                context.lines,
+                ts + 1,
+                ts + window + 1
+            ),
+        ) {
+            Ok(v) => v,
+            Err(e) => {
+                log::error!(
+                    "Error querying the context of alert {}/{}: {e}",
+                    alert.org_id,
+                    alert.name
+                );
+                (vec![], vec![])
+            }
+        };
+        if !lines.is_empty() {
+            lines.push("--".to_string());
+        }
+        lines.extend(
+            before
+                .iter()
+                .rev()
+                .map(|r| format!("  {}", render(context, r))),
+        );
+        lines.push(format!("> {}", render(context, row)));
+        lines.extend(after.iter().map(|r| format!("  {}", render(context, r))));
+    }
+    lines
+}
+
+/// Returns the query of the records sharing the `same_fields` of the row, to
+/// be completed with the sort order
+fn build_sql(alert: &Alert, context: &LogContext, row: &Map<String, Value>) -> String {
+    let conditions = context
+        .same_fields
+        .iter()
+        .map(|field| match row.get(field) {
+            None | Some(Value::Null) => format!("\"{field}\" IS NULL"),
+            Some(Value::String(v)) => format!("\"{field}\" = '{}'", v.replace('\'', "''")),
+            Some(v) => format!("\"{field}\" = {v}"),
+        })
+        .collect::<Vec<_>>();
+    let where_sql = if conditions.is_empty() {
+        String::new()
+    } else {
+        format!(" WHERE {}", conditions.join(" AND "))
+    };
+    format!(
+        "SELECT * FROM \"{}\"{where_sql} ORDER BY {}",
+        alert.stream_name,
+        get_config().common.column_timestamp
+    )
+}
+
+/// Renders a record as its time followed by its `fields`, or by the whole
+/// record when none is set
+fn render(context: &LogContext, record: &Map<String, Value>) -> String {
+    let ts_column = &get_config().common.column_timestamp;
+    let ts = record.get(ts_column).map(json::get_int_value).unwrap_or(0);
+    let time = DateTime::from_timestamp_micros(ts)
+        .map(|t| t.format("%Y-%m-%dT%H:%M:%S%.3fZ").to_string())
+        .unwrap_or_default();
+    let body = if context.fields.is_empty() {
+        let mut record = record.clone();
+        record.remove(ts_column);
+        json::to_string(&record).unwrap_or_default()
+    } else {
+        context
+            .fields
+            .iter()
+            .filter_map(|field| record.get(field))
+            .map(|v| match v {
+                Value::String(v) => v.clone(),
+                v => v.to_string(),
+            })
+            .collect::<Vec<_>>()
+            .join(" ")
+    };
+    format!("{time} {body}")
+}
+
+async fn query(
+    alert: &Alert,
+    sql: &str,
+    size: usize,
+    start: i64,
+    end: i64,
+) -> Result<Vec<Map<String, Value>>, anyhow::Error> {
+    if size == 0 {
+        return Ok(vec![]);
+    }
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql: sql.to_string(),
+            from: 0,
+            size: size as i64,
+            start_time: start,
+            end_time: end,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: std::collections::HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Alerts),
+        search_event_context: Some(SearchEventContext::with_alert(super::alert_key(alert))),
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, &alert.org_id, alert.stream_type, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("alert context query error: {e}"))?;
+    Ok(resp
+        .hits
+        .into_iter()
+        .filter_map(|hit| match hit {
+            Value::Object(hit) => Some(hit),
+            _ => None,
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_build_sql() {
+        let alert = Alert {
+            stream_name: "app".to_string(),
+            ..Default::default()
+        };
+        let context = LogContext {
+            same_fields: vec!["host".to_string(), "pod".to_string(), "code".to_string()],
+            ..Default::default()
+        };
+        let row = json::json!({"host": "it's", "code": 500})
+            .as_object()
+            .unwrap()
+            .clone();
+        assert_eq!(
+            build_sql(&alert, &context, &row),
+            "SELECT * FROM \"app\" WHERE \"host\" = 'it''s' AND \"pod\" IS NULL AND \"code\" = 500 ORDER BY _timestamp"
+        );
+    }
+
+    #[test]
+    fn test_render() {
+        let record =
+            json::json!({"_timestamp": 1700000000123456_i64, "level": "error", "log": "boom"})
+                .as_object()
+                .unwrap()
+                .clone();
+        let context = LogContext {
+            fields: vec!["level".to_string(), "log".to_string()],
+            ..Default::default()
+        };
+        assert_eq!(
+            render(&context, &record),
+            "2023-11-14T22:13:20.123Z error boom"
+        );
+        let context = LogContext::default();
+        assert_eq!(
+            render(&context, &record),
+            r#"2023-11-14T22:13:20.123Z {"level":"error","log":"boom"}"#
+        );
+    }
+}
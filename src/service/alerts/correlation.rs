@@ -0,0 +1,305 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Groups the alerts firing together and uses the dependencies between their
+//! services, found in the entity inventory, to point at the likely root cause.
+
+use std::collections::{HashMap, HashSet, VecDeque};
+
+use chrono::Utc;
+use config::{
+    get_config,
+    utils::json::{Map, Value},
+};
+
+use crate::{
+    common::meta::alerts::{Alert, AlertFiring, RootCauseHint},
+    service::{db, entities},
+};
+
+/// Row fields holding the service the alerting data came from
+const SERVICE_FIELDS: [&str; 2] = ["service_name", "service_service_name"];
+
+/// Records the firing of the alert and returns how it relates to the other
+/// alerts which fired within the correlation window
+pub async fn annotate(alert: &Alert, rows: &[Map<String, Value>]) -> Option<RootCauseHint> {
+    let window = get_config().limit.alert_correlation_window;
+    if window == 0 || rows.is_empty() {
+        return None;
+    }
+
+    let mut services = services_from_rows(rows);
+    if services.is_empty() {
+        services = entities::stream_services(&alert.org_id, alert.stream_type, &alert.stream_name)
+            .await
+            .unwrap_or_default();
+    }
+    let now = Utc::now().timestamp_micros();
+    let mut firing = AlertFiring {
+        alert_name: alert.name.to_string(),
+        stream_type: alert.stream_type,
+        stream_name: alert.stream_name.to_string(),
+        services,
+        fired_at: now,
+        hint: None,
+    };
+
+    let since = now - window * 1_000_000;
+    let mut recent = Vec::new();
+    for other in list_firings(&alert.org_id).await {
+        if other.is_same_alert(&firing) {
+            continue;
+        }
+        if other.fired_at < since {
+            if let Err(e) = db::alerts::firings::delete(&alert.org_id, &other).await {
+                log::error!("Error deleting expired alert firing: {}", e);
+            }
+            continue;
+        }
+        recent.push(other);
+    }
+
+    let deps = if firing.services.is_empty() || recent.is_empty() {
+        HashMap::new()
+    } else {
+        match entities::service_dependencies(&alert.org_id).await {
+            Ok(deps) => deps,
+            Err(e) => {
+                log::error!("Error getting service dependencies: {}", e);
+                HashMap::new()
+            }
+        }
+    };
+    firing.hint = analyze(&firing, &recent, &deps);
+    if let Err(e) = db::alerts::firings::set(&alert.org_id, &firing).await {
+        log::error!("Error saving alert firing: {}", e);
+    }
+    firing.hint
+}
+
+/// Returns the alerts which fired within the correlation window, latest first
+pub async fn list(org_id: &str) -> Vec<AlertFiring> {
+    let since =
+        Utc::now().timestamp_micros() - get_config().limit.alert_correlation_window * 1_000_000;
+    let mut firings = list_firings(org_id)
+        .await
+        .into_iter()
+        .filter(|f| f.fired_at >= since)
+        .collect::<Vec<_>>();
+    firings.sort_by(|a, b| b.fired_at.cmp(&a.fired_at));
+    firings
+}
+
+async fn list_firings(org_id: &str) -> Vec<AlertFiring> {
+    match db::alerts::firings::list(org_id).await {
+        Ok(firings) => firings,
+        Err(e) => {
+            log::error!("Error listing alert firings: {}", e);
+            vec![]
+        }
+    }
+}
+
+fn services_from_rows(rows: &[Map<String, Value>]) -> Vec<String> {
+    let mut services = Vec::new();
+    for row in rows {
+        let Some(name) = SERVICE_FIELDS
+            .iter()
+            .find_map(|f| row.get(*f).and_then(|v| v.as_str()))
+        else {
+            continue;
+        };
+        if !name.is_empty() && !services.iter().any(|s| s == name) {
+            services.push(name.to_string());
+        }
+    }
+    services
+}
+
+/// Returns every service the given services depend on, directly or not
+fn upstream_of(services: &[String], deps: &HashMap<String, Vec<String>>) -> HashSet<String> {
+    let mut seen = HashSet::new();
+    let mut queue = services.iter().collect::<VecDeque<_>>();
+    while let Some(service) = queue.pop_front() {
+        for dep in deps.get(service).into_iter().flatten() {
+            if seen.insert(dep.to_string()) {
+                queue.push_back(dep);
+            }
+        }
+    }
+    seen
+}
+
+fn overlaps(services: &[String], set: &HashSet<String>) -> bool {
+    services.iter().any(|s| set.contains(s))
+}
+
+fn describe(firing: &AlertFiring) -> String {
+    if firing.services.is_empty() {
+        format!("{} on {}", firing.alert_name, firing.stream_name)
+    } else {
+        format!("{} on {}", firing.alert_name, firing.services.join(", "))
+    }
+}
+
+/// Relates the firing to the other recent firings. An alert is upstream of
+/// another when its services are called, directly or not, by the services of
+/// the other one.
+pub(crate) fn analyze(
+    current: &AlertFiring,
+    others: &[AlertFiring],
+    deps: &HashMap<String, Vec<String>>,
+) -> Option<RootCauseHint> {
+    let upstream_services = upstream_of(&current.services, deps);
+    let current_services = current.services.iter().cloned().collect::<HashSet<_>>();
+    let mut upstream = Vec::new();
+    let mut downstream = Vec::new();
+    let mut related = Vec::new();
+    for other in others {
+        if overlaps(&other.services, &upstream_services) {
+            upstream.push(other);
+        } else if overlaps(&current.services, &upstream_of(&other.services, deps)) {
+            downstream.push(other.alert_name.to_string());
+        } else if !overlaps(&other.services, &current_services) {
+            continue;
+        }
+        related.push(other.alert_name.to_string());
+    }
+    if related.is_empty() {
+        return None;
+    }
+    related.sort();
+    downstream.sort();
+
+    // the deepest upstream alert is the likely cause, the earliest one wins
+    let roots = upstream
+        .iter()
+        .copied()
+        .filter(|c| {
+            let reach = upstream_of(&c.services, deps);
+            !upstream
+                .iter()
+                .any(|o| !o.is_same_alert(c) && overlaps(&o.services, &reach))
+        })
+        .collect::<Vec<_>>();
+    let candidates = if roots.is_empty() { &upstream } else { &roots };
+    let cause = candidates.iter().copied().min_by_key(|c| c.fired_at);
+
+    let message = if let Some(cause) = cause {
+        format!(
+            "{} is likely caused by upstream alert {} which fired {}s earlier",
+            describe(current),
+            describe(cause),
+            (current.fired_at - cause.fired_at).max(0) / 1_000_000
+        )
+    } else if !downstream.is_empty() {
+        format!(
+            "{} precedes {} downstream alert(s): {}",
+            describe(current),
+            downstream.len(),
+            downstream.join(", ")
+        )
+    } else {
+        format!(
+            "{} fired together with {} other alert(s) on the same service: {}",
+            describe(current),
+            related.len(),
+            related.join(", ")
+        )
+    };
+    Some(RootCauseHint {
+        upstream_cause: cause.map(|c| c.alert_name.to_string()),
+        downstream,
+        related,
+        message,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn firing(name: &str, service: &str, fired_at: i64) -> AlertFiring {
+        AlertFiring {
+            alert_name: name.to_string(),
+            stream_name: "default".to_string(),
+            services: vec![service.to_string()],
+            fired_at: fired_at * 1_000_000,
+            ..Default::default()
+        }
+    }
+
+    fn deps() -> HashMap<String, Vec<String>> {
+        HashMap::from([
+            ("frontend".to_string(), vec!["checkout".to_string()]),
+            ("checkout".to_string(), vec!["postgresql".to_string()]),
+        ])
+    }
+
+    #[test]
+    fn test_analyze_upstream_cause() {
+        let others = vec![
+            firing("db_latency", "postgresql", 10),
+            firing("checkout_errors", "checkout", 20),
+            firing("billing_errors", "billing", 20),
+        ];
+        let hint = analyze(&firing("frontend_errors", "frontend", 30), &others, &deps()).unwrap();
+        assert_eq!(hint.upstream_cause.as_deref(), Some("db_latency"));
+        assert_eq!(hint.related, vec!["checkout_errors", "db_latency"]);
+        assert!(hint.downstream.is_empty());
+        assert_eq!(
+            hint.message,
+            "frontend_errors on frontend is likely caused by upstream alert db_latency on postgresql which fired 20s earlier"
+        );
+    }
+
+    #[test]
+    fn test_analyze_downstream() {
+        let others = vec![
+            firing("checkout_errors", "checkout", 20),
+            firing("frontend_errors", "frontend", 20),
+        ];
+        let hint = analyze(&firing("db_latency", "postgresql", 30), &others, &deps()).unwrap();
+        assert_eq!(hint.upstream_cause, None);
+        assert_eq!(hint.downstream, vec!["checkout_errors", "frontend_errors"]);
+        assert_eq!(
+            hint.message,
+            "db_latency on postgresql precedes 2 downstream alert(s): checkout_errors, frontend_errors"
+        );
+    }
+
+    #[test]
+    fn test_analyze_unrelated() {
+        let others = vec![firing("billing_errors", "billing", 20)];
+        assert!(analyze(&firing("db_latency", "postgresql", 30), &others, &deps()).is_none());
+        let others = vec![firing("db_errors", "postgresql", 20)];
+        let hint = analyze(&firing("db_latency", "postgresql", 30), &others, &deps()).unwrap();
+        assert_eq!(hint.related, vec!["db_errors"]);
+    }
+
+    #[test]
+    fn test_services_from_rows() {
+        let rows = vec![
+            config::utils::json::json!({"service_name": "checkout"}),
+            config::utils::json::json!({"service_name": "checkout"}),
+            config::utils::json::json!({"service_service_name": "frontend"}),
+        ];
+        let rows = rows
+            .into_iter()
+            .map(|v| v.as_object().unwrap().clone())
+            .collect::<Vec<_>>();
+        assert_eq!(services_from_rows(&rows), vec!["checkout", "frontend"]);
+    }
+}
@@ -0,0 +1,401 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Deadman alerts fire when a series of the stream, told apart by the group by
+//! fields, stops receiving data for the period of the alert. The last data
+//! seen of every series is kept between two evaluations, so each series fires
+//! once when it goes missing and resolves once it sends data again.
+
+use std::collections::HashMap;
+
+use chrono::{Duration, Utc};
+use config::{
+    get_config, ider,
+    meta::search::{SearchEventContext, SearchEventType},
+    utils::json::{self, Map, Value},
+};
+
+use crate::{
+    common::meta::alerts::{Alert, DeadmanCondition, DeadmanSeries, DeadmanState},
+    service::{db, search as SearchService},
+};
+
+/// Series returned by one evaluation, the others are ignored until the next
+const MAX_SERIES: i64 = 10000;
+
+/// Updates the series of the alert and returns the rows of the series which
+/// went missing or resolved since the last evaluation
+pub async fn evaluate(alert: &Alert) -> Result<Option<Vec<Map<String, Value>>>, anyhow::Error> {
+    let deadman = alert.query_condition.deadman.clone().unwrap_or_default();
+    let now = Utc::now().timestamp_micros();
+    let absent_for = minutes(alert.trigger_condition.period);
+    let mut state = db::alerts::deadman::get(
+        &alert.org_id,
+        alert.stream_type,
+        &alert.stream_name,
+        &alert.name,
+    )
+    .await?;
+    // the data since the last evaluation is enough as the older series are
+    // known, the window still covers one period to catch late data
+    let start = (now - 2 * absent_for).max(state.evaluated_at - absent_for);
+    let hits = query(alert, start, now).await?;
+    let rows = update(&mut state, &deadman, hits, start, now, absent_for);
+    db::alerts::deadman::set(
+        &alert.org_id,
+        alert.stream_type,
+        &alert.stream_name,
+        &alert.name,
+        &state,
+    )
+    .await?;
+    Ok(if rows.is_empty() { None } else { Some(rows) })
+}
+
+/// Checks the query of the alert runs, without touching its series
+pub async fn check(alert: &Alert) -> Result<(), anyhow::Error> {
+    if alert.trigger_condition.period <= 0 {
+        return Err(anyhow::anyhow!(
+            "Deadman alert should have a period of at least 1 minute"
+        ));
+    }
+    let now = Utc::now().timestamp_micros();
+    query(alert, now - minutes(alert.trigger_condition.period), now).await?;
+    Ok(())
+}
+
+/// `SELECT <group by>, MAX(_timestamp) AS last_seen` of the stream, filtered
+/// by the conditions of the alert
+pub async fn build_sql(alert: &Alert) -> Result<String, anyhow::Error> {
+    let schema = infra::schema::get(&alert.org_id, &alert.stream_name, alert.stream_type).await?;
+    let group_by = alert
+        .query_condition
+        .deadman
+        .as_ref()
+        .map(|deadman| deadman.group_by.clone())
+        .unwrap_or_default();
+    for field in group_by.iter() {
+        if schema.field_with_name(field).is_err() {
+            return Err(anyhow::anyhow!(
+                "Group by field {} not found on stream {}",
+                field,
+                &alert.stream_name
+            ));
+        }
+    }
+    let where_sql = super::build_where(
+        alert,
+        &schema,
+        alert
+            .query_condition
+            .conditions
+            .as_deref()
+            .unwrap_or_default(),
+    )?;
+
+    let column_timestamp = &get_config().common.column_timestamp;
+    if group_by.is_empty() {
+        return Ok(format!(
+            "SELECT MAX({column_timestamp}) AS last_seen FROM \"{}\" {where_sql}",
+            alert.stream_name
+        ));
+    }
+    let fields = group_by
+        .iter()
+        .map(|field| format!("\"{field}\""))
+        .collect::<Vec<_>>()
+        .join(", ");
+    Ok(format!(
+        "SELECT {fields}, MAX({column_timestamp}) AS last_seen FROM \"{}\" {where_sql} GROUP BY {fields}",
+        alert.stream_name
+    ))
+}
+
+/// Unlike the other alerts a failed query is an error, taking it for missing
+/// data would fire every series
+async fn query(alert: &Alert, start: i64, end: i64) -> Result<Vec<Value>, anyhow::Error> {
+    let sql = build_sql(alert).await?;
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql,
+            from: 0,
+            size: MAX_SERIES,
+            start_time: start,
+            end_time: end,
+            sort_by: None,
+            sql_mode: "full".to_string(),
+            quick_mode: false,
+            query_type: "".to_string(),
+            track_total_hits: false,
+            uses_zo_fn: false,
+            query_context: None,
+            query_fn: None,
+            skip_wal: false,
+        },
+        aggs: HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Alerts),
+        search_event_context: Some(SearchEventContext::with_alert(super::alert_key(alert))),
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, &alert.org_id, alert.stream_type, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("deadman query error: {e}"))?;
+    Ok(resp.hits)
+}
+
+/// Applies the query results to the series and returns the rows of the series
+/// changing state
+fn update(
+    state: &mut DeadmanState,
+    deadman: &DeadmanCondition,
+    hits: Vec<Value>,
+    start: i64,
+    now: i64,
+    absent_for: i64,
+) -> Vec<Map<String, Value>> {
+    for hit in hits {
+        let last_seen = hit.get("last_seen").map(json::get_int_value).unwrap_or(0);
+        if last_seen == 0 {
+            continue; // no data at all in the window of an ungrouped alert
+        }
+        let labels = deadman
+            .group_by
+            .iter()
+            .map(|field| {
+                (
+                    field.clone(),
+                    hit.get(field).cloned().unwrap_or(Value::Null),
+                )
+            })
+            .collect::<HashMap<_, _>>();
+        let series = state
+            .series
+            .entry(series_key(&deadman.group_by, &labels))
+            .or_insert_with(|| DeadmanSeries {
+                labels: labels.into_iter().collect(),
+                ..Default::default()
+            });
+        series.last_seen = series.last_seen.max(last_seen);
+    }
+    if deadman.group_by.is_empty() {
+        // the stream is expected to have data from the first evaluation on
+        state
+            .series
+            .entry(String::new())
+            .or_insert_with(|| DeadmanSeries {
+                last_seen: start,
+                ..Default::default()
+            });
+    } else if deadman.forget_after > 0 {
+        let forget_after = minutes(deadman.forget_after);
+        state
+            .series
+            .retain(|_, series| now - series.last_seen < forget_after);
+    }
+
+    let mut keys = state.series.keys().cloned().collect::<Vec<_>>();
+    keys.sort();
+    let mut rows = Vec::new();
+    for key in keys {
+        let series = state.series.get_mut(&key).unwrap();
+        let absent = now - series.last_seen >= absent_for;
+        let status = if absent && !series.missing {
+            series.missing = true;
+            "missing"
+        } else if !absent && series.missing {
+            series.missing = false;
+            if !deadman.notify_resolved {
+                continue;
+            }
+            "resolved"
+        } else {
+            continue;
+        };
+        let mut row = series
+            .labels
+            .iter()
+            .map(|(k, v)| (k.clone(), v.clone()))
+            .collect::<Map<_, _>>();
+        row.insert(
+            get_config().common.column_timestamp.clone(),
+            series.last_seen.into(),
+        );
+        row.insert(
+            "absent_minutes".to_string(),
+            ((now - series.last_seen) / minutes(1)).into(),
+        );
+        row.insert("deadman_status".to_string(), status.into());
+        rows.push(row);
+    }
+    state.evaluated_at = now;
+    rows
+}
+
+fn series_key(group_by: &[String], labels: &HashMap<String, Value>) -> String {
+    group_by
+        .iter()
+        .map(|field| match labels.get(field) {
+            Some(Value::String(v)) => v.clone(),
+            Some(Value::Null) | None => String::new(),
+            Some(v) => v.to_string(),
+        })
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+fn minutes(n: i64) -> i64 {
+    Duration::try_minutes(n)
+        .unwrap()
+        .num_microseconds()
+        .unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MINUTE: i64 = 60_000_000;
+
+    fn host(name: &str, last_seen: i64) -> Value {
+        json::json!({"host": name, "last_seen": last_seen})
+    }
+
+    #[test]
+    fn test_update_grouped() {
+        let deadman = DeadmanCondition {
+            group_by: vec!["host".to_string()],
+            forget_after: 60,
+            notify_resolved: true,
+        };
+        let mut state = DeadmanState::default();
+        let now = 100 * MINUTE;
+
+        // both hosts report
+        let hits = vec![host("a", now - MINUTE), host("b", now - 2 * MINUTE)];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert!(rows.is_empty());
+        assert_eq!(state.series.len(), 2);
+
+        // host b stops
+        let now = now + 5 * MINUTE;
+        let hits = vec![host("a", now - MINUTE)];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0]["host"], "b");
+        assert_eq!(rows[0]["deadman_status"], "missing");
+        assert_eq!(rows[0]["absent_minutes"], 7);
+
+        // still missing, not notified again
+        let now = now + MINUTE;
+        let rows = update(
+            &mut state,
+            &deadman,
+            vec![],
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert!(rows.is_empty());
+
+        // host b comes back
+        let now = now + MINUTE;
+        let hits = vec![host("a", now), host("b", now)];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0]["deadman_status"], "resolved");
+
+        // host a is forgotten after an hour without data
+        let now = now + 61 * MINUTE;
+        let hits = vec![host("b", now)];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert!(rows.is_empty());
+        assert_eq!(state.series.len(), 1);
+        assert!(state.series.contains_key("b"));
+    }
+
+    #[test]
+    fn test_update_ungrouped() {
+        let deadman = DeadmanCondition::default();
+        let mut state = DeadmanState::default();
+        let now = 100 * MINUTE;
+
+        // no data in the window of the first evaluation
+        let hits = vec![json::json!({"last_seen": null})];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0]["deadman_status"], "missing");
+
+        let hits = vec![json::json!({"last_seen": now})];
+        let rows = update(
+            &mut state,
+            &deadman,
+            hits,
+            now - 10 * MINUTE,
+            now,
+            5 * MINUTE,
+        );
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0]["deadman_status"], "resolved");
+    }
+
+    #[test]
+    fn test_series_key() {
+        let group_by = vec!["host".to_string(), "port".to_string()];
+        let labels = HashMap::from([
+            ("host".to_string(), Value::from("db1")),
+            ("port".to_string(), Value::from(5432)),
+        ]);
+        assert_eq!(series_key(&group_by, &labels), "db1/5432");
+    }
+}
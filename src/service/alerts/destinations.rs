@@ -19,12 +19,14 @@ use crate::{
     common::{
         infra::config::STREAM_ALERTS,
         meta::{
-            alerts::destinations::{Destination, DestinationType, DestinationWithTemplate},
+            alerts::destinations::{
+                Destination, DestinationType, DestinationWithTemplate, OverflowAction,
+            },
             authz::Authz,
         },
         utils::auth::{remove_ownership, set_ownership},
     },
-    service::db,
+    service::{db, plugins, secrets},
 };
 
 pub async fn save(
@@ -57,6 +59,17 @@ pub async fn save(
                 ));
             }
         }
+        DestinationType::Plugin => {
+            let Some(target) = destination.plugin.as_ref() else {
+                return Err((
+                    http::StatusCode::BAD_REQUEST,
+                    anyhow::anyhow!("Alert destination plugin needs to be specified"),
+                ));
+            };
+            if let Err(e) = plugins::check_destination(&target.plugin) {
+                return Err((http::StatusCode::BAD_REQUEST, e));
+            }
+        }
     }
 
     if !name.is_empty() {
@@ -76,6 +89,27 @@ pub async fn save(
         ));
     }
 
+    if let Err(e) = super::throttle::validate(&destination) {
+        return Err((http::StatusCode::BAD_REQUEST, anyhow::anyhow!(e)));
+    }
+    if destination.overflow == OverflowAction::Fallback
+        && db::alerts::destinations::get(org_id, &destination.fallback_destination)
+            .await
+            .is_err()
+    {
+        return Err((
+            http::StatusCode::BAD_REQUEST,
+            anyhow::anyhow!(
+                "Fallback destination {} not found",
+                destination.fallback_destination
+            ),
+        ));
+    }
+
+    if let Err(e) = secrets::check_refs(org_id, "destinations", &destination).await {
+        return Err((http::StatusCode::BAD_REQUEST, e));
+    }
+
     if db::alerts::templates::get(org_id, &destination.template)
         .await
         .is_err()
@@ -126,7 +160,9 @@ pub async fn get_with_template(
     org_id: &str,
     name: &str,
 ) -> Result<DestinationWithTemplate, anyhow::Error> {
-    let dest = get(org_id, name).await?;
+    // the secrets are resolved on every use, so a rotated secret is picked up
+    // without saving the destination again
+    let dest = secrets::resolve(org_id, "destinations", &get(org_id, name).await?).await?;
     let template = db::alerts::templates::get(org_id, &dest.template).await?;
     Ok(dest.with_template(template))
 }
@@ -182,6 +218,9 @@ pub async fn delete(org_id: &str, name: &str) -> Result<(), (http::StatusCode, a
     match db::alerts::destinations::delete(org_id, name).await {
         Ok(_) => {
             remove_ownership(org_id, "destinations", Authz::new(name)).await;
+            if let Err(e) = db::alerts::throttle::delete(org_id, name).await {
+                log::error!("Failed to delete destination throttle state: {}", e);
+            }
             Ok(())
         }
         Err(e) => Err((http::StatusCode::INTERNAL_SERVER_ERROR, e)),
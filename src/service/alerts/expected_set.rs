@@ -0,0 +1,365 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Expected set alerts fire while members of a reference list, listed in the
+//! alert or read from an enrichment table, e.g. all the production hosts, have
+//! no data in the period of the alert. Every evaluation returns one row per
+//! missing member.
+
+use std::collections::{BTreeSet, HashMap, HashSet};
+
+use arrow_schema::DataType;
+use chrono::{Duration, Utc};
+use config::{
+    ider,
+    meta::{
+        search::{SearchEventContext, SearchEventType},
+        stream::StreamType,
+    },
+    utils::{
+        json::{Map, Value},
+        time::BASE_TIME,
+    },
+};
+
+use crate::{
+    common::meta::alerts::{Alert, ExpectedSetCondition},
+    service::search as SearchService,
+};
+
+/// Members of the expected set at most
+const MAX_MEMBERS: usize = 10000;
+
+/// Returns the rows of the members without data in the period, if any
+pub async fn evaluate(alert: &Alert) -> Result<Option<Vec<Map<String, Value>>>, anyhow::Error> {
+    let expected = alert
+        .query_condition
+        .expected_set
+        .clone()
+        .unwrap_or_default();
+    let members = members(alert, &expected).await?;
+    let now = Utc::now().timestamp_micros();
+    let hits = query(
+        alert,
+        alert.stream_type,
+        &build_sql(alert, &members).await?,
+        now - minutes(alert.trigger_condition.period),
+        now,
+    )
+    .await?;
+    let rows = missing(&expected, &members, &hits);
+    Ok(if rows.is_empty() { None } else { Some(rows) })
+}
+
+/// Checks the settings of the alert, its reference list and that its query
+/// runs
+pub async fn check(alert: &Alert) -> Result<(), anyhow::Error> {
+    let Some(expected) = alert.query_condition.expected_set.as_ref() else {
+        return Err(anyhow::anyhow!(
+            "Expected set alert should have an expected set"
+        ));
+    };
+    if expected.field.trim().is_empty() {
+        return Err(anyhow::anyhow!(
+            "Expected set alert should have the field holding the members"
+        ));
+    }
+    if expected.members.is_empty() && expected.enrichment_table.is_none() {
+        return Err(anyhow::anyhow!(
+            "Expected set alert should list its members or an enrichment table"
+        ));
+    }
+    if alert.trigger_condition.period <= 0 {
+        return Err(anyhow::anyhow!(
+            "Expected set alert should have a period of at least 1 minute"
+        ));
+    }
+    let members = members(alert, expected).await?;
+    let now = Utc::now().timestamp_micros();
+    query(
+        alert,
+        alert.stream_type,
+        &build_sql(alert, &members).await?,
+        now - minutes(1),
+        now,
+    )
+    .await?;
+    Ok(())
+}
+
+/// `SELECT <field>, COUNT(*) AS count` of the stream filtered by the
+/// conditions of the alert and restricted to the `members`, grouped by the
+/// field
+pub async fn build_sql(alert: &Alert, members: &BTreeSet<String>) -> Result<String, anyhow::Error> {
+    let expected = alert
+        .query_condition
+        .expected_set
+        .clone()
+        .unwrap_or_default();
+    let field = &expected.field;
+    let schema = infra::schema::get(&alert.org_id, &alert.stream_name, alert.stream_type).await?;
+    let Ok(schema_field) = schema.field_with_name(field) else {
+        return Err(anyhow::anyhow!(
+            "Field {} not found on stream {}",
+            field,
+            &alert.stream_name
+        ));
+    };
+    let mut where_sql = super::build_where(
+        alert,
+        &schema,
+        alert
+            .query_condition
+            .conditions
+            .as_deref()
+            .unwrap_or_default(),
+    )?;
+    if let Some(in_list) = in_list(schema_field.data_type(), members) {
+        where_sql = if where_sql.is_empty() {
+            format!("WHERE \"{field}\" IN ({in_list})")
+        } else {
+            format!("{where_sql} AND \"{field}\" IN ({in_list})")
+        };
+    }
+    Ok(format!(
+        "SELECT \"{field}\", COUNT(*) AS count FROM \"{}\" {where_sql} GROUP BY \"{field}\"",
+        alert.stream_name
+    ))
+}
+
+/// Members listed in the alert and in its enrichment table
+async fn members(
+    alert: &Alert,
+    expected: &ExpectedSetCondition,
+) -> Result<BTreeSet<String>, anyhow::Error> {
+    let mut members = expected
+        .members
+        .iter()
+        .map(|m| m.trim().to_string())
+        .filter(|m| !m.is_empty())
+        .collect::<BTreeSet<_>>();
+    if let Some(table) = expected.enrichment_table.as_deref() {
+        members.extend(table_members(alert, expected, table).await?);
+    }
+    // an empty set would never fire, most likely the table was emptied
+    if members.is_empty() {
+        return Err(anyhow::anyhow!("The expected set is empty"));
+    }
+    if members.len() > MAX_MEMBERS {
+        return Err(anyhow::anyhow!(
+            "The expected set has more than {MAX_MEMBERS} members"
+        ));
+    }
+    Ok(members)
+}
+
+/// Values of the column of the enrichment table, of its rows matching the
+/// filters
+async fn table_members(
+    alert: &Alert,
+    expected: &ExpectedSetCondition,
+    table: &str,
+) -> Result<Vec<String>, anyhow::Error> {
+    let column = if expected.column.is_empty() {
+        &expected.field
+    } else {
+        &expected.column
+    };
+    let schema = infra::schema::get(&alert.org_id, table, StreamType::EnrichmentTables).await?;
+    if schema.fields().is_empty() {
+        return Err(anyhow::anyhow!("Enrichment table {table} not found"));
+    }
+    let mut filters = expected.filters.iter().collect::<Vec<_>>();
+    filters.sort();
+    for name in std::iter::once(column).chain(filters.iter().map(|(k, _)| *k)) {
+        if schema.field_with_name(name).is_err() {
+            return Err(anyhow::anyhow!(
+                "Column {name} not found on enrichment table {table}"
+            ));
+        }
+    }
+    let where_sql = if filters.is_empty() {
+        String::new()
+    } else {
+        format!(
+            "WHERE {}",
+            filters
+                .iter()
+                .map(|(k, v)| format!("\"{k}\" = '{}'", v.replace('\'', "''")))
+                .collect::<Vec<_>>()
+                .join(" AND ")
+        )
+    };
+    let sql = format!("SELECT \"{column}\" FROM \"{table}\" {where_sql} GROUP BY \"{column}\"");
+    let hits = query(
+        alert,
+        StreamType::EnrichmentTables,
+        &sql,
+        BASE_TIME.timestamp_micros(),
+        Utc::now().timestamp_micros(),
+    )
+    .await?;
+    Ok(hits
+        .iter()
+        .filter_map(|hit| hit.get(column).and_then(member))
+        .collect())
+}
+
+/// Returns the rows of the members missing from the hits
+fn missing(
+    expected: &ExpectedSetCondition,
+    members: &BTreeSet<String>,
+    hits: &[Value],
+) -> Vec<Map<String, Value>> {
+    let seen = hits
+        .iter()
+        .filter_map(|hit| hit.get(&expected.field).and_then(member))
+        .collect::<HashSet<_>>();
+    members
+        .iter()
+        .filter(|m| !seen.contains(*m))
+        .map(|m| {
+            let mut row = Map::new();
+            row.insert(expected.field.clone(), m.clone().into());
+            row.insert("expected_set_status".to_string(), "missing".into());
+            row
+        })
+        .collect()
+}
+
+/// Returns the members as SQL literals of the type of the field, none when
+/// there is nothing to restrict
+fn in_list(data_type: &DataType, members: &BTreeSet<String>) -> Option<String> {
+    let literals = match data_type {
+        DataType::Utf8 | DataType::LargeUtf8 => members
+            .iter()
+            .map(|m| format!("'{}'", m.replace('\'', "''")))
+            .collect::<Vec<_>>(),
+        // the members which are not numbers can't match, they stay missing
+        _ => members
+            .iter()
+            .filter(|m| m.parse::<f64>().is_ok())
+            .cloned()
+            .collect::<Vec<_>>(),
+    };
+    if literals.is_empty() {
+        None
+    } else {
+        Some(literals.join(", "))
+    }
+}
+
+fn member(value: &Value) -> Option<String> {
+    match value {
+        Value::Null => None,
+        Value::String(v) if v.trim().is_empty() => None,
+        Value::String(v) => Some(v.trim().to_string()),
+        v => Some(v.to_string()),
+    }
+}
+
+/// A failed query is an error, taking it for missing data would fire every
+/// member
+async fn query(
+    alert: &Alert,
+    stream_type: StreamType,
+    sql: &str,
+    start: i64,
+    end: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql: sql.to_string(),
+            from: 0,
+            size: MAX_MEMBERS as i64 + 1,
+            start_time: start,
+            end_time: end,
+            sort_by: None,
+            sql_mode: "full".to_string(),
+            quick_mode: false,
+            query_type: "".to_string(),
+            track_total_hits: false,
+            uses_zo_fn: false,
+            query_context: None,
+            query_fn: None,
+            skip_wal: false,
+        },
+        aggs: HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Alerts),
+        search_event_context: Some(SearchEventContext::with_alert(super::alert_key(alert))),
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, &alert.org_id, stream_type, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("expected set query error: {e}"))?;
+    Ok(resp.hits)
+}
+
+fn minutes(n: i64) -> i64 {
+    Duration::try_minutes(n)
+        .unwrap()
+        .num_microseconds()
+        .unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    #[test]
+    fn test_missing() {
+        let expected = ExpectedSetCondition {
+            field: "host".to_string(),
+            ..Default::default()
+        };
+        let members = ["a", "b", "c", "1"]
+            .into_iter()
+            .map(String::from)
+            .collect::<BTreeSet<_>>();
+        let hits = vec![
+            json::json!({"host": "a", "count": 10}),
+            json::json!({"host": " c ", "count": 1}),
+            json::json!({"host": 1, "count": 1}),
+            json::json!({"host": null, "count": 3}),
+        ];
+        let rows = missing(&expected, &members, &hits);
+        assert_eq!(rows.len(), 1);
+        assert_eq!(rows[0]["host"], json::json!("b"));
+        assert_eq!(rows[0]["expected_set_status"], json::json!("missing"));
+
+        let hits = vec![json::json!({"host": "x"})];
+        assert_eq!(missing(&expected, &members, &hits).len(), 4);
+    }
+
+    #[test]
+    fn test_in_list() {
+        let members = ["o'brien", "web-1", "42"]
+            .into_iter()
+            .map(String::from)
+            .collect::<BTreeSet<_>>();
+        assert_eq!(
+            in_list(&DataType::Utf8, &members).unwrap(),
+            "'42', 'o''brien', 'web-1'"
+        );
+        assert_eq!(in_list(&DataType::Int64, &members).unwrap(), "42");
+        assert_eq!(in_list(&DataType::Int64, &BTreeSet::new()), None);
+    }
+}
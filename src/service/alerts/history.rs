@@ -0,0 +1,403 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! State history of the alerts. Every transition of an alert between resolved,
+//! pending and firing is written to the [ALERT_HISTORY_STREAM] of its
+//! organization with the destinations notified, and the stats below aggregate
+//! it into fire counts and times to resolve. Realtime alerts are evaluated on
+//! ingestion and never resolve, each of their notifications is recorded as a
+//! new firing.
+
+use std::collections::HashMap;
+
+use chrono::Utc;
+use config::{
+    ider,
+    meta::{search::SearchEventType, stream::StreamType},
+    utils::json::{self, Map, Value},
+};
+use proto::cluster_rpc;
+
+use crate::{
+    common::meta::alerts::{
+        Alert, AlertHistoryStat, AlertHistoryStats, AlertState, AlertStatus, QueryType,
+        ALERT_HISTORY_STREAM,
+    },
+    service::{db, search as SearchService, usage::ingestion_service},
+};
+
+/// Groups returned by the stats query
+const MAX_STATS_ALERTS: i64 = 10000;
+
+/// Destinations of one notification of an alert
+#[derive(Debug, Default)]
+pub struct Notified {
+    pub sent: Vec<String>,
+    /// rate limited, in quiet hours or routed to their fallback
+    pub held: Vec<String>,
+    pub failed: Vec<String>,
+}
+
+/// Records the notification of the rows of the alert, the alert fires when at
+/// least one destination was notified and is pending otherwise
+pub async fn record_notified(alert: &Alert, rows: &[Map<String, Value>], notified: &Notified) {
+    if rows.is_empty() {
+        return; // triggered by hand
+    }
+    let ret = if is_deadman_resolution(alert, rows) {
+        transition(alert, AlertStatus::Resolved, 0, None).await
+    } else if notified.sent.is_empty() {
+        transition(alert, AlertStatus::Pending, rows.len(), Some(notified)).await
+    } else {
+        transition(alert, AlertStatus::Firing, rows.len(), Some(notified)).await
+    };
+    if let Err(e) = ret {
+        log::error!(
+            "Error recording the state of alert {}/{}: {}",
+            alert.org_id,
+            alert.name,
+            e
+        );
+    }
+}
+
+/// Records the condition of the alert is no longer met
+pub async fn record_resolved(alert: &Alert) {
+    // a deadman alert only resolves through the rows of its series
+    if alert.is_real_time || alert.query_condition.query_type == QueryType::Deadman {
+        return;
+    }
+    if let Err(e) = transition(alert, AlertStatus::Resolved, 0, None).await {
+        log::error!(
+            "Error recording the state of alert {}/{}: {}",
+            alert.org_id,
+            alert.name,
+            e
+        );
+    }
+}
+
+/// The rows of a deadman alert only notify series which resumed
+fn is_deadman_resolution(alert: &Alert, rows: &[Map<String, Value>]) -> bool {
+    alert.query_condition.query_type == QueryType::Deadman
+        && rows.iter().all(|row| {
+            row.get("deadman_status")
+                .and_then(|v| v.as_str())
+                .is_some_and(|status| status == "resolved")
+        })
+}
+
+async fn transition(
+    alert: &Alert,
+    status: AlertStatus,
+    rows: usize,
+    notified: Option<&Notified>,
+) -> Result<(), anyhow::Error> {
+    let now = Utc::now().timestamp_micros();
+    if alert.is_real_time {
+        let state = AlertState {
+            status: AlertStatus::Resolved,
+            started_at: now,
+            changed_at: now,
+        };
+        let record = event_record(alert, &state, status, now, rows, notified);
+        return write(&alert.org_id, vec![record]).await;
+    }
+
+    let mut state = db::alerts::states::get(
+        &alert.org_id,
+        alert.stream_type,
+        &alert.stream_name,
+        &alert.name,
+    )
+    .await?;
+    let Some(status) = next_status(state.status, status) else {
+        return Ok(());
+    };
+    if state.status == AlertStatus::Resolved {
+        state.started_at = now;
+    }
+    let record = event_record(alert, &state, status, now, rows, notified);
+    state.status = status;
+    state.changed_at = now;
+    db::alerts::states::set(
+        &alert.org_id,
+        alert.stream_type,
+        &alert.stream_name,
+        &alert.name,
+        &state,
+    )
+    .await?;
+    write(&alert.org_id, vec![record]).await
+}
+
+/// Returns the status to move to, if it is a transition. A firing alert whose
+/// next notifications are held stays firing.
+fn next_status(current: AlertStatus, status: AlertStatus) -> Option<AlertStatus> {
+    match (current, status) {
+        (AlertStatus::Firing, AlertStatus::Pending) => None,
+        (current, status) if current == status => None,
+        (_, status) => Some(status),
+    }
+}
+
+fn event_record(
+    alert: &Alert,
+    state: &AlertState,
+    status: AlertStatus,
+    now: i64,
+    rows: usize,
+    notified: Option<&Notified>,
+) -> Value {
+    let alert_type = super::alert_type(alert);
+    let time_to_resolve = if status == AlertStatus::Resolved {
+        (now - state.started_at) as f64 / 1_000_000.0
+    } else {
+        0.0
+    };
+    let mut record = json::json!({
+        "_timestamp": now,
+        "alert_name": alert.name,
+        "stream_type": alert.stream_type.to_string(),
+        "stream_name": alert.stream_name,
+        "alert_type": alert_type,
+        "from_status": state.status.to_string(),
+        "status": status.to_string(),
+        "incident_started_at": state.started_at,
+        "time_to_resolve": time_to_resolve,
+        "rows": rows,
+    });
+    if let (Some(notified), Some(map)) = (notified, record.as_object_mut()) {
+        for (field, destinations) in [
+            ("destinations", &notified.sent),
+            ("held_destinations", &notified.held),
+            ("failed_destinations", &notified.failed),
+        ] {
+            if !destinations.is_empty() {
+                map.insert(field.to_string(), destinations.join(",").into());
+            }
+        }
+    }
+    record
+}
+
+async fn write(org_id: &str, records: Vec<Value>) -> Result<(), anyhow::Error> {
+    let req = cluster_rpc::UsageRequest {
+        stream_name: ALERT_HISTORY_STREAM.to_string(),
+        data: Some(cluster_rpc::UsageData::from(records)),
+    };
+    let resp = ingestion_service::ingest(org_id, req).await?;
+    if resp.status_code != 200 {
+        return Err(anyhow::anyhow!(
+            "write to stream {} error: {}",
+            ALERT_HISTORY_STREAM,
+            resp.message
+        ));
+    }
+    Ok(())
+}
+
+/// Returns the transitions of the alerts in the time range, the latest first
+pub async fn list(
+    org_id: &str,
+    alert_name: Option<&str>,
+    start_time: i64,
+    end_time: i64,
+    size: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    let where_sql = match alert_name {
+        Some(name) => format!("WHERE alert_name = '{}'", name.replace('\'', "''")),
+        None => String::new(),
+    };
+    let sql =
+        format!("SELECT * FROM \"{ALERT_HISTORY_STREAM}\" {where_sql} ORDER BY _timestamp DESC");
+    search(org_id, sql, start_time, end_time, size).await
+}
+
+/// Aggregates the transitions of the alerts in the time range
+pub async fn stats(
+    org_id: &str,
+    start_time: i64,
+    end_time: i64,
+    limit: usize,
+) -> Result<AlertHistoryStats, anyhow::Error> {
+    let sql = format!(
+        "SELECT alert_name, stream_type, stream_name, \
+         SUM(CASE WHEN status = 'firing' THEN 1 ELSE 0 END) AS fire_count, \
+         SUM(CASE WHEN status = 'resolved' THEN 1 ELSE 0 END) AS resolved_count, \
+         AVG(CASE WHEN status = 'resolved' THEN time_to_resolve END) AS mean_time_to_resolve, \
+         MAX(CASE WHEN status = 'resolved' THEN time_to_resolve END) AS max_time_to_resolve \
+         FROM \"{ALERT_HISTORY_STREAM}\" GROUP BY alert_name, stream_type, stream_name"
+    );
+    let hits = search(org_id, sql, start_time, end_time, MAX_STATS_ALERTS).await?;
+    Ok(aggregate(hits, limit))
+}
+
+fn aggregate(hits: Vec<Value>, limit: usize) -> AlertHistoryStats {
+    let mut alerts = hits
+        .iter()
+        .map(|hit| AlertHistoryStat {
+            alert_name: string_field(hit, "alert_name"),
+            stream_type: string_field(hit, "stream_type"),
+            stream_name: string_field(hit, "stream_name"),
+            fire_count: hit.get("fire_count").map(json::get_int_value).unwrap_or(0),
+            resolved_count: hit
+                .get("resolved_count")
+                .map(json::get_int_value)
+                .unwrap_or(0),
+            mean_time_to_resolve: hit
+                .get("mean_time_to_resolve")
+                .map(json::get_float_value)
+                .unwrap_or(0.0),
+            max_time_to_resolve: hit
+                .get("max_time_to_resolve")
+                .map(json::get_float_value)
+                .unwrap_or(0.0),
+        })
+        .collect::<Vec<_>>();
+
+    let mut stats = AlertHistoryStats::default();
+    let mut resolve_time = 0.0;
+    for alert in alerts.iter() {
+        stats.fire_count += alert.fire_count;
+        stats.resolved_count += alert.resolved_count;
+        resolve_time += alert.mean_time_to_resolve * alert.resolved_count as f64;
+    }
+    if stats.resolved_count > 0 {
+        stats.mean_time_to_resolve = resolve_time / stats.resolved_count as f64;
+    }
+    alerts.sort_by(|a, b| {
+        b.fire_count
+            .cmp(&a.fire_count)
+            .then_with(|| a.alert_name.cmp(&b.alert_name))
+    });
+    alerts.truncate(limit);
+    stats.alerts = alerts;
+    stats
+}
+
+fn string_field(hit: &Value, field: &str) -> String {
+    hit.get(field)
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .to_string()
+}
+
+async fn search(
+    org_id: &str,
+    sql: String,
+    start_time: i64,
+    end_time: i64,
+    size: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    // nothing fired yet
+    let schema = infra::schema::get(org_id, ALERT_HISTORY_STREAM, StreamType::Logs).await?;
+    if schema.fields().is_empty() {
+        return Ok(vec![]);
+    }
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql,
+            from: 0,
+            size,
+            start_time,
+            end_time,
+            sort_by: None,
+            sql_mode: "full".to_string(),
+            quick_mode: false,
+            query_type: "".to_string(),
+            track_total_hits: false,
+            uses_zo_fn: false,
+            query_context: None,
+            query_fn: None,
+            skip_wal: false,
+        },
+        aggs: HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, org_id, StreamType::Logs, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("alert history query error: {e}"))?;
+    Ok(resp.hits)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_next_status() {
+        use AlertStatus::*;
+        assert_eq!(next_status(Resolved, Pending), Some(Pending));
+        assert_eq!(next_status(Pending, Firing), Some(Firing));
+        assert_eq!(next_status(Firing, Pending), None);
+        assert_eq!(next_status(Firing, Firing), None);
+        assert_eq!(next_status(Firing, Resolved), Some(Resolved));
+        assert_eq!(next_status(Resolved, Resolved), None);
+    }
+
+    #[test]
+    fn test_event_record() {
+        let alert = Alert {
+            name: "errors".to_string(),
+            stream_name: "app".to_string(),
+            ..Default::default()
+        };
+        let state = AlertState {
+            status: AlertStatus::Firing,
+            started_at: 1_000_000,
+            changed_at: 1_000_000,
+        };
+        let record = event_record(&alert, &state, AlertStatus::Resolved, 91_000_000, 0, None);
+        assert_eq!(record["from_status"], "firing");
+        assert_eq!(record["status"], "resolved");
+        assert_eq!(record["time_to_resolve"], 90.0);
+        assert!(record.get("destinations").is_none());
+
+        let notified = Notified {
+            sent: vec!["slack".to_string(), "email".to_string()],
+            held: vec!["pager".to_string()],
+            ..Default::default()
+        };
+        let record = event_record(&alert, &state, AlertStatus::Firing, 2, 3, Some(&notified));
+        assert_eq!(record["destinations"], "slack,email");
+        assert_eq!(record["held_destinations"], "pager");
+        assert_eq!(record["rows"], 3);
+    }
+
+    #[test]
+    fn test_aggregate() {
+        let hits = vec![
+            json::json!({"alert_name": "latency", "stream_type": "logs", "stream_name": "app",
+                "fire_count": 2, "resolved_count": 2, "mean_time_to_resolve": 300.0,
+                "max_time_to_resolve": 500.0}),
+            json::json!({"alert_name": "errors", "stream_type": "logs", "stream_name": "app",
+                "fire_count": 8, "resolved_count": 6, "mean_time_to_resolve": 100.0,
+                "max_time_to_resolve": 200.0}),
+        ];
+        let stats = aggregate(hits, 1);
+        assert_eq!(stats.fire_count, 10);
+        assert_eq!(stats.resolved_count, 8);
+        assert_eq!(stats.mean_time_to_resolve, 150.0);
+        assert_eq!(stats.alerts.len(), 1);
+        assert_eq!(stats.alerts[0].alert_name, "errors");
+    }
+}
@@ -19,11 +19,14 @@ use std::{
 };
 
 use actix_web::http;
-use arrow_schema::DataType;
+use arrow_schema::{DataType, Schema};
 use chrono::{Duration, Local, TimeZone, Utc};
 use config::{
     get_config, ider,
-    meta::{search::SearchEventType, stream::StreamType},
+    meta::{
+        search::{SearchEventContext, SearchEventType},
+        stream::StreamType,
+    },
     utils::{
         base64,
         json::{Map, Value},
@@ -40,18 +43,28 @@ use crate::{
             alerts::{
                 destinations::{DestinationType, DestinationWithTemplate, HTTPType},
                 AggFunction, Alert, AlertFrequencyType, Condition, Operator, QueryCondition,
-                QueryType,
+                QueryType, RootCauseHint,
             },
             authz::Authz,
+            governance::GovernedKind,
         },
         utils::auth::{remove_ownership, set_ownership},
     },
-    service::{db, search as SearchService},
+    service::{db, plugins, search as SearchService},
 };
 
 pub mod alert_manager;
+pub mod context;
+pub mod correlation;
+pub mod deadman;
 pub mod destinations;
+pub mod expected_set;
+pub mod history;
+pub mod post_process;
+pub mod quality;
+pub mod scheduler;
 pub mod templates;
+pub mod throttle;
 
 pub async fn save(
     org_id: &str,
@@ -160,10 +173,38 @@ pub async fn save(
                 ));
             }
         }
+        QueryType::Deadman => {
+            if alert.query_condition.deadman.is_none() {
+                alert.query_condition.deadman = Some(Default::default());
+            }
+        }
+        QueryType::Quality => {
+            if alert.query_condition.quality.is_none() {
+                alert.query_condition.quality = Some(Default::default());
+            }
+        }
+        QueryType::ExpectedSet => {
+            if alert.query_condition.expected_set.is_none() {
+                return Err(anyhow::anyhow!(
+                    "Expected set alert should have an expected set"
+                ));
+            }
+        }
     }
 
-    // test the alert
-    _ = &alert.evaluate(None).await?;
+    post_process::validate(&alert)?;
+    context::validate(&alert)?;
+
+    // test the alert, evaluating a deadman alert would update its series and
+    // a schema drift monitor its schema
+    match alert.query_condition.query_type {
+        QueryType::Deadman => deadman::check(&alert).await?,
+        QueryType::Quality => quality::check(&alert).await?,
+        QueryType::ExpectedSet => expected_set::check(&alert).await?,
+        _ => {
+            alert.evaluate(None).await?;
+        }
+    }
 
     // save the alert
     match db::alerts::set(org_id, stream_type, stream_name, &alert, create).await {
@@ -171,6 +212,22 @@ pub async fn save(
             if name.is_empty() {
                 set_ownership(org_id, "alerts", Authz::new(&alert.name)).await;
             }
+            if !create && alert.query_condition.query_type == QueryType::Deadman {
+                // the group by may have changed, start tracking the series again
+                if let Err(e) =
+                    db::alerts::deadman::delete(org_id, stream_type, stream_name, &alert.name).await
+                {
+                    log::error!("Failed to reset deadman series: {}", e);
+                }
+            }
+            if !create && alert.query_condition.query_type == QueryType::Quality {
+                // the check may have changed, record the schema again
+                if let Err(e) =
+                    db::alerts::quality::delete(org_id, stream_type, stream_name, &alert.name).await
+                {
+                    log::error!("Failed to reset quality monitor state: {}", e);
+                }
+            }
             Ok(())
         }
         Err(e) => Err(e),
@@ -233,6 +290,24 @@ pub async fn delete(
     match db::alerts::delete(org_id, stream_type, stream_name, name).await {
         Ok(_) => {
             remove_ownership(org_id, "alerts", Authz::new(name)).await;
+            if let Err(e) =
+                db::alerts::deadman::delete(org_id, stream_type, stream_name, name).await
+            {
+                log::error!("Failed to delete deadman series: {}", e);
+            }
+            if let Err(e) =
+                db::alerts::quality::delete(org_id, stream_type, stream_name, name).await
+            {
+                log::error!("Failed to delete quality monitor state: {}", e);
+            }
+            if let Err(e) = db::alerts::states::delete(org_id, stream_type, stream_name, name).await
+            {
+                log::error!("Failed to delete alert state: {}", e);
+            }
+            let object_id = format!("{stream_type}/{stream_name}/{name}");
+            if let Err(e) = db::governance::delete(org_id, GovernedKind::Alerts, &object_id).await {
+                log::error!("Failed to delete alert governance: {}", e);
+            }
             Ok(())
         }
         Err(e) => Err((http::StatusCode::INTERNAL_SERVER_ERROR, e)),
@@ -298,9 +373,32 @@ impl Alert {
         &self,
         rows: &[Map<String, Value>],
     ) -> Result<(), anyhow::Error> {
+        let hint = correlation::annotate(self, rows).await;
+        let context = context::collect(self, rows).await;
+        let mut notified = history::Notified::default();
         for dest in self.destinations.iter() {
             let dest = destinations::get_with_template(&self.org_id, dest).await?;
-            if let Err(e) = send_notification(self, &dest, rows).await {
+            let dest = match throttle::route(self, &dest).await {
+                Ok(throttle::Route::Send) => dest,
+                Ok(throttle::Route::Held) => {
+                    notified.held.push(dest.name);
+                    continue;
+                }
+                Ok(throttle::Route::Fallback(fallback)) => {
+                    notified.held.push(dest.name);
+                    *fallback
+                }
+                Err(e) => {
+                    // the limits can't be checked, better notify too much
+                    log::error!(
+                        "Error checking the limits of destination {}: {}",
+                        dest.name,
+                        e
+                    );
+                    dest
+                }
+            };
+            if let Err(e) = send_notification(self, &dest, rows, hint.as_ref(), &context).await {
                 log::error!(
                     "Error sending notification for {}/{}/{}/{} err: {}",
                     self.org_id,
@@ -309,8 +407,12 @@ impl Alert {
                     self.name,
                     e
                 );
+                notified.failed.push(dest.name);
+            } else {
+                notified.sent.push(dest.name);
             }
         }
+        history::record_notified(self, rows, &notified).await;
         Ok(())
     }
 }
@@ -353,6 +455,9 @@ impl QueryCondition {
                 };
                 build_sql(alert, v).await?
             }
+            QueryType::Deadman => return deadman::evaluate(alert).await,
+            QueryType::Quality => return quality::evaluate(alert).await,
+            QueryType::ExpectedSet => return expected_set::evaluate(alert).await,
             QueryType::SQL => {
                 let Some(v) = self.sql.as_ref() else {
                     return Ok(None);
@@ -413,37 +518,46 @@ impl QueryCondition {
                     .into_iter()
                     .filter(|f| f.samples.len() >= alert.trigger_condition.threshold as usize)
                     .collect::<Vec<_>>();
-                return if value.is_empty() {
+                let rows = value
+                    .iter()
+                    .map(|v| {
+                        let mut val = Map::with_capacity(v.labels.len() + 2);
+                        for label in v.labels.iter() {
+                            val.insert(label.name.to_string(), label.value.to_string().into());
+                        }
+                        let last_sample = v.samples.last().unwrap();
+                        val.insert("_timestamp".to_string(), last_sample.timestamp.into());
+                        val.insert("value".to_string(), last_sample.value.into());
+                        val
+                    })
+                    .collect::<Vec<_>>();
+                let rows = post_process::apply(alert, rows)?;
+                return if rows.is_empty() {
                     Ok(None)
                 } else {
-                    Ok(Some(
-                        value
-                            .iter()
-                            .map(|v| {
-                                let mut val = Map::with_capacity(v.labels.len() + 2);
-                                for label in v.labels.iter() {
-                                    val.insert(
-                                        label.name.to_string(),
-                                        label.value.to_string().into(),
-                                    );
-                                }
-                                let last_sample = v.samples.last().unwrap();
-                                val.insert("_timestamp".to_string(), last_sample.timestamp.into());
-                                val.insert("value".to_string(), last_sample.value.into());
-                                val
-                            })
-                            .collect(),
-                    ))
+                    Ok(Some(rows))
                 };
             }
         };
 
+        // the function runs on the rows returned and the threshold applies to
+        // the rows it keeps, so it needs more than the first rows
+        let has_function = post_process::has_function(alert);
+        let size = if has_function {
+            get_config()
+                .limit
+                .query_default_limit
+                .max(alert.trigger_condition.threshold)
+        } else {
+            100
+        };
+
         // fire the query
         let req = config::meta::search::Request {
             query: config::meta::search::Query {
                 sql: sql.clone(),
                 from: 0,
-                size: 100,
+                size,
                 start_time: now
                     - Duration::try_minutes(alert.trigger_condition.period)
                         .unwrap()
@@ -466,6 +580,7 @@ impl QueryCondition {
             clusters: vec![],
             timeout: 0,
             search_type: Some(SearchEventType::Alerts),
+            search_event_context: Some(SearchEventContext::with_alert(alert_key(alert))),
         };
         let trace_id = ider::uuid();
         let resp =
@@ -477,15 +592,22 @@ impl QueryCondition {
                     return Ok(None);
                 }
             };
-        if resp.total < alert.trigger_condition.threshold as usize {
+        let rows = resp
+            .hits
+            .iter()
+            .map(|hit| hit.as_object().unwrap().clone())
+            .collect::<Vec<_>>();
+        let (total, rows) = if has_function {
+            // the function may drop rows, the threshold applies to what is left
+            let rows = post_process::apply(alert, rows)?;
+            (rows.len(), rows)
+        } else {
+            (resp.total, rows)
+        };
+        if total < alert.trigger_condition.threshold as usize {
             Ok(None)
         } else {
-            Ok(Some(
-                resp.hits
-                    .iter()
-                    .map(|hit| hit.as_object().unwrap().clone())
-                    .collect(),
-            ))
+            Ok(Some(rows))
         }
     }
 }
@@ -558,26 +680,7 @@ impl Condition {
 
 async fn build_sql(alert: &Alert, conditions: &[Condition]) -> Result<String, anyhow::Error> {
     let schema = infra::schema::get(&alert.org_id, &alert.stream_name, alert.stream_type).await?;
-    let mut wheres = Vec::with_capacity(conditions.len());
-    for cond in conditions.iter() {
-        let data_type = match schema.field_with_name(&cond.column) {
-            Ok(field) => field.data_type(),
-            Err(_) => {
-                return Err(anyhow::anyhow!(
-                    "Column {} not found on stream {}",
-                    &cond.column,
-                    &alert.stream_name
-                ));
-            }
-        };
-        let expr = build_expr(cond, "", data_type)?;
-        wheres.push(expr);
-    }
-    let where_sql = if !wheres.is_empty() {
-        format!("WHERE {}", wheres.join(" AND "))
-    } else {
-        String::new()
-    };
+    let where_sql = build_where(alert, &schema, conditions)?;
     if alert.query_condition.aggregation.is_none() {
         return Ok(format!(
             "SELECT * FROM \"{}\" {}",
@@ -645,6 +748,34 @@ async fn build_sql(alert: &Alert, conditions: &[Condition]) -> Result<String, an
     Ok(sql)
 }
 
+/// `WHERE` clause of the conditions, empty without conditions
+fn build_where(
+    alert: &Alert,
+    schema: &Schema,
+    conditions: &[Condition],
+) -> Result<String, anyhow::Error> {
+    let mut wheres = Vec::with_capacity(conditions.len());
+    for cond in conditions.iter() {
+        let data_type = match schema.field_with_name(&cond.column) {
+            Ok(field) => field.data_type(),
+            Err(_) => {
+                return Err(anyhow::anyhow!(
+                    "Column {} not found on stream {}",
+                    &cond.column,
+                    &alert.stream_name
+                ));
+            }
+        };
+        let expr = build_expr(cond, "", data_type)?;
+        wheres.push(expr);
+    }
+    Ok(if !wheres.is_empty() {
+        format!("WHERE {}", wheres.join(" AND "))
+    } else {
+        String::new()
+    })
+}
+
 fn build_expr(
     cond: &Condition,
     field_alias: &str,
@@ -801,17 +932,30 @@ pub async fn send_notification(
     alert: &Alert,
     dest: &DestinationWithTemplate,
     rows: &[Map<String, Value>],
+    hint: Option<&RootCauseHint>,
+    context: &[String],
 ) -> Result<(), anyhow::Error> {
     let rows_tpl_val = if alert.row_template.is_empty() {
         vec!["".to_string()]
     } else {
         process_row_template(&alert.row_template, alert, rows)
     };
-    let msg: String = process_dest_template(&dest.template.body, alert, rows, &rows_tpl_val).await;
+    let msg: String = process_dest_template(
+        &dest.template.body,
+        alert,
+        rows,
+        &rows_tpl_val,
+        hint,
+        context,
+    )
+    .await;
 
     match dest.destination_type {
         DestinationType::Http => send_http_notification(dest, msg.clone()).await,
         DestinationType::Email => send_email_notification(&alert.name, dest, msg).await,
+        DestinationType::Plugin => {
+            send_plugin_notification(&alert.org_id, &alert.name, dest, msg).await
+        }
     }
 }
 
@@ -863,6 +1007,18 @@ pub async fn send_http_notification(
     Ok(())
 }
 
+pub async fn send_plugin_notification(
+    org_id: &str,
+    title: &str,
+    dest: &DestinationWithTemplate,
+    msg: String,
+) -> Result<(), anyhow::Error> {
+    let Some(target) = dest.plugin.as_ref() else {
+        return Err(anyhow::anyhow!("Destination {} has no plugin", dest.name));
+    };
+    plugins::deliver(org_id, &dest.name, target, title, msg).await
+}
+
 pub async fn send_email_notification(
     alert_name: &str,
     dest: &DestinationWithTemplate,
@@ -899,12 +1055,27 @@ pub async fn send_email_notification(
     }
 }
 
-fn process_row_template(tpl: &String, alert: &Alert, rows: &[Map<String, Value>]) -> Vec<String> {
-    let alert_type = if alert.is_real_time {
+/// Key of the alert in the scheduler, `{stream_type}/{stream_name}/{name}`
+pub fn alert_key(alert: &Alert) -> String {
+    format!("{}/{}/{}", alert.stream_type, alert.stream_name, alert.name)
+}
+
+/// Type of the alert given to the templates and written to the history
+pub fn alert_type(alert: &Alert) -> &'static str {
+    if alert.is_real_time {
         "realtime"
     } else {
-        "scheduled"
-    };
+        match alert.query_condition.query_type {
+            QueryType::Deadman => "deadman",
+            QueryType::Quality => "quality",
+            QueryType::ExpectedSet => "expected_set",
+            _ => "scheduled",
+        }
+    }
+}
+
+fn process_row_template(tpl: &String, alert: &Alert, rows: &[Map<String, Value>]) -> Vec<String> {
+    let alert_type = alert_type(alert);
     let alert_count = rows.len();
     let mut rows_tpl = Vec::with_capacity(rows.len());
     for row in rows.iter() {
@@ -1006,6 +1177,8 @@ async fn process_dest_template(
     alert: &Alert,
     rows: &[Map<String, Value>],
     rows_tpl_val: &[String],
+    hint: Option<&RootCauseHint>,
+    context: &[String],
 ) -> String {
     let cfg = get_config();
     // format values
@@ -1104,11 +1277,7 @@ async fn process_dest_template(
         String::from("N/A")
     };
 
-    let alert_type = if alert.is_real_time {
-        "realtime"
-    } else {
-        "scheduled"
-    };
+    let alert_type = alert_type(alert);
 
     let mut alert_query = String::new();
     let alert_url = if alert.query_condition.query_type == QueryType::PromQL {
@@ -1151,6 +1320,21 @@ async fn process_dest_template(
                     }
                 }
             }
+            QueryType::Deadman => {
+                if let Ok(v) = deadman::build_sql(alert).await {
+                    alert_query = v;
+                }
+            }
+            QueryType::Quality => {
+                if let Ok(v) = quality::build_sql(alert).await {
+                    alert_query = v;
+                }
+            }
+            QueryType::ExpectedSet => {
+                if let Ok(v) = expected_set::build_sql(alert, &Default::default()).await {
+                    alert_query = v;
+                }
+            }
             _ => unreachable!(),
         };
         // http://localhost:5080/web/logs?stream_type=logs&stream=test&from=1708416534519324&to=1708416597898186&sql_mode=true&query=U0VMRUNUICogRlJPTSAidGVzdCIgd2hlcmUgbGV2ZWwgPSAnaW5mbyc=&org_identifier=default
@@ -1189,7 +1373,15 @@ async fn process_dest_template(
         .replace("{alert_count}", &alert_count.to_string())
         .replace("{alert_start_time}", &alert_start_time_str)
         .replace("{alert_end_time}", &alert_end_time_str)
-        .replace("{alert_url}", &alert_url);
+        .replace("{alert_url}", &alert_url)
+        .replace(
+            "{alert_root_cause}",
+            hint.map(|h| h.message.as_str()).unwrap_or_default(),
+        )
+        .replace(
+            "{alert_related_alerts}",
+            &hint.map(|h| h.related.join(", ")).unwrap_or_default(),
+        );
 
     if let Some(contidion) = &alert.query_condition.promql_condition {
         resp = resp
@@ -1198,6 +1390,11 @@ async fn process_dest_template(
     }
 
     process_variable_replace(&mut resp, "rows", &VarValue::Vector(rows_tpl_val));
+    let context = context
+        .iter()
+        .map(|line| format_variable_value(line.to_string()))
+        .collect::<Vec<_>>();
+    process_variable_replace(&mut resp, "alert_context", &VarValue::Vector(&context));
     for (key, value) in vars.iter() {
         if resp.contains(&format!("{{{key}}}")) {
             let val = value.iter().cloned().collect::<Vec<_>>();
@@ -0,0 +1,215 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! VRL post-processing of the rows of scheduled alerts.
+//!
+//! The function of the query condition runs on every row of the query result
+//! before the threshold is evaluated, so conditions that are awkward in SQL can
+//! be written as a function, e.g. the ratio of two columns or a list of hosts
+//! to ignore. The row is replaced by what the function returns and dropped
+//! when the function aborts or doesn't return an object.
+//!
+//! ```text
+//! .error_ratio = (to_float!(.errors) / to_float!(.total)) ?? 0.0
+//! if .error_ratio < 0.05 || includes(["canary-1", "canary-2"], .host) {
+//!     abort
+//! }
+//! ```
+
+use config::utils::{
+    flatten,
+    json::{Map, Value},
+};
+use vector_enrichment::TableRegistry;
+
+use crate::{
+    common::meta::{
+        alerts::{Alert, QueryType},
+        functions::VRLResultResolver,
+    },
+    service::ingestion::{compile_vrl_function, init_functions_runtime, try_apply_vrl_fn},
+};
+
+/// Returns the function of the alert, if it has one
+fn function(alert: &Alert) -> Option<&str> {
+    alert
+        .query_condition
+        .vrl_function
+        .as_deref()
+        .filter(|v| !v.trim().is_empty())
+}
+
+/// Returns true when the rows of the alert are post-processed
+pub fn has_function(alert: &Alert) -> bool {
+    function(alert).is_some()
+}
+
+/// Checks the function of the alert compiles and the alert can be
+/// post-processed
+pub fn validate(alert: &Alert) -> Result<(), anyhow::Error> {
+    let Some(func) = function(alert) else {
+        return Ok(());
+    };
+    if alert.is_real_time {
+        return Err(anyhow::anyhow!(
+            "Realtime alert doesn't support VRL post-processing"
+        ));
+    }
+    if alert.query_condition.query_type == QueryType::Deadman {
+        return Err(anyhow::anyhow!(
+            "Deadman alert doesn't support VRL post-processing"
+        ));
+    }
+    if alert.query_condition.query_type == QueryType::Quality {
+        return Err(anyhow::anyhow!(
+            "Quality monitor doesn't support VRL post-processing"
+        ));
+    }
+    if alert.query_condition.query_type == QueryType::ExpectedSet {
+        return Err(anyhow::anyhow!(
+            "Expected set alert doesn't support VRL post-processing"
+        ));
+    }
+    compile(&alert.org_id, func).map(|_| ())
+}
+
+/// Applies the function of the alert to the rows, returns the rows unchanged
+/// when the alert has no function
+pub fn apply(
+    alert: &Alert,
+    rows: Vec<Map<String, Value>>,
+) -> Result<Vec<Map<String, Value>>, anyhow::Error> {
+    let Some(func) = function(alert) else {
+        return Ok(rows);
+    };
+    let resolver = compile(&alert.org_id, func)?;
+    let mut runtime = init_functions_runtime();
+    let mut processed = Vec::with_capacity(rows.len());
+    for row in rows {
+        match try_apply_vrl_fn(&mut runtime, &resolver, &Value::Object(row)) {
+            Ok(val) if val.is_object() => match flatten::flatten(val) {
+                Ok(Value::Object(row)) => processed.push(row),
+                Ok(_) => {}
+                Err(e) => {
+                    log::warn!(
+                        "Alert {}/{}/{} post-processing flatten error: {}",
+                        alert.org_id,
+                        alert.stream_name,
+                        alert.name,
+                        e
+                    );
+                }
+            },
+            Ok(_) => {}
+            Err(e) => {
+                // abort is how the function drops a row
+                if !e.contains("Abort") {
+                    log::warn!(
+                        "Alert {}/{}/{} post-processing error: {}",
+                        alert.org_id,
+                        alert.stream_name,
+                        alert.name,
+                        e
+                    );
+                }
+            }
+        }
+    }
+    Ok(processed)
+}
+
+fn compile(org_id: &str, func: &str) -> Result<VRLResultResolver, anyhow::Error> {
+    let func = if func.trim().ends_with('.') {
+        func.to_string()
+    } else {
+        format!("{} \n .", func)
+    };
+    let config = compile_vrl_function(&func, org_id)
+        .map_err(|e| anyhow::anyhow!("Alert VRL function is invalid: {e}"))?;
+    if let Some(registry) = config.config.get_custom::<TableRegistry>() {
+        registry.finish_load();
+    }
+    Ok(VRLResultResolver {
+        program: config.program,
+        fields: config.fields,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    fn alert(func: &str) -> Alert {
+        let mut alert = Alert {
+            org_id: "default".to_string(),
+            name: "errors".to_string(),
+            ..Default::default()
+        };
+        alert.query_condition.query_type = QueryType::SQL;
+        alert.query_condition.vrl_function = Some(func.to_string());
+        alert
+    }
+
+    fn rows(v: json::Value) -> Vec<Map<String, Value>> {
+        v.as_array()
+            .unwrap()
+            .iter()
+            .map(|v| v.as_object().unwrap().clone())
+            .collect()
+    }
+
+    #[test]
+    fn test_apply() {
+        let alert = alert(
+            r#".ratio = (to_float!(.errors) / to_float!(.total)) ?? 0.0
+if .ratio < 0.1 || includes(["canary"], .host) {
+    abort
+}"#,
+        );
+        let processed = apply(
+            &alert,
+            rows(json::json!([
+                {"host": "a", "errors": 5, "total": 10},
+                {"host": "b", "errors": 1, "total": 100},
+                {"host": "canary", "errors": 10, "total": 10}
+            ])),
+        )
+        .unwrap();
+        assert_eq!(
+            processed,
+            rows(json::json!([{"host": "a", "errors": 5, "total": 10, "ratio": 0.5}]))
+        );
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(validate(&alert(".a = 1")).is_ok());
+        assert!(validate(&alert(".a = ")).is_err());
+        let mut realtime = alert(".a = 1");
+        realtime.is_real_time = true;
+        assert!(validate(&realtime).is_err());
+        realtime.query_condition.vrl_function = None;
+        assert!(validate(&realtime).is_ok());
+    }
+
+    #[test]
+    fn test_has_function() {
+        assert!(has_function(&alert(".a = 1")));
+        assert!(!has_function(&alert("  ")));
+        assert!(!has_function(&Alert::default()));
+    }
+}
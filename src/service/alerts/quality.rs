@@ -0,0 +1,425 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Data quality monitors of a stream: its freshness, the age of its last
+//! event; its volume, the count of events of the period compared to the same
+//! window of the previous days; and its schema drift, the fields added,
+//! removed or changing type since the last evaluation. They fire while the
+//! check fails, except the schema drift which fires once per change.
+
+use chrono::{Duration, Utc};
+use config::{
+    get_config, ider,
+    meta::search::{SearchEventContext, SearchEventType},
+    utils::json::{self, Map, Value},
+};
+use hashbrown::HashMap;
+use infra::cache::stats;
+
+use crate::{
+    common::meta::alerts::{Alert, QualityCheck, QualityCondition, QualityState},
+    service::{db, search as SearchService},
+};
+
+/// Days of the volume baseline at most
+const MAX_BASELINE_DAYS: i64 = 90;
+
+/// Returns the rows describing the failure of the check, if it fails
+pub async fn evaluate(alert: &Alert) -> Result<Option<Vec<Map<String, Value>>>, anyhow::Error> {
+    let quality = alert.query_condition.quality.clone().unwrap_or_default();
+    let now = Utc::now().timestamp_micros();
+    let row = match quality.check {
+        QualityCheck::Freshness => freshness(alert, &quality, now).await?,
+        QualityCheck::Volume => volume(alert, &quality, now).await?,
+        QualityCheck::SchemaDrift => return schema_drift(alert, &quality, now).await,
+    };
+    Ok(row.map(|row| vec![row]))
+}
+
+/// Checks the settings of the monitor and that its query runs, without
+/// touching the schema tracked by a schema drift monitor
+pub async fn check(alert: &Alert) -> Result<(), anyhow::Error> {
+    let quality = alert.query_condition.quality.clone().unwrap_or_default();
+    let now = Utc::now().timestamp_micros();
+    match quality.check {
+        QualityCheck::Freshness => {
+            if quality.max_age <= 0 {
+                return Err(anyhow::anyhow!(
+                    "Freshness monitor should have a max age of at least 1 minute"
+                ));
+            }
+        }
+        QualityCheck::Volume => {
+            if alert.trigger_condition.period <= 0 {
+                return Err(anyhow::anyhow!(
+                    "Volume monitor should have a period of at least 1 minute"
+                ));
+            }
+            if quality.baseline_days <= 0 || quality.baseline_days > MAX_BASELINE_DAYS {
+                return Err(anyhow::anyhow!(
+                    "Volume monitor should have between 1 and {MAX_BASELINE_DAYS} baseline days"
+                ));
+            }
+            if quality.min_percent < 0.0
+                || (quality.max_percent > 0.0 && quality.max_percent <= quality.min_percent)
+            {
+                return Err(anyhow::anyhow!(
+                    "Volume monitor max percent should be above its min percent"
+                ));
+            }
+        }
+        QualityCheck::SchemaDrift => return Ok(()),
+    }
+    query(alert, &build_sql(alert).await?, now - minutes(1), now).await?;
+    Ok(())
+}
+
+/// `SELECT MAX(_timestamp) AS last_seen` for a freshness monitor, `SELECT
+/// COUNT(*) AS count` for a volume monitor, of the stream filtered by the
+/// conditions of the alert. A schema drift monitor runs no query.
+pub async fn build_sql(alert: &Alert) -> Result<String, anyhow::Error> {
+    let quality = alert.query_condition.quality.clone().unwrap_or_default();
+    let select = match quality.check {
+        QualityCheck::Freshness => {
+            format!("MAX({}) AS last_seen", get_config().common.column_timestamp)
+        }
+        QualityCheck::Volume => "COUNT(*) AS count".to_string(),
+        QualityCheck::SchemaDrift => return Ok(String::new()),
+    };
+    let schema = infra::schema::get(&alert.org_id, &alert.stream_name, alert.stream_type).await?;
+    let where_sql = super::build_where(
+        alert,
+        &schema,
+        alert
+            .query_condition
+            .conditions
+            .as_deref()
+            .unwrap_or_default(),
+    )?;
+    Ok(format!(
+        "SELECT {select} FROM \"{}\" {where_sql}",
+        alert.stream_name
+    ))
+}
+
+async fn freshness(
+    alert: &Alert,
+    quality: &QualityCondition,
+    now: i64,
+) -> Result<Option<Map<String, Value>>, anyhow::Error> {
+    let hits = query(
+        alert,
+        &build_sql(alert).await?,
+        now - minutes(quality.max_age),
+        now,
+    )
+    .await?;
+    let last_seen = hits
+        .first()
+        .and_then(|hit| hit.get("last_seen"))
+        .map(json::get_int_value)
+        .unwrap_or(0);
+    if last_seen > 0 {
+        return Ok(None);
+    }
+    // the older data isn't queried, the stats of the stream tell when it
+    // last received data
+    let last_seen =
+        stats::get_stream_stats(&alert.org_id, &alert.stream_name, alert.stream_type).doc_time_max;
+    let mut row = Map::new();
+    row.insert(
+        "check".to_string(),
+        QualityCheck::Freshness.to_string().into(),
+    );
+    row.insert("max_age".to_string(), quality.max_age.into());
+    if last_seen > 0 {
+        row.insert("last_seen".to_string(), last_seen.into());
+        row.insert(
+            "age_minutes".to_string(),
+            ((now - last_seen) / minutes(1)).into(),
+        );
+    } else {
+        row.insert("last_seen".to_string(), Value::Null);
+    }
+    Ok(Some(row))
+}
+
+async fn volume(
+    alert: &Alert,
+    quality: &QualityCondition,
+    now: i64,
+) -> Result<Option<Map<String, Value>>, anyhow::Error> {
+    let sql = build_sql(alert).await?;
+    let period = minutes(alert.trigger_condition.period);
+    let day = minutes(24 * 60);
+    // the windows before the stream received data would drag the baseline
+    // down
+    let doc_time_min =
+        stats::get_stream_stats(&alert.org_id, &alert.stream_name, alert.stream_type).doc_time_min;
+    let windows = (0..=quality.baseline_days)
+        .map(|days| (now - days * day - period, now - days * day))
+        .filter(|(start, _)| doc_time_min == 0 || *start >= doc_time_min)
+        .collect::<Vec<_>>();
+    if windows.len() < 2 {
+        return Ok(None); // no baseline yet
+    }
+    let counts = futures::future::try_join_all(
+        windows
+            .iter()
+            .map(|(start, end)| query(alert, &sql, *start, *end)),
+    )
+    .await?
+    .into_iter()
+    .map(|hits| {
+        hits.first()
+            .and_then(|hit| hit.get("count"))
+            .map(json::get_int_value)
+            .unwrap_or(0)
+    })
+    .collect::<Vec<_>>();
+    Ok(volume_change(counts[0], &counts[1..], quality))
+}
+
+/// Returns the row of the count when it is out of the bounds of the median
+/// of the baseline counts
+fn volume_change(
+    count: i64,
+    baseline: &[i64],
+    quality: &QualityCondition,
+) -> Option<Map<String, Value>> {
+    let median = median(baseline)?;
+    if median == 0.0 {
+        return None; // nothing to compare with
+    }
+    let percent = (count as f64 * 10000.0 / median).round() / 100.0;
+    if percent >= quality.min_percent
+        && (quality.max_percent <= 0.0 || percent <= quality.max_percent)
+    {
+        return None;
+    }
+    let mut row = Map::new();
+    row.insert("check".to_string(), QualityCheck::Volume.to_string().into());
+    row.insert("count".to_string(), count.into());
+    row.insert("baseline".to_string(), median.into());
+    row.insert("percent".to_string(), percent.into());
+    row.insert("baseline_days".to_string(), baseline.len().into());
+    Some(row)
+}
+
+fn median(values: &[i64]) -> Option<f64> {
+    if values.is_empty() {
+        return None;
+    }
+    let mut values = values.to_vec();
+    values.sort_unstable();
+    let mid = values.len() / 2;
+    Some(if values.len() % 2 == 0 {
+        (values[mid - 1] + values[mid]) as f64 / 2.0
+    } else {
+        values[mid] as f64
+    })
+}
+
+async fn schema_drift(
+    alert: &Alert,
+    quality: &QualityCondition,
+    now: i64,
+) -> Result<Option<Vec<Map<String, Value>>>, anyhow::Error> {
+    let schema = infra::schema::get(&alert.org_id, &alert.stream_name, alert.stream_type).await?;
+    let fields = schema
+        .fields()
+        .iter()
+        .map(|field| (field.name().to_string(), field.data_type().to_string()))
+        .collect::<HashMap<_, _>>();
+    let mut state = db::alerts::quality::get(
+        &alert.org_id,
+        alert.stream_type,
+        &alert.stream_name,
+        &alert.name,
+    )
+    .await?;
+    // the first evaluation only records the schema
+    let rows = if state.evaluated_at > 0 {
+        schema_changes(&state.fields, &fields, quality.ignore_added)
+    } else {
+        vec![]
+    };
+    if state.evaluated_at == 0 || state.fields != fields {
+        state.evaluated_at = now;
+        state.fields = fields;
+        db::alerts::quality::set(
+            &alert.org_id,
+            alert.stream_type,
+            &alert.stream_name,
+            &alert.name,
+            &state,
+        )
+        .await?;
+    }
+    Ok(if rows.is_empty() { None } else { Some(rows) })
+}
+
+/// Returns a row per field added, removed or changing type, sorted by field
+fn schema_changes(
+    old: &HashMap<String, String>,
+    new: &HashMap<String, String>,
+    ignore_added: bool,
+) -> Vec<Map<String, Value>> {
+    let mut names = old.keys().chain(new.keys()).collect::<Vec<_>>();
+    names.sort();
+    names.dedup();
+    let mut rows = Vec::new();
+    for name in names {
+        let (old_type, new_type) = (old.get(name), new.get(name));
+        let change = match (old_type, new_type) {
+            (None, Some(_)) if ignore_added => continue,
+            (None, Some(_)) => "added",
+            (Some(_), None) => "removed",
+            (Some(a), Some(b)) if a != b => "type_changed",
+            _ => continue,
+        };
+        let mut row = Map::new();
+        row.insert(
+            "check".to_string(),
+            QualityCheck::SchemaDrift.to_string().into(),
+        );
+        row.insert("field".to_string(), name.to_string().into());
+        row.insert("change".to_string(), change.into());
+        row.insert("old_type".to_string(), old_type.cloned().into());
+        row.insert("new_type".to_string(), new_type.cloned().into());
+        rows.push(row);
+    }
+    rows
+}
+
+/// Like the deadman alerts a failed query is an error, taking it for missing
+/// data would fire the monitor
+async fn query(
+    alert: &Alert,
+    sql: &str,
+    start: i64,
+    end: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql: sql.to_string(),
+            from: 0,
+            size: 1,
+            start_time: start,
+            end_time: end,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: std::collections::HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Alerts),
+        search_event_context: Some(SearchEventContext::with_alert(super::alert_key(alert))),
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, &alert.org_id, alert.stream_type, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("quality monitor query error: {e}"))?;
+    Ok(resp.hits)
+}
+
+fn minutes(n: i64) -> i64 {
+    Duration::try_minutes(n)
+        .unwrap()
+        .num_microseconds()
+        .unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_median() {
+        assert_eq!(median(&[]), None);
+        assert_eq!(median(&[5]), Some(5.0));
+        assert_eq!(median(&[9, 1, 5]), Some(5.0));
+        assert_eq!(median(&[4, 1, 10, 6]), Some(5.0));
+    }
+
+    #[test]
+    fn test_volume_change() {
+        let quality = QualityCondition {
+            check: QualityCheck::Volume,
+            max_percent: 300.0,
+            ..Default::default()
+        };
+        // within 50% and 300% of the baseline of 100
+        assert_eq!(volume_change(100, &[90, 100, 1000], &quality), None);
+        assert_eq!(volume_change(50, &[100], &quality), None);
+        assert_eq!(volume_change(300, &[100], &quality), None);
+
+        let row = volume_change(20, &[90, 100, 1000], &quality).unwrap();
+        assert_eq!(row["count"], json::json!(20));
+        assert_eq!(row["baseline"], json::json!(100.0));
+        assert_eq!(row["percent"], json::json!(20.0));
+        assert_eq!(row["baseline_days"], json::json!(3));
+        assert!(volume_change(301, &[100], &quality).is_some());
+
+        // no upper bound by default
+        let quality = QualityCondition::default();
+        assert_eq!(volume_change(1000, &[100], &quality), None);
+        // nothing to compare with
+        assert_eq!(volume_change(0, &[], &quality), None);
+        assert_eq!(volume_change(10, &[0, 0], &quality), None);
+    }
+
+    #[test]
+    fn test_schema_changes() {
+        let fields = |list: &[(&str, &str)]| {
+            list.iter()
+                .map(|(k, v)| (k.to_string(), v.to_string()))
+                .collect::<HashMap<_, _>>()
+        };
+        let old = fields(&[("_timestamp", "Int64"), ("code", "Int64"), ("host", "Utf8")]);
+        let new = fields(&[("_timestamp", "Int64"), ("code", "Utf8"), ("user", "Utf8")]);
+        let changes = |ignore_added| {
+            schema_changes(&old, &new, ignore_added)
+                .into_iter()
+                .map(|row| {
+                    (
+                        row["field"].as_str().unwrap().to_string(),
+                        row["change"].as_str().unwrap().to_string(),
+                    )
+                })
+                .collect::<Vec<_>>()
+        };
+        let pair = |f: &str, c: &str| (f.to_string(), c.to_string());
+        assert_eq!(
+            changes(false),
+            vec![
+                pair("code", "type_changed"),
+                pair("host", "removed"),
+                pair("user", "added"),
+            ]
+        );
+        assert_eq!(
+            changes(true),
+            vec![pair("code", "type_changed"), pair("host", "removed")]
+        );
+        let rows = schema_changes(&old, &new, false);
+        assert_eq!(rows[0]["old_type"], json::json!("Int64"));
+        assert_eq!(rows[0]["new_type"], json::json!("Utf8"));
+        assert_eq!(rows[1]["new_type"], Value::Null);
+        assert!(schema_changes(&old, &old, false).is_empty());
+    }
+}
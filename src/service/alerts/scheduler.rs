@@ -0,0 +1,220 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Spreading and accounting of the evaluations of the scheduled alerts,
+//! reports and pipelines. The evaluations are accounted in memory by the
+//! alert managers running them and merged into the stored stats periodically.
+
+use std::sync::Mutex;
+
+use chrono::Utc;
+use config::{
+    get_config,
+    utils::hash::{fnv, Sum64},
+};
+use hashbrown::HashMap;
+use infra::dist_lock;
+use once_cell::sync::Lazy;
+
+use crate::{
+    common::meta::alerts::{ScheduledTrigger, TriggerRunStats},
+    service::db,
+};
+
+/// Evaluations accounted since the last flush, by org, module and module key
+static PENDING: Lazy<Mutex<HashMap<(String, String, String), TriggerRunStats>>> =
+    Lazy::new(Default::default);
+
+const FLUSH_LOCK_KEY: &str = "/trigger_stats/flush";
+
+/// Returns the delay, in microseconds, added to the next evaluation of a
+/// trigger due every `interval` microseconds. The delay is stable for each
+/// trigger, so the triggers created at the same time do not keep running
+/// together.
+pub fn jitter(org_id: &str, module_key: &str, interval: i64) -> i64 {
+    let max_jitter = get_config().limit.scheduler_max_jitter * 1_000_000;
+    let max_jitter = max_jitter.min(interval / 10);
+    if max_jitter <= 0 {
+        return 0;
+    }
+    let h = fnv::new().sum64(&format!("{org_id}/{module_key}"));
+    (h % max_jitter as u64) as i64
+}
+
+/// Accounts an evaluation which started at `started`, in microseconds
+pub async fn record(trigger: &db::scheduler::Trigger, started: i64, error: Option<String>) {
+    // realtime alerts are only pulled to be woken up
+    if trigger.is_realtime {
+        return;
+    }
+    let now = Utc::now().timestamp_micros();
+    let delay = (started - trigger.next_run_at).max(0);
+    // the interval is estimated from the next evaluation, it is longer when
+    // the trigger is silenced
+    let interval =
+        match db::scheduler::get(&trigger.org, trigger.module.clone(), &trigger.module_key).await {
+            Ok(next) => next.next_run_at - now,
+            Err(_) => 0,
+        };
+    let run = run_stats(started, delay, now - started, interval, error);
+
+    let key = (
+        trigger.org.clone(),
+        trigger.module.to_string(),
+        trigger.module_key.clone(),
+    );
+    let mut pending = PENDING.lock().unwrap();
+    let stats = pending.entry(key).or_default();
+    merge(stats, &run);
+}
+
+/// Merges the evaluations accounted since the last flush into the stored stats
+pub async fn flush() -> Result<(), anyhow::Error> {
+    let pending = std::mem::take(&mut *PENDING.lock().unwrap());
+    if pending.is_empty() {
+        return Ok(());
+    }
+    // the alert managers merge their evaluations one after the other
+    let locker = dist_lock::lock(FLUSH_LOCK_KEY, 0).await?;
+    let ret = save(pending).await;
+    dist_lock::unlock(&locker).await?;
+    ret
+}
+
+async fn save(
+    pending: HashMap<(String, String, String), TriggerRunStats>,
+) -> Result<(), anyhow::Error> {
+    for ((org_id, module, module_key), run) in pending {
+        let mut stats = db::alerts::trigger_stats::get(&org_id, &module, &module_key)
+            .await?
+            .unwrap_or_default();
+        merge(&mut stats, &run);
+        db::alerts::trigger_stats::set(&org_id, &module, &module_key, &stats).await?;
+    }
+    Ok(())
+}
+
+/// Lists the scheduled triggers of the org with the stats of their
+/// evaluations
+pub async fn list(org_id: &str) -> Result<Vec<ScheduledTrigger>, anyhow::Error> {
+    let mut stats: HashMap<(String, String), TriggerRunStats> =
+        db::alerts::trigger_stats::list(org_id)
+            .await?
+            .into_iter()
+            .map(|(module, module_key, stats)| ((module, module_key), stats))
+            .collect();
+    let mut triggers = db::scheduler::list(None)
+        .await?
+        .into_iter()
+        .filter(|trigger| trigger.org == org_id)
+        .map(|trigger| {
+            let module = trigger.module.to_string();
+            let stats = stats.remove(&(module.clone(), trigger.module_key.clone()));
+            ScheduledTrigger {
+                module,
+                module_key: trigger.module_key,
+                next_run_at: trigger.next_run_at,
+                status: format!("{:?}", trigger.status).to_lowercase(),
+                retries: trigger.retries,
+                is_silenced: trigger.is_silenced,
+                stats,
+            }
+        })
+        .collect::<Vec<_>>();
+    triggers.sort_by(|a, b| a.next_run_at.cmp(&b.next_run_at));
+
+    // the stats left belong to deleted triggers
+    for (module, module_key) in stats.into_keys() {
+        if let Err(e) = db::alerts::trigger_stats::delete(org_id, &module, &module_key).await {
+            log::error!("Error deleting trigger stats {org_id}/{module}/{module_key}: {e}");
+        }
+    }
+    Ok(triggers)
+}
+
+fn run_stats(
+    started: i64,
+    delay: i64,
+    duration: i64,
+    interval: i64,
+    error: Option<String>,
+) -> TriggerRunStats {
+    TriggerRunStats {
+        runs: 1,
+        failures: error.is_some() as i64,
+        skipped: if interval > 0 { delay / interval } else { 0 },
+        last_run_at: started,
+        last_delay: delay,
+        max_delay: delay,
+        last_duration: duration,
+        last_error: error,
+    }
+}
+
+/// Adds `run` to `stats`, `run` holding the latest evaluations when they
+/// started later
+fn merge(stats: &mut TriggerRunStats, run: &TriggerRunStats) {
+    stats.runs += run.runs;
+    stats.failures += run.failures;
+    stats.skipped += run.skipped;
+    stats.max_delay = stats.max_delay.max(run.max_delay);
+    if run.last_run_at >= stats.last_run_at {
+        stats.last_run_at = run.last_run_at;
+        stats.last_delay = run.last_delay;
+        stats.last_duration = run.last_duration;
+        stats.last_error = run.last_error.clone();
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_jitter() {
+        let interval = 600 * 1_000_000;
+        let delay = jitter("default", "logs/app/errors", interval);
+        assert!((0..interval / 10).contains(&delay));
+        assert_eq!(delay, jitter("default", "logs/app/errors", interval));
+        assert_eq!(jitter("default", "logs/app/errors", 5), 0);
+    }
+
+    #[test]
+    fn test_run_stats() {
+        let minute = 60 * 1_000_000;
+        let run = run_stats(100, 3 * minute, 10, minute, None);
+        assert_eq!(run.skipped, 3);
+        assert_eq!(run.failures, 0);
+        let run = run_stats(100, 10, 10, 0, Some("timeout".to_string()));
+        assert_eq!(run.skipped, 0);
+        assert_eq!(run.failures, 1);
+    }
+
+    #[test]
+    fn test_merge() {
+        let mut stats = run_stats(200, 5, 10, 0, Some("timeout".to_string()));
+        merge(&mut stats, &run_stats(100, 50, 20, 0, None));
+        assert_eq!(stats.runs, 2);
+        assert_eq!(stats.failures, 1);
+        assert_eq!(stats.max_delay, 50);
+        assert_eq!(stats.last_run_at, 200);
+        assert_eq!(stats.last_error.as_deref(), Some("timeout"));
+
+        merge(&mut stats, &run_stats(300, 1, 30, 0, None));
+        assert_eq!(stats.last_run_at, 300);
+        assert_eq!(stats.last_duration, 30);
+        assert_eq!(stats.last_error, None);
+    }
+}
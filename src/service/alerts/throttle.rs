@@ -0,0 +1,408 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Rate limits and quiet hours of the destinations. The notifications over the
+//! limit or in quiet hours are dropped, held for a digest or sent to the
+//! fallback destination, depending on the overflow action of the destination.
+
+use chrono::{DateTime, Datelike, Duration, FixedOffset, NaiveTime, TimeZone, Utc, Weekday};
+use config::utils::json;
+use infra::dist_lock;
+
+use crate::{
+    common::meta::alerts::{
+        destinations::{
+            Destination, DestinationType, DestinationWithTemplate, DigestEntry, OverflowAction,
+            QuietHours, RateLimit, ThrottleState,
+        },
+        Alert,
+    },
+    service::db,
+};
+
+/// Where the notification of an alert goes
+pub enum Route {
+    Send,
+    /// Dropped or held for the digest
+    Held,
+    Fallback(Box<DestinationWithTemplate>),
+}
+
+/// Counts the notification against the limits of the destination
+pub async fn route(alert: &Alert, dest: &DestinationWithTemplate) -> Result<Route, anyhow::Error> {
+    if dest.rate_limit.is_none() && dest.quiet_hours.is_empty() {
+        return Ok(Route::Send);
+    }
+    let locker = dist_lock::lock(&lock_key(&alert.org_id, &dest.name), 0).await?;
+    let ret = admit_or_hold(alert, dest).await;
+    dist_lock::unlock(&locker).await?;
+    let Some(reason) = ret? else {
+        return Ok(Route::Send);
+    };
+
+    log::info!(
+        "Alert {}/{} notification to {} held by {}, overflow: {:?}",
+        alert.org_id,
+        alert.name,
+        dest.name,
+        reason,
+        dest.overflow
+    );
+    if dest.overflow != OverflowAction::Fallback || dest.fallback_destination.is_empty() {
+        return Ok(Route::Held);
+    }
+    let fallback =
+        super::destinations::get_with_template(&alert.org_id, &dest.fallback_destination).await?;
+    Ok(Route::Fallback(Box::new(fallback)))
+}
+
+async fn admit_or_hold(
+    alert: &Alert,
+    dest: &DestinationWithTemplate,
+) -> Result<Option<&'static str>, anyhow::Error> {
+    let mut state = db::alerts::throttle::get(&alert.org_id, &dest.name).await?;
+    let now = Utc::now();
+    let reason = admit(dest.rate_limit.as_ref(), &dest.quiet_hours, &mut state, now);
+    if reason.is_some() && dest.overflow == OverflowAction::Digest {
+        hold(&mut state, alert, now.timestamp_micros());
+    }
+    db::alerts::throttle::set(&alert.org_id, &dest.name, &state).await?;
+    Ok(reason)
+}
+
+/// Returns why the notification can't be sent now, otherwise counts it as sent
+fn admit(
+    rate_limit: Option<&RateLimit>,
+    quiet_hours: &[QuietHours],
+    state: &mut ThrottleState,
+    now: DateTime<Utc>,
+) -> Option<&'static str> {
+    if quiet_hours.iter().any(|window| in_quiet_hours(window, now)) {
+        return Some("quiet hours");
+    }
+    let now = now.timestamp_micros();
+    if let Some(limit) = rate_limit {
+        let since = now - minutes(limit.interval);
+        state.sent.retain(|t| *t > since);
+        if state.sent.len() >= limit.max_notifications as usize {
+            return Some("rate limit");
+        }
+        state.sent.push(now);
+    }
+    None
+}
+
+fn hold(state: &mut ThrottleState, alert: &Alert, now: i64) {
+    let stream_type = alert.stream_type.to_string();
+    match state.digest.iter_mut().find(|entry| {
+        entry.alert_name == alert.name
+            && entry.stream_type == stream_type
+            && entry.stream_name == alert.stream_name
+    }) {
+        Some(entry) => {
+            entry.count += 1;
+            entry.last_at = now;
+        }
+        None => state.digest.push(DigestEntry {
+            alert_name: alert.name.clone(),
+            stream_type,
+            stream_name: alert.stream_name.clone(),
+            count: 1,
+            first_at: now,
+            last_at: now,
+        }),
+    }
+    if state.digest_started_at == 0 {
+        state.digest_started_at = now;
+    }
+}
+
+fn in_quiet_hours(window: &QuietHours, now: DateTime<Utc>) -> bool {
+    let (Ok(start), Ok(end)) = (parse_time(&window.start), parse_time(&window.end)) else {
+        return false;
+    };
+    let Some(tz) = FixedOffset::east_opt(window.tz_offset * 60) else {
+        return false;
+    };
+    let local = now.with_timezone(&tz);
+    let time = local.time();
+    let day = local.weekday();
+    let starts_on = |day: Weekday| {
+        window.days.is_empty()
+            || window
+                .days
+                .iter()
+                .any(|d| d.parse::<Weekday>().is_ok_and(|d| d == day))
+    };
+    if start <= end {
+        start <= time && time < end && starts_on(day)
+    } else {
+        // crossing midnight, the early hours belong to the window of the day
+        // before
+        (time >= start && starts_on(day)) || (time < end && starts_on(day.pred()))
+    }
+}
+
+fn parse_time(s: &str) -> chrono::ParseResult<NaiveTime> {
+    NaiveTime::parse_from_str(s.trim(), "%H:%M")
+}
+
+/// Checks the rate limit, quiet hours and overflow settings of a destination
+pub fn validate(dest: &Destination) -> Result<(), String> {
+    if let Some(limit) = dest.rate_limit.as_ref() {
+        if limit.max_notifications == 0 || limit.interval <= 0 {
+            return Err(
+                "Rate limit needs at least 1 notification and an interval of at least 1 minute"
+                    .to_string(),
+            );
+        }
+    }
+    for window in dest.quiet_hours.iter() {
+        if parse_time(&window.start).is_err() || parse_time(&window.end).is_err() {
+            return Err(format!(
+                "Quiet hours {} to {} should be HH:MM",
+                window.start, window.end
+            ));
+        }
+        if let Some(day) = window.days.iter().find(|d| d.parse::<Weekday>().is_err()) {
+            return Err(format!("Invalid quiet hours day {day}"));
+        }
+        if window.tz_offset.abs() > 14 * 60 {
+            return Err(format!(
+                "Invalid quiet hours tz_offset {}",
+                window.tz_offset
+            ));
+        }
+    }
+    match dest.overflow {
+        OverflowAction::Fallback => {
+            if dest.fallback_destination.is_empty() {
+                return Err("Fallback overflow needs a fallback_destination".to_string());
+            }
+            if dest.fallback_destination == dest.name {
+                return Err("Destination can't be its own fallback".to_string());
+            }
+        }
+        OverflowAction::Digest => {
+            if dest.digest_interval <= 0 {
+                return Err("Digest interval should be at least 1 minute".to_string());
+            }
+        }
+        OverflowAction::Drop => {}
+    }
+    Ok(())
+}
+
+/// Sends the digests which are due, outside of the quiet hours of their
+/// destination
+pub async fn flush_digests() -> Result<(), anyhow::Error> {
+    for (org_id, name, state) in db::alerts::throttle::list_all().await? {
+        if state.digest.is_empty() {
+            continue;
+        }
+        let dest = match super::destinations::get_with_template(&org_id, &name).await {
+            Ok(dest) => dest,
+            Err(_) => {
+                // the destination was deleted
+                db::alerts::throttle::delete(&org_id, &name).await?;
+                continue;
+            }
+        };
+        if !digest_due(&dest, &state, Utc::now()) {
+            continue;
+        }
+
+        // another node may have sent it since the list
+        let locker = dist_lock::lock(&lock_key(&org_id, &name), 0).await?;
+        let taken = take_digest(&org_id, &dest).await;
+        dist_lock::unlock(&locker).await?;
+        let entries = taken?;
+        if entries.is_empty() {
+            continue;
+        }
+        if let Err(e) = send_digest(&org_id, &dest, &entries).await {
+            log::error!(
+                "Error sending alert digest to {}/{}: {}",
+                org_id,
+                dest.name,
+                e
+            );
+        }
+    }
+    Ok(())
+}
+
+fn digest_due(dest: &DestinationWithTemplate, state: &ThrottleState, now: DateTime<Utc>) -> bool {
+    !state.digest.is_empty()
+        && now.timestamp_micros() - state.digest_started_at >= minutes(dest.digest_interval)
+        && !dest
+            .quiet_hours
+            .iter()
+            .any(|window| in_quiet_hours(window, now))
+}
+
+async fn take_digest(
+    org_id: &str,
+    dest: &DestinationWithTemplate,
+) -> Result<Vec<DigestEntry>, anyhow::Error> {
+    let mut state = db::alerts::throttle::get(org_id, &dest.name).await?;
+    if !digest_due(dest, &state, Utc::now()) {
+        return Ok(vec![]);
+    }
+    let entries = std::mem::take(&mut state.digest);
+    state.digest_started_at = 0;
+    db::alerts::throttle::set(org_id, &dest.name, &state).await?;
+    Ok(entries)
+}
+
+async fn send_digest(
+    org_id: &str,
+    dest: &DestinationWithTemplate,
+    entries: &[DigestEntry],
+) -> Result<(), anyhow::Error> {
+    let text = digest_text(&dest.name, entries);
+    match dest.destination_type {
+        DestinationType::Http => {
+            let msg = json::json!({
+                "text": text,
+                "alerts": entries,
+            });
+            super::send_http_notification(dest, json::to_string(&msg)?).await
+        }
+        DestinationType::Email => {
+            let title = format!("digest of {} alerts", entries.len());
+            super::send_email_notification(&title, dest, text.replace('\n', "<br>")).await
+        }
+        DestinationType::Plugin => {
+            let title = format!("digest of {} alerts", entries.len());
+            super::send_plugin_notification(org_id, &title, dest, text).await
+        }
+    }
+}
+
+fn digest_text(destination: &str, entries: &[DigestEntry]) -> String {
+    let total = entries.iter().map(|entry| entry.count).sum::<u64>();
+    let mut text = format!("{total} notifications to {destination} were held:");
+    for entry in entries {
+        text.push_str(&format!(
+            "\n- {} on {}/{}: {} times from {} to {}",
+            entry.alert_name,
+            entry.stream_type,
+            entry.stream_name,
+            entry.count,
+            format_time(entry.first_at),
+            format_time(entry.last_at)
+        ));
+    }
+    text
+}
+
+fn format_time(micros: i64) -> String {
+    Utc.timestamp_nanos(micros * 1000)
+        .format("%Y-%m-%dT%H:%M:%SZ")
+        .to_string()
+}
+
+fn lock_key(org_id: &str, destination: &str) -> String {
+    format!("/alert_throttle/{org_id}/{destination}")
+}
+
+fn minutes(n: i64) -> i64 {
+    Duration::try_minutes(n)
+        .unwrap()
+        .num_microseconds()
+        .unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn at(s: &str) -> DateTime<Utc> {
+        DateTime::parse_from_rfc3339(s).unwrap().with_timezone(&Utc)
+    }
+
+    fn night(days: &[&str]) -> QuietHours {
+        QuietHours {
+            start: "22:00".to_string(),
+            end: "07:00".to_string(),
+            days: days.iter().map(|d| d.to_string()).collect(),
+            tz_offset: 60,
+        }
+    }
+
+    #[test]
+    fn test_in_quiet_hours() {
+        let window = night(&[]);
+        // 21:30 and 22:30 local time
+        assert!(!in_quiet_hours(&window, at("2024-06-07T20:30:00Z")));
+        assert!(in_quiet_hours(&window, at("2024-06-07T21:30:00Z")));
+        // 06:59 and 07:00 local time
+        assert!(in_quiet_hours(&window, at("2024-06-08T05:59:00Z")));
+        assert!(!in_quiet_hours(&window, at("2024-06-08T06:00:00Z")));
+
+        // friday night only, 2024-06-07 is a friday
+        let window = night(&["fri"]);
+        assert!(in_quiet_hours(&window, at("2024-06-07T23:00:00Z")));
+        assert!(in_quiet_hours(&window, at("2024-06-08T03:00:00Z")));
+        assert!(!in_quiet_hours(&window, at("2024-06-08T23:00:00Z")));
+        assert!(!in_quiet_hours(&window, at("2024-06-07T03:00:00Z")));
+    }
+
+    #[test]
+    fn test_admit_rate_limit() {
+        let limit = RateLimit {
+            max_notifications: 2,
+            interval: 10,
+        };
+        let mut state = ThrottleState::default();
+        let now = at("2024-06-07T12:00:00Z");
+        assert_eq!(admit(Some(&limit), &[], &mut state, now), None);
+        assert_eq!(admit(Some(&limit), &[], &mut state, now), None);
+        assert_eq!(
+            admit(Some(&limit), &[], &mut state, now),
+            Some("rate limit")
+        );
+        let later = at("2024-06-07T12:10:01Z");
+        assert_eq!(admit(Some(&limit), &[], &mut state, later), None);
+        assert_eq!(state.sent.len(), 1);
+        assert_eq!(
+            admit(None, &[night(&[])], &mut state, at("2024-06-07T23:00:00Z")),
+            Some("quiet hours")
+        );
+    }
+
+    #[test]
+    fn test_hold() {
+        let alert = Alert {
+            name: "errors".to_string(),
+            stream_name: "app".to_string(),
+            ..Default::default()
+        };
+        let mut state = ThrottleState::default();
+        hold(&mut state, &alert, 100);
+        hold(&mut state, &alert, 200);
+        assert_eq!(state.digest.len(), 1);
+        assert_eq!(state.digest[0].count, 2);
+        assert_eq!(state.digest[0].first_at, 100);
+        assert_eq!(state.digest[0].last_at, 200);
+        assert_eq!(state.digest_started_at, 100);
+        assert_eq!(
+            digest_text("oncall", &state.digest),
+            "2 notifications to oncall were held:\n- errors on logs/app: 2 times from \
+             1970-01-01T00:00:00Z to 1970-01-01T00:00:00Z"
+        );
+    }
+}
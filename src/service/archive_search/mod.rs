@@ -0,0 +1,467 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        sql::Sql,
+        stream::PartitionTimeLevel,
+    },
+    utils::json,
+};
+use futures::{StreamExt, TryStreamExt};
+use infra::{
+    dist_lock,
+    errors::{Error as InfraError, ErrorCodes},
+};
+
+use super::{
+    alerts, db, file_list,
+    search::{self as SearchService, access},
+    users,
+};
+use crate::common::meta::{
+    alerts::destinations::DestinationType,
+    archive_search::{
+        ArchiveSearchJob, ArchiveSearchJobList, ArchiveSearchRequest, ArchiveSearchStatus,
+    },
+    http::HttpResponse as MetaHttpResponse,
+};
+
+mod s3;
+
+#[tracing::instrument(skip(req))]
+pub async fn create_job(
+    org_id: &str,
+    user_email: &str,
+    req: ArchiveSearchRequest,
+) -> Result<HttpResponse, Error> {
+    if req.start_time <= 0 || req.end_time <= req.start_time {
+        return Ok(MetaHttpResponse::bad_request("Invalid time range"));
+    }
+    if req.size <= 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "size should be greater than 0",
+        ));
+    }
+    let stream_name = match Sql::new(&req.sql) {
+        Ok(sql) => sql.source,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    if infra::schema::get(org_id, &stream_name, req.stream_type)
+        .await
+        .map(|schema| schema.fields().is_empty())
+        .unwrap_or(true)
+    {
+        return Ok(MetaHttpResponse::not_found(format!(
+            "Stream {stream_name} not found"
+        )));
+    }
+    if !access::can_read(org_id, user_email, req.stream_type, &stream_name).await {
+        return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+    }
+    if !req.destination.is_empty() {
+        if let Err(e) = alerts::destinations::get(org_id, &req.destination).await {
+            return Ok(MetaHttpResponse::bad_request(e));
+        }
+    }
+    if let Err(e) = s3::client() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+
+    let now = Utc::now().timestamp_micros();
+    let job = ArchiveSearchJob {
+        id: config::ider::uuid(),
+        org_id: org_id.to_string(),
+        stream_type: req.stream_type,
+        stream_name,
+        sql: req.sql,
+        start_time: req.start_time,
+        end_time: req.end_time,
+        size: std::cmp::min(req.size, get_config().limit.archive_search_max_hits),
+        destination: req.destination,
+        status: ArchiveSearchStatus::Pending,
+        created_by: user_email.to_string(),
+        created_at: now,
+        updated_at: now,
+        ..Default::default()
+    };
+    match db::archive_search::set(&job).await {
+        Ok(_) => {
+            log::info!(
+                "[ARCHIVE SEARCH] job {}/{} on stream {} created by {}",
+                job.org_id,
+                job.id,
+                job.stream_name,
+                user_email
+            );
+            Ok(MetaHttpResponse::json(job))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Lists the jobs of the user, every job of the organization for the admins,
+/// without their hits, which are returned by [`get_job`]
+#[tracing::instrument]
+pub async fn list_jobs(org_id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let is_admin = users::is_admin(org_id, user_id).await;
+    match db::archive_search::list(org_id).await {
+        Ok(mut list) => {
+            list.retain(|job| is_admin || job.created_by == user_id);
+            Ok(MetaHttpResponse::json(ArchiveSearchJobList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Returns the job with its hits once it completed
+#[tracing::instrument]
+pub async fn get_job(org_id: &str, id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let Some(mut job) = get_visible(org_id, id, user_id).await else {
+        return Ok(MetaHttpResponse::not_found("Archive search job not found"));
+    };
+    if job.status == ArchiveSearchStatus::Completed {
+        match load_hits(&job).await {
+            Ok(hits) => job.hits = hits,
+            Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+        }
+    }
+    Ok(MetaHttpResponse::json(job))
+}
+
+/// Deletes the job and its hits, the restored copies of the objects expire on
+/// their own
+#[tracing::instrument]
+pub async fn delete_job(org_id: &str, id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let Some(job) = get_visible(org_id, id, user_id).await else {
+        return Ok(MetaHttpResponse::not_found("Archive search job not found"));
+    };
+    if job.status == ArchiveSearchStatus::Running {
+        return Ok(MetaHttpResponse::bad_request(
+            "Archive search job is running, please retry after it finishes",
+        ));
+    }
+    if let Err(e) = db::archive_search::delete(org_id, id).await {
+        return Ok(MetaHttpResponse::internal_error(e));
+    }
+    if job.status == ArchiveSearchStatus::Completed {
+        let key = hits_key(org_id, id);
+        if let Err(e) = infra::storage::del(&[key.as_str()]).await {
+            log::error!(
+                "[ARCHIVE SEARCH] delete hits of job {org_id}/{id} error: {}",
+                e
+            );
+        }
+    }
+    Ok(MetaHttpResponse::ok("Archive search job deleted"))
+}
+
+/// The job, when the user created it or is an admin, the jobs of the other
+/// users are not found
+async fn get_visible(org_id: &str, id: &str, user_id: &str) -> Option<ArchiveSearchJob> {
+    let job = db::archive_search::get(org_id, id).await.ok()?;
+    if job.created_by == user_id || users::is_admin(org_id, user_id).await {
+        Some(job)
+    } else {
+        None
+    }
+}
+
+/// Object storage key of the hits of the job
+fn hits_key(org_id: &str, id: &str) -> String {
+    format!("archive_search/{org_id}/{id}.json")
+}
+
+async fn load_hits(job: &ArchiveSearchJob) -> Result<Vec<json::Value>, anyhow::Error> {
+    let data = infra::storage::get(&hits_key(&job.org_id, &job.id)).await?;
+    Ok(json::from_slice(&data)?)
+}
+
+/// Moves every unfinished job forward: requests the restore of its archived
+/// objects, waits for them to be restored and then runs the query
+pub async fn run() -> Result<(), anyhow::Error> {
+    let started_at = Utc::now().timestamp_micros();
+    let jobs = db::archive_search::list("").await?;
+    for job in jobs {
+        if job.is_done() {
+            continue;
+        }
+        let locker =
+            dist_lock::lock(&format!("/archive_search/run/{}/{}", job.org_id, job.id), 0).await?;
+        let ret = process(&job.org_id, &job.id, started_at).await;
+        dist_lock::unlock(&locker).await?;
+        if let Err(e) = ret {
+            log::error!(
+                "[ARCHIVE SEARCH] job {}/{} error: {}",
+                job.org_id,
+                job.id,
+                e
+            );
+        }
+    }
+    Ok(())
+}
+
+async fn process(org_id: &str, id: &str, started_at: i64) -> Result<(), anyhow::Error> {
+    let Ok(mut job) = db::archive_search::get(org_id, id).await else {
+        return Ok(());
+    };
+    // another querier already handled it in this round
+    if job.is_done() || job.updated_at >= started_at {
+        return Ok(());
+    }
+
+    let ret = async {
+        if job.status == ArchiveSearchStatus::Pending {
+            request_restore(&mut job).await?;
+        }
+        if job.status == ArchiveSearchStatus::Restoring {
+            check_restored(&mut job).await?;
+        }
+        if job.status == ArchiveSearchStatus::Running {
+            execute(&mut job).await?;
+        }
+        Ok::<(), anyhow::Error>(())
+    }
+    .await;
+    if let Err(e) = ret {
+        job.status = ArchiveSearchStatus::Failed;
+        job.error = e.to_string();
+    }
+    job.updated_at = Utc::now().timestamp_micros();
+    db::archive_search::set(&job).await?;
+
+    if job.is_done() {
+        if let Err(e) = notify(&job).await {
+            log::error!(
+                "[ARCHIVE SEARCH] job {}/{} notify {} error: {}",
+                job.org_id,
+                job.id,
+                job.destination,
+                e
+            );
+        }
+    }
+    Ok(())
+}
+
+async fn request_restore(job: &mut ArchiveSearchJob) -> Result<(), anyhow::Error> {
+    let mut keys = file_list::query(
+        &job.org_id,
+        &job.stream_name,
+        job.stream_type,
+        PartitionTimeLevel::Unset,
+        job.start_time,
+        job.end_time,
+        false,
+    )
+    .await?
+    .into_iter()
+    .map(|file| file.key)
+    .collect::<Vec<_>>();
+    keys.sort();
+    keys.dedup();
+
+    job.restoring_files = restore_files(keys).await?;
+    job.restored_files = 0;
+    job.status = if job.restoring_files.is_empty() {
+        ArchiveSearchStatus::Running
+    } else {
+        ArchiveSearchStatus::Restoring
+    };
+    log::info!(
+        "[ARCHIVE SEARCH] job {}/{} restoring {} archived files",
+        job.org_id,
+        job.id,
+        job.restoring_files.len()
+    );
+    Ok(())
+}
+
+async fn check_restored(job: &mut ArchiveSearchJob) -> Result<(), anyhow::Error> {
+    let keys = std::mem::take(&mut job.restoring_files);
+    let total = keys.len();
+    job.restoring_files = restore_files(keys).await?;
+    job.restored_files += total - job.restoring_files.len();
+    if job.restoring_files.is_empty() {
+        job.status = ArchiveSearchStatus::Running;
+    }
+    Ok(())
+}
+
+/// Requests the restore of the archived objects, returns the objects which are
+/// not restored yet
+async fn restore_files(keys: Vec<String>) -> Result<Vec<String>, anyhow::Error> {
+    let client = s3::client()?;
+    let client = &client;
+    let keys = futures::stream::iter(keys)
+        .map(|key| async move {
+            match s3::head(client, &key).await? {
+                s3::ObjectState::Available => Ok(None),
+                s3::ObjectState::Restoring => Ok(Some(key)),
+                state => {
+                    s3::restore(client, &key, state).await?;
+                    Ok(Some(key))
+                }
+            }
+        })
+        .buffer_unordered(get_config().limit.cpu_num)
+        .try_collect::<Vec<Option<String>>>()
+        .await?;
+    Ok(keys.into_iter().flatten().collect())
+}
+
+async fn execute(job: &mut ArchiveSearchJob) -> Result<(), anyhow::Error> {
+    // the job searches as its creator, who may have lost the access since
+    if !access::can_read(
+        &job.org_id,
+        &job.created_by,
+        job.stream_type,
+        &job.stream_name,
+    )
+    .await
+    {
+        return Err(anyhow::anyhow!(
+            "{} can no longer read the stream {}",
+            job.created_by,
+            job.stream_name
+        ));
+    }
+    let req = Request {
+        query: Query {
+            sql: job.sql.clone(),
+            from: 0,
+            size: job.size,
+            start_time: job.start_time,
+            end_time: job.end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: get_config().limit.query_timeout as i64,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    match SearchService::search(
+        &trace_id,
+        &job.org_id,
+        job.stream_type,
+        Some(job.created_by.clone()),
+        &req,
+    )
+    .await
+    {
+        Ok(res) => {
+            let data = json::to_vec(&res.hits)?;
+            infra::storage::put(&hits_key(&job.org_id, &job.id), data.into()).await?;
+            job.hits_count = res.hits.len();
+            job.total = res.total;
+            job.error = "".to_string();
+            job.status = ArchiveSearchStatus::Completed;
+            Ok(())
+        }
+        // the restored copies expired or new objects were archived meanwhile
+        Err(InfraError::ErrorCode(ErrorCodes::SearchObjectArchived(e))) => {
+            log::warn!(
+                "[ARCHIVE SEARCH] job {}/{} found archived files again: {}",
+                job.org_id,
+                job.id,
+                e
+            );
+            job.status = ArchiveSearchStatus::Pending;
+            Ok(())
+        }
+        Err(e) => Err(e.into()),
+    }
+}
+
+async fn notify(job: &ArchiveSearchJob) -> Result<(), anyhow::Error> {
+    if job.destination.is_empty() {
+        return Ok(());
+    }
+    let dest = alerts::destinations::get_with_template(&job.org_id, &job.destination).await?;
+    let text = notification_text(job);
+    match dest.destination_type {
+        DestinationType::Http => {
+            let msg = json::json!({
+                "text": text,
+                "org_id": job.org_id,
+                "id": job.id,
+                "status": job.status,
+                "total": job.total,
+            });
+            alerts::send_http_notification(&dest, json::to_string(&msg)?).await
+        }
+        DestinationType::Email => {
+            let title = format!("archive search {}", job.id);
+            alerts::send_email_notification(&title, &dest, text).await
+        }
+        DestinationType::Plugin => {
+            let title = format!("archive search {}", job.id);
+            alerts::send_plugin_notification(&job.org_id, &title, &dest, text).await
+        }
+    }
+}
+
+fn notification_text(job: &ArchiveSearchJob) -> String {
+    if job.status == ArchiveSearchStatus::Completed {
+        format!(
+            "Archive search {} on stream {} completed with {} hits, {} archived files were restored",
+            job.id, job.stream_name, job.total, job.restored_files
+        )
+    } else {
+        format!(
+            "Archive search {} on stream {} failed: {}",
+            job.id, job.stream_name, job.error
+        )
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_notification_text() {
+        let mut job = ArchiveSearchJob {
+            id: "job1".to_string(),
+            stream_name: "default".to_string(),
+            status: ArchiveSearchStatus::Completed,
+            total: 42,
+            restored_files: 3,
+            ..Default::default()
+        };
+        assert_eq!(
+            notification_text(&job),
+            "Archive search job1 on stream default completed with 42 hits, 3 archived files were restored"
+        );
+        job.status = ArchiveSearchStatus::Failed;
+        job.error = "timeout".to_string();
+        assert_eq!(
+            notification_text(&job),
+            "Archive search job1 on stream default failed: timeout"
+        );
+    }
+}
@@ -0,0 +1,340 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Checks the storage class of the objects and requests their restore, which
+//! the object_store crate doesn't support
+
+use chrono::Utc;
+use config::get_config;
+
+use crate::common::utils::aws;
+
+const SERVICE: &str = "s3";
+const DEFAULT_REGION: &str = "us-east-1";
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq)]
+pub enum ObjectState {
+    /// Readable, stored in a regular storage class or restored
+    Available,
+    /// Stored in Glacier Flexible Retrieval or Deep Archive
+    Archived,
+    /// Moved to an archive tier of Intelligent-Tiering
+    TieringArchived,
+    /// A restore of the object is in progress
+    Restoring,
+}
+
+/// Gets the state of the object from the `x-amz-storage-class`,
+/// `x-amz-restore` and `x-amz-archive-status` headers of a HEAD response
+pub fn object_state(
+    storage_class: Option<&str>,
+    restore: Option<&str>,
+    archive_status: Option<&str>,
+) -> ObjectState {
+    let archived = matches!(storage_class, Some("GLACIER") | Some("DEEP_ARCHIVE"));
+    let tiering_archived = archive_status.is_some_and(|s| s.ends_with("ARCHIVE_ACCESS"));
+    if !archived && !tiering_archived {
+        return ObjectState::Available;
+    }
+    match restore {
+        Some(v) if v.contains("ongoing-request=\"true\"") => ObjectState::Restoring,
+        Some(v) if v.contains("ongoing-request=\"false\"") => ObjectState::Available,
+        _ if tiering_archived => ObjectState::TieringArchived,
+        _ => ObjectState::Archived,
+    }
+}
+
+/// Sends a HEAD request for the object to get its state
+pub async fn head(client: &reqwest::Client, key: &str) -> Result<ObjectState, anyhow::Error> {
+    let resp = send(client, reqwest::Method::HEAD, key, false, vec![]).await?;
+    // merged or deleted by the compactor meanwhile, nothing to restore
+    if resp.status() == reqwest::StatusCode::NOT_FOUND {
+        return Ok(ObjectState::Available);
+    }
+    if !resp.status().is_success() {
+        return Err(anyhow::anyhow!(
+            "head object {key} error: {}",
+            resp.status()
+        ));
+    }
+    let headers = resp.headers();
+    let header = |name: &str| headers.get(name).and_then(|v| v.to_str().ok());
+    Ok(object_state(
+        header("x-amz-storage-class"),
+        header("x-amz-restore"),
+        header("x-amz-archive-status"),
+    ))
+}
+
+/// Requests a temporary copy of an archived object, a restore already in
+/// progress is not an error
+pub async fn restore(
+    client: &reqwest::Client,
+    key: &str,
+    state: ObjectState,
+) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let body = restore_request(
+        cfg.limit.archive_restore_days,
+        &cfg.limit.archive_restore_tier,
+        state == ObjectState::TieringArchived,
+    );
+    let resp = send(client, reqwest::Method::POST, key, true, body.into_bytes()).await?;
+    match resp.status().as_u16() {
+        200 | 202 | 409 => Ok(()),
+        status => Err(anyhow::anyhow!(
+            "restore object {key} error: {status}, {}",
+            resp.text().await.unwrap_or_default()
+        )),
+    }
+}
+
+/// Objects archived by Intelligent-Tiering move back to the frequent access
+/// tier, so their restore has no number of days
+fn restore_request(days: i64, tier: &str, tiering: bool) -> String {
+    let days = if tiering {
+        String::new()
+    } else {
+        format!("<Days>{days}</Days>")
+    };
+    format!(
+        "<RestoreRequest xmlns=\"http://s3.amazonaws.com/doc/2006-03-01/\">{days}<GlacierJobParameters><Tier>{tier}</Tier></GlacierJobParameters></RestoreRequest>"
+    )
+}
+
+pub fn client() -> Result<reqwest::Client, anyhow::Error> {
+    let cfg = get_config();
+    match cfg.s3.provider.as_str() {
+        "azure" | "gcs" | "gcp" => {
+            return Err(anyhow::anyhow!(
+                "archive search is not supported by the {} storage provider",
+                cfg.s3.provider
+            ));
+        }
+        _ => {}
+    }
+    Ok(reqwest::Client::builder()
+        .connect_timeout(std::time::Duration::from_secs(cfg.s3.connect_timeout))
+        .timeout(std::time::Duration::from_secs(cfg.s3.request_timeout))
+        .danger_accept_invalid_certs(cfg.s3.allow_invalid_certificates)
+        .build()?)
+}
+
+async fn send(
+    client: &reqwest::Client,
+    method: reqwest::Method,
+    key: &str,
+    is_restore: bool,
+    body: Vec<u8>,
+) -> Result<reqwest::Response, anyhow::Error> {
+    let cfg = get_config();
+    let region = if cfg.s3.region_name.is_empty() {
+        DEFAULT_REGION
+    } else {
+        cfg.s3.region_name.as_str()
+    };
+    let (access_key_id, secret_access_key, session_token) = if cfg.s3.access_key.is_empty() {
+        (
+            std::env::var("AWS_ACCESS_KEY_ID").unwrap_or_default(),
+            std::env::var("AWS_SECRET_ACCESS_KEY").unwrap_or_default(),
+            std::env::var("AWS_SESSION_TOKEN").unwrap_or_default(),
+        )
+    } else {
+        (
+            cfg.s3.access_key.clone(),
+            cfg.s3.secret_key.clone(),
+            String::new(),
+        )
+    };
+    if access_key_id.is_empty() {
+        return Err(anyhow::anyhow!(
+            "archive search needs the s3 access key or the AWS_ACCESS_KEY_ID env"
+        ));
+    }
+
+    let (url, path) = object_url(
+        &cfg.s3.server_url,
+        &cfg.s3.bucket_name,
+        region,
+        cfg.s3.feature_force_hosted_style,
+        &infra::storage::format_key(key, true),
+    );
+    let parsed = url::Url::parse(&url)?;
+    let host = match (parsed.host_str(), parsed.port()) {
+        (Some(host), Some(port)) => format!("{host}:{port}"),
+        (Some(host), None) => host.to_string(),
+        _ => return Err(anyhow::anyhow!("invalid s3 url: {url}")),
+    };
+    let query = if is_restore { "restore=" } else { "" };
+    let payload_hash = aws::payload_hash(&body);
+    let amz_date = Utc::now().format("%Y%m%dT%H%M%SZ").to_string();
+    let mut headers = vec![
+        ("host", host),
+        ("x-amz-content-sha256", payload_hash.clone()),
+        ("x-amz-date", amz_date.clone()),
+    ];
+    if !session_token.is_empty() {
+        headers.push(("x-amz-security-token", session_token));
+    }
+    let credential = aws::Credential {
+        access_key_id: &access_key_id,
+        secret_access_key: &secret_access_key,
+        region,
+        service: SERVICE,
+    };
+    let authorization = aws::sign_v4(
+        &credential,
+        method.as_str(),
+        &path,
+        query,
+        &amz_date,
+        &headers,
+        &payload_hash,
+    );
+
+    let url = if is_restore {
+        format!("{url}?restore")
+    } else {
+        url
+    };
+    let mut req = client
+        .request(method, url)
+        .header("authorization", authorization);
+    for (name, value) in headers {
+        if name != "host" {
+            req = req.header(name, value);
+        }
+    }
+    Ok(req.body(body).send().await?)
+}
+
+/// Returns the url of the object and its URI encoded path, a virtual hosted
+/// style url is used for AWS
+fn object_url(
+    server_url: &str,
+    bucket: &str,
+    region: &str,
+    hosted_style: bool,
+    key: &str,
+) -> (String, String) {
+    let key = aws::encode_path(key);
+    let (base, path) = if server_url.is_empty() {
+        (
+            format!("https://{bucket}.s3.{region}.amazonaws.com"),
+            format!("/{key}"),
+        )
+    } else if hosted_style {
+        (
+            server_url.trim_end_matches('/').to_string(),
+            format!("/{key}"),
+        )
+    } else {
+        (
+            server_url.trim_end_matches('/').to_string(),
+            format!("/{bucket}/{key}"),
+        )
+    };
+    (format!("{base}{path}"), path)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_object_state() {
+        assert_eq!(object_state(None, None, None), ObjectState::Available);
+        assert_eq!(
+            object_state(Some("STANDARD_IA"), None, None),
+            ObjectState::Available
+        );
+        assert_eq!(
+            object_state(Some("GLACIER_IR"), None, None),
+            ObjectState::Available
+        );
+        assert_eq!(
+            object_state(Some("DEEP_ARCHIVE"), None, None),
+            ObjectState::Archived
+        );
+        assert_eq!(
+            object_state(Some("GLACIER"), Some("ongoing-request=\"true\""), None),
+            ObjectState::Restoring
+        );
+        assert_eq!(
+            object_state(
+                Some("GLACIER"),
+                Some("ongoing-request=\"false\", expiry-date=\"Fri, 23 Dec 2012 00:00:00 GMT\""),
+                None
+            ),
+            ObjectState::Available
+        );
+        assert_eq!(
+            object_state(
+                Some("INTELLIGENT_TIERING"),
+                None,
+                Some("DEEP_ARCHIVE_ACCESS")
+            ),
+            ObjectState::TieringArchived
+        );
+        assert_eq!(
+            object_state(Some("INTELLIGENT_TIERING"), None, None),
+            ObjectState::Available
+        );
+    }
+
+    #[test]
+    fn test_restore_request() {
+        assert_eq!(
+            restore_request(7, "Standard", false),
+            "<RestoreRequest xmlns=\"http://s3.amazonaws.com/doc/2006-03-01/\"><Days>7</Days><GlacierJobParameters><Tier>Standard</Tier></GlacierJobParameters></RestoreRequest>"
+        );
+        assert!(!restore_request(7, "Bulk", true).contains("<Days>"));
+    }
+
+    #[test]
+    fn test_object_url() {
+        assert_eq!(
+            object_url("", "logs", "eu-west-1", false, "files/default/a b.parquet"),
+            (
+                "https://logs.s3.eu-west-1.amazonaws.com/files/default/a%20b.parquet".to_string(),
+                "/files/default/a%20b.parquet".to_string()
+            )
+        );
+        assert_eq!(
+            object_url(
+                "http://minio:9000/",
+                "logs",
+                "us-east-1",
+                false,
+                "files/a.parquet"
+            ),
+            (
+                "http://minio:9000/logs/files/a.parquet".to_string(),
+                "/logs/files/a.parquet".to_string()
+            )
+        );
+        assert_eq!(
+            object_url(
+                "https://logs.oss.example.com",
+                "logs",
+                "us-east-1",
+                true,
+                "files/a.parquet"
+            )
+            .1,
+            "/files/a.parquet"
+        );
+    }
+}
@@ -0,0 +1,565 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Changes, exports and imports of many alerts or dashboards in one call, for
+//! GitOps and migration tools. A change is applied to all the objects or to
+//! none of them: every object is checked before changing any, and the objects
+//! already changed are reverted when one of them fails.
+
+use std::io::Error;
+
+use actix_web::{web, HttpResponse};
+use chrono::Utc;
+use config::utils::json;
+
+use crate::{
+    common::{
+        meta::{
+            alerts::Alert,
+            authz::Authz,
+            bulk::{
+                validate_ids, BulkAction, BulkExportRequest, BulkImportRequest, BulkObject,
+                BulkRequest, BulkResponse,
+            },
+            dashboards::{Dashboard, DashboardVersion, FolderAccess},
+            governance::{Governance, GovernanceRequest, GovernedKind},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::{remove_ownership, set_ownership},
+    },
+    service::{
+        alerts::{self, alert_key},
+        dashboards::{self, folders::FolderAccessResolver},
+        db,
+        governance::split_stream_object_id,
+        search::field_access,
+    },
+};
+
+/// Applies the action to all the objects of the request, or to none of them
+#[tracing::instrument(skip(req))]
+pub async fn apply(
+    org_id: &str,
+    user_id: &str,
+    mut req: BulkRequest,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = validate_ids(&req.ids) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match (req.kind, req.action) {
+        (GovernedKind::Pipelines, _) => {
+            return Ok(MetaHttpResponse::bad_request(
+                "Bulk changes are not supported on pipelines",
+            ));
+        }
+        (GovernedKind::Alerts, BulkAction::Move) => {
+            return Ok(MetaHttpResponse::bad_request(
+                "Only dashboards can be moved",
+            ));
+        }
+        (GovernedKind::Dashboards, BulkAction::Enable | BulkAction::Disable) => {
+            return Ok(MetaHttpResponse::bad_request(
+                "Dashboards can't be enabled or disabled",
+            ));
+        }
+        _ => {}
+    }
+    let resolver = FolderAccessResolver::load(org_id, user_id).await;
+    match req.action {
+        BulkAction::Tag => {
+            let mut tags = GovernanceRequest {
+                tags: std::mem::take(&mut req.tags),
+                ..Default::default()
+            };
+            if let Err(e) = tags.validate() {
+                return Ok(MetaHttpResponse::bad_request(e));
+            }
+            req.tags = tags.tags;
+        }
+        BulkAction::Move => {
+            if db::dashboards::folders::get(org_id, &req.folder)
+                .await
+                .is_err()
+            {
+                return Ok(MetaHttpResponse::bad_request(format!(
+                    "Folder {} not found",
+                    req.folder
+                )));
+            }
+            if !resolver.allows(&req.folder, FolderAccess::Edit) {
+                return Ok(MetaHttpResponse::forbidden(format!(
+                    "No edit permission on the folder {}",
+                    req.folder
+                )));
+            }
+        }
+        _ => {}
+    }
+
+    let targets = match load_targets(org_id, req.kind, &req.ids, &resolver, FolderAccess::Edit)
+        .await
+    {
+        Ok(targets) => targets,
+        Err(errors) => {
+            return Ok(HttpResponse::BadRequest().json(BulkResponse::aborted(&req.ids, &errors, 0)));
+        }
+    };
+    let mut undo = Vec::with_capacity(targets.len());
+    for (i, target) in targets.into_iter().enumerate() {
+        match apply_one(org_id, user_id, &req, &req.ids[i], target).await {
+            Ok(u) => undo.push(u),
+            Err(e) => {
+                rollback(org_id, undo).await;
+                return Ok(
+                    HttpResponse::InternalServerError().json(BulkResponse::aborted(
+                        &req.ids,
+                        &[(i, e)],
+                        i,
+                    )),
+                );
+            }
+        }
+    }
+    Ok(MetaHttpResponse::json(BulkResponse::committed(&req.ids)))
+}
+
+/// Exports the objects as stored, ready to be imported in another
+/// organization or instance
+#[tracing::instrument(skip(req))]
+pub async fn export(
+    org_id: &str,
+    user_id: &str,
+    req: BulkExportRequest,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = validate_ids(&req.ids) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if req.kind == GovernedKind::Pipelines {
+        return Ok(MetaHttpResponse::bad_request(
+            "Bulk export is not supported on pipelines",
+        ));
+    }
+    let resolver = FolderAccessResolver::load(org_id, user_id).await;
+    let targets = match load_targets(org_id, req.kind, &req.ids, &resolver, FolderAccess::View)
+        .await
+    {
+        Ok(targets) => targets,
+        Err(errors) => {
+            return Ok(HttpResponse::BadRequest().json(BulkResponse::aborted(&req.ids, &errors, 0)));
+        }
+    };
+    let objects = targets
+        .into_iter()
+        .zip(req.ids.iter())
+        .map(|(target, id)| BulkObject {
+            kind: req.kind,
+            id: id.clone(),
+            data: match target {
+                Target::Alert(alert) => json::to_value(alert).unwrap(),
+                Target::Dashboard { dashboard, .. } => {
+                    json::from_slice(&dashboards::to_json(&dashboard)).unwrap()
+                }
+            },
+        })
+        .collect();
+    Ok(MetaHttpResponse::json(BulkResponse {
+        objects,
+        ..BulkResponse::committed(&req.ids)
+    }))
+}
+
+/// Imports exported objects, all of them or none
+#[tracing::instrument(skip(req))]
+pub async fn import(
+    org_id: &str,
+    user_id: &str,
+    req: BulkImportRequest,
+) -> Result<HttpResponse, Error> {
+    let ids = req
+        .objects
+        .iter()
+        .map(|o| format!("{}/{}", o.kind, o.id))
+        .collect::<Vec<_>>();
+    if let Err(e) = validate_ids(&ids) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    let resolver = FolderAccessResolver::load(org_id, user_id).await;
+    let mut imports = Vec::with_capacity(req.objects.len());
+    let mut errors = Vec::new();
+    for (i, object) in req.objects.into_iter().enumerate() {
+        match check_import(org_id, user_id, object, req.overwrite, &resolver).await {
+            Ok(import) => imports.push(import),
+            Err(e) => errors.push((i, e)),
+        }
+    }
+    if !errors.is_empty() {
+        return Ok(HttpResponse::BadRequest().json(BulkResponse::aborted(&ids, &errors, 0)));
+    }
+    let mut undo = Vec::with_capacity(imports.len());
+    for (i, import) in imports.into_iter().enumerate() {
+        match apply_import(org_id, import).await {
+            Ok(u) => undo.push(u),
+            Err(e) => {
+                rollback(org_id, undo).await;
+                return Ok(
+                    HttpResponse::InternalServerError().json(BulkResponse::aborted(
+                        &ids,
+                        &[(i, e)],
+                        i,
+                    )),
+                );
+            }
+        }
+    }
+    Ok(MetaHttpResponse::json(BulkResponse::committed(&ids)))
+}
+
+enum Target {
+    Alert(Alert),
+    Dashboard {
+        folder: String,
+        dashboard_id: String,
+        dashboard: Dashboard,
+    },
+}
+
+enum Import {
+    Alert {
+        alert: Alert,
+        previous: Option<Alert>,
+    },
+    Dashboard {
+        folder: String,
+        dashboard_id: String,
+        data: Vec<u8>,
+        previous: Option<Dashboard>,
+    },
+}
+
+/// Reverts a change of one object
+enum Undo {
+    Nothing,
+    /// Stores the alert as it was
+    Alert(Alert),
+    /// Deletes the alert created by an import
+    DeleteAlert(Alert),
+    Governance {
+        kind: GovernedKind,
+        object_id: String,
+        previous: Option<Governance>,
+    },
+    /// Moves the dashboard back
+    Move {
+        dashboard_id: String,
+        from: String,
+        to: String,
+    },
+    /// Stores the dashboard as it was, deletes it when it was created
+    Dashboard {
+        folder: String,
+        dashboard_id: String,
+        previous: Option<Dashboard>,
+    },
+}
+
+/// Loads all the objects, or returns the error of each one which is missing
+/// or out of reach of the user
+async fn load_targets(
+    org_id: &str,
+    kind: GovernedKind,
+    ids: &[String],
+    resolver: &FolderAccessResolver,
+    access: FolderAccess,
+) -> Result<Vec<Target>, Vec<(usize, String)>> {
+    let mut targets = Vec::with_capacity(ids.len());
+    let mut errors = Vec::new();
+    for (i, id) in ids.iter().enumerate() {
+        match load_target(org_id, kind, id, resolver, access).await {
+            Ok(target) => targets.push(target),
+            Err(e) => errors.push((i, e)),
+        }
+    }
+    if errors.is_empty() {
+        Ok(targets)
+    } else {
+        Err(errors)
+    }
+}
+
+async fn load_target(
+    org_id: &str,
+    kind: GovernedKind,
+    id: &str,
+    resolver: &FolderAccessResolver,
+    access: FolderAccess,
+) -> Result<Target, String> {
+    match kind {
+        GovernedKind::Alerts => {
+            let (stream_type, stream_name, name) =
+                split_stream_object_id(id).ok_or("Invalid alert id")?;
+            match alerts::get(org_id, stream_type, stream_name, name).await {
+                Ok(Some(alert)) => Ok(Target::Alert(alert)),
+                _ => Err("Alert not found".to_string()),
+            }
+        }
+        GovernedKind::Dashboards => {
+            let (folder, dashboard_id) = id.split_once('/').ok_or("Invalid dashboard id")?;
+            if !resolver.allows(folder, access) {
+                return Err(format!("No {access} permission on the folder {folder}"));
+            }
+            match db::dashboards::get(org_id, dashboard_id, folder).await {
+                Ok(dashboard) => Ok(Target::Dashboard {
+                    folder: folder.to_string(),
+                    dashboard_id: dashboard_id.to_string(),
+                    dashboard,
+                }),
+                Err(_) => Err("Dashboard not found".to_string()),
+            }
+        }
+        GovernedKind::Pipelines => Err(format!("{kind} are not supported")),
+    }
+}
+
+async fn apply_one(
+    org_id: &str,
+    user_id: &str,
+    req: &BulkRequest,
+    id: &str,
+    target: Target,
+) -> Result<Undo, String> {
+    match (req.action, target) {
+        (BulkAction::Enable | BulkAction::Disable, Target::Alert(alert)) => {
+            let enabled = req.action == BulkAction::Enable;
+            if alert.enabled == enabled {
+                return Ok(Undo::Nothing);
+            }
+            let mut changed = alert.clone();
+            changed.enabled = enabled;
+            db::alerts::set(
+                org_id,
+                changed.stream_type,
+                &changed.stream_name,
+                &changed,
+                false,
+            )
+            .await
+            .map_err(|e| e.to_string())?;
+            Ok(Undo::Alert(alert))
+        }
+        (BulkAction::Tag, _) => {
+            let previous = db::governance::get(org_id, req.kind, id).await.ok();
+            let mut governance = previous.clone().unwrap_or_else(|| Governance {
+                kind: req.kind,
+                object_id: id.to_string(),
+                owner: "".to_string(),
+                tags: vec![],
+                state: Default::default(),
+                updated_by: "".to_string(),
+                updated_at: 0,
+            });
+            governance.tags = req.tags.clone();
+            governance.updated_by = user_id.to_string();
+            governance.updated_at = Utc::now().timestamp_micros();
+            db::governance::set(org_id, &governance)
+                .await
+                .map_err(|e| e.to_string())?;
+            Ok(Undo::Governance {
+                kind: req.kind,
+                object_id: id.to_string(),
+                previous,
+            })
+        }
+        (
+            BulkAction::Move,
+            Target::Dashboard {
+                folder,
+                dashboard_id,
+                ..
+            },
+        ) => {
+            if folder == req.folder {
+                return Ok(Undo::Nothing);
+            }
+            dashboards::move_to_folder(org_id, &dashboard_id, &folder, &req.folder)
+                .await
+                .map_err(|(_, e)| e.to_string())?;
+            Ok(Undo::Move {
+                dashboard_id,
+                from: req.folder.clone(),
+                to: folder,
+            })
+        }
+        _ => Err("Action not supported on this object".to_string()),
+    }
+}
+
+async fn check_import(
+    org_id: &str,
+    user_id: &str,
+    object: BulkObject,
+    overwrite: bool,
+    resolver: &FolderAccessResolver,
+) -> Result<Import, String> {
+    match object.kind {
+        GovernedKind::Alerts => {
+            let alert: Alert = json::from_value(object.data).map_err(|e| e.to_string())?;
+            if alert_key(&alert) != object.id {
+                return Err(format!(
+                    "The id should be {} for this alert",
+                    alert_key(&alert)
+                ));
+            }
+            let previous = alerts::get(org_id, alert.stream_type, &alert.stream_name, &alert.name)
+                .await
+                .map_err(|e| e.to_string())?;
+            if previous.is_some() && !overwrite {
+                return Err("Alert already exists".to_string());
+            }
+            field_access::check_alert(org_id, alert.stream_type, &alert.stream_name, user_id)
+                .await
+                .map_err(|e| e.to_string())?;
+            Ok(Import::Alert { alert, previous })
+        }
+        GovernedKind::Dashboards => {
+            let (folder, dashboard_id) = object.id.split_once('/').ok_or("Invalid dashboard id")?;
+            if db::dashboards::folders::get(org_id, folder).await.is_err() {
+                return Err(format!("Folder {folder} not found"));
+            }
+            if !resolver.allows(folder, FolderAccess::Edit) {
+                return Err(format!("No edit permission on the folder {folder}"));
+            }
+            json::from_value::<DashboardVersion>(object.data.clone()).map_err(|e| e.to_string())?;
+            let previous = db::dashboards::get(org_id, dashboard_id, folder).await.ok();
+            if previous.is_some() && !overwrite {
+                return Err("Dashboard already exists".to_string());
+            }
+            Ok(Import::Dashboard {
+                folder: folder.to_string(),
+                dashboard_id: dashboard_id.to_string(),
+                data: json::to_vec(&object.data).unwrap(),
+                previous,
+            })
+        }
+        GovernedKind::Pipelines => Err(format!("{} are not supported", object.kind)),
+    }
+}
+
+async fn apply_import(org_id: &str, import: Import) -> Result<Undo, String> {
+    match import {
+        Import::Alert { alert, previous } => {
+            let stream_name = alert.stream_name.clone();
+            alerts::save(org_id, &stream_name, "", alert.clone(), previous.is_none())
+                .await
+                .map_err(|e| e.to_string())?;
+            Ok(match previous {
+                Some(previous) => Undo::Alert(previous),
+                None => Undo::DeleteAlert(alert),
+            })
+        }
+        Import::Dashboard {
+            folder,
+            dashboard_id,
+            data,
+            previous,
+        } => {
+            db::dashboards::put(org_id, &dashboard_id, &folder, web::Bytes::from(data))
+                .await
+                .map_err(|e| e.to_string())?;
+            if previous.is_none() {
+                set_ownership(
+                    org_id,
+                    "dashboards",
+                    dashboard_authz(&dashboard_id, &folder),
+                )
+                .await;
+            }
+            Ok(Undo::Dashboard {
+                folder,
+                dashboard_id,
+                previous,
+            })
+        }
+    }
+}
+
+/// Reverts the changes, the last one first
+async fn rollback(org_id: &str, undo: Vec<Undo>) {
+    for u in undo.into_iter().rev() {
+        if let Err(e) = revert(org_id, u).await {
+            log::error!("[BULK] rollback of a change of org {org_id} failed: {e}");
+        }
+    }
+}
+
+async fn revert(org_id: &str, undo: Undo) -> Result<(), anyhow::Error> {
+    match undo {
+        Undo::Nothing => Ok(()),
+        Undo::Alert(alert) => {
+            db::alerts::set(org_id, alert.stream_type, &alert.stream_name, &alert, false).await
+        }
+        Undo::DeleteAlert(alert) => {
+            alerts::delete(org_id, alert.stream_type, &alert.stream_name, &alert.name)
+                .await
+                .map_err(|(_, e)| e)
+        }
+        Undo::Governance {
+            kind,
+            object_id,
+            previous,
+        } => match previous {
+            Some(governance) => db::governance::set(org_id, &governance).await,
+            None => db::governance::delete(org_id, kind, &object_id).await,
+        },
+        Undo::Move {
+            dashboard_id,
+            from,
+            to,
+        } => dashboards::move_to_folder(org_id, &dashboard_id, &from, &to)
+            .await
+            .map_err(|(_, e)| e),
+        Undo::Dashboard {
+            folder,
+            dashboard_id,
+            previous,
+        } => match previous {
+            Some(dashboard) => db::dashboards::put(
+                org_id,
+                &dashboard_id,
+                &folder,
+                dashboards::to_json(&dashboard).into(),
+            )
+            .await
+            .map(|_| ()),
+            None => {
+                db::dashboards::delete(org_id, &dashboard_id, &folder).await?;
+                remove_ownership(
+                    org_id,
+                    "dashboards",
+                    dashboard_authz(&dashboard_id, &folder),
+                )
+                .await;
+                Ok(())
+            }
+        },
+    }
+}
+
+fn dashboard_authz(dashboard_id: &str, folder: &str) -> Authz {
+    Authz {
+        obj_id: dashboard_id.to_string(),
+        parent_type: "folders".to_string(),
+        parent: folder.to_string(),
+    }
+}
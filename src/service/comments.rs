@@ -0,0 +1,165 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Comments and annotations of the saved queries and the dashboards. Everyone
+//! who can see the query or the dashboard can read and add comments, only
+//! their author and the admins can change or delete them.
+
+use std::io::Error;
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+
+use crate::{
+    common::meta::{
+        comments::{Comment, CommentList, CommentRequest, CommentTarget},
+        dashboards::FolderAccess,
+        http::HttpResponse as MetaHttpResponse,
+    },
+    service::{dashboards::folders::FolderAccessResolver, db, saved_query, users},
+};
+
+#[tracing::instrument(skip(req))]
+pub async fn create_comment(
+    org_id: &str,
+    user_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    folder: &str,
+    req: CommentRequest,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = req.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if let Err(e) = check_target(org_id, user_id, target, target_id, folder).await {
+        return Ok(MetaHttpResponse::not_found(e));
+    }
+    let comment = Comment {
+        id: config::ider::uuid(),
+        target,
+        target_id: target_id.to_string(),
+        text: req.text,
+        panel_id: req.panel_id,
+        timestamp: req.timestamp,
+        author: user_id.to_string(),
+        created_at: Utc::now().timestamp_micros(),
+        updated_at: 0,
+    };
+    match db::comments::set(org_id, &comment).await {
+        Ok(_) => Ok(MetaHttpResponse::json(comment)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_comments(
+    org_id: &str,
+    user_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    folder: &str,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = check_target(org_id, user_id, target, target_id, folder).await {
+        return Ok(MetaHttpResponse::not_found(e));
+    }
+    match db::comments::list(org_id, target, target_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(CommentList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument(skip(req))]
+pub async fn update_comment(
+    org_id: &str,
+    user_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    id: &str,
+    req: CommentRequest,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = req.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    let Ok(mut comment) = db::comments::get(org_id, target, target_id, id).await else {
+        return Ok(MetaHttpResponse::not_found("Comment not found"));
+    };
+    if comment.author != user_id && !users::is_admin(org_id, user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the author or an admin can change the comment",
+        ));
+    }
+    comment.text = req.text;
+    comment.panel_id = req.panel_id;
+    comment.timestamp = req.timestamp;
+    comment.updated_at = Utc::now().timestamp_micros();
+    match db::comments::set(org_id, &comment).await {
+        Ok(_) => Ok(MetaHttpResponse::json(comment)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_comment(
+    org_id: &str,
+    user_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    id: &str,
+) -> Result<HttpResponse, Error> {
+    let Ok(comment) = db::comments::get(org_id, target, target_id, id).await else {
+        return Ok(MetaHttpResponse::not_found("Comment not found"));
+    };
+    if comment.author != user_id && !users::is_admin(org_id, user_id).await {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the author or an admin can delete the comment",
+        ));
+    }
+    match db::comments::delete(org_id, target, target_id, id).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Comment deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Checks the saved query or the dashboard exists and the user can see it,
+/// including through the permissions of its folder
+async fn check_target(
+    org_id: &str,
+    user_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    folder: &str,
+) -> Result<(), String> {
+    let resolver = FolderAccessResolver::load(org_id, user_id).await;
+    let found = match target {
+        CommentTarget::SavedQueries => {
+            db::saved_query::get(org_id, target_id)
+                .await
+                .is_ok_and(|query| {
+                    saved_query::can_view(user_id, &query)
+                        && (query.folder_id.is_empty()
+                            || resolver.allows(&query.folder_id, FolderAccess::View))
+                })
+        }
+        CommentTarget::Dashboards => {
+            resolver.allows(folder, FolderAccess::View)
+                && db::dashboards::get(org_id, target_id, folder).await.is_ok()
+        }
+    };
+    if found {
+        Ok(())
+    } else {
+        Err(format!("{target} {target_id} not found"))
+    }
+}
@@ -186,6 +186,7 @@ pub async fn generate_file(file: &FileKey) -> Result<(), anyhow::Error> {
         .unwrap_or_default();
     let bloom_filter_fields = stream_setting.bloom_filter_fields;
     let full_text_search_fields = stream_setting.full_text_search_keys;
+    let parquet_settings = stream_setting.parquet;
     let new_file = format!(
         "files{}/{}",
         get_config().common.column_all,
@@ -198,6 +199,7 @@ pub async fn generate_file(file: &FileKey) -> Result<(), anyhow::Error> {
         &bloom_filter_fields,
         &full_text_search_fields,
         &file.meta,
+        parquet_settings.as_ref(),
     )
     .await
     .map_err(|e| anyhow::anyhow!("write_recordbatch_to_parquet error: {}", e))?;
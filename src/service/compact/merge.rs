@@ -38,7 +38,8 @@ use infra::{
     cache, dist_lock, file_list as infra_file_list,
     schema::{
         get_stream_setting_bloom_filter_fields, get_stream_setting_fts_fields,
-        unwrap_partition_time_level, unwrap_stream_settings, SchemaCache,
+        get_stream_setting_parquet, unwrap_partition_time_level, unwrap_stream_settings,
+        SchemaCache,
     },
     storage,
 };
@@ -48,10 +49,16 @@ use tokio::{
 };
 
 use crate::{
-    common::infra::cluster::get_node_by_uuid,
+    common::{
+        infra::cluster::get_node_by_uuid,
+        meta::prom::{HASH_LABEL, VALUE_LABEL},
+    },
     job::files::parquet::generate_index_on_compactor,
     service::{
-        db, file_list, schema::generate_schema_for_defined_schema_fields, search::datafusion,
+        compact::upsert::{self, LatestVersions},
+        db, file_list,
+        schema::generate_schema_for_defined_schema_fields,
+        search::datafusion,
         stream,
     },
 };
@@ -541,9 +548,7 @@ pub async fn merge_files(
 
     let mut new_file_size = 0;
     let mut new_compressed_file_size = 0;
-    let mut total_records = 0;
     let mut new_file_list = Vec::new();
-    let mut deleted_files = Vec::new();
     let cfg = get_config();
     for file in files_with_size.iter() {
         if new_file_size + file.meta.original_size > cfg.compact.max_file_size as i64
@@ -554,7 +559,6 @@ pub async fn merge_files(
         }
         new_file_size += file.meta.original_size;
         new_compressed_file_size += file.meta.compressed_size;
-        total_records += file.meta.records;
         new_file_list.push(file.clone());
         // metrics
         metrics::COMPACT_MERGED_FILES
@@ -569,6 +573,38 @@ pub async fn merge_files(
         return Ok((String::from(""), FileMeta::default(), Vec::new()));
     }
 
+    rewrite_files(
+        thread_id,
+        org_id,
+        stream_type,
+        stream_name,
+        prefix,
+        new_file_list,
+        2,
+        None,
+    )
+    .await
+}
+
+/// write the files into one file sorted by the clustering keys of the stream,
+/// upload to storage, returns the new file key and the rewritten files. nothing
+/// is written when less than `min_files` files can be read. the rows of upsert
+/// streams superseded in the files, or by `latest_versions` when given, are dropped
+#[allow(clippy::too_many_arguments)]
+pub(crate) async fn rewrite_files(
+    thread_id: usize,
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    prefix: &str,
+    mut new_file_list: Vec<FileKey>,
+    min_files: usize,
+    latest_versions: Option<&LatestVersions>,
+) -> Result<(String, FileMeta, Vec<FileKey>), anyhow::Error> {
+    let cfg = get_config();
+    let new_file_size = new_file_list.iter().map(|f| f.meta.original_size).sum();
+    let total_records = new_file_list.iter().map(|f| f.meta.records).sum();
+    let mut deleted_files = Vec::new();
     let retain_file_list = new_file_list.clone();
 
     // write parquet files into tmpfs
@@ -604,7 +640,7 @@ pub async fn merge_files(
     if !deleted_files.is_empty() {
         new_file_list.retain(|f| !deleted_files.contains(&f.key));
     }
-    if new_file_list.len() <= 1 {
+    if new_file_list.len() < min_files {
         return Ok((String::from(""), FileMeta::default(), retain_file_list));
     }
 
@@ -614,10 +650,10 @@ pub async fn merge_files(
 
     // convert the file to the latest version of schema
     let schema_latest = infra::schema::get(org_id, stream_name, stream_type).await?;
-    let stream_setting = infra::schema::get_settings(org_id, stream_name, stream_type).await;
-    let defined_schema_fields = stream_setting
-        .and_then(|s| s.defined_schema_fields)
+    let stream_setting = infra::schema::get_settings(org_id, stream_name, stream_type)
+        .await
         .unwrap_or_default();
+    let defined_schema_fields = stream_setting.defined_schema_fields.unwrap_or_default();
     let schema_latest = if !defined_schema_fields.is_empty() {
         let schema_latest = SchemaCache::new(schema_latest);
         let schema_latest =
@@ -633,6 +669,25 @@ pub async fn merge_files(
     let schema_latest_id = schema_versions.len() - 1;
     let bloom_filter_fields = get_stream_setting_bloom_filter_fields(&schema_latest);
     let full_text_search_fields = get_stream_setting_fts_fields(&schema_latest);
+    let mut parquet_settings = get_stream_setting_parquet(&schema_latest);
+    let mut clustering_keys = stream_setting.clustering_keys.clone();
+    // the old samples of the metrics are stored series by series, so the
+    // timestamps of a series encode as small deltas of deltas and its values,
+    // split by byte, compress several times better than the mixed series
+    if is_series_encoded(stream_type, max_ts) {
+        clustering_keys.retain(|key| key != HASH_LABEL);
+        clustering_keys.insert(0, HASH_LABEL.to_string());
+        let settings = parquet_settings.get_or_insert_with(Default::default);
+        if !settings
+            .byte_stream_split_fields
+            .iter()
+            .any(|field| field == VALUE_LABEL)
+        {
+            settings
+                .byte_stream_split_fields
+                .push(VALUE_LABEL.to_string());
+        }
+    }
     if cfg.common.widening_schema_evolution && schema_versions.len() > 1 {
         for file in new_file_list.iter() {
             // get the schema version of the file
@@ -723,13 +778,20 @@ pub async fn merge_files(
 
     let start = std::time::Instant::now();
     let merge_result = if stream_type == StreamType::Logs {
-        merge_parquet_files(thread_id, tmp_dir.name(), schema_latest.clone()).await
+        merge_parquet_files(
+            thread_id,
+            tmp_dir.name(),
+            schema_latest.clone(),
+            &clustering_keys,
+        )
+        .await
     } else {
         datafusion::exec::merge_parquet_files(
             tmp_dir.name(),
             stream_type,
             stream_name,
             schema_latest.clone(),
+            &clustering_keys,
         )
         .await
     };
@@ -745,12 +807,46 @@ pub async fn merge_files(
         DataFusionError::Plan(format!("merge_parquet_files err: {:?}", e))
     })?;
 
+    // drop the rows superseded by newer versions of the upsert streams
+    let new_batches = match stream_setting.primary_key.as_deref() {
+        Some(primary_key) => {
+            let mut local_versions = LatestVersions::new();
+            let latest_versions = match latest_versions {
+                Some(v) => v,
+                None => {
+                    upsert::collect_latest_versions(
+                        &new_batches,
+                        primary_key,
+                        &mut local_versions,
+                    )?;
+                    &local_versions
+                }
+            };
+            let records = new_batches.iter().map(|b| b.num_rows()).sum::<usize>() as i64;
+            let new_batches =
+                upsert::drop_superseded_rows(new_batches, primary_key, latest_versions)?;
+            let kept = new_batches.iter().map(|b| b.num_rows()).sum::<usize>() as i64;
+            if kept == 0 {
+                return Err(anyhow::anyhow!(
+                    "merge_parquet_files error: all the records are superseded"
+                ));
+            }
+            if kept < records {
+                new_file_meta.original_size = new_file_meta.original_size * kept / records;
+                new_file_meta.records -= records - kept;
+            }
+            new_batches
+        }
+        None => new_batches,
+    };
+
     let buf = write_recordbatch_to_parquet(
         new_schema.clone(),
         &new_batches,
         &bloom_filter_fields,
         &full_text_search_fields,
         &new_file_meta,
+        parquet_settings.as_ref(),
     )
     .await?;
     new_file_meta.compressed_size = buf.len() as i64;
@@ -830,7 +926,7 @@ pub async fn merge_files(
     }
 }
 
-async fn write_file_list(org_id: &str, events: &[FileKey]) -> Result<(), anyhow::Error> {
+pub(crate) async fn write_file_list(org_id: &str, events: &[FileKey]) -> Result<(), anyhow::Error> {
     if events.is_empty() {
         return Ok(());
     }
@@ -1066,10 +1162,24 @@ pub fn generate_inverted_idx_recordbatch(
     inverted_idx_batches
 }
 
+/// checks the files of a metrics stream ending at `max_ts` are old enough to be
+/// stored series by series
+fn is_series_encoded(stream_type: StreamType, max_ts: i64) -> bool {
+    let days = get_config().compact.metrics_series_encoding_after;
+    if stream_type != StreamType::Metrics || days <= 0 {
+        return false;
+    }
+    let threshold = Utc::now() - Duration::try_days(days).unwrap();
+    max_ts < threshold.timestamp_micros()
+}
+
+/// merge the files of the tmpfs into one RecordBatch, sorted by the clustering
+/// keys and then by the timestamp in desc order
 pub async fn merge_parquet_files(
     thread_id: usize,
     trace_id: &str,
     mut schema: Arc<Schema>,
+    clustering_keys: &[String],
 ) -> ::datafusion::error::Result<(Arc<Schema>, Vec<RecordBatch>)> {
     let start = std::time::Instant::now();
 
@@ -1132,9 +1242,21 @@ pub async fn merge_parquet_files(
         schema = concated_record_batch.schema().clone();
     }
 
-    // 4. sort concatenated record batch by timestamp col in desc order
-    let sort_indices = arrow::compute::sort_to_indices(
-        concated_record_batch
+    // 4. sort concatenated record batch by clustering keys in asc order and timestamp col in desc
+    //    order, the keys dropped as null columns don't change the order
+    let mut sort_columns = clustering_keys
+        .iter()
+        .filter_map(|key| concated_record_batch.column_by_name(key))
+        .map(|column| arrow::compute::SortColumn {
+            values: column.clone(),
+            options: Some(arrow_schema::SortOptions {
+                descending: false,
+                nulls_first: false,
+            }),
+        })
+        .collect::<Vec<_>>();
+    sort_columns.push(arrow::compute::SortColumn {
+        values: concated_record_batch
             .column_by_name(&get_config().common.column_timestamp)
             .ok_or_else(|| {
                 log::error!(
@@ -1143,13 +1265,15 @@ pub async fn merge_parquet_files(
                 DataFusionError::Execution(
                     "No _timestamp column found in merged record batch".to_string(),
                 )
-            })?,
-        Some(arrow_schema::SortOptions {
+            })?
+            .clone(),
+        options: Some(arrow_schema::SortOptions {
             descending: true,
             nulls_first: false,
         }),
-        None,
-    )?;
+    });
+    let sort_indices = arrow::compute::lexsort_to_indices(&sort_columns, None)?;
+    drop(sort_columns);
 
     let batch_columns_len = concated_record_batch.columns().len();
     let mut sorted_columns = Vec::with_capacity(batch_columns_len);
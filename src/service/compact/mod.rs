@@ -37,8 +37,11 @@ mod file_list;
 pub mod file_list_deleted;
 pub mod flatten;
 pub mod merge;
+pub mod recluster;
 pub mod retention;
+pub mod series;
 pub mod stats;
+pub mod upsert;
 
 /// compactor retention run steps:
 pub async fn run_retention() -> Result<(), anyhow::Error> {
@@ -94,7 +97,12 @@ pub async fn run_retention() -> Result<(), anyhow::Error> {
         }
     }
 
-    // delete files
+    Ok(())
+}
+
+/// Delete the data of the deleted streams, the expired days and the ones
+/// requested by the delete and truncate APIs
+pub async fn run_delete() -> Result<(), anyhow::Error> {
     let jobs = db::compact::retention::list().await?;
     for job in jobs {
         let columns = job.split('/').collect::<Vec<&str>>();
@@ -435,3 +443,32 @@ pub async fn run_delay_deletion() -> Result<(), anyhow::Error> {
 
     Ok(())
 }
+
+/// Returns the time before which the partitions of the stream are not merged
+/// anymore, so other jobs can rewrite their files without racing the merging.
+/// The merging works on the offset partition and the lookback hours before it
+pub(crate) async fn get_merged_before(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    partition_time_level: PartitionTimeLevel,
+) -> i64 {
+    let (offset, _) = db::compact::files::get_offset(org_id, stream_type, stream_name).await;
+    let step = get_partition_step(partition_time_level);
+    let lookback = Duration::try_hours(get_config().compact.lookback_hours)
+        .unwrap()
+        .num_microseconds()
+        .unwrap();
+    offset - offset % step - lookback
+}
+
+/// Returns the time span of a partition in microseconds
+pub(crate) fn get_partition_step(partition_time_level: PartitionTimeLevel) -> i64 {
+    if partition_time_level == PartitionTimeLevel::Daily {
+        Duration::try_hours(24).unwrap()
+    } else {
+        Duration::try_hours(1).unwrap()
+    }
+    .num_microseconds()
+    .unwrap()
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use config::{
+    cluster::LOCAL_NODE_UUID,
+    get_config,
+    meta::{
+        cluster::Role,
+        stream::{FileKey, PartitionTimeLevel},
+    },
+};
+use infra::schema::{unwrap_partition_time_level, unwrap_stream_settings};
+
+use crate::{
+    common::{infra::cluster::get_node_from_consistent_hash, meta::stream::ReclusterJob},
+    service::{compact::merge, db, file_list},
+};
+
+/// Processes the recluster jobs of the streams this node compacts
+pub async fn run() -> Result<(), anyhow::Error> {
+    let jobs = db::compact::recluster::list().await?;
+    for job in jobs {
+        let Some(node) = get_node_from_consistent_hash(&job.stream_name, &Role::Compactor).await
+        else {
+            continue; // no compactor node
+        };
+        if LOCAL_NODE_UUID.ne(&node) {
+            continue; // not this node
+        }
+        if let Err(e) = recluster_by_stream(job.clone()).await {
+            log::error!(
+                "[COMPACTOR] recluster [{}/{}/{}] error: {}",
+                job.org_id,
+                job.stream_type,
+                job.stream_name,
+                e
+            );
+        }
+    }
+    Ok(())
+}
+
+/// Rewrites the partitions of the job one by one, saving the progress after
+/// each of them. Partitions the merging may still be working on are left for
+/// the next run.
+async fn recluster_by_stream(mut job: ReclusterJob) -> Result<(), anyhow::Error> {
+    let schema = infra::schema::get(&job.org_id, &job.stream_name, job.stream_type).await?;
+    if schema.fields().is_empty() {
+        // the stream was deleted
+        return db::compact::recluster::delete(&job.org_id, job.stream_type, &job.stream_name)
+            .await;
+    }
+    let stream_settings = unwrap_stream_settings(&schema).unwrap_or_default();
+    let partition_time_level =
+        unwrap_partition_time_level(stream_settings.partition_time_level, job.stream_type);
+    let step = super::get_partition_step(partition_time_level);
+    let merged_before = super::get_merged_before(
+        &job.org_id,
+        job.stream_type,
+        &job.stream_name,
+        partition_time_level,
+    )
+    .await;
+
+    let mut partition_start = job.offset - job.offset % step;
+    while partition_start < job.end_time {
+        if partition_start + step > merged_before {
+            return Ok(());
+        }
+        recluster_partition(
+            &job,
+            partition_time_level,
+            partition_start,
+            partition_start + step - 1,
+        )
+        .await?;
+        partition_start += step;
+        job.offset = partition_start;
+        db::compact::recluster::set(&job).await?;
+    }
+
+    db::compact::recluster::delete(&job.org_id, job.stream_type, &job.stream_name).await?;
+    log::info!(
+        "[COMPACTOR] recluster [{}/{}/{}] done, time range: [{},{}]",
+        job.org_id,
+        job.stream_type,
+        job.stream_name,
+        job.start_time,
+        job.end_time
+    );
+    Ok(())
+}
+
+async fn recluster_partition(
+    job: &ReclusterJob,
+    partition_time_level: PartitionTimeLevel,
+    time_min: i64,
+    time_max: i64,
+) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let files = file_list::query(
+        &job.org_id,
+        &job.stream_name,
+        job.stream_type,
+        partition_time_level,
+        time_min,
+        time_max,
+        true,
+    )
+    .await?;
+
+    let mut partition_files: HashMap<String, Vec<FileKey>> = HashMap::new();
+    for file in files {
+        let prefix = file.key[..file.key.rfind('/').unwrap()].to_string();
+        partition_files.entry(prefix).or_default().push(file);
+    }
+
+    for (prefix, mut files) in partition_files {
+        files.sort_by(|a, b| a.key.cmp(&b.key));
+        files.dedup_by(|a, b| a.key == b.key);
+
+        // group files up to the max file size, a big file is rewritten alone
+        let mut batches = Vec::new();
+        let mut batch = Vec::new();
+        let mut batch_size = 0;
+        for file in files {
+            if !batch.is_empty()
+                && batch_size + file.meta.original_size > cfg.compact.max_file_size as i64
+            {
+                batches.push(std::mem::take(&mut batch));
+                batch_size = 0;
+            }
+            batch_size += file.meta.original_size;
+            batch.push(file);
+        }
+        if !batch.is_empty() {
+            batches.push(batch);
+        }
+
+        for batch in batches {
+            let (new_file_key, new_file_meta, old_files) = merge::rewrite_files(
+                0,
+                &job.org_id,
+                job.stream_type,
+                &job.stream_name,
+                &prefix,
+                batch,
+                1,
+                None,
+            )
+            .await?;
+            if new_file_key.is_empty() {
+                continue;
+            }
+
+            // replace the old files with the new file, use transaction
+            let mut events = Vec::with_capacity(old_files.len() + 1);
+            events.push(FileKey {
+                key: new_file_key,
+                meta: new_file_meta,
+                deleted: false,
+            });
+            for file in old_files {
+                events.push(FileKey {
+                    deleted: true,
+                    ..file
+                });
+            }
+            events.sort_by(|a, b| a.key.cmp(&b.key));
+            merge::write_file_list(&job.org_id, &events).await?;
+        }
+    }
+
+    Ok(())
+}
@@ -210,7 +210,8 @@ pub async fn delete_by_date(
 
     let cfg = get_config();
     if is_local_disk_storage() {
-        while date_start <= date_end {
+        // the end day is not deleted, same as the file list
+        while date_start < date_end {
             let data_dir = format!(
                 "{}files/{org_id}/{stream_type}/{stream_name}/{}",
                 cfg.common.data_stream_dir,
@@ -247,6 +248,11 @@ pub async fn delete_by_date(
     // delete from file list
     delete_from_file_list(org_id, stream_type, stream_name, time_range).await?;
 
+    // the deleted days are not the oldest ones of the stream when deleting a
+    // range requested by the user, the data before them is kept
+    let mut stats = cache::stats::get_stream_stats(org_id, stream_name, stream_type);
+    let keep_older = stats.doc_time_min > 0 && time_range.0 > stats.doc_time_min;
+
     // archive old schema versions
     let mut schema_versions =
         infra::schema::get_versions(org_id, stream_name, stream_type, Some(time_range)).await?;
@@ -257,7 +263,7 @@ pub async fn delete_by_date(
             Some(v) => v.parse().unwrap_or_default(),
             None => 0,
         };
-        if start_dt == 0 {
+        if start_dt == 0 || (keep_older && start_dt < time_range.0) {
             continue;
         }
         infra::schema::history::create(org_id, stream_type, stream_name, start_dt, schema).await?;
@@ -265,8 +271,9 @@ pub async fn delete_by_date(
     }
 
     // update stream stats retention time
-    let mut stats = cache::stats::get_stream_stats(org_id, stream_name, stream_type);
-    let mut min_ts = if time_range.1 > BASE_TIME.timestamp_micros() {
+    let mut min_ts = if keep_older {
+        stats.doc_time_min
+    } else if time_range.1 > BASE_TIME.timestamp_micros() {
         time_range.1
     } else {
         infra_file_list::get_min_ts(org_id, stream_type, stream_name)
@@ -0,0 +1,121 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Metrics get compacted within hours of their ingestion, series mixed. Once
+//! they are older than `ZO_COMPACT_METRICS_SERIES_ENCODING_AFTER` days their
+//! files are rewritten once more, by a recluster job, series by series: the
+//! timestamps of a series then encode as small deltas of deltas and its
+//! values, split by byte, compress several times better. The files stay plain
+//! parquet so they are queried as before.
+
+use chrono::Duration;
+use config::{
+    cluster::LOCAL_NODE_UUID,
+    get_config,
+    meta::{cluster::Role, stream::StreamType},
+    utils::time::now_micros,
+};
+use infra::cache::stats;
+
+use crate::{
+    common::{infra::cluster::get_node_from_consistent_hash, meta::stream::ReclusterJob},
+    service::db,
+};
+
+/// Schedules the rewriting of the metrics which became old enough since the
+/// last run, for the streams this node compacts
+pub async fn run() -> Result<(), anyhow::Error> {
+    let days = get_config().compact.metrics_series_encoding_after;
+    if days <= 0 {
+        return Ok(());
+    }
+    let end_time = series_end_time(now_micros(), days);
+    for org_id in db::schema::list_organizations_from_cache().await {
+        let streams = db::schema::list_streams_from_cache(&org_id, StreamType::Metrics).await;
+        for stream_name in streams {
+            let Some(node) = get_node_from_consistent_hash(&stream_name, &Role::Compactor).await
+            else {
+                continue; // no compactor node
+            };
+            if LOCAL_NODE_UUID.ne(&node) {
+                continue; // not this node
+            }
+            if let Err(e) = schedule(&org_id, &stream_name, end_time).await {
+                log::error!(
+                    "[COMPACTOR] schedule series encoding [{org_id}/metrics/{stream_name}] error: {e}"
+                );
+            }
+        }
+    }
+    Ok(())
+}
+
+async fn schedule(org_id: &str, stream_name: &str, end_time: i64) -> Result<(), anyhow::Error> {
+    let stream_type = StreamType::Metrics;
+    let offset = db::compact::series::get_offset(org_id, stream_type, stream_name).await;
+    if offset >= end_time {
+        return Ok(());
+    }
+    // a stream has at most one recluster job, wait for the running one
+    if db::compact::recluster::get(org_id, stream_type, stream_name)
+        .await
+        .is_ok()
+    {
+        return Ok(());
+    }
+    let start_time = if offset > 0 {
+        offset
+    } else {
+        stats::get_stream_stats(org_id, stream_name, stream_type).doc_time_min
+    };
+    if start_time > 0 && start_time < end_time {
+        let job = ReclusterJob {
+            org_id: org_id.to_string(),
+            stream_type,
+            stream_name: stream_name.to_string(),
+            start_time,
+            end_time,
+            offset: start_time,
+            created_at: now_micros(),
+        };
+        db::compact::recluster::set(&job).await?;
+        log::info!(
+            "[COMPACTOR] scheduled series encoding [{org_id}/metrics/{stream_name}] up to {end_time}"
+        );
+    }
+    db::compact::series::set_offset(org_id, stream_type, stream_name, end_time).await
+}
+
+/// Returns the start of the day the metrics older than `days` end at, so only
+/// whole days get rewritten
+fn series_end_time(now: i64, days: i64) -> i64 {
+    let day = Duration::try_days(1).unwrap().num_microseconds().unwrap();
+    let end_time = now - days * day;
+    end_time - end_time.rem_euclid(day)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_series_end_time() {
+        let day = 86_400_000_000;
+        // 2024-01-10T12:00:00Z
+        let now = 1_704_888_000_000_000;
+        assert_eq!(series_end_time(now, 7), 1_704_844_800_000_000 - 7 * day);
+        assert_eq!(series_end_time(now, 1) % day, 0);
+    }
+}
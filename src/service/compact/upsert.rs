@@ -0,0 +1,423 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use arrow::{
+    array::{Array, BooleanArray, Int64Array, RecordBatch, StringArray},
+    compute,
+    error::ArrowError,
+};
+use arrow_schema::DataType;
+use config::{
+    cluster::LOCAL_NODE_UUID,
+    get_config,
+    meta::{
+        cluster::Role,
+        stream::{FileKey, PartitionTimeLevel, StreamType, ALL_STREAM_TYPES},
+    },
+    utils::{parquet::read_recordbatch_from_bytes, time::now_micros},
+};
+use infra::{
+    schema::{get_settings, unwrap_partition_time_level},
+    storage,
+};
+
+use crate::{
+    common::infra::cluster::get_node_from_consistent_hash,
+    service::{compact::merge, db, file_list},
+};
+
+/// Latest `_timestamp` of each primary key of an upsert stream
+pub type LatestVersions = HashMap<String, i64>;
+
+/// Removes the rows superseded by newer versions from the files of the upsert
+/// streams this node compacts. The merging only drops the versions meeting in
+/// one merged file, this catches the versions spread over the partitions.
+pub async fn run() -> Result<(), anyhow::Error> {
+    let orgs = db::schema::list_organizations_from_cache().await;
+    for org_id in orgs {
+        for stream_type in ALL_STREAM_TYPES {
+            let streams = db::schema::list_streams_from_cache(&org_id, stream_type).await;
+            for stream_name in streams {
+                let Some(settings) = get_settings(&org_id, &stream_name, stream_type).await else {
+                    continue;
+                };
+                let Some(primary_key) = settings.primary_key else {
+                    continue; // not an upsert stream
+                };
+                let Some(node) =
+                    get_node_from_consistent_hash(&stream_name, &Role::Compactor).await
+                else {
+                    continue; // no compactor node
+                };
+                if LOCAL_NODE_UUID.ne(&node) {
+                    continue; // not this node
+                }
+                let partition_time_level =
+                    unwrap_partition_time_level(settings.partition_time_level, stream_type);
+                if let Err(e) = compact_by_stream(
+                    &org_id,
+                    stream_type,
+                    &stream_name,
+                    partition_time_level,
+                    &primary_key,
+                )
+                .await
+                {
+                    log::error!(
+                        "[COMPACTOR] upsert [{}/{}/{}] error: {}",
+                        org_id,
+                        stream_type,
+                        stream_name,
+                        e
+                    );
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+/// 1. find the latest version of each key over all the files, and the range of the versions of each
+///    key in the files of the merged partitions
+/// 2. rewrite the files of the merged partitions having superseded rows
+///
+/// Every file is downloaded once for the scan, only the files that have
+/// superseded rows are downloaded again to be rewritten. The keys tracked by
+/// the scan are bounded by `ZO_COMPACT_UPSERT_MAX_KEYS`, a bigger stream is
+/// left to the merging.
+async fn compact_by_stream(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    partition_time_level: PartitionTimeLevel,
+    primary_key: &str,
+) -> Result<(), anyhow::Error> {
+    let stats = infra::cache::stats::get_stream_stats(org_id, stream_name, stream_type);
+    if stats.doc_time_min <= 0 {
+        return Ok(());
+    }
+    let mut files = file_list::query(
+        org_id,
+        stream_name,
+        stream_type,
+        PartitionTimeLevel::Unset,
+        stats.doc_time_min,
+        now_micros(),
+        true,
+    )
+    .await?;
+    files.sort_by(|a, b| a.key.cmp(&b.key));
+    files.dedup_by(|a, b| a.key == b.key);
+
+    let step = super::get_partition_step(partition_time_level);
+    let merged_before =
+        super::get_merged_before(org_id, stream_type, stream_name, partition_time_level).await;
+    let max_keys = get_config().compact.upsert_max_keys;
+    let mut latest = LatestVersions::new();
+    let mut merged = Vec::new();
+    let mut tracked = 0;
+    for file in files {
+        let data = storage::get(&file.key).await?;
+        let (_, batches) = read_recordbatch_from_bytes(&data).await?;
+        collect_latest_versions(&batches, primary_key, &mut latest)?;
+        let partition_start = file.meta.min_ts - file.meta.min_ts % step;
+        // the merging may still work on the files of the newer partitions
+        if partition_start + step <= merged_before {
+            let mut versions = FileVersions::default();
+            versions.collect(&batches, primary_key)?;
+            tracked += versions.keys.len();
+            merged.push((file, versions));
+        }
+        if latest.len() + tracked > max_keys {
+            log::warn!(
+                "[COMPACTOR] upsert [{}/{}/{}] skipped, more than {} keys to track",
+                org_id,
+                stream_type,
+                stream_name,
+                max_keys
+            );
+            return Ok(());
+        }
+    }
+
+    let mut rewritten = 0;
+    for (file, versions) in merged {
+        if !versions.has_superseded(&latest) {
+            continue;
+        }
+
+        let mut events = Vec::with_capacity(2);
+        if !versions.all_superseded(&latest) {
+            let prefix = &file.key[..file.key.rfind('/').unwrap()];
+            let (new_file_key, new_file_meta, _) = merge::rewrite_files(
+                0,
+                org_id,
+                stream_type,
+                stream_name,
+                prefix,
+                vec![file.clone()],
+                1,
+                Some(&latest),
+            )
+            .await?;
+            if new_file_key.is_empty() {
+                continue;
+            }
+            events.push(FileKey {
+                key: new_file_key,
+                meta: new_file_meta,
+                deleted: false,
+            });
+        }
+        events.push(FileKey {
+            deleted: true,
+            ..file
+        });
+        events.sort_by(|a, b| a.key.cmp(&b.key));
+        merge::write_file_list(org_id, &events).await?;
+        rewritten += 1;
+    }
+
+    if rewritten > 0 {
+        log::info!(
+            "[COMPACTOR] upsert [{}/{}/{}] removed superseded rows from {} files, keys: {}",
+            org_id,
+            stream_type,
+            stream_name,
+            rewritten,
+            latest.len()
+        );
+    }
+    Ok(())
+}
+
+/// Returns the key as string and the timestamp columns of the batch, None when
+/// the batch doesn't have them
+fn key_and_time_columns(
+    batch: &RecordBatch,
+    primary_key: &str,
+) -> Result<Option<(StringArray, Int64Array)>, ArrowError> {
+    let (Some(keys), Some(times)) = (
+        batch.column_by_name(primary_key),
+        batch.column_by_name(&get_config().common.column_timestamp),
+    ) else {
+        return Ok(None);
+    };
+    let Some(times) = times.as_any().downcast_ref::<Int64Array>() else {
+        return Ok(None);
+    };
+    let keys = compute::cast(keys, &DataType::Utf8)?;
+    let keys = keys.as_any().downcast_ref::<StringArray>().unwrap().clone();
+    Ok(Some((keys, times.clone())))
+}
+
+/// Updates the latest versions with the rows of the batches
+pub fn collect_latest_versions(
+    batches: &[RecordBatch],
+    primary_key: &str,
+    latest: &mut LatestVersions,
+) -> Result<(), ArrowError> {
+    for batch in batches {
+        let Some((keys, times)) = key_and_time_columns(batch, primary_key)? else {
+            continue;
+        };
+        for i in 0..batch.num_rows() {
+            if keys.is_null(i) || times.is_null(i) {
+                continue;
+            }
+            let time = times.value(i);
+            latest
+                .entry(keys.value(i).to_string())
+                .and_modify(|t| *t = (*t).max(time))
+                .or_insert(time);
+        }
+    }
+    Ok(())
+}
+
+/// Oldest and newest version of each key in a file
+#[derive(Debug, Default)]
+struct FileVersions {
+    keys: HashMap<String, (i64, i64)>,
+    /// The file has rows without the key, they are always kept
+    keyless: bool,
+}
+
+impl FileVersions {
+    fn collect(&mut self, batches: &[RecordBatch], primary_key: &str) -> Result<(), ArrowError> {
+        for batch in batches {
+            let Some((keys, times)) = key_and_time_columns(batch, primary_key)? else {
+                self.keyless |= batch.num_rows() > 0;
+                continue;
+            };
+            for i in 0..batch.num_rows() {
+                if keys.is_null(i) || times.is_null(i) {
+                    self.keyless = true;
+                    continue;
+                }
+                let time = times.value(i);
+                self.keys
+                    .entry(keys.value(i).to_string())
+                    .and_modify(|(min, max)| {
+                        *min = (*min).min(time);
+                        *max = (*max).max(time);
+                    })
+                    .or_insert((time, time));
+            }
+        }
+        Ok(())
+    }
+
+    /// Returns true when a row of the file is older than the latest version
+    /// of its key
+    fn has_superseded(&self, latest: &LatestVersions) -> bool {
+        self.keys
+            .iter()
+            .any(|(key, (min, _))| latest.get(key).is_some_and(|t| min < t))
+    }
+
+    /// Returns true when every row of the file is older than the latest
+    /// version of its key
+    fn all_superseded(&self, latest: &LatestVersions) -> bool {
+        !self.keyless
+            && self
+                .keys
+                .iter()
+                .all(|(key, (_, max))| latest.get(key).is_some_and(|t| max < t))
+    }
+}
+
+/// Drops the rows older than the latest version of their key, the rows without
+/// the key are kept
+pub fn drop_superseded_rows(
+    batches: Vec<RecordBatch>,
+    primary_key: &str,
+    latest: &LatestVersions,
+) -> Result<Vec<RecordBatch>, ArrowError> {
+    let mut result = Vec::with_capacity(batches.len());
+    for batch in batches {
+        let Some((keys, times)) = key_and_time_columns(&batch, primary_key)? else {
+            result.push(batch);
+            continue;
+        };
+        let mask = (0..batch.num_rows())
+            .map(|i| {
+                Some(
+                    keys.is_null(i)
+                        || times.is_null(i)
+                        || latest
+                            .get(keys.value(i))
+                            .map_or(true, |t| times.value(i) >= *t),
+                )
+            })
+            .collect::<BooleanArray>();
+        let batch = compute::filter_record_batch(&batch, &mask)?;
+        if batch.num_rows() > 0 {
+            result.push(batch);
+        }
+    }
+    Ok(result)
+}
+
+#[cfg(test)]
+mod tests {
+    use std::sync::Arc;
+
+    use arrow_schema::{Field, Schema};
+
+    use super::*;
+
+    fn count_rows(batches: &[RecordBatch]) -> usize {
+        batches.iter().map(|b| b.num_rows()).sum()
+    }
+
+    fn batch(keys: Vec<Option<&str>>, times: Vec<i64>) -> RecordBatch {
+        let schema = Arc::new(Schema::new(vec![
+            Field::new("asset_id", DataType::Utf8, true),
+            Field::new("_timestamp", DataType::Int64, false),
+        ]));
+        RecordBatch::try_new(
+            schema,
+            vec![
+                Arc::new(StringArray::from(keys)),
+                Arc::new(Int64Array::from(times)),
+            ],
+        )
+        .unwrap()
+    }
+
+    #[test]
+    fn test_drop_superseded_rows() {
+        let batches = vec![
+            batch(vec![Some("a"), Some("b"), None], vec![1, 5, 1]),
+            batch(vec![Some("a"), Some("b")], vec![3, 2]),
+        ];
+        let mut latest = LatestVersions::new();
+        collect_latest_versions(&batches, "asset_id", &mut latest).unwrap();
+        assert_eq!(latest.get("a"), Some(&3));
+        assert_eq!(latest.get("b"), Some(&5));
+
+        let batches = drop_superseded_rows(batches, "asset_id", &latest).unwrap();
+        assert_eq!(count_rows(&batches), 3);
+        let keys = batches[0]
+            .column(0)
+            .as_any()
+            .downcast_ref::<StringArray>()
+            .unwrap();
+        assert_eq!(keys.value(0), "b");
+        assert!(keys.is_null(1));
+        let keys = batches[1]
+            .column(0)
+            .as_any()
+            .downcast_ref::<StringArray>()
+            .unwrap();
+        assert_eq!(keys.value(0), "a");
+    }
+
+    #[test]
+    fn test_file_versions() {
+        let batches = vec![batch(vec![Some("a"), Some("a"), Some("b")], vec![1, 2, 4])];
+        let mut versions = FileVersions::default();
+        versions.collect(&batches, "asset_id").unwrap();
+        assert_eq!(versions.keys.get("a"), Some(&(1, 2)));
+        assert_eq!(versions.keys.get("b"), Some(&(4, 4)));
+        assert!(!versions.keyless);
+
+        let latest = LatestVersions::from([("a".to_string(), 2), ("b".to_string(), 4)]);
+        assert!(versions.has_superseded(&latest));
+        assert!(!versions.all_superseded(&latest));
+        let latest = LatestVersions::from([("a".to_string(), 3), ("b".to_string(), 5)]);
+        assert!(versions.all_superseded(&latest));
+        let latest = LatestVersions::from([("a".to_string(), 1), ("b".to_string(), 4)]);
+        assert!(!versions.has_superseded(&latest));
+
+        versions
+            .collect(&[batch(vec![None], vec![1])], "asset_id")
+            .unwrap();
+        assert!(versions.keyless);
+        let latest = LatestVersions::from([("a".to_string(), 3), ("b".to_string(), 5)]);
+        assert!(!versions.all_superseded(&latest));
+    }
+
+    #[test]
+    fn test_drop_superseded_rows_without_key() {
+        let batches = vec![batch(vec![Some("a"), Some("a")], vec![1, 2])];
+        let latest = LatestVersions::from([("a".to_string(), 2)]);
+        let batches = drop_superseded_rows(batches, "other_key", &latest).unwrap();
+        assert_eq!(count_rows(&batches), 2);
+    }
+}
@@ -0,0 +1,538 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Built-in corpus of the OTLP conformance suite: every data point type and
+//! the edge cases of the attributes, the bodies and the ids.
+
+use opentelemetry_proto::tonic::{
+    collector::{
+        logs::v1::ExportLogsServiceRequest, metrics::v1::ExportMetricsServiceRequest,
+        trace::v1::ExportTraceServiceRequest,
+    },
+    common::v1::{
+        any_value::Value, AnyValue, ArrayValue, InstrumentationScope, KeyValue, KeyValueList,
+    },
+    logs::v1::{LogRecord, ResourceLogs, ScopeLogs},
+    metrics::v1::{
+        exemplar, exponential_histogram_data_point::Buckets, metric::Data, number_data_point,
+        summary_data_point::ValueAtQuantile, AggregationTemporality, Exemplar,
+        ExponentialHistogram, ExponentialHistogramDataPoint, Gauge, Histogram, HistogramDataPoint,
+        Metric, NumberDataPoint, ResourceMetrics, ScopeMetrics, Sum, Summary, SummaryDataPoint,
+    },
+    resource::v1::Resource,
+    trace::v1::{
+        span::{Event, Link, SpanKind},
+        status::StatusCode,
+        ResourceSpans, ScopeSpans, Span, Status,
+    },
+};
+use prost::Message;
+
+/// Prefix of the metrics of the corpus, they go to streams named after them
+pub(super) const METRIC_PREFIX: &str = "otlp_conformance_";
+
+const TRACE_ID: [u8; 16] = [
+    0x5b, 0x8e, 0xff, 0xf7, 0x98, 0x03, 0x81, 0x03, 0xd2, 0x69, 0xb6, 0x33, 0x81, 0x3f, 0xc6, 0x0c,
+];
+const SPAN_ID: [u8; 8] = [0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x74];
+const PARENT_SPAN_ID: [u8; 8] = [0xee, 0xe1, 0x9b, 0x7e, 0xc3, 0xc1, 0xb1, 0x73];
+
+pub(super) enum Request {
+    Logs(ExportLogsServiceRequest),
+    Traces(ExportTraceServiceRequest),
+    Metrics(ExportMetricsServiceRequest),
+}
+
+impl Request {
+    pub fn signal(&self) -> &'static str {
+        match self {
+            Request::Logs(_) => "logs",
+            Request::Traces(_) => "traces",
+            Request::Metrics(_) => "metrics",
+        }
+    }
+
+    pub fn encode_to_vec(&self) -> Vec<u8> {
+        match self {
+            Request::Logs(r) => r.encode_to_vec(),
+            Request::Traces(r) => r.encode_to_vec(),
+            Request::Metrics(r) => r.encode_to_vec(),
+        }
+    }
+}
+
+pub(super) struct Case {
+    pub name: &'static str,
+    pub request: Request,
+}
+
+/// Returns the cases with their data at `now`, in nanoseconds
+pub(super) fn cases(now: u64) -> Vec<Case> {
+    vec![
+        Case {
+            name: "logs_minimal",
+            request: logs(vec![LogRecord {
+                time_unix_nano: now,
+                body: Some(string("conformance minimal record")),
+                ..Default::default()
+            }]),
+        },
+        Case {
+            name: "logs_attribute_types",
+            request: logs(vec![LogRecord {
+                time_unix_nano: now,
+                body: Some(string("all the attribute types")),
+                attributes: all_types(),
+                ..Default::default()
+            }]),
+        },
+        Case {
+            name: "logs_edge_attributes",
+            request: logs(vec![LogRecord {
+                time_unix_nano: now,
+                body: Some(string("edge case attributes")),
+                attributes: edge_attributes(),
+                ..Default::default()
+            }]),
+        },
+        Case {
+            name: "logs_body_types",
+            request: logs(
+                all_types()
+                    .into_iter()
+                    .filter_map(|kv| kv.value)
+                    .map(|body| LogRecord {
+                        time_unix_nano: now,
+                        body: Some(body),
+                        ..Default::default()
+                    })
+                    .collect(),
+            ),
+        },
+        Case {
+            name: "logs_trace_context",
+            request: logs(vec![LogRecord {
+                time_unix_nano: now,
+                severity_number: 17,
+                severity_text: "ERROR".to_string(),
+                body: Some(string("record of a span")),
+                flags: 1,
+                trace_id: TRACE_ID.to_vec(),
+                span_id: SPAN_ID.to_vec(),
+                ..Default::default()
+            }]),
+        },
+        Case {
+            name: "logs_observed_time_only",
+            request: logs(vec![LogRecord {
+                observed_time_unix_nano: now,
+                severity_number: 9,
+                body: Some(string("record without a time")),
+                ..Default::default()
+            }]),
+        },
+        Case {
+            name: "logs_multiple_resources_and_scopes",
+            request: Request::Logs(ExportLogsServiceRequest {
+                resource_logs: ["conformance-a", "conformance-b"]
+                    .into_iter()
+                    .map(|service| ResourceLogs {
+                        resource: Some(resource(service)),
+                        scope_logs: ["scope-a", "scope-b"]
+                            .into_iter()
+                            .map(|name| ScopeLogs {
+                                scope: Some(scope(name)),
+                                log_records: vec![LogRecord {
+                                    time_unix_nano: now,
+                                    body: Some(string(&format!("{service} {name}"))),
+                                    ..Default::default()
+                                }],
+                                ..Default::default()
+                            })
+                            .collect(),
+                        ..Default::default()
+                    })
+                    .collect(),
+            }),
+        },
+        Case {
+            name: "traces_minimal",
+            request: traces(vec![span(now, "GET /conformance", SpanKind::Server)]),
+        },
+        Case {
+            name: "traces_span_kinds",
+            request: traces(
+                [
+                    SpanKind::Unspecified,
+                    SpanKind::Internal,
+                    SpanKind::Server,
+                    SpanKind::Client,
+                    SpanKind::Producer,
+                    SpanKind::Consumer,
+                ]
+                .into_iter()
+                .enumerate()
+                .map(|(i, kind)| Span {
+                    span_id: (i as u64 + 1).to_be_bytes().to_vec(),
+                    parent_span_id: if i == 0 {
+                        vec![]
+                    } else {
+                        (i as u64).to_be_bytes().to_vec()
+                    },
+                    ..span(now, &format!("span kind {}", kind as i32), kind)
+                })
+                .collect(),
+            ),
+        },
+        Case {
+            name: "traces_events_links_status",
+            request: traces(vec![Span {
+                parent_span_id: PARENT_SPAN_ID.to_vec(),
+                trace_state: "o2=conformance".to_string(),
+                events: vec![Event {
+                    time_unix_nano: now - 500_000,
+                    name: "exception".to_string(),
+                    attributes: vec![
+                        kv("exception.type", string("ConformanceError")),
+                        kv("exception.message", string("expected failure")),
+                    ],
+                    ..Default::default()
+                }],
+                links: vec![Link {
+                    trace_id: TRACE_ID.iter().rev().copied().collect(),
+                    span_id: SPAN_ID.iter().rev().copied().collect(),
+                    attributes: vec![kv("link.kind", string("follows_from"))],
+                    ..Default::default()
+                }],
+                status: Some(Status {
+                    message: "expected failure".to_string(),
+                    code: StatusCode::Error as i32,
+                }),
+                ..span(now, "POST /conformance", SpanKind::Server)
+            }]),
+        },
+        Case {
+            name: "traces_attribute_types",
+            request: traces(vec![Span {
+                attributes: all_types(),
+                ..span(now, "attribute types", SpanKind::Internal)
+            }]),
+        },
+        Case {
+            name: "traces_edge_attributes",
+            request: traces(vec![Span {
+                attributes: edge_attributes(),
+                ..span(now, "edge case attributes", SpanKind::Internal)
+            }]),
+        },
+        Case {
+            name: "metrics_gauge_double",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}gauge_double"),
+                unit: "1".to_string(),
+                data: Some(Data::Gauge(Gauge {
+                    data_points: vec![number(now, number_data_point::Value::AsDouble(0.75))],
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_gauge_int",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}gauge_int"),
+                data: Some(Data::Gauge(Gauge {
+                    data_points: vec![number(now, number_data_point::Value::AsInt(i64::MIN))],
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_sum_monotonic_cumulative",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}sum_monotonic_cumulative"),
+                description: "monotonic cumulative counter".to_string(),
+                data: Some(Data::Sum(Sum {
+                    data_points: vec![number(now, number_data_point::Value::AsInt(42))],
+                    aggregation_temporality: AggregationTemporality::Cumulative as i32,
+                    is_monotonic: true,
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_sum_delta",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}sum_delta"),
+                data: Some(Data::Sum(Sum {
+                    data_points: vec![number(now, number_data_point::Value::AsDouble(1.5))],
+                    aggregation_temporality: AggregationTemporality::Delta as i32,
+                    is_monotonic: true,
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_sum_up_down",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}sum_up_down"),
+                data: Some(Data::Sum(Sum {
+                    data_points: vec![number(now, number_data_point::Value::AsInt(-3))],
+                    aggregation_temporality: AggregationTemporality::Cumulative as i32,
+                    is_monotonic: false,
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_histogram",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}histogram"),
+                unit: "ms".to_string(),
+                data: Some(Data::Histogram(Histogram {
+                    data_points: vec![HistogramDataPoint {
+                        attributes: vec![kv("http.route", string("/conformance"))],
+                        start_time_unix_nano: now - 60_000_000_000,
+                        time_unix_nano: now,
+                        count: 6,
+                        sum: Some(321.5),
+                        bucket_counts: vec![1, 2, 2, 1],
+                        explicit_bounds: vec![10.0, 50.0, 100.0],
+                        exemplars: vec![Exemplar {
+                            time_unix_nano: now,
+                            span_id: SPAN_ID.to_vec(),
+                            trace_id: TRACE_ID.to_vec(),
+                            value: Some(exemplar::Value::AsDouble(120.0)),
+                            ..Default::default()
+                        }],
+                        min: Some(2.5),
+                        max: Some(120.0),
+                        ..Default::default()
+                    }],
+                    aggregation_temporality: AggregationTemporality::Cumulative as i32,
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_exponential_histogram",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}exponential_histogram"),
+                data: Some(Data::ExponentialHistogram(ExponentialHistogram {
+                    data_points: vec![ExponentialHistogramDataPoint {
+                        start_time_unix_nano: now - 60_000_000_000,
+                        time_unix_nano: now,
+                        count: 10,
+                        sum: Some(12.5),
+                        scale: 2,
+                        zero_count: 1,
+                        positive: Some(Buckets {
+                            offset: -1,
+                            bucket_counts: vec![2, 3, 1],
+                        }),
+                        negative: Some(Buckets {
+                            offset: 0,
+                            bucket_counts: vec![1, 2],
+                        }),
+                        min: Some(-2.0),
+                        max: Some(4.0),
+                        ..Default::default()
+                    }],
+                    aggregation_temporality: AggregationTemporality::Cumulative as i32,
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_summary",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}summary"),
+                data: Some(Data::Summary(Summary {
+                    data_points: vec![SummaryDataPoint {
+                        start_time_unix_nano: now - 60_000_000_000,
+                        time_unix_nano: now,
+                        count: 100,
+                        sum: 2_500.0,
+                        quantile_values: [(0.0, 1.0), (0.5, 20.0), (0.99, 90.0), (1.0, 95.0)]
+                            .into_iter()
+                            .map(|(quantile, value)| ValueAtQuantile { quantile, value })
+                            .collect(),
+                        ..Default::default()
+                    }],
+                })),
+                ..Default::default()
+            }),
+        },
+        Case {
+            name: "metrics_dotted_name",
+            request: metric(Metric {
+                name: format!("{METRIC_PREFIX}http.server.request.duration"),
+                unit: "s".to_string(),
+                data: Some(Data::Gauge(Gauge {
+                    data_points: vec![NumberDataPoint {
+                        attributes: edge_attributes(),
+                        ..number(now, number_data_point::Value::AsDouble(0.25))
+                    }],
+                })),
+                ..Default::default()
+            }),
+        },
+    ]
+}
+
+fn logs(log_records: Vec<LogRecord>) -> Request {
+    Request::Logs(ExportLogsServiceRequest {
+        resource_logs: vec![ResourceLogs {
+            resource: Some(resource("conformance")),
+            scope_logs: vec![ScopeLogs {
+                scope: Some(scope("conformance")),
+                log_records,
+                ..Default::default()
+            }],
+            ..Default::default()
+        }],
+    })
+}
+
+fn traces(spans: Vec<Span>) -> Request {
+    Request::Traces(ExportTraceServiceRequest {
+        resource_spans: vec![ResourceSpans {
+            resource: Some(resource("conformance")),
+            scope_spans: vec![ScopeSpans {
+                scope: Some(scope("conformance")),
+                spans,
+                ..Default::default()
+            }],
+            ..Default::default()
+        }],
+    })
+}
+
+fn metric(metric: Metric) -> Request {
+    Request::Metrics(ExportMetricsServiceRequest {
+        resource_metrics: vec![ResourceMetrics {
+            resource: Some(resource("conformance")),
+            scope_metrics: vec![ScopeMetrics {
+                scope: Some(scope("conformance")),
+                metrics: vec![metric],
+                ..Default::default()
+            }],
+            ..Default::default()
+        }],
+    })
+}
+
+/// Returns a span of one millisecond ending at `now`
+fn span(now: u64, name: &str, kind: SpanKind) -> Span {
+    Span {
+        trace_id: TRACE_ID.to_vec(),
+        span_id: SPAN_ID.to_vec(),
+        name: name.to_string(),
+        kind: kind as i32,
+        start_time_unix_nano: now - 1_000_000,
+        end_time_unix_nano: now,
+        ..Default::default()
+    }
+}
+
+fn number(now: u64, value: number_data_point::Value) -> NumberDataPoint {
+    NumberDataPoint {
+        start_time_unix_nano: now - 60_000_000_000,
+        time_unix_nano: now,
+        value: Some(value),
+        ..Default::default()
+    }
+}
+
+fn resource(service: &str) -> Resource {
+    Resource {
+        attributes: vec![
+            kv("service.name", string(service)),
+            kv("service.version", string("1.0.0")),
+        ],
+        ..Default::default()
+    }
+}
+
+fn scope(name: &str) -> InstrumentationScope {
+    InstrumentationScope {
+        name: name.to_string(),
+        version: "1.0.0".to_string(),
+        ..Default::default()
+    }
+}
+
+/// Returns one attribute of every value type
+fn all_types() -> Vec<KeyValue> {
+    vec![
+        kv("string", string("conformance")),
+        kv("bool", value(Value::BoolValue(true))),
+        kv("int", value(Value::IntValue(i64::MAX))),
+        kv("double", value(Value::DoubleValue(-1.5e300))),
+        kv(
+            "array",
+            value(Value::ArrayValue(ArrayValue {
+                values: vec![string("a"), value(Value::IntValue(1))],
+            })),
+        ),
+        kv(
+            "kvlist",
+            value(Value::KvlistValue(KeyValueList {
+                values: vec![
+                    kv("nested", string("value")),
+                    kv("depth", value(Value::IntValue(2))),
+                ],
+            })),
+        ),
+        kv("bytes", value(Value::BytesValue(vec![0, 1, 2, 0xfe, 0xff]))),
+    ]
+}
+
+/// Returns the attributes with empty, missing, unicode, long and odd values
+/// and keys
+fn edge_attributes() -> Vec<KeyValue> {
+    vec![
+        kv("empty", string("")),
+        KeyValue {
+            key: "missing".to_string(),
+            value: None,
+        },
+        kv("unicode", string("日本語 ✓ ünïcödé")),
+        kv("long", string(&"x".repeat(16 * 1024))),
+        kv("dotted.key-with.dashes", string("value")),
+        kv("UPPER_Case", string("value")),
+        kv("quotes", string("\"quoted\" 'value' \\ with\nnewline")),
+        kv("zero_int", value(Value::IntValue(0))),
+        kv("false_bool", value(Value::BoolValue(false))),
+        kv(
+            "empty_array",
+            value(Value::ArrayValue(ArrayValue { values: vec![] })),
+        ),
+    ]
+}
+
+fn kv(key: &str, value: AnyValue) -> KeyValue {
+    KeyValue {
+        key: key.to_string(),
+        value: Some(value),
+    }
+}
+
+fn string(value: &str) -> AnyValue {
+    self::value(Value::StringValue(value.to_string()))
+}
+
+fn value(value: Value) -> AnyValue {
+    AnyValue { value: Some(value) }
+}
@@ -0,0 +1,376 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! OTLP conformance suite: a built-in corpus of logs, traces and metrics sent
+//! to the OTLP endpoints of this node, with the protobuf and the json
+//! encodings over HTTP and through the gRPC services, with the credentials of
+//! the caller, reporting which cases are accepted, to catch protocol
+//! regressions before a release.
+//!
+//! The cases go through the same routing, auth and decoding as the requests
+//! of the collectors, a request making the ingestion panic shows up as a
+//! failed case with a transport error and its panic in the logs of the node.
+
+use std::{
+    io::Error,
+    time::{Duration, Instant},
+};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    cluster::{self, LOCAL_NODE_UUID},
+    get_config,
+    utils::json,
+};
+use opentelemetry_proto::tonic::collector::{
+    logs::v1::{logs_service_client::LogsServiceClient, ExportLogsServiceResponse},
+    metrics::v1::{metrics_service_client::MetricsServiceClient, ExportMetricsServiceResponse},
+    trace::v1::{trace_service_client::TraceServiceClient, ExportTraceServiceResponse},
+};
+use prost::Message;
+use reqwest::{header, StatusCode};
+use tonic::{
+    metadata::{MetadataKey, MetadataValue},
+    transport::Channel,
+};
+
+use crate::common::{
+    infra::{cluster as infra_cluster, tls},
+    meta::{
+        conformance::{CaseResult, ConformanceReport, ConformanceRequest, Transport},
+        http::HttpResponse as MetaHttpResponse,
+    },
+};
+
+mod corpus;
+mod otlp_json;
+
+use corpus::Request;
+
+const CONTENT_TYPE_JSON: &str = "application/json";
+const CONTENT_TYPE_PROTO: &str = "application/x-protobuf";
+const TIMEOUT: Duration = Duration::from_secs(30);
+
+/// Runs the cases of the corpus matching the request through each transport,
+/// a case passes when the endpoint accepts all its records. `auth` is the
+/// authorization header of the caller, sent with every case.
+pub async fn run(org_id: &str, auth: &str, req: ConformanceRequest) -> Result<HttpResponse, Error> {
+    if !cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(MetaHttpResponse::bad_request(
+            "The conformance suite needs an ingester node",
+        ));
+    }
+    if let Err(e) = req.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    let client = match Client::new(org_id, auth, &req.stream).await {
+        Ok(client) => client,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+
+    let now = Utc::now().timestamp_nanos_opt().unwrap_or_default() as u64;
+    let transports = req.transports();
+    let mut results = Vec::new();
+    for case in corpus::cases(now) {
+        if let Some(filter) = req.filter.as_deref() {
+            if !case.name.contains(filter) {
+                continue;
+            }
+        }
+        for transport in transports.iter() {
+            let start = Instant::now();
+            let error = match transport {
+                Transport::HttpProtobuf | Transport::HttpJson => {
+                    client.send_http(&case.request, *transport).await
+                }
+                Transport::Grpc => client.send_grpc(&case.request).await,
+            }
+            .err();
+            results.push(CaseResult {
+                case: case.name.to_string(),
+                signal: case.request.signal().to_string(),
+                transport: *transport,
+                passed: error.is_none(),
+                took: start.elapsed().as_millis() as u64,
+                error,
+            });
+        }
+    }
+
+    let report = ConformanceReport::new(results);
+    log::info!(
+        "[CONFORMANCE] org {org_id}: {} passed, {} failed",
+        report.passed,
+        report.failed
+    );
+    Ok(MetaHttpResponse::json(report))
+}
+
+/// Sends the cases to the HTTP and gRPC endpoints of this node
+struct Client {
+    http: reqwest::Client,
+    http_url: String,
+    grpc: Channel,
+    org_id: String,
+    auth: String,
+    stream: String,
+}
+
+impl Client {
+    async fn new(org_id: &str, auth: &str, stream: &str) -> Result<Self, anyhow::Error> {
+        let node = infra_cluster::get_node_by_uuid(&LOCAL_NODE_UUID)
+            .await
+            .ok_or_else(|| anyhow::anyhow!("this node is not registered in the cluster"))?;
+        let http = if get_config().tls.http_enabled {
+            reqwest::Client::builder().use_preconfigured_tls(tls::http_client_config()?)
+        } else {
+            reqwest::Client::builder()
+        }
+        .timeout(TIMEOUT)
+        .build()?;
+        Ok(Self {
+            http,
+            http_url: format!(
+                "{}{}/api/{org_id}",
+                node.http_addr,
+                get_config().common.base_uri
+            ),
+            grpc: tls::grpc_endpoint(node.grpc_addr)?
+                .timeout(TIMEOUT)
+                .connect_lazy(),
+            org_id: org_id.to_string(),
+            auth: auth.to_string(),
+            stream: stream.to_string(),
+        })
+    }
+
+    async fn send_http(&self, request: &Request, transport: Transport) -> Result<(), String> {
+        let (content_type, body) = match transport {
+            Transport::HttpJson => (
+                CONTENT_TYPE_JSON,
+                json::to_vec(&to_json(request)).map_err(|e| e.to_string())?,
+            ),
+            _ => (CONTENT_TYPE_PROTO, request.encode_to_vec()),
+        };
+        let resp = self
+            .http
+            .post(format!("{}/v1/{}", self.http_url, request.signal()))
+            .header(header::AUTHORIZATION, self.auth.as_str())
+            .header(header::CONTENT_TYPE, content_type)
+            .header(
+                get_config().grpc.stream_header_key.as_str(),
+                self.stream.as_str(),
+            )
+            .body(body)
+            .send()
+            .await
+            .map_err(|e| format!("request failed: {e}"))?;
+        let status = resp.status();
+        let is_protobuf = resp.headers().get(header::CONTENT_TYPE).map_or(false, |v| {
+            v.as_bytes().starts_with(CONTENT_TYPE_PROTO.as_bytes())
+        });
+        let body = resp
+            .bytes()
+            .await
+            .map_err(|e| format!("invalid response: {e}"))?;
+        check(request, status, is_protobuf, &body)
+    }
+
+    async fn send_grpc(&self, request: &Request) -> Result<(), String> {
+        let status_error = |e: tonic::Status| format!("status {:?}: {}", e.code(), e.message());
+        let partial_success = match request {
+            Request::Logs(r) => LogsServiceClient::new(self.grpc.clone())
+                .export(self.grpc_request(r.clone())?)
+                .await
+                .map_err(status_error)?
+                .into_inner()
+                .partial_success
+                .map(|p| (p.rejected_log_records, p.error_message)),
+            Request::Traces(r) => TraceServiceClient::new(self.grpc.clone())
+                .export(self.grpc_request(r.clone())?)
+                .await
+                .map_err(status_error)?
+                .into_inner()
+                .partial_success
+                .map(|p| (p.rejected_spans, p.error_message)),
+            Request::Metrics(r) => MetricsServiceClient::new(self.grpc.clone())
+                .export(self.grpc_request(r.clone())?)
+                .await
+                .map_err(status_error)?
+                .into_inner()
+                .partial_success
+                .map(|p| (p.rejected_data_points, p.error_message)),
+        };
+        check_rejected(partial_success)
+    }
+
+    /// Wraps a message with the auth, org and stream metadata of the gRPC
+    /// services
+    fn grpc_request<T>(&self, message: T) -> Result<tonic::Request<T>, String> {
+        let cfg = get_config();
+        let mut req = tonic::Request::new(message);
+        let metadata = req.metadata_mut();
+        for (key, value) in [
+            ("authorization", self.auth.as_str()),
+            (cfg.grpc.org_header_key.as_str(), self.org_id.as_str()),
+            (cfg.grpc.stream_header_key.as_str(), self.stream.as_str()),
+        ] {
+            metadata.insert(
+                MetadataKey::from_bytes(key.as_bytes()).map_err(|e| e.to_string())?,
+                MetadataValue::try_from(value).map_err(|e| e.to_string())?,
+            );
+        }
+        Ok(req)
+    }
+}
+
+/// Checks that the response accepts all the records: a success status and
+/// nothing rejected in the partial success of the protobuf responses or in the
+/// stream statuses of the json ones
+fn check(
+    request: &Request,
+    status: StatusCode,
+    is_protobuf: bool,
+    body: &[u8],
+) -> Result<(), String> {
+    if !status.is_success() {
+        return Err(format!(
+            "status {status}: {}",
+            String::from_utf8_lossy(body)
+        ));
+    }
+    if is_protobuf {
+        return check_partial_success(request, body);
+    }
+    if body.is_empty() {
+        return Ok(());
+    }
+    let body: json::Value = json::from_slice(body).map_err(|e| format!("invalid response: {e}"))?;
+    for stream in body
+        .get("status")
+        .and_then(|v| v.as_array())
+        .into_iter()
+        .flatten()
+    {
+        let failed = stream.get("failed").and_then(|v| v.as_u64()).unwrap_or(0);
+        if failed > 0 {
+            return Err(format!(
+                "{failed} records rejected in stream {}: {}",
+                stream
+                    .get("name")
+                    .and_then(|v| v.as_str())
+                    .unwrap_or_default(),
+                stream
+                    .get("error")
+                    .and_then(|v| v.as_str())
+                    .unwrap_or_default()
+            ));
+        }
+    }
+    match body.get("code").and_then(|v| v.as_u64()) {
+        Some(code) if code != 200 => Err(format!("code {code}: {body}")),
+        _ => Ok(()),
+    }
+}
+
+fn check_partial_success(request: &Request, body: &[u8]) -> Result<(), String> {
+    let decode_error = |e: prost::DecodeError| format!("invalid response: {e}");
+    check_rejected(match request {
+        Request::Logs(_) => ExportLogsServiceResponse::decode(body)
+            .map_err(decode_error)?
+            .partial_success
+            .map(|p| (p.rejected_log_records, p.error_message)),
+        Request::Traces(_) => ExportTraceServiceResponse::decode(body)
+            .map_err(decode_error)?
+            .partial_success
+            .map(|p| (p.rejected_spans, p.error_message)),
+        Request::Metrics(_) => ExportMetricsServiceResponse::decode(body)
+            .map_err(decode_error)?
+            .partial_success
+            .map(|p| (p.rejected_data_points, p.error_message)),
+    })
+}
+
+fn check_rejected(partial_success: Option<(i64, String)>) -> Result<(), String> {
+    let (rejected, message) = partial_success.unwrap_or_default();
+    if rejected > 0 {
+        return Err(format!("{rejected} rejected: {message}"));
+    }
+    Ok(())
+}
+
+fn to_json(request: &Request) -> json::Value {
+    match request {
+        Request::Logs(r) => otlp_json::logs(r),
+        Request::Traces(r) => otlp_json::traces(r),
+        Request::Metrics(r) => otlp_json::metrics(r),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use std::collections::HashSet;
+
+    use super::*;
+
+    #[test]
+    fn test_corpus() {
+        let cases = corpus::cases(1_700_000_000_000_000_000);
+        let names = cases.iter().map(|c| c.name).collect::<HashSet<_>>();
+        assert_eq!(names.len(), cases.len(), "case names should be unique");
+        for signal in ["logs", "traces", "metrics"] {
+            assert!(cases.iter().any(|c| c.request.signal() == signal));
+        }
+        for case in cases.iter() {
+            if let Request::Metrics(req) = &case.request {
+                for m in req.resource_metrics[0].scope_metrics[0].metrics.iter() {
+                    assert!(m.name.starts_with(corpus::METRIC_PREFIX), "{}", m.name);
+                }
+            }
+        }
+    }
+
+    #[test]
+    fn test_check() {
+        let logs = Request::Logs(Default::default());
+        let ok = json::to_vec(&json::json!({
+            "code": 200,
+            "status": [{"name": "default", "successful": 2, "failed": 0}]
+        }))
+        .unwrap();
+        assert!(check(&logs, StatusCode::OK, false, &ok).is_ok());
+        let rejected = json::to_vec(&json::json!({
+            "code": 200,
+            "status": [{"name": "default", "successful": 1, "failed": 1, "error": "too old"}]
+        }))
+        .unwrap();
+        assert!(check(&logs, StatusCode::OK, false, &rejected)
+            .unwrap_err()
+            .contains("too old"));
+        assert!(check(&logs, StatusCode::BAD_REQUEST, false, b"invalid")
+            .unwrap_err()
+            .starts_with("status 400"));
+
+        let traces = Request::Traces(Default::default());
+        let partial = ExportTraceServiceResponse {
+            partial_success: Some(Default::default()),
+        };
+        assert!(check(&traces, StatusCode::OK, true, &partial.encode_to_vec()).is_ok());
+        assert!(check_rejected(Some((2, "invalid span id".to_string())))
+            .unwrap_err()
+            .contains("invalid span id"));
+    }
+}
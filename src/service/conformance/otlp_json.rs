@@ -0,0 +1,587 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Encoding of the OTLP export requests with the OTLP/JSON mapping of the
+//! protobuf messages: lowerCamelCase field names, hex trace and span ids,
+//! 64 bit integers as strings, enums as numbers and the default values left out.
+
+use base64::Engine;
+use config::utils::json::{Map, Value};
+use opentelemetry_proto::tonic::{
+    collector::{
+        logs::v1::ExportLogsServiceRequest, metrics::v1::ExportMetricsServiceRequest,
+        trace::v1::ExportTraceServiceRequest,
+    },
+    common::v1::{any_value, AnyValue, InstrumentationScope, KeyValue},
+    logs::v1::LogRecord,
+    metrics::v1::{
+        exemplar, exponential_histogram_data_point::Buckets, metric::Data, number_data_point,
+        Exemplar, ExponentialHistogramDataPoint, HistogramDataPoint, Metric, NumberDataPoint,
+        SummaryDataPoint,
+    },
+    resource::v1::Resource,
+    trace::v1::{
+        span::{Event, Link},
+        Span, Status,
+    },
+};
+
+pub(super) fn logs(req: &ExportLogsServiceRequest) -> Value {
+    let resource_logs = req
+        .resource_logs
+        .iter()
+        .map(|r| {
+            let scope_logs = r
+                .scope_logs
+                .iter()
+                .map(|s| {
+                    Object::default()
+                        .obj("scope", s.scope.as_ref().map(scope))
+                        .list("logRecords", s.log_records.iter().map(log_record))
+                        .str("schemaUrl", &s.schema_url)
+                        .done()
+                })
+                .collect::<Vec<_>>();
+            Object::default()
+                .obj("resource", r.resource.as_ref().map(resource))
+                .list("scopeLogs", scope_logs)
+                .str("schemaUrl", &r.schema_url)
+                .done()
+        })
+        .collect::<Vec<_>>();
+    Object::default().list("resourceLogs", resource_logs).done()
+}
+
+pub(super) fn traces(req: &ExportTraceServiceRequest) -> Value {
+    let resource_spans = req
+        .resource_spans
+        .iter()
+        .map(|r| {
+            let scope_spans = r
+                .scope_spans
+                .iter()
+                .map(|s| {
+                    Object::default()
+                        .obj("scope", s.scope.as_ref().map(scope))
+                        .list("spans", s.spans.iter().map(span))
+                        .str("schemaUrl", &s.schema_url)
+                        .done()
+                })
+                .collect::<Vec<_>>();
+            Object::default()
+                .obj("resource", r.resource.as_ref().map(resource))
+                .list("scopeSpans", scope_spans)
+                .str("schemaUrl", &r.schema_url)
+                .done()
+        })
+        .collect::<Vec<_>>();
+    Object::default()
+        .list("resourceSpans", resource_spans)
+        .done()
+}
+
+pub(super) fn metrics(req: &ExportMetricsServiceRequest) -> Value {
+    let resource_metrics = req
+        .resource_metrics
+        .iter()
+        .map(|r| {
+            let scope_metrics = r
+                .scope_metrics
+                .iter()
+                .map(|s| {
+                    Object::default()
+                        .obj("scope", s.scope.as_ref().map(scope))
+                        .list("metrics", s.metrics.iter().map(metric))
+                        .str("schemaUrl", &s.schema_url)
+                        .done()
+                })
+                .collect::<Vec<_>>();
+            Object::default()
+                .obj("resource", r.resource.as_ref().map(resource))
+                .list("scopeMetrics", scope_metrics)
+                .str("schemaUrl", &r.schema_url)
+                .done()
+        })
+        .collect::<Vec<_>>();
+    Object::default()
+        .list("resourceMetrics", resource_metrics)
+        .done()
+}
+
+fn resource(resource: &Resource) -> Value {
+    Object::default()
+        .list("attributes", attributes(&resource.attributes))
+        .int("droppedAttributesCount", resource.dropped_attributes_count)
+        .done()
+}
+
+fn scope(scope: &InstrumentationScope) -> Value {
+    Object::default()
+        .str("name", &scope.name)
+        .str("version", &scope.version)
+        .list("attributes", attributes(&scope.attributes))
+        .int("droppedAttributesCount", scope.dropped_attributes_count)
+        .done()
+}
+
+fn log_record(record: &LogRecord) -> Value {
+    Object::default()
+        .u64("timeUnixNano", record.time_unix_nano)
+        .u64("observedTimeUnixNano", record.observed_time_unix_nano)
+        .int("severityNumber", record.severity_number)
+        .str("severityText", &record.severity_text)
+        .obj("body", record.body.as_ref().map(any_value))
+        .list("attributes", attributes(&record.attributes))
+        .int("droppedAttributesCount", record.dropped_attributes_count)
+        .int("flags", record.flags)
+        .hex("traceId", &record.trace_id)
+        .hex("spanId", &record.span_id)
+        .done()
+}
+
+fn span(span: &Span) -> Value {
+    Object::default()
+        .hex("traceId", &span.trace_id)
+        .hex("spanId", &span.span_id)
+        .str("traceState", &span.trace_state)
+        .hex("parentSpanId", &span.parent_span_id)
+        .str("name", &span.name)
+        .int("kind", span.kind)
+        .u64("startTimeUnixNano", span.start_time_unix_nano)
+        .u64("endTimeUnixNano", span.end_time_unix_nano)
+        .list("attributes", attributes(&span.attributes))
+        .int("droppedAttributesCount", span.dropped_attributes_count)
+        .list("events", span.events.iter().map(event))
+        .int("droppedEventsCount", span.dropped_events_count)
+        .list("links", span.links.iter().map(link))
+        .int("droppedLinksCount", span.dropped_links_count)
+        .obj("status", span.status.as_ref().map(status))
+        .done()
+}
+
+fn event(event: &Event) -> Value {
+    Object::default()
+        .u64("timeUnixNano", event.time_unix_nano)
+        .str("name", &event.name)
+        .list("attributes", attributes(&event.attributes))
+        .int("droppedAttributesCount", event.dropped_attributes_count)
+        .done()
+}
+
+fn link(link: &Link) -> Value {
+    Object::default()
+        .hex("traceId", &link.trace_id)
+        .hex("spanId", &link.span_id)
+        .str("traceState", &link.trace_state)
+        .list("attributes", attributes(&link.attributes))
+        .int("droppedAttributesCount", link.dropped_attributes_count)
+        .done()
+}
+
+fn status(status: &Status) -> Value {
+    Object::default()
+        .str("message", &status.message)
+        .int("code", status.code)
+        .done()
+}
+
+fn metric(metric: &Metric) -> Value {
+    let obj = Object::default()
+        .str("name", &metric.name)
+        .str("description", &metric.description)
+        .str("unit", &metric.unit);
+    match metric.data.as_ref() {
+        Some(Data::Gauge(gauge)) => obj.obj(
+            "gauge",
+            Some(
+                Object::default()
+                    .list("dataPoints", gauge.data_points.iter().map(number_point))
+                    .done(),
+            ),
+        ),
+        Some(Data::Sum(sum)) => obj.obj(
+            "sum",
+            Some(
+                Object::default()
+                    .list("dataPoints", sum.data_points.iter().map(number_point))
+                    .int("aggregationTemporality", sum.aggregation_temporality)
+                    .bool("isMonotonic", sum.is_monotonic)
+                    .done(),
+            ),
+        ),
+        Some(Data::Histogram(hist)) => obj.obj(
+            "histogram",
+            Some(
+                Object::default()
+                    .list("dataPoints", hist.data_points.iter().map(histogram_point))
+                    .int("aggregationTemporality", hist.aggregation_temporality)
+                    .done(),
+            ),
+        ),
+        Some(Data::ExponentialHistogram(hist)) => obj.obj(
+            "exponentialHistogram",
+            Some(
+                Object::default()
+                    .list(
+                        "dataPoints",
+                        hist.data_points.iter().map(exponential_histogram_point),
+                    )
+                    .int("aggregationTemporality", hist.aggregation_temporality)
+                    .done(),
+            ),
+        ),
+        Some(Data::Summary(summary)) => obj.obj(
+            "summary",
+            Some(
+                Object::default()
+                    .list("dataPoints", summary.data_points.iter().map(summary_point))
+                    .done(),
+            ),
+        ),
+        None => obj,
+    }
+    .done()
+}
+
+fn number_point(point: &NumberDataPoint) -> Value {
+    let obj = Object::default()
+        .list("attributes", attributes(&point.attributes))
+        .u64("startTimeUnixNano", point.start_time_unix_nano)
+        .u64("timeUnixNano", point.time_unix_nano)
+        .list("exemplars", point.exemplars.iter().map(exemplar))
+        .int("flags", point.flags);
+    match point.value {
+        Some(number_data_point::Value::AsDouble(v)) => obj.f64("asDouble", Some(v)),
+        Some(number_data_point::Value::AsInt(v)) => obj.i64("asInt", v),
+        None => obj,
+    }
+    .done()
+}
+
+fn histogram_point(point: &HistogramDataPoint) -> Value {
+    Object::default()
+        .list("attributes", attributes(&point.attributes))
+        .u64("startTimeUnixNano", point.start_time_unix_nano)
+        .u64("timeUnixNano", point.time_unix_nano)
+        .u64("count", point.count)
+        .f64("sum", point.sum)
+        .list(
+            "bucketCounts",
+            point
+                .bucket_counts
+                .iter()
+                .map(|c| Value::from(c.to_string())),
+        )
+        .list(
+            "explicitBounds",
+            point.explicit_bounds.iter().map(|b| float(*b)),
+        )
+        .list("exemplars", point.exemplars.iter().map(exemplar))
+        .int("flags", point.flags)
+        .f64("min", point.min)
+        .f64("max", point.max)
+        .done()
+}
+
+fn exponential_histogram_point(point: &ExponentialHistogramDataPoint) -> Value {
+    Object::default()
+        .list("attributes", attributes(&point.attributes))
+        .u64("startTimeUnixNano", point.start_time_unix_nano)
+        .u64("timeUnixNano", point.time_unix_nano)
+        .u64("count", point.count)
+        .f64("sum", point.sum)
+        .int("scale", point.scale)
+        .u64("zeroCount", point.zero_count)
+        .obj("positive", point.positive.as_ref().map(buckets))
+        .obj("negative", point.negative.as_ref().map(buckets))
+        .int("flags", point.flags)
+        .list("exemplars", point.exemplars.iter().map(exemplar))
+        .f64("min", point.min)
+        .f64("max", point.max)
+        .f64(
+            "zeroThreshold",
+            (point.zero_threshold != 0.0).then_some(point.zero_threshold),
+        )
+        .done()
+}
+
+fn buckets(buckets: &Buckets) -> Value {
+    Object::default()
+        .int("offset", buckets.offset)
+        .list(
+            "bucketCounts",
+            buckets
+                .bucket_counts
+                .iter()
+                .map(|c| Value::from(c.to_string())),
+        )
+        .done()
+}
+
+fn summary_point(point: &SummaryDataPoint) -> Value {
+    let quantiles = point.quantile_values.iter().map(|q| {
+        Object::default()
+            .f64("quantile", (q.quantile != 0.0).then_some(q.quantile))
+            .f64("value", (q.value != 0.0).then_some(q.value))
+            .done()
+    });
+    Object::default()
+        .list("attributes", attributes(&point.attributes))
+        .u64("startTimeUnixNano", point.start_time_unix_nano)
+        .u64("timeUnixNano", point.time_unix_nano)
+        .u64("count", point.count)
+        .f64("sum", (point.sum != 0.0).then_some(point.sum))
+        .list("quantileValues", quantiles)
+        .int("flags", point.flags)
+        .done()
+}
+
+fn exemplar(exemplar: &Exemplar) -> Value {
+    let obj = Object::default()
+        .list(
+            "filteredAttributes",
+            attributes(&exemplar.filtered_attributes),
+        )
+        .u64("timeUnixNano", exemplar.time_unix_nano)
+        .hex("spanId", &exemplar.span_id)
+        .hex("traceId", &exemplar.trace_id);
+    match exemplar.value {
+        Some(exemplar::Value::AsDouble(v)) => obj.f64("asDouble", Some(v)),
+        Some(exemplar::Value::AsInt(v)) => obj.i64("asInt", v),
+        None => obj,
+    }
+    .done()
+}
+
+fn attributes(attributes: &[KeyValue]) -> Vec<Value> {
+    attributes
+        .iter()
+        .map(|kv| {
+            Object::default()
+                .str("key", &kv.key)
+                .obj("value", kv.value.as_ref().map(any_value))
+                .done()
+        })
+        .collect()
+}
+
+fn any_value(value: &AnyValue) -> Value {
+    let obj = Object::default();
+    // the set member of the oneof is kept even with its default value
+    match value.value.as_ref() {
+        Some(any_value::Value::StringValue(v)) => obj.set("stringValue", v.as_str().into()),
+        Some(any_value::Value::BoolValue(v)) => obj.set("boolValue", (*v).into()),
+        Some(any_value::Value::IntValue(v)) => obj.set("intValue", v.to_string().into()),
+        Some(any_value::Value::DoubleValue(v)) => obj.set("doubleValue", float(*v)),
+        Some(any_value::Value::ArrayValue(v)) => obj.set(
+            "arrayValue",
+            Object::default()
+                .list("values", v.values.iter().map(any_value))
+                .done(),
+        ),
+        Some(any_value::Value::KvlistValue(v)) => obj.set(
+            "kvlistValue",
+            Object::default()
+                .list("values", attributes(&v.values))
+                .done(),
+        ),
+        Some(any_value::Value::BytesValue(v)) => obj.set(
+            "bytesValue",
+            base64::engine::general_purpose::STANDARD.encode(v).into(),
+        ),
+        None => obj,
+    }
+    .done()
+}
+
+/// Returns a double, the infinities and NaN as the strings of the mapping
+fn float(v: f64) -> Value {
+    if v.is_nan() {
+        "NaN".into()
+    } else if v == f64::INFINITY {
+        "Infinity".into()
+    } else if v == f64::NEG_INFINITY {
+        "-Infinity".into()
+    } else {
+        v.into()
+    }
+}
+
+/// Json object leaving out the fields with default values
+#[derive(Default)]
+struct Object(Map<String, Value>);
+
+impl Object {
+    fn set(mut self, key: &str, value: Value) -> Self {
+        self.0.insert(key.to_string(), value);
+        self
+    }
+
+    fn str(self, key: &str, value: &str) -> Self {
+        if value.is_empty() {
+            return self;
+        }
+        self.set(key, value.into())
+    }
+
+    fn int(self, key: &str, value: impl Into<i64>) -> Self {
+        match value.into() {
+            0 => self,
+            v => self.set(key, v.into()),
+        }
+    }
+
+    fn u64(self, key: &str, value: u64) -> Self {
+        if value == 0 {
+            return self;
+        }
+        self.set(key, value.to_string().into())
+    }
+
+    fn i64(self, key: &str, value: i64) -> Self {
+        self.set(key, value.to_string().into())
+    }
+
+    /// Sets an optional double, or a member of a oneof
+    fn f64(self, key: &str, value: Option<f64>) -> Self {
+        match value {
+            Some(v) => self.set(key, float(v)),
+            None => self,
+        }
+    }
+
+    fn bool(self, key: &str, value: bool) -> Self {
+        if !value {
+            return self;
+        }
+        self.set(key, value.into())
+    }
+
+    fn hex(self, key: &str, value: &[u8]) -> Self {
+        if value.is_empty() {
+            return self;
+        }
+        self.set(key, hex::encode(value).into())
+    }
+
+    fn obj(self, key: &str, value: Option<Value>) -> Self {
+        match value {
+            Some(v) => self.set(key, v),
+            None => self,
+        }
+    }
+
+    fn list(self, key: &str, values: impl IntoIterator<Item = Value>) -> Self {
+        let values = values.into_iter().collect::<Vec<_>>();
+        if values.is_empty() {
+            return self;
+        }
+        self.set(key, Value::Array(values))
+    }
+
+    fn done(self) -> Value {
+        Value::Object(self.0)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use opentelemetry_proto::tonic::{
+        common::v1::ArrayValue,
+        logs::v1::{ResourceLogs, ScopeLogs},
+        metrics::v1::{Gauge, ResourceMetrics, ScopeMetrics},
+    };
+
+    use super::*;
+
+    #[test]
+    fn test_any_value() {
+        let value = |v| AnyValue { value: Some(v) };
+        assert_eq!(
+            any_value(&value(any_value::Value::IntValue(i64::MAX))),
+            config::utils::json::json!({"intValue": "9223372036854775807"})
+        );
+        assert_eq!(
+            any_value(&value(any_value::Value::StringValue(String::new()))),
+            config::utils::json::json!({"stringValue": ""})
+        );
+        assert_eq!(
+            any_value(&value(any_value::Value::BytesValue(b"o2".to_vec()))),
+            config::utils::json::json!({"bytesValue": "bzI="})
+        );
+        assert_eq!(
+            any_value(&value(any_value::Value::ArrayValue(ArrayValue {
+                values: vec![value(any_value::Value::DoubleValue(f64::INFINITY))],
+            }))),
+            config::utils::json::json!({"arrayValue": {"values": [{"doubleValue": "Infinity"}]}})
+        );
+    }
+
+    #[test]
+    fn test_logs() {
+        let req = ExportLogsServiceRequest {
+            resource_logs: vec![ResourceLogs {
+                scope_logs: vec![ScopeLogs {
+                    log_records: vec![LogRecord {
+                        time_unix_nano: 1_700_000_000_000_000_000,
+                        severity_number: 9,
+                        trace_id: vec![1; 16],
+                        span_id: vec![],
+                        ..Default::default()
+                    }],
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }],
+        };
+        assert_eq!(
+            logs(&req),
+            config::utils::json::json!({"resourceLogs": [{"scopeLogs": [{"logRecords": [{
+                "timeUnixNano": "1700000000000000000",
+                "severityNumber": 9,
+                "traceId": "01010101010101010101010101010101",
+            }]}]}]})
+        );
+    }
+
+    #[test]
+    fn test_metrics() {
+        let req = ExportMetricsServiceRequest {
+            resource_metrics: vec![ResourceMetrics {
+                scope_metrics: vec![ScopeMetrics {
+                    metrics: vec![Metric {
+                        name: "up".to_string(),
+                        data: Some(Data::Gauge(Gauge {
+                            data_points: vec![NumberDataPoint {
+                                value: Some(number_data_point::Value::AsInt(0)),
+                                ..Default::default()
+                            }],
+                        })),
+                        ..Default::default()
+                    }],
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }],
+        };
+        assert_eq!(
+            metrics(&req),
+            config::utils::json::json!({"resourceMetrics": [{"scopeMetrics": [{"metrics": [{
+                "name": "up",
+                "gauge": {"dataPoints": [{"asInt": "0"}]},
+            }]}]}]})
+        );
+    }
+}
@@ -13,7 +13,11 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::io::Error;
+//! Folders of the dashboards, the saved queries and the reports. Folders can
+//! be nested, and the permissions of a folder apply to its subfolders which
+//! don't have their own.
+
+use std::{collections::HashMap, io::Error};
 
 use actix_web::{http, HttpResponse};
 use config::ider;
@@ -22,14 +26,90 @@ use crate::{
     common::{
         meta::{
             authz::Authz,
-            dashboards::{Folder, FolderList, DEFAULT_FOLDER},
+            dashboards::{
+                Folder, FolderAccess, FolderList, FolderPermission, DEFAULT_FOLDER,
+                MAX_FOLDER_DEPTH,
+            },
             http::HttpResponse as MetaHttpResponse,
         },
         utils::auth::{remove_ownership, set_ownership},
     },
-    service::db,
+    service::{db, users},
 };
 
+/// Access of a user to the folders of an organization
+pub struct FolderAccessResolver {
+    folders: HashMap<String, Folder>,
+    user_id: String,
+    is_admin: bool,
+}
+
+impl FolderAccessResolver {
+    pub async fn load(org_id: &str, user_id: &str) -> Self {
+        let folders = db::dashboards::folders::list(org_id)
+            .await
+            .unwrap_or_default()
+            .into_iter()
+            .map(|f| (f.folder_id.clone(), f))
+            .collect();
+        Self {
+            folders,
+            user_id: user_id.to_string(),
+            is_admin: users::is_admin(org_id, user_id).await,
+        }
+    }
+
+    /// Returns the access given by the nearest folder with permissions, none
+    /// when the user can't even view the folder
+    pub fn access(&self, folder_id: &str) -> Option<FolderAccess> {
+        if self.is_admin {
+            return Some(FolderAccess::Edit);
+        }
+        let mut id = folder_id;
+        for _ in 0..=MAX_FOLDER_DEPTH {
+            let Some(folder) = self.folders.get(id) else {
+                break;
+            };
+            if !folder.permissions.is_empty() {
+                return folder
+                    .permissions
+                    .iter()
+                    .filter(|p| p.user.eq_ignore_ascii_case(&self.user_id))
+                    .map(|p| p.access)
+                    .max();
+            }
+            if folder.parent_id.is_empty() {
+                break;
+            }
+            id = &folder.parent_id;
+        }
+        Some(FolderAccess::Edit)
+    }
+
+    pub fn allows(&self, folder_id: &str, access: FolderAccess) -> bool {
+        self.access(folder_id).is_some_and(|a| a >= access)
+    }
+}
+
+/// Returns a forbidden response when the user doesn't have the access to the
+/// folder
+pub async fn check_access(
+    org_id: &str,
+    folder_id: &str,
+    user_id: &str,
+    access: FolderAccess,
+) -> Option<HttpResponse> {
+    if FolderAccessResolver::load(org_id, user_id)
+        .await
+        .allows(folder_id, access)
+    {
+        return None;
+    }
+    Some(MetaHttpResponse::forbidden(format!(
+        "No {access} permission on the folder {folder_id}"
+    )))
+}
+
 #[tracing::instrument(skip(folder))]
 pub async fn save_folder(
     org_id: &str,
@@ -56,6 +136,17 @@ pub async fn save_folder(
     }
     if folder.folder_id != DEFAULT_FOLDER {
         folder.folder_id = ider::generate();
+    } else {
+        folder.parent_id.clear();
+    }
+    if let Err(e) = normalize_permissions(&mut folder.permissions) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if !folder.parent_id.is_empty() {
+        let folders = list_by_id(org_id).await;
+        if let Err(e) = check_parent(&folders, &folder.folder_id, &folder.parent_id) {
+            return Ok(MetaHttpResponse::bad_request(e));
+        }
     }
 
     match db::dashboards::folders::put(org_id, folder).await {
@@ -86,7 +177,13 @@ pub async fn update_folder(
             )),
         );
     }
+    let Ok(stored) = db::dashboards::folders::get(org_id, folder_id).await else {
+        return Ok(MetaHttpResponse::not_found("Dashboard folder not found"));
+    };
     folder.folder_id = folder_id.to_string();
+    // moved and shared with their own endpoints
+    folder.parent_id = stored.parent_id;
+    folder.permissions = stored.permissions;
 
     if let Err(error) = db::dashboards::folders::put(org_id, folder).await {
         return Ok(
@@ -103,12 +200,77 @@ pub async fn update_folder(
     )))
 }
 
+/// Moves the folder into another one, to the top level when `parent_id` is
+/// empty
+#[tracing::instrument]
+pub async fn move_folder(
+    org_id: &str,
+    folder_id: &str,
+    parent_id: &str,
+) -> Result<HttpResponse, Error> {
+    if folder_id.eq(DEFAULT_FOLDER) {
+        return Ok(MetaHttpResponse::bad_request(
+            "can't move default Dashboard folder",
+        ));
+    }
+    let folders = list_by_id(org_id).await;
+    let Some(mut folder) = folders.get(folder_id).cloned() else {
+        return Ok(MetaHttpResponse::not_found("Dashboard folder not found"));
+    };
+    if !parent_id.is_empty() {
+        if let Err(e) = check_parent(&folders, folder_id, parent_id) {
+            return Ok(MetaHttpResponse::bad_request(e));
+        }
+        if depth(&folders, parent_id) + 1 + height(&folders, folder_id) > MAX_FOLDER_DEPTH {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Folders can't be nested more than {MAX_FOLDER_DEPTH} levels"
+            )));
+        }
+    }
+    folder.parent_id = parent_id.to_string();
+    match db::dashboards::folders::put(org_id, folder).await {
+        Ok(folder) => Ok(MetaHttpResponse::json(folder)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Replaces the permissions of the folder, the ones of the parent apply when
+/// empty
+#[tracing::instrument(skip(permissions))]
+pub async fn set_folder_permissions(
+    org_id: &str,
+    folder_id: &str,
+    mut permissions: Vec<FolderPermission>,
+) -> Result<HttpResponse, Error> {
+    let Ok(mut folder) = db::dashboards::folders::get(org_id, folder_id).await else {
+        return Ok(MetaHttpResponse::not_found("Dashboard folder not found"));
+    };
+    if let Err(e) = normalize_permissions(&mut permissions) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    folder.permissions = permissions;
+    match db::dashboards::folders::put(org_id, folder).await {
+        Ok(folder) => Ok(MetaHttpResponse::json(folder)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Lists the folders the user can view, only the subfolders of `parent` when
+/// given, the top level folders when it is empty
 #[tracing::instrument()]
 pub async fn list_folders(
     org_id: &str,
+    user_id: &str,
+    parent: Option<&str>,
     permitted_folders: Option<Vec<String>>,
 ) -> Result<HttpResponse, Error> {
     if let Ok(folders) = db::dashboards::folders::list(org_id).await {
+        let resolver = FolderAccessResolver::load(org_id, user_id).await;
+        let folders = folders
+            .into_iter()
+            .filter(|f| parent.map_or(true, |parent| f.parent_id == parent))
+            .filter(|f| resolver.allows(&f.folder_id, FolderAccess::View))
+            .collect::<Vec<_>>();
         let filtered = match permitted_folders {
             Some(permitted_folders) => {
                 if permitted_folders.contains(&format!("{}:_all_{}", "dfolder", org_id)) {
@@ -155,6 +317,26 @@ pub async fn delete_folder(org_id: &str, folder_id: &str) -> Result<HttpResponse
                 .to_string(),
         )));
     }
+    if list_by_id(org_id)
+        .await
+        .values()
+        .any(|f| f.parent_id == folder_id)
+    {
+        return Ok(MetaHttpResponse::bad_request(
+            "Dashboard folder contains folders, please move/delete them first",
+        ));
+    }
+    let saved_queries = db::saved_query::list(org_id).await.unwrap_or_default();
+    let reports = db::dashboards::reports::list(org_id)
+        .await
+        .unwrap_or_default();
+    if saved_queries.iter().any(|q| q.folder_id == folder_id)
+        || reports.iter().any(|r| r.folder_id == folder_id)
+    {
+        return Ok(MetaHttpResponse::bad_request(
+            "Dashboard folder contains saved queries or reports, please move/delete them first",
+        ));
+    }
 
     if db::dashboards::folders::get(org_id, folder_id)
         .await
@@ -181,3 +363,186 @@ pub async fn delete_folder(org_id: &str, folder_id: &str) -> Result<HttpResponse
         ),
     }
 }
+
+async fn list_by_id(org_id: &str) -> HashMap<String, Folder> {
+    db::dashboards::folders::list(org_id)
+        .await
+        .unwrap_or_default()
+        .into_iter()
+        .map(|f| (f.folder_id.clone(), f))
+        .collect()
+}
+
+/// Checks the parent exists, is not the folder or one of its subfolders, and
+/// is not too deep
+fn check_parent(
+    folders: &HashMap<String, Folder>,
+    folder_id: &str,
+    parent_id: &str,
+) -> Result<(), String> {
+    let mut id = parent_id;
+    for _ in 0..MAX_FOLDER_DEPTH {
+        if id == folder_id {
+            return Err("A folder can't be moved into itself or its subfolders".to_string());
+        }
+        let Some(folder) = folders.get(id) else {
+            return Err(format!("Parent folder {id} not found"));
+        };
+        if folder.parent_id.is_empty() {
+            return Ok(());
+        }
+        id = &folder.parent_id;
+    }
+    Err(format!(
+        "Folders can't be nested more than {MAX_FOLDER_DEPTH} levels"
+    ))
+}
+
+/// Number of folders above the folder
+fn depth(folders: &HashMap<String, Folder>, folder_id: &str) -> usize {
+    let mut depth = 0;
+    let mut id = folder_id;
+    while let Some(folder) = folders.get(id) {
+        if folder.parent_id.is_empty() || depth > MAX_FOLDER_DEPTH {
+            break;
+        }
+        depth += 1;
+        id = &folder.parent_id;
+    }
+    depth
+}
+
+/// Number of levels of subfolders below the folder
+fn height(folders: &HashMap<String, Folder>, folder_id: &str) -> usize {
+    folders
+        .values()
+        .filter(|f| f.parent_id == folder_id && f.folder_id != folder_id)
+        .map(|f| 1 + height(folders, &f.folder_id))
+        .max()
+        .unwrap_or_default()
+        .min(MAX_FOLDER_DEPTH + 1)
+}
+
+/// Trims the users and keeps the highest access of each of them
+fn normalize_permissions(permissions: &mut Vec<FolderPermission>) -> Result<(), String> {
+    let mut by_user: HashMap<String, FolderAccess> = HashMap::new();
+    for p in permissions.iter() {
+        let user = p.user.trim().to_lowercase();
+        if user.is_empty() {
+            return Err("The user of a folder permission can't be empty".to_string());
+        }
+        let access = by_user.entry(user).or_default();
+        *access = (*access).max(p.access);
+    }
+    *permissions = by_user
+        .into_iter()
+        .map(|(user, access)| FolderPermission { user, access })
+        .collect();
+    permissions.sort_by(|a, b| a.user.cmp(&b.user));
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn folder(id: &str, parent_id: &str, permissions: &[(&str, FolderAccess)]) -> Folder {
+        Folder {
+            folder_id: id.to_string(),
+            name: id.to_string(),
+            parent_id: parent_id.to_string(),
+            permissions: permissions
+                .iter()
+                .map(|(user, access)| FolderPermission {
+                    user: user.to_string(),
+                    access: *access,
+                })
+                .collect(),
+            ..Default::default()
+        }
+    }
+
+    fn folders() -> HashMap<String, Folder> {
+        [
+            folder("ops", "", &[]),
+            folder("payments", "", &[("alice@example.com", FolderAccess::Edit)]),
+            folder("payments-prod", "payments", &[]),
+            folder(
+                "payments-shared",
+                "payments",
+                &[
+                    ("alice@example.com", FolderAccess::Edit),
+                    ("bob@example.com", FolderAccess::View),
+                ],
+            ),
+        ]
+        .into_iter()
+        .map(|f| (f.folder_id.clone(), f))
+        .collect()
+    }
+
+    fn resolver(user_id: &str) -> FolderAccessResolver {
+        FolderAccessResolver {
+            folders: folders(),
+            user_id: user_id.to_string(),
+            is_admin: false,
+        }
+    }
+
+    #[test]
+    fn test_access() {
+        let alice = resolver("Alice@example.com");
+        let bob = resolver("bob@example.com");
+        assert_eq!(alice.access("ops"), Some(FolderAccess::Edit));
+        assert_eq!(bob.access("ops"), Some(FolderAccess::Edit));
+        assert_eq!(alice.access("payments-prod"), Some(FolderAccess::Edit));
+        assert_eq!(bob.access("payments-prod"), None);
+        assert_eq!(bob.access("payments-shared"), Some(FolderAccess::View));
+        assert!(bob.allows("payments-shared", FolderAccess::View));
+        assert!(!bob.allows("payments-shared", FolderAccess::Edit));
+        let admin = FolderAccessResolver {
+            is_admin: true,
+            ..resolver("root@example.com")
+        };
+        assert!(admin.allows("payments-prod", FolderAccess::Edit));
+    }
+
+    #[test]
+    fn test_check_parent() {
+        let folders = folders();
+        assert!(check_parent(&folders, "ops", "payments-prod").is_ok());
+        assert!(check_parent(&folders, "payments", "payments-prod").is_err());
+        assert!(check_parent(&folders, "payments", "payments").is_err());
+        assert!(check_parent(&folders, "ops", "missing").is_err());
+        assert_eq!(depth(&folders, "payments-prod"), 1);
+        assert_eq!(height(&folders, "payments"), 1);
+        assert_eq!(height(&folders, "ops"), 0);
+    }
+
+    #[test]
+    fn test_normalize_permissions() {
+        let mut permissions = vec![
+            FolderPermission {
+                user: " Bob@example.com ".to_string(),
+                access: FolderAccess::View,
+            },
+            FolderPermission {
+                user: "bob@example.com".to_string(),
+                access: FolderAccess::Edit,
+            },
+        ];
+        normalize_permissions(&mut permissions).unwrap();
+        assert_eq!(
+            permissions,
+            vec![FolderPermission {
+                user: "bob@example.com".to_string(),
+                access: FolderAccess::Edit,
+            }]
+        );
+        let mut permissions = vec![FolderPermission {
+            user: " ".to_string(),
+            access: FolderAccess::View,
+        }];
+        assert!(normalize_permissions(&mut permissions).is_err());
+    }
+}
@@ -0,0 +1,938 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Import of Grafana dashboards. Graph, time series, stat, gauge, table, pie,
+//! heatmap and text panels are converted with their Prometheus targets as
+//! PromQL queries, the template variables as dashboard variables. Whatever
+//! can't be carried over is listed in the compatibility report of the import.
+
+use config::{
+    ider,
+    utils::json::{self, Value},
+};
+use once_cell::sync::Lazy;
+use regex::Regex;
+
+use crate::{
+    common::{
+        meta::{
+            authz::Authz,
+            dashboards::{
+                grafana::{GrafanaImport, GrafanaImportReport, ImportItem, ImportStatus},
+                Folder, DEFAULT_FOLDER,
+            },
+        },
+        utils::auth::set_ownership,
+    },
+    service::db::dashboards,
+};
+
+/// Grafana lays the panels out on 24 columns, we use 48
+const GRID_SCALE: i64 = 2;
+const DEFAULT_PANEL_WIDTH: i64 = 24;
+const DEFAULT_PANEL_HEIGHT: i64 = 9;
+
+/// `[[var]]` is the deprecated syntax of `$var`
+static RE_BRACKET_VARIABLE: Lazy<Regex> =
+    Lazy::new(|| Regex::new(r"\[\[(\w+)(?::\w+)?\]\]").unwrap());
+static RE_FORMAT_VARIABLE: Lazy<Regex> = Lazy::new(|| Regex::new(r"\$\{(\w+):(\w+)\}").unwrap());
+static RE_LEGEND_LABEL: Lazy<Regex> = Lazy::new(|| Regex::new(r"\{\{\s*(\w+)\s*\}\}").unwrap());
+static RE_LABEL_VALUES: Lazy<Regex> =
+    Lazy::new(|| Regex::new(r"^\s*label_values\(\s*(?:(.+?)\s*,\s*)?(\w+)\s*\)\s*$").unwrap());
+
+/// Formats of multi value variables we support in queries
+const VARIABLE_FORMATS: [&str; 4] = ["csv", "pipe", "doublequote", "singlequote"];
+
+/// Converts and saves Grafana dashboards into the folder. The body is a
+/// dashboard, as exported from Grafana or returned by its API, or a list of
+/// them. Nothing is saved on a dry run.
+pub async fn import(
+    org_id: &str,
+    folder_id: &str,
+    body: &[u8],
+    dry_run: bool,
+) -> Result<Vec<GrafanaImport>, anyhow::Error> {
+    let body: Value = json::from_slice(body)?;
+    let sources = match body {
+        Value::Array(list) => list,
+        body => vec![body],
+    };
+    let mut imports = Vec::with_capacity(sources.len());
+    for source in sources.iter() {
+        // the API of Grafana wraps the dashboard with its metadata
+        let source = source.get("dashboard").unwrap_or(source);
+        let (dashboard, report) = convert(source)?;
+        imports.push(GrafanaImport {
+            title: dashboard["title"].as_str().unwrap_or_default().to_string(),
+            dashboard_id: String::new(),
+            dashboard,
+            report,
+        });
+    }
+    if dry_run || imports.is_empty() {
+        return Ok(imports);
+    }
+
+    if dashboards::folders::get(org_id, folder_id).await.is_err() {
+        if folder_id != DEFAULT_FOLDER {
+            return Err(anyhow::anyhow!("folder not found"));
+        }
+        let folder = Folder {
+            folder_id: DEFAULT_FOLDER.to_string(),
+            name: DEFAULT_FOLDER.to_string(),
+            description: DEFAULT_FOLDER.to_string(),
+            ..Default::default()
+        };
+        super::folders::save_folder(org_id, folder, true).await?;
+    }
+    for import in imports.iter_mut() {
+        let dashboard_id = ider::generate();
+        let body = json::to_vec(&import.dashboard)?;
+        dashboards::put(org_id, &dashboard_id, folder_id, body.into()).await?;
+        set_ownership(
+            org_id,
+            "dashboards",
+            Authz {
+                obj_id: dashboard_id.clone(),
+                parent_type: "folders".to_owned(),
+                parent: folder_id.to_owned(),
+            },
+        )
+        .await;
+        import.dashboard["dashboardId"] = dashboard_id.clone().into();
+        import.dashboard_id = dashboard_id;
+    }
+    Ok(imports)
+}
+
+/// Converts a Grafana dashboard into the latest version of ours
+pub fn convert(source: &Value) -> Result<(Value, GrafanaImportReport), anyhow::Error> {
+    let title = str_field(source, "title").trim().to_string();
+    if title.is_empty() {
+        return Err(anyhow::anyhow!("Grafana dashboard should have title"));
+    }
+    let mut report = GrafanaImportReport::default();
+
+    let mut panels = Vec::new();
+    let mut layout = FlowLayout::default();
+    for (index, source) in collect_panels(source, &mut report).into_iter().enumerate() {
+        let (panel, item) = convert_panel(source, index, &mut layout);
+        if let Some(panel) = panel {
+            panels.push(panel);
+        }
+        report.panels.push(item);
+    }
+
+    let mut variables = Vec::new();
+    if let Some(list) = source
+        .pointer("/templating/list")
+        .and_then(|v| v.as_array())
+    {
+        for source in list.iter() {
+            let (variable, item) = convert_variable(source);
+            if let Some(variable) = variable {
+                variables.push(variable);
+            }
+            report.variables.push(item);
+        }
+    }
+
+    for item in report.panels.iter().chain(report.variables.iter()) {
+        match item.status {
+            ImportStatus::Converted => report.converted += 1,
+            ImportStatus::Partial => report.partial += 1,
+            ImportStatus::Unsupported => report.unsupported += 1,
+        }
+    }
+
+    let mut dashboard = json::json!({
+        "version": 4,
+        "title": title,
+        "description": str_field(source, "description"),
+        "tabs": [{
+            "tabId": "default",
+            "name": "Default",
+            "panels": panels,
+        }],
+        "variables": {
+            "list": variables,
+            "showDynamicFilters": false,
+        },
+    });
+    if let Some(duration) = convert_time_range(source, &mut report) {
+        dashboard["defaultDatetimeDuration"] = duration;
+    }
+    if source
+        .get("refresh")
+        .is_some_and(|v| v.as_str().is_some_and(|v| !v.is_empty()))
+    {
+        report
+            .notes
+            .push("the auto refresh interval is not imported".to_string());
+    }
+    if source
+        .pointer("/annotations/list")
+        .and_then(|v| v.as_array())
+        .is_some_and(|list| {
+            list.iter()
+                .any(|a| !a["builtIn"].as_i64().is_some_and(|v| v == 1))
+        })
+    {
+        report
+            .notes
+            .push("annotations are not imported".to_string());
+    }
+    if source
+        .get("links")
+        .and_then(|v| v.as_array())
+        .is_some_and(|list| !list.is_empty())
+    {
+        report
+            .notes
+            .push("dashboard links are not imported".to_string());
+    }
+    Ok((dashboard, report))
+}
+
+/// Returns the panels of the dashboard, the panels of the rows are flattened
+/// into a single tab
+fn collect_panels<'a>(source: &'a Value, report: &mut GrafanaImportReport) -> Vec<&'a Value> {
+    let mut panels = Vec::new();
+    let mut has_rows = false;
+    for panel in array_field(source, "panels") {
+        if str_field(panel, "type") == "row" {
+            has_rows = true;
+            // the panels of collapsed rows are nested in them
+            panels.extend(array_field(panel, "panels"));
+        } else {
+            panels.push(panel);
+        }
+    }
+    // dashboards older than schema 16 keep their panels in rows
+    for row in array_field(source, "rows") {
+        has_rows = true;
+        panels.extend(array_field(row, "panels"));
+    }
+    if has_rows {
+        report
+            .notes
+            .push("the panels of all rows are imported into a single tab".to_string());
+    }
+    panels
+}
+
+/// Places the panels without a grid position, as in the old row layout, one
+/// after the other
+#[derive(Debug, Default)]
+struct FlowLayout {
+    x: i64,
+    y: i64,
+    row_height: i64,
+}
+
+impl FlowLayout {
+    fn place(&mut self, source: &Value, index: usize) -> Value {
+        let i = index as i64 + 1;
+        if let Some(pos) = source.get("gridPos") {
+            let (x, y) = (int_field(pos, "x", 0), int_field(pos, "y", 0));
+            let (w, h) = (int_field(pos, "w", 12), int_field(pos, "h", 9));
+            self.y = self.y.max(y + h);
+            return json::json!({
+                "x": x * GRID_SCALE,
+                "y": y,
+                "w": w * GRID_SCALE,
+                "h": h,
+                "i": i,
+            });
+        }
+        // the span of old panels is out of 12 columns
+        let w = source
+            .get("span")
+            .and_then(|v| v.as_f64())
+            .map(|span| (span * 4.0).round() as i64)
+            .unwrap_or(DEFAULT_PANEL_WIDTH)
+            .clamp(1, 48);
+        if self.x + w > 48 {
+            self.x = 0;
+            self.y += self.row_height;
+            self.row_height = 0;
+        }
+        let layout = json::json!({
+            "x": self.x,
+            "y": self.y,
+            "w": w,
+            "h": DEFAULT_PANEL_HEIGHT,
+            "i": i,
+        });
+        self.x += w;
+        self.row_height = self.row_height.max(DEFAULT_PANEL_HEIGHT);
+        layout
+    }
+}
+
+fn convert_panel(
+    source: &Value,
+    index: usize,
+    layout: &mut FlowLayout,
+) -> (Option<Value>, ImportItem) {
+    let grafana_type = str_field(source, "type").to_string();
+    let mut item = ImportItem {
+        name: str_field(source, "title").to_string(),
+        grafana_type: grafana_type.clone(),
+        ..Default::default()
+    };
+    let Some(typ) = panel_type(source, &mut item) else {
+        item.status = ImportStatus::Unsupported;
+        item.notes
+            .push(format!("panel type {grafana_type} is not supported"));
+        return (None, item);
+    };
+    item.imported_type = Some(typ.to_string());
+
+    let mut panel = json::json!({
+        "id": format!("Panel_ID{}", index + 1),
+        "type": typ,
+        "title": item.name,
+        "description": str_field(source, "description"),
+        "config": panel_config(source, &mut item),
+        "queryType": "promql",
+        "queries": [],
+        "layout": layout.place(source, index),
+    });
+
+    if typ == "markdown" || typ == "html" {
+        // legacy text panels keep the content at the top level
+        let content = source
+            .pointer("/options/content")
+            .or_else(|| source.get("content"))
+            .and_then(|v| v.as_str())
+            .unwrap_or_default();
+        let field = if typ == "html" {
+            "htmlContent"
+        } else {
+            "markdownContent"
+        };
+        panel[field] = content.into();
+        panel["queryType"] = "".into();
+        panel["queries"] = json::json!([empty_query("")]);
+        return (Some(panel), item);
+    }
+
+    let queries = convert_targets(source, &mut item);
+    if queries.is_empty() {
+        item.status = ImportStatus::Unsupported;
+        item.imported_type = None;
+        item.notes
+            .push("the panel has no Prometheus query".to_string());
+        return (None, item);
+    }
+    panel["queries"] = queries.into();
+    for (field, note) in [
+        ("transformations", "transformations are not imported"),
+        ("links", "panel links are not imported"),
+        (
+            "alert",
+            "legacy panel alerts are not imported, create an alert",
+        ),
+        ("repeat", "repeated panels are imported once"),
+    ] {
+        let set = match source.get(field) {
+            Some(Value::Array(list)) => !list.is_empty(),
+            Some(Value::String(s)) => !s.is_empty(),
+            Some(Value::Object(_)) => true,
+            _ => false,
+        };
+        if set {
+            item.note(note);
+        }
+    }
+    if source
+        .pointer("/fieldConfig/overrides")
+        .and_then(|v| v.as_array())
+        .is_some_and(|list| !list.is_empty())
+    {
+        item.note("field overrides are not imported");
+    }
+    (Some(panel), item)
+}
+
+/// Returns our type of the panel
+fn panel_type(source: &Value, item: &mut ImportItem) -> Option<&'static str> {
+    let typ = match str_field(source, "type") {
+        "timeseries" => {
+            let custom = source.pointer("/fieldConfig/defaults/custom");
+            let bars = custom.is_some_and(|c| str_field(c, "drawStyle") == "bars");
+            let stacked = custom.is_some_and(|c| {
+                c.pointer("/stacking/mode")
+                    .and_then(|v| v.as_str())
+                    .is_some_and(|mode| mode != "none")
+            });
+            let filled = custom.is_some_and(|c| int_field(c, "fillOpacity", 0) > 0);
+            series_type(bars, stacked, filled)
+        }
+        "graph" => {
+            let bars = source["bars"].as_bool().unwrap_or_default();
+            let stacked = source["stack"].as_bool().unwrap_or_default();
+            let filled = int_field(source, "fill", 0) > 0;
+            if source["yaxes"]
+                .as_array()
+                .is_some_and(|axes| axes.iter().any(|a| a["logBase"].as_i64().unwrap_or(1) > 1))
+            {
+                item.note("logarithmic axes are shown linear");
+            }
+            series_type(bars, stacked, filled)
+        }
+        "stat" | "singlestat" => {
+            if let Some(calcs) = source
+                .pointer("/options/reduceOptions/calcs")
+                .and_then(|v| v.as_array())
+            {
+                let calc = calcs.first().and_then(|v| v.as_str()).unwrap_or("last");
+                if calc != "last" && calc != "lastNotNull" {
+                    item.note(format!(
+                        "the reduction {calc} is replaced by the last value"
+                    ));
+                }
+            }
+            "metric"
+        }
+        "gauge" => "gauge",
+        "bargauge" => {
+            item.note("the bar gauge is imported as a horizontal bar chart");
+            "h-bar"
+        }
+        "table" | "table-old" => "table",
+        "piechart" => {
+            if source.pointer("/options/pieType").and_then(|v| v.as_str()) == Some("donut") {
+                "donut"
+            } else {
+                "pie"
+            }
+        }
+        "heatmap" => "heatmap",
+        "text" => {
+            let mode = source
+                .pointer("/options/mode")
+                .or_else(|| source.get("mode"))
+                .and_then(|v| v.as_str());
+            if mode == Some("html") {
+                "html"
+            } else {
+                "markdown"
+            }
+        }
+        _ => return None,
+    };
+    Some(typ)
+}
+
+fn series_type(bars: bool, stacked: bool, filled: bool) -> &'static str {
+    match (bars, stacked, filled) {
+        (true, true, _) => "stacked",
+        (true, false, _) => "bar",
+        (false, true, _) => "area-stacked",
+        (false, false, true) => "area",
+        (false, false, false) => "line",
+    }
+}
+
+fn panel_config(source: &Value, item: &mut ImportItem) -> Value {
+    // the legend of time series panels is in the options, of graphs at the top
+    let legend = source
+        .pointer("/options/legend")
+        .or_else(|| source.get("legend"));
+    let show_legends = legend
+        .and_then(|l| l.get("showLegend").or_else(|| l.get("show")))
+        .and_then(|v| v.as_bool())
+        .unwrap_or(true);
+    let legends_position = legend.and_then(|l| {
+        if l["placement"].as_str() == Some("right") || l["rightSide"].as_bool() == Some(true) {
+            Some("right")
+        } else {
+            None
+        }
+    });
+    let mut config = json::json!({
+        "show_legends": show_legends,
+        "legends_position": legends_position,
+    });
+
+    let defaults = source.pointer("/fieldConfig/defaults");
+    let unit = defaults
+        .and_then(|d| d.get("unit"))
+        .or_else(|| source.get("format"))
+        .and_then(|v| v.as_str())
+        .unwrap_or_default();
+    match convert_unit(unit) {
+        Some((unit, custom)) => {
+            config["unit"] = unit.into();
+            if let Some(custom) = custom {
+                config["unit_custom"] = custom.into();
+            }
+        }
+        None => item.note(format!("the unit {unit} is not supported")),
+    }
+    if let Some(decimals) = defaults
+        .and_then(|d| d.get("decimals"))
+        .or_else(|| source.get("decimals"))
+        .and_then(|v| v.as_f64())
+    {
+        config["decimals"] = decimals.into();
+    }
+    if defaults
+        .and_then(|d| d.pointer("/thresholds/steps"))
+        .and_then(|v| v.as_array())
+        .is_some_and(|steps| steps.len() > 1)
+    {
+        item.note("thresholds are not imported");
+    }
+    if defaults
+        .and_then(|d| d.get("mappings"))
+        .and_then(|v| v.as_array())
+        .is_some_and(|list| !list.is_empty())
+    {
+        item.note("value mappings are not imported");
+    }
+    if defaults
+        .and_then(|d| d.pointer("/custom/spanNulls"))
+        .and_then(|v| v.as_bool())
+        .unwrap_or_default()
+        || str_field(source, "nullPointMode") == "connected"
+    {
+        config["connect_nulls"] = true.into();
+    }
+    config
+}
+
+/// Returns our unit and custom unit of a Grafana unit, None when it has no
+/// equivalent
+fn convert_unit(unit: &str) -> Option<(&'static str, Option<String>)> {
+    let unit = match unit {
+        "" | "short" | "none" | "locale" => "default",
+        "bytes" | "decbytes" => "bytes",
+        "kbytes" | "deckbytes" => "kilobytes",
+        "mbytes" | "decmbytes" => "megabytes",
+        "bps" | "binBps" | "Bps" => "bps",
+        "s" => "seconds",
+        "ms" => "milliseconds",
+        "µs" | "us" => "microseconds",
+        "ns" => "nanoseconds",
+        "percent" => "percent",
+        "percentunit" => "percent-1",
+        "reqps" => return Some(("custom", Some("req/s".to_string()))),
+        "ops" => return Some(("custom", Some("ops/s".to_string()))),
+        unit => {
+            // free text units
+            return unit
+                .strip_prefix("suffix:")
+                .map(|suffix| ("custom", Some(suffix.to_string())));
+        }
+    };
+    Some((unit, None))
+}
+
+fn convert_targets(source: &Value, item: &mut ImportItem) -> Vec<Value> {
+    let panel_datasource = datasource_type(source.get("datasource"));
+    let mut queries = Vec::new();
+    for target in array_field(source, "targets") {
+        if target["hide"].as_bool().unwrap_or_default() {
+            continue;
+        }
+        let datasource =
+            datasource_type(target.get("datasource")).or_else(|| panel_datasource.clone());
+        if datasource.as_deref().is_some_and(|ds| ds != "prometheus") {
+            item.note(format!(
+                "the query of {} {} is not supported",
+                datasource.unwrap_or_default(),
+                str_field(target, "refId")
+            ));
+            continue;
+        }
+        let expr = str_field(target, "expr").trim();
+        if expr.is_empty() {
+            continue;
+        }
+        let mut query = empty_query(&convert_expr(expr, item));
+        query["config"]["promql_legend"] = convert_legend(str_field(target, "legendFormat")).into();
+        queries.push(query);
+    }
+    queries
+}
+
+/// Returns the type of a data source, the old dashboards only reference them by
+/// name
+fn datasource_type(datasource: Option<&Value>) -> Option<String> {
+    match datasource? {
+        Value::Object(ds) => ds
+            .get("type")
+            .and_then(|v| v.as_str())
+            .filter(|typ| !typ.is_empty() && *typ != "datasource")
+            .map(|typ| typ.to_string()),
+        _ => None,
+    }
+}
+
+fn empty_query(query: &str) -> Value {
+    json::json!({
+        "query": query,
+        "customQuery": true,
+        "fields": {
+            "stream": "",
+            "stream_type": "metrics",
+            "x": [],
+            "y": [],
+            "filter": [],
+        },
+        "config": {
+            "promql_legend": "",
+        },
+    })
+}
+
+/// Rewrites the variables of a query into our syntax
+fn convert_expr(expr: &str, item: &mut ImportItem) -> String {
+    let expr = RE_BRACKET_VARIABLE.replace_all(expr, "$${$1}");
+    let mut dropped = Vec::new();
+    let expr = RE_FORMAT_VARIABLE.replace_all(&expr, |caps: &regex::Captures| {
+        if VARIABLE_FORMATS.contains(&&caps[2]) {
+            caps[0].to_string()
+        } else {
+            dropped.push(caps[2].to_string());
+            format!("${{{}}}", &caps[1])
+        }
+    });
+    let expr = expr.to_string();
+    for format in dropped {
+        item.note(format!("the variable format {format} is not supported"));
+    }
+    expr
+}
+
+/// Grafana names the series with `{{label}}`, we use `{label}`
+fn convert_legend(legend: &str) -> String {
+    if legend == "__auto" {
+        return String::new();
+    }
+    RE_LEGEND_LABEL.replace_all(legend, "{$1}").to_string()
+}
+
+fn convert_variable(source: &Value) -> (Option<Value>, ImportItem) {
+    let name = str_field(source, "name").to_string();
+    let grafana_type = str_field(source, "type").to_string();
+    let mut item = ImportItem {
+        name: name.clone(),
+        grafana_type: grafana_type.clone(),
+        ..Default::default()
+    };
+    let label = match str_field(source, "label") {
+        "" => name.clone(),
+        label => label.to_string(),
+    };
+    let current = current_value(source);
+    let multi_select = source["multi"].as_bool().unwrap_or_default();
+    // the query is an object from Grafana 8
+    let query = source
+        .get("query")
+        .and_then(|q| {
+            q.as_str()
+                .or_else(|| q.get("query").and_then(|v| v.as_str()))
+        })
+        .unwrap_or_default();
+
+    let mut variable = json::json!({
+        "type": "",
+        "name": name,
+        "label": label,
+        "query_data": null,
+        "value": current,
+        "options": null,
+    });
+    match grafana_type.as_str() {
+        "query" => {
+            let Some(caps) = RE_LABEL_VALUES.captures(query) else {
+                item.status = ImportStatus::Unsupported;
+                item.notes
+                    .push(format!("the variable query {query} is not supported"));
+                return (None, item);
+            };
+            let Some(selector) = caps.get(1).map(|m| m.as_str()) else {
+                item.status = ImportStatus::Unsupported;
+                item.notes
+                    .push("label_values without a metric is not supported".to_string());
+                return (None, item);
+            };
+            let (metric, filters) = match selector.split_once('{') {
+                Some((metric, filters)) => (metric.trim(), filters.trim_end_matches('}').trim()),
+                None => (selector.trim(), ""),
+            };
+            if metric.is_empty() {
+                item.status = ImportStatus::Unsupported;
+                item.notes
+                    .push("label_values without a metric is not supported".to_string());
+                return (None, item);
+            }
+            if !filters.is_empty() {
+                item.note(format!("the label filters {{{filters}}} are not imported"));
+            }
+            if !str_field(source, "regex").is_empty() {
+                item.note("the regex of the values is not imported");
+            }
+            variable["type"] = "query_values".into();
+            variable["query_data"] = json::json!({
+                "stream_type": "metrics",
+                "stream": metric,
+                "field": &caps[2],
+                "max_record_size": null,
+            });
+            variable["multiSelect"] = multi_select.into();
+        }
+        "custom" | "interval" => {
+            let options = query
+                .split(',')
+                .map(|v| v.trim())
+                .filter(|v| !v.is_empty())
+                .map(|v| {
+                    // custom values may be labelled as `label : value`
+                    let (label, value) = v.split_once(" : ").unwrap_or((v, v));
+                    json::json!({"label": label.trim(), "value": value.trim()})
+                })
+                .collect::<Vec<_>>();
+            if grafana_type == "interval" && source["auto"].as_bool().unwrap_or_default() {
+                item.note("the auto interval is not supported");
+            }
+            if variable["value"].is_null() {
+                variable["value"] = options
+                    .first()
+                    .map(|o| o["value"].clone())
+                    .unwrap_or_default();
+            }
+            variable["type"] = "custom".into();
+            variable["options"] = options.into();
+            variable["multiSelect"] = multi_select.into();
+        }
+        "constant" | "textbox" => {
+            variable["type"] = grafana_type.clone().into();
+            if variable["value"].is_null() {
+                variable["value"] = query.into();
+            }
+        }
+        _ => {
+            item.status = ImportStatus::Unsupported;
+            item.notes
+                .push(format!("variable type {grafana_type} is not supported"));
+            return (None, item);
+        }
+    }
+    if source["includeAll"].as_bool().unwrap_or_default() {
+        item.note("the All option is not supported");
+    }
+    item.imported_type = variable["type"].as_str().map(|v| v.to_string());
+    (Some(variable), item)
+}
+
+/// Returns the selected value of a variable, multiple values are comma
+/// separated
+fn current_value(source: &Value) -> Value {
+    match source.pointer("/current/value") {
+        Some(Value::String(v)) if !v.is_empty() && v != "$__all" => v.as_str().into(),
+        Some(Value::Array(list)) => {
+            let values = list
+                .iter()
+                .filter_map(|v| v.as_str())
+                .filter(|v| *v != "$__all")
+                .collect::<Vec<_>>();
+            if values.is_empty() {
+                Value::Null
+            } else {
+                values.join(",").into()
+            }
+        }
+        _ => Value::Null,
+    }
+}
+
+/// Converts the time range of the dashboard, `now-6h` to `now` being relative
+fn convert_time_range(source: &Value, report: &mut GrafanaImportReport) -> Option<Value> {
+    let time = source.get("time")?;
+    let (from, to) = (str_field(time, "from"), str_field(time, "to"));
+    if to == "now" {
+        if let Some(period) = from.strip_prefix("now-") {
+            // relative ranges are rounded in Grafana with a trailing /d
+            if !period.contains('/') {
+                return Some(json::json!({
+                    "type": "relative",
+                    "relativeTimePeriod": period,
+                }));
+            }
+        }
+    }
+    let start = chrono::DateTime::parse_from_rfc3339(from).ok();
+    let end = chrono::DateTime::parse_from_rfc3339(to).ok();
+    if let (Some(start), Some(end)) = (start, end) {
+        return Some(json::json!({
+            "type": "absolute",
+            "startTime": start.timestamp_micros(),
+            "endTime": end.timestamp_micros(),
+        }));
+    }
+    report
+        .notes
+        .push(format!("the time range {from} to {to} is not supported"));
+    None
+}
+
+fn str_field<'a>(value: &'a Value, field: &str) -> &'a str {
+    value
+        .get(field)
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+}
+
+fn int_field(value: &Value, field: &str, default: i64) -> i64 {
+    value
+        .get(field)
+        .and_then(|v| v.as_f64())
+        .map(|v| v as i64)
+        .unwrap_or(default)
+}
+
+fn array_field<'a>(value: &'a Value, field: &str) -> &'a [Value] {
+    value
+        .get(field)
+        .and_then(|v| v.as_array())
+        .map(|list| list.as_slice())
+        .unwrap_or_default()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::common::meta::dashboards::v4;
+
+    fn grafana_dashboard() -> Value {
+        json::json!({
+            "title": "Node Exporter",
+            "time": {"from": "now-6h", "to": "now"},
+            "templating": {"list": [
+                {"name": "instance", "type": "query", "multi": true,
+                 "query": {"query": "label_values(node_uname_info{job=\"node\"}, instance)"},
+                 "current": {"value": ["a:9100", "b:9100"]}},
+                {"name": "ds", "type": "datasource", "query": "prometheus"},
+                {"name": "step", "type": "custom", "query": "1m,5m,1h"}
+            ]},
+            "panels": [
+                {"type": "timeseries", "title": "CPU", "gridPos": {"x": 0, "y": 0, "w": 12, "h": 8},
+                 "datasource": {"type": "prometheus", "uid": "prom"},
+                 "fieldConfig": {"defaults": {"unit": "percentunit",
+                    "custom": {"drawStyle": "line", "fillOpacity": 10}}, "overrides": []},
+                 "targets": [{"expr": "rate(node_cpu_seconds_total{instance=~\"[[instance]]\"}[5m])",
+                              "legendFormat": "{{ cpu }} {{mode}}", "refId": "A"}]},
+                {"type": "row", "title": "Disk", "collapsed": true, "panels": [
+                    {"type": "stat", "title": "Uptime", "gridPos": {"x": 12, "y": 9, "w": 12, "h": 4},
+                     "options": {"reduceOptions": {"calcs": ["mean"]}},
+                     "targets": [{"expr": "node_time_seconds - node_boot_time_seconds"}]}
+                ]},
+                {"type": "logs", "title": "Logs", "gridPos": {"x": 0, "y": 20, "w": 24, "h": 8}},
+                {"type": "graph", "title": "Loki", "gridPos": {"x": 0, "y": 28, "w": 24, "h": 8},
+                 "datasource": {"type": "loki"}, "targets": [{"expr": "{job=\"x\"}", "refId": "A"}]}
+            ]
+        })
+    }
+
+    #[test]
+    fn test_convert() {
+        let (dashboard, report) = convert(&grafana_dashboard()).unwrap();
+        // the result is a valid dashboard
+        let parsed: v4::Dashboard = json::from_value(dashboard.clone()).unwrap();
+        assert_eq!(parsed.title, "Node Exporter");
+        let panels = &parsed.tabs[0].panels;
+        assert_eq!(panels.len(), 2);
+        assert_eq!(panels[0].typ, "area");
+        assert_eq!(
+            panels[0].queries[0].query.as_deref(),
+            Some("rate(node_cpu_seconds_total{instance=~\"${instance}\"}[5m])")
+        );
+        assert_eq!(panels[0].layout.w, 24);
+        assert_eq!(panels[1].typ, "metric");
+        assert_eq!(panels[1].layout.x, 24);
+        assert_eq!(
+            dashboard["tabs"][0]["panels"][0]["queries"][0]["config"]["promql_legend"],
+            "{cpu} {mode}"
+        );
+        assert_eq!(
+            dashboard["tabs"][0]["panels"][0]["config"]["unit"],
+            "percent-1"
+        );
+        assert_eq!(
+            dashboard["defaultDatetimeDuration"]["relativeTimePeriod"],
+            "6h"
+        );
+
+        let variables = parsed.variables.unwrap().list;
+        assert_eq!(variables.len(), 2);
+        assert_eq!(variables[0].type_field, "query_values");
+        assert_eq!(
+            variables[0].query_data.as_ref().unwrap().stream,
+            "node_uname_info"
+        );
+        assert_eq!(variables[0].query_data.as_ref().unwrap().field, "instance");
+        assert_eq!(variables[0].value.as_deref(), Some("a:9100,b:9100"));
+        assert_eq!(variables[1].options.as_ref().unwrap().len(), 3);
+        assert_eq!(variables[1].value.as_deref(), Some("1m"));
+
+        assert_eq!(report.panels.len(), 4);
+        assert_eq!(report.panels[0].status, ImportStatus::Converted);
+        assert_eq!(report.panels[1].status, ImportStatus::Partial);
+        assert_eq!(report.panels[2].status, ImportStatus::Unsupported);
+        assert_eq!(report.panels[3].status, ImportStatus::Unsupported);
+        assert_eq!(report.variables[0].status, ImportStatus::Partial);
+        assert_eq!(report.variables[1].status, ImportStatus::Unsupported);
+        assert_eq!(
+            (report.converted, report.partial, report.unsupported),
+            (2, 2, 3)
+        );
+    }
+
+    #[test]
+    fn test_convert_without_title() {
+        assert!(convert(&json::json!({"panels": []})).is_err());
+    }
+
+    #[test]
+    fn test_flow_layout() {
+        let mut layout = FlowLayout::default();
+        let a = layout.place(&json::json!({"span": 6}), 0);
+        let b = layout.place(&json::json!({"span": 6}), 1);
+        let c = layout.place(&json::json!({"span": 12}), 2);
+        assert_eq!((a["x"].as_i64(), a["w"].as_i64()), (Some(0), Some(24)));
+        assert_eq!((b["x"].as_i64(), b["y"].as_i64()), (Some(24), Some(0)));
+        assert_eq!((c["x"].as_i64(), c["y"].as_i64()), (Some(0), Some(9)));
+    }
+
+    #[test]
+    fn test_convert_expr() {
+        let mut item = ImportItem::default();
+        assert_eq!(
+            convert_expr("up{job=~\"${job:pipe}\",env=\"${env:regex}\"}", &mut item),
+            "up{job=~\"${job:pipe}\",env=\"${env}\"}"
+        );
+        assert_eq!(item.status, ImportStatus::Partial);
+        assert_eq!(
+            convert_unit("suffix:rpm"),
+            Some(("custom", Some("rpm".to_string())))
+        );
+        assert_eq!(convert_unit("currencyUSD"), None);
+    }
+}
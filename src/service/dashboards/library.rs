@@ -0,0 +1,286 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{http, HttpResponse};
+use config::ider;
+
+use crate::{
+    common::meta::{
+        dashboards::{
+            datetime_now,
+            library::{LibraryPanel, LibraryPanelList, LibraryPanelUsage, LibraryPanelUsageList},
+            v4, Dashboard,
+        },
+        http::HttpResponse as MetaHttpResponse,
+    },
+    service::db,
+};
+
+#[tracing::instrument(skip(panel))]
+pub async fn save_library_panel(
+    org_id: &str,
+    panel_id: Option<&str>,
+    mut panel: LibraryPanel,
+    user_email: &str,
+) -> Result<HttpResponse, Error> {
+    panel.name = panel.name.trim().to_string();
+    if panel.name.is_empty() {
+        return Ok(MetaHttpResponse::bad_request(
+            "library panel name not allow empty",
+        ));
+    }
+    match panel_id {
+        Some(panel_id) => {
+            let Ok(existing) = db::dashboards::library::get(org_id, panel_id).await else {
+                return Ok(MetaHttpResponse::not_found("Library panel not found"));
+            };
+            panel.panel_id = existing.panel_id;
+            panel.owner = existing.owner;
+            panel.created = existing.created;
+        }
+        None => {
+            panel.panel_id = ider::generate();
+            panel.owner = user_email.to_string();
+            panel.created = datetime_now();
+        }
+    }
+    panel.updated = datetime_now();
+    panel.usage_count = 0;
+    // the panel is the definition, a library panel doesn't reference another
+    panel.panel.library_panel_id = None;
+
+    match db::dashboards::library::put(org_id, &panel).await {
+        Ok(_) => Ok(MetaHttpResponse::json(panel)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_library_panels(org_id: &str) -> Result<HttpResponse, Error> {
+    let mut list = match db::dashboards::library::list(org_id).await {
+        Ok(list) => list,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    let mut counts: HashMap<String, usize> = HashMap::new();
+    if let Ok(dashboards) = db::dashboards::list_v4(org_id).await {
+        for (_, dashboard) in dashboards.iter() {
+            for panel_id in referenced_panels(dashboard) {
+                *counts.entry(panel_id.to_string()).or_default() += 1;
+            }
+        }
+    }
+    for panel in list.iter_mut() {
+        panel.usage_count = counts.get(&panel.panel_id).copied().unwrap_or_default();
+    }
+    list.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(MetaHttpResponse::json(LibraryPanelList { list }))
+}
+
+#[tracing::instrument]
+pub async fn get_library_panel(org_id: &str, panel_id: &str) -> Result<HttpResponse, Error> {
+    match db::dashboards::library::get(org_id, panel_id).await {
+        Ok(panel) => Ok(MetaHttpResponse::json(panel)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Library panel not found")),
+    }
+}
+
+/// Lists the dashboards showing the library panel
+#[tracing::instrument]
+pub async fn get_library_panel_usage(org_id: &str, panel_id: &str) -> Result<HttpResponse, Error> {
+    if db::dashboards::library::get(org_id, panel_id)
+        .await
+        .is_err()
+    {
+        return Ok(MetaHttpResponse::not_found("Library panel not found"));
+    }
+    match usage(org_id, panel_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(LibraryPanelUsageList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Deletes the library panel. A panel in use is only deleted when forced, the
+/// dashboards then keep showing the last version they saved.
+#[tracing::instrument]
+pub async fn delete_library_panel(
+    org_id: &str,
+    panel_id: &str,
+    force: bool,
+) -> Result<HttpResponse, Error> {
+    if db::dashboards::library::get(org_id, panel_id)
+        .await
+        .is_err()
+    {
+        return Ok(MetaHttpResponse::not_found("Library panel not found"));
+    }
+    if !force {
+        let used_by = match usage(org_id, panel_id).await {
+            Ok(used_by) => used_by,
+            Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+        };
+        if !used_by.is_empty() {
+            return Ok(HttpResponse::Conflict().json(MetaHttpResponse::error(
+                http::StatusCode::CONFLICT.into(),
+                format!(
+                    "Library panel is used by {} dashboards, delete it with force=true to keep \
+                     their last version",
+                    used_by.len()
+                ),
+            )));
+        }
+    }
+    match db::dashboards::library::delete(org_id, panel_id).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Library panel deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+async fn usage(org_id: &str, panel_id: &str) -> Result<Vec<LibraryPanelUsage>, anyhow::Error> {
+    let mut used_by = Vec::new();
+    for (folder_id, dashboard) in db::dashboards::list_v4(org_id).await? {
+        let panel_ids = dashboard
+            .tabs
+            .iter()
+            .flat_map(|tab| tab.panels.iter())
+            .filter(|panel| panel.library_panel_id.as_deref() == Some(panel_id))
+            .map(|panel| panel.id.clone())
+            .collect::<Vec<_>>();
+        if !panel_ids.is_empty() {
+            used_by.push(LibraryPanelUsage {
+                dashboard_id: dashboard.dashboard_id,
+                folder_id,
+                title: dashboard.title,
+                panel_ids,
+            });
+        }
+    }
+    used_by.sort_by(|a, b| a.title.cmp(&b.title));
+    Ok(used_by)
+}
+
+fn referenced_panels(dashboard: &v4::Dashboard) -> impl Iterator<Item = &str> {
+    dashboard
+        .tabs
+        .iter()
+        .flat_map(|tab| tab.panels.iter())
+        .filter_map(|panel| panel.library_panel_id.as_deref())
+}
+
+/// Replaces the panels referencing library panels with their current
+/// definition
+pub async fn resolve(org_id: &str, dashboards: &mut [Dashboard]) {
+    let referenced = dashboards
+        .iter()
+        .filter_map(|dashboard| dashboard.v4.as_ref())
+        .any(|dashboard| referenced_panels(dashboard).next().is_some());
+    if !referenced {
+        return;
+    }
+    let library = match db::dashboards::library::list(org_id).await {
+        Ok(list) => list
+            .into_iter()
+            .map(|panel| (panel.panel_id, panel.panel))
+            .collect::<HashMap<_, _>>(),
+        Err(e) => {
+            log::error!("Error loading the library panels of {org_id}: {e}");
+            return;
+        }
+    };
+    for dashboard in dashboards.iter_mut() {
+        if let Some(dashboard) = dashboard.v4.as_mut() {
+            apply(dashboard, &library);
+        }
+    }
+}
+
+/// A panel whose library panel was deleted keeps its last definition
+fn apply(dashboard: &mut v4::Dashboard, library: &HashMap<String, v4::Panel>) {
+    for panel in dashboard
+        .tabs
+        .iter_mut()
+        .flat_map(|tab| tab.panels.iter_mut())
+    {
+        let Some(definition) = panel
+            .library_panel_id
+            .as_ref()
+            .and_then(|panel_id| library.get(panel_id))
+        else {
+            continue;
+        };
+        *panel = v4::Panel {
+            id: std::mem::take(&mut panel.id),
+            layout: panel.layout.clone(),
+            library_panel_id: panel.library_panel_id.take(),
+            ..definition.clone()
+        };
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    fn panel(id: &str, title: &str, library_panel_id: Option<&str>) -> v4::Panel {
+        json::from_value(json::json!({
+            "id": id,
+            "type": "line",
+            "title": title,
+            "description": "",
+            "config": {"show_legends": true, "legends_position": null},
+            "queries": [],
+            "layout": {"x": 0, "y": 0, "w": 24, "h": 9, "i": 1},
+            "libraryPanelId": library_panel_id,
+        }))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_apply() {
+        let mut dashboard: v4::Dashboard = json::from_value(json::json!({
+            "version": 4,
+            "title": "Service",
+            "description": "",
+            "tabs": [{"tabId": "default", "name": "Default", "panels": []}],
+        }))
+        .unwrap();
+        dashboard.tabs[0].panels = vec![
+            panel("Panel_ID1", "old error rate", Some("errors")),
+            panel("Panel_ID2", "deleted", Some("gone")),
+            panel("Panel_ID3", "local", None),
+        ];
+        let mut definition = panel("Panel_ID9", "Error rate", None);
+        definition.typ = "bar".to_string();
+        definition.layout.w = 12;
+        let library = HashMap::from([("errors".to_string(), definition)]);
+        assert_eq!(
+            referenced_panels(&dashboard).collect::<Vec<_>>(),
+            ["errors", "gone"]
+        );
+
+        apply(&mut dashboard, &library);
+        let panels = &dashboard.tabs[0].panels;
+        assert_eq!(panels[0].id, "Panel_ID1");
+        assert_eq!(panels[0].title, "Error rate");
+        assert_eq!(panels[0].typ, "bar");
+        assert_eq!(panels[0].layout.w, 24);
+        assert_eq!(panels[0].library_panel_id.as_deref(), Some("errors"));
+        assert_eq!(panels[1].title, "deleted");
+        assert_eq!(panels[2].title, "local");
+    }
+}
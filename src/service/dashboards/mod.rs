@@ -22,15 +22,19 @@ use crate::{
     common::{
         meta::{
             authz::Authz,
-            dashboards::{Dashboards, Folder, DEFAULT_FOLDER},
+            comments::CommentTarget,
+            dashboards::{Dashboard, Dashboards, Folder, DEFAULT_FOLDER},
+            governance::GovernedKind,
             http::HttpResponse as MetaHttpResponse,
         },
         utils::auth::{remove_ownership, set_ownership},
     },
-    service::db::dashboards,
+    service::db::{self, dashboards},
 };
 
 pub mod folders;
+pub mod grafana;
+pub mod library;
 pub mod reports;
 
 #[tracing::instrument(skip(body))]
@@ -68,6 +72,7 @@ pub async fn create_dashboard(
                     folder_id: DEFAULT_FOLDER.to_string(),
                     name: DEFAULT_FOLDER.to_string(),
                     description: DEFAULT_FOLDER.to_string(),
+                    ..Default::default()
                 };
                 folders::save_folder(org_id, folder, true).await?;
                 let dashboard_id = ider::generate();
@@ -115,9 +120,9 @@ pub async fn update_dashboard(
 
 #[tracing::instrument]
 pub async fn list_dashboards(org_id: &str, folder_id: &str) -> Result<HttpResponse, io::Error> {
-    Ok(HttpResponse::Ok().json(Dashboards {
-        dashboards: dashboards::list(org_id, folder_id).await.unwrap(),
-    }))
+    let mut list = dashboards::list(org_id, folder_id).await.unwrap();
+    library::resolve(org_id, &mut list).await;
+    Ok(HttpResponse::Ok().json(Dashboards { dashboards: list }))
 }
 
 #[tracing::instrument]
@@ -126,7 +131,8 @@ pub async fn get_dashboard(
     dashboard_id: &str,
     folder_id: &str,
 ) -> Result<HttpResponse, io::Error> {
-    let resp = if let Ok(dashboard) = dashboards::get(org_id, dashboard_id, folder_id).await {
+    let resp = if let Ok(mut dashboard) = dashboards::get(org_id, dashboard_id, folder_id).await {
+        library::resolve(org_id, std::slice::from_mut(&mut dashboard)).await;
         HttpResponse::Ok().json(dashboard)
     } else {
         return Ok(Response::NotFound("Dashboard".to_string()).into());
@@ -151,6 +157,17 @@ pub async fn delete_dashboard(
     }
     match dashboards::delete(org_id, dashboard_id, folder_id).await {
         Ok(_) => {
+            if let Err(e) =
+                db::comments::delete_all(org_id, CommentTarget::Dashboards, dashboard_id).await
+            {
+                tracing::error!(%e, dashboard_id, "Failed to delete the dashboard comments");
+            }
+            let object_id = format!("{folder_id}/{dashboard_id}");
+            if let Err(e) =
+                db::governance::delete(org_id, GovernedKind::Dashboards, &object_id).await
+            {
+                tracing::error!(%e, dashboard_id, "Failed to delete the dashboard governance");
+            }
             remove_ownership(
                 org_id,
                 "dashboards",
@@ -175,6 +192,37 @@ pub async fn delete_dashboard(
     }
 }
 
+/// Stores the dashboard under its id, created or replaced, e.g. by the restore
+/// of a snapshot. The folder must exist and the dashboard is validated like
+/// one saved through the API.
+pub(crate) async fn put_dashboard(
+    org_id: &str,
+    dashboard_id: &str,
+    folder_id: &str,
+    body: web::Bytes,
+) -> Result<(), anyhow::Error> {
+    if dashboards::folders::get(org_id, folder_id).await.is_err() {
+        return Err(anyhow::anyhow!("folder {folder_id} not found"));
+    }
+    let created = dashboards::get(org_id, dashboard_id, folder_id)
+        .await
+        .is_err();
+    dashboards::put(org_id, dashboard_id, folder_id, body).await?;
+    if created {
+        set_ownership(
+            org_id,
+            "dashboards",
+            Authz {
+                obj_id: dashboard_id.to_owned(),
+                parent_type: "folders".to_owned(),
+                parent: folder_id.to_owned(),
+            },
+        )
+        .await;
+    }
+    Ok(())
+}
+
 async fn save_dashboard(
     org_id: &str,
     dashboard_id: &str,
@@ -200,31 +248,72 @@ pub async fn move_dashboard(
     from_folder: &str,
     to_folder: &str,
 ) -> Result<HttpResponse, io::Error> {
-    if let Ok(dashboard) = dashboards::get(org_id, dashboard_id, from_folder).await {
-        // make sure the destination folder exists
-        if dashboards::folders::get(org_id, to_folder).await.is_err() {
-            return Ok(Response::NotFound("Destination Folder".to_string()).into());
-        }
-        let dash = if dashboard.version == 1 {
-            json::to_vec(&dashboard.v1.unwrap()).unwrap()
-        } else if dashboard.version == 2 {
-            json::to_vec(&dashboard.v2.unwrap()).unwrap()
-        } else if dashboard.version == 3 {
-            json::to_vec(&dashboard.v3.unwrap()).unwrap()
-        } else {
-            json::to_vec(&dashboard.v4.unwrap()).unwrap()
-        };
+    match move_to_folder(org_id, dashboard_id, from_folder, to_folder).await {
+        Ok(_) => Ok(Response::OkMessage("Dashboard moved successfully".to_string()).into()),
+        Err((http::StatusCode::NOT_FOUND, e)) => Ok(Response::NotFound(e.to_string()).into()),
+        Err((_, e)) => Ok(Response::InternalServerError(e).into()),
+    }
+}
 
-        // add the dashboard to the destination folder
-        if let Err(error) = dashboards::put(org_id, dashboard_id, to_folder, dash.into()).await {
-            return Ok(Response::InternalServerError(error).into());
-        }
+/// Moves the dashboard with its governance to another folder, the error of a
+/// missing dashboard or folder is the name of the missing object
+pub(crate) async fn move_to_folder(
+    org_id: &str,
+    dashboard_id: &str,
+    from_folder: &str,
+    to_folder: &str,
+) -> Result<(), (http::StatusCode, anyhow::Error)> {
+    let Ok(dashboard) = dashboards::get(org_id, dashboard_id, from_folder).await else {
+        return Err((http::StatusCode::NOT_FOUND, anyhow::anyhow!("Dashboard")));
+    };
+    // make sure the destination folder exists
+    if dashboards::folders::get(org_id, to_folder).await.is_err() {
+        return Err((
+            http::StatusCode::NOT_FOUND,
+            anyhow::anyhow!("Destination Folder"),
+        ));
+    }
+
+    // add the dashboard to the destination folder
+    if let Err(error) =
+        dashboards::put(org_id, dashboard_id, to_folder, to_json(&dashboard).into()).await
+    {
+        return Err((http::StatusCode::INTERNAL_SERVER_ERROR, error));
+    }
 
-        // delete the dashboard from the source folder
-        let _ = dashboards::delete(org_id, dashboard_id, from_folder).await;
-        Ok(Response::OkMessage("Dashboard moved successfully".to_string()).into())
+    // delete the dashboard from the source folder
+    let _ = dashboards::delete(org_id, dashboard_id, from_folder).await;
+    move_governance(org_id, dashboard_id, from_folder, to_folder).await;
+    Ok(())
+}
+
+/// Serializes the dashboard of its version, as stored
+pub(crate) fn to_json(dashboard: &Dashboard) -> Vec<u8> {
+    if dashboard.version == 1 {
+        json::to_vec(dashboard.v1.as_ref().unwrap()).unwrap()
+    } else if dashboard.version == 2 {
+        json::to_vec(dashboard.v2.as_ref().unwrap()).unwrap()
+    } else if dashboard.version == 3 {
+        json::to_vec(dashboard.v3.as_ref().unwrap()).unwrap()
     } else {
-        Ok(Response::NotFound("Dashboard".to_string()).into())
+        json::to_vec(dashboard.v4.as_ref().unwrap()).unwrap()
+    }
+}
+
+/// Keeps the owner, tags and state of a dashboard moved to another folder
+async fn move_governance(org_id: &str, dashboard_id: &str, from_folder: &str, to_folder: &str) {
+    let from_id = format!("{from_folder}/{dashboard_id}");
+    let Ok(mut governance) = db::governance::get(org_id, GovernedKind::Dashboards, &from_id).await
+    else {
+        return;
+    };
+    governance.object_id = format!("{to_folder}/{dashboard_id}");
+    if let Err(e) = db::governance::set(org_id, &governance).await {
+        tracing::error!(%e, dashboard_id, "Failed to move the dashboard governance");
+        return;
+    }
+    if let Err(e) = db::governance::delete(org_id, GovernedKind::Dashboards, &from_id).await {
+        tracing::error!(%e, dashboard_id, "Failed to delete the dashboard governance");
     }
 }
 
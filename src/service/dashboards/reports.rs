@@ -93,6 +93,15 @@ pub async fn save(
         }
     }
 
+    report.folder_id = report.folder_id.trim().to_string();
+    if !report.folder_id.is_empty()
+        && db::dashboards::folders::get(org_id, &report.folder_id)
+            .await
+            .is_err()
+    {
+        return Err(anyhow::anyhow!("Folder {} not found", report.folder_id));
+    }
+
     // Atleast one `ReportDashboard` and `ReportDestination` needs to be present
     if report.dashboards.is_empty() || report.destinations.is_empty() {
         return Err(anyhow::anyhow!(
@@ -0,0 +1,63 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::alerts::AlertFiring, service::db};
+
+const FIRING_KEY: &str = "/alert_firing/";
+
+/// Stores the latest firing of the alert
+pub async fn set(org_id: &str, firing: &AlertFiring) -> Result<(), anyhow::Error> {
+    let key = format!(
+        "{FIRING_KEY}{org_id}/{}/{}/{}",
+        firing.stream_type, firing.stream_name, firing.alert_name
+    );
+    db::put(
+        &key,
+        json::to_vec(firing).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, firing: &AlertFiring) -> Result<(), anyhow::Error> {
+    let key = format!(
+        "{FIRING_KEY}{org_id}/{}/{}/{}",
+        firing.stream_type, firing.stream_name, firing.alert_name
+    );
+    db::delete(&key, false, db::NO_NEED_WATCH, None).await?;
+    Ok(())
+}
+
+/// Returns the latest firing of every alert of the organization
+pub async fn list(org_id: &str) -> Result<Vec<AlertFiring>, anyhow::Error> {
+    let key = format!("{FIRING_KEY}{org_id}/");
+    let mut firings = Vec::new();
+    for val in db::list_values(&key).await? {
+        match json::from_slice(&val) {
+            Ok(firing) => firings.push(firing),
+            Err(e) => log::error!("Error parsing alert firing: {}", e),
+        }
+    }
+    Ok(firings)
+}
+
+pub async fn reset() -> Result<(), anyhow::Error> {
+    db::delete(FIRING_KEY, true, db::NO_NEED_WATCH, None).await?;
+    Ok(())
+}
@@ -22,9 +22,15 @@ use crate::{
     service::db,
 };
 
+pub mod deadman;
 pub mod destinations;
+pub mod firings;
+pub mod quality;
 pub mod realtime_triggers;
+pub mod states;
 pub mod templates;
+pub mod throttle;
+pub mod trigger_stats;
 
 pub async fn get(
     org_id: &str,
@@ -0,0 +1,70 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{common::meta::alerts::AlertState, service::db};
+
+const STATE_KEY: &str = "/alert_state/";
+
+fn key(org_id: &str, stream_type: StreamType, stream_name: &str, name: &str) -> String {
+    format!("{STATE_KEY}{org_id}/{stream_type}/{stream_name}/{name}")
+}
+
+/// Returns the status of the alert, resolved when it never fired
+pub async fn get(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    name: &str,
+) -> Result<AlertState, anyhow::Error> {
+    match db::get(&key(org_id, stream_type, stream_name, name)).await {
+        Ok(val) => Ok(json::from_slice(&val)?),
+        Err(_) => Ok(AlertState::default()),
+    }
+}
+
+pub async fn set(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    name: &str,
+    state: &AlertState,
+) -> Result<(), anyhow::Error> {
+    db::put(
+        &key(org_id, stream_type, stream_name, name),
+        json::to_vec(state).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    name: &str,
+) -> Result<(), anyhow::Error> {
+    db::delete(
+        &key(org_id, stream_type, stream_name, name),
+        false,
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
@@ -0,0 +1,71 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::alerts::destinations::ThrottleState, service::db};
+
+const THROTTLE_KEY: &str = "/alert_throttle/";
+
+pub async fn get(org_id: &str, destination: &str) -> Result<ThrottleState, anyhow::Error> {
+    match db::get(&format!("{THROTTLE_KEY}{org_id}/{destination}")).await {
+        Ok(val) => Ok(json::from_slice(&val)?),
+        Err(_) => Ok(ThrottleState::default()),
+    }
+}
+
+pub async fn set(
+    org_id: &str,
+    destination: &str,
+    state: &ThrottleState,
+) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{THROTTLE_KEY}{org_id}/{destination}"),
+        json::to_vec(state).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, destination: &str) -> Result<(), anyhow::Error> {
+    db::delete(
+        &format!("{THROTTLE_KEY}{org_id}/{destination}"),
+        false,
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+/// Returns the state of every destination which has one, with its org
+pub async fn list_all() -> Result<Vec<(String, String, ThrottleState)>, anyhow::Error> {
+    let mut items = Vec::new();
+    for (key, val) in db::list(THROTTLE_KEY).await? {
+        let Some((org_id, destination)) = key
+            .strip_prefix(THROTTLE_KEY)
+            .and_then(|key| key.split_once('/'))
+        else {
+            continue;
+        };
+        match json::from_slice(&val) {
+            Ok(state) => items.push((org_id.to_string(), destination.to_string(), state)),
+            Err(e) => log::error!("Error parsing alert throttle state: {}", e),
+        }
+    }
+    Ok(items)
+}
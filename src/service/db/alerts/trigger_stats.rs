@@ -0,0 +1,81 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::alerts::TriggerRunStats, service::db};
+
+const TRIGGER_STATS_KEY: &str = "/trigger_stats/";
+
+fn key(org_id: &str, module: &str, module_key: &str) -> String {
+    format!("{TRIGGER_STATS_KEY}{org_id}/{module}/{module_key}")
+}
+
+pub async fn get(
+    org_id: &str,
+    module: &str,
+    module_key: &str,
+) -> Result<Option<TriggerRunStats>, anyhow::Error> {
+    match db::get(&key(org_id, module, module_key)).await {
+        Ok(val) => Ok(Some(json::from_slice(&val)?)),
+        Err(_) => Ok(None),
+    }
+}
+
+pub async fn set(
+    org_id: &str,
+    module: &str,
+    module_key: &str,
+    stats: &TriggerRunStats,
+) -> Result<(), anyhow::Error> {
+    db::put(
+        &key(org_id, module, module_key),
+        json::to_vec(stats).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, module: &str, module_key: &str) -> Result<(), anyhow::Error> {
+    db::delete(
+        &key(org_id, module, module_key),
+        false,
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+/// Returns the stats of the triggers of the org, by module and module key
+pub async fn list(org_id: &str) -> Result<Vec<(String, String, TriggerRunStats)>, anyhow::Error> {
+    let prefix = format!("{TRIGGER_STATS_KEY}{org_id}/");
+    let mut items = Vec::new();
+    for (key, val) in db::list(&prefix).await? {
+        let Some((module, module_key)) = key
+            .strip_prefix(&prefix)
+            .and_then(|key| key.split_once('/'))
+        else {
+            continue;
+        };
+        match json::from_slice(&val) {
+            Ok(stats) => items.push((module.to_string(), module_key.to_string(), stats)),
+            Err(e) => log::error!("Error parsing trigger stats: {}", e),
+        }
+    }
+    Ok(items)
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::archive_search::ArchiveSearchJob, service::db};
+
+const ARCHIVE_SEARCH_KEY: &str = "/archive_search/";
+
+pub async fn set(job: &ArchiveSearchJob) -> Result<(), anyhow::Error> {
+    let key = format!("{ARCHIVE_SEARCH_KEY}{}/{}", job.org_id, job.id);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(job).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving archive search job: {}", e);
+        return Err(anyhow::anyhow!("Error saving archive search job: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn get(org_id: &str, id: &str) -> Result<ArchiveSearchJob, anyhow::Error> {
+    let val = db::get(&format!("{ARCHIVE_SEARCH_KEY}{org_id}/{id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(org_id: &str, id: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{ARCHIVE_SEARCH_KEY}{org_id}/{id}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting archive search job: {}", e);
+        return Err(anyhow::anyhow!("Error deleting archive search job: {}", e));
+    }
+    Ok(())
+}
+
+/// Lists the jobs of the organization, or of all the organizations when
+/// `org_id` is empty
+pub async fn list(org_id: &str) -> Result<Vec<ArchiveSearchJob>, anyhow::Error> {
+    let key = if org_id.is_empty() {
+        ARCHIVE_SEARCH_KEY.to_string()
+    } else {
+        format!("{ARCHIVE_SEARCH_KEY}{org_id}/")
+    };
+    let mut jobs: Vec<ArchiveSearchJob> = db::list_values(&key)
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    jobs.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+    Ok(jobs)
+}
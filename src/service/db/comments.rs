@@ -0,0 +1,94 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{
+    common::meta::comments::{Comment, CommentTarget},
+    service::db,
+};
+
+// DBKey to store the comments, `/comments/{org_id}/{target}/{target_id}/{id}`
+const COMMENTS_KEY: &str = "/comments/";
+
+pub async fn get(
+    org_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    id: &str,
+) -> Result<Comment, anyhow::Error> {
+    let val = db::get(&format!("{COMMENTS_KEY}{org_id}/{target}/{target_id}/{id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, comment: &Comment) -> Result<(), anyhow::Error> {
+    let key = format!(
+        "{COMMENTS_KEY}{org_id}/{}/{}/{}",
+        comment.target, comment.target_id, comment.id
+    );
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(comment).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving comment: {}", e);
+        return Err(anyhow::anyhow!("Error saving comment: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(
+    org_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+    id: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{COMMENTS_KEY}{org_id}/{target}/{target_id}/{id}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting comment: {}", e);
+        return Err(anyhow::anyhow!("Error deleting comment: {}", e));
+    }
+    Ok(())
+}
+
+/// Deletes the comments of a saved query or a dashboard
+pub async fn delete_all(
+    org_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{COMMENTS_KEY}{org_id}/{target}/{target_id}/");
+    db::delete_if_exists(&key, true, db::NO_NEED_WATCH).await?;
+    Ok(())
+}
+
+/// Lists the comments of a saved query or a dashboard, the oldest first
+pub async fn list(
+    org_id: &str,
+    target: CommentTarget,
+    target_id: &str,
+) -> Result<Vec<Comment>, anyhow::Error> {
+    let mut items: Vec<Comment> =
+        db::list_values(&format!("{COMMENTS_KEY}{org_id}/{target}/{target_id}/"))
+            .await?
+            .iter()
+            .filter_map(|val| json::from_slice(val).ok())
+            .collect();
+    items.sort_by(|a, b| a.created_at.cmp(&b.created_at));
+    Ok(items)
+}
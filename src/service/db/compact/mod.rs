@@ -16,5 +16,7 @@
 pub mod file_list;
 pub mod files;
 pub mod organization;
+pub mod recluster;
 pub mod retention;
+pub mod series;
 pub mod stats;
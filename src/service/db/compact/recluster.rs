@@ -0,0 +1,64 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{common::meta::stream::ReclusterJob, service::db};
+
+const RECLUSTER_KEY: &str = "/compact/recluster/";
+
+#[inline]
+fn mk_key(org_id: &str, stream_type: StreamType, stream_name: &str) -> String {
+    format!("{RECLUSTER_KEY}{org_id}/{stream_type}/{stream_name}")
+}
+
+/// Saves the job, a stream has at most one job so a new job replaces the
+/// previous one
+pub async fn set(job: &ReclusterJob) -> Result<(), anyhow::Error> {
+    let key = mk_key(&job.org_id, job.stream_type, &job.stream_name);
+    Ok(db::put(
+        &key,
+        json::to_vec(job).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?)
+}
+
+pub async fn get(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<ReclusterJob, anyhow::Error> {
+    let val = db::get(&mk_key(org_id, stream_type, stream_name)).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    let key = mk_key(org_id, stream_type, stream_name);
+    Ok(db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await?)
+}
+
+pub async fn list() -> Result<Vec<ReclusterJob>, anyhow::Error> {
+    Ok(db::list_values(RECLUSTER_KEY)
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect())
+}
@@ -123,6 +123,25 @@ pub async fn list() -> Result<Vec<String>, anyhow::Error> {
     Ok(items)
 }
 
+// list the deleting jobs of the stream, returns the date range, `all` when
+// deleting all data, and the node processing it, empty if not started yet
+pub async fn list_stream(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<Vec<(String, String)>, anyhow::Error> {
+    let mut items = Vec::new();
+    let key = format!("/compact/delete/{org_id}/{stream_type}/{stream_name}/");
+    let ret = db::list(&key).await?;
+    for (item_key, node) in ret {
+        let item_key = item_key.strip_prefix(&key).unwrap();
+        let node = String::from_utf8_lossy(&node).to_string();
+        let node = if node.eq("OK") { String::new() } else { node };
+        items.push((item_key.to_string(), node));
+    }
+    Ok(items)
+}
+
 pub async fn watch() -> Result<(), anyhow::Error> {
     let key = "/compact/delete/";
     let cluster_coordinator = db::get_coordinator().await;
@@ -0,0 +1,53 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::meta::stream::StreamType;
+
+use crate::service::db;
+
+const SERIES_KEY: &str = "/compact/series/";
+
+#[inline]
+fn mk_key(org_id: &str, stream_type: StreamType, stream_name: &str) -> String {
+    format!("{SERIES_KEY}{org_id}/{stream_type}/{stream_name}")
+}
+
+/// Returns the time, in microseconds, before which the files of the stream
+/// were scheduled to be rewritten series by series, 0 when none was
+pub async fn get_offset(org_id: &str, stream_type: StreamType, stream_name: &str) -> i64 {
+    match db::get(&mk_key(org_id, stream_type, stream_name)).await {
+        Ok(val) => String::from_utf8_lossy(&val).parse().unwrap_or_default(),
+        Err(_) => 0,
+    }
+}
+
+pub async fn set_offset(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    offset: i64,
+) -> Result<(), anyhow::Error> {
+    let key = mk_key(org_id, stream_type, stream_name);
+    Ok(db::put(&key, offset.to_string().into(), db::NO_NEED_WATCH, None).await?)
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    let key = mk_key(org_id, stream_type, stream_name);
+    Ok(db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await?)
+}
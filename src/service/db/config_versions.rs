@@ -0,0 +1,106 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use chrono::Utc;
+use config::{get_config, utils::json};
+use serde::Serialize;
+
+use crate::{common::meta::config_versions::ConfigVersion, service::db};
+
+const VERSION_KEY: &str = "/versions/";
+
+fn key_prefix(kind: &str, org_id: &str, name: &str) -> String {
+    format!("{VERSION_KEY}{kind}/{org_id}/{name}/")
+}
+
+/// Saves the definition as the next version and drops the versions beyond
+/// the history limit
+pub async fn add<T: Serialize>(
+    kind: &str,
+    org_id: &str,
+    name: &str,
+    definition: &T,
+) -> Result<u64, anyhow::Error> {
+    let mut versions = list(kind, org_id, name).await?;
+    let version = versions.first().map_or(1, |v| v.version + 1);
+    let item = ConfigVersion {
+        version,
+        created_at: Utc::now().timestamp_micros(),
+        definition: json::to_value(definition)?,
+    };
+    let prefix = key_prefix(kind, org_id, name);
+    db::put(
+        &format!("{prefix}{version:020}"),
+        json::to_vec(&item).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+
+    let history = get_config().limit.config_version_history;
+    if versions.len() >= history {
+        for old in versions.split_off(history.saturating_sub(1)) {
+            let key = format!("{prefix}{:020}", old.version);
+            if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+                log::error!("Error deleting old version {}: {}", key, e);
+            }
+        }
+    }
+    Ok(version)
+}
+
+/// Returns the saved versions, latest first
+pub async fn list(
+    kind: &str,
+    org_id: &str,
+    name: &str,
+) -> Result<Vec<ConfigVersion>, anyhow::Error> {
+    let mut versions = Vec::new();
+    for val in db::list_values(&key_prefix(kind, org_id, name)).await? {
+        match json::from_slice::<ConfigVersion>(&val) {
+            Ok(v) => versions.push(v),
+            Err(e) => log::error!("Error parsing version: {}", e),
+        }
+    }
+    versions.sort_by(|a, b| b.version.cmp(&a.version));
+    Ok(versions)
+}
+
+pub async fn get(
+    kind: &str,
+    org_id: &str,
+    name: &str,
+    version: u64,
+) -> Result<ConfigVersion, anyhow::Error> {
+    let key = format!("{}{version:020}", key_prefix(kind, org_id, name));
+    let val = db::get(&key).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(kind: &str, org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    db::delete(
+        &key_prefix(kind, org_id, name),
+        true,
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn reset() -> Result<(), anyhow::Error> {
+    db::delete(VERSION_KEY, true, db::NO_NEED_WATCH, None).await?;
+    Ok(())
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::dashboards::library::LibraryPanel, service::db};
+
+#[tracing::instrument]
+pub(crate) async fn get(org_id: &str, panel_id: &str) -> Result<LibraryPanel, anyhow::Error> {
+    let val = db::get(&format!("/dashboard_library/{org_id}/{panel_id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+#[tracing::instrument(skip(panel))]
+pub(crate) async fn put(org_id: &str, panel: &LibraryPanel) -> Result<(), anyhow::Error> {
+    let key = format!("/dashboard_library/{org_id}/{}", panel.panel_id);
+    match db::put(&key, json::to_vec(panel)?.into(), db::NO_NEED_WATCH, None).await {
+        Ok(_) => Ok(()),
+        Err(_) => Err(anyhow::anyhow!("Failed to save library panel")),
+    }
+}
+
+#[tracing::instrument]
+pub(crate) async fn list(org_id: &str) -> Result<Vec<LibraryPanel>, anyhow::Error> {
+    let db_key = format!("/dashboard_library/{org_id}/");
+    db::list(&db_key)
+        .await?
+        .into_values()
+        .map(|val| json::from_slice(&val).map_err(|e| anyhow::anyhow!(e)))
+        .collect()
+}
+
+#[tracing::instrument]
+pub(crate) async fn delete(org_id: &str, panel_id: &str) -> Result<(), anyhow::Error> {
+    let key = format!("/dashboard_library/{org_id}/{panel_id}");
+    Ok(db::delete(&key, false, db::NO_NEED_WATCH, None).await?)
+}
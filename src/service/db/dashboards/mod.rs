@@ -22,6 +22,7 @@ use crate::{
 };
 
 pub mod folders;
+pub mod library;
 pub mod reports;
 
 #[tracing::instrument]
@@ -178,6 +179,28 @@ pub(crate) async fn list(org_id: &str, folder: &str) -> Result<Vec<Dashboard>, a
         .collect()
 }
 
+/// Lists the latest version dashboards of all the folders of the organization,
+/// with their folder
+#[tracing::instrument]
+pub(crate) async fn list_v4(org_id: &str) -> Result<Vec<(String, v4::Dashboard)>, anyhow::Error> {
+    let db_key = format!("/dashboard/{org_id}/");
+    let mut dashboards = Vec::new();
+    for (key, val) in db::list(&db_key).await? {
+        let d_version: DashboardVersion = json::from_slice(&val)?;
+        if d_version.version < 4 {
+            continue;
+        }
+        // the key is /dashboard/{org_id}/{folder}/{dashboard_id}
+        let folder = key
+            .strip_prefix(&db_key)
+            .and_then(|key| key.split('/').next())
+            .unwrap_or_default()
+            .to_string();
+        dashboards.push((folder, json::from_slice(&val)?));
+    }
+    Ok(dashboards)
+}
+
 #[tracing::instrument]
 pub(crate) async fn delete(
     org_id: &str,
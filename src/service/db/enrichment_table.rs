@@ -50,6 +50,7 @@ pub async fn get(org_id: &str, name: &str) -> Result<Vec<vrl::value::Value>, any
         clusters: vec![],
         timeout: 0,
         search_type: None,
+        search_event_context: None,
     };
     // do search
     match SearchService::search("", org_id, StreamType::EnrichmentTables, None, &req).await {
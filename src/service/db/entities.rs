@@ -0,0 +1,51 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::entity::Entity, service::db};
+
+const ENTITY_KEY: &str = "/entity/";
+
+/// Stores the entities seen by this node for the given organization
+pub async fn set(org_id: &str, node: &str, entities: &[Entity]) -> Result<(), anyhow::Error> {
+    let key = format!("{ENTITY_KEY}{org_id}/{node}");
+    db::put(
+        &key,
+        json::to_vec(entities).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+/// Returns the entities seen by all the nodes, one list per node
+pub async fn list(org_id: &str) -> Result<Vec<Vec<Entity>>, anyhow::Error> {
+    let key = format!("{ENTITY_KEY}{org_id}/");
+    let mut lists = Vec::new();
+    for val in db::list_values(&key).await? {
+        match json::from_slice(&val) {
+            Ok(list) => lists.push(list),
+            Err(e) => log::error!("Error parsing entities: {}", e),
+        }
+    }
+    Ok(lists)
+}
+
+pub async fn reset() -> Result<(), anyhow::Error> {
+    db::delete(ENTITY_KEY, true, db::NO_NEED_WATCH, None).await?;
+    Ok(())
+}
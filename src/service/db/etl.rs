@@ -0,0 +1,84 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::etl::EtlJob, service::db};
+
+const ETL_KEY: &str = "/etl/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<EtlJob, anyhow::Error> {
+    let val = db::get(&format!("{ETL_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+/// Saves the job and schedules its next run
+pub async fn set(org_id: &str, job: &EtlJob, next_run_at: i64) -> Result<(), anyhow::Error> {
+    set_without_updating_trigger(org_id, job).await?;
+    let trigger = db::scheduler::Trigger {
+        org: org_id.to_string(),
+        module: db::scheduler::TriggerModule::Etl,
+        module_key: job.name.clone(),
+        next_run_at,
+        ..Default::default()
+    };
+    let ret = if db::scheduler::exists(org_id, db::scheduler::TriggerModule::Etl, &job.name).await {
+        db::scheduler::update_trigger(trigger).await
+    } else {
+        db::scheduler::push(trigger).await
+    };
+    if let Err(e) = ret {
+        log::error!("Failed to save trigger: {}", e);
+    }
+    Ok(())
+}
+
+pub async fn set_without_updating_trigger(org_id: &str, job: &EtlJob) -> Result<(), anyhow::Error> {
+    let key = format!("{ETL_KEY}{org_id}/{}", job.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(job).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving etl job: {}", e);
+        return Err(anyhow::anyhow!("Error saving etl job: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{ETL_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting etl job: {}", e);
+        return Err(anyhow::anyhow!("Error deleting etl job: {}", e));
+    }
+    if let Err(e) = db::scheduler::delete(org_id, db::scheduler::TriggerModule::Etl, name).await {
+        log::error!("Failed to delete trigger: {}", e);
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<EtlJob>, anyhow::Error> {
+    let mut jobs: Vec<EtlJob> = db::list_values(&format!("{ETL_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    jobs.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(jobs)
+}
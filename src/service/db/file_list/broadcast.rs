@@ -27,9 +27,9 @@ use hashbrown::HashMap;
 use once_cell::sync::Lazy;
 use proto::cluster_rpc;
 use tokio::sync::{mpsc, RwLock};
-use tonic::{codec::CompressionEncoding, metadata::MetadataValue, transport::Channel, Request};
+use tonic::{codec::CompressionEncoding, metadata::MetadataValue, Request};
 
-use crate::common::infra::cluster;
+use crate::common::infra::{cluster, tls};
 
 static EVENTS: Lazy<RwLock<HashMap<String, EventChannel>>> =
     Lazy::new(|| RwLock::new(HashMap::new()));
@@ -154,7 +154,7 @@ async fn send_to_node(
         let token: MetadataValue<_> = cluster::get_internal_grpc_token()
             .parse()
             .expect("parse internal grpc token faile");
-        let channel = match Channel::from_shared(node.grpc_addr.clone())
+        let channel = match tls::grpc_endpoint(node.grpc_addr.clone())
             .unwrap()
             .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
             .connect()
@@ -0,0 +1,83 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{
+    common::meta::governance::{Governance, GovernedKind},
+    service::db,
+};
+
+// DBKey to store the governance of the objects, `/governance/{org_id}/{kind}/{object_id}`
+const GOVERNANCE_KEY: &str = "/governance/";
+
+pub async fn get(
+    org_id: &str,
+    kind: GovernedKind,
+    object_id: &str,
+) -> Result<Governance, anyhow::Error> {
+    let val = db::get(&format!("{GOVERNANCE_KEY}{org_id}/{kind}/{object_id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, governance: &Governance) -> Result<(), anyhow::Error> {
+    let key = format!(
+        "{GOVERNANCE_KEY}{org_id}/{}/{}",
+        governance.kind, governance.object_id
+    );
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(governance).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving governance: {}", e);
+        return Err(anyhow::anyhow!("Error saving governance: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(
+    org_id: &str,
+    kind: GovernedKind,
+    object_id: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{GOVERNANCE_KEY}{org_id}/{kind}/{object_id}");
+    db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await?;
+    Ok(())
+}
+
+/// Lists the governance of the objects of the organization, of a kind when
+/// given
+pub async fn list(
+    org_id: &str,
+    kind: Option<GovernedKind>,
+) -> Result<Vec<Governance>, anyhow::Error> {
+    let key = match kind {
+        Some(kind) => format!("{GOVERNANCE_KEY}{org_id}/{kind}/"),
+        None => format!("{GOVERNANCE_KEY}{org_id}/"),
+    };
+    let mut items: Vec<Governance> = db::list_values(&key)
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| {
+        (a.kind.to_string(), &a.object_id).cmp(&(b.kind.to_string(), &b.object_id))
+    });
+    Ok(items)
+}
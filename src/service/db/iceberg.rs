@@ -0,0 +1,67 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{common::meta::iceberg::IcebergTable, service::db};
+
+// DBKey to store the Iceberg tables, `/iceberg/{org_id}/{stream_type}/{stream_name}`
+const ICEBERG_KEY: &str = "/iceberg/";
+
+pub async fn get(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<IcebergTable, anyhow::Error> {
+    let val = db::get(&format!(
+        "{ICEBERG_KEY}{org_id}/{stream_type}/{stream_name}"
+    ))
+    .await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    table: &IcebergTable,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{ICEBERG_KEY}{org_id}/{stream_type}/{stream_name}");
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(table).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving iceberg table: {}", e);
+        return Err(anyhow::anyhow!("Error saving iceberg table: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{ICEBERG_KEY}{org_id}/{stream_type}/{stream_name}");
+    if let Err(e) = db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await {
+        log::error!("Error deleting iceberg table: {}", e);
+        return Err(anyhow::anyhow!("Error deleting iceberg table: {}", e));
+    }
+    Ok(())
+}
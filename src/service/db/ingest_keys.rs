@@ -0,0 +1,169 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use bytes::Bytes;
+use config::utils::json;
+
+use crate::{
+    common::{infra::config::INGEST_KEYS, meta::ingest_keys::IngestKey},
+    service::db,
+};
+
+// DBKey to store the ingest keys, `/ingest_keys/{org_id}/{key_id}`
+pub const INGEST_KEYS_KEY: &str = "/ingest_keys/";
+// DBKey to record the signatures of the signed requests within the clock skew,
+// `/ingest_key_signatures/{timestamp}/{signature}`
+pub const SIGNATURES_KEY: &str = "/ingest_key_signatures/";
+
+/// Returns the key from the cache, keys are cached by id on every node.
+pub fn get(key_id: &str) -> Option<IngestKey> {
+    INGEST_KEYS.get(key_id).map(|v| v.value().clone())
+}
+
+pub fn list(org_id: &str) -> Vec<IngestKey> {
+    let mut items: Vec<IngestKey> = INGEST_KEYS
+        .iter()
+        .filter(|v| v.value().org_id == org_id)
+        .map(|v| v.value().clone())
+        .collect();
+    items.sort_by(|a, b| a.created_at.cmp(&b.created_at));
+    items
+}
+
+pub async fn set(key: &IngestKey) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{INGEST_KEYS_KEY}{}/{}", key.org_id, key.id),
+        json::to_vec(key).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    INGEST_KEYS.insert(key.id.clone(), key.clone());
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, key_id: &str) -> Result<(), anyhow::Error> {
+    db::delete(
+        &format!("{INGEST_KEYS_KEY}{org_id}/{key_id}"),
+        false,
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    INGEST_KEYS.remove(key_id);
+    Ok(())
+}
+
+/// Records the signature of a request, returns false when a node already
+/// recorded it. The check and the write are one transaction of the meta store.
+pub async fn record_signature(signature: &str, timestamp: i64) -> Result<bool, anyhow::Error> {
+    let (tx, rx) = tokio::sync::oneshot::channel();
+    infra::db::get_db()
+        .await
+        .get_for_update(
+            &format!("{SIGNATURES_KEY}{timestamp}/{signature}"),
+            db::NO_NEED_WATCH,
+            None,
+            Box::new(move |value| {
+                let first = value.is_none();
+                let _ = tx.send(first);
+                Ok(first.then(|| (Some(Bytes::from(timestamp.to_string())), None)))
+            }),
+        )
+        .await?;
+    Ok(rx.await.unwrap_or(false))
+}
+
+/// Deletes the signatures with a timestamp before `before`, they can not pass
+/// the clock skew check anymore. Returns how many timestamps were removed.
+pub async fn delete_signatures_before(before: i64) -> Result<usize, anyhow::Error> {
+    let timestamps = db::list_keys(SIGNATURES_KEY)
+        .await?
+        .iter()
+        .filter_map(|key| {
+            key.strip_prefix(SIGNATURES_KEY)?
+                .split('/')
+                .next()?
+                .parse()
+                .ok()
+        })
+        .filter(|ts: &i64| *ts < before)
+        .collect::<std::collections::BTreeSet<_>>();
+    let db = infra::db::get_db().await;
+    for ts in timestamps.iter() {
+        db.delete_if_exists(&format!("{SIGNATURES_KEY}{ts}/"), true, db::NO_NEED_WATCH)
+            .await?;
+    }
+    Ok(timestamps.len())
+}
+
+fn key_id(item_key: &str) -> &str {
+    item_key.rsplit('/').next().unwrap_or(item_key)
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = INGEST_KEYS_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching ingest keys");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_ingest_keys: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_value: IngestKey = if config::get_config().common.meta_store_external {
+                    match db::get(&ev.key).await {
+                        Ok(val) => match json::from_slice(&val) {
+                            Ok(val) => val,
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        },
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    json::from_slice(&ev.value.unwrap()).unwrap()
+                };
+                INGEST_KEYS.insert(item_value.id.clone(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                INGEST_KEYS.remove(key_id(&ev.key));
+            }
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let ret = db::list_values(INGEST_KEYS_KEY).await?;
+    for item_value in ret {
+        let key: IngestKey = json::from_slice(&item_value).unwrap();
+        INGEST_KEYS.insert(key.id.clone(), key);
+    }
+    log::info!("Ingest keys Cached");
+    Ok(())
+}
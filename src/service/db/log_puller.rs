@@ -0,0 +1,114 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{
+    common::meta::log_puller::{LogPuller, LogPullerState},
+    service::db,
+};
+
+const LOG_PULLER_KEY: &str = "/log_puller/";
+const LOG_PULLER_STATE_KEY: &str = "/log_puller_state/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<LogPuller, anyhow::Error> {
+    let val = db::get(&format!("{LOG_PULLER_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, puller: &LogPuller) -> Result<(), anyhow::Error> {
+    let key = format!("{LOG_PULLER_KEY}{org_id}/{}", puller.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(puller).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving log puller: {}", e);
+        return Err(anyhow::anyhow!("Error saving log puller: {}", e));
+    }
+    Ok(())
+}
+
+/// Deletes the integration together with its checkpoint
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{LOG_PULLER_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting log puller: {}", e);
+        return Err(anyhow::anyhow!("Error deleting log puller: {}", e));
+    }
+    let key = format!("{LOG_PULLER_STATE_KEY}{org_id}/{name}");
+    let _ = db::delete(&key, false, db::NO_NEED_WATCH, None).await;
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<LogPuller>, anyhow::Error> {
+    let mut items: Vec<LogPuller> = db::list_values(&format!("{LOG_PULLER_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
+
+/// Lists the log pullers of all the organizations
+pub async fn list_all() -> Result<Vec<(String, LogPuller)>, anyhow::Error> {
+    let mut items = Vec::new();
+    for (key, val) in db::list(LOG_PULLER_KEY).await? {
+        let Some((org_id, _)) = key
+            .strip_prefix(LOG_PULLER_KEY)
+            .and_then(|k| k.split_once('/'))
+        else {
+            continue;
+        };
+        match json::from_slice(&val) {
+            Ok(puller) => items.push((org_id.to_string(), puller)),
+            Err(e) => log::error!("Error parsing log puller {key}: {e}"),
+        }
+    }
+    Ok(items)
+}
+
+/// Returns the checkpoint and health of the integration, empty before its
+/// first run
+pub async fn get_state(org_id: &str, name: &str) -> LogPullerState {
+    match db::get(&format!("{LOG_PULLER_STATE_KEY}{org_id}/{name}")).await {
+        Ok(val) => json::from_slice(&val).unwrap_or_default(),
+        Err(_) => LogPullerState::default(),
+    }
+}
+
+pub async fn set_state(
+    org_id: &str,
+    name: &str,
+    state: &LogPullerState,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{LOG_PULLER_STATE_KEY}{org_id}/{name}");
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(state).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving log puller state: {}", e);
+        return Err(anyhow::anyhow!("Error saving log puller state: {}", e));
+    }
+    Ok(())
+}
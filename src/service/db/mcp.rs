@@ -0,0 +1,69 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::mcp::McpToken, service::db};
+
+const MCP_TOKEN_KEY: &str = "/mcp_tokens/";
+
+pub async fn set(token: &McpToken) -> Result<(), anyhow::Error> {
+    let key = format!("{MCP_TOKEN_KEY}{}/{}", token.org_id, token.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(token).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving mcp token: {}", e);
+        return Err(anyhow::anyhow!("Error saving mcp token: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn get(org_id: &str, name: &str) -> Result<McpToken, anyhow::Error> {
+    let val = db::get(&format!("{MCP_TOKEN_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{MCP_TOKEN_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting mcp token: {}", e);
+        return Err(anyhow::anyhow!("Error deleting mcp token: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<McpToken>, anyhow::Error> {
+    list_prefix(&format!("{MCP_TOKEN_KEY}{org_id}/")).await
+}
+
+/// Lists the tokens of every organization
+pub async fn list_all() -> Result<Vec<McpToken>, anyhow::Error> {
+    list_prefix(MCP_TOKEN_KEY).await
+}
+
+async fn list_prefix(prefix: &str) -> Result<Vec<McpToken>, anyhow::Error> {
+    let mut tokens: Vec<McpToken> = db::list_values(prefix)
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    tokens.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(tokens)
+}
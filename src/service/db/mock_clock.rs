@@ -0,0 +1,110 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! The now pinned by the mock clock of the UI tests, see
+//! `ZO_MOCK_CLOCK_ENABLED`. It is kept in the meta store and watched by every
+//! node, so the queries get the same now whichever node serves them.
+
+use std::sync::Arc;
+
+use bytes::Bytes;
+use config::utils::time::set_mock_now;
+
+use crate::service::db;
+
+// DBKey of the pinned now in microseconds
+pub const MOCK_CLOCK_KEY: &str = "/mock_clock/now";
+
+pub async fn set(now: i64) -> Result<(), anyhow::Error> {
+    db::put(
+        MOCK_CLOCK_KEY,
+        Bytes::from(now.to_string()),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    set_mock_now(Some(now));
+    Ok(())
+}
+
+pub async fn reset() -> Result<(), anyhow::Error> {
+    db::delete_if_exists(MOCK_CLOCK_KEY, false, db::NEED_WATCH).await?;
+    set_mock_now(None);
+    Ok(())
+}
+
+fn parse(value: &[u8]) -> Option<i64> {
+    std::str::from_utf8(value)
+        .ok()
+        .and_then(|v| v.parse::<i64>().ok())
+        .filter(|v| *v > 0)
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = MOCK_CLOCK_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching mock clock");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_mock_clock: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let value = if config::get_config().common.meta_store_external {
+                    match db::get(&ev.key).await {
+                        Ok(val) => val,
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    ev.value.unwrap()
+                };
+                match parse(&value) {
+                    Some(now) => set_mock_now(Some(now)),
+                    None => log::error!("Error parsing the mock clock: {:?}", value),
+                }
+            }
+            db::Event::Delete(_) => set_mock_now(None),
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let ret = db::list_values(MOCK_CLOCK_KEY).await?;
+    set_mock_now(ret.first().and_then(|v| parse(v)));
+    log::info!("Mock clock Cached");
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse() {
+        assert_eq!(parse(b"1700000000000000"), Some(1700000000000000));
+        assert_eq!(parse(b"0"), None);
+        assert_eq!(parse(b"now"), None);
+    }
+}
@@ -20,24 +20,50 @@ use infra::{db as infra_db, errors::Result};
 use {infra::errors::Error, o2_enterprise::enterprise::common::infra::config::O2_CONFIG};
 
 pub mod alerts;
+pub mod archive_search;
+pub mod comments;
 pub mod compact;
+pub mod config_versions;
 pub mod dashboards;
 pub mod enrichment_table;
+pub mod entities;
+pub mod etl;
 pub mod file_list;
 pub mod functions;
+pub mod governance;
+pub mod iceberg;
+pub mod ingest_keys;
 pub mod instance;
 pub mod kv;
+pub mod log_puller;
+pub mod mcp;
 pub mod metrics;
+pub mod mock_clock;
+pub mod network_policy;
 pub mod ofga;
 pub mod organization;
 pub mod pipelines;
+pub mod plugins;
+pub mod rehydration;
+pub mod residency;
+pub mod saved_query;
 pub mod saved_view;
 pub mod scheduler;
 pub mod schema;
+pub mod schema_contracts;
+pub mod scrape;
+pub mod search_jobs;
+pub mod secrets;
 pub mod session;
+pub mod stream_access;
+pub mod subscriptions;
+pub mod synthetics;
 pub mod syslog;
 pub mod user;
+pub mod user_login_session;
+pub mod user_mfa;
 pub mod version;
+pub mod webhook;
 
 pub(crate) use infra_db::{get_coordinator, Event, NEED_WATCH, NO_NEED_WATCH};
 
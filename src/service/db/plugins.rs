@@ -0,0 +1,108 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::utils::json;
+
+use crate::{
+    common::{infra::config::PLUGINS, meta::plugins::Plugin},
+    service::db,
+};
+
+// DBKey to store the plugins, `/plugins/{name}`
+pub const PLUGINS_KEY: &str = "/plugins/";
+
+pub fn get(name: &str) -> Option<Plugin> {
+    PLUGINS.get(name).map(|v| v.value().clone())
+}
+
+pub fn list() -> Vec<Plugin> {
+    let mut items: Vec<Plugin> = PLUGINS.iter().map(|v| v.value().clone()).collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    items
+}
+
+pub async fn set(plugin: &Plugin) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{PLUGINS_KEY}{}", plugin.name),
+        json::to_vec(plugin).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    PLUGINS.insert(plugin.name.clone(), plugin.clone());
+    Ok(())
+}
+
+pub async fn delete(name: &str) -> Result<(), anyhow::Error> {
+    db::delete(&format!("{PLUGINS_KEY}{name}"), false, db::NEED_WATCH, None).await?;
+    PLUGINS.remove(name);
+    Ok(())
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = PLUGINS_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching plugins");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_plugins: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_value: Plugin = if config::get_config().common.meta_store_external {
+                    match db::get(&ev.key).await {
+                        Ok(val) => match json::from_slice(&val) {
+                            Ok(val) => val,
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        },
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    json::from_slice(&ev.value.unwrap()).unwrap()
+                };
+                PLUGINS.insert(item_value.name.clone(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                let name = ev.key.strip_prefix(PLUGINS_KEY).unwrap();
+                PLUGINS.remove(name);
+            }
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let ret = db::list_values(PLUGINS_KEY).await?;
+    for item_value in ret {
+        let plugin: Plugin = json::from_slice(&item_value).unwrap();
+        PLUGINS.insert(plugin.name.clone(), plugin);
+    }
+    log::info!("Plugins Cached");
+    Ok(())
+}
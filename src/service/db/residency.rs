@@ -0,0 +1,111 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::utils::json;
+
+use crate::{
+    common::{infra::config::ORG_RESIDENCY, meta::organization::OrgResidency},
+    service::db,
+};
+
+// DBKey to store the region an org is pinned to
+pub const RESIDENCY_KEY: &str = "/organization/residency/";
+
+pub fn get(org_id: &str) -> Option<OrgResidency> {
+    ORG_RESIDENCY.get(org_id).map(|v| v.value().clone())
+}
+
+pub async fn set(org_id: &str, residency: &OrgResidency) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{RESIDENCY_KEY}{org_id}"),
+        json::to_vec(residency).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    ORG_RESIDENCY.insert(org_id.to_string(), residency.clone());
+    Ok(())
+}
+
+pub async fn delete(org_id: &str) -> Result<(), anyhow::Error> {
+    db::delete(
+        &format!("{RESIDENCY_KEY}{org_id}"),
+        false,
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    ORG_RESIDENCY.remove(org_id);
+    Ok(())
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = RESIDENCY_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching org residency");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_org_residency: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                let item_value: OrgResidency = if config::get_config().common.meta_store_external {
+                    match db::get(&ev.key).await {
+                        Ok(val) => match json::from_slice(&val) {
+                            Ok(val) => val,
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        },
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    json::from_slice(&ev.value.unwrap()).unwrap()
+                };
+                ORG_RESIDENCY.insert(item_key.to_string(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                ORG_RESIDENCY.remove(item_key);
+            }
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let key = RESIDENCY_KEY;
+    let ret = db::list(key).await?;
+    for (item_key, item_value) in ret {
+        let org_id = item_key.strip_prefix(key).unwrap();
+        let residency: OrgResidency = json::from_slice(&item_value).unwrap();
+        ORG_RESIDENCY.insert(org_id.to_owned(), residency);
+    }
+    log::info!("Org residency Cached");
+    Ok(())
+}
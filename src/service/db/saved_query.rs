@@ -0,0 +1,60 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::saved_query::SavedQuery, service::db};
+
+const SAVED_QUERY_KEY: &str = "/saved_query/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<SavedQuery, anyhow::Error> {
+    let val = db::get(&format!("{SAVED_QUERY_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, query: &SavedQuery) -> Result<(), anyhow::Error> {
+    let key = format!("{SAVED_QUERY_KEY}{org_id}/{}", query.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(query).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving saved query: {}", e);
+        return Err(anyhow::anyhow!("Error saving saved query: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{SAVED_QUERY_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting saved query: {}", e);
+        return Err(anyhow::anyhow!("Error deleting saved query: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<SavedQuery>, anyhow::Error> {
+    let mut items: Vec<SavedQuery> = db::list_values(&format!("{SAVED_QUERY_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
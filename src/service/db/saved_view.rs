@@ -91,6 +91,19 @@ pub async fn get_views_list_only(org_id: &str) -> Result<ViewsWithoutData, Error
     Ok(ViewsWithoutData { views })
 }
 
+/// Return all the saved views associated with a provided org_id, with their
+/// payload
+pub async fn get_views(org_id: &str) -> Result<Vec<View>, Error> {
+    let key = format!("{}/{}/", SAVED_VIEWS_KEY_PREFIX, org_id);
+    let ret = db::list_values(&key).await?;
+    let mut views: Vec<View> = ret
+        .iter()
+        .filter_map(|view| json::from_slice(view).ok())
+        .collect();
+    views.sort_by_key(|v| v.view_name.clone());
+    Ok(views)
+}
+
 /// Delete a saved view id associated with an org-id
 // pub async fn delete_view(org_id: &str, view_id: &str) -> Result<View, Error>
 // {
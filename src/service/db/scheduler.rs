@@ -97,10 +97,11 @@ pub async fn update_status(
 #[inline]
 pub async fn pull(
     concurrency: i64,
+    org_quota: i64,
     alert_timeout: i64,
     report_timeout: i64,
 ) -> Result<Vec<Trigger>> {
-    infra_scheduler::pull(concurrency, alert_timeout, report_timeout).await
+    infra_scheduler::pull(concurrency, org_quota, alert_timeout, report_timeout).await
 }
 
 /// Returns the scheduled job associated with the given id in read-only fashion
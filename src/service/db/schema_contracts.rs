@@ -0,0 +1,185 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{
+    common::{
+        infra::config::SCHEMA_CONTRACTS,
+        meta::schema_contract::{ProducerReport, SchemaContract},
+    },
+    service::db,
+};
+
+const CONTRACT_KEY: &str = "/schema_contract/";
+const REPORT_KEY: &str = "/schema_contract_report/";
+
+pub async fn set(org_id: &str, contract: &SchemaContract) -> Result<(), anyhow::Error> {
+    let key = format!(
+        "{CONTRACT_KEY}{org_id}/{}/{}",
+        contract.stream_type, contract.stream_name
+    );
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(contract).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving schema contract: {}", e);
+        return Err(anyhow::anyhow!("Error saving schema contract: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn get(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<SchemaContract, anyhow::Error> {
+    let val = db::get(&format!(
+        "{CONTRACT_KEY}{org_id}/{stream_type}/{stream_name}"
+    ))
+    .await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{CONTRACT_KEY}{org_id}/{stream_type}/{stream_name}");
+    if let Err(e) = db::delete(&key, false, db::NEED_WATCH, None).await {
+        log::error!("Error deleting schema contract: {}", e);
+        return Err(anyhow::anyhow!("Error deleting schema contract: {}", e));
+    }
+    // reports of all the nodes are stale once the contract is gone
+    let key = format!("{REPORT_KEY}{org_id}/{stream_type}/{stream_name}/");
+    if let Err(e) = db::delete_if_exists(&key, true, db::NO_NEED_WATCH).await {
+        log::error!("Error deleting schema contract reports: {}", e);
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<SchemaContract>, anyhow::Error> {
+    Ok(db::list(&format!("{CONTRACT_KEY}{org_id}/"))
+        .await?
+        .values()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect())
+}
+
+/// Stores the producer reports collected by this node for the given stream
+pub async fn set_report(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    node: &str,
+    reports: &[ProducerReport],
+) -> Result<(), anyhow::Error> {
+    let key = format!("{REPORT_KEY}{org_id}/{stream_type}/{stream_name}/{node}");
+    db::put(
+        &key,
+        json::to_vec(reports).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+/// Returns the producer reports of all the nodes for the given stream
+pub async fn list_reports(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<Vec<ProducerReport>, anyhow::Error> {
+    let key = format!("{REPORT_KEY}{org_id}/{stream_type}/{stream_name}/");
+    let mut reports = Vec::new();
+    for val in db::list_values(&key).await? {
+        let node_reports: Vec<ProducerReport> = json::from_slice(&val)?;
+        reports.extend(node_reports);
+    }
+    Ok(reports)
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = CONTRACT_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching schema contracts");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_schema_contracts: event channel closed");
+                break;
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                let item_value: SchemaContract = if config::get_config().common.meta_store_external
+                {
+                    match db::get(&ev.key).await {
+                        Ok(val) => match json::from_slice(&val) {
+                            Ok(val) => val,
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        },
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    json::from_slice(&ev.value.unwrap()).unwrap()
+                };
+                SCHEMA_CONTRACTS.insert(item_key.to_owned(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                SCHEMA_CONTRACTS.remove(item_key);
+            }
+            db::Event::Empty => {}
+        }
+    }
+    Ok(())
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let key = CONTRACT_KEY;
+    let ret = db::list(key).await?;
+    for (item_key, item_value) in ret {
+        let item_key = item_key.strip_prefix(key).unwrap();
+        let json_val: SchemaContract = json::from_slice(&item_value).unwrap();
+        SCHEMA_CONTRACTS.insert(item_key.to_owned(), json_val);
+    }
+    log::info!("Schema contracts Cached");
+    Ok(())
+}
+
+pub async fn reset() -> Result<(), anyhow::Error> {
+    db::delete(CONTRACT_KEY, true, db::NO_NEED_WATCH, None).await?;
+    db::delete(REPORT_KEY, true, db::NO_NEED_WATCH, None).await?;
+    Ok(())
+}
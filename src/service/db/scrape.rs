@@ -0,0 +1,75 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::scrape::ScrapeConfig, service::db};
+
+const SCRAPE_KEY: &str = "/scrape/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<ScrapeConfig, anyhow::Error> {
+    let val = db::get(&format!("{SCRAPE_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, config: &ScrapeConfig) -> Result<(), anyhow::Error> {
+    let key = format!("{SCRAPE_KEY}{org_id}/{}", config.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(config).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving scrape config: {}", e);
+        return Err(anyhow::anyhow!("Error saving scrape config: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{SCRAPE_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting scrape config: {}", e);
+        return Err(anyhow::anyhow!("Error deleting scrape config: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<ScrapeConfig>, anyhow::Error> {
+    let mut items: Vec<ScrapeConfig> = db::list_values(&format!("{SCRAPE_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
+
+/// Lists the scrape configs of all the organizations
+pub async fn list_all() -> Result<Vec<(String, ScrapeConfig)>, anyhow::Error> {
+    let mut items = Vec::new();
+    for (key, val) in db::list(SCRAPE_KEY).await? {
+        let Some((org_id, _)) = key.strip_prefix(SCRAPE_KEY).and_then(|k| k.split_once('/')) else {
+            continue;
+        };
+        match json::from_slice(&val) {
+            Ok(config) => items.push((org_id.to_string(), config)),
+            Err(e) => log::error!("Error parsing scrape config {key}: {e}"),
+        }
+    }
+    Ok(items)
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::search_jobs::SearchJob, service::db};
+
+const SEARCH_JOBS_KEY: &str = "/search_jobs/";
+/// Jobs not finished yet, `{org_id}/{id}`, so that the queriers looking for a
+/// job to run don't load the finished ones
+const ACTIVE_SEARCH_JOBS_KEY: &str = "/search_jobs_active/";
+
+pub async fn set(job: &SearchJob) -> Result<(), anyhow::Error> {
+    let key = format!("{SEARCH_JOBS_KEY}{}/{}", job.org_id, job.id);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(job).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving search job: {}", e);
+        return Err(anyhow::anyhow!("Error saving search job: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn get(org_id: &str, id: &str) -> Result<SearchJob, anyhow::Error> {
+    let val = db::get(&format!("{SEARCH_JOBS_KEY}{org_id}/{id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn delete(org_id: &str, id: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{SEARCH_JOBS_KEY}{org_id}/{id}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting search job: {}", e);
+        return Err(anyhow::anyhow!("Error deleting search job: {}", e));
+    }
+    Ok(())
+}
+
+/// Lists the jobs of the organization, or of all the organizations when
+/// `org_id` is empty
+pub async fn list(org_id: &str) -> Result<Vec<SearchJob>, anyhow::Error> {
+    let key = if org_id.is_empty() {
+        SEARCH_JOBS_KEY.to_string()
+    } else {
+        format!("{SEARCH_JOBS_KEY}{org_id}/")
+    };
+    let mut jobs: Vec<SearchJob> = db::list_values(&key)
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    jobs.sort_by(|a, b| b.created_at.cmp(&a.created_at));
+    Ok(jobs)
+}
+
+pub async fn set_active(org_id: &str, id: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{ACTIVE_SEARCH_JOBS_KEY}{org_id}/{id}");
+    if let Err(e) = db::put(&key, bytes::Bytes::new(), db::NO_NEED_WATCH, None).await {
+        log::error!("Error saving active search job: {}", e);
+        return Err(anyhow::anyhow!("Error saving active search job: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete_active(org_id: &str, id: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{ACTIVE_SEARCH_JOBS_KEY}{org_id}/{id}");
+    if let Err(e) = db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await {
+        log::error!("Error deleting active search job: {}", e);
+        return Err(anyhow::anyhow!("Error deleting active search job: {}", e));
+    }
+    Ok(())
+}
+
+/// Lists the `(org_id, id)` of the jobs not finished yet
+pub async fn list_active() -> Result<Vec<(String, String)>, anyhow::Error> {
+    Ok(db::list_keys(ACTIVE_SEARCH_JOBS_KEY)
+        .await?
+        .iter()
+        .filter_map(|key| {
+            key.strip_prefix(ACTIVE_SEARCH_JOBS_KEY)?
+                .split_once('/')
+                .map(|(org_id, id)| (org_id.to_string(), id.to_string()))
+        })
+        .collect())
+}
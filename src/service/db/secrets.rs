@@ -0,0 +1,60 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::secrets::Secret, service::db};
+
+const SECRETS_KEY: &str = "/secrets/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<Secret, anyhow::Error> {
+    let val = db::get(&format!("{SECRETS_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, secret: &Secret) -> Result<(), anyhow::Error> {
+    let key = format!("{SECRETS_KEY}{org_id}/{}", secret.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(secret).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving secret: {}", e);
+        return Err(anyhow::anyhow!("Error saving secret: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{SECRETS_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting secret: {}", e);
+        return Err(anyhow::anyhow!("Error deleting secret: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<Secret>, anyhow::Error> {
+    let mut items: Vec<Secret> = db::list_values(&format!("{SECRETS_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
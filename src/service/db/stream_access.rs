@@ -0,0 +1,82 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::HashMap;
+
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{common::meta::stream_access::StreamAccess, service::db};
+
+// DBKey to store the searches of the streams, `/stream_access/{org_id}/{stream_type}/{stream_name}`
+const STREAM_ACCESS_KEY: &str = "/stream_access/";
+
+pub async fn get(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<StreamAccess, anyhow::Error> {
+    let val = db::get(&format!(
+        "{STREAM_ACCESS_KEY}{org_id}/{stream_type}/{stream_name}"
+    ))
+    .await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    access: &StreamAccess,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{STREAM_ACCESS_KEY}{org_id}/{stream_type}/{stream_name}");
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(access).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving stream access: {}", e);
+        return Err(anyhow::anyhow!("Error saving stream access: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    let key = format!("{STREAM_ACCESS_KEY}{org_id}/{stream_type}/{stream_name}");
+    if let Err(e) = db::delete_if_exists(&key, false, db::NO_NEED_WATCH).await {
+        log::error!("Error deleting stream access: {}", e);
+        return Err(anyhow::anyhow!("Error deleting stream access: {}", e));
+    }
+    Ok(())
+}
+
+/// Returns the access of the streams of the organization by `{type}/{name}`
+pub async fn list(org_id: &str) -> Result<HashMap<String, StreamAccess>, anyhow::Error> {
+    let prefix = format!("{STREAM_ACCESS_KEY}{org_id}/");
+    Ok(db::list(&prefix)
+        .await?
+        .into_iter()
+        .filter_map(|(key, val)| {
+            let key = key.strip_prefix(&prefix)?.to_string();
+            json::from_slice(&val).ok().map(|access| (key, access))
+        })
+        .collect())
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::utils::json;
+
+use crate::{
+    common::{infra::config::STREAM_SUBSCRIPTIONS, meta::subscriptions::StreamSubscription},
+    service::db,
+};
+
+// DBKey to store the stream subscriptions, `/subscriptions/{org_id}/{name}`
+pub const SUBSCRIPTIONS_KEY: &str = "/subscriptions/";
+
+pub fn get(org_id: &str, name: &str) -> Option<StreamSubscription> {
+    STREAM_SUBSCRIPTIONS
+        .get(&format!("{org_id}/{name}"))
+        .map(|v| v.value().clone())
+}
+
+pub fn list(org_id: &str) -> Vec<StreamSubscription> {
+    let prefix = format!("{org_id}/");
+    let mut items: Vec<StreamSubscription> = STREAM_SUBSCRIPTIONS
+        .iter()
+        .filter(|v| v.key().starts_with(&prefix))
+        .map(|v| v.value().clone())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    items
+}
+
+pub async fn set(org_id: &str, subscription: &StreamSubscription) -> Result<(), anyhow::Error> {
+    let key = format!("{org_id}/{}", subscription.name);
+    db::put(
+        &format!("{SUBSCRIPTIONS_KEY}{key}"),
+        json::to_vec(subscription).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    STREAM_SUBSCRIPTIONS.insert(key, subscription.clone());
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{org_id}/{name}");
+    db::delete(
+        &format!("{SUBSCRIPTIONS_KEY}{key}"),
+        false,
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    STREAM_SUBSCRIPTIONS.remove(&key);
+    Ok(())
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = SUBSCRIPTIONS_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching stream subscriptions");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_subscriptions: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                let item_value: StreamSubscription =
+                    if config::get_config().common.meta_store_external {
+                        match db::get(&ev.key).await {
+                            Ok(val) => match json::from_slice(&val) {
+                                Ok(val) => val,
+                                Err(e) => {
+                                    log::error!("Error getting value: {}", e);
+                                    continue;
+                                }
+                            },
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        }
+                    } else {
+                        json::from_slice(&ev.value.unwrap()).unwrap()
+                    };
+                STREAM_SUBSCRIPTIONS.insert(item_key.to_string(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                STREAM_SUBSCRIPTIONS.remove(item_key);
+            }
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let ret = db::list(SUBSCRIPTIONS_KEY).await?;
+    for (item_key, item_value) in ret {
+        let item_key = item_key.strip_prefix(SUBSCRIPTIONS_KEY).unwrap();
+        let subscription: StreamSubscription = json::from_slice(&item_value).unwrap();
+        STREAM_SUBSCRIPTIONS.insert(item_key.to_string(), subscription);
+    }
+    log::info!("Stream subscriptions Cached");
+    Ok(())
+}
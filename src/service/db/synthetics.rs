@@ -0,0 +1,78 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::synthetics::SyntheticCheck, service::db};
+
+const SYNTHETICS_KEY: &str = "/synthetics/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<SyntheticCheck, anyhow::Error> {
+    let val = db::get(&format!("{SYNTHETICS_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, check: &SyntheticCheck) -> Result<(), anyhow::Error> {
+    let key = format!("{SYNTHETICS_KEY}{org_id}/{}", check.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(check).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving synthetic check: {}", e);
+        return Err(anyhow::anyhow!("Error saving synthetic check: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{SYNTHETICS_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting synthetic check: {}", e);
+        return Err(anyhow::anyhow!("Error deleting synthetic check: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<SyntheticCheck>, anyhow::Error> {
+    let mut items: Vec<SyntheticCheck> = db::list_values(&format!("{SYNTHETICS_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
+
+/// Lists the checks of all the organizations
+pub async fn list_all() -> Result<Vec<(String, SyntheticCheck)>, anyhow::Error> {
+    let mut items = Vec::new();
+    for (key, val) in db::list(SYNTHETICS_KEY).await? {
+        let Some((org_id, _)) = key
+            .strip_prefix(SYNTHETICS_KEY)
+            .and_then(|k| k.split_once('/'))
+        else {
+            continue;
+        };
+        match json::from_slice(&val) {
+            Ok(check) => items.push((org_id.to_string(), check)),
+            Err(e) => log::error!("Error parsing synthetic check {key}: {e}"),
+        }
+    }
+    Ok(items)
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::utils::json;
+
+use crate::{
+    common::{infra::config::USER_LOGIN_SESSIONS, meta::user::UserSession},
+    service::db,
+};
+
+// DBKey to store native login sessions, keyed by session id
+pub const USER_LOGIN_SESSION_KEY: &str = "/user_login_sessions/";
+
+pub async fn get(session_id: &str) -> Result<UserSession, anyhow::Error> {
+    if let Some(val) = USER_LOGIN_SESSIONS.get(session_id) {
+        return Ok(val.value().clone());
+    }
+    let val = db::get(&format!("{USER_LOGIN_SESSION_KEY}{session_id}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(session: &UserSession) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{USER_LOGIN_SESSION_KEY}{}", session.id),
+        json::to_vec(session).unwrap().into(),
+        db::NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(session_id: &str) -> Result<(), anyhow::Error> {
+    Ok(db::delete(
+        &format!("{USER_LOGIN_SESSION_KEY}{session_id}"),
+        false,
+        db::NEED_WATCH,
+        None,
+    )
+    .await?)
+}
+
+pub fn list_by_user(user_email: &str) -> Vec<UserSession> {
+    let mut sessions: Vec<UserSession> = USER_LOGIN_SESSIONS
+        .iter()
+        .filter(|s| s.user_email.eq(user_email))
+        .map(|s| s.value().clone())
+        .collect();
+    sessions.sort_by_key(|s| s.created_at);
+    sessions
+}
+
+/// Lists all the sessions from the meta store, including the expired ones
+pub async fn list() -> Result<Vec<UserSession>, anyhow::Error> {
+    let key = USER_LOGIN_SESSION_KEY;
+    let ret = db::list(key).await?;
+    let mut sessions = Vec::with_capacity(ret.len());
+    for (item_key, item_value) in ret {
+        match json::from_slice(&item_value) {
+            Ok(v) => sessions.push(v),
+            Err(e) => log::error!("Error parsing user login session {item_key}: {e}"),
+        }
+    }
+    Ok(sessions)
+}
+
+pub async fn watch() -> Result<(), anyhow::Error> {
+    let key = USER_LOGIN_SESSION_KEY;
+    let cluster_coordinator = db::get_coordinator().await;
+    let mut events = cluster_coordinator.watch(key).await?;
+    let events = Arc::get_mut(&mut events).unwrap();
+    log::info!("Start watching user login sessions");
+    loop {
+        let ev = match events.recv().await {
+            Some(ev) => ev,
+            None => {
+                log::error!("watch_user_login_sessions: event channel closed");
+                return Ok(());
+            }
+        };
+        match ev {
+            db::Event::Put(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                let item_value: UserSession = if config::get_config().common.meta_store_external {
+                    match db::get(&ev.key).await {
+                        Ok(val) => match json::from_slice(&val) {
+                            Ok(val) => val,
+                            Err(e) => {
+                                log::error!("Error getting value: {}", e);
+                                continue;
+                            }
+                        },
+                        Err(e) => {
+                            log::error!("Error getting value: {}", e);
+                            continue;
+                        }
+                    }
+                } else {
+                    json::from_slice(&ev.value.unwrap()).unwrap()
+                };
+                USER_LOGIN_SESSIONS.insert(item_key.to_string(), item_value);
+            }
+            db::Event::Delete(ev) => {
+                let item_key = ev.key.strip_prefix(key).unwrap();
+                USER_LOGIN_SESSIONS.remove(item_key);
+            }
+            db::Event::Empty => {}
+        }
+    }
+}
+
+pub async fn cache() -> Result<(), anyhow::Error> {
+    let key = USER_LOGIN_SESSION_KEY;
+    let ret = db::list(key).await?;
+    for (item_key, item_value) in ret {
+        let session_id = item_key.strip_prefix(key).unwrap();
+        let session: UserSession = match json::from_slice(&item_value) {
+            Ok(v) => v,
+            Err(e) => {
+                log::error!("Error parsing user login session {session_id}: {e}");
+                continue;
+            }
+        };
+        USER_LOGIN_SESSIONS.insert(session_id.to_owned(), session);
+    }
+    log::info!("User Login Sessions Cached");
+    Ok(())
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+use infra::errors::{DbError, Error};
+
+use crate::{common::meta::user::UserMfa, service::db};
+
+// DBKey to store the MFA settings of a user, keyed by email
+pub const USER_MFA_KEY: &str = "/user_mfa/";
+
+pub async fn get(user_email: &str) -> Result<Option<UserMfa>, anyhow::Error> {
+    match db::get(&format!("{USER_MFA_KEY}{user_email}")).await {
+        Ok(val) => Ok(Some(json::from_slice(&val)?)),
+        Err(Error::DbError(DbError::KeyNotExists(_))) => Ok(None),
+        Err(e) => Err(e.into()),
+    }
+}
+
+pub async fn set(user_email: &str, mfa: &UserMfa) -> Result<(), anyhow::Error> {
+    db::put(
+        &format!("{USER_MFA_KEY}{user_email}"),
+        json::to_vec(mfa).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?;
+    Ok(())
+}
+
+pub async fn delete(user_email: &str) -> Result<(), anyhow::Error> {
+    Ok(db::delete(
+        &format!("{USER_MFA_KEY}{user_email}"),
+        false,
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await?)
+}
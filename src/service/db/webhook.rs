@@ -0,0 +1,60 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use crate::{common::meta::webhook::WebhookSource, service::db};
+
+const WEBHOOK_KEY: &str = "/webhook/";
+
+pub async fn get(org_id: &str, name: &str) -> Result<WebhookSource, anyhow::Error> {
+    let val = db::get(&format!("{WEBHOOK_KEY}{org_id}/{name}")).await?;
+    Ok(json::from_slice(&val)?)
+}
+
+pub async fn set(org_id: &str, source: &WebhookSource) -> Result<(), anyhow::Error> {
+    let key = format!("{WEBHOOK_KEY}{org_id}/{}", source.name);
+    if let Err(e) = db::put(
+        &key,
+        json::to_vec(source).unwrap().into(),
+        db::NO_NEED_WATCH,
+        None,
+    )
+    .await
+    {
+        log::error!("Error saving webhook: {}", e);
+        return Err(anyhow::anyhow!("Error saving webhook: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn delete(org_id: &str, name: &str) -> Result<(), anyhow::Error> {
+    let key = format!("{WEBHOOK_KEY}{org_id}/{name}");
+    if let Err(e) = db::delete(&key, false, db::NO_NEED_WATCH, None).await {
+        log::error!("Error deleting webhook: {}", e);
+        return Err(anyhow::anyhow!("Error deleting webhook: {}", e));
+    }
+    Ok(())
+}
+
+pub async fn list(org_id: &str) -> Result<Vec<WebhookSource>, anyhow::Error> {
+    let mut items: Vec<WebhookSource> = db::list_values(&format!("{WEBHOOK_KEY}{org_id}/"))
+        .await?
+        .iter()
+        .filter_map(|val| json::from_slice(val).ok())
+        .collect();
+    items.sort_by(|a, b| a.name.cmp(&b.name));
+    Ok(items)
+}
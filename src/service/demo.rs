@@ -0,0 +1,412 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Seeding of an organization with generated data, for demos and e2e tests:
+//! correlated logs, traces and metrics ingested in process, a dashboard over
+//! them and a disabled alert on the error logs.
+
+use std::io::Error;
+
+use actix_web::{web, HttpResponse};
+use chrono::Utc;
+use config::{
+    cluster, get_config,
+    meta::stream::StreamType,
+    utils::{json, json::Value},
+};
+use opentelemetry_proto::tonic::{
+    collector::{
+        logs::v1::ExportLogsServiceRequest, metrics::v1::ExportMetricsServiceRequest,
+        trace::v1::ExportTraceServiceRequest,
+    },
+    metrics::v1::metric::Data,
+};
+
+use crate::{
+    cli::generate::correlated,
+    common::{
+        meta::{
+            alerts::{
+                destinations::Destination, templates::Template, Alert, Condition, Operator,
+                QueryCondition, QueryType, TriggerCondition,
+            },
+            authz::Authz,
+            dashboards::{Folder, DEFAULT_FOLDER},
+            demo::{SeedRequest, SeedResponse},
+            http::HttpResponse as MetaHttpResponse,
+        },
+        utils::auth::set_ownership,
+    },
+    service::{alerts, dashboards, db, logs, metrics, traces},
+};
+
+const TEMPLATE_NAME: &str = "demo_template";
+const DESTINATION_NAME: &str = "demo_destination";
+
+/// Seeds the organization, the dashboard and the alert are replaced when they
+/// already exist so the seeding can be repeated
+pub async fn seed(org_id: &str, user_email: &str, req: SeedRequest) -> Result<HttpResponse, Error> {
+    let cfg = get_config();
+    if !cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
+        return Ok(MetaHttpResponse::bad_request(
+            "The seeding needs an ingester node",
+        ));
+    }
+    if let Err(e) = req.validate(cfg.limit.ingest_allowed_upto * 60) {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    let stream = req.stream.trim().to_string();
+
+    let mut resp = SeedResponse {
+        stream: stream.clone(),
+        metric_name: correlated::DURATION_METRIC.to_string(),
+        ..Default::default()
+    };
+    let now = Utc::now().timestamp_nanos_opt().unwrap_or_default();
+    let step = req.minutes * 60 * 1_000_000_000 / req.batches as i64;
+    for i in 0..req.batches {
+        let batch_now = now - step * (req.batches - 1 - i) as i64;
+        let (trace_req, logs_req, metrics_req) =
+            correlated::generate(&mut rand::thread_rng(), req.batch_size, batch_now);
+        resp.spans += count_spans(&trace_req);
+        resp.logs += count_logs(&logs_req);
+        resp.metrics += count_points(&metrics_req);
+        if let Err(e) = ingest(
+            org_id,
+            user_email,
+            &stream,
+            trace_req,
+            logs_req,
+            metrics_req,
+        )
+        .await
+        {
+            log::error!("[DEMO] seeding of org {org_id} failed: {e}");
+            return Ok(MetaHttpResponse::internal_error(e));
+        }
+    }
+
+    if req.dashboard {
+        match save_dashboard(org_id, &stream).await {
+            Ok(dashboard_id) => resp.dashboard_id = Some(dashboard_id),
+            Err(e) => {
+                return Ok(MetaHttpResponse::internal_error(format!(
+                    "Data seeded but the dashboard failed: {e}"
+                )));
+            }
+        }
+    }
+    if req.alert {
+        match save_alert(org_id, &stream).await {
+            Ok(name) => resp.alert = Some(name),
+            Err(e) => {
+                return Ok(MetaHttpResponse::internal_error(format!(
+                    "Data seeded but the alert failed: {e}"
+                )));
+            }
+        }
+    }
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// Ingests one batch, failing on the first signal which is not accepted
+async fn ingest(
+    org_id: &str,
+    user_email: &str,
+    stream: &str,
+    trace_req: ExportTraceServiceRequest,
+    logs_req: ExportLogsServiceRequest,
+    metrics_req: ExportMetricsServiceRequest,
+) -> Result<(), String> {
+    let res = traces::handle_trace_request(org_id, trace_req, false, Some(stream), false)
+        .await
+        .map_err(|e| format!("traces: {e}"))?;
+    check("traces", res)?;
+    let res = logs::otlp_grpc::handle_grpc_request(
+        org_id,
+        logs_req,
+        false,
+        Some(stream),
+        user_email,
+        false,
+    )
+    .await
+    .map_err(|e| format!("logs: {e}"))?;
+    check("logs", res)?;
+    let res = metrics::otlp_grpc::handle_grpc_request(org_id, metrics_req, false)
+        .await
+        .map_err(|e| format!("metrics: {e}"))?;
+    check("metrics", res)
+}
+
+fn check(signal: &str, res: HttpResponse) -> Result<(), String> {
+    if res.status().is_success() {
+        Ok(())
+    } else {
+        Err(format!("{signal} rejected with status {}", res.status()))
+    }
+}
+
+fn count_spans(req: &ExportTraceServiceRequest) -> usize {
+    req.resource_spans
+        .iter()
+        .flat_map(|r| r.scope_spans.iter())
+        .map(|s| s.spans.len())
+        .sum()
+}
+
+fn count_logs(req: &ExportLogsServiceRequest) -> usize {
+    req.resource_logs
+        .iter()
+        .flat_map(|r| r.scope_logs.iter())
+        .map(|s| s.log_records.len())
+        .sum()
+}
+
+fn count_points(req: &ExportMetricsServiceRequest) -> usize {
+    req.resource_metrics
+        .iter()
+        .flat_map(|r| r.scope_metrics.iter())
+        .flat_map(|s| s.metrics.iter())
+        .map(|m| match &m.data {
+            Some(Data::Histogram(h)) => h.data_points.len(),
+            _ => 0,
+        })
+        .sum()
+}
+
+/// Stores the dashboard of the stream in the default folder, returns its id
+async fn save_dashboard(org_id: &str, stream: &str) -> Result<String, anyhow::Error> {
+    if db::dashboards::folders::get(org_id, DEFAULT_FOLDER)
+        .await
+        .is_err()
+    {
+        let folder = Folder {
+            folder_id: DEFAULT_FOLDER.to_string(),
+            name: DEFAULT_FOLDER.to_string(),
+            description: DEFAULT_FOLDER.to_string(),
+            ..Default::default()
+        };
+        dashboards::folders::save_folder(org_id, folder, true).await?;
+    }
+    let dashboard_id = format!("demo_{stream}");
+    let created = db::dashboards::get(org_id, &dashboard_id, DEFAULT_FOLDER)
+        .await
+        .is_err();
+    let body = json::to_vec(&dashboard(stream))?;
+    db::dashboards::put(
+        org_id,
+        &dashboard_id,
+        DEFAULT_FOLDER,
+        web::Bytes::from(body),
+    )
+    .await?;
+    if created {
+        set_ownership(
+            org_id,
+            "dashboards",
+            Authz {
+                obj_id: dashboard_id.clone(),
+                parent_type: "folders".to_string(),
+                parent: DEFAULT_FOLDER.to_string(),
+            },
+        )
+        .await;
+    }
+    Ok(dashboard_id)
+}
+
+/// Returns the dashboard of the requests, errors and span durations of the
+/// stream
+fn dashboard(stream: &str) -> Value {
+    json::json!({
+        "version": 3,
+        "title": format!("Demo - {stream}"),
+        "description": "Generated by the seeding of the demo data",
+        "tabs": [{
+            "tabId": "default",
+            "name": "Default",
+            "panels": [
+                panel(
+                    1,
+                    "Requests",
+                    "line",
+                    StreamType::Logs,
+                    stream,
+                    format!("SELECT histogram(_timestamp) AS \"x_axis_1\", count(*) AS \"y_axis_1\" FROM \"{stream}\" GROUP BY x_axis_1 ORDER BY x_axis_1"),
+                    0,
+                ),
+                panel(
+                    2,
+                    "Errors",
+                    "bar",
+                    StreamType::Logs,
+                    stream,
+                    format!("SELECT histogram(_timestamp) AS \"x_axis_1\", count(*) AS \"y_axis_1\" FROM \"{stream}\" WHERE severity = 'ERROR' GROUP BY x_axis_1 ORDER BY x_axis_1"),
+                    24,
+                ),
+                panel(
+                    3,
+                    "Average span duration by service",
+                    "bar",
+                    StreamType::Traces,
+                    stream,
+                    format!("SELECT service_name AS \"x_axis_1\", avg(duration) AS \"y_axis_1\" FROM \"{stream}\" GROUP BY x_axis_1 ORDER BY x_axis_1"),
+                    48,
+                ),
+            ],
+        }],
+    })
+}
+
+/// Returns a panel of a custom query, the panels are laid out in a row
+fn panel(
+    id: usize,
+    title: &str,
+    typ: &str,
+    stream_type: StreamType,
+    stream: &str,
+    sql: String,
+    x: i64,
+) -> Value {
+    let axis = |column: &str| {
+        json::json!({
+            "label": column,
+            "alias": column,
+            "column": column,
+            "color": null,
+        })
+    };
+    json::json!({
+        "id": format!("Panel_ID{id}"),
+        "type": typ,
+        "title": title,
+        "description": "",
+        "config": {
+            "show_legends": true,
+            "legends_position": null,
+            "base_map": null,
+            "map_view": null,
+        },
+        "queryType": "sql",
+        "queries": [{
+            "query": sql,
+            "customQuery": true,
+            "fields": {
+                "stream": stream,
+                "stream_type": stream_type,
+                "x": [axis("x_axis_1")],
+                "y": [axis("y_axis_1")],
+                "filter": [],
+            },
+            "config": {
+                "promql_legend": "",
+            },
+        }],
+        "layout": {
+            "x": x,
+            "y": 0,
+            "w": 24,
+            "h": 9,
+            "i": id,
+        },
+    })
+}
+
+/// Stores the alert on the error logs of the stream with its template and
+/// destination, disabled so the demo sends no notification
+async fn save_alert(org_id: &str, stream: &str) -> Result<String, anyhow::Error> {
+    let template = Template {
+        name: TEMPLATE_NAME.to_string(),
+        body: r#"{"text": "{alert_name} on {stream_name}: {alert_count} errors"}"#.to_string(),
+        ..Default::default()
+    };
+    let exists = db::alerts::templates::get(org_id, TEMPLATE_NAME)
+        .await
+        .is_ok();
+    alerts::templates::save(org_id, "", template, !exists).await?;
+
+    let destination: Destination = json::from_value(json::json!({
+        "name": DESTINATION_NAME,
+        "url": "http://localhost:5080/healthz",
+        "method": "get",
+        "template": TEMPLATE_NAME,
+    }))?;
+    let exists = db::alerts::destinations::get(org_id, DESTINATION_NAME)
+        .await
+        .is_ok();
+    alerts::destinations::save(org_id, "", destination, !exists)
+        .await
+        .map_err(|(_, e)| e)?;
+
+    let alert = Alert {
+        name: format!("demo_{stream}_errors"),
+        stream_type: StreamType::Logs,
+        query_condition: QueryCondition {
+            query_type: QueryType::Custom,
+            conditions: Some(vec![Condition {
+                column: "severity".to_string(),
+                operator: Operator::EqualTo,
+                value: json::json!("ERROR"),
+                ignore_case: false,
+            }]),
+            ..Default::default()
+        },
+        trigger_condition: TriggerCondition {
+            period: 10,
+            operator: Operator::GreaterThanEquals,
+            threshold: 5,
+            ..Default::default()
+        },
+        destinations: vec![DESTINATION_NAME.to_string()],
+        description: "Errors of the demo data".to_string(),
+        enabled: false,
+        ..Default::default()
+    };
+    let exists = db::alerts::get(org_id, StreamType::Logs, stream, &alert.name)
+        .await?
+        .is_some();
+    let name = alert.name.clone();
+    alerts::save(org_id, stream, "", alert, !exists).await?;
+    Ok(name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::common::meta::dashboards::v3;
+
+    #[test]
+    fn test_dashboard() {
+        let dashboard: v3::Dashboard = json::from_value(dashboard("demo")).unwrap();
+        assert_eq!(dashboard.title, "Demo - demo");
+        assert_eq!(dashboard.tabs.len(), 1);
+        assert_eq!(dashboard.tabs[0].panels.len(), 3);
+        assert_eq!(
+            dashboard.tabs[0].panels[2].queries[0].fields.stream_type,
+            StreamType::Traces
+        );
+    }
+
+    #[test]
+    fn test_counts() {
+        let (traces, logs, metrics) =
+            correlated::generate(&mut rand::thread_rng(), 5, 1_700_000_000_000_000_000);
+        assert!(count_spans(&traces) >= 5);
+        assert!(count_logs(&logs) > 0);
+        assert!(count_logs(&logs) <= count_spans(&traces));
+        assert!(count_points(&metrics) > 0);
+    }
+}
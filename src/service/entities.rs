@@ -0,0 +1,476 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Inventory of the hosts, services, pods and containers seen in the resource
+//! attributes of OTLP ingestion. Every ingester tracks what it sees and
+//! periodically stores it in the meta store, lists merge all the nodes.
+
+use std::{
+    collections::{BTreeMap, HashMap},
+    io::Error,
+};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    cluster, get_config,
+    meta::stream::StreamType,
+    utils::{flatten, json},
+    RwHashMap,
+};
+use once_cell::sync::Lazy;
+
+use super::db;
+use crate::common::meta::{
+    entity::{Entity, EntityKind, EntityList, EntityStream},
+    http::HttpResponse as MetaHttpResponse,
+};
+
+/// Maximum number of streams remembered for an entity
+const MAX_ENTITY_STREAMS: usize = 100;
+
+/// Prefix used by the traces ingestion for resource attributes
+const RESOURCE_PREFIX: &str = "service_";
+
+struct EntitySpec {
+    kind: EntityKind,
+    /// attributes identifying the entity, the first one found is used
+    ids: &'static [&'static str],
+    /// attribute scoping the identifier
+    namespace: Option<&'static str>,
+    /// descriptive attributes kept with the entity
+    attributes: &'static [&'static str],
+}
+
+const ENTITY_SPECS: [EntitySpec; 4] = [
+    EntitySpec {
+        kind: EntityKind::Host,
+        ids: &["host_name", "host_id"],
+        namespace: None,
+        attributes: &[
+            "host_id",
+            "host_arch",
+            "os_type",
+            "cloud_provider",
+            "cloud_region",
+        ],
+    },
+    EntitySpec {
+        kind: EntityKind::Service,
+        ids: &["service_name"],
+        namespace: Some("service_namespace"),
+        attributes: &[
+            "service_version",
+            "service_instance_id",
+            "deployment_environment",
+        ],
+    },
+    EntitySpec {
+        kind: EntityKind::Pod,
+        ids: &["k8s_pod_name"],
+        namespace: Some("k8s_namespace_name"),
+        attributes: &["k8s_node_name", "k8s_pod_uid", "k8s_deployment_name"],
+    },
+    EntitySpec {
+        kind: EntityKind::Container,
+        ids: &["container_id", "container_name"],
+        namespace: None,
+        attributes: &["container_name", "container_image_name"],
+    },
+];
+
+/// Entities seen by this node, keyed by organization and entity id
+static ENTITIES: Lazy<RwHashMap<String, HashMap<String, Entity>>> = Lazy::new(Default::default);
+
+/// An entity found in the resource attributes of a request
+#[derive(Clone, Debug, PartialEq)]
+pub struct ObservedEntity {
+    pub kind: EntityKind,
+    pub name: String,
+    pub value: String,
+    /// field holding `value` in the ingested records
+    pub field: String,
+    pub attributes: BTreeMap<String, String>,
+}
+
+fn is_known_attribute(name: &str) -> bool {
+    ENTITY_SPECS.iter().any(|spec| {
+        spec.ids.contains(&name) || spec.namespace == Some(name) || spec.attributes.contains(&name)
+    })
+}
+
+/// Extracts the entities described by resource attributes. Keys are the
+/// names the attributes are stored with in the records.
+pub fn from_attributes<'a, I>(attrs: I) -> Vec<ObservedEntity>
+where
+    I: IntoIterator<Item = (&'a str, &'a json::Value)>,
+{
+    // attribute name -> (field, value)
+    let mut found: HashMap<String, (String, String)> = HashMap::new();
+    for (key, value) in attrs {
+        let value = match value {
+            json::Value::String(v) => v.to_string(),
+            json::Value::Null | json::Value::Array(_) | json::Value::Object(_) => continue,
+            v => v.to_string(),
+        };
+        if value.is_empty() {
+            continue;
+        }
+        let mut field = key.to_string();
+        flatten::format_key(&mut field);
+        let name = match field.strip_prefix(RESOURCE_PREFIX) {
+            Some(name) if is_known_attribute(name) => name.to_string(),
+            _ if is_known_attribute(&field) => field.clone(),
+            _ => continue,
+        };
+        found.entry(name).or_insert((field, value));
+    }
+
+    let mut entities = Vec::new();
+    for spec in ENTITY_SPECS.iter() {
+        let Some((field, value)) = spec.ids.iter().find_map(|id| found.get(*id)) else {
+            continue;
+        };
+        let mut attributes = BTreeMap::new();
+        for name in spec.attributes.iter().chain(spec.namespace.iter()) {
+            if let Some((_, v)) = found.get(*name) {
+                attributes.insert(name.to_string(), v.to_string());
+            }
+        }
+        let name = match spec.namespace.and_then(|ns| found.get(ns)) {
+            Some((_, ns)) => format!("{ns}/{value}"),
+            None => value.to_string(),
+        };
+        entities.push(ObservedEntity {
+            kind: spec.kind,
+            name,
+            value: value.to_string(),
+            field: field.to_string(),
+            attributes,
+        });
+    }
+    entities
+}
+
+/// Records that the entities sent telemetry to the stream
+pub fn observe(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    entities: &[ObservedEntity],
+) {
+    let cfg = get_config();
+    if entities.is_empty() || cfg.limit.entity_inventory_interval == 0 {
+        return;
+    }
+    let now = Utc::now().timestamp_micros();
+    let mut org = ENTITIES.entry(org_id.to_string()).or_default();
+    for e in entities {
+        let id = format!("{}/{}", e.kind, e.name);
+        if !org.contains_key(&id) {
+            if org.len() >= cfg.limit.entity_inventory_max_entities {
+                continue;
+            }
+            org.insert(
+                id.clone(),
+                Entity {
+                    kind: e.kind,
+                    name: e.name.to_string(),
+                    value: e.value.to_string(),
+                    attributes: BTreeMap::new(),
+                    streams: Vec::new(),
+                    depends_on: Vec::new(),
+                    first_seen: now,
+                    last_seen: now,
+                },
+            );
+        }
+        let entity = org.get_mut(&id).unwrap();
+        entity.last_seen = now;
+        for (k, v) in e.attributes.iter() {
+            if entity.attributes.get(k) != Some(v) {
+                entity.attributes.insert(k.to_string(), v.to_string());
+            }
+        }
+        if entity.streams.len() < MAX_ENTITY_STREAMS {
+            entity.add_stream(EntityStream {
+                stream_type,
+                stream_name: stream_name.to_string(),
+                field: e.field.to_string(),
+                query: String::new(),
+            });
+        }
+    }
+}
+
+/// Span attributes naming the service called by a client span, by priority
+const PEER_ATTRIBUTES: [&str; 5] = [
+    "peer.service",
+    "db.system",
+    "messaging.system",
+    "rpc.service",
+    "server.address",
+];
+
+/// Returns the service called by a client or producer span
+pub fn peer_service(attributes: &HashMap<String, json::Value>) -> Option<String> {
+    PEER_ATTRIBUTES.iter().find_map(|key| {
+        attributes
+            .get(*key)
+            .and_then(|v| v.as_str())
+            .filter(|v| !v.is_empty())
+            .map(|v| v.to_string())
+    })
+}
+
+/// Records the services called by the service of the resource
+pub fn observe_dependencies(
+    org_id: &str,
+    entities: &[ObservedEntity],
+    peers: &std::collections::HashSet<String>,
+) {
+    if peers.is_empty() || get_config().limit.entity_inventory_interval == 0 {
+        return;
+    }
+    let Some(service) = entities.iter().find(|e| e.kind == EntityKind::Service) else {
+        return;
+    };
+    let Some(mut org) = ENTITIES.get_mut(org_id) else {
+        return;
+    };
+    let Some(entity) = org.get_mut(&format!("{}/{}", service.kind, service.name)) else {
+        return;
+    };
+    for peer in peers {
+        if entity.depends_on.len() >= MAX_ENTITY_STREAMS {
+            break;
+        }
+        if peer != &service.value {
+            entity.add_dependency(peer);
+        }
+    }
+}
+
+/// Returns the services called by every service, keyed by service name
+pub async fn service_dependencies(
+    org_id: &str,
+) -> Result<HashMap<String, Vec<String>>, anyhow::Error> {
+    Ok(list_entities(org_id)
+        .await?
+        .into_iter()
+        .filter(|e| e.kind == EntityKind::Service)
+        .map(|e| (e.value, e.depends_on))
+        .collect())
+}
+
+/// Returns the services which sent telemetry to the stream
+pub async fn stream_services(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<Vec<String>, anyhow::Error> {
+    Ok(list_entities(org_id)
+        .await?
+        .into_iter()
+        .filter(|e| {
+            e.kind == EntityKind::Service
+                && e.streams
+                    .iter()
+                    .any(|s| s.stream_type == stream_type && s.stream_name == stream_name)
+        })
+        .map(|e| e.value)
+        .collect())
+}
+
+/// Writes the entities seen by this node to the meta store
+pub async fn flush() -> Result<(), anyhow::Error> {
+    let orgs = ENTITIES.iter().map(|v| v.key().clone()).collect::<Vec<_>>();
+    for org_id in orgs {
+        let entities = match ENTITIES.get(&org_id) {
+            Some(v) => v.values().cloned().collect::<Vec<_>>(),
+            None => continue,
+        };
+        db::entities::set(&org_id, &cluster::LOCAL_NODE_UUID, &entities).await?;
+    }
+    Ok(())
+}
+
+/// SQL query returning the records of the entity in the stream
+fn telemetry_query(stream: &EntityStream, value: &str) -> String {
+    format!(
+        "SELECT * FROM \"{}\" WHERE \"{}\" = '{}'",
+        stream.stream_name,
+        stream.field,
+        value.replace('\'', "''")
+    )
+}
+
+/// Merges the entities of all the nodes, including what this node has not
+/// flushed yet
+async fn list_entities(org_id: &str) -> Result<Vec<Entity>, anyhow::Error> {
+    let mut lists = db::entities::list(org_id).await?;
+    if let Some(local) = ENTITIES.get(org_id) {
+        lists.push(local.values().cloned().collect());
+    }
+    let mut merged: HashMap<String, Entity> = HashMap::new();
+    for entity in lists.into_iter().flatten() {
+        match merged.get_mut(&entity.id()) {
+            Some(v) => v.merge(&entity, MAX_ENTITY_STREAMS),
+            None => {
+                merged.insert(entity.id(), entity);
+            }
+        }
+    }
+    let mut entities = merged.into_values().collect::<Vec<_>>();
+    for entity in entities.iter_mut() {
+        for stream in entity.streams.iter_mut() {
+            stream.query = telemetry_query(stream, &entity.value);
+        }
+    }
+    entities.sort_by(|a, b| a.kind.cmp(&b.kind).then_with(|| a.name.cmp(&b.name)));
+    Ok(entities)
+}
+
+#[tracing::instrument]
+pub async fn list(
+    org_id: &str,
+    kind: Option<EntityKind>,
+    search: Option<&str>,
+) -> Result<HttpResponse, Error> {
+    let search = search.map(|v| v.to_lowercase());
+    match list_entities(org_id).await {
+        Ok(list) => {
+            let list = list
+                .into_iter()
+                .filter(|e| kind.map(|k| e.kind == k).unwrap_or(true))
+                .filter(|e| {
+                    search
+                        .as_ref()
+                        .map(|s| e.name.to_lowercase().contains(s))
+                        .unwrap_or(true)
+                })
+                .collect();
+            Ok(MetaHttpResponse::json(EntityList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get(org_id: &str, kind: EntityKind, name: &str) -> Result<HttpResponse, Error> {
+    match list_entities(org_id).await {
+        Ok(list) => match list.into_iter().find(|e| e.kind == kind && e.name == name) {
+            Some(entity) => Ok(MetaHttpResponse::json(entity)),
+            None => Ok(MetaHttpResponse::not_found("Entity not found")),
+        },
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_from_attributes() {
+        let attrs = json::json!({
+            "service.name": "checkout",
+            "service.namespace": "shop",
+            "host.name": "node-1",
+            "os.type": "linux",
+            "k8s.pod.name": "checkout-7d9f",
+            "k8s.namespace.name": "prod",
+            "telemetry.sdk.name": "opentelemetry",
+        });
+        let attrs = attrs.as_object().unwrap();
+        let mut entities = from_attributes(attrs.iter().map(|(k, v)| (k.as_str(), v)));
+        entities.sort_by(|a, b| a.kind.cmp(&b.kind));
+        assert_eq!(entities.len(), 3);
+
+        assert_eq!(entities[0].kind, EntityKind::Host);
+        assert_eq!(entities[0].name, "node-1");
+        assert_eq!(entities[0].field, "host_name");
+        assert_eq!(entities[0].attributes["os_type"], "linux");
+
+        assert_eq!(entities[1].kind, EntityKind::Service);
+        assert_eq!(entities[1].name, "shop/checkout");
+        assert_eq!(entities[1].value, "checkout");
+
+        assert_eq!(entities[2].kind, EntityKind::Pod);
+        assert_eq!(entities[2].name, "prod/checkout-7d9f");
+        assert_eq!(entities[2].field, "k8s_pod_name");
+    }
+
+    #[test]
+    fn test_from_attributes_prefixed() {
+        // traces store resource attributes as `service.<key>`
+        let attrs = json::json!({
+            "service.name": "checkout",
+            "service.host.name": "node-1",
+            "service.service.version": "1.2.0",
+        });
+        let attrs = attrs.as_object().unwrap();
+        let mut entities = from_attributes(attrs.iter().map(|(k, v)| (k.as_str(), v)));
+        entities.sort_by(|a, b| a.kind.cmp(&b.kind));
+        assert_eq!(entities.len(), 2);
+        assert_eq!(entities[0].field, "service_host_name");
+        assert_eq!(entities[1].field, "service_name");
+        assert_eq!(entities[1].attributes["service_version"], "1.2.0");
+    }
+
+    #[test]
+    fn test_observe() {
+        let attrs = json::json!({"container.id": "abc", "container.name": "web"});
+        let attrs = attrs.as_object().unwrap();
+        let entities = from_attributes(attrs.iter().map(|(k, v)| (k.as_str(), v)));
+        observe("test_entities", StreamType::Logs, "default", &entities);
+        observe("test_entities", StreamType::Logs, "default", &entities);
+        observe("test_entities", StreamType::Metrics, "cpu", &entities);
+        let org = ENTITIES.get("test_entities").unwrap();
+        let entity = org.get("container/abc").unwrap();
+        assert_eq!(entity.attributes["container_name"], "web");
+        assert_eq!(entity.streams.len(), 2);
+        assert_eq!(
+            telemetry_query(&entity.streams[0], "it's"),
+            "SELECT * FROM \"default\" WHERE \"container_id\" = 'it''s'"
+        );
+    }
+
+    #[test]
+    fn test_observe_dependencies() {
+        let attrs = json::json!({"service.name": "checkout"});
+        let attrs = attrs.as_object().unwrap();
+        let entities = from_attributes(attrs.iter().map(|(k, v)| (k.as_str(), v)));
+        observe(
+            "test_dependencies",
+            StreamType::Traces,
+            "default",
+            &entities,
+        );
+
+        let span_attrs = HashMap::from([
+            ("db.system".to_string(), json::json!("postgresql")),
+            ("server.address".to_string(), json::json!("db:5432")),
+        ]);
+        let peer = peer_service(&span_attrs).unwrap();
+        assert_eq!(peer, "postgresql");
+        let peers = std::collections::HashSet::from([peer, "checkout".to_string()]);
+        observe_dependencies("test_dependencies", &entities, &peers);
+
+        let org = ENTITIES.get("test_dependencies").unwrap();
+        let entity = org.get("service/checkout").unwrap();
+        assert_eq!(entity.depends_on, vec!["postgresql"]);
+    }
+}
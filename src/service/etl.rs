@@ -0,0 +1,446 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding},
+        stream::StreamType,
+        usage::{TriggerData, TriggerDataStatus, TriggerDataType},
+    },
+    utils::json,
+};
+use proto::cluster_rpc;
+
+use super::{
+    db, format_stream_name, search as SearchService,
+    usage::{ingestion_service, publish_triggers_usage},
+};
+use crate::common::meta::{
+    etl::{partition_dates, EtlCheckpoint, EtlJob, EtlJobList, EtlRun, EtlWriteMode},
+    http::HttpResponse as MetaHttpResponse,
+};
+
+const PAGE_SIZE: i64 = 10000;
+
+/// Seconds to wait before checking again whether the destination partitions
+/// have been deleted
+const OVERWRITE_CHECK_INTERVAL: i64 = 60;
+
+#[tracing::instrument]
+pub async fn save_job(
+    org_id: &str,
+    user_email: &str,
+    mut job: EtlJob,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    job.name = job.name.trim().to_string();
+    job.destination = format_stream_name(job.destination.trim());
+    if let Err(e) = job.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+
+    let now = Utc::now().timestamp_micros();
+    match db::etl::get(org_id, &job.name).await {
+        Ok(existing) => {
+            if create {
+                return Ok(MetaHttpResponse::bad_request(format!(
+                    "ETL job {} already exists",
+                    job.name
+                )));
+            }
+            // keep the progress of the job, a changed query applies from the
+            // next window on
+            job.owner = existing.owner;
+            job.created_at = existing.created_at;
+            job.last_end_time = existing.last_end_time;
+            job.pending_overwrite = existing.pending_overwrite;
+            job.checkpoint = existing.checkpoint;
+            job.last_run = existing.last_run;
+        }
+        Err(_) => {
+            if !create {
+                return Ok(MetaHttpResponse::not_found("ETL job not found"));
+            }
+            job.owner = user_email.to_string();
+            job.created_at = now;
+            job.last_end_time = 0;
+            job.pending_overwrite = 0;
+            job.checkpoint = None;
+            job.last_run = None;
+        }
+    }
+    job.updated_at = now;
+
+    match db::etl::set(org_id, &job, job.next_run_at(now)).await {
+        Ok(_) => Ok(MetaHttpResponse::json(job)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_jobs(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::etl::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(EtlJobList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_job(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::etl::get(org_id, name).await {
+        Ok(job) => Ok(MetaHttpResponse::json(job)),
+        Err(_) => Ok(MetaHttpResponse::not_found("ETL job not found")),
+    }
+}
+
+/// Deletes the job, the data already written to the destination is kept
+#[tracing::instrument]
+pub async fn delete_job(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::etl::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("ETL job not found"));
+    }
+    match db::etl::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("ETL job deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Processes the next complete window of the job and reschedules it
+pub async fn handle_trigger(trigger: db::scheduler::Trigger) -> Result<(), anyhow::Error> {
+    log::debug!(
+        "Inside handle_etl_trigger, org: {}, module_key: {}",
+        &trigger.org,
+        &trigger.module_key
+    );
+    let org_id = &trigger.org;
+    // For etl, trigger.module_key is the job name
+    let name = &trigger.module_key;
+
+    let mut job = db::etl::get(org_id, name).await?;
+    let now = Utc::now().timestamp_micros();
+    let mut new_trigger = db::scheduler::Trigger {
+        next_run_at: job.next_run_at(now),
+        is_realtime: false,
+        is_silenced: false,
+        status: db::scheduler::TriggerStatus::Waiting,
+        retries: 0,
+        ..trigger.clone()
+    };
+
+    if !job.enabled {
+        log::debug!("ETL job not enabled: org: {}, job: {}", org_id, name);
+        new_trigger.next_run_at = now + job.frequency * 1_000_000;
+        db::scheduler::update_trigger(new_trigger).await?;
+        return Ok(());
+    }
+    let Some((start_time, end_time)) = job.next_window(now) else {
+        db::scheduler::update_trigger(new_trigger).await?;
+        return Ok(());
+    };
+
+    if job.write_mode == EtlWriteMode::OverwritePartition
+        && !prepare_overwrite(org_id, &mut job, start_time, end_time).await?
+    {
+        // the partitions are being deleted, write the window once it is done
+        new_trigger.next_run_at = now + OVERWRITE_CHECK_INTERVAL * 1_000_000;
+        db::scheduler::update_trigger(new_trigger).await?;
+        save_state(org_id, &job).await;
+        return Ok(());
+    }
+
+    let mut trigger_data_stream = TriggerData {
+        org: trigger.org.clone(),
+        module: TriggerDataType::Etl,
+        key: trigger.module_key.clone(),
+        next_run_at: new_trigger.next_run_at,
+        is_realtime: trigger.is_realtime,
+        is_silenced: trigger.is_silenced,
+        status: TriggerDataStatus::Completed,
+        start_time: now,
+        end_time: 0,
+        retries: trigger.retries,
+        error: None,
+    };
+
+    let mut run = EtlRun {
+        start_time,
+        end_time,
+        run_at: now,
+        ..Default::default()
+    };
+    match execute(org_id, &mut job, start_time, end_time).await {
+        Ok(records) => {
+            run.records = records;
+            log::info!(
+                "[ETL] job {org_id}/{name} wrote {} records of window [{start_time}, {end_time})",
+                run.records
+            );
+            job.last_end_time = end_time;
+            job.pending_overwrite = 0;
+            job.checkpoint = None;
+            // catch up with the missed windows right away
+            new_trigger.next_run_at = std::cmp::max(job.next_run_at(now), now);
+            trigger_data_stream.next_run_at = new_trigger.next_run_at;
+            db::scheduler::update_trigger(new_trigger).await?;
+        }
+        Err(e) => {
+            log::error!("[ETL] job {org_id}/{name} error: {e}");
+            // an overwritten window has its partitions deleted again and is
+            // written from the start, an appended one resumes from its
+            // checkpoint
+            job.pending_overwrite = 0;
+            if trigger.retries + 1 >= get_config().limit.scheduler_max_retries {
+                // It has been tried the maximum time, try the same window
+                // again after the frequency interval
+                new_trigger.next_run_at = now + job.frequency * 1_000_000;
+                trigger_data_stream.next_run_at = new_trigger.next_run_at;
+                db::scheduler::update_trigger(new_trigger).await?;
+            } else {
+                db::scheduler::update_status(
+                    &new_trigger.org,
+                    new_trigger.module,
+                    &new_trigger.module_key,
+                    db::scheduler::TriggerStatus::Waiting,
+                    trigger.retries + 1,
+                )
+                .await?;
+            }
+            trigger_data_stream.status = TriggerDataStatus::Failed;
+            trigger_data_stream.error = Some(format!("error processing etl job: {e}"));
+            run.error = Some(e.to_string());
+        }
+    }
+    trigger_data_stream.end_time = Utc::now().timestamp_micros();
+    job.last_run = Some(run);
+    save_state(org_id, &job).await;
+    publish_triggers_usage(trigger_data_stream).await;
+
+    Ok(())
+}
+
+/// Requests the deletion of the destination partitions covered by the window,
+/// returns true once they are deleted and the window can be written.
+///
+/// The deletion is done by the compactor, writing before it finishes would
+/// get the new data deleted as well.
+async fn prepare_overwrite(
+    org_id: &str,
+    job: &mut EtlJob,
+    start_time: i64,
+    end_time: i64,
+) -> Result<bool, anyhow::Error> {
+    let (start_date, end_date) = partition_dates(start_time, end_time);
+    let date_range = Some((start_date.as_str(), end_date.as_str()));
+    if db::compact::retention::is_deleting_stream(
+        org_id,
+        StreamType::Logs,
+        &job.destination,
+        date_range,
+    ) {
+        return Ok(false);
+    }
+    if job.pending_overwrite == start_time {
+        return Ok(true);
+    }
+    let exists = infra::schema::get(org_id, &job.destination, StreamType::Logs)
+        .await
+        .map(|schema| !schema.fields().is_empty())
+        .unwrap_or_default();
+    if !exists {
+        return Ok(true);
+    }
+    db::compact::retention::delete_stream(org_id, StreamType::Logs, &job.destination, date_range)
+        .await?;
+    job.pending_overwrite = start_time;
+    Ok(false)
+}
+
+/// Writes the results of the window, returns the records written. In append
+/// mode the progress is saved after every page, so a failed window resumes
+/// where it stopped instead of appending its first pages again.
+async fn execute(
+    org_id: &str,
+    job: &mut EtlJob,
+    start_time: i64,
+    end_time: i64,
+) -> Result<i64, anyhow::Error> {
+    let cfg = get_config();
+    let append = job.write_mode == EtlWriteMode::Append;
+    let mut checkpoint = match job.checkpoint.clone() {
+        Some(checkpoint) if append && checkpoint.start_time == start_time => {
+            log::info!(
+                "[ETL] job {org_id}/{} resumes window [{start_time}, {end_time}) after {} \
+                 records",
+                job.name,
+                checkpoint.written
+            );
+            checkpoint
+        }
+        _ => EtlCheckpoint {
+            start_time,
+            sources: job.sources.clone(),
+            ..Default::default()
+        },
+    };
+    let sources = checkpoint.sources.clone();
+    for (idx, source) in sources.iter().enumerate().skip(checkpoint.source) {
+        if idx != checkpoint.source {
+            checkpoint.source = idx;
+            checkpoint.from = 0;
+        }
+        loop {
+            let written = checkpoint.written;
+            let from = checkpoint.from;
+            let size = std::cmp::min(PAGE_SIZE, cfg.limit.etl_max_records - written);
+            if size <= 0 {
+                log::warn!(
+                    "[ETL] job {org_id}/{} reached the max records limit",
+                    job.name
+                );
+                return Ok(written);
+            }
+            let req = Request {
+                query: Query {
+                    sql: source.query.clone(),
+                    from,
+                    size,
+                    start_time,
+                    end_time,
+                    sql_mode: "full".to_string(),
+                    ..Default::default()
+                },
+                aggs: HashMap::new(),
+                encoding: RequestEncoding::Empty,
+                regions: vec![],
+                clusters: vec![],
+                timeout: 0,
+                search_type: None,
+                search_event_context: None,
+            };
+            let trace_id = config::ider::uuid();
+            let res = SearchService::search(
+                &trace_id,
+                org_id,
+                source.stream_type,
+                Some(job.owner.clone()),
+                &req,
+            )
+            .await?;
+            let hits = res.hits.len() as i64;
+            if hits == 0 {
+                break;
+            }
+
+            let records = res
+                .hits
+                .into_iter()
+                .filter_map(|hit| match hit {
+                    json::Value::Object(mut row) => {
+                        stamp_record(&mut row, &cfg.common.column_timestamp, start_time, end_time);
+                        Some(json::Value::Object(row))
+                    }
+                    _ => None,
+                })
+                .collect::<Vec<_>>();
+            let ingest_req = cluster_rpc::UsageRequest {
+                stream_name: job.destination.clone(),
+                data: Some(cluster_rpc::UsageData::from(records)),
+            };
+            let resp = ingestion_service::ingest(org_id, ingest_req).await?;
+            if resp.status_code != 200 {
+                return Err(anyhow::anyhow!(
+                    "write to stream {} error: {}",
+                    job.destination,
+                    resp.message
+                ));
+            }
+
+            checkpoint.written += hits;
+            checkpoint.from += hits;
+            if append {
+                job.checkpoint = Some(checkpoint.clone());
+                save_state(org_id, job).await;
+            }
+            if hits < size {
+                break;
+            }
+        }
+    }
+    Ok(checkpoint.written)
+}
+
+/// Keeps the timestamp of the record when it falls in the window, otherwise
+/// the record gets the start of the window, e.g. results of aggregations.
+fn stamp_record(
+    row: &mut json::Map<String, json::Value>,
+    column_timestamp: &str,
+    start_time: i64,
+    end_time: i64,
+) {
+    let in_window = row
+        .get(column_timestamp)
+        .and_then(|v| v.as_i64())
+        .map(|ts| ts >= start_time && ts < end_time)
+        .unwrap_or_default();
+    if !in_window {
+        row.insert(column_timestamp.to_string(), start_time.into());
+    }
+}
+
+/// Saves the progress of the job, keeping the changes made to it meanwhile
+async fn save_state(org_id: &str, job: &EtlJob) {
+    let mut current = match db::etl::get(org_id, &job.name).await {
+        Ok(current) => current,
+        Err(_) => return, // deleted meanwhile
+    };
+    current.last_end_time = job.last_end_time;
+    current.pending_overwrite = job.pending_overwrite;
+    current.checkpoint = job.checkpoint.clone();
+    current.last_run = job.last_run.clone();
+    if let Err(e) = db::etl::set_without_updating_trigger(org_id, &current).await {
+        log::error!(
+            "Failed to update etl job: {}/{} after trigger: {e}",
+            org_id,
+            job.name
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_stamp_record() {
+        let mut row = json::Map::new();
+        row.insert("_timestamp".to_string(), 150.into());
+        stamp_record(&mut row, "_timestamp", 100, 200);
+        assert_eq!(row.get("_timestamp").unwrap().as_i64(), Some(150));
+
+        row.insert("_timestamp".to_string(), 200.into());
+        stamp_record(&mut row, "_timestamp", 100, 200);
+        assert_eq!(row.get("_timestamp").unwrap().as_i64(), Some(100));
+
+        let mut row = json::Map::new();
+        row.insert("requests".to_string(), 3.into());
+        stamp_record(&mut row, "_timestamp", 100, 200);
+        assert_eq!(row.get("_timestamp").unwrap().as_i64(), Some(100));
+    }
+}
@@ -34,13 +34,12 @@ use proto::cluster_rpc;
 use tonic::{
     codec::CompressionEncoding,
     metadata::{MetadataKey, MetadataValue},
-    transport::Channel,
     Request,
 };
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 
 use crate::{
-    common::infra::cluster,
+    common::infra::{cluster, tls},
     service::{db, search::MetadataMap},
 };
 
@@ -106,7 +105,7 @@ pub async fn query(
             let token: MetadataValue<_> = cluster::get_internal_grpc_token()
                 .parse()
                 .map_err(|_| Error::Message("invalid token".to_string()))?;
-            let channel = Channel::from_shared(node.grpc_addr.clone())
+            let channel = tls::grpc_endpoint(node.grpc_addr.clone())
                 .unwrap()
                 .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                 .connect()
@@ -218,7 +217,7 @@ pub async fn query(
     let token: MetadataValue<_> = cluster::get_internal_grpc_token()
         .parse()
         .map_err(|_| Error::Message("invalid token".to_string()))?;
-    let channel = Channel::from_shared(node.grpc_addr.clone())
+    let channel = tls::grpc_endpoint(node.grpc_addr.clone())
         .unwrap()
         .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
         .connect()
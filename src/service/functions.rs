@@ -19,21 +19,34 @@ use actix_web::{
     http::{self, StatusCode},
     HttpResponse,
 };
-use config::meta::stream::StreamType;
+use chrono::{Duration, Utc};
+use config::{
+    get_config,
+    meta::stream::StreamType,
+    utils::{flatten, json},
+};
+use vector_enrichment::TableRegistry;
 
 use crate::{
     common::{
         infra::config::STREAM_FUNCTIONS,
         meta::{
             authz::Authz,
+            config_versions::ConfigVersionList,
             functions::{
-                FunctionList, StreamFunctionsList, StreamOrder, StreamTransform, Transform,
+                FunctionList, StreamFunctionsList, StreamOrder, StreamTransform,
+                TestFunctionRequest, TestFunctionResponse, TestFunctionResult, Transform,
+                VRLResultResolver,
             },
             http::HttpResponse as MetaHttpResponse,
         },
         utils::auth::{remove_ownership, set_ownership},
     },
-    service::{db, ingestion::compile_vrl_function},
+    service::{
+        db,
+        ingestion::{compile_vrl_function, init_functions_runtime, try_apply_vrl_fn},
+        search::{self as SearchService, access},
+    },
 };
 
 const FN_SUCCESS: &str = "Function saved successfully";
@@ -44,6 +57,11 @@ const FN_DELETED: &str = "Function deleted";
 const FN_ALREADY_EXIST: &str = "Function already exist";
 const FN_IN_USE: &str =
     "Function is associated with streams, please remove association from streams before deleting:";
+const FN_VERSION_NOT_FOUND: &str = "Function version not found";
+const FN_NO_SHADOW: &str = "Function has no shadow version to promote";
+const FN_VERSION_KIND: &str = "function";
+const FN_TEST_MAX_EVENTS: usize = 100;
+const FN_TEST_DEFAULT_EVENTS: usize = 10;
 
 pub async fn save_function(org_id: String, mut func: Transform) -> Result<HttpResponse, Error> {
     if let Some(_existing_fn) = check_existing_fn(&org_id, &func.name).await {
@@ -52,33 +70,15 @@ pub async fn save_function(org_id: String, mut func: Transform) -> Result<HttpRe
             FN_ALREADY_EXIST.to_string(),
         )))
     } else {
-        if !func.function.ends_with('.') {
-            func.function = format!("{} \n .", func.function);
-        }
-        if func.trans_type.unwrap() == 0 {
-            if let Err(e) = compile_vrl_function(func.function.as_str(), &org_id) {
-                return Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
-                    StatusCode::BAD_REQUEST.into(),
-                    e.to_string(),
-                )));
-            }
+        if let Err(e) = store_function(&org_id, &mut func).await {
+            return Ok(store_error_response(e));
         }
-        extract_num_args(&mut func);
-        if let Err(error) = db::functions::set(&org_id, &func.name, &func).await {
-            Ok(
-                HttpResponse::InternalServerError().json(MetaHttpResponse::message(
-                    http::StatusCode::INTERNAL_SERVER_ERROR.into(),
-                    error.to_string(),
-                )),
-            )
-        } else {
-            set_ownership(&org_id, "functions", Authz::new(&func.name)).await;
+        set_ownership(&org_id, "functions", Authz::new(&func.name)).await;
 
-            Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
-                http::StatusCode::OK.into(),
-                FN_SUCCESS.to_string(),
-            )))
-        }
+        Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
+            http::StatusCode::OK.into(),
+            FN_SUCCESS.to_string(),
+        )))
     }
 }
 
@@ -105,30 +105,131 @@ pub async fn update_function(
     // from existing function
     func.streams = existing_fn.streams;
 
+    if let Err(e) = store_function(org_id, &mut func).await {
+        return Ok(store_error_response(e));
+    }
+    Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
+        http::StatusCode::OK.into(),
+        FN_SUCCESS.to_string(),
+    )))
+}
+
+/// Stores the function as it is, created or replaced, e.g. by the restore of
+/// a snapshot. The function is compiled like one saved through the API.
+pub(crate) async fn put_function(org_id: &str, mut func: Transform) -> Result<(), anyhow::Error> {
+    let created = check_existing_fn(org_id, &func.name).await.is_none();
+    store_function(org_id, &mut func)
+        .await
+        .map_err(|(_, e)| anyhow::anyhow!(e))?;
+    if created {
+        set_ownership(org_id, "functions", Authz::new(&func.name)).await;
+    }
+    Ok(())
+}
+
+/// Compiles and stores the function with a new version
+async fn store_function(org_id: &str, func: &mut Transform) -> Result<(), (StatusCode, String)> {
+    prepare_function(org_id, func).map_err(|e| (StatusCode::BAD_REQUEST, e.to_string()))?;
+    extract_num_args(func);
+    db::functions::set(org_id, &func.name, func)
+        .await
+        .map_err(|e| (StatusCode::INTERNAL_SERVER_ERROR, e.to_string()))?;
+    save_version(org_id, func).await;
+    Ok(())
+}
+
+fn store_error_response((code, e): (StatusCode, String)) -> HttpResponse {
+    if code == StatusCode::BAD_REQUEST {
+        HttpResponse::BadRequest().json(MetaHttpResponse::error(code.into(), e))
+    } else {
+        HttpResponse::InternalServerError().json(MetaHttpResponse::message(code.into(), e))
+    }
+}
+
+pub async fn list_function_versions(org_id: &str, fn_name: &str) -> Result<HttpResponse, Error> {
+    if check_existing_fn(org_id, fn_name).await.is_none() {
+        return Ok(MetaHttpResponse::not_found(FN_NOT_FOUND));
+    }
+    match db::config_versions::list(FN_VERSION_KIND, org_id, fn_name).await {
+        Ok(list) => Ok(MetaHttpResponse::json(ConfigVersionList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Makes a saved version the active one again, the stream associations and
+/// the shadow version are kept as they are
+pub async fn rollback_function(
+    org_id: &str,
+    fn_name: &str,
+    version: u64,
+) -> Result<HttpResponse, Error> {
+    let Some(existing_fn) = check_existing_fn(org_id, fn_name).await else {
+        return Ok(MetaHttpResponse::not_found(FN_NOT_FOUND));
+    };
+    let saved = match db::config_versions::get(FN_VERSION_KIND, org_id, fn_name, version).await {
+        Ok(saved) => saved,
+        Err(_) => {
+            return Ok(MetaHttpResponse::not_found(FN_VERSION_NOT_FOUND));
+        }
+    };
+    let mut func: Transform = match json::from_value(saved.definition) {
+        Ok(func) => func,
+        Err(e) => {
+            return Ok(MetaHttpResponse::internal_error(e));
+        }
+    };
+    func.streams = existing_fn.streams;
+    func.shadow = existing_fn.shadow;
+    update_function(org_id, fn_name, func).await
+}
+
+/// Replaces the active version of the function with its shadow version
+pub async fn promote_function(org_id: &str, fn_name: &str) -> Result<HttpResponse, Error> {
+    let Some(mut func) = check_existing_fn(org_id, fn_name).await else {
+        return Ok(MetaHttpResponse::not_found(FN_NOT_FOUND));
+    };
+    let Some(shadow) = func.shadow.take() else {
+        return Ok(MetaHttpResponse::bad_request(FN_NO_SHADOW));
+    };
+    func.function = shadow;
+    update_function(org_id, fn_name, func).await
+}
+
+/// Completes the function and its shadow version the way they are stored and
+/// checks that they compile
+fn prepare_function(org_id: &str, func: &mut Transform) -> Result<(), Error> {
     if !func.function.ends_with('.') {
         func.function = format!("{} \n .", func.function);
     }
+    if let Some(shadow) = func.shadow.as_mut() {
+        if shadow.trim().is_empty() {
+            func.shadow = None;
+        } else if !shadow.ends_with('.') {
+            *shadow = format!("{} \n .", shadow);
+        }
+    }
     if func.trans_type.unwrap() == 0 {
-        if let Err(e) = compile_vrl_function(&func.function, org_id) {
-            return Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
-                StatusCode::BAD_REQUEST.into(),
-                e.to_string(),
-            )));
+        compile_vrl_function(&func.function, org_id)?;
+        if let Some(shadow) = func.shadow.as_ref() {
+            compile_vrl_function(shadow, org_id)
+                .map_err(|e| Error::new(e.kind(), format!("shadow version: {e}")))?;
         }
+    } else if func.shadow.is_some() {
+        return Err(Error::new(
+            std::io::ErrorKind::InvalidInput,
+            "shadow versions are only supported for VRL functions",
+        ));
     }
-    extract_num_args(&mut func);
-    if let Err(error) = db::functions::set(org_id, &func.name, &func).await {
-        return Ok(
-            HttpResponse::InternalServerError().json(MetaHttpResponse::message(
-                http::StatusCode::INTERNAL_SERVER_ERROR.into(),
-                error.to_string(),
-            )),
-        );
+    Ok(())
+}
+
+async fn save_version(org_id: &str, func: &Transform) {
+    let mut func = func.clone();
+    func.streams = None;
+    func.shadow = None;
+    if let Err(e) = db::config_versions::add(FN_VERSION_KIND, org_id, &func.name, &func).await {
+        log::error!("Error saving version of function {}: {}", func.name, e);
     }
-    Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
-        http::StatusCode::OK.into(),
-        FN_SUCCESS.to_string(),
-    )))
 }
 
 pub async fn list_functions(
@@ -193,6 +294,9 @@ pub async fn delete_function(org_id: String, fn_name: String) -> Result<HttpResp
     match result {
         Ok(_) => {
             remove_ownership(&org_id, "functions", Authz::new(&fn_name)).await;
+            if let Err(e) = db::config_versions::delete(FN_VERSION_KIND, &org_id, &fn_name).await {
+                log::error!("Error deleting versions of function {}: {}", fn_name, e);
+            }
 
             Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
                 http::StatusCode::OK.into(),
@@ -315,6 +419,148 @@ pub async fn add_function_to_stream(
     }
 }
 
+/// Runs a function against sample records without saving it or touching
+/// ingestion, returning the transformed record or the error for every record
+pub async fn test_function(
+    org_id: &str,
+    user_id: &str,
+    req: TestFunctionRequest,
+) -> Result<HttpResponse, Error> {
+    let size = req
+        .size
+        .unwrap_or(FN_TEST_DEFAULT_EVENTS)
+        .clamp(1, FN_TEST_MAX_EVENTS);
+    let events = if !req.events.is_empty() {
+        req.events.into_iter().take(size).collect()
+    } else if let Some(stream_name) = req.stream_name.as_deref() {
+        let stream_type = req.stream_type.unwrap_or_default();
+        // the name is quoted into the query, and must be an existing stream
+        if stream_name.contains('"')
+            || infra::schema::get(org_id, stream_name, stream_type)
+                .await
+                .map(|schema| schema.fields().is_empty())
+                .unwrap_or(true)
+        {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Stream {stream_name} not found"
+            )));
+        }
+        if !access::can_read(org_id, user_id, stream_type, stream_name).await {
+            return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+        }
+        match latest_records(org_id, user_id, stream_type, stream_name, size).await {
+            Ok(events) => events,
+            Err(e) => {
+                return Ok(MetaHttpResponse::internal_error(e));
+            }
+        }
+    } else {
+        return Ok(MetaHttpResponse::bad_request(
+            "either events or stream_name is required",
+        ));
+    };
+
+    match run_function(org_id, &req.function, events) {
+        Ok(results) => Ok(MetaHttpResponse::json(TestFunctionResponse { results })),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
+
+/// Returns the latest records of the stream, looking at the last hour of data
+async fn latest_records(
+    org_id: &str,
+    user_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    size: usize,
+) -> Result<Vec<json::Value>, anyhow::Error> {
+    let cfg = get_config();
+    let end_time = Utc::now().timestamp_micros();
+    let stats = infra::cache::stats::get_stream_stats(org_id, stream_name, stream_type);
+    let last_time = if stats.doc_time_max > 0 {
+        stats.doc_time_max.min(end_time)
+    } else {
+        end_time
+    };
+    let start_time = last_time - Duration::try_hours(1).unwrap().num_microseconds().unwrap();
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql: format!(
+                "SELECT * FROM \"{stream_name}\" ORDER BY {} DESC",
+                cfg.common.column_timestamp
+            ),
+            from: 0,
+            size: size as i64,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: std::collections::HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: None,
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    let resp = SearchService::search(
+        &trace_id,
+        org_id,
+        stream_type,
+        Some(user_id.to_string()),
+        &req,
+    )
+    .await?;
+    Ok(resp.hits)
+}
+
+/// Compiles the function and applies it to every event with a fresh runtime,
+/// the way ingestion would
+fn run_function(
+    org_id: &str,
+    function: &str,
+    events: Vec<json::Value>,
+) -> Result<Vec<TestFunctionResult>, Error> {
+    let function = if function.trim().ends_with('.') {
+        function.to_string()
+    } else {
+        format!("{} \n .", function)
+    };
+    let vrl_runtime_config = compile_vrl_function(&function, org_id)?;
+    if let Some(registry) = vrl_runtime_config.config.get_custom::<TableRegistry>() {
+        registry.finish_load();
+    }
+    let resolver = VRLResultResolver {
+        program: vrl_runtime_config.program,
+        fields: vrl_runtime_config.fields,
+    };
+
+    let flatten_level = get_config().limit.ingest_flatten_level;
+    let mut results = Vec::with_capacity(events.len());
+    for event in events {
+        let mut runtime = init_functions_runtime();
+        let (result, error) = match try_apply_vrl_fn(&mut runtime, &resolver, &event) {
+            Ok(val) if !val.is_object() => (
+                Some(val),
+                Some("result is not an object, the record would be dropped".to_string()),
+            ),
+            Ok(val) => match flatten::flatten_with_level(val, flatten_level) {
+                Ok(val) => (Some(val), None),
+                Err(e) => (None, Some(e.to_string())),
+            },
+            Err(e) => (None, Some(e)),
+        };
+        results.push(TestFunctionResult {
+            event,
+            result,
+            error,
+        });
+    }
+    Ok(results)
+}
+
 fn extract_num_args(func: &mut Transform) {
     if func.trans_type.unwrap() == 1 {
         let src: String = func.function.to_owned();
@@ -369,6 +615,7 @@ mod tests {
             streams: None,
             num_args: 0,
             trans_type: Some(1),
+            shadow: None,
         };
 
         let mut vrl_trans = Transform {
@@ -383,6 +630,7 @@ mod tests {
                 order: 0,
                 is_removed: false,
             }]),
+            shadow: None,
         };
 
         extract_num_args(&mut trans);
@@ -404,4 +652,56 @@ mod tests {
                 .is_ok()
         );
     }
+
+    #[test]
+    fn test_prepare_function() {
+        let mut func = Transform {
+            name: "shadowed".to_owned(),
+            function: ".a = 1".to_owned(),
+            params: "row".to_owned(),
+            num_args: 0,
+            trans_type: Some(0),
+            streams: None,
+            shadow: Some(".a = 2".to_owned()),
+        };
+        prepare_function("nexus", &mut func).unwrap();
+        assert!(func.function.ends_with('.'));
+        assert_eq!(func.shadow.as_deref(), Some(".a = 2 \n ."));
+
+        func.shadow = Some("  ".to_owned());
+        prepare_function("nexus", &mut func).unwrap();
+        assert!(func.shadow.is_none());
+
+        func.shadow = Some(".a = ".to_owned());
+        let err = prepare_function("nexus", &mut func).unwrap_err();
+        assert!(err.to_string().starts_with("shadow version"));
+
+        func.trans_type = Some(1);
+        func.shadow = Some("function(row) return row end".to_owned());
+        assert!(prepare_function("nexus", &mut func).is_err());
+    }
+
+    #[test]
+    fn test_run_function() {
+        let events = vec![
+            json::json!({"message": "a=1", "level": "info"}),
+            json::json!({"message": 1}),
+        ];
+        let results = run_function(
+            "nexus",
+            ".kv = parse_key_value!(.message)\ndel(.level)",
+            events,
+        )
+        .unwrap();
+        assert_eq!(results.len(), 2);
+        assert_eq!(
+            results[0].result,
+            Some(json::json!({"message": "a=1", "kv_a": "1"}))
+        );
+        assert!(results[0].error.is_none());
+        assert!(results[1].result.is_none());
+        assert!(results[1].error.is_some());
+
+        assert!(run_function("nexus", ".a = ", vec![]).is_err());
+    }
 }
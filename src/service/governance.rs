@@ -0,0 +1,381 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Ownership, team tags and lifecycle states of the dashboards, the alerts and
+//! the pipelines, so large installations can tell who to ask about an object
+//! and find the ones nobody looks after anymore.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{meta::stream::StreamType, utils::json};
+
+use crate::{
+    common::meta::{
+        governance::{
+            Governance, GovernanceList, GovernanceRequest, GovernedKind, LifecycleState,
+            StaleObject, StaleReason, StaleReport,
+        },
+        http::HttpResponse as MetaHttpResponse,
+    },
+    service::{alerts::alert_key, db, users},
+};
+
+const DAY_MICROS: i64 = 86_400_000_000;
+
+/// Filter of the governance list, every given field has to match
+#[derive(Debug, Default)]
+pub struct GovernanceFilter {
+    pub kind: Option<GovernedKind>,
+    pub owner: Option<String>,
+    pub tag: Option<String>,
+    pub state: Option<LifecycleState>,
+}
+
+impl GovernanceFilter {
+    fn matches(&self, governance: &Governance) -> bool {
+        self.kind.map_or(true, |kind| governance.kind == kind)
+            && self
+                .owner
+                .as_ref()
+                .map_or(true, |owner| governance.owner.eq_ignore_ascii_case(owner))
+            && self
+                .tag
+                .as_ref()
+                .map_or(true, |tag| governance.tags.iter().any(|t| t == tag))
+            && self.state.map_or(true, |state| governance.state == state)
+    }
+}
+
+#[tracing::instrument(skip(req))]
+pub async fn set_governance(
+    org_id: &str,
+    user_id: &str,
+    kind: GovernedKind,
+    object_id: &str,
+    mut req: GovernanceRequest,
+) -> Result<HttpResponse, Error> {
+    if let Err(e) = req.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if get_object_name(org_id, kind, object_id).await.is_none() {
+        return Ok(MetaHttpResponse::not_found(format!(
+            "{kind} {object_id} not found"
+        )));
+    }
+    if !req.owner.is_empty() && users::get_user(Some(org_id), &req.owner).await.is_none() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Owner {} is not a member of the organization",
+            req.owner
+        )));
+    }
+    let governance = Governance {
+        kind,
+        object_id: object_id.to_string(),
+        owner: req.owner,
+        tags: req.tags,
+        state: req.state,
+        updated_by: user_id.to_string(),
+        updated_at: Utc::now().timestamp_micros(),
+    };
+    match db::governance::set(org_id, &governance).await {
+        Ok(_) => Ok(MetaHttpResponse::json(governance)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_governance(
+    org_id: &str,
+    kind: GovernedKind,
+    object_id: &str,
+) -> Result<HttpResponse, Error> {
+    match db::governance::get(org_id, kind, object_id).await {
+        Ok(governance) => Ok(MetaHttpResponse::json(governance)),
+        Err(_) => Ok(MetaHttpResponse::not_found(format!(
+            "{kind} {object_id} has no owner"
+        ))),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_governance(
+    org_id: &str,
+    kind: GovernedKind,
+    object_id: &str,
+) -> Result<HttpResponse, Error> {
+    match db::governance::delete(org_id, kind, object_id).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Governance deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_governance(
+    org_id: &str,
+    filter: GovernanceFilter,
+) -> Result<HttpResponse, Error> {
+    match db::governance::list(org_id, filter.kind).await {
+        Ok(list) => Ok(MetaHttpResponse::json(GovernanceList {
+            list: list.into_iter().filter(|g| filter.matches(g)).collect(),
+        })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Reports the objects without an owner, owned by someone who left, left
+/// deprecated or as a draft, or not reviewed for `stale_days`
+#[tracing::instrument]
+pub async fn stale_report(org_id: &str, stale_days: i64) -> Result<HttpResponse, Error> {
+    let objects = match list_objects(org_id).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    let mut governances: HashMap<(GovernedKind, String), Governance> =
+        match db::governance::list(org_id, None).await {
+            Ok(list) => list
+                .into_iter()
+                .map(|g| ((g.kind, g.object_id.clone()), g))
+                .collect(),
+            Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+        };
+    let now = Utc::now().timestamp_micros();
+    let mut members = HashMap::new();
+    let mut list = Vec::new();
+    for object in objects {
+        let governance = governances.remove(&(object.kind, object.object_id.clone()));
+        let owner_is_member = match governance.as_ref().map(|g| g.owner.as_str()) {
+            None | Some("") => true,
+            Some(owner) => match members.get(owner) {
+                Some(is_member) => *is_member,
+                None => {
+                    let is_member = users::get_user(Some(org_id), owner).await.is_some();
+                    members.insert(owner.to_string(), is_member);
+                    is_member
+                }
+            },
+        };
+        let reasons = stale_reasons(
+            governance.as_ref(),
+            owner_is_member,
+            object.disabled,
+            now,
+            stale_days,
+        );
+        if !reasons.is_empty() {
+            list.push(StaleObject {
+                kind: object.kind,
+                object_id: object.object_id,
+                name: object.name,
+                governance,
+                reasons,
+            });
+        }
+    }
+    Ok(MetaHttpResponse::json(StaleReport { stale_days, list }))
+}
+
+fn stale_reasons(
+    governance: Option<&Governance>,
+    owner_is_member: bool,
+    disabled: bool,
+    now: i64,
+    stale_days: i64,
+) -> Vec<StaleReason> {
+    let mut reasons = Vec::new();
+    let stale_before = now.saturating_sub(stale_days.saturating_mul(DAY_MICROS));
+    match governance {
+        Some(g) if !g.owner.is_empty() => {
+            if !owner_is_member {
+                reasons.push(StaleReason::OwnerLeft);
+            }
+        }
+        _ => reasons.push(StaleReason::NoOwner),
+    }
+    if let Some(g) = governance {
+        if g.updated_at < stale_before {
+            reasons.push(match g.state {
+                LifecycleState::Deprecated => StaleReason::Deprecated,
+                LifecycleState::Draft => StaleReason::Draft,
+                LifecycleState::Active => StaleReason::NotReviewed,
+            });
+        }
+    }
+    if disabled {
+        reasons.push(StaleReason::Disabled);
+    }
+    reasons
+}
+
+struct GovernedObject {
+    kind: GovernedKind,
+    object_id: String,
+    name: String,
+    disabled: bool,
+}
+
+/// Lists the dashboards, the alerts and the pipelines of the organization
+async fn list_objects(org_id: &str) -> Result<Vec<GovernedObject>, anyhow::Error> {
+    let mut objects = Vec::new();
+    let dashboards_key = format!("/dashboard/{org_id}/");
+    for (key, val) in db::list(&dashboards_key).await? {
+        // the key is /dashboard/{org_id}/{folder}/{dashboard_id}
+        let Some(object_id) = key.strip_prefix(&dashboards_key) else {
+            continue;
+        };
+        let name = json::from_slice::<json::Value>(&val)
+            .ok()
+            .and_then(|v| v.get("title").and_then(|t| t.as_str().map(String::from)))
+            .unwrap_or_default();
+        objects.push(GovernedObject {
+            kind: GovernedKind::Dashboards,
+            object_id: object_id.to_string(),
+            name,
+            disabled: false,
+        });
+    }
+    for alert in db::alerts::list(org_id, None, None).await? {
+        objects.push(GovernedObject {
+            kind: GovernedKind::Alerts,
+            object_id: alert_key(&alert),
+            name: alert.name.clone(),
+            disabled: !alert.enabled,
+        });
+    }
+    for pipeline in db::pipelines::list(org_id).await? {
+        objects.push(GovernedObject {
+            kind: GovernedKind::Pipelines,
+            object_id: format!(
+                "{}/{}/{}",
+                pipeline.stream_type, pipeline.stream_name, pipeline.name
+            ),
+            name: pipeline.name,
+            disabled: false,
+        });
+    }
+    Ok(objects)
+}
+
+/// Returns the name of the object, none when it doesn't exist
+async fn get_object_name(org_id: &str, kind: GovernedKind, object_id: &str) -> Option<String> {
+    match kind {
+        GovernedKind::Dashboards => {
+            let (folder, dashboard_id) = object_id.split_once('/')?;
+            db::dashboards::get(org_id, dashboard_id, folder)
+                .await
+                .ok()
+                .map(|_| dashboard_id.to_string())
+        }
+        GovernedKind::Alerts => {
+            let (stream_type, stream_name, name) = split_stream_object_id(object_id)?;
+            db::alerts::get(org_id, stream_type, stream_name, name)
+                .await
+                .ok()
+                .flatten()
+                .map(|alert| alert.name)
+        }
+        GovernedKind::Pipelines => {
+            let (stream_type, stream_name, name) = split_stream_object_id(object_id)?;
+            db::pipelines::get(org_id, stream_type, stream_name, name)
+                .await
+                .ok()
+                .map(|pipeline| pipeline.name)
+        }
+    }
+}
+
+/// Splits `{stream_type}/{stream_name}/{name}`
+pub(crate) fn split_stream_object_id(object_id: &str) -> Option<(StreamType, &str, &str)> {
+    let mut parts = object_id.splitn(3, '/');
+    let stream_type = StreamType::from(parts.next()?);
+    let stream_name = parts.next()?;
+    let name = parts.next()?;
+    Some((stream_type, stream_name, name))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn governance(owner: &str, state: LifecycleState, updated_at: i64) -> Governance {
+        Governance {
+            kind: GovernedKind::Alerts,
+            object_id: "logs/default/errors".to_string(),
+            owner: owner.to_string(),
+            tags: vec!["payments".to_string()],
+            state,
+            updated_by: "root@example.com".to_string(),
+            updated_at,
+        }
+    }
+
+    #[test]
+    fn test_stale_reasons() {
+        let day = DAY_MICROS;
+        let now = 100 * day;
+        assert_eq!(
+            stale_reasons(None, true, false, now, 30),
+            vec![StaleReason::NoOwner]
+        );
+        let g = governance("alice@example.com", LifecycleState::Active, now - day);
+        assert!(stale_reasons(Some(&g), true, false, now, 30).is_empty());
+        assert_eq!(
+            stale_reasons(Some(&g), false, true, now, 30),
+            vec![StaleReason::OwnerLeft, StaleReason::Disabled]
+        );
+        let g = governance(
+            "alice@example.com",
+            LifecycleState::Deprecated,
+            now - 31 * day,
+        );
+        assert_eq!(
+            stale_reasons(Some(&g), true, false, now, 30),
+            vec![StaleReason::Deprecated]
+        );
+        let g = governance("", LifecycleState::Active, now - 31 * day);
+        assert_eq!(
+            stale_reasons(Some(&g), true, false, now, 30),
+            vec![StaleReason::NoOwner, StaleReason::NotReviewed]
+        );
+    }
+
+    #[test]
+    fn test_filter() {
+        let g = governance("Alice@example.com", LifecycleState::Draft, 0);
+        assert!(GovernanceFilter::default().matches(&g));
+        let filter = GovernanceFilter {
+            kind: Some(GovernedKind::Alerts),
+            owner: Some("alice@example.com".to_string()),
+            tag: Some("payments".to_string()),
+            state: Some(LifecycleState::Draft),
+        };
+        assert!(filter.matches(&g));
+        let filter = GovernanceFilter {
+            tag: Some("search".to_string()),
+            ..Default::default()
+        };
+        assert!(!filter.matches(&g));
+    }
+
+    #[test]
+    fn test_split_stream_object_id() {
+        assert_eq!(
+            split_stream_object_id("logs/default/errors/5xx"),
+            Some((StreamType::Logs, "default", "errors/5xx"))
+        );
+        assert_eq!(split_stream_object_id("logs/default"), None);
+    }
+}
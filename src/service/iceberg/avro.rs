@@ -0,0 +1,138 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Minimal Avro object container writer for the Iceberg manifests, without
+//! compression. The records are encoded by the caller with the `put_*`
+//! functions in the order of the fields of the schema.
+
+const MAGIC: &[u8] = b"Obj\x01";
+
+pub struct ContainerWriter {
+    buf: Vec<u8>,
+    block: Vec<u8>,
+    count: i64,
+    sync: [u8; 16],
+}
+
+impl ContainerWriter {
+    /// Writes the header with the schema and the metadata of the file
+    pub fn new(schema: &str, metadata: &[(&str, String)]) -> Self {
+        let mut buf = Vec::with_capacity(4096);
+        buf.extend_from_slice(MAGIC);
+        // file metadata is a map of bytes, in a single block
+        put_long(&mut buf, metadata.len() as i64 + 2);
+        put_string(&mut buf, "avro.schema");
+        put_string(&mut buf, schema);
+        put_string(&mut buf, "avro.codec");
+        put_string(&mut buf, "null");
+        for (key, value) in metadata {
+            put_string(&mut buf, key);
+            put_string(&mut buf, value);
+        }
+        put_long(&mut buf, 0);
+        let sync = rand::random::<[u8; 16]>();
+        buf.extend_from_slice(&sync);
+        Self {
+            buf,
+            block: Vec::new(),
+            count: 0,
+            sync,
+        }
+    }
+
+    /// Appends an encoded record
+    pub fn append(&mut self, record: &[u8]) {
+        self.block.extend_from_slice(record);
+        self.count += 1;
+    }
+
+    /// Returns the file, the records in a single block
+    pub fn finish(mut self) -> Vec<u8> {
+        if self.count > 0 {
+            put_long(&mut self.buf, self.count);
+            put_long(&mut self.buf, self.block.len() as i64);
+            self.buf.extend_from_slice(&self.block);
+            self.buf.extend_from_slice(&self.sync);
+        }
+        self.buf
+    }
+}
+
+/// Zigzag varint, for the ints and the longs
+pub fn put_long(buf: &mut Vec<u8>, v: i64) {
+    let mut n = ((v << 1) ^ (v >> 63)) as u64;
+    while n >= 0x80 {
+        buf.push((n as u8) | 0x80);
+        n >>= 7;
+    }
+    buf.push(n as u8);
+}
+
+pub fn put_string(buf: &mut Vec<u8>, v: &str) {
+    put_long(buf, v.len() as i64);
+    buf.extend_from_slice(v.as_bytes());
+}
+
+/// Optional long, as the `["null", "long"]` union
+pub fn put_optional_long(buf: &mut Vec<u8>, v: Option<i64>) {
+    match v {
+        None => put_long(buf, 0),
+        Some(v) => {
+            put_long(buf, 1);
+            put_long(buf, v);
+        }
+    }
+}
+
+/// Null of a `["null", ...]` union
+pub fn put_null(buf: &mut Vec<u8>) {
+    put_long(buf, 0);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_put_long() {
+        for (v, expected) in [
+            (0, vec![0x00]),
+            (-1, vec![0x01]),
+            (1, vec![0x02]),
+            (-64, vec![0x7f]),
+            (64, vec![0x80, 0x01]),
+            (300, vec![0xd8, 0x04]),
+        ] {
+            let mut buf = Vec::new();
+            put_long(&mut buf, v);
+            assert_eq!(buf, expected, "{v}");
+        }
+    }
+
+    #[test]
+    fn test_container() {
+        let mut w = ContainerWriter::new(r#""long""#, &[("format-version", "2".to_string())]);
+        let mut record = Vec::new();
+        put_long(&mut record, 300);
+        w.append(&record);
+        let sync = w.sync;
+        let data = w.finish();
+        assert!(data.starts_with(MAGIC));
+        assert!(data.ends_with(&sync));
+        // count 1, size 2, the record, then the sync marker
+        let block = &data[data.len() - 16 - 4..data.len() - 16];
+        assert_eq!(block, &[0x02, 0x04, 0xd8, 0x04]);
+    }
+}
@@ -0,0 +1,479 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Keeps the streams with the Iceberg table format readable as Apache Iceberg
+//! tables by lakehouse engines, OpenObserve staying the only writer.
+//!
+//! The compactor commits the file list of each of these streams periodically
+//! under `iceberg/{org_id}/{stream_type}/{stream_name}/metadata/` in the
+//! storage of the data: every commit is a snapshot with a single manifest
+//! listing all the current parquet files, so the files merged or deleted by
+//! OpenObserve leave the table with the next snapshot. The older snapshots
+//! stay readable for time travel as long as their files aren't deleted, up to
+//! ZO_ICEBERG_MAX_SNAPSHOTS snapshots.
+//!
+//! The tables are unpartitioned and the parquet files have no field ids, the
+//! readers match the columns by name with the name mapping of the table.
+
+use std::{collections::BTreeMap, io::Error};
+
+use actix_web::HttpResponse;
+use arrow_schema::Schema;
+use chrono::Utc;
+use config::{
+    get_config,
+    meta::stream::{FileMeta, PartitionTimeLevel, StreamType, TableFormat},
+    utils::{
+        hash::{gxhash, Sum64},
+        json,
+    },
+};
+use infra::{dist_lock, schema::STREAM_SETTINGS};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        iceberg::{
+            IcebergTable, MetadataLogEntry, Snapshot, SnapshotLogEntry, SnapshotRef, TableMetadata,
+            FORMAT_VERSION,
+        },
+    },
+    service::{db, stream_partitions},
+};
+
+mod avro;
+
+/// Directory of the tables in the storage
+const TABLES_DIR: &str = "iceberg";
+/// Branch of the current snapshot
+const MAIN_BRANCH: &str = "main";
+
+const MANIFEST_SCHEMA: &str = r#"{"type":"record","name":"manifest_entry","fields":[
+{"name":"status","type":"int","field-id":0},
+{"name":"snapshot_id","type":["null","long"],"default":null,"field-id":1},
+{"name":"sequence_number","type":["null","long"],"default":null,"field-id":3},
+{"name":"file_sequence_number","type":["null","long"],"default":null,"field-id":4},
+{"name":"data_file","type":{"type":"record","name":"r2","fields":[
+{"name":"content","type":"int","field-id":134},
+{"name":"file_path","type":"string","field-id":100},
+{"name":"file_format","type":"string","field-id":101},
+{"name":"partition","type":{"type":"record","name":"r102","fields":[]},"field-id":102},
+{"name":"record_count","type":"long","field-id":103},
+{"name":"file_size_in_bytes","type":"long","field-id":104}]},"field-id":2}]}"#;
+
+const MANIFEST_LIST_SCHEMA: &str = r#"{"type":"record","name":"manifest_file","fields":[
+{"name":"manifest_path","type":"string","field-id":500},
+{"name":"manifest_length","type":"long","field-id":501},
+{"name":"partition_spec_id","type":"int","field-id":502},
+{"name":"content","type":"int","field-id":517},
+{"name":"sequence_number","type":"long","field-id":515},
+{"name":"min_sequence_number","type":"long","field-id":516},
+{"name":"added_snapshot_id","type":"long","field-id":503},
+{"name":"added_files_count","type":"int","field-id":504},
+{"name":"existing_files_count","type":"int","field-id":505},
+{"name":"deleted_files_count","type":"int","field-id":506},
+{"name":"added_rows_count","type":"long","field-id":512},
+{"name":"existing_rows_count","type":"long","field-id":513},
+{"name":"deleted_rows_count","type":"long","field-id":514}]}"#;
+
+/// Status of a manifest entry
+const STATUS_ADDED: i64 = 1;
+/// Content of data files, as opposed to delete files
+const CONTENT_DATA: i64 = 0;
+
+/// Commits the streams with the Iceberg table format, one compactor at a time
+/// per stream
+pub async fn commit_all() -> Result<(), anyhow::Error> {
+    let streams: Vec<String> = STREAM_SETTINGS
+        .read()
+        .await
+        .iter()
+        .filter(|(_, settings)| settings.table_format == TableFormat::Iceberg)
+        .map(|(key, _)| key.clone())
+        .collect();
+    for key in streams {
+        let columns: Vec<&str> = key.splitn(3, '/').collect();
+        if columns.len() != 3 {
+            continue;
+        }
+        let (org_id, stream_type, stream_name) =
+            (columns[0], StreamType::from(columns[1]), columns[2]);
+        let locker = dist_lock::lock(&format!("/iceberg/commit/{key}"), 0).await?;
+        let ret = commit(org_id, stream_type, stream_name).await;
+        dist_lock::unlock(&locker).await?;
+        match ret {
+            Ok(true) => log::info!("[ICEBERG] committed table {key}"),
+            Ok(false) => {}
+            Err(e) => log::error!("[ICEBERG] commit table {key} error: {e}"),
+        }
+    }
+    Ok(())
+}
+
+/// Writes a new snapshot of the table of the stream when its files or its
+/// schema changed since the last one, returns true when it did
+pub async fn commit(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<bool, anyhow::Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type).await?;
+    if schema == Schema::empty() {
+        return Ok(false);
+    }
+    let mut files = infra::file_list::query(
+        org_id,
+        stream_type,
+        stream_name,
+        PartitionTimeLevel::Unset,
+        None,
+        None,
+    )
+    .await?;
+    files.sort_by(|a, b| a.0.cmp(&b.0));
+    files.dedup_by(|a, b| a.0 == b.0);
+    let files_hash = files_hash(&files);
+
+    let base = stream_partitions::location();
+    let table_dir = format!("{TABLES_DIR}/{org_id}/{stream_type}/{stream_name}");
+    let mut table = match db::iceberg::get(org_id, stream_type, stream_name).await {
+        Ok(table) => table,
+        Err(_) => IcebergTable {
+            location: format!("{base}{table_dir}"),
+            metadata: TableMetadata::new(&new_uuid(), &format!("{base}{table_dir}")),
+            ..Default::default()
+        },
+    };
+    let schema_id = table.metadata.current_schema_id;
+    let new_schema_id = table.metadata.update_schema(&schema);
+    if table.version > 0 && table.files_hash == files_hash && schema_id == new_schema_id {
+        return Ok(false);
+    }
+
+    let now = Utc::now().timestamp_millis();
+    let snapshot_id = rand::random::<i64>() & i64::MAX;
+    let sequence_number = table.metadata.last_sequence_number + 1;
+    let commit_id = new_uuid();
+    let records: i64 = files.iter().map(|(_, meta)| meta.records).sum();
+    let size: i64 = files.iter().map(|(_, meta)| meta.compressed_size).sum();
+
+    let manifest_key = format!("{table_dir}/metadata/{commit_id}-m0.avro");
+    let manifest = write_manifest(&table.metadata, snapshot_id, sequence_number, &base, &files)?;
+    let manifest_len = manifest.len() as i64;
+    infra::storage::put(&manifest_key, manifest.into()).await?;
+
+    let parent_snapshot_id = table.metadata.current_snapshot_id;
+    let list_key = format!("{table_dir}/metadata/snap-{snapshot_id}-1-{commit_id}.avro");
+    let list = write_manifest_list(
+        &format!("{base}{manifest_key}"),
+        manifest_len,
+        snapshot_id,
+        parent_snapshot_id,
+        sequence_number,
+        files.len() as i64,
+        records,
+    );
+    infra::storage::put(&list_key, list.into()).await?;
+
+    let metadata = &mut table.metadata;
+    if !table.metadata_location.is_empty() {
+        metadata.metadata_log.push(MetadataLogEntry {
+            timestamp_ms: metadata.last_updated_ms,
+            metadata_file: table.metadata_location.clone(),
+        });
+    }
+    metadata.snapshots.push(Snapshot {
+        snapshot_id,
+        parent_snapshot_id,
+        sequence_number,
+        timestamp_ms: now,
+        manifest_list: format!("{base}{list_key}"),
+        summary: BTreeMap::from([
+            ("operation".to_string(), "overwrite".to_string()),
+            ("total-data-files".to_string(), files.len().to_string()),
+            ("total-records".to_string(), records.to_string()),
+            ("total-files-size".to_string(), size.to_string()),
+        ]),
+        schema_id: new_schema_id,
+    });
+    metadata.snapshot_log.push(SnapshotLogEntry {
+        timestamp_ms: now,
+        snapshot_id,
+    });
+    metadata.refs.insert(
+        MAIN_BRANCH.to_string(),
+        SnapshotRef {
+            snapshot_id,
+            ref_type: "branch".to_string(),
+        },
+    );
+    metadata.current_snapshot_id = Some(snapshot_id);
+    metadata.last_sequence_number = sequence_number;
+    metadata.last_updated_ms = now;
+    let expired = expire(metadata, get_config().limit.iceberg_max_snapshots, &base);
+
+    table.version += 1;
+    let metadata_key = format!("{table_dir}/metadata/v{}.metadata.json", table.version);
+    infra::storage::put(&metadata_key, json::to_vec(&table.metadata)?.into()).await?;
+    // lets the readers without a catalog find the current metadata
+    infra::storage::put(
+        &format!("{table_dir}/metadata/version-hint.text"),
+        table.version.to_string().into(),
+    )
+    .await?;
+    table.location = format!("{base}{table_dir}");
+    table.metadata_location = format!("{base}{metadata_key}");
+    table.files_hash = files_hash;
+    db::iceberg::set(org_id, stream_type, stream_name, &table).await?;
+
+    let expired: Vec<&str> = expired.iter().map(|k| k.as_str()).collect();
+    if let Err(e) = infra::storage::del(&expired).await {
+        log::warn!("[ICEBERG] delete expired metadata of {table_dir} error: {e}");
+    }
+    Ok(true)
+}
+
+/// Returns the table of the stream as last committed
+#[tracing::instrument]
+pub async fn get_table(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+) -> Result<HttpResponse, Error> {
+    match db::iceberg::get(org_id, stream_type, stream_name).await {
+        Ok(table) => Ok(MetaHttpResponse::json(table)),
+        Err(_) => Ok(MetaHttpResponse::not_found(
+            "the stream has no Iceberg table yet, set its table_format to iceberg and wait for the next commit",
+        )),
+    }
+}
+
+/// Deletes the table of a deleted stream
+pub async fn drop_table(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<(), anyhow::Error> {
+    if db::iceberg::get(org_id, stream_type, stream_name)
+        .await
+        .is_err()
+    {
+        return Ok(());
+    }
+    let files = infra::storage::list(&format!(
+        "{TABLES_DIR}/{org_id}/{stream_type}/{stream_name}/"
+    ))
+    .await?;
+    let files: Vec<&str> = files.iter().map(|f| f.as_str()).collect();
+    infra::storage::del(&files).await?;
+    db::iceberg::delete(org_id, stream_type, stream_name).await
+}
+
+fn write_manifest(
+    metadata: &TableMetadata,
+    snapshot_id: i64,
+    sequence_number: i64,
+    base: &str,
+    files: &[(String, FileMeta)],
+) -> Result<Vec<u8>, anyhow::Error> {
+    let schema = json::to_string(metadata.current_schema().unwrap())?;
+    let mut w = avro::ContainerWriter::new(
+        MANIFEST_SCHEMA,
+        &[
+            ("schema", schema),
+            ("schema-id", metadata.current_schema_id.to_string()),
+            ("partition-spec", "[]".to_string()),
+            ("partition-spec-id", metadata.default_spec_id.to_string()),
+            ("format-version", FORMAT_VERSION.to_string()),
+            ("content", "data".to_string()),
+        ],
+    );
+    let mut buf = Vec::new();
+    for (key, meta) in files {
+        buf.clear();
+        avro::put_long(&mut buf, STATUS_ADDED);
+        avro::put_optional_long(&mut buf, Some(snapshot_id));
+        avro::put_optional_long(&mut buf, Some(sequence_number));
+        avro::put_optional_long(&mut buf, Some(sequence_number));
+        // data_file
+        avro::put_long(&mut buf, CONTENT_DATA);
+        avro::put_string(&mut buf, &format!("{base}{key}"));
+        avro::put_string(&mut buf, "PARQUET");
+        // the partition has no fields
+        avro::put_long(&mut buf, meta.records);
+        avro::put_long(&mut buf, meta.compressed_size);
+        w.append(&buf);
+    }
+    Ok(w.finish())
+}
+
+fn write_manifest_list(
+    manifest_path: &str,
+    manifest_len: i64,
+    snapshot_id: i64,
+    parent_snapshot_id: Option<i64>,
+    sequence_number: i64,
+    files: i64,
+    records: i64,
+) -> Vec<u8> {
+    let mut w = avro::ContainerWriter::new(
+        MANIFEST_LIST_SCHEMA,
+        &[
+            ("snapshot-id", snapshot_id.to_string()),
+            (
+                "parent-snapshot-id",
+                parent_snapshot_id.map_or("null".to_string(), |v| v.to_string()),
+            ),
+            ("sequence-number", sequence_number.to_string()),
+            ("format-version", FORMAT_VERSION.to_string()),
+        ],
+    );
+    let mut buf = Vec::new();
+    avro::put_string(&mut buf, manifest_path);
+    avro::put_long(&mut buf, manifest_len);
+    avro::put_long(&mut buf, 0); // partition_spec_id
+    avro::put_long(&mut buf, CONTENT_DATA);
+    avro::put_long(&mut buf, sequence_number);
+    avro::put_long(&mut buf, sequence_number); // min_sequence_number
+    avro::put_long(&mut buf, snapshot_id);
+    avro::put_long(&mut buf, files); // added_files_count
+    avro::put_long(&mut buf, 0); // existing_files_count
+    avro::put_long(&mut buf, 0); // deleted_files_count
+    avro::put_long(&mut buf, records); // added_rows_count
+    avro::put_long(&mut buf, 0); // existing_rows_count
+    avro::put_long(&mut buf, 0); // deleted_rows_count
+    w.append(&buf);
+    w.finish()
+}
+
+/// Drops the snapshots and the metadata files beyond `max`, the oldest first,
+/// returns the storage keys of their files
+fn expire(metadata: &mut TableMetadata, max: usize, base: &str) -> Vec<String> {
+    let mut expired = Vec::new();
+    if metadata.snapshots.len() > max {
+        let n = metadata.snapshots.len() - max;
+        for snapshot in metadata.snapshots.drain(..n) {
+            let Some(list_key) = snapshot.manifest_list.strip_prefix(base) else {
+                continue;
+            };
+            // snap-{snapshot_id}-1-{commit_id}.avro lists {commit_id}-m0.avro
+            if let Some((dir, name)) = list_key.rsplit_once('/') {
+                let prefix = format!("snap-{}-1-", snapshot.snapshot_id);
+                if let Some(commit_id) = name
+                    .strip_prefix(&prefix)
+                    .and_then(|v| v.strip_suffix(".avro"))
+                {
+                    expired.push(format!("{dir}/{commit_id}-m0.avro"));
+                }
+            }
+            expired.push(list_key.to_string());
+        }
+        let first = metadata.snapshots[0].snapshot_id;
+        if let Some(pos) = metadata
+            .snapshot_log
+            .iter()
+            .position(|e| e.snapshot_id == first)
+        {
+            metadata.snapshot_log.drain(..pos);
+        }
+        // the oldest snapshot kept has no parent anymore
+        metadata.snapshots[0].parent_snapshot_id = None;
+    }
+    if metadata.metadata_log.len() > max {
+        let n = metadata.metadata_log.len() - max;
+        for entry in metadata.metadata_log.drain(..n) {
+            if let Some(key) = entry.metadata_file.strip_prefix(base) {
+                expired.push(key.to_string());
+            }
+        }
+    }
+    expired
+}
+
+fn files_hash(files: &[(String, FileMeta)]) -> u64 {
+    let keys: Vec<&str> = files.iter().map(|(key, _)| key.as_str()).collect();
+    gxhash::new().sum64(&keys.join("\n"))
+}
+
+/// Random version 4 uuid, e.g. for the table uuid
+fn new_uuid() -> String {
+    let mut b = rand::random::<[u8; 16]>();
+    b[6] = (b[6] & 0x0f) | 0x40;
+    b[8] = (b[8] & 0x3f) | 0x80;
+    let hex: String = b.iter().map(|v| format!("{v:02x}")).collect();
+    format!(
+        "{}-{}-{}-{}-{}",
+        &hex[0..8],
+        &hex[8..12],
+        &hex[12..16],
+        &hex[16..20],
+        &hex[20..32]
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn snapshot(id: i64, parent: Option<i64>) -> Snapshot {
+        Snapshot {
+            snapshot_id: id,
+            parent_snapshot_id: parent,
+            sequence_number: id,
+            timestamp_ms: id,
+            manifest_list: format!("s3://b/iceberg/t/metadata/snap-{id}-1-c{id}.avro"),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_expire() {
+        let mut metadata = TableMetadata::new("uuid", "s3://b/iceberg/t");
+        for id in 1..=3 {
+            metadata
+                .snapshots
+                .push(snapshot(id, if id > 1 { Some(id - 1) } else { None }));
+            metadata.snapshot_log.push(SnapshotLogEntry {
+                timestamp_ms: id,
+                snapshot_id: id,
+            });
+            metadata.metadata_log.push(MetadataLogEntry {
+                timestamp_ms: id,
+                metadata_file: format!("s3://b/iceberg/t/metadata/v{id}.metadata.json"),
+            });
+        }
+        let expired = expire(&mut metadata, 2, "s3://b/");
+        assert_eq!(
+            expired,
+            vec![
+                "iceberg/t/metadata/c1-m0.avro",
+                "iceberg/t/metadata/snap-1-1-c1.avro",
+                "iceberg/t/metadata/v1.metadata.json",
+            ]
+        );
+        assert_eq!(metadata.snapshots.len(), 2);
+        assert_eq!(metadata.snapshots[0].parent_snapshot_id, None);
+        assert_eq!(metadata.snapshot_log[0].snapshot_id, 2);
+        assert!(expire(&mut metadata, 2, "s3://b/").is_empty());
+    }
+
+    #[test]
+    fn test_new_uuid() {
+        let id = new_uuid();
+        assert_eq!(id.len(), 36);
+        assert_eq!(id.split('-').count(), 5);
+        assert_eq!(&id[14..15], "4");
+    }
+}
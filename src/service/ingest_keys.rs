@@ -0,0 +1,355 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Keys signing ingestion requests with HMAC-SHA256. The client signs
+//! `"{timestamp}\n{METHOD}\n{path and query}\n{hex(sha256(body))}"` with the
+//! secret of the key and sends it as
+//! `Authorization: HMAC-SHA256 Credential=<key id>, Timestamp=<unix seconds>,
+//! Signature=<hex>`. The timestamp bounds how long a captured request can be
+//! replayed, and signatures seen within that window are rejected. They are
+//! recorded in the meta store, so a replay to another node is rejected too.
+
+use std::collections::{HashSet, VecDeque};
+
+use config::{get_config, ider, utils::rand::generate_random_string};
+use hmac::{Hmac, Mac};
+use once_cell::sync::Lazy;
+use parking_lot::Mutex;
+use sha2::{Digest, Sha256};
+
+use super::{db, format_stream_name};
+use crate::common::meta::ingest_keys::{IngestKey, IngestKeyRequest, HMAC_AUTH_SCHEME};
+
+/// Most signatures remembered by this node, the oldest ones are evicted first
+/// and their replays are then caught by the meta store.
+const MAX_LOCAL_SIGNATURES: usize = 100_000;
+
+/// Signatures accepted by this node within the allowed clock skew.
+static SEEN_SIGNATURES: Lazy<Mutex<SeenSignatures>> =
+    Lazy::new(|| Mutex::new(SeenSignatures::new(MAX_LOCAL_SIGNATURES)));
+
+/// Bounded set of signatures, in the order they were accepted with their
+/// timestamps.
+struct SeenSignatures {
+    capacity: usize,
+    signatures: HashSet<String>,
+    order: VecDeque<(i64, String)>,
+}
+
+impl SeenSignatures {
+    fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            signatures: HashSet::new(),
+            order: VecDeque::new(),
+        }
+    }
+
+    /// Returns false when the signature was already seen. Entries older than
+    /// the skew window can not pass the timestamp check anymore and are
+    /// dropped, the oldest entries are dropped too once the set is full.
+    fn insert(&mut self, signature: &str, timestamp: i64, now: i64, max_skew: i64) -> bool {
+        if self.signatures.contains(signature) {
+            return false;
+        }
+        while let Some((ts, oldest)) = self.order.front() {
+            if (now - *ts).abs() <= max_skew && self.order.len() < self.capacity {
+                break;
+            }
+            self.signatures.remove(oldest);
+            self.order.pop_front();
+        }
+        self.signatures.insert(signature.to_string());
+        self.order.push_back((timestamp, signature.to_string()));
+        true
+    }
+
+    #[cfg(test)]
+    fn len(&self) -> usize {
+        self.order.len()
+    }
+}
+
+#[derive(Debug, PartialEq)]
+pub struct SignedRequest {
+    pub key_id: String,
+    pub timestamp: i64,
+    pub signature: String,
+}
+
+/// Creates a key for the user, the returned key is the only copy of the
+/// secret handed out.
+pub async fn create_key(
+    org_id: &str,
+    user_email: &str,
+    req: IngestKeyRequest,
+) -> Result<IngestKey, anyhow::Error> {
+    let key = IngestKey {
+        id: ider::uuid(),
+        org_id: org_id.to_string(),
+        description: req.description,
+        streams: req
+            .streams
+            .iter()
+            .map(|s| format_stream_name(s.trim()))
+            .filter(|s| !s.is_empty())
+            .collect(),
+        user_email: user_email.to_string(),
+        secret: generate_random_string(40),
+        created_at: chrono::Utc::now().timestamp_micros(),
+    };
+    db::ingest_keys::set(&key).await?;
+    Ok(key)
+}
+
+/// Lists the keys of the org without their secrets.
+pub fn list_keys(org_id: &str) -> Vec<IngestKey> {
+    db::ingest_keys::list(org_id)
+        .into_iter()
+        .map(|mut key| {
+            key.secret.clear();
+            key
+        })
+        .collect()
+}
+
+/// Returns false when the org has no such key.
+pub async fn delete_key(org_id: &str, key_id: &str) -> Result<bool, anyhow::Error> {
+    match db::ingest_keys::get(key_id) {
+        Some(key) if key.org_id == org_id => {
+            db::ingest_keys::delete(org_id, key_id).await?;
+            Ok(true)
+        }
+        _ => Ok(false),
+    }
+}
+
+/// Parses the `Authorization` header of a signed request.
+pub fn parse_authorization(auth: &str) -> Option<SignedRequest> {
+    let params = auth.strip_prefix(HMAC_AUTH_SCHEME)?;
+    let (mut key_id, mut timestamp, mut signature) = (None, None, None);
+    for param in params.split(',') {
+        let (name, value) = param.trim().split_once('=')?;
+        match name.trim() {
+            "Credential" => key_id = Some(value.trim().to_string()),
+            "Timestamp" => timestamp = value.trim().parse().ok(),
+            "Signature" => signature = Some(value.trim().to_ascii_lowercase()),
+            _ => return None,
+        }
+    }
+    Some(SignedRequest {
+        key_id: key_id.filter(|v| !v.is_empty())?,
+        timestamp: timestamp?,
+        signature: signature.filter(|v| !v.is_empty())?,
+    })
+}
+
+pub fn string_to_sign(timestamp: i64, method: &str, path_and_query: &str, body: &[u8]) -> String {
+    format!(
+        "{timestamp}\n{}\n{path_and_query}\n{}",
+        method.to_ascii_uppercase(),
+        hex::encode(Sha256::digest(body))
+    )
+}
+
+/// Returns the hex encoded signature, this is what the clients compute.
+pub fn sign(secret: &str, string_to_sign: &str) -> String {
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(secret.as_bytes()).expect("HMAC accepts keys of any size");
+    mac.update(string_to_sign.as_bytes());
+    hex::encode(mac.finalize().into_bytes())
+}
+
+/// Compares the signature in constant time.
+pub fn verify(secret: &str, string_to_sign: &str, signature: &str) -> bool {
+    let Ok(signature) = hex::decode(signature) else {
+        return false;
+    };
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(secret.as_bytes()).expect("HMAC accepts keys of any size");
+    mac.update(string_to_sign.as_bytes());
+    mac.verify_slice(&signature).is_ok()
+}
+
+pub fn within_skew(timestamp: i64, now: i64) -> bool {
+    (now - timestamp).abs() <= get_config().auth.ingest_hmac_max_skew
+}
+
+/// Records the signature, returns false when it was already used on any node.
+/// Replays to the same node are rejected without a round trip to the meta
+/// store.
+pub async fn record_signature(
+    signature: &str,
+    timestamp: i64,
+    now: i64,
+) -> Result<bool, anyhow::Error> {
+    if !record_local_signature(signature, timestamp, now) {
+        return Ok(false);
+    }
+    db::ingest_keys::record_signature(signature, timestamp).await
+}
+
+/// Deletes the signatures which can not pass the clock skew check anymore.
+pub async fn delete_expired_signatures() -> Result<usize, anyhow::Error> {
+    let before = chrono::Utc::now().timestamp() - get_config().auth.ingest_hmac_max_skew;
+    db::ingest_keys::delete_signatures_before(before).await
+}
+
+fn record_local_signature(signature: &str, timestamp: i64, now: i64) -> bool {
+    let max_skew = get_config().auth.ingest_hmac_max_skew;
+    SEEN_SIGNATURES
+        .lock()
+        .insert(signature, timestamp, now, max_skew)
+}
+
+/// Returns the stream a signed request ingests into. `path_columns` starts
+/// with the org id, OTLP endpoints take the stream from a header.
+pub fn request_stream(path_columns: &[&str], header_stream: Option<&str>) -> Option<String> {
+    match path_columns {
+        [_, stream, ep] if ["_json", "_multi", "_kinesis_firehose", "_sub"].contains(ep) => {
+            Some(stream.to_string())
+        }
+        [_, "v1", "logs" | "traces"] | [_, "traces"] => Some(
+            header_stream
+                .filter(|s| !s.is_empty())
+                .unwrap_or("default")
+                .to_string(),
+        ),
+        _ => None,
+    }
+}
+
+/// Checks the stream against the scope of the key, keys limited to streams can
+/// not use endpoints naming the stream in the payload, e.g. `_bulk`.
+pub fn check_stream(key: &IngestKey, stream: Option<&str>) -> Result<(), String> {
+    if key.streams.is_empty() {
+        return Ok(());
+    }
+    match stream {
+        Some(stream) if key.streams.contains(&format_stream_name(stream)) => Ok(()),
+        Some(stream) => Err(format!(
+            "Ingest key is not allowed to ingest into stream [{stream}]"
+        )),
+        None => Err(
+            "Ingest key limited to streams can only be used with endpoints naming the stream"
+                .to_string(),
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn key(streams: &[&str]) -> IngestKey {
+        IngestKey {
+            id: "k1".to_string(),
+            org_id: "default".to_string(),
+            streams: streams.iter().map(|s| s.to_string()).collect(),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_parse_authorization() {
+        assert_eq!(
+            parse_authorization("HMAC-SHA256 Credential=k1, Timestamp=1700000000, Signature=AB01"),
+            Some(SignedRequest {
+                key_id: "k1".to_string(),
+                timestamp: 1700000000,
+                signature: "ab01".to_string(),
+            })
+        );
+        assert_eq!(parse_authorization("Basic abc"), None);
+        assert_eq!(
+            parse_authorization("HMAC-SHA256 Credential=k1, Signature=ab"),
+            None
+        );
+        assert_eq!(
+            parse_authorization("HMAC-SHA256 Credential=k1, Timestamp=x, Signature=ab"),
+            None
+        );
+        assert_eq!(
+            parse_authorization("HMAC-SHA256 Credential=k1, Timestamp=1, Signature=ab, Extra=1"),
+            None
+        );
+    }
+
+    #[test]
+    fn test_sign_and_verify() {
+        let sts = string_to_sign(1700000000, "post", "/api/default/logs/_json?x=1", b"[]");
+        assert_eq!(
+            sts,
+            "1700000000\nPOST\n/api/default/logs/_json?x=1\n\
+             4f53cda18c2baa0c0354bb5f9a3ecbe5ed12ab4d8e11ba873c2f11161202b945"
+        );
+        let signature = sign("secret", &sts);
+        assert!(verify("secret", &sts, &signature));
+        assert!(!verify("other", &sts, &signature));
+        assert!(!verify("secret", &sts, "not hex"));
+        let tampered = string_to_sign(1700000000, "POST", "/api/default/logs/_json?x=1", b"[{}]");
+        assert!(!verify("secret", &tampered, &signature));
+    }
+
+    #[test]
+    fn test_skew_and_replay() {
+        let max_skew = get_config().auth.ingest_hmac_max_skew;
+        assert!(within_skew(1000, 1000 + max_skew));
+        assert!(within_skew(1000 + max_skew, 1000));
+        assert!(!within_skew(1000, 1001 + max_skew));
+        assert!(record_local_signature("test_skew_and_replay", 1000, 1000));
+        assert!(!record_local_signature("test_skew_and_replay", 1000, 1001));
+    }
+
+    #[test]
+    fn test_seen_signatures_bounded() {
+        let mut seen = SeenSignatures::new(3);
+        for i in 0..5 {
+            assert!(seen.insert(&format!("sig{i}"), 1000, 1000, 300));
+        }
+        assert_eq!(seen.len(), 3);
+        // the oldest ones were evicted, the newest ones are still rejected
+        assert!(seen.insert("sig0", 1000, 1000, 300));
+        assert!(!seen.insert("sig4", 1000, 1000, 300));
+        // expired entries are dropped on the next insert
+        assert!(seen.insert("late", 2000, 2000, 300));
+        assert_eq!(seen.len(), 1);
+    }
+
+    #[test]
+    fn test_request_stream() {
+        assert_eq!(
+            request_stream(&["default", "app", "_json"], None),
+            Some("app".to_string())
+        );
+        assert_eq!(
+            request_stream(&["default", "v1", "logs"], Some("otel")),
+            Some("otel".to_string())
+        );
+        assert_eq!(
+            request_stream(&["default", "traces"], None),
+            Some("default".to_string())
+        );
+        assert_eq!(request_stream(&["default", "_bulk"], None), None);
+    }
+
+    #[test]
+    fn test_check_stream() {
+        assert!(check_stream(&key(&[]), None).is_ok());
+        assert!(check_stream(&key(&["app"]), Some("app")).is_ok());
+        assert!(check_stream(&key(&["app"]), Some("other")).is_err());
+        assert!(check_stream(&key(&["app"]), None).is_err());
+    }
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Limits of the ingestion requests. When the adaptive limits are enabled
+//! they are lowered as the memtables fill up, so the agents send smaller
+//! batches instead of getting rejected once the memtables are full. The
+//! limits are advertised in the headers of every ingestion response.
+
+use config::{get_config, metrics};
+
+/// Payload limit of the ingestion requests, in bytes
+pub const MAX_BYTES_HEADER: &str = "o2-ingest-max-bytes";
+/// Record limit of the logs ingestion requests, not sent when there is none
+pub const MAX_RECORDS_HEADER: &str = "o2-ingest-max-records";
+/// Percent of the memtable limit in use
+pub const MEMORY_PRESSURE_HEADER: &str = "o2-ingest-memory-pressure";
+
+#[derive(Clone, Copy, Debug, PartialEq)]
+pub struct IngestLimits {
+    pub max_bytes: usize,
+    /// No limit when 0
+    pub max_records: usize,
+    /// Percent of the memtable limit in use
+    pub memory_pressure: u64,
+}
+
+/// Returns the limits of the ingestion requests for the current memory usage
+pub fn current() -> IngestLimits {
+    let cfg = get_config();
+    let used = metrics::INGEST_MEMTABLE_ARROW_BYTES
+        .with_label_values(&[])
+        .get()
+        .max(0) as u64;
+    let memory_pressure = used * 100 / (cfg.limit.mem_table_max_size as u64).max(1);
+    if !cfg.limit.ingest_adaptive_limits_enabled {
+        return IngestLimits {
+            max_bytes: cfg.limit.req_payload_limit,
+            max_records: cfg.limit.ingest_max_records_per_request,
+            memory_pressure,
+        };
+    }
+    let factor = scale_factor(memory_pressure, cfg.limit.ingest_adaptive_threshold as u64);
+    IngestLimits {
+        max_bytes: scale(
+            cfg.limit.ingest_adaptive_min_payload,
+            cfg.limit.req_payload_limit,
+            factor,
+        ),
+        max_records: match cfg.limit.ingest_max_records_per_request {
+            0 => 0,
+            max => scale(cfg.limit.ingest_adaptive_min_records.min(max), max, factor),
+        },
+        memory_pressure,
+    }
+}
+
+/// Rejects a request with more records than the current limit
+pub fn check_records(records: usize) -> Result<(), String> {
+    let max_records = current().max_records;
+    if max_records > 0 && records > max_records {
+        return Err(format!(
+            "too many records in the request: {records}, the current limit is {max_records}, see the {MAX_RECORDS_HEADER} response header"
+        ));
+    }
+    Ok(())
+}
+
+/// Counts the non empty lines of a ndjson payload
+pub fn count_lines(data: &[u8]) -> usize {
+    data.split(|b| *b == b'\n')
+        .filter(|line| !line.iter().all(|b| b.is_ascii_whitespace()))
+        .count()
+}
+
+/// Returns 1 up to the threshold, decreasing linearly to 0 when the memtables
+/// are full
+fn scale_factor(memory_pressure: u64, threshold: u64) -> f64 {
+    if memory_pressure <= threshold {
+        return 1.0;
+    }
+    let over = (memory_pressure - threshold) as f64 / (100 - threshold.min(99)) as f64;
+    (1.0 - over).max(0.0)
+}
+
+fn scale(min: usize, max: usize, factor: f64) -> usize {
+    min + (max.saturating_sub(min) as f64 * factor) as usize
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_scale_factor() {
+        assert_eq!(scale_factor(0, 50), 1.0);
+        assert_eq!(scale_factor(50, 50), 1.0);
+        assert_eq!(scale_factor(75, 50), 0.5);
+        assert_eq!(scale_factor(100, 50), 0.0);
+        assert_eq!(scale_factor(120, 50), 0.0);
+    }
+
+    #[test]
+    fn test_scale() {
+        assert_eq!(scale(10, 110, 1.0), 110);
+        assert_eq!(scale(10, 110, 0.5), 60);
+        assert_eq!(scale(10, 110, 0.0), 10);
+        assert_eq!(scale(200, 100, 0.5), 200);
+    }
+
+    #[test]
+    fn test_count_lines() {
+        assert_eq!(count_lines(b""), 0);
+        assert_eq!(count_lines(b"{\"a\":1}\n{\"a\":2}\n"), 2);
+        assert_eq!(count_lines(b"{\"a\":1}\n  \n{\"a\":2}"), 2);
+    }
+}
@@ -23,10 +23,18 @@ use chrono::{Duration, TimeZone, Utc};
 use config::{
     cluster, get_config,
     meta::{
-        stream::{PartitionTimeLevel, PartitioningDetails, Routing, StreamPartition, StreamType},
+        stream::{
+            PartitionTimeLevel, PartitioningDetails, Routing, SamplingRule, StreamPartition,
+            StreamType,
+        },
         usage::{RequestStats, TriggerData, TriggerDataStatus, TriggerDataType},
     },
-    utils::{flatten, json::*},
+    metrics,
+    utils::{
+        flatten,
+        hash::{self, Sum64},
+        json::*,
+    },
     SIZE_IN_MB,
 };
 use vector_enrichment::TableRegistry;
@@ -48,13 +56,18 @@ use crate::{
         },
         utils::functions::get_vrl_compiler_config,
     },
-    service::{db, format_partition_key},
+    service::{db, format_partition_key, users},
 };
 
 pub mod grpc;
+pub mod limits;
+pub mod watermark;
 
 pub type TriggerAlertData = Vec<(Alert, Vec<Map<String, Value>>)>;
 
+/// Suffix of the key the shadow version of a function is compiled under
+const SHADOW_FN_SUFFIX: &str = "#shadow";
+
 pub fn compile_vrl_function(func: &str, org_id: &str) -> Result<VRLRuntimeConfig, std::io::Error> {
     if func.contains("get_env_var") {
         return Err(std::io::Error::new(
@@ -94,6 +107,27 @@ pub fn apply_vrl_fn(
     org_id: &str,
     stream_name: &str,
 ) -> Value {
+    match try_apply_vrl_fn(runtime, vrl_runtime, row) {
+        Ok(val) => val,
+        Err(err) => {
+            log::error!(
+                "{}/{} vrl {}. Returning original row.",
+                org_id,
+                stream_name,
+                err,
+            );
+            row.clone()
+        }
+    }
+}
+
+/// Runs the program against the row, returning why it failed instead of
+/// falling back to the original row
+pub fn try_apply_vrl_fn(
+    runtime: &mut Runtime,
+    vrl_runtime: &VRLResultResolver,
+    row: &Value,
+) -> Result<Value, String> {
     let mut metadata = vrl::value::Value::from(BTreeMap::new());
     let mut target = TargetValueRef {
         value: &mut vrl::value::Value::from(row),
@@ -107,27 +141,10 @@ pub fn apply_vrl_fn(
         }
     };
     match result {
-        Ok(res) => match res.try_into() {
-            Ok(val) => val,
-            Err(err) => {
-                log::error!(
-                    "{}/{} vrl failed at processing result {:?}. Returning original row.",
-                    org_id,
-                    stream_name,
-                    err,
-                );
-                row.clone()
-            }
-        },
-        Err(err) => {
-            log::error!(
-                "{}/{} vrl runtime failed at getting result {:?}. Returning original row.",
-                org_id,
-                stream_name,
-                err,
-            );
-            row.clone()
-        }
+        Ok(res) => res
+            .try_into()
+            .map_err(|err| format!("failed at processing result {:?}", err)),
+        Err(err) => Err(format!("runtime failed at getting result {:?}", err)),
     }
 }
 
@@ -172,6 +189,33 @@ pub async fn get_stream_partition_keys(
     }
 }
 
+/// Whether the user can skip the sampling rules of the streams with the
+/// sampling override header, only the admins of the org can
+pub async fn can_override_sampling(org_id: &str, user_id: &str) -> bool {
+    if users::is_admin(org_id, user_id).await {
+        return true;
+    }
+    log::warn!(
+        "[SAMPLING] override asked by {user_id} in org {org_id} ignored, only admins can skip the sampling rules"
+    );
+    false
+}
+
+/// Returns the flatten level of the stream, the ingest flatten level when the
+/// stream doesn't set one. The objects deeper than the level are kept whole in
+/// one column, as json, instead of a column per nested key.
+pub async fn get_stream_flatten_level(
+    org_id: &str,
+    stream_type: &StreamType,
+    stream_name: &str,
+) -> u32 {
+    infra::schema::get_settings(org_id, stream_name, *stream_type)
+        .await
+        .and_then(|s| s.flatten_level)
+        .and_then(|v| u32::try_from(v).ok())
+        .unwrap_or_else(|| get_config().limit.ingest_flatten_level)
+}
+
 pub async fn get_stream_alerts(
     streams: &[StreamParams],
     stream_alerts_map: &mut HashMap<String, Vec<Alert>>,
@@ -340,13 +384,40 @@ pub fn register_stream_functions(
                     .unwrap();
                 registry.finish_load();
                 stream_vrl_map.insert(
-                    func_key,
+                    func_key.clone(),
                     VRLResultResolver {
                         program: vrl_runtime_config.program,
                         fields: vrl_runtime_config.fields,
                     },
                 );
             }
+            let Some(shadow) = trans.transform.shadow.as_ref() else {
+                continue;
+            };
+            match compile_vrl_function(shadow, org_id) {
+                Ok(vrl_runtime_config) => {
+                    if let Some(registry) = vrl_runtime_config.config.get_custom::<TableRegistry>()
+                    {
+                        registry.finish_load();
+                    }
+                    stream_vrl_map.insert(
+                        format!("{func_key}{SHADOW_FN_SUFFIX}"),
+                        VRLResultResolver {
+                            program: vrl_runtime_config.program,
+                            fields: vrl_runtime_config.fields,
+                        },
+                    );
+                }
+                Err(e) => {
+                    log::error!(
+                        "{}/{} failed to compile shadow version of function {}: {}",
+                        org_id,
+                        stream_name,
+                        trans.transform.name,
+                        e
+                    );
+                }
+            }
         }
     }
 
@@ -365,7 +436,21 @@ pub fn apply_stream_functions(
         let func_key = format!("{stream_name}/{}", trans.transform.name);
         if stream_vrl_map.contains_key(&func_key) && !value.is_null() {
             let vrl_runtime = stream_vrl_map.get(&func_key).unwrap();
+            // the shadow version sees the same input and its output is only compared
+            let shadow = stream_vrl_map
+                .get(&format!("{func_key}{SHADOW_FN_SUFFIX}"))
+                .map(|shadow| try_apply_vrl_fn(runtime, shadow, &value));
             value = apply_vrl_fn(runtime, vrl_runtime, &value, org_id, stream_name);
+            if let Some(shadow) = shadow {
+                let result = match shadow {
+                    Ok(shadow) if shadow == value => "match",
+                    Ok(_) => "diverged",
+                    Err(_) => "error",
+                };
+                metrics::INGEST_FUNCTION_SHADOW_RECORDS
+                    .with_label_values(&[org_id, stream_name, &trans.transform.name, result])
+                    .inc();
+            }
         }
     }
     flatten::flatten_with_level(value, get_config().limit.ingest_flatten_level)
@@ -386,6 +471,13 @@ pub async fn write_file(
             continue;
         }
         let entry_records = entry.records.len();
+        crate::service::subscriptions::publish(
+            writer.org_id(),
+            writer.stream_type(),
+            stream_name,
+            &entry.records,
+        )
+        .await;
         if let Err(e) = writer
             .write(
                 entry.schema,
@@ -418,19 +510,24 @@ pub fn check_ingestion_allowed(org_id: &str, stream_name: Option<&str>) -> Resul
     {
         return Err(anyhow!("Quota exceeded for this organization [{}]", org_id));
     }
+    crate::service::residency::check_local(org_id).map_err(|e| anyhow!(e))?;
 
     // check if we are allowed to ingest
     if let Some(stream_name) = stream_name {
         if db::compact::retention::is_deleting_stream(org_id, StreamType::Logs, stream_name, None) {
             return Err(anyhow!("stream [{stream_name}] is being deleted"));
         }
+        ingester::check_stream_memtable_size(org_id, &StreamType::Logs.to_string(), stream_name)
+            .map_err(|e| anyhow!(e.to_string()))?;
     };
 
     Ok(())
 }
 
 pub fn get_val_for_attr(attr_val: &Value) -> Value {
-    let local_val = attr_val.as_object().unwrap();
+    let Some(local_val) = attr_val.as_object() else {
+        return attr_val.clone();
+    };
     if let Some((key, value)) = local_val.into_iter().next() {
         match key.as_str() {
             "stringValue" | "string_value" => {
@@ -452,10 +549,10 @@ pub fn get_val_for_attr(attr_val: &Value) -> Value {
 
             "arrayValue" | "array_value" => {
                 let mut vals = vec![];
+                // empty lists omit their values
                 for item in value
                     .get("values")
-                    .unwrap()
-                    .as_array()
+                    .and_then(|v| v.as_array())
                     .unwrap_or(&vec![])
                     .iter()
                 {
@@ -466,17 +563,17 @@ pub fn get_val_for_attr(attr_val: &Value) -> Value {
 
             "kvlistValue" | "kvlist_value" => {
                 let mut vals = Map::new();
+                // empty lists omit their values
                 for item in value
                     .get("values")
-                    .unwrap()
-                    .as_array()
+                    .and_then(|v| v.as_array())
                     .unwrap_or(&vec![])
                     .iter()
                 {
                     let mut key = item.get("key").unwrap().as_str().unwrap_or("").to_string();
                     flatten::format_key(&mut key);
-                    let value = item.get("value").unwrap().clone();
-                    vals.insert(key, get_val_for_attr(&value));
+                    let value = item.get("value").map(get_val_for_attr);
+                    vals.insert(key, value.unwrap_or_default());
                 }
                 return json!(vals);
             }
@@ -555,6 +652,62 @@ pub async fn get_user_defined_schema(
     }
 }
 
+pub async fn get_stream_sampling_rules(
+    streams: &[StreamParams],
+    stream_sampling_map: &mut HashMap<String, Vec<SamplingRule>>,
+) {
+    for stream in streams {
+        let stream_settings =
+            infra::schema::get_settings(&stream.org_id, &stream.stream_name, stream.stream_type)
+                .await
+                .unwrap_or_default();
+        if !stream_settings.sampling_rules.is_empty() {
+            stream_sampling_map.insert(
+                stream.stream_name.to_string(),
+                stream_settings.sampling_rules,
+            );
+        }
+    }
+}
+
+/// Applies the sampling rules to the record, returns the name of the rule
+/// when the record should be dropped.
+pub async fn sample_record<'a>(
+    rules: &'a [SamplingRule],
+    record: &Map<String, Value>,
+) -> Option<&'a str> {
+    sample(rules, record, rand::random::<f64>()).await
+}
+
+/// Applies the sampling rules to a span. The roll is derived from the trace
+/// id instead of being random, so the spans of a trace matching the same rule
+/// are kept or dropped together, whichever node ingests them.
+pub async fn sample_span<'a>(
+    rules: &'a [SamplingRule],
+    record: &Map<String, Value>,
+    trace_id: &str,
+) -> Option<&'a str> {
+    let roll = (hash::fnv::new().sum64(trace_id) % 1_000_000) as f64 / 1_000_000.0;
+    sample(rules, record, roll).await
+}
+
+async fn sample<'a>(
+    rules: &'a [SamplingRule],
+    record: &Map<String, Value>,
+    roll: f64,
+) -> Option<&'a str> {
+    for rule in rules.iter() {
+        if !rule.matches(record).await {
+            continue;
+        }
+        if rule.rate >= 1.0 || (rule.rate > 0.0 && roll < rule.rate) {
+            return None;
+        }
+        return Some(rule.name.as_str());
+    }
+    None
+}
+
 #[cfg(test)]
 mod tests {
     use infra::schema::{unwrap_stream_settings, STREAM_SETTINGS};
@@ -646,4 +799,65 @@ mod tests {
         );
         assert!(result.is_err())
     }
+
+    #[tokio::test]
+    async fn test_sample_record() {
+        let rules = vec![
+            SamplingRule {
+                name: "errors".to_string(),
+                conditions: vec![config::meta::stream::RoutingCondition {
+                    column: "level".to_string(),
+                    operator: config::meta::stream::Operator::EqualTo,
+                    value: Value::String("error".to_string()),
+                    ignore_case: false,
+                }],
+                rate: 1.0,
+            },
+            SamplingRule {
+                name: "others".to_string(),
+                conditions: vec![],
+                rate: 0.0,
+            },
+        ];
+        let mut local_val = Map::new();
+        local_val.insert("level".to_string(), Value::String("error".to_string()));
+        assert_eq!(sample_record(&rules, &local_val).await, None);
+        local_val.insert("level".to_string(), Value::String("debug".to_string()));
+        assert_eq!(sample_record(&rules, &local_val).await, Some("others"));
+        assert_eq!(sample_record(&[], &local_val).await, None);
+    }
+
+    #[tokio::test]
+    async fn test_sample_span() {
+        let rules = vec![SamplingRule {
+            name: "half".to_string(),
+            conditions: vec![],
+            rate: 0.5,
+        }];
+        let local_val = Map::new();
+        let mut kept = 0;
+        for i in 0..1000 {
+            let trace_id = format!("{:032x}", i);
+            let sampled = sample_span(&rules, &local_val, &trace_id).await;
+            // every span of the trace gets the same decision
+            assert_eq!(sample_span(&rules, &local_val, &trace_id).await, sampled);
+            if sampled.is_none() {
+                kept += 1;
+            }
+        }
+        assert!((400..600).contains(&kept), "{kept} traces kept of 50%");
+    }
+
+    #[test]
+    fn test_get_val_for_attr() {
+        assert_eq!(get_val_for_attr(&json!({"intValue": "42"})), json!("42"));
+        // empty lists and attributes without value, as the json encoding omits
+        // them
+        assert_eq!(get_val_for_attr(&json!({"arrayValue": {}})), json!([]));
+        assert_eq!(
+            get_val_for_attr(&json!({"kvlistValue": {"values": [{"key": "a"}]}})),
+            json!({"a": null})
+        );
+        assert_eq!(get_val_for_attr(&json!("plain")), json!("plain"));
+    }
 }
@@ -0,0 +1,252 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Ingest to queryable latency. The ingesters write a watermark record to each
+//! stream of `ZO_INGEST_WATERMARK_STREAMS` periodically and search it until it
+//! is found, the time it took is the latency of the stream. It is exported as
+//! the `zo_ingest_watermark_latency_seconds` histogram and written to the
+//! metrics of the org as `ingest_watermark_latency_seconds`, so the usual
+//! alerts apply. The watermarks still not found after the timeout are counted
+//! as lost.
+
+use std::{
+    collections::{HashMap, HashSet},
+    sync::Mutex,
+};
+
+use actix_web::web;
+use chrono::Utc;
+use config::{
+    cluster::{is_single_node, LOCAL_NODE_ROLE, LOCAL_NODE_UUID},
+    get_config, ider,
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        stream::StreamType,
+    },
+    metrics,
+    utils::json,
+};
+use once_cell::sync::Lazy;
+use prost::Message;
+use proto::prometheus_rpc::{Label, Sample, TimeSeries, WriteRequest};
+
+use crate::{
+    common::{
+        infra::cluster::get_ingester_by_key,
+        meta::{ingestion::IngestionRequest, prom::NAME_LABEL},
+    },
+    service::{logs, metrics::prom, search as SearchService},
+};
+
+/// Field of the watermark records holding their id
+pub const WATERMARK_FIELD: &str = "o2_watermark";
+/// Metric written to the org of the stream
+const LATENCY_METRIC: &str = "ingest_watermark_latency_seconds";
+/// Seconds between two searches of a watermark
+const POLL_INTERVAL: u64 = 1;
+
+/// Streams with a watermark not found yet
+static IN_FLIGHT: Lazy<Mutex<HashSet<(String, String)>>> = Lazy::new(Default::default);
+
+/// Keeps the time the watermark of each stream is due next
+#[derive(Default)]
+pub struct Tracker {
+    next_runs: HashMap<(String, String), i64>,
+}
+
+impl Tracker {
+    /// Writes the watermarks which are due, of the streams owned by this node
+    pub fn run(&mut self) {
+        let cfg = get_config();
+        let now = Utc::now().timestamp();
+        let streams = parse_streams(&cfg.limit.ingest_watermark_streams);
+        for stream in streams.iter() {
+            if self.next_runs.get(stream).is_some_and(|t| *t > now) {
+                continue;
+            }
+            self.next_runs
+                .insert(stream.clone(), now + cfg.limit.ingest_watermark_interval);
+            // a slow stream gets one watermark at a time
+            if !IN_FLIGHT.lock().unwrap().insert(stream.clone()) {
+                continue;
+            }
+            let stream = stream.clone();
+            tokio::task::spawn(async move {
+                let (org_id, stream_name) = &stream;
+                if is_owner(org_id, stream_name).await {
+                    if let Err(e) = measure(org_id, stream_name).await {
+                        log::error!("[WATERMARK] {org_id}/{stream_name} measure error: {e}");
+                    }
+                }
+                IN_FLIGHT.lock().unwrap().remove(&stream);
+            });
+        }
+        self.next_runs.retain(|stream, _| streams.contains(stream));
+    }
+}
+
+async fn is_owner(org_id: &str, stream_name: &str) -> bool {
+    is_single_node(&LOCAL_NODE_ROLE)
+        || get_ingester_by_key(&format!("watermark/{org_id}/{stream_name}")).await
+            == Some(LOCAL_NODE_UUID.clone())
+}
+
+/// Writes a watermark to the stream and searches it until it is found or the
+/// timeout expires
+async fn measure(org_id: &str, stream_name: &str) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let id = ider::uuid();
+    let sent_at = Utc::now().timestamp_micros();
+    let record = json::json!({
+        (cfg.common.column_timestamp.as_str()): sent_at,
+        WATERMARK_FIELD: id,
+        "o2_watermark_node": cfg.common.instance_name.as_str(),
+    });
+    let data = web::Bytes::from(json::to_vec(&vec![record])?);
+    let resp =
+        logs::ingest::ingest(org_id, stream_name, IngestionRequest::JSON(&data), "", true).await?;
+    if resp.code != 200 {
+        return Err(anyhow::anyhow!(
+            "ingest watermark error: {}",
+            resp.error.unwrap_or_default()
+        ));
+    }
+
+    let timeout = cfg.limit.ingest_watermark_timeout * 1_000_000;
+    loop {
+        tokio::time::sleep(tokio::time::Duration::from_secs(POLL_INTERVAL)).await;
+        let now = Utc::now().timestamp_micros();
+        if now - sent_at > timeout {
+            metrics::INGEST_WATERMARK_LOST
+                .with_label_values(&[org_id, stream_name])
+                .inc();
+            log::warn!(
+                "[WATERMARK] {org_id}/{stream_name} watermark {id} not queryable after {}s",
+                cfg.limit.ingest_watermark_timeout
+            );
+            return Ok(());
+        }
+        // the field is unknown to the queriers until the schema is synced,
+        // which is part of the latency
+        match is_queryable(org_id, stream_name, &id, sent_at, now).await {
+            Ok(true) => break,
+            Ok(false) => {}
+            Err(e) => log::debug!("[WATERMARK] {org_id}/{stream_name} search error: {e}"),
+        }
+    }
+
+    let latency = (Utc::now().timestamp_micros() - sent_at) as f64 / 1_000_000.0;
+    metrics::INGEST_WATERMARK_LATENCY_SECONDS
+        .with_label_values(&[org_id, stream_name])
+        .observe(latency);
+    let req = WriteRequest {
+        timeseries: vec![latency_series(stream_name, latency, sent_at / 1000)],
+        metadata: vec![],
+    }
+    .encode_to_vec();
+    let body = snap::raw::Encoder::new().compress_vec(&req)?;
+    prom::remote_write(org_id, body.into()).await
+}
+
+async fn is_queryable(
+    org_id: &str,
+    stream_name: &str,
+    id: &str,
+    sent_at: i64,
+    now: i64,
+) -> Result<bool, anyhow::Error> {
+    let req = Request {
+        query: Query {
+            sql: format!(
+                "SELECT {WATERMARK_FIELD} FROM \"{stream_name}\" WHERE {WATERMARK_FIELD} = '{id}'"
+            ),
+            from: 0,
+            size: 1,
+            start_time: sent_at,
+            end_time: now + 1,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, org_id, StreamType::Logs, None, &req).await?;
+    Ok(!resp.hits.is_empty())
+}
+
+/// Parses `org/stream` items separated by commas
+fn parse_streams(streams: &str) -> Vec<(String, String)> {
+    streams
+        .split(',')
+        .filter_map(|item| {
+            let (org_id, stream_name) = item.trim().split_once('/')?;
+            let (org_id, stream_name) = (org_id.trim(), stream_name.trim());
+            (!org_id.is_empty() && !stream_name.is_empty())
+                .then(|| (org_id.to_string(), stream_name.to_string()))
+        })
+        .collect()
+}
+
+fn latency_series(stream_name: &str, latency: f64, timestamp: i64) -> TimeSeries {
+    TimeSeries {
+        labels: vec![
+            Label {
+                name: NAME_LABEL.to_string(),
+                value: LATENCY_METRIC.to_string(),
+            },
+            Label {
+                name: "stream".to_string(),
+                value: stream_name.to_string(),
+            },
+        ],
+        samples: vec![Sample {
+            value: latency,
+            timestamp,
+        }],
+        ..Default::default()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_streams() {
+        assert!(parse_streams("").is_empty());
+        assert_eq!(
+            parse_streams("default/app, default/web ,bad,/x,y/"),
+            vec![
+                ("default".to_string(), "app".to_string()),
+                ("default".to_string(), "web".to_string()),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_latency_series() {
+        let series = latency_series("app", 2.5, 1000);
+        assert_eq!(series.labels[0].value, LATENCY_METRIC);
+        assert_eq!(series.labels[1].value, "app");
+        assert_eq!(series.samples[0].value, 2.5);
+        assert_eq!(series.samples[0].timestamp, 1000);
+    }
+}
@@ -0,0 +1,423 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Ingests the kubernetes events and the state changes of the pods and
+//! deployments into logs streams with normalized fields, so infrastructure
+//! changes can be correlated with the telemetry of the workloads.
+
+use std::collections::HashMap;
+
+use chrono::Utc;
+use config::{
+    get_config,
+    utils::{json, time::parse_str_to_timestamp_micros},
+};
+use proto::cluster_rpc;
+use tokio::time;
+
+use super::usage::ingestion_service;
+use crate::common::infra::kubernetes;
+
+/// Watched kinds with the prefix of their API path
+pub const RESOURCES: [(&str, &str, &str); 3] = [
+    ("Event", "/api/v1", "events"),
+    ("Pod", "/api/v1", "pods"),
+    ("Deployment", "/apis/apps/v1", "deployments"),
+];
+
+const BATCH_SIZE: usize = 500;
+const FLUSH_INTERVAL: time::Duration = time::Duration::from_secs(1);
+
+/// Lists the resources of the kind then follows their changes until the
+/// watch fails
+pub async fn watch(kind: &str, api: &str, plural: &str) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let path = if cfg.k8s_watcher.namespace.is_empty() {
+        format!("{api}/{plural}")
+    } else {
+        format!("{api}/namespaces/{}/{plural}", cfg.k8s_watcher.namespace)
+    };
+    let stream = if kind == "Event" {
+        &cfg.k8s_watcher.events_stream
+    } else {
+        &cfg.k8s_watcher.objects_stream
+    };
+
+    let client = kubernetes::Client::in_cluster()?;
+    let list = client.get(&path, &[]).await?;
+    let mut resource_version = list
+        .pointer("/metadata/resourceVersion")
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .to_string();
+    // the current state is the baseline, only the following changes are
+    // ingested
+    let mut states: HashMap<String, json::Map<String, json::Value>> = HashMap::new();
+    if kind != "Event" {
+        for item in list
+            .get("items")
+            .and_then(|v| v.as_array())
+            .into_iter()
+            .flatten()
+        {
+            if let (Some(uid), Some(summary)) = (uid(item), summarize(kind, item)) {
+                states.insert(uid, summary);
+            }
+        }
+    }
+
+    let mut records = Vec::new();
+    loop {
+        let mut watch = client.watch(&path, &resource_version).await?;
+        loop {
+            let event = match time::timeout(FLUSH_INTERVAL, watch.next()).await {
+                Ok(event) => event?,
+                Err(_) => {
+                    flush(stream, &mut records).await;
+                    continue;
+                }
+            };
+            let Some(event) = event else {
+                break; // closed by the server, watch again
+            };
+            let event_type = event
+                .get("type")
+                .and_then(|v| v.as_str())
+                .unwrap_or_default();
+            let Some(object) = event.get("object") else {
+                continue;
+            };
+            if event_type == "ERROR" {
+                // e.g. 410 Gone when the resource version is too old
+                flush(stream, &mut records).await;
+                return Err(anyhow::anyhow!(
+                    "watch {path} error: {}",
+                    object
+                        .get("message")
+                        .and_then(|v| v.as_str())
+                        .unwrap_or_default()
+                ));
+            }
+            if let Some(v) = object
+                .pointer("/metadata/resourceVersion")
+                .and_then(|v| v.as_str())
+            {
+                resource_version = v.to_string();
+            }
+            let record = if kind == "Event" {
+                (event_type == "ADDED" || event_type == "MODIFIED")
+                    .then(|| event_record(object, &cfg.k8s_watcher.cluster_name))
+            } else {
+                object_change(kind, event_type, object, &mut states).map(|mut record| {
+                    record.insert(
+                        "k8s_cluster".to_string(),
+                        cfg.k8s_watcher.cluster_name.clone().into(),
+                    );
+                    record
+                })
+            };
+            if let Some(record) = record {
+                records.push(json::Value::Object(record));
+            }
+            if records.len() >= BATCH_SIZE {
+                flush(stream, &mut records).await;
+            }
+        }
+        flush(stream, &mut records).await;
+    }
+}
+
+async fn flush(stream: &str, records: &mut Vec<json::Value>) {
+    if records.is_empty() {
+        return;
+    }
+    let org_id = &get_config().k8s_watcher.org;
+    let req = cluster_rpc::UsageRequest {
+        stream_name: stream.to_string(),
+        data: Some(cluster_rpc::UsageData::from(std::mem::take(records))),
+    };
+    match ingestion_service::ingest(org_id, req).await {
+        Ok(resp) if resp.status_code != 200 => {
+            log::error!(
+                "[K8S_WATCHER] write to stream {stream} error: {}",
+                resp.message
+            )
+        }
+        Err(e) => log::error!("[K8S_WATCHER] write to stream {stream} error: {e}"),
+        _ => {}
+    }
+}
+
+fn uid(object: &json::Value) -> Option<String> {
+    object
+        .pointer("/metadata/uid")
+        .and_then(|v| v.as_str())
+        .map(|v| v.to_string())
+}
+
+fn str_field(object: &json::Value, pointer: &str) -> json::Value {
+    object
+        .pointer(pointer)
+        .and_then(|v| v.as_str())
+        .unwrap_or_default()
+        .into()
+}
+
+fn event_record(event: &json::Value, cluster: &str) -> json::Map<String, json::Value> {
+    let timestamp = [
+        "/lastTimestamp",
+        "/eventTime",
+        "/series/lastObservedTime",
+        "/firstTimestamp",
+        "/metadata/creationTimestamp",
+    ]
+    .iter()
+    .filter_map(|p| event.pointer(p).and_then(|v| v.as_str()))
+    .find_map(|v| parse_str_to_timestamp_micros(v).ok())
+    .unwrap_or_else(|| Utc::now().timestamp_micros());
+    let source = match event.pointer("/source/component").and_then(|v| v.as_str()) {
+        Some(v) if !v.is_empty() => v.into(),
+        _ => str_field(event, "/reportingComponent"),
+    };
+    let namespace = match event
+        .pointer("/involvedObject/namespace")
+        .and_then(|v| v.as_str())
+    {
+        Some(v) if !v.is_empty() => v.into(),
+        _ => str_field(event, "/metadata/namespace"),
+    };
+
+    let mut record = json::Map::new();
+    record.insert(
+        get_config().common.column_timestamp.clone(),
+        timestamp.into(),
+    );
+    record.insert("k8s_cluster".to_string(), cluster.into());
+    record.insert("namespace".to_string(), namespace);
+    record.insert("kind".to_string(), str_field(event, "/involvedObject/kind"));
+    record.insert("name".to_string(), str_field(event, "/involvedObject/name"));
+    record.insert("uid".to_string(), str_field(event, "/involvedObject/uid"));
+    record.insert("reason".to_string(), str_field(event, "/reason"));
+    record.insert("message".to_string(), str_field(event, "/message"));
+    record.insert("event_type".to_string(), str_field(event, "/type"));
+    record.insert(
+        "count".to_string(),
+        event
+            .get("count")
+            .and_then(|v| v.as_i64())
+            .unwrap_or(1)
+            .into(),
+    );
+    record.insert("source".to_string(), source);
+    record.insert("node".to_string(), str_field(event, "/source/host"));
+    record
+}
+
+/// Returns the fields of the object whose changes are ingested
+fn summarize(kind: &str, object: &json::Value) -> Option<json::Map<String, json::Value>> {
+    let mut summary = json::Map::new();
+    match kind {
+        "Pod" => {
+            let statuses = object
+                .pointer("/status/containerStatuses")
+                .and_then(|v| v.as_array())
+                .cloned()
+                .unwrap_or_default();
+            let ready = !statuses.is_empty()
+                && statuses
+                    .iter()
+                    .all(|s| s.get("ready").and_then(|v| v.as_bool()) == Some(true));
+            let restarts: i64 = statuses
+                .iter()
+                .filter_map(|s| s.get("restartCount").and_then(|v| v.as_i64()))
+                .sum();
+            // the reason the container is not running, e.g. CrashLoopBackOff,
+            // or the reason of its last termination, e.g. OOMKilled
+            let (container, reason) = statuses
+                .iter()
+                .find_map(|s| {
+                    [
+                        "/state/waiting/reason",
+                        "/state/terminated/reason",
+                        "/lastState/terminated/reason",
+                    ]
+                    .iter()
+                    .find_map(|p| s.pointer(p).and_then(|v| v.as_str()))
+                    .map(|reason| (s.get("name").cloned(), reason.to_string()))
+                })
+                .unwrap_or_default();
+            summary.insert("phase".to_string(), str_field(object, "/status/phase"));
+            summary.insert("ready".to_string(), ready.into());
+            summary.insert("restart_count".to_string(), restarts.into());
+            summary.insert("oom_killed".to_string(), (reason == "OOMKilled").into());
+            summary.insert("reason".to_string(), reason.into());
+            summary.insert(
+                "container".to_string(),
+                container.unwrap_or_else(|| "".into()),
+            );
+            summary.insert("node".to_string(), str_field(object, "/spec/nodeName"));
+        }
+        "Deployment" => {
+            let int_field = |p: &str| object.pointer(p).and_then(|v| v.as_i64()).unwrap_or(0);
+            let images = object
+                .pointer("/spec/template/spec/containers")
+                .and_then(|v| v.as_array())
+                .map(|containers| {
+                    containers
+                        .iter()
+                        .filter_map(|c| c.get("image").and_then(|v| v.as_str()))
+                        .collect::<Vec<_>>()
+                        .join(",")
+                })
+                .unwrap_or_default();
+            summary.insert("replicas".to_string(), int_field("/spec/replicas").into());
+            summary.insert(
+                "ready_replicas".to_string(),
+                int_field("/status/readyReplicas").into(),
+            );
+            summary.insert(
+                "available_replicas".to_string(),
+                int_field("/status/availableReplicas").into(),
+            );
+            summary.insert(
+                "updated_replicas".to_string(),
+                int_field("/status/updatedReplicas").into(),
+            );
+            summary.insert(
+                "generation".to_string(),
+                int_field("/metadata/generation").into(),
+            );
+            summary.insert("images".to_string(), images.into());
+        }
+        _ => return None,
+    }
+    Some(summary)
+}
+
+/// Returns the record of the change when the summary of the object changed
+fn object_change(
+    kind: &str,
+    event_type: &str,
+    object: &json::Value,
+    states: &mut HashMap<String, json::Map<String, json::Value>>,
+) -> Option<json::Map<String, json::Value>> {
+    let uid = uid(object)?;
+    let summary = summarize(kind, object)?;
+    let (change, previous) = match event_type {
+        "ADDED" => ("added", states.insert(uid, summary.clone())),
+        "MODIFIED" => ("modified", states.insert(uid, summary.clone())),
+        "DELETED" => ("deleted", states.remove(&uid)),
+        _ => return None,
+    };
+    if change != "deleted" && previous.as_ref() == Some(&summary) {
+        return None;
+    }
+
+    let mut record = json::Map::new();
+    record.insert(
+        get_config().common.column_timestamp.clone(),
+        Utc::now().timestamp_micros().into(),
+    );
+    record.insert("kind".to_string(), kind.into());
+    record.insert(
+        "namespace".to_string(),
+        str_field(object, "/metadata/namespace"),
+    );
+    record.insert("name".to_string(), str_field(object, "/metadata/name"));
+    record.insert("uid".to_string(), object.pointer("/metadata/uid").cloned()?);
+    record.insert("change".to_string(), change.into());
+    if let Some(phase) = previous.as_ref().and_then(|p| p.get("phase")) {
+        if summary.get("phase") != Some(phase) {
+            record.insert("previous_phase".to_string(), phase.clone());
+        }
+    }
+    record.extend(summary);
+    Some(record)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn pod(phase: &str, restarts: i64, last_reason: Option<&str>) -> json::Value {
+        let mut status = json::json!({
+            "name": "app",
+            "ready": phase == "Running",
+            "restartCount": restarts,
+        });
+        if let Some(reason) = last_reason {
+            status["lastState"] = json::json!({"terminated": {"reason": reason}});
+        }
+        json::json!({
+            "metadata": {"uid": "u1", "name": "api-0", "namespace": "prod"},
+            "spec": {"nodeName": "node-1"},
+            "status": {"phase": phase, "containerStatuses": [status]}
+        })
+    }
+
+    #[test]
+    fn test_object_change() {
+        let mut states = HashMap::new();
+        let record = object_change("Pod", "ADDED", &pod("Pending", 0, None), &mut states).unwrap();
+        assert_eq!(record.get("change").unwrap(), "added");
+        assert_eq!(record.get("phase").unwrap(), "Pending");
+
+        let record =
+            object_change("Pod", "MODIFIED", &pod("Running", 0, None), &mut states).unwrap();
+        assert_eq!(record.get("previous_phase").unwrap(), "Pending");
+        assert_eq!(record.get("ready").unwrap(), true);
+
+        // nothing ingested when the summary did not change
+        assert!(object_change("Pod", "MODIFIED", &pod("Running", 0, None), &mut states).is_none());
+
+        let record = object_change(
+            "Pod",
+            "MODIFIED",
+            &pod("Running", 1, Some("OOMKilled")),
+            &mut states,
+        )
+        .unwrap();
+        assert_eq!(record.get("oom_killed").unwrap(), true);
+        assert_eq!(record.get("restart_count").unwrap(), 1);
+        assert_eq!(record.get("container").unwrap(), "app");
+        assert!(record.get("previous_phase").is_none());
+
+        let record =
+            object_change("Pod", "DELETED", &pod("Running", 1, None), &mut states).unwrap();
+        assert_eq!(record.get("change").unwrap(), "deleted");
+        assert!(states.is_empty());
+    }
+
+    #[test]
+    fn test_event_record() {
+        let event = json::json!({
+            "metadata": {"namespace": "prod"},
+            "involvedObject": {"kind": "Pod", "name": "api-0", "uid": "u1"},
+            "reason": "BackOff",
+            "message": "Back-off restarting failed container",
+            "type": "Warning",
+            "count": 5,
+            "lastTimestamp": "2024-01-02T03:04:05Z",
+            "source": {"component": "kubelet", "host": "node-1"}
+        });
+        let record = event_record(&event, "prod-eu");
+        assert_eq!(record.get("_timestamp").unwrap(), 1704164645000000i64);
+        assert_eq!(record.get("namespace").unwrap(), "prod");
+        assert_eq!(record.get("kind").unwrap(), "Pod");
+        assert_eq!(record.get("count").unwrap(), 5);
+        assert_eq!(record.get("source").unwrap(), "kubelet");
+        assert_eq!(record.get("k8s_cluster").unwrap(), "prod-eu");
+    }
+}
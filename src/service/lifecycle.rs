@@ -0,0 +1,358 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Projects the storage of streams for capacity planning.
+//!
+//! The projection keeps the compressed size of every day of data: the data
+//! stored now is spread evenly over the days it covers, then every simulated
+//! day adds the day's ingest, rolls up the days older than the rollup and
+//! deletes the days older than the retention. The cost of a day is the size of
+//! each day of data at the price of the tier of its age.
+
+use std::collections::{BTreeMap, VecDeque};
+
+use config::{get_config, meta::stream::StreamType};
+
+use crate::common::meta::lifecycle::{
+    LifecycleEstimateRequest, LifecycleEstimateResponse, MonthEstimate, Rollup, StorageTier,
+    StreamEstimate, StreamLifecycle, MAX_MONTHS,
+};
+
+const GB: f64 = 1024.0 * 1024.0 * 1024.0;
+const DAY_MICROS: i64 = 24 * 3600 * 1_000_000;
+const DAYS_PER_MONTH: i64 = 30;
+/// Compression of a stream without data to observe it on
+const DEFAULT_COMPRESSION_RATIO: f64 = 0.1;
+/// Object store standard storage, per GB and month
+const DEFAULT_TIER_NAME: &str = "standard";
+const DEFAULT_TIER_PRICE: f64 = 0.023;
+
+/// Inputs of the projection of one stream
+#[derive(Clone, Debug, Default)]
+struct StreamModel {
+    /// compressed GB stored now
+    current_gb: f64,
+    /// days covered by the data stored now
+    current_days: i64,
+    /// compressed GB ingested per day
+    daily_gb: f64,
+    /// percent
+    monthly_growth: f64,
+    /// 0 keeps forever
+    retention_days: i64,
+    rollup: Option<Rollup>,
+}
+
+pub async fn estimate(
+    org_id: &str,
+    req: &LifecycleEstimateRequest,
+) -> Result<LifecycleEstimateResponse, anyhow::Error> {
+    if req.months == 0 || req.months > MAX_MONTHS {
+        return Err(anyhow::anyhow!(
+            "months should be between 1 and {MAX_MONTHS}"
+        ));
+    }
+    if req.streams.is_empty() {
+        return Err(anyhow::anyhow!("at least one stream is required"));
+    }
+    let tiers = tiers(&req.tiers)?;
+
+    let mut resp = LifecycleEstimateResponse::default();
+    for stream in req.streams.iter() {
+        let (estimate, model) = stream_model(org_id, stream).await?;
+        let months = project(&model, &tiers, req.months);
+        resp.total = if resp.total.is_empty() {
+            months.clone()
+        } else {
+            add_months(resp.total, &months)
+        };
+        resp.streams.push(StreamEstimate { months, ..estimate });
+    }
+    Ok(resp)
+}
+
+/// Validates the tiers and sorts them by age
+fn tiers(tiers: &[StorageTier]) -> Result<Vec<StorageTier>, anyhow::Error> {
+    if tiers.is_empty() {
+        return Ok(vec![StorageTier {
+            name: DEFAULT_TIER_NAME.to_string(),
+            after_days: 0,
+            price_per_gb_month: DEFAULT_TIER_PRICE,
+        }]);
+    }
+    for tier in tiers.iter() {
+        if tier.name.is_empty() {
+            return Err(anyhow::anyhow!("tier name is required"));
+        }
+        if tier.after_days < 0 || tier.price_per_gb_month < 0.0 {
+            return Err(anyhow::anyhow!(
+                "tier {} should have a positive age and price",
+                tier.name
+            ));
+        }
+    }
+    let mut tiers = tiers.to_vec();
+    tiers.sort_by_key(|t| t.after_days);
+    Ok(tiers)
+}
+
+/// Fills the settings the request doesn't set with the ones observed on the
+/// stream
+async fn stream_model(
+    org_id: &str,
+    stream: &StreamLifecycle,
+) -> Result<(StreamEstimate, StreamModel), anyhow::Error> {
+    let name = &stream.stream_name;
+    if let Some(rollup) = stream.rollup.as_ref() {
+        if rollup.after_days < 0 || rollup.size_ratio <= 0.0 || rollup.size_ratio > 1.0 {
+            return Err(anyhow::anyhow!(
+                "stream {name}: rollup should have a positive age and a size ratio in (0, 1]"
+            ));
+        }
+    }
+    if stream.monthly_growth <= -100.0 {
+        return Err(anyhow::anyhow!(
+            "stream {name}: monthly growth should be above -100%"
+        ));
+    }
+
+    let stats = infra::cache::stats::get_stream_stats(org_id, name, stream.stream_type);
+    let current_days = if stats.doc_time_min > 0 && stats.doc_time_max > stats.doc_time_min {
+        ((stats.doc_time_max - stats.doc_time_min) / DAY_MICROS).max(1)
+    } else {
+        1
+    };
+    let ingest_gb_per_day = match stream.ingest_gb_per_day {
+        Some(v) if v >= 0.0 => v,
+        Some(_) => {
+            return Err(anyhow::anyhow!(
+                "stream {name}: ingest rate can't be negative"
+            ));
+        }
+        None if stats.storage_size > 0.0 => stats.storage_size / GB / current_days as f64,
+        None => {
+            return Err(anyhow::anyhow!(
+                "stream {name} has no data to observe the ingest rate on, set ingest_gb_per_day"
+            ));
+        }
+    };
+    let compression_ratio = match stream.compression_ratio {
+        Some(v) if v > 0.0 => v,
+        Some(_) => {
+            return Err(anyhow::anyhow!(
+                "stream {name}: compression ratio should be positive"
+            ));
+        }
+        None if stats.storage_size > 0.0 && stats.compressed_size > 0.0 => {
+            stats.compressed_size / stats.storage_size
+        }
+        None => DEFAULT_COMPRESSION_RATIO,
+    };
+    let retention_days = match stream.retention_days {
+        Some(v) => v.max(0),
+        None => stream_retention(org_id, name, stream.stream_type).await,
+    };
+
+    let model = StreamModel {
+        current_gb: stats.compressed_size / GB,
+        current_days,
+        daily_gb: ingest_gb_per_day * compression_ratio,
+        monthly_growth: stream.monthly_growth,
+        retention_days,
+        rollup: stream.rollup.clone(),
+    };
+    let estimate = StreamEstimate {
+        stream_name: name.to_string(),
+        stream_type: stream.stream_type,
+        ingest_gb_per_day: round(ingest_gb_per_day),
+        compression_ratio: round(compression_ratio),
+        retention_days,
+        current_gb: round(model.current_gb),
+        months: vec![],
+    };
+    Ok((estimate, model))
+}
+
+/// Retention of the stream, the global one when the stream has none
+async fn stream_retention(org_id: &str, stream_name: &str, stream_type: StreamType) -> i64 {
+    let retention = infra::schema::get_settings(org_id, stream_name, stream_type)
+        .await
+        .map(|s| s.data_retention)
+        .unwrap_or_default();
+    if retention > 0 {
+        retention
+    } else {
+        get_config().compact.data_retention_days
+    }
+}
+
+fn project(model: &StreamModel, tiers: &[StorageTier], months: u32) -> Vec<MonthEstimate> {
+    // compressed GB by the day it was ingested, oldest first
+    let mut days: VecDeque<(i64, f64)> = VecDeque::new();
+    if model.current_gb > 0.0 {
+        let per_day = model.current_gb / model.current_days as f64;
+        for day in -model.current_days..0 {
+            days.push_back((day, per_day));
+        }
+    }
+
+    let mut estimates = Vec::with_capacity(months as usize);
+    let mut cost = 0.0;
+    for today in 0..months as i64 * DAYS_PER_MONTH {
+        let growth =
+            (1.0 + model.monthly_growth / 100.0).powf(today as f64 / DAYS_PER_MONTH as f64);
+        days.push_back((today, model.daily_gb * growth));
+        while let Some((day, _)) = days.front() {
+            if model.retention_days > 0 && today - day >= model.retention_days {
+                days.pop_front();
+            } else {
+                break;
+            }
+        }
+
+        let end_of_month = (today + 1) % DAYS_PER_MONTH == 0;
+        let mut stored = BTreeMap::new();
+        for (day, gb) in days.iter() {
+            let age = today - day;
+            let gb = match model.rollup.as_ref() {
+                Some(rollup) if age >= rollup.after_days => gb * rollup.size_ratio,
+                _ => *gb,
+            };
+            let tier = tier_for(tiers, age);
+            cost += gb * tier.price_per_gb_month / DAYS_PER_MONTH as f64;
+            if end_of_month {
+                *stored.entry(tier.name.to_string()).or_insert(0.0) += gb;
+            }
+        }
+        if end_of_month {
+            estimates.push(MonthEstimate {
+                month: ((today + 1) / DAYS_PER_MONTH) as u32,
+                stored_gb: round(stored.values().sum()),
+                tiers: stored.into_iter().map(|(k, v)| (k, round(v))).collect(),
+                cost: round(cost),
+            });
+            cost = 0.0;
+        }
+    }
+    estimates
+}
+
+/// The last tier the age reached, the tiers are sorted by age
+fn tier_for(tiers: &[StorageTier], age: i64) -> &StorageTier {
+    tiers
+        .iter()
+        .rev()
+        .find(|t| t.after_days <= age)
+        .unwrap_or(&tiers[0])
+}
+
+fn add_months(total: Vec<MonthEstimate>, months: &[MonthEstimate]) -> Vec<MonthEstimate> {
+    total
+        .into_iter()
+        .zip(months.iter())
+        .map(|(mut total, month)| {
+            total.stored_gb = round(total.stored_gb + month.stored_gb);
+            total.cost = round(total.cost + month.cost);
+            for (tier, gb) in month.tiers.iter() {
+                let v = total.tiers.entry(tier.to_string()).or_insert(0.0);
+                *v = round(*v + gb);
+            }
+            total
+        })
+        .collect()
+}
+
+fn round(v: f64) -> f64 {
+    (v * 1000.0).round() / 1000.0
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn tier(name: &str, after_days: i64, price: f64) -> StorageTier {
+        StorageTier {
+            name: name.to_string(),
+            after_days,
+            price_per_gb_month: price,
+        }
+    }
+
+    #[test]
+    fn test_project_retention() {
+        let model = StreamModel {
+            daily_gb: 1.0,
+            retention_days: 45,
+            current_days: 1,
+            ..Default::default()
+        };
+        let months = project(&model, &[tier("hot", 0, 1.0)], 3);
+        assert_eq!(months.len(), 3);
+        assert_eq!(months[0].stored_gb, 30.0);
+        assert_eq!(months[1].stored_gb, 45.0);
+        assert_eq!(months[2].stored_gb, 45.0);
+        // sum of 1..=30 GB-days at 1 per GB-month
+        assert_eq!(months[0].cost, 15.5);
+        assert_eq!(months[2].cost, 45.0);
+    }
+
+    #[test]
+    fn test_project_rollup_and_tiers() {
+        let model = StreamModel {
+            current_gb: 10.0,
+            current_days: 10,
+            daily_gb: 1.0,
+            retention_days: 0,
+            rollup: Some(Rollup {
+                after_days: 20,
+                size_ratio: 0.1,
+            }),
+            ..Default::default()
+        };
+        let tiers = tiers(&[tier("cold", 15, 0.5), tier("hot", 0, 1.0)]).unwrap();
+        let months = project(&model, &tiers, 1);
+        // ages 0..=14 hot, 15..=19 cold, 20..=39 rolled up and cold
+        assert_eq!(months[0].tiers.get("hot"), Some(&15.0));
+        assert_eq!(months[0].tiers.get("cold"), Some(&7.0));
+        assert_eq!(months[0].stored_gb, 22.0);
+    }
+
+    #[test]
+    fn test_project_growth() {
+        let model = StreamModel {
+            daily_gb: 1.0,
+            monthly_growth: 100.0,
+            current_days: 1,
+            retention_days: 30,
+            ..Default::default()
+        };
+        let months = project(&model, &tiers(&[]).unwrap(), 2);
+        assert!(months[1].stored_gb > months[0].stored_gb * 1.9);
+    }
+
+    #[test]
+    fn test_add_months() {
+        let month = |gb: f64| MonthEstimate {
+            month: 1,
+            stored_gb: gb,
+            tiers: BTreeMap::from([("hot".to_string(), gb)]),
+            cost: gb,
+        };
+        assert_eq!(
+            add_months(vec![month(1.0)], &[month(2.5)]),
+            vec![month(3.5)]
+        );
+    }
+}
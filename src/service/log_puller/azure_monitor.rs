@@ -0,0 +1,172 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Pulls the records of a Log Analytics workspace, where Azure Monitor stores
+//! the resource and activity logs, with the query API window by window.
+
+use chrono::{DateTime, SecondsFormat, Utc};
+use config::{get_config, utils::json};
+
+use crate::{
+    common::meta::log_puller::{AzureMonitorSource, LogPuller, LogPullerState},
+    service::db,
+};
+
+const SCOPE: &str = "https://api.loganalytics.io/.default";
+const QUERY_URL: &str = "https://api.loganalytics.io/v1/workspaces";
+const TIME_COLUMN: &str = "TimeGenerated";
+
+pub(super) async fn pull(
+    org_id: &str,
+    puller: &LogPuller,
+    state: &mut LogPullerState,
+) -> Result<i64, anyhow::Error> {
+    let Some(source) = puller.azure_monitor.as_ref() else {
+        return Err(anyhow::anyhow!("missing azure_monitor settings"));
+    };
+    let max_records = get_config().limit.log_puller_max_records;
+    let client = super::http_client()?;
+    let token = access_token(&client, source).await?;
+    let mut pulled = 0;
+    while let Some((start, end)) =
+        state.next_window(Utc::now().timestamp_micros(), source.delay, puller.interval)
+    {
+        let resp = client
+            .post(format!("{QUERY_URL}/{}/query", source.workspace_id))
+            .bearer_auth(&token)
+            .header("content-type", "application/json")
+            .body(json::to_vec(&json::json!({
+                "query": window_query(&source.query, start, end),
+            }))?)
+            .send()
+            .await?;
+        let resp = super::read_json(resp, "log analytics query").await?;
+        let records = table_records(&resp["tables"][0]);
+        pulled += records.len() as i64;
+        super::write(org_id, puller, state, records).await?;
+        state.complete_window(end);
+        db::log_puller::set_state(org_id, &puller.name, state).await?;
+        if pulled >= max_records {
+            break;
+        }
+    }
+    Ok(pulled)
+}
+
+async fn access_token(
+    client: &reqwest::Client,
+    source: &AzureMonitorSource,
+) -> Result<String, anyhow::Error> {
+    let resp = client
+        .post(format!(
+            "https://login.microsoftonline.com/{}/oauth2/v2.0/token",
+            source.tenant_id
+        ))
+        .form(&[
+            ("grant_type", "client_credentials"),
+            ("client_id", source.client_id.as_str()),
+            ("client_secret", source.client_secret.as_str()),
+            ("scope", SCOPE),
+        ])
+        .send()
+        .await?;
+    let resp = super::read_json(resp, "azure oauth").await?;
+    match resp["access_token"].as_str() {
+        Some(token) => Ok(token.to_string()),
+        None => Err(anyhow::anyhow!("azure oauth error: no access token")),
+    }
+}
+
+/// Appends the filter of the window, `[start, end)` in microseconds, to the
+/// KQL query
+fn window_query(query: &str, start: i64, end: i64) -> String {
+    let time = |t: i64| {
+        DateTime::from_timestamp_micros(t)
+            .unwrap_or_default()
+            .to_rfc3339_opts(SecondsFormat::Micros, true)
+    };
+    format!(
+        "{} | where {TIME_COLUMN} >= datetime({}) and {TIME_COLUMN} < datetime({}) | order by {TIME_COLUMN} asc",
+        query.trim(),
+        time(start),
+        time(end)
+    )
+}
+
+/// Converts the rows of a query result table into records
+fn table_records(table: &json::Value) -> Vec<json::Value> {
+    let (Some(columns), Some(rows)) = (table["columns"].as_array(), table["rows"].as_array())
+    else {
+        return vec![];
+    };
+    let names = columns
+        .iter()
+        .map(|c| c["name"].as_str().unwrap_or_default())
+        .collect::<Vec<_>>();
+    rows.iter()
+        .filter_map(|row| row.as_array())
+        .map(|row| {
+            let mut record = json::Map::new();
+            for (name, value) in names.iter().zip(row.iter()) {
+                if value.is_null() || value.as_str().is_some_and(|v| v.is_empty()) {
+                    continue;
+                }
+                if *name == TIME_COLUMN {
+                    if let Some(ts) = super::parse_time(value) {
+                        record.insert("_timestamp".to_string(), json::Value::from(ts));
+                    }
+                }
+                record.insert(name.to_string(), value.clone());
+            }
+            json::Value::Object(record)
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_window_query() {
+        assert_eq!(
+            window_query(" AzureActivity ", 1704164645000000, 1704164705000000),
+            "AzureActivity | where TimeGenerated >= datetime(2024-01-02T03:04:05.000000Z) and \
+             TimeGenerated < datetime(2024-01-02T03:05:05.000000Z) | order by TimeGenerated asc"
+        );
+    }
+
+    #[test]
+    fn test_table_records() {
+        let table = json::json!({
+            "name": "PrimaryResult",
+            "columns": [
+                {"name": "TimeGenerated", "type": "datetime"},
+                {"name": "OperationName", "type": "string"},
+                {"name": "Caller", "type": "string"},
+            ],
+            "rows": [
+                ["2024-01-02T03:04:05.5Z", "Delete VM", ""],
+                ["2024-01-02T03:04:06Z", "Create VM", "ops@example.com"],
+            ],
+        });
+        let records = table_records(&table);
+        assert_eq!(records.len(), 2);
+        assert_eq!(records[0]["_timestamp"], 1704164645500000_i64);
+        assert_eq!(records[0]["OperationName"], "Delete VM");
+        assert!(records[0].get("Caller").is_none());
+        assert_eq!(records[1]["Caller"], "ops@example.com");
+    }
+}
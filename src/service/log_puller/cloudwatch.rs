@@ -0,0 +1,201 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Pulls the events of a CloudWatch Logs log group with `FilterLogEvents`,
+//! window by window.
+
+use chrono::Utc;
+use config::{get_config, utils::json};
+
+use crate::{
+    common::{
+        meta::log_puller::{CloudwatchSource, LogPuller, LogPullerState},
+        utils::aws,
+    },
+    service::db,
+};
+
+const SERVICE: &str = "logs";
+const TARGET: &str = "Logs_20140328.FilterLogEvents";
+const CONTENT_TYPE: &str = "application/x-amz-json-1.1";
+const PAGE_SIZE: i64 = 10000;
+
+pub(super) async fn pull(
+    org_id: &str,
+    puller: &LogPuller,
+    state: &mut LogPullerState,
+) -> Result<i64, anyhow::Error> {
+    let Some(source) = puller.cloudwatch.as_ref() else {
+        return Err(anyhow::anyhow!("missing cloudwatch settings"));
+    };
+    let max_records = get_config().limit.log_puller_max_records;
+    let client = super::http_client()?;
+    let mut pulled = 0;
+    while let Some((start, end)) =
+        state.next_window(Utc::now().timestamp_micros(), source.delay, puller.interval)
+    {
+        // startTime and endTime are inclusive, in milliseconds
+        let mut body = json::json!({
+            "logGroupName": source.log_group_name,
+            "startTime": start / 1000,
+            "endTime": end / 1000 - 1,
+            "limit": PAGE_SIZE,
+        });
+        if !source.log_stream_prefix.is_empty() {
+            body["logStreamNamePrefix"] = json::Value::from(source.log_stream_prefix.as_str());
+        }
+        if !source.filter_pattern.is_empty() {
+            body["filterPattern"] = json::Value::from(source.filter_pattern.as_str());
+        }
+        if let Some(token) = state.next_token.as_ref() {
+            body["nextToken"] = json::Value::from(token.as_str());
+        }
+        let resp = filter_log_events(&client, source, &body).await?;
+        let records = resp["events"]
+            .as_array()
+            .map(|events| {
+                events
+                    .iter()
+                    .map(|event| event_record(&source.log_group_name, event))
+                    .collect::<Vec<_>>()
+            })
+            .unwrap_or_default();
+        pulled += records.len() as i64;
+        super::write(org_id, puller, state, records).await?;
+
+        match resp["nextToken"].as_str() {
+            Some(token) if !token.is_empty() => {
+                state.window_end = end;
+                state.next_token = Some(token.to_string());
+            }
+            _ => state.complete_window(end),
+        }
+        db::log_puller::set_state(org_id, &puller.name, state).await?;
+        if pulled >= max_records {
+            break;
+        }
+    }
+    Ok(pulled)
+}
+
+async fn filter_log_events(
+    client: &reqwest::Client,
+    source: &CloudwatchSource,
+    body: &json::Value,
+) -> Result<json::Value, anyhow::Error> {
+    let host = format!("logs.{}.amazonaws.com", source.region);
+    let body = json::to_vec(body)?;
+    let amz_date = Utc::now().format("%Y%m%dT%H%M%SZ").to_string();
+    let mut headers = vec![
+        ("content-type", CONTENT_TYPE.to_string()),
+        ("host", host.clone()),
+        ("x-amz-date", amz_date.clone()),
+        ("x-amz-target", TARGET.to_string()),
+    ];
+    if !source.session_token.is_empty() {
+        headers.push(("x-amz-security-token", source.session_token.clone()));
+    }
+    headers.sort_by(|a, b| a.0.cmp(b.0));
+    let authorization = sign_v4(source, &amz_date, &headers, &body);
+
+    let mut req = client
+        .post(format!("https://{host}/"))
+        .header("authorization", authorization);
+    for (name, value) in headers {
+        if name != "host" {
+            req = req.header(name, value);
+        }
+    }
+    super::read_json(req.body(body).send().await?, "cloudwatch").await
+}
+
+fn event_record(log_group: &str, event: &json::Value) -> json::Value {
+    json::json!({
+        "_timestamp": event["timestamp"].as_i64().unwrap_or_default() * 1000,
+        "log_group": log_group,
+        "log_stream": event["logStreamName"],
+        "event_id": event["eventId"],
+        "ingestion_time": event["ingestionTime"],
+        "message": event["message"],
+    })
+}
+
+/// Signs a POST to `/` with AWS Signature Version 4, the headers should be
+/// lowercase and sorted
+fn sign_v4(
+    source: &CloudwatchSource,
+    amz_date: &str,
+    headers: &[(&str, String)],
+    body: &[u8],
+) -> String {
+    let credential = aws::Credential {
+        access_key_id: &source.access_key_id,
+        secret_access_key: &source.secret_access_key,
+        region: &source.region,
+        service: SERVICE,
+    };
+    aws::sign_v4(
+        &credential,
+        "POST",
+        "/",
+        "",
+        amz_date,
+        headers,
+        &aws::payload_hash(body),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_sign_v4() {
+        let source = CloudwatchSource {
+            region: "us-east-1".to_string(),
+            access_key_id: "AKIDEXAMPLE".to_string(),
+            secret_access_key: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY".to_string(),
+            ..Default::default()
+        };
+        let headers = vec![
+            ("content-type", CONTENT_TYPE.to_string()),
+            ("host", "logs.us-east-1.amazonaws.com".to_string()),
+            ("x-amz-date", "20240102T030405Z".to_string()),
+            ("x-amz-target", TARGET.to_string()),
+        ];
+        let body = br#"{"logGroupName":"/aws/lambda/api"}"#;
+        assert_eq!(
+            sign_v4(&source, "20240102T030405Z", &headers, body),
+            "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/us-east-1/logs/aws4_request, \
+             SignedHeaders=content-type;host;x-amz-date;x-amz-target, \
+             Signature=e87e46c768fced506f98d663000e827b024b2e28cd9601fcb6882e67fb70f5cf"
+        );
+    }
+
+    #[test]
+    fn test_event_record() {
+        let event = json::json!({
+            "logStreamName": "2024/01/02/[$LATEST]abc",
+            "timestamp": 1704164645123_i64,
+            "message": "START RequestId: 1",
+            "ingestionTime": 1704164646000_i64,
+            "eventId": "3781",
+        });
+        let record = event_record("/aws/lambda/api", &event);
+        assert_eq!(record["_timestamp"], 1704164645123000_i64);
+        assert_eq!(record["log_group"], "/aws/lambda/api");
+        assert_eq!(record["message"], "START RequestId: 1");
+    }
+}
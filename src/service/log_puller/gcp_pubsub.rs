@@ -0,0 +1,210 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Pulls the log entries a Cloud Logging sink publishes to a Pub/Sub
+//! subscription. The messages are acknowledged once written, so the
+//! subscription keeps the position and the checkpoint only reports the time of
+//! the latest entry.
+
+use chrono::Utc;
+use config::{
+    get_config,
+    utils::{base64, json},
+};
+use jsonwebtoken::{Algorithm, EncodingKey, Header};
+use serde::{Deserialize, Serialize};
+
+use crate::{
+    common::meta::log_puller::{LogPuller, LogPullerState},
+    service::db,
+};
+
+const SCOPE: &str = "https://www.googleapis.com/auth/pubsub";
+const DEFAULT_TOKEN_URI: &str = "https://oauth2.googleapis.com/token";
+const PUBSUB_URL: &str = "https://pubsub.googleapis.com/v1";
+const PAGE_SIZE: i64 = 1000;
+
+#[derive(Deserialize)]
+struct ServiceAccountKey {
+    client_email: String,
+    private_key: String,
+    #[serde(default)]
+    token_uri: String,
+}
+
+#[derive(Serialize)]
+struct Claims<'a> {
+    iss: &'a str,
+    scope: &'a str,
+    aud: &'a str,
+    iat: i64,
+    exp: i64,
+}
+
+pub(super) async fn pull(
+    org_id: &str,
+    puller: &LogPuller,
+    state: &mut LogPullerState,
+) -> Result<i64, anyhow::Error> {
+    let Some(source) = puller.gcp_pubsub.as_ref() else {
+        return Err(anyhow::anyhow!("missing gcp_pubsub settings"));
+    };
+    let max_records = get_config().limit.log_puller_max_records;
+    let client = super::http_client()?;
+    let token = access_token(&client, &source.service_account_key).await?;
+    let mut pulled = 0;
+    while pulled < max_records {
+        let resp = client
+            .post(format!("{PUBSUB_URL}/{}:pull", source.subscription))
+            .bearer_auth(&token)
+            .header("content-type", "application/json")
+            .body(json::to_vec(&json::json!({ "maxMessages": PAGE_SIZE }))?)
+            .send()
+            .await?;
+        let resp = super::read_json(resp, "pubsub pull").await?;
+        let Some(messages) = resp["receivedMessages"]
+            .as_array()
+            .filter(|messages| !messages.is_empty())
+        else {
+            break;
+        };
+        let mut ack_ids = Vec::with_capacity(messages.len());
+        let mut records = Vec::with_capacity(messages.len());
+        for message in messages {
+            if let Some(id) = message["ackId"].as_str() {
+                ack_ids.push(id.to_string());
+            }
+            if let Some(record) = message_record(&message["message"]) {
+                let ts = record["_timestamp"].as_i64().unwrap_or_default();
+                state.checkpoint = state.checkpoint.max(ts);
+                records.push(record);
+            }
+        }
+        pulled += records.len() as i64;
+        // the messages which are not acknowledged are delivered again, a failed
+        // write is retried by the next run
+        super::write(org_id, puller, state, records).await?;
+        let resp = client
+            .post(format!("{PUBSUB_URL}/{}:acknowledge", source.subscription))
+            .bearer_auth(&token)
+            .header("content-type", "application/json")
+            .body(json::to_vec(&json::json!({ "ackIds": ack_ids }))?)
+            .send()
+            .await?;
+        super::read_json(resp, "pubsub acknowledge").await?;
+        db::log_puller::set_state(org_id, &puller.name, state).await?;
+    }
+    Ok(pulled)
+}
+
+/// Exchanges a JWT signed with the key of the service account for an access
+/// token
+async fn access_token(client: &reqwest::Client, key: &str) -> Result<String, anyhow::Error> {
+    let key: ServiceAccountKey =
+        json::from_str(key).map_err(|e| anyhow::anyhow!("invalid service account key: {e}"))?;
+    let token_uri = if key.token_uri.is_empty() {
+        DEFAULT_TOKEN_URI
+    } else {
+        key.token_uri.as_str()
+    };
+    let now = Utc::now().timestamp();
+    let claims = Claims {
+        iss: &key.client_email,
+        scope: SCOPE,
+        aud: token_uri,
+        iat: now,
+        exp: now + 3600,
+    };
+    let assertion = jsonwebtoken::encode(
+        &Header::new(Algorithm::RS256),
+        &claims,
+        &EncodingKey::from_rsa_pem(key.private_key.as_bytes())?,
+    )?;
+    let resp = client
+        .post(token_uri)
+        .form(&[
+            ("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer"),
+            ("assertion", assertion.as_str()),
+        ])
+        .send()
+        .await?;
+    let resp = super::read_json(resp, "google oauth").await?;
+    match resp["access_token"].as_str() {
+        Some(token) => Ok(token.to_string()),
+        None => Err(anyhow::anyhow!("google oauth error: no access token")),
+    }
+}
+
+/// Converts a Pub/Sub message into a record. The data of the messages
+/// published by a logging sink is a `LogEntry`, other messages are kept as
+/// text with their attributes.
+fn message_record(message: &json::Value) -> Option<json::Value> {
+    let data = base64::decode_raw(message["data"].as_str().unwrap_or_default()).ok()?;
+    let publish_time =
+        super::parse_time(&message["publishTime"]).unwrap_or_else(|| Utc::now().timestamp_micros());
+    let mut record = match json::from_slice::<json::Value>(&data) {
+        Ok(json::Value::Object(entry)) => {
+            let mut record = json::Value::Object(entry);
+            let ts = super::parse_time(&record["timestamp"])
+                .or_else(|| super::parse_time(&record["receiveTimestamp"]))
+                .unwrap_or(publish_time);
+            record["_timestamp"] = json::Value::from(ts);
+            record
+        }
+        _ => json::json!({
+            "_timestamp": publish_time,
+            "message": String::from_utf8_lossy(&data),
+            "attributes": message["attributes"],
+        }),
+    };
+    record["message_id"] = message["messageId"].clone();
+    Some(record)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_message_record() {
+        let entry = json::json!({
+            "insertId": "1",
+            "logName": "projects/p/logs/stdout",
+            "severity": "ERROR",
+            "textPayload": "boom",
+            "timestamp": "2024-01-02T03:04:05.123456Z",
+        });
+        let message = json::json!({
+            "data": base64::encode(&entry.to_string()),
+            "messageId": "42",
+            "publishTime": "2024-01-02T03:04:06Z",
+        });
+        let record = message_record(&message).unwrap();
+        assert_eq!(record["_timestamp"], 1704164645123456_i64);
+        assert_eq!(record["severity"], "ERROR");
+        assert_eq!(record["message_id"], "42");
+
+        let message = json::json!({
+            "data": base64::encode("plain text"),
+            "messageId": "43",
+            "publishTime": "2024-01-02T03:04:06Z",
+            "attributes": {"source": "app"},
+        });
+        let record = message_record(&message).unwrap();
+        assert_eq!(record["_timestamp"], 1704164646000000_i64);
+        assert_eq!(record["message"], "plain text");
+        assert_eq!(record["attributes"]["source"], "app");
+    }
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Managed integrations pulling the logs of the cloud providers into a logs
+//! stream, so the cloud logs can be onboarded without deploying forwarders.
+//! Every integration is pulled by one ingester, which keeps its checkpoint
+//! in the metadata store and resumes from it after a restart or a takeover.
+
+use std::{
+    collections::{HashMap, HashSet},
+    io::Error,
+    sync::Arc,
+};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    cluster::{is_single_node, LOCAL_NODE_ROLE, LOCAL_NODE_UUID},
+    get_config, metrics,
+    utils::json,
+};
+use parking_lot::Mutex;
+use proto::cluster_rpc;
+
+use crate::{
+    common::{
+        infra::cluster::get_ingester_by_key,
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            log_puller::{LogPuller, LogPullerList, LogPullerProvider, LogPullerState},
+        },
+    },
+    service::{db, plugins, secrets, usage::ingestion_service},
+};
+
+mod azure_monitor;
+mod cloudwatch;
+mod gcp_pubsub;
+mod plugin;
+
+/// Seconds between two reloads of the integrations
+const CONFIG_RELOAD_INTERVAL: i64 = 30;
+/// Seconds to wait for the APIs of the cloud providers
+const HTTP_TIMEOUT: u64 = 60;
+
+#[tracing::instrument(skip(puller))]
+pub async fn save_puller(
+    org_id: &str,
+    mut puller: LogPuller,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    puller.name = puller.name.trim().to_string();
+    let stored = db::log_puller::get(org_id, &puller.name).await.ok();
+    if create && stored.is_some() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Log puller {} already exists",
+            puller.name
+        )));
+    }
+    let now = Utc::now().timestamp_micros();
+    match stored {
+        Some(stored) => {
+            puller.keep_secrets(&stored);
+            puller.created_at = stored.created_at;
+        }
+        None if !create => return Ok(MetaHttpResponse::not_found("Log puller not found")),
+        None => puller.created_at = now,
+    }
+    puller.updated_at = now;
+    if let Err(e) = puller.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if let Err(e) = secrets::check_refs(org_id, "log_pullers", &puller).await {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if let Some(target) = puller.plugin.as_ref() {
+        if let Err(e) = plugins::check_source(&target.plugin) {
+            return Ok(MetaHttpResponse::bad_request(e));
+        }
+    }
+    match db::log_puller::set(org_id, &puller).await {
+        Ok(_) => {
+            puller.mask_secrets();
+            Ok(MetaHttpResponse::json(puller))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_pullers(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::log_puller::list(org_id).await {
+        Ok(mut list) => {
+            list.iter_mut().for_each(|puller| puller.mask_secrets());
+            Ok(MetaHttpResponse::json(LogPullerList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_puller(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::log_puller::get(org_id, name).await {
+        Ok(mut puller) => {
+            puller.mask_secrets();
+            Ok(MetaHttpResponse::json(puller))
+        }
+        Err(_) => Ok(MetaHttpResponse::not_found("Log puller not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_puller(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::log_puller::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Log puller not found"));
+    }
+    match db::log_puller::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Log puller deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Returns the checkpoint and the health of the integration
+#[tracing::instrument]
+pub async fn get_status(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::log_puller::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Log puller not found"));
+    }
+    Ok(MetaHttpResponse::json(
+        db::log_puller::get_state(org_id, name).await,
+    ))
+}
+
+/// Keeps the integrations, the time each one is due next and the ones being
+/// pulled, a run never overlaps the previous one of the same integration
+#[derive(Default)]
+pub struct Scheduler {
+    pullers: Vec<(String, LogPuller)>,
+    reloaded_at: i64,
+    next_runs: HashMap<String, i64>,
+    running: Arc<Mutex<HashSet<String>>>,
+}
+
+impl Scheduler {
+    /// Pulls the integrations which are due, owned by this node
+    pub async fn run(&mut self) -> Result<(), anyhow::Error> {
+        let now = Utc::now().timestamp();
+        if now - self.reloaded_at >= CONFIG_RELOAD_INTERVAL {
+            self.pullers = db::log_puller::list_all().await?;
+            self.reloaded_at = now;
+        }
+
+        for (org_id, puller) in self.pullers.iter() {
+            if !puller.enabled {
+                continue;
+            }
+            let key = format!("{org_id}/{}", puller.name);
+            if self.next_runs.get(&key).is_some_and(|t| *t > now) {
+                continue;
+            }
+            self.next_runs
+                .insert(key.clone(), now + puller.interval as i64);
+            if !is_single_node(&LOCAL_NODE_ROLE)
+                && get_ingester_by_key(&format!("log_puller/{key}")).await
+                    != Some(LOCAL_NODE_UUID.clone())
+            {
+                continue;
+            }
+            if !self.running.lock().insert(key.clone()) {
+                continue; // the previous run is still pulling
+            }
+            let running = self.running.clone();
+            let org_id = org_id.clone();
+            let puller = puller.clone();
+            tokio::task::spawn(async move {
+                run_puller(&org_id, &puller).await;
+                running.lock().remove(&key);
+            });
+        }
+        // forget the deleted integrations
+        let keys = self
+            .pullers
+            .iter()
+            .map(|(org_id, puller)| format!("{org_id}/{}", puller.name))
+            .collect::<HashSet<_>>();
+        self.next_runs.retain(|key, _| keys.contains(key));
+        Ok(())
+    }
+}
+
+async fn run_puller(org_id: &str, puller: &LogPuller) {
+    let mut state = db::log_puller::get_state(org_id, &puller.name).await;
+    state.last_run_at = Utc::now().timestamp_micros();
+    let ret = match secrets::resolve(org_id, "log_pullers", puller).await {
+        Ok(resolved) => match resolved.provider {
+            LogPullerProvider::Cloudwatch => cloudwatch::pull(org_id, &resolved, &mut state).await,
+            LogPullerProvider::GcpPubsub => gcp_pubsub::pull(org_id, &resolved, &mut state).await,
+            LogPullerProvider::AzureMonitor => {
+                azure_monitor::pull(org_id, &resolved, &mut state).await
+            }
+            LogPullerProvider::Plugin => plugin::pull(org_id, &resolved, &mut state).await,
+        },
+        Err(e) => Err(e),
+    };
+    let provider = puller.provider.to_string();
+    let labels = [org_id, puller.name.as_str(), provider.as_str()];
+    let now = Utc::now().timestamp_micros();
+    match ret {
+        Ok(records) => {
+            log::debug!(
+                "[LOG_PULLER] {org_id}/{} pulled {records} records",
+                puller.name
+            );
+            state.last_success_at = now;
+            state.last_error = None;
+            state.consecutive_failures = 0;
+        }
+        Err(e) => {
+            log::error!("[LOG_PULLER] {org_id}/{} pull error: {e}", puller.name);
+            metrics::LOG_PULLER_ERRORS.with_label_values(&labels).inc();
+            state.last_error = Some(e.to_string());
+            state.consecutive_failures += 1;
+        }
+    }
+    if state.checkpoint > 0 {
+        metrics::LOG_PULLER_LAG_SECONDS
+            .with_label_values(&labels)
+            .set((now - state.checkpoint) / 1_000_000);
+    }
+    if let Err(e) = db::log_puller::set_state(org_id, &puller.name, &state).await {
+        log::error!(
+            "[LOG_PULLER] {org_id}/{} save state error: {e}",
+            puller.name
+        );
+    }
+}
+
+/// Writes the pulled records to the destination stream of the integration
+async fn write(
+    org_id: &str,
+    puller: &LogPuller,
+    state: &mut LogPullerState,
+    records: Vec<json::Value>,
+) -> Result<(), anyhow::Error> {
+    if records.is_empty() {
+        return Ok(());
+    }
+    let num = records.len() as i64;
+    let req = cluster_rpc::UsageRequest {
+        stream_name: puller.stream_name.clone(),
+        data: Some(cluster_rpc::UsageData::from(records)),
+    };
+    let resp = ingestion_service::ingest(org_id, req).await?;
+    if resp.status_code != 200 {
+        return Err(anyhow::anyhow!(
+            "write to stream {} error: {}",
+            puller.stream_name,
+            resp.message
+        ));
+    }
+    state.records += num;
+    metrics::LOG_PULLER_RECORDS
+        .with_label_values(&[org_id, puller.name.as_str(), &puller.provider.to_string()])
+        .inc_by(num as u64);
+    Ok(())
+}
+
+fn http_client() -> Result<reqwest::Client, anyhow::Error> {
+    Ok(reqwest::Client::builder()
+        .timeout(std::time::Duration::from_secs(HTTP_TIMEOUT))
+        .build()?)
+}
+
+/// Checks the status of the response and parses its JSON body
+async fn read_json(resp: reqwest::Response, api: &str) -> Result<json::Value, anyhow::Error> {
+    let status = resp.status();
+    let body = resp.bytes().await?;
+    if !status.is_success() {
+        return Err(anyhow::anyhow!(
+            "{api} error: {status} {}",
+            String::from_utf8_lossy(&body)
+        ));
+    }
+    if body.is_empty() {
+        return Ok(json::Value::Object(json::Map::new()));
+    }
+    Ok(json::from_slice(&body)?)
+}
+
+/// Parses an RFC 3339 time into microseconds
+fn parse_time(value: &json::Value) -> Option<i64> {
+    chrono::DateTime::parse_from_rfc3339(value.as_str()?)
+        .ok()
+        .map(|t| t.timestamp_micros())
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Pulls the records of a source plugin. The plugin keeps its position in an
+//! opaque cursor, which is stored as the page token of the checkpoint.
+
+use chrono::Utc;
+use config::get_config;
+
+use crate::{
+    common::meta::log_puller::{LogPuller, LogPullerState},
+    service::{db, plugins},
+};
+
+pub(super) async fn pull(
+    org_id: &str,
+    puller: &LogPuller,
+    state: &mut LogPullerState,
+) -> Result<i64, anyhow::Error> {
+    let Some(target) = puller.plugin.as_ref() else {
+        return Err(anyhow::anyhow!("missing plugin settings"));
+    };
+    let max_records = get_config().limit.log_puller_max_records;
+    let mut pulled = 0;
+    while pulled < max_records {
+        let cursor = state.next_token.clone().unwrap_or_default();
+        let (records, next_cursor) =
+            plugins::pull(org_id, &puller.name, target, &cursor, max_records - pulled).await?;
+        let num = records.len() as i64;
+        pulled += num;
+        super::write(org_id, puller, state, records).await?;
+        // the records are written, the plugin resumes after them from now on
+        state.next_token = Some(next_cursor.clone()).filter(|c| !c.is_empty());
+        state.checkpoint = Utc::now().timestamp_micros();
+        db::log_puller::set_state(org_id, &puller.name, state).await?;
+        if num == 0 || next_cursor == cursor {
+            break;
+        }
+    }
+    Ok(pulled)
+}
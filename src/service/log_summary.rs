@@ -0,0 +1,420 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Summarizes the logs of a query result window: the patterns of the
+//! messages, the new errors and the changes compared to the previous window
+//! of the same duration, plus a narrative summary by the language model.
+
+use std::collections::HashMap;
+
+use anyhow::{anyhow, Result};
+use config::{
+    get_config,
+    meta::search::{Query, Request, RequestEncoding, SearchEventType},
+    utils::json,
+};
+use once_cell::sync::Lazy;
+use regex::Regex;
+
+use crate::{
+    common::meta::log_summary::{LogPattern, LogSummaryRequest, LogSummaryResponse},
+    service::{ai, search as SearchService},
+};
+
+const MESSAGE_FIELDS: [&str; 5] = ["message", "msg", "log", "body", "_raw"];
+const LEVEL_FIELDS: [&str; 5] = [
+    "level",
+    "severity",
+    "severity_text",
+    "log_level",
+    "loglevel",
+];
+/// Messages are cut to this length before their pattern is extracted
+const MAX_MESSAGE_LEN: usize = 512;
+const TOP_PATTERNS: usize = 20;
+const TOP_NEW_ERRORS: usize = 10;
+const TOP_CHANGES: usize = 10;
+/// Patterns of each list given to the language model
+const PROMPT_PATTERNS: usize = 10;
+
+const SUMMARY_INSTRUCTIONS: &str = "You help an on-call engineer triage an incident. \
+    You get the statistics of the log messages of a time window compared to the previous window \
+    of the same duration: the most frequent patterns, the error patterns which are new, and the \
+    patterns whose count changed the most. `<*>` replaces the variable parts of the messages. \
+    Summarize what stands out in at most 5 short bullet points, most important first, and \
+    don't invent anything absent from the statistics.";
+
+static QUOTED: Lazy<Regex> = Lazy::new(|| Regex::new(r#""[^"]*"|'[^']*'"#).unwrap());
+static ERROR_LEVEL: Lazy<Regex> = Lazy::new(|| {
+    Regex::new(r"(?i)^(err|error|fatal|crit|critical|panic|emerg|emergency|alert)$").unwrap()
+});
+static ERROR_WORDS: Lazy<Regex> = Lazy::new(|| {
+    Regex::new(r"(?i)\b(error|exception|fatal|panic|failed|failure|traceback)\b").unwrap()
+});
+
+pub async fn summarize(
+    org_id: &str,
+    user_id: &str,
+    req: &LogSummaryRequest,
+) -> Result<LogSummaryResponse> {
+    if req.start_time <= 0 || req.end_time <= req.start_time {
+        return Err(anyhow!("invalid time range"));
+    }
+    let duration = req.end_time - req.start_time;
+    let (current, previous) = tokio::try_join!(
+        search(org_id, user_id, req, req.start_time, req.end_time),
+        search(
+            org_id,
+            user_id,
+            req,
+            req.start_time - duration,
+            req.start_time
+        ),
+    )?;
+
+    let message_field = if req.message_field.is_empty() {
+        detect_message_field(&current)
+            .ok_or_else(|| anyhow!("no message field found, set message_field"))?
+    } else {
+        req.message_field.clone()
+    };
+    let current = window_stats(&current, &message_field);
+    let previous = window_stats(&previous, &message_field);
+    let mut resp = compare(&current, &previous);
+    resp.message_field = message_field;
+
+    if get_config().ai.enabled {
+        match ai::chat(&summary_messages(&resp), false).await {
+            Ok(summary) => resp.summary = Some(summary.trim().to_string()),
+            Err(e) => resp.warnings.push(format!("summary failed: {e}")),
+        }
+    }
+    Ok(resp)
+}
+
+async fn search(
+    org_id: &str,
+    user_id: &str,
+    req: &LogSummaryRequest,
+    start_time: i64,
+    end_time: i64,
+) -> Result<Vec<json::Value>> {
+    let search_req = Request {
+        query: Query {
+            sql: req.sql.clone(),
+            from: 0,
+            size: get_config().ai.summary_max_records,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    let resp = SearchService::search(
+        &trace_id,
+        org_id,
+        req.stream_type,
+        Some(user_id.to_string()),
+        &search_req,
+    )
+    .await?;
+    Ok(resp.hits)
+}
+
+/// Returns the first well known message field of the hits, or else their
+/// longest string field
+fn detect_message_field(hits: &[json::Value]) -> Option<String> {
+    let sample = &hits[..std::cmp::min(hits.len(), 100)];
+    if let Some(field) = MESSAGE_FIELDS.iter().find(|f| {
+        sample
+            .iter()
+            .any(|h| h.get(**f).is_some_and(|v| v.is_string()))
+    }) {
+        return Some(field.to_string());
+    }
+    let mut lengths: HashMap<&str, usize> = HashMap::new();
+    for hit in sample {
+        for (name, val) in hit.as_object()?.iter() {
+            if let Some(s) = val.as_str() {
+                *lengths.entry(name.as_str()).or_default() += s.len();
+            }
+        }
+    }
+    lengths
+        .into_iter()
+        .max_by(|a, b| a.1.cmp(&b.1).then_with(|| b.0.cmp(a.0)))
+        .map(|(name, _)| name.to_string())
+}
+
+/// Replaces the quoted strings and the words holding digits, like numbers,
+/// ids, addresses and durations, by `<*>`
+fn pattern_of(message: &str) -> String {
+    let message = match message.char_indices().nth(MAX_MESSAGE_LEN) {
+        Some((pos, _)) => &message[..pos],
+        None => message,
+    };
+    let message = QUOTED.replace_all(message, "<*>");
+    let mut words: Vec<String> = Vec::new();
+    for word in message.split_whitespace() {
+        let word = if !word.chars().any(|c| c.is_ascii_digit()) {
+            word.to_string()
+        } else {
+            match word.split_once('=') {
+                Some((key, _)) if !key.chars().any(|c| c.is_ascii_digit()) => format!("{key}=<*>"),
+                _ => "<*>".to_string(),
+            }
+        };
+        if word == "<*>" && words.last().is_some_and(|w| w == "<*>") {
+            continue;
+        }
+        words.push(word);
+    }
+    words.join(" ")
+}
+
+fn is_error(hit: &json::Value, message: &str) -> bool {
+    for field in LEVEL_FIELDS {
+        if let Some(level) = hit.get(field).and_then(|v| v.as_str()) {
+            return ERROR_LEVEL.is_match(level.trim());
+        }
+    }
+    // OpenTelemetry severity numbers, 17 to 24 are the errors and the fatals
+    if let Some(n) = hit.get("severity_number").and_then(|v| v.as_i64()) {
+        return n >= 17;
+    }
+    ERROR_WORDS.is_match(message)
+}
+
+#[derive(Default)]
+struct PatternStats {
+    count: usize,
+    error: bool,
+    example: String,
+}
+
+#[derive(Default)]
+struct WindowStats {
+    records: usize,
+    errors: usize,
+    patterns: HashMap<String, PatternStats>,
+}
+
+fn window_stats(hits: &[json::Value], message_field: &str) -> WindowStats {
+    let mut stats = WindowStats {
+        records: hits.len(),
+        ..Default::default()
+    };
+    for hit in hits {
+        let message = match hit.get(message_field) {
+            Some(json::Value::String(s)) => s.to_string(),
+            Some(json::Value::Null) | None => continue,
+            Some(v) => v.to_string(),
+        };
+        let error = is_error(hit, &message);
+        if error {
+            stats.errors += 1;
+        }
+        let pattern = stats.patterns.entry(pattern_of(&message)).or_default();
+        if pattern.count == 0 {
+            pattern.example = message;
+        }
+        pattern.count += 1;
+        pattern.error |= error;
+    }
+    stats
+}
+
+fn compare(current: &WindowStats, previous: &WindowStats) -> LogSummaryResponse {
+    let mut all = current
+        .patterns
+        .iter()
+        .map(|(pattern, s)| LogPattern {
+            pattern: pattern.to_string(),
+            count: s.count,
+            previous_count: previous.patterns.get(pattern).map_or(0, |p| p.count),
+            error: s.error,
+            example: s.example.clone(),
+        })
+        .collect::<Vec<_>>();
+    // the patterns which disappeared count as changes
+    all.extend(
+        previous
+            .patterns
+            .iter()
+            .filter(|(pattern, _)| !current.patterns.contains_key(*pattern))
+            .map(|(pattern, s)| LogPattern {
+                pattern: pattern.to_string(),
+                count: 0,
+                previous_count: s.count,
+                error: s.error,
+                example: s.example.clone(),
+            }),
+    );
+
+    let top =
+        |filter: &dyn Fn(&LogPattern) -> bool, key: &dyn Fn(&LogPattern) -> usize, limit: usize| {
+            let mut list = all
+                .iter()
+                .filter(|p| filter(p))
+                .cloned()
+                .collect::<Vec<_>>();
+            list.sort_by(|a, b| key(b).cmp(&key(a)).then_with(|| a.pattern.cmp(&b.pattern)));
+            list.truncate(limit);
+            list
+        };
+    LogSummaryResponse {
+        records: current.records,
+        previous_records: previous.records,
+        errors: current.errors,
+        previous_errors: previous.errors,
+        patterns: top(&|p| p.count > 0, &|p| p.count, TOP_PATTERNS),
+        new_errors: top(
+            &|p| p.error && p.count > 0 && p.previous_count == 0,
+            &|p| p.count,
+            TOP_NEW_ERRORS,
+        ),
+        changes: top(
+            &|p| p.count != p.previous_count,
+            &|p| p.count.abs_diff(p.previous_count),
+            TOP_CHANGES,
+        ),
+        ..Default::default()
+    }
+}
+
+fn summary_messages(resp: &LogSummaryResponse) -> Vec<json::Value> {
+    let patterns = |list: &[LogPattern]| {
+        list.iter()
+            .take(PROMPT_PATTERNS)
+            .map(|p| {
+                json::json!({
+                    "pattern": p.pattern,
+                    "count": p.count,
+                    "previous_count": p.previous_count,
+                    "error": p.error,
+                })
+            })
+            .collect::<Vec<_>>()
+    };
+    let stats = json::json!({
+        "records": resp.records,
+        "previous_records": resp.previous_records,
+        "errors": resp.errors,
+        "previous_errors": resp.previous_errors,
+        "top_patterns": patterns(&resp.patterns),
+        "new_error_patterns": patterns(&resp.new_errors),
+        "changed_patterns": patterns(&resp.changes),
+    });
+    vec![
+        json::json!({ "role": "system", "content": SUMMARY_INSTRUCTIONS }),
+        json::json!({ "role": "user", "content": stats.to_string() }),
+    ]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pattern_of() {
+        assert_eq!(
+            pattern_of("GET /api/users/42 took 35ms status=500"),
+            "GET <*> took <*> status=<*>"
+        );
+        assert_eq!(
+            pattern_of("connection from 10.0.0.1:5432 refused, retry 3 of 5"),
+            "connection from <*> refused, retry <*> of <*>"
+        );
+        assert_eq!(
+            pattern_of("user \"alice\" not found in 'db1'"),
+            "user <*> not found in <*>"
+        );
+        assert_eq!(
+            pattern_of("request 1b4e28ba-2fa1-11d2-883f-0016d3cca427 0x7f 12"),
+            "request <*>"
+        );
+        assert_eq!(
+            pattern_of("é".repeat(MAX_MESSAGE_LEN + 1).as_str())
+                .chars()
+                .count(),
+            MAX_MESSAGE_LEN
+        );
+    }
+
+    #[test]
+    fn test_is_error() {
+        assert!(is_error(&json::json!({"level": "ERROR"}), "all good"));
+        assert!(!is_error(&json::json!({"level": "info"}), "no error here"));
+        assert!(is_error(&json::json!({"severity_number": 17}), ""));
+        assert!(!is_error(&json::json!({"severity_number": 9}), "failed"));
+        assert!(is_error(&json::json!({}), "request failed: timeout"));
+        assert!(!is_error(&json::json!({}), "errors_total metric exported"));
+    }
+
+    #[test]
+    fn test_detect_message_field() {
+        let hits = vec![json::json!({"_timestamp": 1, "msg": "started", "host": "a"})];
+        assert_eq!(detect_message_field(&hits).as_deref(), Some("msg"));
+        let hits = vec![json::json!({"host": "web-1", "text": "a much longer line"})];
+        assert_eq!(detect_message_field(&hits).as_deref(), Some("text"));
+        assert_eq!(detect_message_field(&[]), None);
+    }
+
+    #[test]
+    fn test_compare() {
+        let current = vec![
+            json::json!({"message": "db timeout after 30s", "level": "error"}),
+            json::json!({"message": "db timeout after 31s", "level": "error"}),
+            json::json!({"message": "served /a in 3ms"}),
+        ];
+        let previous = vec![
+            json::json!({"message": "served /a in 5ms"}),
+            json::json!({"message": "served /b in 5ms"}),
+            json::json!({"message": "cache warmed"}),
+        ];
+        let resp = compare(
+            &window_stats(&current, "message"),
+            &window_stats(&previous, "message"),
+        );
+        assert_eq!((resp.records, resp.errors), (3, 2));
+        assert_eq!((resp.previous_records, resp.previous_errors), (3, 0));
+        assert_eq!(resp.patterns[0].pattern, "db timeout after <*>");
+        assert_eq!(resp.patterns[0].count, 2);
+        assert_eq!(resp.patterns[0].example, "db timeout after 30s");
+        assert_eq!(resp.new_errors.len(), 1);
+        assert_eq!(resp.new_errors[0].pattern, "db timeout after <*>");
+        let changes = resp
+            .changes
+            .iter()
+            .map(|p| (p.pattern.as_str(), p.count, p.previous_count))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            changes,
+            vec![
+                ("db timeout after <*>", 2, 0),
+                ("cache warmed", 0, 1),
+                ("served /b in <*>", 0, 1),
+            ]
+        );
+    }
+}
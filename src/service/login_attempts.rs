@@ -0,0 +1,84 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Throttles the native logins: after ZO_LOGIN_MAX_FAILURES failed attempts in
+//! a row, the user can't log in for ZO_LOGIN_LOCKOUT seconds. The attempts are
+//! counted by every node in memory.
+
+use config::{get_config, RwHashMap};
+use once_cell::sync::Lazy;
+
+/// Above it, the users not locked out are forgotten
+const MAX_TRACKED: usize = 100_000;
+
+#[derive(Clone, Copy, Debug, Default)]
+struct Failures {
+    count: u32,
+    /// Unix timestamp in seconds
+    locked_until: i64,
+}
+
+static FAILURES: Lazy<RwHashMap<String, Failures>> = Lazy::new(Default::default);
+
+fn key(user: &str) -> String {
+    user.trim().to_lowercase()
+}
+
+/// Seconds the user has to wait before the next attempt, `None` when allowed
+pub fn locked_for(user: &str, now: i64) -> Option<i64> {
+    let failures = FAILURES.get(&key(user))?;
+    (failures.locked_until > now).then(|| failures.locked_until - now)
+}
+
+pub fn record_failure(user: &str, now: i64) {
+    let cfg = get_config();
+    if cfg.auth.login_max_failures == 0 {
+        return;
+    }
+    if FAILURES.len() >= MAX_TRACKED {
+        FAILURES.retain(|_, f| f.locked_until > now);
+    }
+    let mut failures = FAILURES.entry(key(user)).or_default();
+    failures.count += 1;
+    if failures.count >= cfg.auth.login_max_failures {
+        failures.count = 0;
+        failures.locked_until = now + cfg.auth.login_lockout;
+    }
+}
+
+pub fn record_success(user: &str) {
+    FAILURES.remove(&key(user));
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lockout() {
+        let cfg = get_config();
+        let user = "Lockout@example.com";
+        let now = 1000;
+        for _ in 1..cfg.auth.login_max_failures {
+            record_failure(user, now);
+        }
+        assert_eq!(locked_for(user, now), None);
+        record_failure("lockout@example.com ", now);
+        assert_eq!(locked_for(user, now), Some(cfg.auth.login_lockout));
+        assert_eq!(locked_for(user, now + cfg.auth.login_lockout), None);
+        record_success(user);
+        assert_eq!(locked_for(user, now), None);
+    }
+}
@@ -25,7 +25,7 @@ use chrono::{Duration, Utc};
 use config::{
     cluster, get_config,
     meta::{
-        stream::{PartitioningDetails, Routing, StreamType},
+        stream::{PartitioningDetails, Routing, SamplingRule, StreamType},
         usage::UsageType,
     },
     metrics,
@@ -42,6 +42,7 @@ use crate::{
         ingestion::{
             BulkResponse, BulkResponseError, BulkResponseItem, BulkStreamData, StreamSchemaChk,
         },
+        schema_contract::SchemaContract,
         stream::StreamParams,
     },
     service::{
@@ -56,11 +57,14 @@ use crate::{
 pub const TRANSFORM_FAILED: &str = "document_failed_transform";
 pub const TS_PARSE_FAILED: &str = "timestamp_parsing_failed";
 pub const SCHEMA_CONFORMANCE_FAILED: &str = "schema_conformance_failed";
+pub const SCHEMA_CONTRACT_FAILED: &str = "schema_contract_violation";
+pub const MEMORY_LIMIT_EXCEEDED: &str = "stream_memory_limit_exceeded";
 
 pub async fn ingest(
     org_id: &str,
     body: web::Bytes,
     user_email: &str,
+    skip_sampling: bool,
 ) -> Result<BulkResponse, anyhow::Error> {
     let start = std::time::Instant::now();
     let started_at = Utc::now().timestamp_micros();
@@ -78,8 +82,10 @@ pub async fn ingest(
         ));
     }
 
+    crate::service::residency::check_local(org_id).map_err(|e| anyhow::anyhow!(e))?;
+
     // check memtable
-    ingester::check_memtable_size().map_err(|e| Error::msg(e.to_string()))?;
+    ingester::check_memtable_size(org_id).map_err(|e| Error::msg(e.to_string()))?;
 
     // let mut errors = false;
     let mut bulk_res = BulkResponse {
@@ -102,6 +108,7 @@ pub async fn ingest(
     let mut stream_partition_keys_map: HashMap<String, (StreamSchemaChk, PartitioningDetails)> =
         HashMap::new();
     let mut stream_alerts_map: HashMap<String, Vec<Alert>> = HashMap::new();
+    let mut stream_flatten_levels: HashMap<String, u32> = HashMap::new();
     let distinct_values = Vec::with_capacity(16);
 
     let mut action = String::from("");
@@ -115,6 +122,10 @@ pub async fn ingest(
 
     let mut user_defined_schema_map: HashMap<String, HashSet<String>> = HashMap::new();
 
+    let mut stream_contract_map: HashMap<String, Option<SchemaContract>> = HashMap::new();
+
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+
     let mut next_line_is_data = false;
     let reader = BufReader::new(body.as_ref());
     for line in reader.lines() {
@@ -148,6 +159,25 @@ pub async fn ingest(
                 continue; // skip
             }
 
+            // reject the documents of the streams over their memory limit
+            if let Err(e) = ingester::check_stream_memtable_size(
+                org_id,
+                &StreamType::Logs.to_string(),
+                &stream_name,
+            ) {
+                bulk_res.errors = true;
+                add_record_status(
+                    stream_name.clone(),
+                    doc_id.clone(),
+                    action.clone(),
+                    None,
+                    &mut bulk_res,
+                    Some(MEMORY_LIMIT_EXCEEDED.to_string()),
+                    Some(e.to_string()),
+                );
+                continue; // skip
+            }
+
             // Start get routing keys
             crate::service::ingestion::get_stream_routing(
                 StreamParams {
@@ -182,6 +212,14 @@ pub async fn ingest(
             )
             .await;
 
+            if !skip_sampling {
+                crate::service::ingestion::get_stream_sampling_rules(
+                    &streams,
+                    &mut stream_sampling_map,
+                )
+                .await;
+            }
+
             next_line_is_data = true;
 
             // Start Register functions for stream
@@ -217,6 +255,15 @@ pub async fn ingest(
                     .await;
                     e.insert((stream_schema, partition_det));
                 }
+                if !stream_flatten_levels.contains_key(&local_stream_name) {
+                    let flatten_level = crate::service::ingestion::get_stream_flatten_level(
+                        org_id,
+                        &StreamType::Logs,
+                        &local_stream_name,
+                    )
+                    .await;
+                    stream_flatten_levels.insert(local_stream_name, flatten_level);
+                }
             }
 
             stream_data_map
@@ -227,8 +274,13 @@ pub async fn ingest(
         } else {
             next_line_is_data = false;
 
-            // JSON Flattening
-            let mut value = flatten::flatten_with_level(value, cfg.limit.ingest_flatten_level)?;
+            // JSON Flattening, at the level of the stream of the action line, the
+            // routes are evaluated on the flattened record
+            let flatten_level = stream_flatten_levels
+                .get(&stream_name)
+                .copied()
+                .unwrap_or(cfg.limit.ingest_flatten_level);
+            let mut value = flatten::flatten_with_level(value, flatten_level)?;
 
             if let Some(routing) = stream_routing_map.get(&stream_name) {
                 if !routing.is_empty() {
@@ -298,6 +350,54 @@ pub async fn ingest(
                 _ => unreachable!(),
             };
 
+            if let Some(rules) = stream_sampling_map.get(&stream_name) {
+                if let Some(rule) =
+                    crate::service::ingestion::sample_record(rules, &local_val).await
+                {
+                    metrics::INGEST_SAMPLED_RECORDS
+                        .with_label_values(&[
+                            org_id,
+                            &stream_name,
+                            StreamType::Logs.to_string().as_str(),
+                            rule,
+                        ])
+                        .inc();
+                    continue;
+                }
+            }
+
+            let contract = stream_contract_map
+                .entry(stream_name.clone())
+                .or_insert_with(|| {
+                    crate::service::schema_contracts::get_contract(
+                        org_id,
+                        StreamType::Logs,
+                        &stream_name,
+                    )
+                });
+            if let Some(contract) = contract.as_ref() {
+                if let Some(e) = crate::service::schema_contracts::check_record(
+                    org_id,
+                    StreamType::Logs,
+                    &stream_name,
+                    contract,
+                    user_email,
+                    &local_val,
+                ) {
+                    bulk_res.errors = true;
+                    add_record_status(
+                        stream_name.clone(),
+                        doc_id.clone(),
+                        action.clone(),
+                        Some(json::Value::Object(local_val)),
+                        &mut bulk_res,
+                        Some(SCHEMA_CONTRACT_FAILED.to_string()),
+                        Some(e),
+                    );
+                    continue;
+                }
+            }
+
             if let Some(fields) = user_defined_schema_map.get(&stream_name) {
                 local_val = crate::service::logs::refactor_map(local_val, fields);
             }
@@ -500,6 +600,23 @@ async fn process_record(
     let mut trigger: TriggerAlertData = Vec::new();
     let cfg = get_config();
     for schema_records in stream_data.data.values_mut() {
+        // move the fields beyond the stream column limit to _overflow, the new fields of the
+        // records count together as the schema evolves once for all of them
+        let mut batch_fields = Default::default();
+        for record in schema_records.records.iter_mut() {
+            if let Some(rec) = Arc::make_mut(record).as_object_mut() {
+                crate::service::schema::apply_column_limit(
+                    &stream.org_id,
+                    &stream.stream_name,
+                    StreamType::Logs,
+                    stream_schema_map,
+                    &mut batch_fields,
+                    rec,
+                )
+                .await?;
+            }
+        }
+
         // check schema
         let mut timestamp = 0;
         let mut records: Vec<&serde_json::Map<std::string::String, serde_json::Value>> =
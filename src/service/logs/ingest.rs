@@ -23,7 +23,10 @@ use anyhow::Result;
 use chrono::{Duration, Utc};
 use config::{
     get_config,
-    meta::{stream::StreamType, usage::UsageType},
+    meta::{
+        stream::{SamplingRule, StreamType},
+        usage::UsageType,
+    },
     metrics,
     utils::{flatten, json, time::parse_timestamp_micro_from_value},
     DISTINCT_FIELDS,
@@ -44,7 +47,9 @@ use crate::{
     },
     service::{
         get_formatted_stream_name,
-        ingestion::{check_ingestion_allowed, evaluate_trigger, write_file, TriggerAlertData},
+        ingestion::{
+            check_ingestion_allowed, evaluate_trigger, limits, write_file, TriggerAlertData,
+        },
         logs::StreamMeta,
         metadata::{distinct_values::DvItem, write, MetadataItem, MetadataType},
         schema::get_upto_discard_error,
@@ -57,6 +62,7 @@ pub async fn ingest(
     in_stream_name: &str,
     in_req: IngestionRequest<'_>,
     user_email: &str,
+    skip_sampling: bool,
 ) -> Result<IngestionResponse> {
     let start = std::time::Instant::now();
     let started_at = Utc::now().timestamp_micros();
@@ -67,7 +73,7 @@ pub async fn ingest(
     check_ingestion_allowed(org_id, Some(stream_name))?;
 
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(IngestionResponse {
             code: http::StatusCode::SERVICE_UNAVAILABLE.into(),
             status: vec![],
@@ -103,12 +109,26 @@ pub async fn ingest(
     // Start get user defined schema
     let mut user_defined_schema_map: HashMap<String, HashSet<String>> = HashMap::new();
     crate::service::ingestion::get_user_defined_schema(
-        &[stream_param],
+        &[stream_param.clone()],
         &mut user_defined_schema_map,
     )
     .await;
     // End get user defined schema
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    if !skip_sampling {
+        crate::service::ingestion::get_stream_sampling_rules(
+            &[stream_param],
+            &mut stream_sampling_map,
+        )
+        .await;
+    }
+    // End get sampling rules
+
+    let schema_contract =
+        crate::service::schema_contracts::get_contract(org_id, StreamType::Logs, stream_name);
+
     let mut stream_status = StreamStatus::new(stream_name);
     let mut distinct_values = Vec::with_capacity(16);
     let mut trigger: Option<TriggerAlertData> = None;
@@ -121,6 +141,9 @@ pub async fn ingest(
     .await;
     let partition_keys = partition_det.partition_keys;
     let partition_time_level = partition_det.partition_time_level;
+    let flatten_level =
+        crate::service::ingestion::get_stream_flatten_level(org_id, &StreamType::Logs, stream_name)
+            .await;
 
     let mut write_buf: HashMap<String, SchemaRecords> = HashMap::new();
 
@@ -141,6 +164,19 @@ pub async fn ingest(
         ),
     };
 
+    let records = match &data {
+        IngestionData::JSON(req) => req.len(),
+        IngestionData::Multi(req) => limits::count_lines(req),
+        _ => 0,
+    };
+    if let Err(e) = limits::check_records(records) {
+        return Ok(IngestionResponse {
+            code: http::StatusCode::PAYLOAD_TOO_LARGE.into(),
+            status: vec![],
+            error: Some(e),
+        });
+    }
+
     for ret in data.iter() {
         let item = match ret {
             Ok(item) => item,
@@ -152,6 +188,7 @@ pub async fn ingest(
 
         let mut res = match apply_functions(
             item,
+            flatten_level,
             &local_trans,
             &stream_vrl_map,
             org_id,
@@ -171,6 +208,35 @@ pub async fn ingest(
             _ => unreachable!(),
         };
 
+        if let Some(rules) = stream_sampling_map.get(stream_name) {
+            if let Some(rule) = crate::service::ingestion::sample_record(rules, &local_val).await {
+                metrics::INGEST_SAMPLED_RECORDS
+                    .with_label_values(&[
+                        org_id,
+                        stream_name,
+                        StreamType::Logs.to_string().as_str(),
+                        rule,
+                    ])
+                    .inc();
+                continue;
+            }
+        }
+
+        if let Some(contract) = schema_contract.as_ref() {
+            if let Some(e) = crate::service::schema_contracts::check_record(
+                org_id,
+                StreamType::Logs,
+                stream_name,
+                contract,
+                user_email,
+                &local_val,
+            ) {
+                stream_status.status.failed += 1;
+                stream_status.status.error = e;
+                continue;
+            }
+        }
+
         if let Some(fields) = user_defined_schema_map.get(stream_name) {
             local_val = crate::service::logs::refactor_map(local_val, fields);
         }
@@ -296,14 +362,14 @@ pub async fn ingest(
 
 pub fn apply_functions<'a>(
     item: json::Value,
+    flatten_level: u32,
     local_trans: &[StreamTransform],
     stream_vrl_map: &'a HashMap<String, VRLResultResolver>,
     org_id: &'a str,
     stream_name: &'a str,
     runtime: &mut Runtime,
 ) -> Result<json::Value> {
-    let cfg = get_config();
-    let mut value = flatten::flatten_with_level(item, cfg.limit.ingest_flatten_level)?;
+    let mut value = flatten::flatten_with_level(item, flatten_level)?;
 
     if !local_trans.is_empty() {
         value = crate::service::ingestion::apply_stream_functions(
@@ -23,7 +23,10 @@ use anyhow::Result;
 use arrow_schema::{DataType, Field, Schema};
 use config::{
     get_config,
-    meta::stream::{PartitionTimeLevel, StreamPartition, StreamType},
+    meta::stream::{
+        DedupSettings, PartitionTimeLevel, StreamPartition, StreamType, REPEAT_COUNT_FIELD,
+    },
+    metrics,
     utils::{
         json::{estimate_json_bytes, get_string_value, pickup_string_value, Map, Number, Value},
         schema_ext::SchemaExt,
@@ -34,7 +37,10 @@ use infra::schema::{unwrap_partition_time_level, SchemaCache};
 use super::ingestion::TriggerAlertData;
 use crate::{
     common::meta::{alerts::Alert, ingestion::RecordStatus, stream::SchemaRecords},
-    service::{ingestion::get_wal_time_key, schema::check_for_schema},
+    service::{
+        ingestion::get_wal_time_key,
+        schema::{apply_column_limit, check_for_schema},
+    },
 };
 
 pub mod bulk;
@@ -293,6 +299,24 @@ async fn add_valid_record(
         .as_i64()
         .unwrap();
 
+    let dedup = get_dedup_settings(stream_meta).await;
+    if dedup.is_some() {
+        record_val
+            .entry(REPEAT_COUNT_FIELD)
+            .or_insert_with(|| Value::from(1));
+    }
+
+    // move the fields beyond the stream column limit to _overflow
+    apply_column_limit(
+        &stream_meta.org_id,
+        &stream_meta.stream_name,
+        StreamType::Logs,
+        stream_schema_map,
+        &mut Default::default(),
+        &mut record_val,
+    )
+    .await?;
+
     // check schema
     let schema_evolution = check_for_schema(
         &stream_meta.org_id,
@@ -353,6 +377,13 @@ async fn add_valid_record(
         return Ok(None);
     }
 
+    if let (Some(dedup), Some(hour_buf)) = (dedup.as_ref(), write_buf.get_mut(&hour_key)) {
+        if collapse_repeat(stream_meta, dedup, hour_buf, &record_val) {
+            status.successful += 1;
+            return Ok(None);
+        }
+    }
+
     if need_trigger && !stream_meta.stream_alerts_map.is_empty() {
         // Start check for alert trigger
         let key = format!(
@@ -405,7 +436,7 @@ fn set_parsing_error(parse_error: &mut String, field: &Field) {
 async fn add_record(
     stream_meta: &StreamMeta<'_>,
     write_buf: &mut HashMap<String, SchemaRecords>,
-    record_val: Map<String, Value>,
+    mut record_val: Map<String, Value>,
 ) -> Result<()> {
     let cfg = get_config();
     let timestamp: i64 = record_val
@@ -422,6 +453,18 @@ async fn add_record(
         None,
     );
 
+    let dedup = get_dedup_settings(stream_meta).await;
+    if dedup.is_some() {
+        record_val
+            .entry(REPEAT_COUNT_FIELD)
+            .or_insert_with(|| Value::from(1));
+    }
+    if let (Some(dedup), Some(hour_buf)) = (dedup.as_ref(), write_buf.get_mut(&hour_key)) {
+        if collapse_repeat(stream_meta, dedup, hour_buf, &record_val) {
+            return Ok(());
+        }
+    }
+
     let hour_buf = write_buf.entry(hour_key).or_insert_with(|| {
         let schema = Arc::new(Schema::empty());
         let schema_key = schema.hash_key();
@@ -437,6 +480,50 @@ async fn add_record(
     Ok(())
 }
 
+async fn get_dedup_settings(stream_meta: &StreamMeta<'_>) -> Option<DedupSettings> {
+    infra::schema::get_settings(
+        &stream_meta.org_id,
+        &stream_meta.stream_name,
+        StreamType::Logs,
+    )
+    .await
+    .and_then(|settings| settings.dedup)
+}
+
+/// Counts the record in the last record of the buffer when it repeats it,
+/// returns false when the record should be added instead
+fn collapse_repeat(
+    stream_meta: &StreamMeta<'_>,
+    dedup: &DedupSettings,
+    hour_buf: &mut SchemaRecords,
+    record_val: &Map<String, Value>,
+) -> bool {
+    let Some(last) = hour_buf.records.last_mut() else {
+        return false;
+    };
+    match last.as_object() {
+        Some(first) if dedup.is_repeat(first, record_val) => {}
+        _ => return false,
+    }
+    let repeats = |r: &Map<String, Value>| {
+        r.get(REPEAT_COUNT_FIELD)
+            .and_then(|v| v.as_i64())
+            .unwrap_or(1)
+    };
+    if let Value::Object(first) = Arc::make_mut(last) {
+        let count = repeats(first) + repeats(record_val);
+        first.insert(REPEAT_COUNT_FIELD.to_string(), count.into());
+    }
+    metrics::INGEST_DEDUP_RECORDS
+        .with_label_values(&[
+            &stream_meta.org_id,
+            &stream_meta.stream_name,
+            StreamType::Logs.to_string().as_str(),
+        ])
+        .inc();
+    true
+}
+
 struct StreamMeta<'a> {
     org_id: String,
     stream_name: String,
@@ -22,7 +22,10 @@ use actix_web::{http, web};
 use anyhow::Result;
 use chrono::{Duration, Utc};
 use config::{
-    meta::{stream::StreamType, usage::UsageType},
+    meta::{
+        stream::{SamplingRule, StreamType},
+        usage::UsageType,
+    },
     metrics,
     utils::{flatten, json, time::parse_timestamp_micro_from_value},
     DISTINCT_FIELDS,
@@ -102,6 +105,9 @@ async fn ingest_inner(
     .await;
     let partition_keys = partition_det.partition_keys;
     let partition_time_level = partition_det.partition_time_level;
+    let flatten_level =
+        crate::service::ingestion::get_stream_flatten_level(org_id, &StreamType::Logs, stream_name)
+            .await;
 
     // Start get stream alerts
     crate::service::ingestion::get_stream_alerts(
@@ -115,6 +121,18 @@ async fn ingest_inner(
     .await;
     // End get stream alert
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    crate::service::ingestion::get_stream_sampling_rules(
+        &[StreamParams::new(org_id, stream_name, StreamType::Logs)],
+        &mut stream_sampling_map,
+    )
+    .await;
+    // End get sampling rules
+
+    let schema_contract =
+        crate::service::schema_contracts::get_contract(org_id, StreamType::Logs, stream_name);
+
     let mut buf: HashMap<String, SchemaRecords> = HashMap::new();
     let reader = BufReader::new(body.as_ref());
     for line in reader.lines() {
@@ -130,7 +148,7 @@ async fn ingest_inner(
         }
 
         // JSON Flattening
-        value = flatten::flatten_with_level(value, cfg.limit.ingest_flatten_level)?;
+        value = flatten::flatten_with_level(value, flatten_level)?;
         // Start row based transform
 
         if !local_trans.is_empty() {
@@ -156,6 +174,35 @@ async fn ingest_inner(
             _ => unreachable!(),
         };
 
+        if let Some(rules) = stream_sampling_map.get(stream_name) {
+            if let Some(rule) = crate::service::ingestion::sample_record(rules, &local_val).await {
+                metrics::INGEST_SAMPLED_RECORDS
+                    .with_label_values(&[
+                        org_id,
+                        stream_name,
+                        StreamType::Logs.to_string().as_str(),
+                        rule,
+                    ])
+                    .inc();
+                continue;
+            }
+        }
+
+        if let Some(contract) = schema_contract.as_ref() {
+            if let Some(e) = crate::service::schema_contracts::check_record(
+                org_id,
+                StreamType::Logs,
+                stream_name,
+                contract,
+                "",
+                &local_val,
+            ) {
+                stream_status.status.failed += 1;
+                stream_status.status.error = e;
+                continue;
+            }
+        }
+
         // handle timestamp
         let timestamp = match local_val.get(&cfg.common.column_timestamp) {
             Some(v) => match parse_timestamp_micro_from_value(v) {
@@ -21,7 +21,10 @@ use bytes::BytesMut;
 use chrono::{Duration, Utc};
 use config::{
     cluster,
-    meta::{stream::StreamType, usage::UsageType},
+    meta::{
+        stream::{SamplingRule, StreamType},
+        usage::UsageType,
+    },
     metrics,
     utils::{flatten, json, time::parse_timestamp_micro_from_value},
     DISTINCT_FIELDS,
@@ -29,7 +32,7 @@ use config::{
 use infra::schema::SchemaCache;
 use opentelemetry::trace::{SpanId, TraceId};
 use opentelemetry_proto::tonic::collector::logs::v1::{
-    ExportLogsServiceRequest, ExportLogsServiceResponse,
+    ExportLogsPartialSuccess, ExportLogsServiceRequest, ExportLogsServiceResponse,
 };
 use prost::Message;
 
@@ -54,10 +57,13 @@ use crate::{
     },
 };
 
+/// Ingests the records of an internal usage request, `historical` requests
+/// keep records older than `ZO_INGEST_ALLOWED_UPTO`
 pub async fn usage_ingest(
     org_id: &str,
     in_stream_name: &str,
     body: web::Bytes,
+    historical: bool,
 ) -> Result<IngestionResponse> {
     let start = std::time::Instant::now();
     let mut stream_schema_map: HashMap<String, SchemaCache> = HashMap::new();
@@ -80,14 +86,20 @@ pub async fn usage_ingest(
         ));
     }
 
+    crate::service::residency::check_local(org_id).map_err(|e| anyhow::anyhow!(e))?;
+
     // check if we are allowed to ingest
     if db::compact::retention::is_deleting_stream(org_id, StreamType::Logs, stream_name, None) {
         return Err(anyhow::anyhow!("stream [{stream_name}] is being deleted"));
     }
 
     let cfg = config::get_config();
-    let min_ts = (Utc::now() - Duration::try_hours(cfg.limit.ingest_allowed_upto).unwrap())
-        .timestamp_micros();
+    let min_ts = if historical {
+        0
+    } else {
+        (Utc::now() - Duration::try_hours(cfg.limit.ingest_allowed_upto).unwrap())
+            .timestamp_micros()
+    };
 
     let mut stream_alerts_map: HashMap<String, Vec<Alert>> = HashMap::new();
     let mut stream_status = StreamStatus::new(stream_name);
@@ -250,6 +262,7 @@ pub async fn handle_grpc_request(
     is_grpc: bool,
     in_stream_name: Option<&str>,
     user_email: &str,
+    skip_sampling: bool,
 ) -> Result<HttpResponse> {
     let started_at = Utc::now().timestamp_micros();
 
@@ -271,8 +284,15 @@ pub async fn handle_grpc_request(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -329,12 +349,26 @@ pub async fn handle_grpc_request(
     // Start get user defined schema
     let mut user_defined_schema_map: HashMap<String, HashSet<String>> = HashMap::new();
     crate::service::ingestion::get_user_defined_schema(
-        &[stream_param],
+        &[stream_param.clone()],
         &mut user_defined_schema_map,
     )
     .await;
     // End get user defined schema
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    if !skip_sampling {
+        crate::service::ingestion::get_stream_sampling_rules(
+            &[stream_param],
+            &mut stream_sampling_map,
+        )
+        .await;
+    }
+    // End get sampling rules
+
+    let schema_contract =
+        crate::service::schema_contracts::get_contract(org_id, StreamType::Logs, stream_name);
+
     // Start Register Transforms for stream
     let (local_trans, stream_vrl_map) = crate::service::ingestion::register_stream_functions(
         org_id,
@@ -349,6 +383,21 @@ pub async fn handle_grpc_request(
 
     let cfg = config::get_config();
     for resource_log in &request.resource_logs {
+        if let Some(res) = &resource_log.resource {
+            let attrs = res
+                .attributes
+                .iter()
+                .map(|item| (item.key.as_str(), get_val(&item.value.as_ref())))
+                .collect::<Vec<_>>();
+            let resource_entities =
+                crate::service::entities::from_attributes(attrs.iter().map(|(k, v)| (*k, v)));
+            crate::service::entities::observe(
+                org_id,
+                StreamType::Logs,
+                stream_name,
+                &resource_entities,
+            );
+        }
         for instrumentation_logs in &resource_log.scope_logs {
             for log_record in &instrumentation_logs.log_records {
                 let mut rec = json::json!({});
@@ -454,6 +503,37 @@ pub async fn handle_grpc_request(
                     _ => unreachable!(),
                 };
 
+                if let Some(rules) = stream_sampling_map.get(stream_name) {
+                    if let Some(rule) =
+                        crate::service::ingestion::sample_record(rules, &local_val).await
+                    {
+                        metrics::INGEST_SAMPLED_RECORDS
+                            .with_label_values(&[
+                                org_id,
+                                stream_name,
+                                StreamType::Logs.to_string().as_str(),
+                                rule,
+                            ])
+                            .inc();
+                        continue;
+                    }
+                }
+
+                if let Some(contract) = schema_contract.as_ref() {
+                    if let Some(e) = crate::service::schema_contracts::check_record(
+                        org_id,
+                        StreamType::Logs,
+                        stream_name,
+                        contract,
+                        user_email,
+                        &local_val,
+                    ) {
+                        stream_status.status.failed += 1;
+                        stream_status.status.error = e;
+                        continue;
+                    }
+                }
+
                 if let Some(fields) = user_defined_schema_map.get(stream_name) {
                     local_val = crate::service::logs::refactor_map(local_val, fields);
                 }
@@ -563,7 +643,10 @@ pub async fn handle_grpc_request(
     )
     .await;
     let res = ExportLogsServiceResponse {
-        partial_success: None,
+        partial_success: (stream_status.status.failed > 0).then(|| ExportLogsPartialSuccess {
+            rejected_log_records: stream_status.status.failed as i64,
+            error_message: stream_status.status.error,
+        }),
     };
     let mut out = BytesMut::with_capacity(res.encoded_len());
     res.encode(&mut out).expect("Out of memory");
@@ -577,7 +660,6 @@ pub async fn handle_grpc_request(
 #[cfg(test)]
 mod tests {
     use opentelemetry_proto::tonic::{
-        collector::logs::v1::ExportLogsServiceRequest,
         common::v1::{
             any_value::Value::{IntValue, StringValue},
             AnyValue, InstrumentationScope, KeyValue,
@@ -585,7 +667,13 @@ mod tests {
         logs::v1::{LogRecord, ResourceLogs, ScopeLogs},
     };
 
-    use crate::service::logs::otlp_grpc::handle_grpc_request;
+    use super::*;
+    use crate::common::{
+        infra::config::SCHEMA_CONTRACTS,
+        meta::schema_contract::{
+            ContractEnforcement, ContractField, ContractFieldType, SchemaContract,
+        },
+    };
 
     #[tokio::test]
     async fn test_handle_logs_request() {
@@ -640,7 +728,73 @@ mod tests {
         };
 
         let result =
-            handle_grpc_request(org_id, request, true, Some("test_stream"), "a@a.com").await;
+            handle_grpc_request(org_id, request, true, Some("test_stream"), "a@a.com", false).await;
         assert!(result.is_ok());
     }
+
+    #[tokio::test]
+    async fn test_handle_logs_request_with_schema_contract() {
+        let org_id = "test_org_contract";
+        let stream_name = "test_stream";
+        SCHEMA_CONTRACTS.insert(
+            format!("{org_id}/{}/{stream_name}", StreamType::Logs),
+            SchemaContract {
+                stream_name: stream_name.to_string(),
+                stream_type: StreamType::Logs,
+                description: "".to_string(),
+                fields: vec![ContractField {
+                    name: "instance_num".to_string(),
+                    field_type: ContractFieldType::String,
+                    required: true,
+                }],
+                allow_extra_fields: true,
+                enforcement: ContractEnforcement::Reject,
+                updated_at: 0,
+            },
+        );
+
+        let now = Utc::now().timestamp_nanos_opt().unwrap() as u64;
+        let log_records = [
+            StringValue("1".to_string()),
+            IntValue(1), // violates the contract
+        ]
+        .into_iter()
+        .map(|instance_num| LogRecord {
+            time_unix_nano: now,
+            body: Some(AnyValue {
+                value: Some(StringValue("This is a log message".to_string())),
+            }),
+            attributes: vec![KeyValue {
+                key: "instance_num".to_string(),
+                value: Some(AnyValue {
+                    value: Some(instance_num),
+                }),
+            }],
+            ..Default::default()
+        })
+        .collect();
+        let request = ExportLogsServiceRequest {
+            resource_logs: vec![ResourceLogs {
+                scope_logs: vec![ScopeLogs {
+                    log_records,
+                    ..Default::default()
+                }],
+                ..Default::default()
+            }],
+        };
+
+        let resp = handle_grpc_request(org_id, request, true, Some(stream_name), "a@a.com", false)
+            .await
+            .unwrap();
+        SCHEMA_CONTRACTS.remove(&format!("{org_id}/{}/{stream_name}", StreamType::Logs));
+        let body = actix_web::body::to_bytes(resp.into_body()).await.unwrap();
+        let partial_success = ExportLogsServiceResponse::decode(body)
+            .unwrap()
+            .partial_success
+            .unwrap();
+        assert_eq!(partial_success.rejected_log_records, 1);
+        assert!(partial_success
+            .error_message
+            .starts_with("schema contract violation"));
+    }
 }
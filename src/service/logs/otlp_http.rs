@@ -20,7 +20,10 @@ use bytes::BytesMut;
 use chrono::{Duration, Utc};
 use config::{
     cluster,
-    meta::{stream::StreamType, usage::UsageType},
+    meta::{
+        stream::{SamplingRule, StreamType},
+        usage::UsageType,
+    },
     metrics,
     utils::{flatten, json},
     DISTINCT_FIELDS,
@@ -58,10 +61,26 @@ pub async fn logs_proto_handler(
     body: web::Bytes,
     in_stream_name: Option<&str>,
     user_email: &str,
+    skip_sampling: bool,
 ) -> Result<HttpResponse, std::io::Error> {
-    let request = ExportLogsServiceRequest::decode(body).expect("Invalid protobuf");
-    match super::otlp_grpc::handle_grpc_request(org_id, request, false, in_stream_name, user_email)
-        .await
+    let request = match ExportLogsServiceRequest::decode(body) {
+        Ok(v) => v,
+        Err(e) => {
+            return Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
+                http::StatusCode::BAD_REQUEST.into(),
+                format!("Invalid proto: {}", e),
+            )));
+        }
+    };
+    match super::otlp_grpc::handle_grpc_request(
+        org_id,
+        request,
+        false,
+        in_stream_name,
+        user_email,
+        skip_sampling,
+    )
+    .await
     {
         Ok(res) => Ok(res),
         Err(e) => {
@@ -83,6 +102,7 @@ pub async fn logs_json_handler(
     body: web::Bytes,
     in_stream_name: Option<&str>,
     user_email: &str,
+    skip_sampling: bool,
 ) -> Result<HttpResponse, std::io::Error> {
     let started_at = Utc::now().timestamp_micros();
 
@@ -104,8 +124,15 @@ pub async fn logs_json_handler(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -167,12 +194,26 @@ pub async fn logs_json_handler(
     // Start get user defined schema
     let mut user_defined_schema_map: HashMap<String, HashSet<String>> = HashMap::new();
     crate::service::ingestion::get_user_defined_schema(
-        &[stream_param],
+        &[stream_param.clone()],
         &mut user_defined_schema_map,
     )
     .await;
     // End get user defined schema
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    if !skip_sampling {
+        crate::service::ingestion::get_stream_sampling_rules(
+            &[stream_param],
+            &mut stream_sampling_map,
+        )
+        .await;
+    }
+    // End get sampling rules
+
+    let schema_contract =
+        crate::service::schema_contracts::get_contract(org_id, StreamType::Logs, stream_name);
+
     // Start Register Transforms for stream
     let (local_trans, stream_vrl_map) = crate::service::ingestion::register_stream_functions(
         org_id,
@@ -230,31 +271,35 @@ pub async fn logs_json_handler(
                 let attributes = resource.get("attributes").unwrap().as_array().unwrap();
                 for res_attr in attributes {
                     let local_attr = res_attr.as_object().unwrap();
-                    if local_attr
+                    let key = local_attr
                         .get("key")
-                        .unwrap()
-                        .as_str()
-                        .unwrap()
-                        .eq(SERVICE_NAME)
-                    {
-                        let loc_service_name =
-                            local_attr.get("value").unwrap().as_object().unwrap();
-                        for item in loc_service_name {
+                        .and_then(|v| v.as_str())
+                        .unwrap_or_default();
+                    // an attribute without value is valid, it is stored as null
+                    let value = local_attr.get("value");
+                    if key.eq(SERVICE_NAME) {
+                        let loc_service_name = value.and_then(|v| v.as_object());
+                        for item in loc_service_name.into_iter().flatten() {
                             service_att_map.insert(SERVICE_NAME.to_string(), item.1.clone());
                         }
                     } else {
                         service_att_map.insert(
-                            format!(
-                                "{}_{}",
-                                SERVICE,
-                                local_attr.get("key").unwrap().as_str().unwrap()
-                            ),
-                            get_val_for_attr(local_attr.get("value").unwrap()),
+                            format!("{}_{}", SERVICE, key),
+                            value.map(get_val_for_attr).unwrap_or_default(),
                         );
                     }
                 }
             }
         }
+        let resource_entities = crate::service::entities::from_attributes(
+            service_att_map.iter().map(|(k, v)| (k.as_str(), v)),
+        );
+        crate::service::entities::observe(
+            org_id,
+            StreamType::Logs,
+            &stream_name,
+            &resource_entities,
+        );
         let scope_resources = res_log.get("scopeLogs");
         let inst_resources = if let Some(v) = scope_resources {
             v.as_array().unwrap()
@@ -292,7 +337,8 @@ pub async fn logs_json_handler(
                         let local_attr = res_attr.as_object().unwrap();
                         let mut key = local_attr.get("key").unwrap().as_str().unwrap().to_string();
                         flatten::format_key(&mut key);
-                        local_val.insert(key, get_val_for_attr(local_attr.get("value").unwrap()));
+                        let value = local_attr.get("value").map(get_val_for_attr);
+                        local_val.insert(key, value.unwrap_or_default());
                     }
                 }
                 // remove attributes after adding
@@ -304,40 +350,36 @@ pub async fn logs_json_handler(
                 // process trace id
                 if log.get("trace_id").is_some() {
                     local_val.remove("trace_id");
-                    let trace_id = log.get("trace_id").unwrap();
-                    let trace_id_str = trace_id.as_str().unwrap();
-                    let trace_id_bytes = hex::decode(trace_id_str).unwrap().try_into().unwrap();
-                    let trace_id = TraceId::from_bytes(trace_id_bytes).to_string();
-                    local_val.insert("trace_id".to_owned(), trace_id.into());
+                    if let Some(trace_id) = log.get("trace_id").and_then(hex_id) {
+                        let trace_id = TraceId::from_bytes(trace_id).to_string();
+                        local_val.insert("trace_id".to_owned(), trace_id.into());
+                    }
                 } else if log.get("traceId").is_some() {
                     local_val.remove("traceId");
-                    let trace_id = log.get("traceId").unwrap();
-                    let trace_id_str = trace_id.as_str().unwrap();
-                    let trace_id_bytes = hex::decode(trace_id_str).unwrap().try_into().unwrap();
-                    let trace_id = TraceId::from_bytes(trace_id_bytes).to_string();
-                    local_val.insert("trace_id".to_owned(), trace_id.into());
+                    if let Some(trace_id) = log.get("traceId").and_then(hex_id) {
+                        let trace_id = TraceId::from_bytes(trace_id).to_string();
+                        local_val.insert("trace_id".to_owned(), trace_id.into());
+                    }
                 };
 
                 // process span id
                 if log.get("span_id").is_some() {
                     local_val.remove("span_id");
-                    let span_id = log.get("span_id").unwrap();
-                    let span_id_str = span_id.as_str().unwrap();
-                    let span_id_bytes = hex::decode(span_id_str).unwrap().try_into().unwrap();
-                    let span_id = SpanId::from_bytes(span_id_bytes).to_string();
-                    local_val.insert("span_id".to_owned(), span_id.into());
+                    if let Some(span_id) = log.get("span_id").and_then(hex_id) {
+                        let span_id = SpanId::from_bytes(span_id).to_string();
+                        local_val.insert("span_id".to_owned(), span_id.into());
+                    }
                 } else if log.get("spanId").is_some() {
                     local_val.remove("spanId");
-                    let span_id = log.get("spanId").unwrap();
-                    let span_id_str = span_id.as_str().unwrap();
-                    let span_id_bytes = hex::decode(span_id_str).unwrap().try_into().unwrap();
-                    let span_id = SpanId::from_bytes(span_id_bytes).to_string();
-                    local_val.insert("span_id".to_owned(), span_id.into());
+                    if let Some(span_id) = log.get("spanId").and_then(hex_id) {
+                        let span_id = SpanId::from_bytes(span_id).to_string();
+                        local_val.insert("span_id".to_owned(), span_id.into());
+                    }
                 };
 
                 if log.get("body").is_some() {
-                    let body = log.get("body").unwrap().get("stringValue").unwrap();
-                    local_val.insert("body".to_owned(), body.clone());
+                    let body = get_val_for_attr(log.get("body").unwrap());
+                    local_val.insert("body".to_owned(), body);
                 }
 
                 // check ingestion time
@@ -376,6 +418,37 @@ pub async fn logs_json_handler(
                     _ => unreachable!(),
                 };
 
+                if let Some(rules) = stream_sampling_map.get(stream_name) {
+                    if let Some(rule) =
+                        crate::service::ingestion::sample_record(rules, &local_val).await
+                    {
+                        metrics::INGEST_SAMPLED_RECORDS
+                            .with_label_values(&[
+                                org_id,
+                                stream_name,
+                                StreamType::Logs.to_string().as_str(),
+                                rule,
+                            ])
+                            .inc();
+                        continue;
+                    }
+                }
+
+                if let Some(contract) = schema_contract.as_ref() {
+                    if let Some(e) = crate::service::schema_contracts::check_record(
+                        org_id,
+                        StreamType::Logs,
+                        stream_name,
+                        contract,
+                        user_email,
+                        &local_val,
+                    ) {
+                        stream_status.status.failed += 1;
+                        stream_status.status.error = e;
+                        continue;
+                    }
+                }
+
                 if let Some(fields) = user_defined_schema_map.get(stream_name) {
                     local_val = crate::service::logs::refactor_map(local_val, fields);
                 }
@@ -495,3 +568,9 @@ pub async fn logs_json_handler(
         .content_type(CONTENT_TYPE_JSON)
         .body(out));
 }
+
+/// Decodes a trace or span id of the json encoding, the malformed ones are
+/// dropped
+fn hex_id<const N: usize>(value: &json::Value) -> Option<[u8; N]> {
+    hex::decode(value.as_str()?).ok()?.try_into().ok()
+}
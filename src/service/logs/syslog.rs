@@ -20,7 +20,7 @@ use anyhow::Result;
 use chrono::{Duration, Utc};
 use config::{
     cluster,
-    meta::stream::StreamType,
+    meta::stream::{SamplingRule, StreamType},
     metrics,
     utils::{flatten, json, time::parse_timestamp_micro_from_value},
     DISTINCT_FIELDS,
@@ -81,6 +81,13 @@ pub async fn ingest(msg: &str, addr: SocketAddr) -> Result<HttpResponse> {
         );
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check if we are allowed to ingest
     if db::compact::retention::is_deleting_stream(org_id, StreamType::Logs, stream_name, None) {
         return Ok(
@@ -159,6 +166,50 @@ pub async fn ingest(msg: &str, addr: SocketAddr) -> Result<HttpResponse> {
         _ => unreachable!(),
     };
 
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    crate::service::ingestion::get_stream_sampling_rules(
+        &[StreamParams::new(org_id, stream_name, StreamType::Logs)],
+        &mut stream_sampling_map,
+    )
+    .await;
+    if let Some(rules) = stream_sampling_map.get(stream_name) {
+        if let Some(rule) = crate::service::ingestion::sample_record(rules, &local_val).await {
+            metrics::INGEST_SAMPLED_RECORDS
+                .with_label_values(&[
+                    org_id,
+                    stream_name,
+                    StreamType::Logs.to_string().as_str(),
+                    rule,
+                ])
+                .inc();
+            return Ok(HttpResponse::Ok().json(IngestionResponse::new(
+                http::StatusCode::OK.into(),
+                vec![stream_status],
+            )));
+        }
+    }
+
+    // the records are accounted to the address which sent them
+    if let Some(contract) =
+        crate::service::schema_contracts::get_contract(org_id, StreamType::Logs, stream_name)
+    {
+        if let Some(e) = crate::service::schema_contracts::check_record(
+            org_id,
+            StreamType::Logs,
+            stream_name,
+            &contract,
+            &ip.to_string(),
+            &local_val,
+        ) {
+            stream_status.status.failed += 1;
+            stream_status.status.error = e;
+            return Ok(HttpResponse::Ok().json(IngestionResponse::new(
+                http::StatusCode::OK.into(),
+                vec![stream_status],
+            )));
+        }
+    }
+
     // handle timestamp
     let timestamp = match local_val.get(&cfg.common.column_timestamp) {
         Some(v) => match parse_timestamp_micro_from_value(v) {
@@ -0,0 +1,233 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::io::Error;
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::utils::{json, rand::generate_random_string};
+
+use super::{db, users};
+use crate::common::{
+    infra::config::VERSION,
+    meta::{
+        http::HttpResponse as MetaHttpResponse,
+        mcp::{
+            JsonRpcRequest, JsonRpcResponse, McpToken, McpTokenCreated, McpTokenList,
+            McpTokenRequest, INVALID_PARAMS, INVALID_REQUEST, METHOD_NOT_FOUND, PROTOCOL_VERSION,
+        },
+    },
+    utils::auth::{get_hash, is_root_user},
+};
+
+mod tools;
+
+/// The last use of a token is saved at most once per minute
+const LAST_USED_INTERVAL: i64 = 60_000_000;
+
+fn is_valid_name(name: &str) -> bool {
+    !name.is_empty()
+        && name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-')
+}
+
+#[tracing::instrument(skip(req))]
+pub async fn create_token(
+    org_id: &str,
+    user_email: &str,
+    req: McpTokenRequest,
+) -> Result<HttpResponse, Error> {
+    if !is_valid_name(&req.name) {
+        return Ok(MetaHttpResponse::bad_request(
+            "Token name can only contain letters, digits, '_' and '-'",
+        ));
+    }
+    if req.max_rows < 0 || req.max_time_range < 0 || req.max_scan_size < 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "Token limits can't be negative",
+        ));
+    }
+    if db::mcp::get(org_id, &req.name).await.is_ok() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Token {} already exists",
+            req.name
+        )));
+    }
+
+    let secret = generate_random_string(32);
+    let salt = config::ider::uuid();
+    let token = McpToken {
+        name: req.name,
+        org_id: org_id.to_string(),
+        description: req.description,
+        streams: req.streams,
+        stream_types: req.stream_types,
+        max_rows: req.max_rows,
+        max_time_range: req.max_time_range,
+        max_scan_size: req.max_scan_size,
+        expires_at: req.expires_at,
+        token_hash: get_hash(&secret, &salt),
+        salt,
+        created_by: user_email.to_string(),
+        created_at: Utc::now().timestamp_micros(),
+        last_used_at: 0,
+    };
+    match db::mcp::set(&token).await {
+        Ok(_) => {
+            log::info!(
+                "[MCP] token {}/{} created by {}",
+                token.org_id,
+                token.name,
+                user_email
+            );
+            Ok(MetaHttpResponse::json(McpTokenCreated {
+                token: format!("{}.{}", token.name, secret),
+                name: token.name,
+            }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_tokens(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::mcp::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(McpTokenList {
+            list: list.into_iter().map(redact).collect(),
+        })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_token(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::mcp::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Token not found"));
+    }
+    match db::mcp::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Token deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Deletes the tokens created by a user removed from the organization, or
+/// from every organization when `org_id` is `None`
+pub async fn revoke_user_tokens(
+    org_id: Option<&str>,
+    user_email: &str,
+) -> Result<(), anyhow::Error> {
+    let tokens = match org_id {
+        Some(org_id) => db::mcp::list(org_id).await?,
+        None => db::mcp::list_all().await?,
+    };
+    for token in tokens.iter().filter(|t| t.created_by == user_email) {
+        db::mcp::delete(&token.org_id, &token.name).await?;
+        log::info!(
+            "[MCP] token {}/{} revoked, {} was removed",
+            token.org_id,
+            token.name,
+            user_email
+        );
+    }
+    Ok(())
+}
+
+fn redact(mut token: McpToken) -> McpToken {
+    token.salt.clear();
+    token.token_hash.clear();
+    token
+}
+
+/// Returns the token of the bearer `name.secret`, or why it was refused
+pub async fn authenticate(org_id: &str, bearer: &str) -> Result<McpToken, String> {
+    let Some((name, secret)) = bearer.split_once('.') else {
+        return Err("Invalid token".to_string());
+    };
+    let Ok(mut token) = db::mcp::get(org_id, name).await else {
+        return Err("Invalid token".to_string());
+    };
+    if get_hash(secret, &token.salt) != token.token_hash {
+        return Err("Invalid token".to_string());
+    }
+    let now = Utc::now().timestamp_micros();
+    if token.is_expired(now) {
+        return Err("Token expired".to_string());
+    }
+    // the token acts as its creator, it ends when the creator leaves
+    if !is_root_user(&token.created_by)
+        && users::get_user(Some(org_id), &token.created_by)
+            .await
+            .is_none()
+    {
+        return Err("Token revoked".to_string());
+    }
+    if now - token.last_used_at > LAST_USED_INTERVAL {
+        token.last_used_at = now;
+        if let Err(e) = db::mcp::set(&token).await {
+            log::error!("[MCP] failed to save the last use of {org_id}/{name}: {e}");
+        }
+    }
+    Ok(token)
+}
+
+/// Handles a JSON-RPC message of the Model Context Protocol, the
+/// notifications get no response
+pub async fn handle(token: &McpToken, req: JsonRpcRequest) -> Option<JsonRpcResponse> {
+    let id = req.id?;
+    if req.jsonrpc != "2.0" {
+        return Some(JsonRpcResponse::error(
+            id,
+            INVALID_REQUEST,
+            "jsonrpc should be 2.0",
+        ));
+    }
+    let resp = match req.method.as_str() {
+        "initialize" => JsonRpcResponse::result(
+            id,
+            json::json!({
+                "protocolVersion": PROTOCOL_VERSION,
+                "capabilities": { "tools": {} },
+                "serverInfo": {
+                    "name": "openobserve",
+                    "version": VERSION,
+                },
+                "instructions": tools::INSTRUCTIONS,
+            }),
+        ),
+        "ping" => JsonRpcResponse::result(id, json::json!({})),
+        "tools/list" => JsonRpcResponse::result(id, json::json!({ "tools": tools::definitions() })),
+        "tools/call" => {
+            let Some(name) = req.params.get("name").and_then(|v| v.as_str()) else {
+                return Some(JsonRpcResponse::error(
+                    id,
+                    INVALID_PARAMS,
+                    "missing tool name",
+                ));
+            };
+            let args = req
+                .params
+                .get("arguments")
+                .cloned()
+                .unwrap_or_else(|| json::json!({}));
+            match tools::call(token, name, &args).await {
+                Some(result) => JsonRpcResponse::result(id, result),
+                None => JsonRpcResponse::error(id, INVALID_PARAMS, format!("unknown tool {name}")),
+            }
+        }
+        method => JsonRpcResponse::error(id, METHOD_NOT_FOUND, format!("unknown method {method}")),
+    };
+    Some(resp)
+}
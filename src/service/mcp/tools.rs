@@ -0,0 +1,491 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::collections::{BTreeMap, HashMap};
+
+use anyhow::{anyhow, Result};
+use chrono::Utc;
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        sql::Sql,
+        stream::StreamType,
+    },
+    utils::{json, time::parse_milliseconds},
+};
+
+use crate::{
+    common::meta::{
+        mcp::McpToken,
+        query_analyze::{QueryAnalyzeRequest, QueryLanguage},
+    },
+    service::{
+        query_analyze,
+        search::{self as SearchService, access},
+        stream,
+    },
+};
+
+pub const INSTRUCTIONS: &str = "Query the logs, metrics and traces stored in OpenObserve. \
+    Call list_streams and get_schema before writing a query. Queries are SQL on one stream, \
+    e.g. SELECT * FROM \"app\" WHERE level = 'error' ORDER BY _timestamp DESC. \
+    The time range is given apart from the SQL, by `since` or by `start_time` and `end_time`.";
+
+const DEFAULT_SINCE: &str = "1h";
+/// Longer string values of the hits are cut to keep the results small
+const MAX_VALUE_LEN: usize = 1024;
+/// Most frequent values listed by summarize_results for each field
+const TOP_VALUES: usize = 5;
+
+pub fn definitions() -> json::Value {
+    let stream_type = json::json!({
+        "type": "string",
+        "enum": ["logs", "metrics", "traces"],
+        "description": "Type of the stream, default logs",
+    });
+    let query_args = json::json!({
+        "type": "object",
+        "properties": {
+            "sql": { "type": "string", "description": "SQL query on one stream" },
+            "stream_type": stream_type.clone(),
+            "since": {
+                "type": "string",
+                "description": "Relative time range ending now, e.g. 15m, 1h or 2d, default 1h",
+            },
+            "start_time": { "type": "integer", "description": "Start of the time range in unix microseconds" },
+            "end_time": { "type": "integer", "description": "End of the time range in unix microseconds" },
+            "size": { "type": "integer", "description": "Maximum rows to return" },
+        },
+        "required": ["sql"],
+    });
+    json::json!([
+        {
+            "name": "list_streams",
+            "description": "Lists the streams which can be queried, with their record count and time range.",
+            "inputSchema": {
+                "type": "object",
+                "properties": { "stream_type": stream_type.clone() },
+            },
+        },
+        {
+            "name": "get_schema",
+            "description": "Returns the fields of a stream and their types.",
+            "inputSchema": {
+                "type": "object",
+                "properties": {
+                    "stream_name": { "type": "string" },
+                    "stream_type": stream_type,
+                },
+                "required": ["stream_name"],
+            },
+        },
+        {
+            "name": "run_query",
+            "description": "Runs a SQL query and returns the rows. The rows, the time range and the data scanned are limited.",
+            "inputSchema": query_args.clone(),
+        },
+        {
+            "name": "summarize_results",
+            "description": "Runs a SQL query and returns, for each field of the rows, the most frequent values or the min, max and average of the numbers, instead of the rows themselves.",
+            "inputSchema": query_args,
+        },
+    ])
+}
+
+/// Runs a tool, `None` when it doesn't exist. The errors are returned as
+/// tool results so that the assistant can correct its call.
+pub async fn call(token: &McpToken, name: &str, args: &json::Value) -> Option<json::Value> {
+    let ret = match name {
+        "list_streams" => list_streams(token, args).await,
+        "get_schema" => get_schema(token, args).await,
+        "run_query" => run_query(token, args).await,
+        "summarize_results" => summarize_results(token, args).await,
+        _ => return None,
+    };
+    let (text, is_error) = match ret {
+        Ok(value) => (json::to_string(&value).unwrap_or_default(), false),
+        Err(e) => {
+            log::warn!(
+                "[MCP] tool {name} of token {}/{} failed: {e}",
+                token.org_id,
+                token.name
+            );
+            (e.to_string(), true)
+        }
+    };
+    Some(json::json!({
+        "content": [{ "type": "text", "text": text }],
+        "isError": is_error,
+    }))
+}
+
+/// Limits of a token, capped by the global ones
+struct Limits {
+    max_rows: i64,
+    /// microseconds
+    max_time_range: i64,
+    /// bytes
+    max_scan_size: i64,
+}
+
+fn cap(value: i64, global: i64) -> i64 {
+    if value > 0 {
+        std::cmp::min(value, global)
+    } else {
+        global
+    }
+}
+
+fn limits(token: &McpToken) -> Limits {
+    let cfg = get_config();
+    Limits {
+        max_rows: cap(token.max_rows, cfg.limit.mcp_max_rows),
+        max_time_range: cap(token.max_time_range, cfg.limit.mcp_max_time_range) * 3_600_000_000,
+        max_scan_size: cap(token.max_scan_size, cfg.limit.mcp_max_scan_size) * 1024 * 1024,
+    }
+}
+
+fn stream_type_arg(args: &json::Value) -> Option<StreamType> {
+    args.get("stream_type")
+        .and_then(|v| v.as_str())
+        .filter(|v| !v.is_empty())
+        .map(StreamType::from)
+}
+
+/// Returns the time range of the arguments, `start_time` and `end_time`
+/// win over `since`
+fn time_range(args: &json::Value, now: i64, max_range: i64) -> Result<(i64, i64)> {
+    let end_time = args
+        .get("end_time")
+        .and_then(|v| v.as_i64())
+        .filter(|v| *v > 0)
+        .unwrap_or(now);
+    let start_time = match args.get("start_time").and_then(|v| v.as_i64()) {
+        Some(v) if v > 0 => v,
+        _ => {
+            let since = args
+                .get("since")
+                .and_then(|v| v.as_str())
+                .unwrap_or(DEFAULT_SINCE);
+            let millis = parse_milliseconds(since).map_err(|e| anyhow!("invalid since: {e}"))?;
+            end_time - millis as i64 * 1000
+        }
+    };
+    if start_time >= end_time {
+        return Err(anyhow!("start_time should be before end_time"));
+    }
+    if end_time - start_time > max_range {
+        return Err(anyhow!(
+            "the time range is longer than the limit of {} hours, narrow it",
+            max_range / 3_600_000_000
+        ));
+    }
+    Ok((start_time, end_time))
+}
+
+/// The stream has to be allowed by the token and readable by its creator,
+/// whose permissions can change after the token was created
+async fn check_stream(token: &McpToken, stream_type: StreamType, stream_name: &str) -> Result<()> {
+    if token.allows(stream_type, stream_name)
+        && access::can_read(&token.org_id, &token.created_by, stream_type, stream_name).await
+    {
+        Ok(())
+    } else {
+        Err(anyhow!(
+            "the token has no access to the {stream_type} stream {stream_name}"
+        ))
+    }
+}
+
+async fn list_streams(token: &McpToken, args: &json::Value) -> Result<json::Value> {
+    let streams = stream::get_streams(&token.org_id, stream_type_arg(args), false, None).await;
+    let mut list = Vec::with_capacity(streams.len());
+    for s in streams {
+        if check_stream(token, s.stream_type, &s.name).await.is_err() {
+            continue;
+        }
+        list.push(json::json!({
+            "name": s.name,
+            "stream_type": s.stream_type,
+            "records": s.stats.doc_num,
+            "start_time": s.stats.doc_time_min,
+            "end_time": s.stats.doc_time_max,
+        }));
+    }
+    Ok(json::Value::Array(list))
+}
+
+async fn get_schema(token: &McpToken, args: &json::Value) -> Result<json::Value> {
+    let stream_name = args
+        .get("stream_name")
+        .and_then(|v| v.as_str())
+        .ok_or_else(|| anyhow!("missing stream_name"))?;
+    let stream_type = stream_type_arg(args).unwrap_or_default();
+    check_stream(token, stream_type, stream_name).await?;
+    let schema = infra::schema::get(&token.org_id, stream_name, stream_type).await?;
+    if schema.fields().is_empty() {
+        return Err(anyhow!("stream {stream_name} not found"));
+    }
+    let fields = schema
+        .fields()
+        .iter()
+        .map(|f| json::json!({ "name": f.name(), "type": f.data_type().to_string() }))
+        .collect::<Vec<_>>();
+    Ok(json::json!({ "stream_name": stream_name, "fields": fields }))
+}
+
+/// Checks the query against the token and its limits, then runs it
+async fn search(token: &McpToken, args: &json::Value) -> Result<config::meta::search::Response> {
+    let sql = args
+        .get("sql")
+        .and_then(|v| v.as_str())
+        .ok_or_else(|| anyhow!("missing sql"))?;
+    let stream_type = stream_type_arg(args).unwrap_or_default();
+    let stream_name = Sql::new(sql)?.source;
+    check_stream(token, stream_type, &stream_name).await?;
+
+    let limits = limits(token);
+    let (start_time, end_time) =
+        time_range(args, Utc::now().timestamp_micros(), limits.max_time_range)?;
+    let size = args
+        .get("size")
+        .and_then(|v| v.as_i64())
+        .filter(|v| *v > 0)
+        .map_or(limits.max_rows, |v| std::cmp::min(v, limits.max_rows));
+
+    let cost = query_analyze::analyze(
+        &token.org_id,
+        stream_type,
+        &QueryAnalyzeRequest {
+            query: sql.to_string(),
+            query_type: QueryLanguage::Sql,
+            start_time,
+            end_time,
+            step: 0,
+        },
+    )
+    .await?;
+    if cost.compressed_size > limits.max_scan_size {
+        return Err(anyhow!(
+            "the query would scan {} MB, more than the limit of {} MB, narrow the time range",
+            cost.compressed_size / 1024 / 1024,
+            limits.max_scan_size / 1024 / 1024
+        ));
+    }
+
+    let req = Request {
+        query: Query {
+            sql: sql.to_string(),
+            from: 0,
+            size,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: get_config().limit.query_timeout as i64,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    log::info!(
+        "[MCP] trace_id {trace_id} token {}/{} query: {sql}",
+        token.org_id,
+        token.name
+    );
+    Ok(SearchService::search(
+        &trace_id,
+        &token.org_id,
+        stream_type,
+        Some(token.created_by.clone()),
+        &req,
+    )
+    .await?)
+}
+
+async fn run_query(token: &McpToken, args: &json::Value) -> Result<json::Value> {
+    let mut resp = search(token, args).await?;
+    resp.hits.iter_mut().for_each(truncate_values);
+    Ok(json::json!({
+        "took": resp.took,
+        "rows": resp.hits.len(),
+        "hits": resp.hits,
+    }))
+}
+
+async fn summarize_results(token: &McpToken, args: &json::Value) -> Result<json::Value> {
+    let resp = search(token, args).await?;
+    Ok(summarize(&resp.hits, TOP_VALUES))
+}
+
+fn truncate_values(hit: &mut json::Value) {
+    if let Some(obj) = hit.as_object_mut() {
+        for val in obj.values_mut() {
+            if let json::Value::String(s) = val {
+                if let Some((pos, _)) = s.char_indices().nth(MAX_VALUE_LEN) {
+                    s.truncate(pos);
+                    s.push_str("...");
+                }
+            }
+        }
+    }
+}
+
+#[derive(Default)]
+struct FieldSummary {
+    count: usize,
+    min: Option<f64>,
+    max: Option<f64>,
+    sum: f64,
+    numbers: usize,
+    values: HashMap<String, usize>,
+}
+
+/// Describes the rows by field: the numbers by their min, max and average,
+/// the other values by the most frequent ones
+fn summarize(hits: &[json::Value], top: usize) -> json::Value {
+    let mut fields: BTreeMap<&str, FieldSummary> = BTreeMap::new();
+    for hit in hits {
+        let Some(obj) = hit.as_object() else {
+            continue;
+        };
+        for (name, val) in obj {
+            let summary = fields.entry(name.as_str()).or_default();
+            match val {
+                json::Value::Null => continue,
+                json::Value::Number(n) => {
+                    let n = n.as_f64().unwrap_or_default();
+                    summary.min = Some(summary.min.map_or(n, |v| v.min(n)));
+                    summary.max = Some(summary.max.map_or(n, |v| v.max(n)));
+                    summary.sum += n;
+                    summary.numbers += 1;
+                }
+                json::Value::String(s) => *summary.values.entry(s.to_string()).or_default() += 1,
+                v => *summary.values.entry(v.to_string()).or_default() += 1,
+            }
+            summary.count += 1;
+        }
+    }
+
+    let fields = fields
+        .into_iter()
+        .map(|(name, s)| {
+            let mut field = json::Map::new();
+            field.insert("count".to_string(), s.count.into());
+            if s.numbers > 0 {
+                field.insert("min".to_string(), json::json!(s.min));
+                field.insert("max".to_string(), json::json!(s.max));
+                field.insert("avg".to_string(), json::json!(s.sum / s.numbers as f64));
+            }
+            if !s.values.is_empty() {
+                field.insert("distinct".to_string(), s.values.len().into());
+                let mut values = s.values.into_iter().collect::<Vec<_>>();
+                values.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+                let top_values = values
+                    .into_iter()
+                    .take(top)
+                    .map(|(value, count)| json::json!({ "value": value, "count": count }))
+                    .collect::<Vec<_>>();
+                field.insert("top_values".to_string(), top_values.into());
+            }
+            (name.to_string(), json::Value::Object(field))
+        })
+        .collect::<json::Map<_, _>>();
+    json::json!({ "rows": hits.len(), "fields": fields })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_time_range() {
+        let hour = 3_600_000_000;
+        let now = 100 * hour;
+        assert_eq!(
+            time_range(&json::json!({}), now, 24 * hour).unwrap(),
+            (99 * hour, now)
+        );
+        assert_eq!(
+            time_range(&json::json!({"since": "2h"}), now, 24 * hour).unwrap(),
+            (98 * hour, now)
+        );
+        assert_eq!(
+            time_range(
+                &json::json!({"start_time": hour, "end_time": 2 * hour, "since": "1d"}),
+                now,
+                24 * hour
+            )
+            .unwrap(),
+            (hour, 2 * hour)
+        );
+        assert!(time_range(&json::json!({"since": "2d"}), now, 24 * hour).is_err());
+        assert!(time_range(&json::json!({"since": "x"}), now, 24 * hour).is_err());
+        assert!(time_range(
+            &json::json!({"start_time": 2 * hour, "end_time": hour}),
+            now,
+            24 * hour
+        )
+        .is_err());
+    }
+
+    #[test]
+    fn test_cap() {
+        assert_eq!(cap(0, 100), 100);
+        assert_eq!(cap(10, 100), 10);
+        assert_eq!(cap(1000, 100), 100);
+    }
+
+    #[test]
+    fn test_truncate_values() {
+        let mut hit = json::json!({"short": "abc", "long": "é".repeat(MAX_VALUE_LEN + 10), "n": 1});
+        truncate_values(&mut hit);
+        assert_eq!(hit["short"], "abc");
+        assert_eq!(
+            hit["long"].as_str().unwrap().chars().count(),
+            MAX_VALUE_LEN + 3
+        );
+        assert_eq!(hit["n"], 1);
+    }
+
+    #[test]
+    fn test_summarize() {
+        let hits = vec![
+            json::json!({"level": "error", "took": 10, "host": null}),
+            json::json!({"level": "info", "took": 30}),
+            json::json!({"level": "error", "took": 20}),
+        ];
+        let summary = summarize(&hits, 1);
+        assert_eq!(summary["rows"], 3);
+        let level = &summary["fields"]["level"];
+        assert_eq!(level["count"], 3);
+        assert_eq!(level["distinct"], 2);
+        assert_eq!(
+            level["top_values"],
+            json::json!([{"value": "error", "count": 2}])
+        );
+        let took = &summary["fields"]["took"];
+        assert_eq!(took["min"], 10.0);
+        assert_eq!(took["max"], 30.0);
+        assert_eq!(took["avg"], 20.0);
+        assert!(took.get("top_values").is_none());
+        assert_eq!(summary["fields"]["host"]["count"], 0);
+    }
+}
@@ -188,6 +188,15 @@ impl TraceListIndex {
                 flatten_level: None,
                 max_query_range: 0,
                 defined_schema_fields: None,
+                sampling_rules: vec![],
+                max_columns: 0,
+                parquet: None,
+                clustering_keys: vec![],
+                primary_key: None,
+                dedup: None,
+                table_format: Default::default(),
+                resolution_ms: 0,
+                field_access: vec![],
             };
 
             stream::save_stream_settings(org_id, STREAM_NAME, StreamType::Metadata, settings)
@@ -0,0 +1,256 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding},
+        stream::StreamType,
+    },
+    utils::json,
+};
+use futures::{stream, StreamExt};
+use infra::errors::Result;
+
+use super::EXCLUDE_LABELS;
+use crate::{
+    common::meta::prom::{
+        CardinalityResponse, LabelCardinality, LabelValueCount, LabelValuesResponse,
+        StreamSeriesCount, TopStreamsResponse, HASH_LABEL, NAME_LABEL, TYPE_LABEL,
+    },
+    service::{db, search as search_service},
+};
+
+/// Default number of label values returned
+pub const DEFAULT_VALUES_LIMIT: i64 = 100;
+
+/// Default number of streams returned by the organization ranking
+pub const DEFAULT_STREAMS_LIMIT: usize = 10;
+
+/// Returns the labels of the metric stream which can be used to split series.
+pub fn label_names(schema: &arrow_schema::Schema) -> Vec<String> {
+    schema
+        .fields()
+        .iter()
+        .map(|f| f.name().to_string())
+        .filter(|name| {
+            !EXCLUDE_LABELS.contains(&name.as_str())
+                && name != NAME_LABEL
+                && name != TYPE_LABEL
+                && name != "_all"
+        })
+        .collect()
+}
+
+fn new_request(sql: String, start_time: i64, end_time: i64, size: i64) -> Request {
+    Request {
+        query: Query {
+            sql,
+            from: 0,
+            size,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: Default::default(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: None,
+        search_event_context: None,
+    }
+}
+
+fn get_u64(hit: &json::Value, key: &str) -> u64 {
+    hit.get(key).and_then(|v| v.as_u64()).unwrap_or_default()
+}
+
+/// Counts the series of the stream and the distinct values of every label in the time range.
+pub async fn get_cardinality(
+    org_id: &str,
+    stream_name: &str,
+    start_time: i64,
+    end_time: i64,
+) -> Result<Option<CardinalityResponse>> {
+    let schema = infra::schema::get(org_id, stream_name, StreamType::Metrics).await?;
+    if schema.fields().is_empty() {
+        return Ok(None);
+    }
+    let labels = label_names(&schema);
+    let mut columns = vec![format!(
+        "COUNT(DISTINCT \"{HASH_LABEL}\") AS \"{HASH_LABEL}\""
+    )];
+    columns.extend(
+        labels
+            .iter()
+            .map(|label| format!("COUNT(DISTINCT \"{label}\") AS \"{label}\"")),
+    );
+    let sql = format!("SELECT {} FROM \"{stream_name}\"", columns.join(", "));
+    let req = new_request(sql, start_time, end_time, 1);
+    let resp = search_service::search("", org_id, StreamType::Metrics, None, &req).await?;
+    let hit = resp.hits.first().cloned().unwrap_or_default();
+
+    let mut labels = labels
+        .into_iter()
+        .map(|name| LabelCardinality {
+            values: get_u64(&hit, &name),
+            name,
+        })
+        .collect::<Vec<_>>();
+    labels.sort_by(|a, b| b.values.cmp(&a.values).then_with(|| a.name.cmp(&b.name)));
+
+    Ok(Some(CardinalityResponse {
+        stream_name: stream_name.to_string(),
+        start_time,
+        end_time,
+        series: get_u64(&hit, HASH_LABEL),
+        labels,
+    }))
+}
+
+/// Returns the values of a label ordered by the number of series carrying them.
+pub async fn get_label_values(
+    org_id: &str,
+    stream_name: &str,
+    label: &str,
+    start_time: i64,
+    end_time: i64,
+    limit: i64,
+) -> Result<Option<LabelValuesResponse>> {
+    let schema = infra::schema::get(org_id, stream_name, StreamType::Metrics).await?;
+    if !label_names(&schema).iter().any(|v| v == label) {
+        return Ok(None);
+    }
+    let limit = if limit > 0 {
+        limit
+    } else {
+        DEFAULT_VALUES_LIMIT
+    };
+    let sql = format!(
+        "SELECT \"{label}\" AS zo_sql_key, COUNT(DISTINCT \"{HASH_LABEL}\") AS zo_sql_num FROM \"{stream_name}\" GROUP BY zo_sql_key ORDER BY zo_sql_num DESC"
+    );
+    let req = new_request(sql, start_time, end_time, limit);
+    let resp = search_service::search("", org_id, StreamType::Metrics, None, &req).await?;
+    let values = resp
+        .hits
+        .iter()
+        .map(|hit| LabelValueCount {
+            value: hit
+                .get("zo_sql_key")
+                .map(json::get_string_value)
+                .unwrap_or_default(),
+            series: get_u64(hit, "zo_sql_num"),
+        })
+        .collect();
+
+    Ok(Some(LabelValuesResponse {
+        stream_name: stream_name.to_string(),
+        label: label.to_string(),
+        start_time,
+        end_time,
+        values,
+    }))
+}
+
+/// Ranks the metric streams of the organization by the number of series in the
+/// time range, to find the streams whose cardinality grows before looking at
+/// their labels with [`get_cardinality`].
+pub async fn get_top_streams(
+    org_id: &str,
+    start_time: i64,
+    end_time: i64,
+    limit: usize,
+) -> Result<TopStreamsResponse> {
+    let limit = if limit > 0 {
+        limit
+    } else {
+        DEFAULT_STREAMS_LIMIT
+    };
+    let stream_names = db::schema::list_streams_from_cache(org_id, StreamType::Metrics).await;
+    let mut streams = stream::iter(stream_names)
+        .map(|stream_name| async move {
+            let sql = format!(
+                "SELECT COUNT(DISTINCT \"{HASH_LABEL}\") AS \"{HASH_LABEL}\" FROM \"{stream_name}\""
+            );
+            let req = new_request(sql, start_time, end_time, 1);
+            let resp = search_service::search("", org_id, StreamType::Metrics, None, &req).await?;
+            let hit = resp.hits.first().cloned().unwrap_or_default();
+            Ok::<_, infra::errors::Error>(StreamSeriesCount {
+                series: get_u64(&hit, HASH_LABEL),
+                stream_name,
+            })
+        })
+        .buffer_unordered(get_config().limit.cpu_num.max(1))
+        .collect::<Vec<_>>()
+        .await
+        .into_iter()
+        .collect::<Result<Vec<_>>>()?;
+    rank_streams(&mut streams, limit);
+
+    Ok(TopStreamsResponse {
+        start_time,
+        end_time,
+        streams,
+    })
+}
+
+fn rank_streams(streams: &mut Vec<StreamSeriesCount>, limit: usize) {
+    streams.retain(|s| s.series > 0);
+    streams.sort_by(|a, b| {
+        b.series
+            .cmp(&a.series)
+            .then_with(|| a.stream_name.cmp(&b.stream_name))
+    });
+    streams.truncate(limit);
+}
+
+#[cfg(test)]
+mod tests {
+    use arrow_schema::{DataType, Field, Schema};
+
+    use super::*;
+
+    #[test]
+    fn test_label_names() {
+        let schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new(NAME_LABEL, DataType::Utf8, false),
+            Field::new(HASH_LABEL, DataType::Utf8, false),
+            Field::new("value", DataType::Float64, false),
+            Field::new("method", DataType::Utf8, true),
+            Field::new("pod", DataType::Utf8, true),
+        ]);
+        assert_eq!(label_names(&schema), vec!["method", "pod"]);
+    }
+
+    #[test]
+    fn test_rank_streams() {
+        let mut streams = [("b", 10), ("empty", 0), ("a", 10), ("c", 500), ("d", 1)]
+            .into_iter()
+            .map(|(name, series)| StreamSeriesCount {
+                stream_name: name.to_string(),
+                series,
+            })
+            .collect::<Vec<_>>();
+        rank_streams(&mut streams, 3);
+        let names = streams
+            .iter()
+            .map(|s| s.stream_name.as_str())
+            .collect::<Vec<_>>();
+        assert_eq!(names, vec!["c", "a", "b"]);
+    }
+}
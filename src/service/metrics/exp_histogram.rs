@@ -0,0 +1,165 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Conversion of OTLP exponential (base-2) histograms into cumulative `le`
+//! buckets, so they can be merged across series and time and queried with
+//! `histogram_quantile` like conventional histograms.
+
+/// One OTLP exponential histogram data point, independent of the wire format.
+#[derive(Debug, Default, Clone, PartialEq)]
+pub struct ExpHistogram {
+    pub scale: i32,
+    pub count: u64,
+    pub zero_count: u64,
+    pub zero_threshold: f64,
+    /// `(offset, bucket_counts)` of the positive range
+    pub positive: Option<(i32, Vec<u64>)>,
+    /// `(offset, bucket_counts)` of the negative range
+    pub negative: Option<(i32, Vec<u64>)>,
+}
+
+/// Returns the lower boundary of the bucket `index` at `scale`, i.e.
+/// `base^index` where `base = 2^(2^-scale)`.
+pub fn bucket_boundary(index: i32, scale: i32) -> f64 {
+    (index as f64 * (-scale as f64).exp2()).exp2()
+}
+
+/// Merges adjacent buckets so that the histogram is expressed at
+/// `scale - by`. Every bucket boundary of the lower scale is also a boundary
+/// of the higher one, so this is lossless with respect to the new buckets.
+pub fn downscale(offset: i32, counts: &[u64], by: u32) -> (i32, Vec<u64>) {
+    if by == 0 || counts.is_empty() {
+        return (offset, counts.to_vec());
+    }
+    let new_offset = offset >> by;
+    let last = (offset + counts.len() as i32 - 1) >> by;
+    let mut out = vec![0; (last - new_offset + 1) as usize];
+    for (i, count) in counts.iter().enumerate() {
+        let idx = (offset + i as i32) >> by;
+        out[(idx - new_offset) as usize] += count;
+    }
+    (new_offset, out)
+}
+
+impl ExpHistogram {
+    /// Converts the data point into `(upper_bound, cumulative_count)` pairs,
+    /// ordered by upper bound and always ending with a `+Inf` bucket.
+    ///
+    /// The histogram is first downscaled to `max_scale` when it is recorded at
+    /// a finer resolution. Series recorded at different scales then share the
+    /// same `le` values, which is what makes `sum by (le)` merge them exactly.
+    pub fn cumulative_buckets(&self, max_scale: i32) -> Vec<(f64, f64)> {
+        let scale = self.scale.min(max_scale);
+        let by = (self.scale - scale) as u32;
+        let mut buckets = vec![];
+        let mut cumulative = 0u64;
+
+        // negative bucket `i` covers [-base^(i+1), -base^i), so walk them from
+        // the largest index (closest to zero from below) downwards
+        if let Some((offset, counts)) = &self.negative {
+            let (offset, counts) = downscale(*offset, counts, by);
+            for (i, count) in counts.iter().enumerate().rev() {
+                cumulative += count;
+                buckets.push((
+                    -bucket_boundary(offset + i as i32, scale),
+                    cumulative as f64,
+                ));
+            }
+        }
+
+        cumulative += self.zero_count;
+        buckets.push((self.zero_threshold, cumulative as f64));
+
+        // positive bucket `i` covers (base^i, base^(i+1)]
+        if let Some((offset, counts)) = &self.positive {
+            let (offset, counts) = downscale(*offset, counts, by);
+            for (i, count) in counts.iter().enumerate() {
+                cumulative += count;
+                buckets.push((
+                    bucket_boundary(offset + i as i32 + 1, scale),
+                    cumulative as f64,
+                ));
+            }
+        }
+
+        buckets.push((f64::INFINITY, self.count.max(cumulative) as f64));
+        buckets
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_bucket_boundary() {
+        assert_eq!(bucket_boundary(0, 0), 1.0);
+        assert_eq!(bucket_boundary(3, 0), 8.0);
+        assert_eq!(bucket_boundary(-1, 0), 0.5);
+        assert_eq!(bucket_boundary(2, 1), 2.0);
+        assert_eq!(bucket_boundary(1, -1), 4.0);
+    }
+
+    #[test]
+    fn test_downscale() {
+        assert_eq!(downscale(1, &[1, 2, 3, 4], 0), (1, vec![1, 2, 3, 4]));
+        // indices 1..=4 -> 0, 1, 1, 2
+        assert_eq!(downscale(1, &[1, 2, 3, 4], 1), (0, vec![1, 5, 4]));
+        // negative indices round towards -inf: -3 -> -2, -2 -> -1, -1 -> -1
+        assert_eq!(downscale(-3, &[1, 2, 3], 1), (-2, vec![1, 5]));
+        assert_eq!(downscale(5, &[], 2), (5, vec![]));
+    }
+
+    #[test]
+    fn test_cumulative_buckets() {
+        let hist = ExpHistogram {
+            scale: 0,
+            count: 10,
+            zero_count: 1,
+            zero_threshold: 0.0,
+            positive: Some((0, vec![2, 3, 1])),
+            negative: Some((0, vec![1, 2])),
+        };
+        assert_eq!(
+            hist.cumulative_buckets(20),
+            vec![
+                (-2.0, 2.0),
+                (-1.0, 3.0),
+                (0.0, 4.0),
+                (2.0, 6.0),
+                (4.0, 9.0),
+                (8.0, 10.0),
+                (f64::INFINITY, 10.0),
+            ]
+        );
+    }
+
+    #[test]
+    fn test_cumulative_buckets_aligned_across_scales() {
+        let fine = ExpHistogram {
+            scale: 1,
+            count: 4,
+            positive: Some((0, vec![1, 1, 1, 1])),
+            ..Default::default()
+        };
+        let coarse = ExpHistogram {
+            scale: 0,
+            count: 4,
+            positive: Some((0, vec![2, 2])),
+            ..Default::default()
+        };
+        assert_eq!(fine.cumulative_buckets(0), coarse.cumulative_buckets(0));
+    }
+}
@@ -63,8 +63,10 @@ pub async fn ingest(org_id: &str, body: web::Bytes) -> Result<IngestionResponse>
         ));
     }
 
+    crate::service::residency::check_local(org_id).map_err(|e| anyhow::anyhow!(e))?;
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(IngestionResponse {
             code: http::StatusCode::SERVICE_UNAVAILABLE.into(),
             status: vec![],
@@ -77,6 +79,7 @@ pub async fn ingest(org_id: &str, body: web::Bytes) -> Result<IngestionResponse>
     let mut stream_status_map: HashMap<String, StreamStatus> = HashMap::new();
     let mut stream_data_buf: HashMap<String, HashMap<String, SchemaRecords>> = HashMap::new();
     let mut stream_partitioning_map: HashMap<String, PartitioningDetails> = HashMap::new();
+    let mut stream_resolution_map: HashMap<String, i64> = HashMap::new();
 
     let reader: Vec<json::Value> = json::from_slice(&body)?;
     for record in reader.into_iter() {
@@ -109,6 +112,7 @@ pub async fn ingest(org_id: &str, body: web::Bytes) -> Result<IngestionResponse>
                         metric_type: metrics_type.as_str().into(),
                         help: stream_name.clone().replace('_', " "),
                         unit: "".to_string(),
+                        original_unit: "".to_string(),
                     };
                     let mut extra_metadata: HashMap<String, String> = HashMap::new();
                     extra_metadata.insert(
@@ -147,6 +151,10 @@ pub async fn ingest(org_id: &str, body: web::Bytes) -> Result<IngestionResponse>
                 return Err(anyhow::anyhow!("invalid _timestamp, need to be number"));
             }
         };
+        let timestamp = super::align_timestamp(
+            timestamp,
+            super::get_resolution(&mut stream_resolution_map, org_id, &stream_name).await,
+        );
         let value: f64 = match record.get(VALUE_LABEL).ok_or(anyhow!("missing value"))? {
             json::Value::Number(s) => s.as_f64().unwrap(),
             _ => {
@@ -198,6 +206,7 @@ pub async fn ingest(org_id: &str, body: web::Bytes) -> Result<IngestionResponse>
                     metric_type: metrics_type.as_str().into(),
                     help: stream_name.clone().replace('_', " "),
                     unit: "".to_string(),
+                    original_unit: "".to_string(),
                 };
                 let mut extra_metadata: HashMap<String, String> = HashMap::new();
                 extra_metadata.insert(
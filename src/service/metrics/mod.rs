@@ -13,16 +13,24 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+use std::collections::HashMap;
+
+use config::meta::stream::StreamType;
 use datafusion::arrow::datatypes::Schema;
 use once_cell::sync::Lazy;
 use regex::Regex;
 
 use crate::common::meta::prom::{Metadata, HASH_LABEL, METADATA_LABEL, VALUE_LABEL};
 
+pub mod cardinality;
+pub mod exp_histogram;
 pub mod json;
 pub mod otlp_grpc;
 pub mod otlp_http;
 pub mod prom;
+pub mod remote_read;
+pub mod scrape;
+pub mod units;
 
 const EXCLUDE_LABELS: [&str; 5] = [
     VALUE_LABEL,
@@ -40,6 +48,47 @@ pub fn get_prom_metadata_from_schema(schema: &Schema) -> Option<Metadata> {
     Some(metadata)
 }
 
+/// Returns the sample resolution of the metrics stream in milliseconds, 0 when
+/// the samples keep the precision they were sent with
+pub async fn get_resolution(
+    cache: &mut HashMap<String, i64>,
+    org_id: &str,
+    stream_name: &str,
+) -> i64 {
+    if let Some(resolution) = cache.get(stream_name) {
+        return *resolution;
+    }
+    let resolution = infra::schema::get_settings(org_id, stream_name, StreamType::Metrics)
+        .await
+        .map(|settings| settings.resolution_ms)
+        .unwrap_or_default();
+    cache.insert(stream_name.to_string(), resolution);
+    resolution
+}
+
+/// Aligns a timestamp in microseconds down to the resolution of its stream
+pub fn align_timestamp(timestamp: i64, resolution_ms: i64) -> i64 {
+    if resolution_ms <= 0 {
+        return timestamp;
+    }
+    let resolution = resolution_ms * 1000;
+    timestamp - timestamp.rem_euclid(resolution)
+}
+
+/// Checks the metadata differs from the one stored in the latest stream schema.
+pub async fn is_metadata_changed(org_id: &str, metric_name: &str, metadata: &Metadata) -> bool {
+    match infra::schema::get_cache(
+        org_id,
+        metric_name,
+        config::meta::stream::StreamType::Metrics,
+    )
+    .await
+    {
+        Ok(schema) => get_prom_metadata_from_schema(schema.schema()).as_ref() != Some(metadata),
+        Err(_) => true,
+    }
+}
+
 #[derive(Debug, Default, Clone, PartialEq, Eq, Hash)]
 pub struct Signature([u8; 32]);
 
@@ -83,3 +132,45 @@ fn get_exclude_labels() -> Vec<&'static str> {
 pub fn format_label_name(label: &str) -> String {
     RE_CORRECT_LABEL_NAME.replace_all(label, "_").to_string()
 }
+
+/// Formats the upper bound of a histogram bucket like Prometheus does, `+Inf`
+/// for the last bucket
+pub fn format_le(le: f64) -> String {
+    if le == f64::INFINITY {
+        "+Inf".to_string()
+    } else {
+        le.to_string()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_format_le() {
+        assert_eq!(format_le(0.5), "0.5");
+        assert_eq!(format_le(10.0), "10");
+        assert_eq!(format_le(f64::INFINITY), "+Inf");
+    }
+
+    #[test]
+    fn test_align_timestamp() {
+        assert_eq!(
+            align_timestamp(1_700_000_000_123_456, 0),
+            1_700_000_000_123_456
+        );
+        assert_eq!(
+            align_timestamp(1_700_000_000_123_456, 100),
+            1_700_000_000_100_000
+        );
+        assert_eq!(
+            align_timestamp(1_700_000_000_999_999, 500),
+            1_700_000_000_500_000
+        );
+        assert_eq!(
+            align_timestamp(1_700_000_001_000_000, 1000),
+            1_700_000_001_000_000
+        );
+    }
+}
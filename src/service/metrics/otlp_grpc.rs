@@ -44,13 +44,13 @@ use crate::{
         stream::{SchemaRecords, StreamParams},
     },
     service::{
-        db, format_stream_name,
+        db, entities, format_stream_name,
         ingestion::{
             evaluate_trigger,
             grpc::{get_exemplar_val, get_metric_val, get_val},
             write_file, TriggerAlertData,
         },
-        metrics::{format_label_name, get_exclude_labels},
+        metrics::{exp_histogram::ExpHistogram, format_label_name, format_le, get_exclude_labels},
         schema::{check_for_schema, stream_schema_exists},
         usage::report_request_usage_stats,
     },
@@ -79,8 +79,15 @@ pub async fn handle_grpc_request(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -98,12 +105,25 @@ pub async fn handle_grpc_request(
     let mut stream_alerts_map: HashMap<String, Vec<alerts::Alert>> = HashMap::new();
     let mut stream_trigger_map: HashMap<String, Option<TriggerAlertData>> = HashMap::new();
     let mut stream_partitioning_map: HashMap<String, PartitioningDetails> = HashMap::new();
+    let mut stream_resolution_map: HashMap<String, i64> = HashMap::new();
 
     let cfg = get_config();
     for resource_metric in &request.resource_metrics {
+        let resource_entities = match &resource_metric.resource {
+            Some(res) => {
+                let attrs = res
+                    .attributes
+                    .iter()
+                    .map(|item| (format_label_name(&item.key), get_val(&item.value.as_ref())))
+                    .collect::<Vec<_>>();
+                entities::from_attributes(attrs.iter().map(|(k, v)| (k.as_str(), v)))
+            }
+            None => vec![],
+        };
         for scope_metric in &resource_metric.scope_metrics {
             for metric in &scope_metric.metrics {
                 let metric_name = &format_stream_name(&metric.name);
+                entities::observe(org_id, StreamType::Metrics, metric_name, &resource_entities);
                 // check for schema
                 let schema_exists = stream_schema_exists(
                     org_id,
@@ -179,10 +199,15 @@ pub async fn handle_grpc_request(
                     metric_type: MetricType::Unknown,
                     help: metric.description.to_owned(),
                     unit: metric.unit.to_owned(),
+                    original_unit: "".to_string(),
                 };
+                let unit_factor = super::units::normalize_metadata(
+                    &mut metadata,
+                    &cfg.common.metrics_unit_normalization,
+                );
                 let mut prom_meta: HashMap<String, String> = HashMap::new();
 
-                let records = match &metric.data {
+                let mut records = match &metric.data {
                     Some(data) => match data {
                         Data::Gauge(gauge) => {
                             process_gauge(&mut rec, gauge, &mut metadata, &mut prom_meta)
@@ -203,9 +228,14 @@ pub async fn handle_grpc_request(
                     },
                     None => vec![],
                 };
+                if let Some(factor) = unit_factor {
+                    super::units::apply(&mut records, metric_name, factor);
+                }
 
-                // udpate schema metadata
-                if !schema_exists.has_metadata {
+                // udpate schema metadata, also when the description or unit changed
+                if !schema_exists.has_metadata
+                    || super::is_metadata_changed(org_id, metric_name, &metadata).await
+                {
                     if let Err(e) =
                         update_setting(org_id, metric_name, StreamType::Metrics, prom_meta).await
                     {
@@ -294,6 +324,19 @@ pub async fn handle_grpc_request(
                         .unwrap()
                         .as_i64()
                         .unwrap_or(Utc::now().timestamp_micros());
+                    let resolution = super::get_resolution(
+                        &mut stream_resolution_map,
+                        org_id,
+                        local_metric_name,
+                    )
+                    .await;
+                    let timestamp = super::align_timestamp(timestamp, resolution);
+                    if resolution > 0 {
+                        val_map.insert(
+                            cfg.common.column_timestamp.clone(),
+                            json::Value::Number(timestamp.into()),
+                        );
+                    }
 
                     let value_str = json::to_string(&val_map).unwrap();
 
@@ -670,7 +713,7 @@ fn process_hist_data_point(
             bucket_rec["le"] = (*val.to_string()).into()
         }
         if i == len - 1 {
-            bucket_rec["le"] = std::f64::INFINITY.to_string().into();
+            bucket_rec["le"] = format_le(f64::INFINITY).into();
         }
         bucket_recs.push(bucket_rec);
     }
@@ -707,36 +750,28 @@ fn process_exp_hist_data_point(
     sum_rec[NAME_LABEL] = format!("{}_sum", sum_rec[NAME_LABEL].as_str().unwrap()).into();
     bucket_recs.push(sum_rec);
 
-    let base = 2 ^ (2 ^ -data_point.scale);
-    // add negative bucket records
-    match &data_point.negative {
-        Some(buckets) => {
-            let offset = buckets.offset;
-            for (i, val) in buckets.bucket_counts.iter().enumerate() {
-                let mut bucket_rec = rec.clone();
-                bucket_rec[NAME_LABEL] =
-                    format!("{}_bucket", rec[NAME_LABEL].as_str().unwrap()).into();
-                bucket_rec[VALUE_LABEL] = (*val as f64).into();
-                bucket_rec["le"] = (base ^ (offset + (i as i32) + 1)).to_string().into();
-                bucket_recs.push(bucket_rec);
-            }
-        }
-        None => {}
-    }
-    // add positive bucket records
-    match &data_point.positive {
-        Some(buckets) => {
-            let offset = buckets.offset;
-            for (i, val) in buckets.bucket_counts.iter().enumerate() {
-                let mut bucket_rec = rec.clone();
-                bucket_rec[NAME_LABEL] =
-                    format!("{}_bucket", rec[NAME_LABEL].as_str().unwrap()).into();
-                bucket_rec[VALUE_LABEL] = (*val as f64).into();
-                bucket_rec["le"] = (base ^ (offset + (i as i32) + 1)).to_string().into();
-                bucket_recs.push(bucket_rec);
-            }
-        }
-        None => {}
+    // add bucket records, as cumulative counts with an `le` upper bound
+    let hist = ExpHistogram {
+        scale: data_point.scale,
+        count: data_point.count,
+        zero_count: data_point.zero_count,
+        zero_threshold: data_point.zero_threshold,
+        positive: data_point
+            .positive
+            .as_ref()
+            .map(|b| (b.offset, b.bucket_counts.clone())),
+        negative: data_point
+            .negative
+            .as_ref()
+            .map(|b| (b.offset, b.bucket_counts.clone())),
+    };
+    let max_scale = get_config().limit.metrics_exp_histogram_max_scale;
+    for (le, val) in hist.cumulative_buckets(max_scale) {
+        let mut bucket_rec = rec.clone();
+        bucket_rec[NAME_LABEL] = format!("{}_bucket", rec[NAME_LABEL].as_str().unwrap()).into();
+        bucket_rec[VALUE_LABEL] = val.into();
+        bucket_rec["le"] = format_le(le).into();
+        bucket_recs.push(bucket_rec);
     }
 
     bucket_recs
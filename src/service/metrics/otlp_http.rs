@@ -46,9 +46,12 @@ use crate::{
     },
     handler::http::request::CONTENT_TYPE_JSON,
     service::{
-        db, format_stream_name,
+        db, entities, format_stream_name,
         ingestion::{evaluate_trigger, get_val_for_attr, write_file, TriggerAlertData},
-        metrics::{format_label_name, get_exclude_labels, otlp_grpc::handle_grpc_request},
+        metrics::{
+            exp_histogram::ExpHistogram, format_label_name, format_le, get_exclude_labels,
+            otlp_grpc::handle_grpc_request,
+        },
         schema::{check_for_schema, stream_schema_exists},
         usage::report_request_usage_stats,
     },
@@ -60,7 +63,15 @@ pub async fn metrics_proto_handler(
     org_id: &str,
     body: web::Bytes,
 ) -> Result<HttpResponse, std::io::Error> {
-    let request = ExportMetricsServiceRequest::decode(body).expect("Invalid protobuf");
+    let request = match ExportMetricsServiceRequest::decode(body) {
+        Ok(v) => v,
+        Err(e) => {
+            return Ok(HttpResponse::BadRequest().json(MetaHttpResponse::error(
+                http::StatusCode::BAD_REQUEST.into(),
+                format!("Invalid proto: {}", e),
+            )));
+        }
+    };
     match handle_grpc_request(org_id, request, false).await {
         Ok(res) => Ok(res),
         Err(e) => {
@@ -97,8 +108,15 @@ pub async fn metrics_json_handler(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -116,6 +134,7 @@ pub async fn metrics_json_handler(
     let mut stream_alerts_map: HashMap<String, Vec<Alert>> = HashMap::new();
     let mut stream_trigger_map: HashMap<String, Option<TriggerAlertData>> = HashMap::new();
     let mut stream_partitioning_map: HashMap<String, PartitioningDetails> = HashMap::new();
+    let mut stream_resolution_map: HashMap<String, i64> = HashMap::new();
 
     let body: json::Value = match json::from_slice(body.as_ref()) {
         Ok(v) => v,
@@ -161,11 +180,16 @@ pub async fn metrics_json_handler(
                             SERVICE,
                             format_label_name(local_attr.get("key").unwrap().as_str().unwrap())
                         ),
-                        get_val_for_attr(local_attr.get("value").unwrap()),
+                        local_attr
+                            .get("value")
+                            .map(get_val_for_attr)
+                            .unwrap_or_default(),
                     );
                 }
             }
         }
+        let resource_entities =
+            entities::from_attributes(service_att_map.iter().map(|(k, v)| (k.as_str(), v)));
         let inst_resources = if res_metric.get("scopeMetrics").is_some() {
             res_metric.get("scopeMetrics").unwrap().as_array().unwrap()
         } else {
@@ -178,6 +202,7 @@ pub async fn metrics_json_handler(
                     // parse metadata
                     let metric_name =
                         &format_stream_name(metric.get("name").unwrap().as_str().unwrap());
+                    entities::observe(org_id, StreamType::Metrics, metric_name, &resource_entities);
 
                     // check for schema
                     let schema_exists = stream_schema_exists(
@@ -257,10 +282,15 @@ pub async fn metrics_json_handler(
                             .unwrap()
                             .to_owned(),
                         unit: metric.get("unit").unwrap().as_str().unwrap().to_owned(),
+                        original_unit: "".to_string(),
                     };
+                    let unit_factor = super::units::normalize_metadata(
+                        &mut metadata,
+                        &cfg.common.metrics_unit_normalization,
+                    );
                     let mut prom_meta: HashMap<String, String> = HashMap::new();
 
-                    let records = if metric.get("sum").is_some() {
+                    let mut records = if metric.get("sum").is_some() {
                         let sum = metric.get("sum").unwrap().as_object().unwrap();
                         process_sum(&mut rec, sum, &mut metadata, &mut prom_meta)
                     } else if metric.get("histogram").is_some() {
@@ -282,9 +312,14 @@ pub async fn metrics_json_handler(
                     } else {
                         continue;
                     };
+                    if let Some(factor) = unit_factor {
+                        super::units::apply(&mut records, metric_name, factor);
+                    }
 
-                    // udpate schema metadata
-                    if !schema_exists.has_metadata {
+                    // udpate schema metadata, also when the description or unit changed
+                    if !schema_exists.has_metadata
+                        || super::is_metadata_changed(org_id, metric_name, &metadata).await
+                    {
                         if let Err(e) =
                             update_setting(org_id, metric_name, StreamType::Metrics, prom_meta)
                                 .await
@@ -376,6 +411,19 @@ pub async fn metrics_json_handler(
                             .unwrap()
                             .as_i64()
                             .unwrap_or(Utc::now().timestamp_micros());
+                        let resolution = super::get_resolution(
+                            &mut stream_resolution_map,
+                            org_id,
+                            local_metric_name,
+                        )
+                        .await;
+                        let timestamp = super::align_timestamp(timestamp, resolution);
+                        if resolution > 0 {
+                            val_map.insert(
+                                cfg.common.column_timestamp.clone(),
+                                json::Value::Number(timestamp.into()),
+                            );
+                        }
 
                         let value_str = json::to_string(&val_map).unwrap();
 
@@ -743,8 +791,7 @@ fn get_data_points(
 fn process_data_point(rec: &mut json::Value, data_point: &json::Map<String, json::Value>) {
     for attr in data_point
         .get("attributes")
-        .unwrap()
-        .as_array()
+        .and_then(|v| v.as_array())
         .unwrap_or(&vec![])
     {
         let attr = attr.as_object().unwrap();
@@ -781,8 +828,7 @@ fn process_hist_data_point(
 
     for attr in data_point
         .get("attributes")
-        .unwrap()
-        .as_array()
+        .and_then(|v| v.as_array())
         .unwrap_or(&vec![])
     {
         let attr = attr.as_object().unwrap();
@@ -836,7 +882,7 @@ fn process_hist_data_point(
             bucket_rec["le"] = (*val.to_string()).into()
         }
         if i == len - 1 {
-            bucket_rec["le"] = std::f64::INFINITY.to_string().into();
+            bucket_rec["le"] = format_le(f64::INFINITY).into();
         }
         bucket_recs.push(bucket_rec);
     }
@@ -851,8 +897,7 @@ fn process_exp_hist_data_point(
 
     for attr in data_point
         .get("attributes")
-        .unwrap()
-        .as_array()
+        .and_then(|v| v.as_array())
         .unwrap_or(&vec![])
     {
         let attr = attr.as_object().unwrap();
@@ -889,48 +934,53 @@ fn process_exp_hist_data_point(
     sum_rec[NAME_LABEL] = format!("{}_sum", sum_rec[NAME_LABEL].as_str().unwrap()).into();
     bucket_recs.push(sum_rec);
 
-    let base = 2 ^ (2 ^ -data_point.get("scale").unwrap().as_i64().unwrap());
-    // add negative bucket records
-    if let Some(buckets) = data_point.get("negative") {
-        let buckets = buckets.as_object().unwrap();
-        let offset = buckets.get("offset").unwrap().as_i64().unwrap();
-        for (i, val) in buckets
-            .get("bucket_counts")
-            .unwrap()
-            .as_array()
-            .unwrap()
-            .iter()
-            .enumerate()
-        {
-            let mut bucket_rec = rec.clone();
-            bucket_rec[NAME_LABEL] = format!("{}_bucket", rec[NAME_LABEL].as_str().unwrap()).into();
-            bucket_rec[VALUE_LABEL] = json::get_float_value(val).into();
-            bucket_rec["le"] = (base ^ (offset + (i as i64) + 1)).to_string().into();
-            bucket_recs.push(bucket_rec);
-        }
-    }
-    // add positive bucket records
-    if let Some(buckets) = data_point.get("positive") {
-        let buckets = buckets.as_object().unwrap();
-        let offset = buckets.get("offset").unwrap().as_i64().unwrap();
-        for (i, val) in buckets
-            .get("bucket_counts")
-            .unwrap()
-            .as_array()
-            .unwrap()
-            .iter()
-            .enumerate()
-        {
-            let mut bucket_rec = rec.clone();
-            bucket_rec[VALUE_LABEL] = json::get_float_value(val).into();
-            bucket_rec["le"] = (base ^ (offset + (i as i64) + 1)).to_string().into();
-            bucket_recs.push(bucket_rec);
-        }
+    // add bucket records, as cumulative counts with an `le` upper bound
+    let hist = ExpHistogram {
+        scale: data_point
+            .get("scale")
+            .map(json::get_int_value)
+            .unwrap_or(0) as i32,
+        count: data_point
+            .get("count")
+            .map(json::get_uint_value)
+            .unwrap_or(0),
+        zero_count: data_point
+            .get("zeroCount")
+            .map(json::get_uint_value)
+            .unwrap_or(0),
+        zero_threshold: data_point
+            .get("zeroThreshold")
+            .map(json::get_float_value)
+            .unwrap_or(0.0),
+        positive: get_exp_buckets(data_point.get("positive")),
+        negative: get_exp_buckets(data_point.get("negative")),
+    };
+    let max_scale = get_config().limit.metrics_exp_histogram_max_scale;
+    for (le, val) in hist.cumulative_buckets(max_scale) {
+        let mut bucket_rec = rec.clone();
+        bucket_rec[NAME_LABEL] = format!("{}_bucket", rec[NAME_LABEL].as_str().unwrap()).into();
+        bucket_rec[VALUE_LABEL] = val.into();
+        bucket_rec["le"] = format_le(le).into();
+        bucket_recs.push(bucket_rec);
     }
 
     bucket_recs
 }
 
+/// Reads the `offset` and `bucketCounts` of an exponential histogram bucket
+/// range. Counts are uint64, which the OTLP JSON encoding may send as strings.
+fn get_exp_buckets(buckets: Option<&json::Value>) -> Option<(i32, Vec<u64>)> {
+    let buckets = buckets?.as_object()?;
+    let offset = buckets.get("offset").map(json::get_int_value).unwrap_or(0) as i32;
+    let counts = buckets
+        .get("bucketCounts")
+        .or_else(|| buckets.get("bucket_counts"))
+        .and_then(|v| v.as_array())
+        .map(|v| v.iter().map(json::get_uint_value).collect())
+        .unwrap_or_default();
+    Some((offset, counts))
+}
+
 fn process_summary_data_point(
     rec: &mut json::Value,
     data_point: &json::Map<String, json::Value>,
@@ -939,8 +989,7 @@ fn process_summary_data_point(
 
     for attr in data_point
         .get("attributes")
-        .unwrap()
-        .as_array()
+        .and_then(|v| v.as_array())
         .unwrap_or(&vec![])
     {
         let attr = attr.as_object().unwrap();
@@ -1171,4 +1220,40 @@ mod tests {
             expected_lens
         );
     }
+
+    #[test]
+    fn test_process_exp_hist_data_point() {
+        let dp = json!({
+            "attributes": [],
+            "timeUnixNano": "1620000000000",
+            "count": "6",
+            "sum": 12.5,
+            "scale": 0,
+            "zeroCount": "1",
+            "positive": {"offset": 1, "bucketCounts": ["2", "3"]}
+        });
+        let result =
+            process_exp_hist_data_point(&mut json!({"__name__": "test"}), dp.as_object().unwrap());
+        // count, sum, zero bucket, two positive buckets and +Inf
+        assert_eq!(result.len(), 6);
+        let buckets = result[2..]
+            .iter()
+            .map(|r| {
+                (
+                    r["le"].as_str().unwrap().to_string(),
+                    r["value"].as_f64().unwrap(),
+                )
+            })
+            .collect::<Vec<_>>();
+        assert_eq!(
+            buckets,
+            vec![
+                ("0".to_string(), 1.0),
+                ("4".to_string(), 3.0),
+                ("8".to_string(), 6.0),
+                ("inf".to_string(), 6.0),
+            ]
+        );
+        assert_eq!(result[2]["__name__"], json!("test_bucket"));
+    }
 }
@@ -76,8 +76,10 @@ pub async fn remote_write(
         ));
     }
 
+    crate::service::residency::check_local(org_id).map_err(|e| anyhow::anyhow!(e))?;
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Err(anyhow::Error::msg(e.to_string()));
     }
 
@@ -97,6 +99,7 @@ pub async fn remote_write(
     let mut stream_trigger_map: HashMap<String, Option<TriggerAlertData>> = HashMap::new();
     let mut stream_transform_map: HashMap<String, Vec<StreamTransform>> = HashMap::new();
     let mut stream_partitioning_map: HashMap<String, PartitioningDetails> = HashMap::new();
+    let mut stream_resolution_map: HashMap<String, i64> = HashMap::new();
 
     let decoded = snap::raw::Decoder::new()
         .decompress_vec(&body)
@@ -112,7 +115,12 @@ pub async fn remote_write(
             metric_type: item.r#type().into(),
             help: item.help.clone(),
             unit: item.unit.clone(),
+            original_unit: "".to_string(),
         };
+        // senders repeat the metadata in every request, only store it when it changes
+        if !super::is_metadata_changed(org_id, &metric_name, &metadata).await {
+            continue;
+        }
         let mut extra_metadata: HashMap<String, String> = HashMap::new();
         extra_metadata.insert(
             METADATA_LABEL.to_string(),
@@ -200,7 +208,10 @@ pub async fn remote_write(
                 value: sample_val,
             };
 
-            let timestamp = parse_i64_to_timestamp_micros(sample.timestamp);
+            let timestamp = super::align_timestamp(
+                parse_i64_to_timestamp_micros(sample.timestamp),
+                super::get_resolution(&mut stream_resolution_map, org_id, &metric_name).await,
+            );
 
             if first_line && dedup_enabled && !cluster_name.is_empty() {
                 let lock = METRIC_CLUSTER_LEADER.read().await;
@@ -479,10 +490,10 @@ pub(crate) async fn get_metadata(org_id: &str, req: RequestMetadata) -> Result<R
             .unwrap();
         let mut resp = hashbrown::HashMap::new();
         if schema != Schema::empty() {
-            resp.insert(
-                metric_name,
-                get_metadata_object(&schema).map_or_else(Vec::new, |obj| vec![obj]),
-            );
+            let objs = get_metadata_object(&schema)
+                .filter(|obj| req.matches(&metric_name, obj))
+                .map_or_else(Vec::new, |obj| vec![obj]);
+            resp.insert(metric_name, objs);
         };
         return Ok(resp);
     }
@@ -521,7 +532,9 @@ pub(crate) async fn get_metadata(org_id: &str, req: RequestMetadata) -> Result<R
                 if histogram_summary_sub.contains(&schema.stream_name) {
                     None
                 } else {
-                    get_metadata_object(&schema.schema).map(|meta| (schema.stream_name, vec![meta]))
+                    get_metadata_object(&schema.schema)
+                        .filter(|meta| req.matches(&schema.stream_name, meta))
+                        .map(|meta| (schema.stream_name, vec![meta]))
                 }
             });
             Ok(match req.limit {
@@ -627,6 +640,7 @@ pub(crate) async fn get_series(
         clusters: vec![],
         timeout: 0,
         search_type: None,
+        search_event_context: None,
     };
     let series = match search_service::search("", org_id, StreamType::Metrics, None, &req).await {
         Err(err) => {
@@ -773,6 +787,7 @@ pub(crate) async fn get_label_values(
         clusters: vec![],
         timeout: 0,
         search_type: None,
+        search_event_context: None,
     };
     let mut label_values = match search_service::search("", org_id, stream_type, None, &req).await {
         Ok(resp) => resp
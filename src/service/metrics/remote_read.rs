@@ -0,0 +1,364 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Prometheus remote read protocol, lets a Prometheus server read the raw
+//! samples of the metrics stored in OpenObserve.
+//! refer: https://prometheus.io/docs/prometheus/latest/querying/remote_read_api/
+
+use std::collections::{BTreeMap, HashMap};
+
+use actix_web::web;
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding},
+        stream::StreamType,
+    },
+    utils::json,
+};
+use prost::Message;
+use proto::prometheus_rpc::{
+    label_matcher::Type as MatchType, read_request::ResponseType, Label, LabelMatcher, QueryResult,
+    ReadRequest, ReadResponse, Sample, TimeSeries,
+};
+use regex::Regex;
+
+use crate::{
+    common::meta::prom::{HASH_LABEL, NAME_LABEL, VALUE_LABEL},
+    service::{db, search as search_service},
+};
+
+const PAGE_SIZE: i64 = 10000;
+
+/// Answers a snappy compressed `ReadRequest` with a snappy compressed
+/// `ReadResponse` holding the raw samples of the matched series.
+pub async fn remote_read(org_id: &str, body: web::Bytes) -> Result<Vec<u8>, anyhow::Error> {
+    let decoded = snap::raw::Decoder::new()
+        .decompress_vec(&body)
+        .map_err(|e| anyhow::anyhow!("Invalid snappy compressed data: {}", e.to_string()))?;
+    let request = ReadRequest::decode(bytes::Bytes::from(decoded))
+        .map_err(|e| anyhow::anyhow!("Invalid protobuf: {}", e.to_string()))?;
+    if !request.accepted_response_types.is_empty()
+        && !request
+            .accepted_response_types
+            .contains(&(ResponseType::Samples as i32))
+    {
+        return Err(anyhow::anyhow!(
+            "Unsupported response types, only SAMPLES is supported"
+        ));
+    }
+
+    let mut budget = get_config().prom.remote_read_max_samples;
+    let mut results = Vec::with_capacity(request.queries.len());
+    for query in request.queries.iter() {
+        let mut timeseries = Vec::new();
+        for metric_name in match_metric_names(org_id, &query.matchers).await? {
+            timeseries.extend(
+                read_metric(
+                    org_id,
+                    &metric_name,
+                    &query.matchers,
+                    query.start_timestamp_ms * 1000,
+                    // the end of the query is inclusive
+                    query.end_timestamp_ms * 1000 + 1,
+                    &mut budget,
+                )
+                .await?,
+            );
+        }
+        results.push(QueryResult { timeseries });
+    }
+
+    let resp = ReadResponse { results }.encode_to_vec();
+    snap::raw::Encoder::new()
+        .compress_vec(&resp)
+        .map_err(|e| anyhow::anyhow!("Snappy compress error: {}", e.to_string()))
+}
+
+/// Returns the metric streams matching the `__name__` matchers of the query
+async fn match_metric_names(
+    org_id: &str,
+    matchers: &[LabelMatcher],
+) -> Result<Vec<String>, anyhow::Error> {
+    let name_matchers = matchers
+        .iter()
+        .filter(|m| m.name == NAME_LABEL)
+        .collect::<Vec<_>>();
+    if let Some(m) = name_matchers.iter().find(|m| m.r#type() == MatchType::Eq) {
+        return Ok(vec![m.value.clone()]);
+    }
+    if name_matchers.is_empty() {
+        return Err(anyhow::anyhow!("The query should select a metric name"));
+    }
+    let name_matchers = name_matchers
+        .into_iter()
+        .map(Matcher::new)
+        .collect::<Result<Vec<_>, _>>()?;
+    let mut names = db::schema::list_streams_from_cache(org_id, StreamType::Metrics)
+        .await
+        .into_iter()
+        .filter(|name| name_matchers.iter().all(|m| m.is_match(name)))
+        .collect::<Vec<_>>();
+    names.sort();
+    Ok(names)
+}
+
+async fn read_metric(
+    org_id: &str,
+    metric_name: &str,
+    matchers: &[LabelMatcher],
+    start_time: i64,
+    end_time: i64,
+    budget: &mut i64,
+) -> Result<Vec<TimeSeries>, anyhow::Error> {
+    let schema = infra::schema::get(org_id, metric_name, StreamType::Metrics).await?;
+    if schema.fields().is_empty() {
+        return Ok(vec![]);
+    }
+
+    let mut sql_where = Vec::new();
+    for m in matchers.iter().filter(|m| m.name != NAME_LABEL) {
+        if schema.field_with_name(&m.name).is_ok() {
+            sql_where.push(matcher_to_sql(m));
+        } else if !Matcher::new(m)?.is_match("") {
+            // a missing label has the empty value, no series can match
+            return Ok(vec![]);
+        }
+    }
+    let cfg = get_config();
+    let mut sql = format!("SELECT * FROM \"{metric_name}\"");
+    if !sql_where.is_empty() {
+        sql.push_str(" WHERE ");
+        sql.push_str(&sql_where.join(" AND "));
+    }
+
+    let mut series: BTreeMap<String, TimeSeries> = BTreeMap::new();
+    let mut from = 0;
+    loop {
+        let size = std::cmp::min(PAGE_SIZE, *budget);
+        if size <= 0 {
+            return Err(anyhow::anyhow!(
+                "The query exceeds the limit of {} samples",
+                cfg.prom.remote_read_max_samples
+            ));
+        }
+        let req = Request {
+            query: Query {
+                sql: sql.clone(),
+                from,
+                size,
+                start_time,
+                end_time,
+                sort_by: Some(format!("{} ASC", cfg.common.column_timestamp)),
+                sql_mode: "full".to_string(),
+                ..Default::default()
+            },
+            aggs: HashMap::new(),
+            encoding: RequestEncoding::Empty,
+            regions: vec![],
+            clusters: vec![],
+            timeout: 0,
+            search_type: None,
+            search_event_context: None,
+        };
+        let resp = search_service::search("", org_id, StreamType::Metrics, None, &req).await?;
+        let hits = resp.hits.len() as i64;
+        for hit in resp.hits {
+            let json::Value::Object(row) = hit else {
+                continue;
+            };
+            let Some((key, sample)) = to_sample(metric_name, &row, &cfg.common.column_timestamp)
+            else {
+                continue;
+            };
+            series
+                .entry(key)
+                .or_insert_with(|| TimeSeries {
+                    labels: to_labels(metric_name, &row, &cfg.common.column_timestamp),
+                    ..Default::default()
+                })
+                .samples
+                .push(sample);
+        }
+        *budget -= hits;
+        if hits < size {
+            break;
+        }
+        from += hits;
+    }
+    Ok(series.into_values().collect())
+}
+
+/// Returns the series key of the row together with its sample
+fn to_sample(
+    metric_name: &str,
+    row: &json::Map<String, json::Value>,
+    column_timestamp: &str,
+) -> Option<(String, Sample)> {
+    let value = row.get(VALUE_LABEL)?.as_f64()?;
+    let timestamp = row.get(column_timestamp)?.as_i64()? / 1000;
+    let key = match row.get(HASH_LABEL).and_then(|v| v.as_str()) {
+        Some(hash) => hash.to_string(),
+        None => to_labels(metric_name, row, column_timestamp)
+            .into_iter()
+            .map(|l| format!("{}={}", l.name, l.value))
+            .collect::<Vec<_>>()
+            .join(","),
+    };
+    Some((key, Sample { value, timestamp }))
+}
+
+/// Returns the labels of the row sorted by name, as required by Prometheus
+fn to_labels(
+    metric_name: &str,
+    row: &json::Map<String, json::Value>,
+    column_timestamp: &str,
+) -> Vec<Label> {
+    let mut labels = row
+        .iter()
+        .filter(|(name, _)| {
+            name.as_str() != column_timestamp
+                && name.as_str() != VALUE_LABEL
+                && name.as_str() != HASH_LABEL
+                && name.as_str() != NAME_LABEL
+        })
+        .filter_map(|(name, value)| {
+            let value = match value {
+                json::Value::String(v) if !v.is_empty() => v.clone(),
+                json::Value::Number(v) => v.to_string(),
+                json::Value::Bool(v) => v.to_string(),
+                _ => return None,
+            };
+            Some(Label {
+                name: name.clone(),
+                value,
+            })
+        })
+        .collect::<Vec<_>>();
+    labels.push(Label {
+        name: NAME_LABEL.to_string(),
+        value: metric_name.to_string(),
+    });
+    labels.sort_by(|a, b| a.name.cmp(&b.name));
+    labels
+}
+
+fn matcher_to_sql(m: &LabelMatcher) -> String {
+    let value = m.value.replace('\'', "''");
+    match m.r#type() {
+        MatchType::Eq if value.is_empty() => format!("(\"{0}\" IS NULL OR \"{0}\" = '')", m.name),
+        MatchType::Eq => format!("\"{}\" = '{}'", m.name, value),
+        MatchType::Neq if value.is_empty() => {
+            format!("(\"{0}\" IS NOT NULL AND \"{0}\" != '')", m.name)
+        }
+        MatchType::Neq => format!("\"{}\" != '{}'", m.name, value),
+        // Prometheus regular expressions are fully anchored
+        MatchType::Re => format!("re_match(\"{}\", '^(?:{})$')", m.name, value),
+        MatchType::Nre => format!("re_not_match(\"{}\", '^(?:{})$')", m.name, value),
+    }
+}
+
+/// Label matcher evaluated in memory, for the metric names and the labels
+/// missing in the stream
+struct Matcher {
+    op: MatchType,
+    value: String,
+    re: Option<Regex>,
+}
+
+impl Matcher {
+    fn new(m: &LabelMatcher) -> Result<Self, anyhow::Error> {
+        let re = match m.r#type() {
+            MatchType::Re | MatchType::Nre => Some(
+                Regex::new(&format!("^(?:{})$", m.value))
+                    .map_err(|e| anyhow::anyhow!("Invalid regex of label {}: {e}", m.name))?,
+            ),
+            _ => None,
+        };
+        Ok(Self {
+            op: m.r#type(),
+            value: m.value.clone(),
+            re,
+        })
+    }
+
+    fn is_match(&self, value: &str) -> bool {
+        match self.op {
+            MatchType::Eq => self.value == value,
+            MatchType::Neq => self.value != value,
+            MatchType::Re => self.re.as_ref().unwrap().is_match(value),
+            MatchType::Nre => !self.re.as_ref().unwrap().is_match(value),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn matcher(name: &str, op: MatchType, value: &str) -> LabelMatcher {
+        LabelMatcher {
+            r#type: op as i32,
+            name: name.to_string(),
+            value: value.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_matcher() {
+        let m = Matcher::new(&matcher("job", MatchType::Re, "api|web")).unwrap();
+        assert!(m.is_match("api"));
+        assert!(!m.is_match("api_server"));
+        let m = Matcher::new(&matcher("job", MatchType::Neq, "")).unwrap();
+        assert!(!m.is_match(""));
+        assert!(Matcher::new(&matcher("job", MatchType::Re, "(")).is_err());
+    }
+
+    #[test]
+    fn test_matcher_to_sql() {
+        assert_eq!(
+            matcher_to_sql(&matcher("job", MatchType::Eq, "o'2")),
+            "\"job\" = 'o''2'"
+        );
+        assert_eq!(
+            matcher_to_sql(&matcher("job", MatchType::Nre, "api.*")),
+            "re_not_match(\"job\", '^(?:api.*)$')"
+        );
+    }
+
+    #[test]
+    fn test_to_labels() {
+        let row = json::json!({
+            "_timestamp": 1700000000000000i64,
+            "__hash__": "123",
+            "value": 1.5,
+            "job": "api",
+            "instance": "",
+        });
+        let row = row.as_object().unwrap();
+        let labels = to_labels("up", row, "_timestamp");
+        assert_eq!(
+            labels
+                .iter()
+                .map(|l| format!("{}={}", l.name, l.value))
+                .collect::<Vec<_>>(),
+            vec!["__name__=up", "job=api"]
+        );
+        let (key, sample) = to_sample("up", row, "_timestamp").unwrap();
+        assert_eq!(key, "123");
+        assert_eq!(sample.timestamp, 1700000000000);
+        assert_eq!(sample.value, 1.5);
+    }
+}
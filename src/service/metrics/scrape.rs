@@ -0,0 +1,653 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Pull based collection of the metrics exposed by Prometheus/OpenMetrics
+//! targets. The targets are spread over the ingesters, every ingester scrapes
+//! its share and writes the samples like a Prometheus remote write.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    cluster::{is_single_node, LOCAL_NODE_ROLE, LOCAL_NODE_UUID},
+    get_config,
+    utils::json,
+};
+use prost::Message;
+use proto::prometheus_rpc::{
+    metric_metadata::MetricType, Label, MetricMetadata, Sample, TimeSeries, WriteRequest,
+};
+
+use crate::{
+    common::{
+        infra::{cluster::get_ingester_by_key, kubernetes},
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            prom::NAME_LABEL,
+            scrape::{
+                is_dns_label, KubernetesDiscovery, ScrapeConfig, ScrapeConfigList, ScrapeTarget,
+                ScrapeTargetList, ANNOTATION_PATH, ANNOTATION_PORT, ANNOTATION_SCHEME,
+                ANNOTATION_SCRAPE,
+            },
+        },
+        utils::auth::is_root_user,
+    },
+    service::db,
+};
+
+/// Seconds between two reloads of the scrape configs
+const CONFIG_RELOAD_INTERVAL: i64 = 30;
+
+const ACCEPT_HEADER: &str =
+    "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1";
+
+/// Saves the scrape config. The kubernetes discovery lists the pods of the
+/// whole cluster, only the root user can set it up unless
+/// `ZO_PROMETHEUS_SCRAPE_KUBERNETES_ENABLED` opens it to every organization.
+#[tracing::instrument]
+pub async fn save_config(
+    org_id: &str,
+    user_id: &str,
+    mut config: ScrapeConfig,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    config.name = config.name.trim().to_string();
+    if let Err(e) = config.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if config.kubernetes.is_some()
+        && !get_config().prom.scrape_kubernetes_enabled
+        && !is_root_user(user_id)
+    {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the root user can set up the kubernetes discovery",
+        ));
+    }
+    let exists = db::scrape::get(org_id, &config.name).await.is_ok();
+    if create && exists {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Scrape config {} already exists",
+            config.name
+        )));
+    }
+    if !create && !exists {
+        return Ok(MetaHttpResponse::not_found("Scrape config not found"));
+    }
+    match db::scrape::set(org_id, &config).await {
+        Ok(_) => Ok(MetaHttpResponse::json(config)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_configs(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::scrape::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(ScrapeConfigList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_config_by_name(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::scrape::get(org_id, name).await {
+        Ok(config) => Ok(MetaHttpResponse::json(config)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Scrape config not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_config(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::scrape::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Scrape config not found"));
+    }
+    match db::scrape::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Scrape config deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Lists the current targets of the config together with the ingester
+/// scraping them
+#[tracing::instrument]
+pub async fn list_targets(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    let config = match db::scrape::get(org_id, name).await {
+        Ok(config) => config,
+        Err(_) => return Ok(MetaHttpResponse::not_found("Scrape config not found")),
+    };
+    match discover_targets(&config).await {
+        Ok(mut list) => {
+            for target in list.iter_mut() {
+                target.node = get_ingester_by_key(&target_key(org_id, &config.name, target))
+                    .await
+                    .unwrap_or_default();
+            }
+            Ok(MetaHttpResponse::json(ScrapeTargetList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Keeps the scrape configs and the time each one is due next
+#[derive(Default)]
+pub struct Scheduler {
+    configs: Vec<(String, ScrapeConfig)>,
+    reloaded_at: i64,
+    next_scrapes: HashMap<String, i64>,
+}
+
+impl Scheduler {
+    /// Scrapes the targets of the configs which are due, owned by this node
+    pub async fn run(&mut self) -> Result<(), anyhow::Error> {
+        let now = Utc::now().timestamp();
+        if now - self.reloaded_at >= CONFIG_RELOAD_INTERVAL {
+            self.configs = db::scrape::list_all().await?;
+            self.reloaded_at = now;
+        }
+
+        for (org_id, config) in self.configs.iter() {
+            if !config.enabled {
+                continue;
+            }
+            let key = format!("{org_id}/{}", config.name);
+            if self.next_scrapes.get(&key).is_some_and(|t| *t > now) {
+                continue;
+            }
+            self.next_scrapes.insert(key, now + config.interval as i64);
+
+            let targets = match discover_targets(config).await {
+                Ok(targets) => targets,
+                Err(e) => {
+                    log::error!("[SCRAPE] {org_id}/{} discovery error: {e}", config.name);
+                    continue;
+                }
+            };
+            for target in targets {
+                if !is_single_node(&LOCAL_NODE_ROLE)
+                    && get_ingester_by_key(&target_key(org_id, &config.name, &target)).await
+                        != Some(LOCAL_NODE_UUID.clone())
+                {
+                    continue;
+                }
+                let org_id = org_id.clone();
+                let config = config.clone();
+                tokio::task::spawn(async move {
+                    if let Err(e) = scrape_target(&org_id, &config, &target).await {
+                        log::error!(
+                            "[SCRAPE] {org_id}/{} write samples of {} error: {e}",
+                            config.name,
+                            target.url
+                        );
+                    }
+                });
+            }
+        }
+        // forget the deleted configs
+        let keys = self
+            .configs
+            .iter()
+            .map(|(org_id, config)| format!("{org_id}/{}", config.name))
+            .collect::<std::collections::HashSet<_>>();
+        self.next_scrapes.retain(|key, _| keys.contains(key));
+        Ok(())
+    }
+}
+
+fn target_key(org_id: &str, job: &str, target: &ScrapeTarget) -> String {
+    format!("{org_id}/{job}/{}", target.url)
+}
+
+async fn discover_targets(config: &ScrapeConfig) -> Result<Vec<ScrapeTarget>, anyhow::Error> {
+    let mut targets = config
+        .static_targets
+        .iter()
+        .map(|instance| ScrapeTarget {
+            instance: instance.clone(),
+            url: format!("{}://{instance}{}", config.scheme, config.metrics_path),
+            ..Default::default()
+        })
+        .collect::<Vec<_>>();
+    if let Some(sd) = config.kubernetes.as_ref() {
+        targets.extend(discover_pods(config, sd).await?);
+    }
+    Ok(targets)
+}
+
+async fn discover_pods(
+    config: &ScrapeConfig,
+    sd: &KubernetesDiscovery,
+) -> Result<Vec<ScrapeTarget>, anyhow::Error> {
+    if !sd.namespace.is_empty() && !is_dns_label(&sd.namespace) {
+        return Err(anyhow::anyhow!("invalid namespace {}", sd.namespace));
+    }
+    let client = kubernetes::Client::in_cluster()?;
+    let path = if sd.namespace.is_empty() {
+        "/api/v1/pods".to_string()
+    } else {
+        format!("/api/v1/namespaces/{}/pods", sd.namespace)
+    };
+    let mut query = vec![("fieldSelector", "status.phase=Running")];
+    if !sd.label_selector.is_empty() {
+        query.push(("labelSelector", sd.label_selector.as_str()));
+    }
+    let pods = client.get(&path, &query).await?;
+    Ok(pods
+        .get("items")
+        .and_then(|v| v.as_array())
+        .map(|items| {
+            items
+                .iter()
+                .filter_map(|pod| pod_target(config, pod))
+                .collect()
+        })
+        .unwrap_or_default())
+}
+
+/// Returns the target of the pod when it is annotated to be scraped
+fn pod_target(config: &ScrapeConfig, pod: &json::Value) -> Option<ScrapeTarget> {
+    let metadata = pod.get("metadata")?;
+    let annotations = metadata.get("annotations")?;
+    let annotation = |name: &str| annotations.get(name).and_then(|v| v.as_str());
+    if annotation(ANNOTATION_SCRAPE) != Some("true") {
+        return None;
+    }
+    let ip = pod.pointer("/status/podIP")?.as_str()?;
+    let port = match annotation(ANNOTATION_PORT) {
+        Some(port) => port.parse::<u16>().ok()?,
+        None => pod
+            .pointer("/spec/containers")?
+            .as_array()?
+            .iter()
+            .filter_map(|c| c.get("ports")?.as_array()?.first()?.get("containerPort"))
+            .find_map(|port| port.as_u64())? as u16,
+    };
+    let instance = if ip.contains(':') {
+        format!("[{ip}]:{port}")
+    } else {
+        format!("{ip}:{port}")
+    };
+    let scheme = annotation(ANNOTATION_SCHEME).unwrap_or(&config.scheme);
+    let path = annotation(ANNOTATION_PATH).unwrap_or(&config.metrics_path);
+    let mut labels = HashMap::new();
+    for (label, field) in [("namespace", "namespace"), ("pod", "name")] {
+        if let Some(v) = metadata.get(field).and_then(|v| v.as_str()) {
+            labels.insert(label.to_string(), v.to_string());
+        }
+    }
+    Some(ScrapeTarget {
+        url: format!("{scheme}://{instance}{path}"),
+        instance,
+        labels,
+        ..Default::default()
+    })
+}
+
+async fn scrape_target(
+    org_id: &str,
+    config: &ScrapeConfig,
+    target: &ScrapeTarget,
+) -> Result<(), anyhow::Error> {
+    let start = std::time::Instant::now();
+    let now = Utc::now().timestamp_millis();
+    let (mut timeseries, metadata, up) = match fetch(config, target).await {
+        Ok((body, openmetrics)) => {
+            let (timeseries, metadata) = parse_exposition(&body, openmetrics, now);
+            (timeseries, metadata, 1.0)
+        }
+        Err(e) => {
+            log::warn!(
+                "[SCRAPE] {org_id}/{} scrape {} error: {e}",
+                config.name,
+                target.url
+            );
+            (vec![], vec![], 0.0)
+        }
+    };
+    let samples = timeseries.len();
+    for (name, value) in [
+        ("up", up),
+        ("scrape_duration_seconds", start.elapsed().as_secs_f64()),
+        ("scrape_samples_scraped", samples as f64),
+    ] {
+        timeseries.push(TimeSeries {
+            labels: vec![Label {
+                name: NAME_LABEL.to_string(),
+                value: name.to_string(),
+            }],
+            samples: vec![Sample {
+                value,
+                timestamp: now,
+            }],
+            ..Default::default()
+        });
+    }
+    for series in timeseries.iter_mut() {
+        add_target_labels(&mut series.labels, config, target);
+    }
+
+    let req = WriteRequest {
+        timeseries,
+        metadata,
+    }
+    .encode_to_vec();
+    let body = snap::raw::Encoder::new().compress_vec(&req)?;
+    super::prom::remote_write(org_id, body.into()).await
+}
+
+async fn fetch(
+    config: &ScrapeConfig,
+    target: &ScrapeTarget,
+) -> Result<(String, bool), anyhow::Error> {
+    let resp = reqwest::Client::new()
+        .get(&target.url)
+        .header("Accept", ACCEPT_HEADER)
+        .header(
+            "X-Prometheus-Scrape-Timeout-Seconds",
+            config.timeout.to_string(),
+        )
+        .timeout(std::time::Duration::from_secs(config.timeout))
+        .send()
+        .await?;
+    if !resp.status().is_success() {
+        return Err(anyhow::anyhow!("server returned {}", resp.status()));
+    }
+    let openmetrics = resp
+        .headers()
+        .get("Content-Type")
+        .and_then(|v| v.to_str().ok())
+        .is_some_and(|v| v.starts_with("application/openmetrics-text"));
+    Ok((resp.text().await?, openmetrics))
+}
+
+/// Adds the job, instance and configured labels to the series, the scraped
+/// labels with the same names are kept with the `exported_` prefix like
+/// Prometheus does
+fn add_target_labels(labels: &mut Vec<Label>, config: &ScrapeConfig, target: &ScrapeTarget) {
+    let target_labels = config
+        .labels
+        .iter()
+        .chain(target.labels.iter())
+        .map(|(k, v)| (k.as_str(), v.as_str()))
+        .chain([
+            ("job", config.name.as_str()),
+            ("instance", target.instance.as_str()),
+        ]);
+    for (name, value) in target_labels {
+        if let Some(label) = labels.iter_mut().find(|l| l.name == name) {
+            if label.value == value {
+                continue;
+            }
+            label.name = format!("exported_{name}");
+        }
+        labels.push(Label {
+            name: name.to_string(),
+            value: value.to_string(),
+        });
+    }
+}
+
+/// Parses the Prometheus text format or the OpenMetrics text format. Samples
+/// without timestamp get `default_ts`, in milliseconds. Malformed lines are
+/// skipped.
+pub(crate) fn parse_exposition(
+    body: &str,
+    openmetrics: bool,
+    default_ts: i64,
+) -> (Vec<TimeSeries>, Vec<MetricMetadata>) {
+    let mut timeseries = Vec::new();
+    let mut metadata: Vec<MetricMetadata> = Vec::new();
+    for line in body.lines() {
+        let line = line.trim();
+        if line.is_empty() {
+            continue;
+        }
+        if let Some(comment) = line.strip_prefix('#') {
+            let mut parts = comment.trim_start().splitn(3, ' ');
+            let (Some(kind), Some(name)) = (parts.next(), parts.next()) else {
+                if comment.trim() == "EOF" {
+                    break;
+                }
+                continue;
+            };
+            let text = parts.next().unwrap_or_default().to_string();
+            let pos = match metadata.iter().position(|m| m.metric_family_name == name) {
+                Some(pos) => pos,
+                None if matches!(kind, "HELP" | "TYPE" | "UNIT") => {
+                    metadata.push(MetricMetadata {
+                        metric_family_name: name.to_string(),
+                        ..Default::default()
+                    });
+                    metadata.len() - 1
+                }
+                None => continue,
+            };
+            let m = &mut metadata[pos];
+            match kind {
+                "HELP" => m.help = unescape(&text),
+                "TYPE" => m.set_type(metric_type(&text)),
+                "UNIT" => m.unit = text,
+                _ => {}
+            }
+            continue;
+        }
+        match parse_sample(line, openmetrics, default_ts) {
+            Some(series) => timeseries.push(series),
+            None => log::debug!("[SCRAPE] skip malformed line: {line}"),
+        }
+    }
+    (timeseries, metadata)
+}
+
+fn metric_type(name: &str) -> MetricType {
+    match name {
+        "counter" => MetricType::Counter,
+        "gauge" => MetricType::Gauge,
+        "histogram" => MetricType::Histogram,
+        "gaugehistogram" => MetricType::Gaugehistogram,
+        "summary" => MetricType::Summary,
+        "info" => MetricType::Info,
+        "stateset" => MetricType::Stateset,
+        _ => MetricType::Unknown,
+    }
+}
+
+fn parse_sample(line: &str, openmetrics: bool, default_ts: i64) -> Option<TimeSeries> {
+    // drop the exemplar of the OpenMetrics format
+    let line = match line.find(" # ") {
+        Some(pos) if openmetrics => &line[..pos],
+        _ => line,
+    };
+    let name_end = line.find(|c: char| c == '{' || c.is_whitespace())?;
+    let name = &line[..name_end];
+    let mut labels = vec![Label {
+        name: NAME_LABEL.to_string(),
+        value: name.to_string(),
+    }];
+    let mut rest = &line[name_end..];
+    if rest.starts_with('{') {
+        let (parsed, remaining) = parse_labels(&rest[1..])?;
+        labels.extend(parsed);
+        rest = remaining;
+    }
+    let mut fields = rest.split_whitespace();
+    let value = parse_value(fields.next()?)?;
+    let timestamp = match fields.next() {
+        // OpenMetrics timestamps are in seconds
+        Some(ts) if openmetrics => (ts.parse::<f64>().ok()? * 1000.0) as i64,
+        Some(ts) => ts.parse::<i64>().ok()?,
+        None => default_ts,
+    };
+    Some(TimeSeries {
+        labels,
+        samples: vec![Sample { value, timestamp }],
+        ..Default::default()
+    })
+}
+
+/// Parses the labels after the opening brace, returns them with the rest of
+/// the line
+fn parse_labels(mut input: &str) -> Option<(Vec<Label>, &str)> {
+    let mut labels = Vec::new();
+    loop {
+        input = input.trim_start_matches([' ', ',']);
+        if let Some(rest) = input.strip_prefix('}') {
+            return Some((labels, rest));
+        }
+        let (name, rest) = input.split_once('=')?;
+        let rest = rest.trim_start().strip_prefix('"')?;
+        // find the closing quote, skipping the escaped characters
+        let mut end = None;
+        let mut escaped = false;
+        for (i, c) in rest.char_indices() {
+            match c {
+                '\\' if !escaped => escaped = true,
+                '"' if !escaped => {
+                    end = Some(i);
+                    break;
+                }
+                _ => escaped = false,
+            }
+        }
+        let end = end?;
+        labels.push(Label {
+            name: name.trim().to_string(),
+            value: unescape(&rest[..end]),
+        });
+        input = &rest[end + 1..];
+    }
+}
+
+fn parse_value(v: &str) -> Option<f64> {
+    match v {
+        "+Inf" | "Inf" => Some(f64::INFINITY),
+        "-Inf" => Some(f64::NEG_INFINITY),
+        "NaN" => Some(f64::NAN),
+        v => v.parse().ok(),
+    }
+}
+
+fn unescape(v: &str) -> String {
+    if !v.contains('\\') {
+        return v.to_string();
+    }
+    let mut out = String::with_capacity(v.len());
+    let mut chars = v.chars();
+    while let Some(c) = chars.next() {
+        if c != '\\' {
+            out.push(c);
+            continue;
+        }
+        match chars.next() {
+            Some('n') => out.push('\n'),
+            Some(c) => out.push(c),
+            None => out.push('\\'),
+        }
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn labels(series: &TimeSeries) -> Vec<String> {
+        series
+            .labels
+            .iter()
+            .map(|l| format!("{}={}", l.name, l.value))
+            .collect()
+    }
+
+    #[test]
+    fn test_parse_exposition() {
+        let body = r#"
+# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",path="/a\"b"} 3
+# A histogram
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="+Inf"} 5
+latency_seconds_sum 1.5
+broken{method="post" 1
+"#;
+        let (series, metadata) = parse_exposition(body, false, 100);
+        assert_eq!(series.len(), 4);
+        assert_eq!(
+            labels(&series[0]),
+            vec!["__name__=http_requests_total", "method=post", "code=200"]
+        );
+        assert_eq!(series[0].samples[0].value, 1027.0);
+        assert_eq!(series[0].samples[0].timestamp, 1395066363000);
+        assert_eq!(labels(&series[1])[2], "path=/a\"b");
+        assert_eq!(series[1].samples[0].timestamp, 100);
+        assert_eq!(series[2].samples[0].value, 3.0);
+        assert_eq!(series[3].samples[0].value, 1.5);
+
+        assert_eq!(metadata.len(), 2);
+        assert_eq!(metadata[0].r#type(), MetricType::Counter);
+        assert_eq!(metadata[0].help, "The total number of HTTP requests.");
+        assert_eq!(metadata[1].r#type(), MetricType::Histogram);
+    }
+
+    #[test]
+    fn test_parse_openmetrics() {
+        let body = "# TYPE foo counter\nfoo_total 17.0 1520879607.789 # {trace_id=\"KOO5S4vxi0o\"} 0.67\n# EOF\nbar 1\n";
+        let (series, _) = parse_exposition(body, true, 100);
+        assert_eq!(series.len(), 1);
+        assert_eq!(series[0].samples[0].timestamp, 1520879607789);
+    }
+
+    #[test]
+    fn test_add_target_labels() {
+        let config: ScrapeConfig =
+            json::from_str(r#"{"name":"node","static_targets":["node:9100"]}"#).unwrap();
+        let target = ScrapeTarget {
+            instance: "node:9100".to_string(),
+            ..Default::default()
+        };
+        let mut series = parse_sample(r#"up{job="other"} 1"#, false, 0).unwrap();
+        add_target_labels(&mut series.labels, &config, &target);
+        assert_eq!(
+            labels(&series),
+            vec![
+                "__name__=up",
+                "exported_job=other",
+                "job=node",
+                "instance=node:9100"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_pod_target() {
+        let config: ScrapeConfig = json::from_str(r#"{"name":"pods","kubernetes":{}}"#).unwrap();
+        let pod = json::json!({
+            "metadata": {
+                "name": "api-0",
+                "namespace": "prod",
+                "annotations": {"prometheus.io/scrape": "true"}
+            },
+            "spec": {"containers": [{"ports": [{"containerPort": 8080}]}]},
+            "status": {"podIP": "10.0.0.1"}
+        });
+        let target = pod_target(&config, &pod).unwrap();
+        assert_eq!(target.url, "http://10.0.0.1:8080/metrics");
+        assert_eq!(target.labels.get("pod").unwrap(), "api-0");
+
+        let pod = json::json!({"metadata": {"annotations": {}}, "status": {"podIP": "10.0.0.1"}});
+        assert!(pod_target(&config, &pod).is_none());
+    }
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Unit normalization for ingested metrics, so that series coming from
+//! sources with different units (ms vs s, MiB vs bytes) can be charted
+//! together. Enabled per unit group with `ZO_METRICS_UNIT_NORMALIZATION`.
+
+use config::utils::json;
+
+use crate::common::meta::prom::{Metadata, BUCKET_LABEL, NAME_LABEL, VALUE_LABEL};
+
+/// Target unit of the `time` group
+pub const UNIT_SECONDS: &str = "s";
+/// Target unit of the `bytes` group
+pub const UNIT_BYTES: &str = "By";
+/// Target unit of the `percent` group
+pub const UNIT_RATIO: &str = "1";
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum UnitGroup {
+    Time,
+    Bytes,
+    Percent,
+}
+
+impl UnitGroup {
+    fn from_name(name: &str) -> Option<Self> {
+        match name.trim().to_lowercase().as_str() {
+            "time" => Some(Self::Time),
+            "bytes" => Some(Self::Bytes),
+            "percent" => Some(Self::Percent),
+            _ => None,
+        }
+    }
+}
+
+/// Parses the comma separated unit groups of `ZO_METRICS_UNIT_NORMALIZATION`,
+/// unknown names are ignored.
+pub fn parse_groups(groups: &str) -> Vec<UnitGroup> {
+    groups.split(',').filter_map(UnitGroup::from_name).collect()
+}
+
+/// Returns the group, target unit and multiplier to reach it for a known unit.
+/// Both UCUM units, as used by OpenTelemetry, and common spellings are
+/// understood.
+fn lookup(unit: &str) -> Option<(UnitGroup, &'static str, f64)> {
+    const KB: f64 = 1000.0;
+    const KIB: f64 = 1024.0;
+    let (group, target, factor) = match unit {
+        "ns" => (UnitGroup::Time, UNIT_SECONDS, 1e-9),
+        "us" | "µs" => (UnitGroup::Time, UNIT_SECONDS, 1e-6),
+        "ms" => (UnitGroup::Time, UNIT_SECONDS, 1e-3),
+        "s" => (UnitGroup::Time, UNIT_SECONDS, 1.0),
+        "min" => (UnitGroup::Time, UNIT_SECONDS, 60.0),
+        "h" => (UnitGroup::Time, UNIT_SECONDS, 3600.0),
+        "d" => (UnitGroup::Time, UNIT_SECONDS, 86400.0),
+        "By" | "bytes" | "B" => (UnitGroup::Bytes, UNIT_BYTES, 1.0),
+        "KBy" | "kB" | "KB" => (UnitGroup::Bytes, UNIT_BYTES, KB),
+        "MBy" | "MB" => (UnitGroup::Bytes, UNIT_BYTES, KB * KB),
+        "GBy" | "GB" => (UnitGroup::Bytes, UNIT_BYTES, KB * KB * KB),
+        "TBy" | "TB" => (UnitGroup::Bytes, UNIT_BYTES, KB * KB * KB * KB),
+        "KiBy" | "KiB" => (UnitGroup::Bytes, UNIT_BYTES, KIB),
+        "MiBy" | "MiB" => (UnitGroup::Bytes, UNIT_BYTES, KIB * KIB),
+        "GiBy" | "GiB" => (UnitGroup::Bytes, UNIT_BYTES, KIB * KIB * KIB),
+        "TiBy" | "TiB" => (UnitGroup::Bytes, UNIT_BYTES, KIB * KIB * KIB * KIB),
+        "%" => (UnitGroup::Percent, UNIT_RATIO, 0.01),
+        _ => return None,
+    };
+    Some((group, target, factor))
+}
+
+/// Returns the normalized unit and the multiplier to convert values into it,
+/// or `None` when the unit is unknown, not in an enabled group or already
+/// normalized. Rates such as `MiBy/s` keep their denominator.
+pub fn normalize(unit: &str, groups: &[UnitGroup]) -> Option<(String, f64)> {
+    let (unit, per) = match unit.split_once('/') {
+        Some((unit, per)) => (unit, Some(per)),
+        None => (unit, None),
+    };
+    let (group, target, factor) = lookup(unit)?;
+    if !groups.contains(&group) || unit == target {
+        return None;
+    }
+    let target = match per {
+        Some(per) => format!("{target}/{per}"),
+        None => target.to_string(),
+    };
+    Some((target, factor))
+}
+
+/// Rewrites the metadata unit when it should be normalized, keeping the
+/// original unit, and returns the multiplier for the metric values.
+pub fn normalize_metadata(metadata: &mut Metadata, groups: &str) -> Option<f64> {
+    if metadata.unit.is_empty() || groups.is_empty() {
+        return None;
+    }
+    let (unit, factor) = normalize(&metadata.unit, &parse_groups(groups))?;
+    metadata.original_unit = std::mem::replace(&mut metadata.unit, unit);
+    Some(factor)
+}
+
+/// Scales the records of the metric `metric_name` by `factor`. Observation
+/// values, `_sum`, `_min` and `_max` are scaled, histogram buckets get their
+/// `le` bound scaled instead, and `_count` is left untouched.
+pub fn apply(records: &mut [json::Value], metric_name: &str, factor: f64) {
+    for rec in records.iter_mut() {
+        let Some(name) = rec.get(NAME_LABEL).and_then(|v| v.as_str()) else {
+            continue;
+        };
+        let suffix = name.strip_prefix(metric_name).unwrap_or(name);
+        match suffix {
+            "_count" => {}
+            "_bucket" => {
+                let le = rec
+                    .get(BUCKET_LABEL)
+                    .and_then(|v| v.as_str())
+                    .and_then(|v| v.parse::<f64>().ok());
+                if let Some(le) = le {
+                    rec[BUCKET_LABEL] = (le * factor).to_string().into();
+                }
+            }
+            _ => {
+                if let Some(v) = rec.get(VALUE_LABEL).and_then(|v| v.as_f64()) {
+                    rec[VALUE_LABEL] = (v * factor).into();
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::common::meta::prom::MetricType;
+
+    #[test]
+    fn test_normalize() {
+        let all = parse_groups("time, bytes,percent,unknown");
+        assert_eq!(all.len(), 3);
+        assert_eq!(normalize("ms", &all), Some(("s".to_string(), 1e-3)));
+        assert_eq!(
+            normalize("MiBy", &all),
+            Some(("By".to_string(), 1024.0 * 1024.0))
+        );
+        assert_eq!(normalize("MB/s", &all), Some(("By/s".to_string(), 1e6)));
+        assert_eq!(normalize("%", &all), Some(("1".to_string(), 0.01)));
+        assert_eq!(normalize("s", &all), None);
+        assert_eq!(normalize("{requests}", &all), None);
+        assert_eq!(normalize("ms", &parse_groups("bytes")), None);
+    }
+
+    #[test]
+    fn test_normalize_metadata() {
+        let mut md = Metadata {
+            metric_type: MetricType::Gauge,
+            metric_family_name: "mem".to_string(),
+            help: "".to_string(),
+            unit: "MB".to_string(),
+            original_unit: "".to_string(),
+        };
+        assert_eq!(normalize_metadata(&mut md, ""), None);
+        assert_eq!(normalize_metadata(&mut md, "bytes"), Some(1e6));
+        assert_eq!(md.unit, "By");
+        assert_eq!(md.original_unit, "MB");
+    }
+
+    #[test]
+    fn test_apply() {
+        let mut records = vec![
+            json::json!({"__name__": "latency", "value": 250.0}),
+            json::json!({"__name__": "latency_sum", "value": 1000.0}),
+            json::json!({"__name__": "latency_count", "value": 4.0}),
+            json::json!({"__name__": "latency_bucket", "le": "500", "value": 3.0}),
+            json::json!({"__name__": "latency_bucket", "le": "inf", "value": 4.0}),
+        ];
+        apply(&mut records, "latency", 1e-3);
+        assert_eq!(records[0]["value"], json::json!(0.25));
+        assert_eq!(records[1]["value"], json::json!(1.0));
+        assert_eq!(records[2]["value"], json::json!(4.0));
+        assert_eq!(records[3]["le"], json::json!("0.5"));
+        assert_eq!(records[3]["value"], json::json!(3.0));
+        assert_eq!(records[4]["le"], json::json!("inf"));
+    }
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Second factor of the native logins. Only TOTP authenticator apps are
+//! supported, WebAuthn security keys and passkeys are not implemented yet.
+
+use config::get_config;
+
+use super::db;
+use crate::common::{
+    meta::user::{MfaEnrollResponse, UserMfa},
+    utils::totp,
+};
+
+/// Starts a TOTP enrollment for the user. The secret stays pending until it
+/// is activated with a valid code, so a half finished enrollment can not lock
+/// the user out.
+pub async fn enroll(user_email: &str) -> Result<MfaEnrollResponse, anyhow::Error> {
+    if let Some(mfa) = db::user_mfa::get(user_email).await? {
+        if mfa.enabled {
+            return Err(anyhow::anyhow!("MFA is already enabled for the user"));
+        }
+    }
+    let secret = totp::generate_secret();
+    db::user_mfa::set(
+        user_email,
+        &UserMfa {
+            secret: secret.clone(),
+            enabled: false,
+            created_at: chrono::Utc::now().timestamp(),
+            last_step: 0,
+        },
+    )
+    .await?;
+    let otpauth_url = totp::otpauth_url(&get_config().auth.mfa_issuer, user_email, &secret);
+    Ok(MfaEnrollResponse {
+        secret,
+        otpauth_url,
+    })
+}
+
+/// Confirms a pending enrollment with a code from the authenticator app.
+pub async fn activate(user_email: &str, code: &str) -> Result<bool, anyhow::Error> {
+    let Some(mut mfa) = db::user_mfa::get(user_email).await? else {
+        return Err(anyhow::anyhow!("MFA enrollment not found for the user"));
+    };
+    let Some(step) = totp::verify(&mfa.secret, code, now(), mfa.last_step) else {
+        return Ok(false);
+    };
+    mfa.enabled = true;
+    mfa.last_step = step;
+    db::user_mfa::set(user_email, &mfa).await?;
+    Ok(true)
+}
+
+pub async fn disable(user_email: &str) -> Result<(), anyhow::Error> {
+    db::user_mfa::delete(user_email).await
+}
+
+/// Verifies a login code, users without MFA enabled always pass. The step of
+/// the code is saved, so the same code can't log in again.
+pub async fn verify(user_email: &str, code: Option<&str>) -> Result<bool, anyhow::Error> {
+    let mut mfa = match db::user_mfa::get(user_email).await? {
+        Some(mfa) if mfa.enabled => mfa,
+        _ => return Ok(true),
+    };
+    let Some(step) = code.and_then(|code| totp::verify(&mfa.secret, code, now(), mfa.last_step))
+    else {
+        return Ok(false);
+    };
+    mfa.last_step = step;
+    db::user_mfa::set(user_email, &mfa).await?;
+    Ok(true)
+}
+
+/// Whether the user has to give a one-time code at login
+pub async fn is_enabled(user_email: &str) -> Result<bool, anyhow::Error> {
+    Ok(db::user_mfa::get(user_email)
+        .await?
+        .is_some_and(|mfa| mfa.enabled))
+}
+
+fn now() -> u64 {
+    chrono::Utc::now().timestamp() as u64
+}
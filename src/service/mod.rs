@@ -20,30 +20,68 @@ use regex::Regex;
 
 use crate::common::meta::stream::StreamParams;
 
+pub mod ai;
 pub mod alerts;
+pub mod archive_search;
+pub mod bulk;
+pub mod comments;
 pub mod compact;
+pub mod conformance;
 pub mod dashboards;
 pub mod db;
+pub mod demo;
 pub mod enrichment;
 pub mod enrichment_table;
+pub mod entities;
+pub mod etl;
 pub mod file_list;
 pub mod functions;
+pub mod governance;
+pub mod iceberg;
+pub mod ingest_keys;
 pub mod ingestion;
+pub mod k8s_watcher;
 pub mod kv;
+pub mod lifecycle;
+pub mod log_puller;
+pub mod log_summary;
+pub mod login_attempts;
 pub mod logs;
+pub mod mcp;
 pub mod metadata;
 pub mod metrics;
+pub mod mfa;
+pub mod network_policy;
+pub mod nl_query;
 pub mod organization;
+pub mod parquet_import;
 pub mod pipelines;
+pub mod plugins;
 pub mod promql;
+pub mod query_analyze;
+pub mod query_history;
+pub mod rehydration;
+pub mod residency;
+pub mod saved_query;
 pub mod schema;
+pub mod schema_contracts;
 pub mod search;
+pub mod search_jobs;
+pub mod secrets;
 pub mod session;
+pub mod snapshot;
 pub mod stream;
+pub mod stream_access;
+pub mod stream_partitions;
+pub mod stream_profile;
+pub mod subscriptions;
+pub mod synthetics;
 pub mod syslogs_route;
 pub mod traces;
 pub mod usage;
+pub mod user_sessions;
 pub mod users;
+pub mod webhooks;
 
 const MAX_KEY_LENGTH: usize = 100;
 
@@ -0,0 +1,337 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::net::{IpAddr, SocketAddr};
+
+use actix_web::http::{header::HeaderMap, Method};
+use config::get_config;
+use ipnetwork::IpNetwork;
+use once_cell::sync::Lazy;
+
+use super::db;
+use crate::common::meta::{
+    ingestion::INGESTION_EP,
+    organization::{NetworkPolicy, NetworkScope},
+};
+
+/// Proxies allowed to set the client address with the forwarded headers
+static TRUSTED_PROXIES: Lazy<Vec<IpNetwork>> =
+    Lazy::new(|| parse_trusted_proxies(&get_config().http.trusted_proxies));
+
+/// Returns the org of a request and the policy of the org which applies to it,
+/// `path` being the path without the base uri, e.g. `/api/default/_search`. The
+/// rum endpoints are versioned, `/rum/v1/{org_id}/...`, and all of them ingest.
+pub fn request_scope<'a>(method: &Method, path: &'a str) -> Option<(&'a str, NetworkScope)> {
+    // skip the scope segment, i.e. `api`, `aws`, `gcp` or `rum`
+    let mut columns = path.trim_matches('/').split('/');
+    let is_rum = columns.next() == Some("rum");
+    if is_rum {
+        columns.next();
+    }
+    let path_columns = columns.collect::<Vec<_>>();
+    let org_id = path_columns
+        .first()
+        .copied()
+        .filter(|org_id| !org_id.is_empty() && !org_id.eq(&"organizations"))?;
+    let scope = if is_rum {
+        NetworkScope::Ingest
+    } else {
+        endpoint_scope(method, &path_columns)
+    };
+    Some((org_id, scope))
+}
+
+/// Decides which policy of the org applies to a request. `path_columns` starts
+/// with the org id, e.g. `["default", "_search"]`.
+pub fn endpoint_scope(method: &Method, path_columns: &[&str]) -> NetworkScope {
+    let last = path_columns.last().copied().unwrap_or_default();
+    if method.eq(&Method::POST) && INGESTION_EP.contains(&last) {
+        return NetworkScope::Ingest;
+    }
+    let is_query = path_columns
+        .iter()
+        .skip(1)
+        .any(|c| c.starts_with("_search") || c.starts_with("_around") || c.eq(&"_values"))
+        || (path_columns.get(1) == Some(&"prometheus") && path_columns.get(2) == Some(&"api"))
+        || (method.eq(&Method::GET) && path_columns.get(2) == Some(&"traces"));
+    if is_query {
+        NetworkScope::Query
+    } else {
+        NetworkScope::Ui
+    }
+}
+
+/// Returns false when the org has a policy for the scope that does not include
+/// the client address. Requests without a parsable client address are only
+/// let through when the scope has no allowlist.
+pub fn is_allowed(org_id: &str, scope: NetworkScope, client_ip: Option<IpAddr>) -> bool {
+    let Some(policy) = db::network_policy::get(org_id) else {
+        return true;
+    };
+    match client_ip {
+        Some(ip) => policy.allows(scope, ip),
+        None => !has_allowlist(&policy, scope),
+    }
+}
+
+fn has_allowlist(policy: &NetworkPolicy, scope: NetworkScope) -> bool {
+    match scope {
+        NetworkScope::Ui => !policy.ui.is_empty(),
+        NetworkScope::Query => !policy.query.is_empty(),
+        NetworkScope::Ingest => !policy.ingest.is_empty(),
+    }
+}
+
+/// Returns the address of the client. The `Forwarded` and `X-Forwarded-For`
+/// headers are written by the client itself unless a proxy replaces them, so
+/// they are only honoured on connections from `ZO_HTTP_TRUSTED_PROXIES`.
+pub fn client_ip(peer: Option<SocketAddr>, headers: &HeaderMap) -> Option<IpAddr> {
+    resolve_client_ip(peer, headers, &TRUSTED_PROXIES)
+}
+
+/// Walks the forwarded hops from the peer backwards and returns the first one
+/// which is not a trusted proxy, the hops before it may be made up by the
+/// client.
+fn resolve_client_ip(
+    peer: Option<SocketAddr>,
+    headers: &HeaderMap,
+    trusted: &[IpNetwork],
+) -> Option<IpAddr> {
+    let mut client = peer?.ip();
+    if !is_trusted(trusted, client) {
+        return Some(client);
+    }
+    for hop in forwarded_hops(headers).into_iter().rev() {
+        client = parse_client_ip(&hop)?;
+        if !is_trusted(trusted, client) {
+            break;
+        }
+    }
+    Some(client)
+}
+
+fn is_trusted(trusted: &[IpNetwork], ip: IpAddr) -> bool {
+    trusted.iter().any(|net| net.contains(ip))
+}
+
+/// Client addresses of the forwarded headers, the closest hop last.
+/// `Forwarded` takes precedence over `X-Forwarded-For` like in actix.
+fn forwarded_hops(headers: &HeaderMap) -> Vec<String> {
+    let forwarded = headers
+        .get_all("forwarded")
+        .filter_map(|v| v.to_str().ok())
+        .flat_map(|v| v.split(','))
+        .filter_map(|hop| {
+            hop.split(';').find_map(|pair| {
+                let (key, value) = pair.trim().split_once('=')?;
+                key.trim()
+                    .eq_ignore_ascii_case("for")
+                    .then(|| value.trim().trim_matches('"').to_string())
+            })
+        })
+        .collect::<Vec<_>>();
+    if !forwarded.is_empty() {
+        return forwarded;
+    }
+    headers
+        .get_all("x-forwarded-for")
+        .filter_map(|v| v.to_str().ok())
+        .flat_map(|v| v.split(','))
+        .map(|hop| hop.trim().to_string())
+        .filter(|hop| !hop.is_empty())
+        .collect()
+}
+
+fn parse_trusted_proxies(value: &str) -> Vec<IpNetwork> {
+    value
+        .split(',')
+        .map(|v| v.trim())
+        .filter(|v| !v.is_empty())
+        .filter_map(|v| match v.parse::<IpNetwork>() {
+            Ok(net) => Some(net),
+            Err(e) => {
+                log::error!("[NETWORK_POLICY] invalid trusted proxy {v}: {e}");
+                None
+            }
+        })
+        .collect()
+}
+
+/// Parses a client address, which may carry a port and brackets around ipv6
+/// addresses.
+fn parse_client_ip(addr: &str) -> Option<IpAddr> {
+    if let Ok(ip) = addr.parse::<IpAddr>() {
+        return Some(ip);
+    }
+    if let Ok(sock) = addr.parse::<std::net::SocketAddr>() {
+        return Some(sock.ip());
+    }
+    addr.trim_start_matches('[')
+        .trim_end_matches(']')
+        .parse::<IpAddr>()
+        .ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_endpoint_scope() {
+        assert_eq!(
+            endpoint_scope(&Method::POST, &["default", "app", "_json"]),
+            NetworkScope::Ingest
+        );
+        assert_eq!(
+            endpoint_scope(
+                &Method::POST,
+                &["default", "prometheus", "api", "v1", "write"]
+            ),
+            NetworkScope::Ingest
+        );
+        assert_eq!(
+            endpoint_scope(&Method::POST, &["default", "_search"]),
+            NetworkScope::Query
+        );
+        assert_eq!(
+            endpoint_scope(&Method::GET, &["default", "app", "_values"]),
+            NetworkScope::Query
+        );
+        assert_eq!(
+            endpoint_scope(
+                &Method::GET,
+                &["default", "prometheus", "api", "v1", "query"]
+            ),
+            NetworkScope::Query
+        );
+        assert_eq!(
+            endpoint_scope(&Method::GET, &["default", "default", "traces", "latest"]),
+            NetworkScope::Query
+        );
+        assert_eq!(
+            endpoint_scope(&Method::GET, &["default", "dashboards"]),
+            NetworkScope::Ui
+        );
+        assert_eq!(
+            endpoint_scope(&Method::GET, &["default", "logs"]),
+            NetworkScope::Ui
+        );
+    }
+
+    #[test]
+    fn test_request_scope() {
+        assert_eq!(
+            request_scope(&Method::POST, "/api/default/app/_json"),
+            Some(("default", NetworkScope::Ingest))
+        );
+        assert_eq!(
+            request_scope(&Method::GET, "/api/default/dashboards"),
+            Some(("default", NetworkScope::Ui))
+        );
+        assert_eq!(
+            request_scope(&Method::POST, "/rum/v1/default/replay"),
+            Some(("default", NetworkScope::Ingest))
+        );
+        assert_eq!(
+            request_scope(&Method::POST, "/rum/v1/default/rum"),
+            Some(("default", NetworkScope::Ingest))
+        );
+        assert_eq!(request_scope(&Method::GET, "/api/organizations"), None);
+        assert_eq!(request_scope(&Method::GET, "/api/"), None);
+    }
+
+    #[test]
+    fn test_parse_client_ip() {
+        assert_eq!(parse_client_ip("10.0.0.1"), "10.0.0.1".parse().ok());
+        assert_eq!(parse_client_ip("10.0.0.1:5080"), "10.0.0.1".parse().ok());
+        assert_eq!(parse_client_ip("[::1]:5080"), "::1".parse().ok());
+        assert_eq!(parse_client_ip("[::1]"), "::1".parse().ok());
+        assert_eq!(parse_client_ip("unknown"), None);
+    }
+
+    fn headers(pairs: &[(&'static str, &'static str)]) -> HeaderMap {
+        let mut headers = HeaderMap::new();
+        for (name, value) in pairs {
+            headers.append(
+                actix_web::http::header::HeaderName::from_static(name),
+                actix_web::http::header::HeaderValue::from_static(value),
+            );
+        }
+        headers
+    }
+
+    #[test]
+    fn test_client_ip_ignores_spoofed_headers() {
+        let peer = "203.0.113.7:40000".parse().ok();
+        let spoofed = headers(&[("x-forwarded-for", "10.0.0.1")]);
+        assert_eq!(
+            resolve_client_ip(peer, &spoofed, &[]),
+            "203.0.113.7".parse().ok()
+        );
+        let spoofed = headers(&[("forwarded", "for=10.0.0.1")]);
+        assert_eq!(
+            resolve_client_ip(peer, &spoofed, &parse_trusted_proxies("192.168.0.0/16")),
+            "203.0.113.7".parse().ok()
+        );
+
+        // the spoofed address is blocked by an allowlist of 10.0.0.0/8
+        let policy = NetworkPolicy {
+            ui: vec!["10.0.0.0/8".parse().unwrap()],
+            ..Default::default()
+        };
+        let client_ip = resolve_client_ip(peer, &spoofed, &[]).unwrap();
+        assert!(!policy.allows(NetworkScope::Ui, client_ip));
+    }
+
+    #[test]
+    fn test_client_ip_behind_trusted_proxies() {
+        let trusted = parse_trusted_proxies("192.168.0.0/16, 172.16.0.1, bad");
+        assert_eq!(trusted.len(), 2);
+        let peer = "192.168.1.1:40000".parse().ok();
+        assert_eq!(
+            resolve_client_ip(peer, &headers(&[]), &trusted),
+            "192.168.1.1".parse().ok()
+        );
+        assert_eq!(
+            resolve_client_ip(
+                peer,
+                &headers(&[("x-forwarded-for", "203.0.113.7, 172.16.0.1")]),
+                &trusted
+            ),
+            "203.0.113.7".parse().ok()
+        );
+        // the client prepends an allowed address, the proxy appends the real one
+        assert_eq!(
+            resolve_client_ip(
+                peer,
+                &headers(&[("x-forwarded-for", "10.0.0.1, 203.0.113.7")]),
+                &trusted
+            ),
+            "203.0.113.7".parse().ok()
+        );
+        assert_eq!(
+            resolve_client_ip(
+                peer,
+                &headers(&[(
+                    "forwarded",
+                    "for=10.0.0.1, for=\"[2001:db8::1]:4711\";proto=https"
+                )]),
+                &trusted
+            ),
+            "2001:db8::1".parse().ok()
+        );
+        assert_eq!(resolve_client_ip(None, &headers(&[]), &trusted), None);
+    }
+}
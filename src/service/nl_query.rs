@@ -0,0 +1,437 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Generates the SQL or PromQL query of a question in natural language with
+//! a language model, grounded on the schemas of the streams and on the saved
+//! views of the organization.
+
+use std::collections::HashSet;
+
+use anyhow::{anyhow, Result};
+use config::{
+    get_config,
+    meta::{sql::Sql, stream::StreamType},
+    utils::json,
+};
+use serde::Deserialize;
+
+use crate::{
+    common::meta::{
+        nl_query::{NlQueryRequest, NlQueryResponse},
+        query_analyze::{QueryAnalyzeRequest, QueryLanguage},
+        saved_view::View,
+    },
+    service::{ai, db, query_analyze},
+};
+
+/// Streams described to the model when the request has none
+const MAX_CANDIDATE_STREAMS: usize = 3;
+/// Stream names listed to the model besides the described ones
+const MAX_LISTED_STREAMS: usize = 100;
+/// Corrections asked to the model when its query is invalid
+const MAX_RETRIES: usize = 1;
+
+const SQL_INSTRUCTIONS: &str = r#"You write SQL queries for OpenObserve, which uses the Apache DataFusion SQL dialect.
+- A query reads one stream, quote the stream and the field names with double quotes.
+- `_timestamp` is the time of a record in unix microseconds. Don't filter on the time range, it is applied apart from the query.
+- Full text search: `match_all('text')` over all the fields, `str_match("field", 'text')` on one field, `re_match("field", 'regex')` for regular expressions.
+- Time buckets: `histogram(_timestamp, '1 minute') AS "bucket"` with `GROUP BY "bucket" ORDER BY "bucket"`.
+- Only use the fields of the schema."#;
+
+const PROMQL_INSTRUCTIONS: &str = r#"You write PromQL queries for OpenObserve, which is compatible with Prometheus.
+- Only use the metrics and the labels listed.
+- Use `rate()` or `increase()` on counters, `histogram_quantile()` on the `_bucket` series of histograms.
+- Don't use the time range selectors of the instant queries, the range of the query is applied apart."#;
+
+const OUTPUT_INSTRUCTIONS: &str = r#"Answer with a JSON object and nothing else: {"query": "<the query>", "stream_name": "<the stream or the metric queried>", "explanation": "<what the query does, in one or two sentences>"}"#;
+
+#[derive(Debug, Default, Deserialize, PartialEq)]
+struct Candidate {
+    query: String,
+    #[serde(default)]
+    stream_name: String,
+    #[serde(default)]
+    explanation: String,
+}
+
+/// Schema of a stream given to the model
+struct StreamDoc {
+    name: String,
+    fields: Vec<(String, String)>,
+}
+
+/// Example of a query saved by a user
+#[derive(Debug, PartialEq)]
+struct Example {
+    name: String,
+    stream_name: String,
+    query: String,
+}
+
+pub async fn generate(org_id: &str, req: &NlQueryRequest) -> Result<NlQueryResponse> {
+    let cfg = get_config();
+    if !cfg.ai.enabled {
+        return Err(anyhow!("query generation is disabled, set ZO_AI_ENABLED"));
+    }
+    if req.question.trim().is_empty() {
+        return Err(anyhow!("question is empty"));
+    }
+    let stream_type = match req.query_type {
+        QueryLanguage::Sql => req.stream_type,
+        QueryLanguage::Promql => StreamType::Metrics,
+    };
+
+    let names = db::schema::list(org_id, Some(stream_type), false)
+        .await?
+        .into_iter()
+        .map(|s| s.stream_name)
+        .collect::<Vec<_>>();
+    if names.is_empty() {
+        return Err(anyhow!("no {stream_type} stream found"));
+    }
+    let candidates = if req.stream_name.is_empty() {
+        rank_streams(&req.question, &names, MAX_CANDIDATE_STREAMS)
+    } else if names.contains(&req.stream_name) {
+        vec![req.stream_name.clone()]
+    } else {
+        return Err(anyhow!("stream {} not found", req.stream_name));
+    };
+
+    let mut docs = Vec::with_capacity(candidates.len());
+    for name in candidates.iter() {
+        let schema = infra::schema::get(org_id, name, stream_type).await?;
+        let fields = schema
+            .fields()
+            .iter()
+            .filter(|f| !f.name().starts_with("__"))
+            .take(cfg.ai.max_fields)
+            .map(|f| (f.name().to_string(), f.data_type().to_string()))
+            .collect();
+        docs.push(StreamDoc {
+            name: name.to_string(),
+            fields,
+        });
+    }
+    let examples = match req.query_type {
+        QueryLanguage::Sql => {
+            let views = db::saved_view::get_views(org_id).await.unwrap_or_default();
+            select_examples(&views, &candidates, cfg.ai.max_examples)
+        }
+        // the saved views are all SQL searches
+        QueryLanguage::Promql => vec![],
+    };
+    let others = if req.stream_name.is_empty() {
+        names
+            .iter()
+            .filter(|n| !candidates.contains(n))
+            .take(MAX_LISTED_STREAMS)
+            .cloned()
+            .collect()
+    } else {
+        vec![]
+    };
+
+    let mut messages = vec![
+        json::json!({ "role": "system", "content": system_prompt(req.query_type, &docs, &others, &examples) }),
+        json::json!({ "role": "user", "content": req.question }),
+    ];
+    let mut retries = 0;
+    let candidate = loop {
+        let content = ai::chat(&messages, true).await?;
+        let err = match parse_candidate(&content) {
+            Ok(c) => match validate(&c, req.query_type, &names) {
+                Ok(_) => break c,
+                Err(e) => e,
+            },
+            Err(e) => e,
+        };
+        if retries >= MAX_RETRIES {
+            return Err(anyhow!("the generated query is invalid: {err}"));
+        }
+        retries += 1;
+        messages.push(json::json!({ "role": "assistant", "content": content }));
+        messages.push(json::json!({
+            "role": "user",
+            "content": format!("The query is invalid: {err}. Correct it and answer with the JSON object again."),
+        }));
+    };
+
+    let stream_name = match req.query_type {
+        QueryLanguage::Sql => Sql::new(&candidate.query)?.source,
+        QueryLanguage::Promql => candidate.stream_name.clone(),
+    };
+    let mut resp = NlQueryResponse {
+        query: candidate.query,
+        query_type: req.query_type,
+        stream_type,
+        stream_name,
+        explanation: candidate.explanation,
+        cost: None,
+        warnings: vec![],
+    };
+    let analyze_req = QueryAnalyzeRequest {
+        query: resp.query.clone(),
+        query_type: req.query_type,
+        start_time: req.start_time,
+        end_time: req.end_time,
+        step: 0,
+    };
+    match query_analyze::analyze(org_id, stream_type, &analyze_req).await {
+        Ok(cost) => resp.cost = Some(cost),
+        Err(e) => resp.warnings.push(format!("cost estimate failed: {e}")),
+    }
+    Ok(resp)
+}
+
+/// Orders the streams by the words of their name found in the question
+fn rank_streams(question: &str, names: &[String], limit: usize) -> Vec<String> {
+    let words = words(question);
+    let mut scored = names
+        .iter()
+        .map(|name| {
+            let score = words_of_name(name)
+                .iter()
+                .filter(|w| words.iter().any(|q| q.starts_with(*w) || w.starts_with(q)))
+                .count();
+            (score, name)
+        })
+        .collect::<Vec<_>>();
+    scored.sort_by(|a, b| b.0.cmp(&a.0).then_with(|| a.1.cmp(b.1)));
+    let matched = scored.iter().filter(|(score, _)| *score > 0).count();
+    // without any match the model picks among the listed names
+    let limit = if matched == 0 {
+        std::cmp::min(limit, 1)
+    } else {
+        std::cmp::min(limit, matched)
+    };
+    scored
+        .into_iter()
+        .take(limit)
+        .map(|(_, name)| name.to_string())
+        .collect()
+}
+
+fn words(text: &str) -> HashSet<String> {
+    text.split(|c: char| !c.is_alphanumeric())
+        .filter(|w| w.len() > 2)
+        .map(|w| w.to_lowercase())
+        .collect()
+}
+
+fn words_of_name(name: &str) -> Vec<String> {
+    words(name).into_iter().collect()
+}
+
+/// Takes the saved views on the candidate streams first
+fn select_examples(views: &[View], streams: &[String], limit: usize) -> Vec<Example> {
+    let mut examples = views.iter().filter_map(view_example).collect::<Vec<_>>();
+    examples.sort_by_key(|e| !streams.contains(&e.stream_name));
+    examples.truncate(limit);
+    examples
+}
+
+/// Extracts the query of a view saved by the logs search page
+fn view_example(view: &View) -> Option<Example> {
+    let data = view.data.get("data")?;
+    let query = data.get("query")?.as_str()?.trim();
+    if query.is_empty() {
+        return None;
+    }
+    let stream_name = data
+        .get("stream")?
+        .get("selectedStream")?
+        .as_array()?
+        .first()?
+        .as_str()?
+        .to_string();
+    let sql_mode = view
+        .data
+        .get("meta")
+        .and_then(|m| m.get("sqlMode"))
+        .and_then(|v| v.as_bool())
+        .unwrap_or(false);
+    let query = if sql_mode {
+        query.to_string()
+    } else {
+        format!("SELECT * FROM \"{stream_name}\" WHERE {query}")
+    };
+    Some(Example {
+        name: view.view_name.clone(),
+        stream_name,
+        query,
+    })
+}
+
+fn system_prompt(
+    query_type: QueryLanguage,
+    docs: &[StreamDoc],
+    others: &[String],
+    examples: &[Example],
+) -> String {
+    let (instructions, kind) = match query_type {
+        QueryLanguage::Sql => (SQL_INSTRUCTIONS, "Stream"),
+        QueryLanguage::Promql => (PROMQL_INSTRUCTIONS, "Metric"),
+    };
+    let mut prompt = format!("{instructions}\n\n");
+    for doc in docs {
+        prompt.push_str(&format!("{kind} \"{}\" fields:\n", doc.name));
+        for (name, data_type) in doc.fields.iter() {
+            prompt.push_str(&format!("- {name}: {data_type}\n"));
+        }
+        prompt.push('\n');
+    }
+    if !others.is_empty() {
+        prompt.push_str(&format!(
+            "Other {}s: {}\n\n",
+            kind.to_lowercase(),
+            others.join(", ")
+        ));
+    }
+    if !examples.is_empty() {
+        prompt.push_str("Queries saved by the users:\n");
+        for e in examples {
+            prompt.push_str(&format!("- {}: {}\n", e.name, e.query));
+        }
+        prompt.push('\n');
+    }
+    prompt.push_str(OUTPUT_INSTRUCTIONS);
+    prompt
+}
+
+/// Parses the answer of the model, tolerating a markdown code block around
+/// the JSON object
+fn parse_candidate(content: &str) -> std::result::Result<Candidate, String> {
+    let content = content.trim();
+    let content = match (content.find('{'), content.rfind('}')) {
+        (Some(start), Some(end)) if start < end => &content[start..=end],
+        _ => return Err("the answer is not a JSON object".to_string()),
+    };
+    let candidate: Candidate = json::from_str(content).map_err(|e| e.to_string())?;
+    if candidate.query.trim().is_empty() {
+        return Err("the query is empty".to_string());
+    }
+    Ok(candidate)
+}
+
+fn validate(
+    candidate: &Candidate,
+    query_type: QueryLanguage,
+    streams: &[String],
+) -> std::result::Result<(), String> {
+    match query_type {
+        QueryLanguage::Sql => {
+            let sql = Sql::new(&candidate.query).map_err(|e| e.to_string())?;
+            if !streams.contains(&sql.source) {
+                return Err(format!("stream {} doesn't exist", sql.source));
+            }
+        }
+        QueryLanguage::Promql => {
+            promql_parser::parser::parse(&candidate.query)?;
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rank_streams() {
+        let names = vec![
+            "k8s_events".to_string(),
+            "nginx_access".to_string(),
+            "payments".to_string(),
+        ];
+        assert_eq!(
+            rank_streams("slow requests in the Nginx access logs", &names, 3),
+            vec!["nginx_access"]
+        );
+        assert_eq!(
+            rank_streams("failed payment events", &names, 3),
+            vec!["k8s_events", "payments"]
+        );
+        assert_eq!(rank_streams("how many errors", &names, 3).len(), 1);
+    }
+
+    #[test]
+    fn test_parse_candidate() {
+        let expected = Candidate {
+            query: "SELECT * FROM \"app\"".to_string(),
+            stream_name: "app".to_string(),
+            explanation: "all".to_string(),
+        };
+        let answer =
+            r#"{"query": "SELECT * FROM \"app\"", "stream_name": "app", "explanation": "all"}"#;
+        assert_eq!(parse_candidate(answer).unwrap(), expected);
+        assert_eq!(
+            parse_candidate(&format!("```json\n{answer}\n```")).unwrap(),
+            expected
+        );
+        assert!(parse_candidate("SELECT 1").is_err());
+        assert!(parse_candidate(r#"{"query": " "}"#).is_err());
+    }
+
+    #[test]
+    fn test_view_example() {
+        let view = |sql_mode: bool, query: &str| View {
+            org_id: "default".to_string(),
+            view_id: "1".to_string(),
+            view_name: "errors".to_string(),
+            data: json::json!({
+                "data": { "query": query, "stream": { "selectedStream": ["app"] } },
+                "meta": { "sqlMode": sql_mode },
+            }),
+        };
+        assert_eq!(
+            view_example(&view(false, "level = 'error'")).unwrap().query,
+            "SELECT * FROM \"app\" WHERE level = 'error'"
+        );
+        assert_eq!(
+            view_example(&view(true, "SELECT count(*) FROM \"app\""))
+                .unwrap()
+                .query,
+            "SELECT count(*) FROM \"app\""
+        );
+        assert!(view_example(&view(false, "")).is_none());
+
+        let views = vec![
+            view(false, "a = 1"),
+            View {
+                data: json::json!({
+                    "data": { "query": "b = 2", "stream": { "selectedStream": ["web"] } },
+                }),
+                ..view(false, "")
+            },
+        ];
+        let examples = select_examples(&views, &["web".to_string()], 1);
+        assert_eq!(examples.len(), 1);
+        assert_eq!(examples[0].stream_name, "web");
+    }
+
+    #[test]
+    fn test_system_prompt() {
+        let docs = vec![StreamDoc {
+            name: "app".to_string(),
+            fields: vec![("level".to_string(), "Utf8".to_string())],
+        }];
+        let prompt = system_prompt(QueryLanguage::Sql, &docs, &["web".to_string()], &[]);
+        assert!(prompt.starts_with(SQL_INSTRUCTIONS));
+        assert!(prompt.contains("Stream \"app\" fields:\n- level: Utf8\n"));
+        assert!(prompt.contains("Other streams: web\n"));
+        assert!(!prompt.contains("Queries saved"));
+        assert!(prompt.ends_with(OUTPUT_INSTRUCTIONS));
+    }
+}
@@ -0,0 +1,436 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Import of parquet files produced outside of OpenObserve, e.g. by a
+//! backfill job. The files are validated against the stream schema and
+//! registered into the file list as they are, the records are never decoded.
+//! The files are copied under the time partition of their records. The files
+//! of the bucket are only read from the import prefix of the organization,
+//! `import/{org_id}/`, never from the data of a stream.
+
+use std::{
+    io::{Cursor, Error},
+    sync::Arc,
+};
+
+use actix_multipart::Multipart;
+use actix_web::HttpResponse;
+use arrow_schema::{DataType, Schema};
+use bytes::Bytes;
+use chrono::{TimeZone, Utc};
+use config::{
+    cluster, get_config,
+    meta::stream::{FileMeta, PartitionTimeLevel, StreamType},
+    utils::parquet::generate_filename_with_time_range,
+    SIZE_IN_MB,
+};
+use futures::{StreamExt, TryStreamExt};
+use infra::schema::{unwrap_partition_time_level, unwrap_stream_settings};
+use parquet::{
+    arrow::ParquetRecordBatchStreamBuilder,
+    file::{metadata::ParquetMetaData, statistics::Statistics},
+};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        parquet_import::{FailedFile, ImportParquetRequest, ImportParquetResponse, ImportedFile},
+    },
+    service::db,
+};
+
+/// Stream the files are imported into
+struct Target {
+    org_id: String,
+    stream_name: String,
+    stream_type: StreamType,
+    schema: Schema,
+    partition_time_level: PartitionTimeLevel,
+}
+
+/// Imports parquet files already in the bucket
+pub async fn import_from_storage(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    req: ImportParquetRequest,
+) -> Result<HttpResponse, Error> {
+    if req.files.is_empty() {
+        return Ok(MetaHttpResponse::bad_request("files is required"));
+    }
+    let target = match get_target(org_id, stream_name, stream_type).await {
+        Ok(v) => v,
+        Err(resp) => return Ok(resp),
+    };
+
+    let mut resp = ImportParquetResponse::default();
+    for source in req.files {
+        let ret = match read_source(org_id, &source).await {
+            Ok(data) => import_file(&target, &source, data).await,
+            Err(e) => Err(e),
+        };
+        match ret {
+            Ok(file) => {
+                if req.delete_source {
+                    if let Err(e) = infra::storage::del(&[source.as_str()]).await {
+                        log::error!("[PARQUET_IMPORT] delete source {source} error: {e}");
+                    }
+                }
+                resp.imported.push(file);
+            }
+            Err(e) => {
+                log::warn!("[PARQUET_IMPORT] import {source} into {org_id}/{stream_type}/{stream_name} failed: {e}");
+                resp.failed.push(FailedFile {
+                    source,
+                    reason: e.to_string(),
+                });
+            }
+        }
+    }
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// Imports the parquet files uploaded in a multipart form, one file per field
+pub async fn import_from_multipart(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    mut payload: Multipart,
+) -> Result<HttpResponse, Error> {
+    let target = match get_target(org_id, stream_name, stream_type).await {
+        Ok(v) => v,
+        Err(resp) => return Ok(resp),
+    };
+
+    let max_size = get_config().limit.parquet_import_max_size * SIZE_IN_MB as usize;
+    let mut resp = ImportParquetResponse::default();
+    while let Ok(Some(mut field)) = payload.try_next().await {
+        let Some(source) = field.content_disposition().get_filename().map(String::from) else {
+            continue;
+        };
+        let mut data = Vec::new();
+        while let Some(chunk) = field.next().await {
+            let chunk = match chunk {
+                Ok(v) => v,
+                Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+            };
+            if data.len() + chunk.len() > max_size {
+                return Ok(MetaHttpResponse::bad_request(format!(
+                    "exceeds allowed limit of {} mb",
+                    get_config().limit.parquet_import_max_size
+                )));
+            }
+            data.extend_from_slice(&chunk);
+        }
+        match import_file(&target, &source, Bytes::from(data)).await {
+            Ok(file) => resp.imported.push(file),
+            Err(e) => {
+                log::warn!("[PARQUET_IMPORT] import {source} into {org_id}/{stream_type}/{stream_name} failed: {e}");
+                resp.failed.push(FailedFile {
+                    source,
+                    reason: e.to_string(),
+                });
+            }
+        }
+    }
+    if resp.imported.is_empty() && resp.failed.is_empty() {
+        return Ok(MetaHttpResponse::bad_request("no file uploaded"));
+    }
+    Ok(MetaHttpResponse::json(resp))
+}
+
+async fn get_target(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+) -> Result<Target, HttpResponse> {
+    if !cluster::is_ingester(&cluster::LOCAL_NODE_ROLE) {
+        return Err(MetaHttpResponse::bad_request("not an ingester"));
+    }
+    if db::compact::retention::is_deleting_stream(org_id, stream_type, stream_name, None) {
+        return Err(MetaHttpResponse::bad_request(format!(
+            "stream [{stream_name}] is being deleted"
+        )));
+    }
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .map_err(MetaHttpResponse::internal_error)?;
+    if schema == Schema::empty() {
+        return Err(MetaHttpResponse::not_found("stream not found"));
+    }
+    let stream_settings = unwrap_stream_settings(&schema).unwrap_or_default();
+    Ok(Target {
+        org_id: org_id.to_string(),
+        stream_name: stream_name.to_string(),
+        stream_type,
+        partition_time_level: unwrap_partition_time_level(
+            stream_settings.partition_time_level,
+            stream_type,
+        ),
+        schema,
+    })
+}
+
+/// Prefix of the bucket the files of the organization are imported from
+fn import_prefix(org_id: &str) -> String {
+    format!("import/{org_id}/")
+}
+
+/// Checks a key of the bucket is under the import prefix of the organization
+fn check_source(org_id: &str, source: &str) -> Result<(), anyhow::Error> {
+    let valid = source
+        .strip_prefix(&import_prefix(org_id))
+        .is_some_and(|name| {
+            !name.is_empty() && !name.split('/').any(|part| part.is_empty() || part == "..")
+        });
+    if !valid {
+        return Err(anyhow::anyhow!(
+            "the file must be under {}",
+            import_prefix(org_id)
+        ));
+    }
+    Ok(())
+}
+
+/// Reads a file of the bucket, up to the size allowed for the uploads
+async fn read_source(org_id: &str, source: &str) -> Result<Bytes, anyhow::Error> {
+    check_source(org_id, source)?;
+    let max_size = get_config().limit.parquet_import_max_size;
+    let size = infra::storage::head(source)
+        .await
+        .map_err(|e| anyhow::anyhow!("failed to read the file: {e}"))?;
+    if size > max_size * SIZE_IN_MB as usize {
+        return Err(anyhow::anyhow!("exceeds allowed limit of {max_size} mb"));
+    }
+    infra::storage::get(source)
+        .await
+        .map_err(|e| anyhow::anyhow!("failed to read the file: {e}"))
+}
+
+/// Validates a file and copies it into the file list of the stream
+async fn import_file(
+    target: &Target,
+    source: &str,
+    data: Bytes,
+) -> Result<ImportedFile, anyhow::Error> {
+    let (schema, metadata) = read_footer(&data).await?;
+    check_schema(&target.schema, &schema)?;
+    let records = metadata.file_metadata().num_rows();
+    if records == 0 {
+        return Err(anyhow::anyhow!("the file has no records"));
+    }
+    let (min_ts, max_ts) = time_range(&metadata)?;
+    let time_key = time_partition(min_ts, max_ts, target.partition_time_level)?;
+
+    let prefix = format!(
+        "files/{}/{}/{}/{time_key}/",
+        target.org_id, target.stream_type, target.stream_name
+    );
+    let key = format!(
+        "{prefix}{}",
+        generate_filename_with_time_range(min_ts, max_ts)
+    );
+    infra::storage::put(&key, data.clone()).await?;
+
+    let meta = FileMeta {
+        min_ts,
+        max_ts,
+        records,
+        original_size: metadata
+            .row_groups()
+            .iter()
+            .map(|r| r.total_byte_size())
+            .sum(),
+        compressed_size: data.len() as i64,
+        flattened: false,
+    };
+    db::file_list::local::set(&key, Some(meta.clone()), false).await?;
+    log::info!("[PARQUET_IMPORT] imported {source} as {key}");
+
+    Ok(ImportedFile {
+        source: source.to_string(),
+        key,
+        min_ts: meta.min_ts,
+        max_ts: meta.max_ts,
+        records: meta.records,
+        original_size: meta.original_size,
+        compressed_size: meta.compressed_size,
+    })
+}
+
+async fn read_footer(data: &Bytes) -> Result<(Arc<Schema>, Arc<ParquetMetaData>), anyhow::Error> {
+    let reader = ParquetRecordBatchStreamBuilder::new(Cursor::new(data.clone()))
+        .await
+        .map_err(|e| anyhow::anyhow!("not a parquet file: {e}"))?;
+    Ok((reader.schema().clone(), reader.metadata().clone()))
+}
+
+/// Every field of the file must be a field of the stream with the same type,
+/// the fields of the stream missing in the file are read as nulls
+fn check_schema(stream_schema: &Schema, file_schema: &Schema) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    match file_schema.field_with_name(&cfg.common.column_timestamp) {
+        Ok(f) if f.data_type() == &DataType::Int64 => {}
+        Ok(f) => {
+            return Err(anyhow::anyhow!(
+                "field {} must be Int64, found {}",
+                cfg.common.column_timestamp,
+                f.data_type()
+            ));
+        }
+        Err(_) => {
+            return Err(anyhow::anyhow!(
+                "field {} is missing",
+                cfg.common.column_timestamp
+            ));
+        }
+    }
+    for field in file_schema.fields() {
+        match stream_schema.field_with_name(field.name()) {
+            Ok(f) if f.data_type() == field.data_type() => {}
+            Ok(f) => {
+                return Err(anyhow::anyhow!(
+                    "field {} is {} in the stream, found {}",
+                    field.name(),
+                    f.data_type(),
+                    field.data_type()
+                ));
+            }
+            Err(_) => {
+                return Err(anyhow::anyhow!(
+                    "field {} is not in the stream schema",
+                    field.name()
+                ));
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Returns the time range of the records from the statistics of the
+/// timestamp column, required to register the file without reading it
+fn time_range(metadata: &ParquetMetaData) -> Result<(i64, i64), anyhow::Error> {
+    let column = get_config().common.column_timestamp.clone();
+    let mut range: Option<(i64, i64)> = None;
+    for row_group in metadata.row_groups() {
+        let stats = row_group
+            .columns()
+            .iter()
+            .find(|c| c.column_path().string() == column)
+            .and_then(|c| c.statistics());
+        let Some(Statistics::Int64(s)) = stats.filter(|s| s.has_min_max_set()) else {
+            return Err(anyhow::anyhow!(
+                "the statistics of the field {column} are missing"
+            ));
+        };
+        range = Some(match range {
+            Some((min, max)) => (min.min(*s.min()), max.max(*s.max())),
+            None => (*s.min(), *s.max()),
+        });
+    }
+    range.ok_or_else(|| anyhow::anyhow!("the file has no records"))
+}
+
+/// Returns the time partition of the records, e.g. `2024/05/01/10`, they must
+/// all be in the same one
+fn time_partition(
+    min_ts: i64,
+    max_ts: i64,
+    level: PartitionTimeLevel,
+) -> Result<String, anyhow::Error> {
+    let format = match level {
+        PartitionTimeLevel::Daily => "%Y/%m/%d/00",
+        PartitionTimeLevel::Unset | PartitionTimeLevel::Hourly => "%Y/%m/%d/%H",
+    };
+    let min_key = Utc
+        .timestamp_nanos(min_ts * 1000)
+        .format(format)
+        .to_string();
+    let max_key = Utc
+        .timestamp_nanos(max_ts * 1000)
+        .format(format)
+        .to_string();
+    if min_key != max_key {
+        return Err(anyhow::anyhow!(
+            "the records span the partitions {min_key} to {max_key}, the stream is partitioned {level}"
+        ));
+    }
+    Ok(min_key)
+}
+
+#[cfg(test)]
+mod tests {
+    use arrow_schema::Field;
+
+    use super::*;
+
+    #[test]
+    fn test_check_schema() {
+        let stream_schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("level", DataType::Utf8, true),
+            Field::new("took", DataType::Float64, true),
+        ]);
+        let file_schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("level", DataType::Utf8, true),
+        ]);
+        assert!(check_schema(&stream_schema, &file_schema).is_ok());
+
+        let file_schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("took", DataType::Int64, true),
+        ]);
+        assert!(check_schema(&stream_schema, &file_schema).is_err());
+
+        let file_schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("host", DataType::Utf8, true),
+        ]);
+        assert!(check_schema(&stream_schema, &file_schema).is_err());
+
+        let file_schema = Schema::new(vec![Field::new("level", DataType::Utf8, true)]);
+        assert!(check_schema(&stream_schema, &file_schema).is_err());
+    }
+
+    #[test]
+    fn test_time_partition() {
+        // 2024-05-01T10:00:00Z
+        let ts = 1714557600000000;
+        let hour = 3600 * 1_000_000;
+        assert_eq!(
+            time_partition(ts, ts + hour - 1, PartitionTimeLevel::Hourly).unwrap(),
+            "2024/05/01/10"
+        );
+        assert!(time_partition(ts, ts + hour, PartitionTimeLevel::Hourly).is_err());
+        assert_eq!(
+            time_partition(ts, ts + hour, PartitionTimeLevel::Daily).unwrap(),
+            "2024/05/01/00"
+        );
+    }
+
+    #[test]
+    fn test_check_source() {
+        assert!(check_source("default", "import/default/app/2024-05-01.parquet").is_ok());
+        assert!(check_source("default", "import/other/app/2024-05-01.parquet").is_err());
+        assert!(check_source("default", "import/default/../other/1.parquet").is_err());
+        assert!(check_source("default", "import/default/").is_err());
+        assert!(check_source(
+            "default",
+            "files/default/logs/app/2024/05/01/10/1.2.abc.parquet"
+        )
+        .is_err());
+    }
+}
@@ -19,17 +19,21 @@ use actix_web::{
     http::{self, StatusCode},
     HttpResponse,
 };
-use config::meta::stream::StreamType;
+use config::{meta::stream::StreamType, utils::json};
 
 use super::db;
 use crate::common::{
     infra::config::STREAM_FUNCTIONS,
     meta::{
+        config_versions::ConfigVersionList,
+        governance::GovernedKind,
         http::HttpResponse as MetaHttpResponse,
         pipelines::{PipeLine, PipeLineList},
     },
 };
 
+const PIPELINE_VERSION_KIND: &str = "pipeline";
+
 #[tracing::instrument(skip(pipeline))]
 pub async fn save_pipeline(org_id: String, pipeline: PipeLine) -> Result<HttpResponse, Error> {
     if let Some(_existing_pipeline) = check_existing_pipeline(
@@ -52,6 +56,7 @@ pub async fn save_pipeline(org_id: String, pipeline: PipeLine) -> Result<HttpRes
             )),
         );
     } else {
+        save_version(&org_id, &pipeline).await;
         Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
             http::StatusCode::OK.into(),
             "Pipeline saved successfully".to_string(),
@@ -93,12 +98,64 @@ pub async fn update_pipeline(
             )),
         );
     }
+    save_version(org_id, &pipeline).await;
     Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
         http::StatusCode::OK.into(),
         "Pipeline updated successfully".to_string(),
     )))
 }
 
+#[tracing::instrument]
+pub async fn list_pipeline_versions(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    pipeline_name: &str,
+) -> Result<HttpResponse, Error> {
+    let name = version_name(stream_type, stream_name, pipeline_name);
+    match db::config_versions::list(PIPELINE_VERSION_KIND, org_id, &name).await {
+        Ok(list) => Ok(MetaHttpResponse::json(ConfigVersionList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Makes a saved version of the pipeline the active one again
+#[tracing::instrument]
+pub async fn rollback_pipeline(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    pipeline_name: &str,
+    version: u64,
+) -> Result<HttpResponse, Error> {
+    let name = version_name(stream_type, stream_name, pipeline_name);
+    let saved = match db::config_versions::get(PIPELINE_VERSION_KIND, org_id, &name, version).await
+    {
+        Ok(saved) => saved,
+        Err(_) => {
+            return Ok(MetaHttpResponse::not_found("Pipeline version not found"));
+        }
+    };
+    let pipeline: PipeLine = match json::from_value(saved.definition) {
+        Ok(pipeline) => pipeline,
+        Err(e) => {
+            return Ok(MetaHttpResponse::internal_error(e));
+        }
+    };
+    update_pipeline(org_id, pipeline_name, pipeline).await
+}
+
+fn version_name(stream_type: StreamType, stream_name: &str, pipeline_name: &str) -> String {
+    format!("{stream_type}/{stream_name}/{pipeline_name}")
+}
+
+async fn save_version(org_id: &str, pipeline: &PipeLine) {
+    let name = version_name(pipeline.stream_type, &pipeline.stream_name, &pipeline.name);
+    if let Err(e) = db::config_versions::add(PIPELINE_VERSION_KIND, org_id, &name, pipeline).await {
+        log::error!("Error saving version of pipeline {}: {}", name, e);
+    }
+}
+
 #[tracing::instrument]
 pub async fn list_pipelines(
     org_id: String,
@@ -142,10 +199,20 @@ pub async fn delete_pipeline(
 ) -> Result<HttpResponse, Error> {
     let result = db::pipelines::delete(org_id, stream_type, stream_name, pipeline_name).await;
     match result {
-        Ok(_) => Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
-            http::StatusCode::OK.into(),
-            "Pipeline deleted".to_string(),
-        ))),
+        Ok(_) => {
+            let name = version_name(stream_type, stream_name, pipeline_name);
+            if let Err(e) = db::config_versions::delete(PIPELINE_VERSION_KIND, org_id, &name).await
+            {
+                log::error!("Error deleting versions of pipeline {}: {}", name, e);
+            }
+            if let Err(e) = db::governance::delete(org_id, GovernedKind::Pipelines, &name).await {
+                log::error!("Error deleting governance of pipeline {}: {}", name, e);
+            }
+            Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
+                http::StatusCode::OK.into(),
+                "Pipeline deleted".to_string(),
+            )))
+        }
         Err(e) => Ok(HttpResponse::NotFound().json(MetaHttpResponse::error(
             http::StatusCode::NOT_FOUND.into(),
             e.to_string(),
@@ -0,0 +1,468 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Plugins are gRPC sidecars adding ingestion sources, alert destinations and
+//! query functions. A plugin runs in its own process, so a crashing plugin can
+//! not take a node down, and every call goes through [`call`] which bounds it
+//! with the timeout and the concurrency of the plugin. A plugin failing
+//! `ZO_PLUGIN_MAX_FAILURES` calls in a row is suspended and its calls fail fast
+//! until `ZO_PLUGIN_SUSPEND_SECONDS` have passed or a health check succeeds.
+
+use std::{collections::HashMap, future::Future, sync::Arc, time::Duration};
+
+use actix_web::http;
+use chrono::Utc;
+use config::{get_config, utils::json};
+use once_cell::sync::Lazy;
+use parking_lot::Mutex;
+use proto::openobserve_plugin_v1::{
+    plugin_service_client::PluginServiceClient, DeliverRequest, DescribeRequest, FunctionRequest,
+    PullRequest, API_VERSION,
+};
+use tokio::sync::Semaphore;
+use tonic::transport::Channel;
+
+use super::db;
+use crate::common::{
+    infra::tls,
+    meta::plugins::{
+        parse_function_name, Plugin, PluginCapabilities, PluginState, PluginStatus, PluginTarget,
+        PluginWithStatus,
+    },
+};
+
+/// Seconds to wait for the connection to a plugin
+const CONNECT_TIMEOUT: u64 = 5;
+
+/// Health of the plugins as seen by this node, by name
+static STATUSES: Lazy<Mutex<HashMap<String, PluginStatus>>> = Lazy::new(Default::default);
+/// Channels by endpoint, they reconnect by themselves
+static CHANNELS: Lazy<Mutex<HashMap<String, Channel>>> = Lazy::new(Default::default);
+/// Calls in flight by plugin, with the concurrency they were created for
+static PERMITS: Lazy<Mutex<HashMap<String, (usize, Arc<Semaphore>)>>> = Lazy::new(Default::default);
+
+fn client(endpoint: &str) -> Result<PluginServiceClient<Channel>, anyhow::Error> {
+    let mut channels = CHANNELS.lock();
+    let channel = match channels.get(endpoint) {
+        Some(channel) => channel.clone(),
+        None => {
+            let cfg = get_config();
+            let ca_file = if cfg.plugin.tls_ca_file.is_empty() {
+                &cfg.tls.ca_file
+            } else {
+                &cfg.plugin.tls_ca_file
+            };
+            let channel = tls::external_grpc_channel(
+                endpoint,
+                ca_file,
+                Duration::from_secs(CONNECT_TIMEOUT),
+            )?;
+            channels.insert(endpoint.to_string(), channel.clone());
+            channel
+        }
+    };
+    Ok(PluginServiceClient::new(channel))
+}
+
+fn permits(plugin: &Plugin) -> Arc<Semaphore> {
+    let mut permits = PERMITS.lock();
+    match permits.get(&plugin.name) {
+        Some((max, semaphore)) if *max == plugin.max_concurrency => semaphore.clone(),
+        _ => {
+            let semaphore = Arc::new(Semaphore::new(plugin.max_concurrency));
+            permits.insert(
+                plugin.name.clone(),
+                (plugin.max_concurrency, semaphore.clone()),
+            );
+            semaphore
+        }
+    }
+}
+
+/// Returns false while the plugin is suspended. Once the suspension is over
+/// the next call goes through, and suspends the plugin again if it fails.
+fn is_callable(status: &PluginStatus, now: i64) -> bool {
+    status.state != PluginState::Suspended || status.suspended_until <= now
+}
+
+fn record_success(status: &mut PluginStatus, now: i64) {
+    status.state = PluginState::Healthy;
+    status.consecutive_failures = 0;
+    status.suspended_until = 0;
+    status.last_error = None;
+    status.last_checked_at = now;
+}
+
+fn record_failure(
+    status: &mut PluginStatus,
+    error: String,
+    now: i64,
+    max_failures: u32,
+    suspend_seconds: i64,
+) {
+    status.consecutive_failures += 1;
+    status.last_error = Some(error);
+    status.last_checked_at = now;
+    if status.consecutive_failures >= max_failures {
+        status.state = PluginState::Suspended;
+        status.suspended_until = now + suspend_seconds * 1_000_000;
+    }
+}
+
+fn update_status(name: &str, ret: &Result<(), String>) {
+    let cfg = get_config();
+    let now = Utc::now().timestamp_micros();
+    let mut statuses = STATUSES.lock();
+    let status = statuses.entry(name.to_string()).or_default();
+    match ret {
+        Ok(()) => record_success(status, now),
+        Err(e) => {
+            let was_suspended = status.state == PluginState::Suspended;
+            record_failure(
+                status,
+                e.clone(),
+                now,
+                cfg.plugin.max_failures,
+                cfg.plugin.suspend_seconds,
+            );
+            if !was_suspended && status.state == PluginState::Suspended {
+                log::warn!(
+                    "[PLUGIN] {name} suspended after {} failed calls: {e}",
+                    status.consecutive_failures
+                );
+            }
+        }
+    }
+}
+
+/// Returns the status of the plugin on this node.
+pub fn get_status(plugin: &Plugin) -> PluginStatus {
+    if !plugin.enabled {
+        return PluginStatus {
+            state: PluginState::Disabled,
+            ..Default::default()
+        };
+    }
+    STATUSES
+        .lock()
+        .get(&plugin.name)
+        .cloned()
+        .unwrap_or_default()
+}
+
+/// Calls the plugin, isolating the caller from a slow or failing plugin.
+async fn call<T, F, Fut>(name: &str, f: F) -> Result<T, anyhow::Error>
+where
+    F: FnOnce(PluginServiceClient<Channel>) -> Fut,
+    Fut: Future<Output = Result<tonic::Response<T>, tonic::Status>>,
+{
+    let Some(plugin) = db::plugins::get(name) else {
+        return Err(anyhow::anyhow!("plugin {name} not found"));
+    };
+    if !plugin.enabled {
+        return Err(anyhow::anyhow!("plugin {name} is disabled"));
+    }
+    if !is_callable(&get_status(&plugin), Utc::now().timestamp_micros()) {
+        return Err(anyhow::anyhow!(
+            "plugin {name} is suspended after repeated failures"
+        ));
+    }
+    let semaphore = permits(&plugin);
+    let Ok(_permit) = semaphore.try_acquire() else {
+        return Err(anyhow::anyhow!(
+            "plugin {name} is busy, {} calls in flight",
+            plugin.max_concurrency
+        ));
+    };
+    let client = client(&plugin.endpoint)?;
+    let ret = match tokio::time::timeout(Duration::from_secs(plugin.timeout), f(client)).await {
+        Ok(Ok(resp)) => Ok(resp.into_inner()),
+        Ok(Err(status)) => Err(format!("{}: {}", status.code(), status.message())),
+        Err(_) => Err(format!("timed out after {} seconds", plugin.timeout)),
+    };
+    update_status(name, &ret.as_ref().map(|_| ()).map_err(|e| e.clone()));
+    ret.map_err(|e| anyhow::anyhow!("plugin {name} error: {e}"))
+}
+
+/// Asks the plugin what it implements, without going through the cache so it
+/// works before the plugin is registered.
+async fn describe(plugin: &Plugin) -> Result<PluginCapabilities, anyhow::Error> {
+    let mut client = client(&plugin.endpoint)?;
+    let req = DescribeRequest {
+        api_version: API_VERSION.to_string(),
+    };
+    let resp = tokio::time::timeout(Duration::from_secs(plugin.timeout), client.describe(req))
+        .await
+        .map_err(|_| anyhow::anyhow!("timed out after {} seconds", plugin.timeout))??
+        .into_inner();
+    Ok(PluginCapabilities {
+        version: resp.version,
+        source: resp.source,
+        destination: resp.destination,
+        functions: resp.functions,
+    })
+}
+
+/// Registers or updates the plugin, the plugin should be reachable so its
+/// capabilities can be recorded.
+pub async fn save(
+    mut plugin: Plugin,
+    create: bool,
+) -> Result<Plugin, (http::StatusCode, anyhow::Error)> {
+    plugin.name = plugin.name.trim().to_string();
+    plugin.endpoint = plugin.endpoint.trim().trim_end_matches('/').to_string();
+    if let Err(e) = plugin.validate() {
+        return Err((http::StatusCode::BAD_REQUEST, anyhow::anyhow!(e)));
+    }
+    let now = Utc::now().timestamp_micros();
+    match db::plugins::get(&plugin.name) {
+        Some(_) if create => {
+            return Err((
+                http::StatusCode::BAD_REQUEST,
+                anyhow::anyhow!("Plugin {} already exists", plugin.name),
+            ));
+        }
+        Some(stored) => plugin.created_at = stored.created_at,
+        None if !create => {
+            return Err((
+                http::StatusCode::NOT_FOUND,
+                anyhow::anyhow!("Plugin not found"),
+            ));
+        }
+        None => plugin.created_at = now,
+    }
+    plugin.updated_at = now;
+    plugin.capabilities = match describe(&plugin).await {
+        Ok(capabilities) => capabilities,
+        Err(e) => {
+            return Err((
+                http::StatusCode::BAD_REQUEST,
+                anyhow::anyhow!("Plugin handshake failed: {e}"),
+            ));
+        }
+    };
+    if let Err(e) = db::plugins::set(&plugin).await {
+        return Err((http::StatusCode::INTERNAL_SERVER_ERROR, e));
+    }
+    STATUSES.lock().remove(&plugin.name);
+    Ok(plugin)
+}
+
+pub fn list() -> Vec<PluginWithStatus> {
+    db::plugins::list()
+        .into_iter()
+        .map(|plugin| PluginWithStatus {
+            status: get_status(&plugin),
+            plugin,
+        })
+        .collect()
+}
+
+pub fn get(name: &str) -> Option<PluginWithStatus> {
+    db::plugins::get(name).map(|plugin| PluginWithStatus {
+        status: get_status(&plugin),
+        plugin,
+    })
+}
+
+/// Returns false when there is no such plugin.
+pub async fn set_enabled(name: &str, enabled: bool) -> Result<bool, anyhow::Error> {
+    let Some(mut plugin) = db::plugins::get(name) else {
+        return Ok(false);
+    };
+    plugin.enabled = enabled;
+    plugin.updated_at = Utc::now().timestamp_micros();
+    db::plugins::set(&plugin).await?;
+    STATUSES.lock().remove(name);
+    Ok(true)
+}
+
+/// Returns false when there is no such plugin.
+pub async fn delete(name: &str) -> Result<bool, anyhow::Error> {
+    if db::plugins::get(name).is_none() {
+        return Ok(false);
+    }
+    db::plugins::delete(name).await?;
+    STATUSES.lock().remove(name);
+    PERMITS.lock().remove(name);
+    Ok(true)
+}
+
+/// Checks the enabled plugins, a successful check ends the suspension of a
+/// plugin and records the capabilities of a plugin upgraded in place.
+pub async fn check_health() {
+    for plugin in db::plugins::list() {
+        if !plugin.enabled {
+            continue;
+        }
+        let ret = match describe(&plugin).await {
+            Ok(capabilities) => {
+                if capabilities != plugin.capabilities {
+                    log::info!(
+                        "[PLUGIN] {} capabilities changed, version {}",
+                        plugin.name,
+                        capabilities.version
+                    );
+                    // the stored plugin, it may have changed since the list
+                    if let Some(mut stored) = db::plugins::get(&plugin.name) {
+                        stored.capabilities = capabilities;
+                        stored.updated_at = Utc::now().timestamp_micros();
+                        if let Err(e) = db::plugins::set(&stored).await {
+                            log::error!("[PLUGIN] {} save capabilities error: {e}", plugin.name);
+                        }
+                    }
+                }
+                Ok(())
+            }
+            Err(e) => {
+                log::warn!("[PLUGIN] {} health check failed: {e}", plugin.name);
+                Err(e.to_string())
+            }
+        };
+        update_status(&plugin.name, &ret);
+    }
+}
+
+/// Pulls the records after the cursor from a source plugin, returns the
+/// records and the cursor of the next pull.
+pub async fn pull(
+    org_id: &str,
+    puller: &str,
+    target: &PluginTarget,
+    cursor: &str,
+    max_records: i64,
+) -> Result<(Vec<json::Value>, String), anyhow::Error> {
+    check_source(&target.plugin)?;
+    let req = PullRequest {
+        org_id: org_id.to_string(),
+        puller: puller.to_string(),
+        config: json::to_string(&target.config)?,
+        cursor: cursor.to_string(),
+        max_records,
+    };
+    let resp = call(&target.plugin, |mut client| async move {
+        client.pull(req).await
+    })
+    .await?;
+    let records: Vec<json::Value> = if resp.records.is_empty() {
+        vec![]
+    } else {
+        json::from_slice(&resp.records)?
+    };
+    Ok((records, resp.cursor))
+}
+
+/// Delivers a notification through a destination plugin.
+pub async fn deliver(
+    org_id: &str,
+    destination: &str,
+    target: &PluginTarget,
+    title: &str,
+    message: String,
+) -> Result<(), anyhow::Error> {
+    check_destination(&target.plugin)?;
+    let req = DeliverRequest {
+        org_id: org_id.to_string(),
+        destination: destination.to_string(),
+        config: json::to_string(&target.config)?,
+        title: title.to_string(),
+        message,
+    };
+    call(&target.plugin, |mut client| async move {
+        client.deliver(req).await
+    })
+    .await?;
+    Ok(())
+}
+
+/// Runs the rows of a search result through a query function, named
+/// `<plugin>/<function>`.
+pub async fn call_function(
+    org_id: &str,
+    name: &str,
+    rows: &[json::Value],
+) -> Result<Vec<json::Value>, anyhow::Error> {
+    let Some((plugin, function)) = parse_function_name(name) else {
+        return Err(anyhow::anyhow!(
+            "invalid plugin function {name}, expected <plugin>/<function>"
+        ));
+    };
+    check_capability(plugin, |c| c.functions.iter().any(|f| f == function), name)?;
+    let req = FunctionRequest {
+        org_id: org_id.to_string(),
+        function: function.to_string(),
+        rows: json::to_vec(rows)?,
+    };
+    let resp = call(plugin, |mut client| async move {
+        client.call_function(req).await
+    })
+    .await?;
+    Ok(json::from_slice(&resp.rows)?)
+}
+
+/// Checks that a log puller can use the plugin.
+pub fn check_source(name: &str) -> Result<(), anyhow::Error> {
+    check_capability(name, |c| c.source, "source")
+}
+
+/// Checks that an alert destination can use the plugin.
+pub fn check_destination(name: &str) -> Result<(), anyhow::Error> {
+    check_capability(name, |c| c.destination, "destination")
+}
+
+fn check_capability(
+    name: &str,
+    has: impl Fn(&PluginCapabilities) -> bool,
+    what: &str,
+) -> Result<(), anyhow::Error> {
+    match db::plugins::get(name) {
+        Some(plugin) if has(&plugin.capabilities) => Ok(()),
+        Some(_) => Err(anyhow::anyhow!("plugin {name} does not provide {what}")),
+        None => Err(anyhow::anyhow!("plugin {name} not found")),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_suspend_after_max_failures() {
+        let mut status = PluginStatus::default();
+        record_failure(&mut status, "e1".to_string(), 1_000_000, 2, 60);
+        assert_eq!(status.state, PluginState::Unknown);
+        assert!(is_callable(&status, 1_000_000));
+
+        record_failure(&mut status, "e2".to_string(), 2_000_000, 2, 60);
+        assert_eq!(status.state, PluginState::Suspended);
+        assert_eq!(status.suspended_until, 62_000_000);
+        assert!(!is_callable(&status, 61_999_999));
+        // the suspension is over, the next call probes the plugin
+        assert!(is_callable(&status, 62_000_000));
+
+        record_success(&mut status, 63_000_000);
+        assert_eq!(status.state, PluginState::Healthy);
+        assert_eq!(status.consecutive_failures, 0);
+        assert_eq!(status.last_error, None);
+    }
+
+    #[test]
+    fn test_parse_function_name() {
+        assert_eq!(parse_function_name("geo/enrich"), Some(("geo", "enrich")));
+        assert_eq!(parse_function_name("geo"), None);
+        assert_eq!(parse_function_name("/enrich"), None);
+        assert_eq!(parse_function_name("geo/"), None);
+    }
+}
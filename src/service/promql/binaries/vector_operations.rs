@@ -259,25 +259,54 @@ fn vector_arithmatic_operators(
         }
     };
 
-    // Get the hash for the labels on the right
-    let rhs_sig: HashMap<Signature, InstantValue> = right
-        .par_iter()
-        .map(|instant| {
+    // The "one" side is hashed and the "many" side iterated, for group_right the
+    // "one" side is the left one. Without grouping both sides are "one" sides.
+    let card = expr
+        .modifier
+        .as_ref()
+        .map(|modifier| modifier.card.clone())
+        .unwrap_or(VectorMatchCardinality::OneToOne);
+    let (one_side, many_side) = match card {
+        VectorMatchCardinality::OneToMany(_) => (left, right),
+        _ => (right, left),
+    };
+    let mut one_sigs: HashMap<Signature, &InstantValue> = HashMap::with_capacity(one_side.len());
+    for instant in one_side.iter() {
+        let signature = labels_to_compare(&instant.labels).signature();
+        if one_sigs.insert(signature, instant).is_some() {
+            return Err(DataFusionError::Plan(
+                "many-to-many matching not allowed: matching labels must be unique on one side"
+                    .to_string(),
+            ));
+        }
+    }
+    if card == VectorMatchCardinality::OneToOne {
+        let mut many_sigs = HashSet::with_capacity(many_side.len());
+        for instant in many_side.iter() {
             let signature = labels_to_compare(&instant.labels).signature();
-            (signature, instant.clone())
-        })
-        .collect();
+            if one_sigs.contains_key(&signature) && !many_sigs.insert(signature) {
+                return Err(DataFusionError::Plan(
+                    "multiple matches for labels: many-to-one matching must be explicit (group_left/group_right)"
+                        .to_string(),
+                ));
+            }
+        }
+    }
 
-    // Iterate over left and pick up the corresponding instance from rhs
-    let output: Vec<InstantValue> = left
+    // Iterate over the "many" side and pick up the matching instance of the "one" side
+    let output: Vec<InstantValue> = many_side
         .par_iter()
-        .flat_map(|instant| {
-            let left_sig = labels_to_compare(&instant.labels).signature();
-            rhs_sig
-                .get(&left_sig)
-                .map(|rhs_instant| (instant, rhs_instant))
+        .flat_map(|many_instant| {
+            let many_sig = labels_to_compare(&many_instant.labels).signature();
+            one_sigs
+                .get(&many_sig)
+                .map(|one_instant| (many_instant, *one_instant))
         })
-        .flat_map(|(lhs_instant, rhs_instant)| {
+        .flat_map(|(many_instant, one_instant)| {
+            let (lhs_instant, rhs_instant) = match card {
+                VectorMatchCardinality::OneToMany(_) => (one_instant, many_instant),
+                _ => (many_instant, one_instant),
+            };
             scalar_binary_operations(
                 operator,
                 lhs_instant.sample.value,
@@ -287,31 +316,32 @@ fn vector_arithmatic_operators(
             )
             .ok()
             .map(|value| {
+                // the output keeps the labels of the "many" side
                 let mut labels = if return_bool || DROP_METRIC_VECTOR_BIN_OP.contains(&operator) {
-                    lhs_instant.labels.without_metric_name()
+                    many_instant.labels.without_metric_name()
                 } else {
-                    lhs_instant.labels.clone()
+                    many_instant.labels.clone()
                 };
 
-                if let Some(modifier) = expr.modifier.as_ref() {
-                    if modifier.card == VectorMatchCardinality::OneToOne {
-                        labels = labels_to_compare(&labels);
-                    }
+                if card == VectorMatchCardinality::OneToOne {
+                    labels = labels_to_compare(&labels);
+                }
 
-                    // group_labels from the `group_x` modifier are taken from the "one"-side.
-                    if let Some(group_labels) = modifier.card.labels() {
-                        for ln in group_labels.labels.iter() {
-                            let value = rhs_instant.labels.get_value(ln);
-                            if !value.is_empty() {
-                                labels.set(ln, &value);
-                            }
+                // group_labels from the `group_x` modifier are taken from the "one"-side,
+                // e.g. the team of an info metric, an empty value drops the label
+                if let Some(group_labels) = card.labels() {
+                    for ln in group_labels.labels.iter() {
+                        let value = one_instant.labels.get_value(ln);
+                        labels = labels.without_label(ln);
+                        if !value.is_empty() {
+                            labels.set(ln, &value);
                         }
                     }
                 }
                 InstantValue {
                     labels,
                     sample: Sample {
-                        timestamp: lhs_instant.sample.timestamp,
+                        timestamp: many_instant.sample.timestamp,
                         value,
                     },
                 }
@@ -355,3 +385,113 @@ pub fn vector_bin_op(
         _ => vector_arithmatic_operators(expr, left, right),
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use promql_parser::parser::{self, Expr};
+
+    use super::*;
+
+    fn instant(labels: &[(&str, &str)], value: f64) -> InstantValue {
+        InstantValue {
+            labels: labels
+                .iter()
+                .map(|(name, value)| Arc::new(Label::new(*name, *value)))
+                .collect(),
+            sample: Sample::new(1_000_000, value),
+        }
+    }
+
+    fn binary_expr(query: &str) -> BinaryExpr {
+        match parser::parse(query).unwrap() {
+            Expr::Binary(expr) => expr,
+            _ => unreachable!(),
+        }
+    }
+
+    fn values(value: Value) -> Vec<(String, f64)> {
+        let Value::Vector(values) = value else {
+            unreachable!()
+        };
+        let mut values = values
+            .into_iter()
+            .map(|v| {
+                let mut labels = v
+                    .labels
+                    .iter()
+                    .map(|l| format!("{}={}", l.name, l.value))
+                    .collect::<Vec<_>>();
+                labels.sort();
+                (labels.join(","), v.sample.value)
+            })
+            .collect::<Vec<_>>();
+        values.sort_by(|a, b| a.0.cmp(&b.0));
+        values
+    }
+
+    #[test]
+    fn test_group_left_info_join() {
+        let requests = vec![
+            instant(
+                &[("__name__", "requests"), ("job", "api"), ("path", "/a")],
+                2.0,
+            ),
+            instant(
+                &[("__name__", "requests"), ("job", "api"), ("path", "/b")],
+                3.0,
+            ),
+            instant(
+                &[("__name__", "requests"), ("job", "web"), ("path", "/a")],
+                4.0,
+            ),
+        ];
+        let info = vec![
+            instant(
+                &[
+                    ("__name__", "job_info"),
+                    ("job", "api"),
+                    ("team", "payments"),
+                ],
+                1.0,
+            ),
+            instant(
+                &[("__name__", "job_info"), ("job", "web"), ("team", "")],
+                1.0,
+            ),
+        ];
+        let expr = binary_expr("requests * on(job) group_left(team) job_info");
+        let ret = values(vector_bin_op(&expr, &requests, &info).unwrap());
+        assert_eq!(
+            ret,
+            vec![
+                ("job=api,path=/a,team=payments".to_string(), 2.0),
+                ("job=api,path=/b,team=payments".to_string(), 3.0),
+                ("job=web,path=/a".to_string(), 4.0),
+            ]
+        );
+
+        // the same join with the info metric on the left
+        let expr = binary_expr("job_info * on(job) group_right(team) requests");
+        let ret2 = values(vector_bin_op(&expr, &info, &requests).unwrap());
+        assert_eq!(ret, ret2);
+    }
+
+    #[test]
+    fn test_duplicate_one_side() {
+        let left = vec![instant(&[("job", "api"), ("path", "/a")], 1.0)];
+        let right = vec![
+            instant(&[("job", "api"), ("team", "a")], 1.0),
+            instant(&[("job", "api"), ("team", "b")], 1.0),
+        ];
+        let expr = binary_expr("left * on(job) group_left(team) right");
+        assert!(vector_bin_op(&expr, &left, &right).is_err());
+
+        let left = vec![
+            instant(&[("job", "api"), ("path", "/a")], 1.0),
+            instant(&[("job", "api"), ("path", "/b")], 1.0),
+        ];
+        let right = vec![instant(&[("job", "api")], 1.0)];
+        let expr = binary_expr("left * on(job) right");
+        assert!(vector_bin_op(&expr, &left, &right).is_err());
+    }
+}
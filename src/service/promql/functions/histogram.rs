@@ -25,14 +25,13 @@ use crate::{
 
 // https://github.com/prometheus/prometheus/blob/cf1bea344a3c390a90c35ea8764c4a468b345d5e/promql/quantile.go#L33
 #[derive(Debug, Clone, Copy, PartialEq)]
-struct Bucket {
+pub(crate) struct Bucket {
     upper_bound: f64,
     count: f64,
 }
 
 impl Bucket {
-    #[allow(dead_code)]
-    fn new(upper_bound: f64, count: f64) -> Self {
+    pub(crate) fn new(upper_bound: f64, count: f64) -> Self {
         Self { upper_bound, count }
     }
 }
@@ -44,6 +43,10 @@ struct MetricWithBuckets {
     buckets: Vec<Bucket>,
 }
 
+/// OTLP exponential histograms are stored as cumulative `le` buckets at
+/// ingestion (see `service::metrics::exp_histogram`), so they are handled
+/// here like conventional histograms.
+///
 /// TODO: support native histograms; see [`histogramQuantile`]
 ///
 /// [`histogramQuantile`]: https://github.com/prometheus/prometheus/blob/f7c6130ff27a2a12412c02cce223f7a8abc59e49/promql/quantile.go#L146
@@ -99,7 +102,7 @@ pub(crate) fn histogram_quantile(sample_time: i64, phi: f64, data: Value) -> Res
 }
 
 // cf. https://github.com/prometheus/prometheus/blob/cf1bea344a3c390a90c35ea8764c4a468b345d5e/promql/quantile.go#L76
-fn bucket_quantile(phi: f64, mut buckets: Vec<Bucket>) -> f64 {
+pub(crate) fn bucket_quantile(phi: f64, mut buckets: Vec<Bucket>) -> f64 {
     if phi.is_nan() || buckets.is_empty() {
         return f64::NAN;
     }
@@ -203,6 +206,34 @@ mod tests {
 
     use super::*;
 
+    #[test]
+    fn test_bucket_quantile_exp_histogram() {
+        use crate::service::metrics::exp_histogram::ExpHistogram;
+
+        // two series recorded at different scales, merged by `le`
+        let fine = ExpHistogram {
+            scale: 1,
+            count: 4,
+            positive: Some((0, vec![1, 1, 1, 1])),
+            ..Default::default()
+        };
+        let coarse = ExpHistogram {
+            scale: 0,
+            count: 4,
+            positive: Some((0, vec![2, 2])),
+            ..Default::default()
+        };
+        let buckets = fine
+            .cumulative_buckets(0)
+            .into_iter()
+            .chain(coarse.cumulative_buckets(0))
+            .map(|(le, count)| Bucket::new(le, count))
+            .collect::<Vec<_>>();
+        // 8 observations: 4 in (1, 2] and 4 in (2, 4]
+        assert_eq!(bucket_quantile(0.5, buckets.clone()), 2.0);
+        assert_eq!(bucket_quantile(0.75, buckets), 3.0);
+    }
+
     #[test]
     fn test_coalesce_buckets() {
         let buckets = vec![
@@ -56,7 +56,7 @@ pub(crate) use clamp::clamp;
 pub(crate) use count_over_time::count_over_time;
 pub(crate) use delta::delta;
 pub(crate) use deriv::deriv;
-pub(crate) use histogram::histogram_quantile;
+pub(crate) use histogram::{bucket_quantile, histogram_quantile, Bucket};
 pub(crate) use holt_winters::holt_winters;
 pub(crate) use idelta::idelta;
 pub(crate) use increase::increase;
@@ -14,12 +14,13 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 use std::{
+    collections::HashSet,
     sync::Arc,
     time::{Duration, SystemTime, UNIX_EPOCH},
 };
 
 use async_trait::async_trait;
-use config::meta::search::ScanStats;
+use config::meta::{search::ScanStats, stream::StreamType};
 use datafusion::{arrow::datatypes::Schema, error::Result, prelude::SessionContext};
 use serde::{Deserialize, Serialize};
 use utoipa::ToSchema;
@@ -29,7 +30,7 @@ mod binaries;
 pub mod common;
 mod engine;
 mod exec;
-mod functions;
+pub(crate) mod functions;
 pub mod name_visitor;
 pub mod search;
 pub mod value;
@@ -40,6 +41,7 @@ pub use exec::Query;
 pub(crate) const DEFAULT_LOOKBACK: Duration = Duration::from_secs(300); // 5m
 pub(crate) const MINIMAL_INTERVAL: Duration = Duration::from_secs(10); // 10s
 pub(crate) const MAX_DATA_POINTS: i64 = 256; // Width of panel
+pub(crate) const MAX_POINTS_PER_SERIES: i64 = 11_000; // Same as prometheus
 
 #[async_trait]
 pub trait TableProvider: Sync + Send + 'static {
@@ -141,6 +143,32 @@ pub(crate) fn micros_since_epoch(t: SystemTime) -> i64 {
     )
 }
 
+/// Returns the finest step the queried metrics can be evaluated at, that is
+/// the finest resolution among their streams, or `MINIMAL_INTERVAL` when none
+/// of them has one.
+pub(crate) async fn minimal_step(org_id: &str, query: &str) -> i64 {
+    let mut step = micros(MINIMAL_INTERVAL);
+    let Ok(ast) = promql_parser::parser::parse(query) else {
+        return step;
+    };
+    let mut visitor = name_visitor::MetricNameVisitor {
+        name: HashSet::new(),
+    };
+    if promql_parser::util::walk_expr(&mut visitor, &ast).is_err() {
+        return step;
+    }
+    for name in visitor.name {
+        let resolution = infra::schema::get_settings(org_id, &name, StreamType::Metrics)
+            .await
+            .map(|settings| settings.resolution_ms)
+            .unwrap_or_default();
+        if resolution > 0 {
+            step = step.min(resolution * 1000);
+        }
+    }
+    step
+}
+
 pub(crate) fn micros(t: Duration) -> i64 {
     t.as_micros()
         .try_into()
@@ -148,6 +148,8 @@ pub(crate) async fn create_context(
         storage_type: StorageType::Memory,
         search_type: SearchType::Normal,
         work_group: None,
+        sorted_by_time: true,
+        primary_key: None,
     };
 
     let ctx = register_table(
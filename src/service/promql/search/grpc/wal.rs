@@ -37,14 +37,16 @@ use proto::cluster_rpc;
 use tonic::{
     codec::CompressionEncoding,
     metadata::{MetadataKey, MetadataValue},
-    transport::Channel,
     Request,
 };
 use tracing::{info_span, Instrument};
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 
 use crate::{
-    common::infra::cluster::{get_cached_online_ingester_nodes, get_internal_grpc_token},
+    common::infra::{
+        cluster::{get_cached_online_ingester_nodes, get_internal_grpc_token},
+        tls,
+    },
     service::search::{
         datafusion::exec::{prepare_datafusion_context, register_table},
         MetadataMap,
@@ -178,6 +180,8 @@ pub(crate) async fn create_context(
         storage_type: StorageType::Tmpfs,
         search_type: SearchType::Normal,
         work_group: None,
+        sorted_by_time: true,
+        primary_key: None,
     };
 
     let ctx = register_table(
@@ -255,7 +259,7 @@ async fn get_file_list(
                 let token: MetadataValue<_> = get_internal_grpc_token()
                     .parse()
                     .map_err(|_| DataFusionError::Execution("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
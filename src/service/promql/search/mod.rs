@@ -33,14 +33,13 @@ use proto::cluster_rpc;
 use tonic::{
     codec::CompressionEncoding,
     metadata::{MetadataKey, MetadataValue},
-    transport::Channel,
     Request,
 };
 use tracing::{info_span, Instrument};
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 
 use crate::{
-    common::infra::cluster,
+    common::infra::{cluster, tls},
     service::{
         promql::{micros, value::*, MetricsQueryRequest, DEFAULT_LOOKBACK},
         search::{server_internal_error, MetadataMap},
@@ -175,7 +174,7 @@ async fn search_in_cluster(
                 let token: MetadataValue<_> = cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -173,7 +173,12 @@ impl Serialize for Sample {
         S: Serializer,
     {
         let mut seq = serializer.serialize_seq(Some(2))?;
-        seq.serialize_element(&(self.timestamp / 1_000_000))?;
+        if self.timestamp % 1_000_000 == 0 {
+            seq.serialize_element(&(self.timestamp / 1_000_000))?;
+        } else {
+            // sub-second samples, in seconds with millisecond precision
+            seq.serialize_element(&((self.timestamp / 1_000) as f64 / 1_000.0))?;
+        }
         seq.serialize_element(&self.value.to_string())?;
         seq.end()
     }
@@ -747,4 +752,18 @@ mod tests {
             assert_eq!(expect.name, got.name, "{:?}", &output_kept);
         }
     }
+
+    #[test]
+    fn test_sample_serialize() {
+        let sample = Sample::new(1_700_000_000_000_000, 1.5);
+        assert_eq!(
+            config::utils::json::to_string(&sample).unwrap(),
+            r#"[1700000000,"1.5"]"#
+        );
+        let sample = Sample::new(1_700_000_000_250_000, 2.0);
+        assert_eq!(
+            config::utils::json::to_string(&sample).unwrap(),
+            r#"[1700000000.25,"2"]"#
+        );
+    }
 }
@@ -0,0 +1,518 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Static checks and cost estimation of a query before running it.
+
+use std::{collections::HashMap, time::Duration};
+
+use anyhow::Result;
+use config::{
+    get_config,
+    meta::{
+        sql::Sql,
+        stream::{PartitionTimeLevel, StreamType},
+    },
+};
+use once_cell::sync::Lazy;
+use promql_parser::{
+    label::MatchOp,
+    parser::{self, Expr, VectorSelector},
+};
+use regex::Regex;
+
+use crate::{
+    common::meta::{
+        prom::MetricType,
+        query_analyze::{
+            LintWarning, QueryAnalyzeRequest, QueryAnalyzeResponse, QueryLanguage, StreamCost,
+        },
+    },
+    service::{
+        file_list, format_stream_name, metrics::get_prom_metadata_from_schema,
+        promql::DEFAULT_LOOKBACK,
+    },
+};
+
+/// Functions which are meant to be applied on counters
+const COUNTER_FUNCTIONS: [&str; 4] = ["rate", "irate", "increase", "resets"];
+/// Prometheus refuses to evaluate more points than this in a subquery
+const MAX_SUBQUERY_POINTS: u128 = 11000;
+const LONG_RANGE: Duration = Duration::from_secs(24 * 3600);
+
+static RE_LEADING_WILDCARD: Lazy<Regex> = Lazy::new(|| Regex::new(r"(?i)\blike\s+'%").unwrap());
+static RE_REGEX_FILTER: Lazy<Regex> =
+    Lazy::new(|| Regex::new(r"(?i)\b(re_match|re_not_match)\s*\(").unwrap());
+
+#[derive(Clone, Debug, Default, PartialEq)]
+struct Selector {
+    name: Option<String>,
+    range: Option<Duration>,
+    /// the function the range vector is passed to
+    func: Option<String>,
+}
+
+pub async fn analyze(
+    org_id: &str,
+    stream_type: StreamType,
+    req: &QueryAnalyzeRequest,
+) -> Result<QueryAnalyzeResponse> {
+    let end_time = if req.end_time > 0 {
+        req.end_time
+    } else {
+        chrono::Utc::now().timestamp_micros()
+    };
+    match req.query_type {
+        QueryLanguage::Sql => analyze_sql(org_id, stream_type, req, end_time).await,
+        QueryLanguage::Promql => analyze_promql(org_id, req, end_time).await,
+    }
+}
+
+async fn analyze_sql(
+    org_id: &str,
+    stream_type: StreamType,
+    req: &QueryAnalyzeRequest,
+    end_time: i64,
+) -> Result<QueryAnalyzeResponse> {
+    let sql = Sql::new(&req.query)?;
+    let warnings = lint_sql(&req.query, &sql, req.start_time);
+    let (start_time, end_time) = match sql.time_range {
+        Some((start, end)) if start > 0 || end > 0 => (start, if end > 0 { end } else { end_time }),
+        _ => (req.start_time, end_time),
+    };
+    let cost = estimate_stream(org_id, &sql.source, stream_type, start_time, end_time).await?;
+    Ok(QueryAnalyzeResponse {
+        files: cost.files,
+        records: cost.records,
+        compressed_size: cost.compressed_size,
+        series: cost.series,
+        samples_evaluated: None,
+        streams: vec![cost],
+        warnings,
+    })
+}
+
+fn lint_sql(query: &str, sql: &Sql, start_time: i64) -> Vec<LintWarning> {
+    let cfg = get_config();
+    let mut warnings = Vec::new();
+    if start_time == 0 && sql.time_range.is_none() {
+        warnings.push(LintWarning::new(
+            "missing_time_range",
+            "query has no time range, every file of the stream will be scanned",
+        ));
+    }
+    if sql.selection.is_none() && sql.group_by.is_empty() {
+        warnings.push(LintWarning::new(
+            "no_filter",
+            "query has neither a WHERE clause nor an aggregation",
+        ));
+    }
+    if RE_LEADING_WILDCARD.is_match(query) {
+        warnings.push(LintWarning::new(
+            "leading_wildcard",
+            "LIKE patterns starting with % scan every value, prefer match_all() or str_match()",
+        ));
+    }
+    if RE_REGEX_FILTER.is_match(query) {
+        warnings.push(LintWarning::new(
+            "regex_filter",
+            "regular expression filters are evaluated on every row",
+        ));
+    }
+    if sql.group_by.is_empty()
+        && sql
+            .order_by
+            .iter()
+            .any(|(field, _)| field != &cfg.common.column_timestamp)
+    {
+        warnings.push(LintWarning::new(
+            "sort_by_field",
+            format!(
+                "sorting raw records by a field other than {} requires reading all the matching records",
+                cfg.common.column_timestamp
+            ),
+        ));
+    }
+    if is_topk_count(sql) {
+        warnings.push(LintWarning::new(
+            "topk_group_by",
+            "top-k by count aggregates every group before sorting, prefer approx_topk(field, k) on high cardinality fields",
+        ));
+    }
+    warnings
+}
+
+/// Checks for `SELECT f, count(*) AS c ... GROUP BY f ORDER BY c DESC LIMIT k`.
+fn is_topk_count(sql: &Sql) -> bool {
+    if sql.group_by.len() != 1 || sql.limit <= 0 || sql.order_by.len() != 1 {
+        return false;
+    }
+    let (field, desc) = &sql.order_by[0];
+    *desc
+        && sql.field_alias.iter().any(|(expr, alias)| {
+            alias == field && expr.to_lowercase().replace(' ', "").starts_with("count(")
+        })
+}
+
+async fn analyze_promql(
+    org_id: &str,
+    req: &QueryAnalyzeRequest,
+    end_time: i64,
+) -> Result<QueryAnalyzeResponse> {
+    let expr = parser::parse(&req.query).map_err(|e| anyhow::anyhow!(e))?;
+    let start_time = if req.start_time > 0 {
+        req.start_time
+    } else {
+        end_time
+    };
+    let mut selectors = Vec::new();
+    let mut warnings = Vec::new();
+    walk_promql(&expr, None, &mut selectors, &mut warnings);
+
+    // the widest range read for every metric
+    let mut streams: HashMap<String, Duration> = HashMap::new();
+    for selector in selectors.iter() {
+        let Some(name) = selector.name.as_ref() else {
+            continue;
+        };
+        lint_metric_type(org_id, name, selector, &mut warnings).await;
+        let lookback = selector.range.unwrap_or(DEFAULT_LOOKBACK);
+        let entry = streams.entry(format_stream_name(name)).or_default();
+        if lookback > *entry {
+            *entry = lookback;
+        }
+    }
+
+    let mut resp = QueryAnalyzeResponse {
+        warnings,
+        ..Default::default()
+    };
+    let mut series = 0;
+    let mut names = streams.into_iter().collect::<Vec<_>>();
+    names.sort();
+    for (stream_name, lookback) in names {
+        let cost = estimate_stream(
+            org_id,
+            &stream_name,
+            StreamType::Metrics,
+            start_time - lookback.as_micros() as i64,
+            end_time,
+        )
+        .await?;
+        resp.files += cost.files;
+        resp.records += cost.records;
+        resp.compressed_size += cost.compressed_size;
+        series += cost.series.unwrap_or_default();
+        resp.streams.push(cost);
+    }
+    let steps = if req.step > 0 && end_time > start_time {
+        (end_time - start_time) / 1_000_000 / req.step + 1
+    } else {
+        1
+    };
+    resp.series = Some(series);
+    resp.samples_evaluated = Some(series * steps);
+    Ok(resp)
+}
+
+fn walk_promql(
+    expr: &Expr,
+    func: Option<&str>,
+    selectors: &mut Vec<Selector>,
+    warnings: &mut Vec<LintWarning>,
+) {
+    match expr {
+        Expr::VectorSelector(vs) => {
+            lint_matchers(vs, warnings);
+            selectors.push(Selector {
+                name: vs.name.clone(),
+                ..Default::default()
+            });
+        }
+        Expr::MatrixSelector(ms) => {
+            lint_matchers(&ms.vs, warnings);
+            if ms.range > LONG_RANGE {
+                warnings.push(LintWarning::new(
+                    "long_range",
+                    format!(
+                        "range [{}s] is longer than a day, every sample in it is read for each step",
+                        ms.range.as_secs()
+                    ),
+                ));
+            }
+            selectors.push(Selector {
+                name: ms.vs.name.clone(),
+                range: Some(ms.range),
+                func: func.map(|v| v.to_string()),
+            });
+        }
+        Expr::Call(call) => {
+            for arg in call.args.args.iter() {
+                walk_promql(arg, Some(call.func.name), selectors, warnings);
+            }
+        }
+        Expr::Aggregate(aggr) => walk_promql(&aggr.expr, None, selectors, warnings),
+        Expr::Binary(bin) => {
+            walk_promql(&bin.lhs, None, selectors, warnings);
+            walk_promql(&bin.rhs, None, selectors, warnings);
+        }
+        Expr::Unary(uni) => walk_promql(&uni.expr, None, selectors, warnings),
+        Expr::Paren(paren) => walk_promql(&paren.expr, func, selectors, warnings),
+        Expr::Subquery(sub) => {
+            if let Some(step) = sub.step.filter(|s| !s.is_zero()) {
+                if sub.range.as_millis() / step.as_millis().max(1) > MAX_SUBQUERY_POINTS {
+                    warnings.push(LintWarning::new(
+                        "subquery_resolution",
+                        "subquery evaluates too many points, increase its resolution",
+                    ));
+                }
+            }
+            walk_promql(&sub.expr, None, selectors, warnings)
+        }
+        Expr::NumberLiteral(_) | Expr::StringLiteral(_) | Expr::Extension(_) => {}
+    }
+}
+
+fn is_unbounded_regex(value: &str) -> bool {
+    matches!(value, "" | ".*" | ".+") || value.starts_with(".*") || value.starts_with(".+")
+}
+
+fn lint_matchers(vs: &VectorSelector, warnings: &mut Vec<LintWarning>) {
+    let mut has_equal = false;
+    for mat in vs.matchers.matchers.iter() {
+        match &mat.op {
+            MatchOp::Re(_) if is_unbounded_regex(&mat.value) => {
+                warnings.push(LintWarning::new(
+                    "unbounded_regex",
+                    format!(
+                        "matcher {}=~\"{}\" matches almost every value and can't narrow the series",
+                        mat.name, mat.value
+                    ),
+                ));
+            }
+            MatchOp::Equal => has_equal = true,
+            _ => {}
+        }
+    }
+    if vs.name.is_none() && !has_equal {
+        warnings.push(LintWarning::new(
+            "missing_metric_name",
+            "selector without a metric name or equality matcher has to look into every metric",
+        ));
+    }
+}
+
+async fn lint_metric_type(
+    org_id: &str,
+    name: &str,
+    selector: &Selector,
+    warnings: &mut Vec<LintWarning>,
+) {
+    let stream_name = format_stream_name(name);
+    let metric_type = infra::schema::get(org_id, &stream_name, StreamType::Metrics)
+        .await
+        .ok()
+        .and_then(|schema| get_prom_metadata_from_schema(&schema))
+        .map(|m| m.metric_type);
+    if let Some(warning) = check_metric_type(name, metric_type, selector) {
+        warnings.push(warning);
+    }
+}
+
+fn check_metric_type(
+    name: &str,
+    metric_type: Option<MetricType>,
+    selector: &Selector,
+) -> Option<LintWarning> {
+    let is_counter = match metric_type {
+        Some(t) => t == MetricType::Counter,
+        // without metadata fall back to the naming convention
+        None => name.ends_with("_total"),
+    };
+    let func = selector.func.as_deref();
+    let counter_func = func.is_some_and(|f| COUNTER_FUNCTIONS.contains(&f));
+    if is_counter && selector.range.is_none() {
+        return Some(LintWarning::new(
+            "counter_without_rate",
+            format!("{name} is a counter, its raw value is rarely useful, wrap it in rate() or increase()"),
+        ));
+    }
+    if is_counter && selector.range.is_some() && !counter_func {
+        return Some(LintWarning::new(
+            "counter_without_rate",
+            format!(
+                "{name} is a counter, {}() over its raw values ignores counter resets, use rate() or increase()",
+                func.unwrap_or_default()
+            ),
+        ));
+    }
+    if metric_type == Some(MetricType::Gauge) && counter_func {
+        return Some(LintWarning::new(
+            "rate_on_gauge",
+            format!(
+                "{name} is a gauge, {}() is meant for counters, use deriv() or delta()",
+                func.unwrap_or_default()
+            ),
+        ));
+    }
+    None
+}
+
+/// Fraction of the file which falls in the time range, files are assumed to be
+/// filled evenly.
+fn overlap_ratio(min_ts: i64, max_ts: i64, start_time: i64, end_time: i64) -> f64 {
+    if max_ts <= min_ts {
+        return 1.0;
+    }
+    let overlap = (max_ts.min(end_time) - min_ts.max(start_time)).max(0);
+    overlap as f64 / (max_ts - min_ts) as f64
+}
+
+async fn estimate_stream(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    start_time: i64,
+    end_time: i64,
+) -> Result<StreamCost> {
+    let files = file_list::query(
+        org_id,
+        stream_name,
+        stream_type,
+        PartitionTimeLevel::Unset,
+        start_time,
+        end_time,
+        false,
+    )
+    .await?;
+    let mut cost = StreamCost {
+        stream_name: stream_name.to_string(),
+        start_time,
+        end_time,
+        files: files.len(),
+        ..Default::default()
+    };
+    for file in files.iter() {
+        let ratio = overlap_ratio(file.meta.min_ts, file.meta.max_ts, start_time, end_time);
+        cost.records += (file.meta.records as f64 * ratio) as i64;
+        cost.compressed_size += file.meta.compressed_size;
+    }
+    if stream_type == StreamType::Metrics {
+        let scrape_interval = get_config().common.default_scrape_interval.max(1) as i64;
+        let points = ((end_time - start_time) / 1_000_000 / scrape_interval).max(1);
+        cost.series = Some((cost.records + points - 1) / points);
+    }
+    Ok(cost)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn lint(query: &str) -> (Vec<Selector>, Vec<String>) {
+        let expr = parser::parse(query).unwrap();
+        let mut selectors = Vec::new();
+        let mut warnings = Vec::new();
+        walk_promql(&expr, None, &mut selectors, &mut warnings);
+        (selectors, warnings.into_iter().map(|w| w.rule).collect())
+    }
+
+    #[test]
+    fn test_walk_promql() {
+        let (selectors, warnings) = lint(r#"sum(rate(http_requests_total{code=~".*"}[5m]))"#);
+        assert_eq!(
+            selectors,
+            vec![Selector {
+                name: Some("http_requests_total".to_string()),
+                range: Some(Duration::from_secs(300)),
+                func: Some("rate".to_string()),
+            }]
+        );
+        assert_eq!(warnings, vec!["unbounded_regex"]);
+
+        let (_, warnings) = lint(r#"{job=~"api.*"}"#);
+        assert_eq!(warnings, vec!["missing_metric_name"]);
+
+        let (_, warnings) = lint("avg_over_time(up[2d])");
+        assert_eq!(warnings, vec!["long_range"]);
+
+        let (_, warnings) = lint(r#"up{job="api"} + (node_load1)"#);
+        assert!(warnings.is_empty());
+    }
+
+    #[test]
+    fn test_check_metric_type() {
+        let instant = Selector {
+            name: Some("http_requests_total".to_string()),
+            ..Default::default()
+        };
+        let rate = Selector {
+            name: Some("http_requests_total".to_string()),
+            range: Some(Duration::from_secs(300)),
+            func: Some("rate".to_string()),
+        };
+        let avg = Selector {
+            func: Some("avg_over_time".to_string()),
+            ..rate.clone()
+        };
+        assert!(check_metric_type("http_requests_total", None, &instant).is_some());
+        assert!(check_metric_type("http_requests_total", None, &rate).is_none());
+        assert!(check_metric_type("http_requests_total", None, &avg).is_some());
+        assert!(check_metric_type("node_load1", Some(MetricType::Gauge), &instant).is_none());
+        assert_eq!(
+            check_metric_type("node_load1", Some(MetricType::Gauge), &rate)
+                .unwrap()
+                .rule,
+            "rate_on_gauge"
+        );
+    }
+
+    #[test]
+    fn test_lint_sql() {
+        let rules = |query: &str, start_time: i64| {
+            lint_sql(query, &Sql::new(query).unwrap(), start_time)
+                .into_iter()
+                .map(|w| w.rule)
+                .collect::<Vec<_>>()
+        };
+        assert!(rules("select * from t where a = 'b'", 1).is_empty());
+        assert_eq!(
+            rules("select * from t", 0),
+            vec!["missing_time_range", "no_filter"]
+        );
+        assert_eq!(
+            rules("select * from t where a like '%b'", 1),
+            vec!["leading_wildcard"]
+        );
+        assert_eq!(
+            rules("select * from t where re_match(a, 'b') order by a", 1),
+            vec!["regex_filter", "sort_by_field"]
+        );
+        assert_eq!(
+            rules(
+                "select path, count(*) as cnt from t group by path order by cnt desc limit 10",
+                1
+            ),
+            vec!["topk_group_by"]
+        );
+    }
+
+    #[test]
+    fn test_overlap_ratio() {
+        assert_eq!(overlap_ratio(0, 100, 0, 100), 1.0);
+        assert_eq!(overlap_ratio(0, 100, 50, 200), 0.5);
+        assert_eq!(overlap_ratio(0, 100, 200, 300), 0.0);
+        assert_eq!(overlap_ratio(10, 10, 0, 100), 1.0);
+    }
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! History of the searches run by the users. Every search through the search
+//! API is written to the [QUERY_HISTORY_STREAM] of its organization with its
+//! execution stats, so what was run during an incident can be reviewed
+//! afterwards. The users see their own history, the admins see everyone's.
+
+use std::collections::HashMap;
+
+use config::{
+    get_config, ider,
+    meta::{search::SearchEventType, stream::StreamType},
+    utils::json::{self, Value},
+};
+use proto::cluster_rpc;
+
+use crate::{
+    common::meta::query_history::{QueryHistoryRecord, QUERY_HISTORY_STREAM},
+    service::{search as SearchService, usage::ingestion_service, users},
+};
+
+/// Records the search in the background, the response does not wait for it
+pub fn record(org_id: &str, record: QueryHistoryRecord) {
+    if !get_config().common.query_history_enabled {
+        return;
+    }
+    let org_id = org_id.to_string();
+    tokio::task::spawn(async move {
+        if let Err(e) = write(&org_id, record).await {
+            log::error!("Error recording the query history of {org_id}: {e}");
+        }
+    });
+}
+
+async fn write(org_id: &str, record: QueryHistoryRecord) -> Result<(), anyhow::Error> {
+    let req = cluster_rpc::UsageRequest {
+        stream_name: QUERY_HISTORY_STREAM.to_string(),
+        data: Some(cluster_rpc::UsageData::from(vec![json::to_value(record)?])),
+    };
+    let resp = ingestion_service::ingest(org_id, req).await?;
+    if resp.status_code != 200 {
+        return Err(anyhow::anyhow!(
+            "write to stream {} error: {}",
+            QUERY_HISTORY_STREAM,
+            resp.message
+        ));
+    }
+    Ok(())
+}
+
+/// Returns the searches in the time range, the latest first. Only the admins
+/// can list the searches of the other users, `user` is ignored for the
+/// others.
+pub async fn list(
+    org_id: &str,
+    user_id: &str,
+    user: Option<&str>,
+    start_time: i64,
+    end_time: i64,
+    size: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    let user = if users::is_admin(org_id, user_id).await {
+        user
+    } else {
+        Some(user_id)
+    };
+    let where_sql = match user {
+        Some(user) => format!("WHERE user_email = '{}'", user.replace('\'', "''")),
+        None => String::new(),
+    };
+    let sql =
+        format!("SELECT * FROM \"{QUERY_HISTORY_STREAM}\" {where_sql} ORDER BY _timestamp DESC");
+    search(org_id, sql, start_time, end_time, size).await
+}
+
+async fn search(
+    org_id: &str,
+    sql: String,
+    start_time: i64,
+    end_time: i64,
+    size: i64,
+) -> Result<Vec<Value>, anyhow::Error> {
+    // nothing recorded yet
+    let schema = infra::schema::get(org_id, QUERY_HISTORY_STREAM, StreamType::Logs).await?;
+    if schema.fields().is_empty() {
+        return Ok(vec![]);
+    }
+    let req = config::meta::search::Request {
+        query: config::meta::search::Query {
+            sql,
+            from: 0,
+            size,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: config::meta::search::RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = ider::uuid();
+    let resp = SearchService::search(&trace_id, org_id, StreamType::Logs, None, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!("query history query error: {e}"))?;
+    Ok(resp.hits)
+}
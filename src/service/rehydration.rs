@@ -0,0 +1,377 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::{Duration, Utc};
+use config::{
+    cluster::LOCAL_NODE_UUID,
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding},
+        stream::StreamType,
+    },
+};
+use infra::dist_lock;
+use proto::cluster_rpc;
+
+use super::{db, format_stream_name, search as SearchService, usage::ingestion_service};
+use crate::common::{
+    infra::cluster::get_node_by_uuid,
+    meta::{
+        http::HttpResponse as MetaHttpResponse,
+        rehydration::{RehydrationJob, RehydrationJobList, RehydrationRequest, RehydrationStatus},
+    },
+};
+
+const PAGE_SIZE: i64 = 10000;
+
+#[tracing::instrument(skip(req))]
+pub async fn create_job(
+    org_id: &str,
+    user_email: &str,
+    req: RehydrationRequest,
+) -> Result<HttpResponse, Error> {
+    if req.stream_type != StreamType::Logs {
+        return Ok(MetaHttpResponse::bad_request(
+            "Only logs streams can be rehydrated",
+        ));
+    }
+    if req.start_time <= 0 || req.end_time <= req.start_time {
+        return Ok(MetaHttpResponse::bad_request("Invalid time range"));
+    }
+    if req.ttl_hours <= 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "ttl_hours should be greater than 0",
+        ));
+    }
+    if !stream_exists(org_id, &req.source_stream, req.stream_type).await {
+        return Ok(MetaHttpResponse::not_found(format!(
+            "Stream {} not found",
+            req.source_stream
+        )));
+    }
+
+    let id = config::ider::uuid();
+    let target_stream = if req.target_stream.trim().is_empty() {
+        format_stream_name(&format!(
+            "{}_rehydrated_{}",
+            req.source_stream,
+            id[id.len() - 8..].to_lowercase()
+        ))
+    } else {
+        format_stream_name(req.target_stream.trim())
+    };
+    if target_stream == req.source_stream
+        || stream_exists(org_id, &target_stream, req.stream_type).await
+    {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Target stream {target_stream} already exists"
+        )));
+    }
+
+    let now = Utc::now();
+    let job = RehydrationJob {
+        id,
+        org_id: org_id.to_string(),
+        source_stream: req.source_stream,
+        stream_type: req.stream_type,
+        target_stream,
+        start_time: req.start_time,
+        end_time: req.end_time,
+        filter: req.filter,
+        status: RehydrationStatus::Pending,
+        created_by: user_email.to_string(),
+        created_at: now.timestamp_micros(),
+        updated_at: now.timestamp_micros(),
+        expires_at: (now + Duration::try_hours(req.ttl_hours).unwrap_or_default())
+            .timestamp_micros(),
+        ..Default::default()
+    };
+    match db::rehydration::set(&job).await {
+        Ok(_) => Ok(MetaHttpResponse::json(job)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_jobs(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::rehydration::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(RehydrationJobList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_job(org_id: &str, id: &str) -> Result<HttpResponse, Error> {
+    match db::rehydration::get(org_id, id).await {
+        Ok(job) => Ok(MetaHttpResponse::json(job)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Rehydration job not found")),
+    }
+}
+
+/// Deletes the job together with its temporary stream
+#[tracing::instrument]
+pub async fn delete_job(org_id: &str, id: &str) -> Result<HttpResponse, Error> {
+    let job = match db::rehydration::get(org_id, id).await {
+        Ok(job) => job,
+        Err(_) => return Ok(MetaHttpResponse::not_found("Rehydration job not found")),
+    };
+    if job.status == RehydrationStatus::Running {
+        return Ok(MetaHttpResponse::bad_request(
+            "Rehydration job is running, please retry after it finishes",
+        ));
+    }
+    if let Err(e) = drop_target_stream(&job).await {
+        return Ok(MetaHttpResponse::internal_error(e));
+    }
+    match db::rehydration::delete(org_id, id).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Rehydration job deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Picks up the pending jobs and runs them one by one on this node
+pub async fn run_pending() -> Result<(), anyhow::Error> {
+    let jobs = db::rehydration::list("").await?;
+    for job in jobs {
+        match job.status {
+            RehydrationStatus::Pending => {}
+            RehydrationStatus::Running => {
+                // the node running the job is gone, run it again
+                if job.node.is_empty() || get_node_by_uuid(&job.node).await.is_some() {
+                    continue;
+                }
+            }
+            _ => continue,
+        }
+        let Some(mut job) = claim_job(&job.org_id, &job.id).await? else {
+            continue;
+        };
+        log::info!(
+            "[REHYDRATION] start job {}/{}: {} -> {}",
+            job.org_id,
+            job.id,
+            job.source_stream,
+            job.target_stream
+        );
+        match execute(&mut job).await {
+            Ok(_) => {
+                job.status = RehydrationStatus::Completed;
+                job.error = "".to_string();
+            }
+            Err(e) => {
+                log::error!("[REHYDRATION] job {}/{} error: {}", job.org_id, job.id, e);
+                job.status = RehydrationStatus::Failed;
+                job.error = e.to_string();
+            }
+        }
+        job.updated_at = Utc::now().timestamp_micros();
+        db::rehydration::set(&job).await?;
+    }
+    Ok(())
+}
+
+/// Deletes the temporary streams of the jobs which reached their ttl
+pub async fn expire_jobs() -> Result<(), anyhow::Error> {
+    let now = Utc::now().timestamp_micros();
+    let jobs = db::rehydration::list("").await?;
+    for mut job in jobs {
+        if !matches!(
+            job.status,
+            RehydrationStatus::Completed | RehydrationStatus::Failed
+        ) || !job.is_expired(now)
+        {
+            continue;
+        }
+        let locker =
+            dist_lock::lock(&format!("/rehydration/expire/{}/{}", job.org_id, job.id), 0).await?;
+        let ret = drop_target_stream(&job).await;
+        if ret.is_ok() {
+            job.status = RehydrationStatus::Expired;
+            job.updated_at = now;
+            db::rehydration::set(&job).await?;
+            log::info!(
+                "[REHYDRATION] job {}/{} expired, stream {} deleted",
+                job.org_id,
+                job.id,
+                job.target_stream
+            );
+        }
+        dist_lock::unlock(&locker).await?;
+        ret?;
+    }
+    Ok(())
+}
+
+async fn claim_job(org_id: &str, id: &str) -> Result<Option<RehydrationJob>, anyhow::Error> {
+    let locker = dist_lock::lock(&format!("/rehydration/claim/{org_id}/{id}"), 0).await?;
+    let mut job = match db::rehydration::get(org_id, id).await {
+        Ok(job) => job,
+        Err(_) => {
+            dist_lock::unlock(&locker).await?;
+            return Ok(None);
+        }
+    };
+    let claimable = match job.status {
+        RehydrationStatus::Pending => true,
+        RehydrationStatus::Running => get_node_by_uuid(&job.node).await.is_none(),
+        _ => false,
+    };
+    let ret = if claimable {
+        job.status = RehydrationStatus::Running;
+        job.node = LOCAL_NODE_UUID.clone();
+        job.updated_at = Utc::now().timestamp_micros();
+        db::rehydration::set(&job).await.map(|_| Some(job))
+    } else {
+        Ok(None)
+    };
+    dist_lock::unlock(&locker).await?;
+    ret
+}
+
+/// Copies the records page by page, keeping their `_timestamp` so the target
+/// stream is searched over the same time range as the source. The progress is
+/// saved after every page, a job taken over from a dead node only copies again
+/// the page which was in flight.
+async fn execute(job: &mut RehydrationJob) -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let mut from = job.records;
+    loop {
+        let size = std::cmp::min(PAGE_SIZE, cfg.limit.rehydration_max_records - job.records);
+        if size <= 0 {
+            log::warn!(
+                "[REHYDRATION] job {}/{} reached the max records limit",
+                job.org_id,
+                job.id
+            );
+            break;
+        }
+        let req = Request {
+            query: Query {
+                sql: job.sql(),
+                from,
+                size,
+                start_time: job.start_time,
+                end_time: job.end_time,
+                sort_by: Some(format!("{} ASC", cfg.common.column_timestamp)),
+                sql_mode: "full".to_string(),
+                ..Default::default()
+            },
+            aggs: HashMap::new(),
+            encoding: RequestEncoding::Empty,
+            regions: vec![],
+            clusters: vec![],
+            timeout: 0,
+            search_type: None,
+            search_event_context: None,
+        };
+        let trace_id = config::ider::uuid();
+        let res = SearchService::search(
+            &trace_id,
+            &job.org_id,
+            job.stream_type,
+            Some(job.created_by.clone()),
+            &req,
+        )
+        .await?;
+        let hits = res.hits.len() as i64;
+        if hits == 0 {
+            break;
+        }
+
+        let ingest_req = cluster_rpc::UsageRequest {
+            stream_name: job.target_stream.clone(),
+            data: Some(cluster_rpc::UsageData::from(res.hits)),
+        };
+        // the records are older than the ingestion allows
+        let resp = ingestion_service::ingest_historical(&job.org_id, ingest_req).await?;
+        if resp.status_code != 200 {
+            return Err(anyhow::anyhow!(
+                "write to stream {} error: {}",
+                job.target_stream,
+                resp.message
+            ));
+        }
+        if job.records == 0 {
+            keep_source_retention(job).await?;
+        }
+
+        let now = Utc::now().timestamp_micros();
+        job.records += hits;
+        job.updated_at = now;
+        db::rehydration::set(job).await?;
+        if hits < size {
+            break;
+        }
+        from += hits;
+    }
+    Ok(())
+}
+
+/// The target stream holds records as old as the source stream, it keeps them
+/// as long as the source until the job expires and deletes it
+async fn keep_source_retention(job: &RehydrationJob) -> Result<(), anyhow::Error> {
+    let data_retention =
+        infra::schema::get_settings(&job.org_id, &job.source_stream, job.stream_type)
+            .await
+            .map(|s| s.data_retention)
+            .unwrap_or_default();
+    if data_retention <= 0 {
+        return Ok(());
+    }
+    let mut settings =
+        infra::schema::get_settings(&job.org_id, &job.target_stream, job.stream_type)
+            .await
+            .unwrap_or_default();
+    settings.data_retention = data_retention;
+    let resp = super::stream::save_stream_settings(
+        &job.org_id,
+        &job.target_stream,
+        job.stream_type,
+        settings,
+    )
+    .await?;
+    if !resp.status().is_success() {
+        return Err(anyhow::anyhow!(
+            "failed to set the retention of stream {}",
+            job.target_stream
+        ));
+    }
+    Ok(())
+}
+
+async fn drop_target_stream(job: &RehydrationJob) -> Result<(), anyhow::Error> {
+    if !stream_exists(&job.org_id, &job.target_stream, job.stream_type).await {
+        return Ok(());
+    }
+    let resp =
+        super::stream::delete_stream(&job.org_id, &job.target_stream, job.stream_type).await?;
+    if !resp.status().is_success() {
+        return Err(anyhow::anyhow!(
+            "failed to delete stream {}",
+            job.target_stream
+        ));
+    }
+    Ok(())
+}
+
+async fn stream_exists(org_id: &str, stream_name: &str, stream_type: StreamType) -> bool {
+    infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .map(|schema| !schema.fields().is_empty())
+        .unwrap_or_default()
+}
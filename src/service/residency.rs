@@ -0,0 +1,125 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::get_config;
+#[cfg(feature = "enterprise")]
+use o2_enterprise::enterprise::common::infra::config::O2_CONFIG;
+
+use super::db;
+
+/// Region of this cluster, the super cluster region takes precedence over
+/// `ZO_CLUSTER_REGION` when the super cluster is enabled.
+pub fn local_region() -> String {
+    #[cfg(feature = "enterprise")]
+    if O2_CONFIG.super_cluster.enabled && !O2_CONFIG.super_cluster.region.is_empty() {
+        return O2_CONFIG.super_cluster.region.clone();
+    }
+    get_config().common.cluster_region.clone()
+}
+
+/// Checks this cluster may ingest and query the data of the org. The error
+/// explains which region the org is pinned to. Besides the http and gRPC
+/// requests, the ingestion services check it themselves, so the ingestion not
+/// coming from a request, like syslog, is rejected too.
+pub fn check_local(org_id: &str) -> Result<(), String> {
+    match db::residency::get(org_id) {
+        Some(residency) => check_region(org_id, &residency.region, &local_region()),
+        None => Ok(()),
+    }
+}
+
+/// Restricts the regions of a super cluster query to the region the org is
+/// pinned to. An empty list means all the regions, so it becomes the pinned
+/// region; asking for any other region is an error instead of silently
+/// dropping it.
+pub fn pin_regions(org_id: &str, regions: &[String]) -> Result<Vec<String>, String> {
+    match db::residency::get(org_id) {
+        Some(residency) => pin_to(org_id, &residency.region, &local_region(), regions),
+        None => Ok(regions.to_vec()),
+    }
+}
+
+fn check_region(org_id: &str, pinned: &str, local: &str) -> Result<(), String> {
+    if pinned.is_empty() || pinned == local {
+        Ok(())
+    } else if local.is_empty() {
+        Err(format!(
+            "Organization [{org_id}] is pinned to region [{pinned}], this cluster has no region configured"
+        ))
+    } else {
+        Err(format!(
+            "Organization [{org_id}] is pinned to region [{pinned}] and can not be served from region [{local}]"
+        ))
+    }
+}
+
+fn pin_to(
+    org_id: &str,
+    pinned: &str,
+    local: &str,
+    regions: &[String],
+) -> Result<Vec<String>, String> {
+    if pinned.is_empty() {
+        return Ok(regions.to_vec());
+    }
+    if regions.is_empty() {
+        return Ok(vec![pinned.to_string()]);
+    }
+    for region in regions {
+        let region = if region == "local" {
+            local
+        } else {
+            region.as_str()
+        };
+        if region != pinned {
+            return Err(format!(
+                "Organization [{org_id}] is pinned to region [{pinned}], region [{region}] can not be queried"
+            ));
+        }
+    }
+    Ok(regions.to_vec())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_region() {
+        assert!(check_region("default", "", "eu").is_ok());
+        assert!(check_region("default", "eu", "eu").is_ok());
+        assert!(check_region("default", "eu", "us").is_err());
+        assert!(check_region("default", "eu", "").is_err());
+    }
+
+    #[test]
+    fn test_pin_to() {
+        let regions = |v: &[&str]| v.iter().map(|r| r.to_string()).collect::<Vec<_>>();
+        assert_eq!(
+            pin_to("default", "", "us", &regions(&["us", "eu"])).unwrap(),
+            regions(&["us", "eu"])
+        );
+        assert_eq!(
+            pin_to("default", "eu", "eu", &[]).unwrap(),
+            regions(&["eu"])
+        );
+        assert_eq!(
+            pin_to("default", "eu", "eu", &regions(&["local"])).unwrap(),
+            regions(&["local"])
+        );
+        assert!(pin_to("default", "eu", "us", &regions(&["local"])).is_err());
+        assert!(pin_to("default", "eu", "eu", &regions(&["eu", "us"])).is_err());
+    }
+}
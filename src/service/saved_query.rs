@@ -0,0 +1,303 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Library of named SQL queries with parameters. A query is private to its
+//! owner until it is shared with the organization, only the owner and the
+//! admins can change it. A query filed in a folder also needs the access to
+//! the folder.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::meta::search::{Query, Request, RequestEncoding, SearchEventType};
+
+use crate::{
+    common::{
+        meta::{
+            comments::CommentTarget,
+            dashboards::FolderAccess,
+            http::HttpResponse as MetaHttpResponse,
+            saved_query::{QuerySharing, RunSavedQueryRequest, SavedQuery, SavedQueryList},
+        },
+        utils::auth::is_root_user,
+    },
+    service::{dashboards::folders::FolderAccessResolver, db, search as SearchService, users},
+};
+
+#[tracing::instrument(skip(query))]
+pub async fn save_query(
+    org_id: &str,
+    user_id: &str,
+    mut query: SavedQuery,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    query.name = query.name.trim().to_string();
+    let stored = db::saved_query::get(org_id, &query.name).await.ok();
+    if create && stored.is_some() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Saved query {} already exists",
+            query.name
+        )));
+    }
+    let now = Utc::now().timestamp_micros();
+    match stored {
+        Some(stored) => {
+            if !can_edit(org_id, user_id, &stored).await
+                || !folder_allows(org_id, user_id, &stored, FolderAccess::Edit).await
+            {
+                return Ok(MetaHttpResponse::forbidden(
+                    "Only the owner or an admin can change the saved query",
+                ));
+            }
+            query.owner = stored.owner;
+            query.created_at = stored.created_at;
+        }
+        None if !create => return Ok(MetaHttpResponse::not_found("Saved query not found")),
+        None => {
+            query.owner = user_id.to_string();
+            query.created_at = now;
+        }
+    }
+    query.folder_id = query.folder_id.trim().to_string();
+    if !query.folder_id.is_empty() {
+        if db::dashboards::folders::get(org_id, &query.folder_id)
+            .await
+            .is_err()
+        {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Folder {} not found",
+                query.folder_id
+            )));
+        }
+        if !folder_allows(org_id, user_id, &query, FolderAccess::Edit).await {
+            return Ok(MetaHttpResponse::forbidden(format!(
+                "No edit permission on the folder {}",
+                query.folder_id
+            )));
+        }
+    }
+    query.updated_at = now;
+    query.updated_by = user_id.to_string();
+    query.tags = normalize_tags(&query.tags);
+    if let Err(e) = query.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match db::saved_query::set(org_id, &query).await {
+        Ok(_) => Ok(MetaHttpResponse::json(query)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Lists the queries the user can see, optionally only the ones with the tag
+#[tracing::instrument]
+pub async fn list_queries(
+    org_id: &str,
+    user_id: &str,
+    tag: Option<&str>,
+) -> Result<HttpResponse, Error> {
+    match db::saved_query::list(org_id).await {
+        Ok(list) => {
+            let resolver = FolderAccessResolver::load(org_id, user_id).await;
+            let list = list
+                .into_iter()
+                .filter(|q| can_view(user_id, q))
+                .filter(|q| {
+                    q.folder_id.is_empty() || resolver.allows(&q.folder_id, FolderAccess::View)
+                })
+                .filter(|q| tag.map_or(true, |tag| q.tags.iter().any(|t| t == tag)))
+                .collect();
+            Ok(MetaHttpResponse::json(SavedQueryList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_query(org_id: &str, user_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::saved_query::get(org_id, name).await {
+        Ok(query)
+            if can_view(user_id, &query)
+                && folder_allows(org_id, user_id, &query, FolderAccess::View).await =>
+        {
+            Ok(MetaHttpResponse::json(query))
+        }
+        _ => Ok(MetaHttpResponse::not_found("Saved query not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_query(org_id: &str, user_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    let query = match db::saved_query::get(org_id, name).await {
+        Ok(query)
+            if can_view(user_id, &query)
+                && folder_allows(org_id, user_id, &query, FolderAccess::View).await =>
+        {
+            query
+        }
+        _ => return Ok(MetaHttpResponse::not_found("Saved query not found")),
+    };
+    if !can_edit(org_id, user_id, &query).await
+        || !folder_allows(org_id, user_id, &query, FolderAccess::Edit).await
+    {
+        return Ok(MetaHttpResponse::forbidden(
+            "Only the owner or an admin can delete the saved query",
+        ));
+    }
+    match db::saved_query::delete(org_id, name).await {
+        Ok(_) => {
+            if let Err(e) =
+                db::comments::delete_all(org_id, CommentTarget::SavedQueries, name).await
+            {
+                log::error!("Error deleting the comments of saved query {org_id}/{name}: {e}");
+            }
+            Ok(MetaHttpResponse::ok("Saved query deleted"))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Runs the query with the given parameters and returns the search response
+#[tracing::instrument(skip(req))]
+pub async fn run_query(
+    org_id: &str,
+    user_id: &str,
+    name: &str,
+    req: RunSavedQueryRequest,
+) -> Result<HttpResponse, Error> {
+    let query = match db::saved_query::get(org_id, name).await {
+        Ok(query)
+            if can_view(user_id, &query)
+                && folder_allows(org_id, user_id, &query, FolderAccess::View).await =>
+        {
+            query
+        }
+        _ => return Ok(MetaHttpResponse::not_found("Saved query not found")),
+    };
+    let sql = match query.render(&req.params) {
+        Ok(sql) => sql,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let end_time = if req.end_time > 0 {
+        req.end_time
+    } else {
+        Utc::now().timestamp_micros()
+    };
+    let start_time = if req.start_time > 0 {
+        req.start_time
+    } else {
+        end_time - query.period * 60 * 1_000_000
+    };
+    if start_time >= end_time {
+        return Ok(MetaHttpResponse::bad_request(
+            "start_time should be before end_time",
+        ));
+    }
+    let search_req = Request {
+        query: Query {
+            sql,
+            from: req.from,
+            size: req.size,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    log::info!("[SAVED_QUERY] trace_id {trace_id} {org_id}/{name} run by {user_id}");
+    match SearchService::search(
+        &trace_id,
+        org_id,
+        query.stream_type,
+        Some(user_id.to_string()),
+        &search_req,
+    )
+    .await
+    {
+        Ok(res) => Ok(MetaHttpResponse::json(res)),
+        Err(e) => Ok(MetaHttpResponse::bad_request(e)),
+    }
+}
+
+/// Returns true when the query is shared or owned by the user
+pub fn can_view(user_id: &str, query: &SavedQuery) -> bool {
+    query.sharing == QuerySharing::Org || query.owner == user_id || is_root_user(user_id)
+}
+
+async fn can_edit(org_id: &str, user_id: &str, query: &SavedQuery) -> bool {
+    query.owner == user_id || users::is_admin(org_id, user_id).await
+}
+
+/// Returns true when the query is not in a folder or the folder gives the
+/// access to the user
+async fn folder_allows(
+    org_id: &str,
+    user_id: &str,
+    query: &SavedQuery,
+    access: FolderAccess,
+) -> bool {
+    query.folder_id.is_empty()
+        || FolderAccessResolver::load(org_id, user_id)
+            .await
+            .allows(&query.folder_id, access)
+}
+
+fn normalize_tags(tags: &[String]) -> Vec<String> {
+    let mut tags: Vec<String> = tags
+        .iter()
+        .map(|t| t.trim().to_lowercase())
+        .filter(|t| !t.is_empty())
+        .collect();
+    tags.sort();
+    tags.dedup();
+    tags
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_tags() {
+        let tags = vec![
+            "Payments".to_string(),
+            " api ".to_string(),
+            "payments".to_string(),
+            "".to_string(),
+        ];
+        assert_eq!(normalize_tags(&tags), vec!["api", "payments"]);
+    }
+
+    #[test]
+    fn test_can_view() {
+        let mut query = SavedQuery {
+            name: "errors".to_string(),
+            owner: "alice@example.com".to_string(),
+            ..Default::default()
+        };
+        assert!(can_view("alice@example.com", &query));
+        assert!(!can_view("bob@example.com", &query));
+        query.sharing = QuerySharing::Org;
+        assert!(can_view("bob@example.com", &query));
+    }
+}
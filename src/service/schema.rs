@@ -19,7 +19,9 @@ use anyhow::Result;
 use config::{
     get_config,
     meta::stream::StreamType,
+    metrics,
     utils::{json, schema::infer_json_schema_from_map, schema_ext::SchemaExt},
+    OVERFLOW_FIELD,
 };
 use datafusion::arrow::datatypes::{Field, Schema};
 use hashbrown::HashSet;
@@ -52,6 +54,128 @@ pub(crate) fn get_request_columns_limit_error(
     )
 }
 
+/// Enforces the `max_columns` stream setting on a record before schema evolution. Fields which
+/// are not in the stream schema yet and would grow it beyond the limit are moved into the
+/// `_overflow` field as a json string, where they stay queryable with the json functions.
+/// `batch_fields` collects the new fields kept from the previous records of a batch whose schema
+/// evolves once for all of them, so they count against the limit too.
+/// Returns the number of moved fields.
+pub async fn apply_column_limit(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    stream_schema_map: &mut HashMap<String, SchemaCache>,
+    batch_fields: &mut HashSet<String>,
+    record: &mut Map<String, Value>,
+) -> Result<usize> {
+    if !stream_schema_map.contains_key(stream_name) {
+        let schema = infra::schema::get_cache(org_id, stream_name, stream_type).await?;
+        stream_schema_map.insert(stream_name.to_string(), schema);
+    }
+    let schema = stream_schema_map.get(stream_name).unwrap();
+    let fields_map = schema.fields_map();
+
+    // fast path, the record doesn't add any column
+    if record
+        .keys()
+        .all(|k| fields_map.contains_key(k) || batch_fields.contains(k))
+    {
+        return Ok(0);
+    }
+    let max_columns = get_settings(org_id, stream_name, stream_type)
+        .await
+        .map(|s| s.max_columns)
+        .unwrap_or_default();
+    if max_columns == 0 {
+        return Ok(0);
+    }
+
+    let moved = overflow_new_fields(record, fields_map, batch_fields, max_columns);
+    if moved > 0 {
+        metrics::INGEST_OVERFLOW_FIELDS
+            .with_label_values(&[org_id, stream_name, stream_type.to_string().as_str()])
+            .inc_by(moved as u64);
+    }
+    Ok(moved)
+}
+
+fn overflow_new_fields(
+    record: &mut Map<String, Value>,
+    fields_map: &HashMap<String, usize>,
+    batch_fields: &mut HashSet<String>,
+    max_columns: usize,
+) -> usize {
+    let cfg = get_config();
+    let mut new_fields = record
+        .keys()
+        .filter(|k| {
+            !fields_map.contains_key(*k)
+                && !batch_fields.contains(*k)
+                && k.as_str() != OVERFLOW_FIELD
+                && k.as_str() != cfg.common.column_timestamp
+        })
+        .cloned()
+        .collect::<Vec<_>>();
+    let mut available = max_columns.saturating_sub(fields_map.len() + batch_fields.len());
+    if !fields_map.contains_key(&cfg.common.column_timestamp) {
+        available = available.saturating_sub(1);
+    }
+    if new_fields.len() <= available {
+        batch_fields.extend(new_fields);
+        return 0;
+    }
+    // the overflow field itself takes a column
+    if !fields_map.contains_key(OVERFLOW_FIELD) && !batch_fields.contains(OVERFLOW_FIELD) {
+        available = available.saturating_sub(1);
+        batch_fields.insert(OVERFLOW_FIELD.to_string());
+    }
+
+    let mut overflow = match record.remove(OVERFLOW_FIELD) {
+        Some(Value::String(v)) => json::from_str::<Map<String, Value>>(&v).unwrap_or_default(),
+        Some(Value::Object(v)) => v,
+        _ => Map::new(),
+    };
+    let overflowed = new_fields.split_off(available);
+    batch_fields.extend(new_fields);
+    for key in overflowed.iter() {
+        if let Some(v) = record.remove(key) {
+            overflow.insert(key.to_string(), v);
+        }
+    }
+    record.insert(
+        OVERFLOW_FIELD.to_string(),
+        Value::String(json::to_string(&overflow).unwrap_or_default()),
+    );
+    overflowed.len()
+}
+
+/// Reports how close the stream is to its `max_columns` limit after the schema changed.
+fn check_columns_usage(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    num_fields: usize,
+    max_columns: usize,
+) {
+    metrics::INGEST_STREAM_COLUMNS
+        .with_label_values(&[org_id, stream_name, stream_type.to_string().as_str()])
+        .set(num_fields as i64);
+    if max_columns == 0 {
+        return;
+    }
+    if num_fields * 100 >= max_columns * get_config().limit.stream_columns_warn_percent {
+        log::warn!(
+            "stream [{}/{}/{}] has {} columns, approaching its limit of {} columns, new fields will be stored in {}",
+            org_id,
+            stream_type,
+            stream_name,
+            num_fields,
+            max_columns,
+            OVERFLOW_FIELD
+        );
+    }
+}
+
 pub async fn check_for_schema(
     org_id: &str,
     stream_name: &str,
@@ -279,6 +403,13 @@ async fn handle_diff_schema(
         .defined_schema_fields
         .clone()
         .unwrap_or_default();
+    check_columns_usage(
+        org_id,
+        stream_name,
+        stream_type,
+        final_schema.fields().len(),
+        stream_setting.max_columns,
+    );
     let final_schema = SchemaCache::new(final_schema);
 
     // update node cache
@@ -452,6 +583,67 @@ mod tests {
         assert!(result.schema_compatible);
     }
 
+    #[test]
+    fn test_overflow_new_fields() {
+        let fields_map: HashMap<String, usize> = [("_timestamp", 0), ("a", 1), ("b", 2)]
+            .into_iter()
+            .map(|(k, v)| (k.to_string(), v))
+            .collect();
+        let mut record = json::from_str::<Map<String, Value>>(
+            r#"{"_timestamp": 1, "a": 1, "c": "x", "d": "y", "e": "z"}"#,
+        )
+        .unwrap();
+
+        // no overflow while the new fields fit
+        let mut batch_fields = HashSet::new();
+        assert_eq!(
+            overflow_new_fields(&mut record.clone(), &fields_map, &mut batch_fields, 6),
+            0
+        );
+
+        // limit 5: 3 existing columns, 1 for _overflow, 1 new field kept
+        let mut batch_fields = HashSet::new();
+        assert_eq!(
+            overflow_new_fields(&mut record, &fields_map, &mut batch_fields, 5),
+            2
+        );
+        assert!(record.contains_key("c"));
+        assert!(!record.contains_key("d"));
+        assert!(!record.contains_key("e"));
+        let overflow: Map<String, Value> =
+            json::from_str(record.get(OVERFLOW_FIELD).unwrap().as_str().unwrap()).unwrap();
+        assert_eq!(overflow.get("d").unwrap(), "y");
+        assert_eq!(overflow.get("e").unwrap(), "z");
+    }
+
+    #[test]
+    fn test_overflow_new_fields_of_batch() {
+        let fields_map: HashMap<String, usize> = [("_timestamp", 0), ("a", 1)]
+            .into_iter()
+            .map(|(k, v)| (k.to_string(), v))
+            .collect();
+        let mut batch_fields = HashSet::new();
+        let mut first =
+            json::from_str::<Map<String, Value>>(r#"{"_timestamp": 1, "b": 1, "c": 2}"#).unwrap();
+        assert_eq!(
+            overflow_new_fields(&mut first, &fields_map, &mut batch_fields, 5),
+            0
+        );
+
+        // each record fits on its own, but the batch adds b, c, d and e
+        let mut second =
+            json::from_str::<Map<String, Value>>(r#"{"_timestamp": 2, "b": 3, "d": 4, "e": 5}"#)
+                .unwrap();
+        assert_eq!(
+            overflow_new_fields(&mut second, &fields_map, &mut batch_fields, 5),
+            2
+        );
+        assert!(second.contains_key("b"));
+        assert!(!second.contains_key("d"));
+        assert!(!second.contains_key("e"));
+        assert_eq!(batch_fields.len(), 3);
+    }
+
     #[tokio::test]
     async fn test_infer_schema() {
         let mut record_val: Vec<&Map<String, Value>> = vec![];
@@ -0,0 +1,228 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{cluster, meta::stream::StreamType, metrics, utils::json, RwHashMap};
+use once_cell::sync::Lazy;
+
+use super::db;
+use crate::common::{
+    infra::config::SCHEMA_CONTRACTS,
+    meta::{
+        http::HttpResponse as MetaHttpResponse,
+        schema_contract::{ContractEnforcement, ContractReport, ProducerReport, SchemaContract},
+    },
+};
+
+const UNKNOWN_PRODUCER: &str = "unknown";
+
+/// Producer reports collected by this node, keyed by `{org_id}/{stream_type}/{stream_name}`
+static PRODUCER_REPORTS: Lazy<RwHashMap<String, HashMap<String, ProducerReport>>> =
+    Lazy::new(Default::default);
+
+pub fn get_contract(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Option<SchemaContract> {
+    SCHEMA_CONTRACTS
+        .get(&format!("{org_id}/{stream_type}/{stream_name}"))
+        .map(|v| v.value().clone())
+}
+
+/// Validates a record against the stream contract and accounts the result to
+/// the producer. Returns an error message when the record must be rejected.
+pub fn check_record(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    contract: &SchemaContract,
+    producer: &str,
+    record: &json::Map<String, json::Value>,
+) -> Option<String> {
+    let producer = if producer.is_empty() {
+        UNKNOWN_PRODUCER
+    } else {
+        producer
+    };
+    let timestamp_col = &config::get_config().common.column_timestamp;
+    let violations = contract.validate(record, timestamp_col);
+    let rejected = !violations.is_empty() && contract.enforcement == ContractEnforcement::Reject;
+
+    let key = format!("{org_id}/{stream_type}/{stream_name}");
+    PRODUCER_REPORTS
+        .entry(key)
+        .or_default()
+        .entry(producer.to_string())
+        .or_insert_with(|| ProducerReport::new(producer))
+        .add(&violations, rejected, Utc::now().timestamp_micros());
+
+    if violations.is_empty() {
+        return None;
+    }
+    metrics::INGEST_SCHEMA_VIOLATIONS
+        .with_label_values(&[
+            org_id,
+            stream_name,
+            stream_type.to_string().as_str(),
+            producer,
+        ])
+        .inc();
+    if rejected {
+        Some(format!(
+            "schema contract violation: {}",
+            violations
+                .iter()
+                .map(|v| v.to_string())
+                .collect::<Vec<_>>()
+                .join(", ")
+        ))
+    } else {
+        None
+    }
+}
+
+/// Writes the reports collected by this node to the meta store
+pub async fn flush_reports() -> Result<(), anyhow::Error> {
+    let keys = PRODUCER_REPORTS
+        .iter()
+        .map(|v| v.key().clone())
+        .collect::<Vec<_>>();
+    for key in keys {
+        flush_report(&key).await?;
+    }
+    Ok(())
+}
+
+async fn flush_report(key: &str) -> Result<(), anyhow::Error> {
+    let reports = match PRODUCER_REPORTS.get(key) {
+        Some(v) => v.values().cloned().collect::<Vec<_>>(),
+        None => return Ok(()),
+    };
+    let columns = key.splitn(3, '/').collect::<Vec<_>>();
+    if columns.len() != 3 {
+        return Ok(());
+    }
+    let stream_type = StreamType::from(columns[1]);
+    db::schema_contracts::set_report(
+        columns[0],
+        stream_type,
+        columns[2],
+        &cluster::LOCAL_NODE_UUID,
+        &reports,
+    )
+    .await
+}
+
+#[tracing::instrument(skip(contract))]
+pub async fn save_contract(
+    org_id: &str,
+    mut contract: SchemaContract,
+) -> Result<HttpResponse, Error> {
+    if contract.fields.is_empty() {
+        return Ok(MetaHttpResponse::bad_request(
+            "Schema contract should have at least one field",
+        ));
+    }
+    let mut names = std::collections::HashSet::with_capacity(contract.fields.len());
+    for field in contract.fields.iter_mut() {
+        field.name = field.name.trim().to_string();
+        if field.name.is_empty() {
+            return Ok(MetaHttpResponse::bad_request(
+                "Schema contract field name should not be empty",
+            ));
+        }
+        if !names.insert(field.name.clone()) {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Schema contract field [{}] is duplicated",
+                field.name
+            )));
+        }
+    }
+    contract.updated_at = Utc::now().timestamp_micros();
+    match db::schema_contracts::set(org_id, &contract).await {
+        Ok(_) => Ok(MetaHttpResponse::json(contract)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_contract_response(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<HttpResponse, Error> {
+    match db::schema_contracts::get(org_id, stream_type, stream_name).await {
+        Ok(contract) => Ok(MetaHttpResponse::json(contract)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Schema contract not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_contract(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<HttpResponse, Error> {
+    if db::schema_contracts::get(org_id, stream_type, stream_name)
+        .await
+        .is_err()
+    {
+        return Ok(MetaHttpResponse::not_found("Schema contract not found"));
+    }
+    PRODUCER_REPORTS.remove(&format!("{org_id}/{stream_type}/{stream_name}"));
+    match db::schema_contracts::delete(org_id, stream_type, stream_name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Schema contract deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_report(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> Result<HttpResponse, Error> {
+    // make sure the report of this node is up to date
+    if let Err(e) = flush_report(&format!("{org_id}/{stream_type}/{stream_name}")).await {
+        log::error!("[SCHEMA_CONTRACT] flush report error: {}", e);
+    }
+    let reports = match db::schema_contracts::list_reports(org_id, stream_type, stream_name).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    let mut producers: HashMap<String, ProducerReport> = HashMap::new();
+    for report in reports {
+        producers
+            .entry(report.producer.clone())
+            .or_insert_with(|| ProducerReport::new(&report.producer))
+            .merge(&report);
+    }
+    let mut producers = producers.into_values().collect::<Vec<_>>();
+    // producers breaking the contract the most come first
+    producers.sort_by(|a, b| {
+        b.violated_records
+            .cmp(&a.violated_records)
+            .then_with(|| a.producer.cmp(&b.producer))
+    });
+    Ok(MetaHttpResponse::json(ContractReport {
+        stream_name: stream_name.to_string(),
+        stream_type,
+        producers,
+    }))
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Access of a user to a stream for the searches run outside of the search
+//! handlers, jobs, tools and the gRPC api: the stream permission of the
+//! enterprise edition and the max query range of the stream settings.
+
+use config::meta::{search, stream::StreamType};
+
+use crate::{
+    common::utils::auth::{is_root_user, AuthExtractor},
+    handler::http::auth::validator::check_permissions,
+    service::users,
+};
+
+/// Microseconds in an hour, the unit of the max query range
+const HOUR_MICROS: i64 = 1000 * 1000 * 60 * 60;
+
+/// Whether the user can read the stream, never once the user left the
+/// organization
+pub async fn can_read(
+    org_id: &str,
+    user_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+) -> bool {
+    if is_root_user(user_id) {
+        return true;
+    }
+    let Some(user) = users::get_user(Some(org_id), user_id).await else {
+        return false;
+    };
+    !user.is_external
+        || check_permissions(
+            user_id,
+            AuthExtractor {
+                auth: "".to_string(),
+                method: "GET".to_string(),
+                o2_type: format!("{}:{}", stream_type, stream_name),
+                org_id: org_id.to_string(),
+                bypass_check: false,
+                parent_id: "".to_string(),
+            },
+            Some(user.role),
+        )
+        .await
+}
+
+/// Shortens the time range of the query to the max query range of the stream,
+/// returns the warning to show when it did
+pub async fn clamp_query_range(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    query: &mut search::Query,
+) -> Option<String> {
+    let max_query_range = infra::schema::get_settings(org_id, stream_name, stream_type)
+        .await?
+        .max_query_range;
+    clamp_range(max_query_range, query)
+}
+
+fn clamp_range(max_query_range: i64, query: &mut search::Query) -> Option<String> {
+    if max_query_range <= 0 || (query.end_time - query.start_time) / HOUR_MICROS <= max_query_range
+    {
+        return None;
+    }
+    query.start_time = query.end_time - max_query_range * HOUR_MICROS;
+    Some(format!(
+        "Query duration is modified due to query range restriction of {} hours",
+        max_query_range
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_clamp_range() {
+        let mut query = search::Query {
+            start_time: 0,
+            end_time: 48 * HOUR_MICROS,
+            ..Default::default()
+        };
+        assert!(clamp_range(0, &mut query).is_none());
+        assert!(clamp_range(48, &mut query).is_none());
+        assert!(clamp_range(24, &mut query).is_some());
+        assert_eq!(query.start_time, 24 * HOUR_MICROS);
+        assert_eq!(query.end_time, 48 * HOUR_MICROS);
+    }
+}
@@ -17,7 +17,11 @@ use bytes::Bytes;
 use config::{
     get_config,
     meta::search::Response,
-    utils::{file::scan_files, json, time::parse_str_to_timestamp_micros_as_option},
+    utils::{
+        file::scan_files,
+        json,
+        time::{parse_str_to_timestamp_micros_as_option, query_now_micros},
+    },
 };
 use infra::cache::{
     file_data::disk::{self, QUERY_RESULT_CACHE},
@@ -85,7 +89,7 @@ pub async fn check_cache(
 
         let mut req_time_range = (req.query.start_time, req.query.end_time);
         if req_time_range.1 == 0 {
-            req_time_range.1 = chrono::Utc::now().timestamp_micros();
+            req_time_range.1 = query_now_micros();
         }
 
         let meta_time_range_is_empty =
@@ -61,7 +61,7 @@ fn is_aggregate_expression(expr: &Expr) -> bool {
     }
 }
 
-const AGGREGATE_UDF_LIST: [&str; 7] = [
+const AGGREGATE_UDF_LIST: [&str; 9] = [
     "min",
     "max",
     "count",
@@ -69,6 +69,8 @@ const AGGREGATE_UDF_LIST: [&str; 7] = [
     "sum",
     "array_agg",
     "approx_percentile_cont",
+    "approx_topk",
+    "histogram_quantile",
 ];
 
 pub fn encode_sql_to_foldername(sql_query: &str) -> io::Result<String> {
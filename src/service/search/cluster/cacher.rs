@@ -16,10 +16,13 @@
 use config::cluster::{is_querier, LOCAL_NODE_UUID};
 use infra::errors::{Error, ErrorCodes};
 use proto::cluster_rpc::{self, DeleteResultCacheRequest, QueryCacheRequest};
-use tonic::{codec::CompressionEncoding, metadata::MetadataValue, transport::Channel, Request};
+use tonic::{codec::CompressionEncoding, metadata::MetadataValue, Request};
 use tracing::{info_span, Instrument};
 
-use crate::{common::meta::search::CachedQueryResponse, service::search::infra_cluster};
+use crate::{
+    common::{infra::tls, meta::search::CachedQueryResponse},
+    service::search::infra_cluster,
+};
 
 pub async fn get_cached_results(
     start_time: i64,
@@ -85,7 +88,7 @@ pub async fn get_cached_results(
                 let token: MetadataValue<_> = infra_cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -296,7 +299,7 @@ pub async fn delete_cached_results(path: String) -> bool {
                 let token: MetadataValue<_> = infra_cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -23,6 +23,7 @@ use config::{
     meta::{
         cluster::{Node, Role},
         search::{self, ScanStats},
+        sql::{Sql as MetaSql, Where},
         stream::{
             FileKey, PartitionTimeLevel, QueryPartitionStrategy, StreamPartition, StreamType,
         },
@@ -37,16 +38,23 @@ use infra::{
 };
 use itertools::Itertools;
 use proto::cluster_rpc;
+use sqlparser::{
+    ast::{Expr as SqlExpr, SelectItem, SetExpr, Statement},
+    dialect::GenericDialect,
+    parser::Parser,
+};
 use tonic::{
     codec::CompressionEncoding,
     metadata::{MetadataKey, MetadataValue},
-    transport::Channel,
     Request,
 };
 use tracing::{info_span, Instrument};
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 
-use crate::{common::infra::cluster as infra_cluster, service::file_list};
+use crate::{
+    common::infra::{cluster as infra_cluster, tls},
+    service::{file_list, search::cache::result_utils::is_aggregate_query},
+};
 
 pub mod cacher;
 pub mod grpc;
@@ -119,6 +127,17 @@ pub async fn search(
     let partition_time_level =
         unwrap_partition_time_level(stream_settings.partition_time_level, stream_type);
 
+    if let Some(primary_key) = stream_settings.primary_key.as_deref() {
+        if !req.aggs.is_empty() || !meta.fulltext.is_empty() {
+            return Err(Error::ErrorCode(ErrorCodes::SearchSQLNotValid(
+                "Histograms and full text search are not supported on upsert streams".to_string(),
+            )));
+        }
+        if let Err(e) = check_upsert_query(&meta.meta, &meta.origin_sql, primary_key) {
+            return Err(Error::ErrorCode(ErrorCodes::SearchSQLNotValid(e)));
+        }
+    }
+
     // If the query is of type inverted index and this is not an aggregations request
     let file_list = if is_inverted_index && req.aggs.is_empty() {
         let mut idx_req = req.clone();
@@ -253,7 +272,7 @@ pub async fn search(
             &meta,
             stream_type,
             partition_time_level,
-            &stream_settings.partition_keys,
+            &stream_settings.search_partition_keys(stream_type),
         )
         .await
     };
@@ -490,7 +509,7 @@ pub async fn search(
                 let token: MetadataValue<_> = infra_cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -779,8 +798,18 @@ async fn merge_grpc_result(
         .collect::<Vec<_>>();
 
     // merge all batches
+    let primary_key = unwrap_stream_settings(&sql.schema).and_then(|s| s.primary_key);
     let mut merge_batches = HashMap::new();
     for (name, batch) in batches {
+        // the versions of an upsert stream can come from several nodes
+        let batch = match primary_key.as_deref() {
+            Some(key) if name == "query" && sql.meta.group_by.is_empty() => {
+                super::datafusion::exec::merge_upsert_versions(batch, key)
+                    .await
+                    .map_err(|e| Error::ErrorCode(ErrorCodes::ServerInternalError(e.to_string())))?
+            }
+            _ => batch,
+        };
         let (merge_sql, select_fields) = if name == "query" {
             (sql.origin_sql.clone(), select_fields.clone())
         } else {
@@ -997,10 +1026,101 @@ fn handle_metrics_response(sources: Vec<json::Value>) -> Vec<json::Value> {
     new_sources
 }
 
+/// Rejects the queries the merge on read of an upsert stream can't answer.
+/// Each querier only sees the versions of its own files, the leader drops a
+/// row when a newer version of its key reaches it too. That holds when:
+/// - the filters only use the primary key, which all the versions of a key share, and `_timestamp`,
+///   which selects the versions in the time range
+/// - the rows come newest first, so a querier cutting a newer version at the limit has enough newer
+///   rows for the older version to be cut too
+/// - there is no aggregation, which would count every version
+/// - the rows have the primary key and `_timestamp` for the leader to compare
+fn check_upsert_query(
+    sql: &MetaSql,
+    origin_sql: &str,
+    primary_key: &str,
+) -> std::result::Result<(), String> {
+    let column_timestamp = &get_config().common.column_timestamp;
+    if !sql.group_by.is_empty()
+        || sql.having
+        || sql.subquery.is_some()
+        || is_aggregate_query(origin_sql).unwrap_or(true)
+    {
+        return Err("Aggregations are not supported on upsert streams".to_string());
+    }
+    if !selects_versions(origin_sql, primary_key, column_timestamp) {
+        return Err(format!(
+            "Queries of upsert streams must select the primary key {primary_key} and {column_timestamp}"
+        ));
+    }
+    let where_fields: Vec<String> = Where(&sql.selection)
+        .try_into()
+        .map_err(|e: anyhow::Error| e.to_string())?;
+    if let Some(field) = where_fields
+        .iter()
+        .find(|f| *f != primary_key && *f != column_timestamp)
+    {
+        return Err(format!(
+            "Upsert streams can only be filtered by the primary key {primary_key} and {column_timestamp}, not by {field}"
+        ));
+    }
+    if sql
+        .order_by
+        .iter()
+        .any(|(field, desc)| field != column_timestamp || !desc)
+    {
+        return Err(format!(
+            "Upsert streams can only be ordered by {column_timestamp} DESC"
+        ));
+    }
+    Ok(())
+}
+
+/// Returns true when the query selects all the fields, or the primary key and
+/// `_timestamp` without an alias
+fn selects_versions(origin_sql: &str, primary_key: &str, column_timestamp: &str) -> bool {
+    let Ok(statements) = Parser::parse_sql(&GenericDialect {}, origin_sql) else {
+        return false;
+    };
+    let Some(Statement::Query(query)) = statements.first() else {
+        return false;
+    };
+    let SetExpr::Select(select) = query.body.as_ref() else {
+        return false;
+    };
+    let mut names = Vec::new();
+    for item in select.projection.iter() {
+        match item {
+            SelectItem::Wildcard(_) | SelectItem::QualifiedWildcard(..) => return true,
+            SelectItem::UnnamedExpr(SqlExpr::Identifier(ident)) => names.push(ident.value.as_str()),
+            _ => {}
+        }
+    }
+    names.contains(&primary_key) && names.contains(&column_timestamp)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_check_upsert_query() {
+        let check = |sql: &str| check_upsert_query(&MetaSql::new(sql).unwrap(), sql, "asset_id");
+        assert!(check("SELECT * FROM t ORDER BY _timestamp DESC LIMIT 10").is_ok());
+        assert!(check(
+            "SELECT * FROM t WHERE asset_id = 'a' AND _timestamp >= 1 ORDER BY _timestamp DESC"
+        )
+        .is_ok());
+        assert!(check("SELECT * FROM t WHERE status = 'down'").is_err());
+        assert!(check("SELECT count(*) FROM t").is_err());
+        assert!(check("SELECT status, count(*) AS n FROM t GROUP BY status").is_err());
+        assert!(check("SELECT * FROM t ORDER BY _timestamp ASC").is_err());
+        assert!(check("SELECT * FROM t ORDER BY status DESC").is_err());
+        assert!(check("SELECT asset_id, _timestamp, status FROM t").is_ok());
+        assert!(check("SELECT status FROM t").is_err());
+        assert!(check("SELECT asset_id AS id, _timestamp FROM t").is_err());
+    }
+
     #[test]
     fn test_partition_file_by_bytes() {
         use config::meta::stream::FileMeta;
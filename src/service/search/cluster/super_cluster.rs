@@ -13,14 +13,22 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::sync::Arc;
+use std::{sync::Arc, time::Duration};
 
 use ::datafusion::arrow::record_batch::RecordBatch;
+use chrono::Utc;
 use config::{
-    meta::{cluster::Node, search},
+    get_config,
+    meta::{
+        cluster::Node,
+        search::{self, ClusterSearchStatus, ResponseClusterStats},
+    },
     utils::{arrow::record_batches_to_json_rows, flatten, json},
 };
+use hashbrown::HashMap;
 use infra::errors::{Error, ErrorCodes, Result};
+use once_cell::sync::Lazy;
+use parking_lot::RwLock;
 use proto::cluster_rpc;
 use vector_enrichment::TableRegistry;
 
@@ -48,15 +56,8 @@ pub async fn search(
     let query_fn = req.query.as_ref().unwrap().query_fn.clone();
     req.query.as_mut().unwrap().query_fn = "".to_string();
 
-    // handle query function
-    let (_took, grpc_results) =
-        o2_enterprise::enterprise::super_cluster::search(req, req_regions, req_clusters).await?;
-
-    // handle query function
-    let grpc_results = grpc_results
-        .into_iter()
-        .map(|v| (Node::default(), v))
-        .collect();
+    let (grpc_results, cluster_stats) =
+        search_clusters(&trace_id, req, req_regions, req_clusters).await?;
     let (merge_batches, scan_stats, is_partial) =
         super::merge_grpc_result(&trace_id, sql.clone(), grpc_results, true).await?;
 
@@ -177,6 +178,25 @@ pub async fn search(
             / scan_stats.querier_files as f64) as usize,
     );
 
+    let failed_clusters = cluster_stats
+        .iter()
+        .filter(|c| c.status != ClusterSearchStatus::Ok)
+        .map(|c| c.cluster.as_str())
+        .collect::<Vec<_>>();
+    if !failed_clusters.is_empty() {
+        let warning = format!(
+            "clusters {} did not respond, the results are partial",
+            failed_clusters.join(", ")
+        );
+        result.function_error = if result.function_error.is_empty() {
+            warning
+        } else {
+            format!("{}; {warning}", result.function_error)
+        };
+        result.set_partial(true);
+    }
+    result.clusters = cluster_stats;
+
     if query_type == "table" {
         result.response_type = "table".to_string();
     } else if query_type == "metrics" {
@@ -192,3 +212,207 @@ pub async fn search(
 
     Ok(result)
 }
+
+/// Recent search health of every cluster, by cluster name
+static CLUSTER_HEALTH: Lazy<RwLock<HashMap<String, ClusterHealth>>> = Lazy::new(Default::default);
+
+#[derive(Clone, Debug, Default)]
+struct ClusterHealth {
+    /// moving average of the search latency, in milliseconds
+    latency: f64,
+    /// consecutive failed or timed out searches
+    failures: u32,
+    last_attempt: i64,
+}
+
+impl ClusterHealth {
+    fn record(&mut self, took: usize, success: bool, now: i64) {
+        self.latency = if self.latency == 0.0 {
+            took as f64
+        } else {
+            self.latency * 0.8 + took as f64 * 0.2
+        };
+        self.failures = if success { 0 } else { self.failures + 1 };
+        self.last_attempt = now;
+    }
+
+    /// A cluster failing `max_failures` times in a row, or answering slower
+    /// than the timeout on average, is routed around and probed again once the
+    /// backoff passed
+    fn is_available(&self, now: i64, timeout_ms: u64, max_failures: u32, backoff: i64) -> bool {
+        let degraded =
+            (max_failures > 0 && self.failures >= max_failures) || self.latency > timeout_ms as f64;
+        !degraded || now - self.last_attempt >= backoff * 1_000_000
+    }
+}
+
+/// Queries every cluster on its own, so that a cluster which fails or times out
+/// only drops its part of the results
+async fn search_clusters(
+    trace_id: &str,
+    req: cluster_rpc::SearchRequest,
+    req_regions: Vec<String>,
+    req_clusters: Vec<String>,
+) -> Result<(
+    Vec<(Node, cluster_rpc::SearchResponse)>,
+    Vec<ResponseClusterStats>,
+)> {
+    let cfg = get_config();
+    let clusters = match o2_enterprise::enterprise::super_cluster::kv::cluster::list().await {
+        Ok(clusters) => clusters
+            .into_iter()
+            .filter(|c| {
+                (req_regions.is_empty() || req_regions.contains(&c.region))
+                    && (req_clusters.is_empty() || req_clusters.contains(&c.name))
+            })
+            .collect::<Vec<_>>(),
+        Err(e) => {
+            log::error!("[trace_id {trace_id}] super cluster: list clusters error: {e}");
+            vec![]
+        }
+    };
+    if clusters.is_empty() {
+        let (_took, results) =
+            o2_enterprise::enterprise::super_cluster::search(req, req_regions, req_clusters)
+                .await?;
+        let results = results.into_iter().map(|v| (Node::default(), v)).collect();
+        return Ok((results, vec![]));
+    }
+
+    let timeout = if req.timeout > 0 {
+        req.timeout as u64
+    } else {
+        cfg.limit.query_timeout
+    };
+    let now = Utc::now().timestamp_micros();
+    let mut stats = Vec::with_capacity(clusters.len());
+    let mut tasks = Vec::with_capacity(clusters.len());
+    for c in clusters {
+        let available = CLUSTER_HEALTH
+            .read()
+            .get(&c.name)
+            .map(|h| {
+                h.is_available(
+                    now,
+                    timeout * 1000,
+                    cfg.limit.super_cluster_max_failures,
+                    cfg.limit.super_cluster_backoff,
+                )
+            })
+            .unwrap_or(true);
+        if !available {
+            log::warn!(
+                "[trace_id {trace_id}] super cluster: skip unhealthy cluster {}",
+                c.name
+            );
+            stats.push(ResponseClusterStats {
+                cluster: c.name,
+                region: c.region,
+                status: ClusterSearchStatus::Skipped,
+                ..Default::default()
+            });
+            continue;
+        }
+        let req = req.clone();
+        tasks.push(tokio::task::spawn(async move {
+            let start = std::time::Instant::now();
+            let ret = tokio::time::timeout(
+                Duration::from_secs(timeout),
+                o2_enterprise::enterprise::super_cluster::search(
+                    req,
+                    vec![c.region.clone()],
+                    vec![c.name.clone()],
+                ),
+            )
+            .await;
+            (c.name, c.region, start.elapsed().as_millis() as usize, ret)
+        }));
+    }
+
+    let mut results = Vec::new();
+    let mut first_error = None;
+    for task in tasks {
+        let (cluster, region, took, ret) = task.await.map_err(|e| Error::Message(e.to_string()))?;
+        let mut stat = ResponseClusterStats {
+            cluster,
+            region,
+            took,
+            ..Default::default()
+        };
+        match ret {
+            Ok(Ok((_, responses))) => {
+                for resp in responses {
+                    if let Some(scan_stats) = resp.scan_stats.as_ref() {
+                        stat.files += scan_stats.files;
+                        stat.records += scan_stats.records;
+                        stat.scan_size += scan_stats.original_size;
+                    }
+                    results.push((Node::default(), resp));
+                }
+            }
+            Ok(Err(e)) => {
+                let e = Error::from(e);
+                stat.status = ClusterSearchStatus::Failed;
+                stat.error = e.to_string();
+                first_error.get_or_insert(e);
+            }
+            Err(_) => {
+                stat.status = ClusterSearchStatus::Timeout;
+                stat.error = format!("no response in {timeout} seconds");
+            }
+        }
+        if stat.status != ClusterSearchStatus::Ok {
+            log::error!(
+                "[trace_id {trace_id}] super cluster: search cluster {} error: {}",
+                stat.cluster,
+                stat.error
+            );
+        }
+        CLUSTER_HEALTH
+            .write()
+            .entry(stat.cluster.clone())
+            .or_default()
+            .record(took, stat.status == ClusterSearchStatus::Ok, now);
+        stats.push(stat);
+    }
+
+    let failed = stats
+        .iter()
+        .filter(|s| s.status != ClusterSearchStatus::Ok)
+        .count();
+    if failed == stats.len() || (failed > 0 && !cfg.common.super_cluster_partial_results) {
+        return Err(first_error.unwrap_or_else(|| {
+            Error::Message(format!(
+                "[trace_id {trace_id}] super cluster: request timeout in {failed} clusters"
+            ))
+        }));
+    }
+    Ok((results, stats))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_cluster_health() {
+        let mut health = ClusterHealth::default();
+        assert!(health.is_available(0, 1000, 3, 30));
+
+        health.record(200, false, 1_000_000);
+        health.record(200, false, 2_000_000);
+        assert!(health.is_available(2_000_000, 1000, 3, 30));
+        health.record(200, false, 3_000_000);
+        assert!(!health.is_available(4_000_000, 1000, 3, 30));
+        // probed again after the backoff
+        assert!(health.is_available(33_000_000, 1000, 3, 30));
+        // disabled
+        assert!(health.is_available(4_000_000, 1000, 0, 30));
+
+        health.record(100, true, 34_000_000);
+        assert_eq!(health.failures, 0);
+        assert!(health.is_available(34_000_000, 1000, 3, 30));
+        // slower than the timeout on average
+        assert!(!health.is_available(34_000_000, 100, 3, 30));
+    }
+}
@@ -0,0 +1,267 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Cursor pagination for raw (non aggregate) queries.
+//!
+//! Instead of growing `from` for every page, a cursor narrows the time range to the last
+//! returned timestamp, so the cost of a page doesn't depend on how deep the client is.
+//! `skip` only counts the rows sharing that last timestamp which were already returned.
+
+use bytes::Bytes;
+use config::{
+    get_config,
+    meta::{
+        search::{Request, Response},
+        sql::Sql,
+        stream::StreamType,
+    },
+    utils::{base64, json, time::query_now_micros},
+};
+use futures::{stream, Stream};
+use serde::{Deserialize, Serialize};
+
+use crate::service::plugins;
+
+/// Maximum rows fetched per page when streaming results
+pub const STREAM_MAX_PAGE_SIZE: i64 = 10000;
+
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct Cursor {
+    /// exclusive upper bound of the timestamp for the next page
+    pub end_time: i64,
+    /// rows at `end_time - 1` already returned
+    pub skip: i64,
+}
+
+impl Cursor {
+    pub fn encode(&self) -> String {
+        base64::encode_url(&json::to_string(self).unwrap())
+    }
+
+    /// Decodes a cursor, an empty string means the first page.
+    pub fn decode(s: &str) -> Result<Option<Self>, String> {
+        if s.is_empty() {
+            return Ok(None);
+        }
+        base64::decode_url(s)
+            .map_err(|e| e.to_string())
+            .and_then(|v| json::from_str(&v).map_err(|e| e.to_string()))
+            .map(Some)
+            .map_err(|e| format!("invalid cursor: {e}"))
+    }
+
+    pub fn apply(&self, req: &mut Request) {
+        req.query.end_time = self.end_time;
+        req.query.from = self.skip;
+    }
+}
+
+/// Checks the query can be paginated with cursors: rows must be returned in
+/// descending timestamp order and the time range must come from the request.
+pub fn validate(sql: &Sql) -> Result<(), String> {
+    let cfg = get_config();
+    if !sql.group_by.is_empty() || sql.having {
+        return Err("cursor pagination doesn't support aggregate queries".to_string());
+    }
+    if sql.subquery.is_some() {
+        return Err("cursor pagination doesn't support subqueries".to_string());
+    }
+    if sql.limit > 0 || sql.offset > 0 {
+        return Err("cursor pagination doesn't support LIMIT/OFFSET in sql, use size".to_string());
+    }
+    if sql.time_range.is_some() {
+        return Err(format!(
+            "cursor pagination doesn't support {} filters in sql, use start_time/end_time",
+            cfg.common.column_timestamp
+        ));
+    }
+    match sql.order_by.as_slice() {
+        [] => Ok(()),
+        [(field, true)] if field == &cfg.common.column_timestamp => Ok(()),
+        _ => Err(format!(
+            "cursor pagination only supports ORDER BY {} DESC",
+            cfg.common.column_timestamp
+        )),
+    }
+}
+
+/// Returns the cursor of the page following `res`, or `None` when `res` was the last page.
+pub fn next_cursor(req: &Request, res: &Response) -> Option<Cursor> {
+    if res.hits.is_empty() || (res.hits.len() as i64) < req.query.size {
+        return None;
+    }
+    let ts_col = &get_config().common.column_timestamp;
+    let get_ts = |hit: &json::Value| hit.get(ts_col).and_then(|v| v.as_i64());
+    let last_ts = get_ts(res.hits.last()?)?;
+    let same_ts = res
+        .hits
+        .iter()
+        .rev()
+        .take_while(|hit| get_ts(hit) == Some(last_ts))
+        .count() as i64;
+    let end_time = last_ts + 1;
+    // the whole page shared the timestamp of the previous cursor, keep skipping
+    let skip = if end_time == req.query.end_time && same_ts == res.hits.len() as i64 {
+        req.query.from + same_ts
+    } else {
+        same_ts
+    };
+    Some(Cursor { end_time, skip })
+}
+
+/// Streams every matching row as NDJSON, fetching the pages with cursors. The request
+/// may already carry a cursor to resume an interrupted stream. Each page runs through the
+/// plugin query function `plugin_fn` when given, a failing plugin leaves the rows as they are
+/// and adds a `function_error` line.
+pub fn stream_ndjson(
+    trace_id: String,
+    org_id: String,
+    stream_type: StreamType,
+    user_id: Option<String>,
+    mut req: Request,
+    plugin_fn: Option<String>,
+) -> impl Stream<Item = Result<Bytes, std::io::Error>> {
+    if req.query.size <= 0 || req.query.size > STREAM_MAX_PAGE_SIZE {
+        req.query.size = STREAM_MAX_PAGE_SIZE;
+    }
+    if req.query.end_time == 0 {
+        req.query.end_time = query_now_micros();
+    }
+    stream::unfold(Some(req), move |req| {
+        let trace_id = trace_id.clone();
+        let org_id = org_id.clone();
+        let user_id = user_id.clone();
+        let plugin_fn = plugin_fn.clone();
+        async move {
+            let mut req = req?;
+            let res = match super::search(&trace_id, &org_id, stream_type, user_id, &req).await {
+                Ok(res) => res,
+                Err(e) => {
+                    log::error!("[trace_id {trace_id}] search stream error: {e}");
+                    let line = json::json!({"error": e.to_string()}).to_string() + "\n";
+                    return Some((Ok(Bytes::from(line)), None));
+                }
+            };
+            // the cursor comes from the rows of the query, before the plugin changes them
+            let next = next_cursor(&req, &res).map(|cursor| {
+                cursor.apply(&mut req);
+                req
+            });
+            let mut hits = res.hits;
+            let mut function_error = None;
+            if let Some(plugin_fn) = plugin_fn.as_deref() {
+                match plugins::call_function(&org_id, plugin_fn, &hits).await {
+                    Ok(v) => hits = v,
+                    Err(e) => {
+                        log::error!("[trace_id {trace_id}] plugin function {plugin_fn} error: {e}");
+                        function_error = Some(e.to_string());
+                    }
+                }
+            }
+            let mut buf = Vec::with_capacity(hits.len() * 256);
+            for hit in hits.iter() {
+                if let Ok(line) = json::to_vec(hit) {
+                    buf.extend_from_slice(&line);
+                    buf.push(b'\n');
+                }
+            }
+            if let Some(e) = function_error {
+                buf.extend_from_slice(json::json!({"function_error": e}).to_string().as_bytes());
+                buf.push(b'\n');
+            }
+            Some((Ok(Bytes::from(buf)), next))
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use config::meta::search::Query;
+
+    use super::*;
+
+    fn hits(ts: &[i64]) -> Response {
+        let mut res = Response::new(0, ts.len() as i64);
+        for t in ts {
+            res.add_hit(&json::json!({"_timestamp": t}));
+        }
+        res
+    }
+
+    fn request(end_time: i64, from: i64, size: i64) -> Request {
+        Request {
+            query: Query {
+                sql: "select * from t".to_string(),
+                end_time,
+                from,
+                size,
+                ..Default::default()
+            },
+            aggs: Default::default(),
+            encoding: Default::default(),
+            regions: vec![],
+            clusters: vec![],
+            timeout: 0,
+            search_type: None,
+            search_event_context: None,
+        }
+    }
+
+    #[test]
+    fn test_cursor_encode_decode() {
+        let cursor = Cursor {
+            end_time: 1700000000000000,
+            skip: 3,
+        };
+        let resp = Cursor::decode(&cursor.encode()).unwrap();
+        assert_eq!(resp, Some(cursor));
+        assert_eq!(Cursor::decode("").unwrap(), None);
+        assert!(Cursor::decode("not a cursor").is_err());
+    }
+
+    #[test]
+    fn test_next_cursor() {
+        // a short page is the last one
+        assert!(next_cursor(&request(100, 0, 3), &hits(&[90, 80])).is_none());
+
+        let cursor = next_cursor(&request(100, 0, 3), &hits(&[90, 80, 80])).unwrap();
+        assert_eq!(
+            cursor,
+            Cursor {
+                end_time: 81,
+                skip: 2
+            }
+        );
+
+        // the whole page shares the cursor timestamp
+        let cursor = next_cursor(&request(81, 2, 3), &hits(&[80, 80, 80])).unwrap();
+        assert_eq!(
+            cursor,
+            Cursor {
+                end_time: 81,
+                skip: 5
+            }
+        );
+    }
+
+    #[test]
+    fn test_validate() {
+        assert!(validate(&Sql::new("select * from t").unwrap()).is_ok());
+        assert!(validate(&Sql::new("select * from t order by _timestamp desc").unwrap()).is_ok());
+        assert!(validate(&Sql::new("select * from t order by _timestamp asc").unwrap()).is_err());
+        assert!(validate(&Sql::new("select count(*) from t group by a").unwrap()).is_err());
+        assert!(validate(&Sql::new("select * from t limit 10").unwrap()).is_err());
+    }
+}
@@ -25,7 +25,8 @@ use config::{
     },
     utils::{
         arrow::record_batches_to_json_rows, flatten, json, parquet::new_parquet_writer,
-        schema::infer_json_schema_from_values, schema_ext::SchemaExt,
+        record_batch_ext::format_recordbatch_by_schema, schema::infer_json_schema_from_values,
+        schema_ext::SchemaExt,
     },
     PARQUET_BATCH_SIZE,
 };
@@ -39,7 +40,7 @@ use datafusion::{
         file_format::{json::JsonFormat, parquet::ParquetFormat},
         listing::{ListingOptions, ListingTableConfig, ListingTableUrl},
         object_store::{DefaultObjectStoreRegistry, ObjectStoreRegistry},
-        MemTable,
+        MemTable, TableProvider,
     },
     error::{DataFusionError, Result},
     execution::{
@@ -70,7 +71,7 @@ use crate::{
 
 const DATAFUSION_MIN_MEM: usize = 1024 * 1024 * 256; // 256MB
 
-const AGGREGATE_UDF_LIST: [&str; 7] = [
+const AGGREGATE_UDF_LIST: [&str; 9] = [
     "min",
     "max",
     "count",
@@ -78,8 +79,13 @@ const AGGREGATE_UDF_LIST: [&str; 7] = [
     "sum",
     "array_agg",
     "approx_percentile_cont",
+    "approx_topk",
+    "histogram_quantile",
 ];
 
+/// Column numbering the versions of a primary key in the upsert view, newest first
+const UPSERT_VERSION_COLUMN: &str = "_upsert_version";
+
 static RE_WHERE: Lazy<Regex> = Lazy::new(|| Regex::new(r"(?i) where (.*)").unwrap());
 static RE_COUNT_DISTINCT: Lazy<Regex> = Lazy::new(|| {
     Regex::new(r"(?i)count\s*\(\s*distinct\(.*?\)\)|count\s*\(\s*distinct\s+(\w+)\s*\)").unwrap()
@@ -124,7 +130,7 @@ pub async fn sql(
         let record_batches = in_records_batches.unwrap();
         let mem_table = Arc::new(MemTable::try_new(schema.clone(), vec![record_batches])?);
         // Register the MemTable as a table in the DataFusion context
-        ctx.register_table("tbl", mem_table)?;
+        register_upsert_table(&ctx, "tbl", mem_table, session.primary_key.as_deref()).await?;
         ctx
     };
 
@@ -487,6 +493,8 @@ async fn get_fast_mode_ctx(
         storage_type: session.storage_type.clone(),
         search_type: session.search_type.clone(),
         work_group: session.work_group.clone(),
+        sorted_by_time: session.sorted_by_time,
+        primary_key: session.primary_key.clone(),
     };
     let mut ctx = register_table(
         &fast_session,
@@ -897,7 +905,24 @@ fn merge_rewrite_sql(
         if fn_name == "count" {
             fn_name = "sum".to_string();
         }
-        if fn_name == "approx_percentile_cont" {
+        if fn_name == "approx_topk" {
+            // partial top-k results are merged, not recomputed
+            let k = cap
+                .get(2)
+                .unwrap()
+                .as_str()
+                .splitn(2, ',')
+                .last()
+                .unwrap()
+                .trim();
+            fields[i] = format!(
+                "{}(\"{}\", {}) {}",
+                super::udf::approx_topk_udf::APPROX_TOPK_MERGE_UDAF_NAME,
+                schema_field,
+                k,
+                over_as
+            );
+        } else if fn_name == "approx_percentile_cont" {
             let percentile = cap
                 .get(2)
                 .unwrap()
@@ -1029,6 +1054,7 @@ pub async fn convert_parquet_file(
         bloom_filter_fields,
         full_text_search_fields,
         &file_meta,
+        None,
     );
     for batch in batches {
         writer.write(&batch).await?;
@@ -1050,6 +1076,7 @@ pub async fn merge_parquet_files(
     stream_type: StreamType,
     stream_name: &str,
     schema: Arc<Schema>,
+    clustering_keys: &[String],
 ) -> Result<(Arc<Schema>, Vec<RecordBatch>)> {
     let start = std::time::Instant::now();
     let cfg = get_config();
@@ -1070,10 +1097,13 @@ pub async fn merge_parquet_files(
             cfg.common.column_timestamp, cfg.common.column_timestamp, cfg.common.column_timestamp
         )
     } else {
-        format!(
-            "SELECT * FROM tbl ORDER BY {} DESC",
-            cfg.common.column_timestamp
-        )
+        let mut order_by = clustering_keys
+            .iter()
+            .filter(|key| schema.field_with_name(key).is_ok())
+            .map(|key| format!("\"{key}\""))
+            .collect::<Vec<_>>();
+        order_by.push(format!("{} DESC", cfg.common.column_timestamp));
+        format!("SELECT * FROM tbl ORDER BY {}", order_by.join(", "))
     };
 
     // create datafusion context
@@ -1217,6 +1247,12 @@ async fn register_udf(ctx: &mut SessionContext, _org_id: &str) {
     ctx.register_udf(super::udf::regexp_udf::REGEX_MATCH_UDF.clone());
     ctx.register_udf(super::udf::regexp_udf::REGEX_NOT_MATCH_UDF.clone());
     ctx.register_udf(super::udf::regexp_udf::REGEXP_MATCH_TO_FIELDS_UDF.clone());
+    ctx.register_udf(super::udf::parse_regex_udf::PARSE_REGEX_UDF.clone());
+    ctx.register_udf(super::udf::parse_kv_udf::PARSE_KV_UDF.clone());
+    ctx.register_udf(super::udf::geohash_udf::GEOHASH_UDF.clone());
+    ctx.register_udf(super::udf::geohash_udf::GEOHASH_LAT_UDF.clone());
+    ctx.register_udf(super::udf::geohash_udf::GEOHASH_LON_UDF.clone());
+    ctx.register_udf(super::udf::country_code_udf::COUNTRY_CODE_UDF.clone());
     ctx.register_udf(super::udf::time_range_udf::TIME_RANGE_UDF.clone());
     ctx.register_udf(super::udf::date_format_udf::DATE_FORMAT_UDF.clone());
     ctx.register_udf(super::udf::string_to_array_v2_udf::STRING_TO_ARRAY_V2_UDF.clone());
@@ -1225,10 +1261,16 @@ async fn register_udf(ctx: &mut SessionContext, _org_id: &str) {
     ctx.register_udf(super::udf::arr_descending_udf::ARR_DESCENDING_UDF.clone());
     ctx.register_udf(super::udf::arrjoin_udf::ARR_JOIN_UDF.clone());
     ctx.register_udf(super::udf::arrcount_udf::ARR_COUNT_UDF.clone());
+    ctx.register_udf(super::udf::arrcontains_udf::ARR_CONTAINS_UDF.clone());
     ctx.register_udf(super::udf::arrsort_udf::ARR_SORT_UDF.clone());
     ctx.register_udf(super::udf::cast_to_arr_udf::CAST_TO_ARR_UDF.clone());
     ctx.register_udf(super::udf::spath_udf::SPATH_UDF.clone());
+    ctx.register_udf(super::udf::span_event_attr_udf::SPAN_EVENT_ATTR_UDF.clone());
     ctx.register_udf(super::udf::to_arr_string_udf::TO_ARR_STRING.clone());
+    ctx.register_udf(super::udf::enrich_udf::get_enrich_udf(_org_id));
+    ctx.register_udaf(super::udf::histogram_quantile_udf::HISTOGRAM_QUANTILE_UDAF.clone());
+    ctx.register_udaf(super::udf::approx_topk_udf::APPROX_TOPK_UDAF.clone());
+    ctx.register_udaf(super::udf::approx_topk_udf::APPROX_TOPK_MERGE_UDAF.clone());
 
     {
         let udf_list = get_all_transform(_org_id).await;
@@ -1277,16 +1319,18 @@ pub async fn register_table(
         }
     };
 
-    // specify sort columns for parquet file
-    listing_options = listing_options.with_file_sort_order(vec![vec![Expr::Sort(
-        datafusion::logical_expr::SortExpr {
-            expr: Box::new(Expr::Column(Column::new_unqualified(
-                cfg.common.column_timestamp.clone(),
-            ))),
-            asc: false,
-            nulls_first: false,
-        },
-    )]]);
+    // specify sort columns for parquet file, clustered files are sorted by other columns first
+    if session.sorted_by_time {
+        listing_options = listing_options.with_file_sort_order(vec![vec![Expr::Sort(
+            datafusion::logical_expr::SortExpr {
+                expr: Box::new(Expr::Column(Column::new_unqualified(
+                    cfg.common.column_timestamp.clone(),
+                ))),
+                asc: false,
+                nulls_first: false,
+            },
+        )]]);
+    }
 
     let schema_key = schema.hash_key();
     let prefix = if session.storage_type == StorageType::Memory {
@@ -1345,11 +1389,81 @@ pub async fn register_table(
     if session.storage_type != StorageType::Tmpfs {
         table = table.with_cache(ctx.runtime_env().cache_manager.get_file_statistic_cache());
     }
-    ctx.register_table(table_name, Arc::new(table))?;
+    register_upsert_table(
+        &ctx,
+        table_name,
+        Arc::new(table),
+        session.primary_key.as_deref(),
+    )
+    .await?;
 
     Ok(ctx)
 }
 
+/// Registers the table, for upsert streams behind a view only showing the
+/// latest version of each primary key. The records without the key are all kept
+async fn register_upsert_table(
+    ctx: &SessionContext,
+    table_name: &str,
+    table: Arc<dyn TableProvider>,
+    primary_key: Option<&str>,
+) -> Result<()> {
+    let Some(primary_key) = primary_key.filter(|key| table.schema().field_with_name(key).is_ok())
+    else {
+        ctx.register_table(table_name, table)?;
+        return Ok(());
+    };
+    let versions_table = format!("{table_name}_versions");
+    ctx.register_table(versions_table.as_str(), table)?;
+    let view = ctx
+        .sql(&upsert_view_sql(&versions_table, primary_key))
+        .await?
+        .into_view();
+    ctx.register_table(table_name, view)?;
+    Ok(())
+}
+
+fn upsert_view_sql(table_name: &str, primary_key: &str) -> String {
+    format!(
+        "SELECT * EXCLUDE ({UPSERT_VERSION_COLUMN}) FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY \"{primary_key}\" ORDER BY \"{}\" DESC) AS {UPSERT_VERSION_COLUMN} FROM \"{table_name}\") WHERE {UPSERT_VERSION_COLUMN} = 1 OR \"{primary_key}\" IS NULL",
+        get_config().common.column_timestamp
+    )
+}
+
+/// Keeps the latest version of each primary key in the records merged from
+/// several tables of an upsert stream. The batches without the key or the
+/// timestamp, e.g. aggregations, are returned as they are
+pub async fn merge_upsert_versions(
+    batches: Vec<RecordBatch>,
+    primary_key: &str,
+) -> Result<Vec<RecordBatch>> {
+    if batches.is_empty() {
+        return Ok(batches);
+    }
+    let schema = Arc::new(Schema::try_merge(
+        batches.iter().map(|b| b.schema().as_ref().clone()),
+    )?);
+    if schema.field_with_name(primary_key).is_err()
+        || schema
+            .field_with_name(&get_config().common.column_timestamp)
+            .is_err()
+    {
+        return Ok(batches);
+    }
+    let batches = batches
+        .into_iter()
+        .map(|b| format_recordbatch_by_schema(schema.clone(), b))
+        .collect::<Vec<_>>();
+
+    let ctx = SessionContext::new();
+    let mem_table = Arc::new(MemTable::try_new(schema, vec![batches])?);
+    ctx.register_table("tbl_versions", mem_table)?;
+    ctx.sql(&upsert_view_sql("tbl_versions", primary_key))
+        .await?
+        .collect()
+        .await
+}
+
 fn handle_query_fn(
     query_fn: String,
     batches: &[&RecordBatch],
@@ -20,6 +20,8 @@ use core::ops::ControlFlow;
 use config::FxIndexSet;
 use datafusion::error::Result;
 use itertools::Itertools;
+use once_cell::sync::Lazy;
+use regex::{Captures, Regex};
 use sqlparser::{
     ast::{
         Expr, Function, FunctionArguments, GroupByExpr, Ident, ObjectName, Query, TableFactor,
@@ -39,6 +41,16 @@ const AGGREGATE_UDF_LIST: [&str; 7] = [
     "approx_percentile_cont",
 ];
 
+/// A column followed by a path with at least one array subscript, like
+/// `field.sub[0].name` or `items[*].id`, with the character before the column
+const PATH_STEP: &str = r"(?:\.[a-zA-Z_][a-zA-Z0-9_]*|\[\s*(?:[0-9]+|\*)\s*\])";
+static RE_PATH_ACCESS: Lazy<Regex> = Lazy::new(|| {
+    Regex::new(&format!(
+        r"(^|[^a-zA-Z0-9_.])([a-zA-Z_][a-zA-Z0-9_]*)({PATH_STEP}*\[\s*(?:[0-9]+|\*)\s*\]{PATH_STEP}*)"
+    ))
+    .unwrap()
+});
+
 pub fn rewrite_count_distinct_sql(sql: &str, is_first_phase: bool) -> Result<String> {
     let mut statements = Parser::parse_sql(&GenericDialect {}, sql)?;
     if is_first_phase {
@@ -398,6 +410,48 @@ impl VisitorMut for ReplaceDataSource {
     }
 }
 
+/// Rewrites the path accesses into nested fields, like `field.sub[0].name`,
+/// into `spath(field, 'sub[0].name')`. The nested values are stored as json
+/// strings, so the path is resolved by spath at query time. Only the paths with
+/// an array subscript are rewritten, `a.b` being a qualified column, and the
+/// quoted strings and identifiers are left as they are.
+pub fn rewrite_path_access(sql: &str) -> String {
+    if !sql.contains('[') {
+        return sql.to_string();
+    }
+    let mut new_sql = String::with_capacity(sql.len());
+    let mut rest = sql;
+    while let Some(start) = rest.find(['\'', '"']) {
+        new_sql.push_str(&replace_path_access(&rest[..start]));
+        let quote = &rest[start..start + 1];
+        let end = rest[start + 1..]
+            .find(quote)
+            .map(|i| start + i + 2)
+            .unwrap_or(rest.len());
+        new_sql.push_str(&rest[start..end]);
+        rest = &rest[end..];
+    }
+    new_sql.push_str(&replace_path_access(rest));
+    new_sql
+}
+
+fn replace_path_access(sql: &str) -> String {
+    RE_PATH_ACCESS
+        .replace_all(sql, |caps: &Captures| {
+            let path = caps[3]
+                .chars()
+                .filter(|c| !c.is_whitespace())
+                .collect::<String>();
+            format!(
+                "{}spath({}, '{}')",
+                &caps[1],
+                &caps[2],
+                path.trim_start_matches('.')
+            )
+        })
+        .to_string()
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -519,4 +573,33 @@ mod tests {
             assert_eq!(new_sql, except.to_string());
         }
     }
+
+    #[test]
+    fn test_rewrite_path_access() {
+        let sqls = [
+            (
+                "SELECT field.sub[0].name FROM tbl",
+                "SELECT spath(field, 'sub[0].name') FROM tbl",
+            ),
+            (
+                "SELECT * FROM tbl WHERE items[ 1 ].id = 3",
+                "SELECT * FROM tbl WHERE spath(items, '[1].id') = 3",
+            ),
+            (
+                "SELECT unnest(cast_to_arr(items[*].id)) FROM tbl",
+                "SELECT unnest(cast_to_arr(spath(items, '[*].id'))) FROM tbl",
+            ),
+            (
+                "SELECT * FROM tbl WHERE log = 'items[0].id' AND a.b = 1",
+                "SELECT * FROM tbl WHERE log = 'items[0].id' AND a.b = 1",
+            ),
+            (
+                "SELECT \"items\".sub[0] FROM tbl",
+                "SELECT \"items\".sub[0] FROM tbl",
+            ),
+        ];
+        for (sql, expected) in sqls {
+            assert_eq!(rewrite_path_access(sql), expected);
+        }
+    }
 }
@@ -0,0 +1,399 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, sync::Arc};
+
+use config::utils::json;
+use datafusion::{
+    arrow::{
+        array::{Array, ArrayRef},
+        datatypes::DataType,
+    },
+    common::{
+        cast::{as_int64_array, as_string_array},
+        ScalarValue,
+    },
+    error::{DataFusionError, Result},
+    logical_expr::{create_udaf, Accumulator, AggregateUDF, Volatility},
+};
+use once_cell::sync::Lazy;
+use serde::{Deserialize, Serialize};
+
+/// The name of the approx_topk UDAF given to DataFusion.
+pub const APPROX_TOPK_UDAF_NAME: &str = "approx_topk";
+/// The name of the approx_topk_merge UDAF given to DataFusion.
+pub const APPROX_TOPK_MERGE_UDAF_NAME: &str = "approx_topk_merge";
+
+/// Number of counters kept per requested item, the estimation error of an
+/// item is bounded by `rows / (k * TOPK_CAPACITY_FACTOR)`.
+const TOPK_CAPACITY_FACTOR: usize = 10;
+const TOPK_MIN_CAPACITY: usize = 100;
+
+/// Implementation of approx_topk(field, k).
+///
+/// Returns the `k` most frequent values of `field` as a JSON array of
+/// `{"value", "count"}` objects, using the space-saving algorithm so only a
+/// bounded number of counters is kept instead of one group per value.
+pub(crate) static APPROX_TOPK_UDAF: Lazy<AggregateUDF> = Lazy::new(|| {
+    create_udaf(
+        APPROX_TOPK_UDAF_NAME,
+        // expects the field and the number of items
+        vec![DataType::Utf8, DataType::Int64],
+        // returns json array
+        Arc::new(DataType::Utf8),
+        Volatility::Immutable,
+        Arc::new(|_| Ok(Box::new(TopKAccumulator::new(false)))),
+        Arc::new(vec![DataType::Utf8]),
+    )
+});
+
+/// Implementation of approx_topk_merge(topk, k).
+///
+/// Merges the results of `approx_topk` computed on different partitions.
+pub(crate) static APPROX_TOPK_MERGE_UDAF: Lazy<AggregateUDF> = Lazy::new(|| {
+    create_udaf(
+        APPROX_TOPK_MERGE_UDAF_NAME,
+        // expects the json array returned by approx_topk and the number of items
+        vec![DataType::Utf8, DataType::Int64],
+        // returns json array
+        Arc::new(DataType::Utf8),
+        Volatility::Immutable,
+        Arc::new(|_| Ok(Box::new(TopKAccumulator::new(true)))),
+        Arc::new(vec![DataType::Utf8]),
+    )
+});
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TopKItem {
+    pub value: String,
+    pub count: u64,
+}
+
+/// Space-saving summary. Counters are only pruned once twice the capacity is
+/// reached, so inserts stay amortized O(1); `floor` is the largest count that
+/// was pruned, i.e. the most an untracked value can have been seen.
+#[derive(Debug, Default, Clone, PartialEq, Serialize, Deserialize)]
+pub struct SpaceSaving {
+    capacity: usize,
+    floor: u64,
+    counters: HashMap<String, u64>,
+}
+
+impl SpaceSaving {
+    pub fn new(capacity: usize) -> Self {
+        Self {
+            capacity,
+            ..Default::default()
+        }
+    }
+
+    pub fn insert(&mut self, value: &str, weight: u64) {
+        match self.counters.get_mut(value) {
+            Some(count) => *count += weight,
+            None => {
+                // an untracked value may have been pruned before
+                self.counters.insert(value.to_string(), self.floor + weight);
+                if self.counters.len() >= self.capacity * 2 {
+                    self.prune(self.capacity);
+                }
+            }
+        }
+    }
+
+    pub fn merge(&mut self, other: &SpaceSaving) {
+        self.capacity = self.capacity.max(other.capacity);
+        for (value, count) in self.counters.iter_mut() {
+            if !other.counters.contains_key(value) {
+                *count += other.floor;
+            }
+        }
+        for (value, count) in other.counters.iter() {
+            match self.counters.get_mut(value) {
+                Some(c) => *c += count,
+                None => {
+                    self.counters.insert(value.to_string(), self.floor + count);
+                }
+            }
+        }
+        self.floor += other.floor;
+        if self.counters.len() >= self.capacity * 2 {
+            self.prune(self.capacity);
+        }
+    }
+
+    fn prune(&mut self, size: usize) {
+        if self.counters.len() <= size {
+            return;
+        }
+        let mut counts = self.counters.values().copied().collect::<Vec<_>>();
+        counts.sort_unstable_by(|a, b| b.cmp(a));
+        let min_kept = counts[size - 1];
+        let mut kept = 0;
+        self.counters.retain(|_, count| {
+            // ties at the boundary are dropped once `size` items are kept
+            if *count > min_kept || (*count == min_kept && kept < size) {
+                kept += 1;
+                true
+            } else {
+                self.floor = self.floor.max(*count);
+                false
+            }
+        });
+    }
+
+    /// Returns the `k` most frequent values, ordered by count.
+    pub fn top(&self, k: usize) -> Vec<TopKItem> {
+        let mut items = self
+            .counters
+            .iter()
+            .map(|(value, count)| TopKItem {
+                value: value.to_string(),
+                count: *count,
+            })
+            .collect::<Vec<_>>();
+        items.sort_by(|a, b| b.count.cmp(&a.count).then_with(|| a.value.cmp(&b.value)));
+        items.truncate(k);
+        items
+    }
+}
+
+#[derive(Debug)]
+struct TopKAccumulator {
+    merge: bool,
+    k: usize,
+    summary: SpaceSaving,
+}
+
+impl TopKAccumulator {
+    fn new(merge: bool) -> Self {
+        Self {
+            merge,
+            k: 0,
+            summary: SpaceSaving::default(),
+        }
+    }
+
+    fn set_k(&mut self, k: i64) -> Result<()> {
+        if self.k > 0 {
+            return Ok(());
+        }
+        if k <= 0 {
+            return Err(DataFusionError::Execution(format!(
+                "{APPROX_TOPK_UDAF_NAME}: k should be greater than 0"
+            )));
+        }
+        self.k = k as usize;
+        self.summary.capacity = (self.k * TOPK_CAPACITY_FACTOR).max(TOPK_MIN_CAPACITY);
+        Ok(())
+    }
+
+    /// Turns a previous `approx_topk` result into a summary. A full list may
+    /// have left out values counted up to its last item.
+    fn merge_result(&mut self, result: &str) -> Result<()> {
+        let items: Vec<TopKItem> =
+            json::from_str(result).map_err(|e| DataFusionError::External(Box::new(e)))?;
+        let mut other = SpaceSaving::new(self.summary.capacity);
+        if items.len() >= self.k {
+            other.floor = items.last().map(|i| i.count).unwrap_or_default();
+        }
+        for item in items {
+            other.counters.insert(item.value, item.count);
+        }
+        self.summary.merge(&other);
+        Ok(())
+    }
+}
+
+impl Accumulator for TopKAccumulator {
+    fn update_batch(&mut self, values: &[ArrayRef]) -> Result<()> {
+        if values.len() != 2 {
+            return Err(DataFusionError::Execution(format!(
+                "UDAF params should be: {APPROX_TOPK_UDAF_NAME}(field, k)"
+            )));
+        }
+        let field = as_string_array(&values[0])?;
+        let k = as_int64_array(&values[1])?;
+        if let Some(k) = k.iter().flatten().next() {
+            self.set_k(k)?;
+        }
+        if self.merge {
+            for v in field.iter().flatten() {
+                self.merge_result(v)?;
+            }
+            return Ok(());
+        }
+        // count the batch first so each distinct value is offered only once
+        let mut batch: HashMap<&str, u64> = HashMap::new();
+        for v in field.iter().flatten() {
+            *batch.entry(v).or_default() += 1;
+        }
+        for (v, count) in batch {
+            self.summary.insert(v, count);
+        }
+        Ok(())
+    }
+
+    fn merge_batch(&mut self, states: &[ArrayRef]) -> Result<()> {
+        let states = as_string_array(&states[0])?;
+        for state in states.iter().flatten() {
+            let (k, other): (usize, SpaceSaving) =
+                json::from_str(state).map_err(|e| DataFusionError::External(Box::new(e)))?;
+            if k > 0 {
+                self.set_k(k as i64)?;
+            }
+            self.summary.merge(&other);
+        }
+        Ok(())
+    }
+
+    fn state(&mut self) -> Result<Vec<ScalarValue>> {
+        let state = json::to_string(&(self.k, &self.summary))
+            .map_err(|e| DataFusionError::External(Box::new(e)))?;
+        Ok(vec![ScalarValue::Utf8(Some(state))])
+    }
+
+    fn evaluate(&mut self) -> Result<ScalarValue> {
+        let items = self.summary.top(self.k);
+        let result = json::to_string(&items).map_err(|e| DataFusionError::External(Box::new(e)))?;
+        Ok(ScalarValue::Utf8(Some(result)))
+    }
+
+    fn size(&self) -> usize {
+        std::mem::size_of_val(self)
+            + self
+                .summary
+                .counters
+                .keys()
+                .map(|k| k.capacity() + std::mem::size_of::<u64>())
+                .sum::<usize>()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            array::StringArray,
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[test]
+    fn test_space_saving() {
+        let mut s = SpaceSaving::new(2);
+        for (v, n) in [("a", 10), ("b", 5), ("c", 1), ("d", 1), ("a", 2), ("e", 1)] {
+            s.insert(v, n);
+        }
+        let top = s.top(1);
+        assert_eq!(top[0].value, "a");
+        assert_eq!(top[0].count, 12);
+        // counts never underestimate
+        let b = s.top(2).into_iter().find(|i| i.value == "b").unwrap();
+        assert!(b.count >= 5);
+    }
+
+    #[test]
+    fn test_space_saving_merge() {
+        let mut a = SpaceSaving::new(10);
+        a.insert("x", 3);
+        a.insert("y", 1);
+        let mut b = SpaceSaving::new(10);
+        b.insert("x", 2);
+        b.insert("z", 4);
+        a.merge(&b);
+        assert_eq!(
+            a.top(3),
+            vec![
+                TopKItem {
+                    value: "x".to_string(),
+                    count: 5
+                },
+                TopKItem {
+                    value: "z".to_string(),
+                    count: 4
+                },
+                TopKItem {
+                    value: "y".to_string(),
+                    count: 1
+                },
+            ]
+        );
+    }
+
+    #[tokio::test]
+    async fn test_approx_topk_udaf() {
+        let schema = Arc::new(Schema::new(vec![Field::new("path", DataType::Utf8, true)]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                Some("/a"),
+                Some("/b"),
+                Some("/a"),
+                None,
+                Some("/c"),
+                Some("/a"),
+                Some("/b"),
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udaf(APPROX_TOPK_UDAF.clone());
+        ctx.register_udaf(APPROX_TOPK_MERGE_UDAF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        let df = ctx
+            .sql("SELECT approx_topk(path, 2) AS top FROM t")
+            .await
+            .unwrap();
+        let data = df.collect().await.unwrap();
+        let expected = [
+            "+-----------------------------------------------------+",
+            "| top                                                 |",
+            "+-----------------------------------------------------+",
+            r#"| [{"value":"/a","count":3},{"value":"/b","count":2}] |"#,
+            "+-----------------------------------------------------+",
+        ];
+        assert_batches_eq!(expected, &data);
+
+        // merging partial results keeps the counts
+        let partial = data[0].column(0).clone();
+        let schema = Arc::new(Schema::new(vec![Field::new("top", DataType::Utf8, true)]));
+        let batch = RecordBatch::try_new(schema.clone(), vec![partial.clone()]).unwrap();
+        let batch2 = RecordBatch::try_new(schema.clone(), vec![partial]).unwrap();
+        let provider = MemTable::try_new(schema, vec![vec![batch, batch2]]).unwrap();
+        ctx.register_table("m", Arc::new(provider)).unwrap();
+        let df = ctx
+            .sql("SELECT approx_topk_merge(top, 2) AS top FROM m")
+            .await
+            .unwrap();
+        let data = df.collect().await.unwrap();
+        let expected = [
+            "+-----------------------------------------------------+",
+            "| top                                                 |",
+            "+-----------------------------------------------------+",
+            r#"| [{"value":"/a","count":6},{"value":"/b","count":4}] |"#,
+            "+-----------------------------------------------------+",
+        ];
+        assert_batches_eq!(expected, &data);
+    }
+}
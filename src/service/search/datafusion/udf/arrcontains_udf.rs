@@ -0,0 +1,166 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{iter::zip, sync::Arc};
+
+use arrow::array::BooleanArray;
+use config::utils::json;
+use datafusion::{
+    arrow::{array::ArrayRef, datatypes::DataType},
+    common::cast::as_string_array,
+    error::DataFusionError,
+    logical_expr::{ScalarUDF, Volatility},
+    prelude::create_udf,
+    sql::sqlparser::parser::ParserError,
+};
+use datafusion_expr::ColumnarValue;
+use once_cell::sync::Lazy;
+
+/// The name of the arrcontains UDF given to DataFusion.
+pub const ARR_CONTAINS_UDF_NAME: &str = "arrcontains";
+
+/// Implementation of arrcontains
+pub(crate) static ARR_CONTAINS_UDF: Lazy<ScalarUDF> = Lazy::new(|| {
+    create_udf(
+        ARR_CONTAINS_UDF_NAME,
+        // expects two string - the array field and the value to look for
+        vec![DataType::Utf8, DataType::Utf8],
+        // returns boolean
+        Arc::new(DataType::Boolean),
+        Volatility::Immutable,
+        Arc::new(arr_contains_impl),
+    )
+});
+
+/// arrcontains function for datafusion
+///
+/// Returns true when any element of the json array matches the given value. Elements are
+/// compared using their string form, so `arrcontains(arr, '1')` matches both `1` and `"1"`.
+/// Combined with `spath(field, 'items[*].name')` this allows filtering on values nested in
+/// arrays of objects.
+pub fn arr_contains_impl(args: &[ColumnarValue]) -> datafusion::error::Result<ColumnarValue> {
+    log::debug!("Inside arrcontains");
+    if args.len() != 2 {
+        return Err(DataFusionError::SQL(
+            ParserError::ParserError(
+                "UDF params should be: arrcontains(arr_field, value)".to_string(),
+            ),
+            None,
+        ));
+    }
+    let args = ColumnarValue::values_to_arrays(args)?;
+    log::debug!("Got the args: {:#?}", args);
+
+    // 1. cast both arguments to string. These casts MUST be aligned with the signature or this
+    //    function panics!
+    let arr_field = as_string_array(&args[0]).expect("cast failed");
+    let value = as_string_array(&args[1]).expect("cast failed");
+
+    // 2. perform the computation
+    let array = zip(arr_field.iter(), value.iter())
+        .map(|(arr_field, value)| match (arr_field, value) {
+            (Some(arr_field), Some(value)) => match json::from_str::<json::Value>(arr_field) {
+                Ok(json::Value::Array(arr)) => Some(
+                    arr.iter()
+                        .any(|item| super::stringify_json_value(item) == value),
+                ),
+                _ => Some(false),
+            },
+            // in arrow, any value can be null.
+            // Here we decide to make our UDF to return null when either argument is null.
+            _ => None,
+        })
+        .collect::<BooleanArray>();
+
+    // `Ok` because no error occurred during the calculation
+    // `Arc` because arrays are immutable, thread-safe, trait objects.
+    Ok(ColumnarValue::from(Arc::new(array) as ArrayRef))
+}
+
+#[cfg(test)]
+mod tests {
+    use arrow::array::StringArray;
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+    use crate::service::search::datafusion::udf::spath_udf::SPATH_UDF;
+
+    #[tokio::test]
+    async fn test_arr_contains_udf() {
+        let sqls = [
+            (
+                "select arrcontains(names, 'bob') as ret from t",
+                vec!["+------+", "| ret  |", "+------+", "| true |", "+------+"],
+            ),
+            (
+                "select arrcontains(names, 'eve') as ret from t",
+                vec!["+-------+", "| ret   |", "+-------+", "| false |", "+-------+"],
+            ),
+            (
+                "select arrcontains(nums, '2') as ret from t",
+                vec!["+------+", "| ret  |", "+------+", "| true |", "+------+"],
+            ),
+            (
+                "select arrcontains(spath(object, 'items[*].name'), 'bar') as ret from t",
+                vec!["+------+", "| ret  |", "+------+", "| true |", "+------+"],
+            ),
+            (
+                "select count(*) as ret from t where arrcontains(spath(object, 'items[*].name'), 'baz')",
+                vec!["+-----+", "| ret |", "+-----+", "| 0   |", "+-----+"],
+            ),
+        ];
+
+        // define a schema.
+        let schema = Arc::new(Schema::new(vec![
+            Field::new("names", DataType::Utf8, false),
+            Field::new("nums", DataType::Utf8, false),
+            Field::new("object", DataType::Utf8, false),
+        ]));
+
+        // define data.
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![
+                Arc::new(StringArray::from(vec!["[\"alice\",\"bob\"]"])),
+                Arc::new(StringArray::from(vec!["[1,2,3]"])),
+                Arc::new(StringArray::from(vec![
+                    "{\"items\":[{\"name\":\"foo\"},{\"name\":\"bar\"}]}",
+                ])),
+            ],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(ARR_CONTAINS_UDF.clone());
+        ctx.register_udf(SPATH_UDF.clone());
+
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        for item in sqls {
+            let df = ctx.sql(item.0).await.unwrap();
+            let data = df.collect().await.unwrap();
+            assert_batches_eq!(item.1, &data);
+        }
+    }
+}
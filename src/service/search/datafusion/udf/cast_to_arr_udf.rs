@@ -67,10 +67,10 @@ pub fn cast_to_arr_impl(args: &[ColumnarValue]) -> datafusion::error::Result<Col
     ));
 
     string_array.iter().for_each(|string| {
-        if let Some(string) = string {
-            let arr: json::Value =
-                json::from_str(string).expect("Failed to deserialize the field into an array");
-            if let json::Value::Array(arr) = arr {
+        // every input row must produce exactly one list entry, so anything that isn't a json
+        // array becomes null instead of being dropped
+        match string.and_then(|s| json::from_str::<json::Value>(s).ok()) {
+            Some(json::Value::Array(arr)) => {
                 arr.iter().for_each(|field| {
                     let field = super::stringify_json_value(field);
                     if !field.is_empty() {
@@ -79,6 +79,7 @@ pub fn cast_to_arr_impl(args: &[ColumnarValue]) -> datafusion::error::Result<Col
                 });
                 list_builder.append(true);
             }
+            _ => list_builder.append(false),
         }
     });
 
@@ -178,4 +179,40 @@ mod tests {
             assert_batches_eq!(item.1, &data);
         }
     }
+
+    #[tokio::test]
+    async fn test_cast_to_arr_unnest() {
+        let sqls = [(
+            "select unnest(cast_to_arr(log)) as ret from t",
+            vec![
+                "+-----+", "| ret |", "+-----+", "| a   |", "| b   |", "| c   |", "+-----+",
+            ],
+        )];
+
+        // define a schema.
+        let schema = Arc::new(Schema::new(vec![Field::new("log", DataType::Utf8, false)]));
+
+        // non array values must not break the row alignment, they simply produce no rows
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                r#"["a","b"]"#,
+                "not json",
+                r#"["c"]"#,
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(CAST_TO_ARR_UDF.clone());
+
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        for item in sqls {
+            let df = ctx.sql(item.0).await.unwrap();
+            let data = df.collect().await.unwrap();
+            assert_batches_eq!(item.1, &data);
+        }
+    }
 }
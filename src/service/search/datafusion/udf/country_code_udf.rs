@@ -0,0 +1,489 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, sync::Arc};
+
+use datafusion::{
+    arrow::{
+        array::{ArrayRef, StringArray},
+        datatypes::DataType,
+    },
+    common::cast::as_string_array,
+    error::{DataFusionError, Result},
+    logical_expr::{ScalarUDF, ScalarUDFImpl, Signature, Volatility},
+    physical_plan::ColumnarValue,
+    scalar::ScalarValue,
+};
+use datafusion_expr::TypeSignature::Exact;
+use once_cell::sync::Lazy;
+
+/// Implementation of country_code
+pub(crate) static COUNTRY_CODE_UDF: Lazy<ScalarUDF> =
+    Lazy::new(|| ScalarUDF::from(CountryCode::new()));
+
+/// ISO 3166-1 countries as (alpha-2, alpha-3, name), the names are the ones
+/// used by the world map of the map panels
+const COUNTRIES: &[(&str, &str, &str)] = &[
+    ("AD", "AND", "Andorra"),
+    ("AE", "ARE", "United Arab Emirates"),
+    ("AF", "AFG", "Afghanistan"),
+    ("AG", "ATG", "Antigua and Barbuda"),
+    ("AI", "AIA", "Anguilla"),
+    ("AL", "ALB", "Albania"),
+    ("AM", "ARM", "Armenia"),
+    ("AO", "AGO", "Angola"),
+    ("AQ", "ATA", "Antarctica"),
+    ("AR", "ARG", "Argentina"),
+    ("AS", "ASM", "American Samoa"),
+    ("AT", "AUT", "Austria"),
+    ("AU", "AUS", "Australia"),
+    ("AW", "ABW", "Aruba"),
+    ("AX", "ALA", "Aland Islands"),
+    ("AZ", "AZE", "Azerbaijan"),
+    ("BA", "BIH", "Bosnia and Herzegovina"),
+    ("BB", "BRB", "Barbados"),
+    ("BD", "BGD", "Bangladesh"),
+    ("BE", "BEL", "Belgium"),
+    ("BF", "BFA", "Burkina Faso"),
+    ("BG", "BGR", "Bulgaria"),
+    ("BH", "BHR", "Bahrain"),
+    ("BI", "BDI", "Burundi"),
+    ("BJ", "BEN", "Benin"),
+    ("BL", "BLM", "Saint Barthelemy"),
+    ("BM", "BMU", "Bermuda"),
+    ("BN", "BRN", "Brunei"),
+    ("BO", "BOL", "Bolivia"),
+    ("BQ", "BES", "Caribbean Netherlands"),
+    ("BR", "BRA", "Brazil"),
+    ("BS", "BHS", "Bahamas"),
+    ("BT", "BTN", "Bhutan"),
+    ("BV", "BVT", "Bouvet Island"),
+    ("BW", "BWA", "Botswana"),
+    ("BY", "BLR", "Belarus"),
+    ("BZ", "BLZ", "Belize"),
+    ("CA", "CAN", "Canada"),
+    ("CC", "CCK", "Cocos Islands"),
+    ("CD", "COD", "Democratic Republic of the Congo"),
+    ("CF", "CAF", "Central African Republic"),
+    ("CG", "COG", "Republic of the Congo"),
+    ("CH", "CHE", "Switzerland"),
+    ("CI", "CIV", "Ivory Coast"),
+    ("CK", "COK", "Cook Islands"),
+    ("CL", "CHL", "Chile"),
+    ("CM", "CMR", "Cameroon"),
+    ("CN", "CHN", "China"),
+    ("CO", "COL", "Colombia"),
+    ("CR", "CRI", "Costa Rica"),
+    ("CU", "CUB", "Cuba"),
+    ("CV", "CPV", "Cape Verde"),
+    ("CW", "CUW", "Curacao"),
+    ("CX", "CXR", "Christmas Island"),
+    ("CY", "CYP", "Cyprus"),
+    ("CZ", "CZE", "Czech Republic"),
+    ("DE", "DEU", "Germany"),
+    ("DJ", "DJI", "Djibouti"),
+    ("DK", "DNK", "Denmark"),
+    ("DM", "DMA", "Dominica"),
+    ("DO", "DOM", "Dominican Republic"),
+    ("DZ", "DZA", "Algeria"),
+    ("EC", "ECU", "Ecuador"),
+    ("EE", "EST", "Estonia"),
+    ("EG", "EGY", "Egypt"),
+    ("EH", "ESH", "Western Sahara"),
+    ("ER", "ERI", "Eritrea"),
+    ("ES", "ESP", "Spain"),
+    ("ET", "ETH", "Ethiopia"),
+    ("FI", "FIN", "Finland"),
+    ("FJ", "FJI", "Fiji"),
+    ("FK", "FLK", "Falkland Islands"),
+    ("FM", "FSM", "Micronesia"),
+    ("FO", "FRO", "Faroe Islands"),
+    ("FR", "FRA", "France"),
+    ("GA", "GAB", "Gabon"),
+    ("GB", "GBR", "United Kingdom"),
+    ("GD", "GRD", "Grenada"),
+    ("GE", "GEO", "Georgia"),
+    ("GF", "GUF", "French Guiana"),
+    ("GG", "GGY", "Guernsey"),
+    ("GH", "GHA", "Ghana"),
+    ("GI", "GIB", "Gibraltar"),
+    ("GL", "GRL", "Greenland"),
+    ("GM", "GMB", "Gambia"),
+    ("GN", "GIN", "Guinea"),
+    ("GP", "GLP", "Guadeloupe"),
+    ("GQ", "GNQ", "Equatorial Guinea"),
+    ("GR", "GRC", "Greece"),
+    ("GS", "SGS", "South Georgia and the South Sandwich Islands"),
+    ("GT", "GTM", "Guatemala"),
+    ("GU", "GUM", "Guam"),
+    ("GW", "GNB", "Guinea-Bissau"),
+    ("GY", "GUY", "Guyana"),
+    ("HK", "HKG", "Hong Kong"),
+    ("HM", "HMD", "Heard Island and McDonald Islands"),
+    ("HN", "HND", "Honduras"),
+    ("HR", "HRV", "Croatia"),
+    ("HT", "HTI", "Haiti"),
+    ("HU", "HUN", "Hungary"),
+    ("ID", "IDN", "Indonesia"),
+    ("IE", "IRL", "Ireland"),
+    ("IL", "ISR", "Israel"),
+    ("IM", "IMN", "Isle of Man"),
+    ("IN", "IND", "India"),
+    ("IO", "IOT", "British Indian Ocean Territory"),
+    ("IQ", "IRQ", "Iraq"),
+    ("IR", "IRN", "Iran"),
+    ("IS", "ISL", "Iceland"),
+    ("IT", "ITA", "Italy"),
+    ("JE", "JEY", "Jersey"),
+    ("JM", "JAM", "Jamaica"),
+    ("JO", "JOR", "Jordan"),
+    ("JP", "JPN", "Japan"),
+    ("KE", "KEN", "Kenya"),
+    ("KG", "KGZ", "Kyrgyzstan"),
+    ("KH", "KHM", "Cambodia"),
+    ("KI", "KIR", "Kiribati"),
+    ("KM", "COM", "Comoros"),
+    ("KN", "KNA", "Saint Kitts and Nevis"),
+    ("KP", "PRK", "North Korea"),
+    ("KR", "KOR", "South Korea"),
+    ("KW", "KWT", "Kuwait"),
+    ("KY", "CYM", "Cayman Islands"),
+    ("KZ", "KAZ", "Kazakhstan"),
+    ("LA", "LAO", "Laos"),
+    ("LB", "LBN", "Lebanon"),
+    ("LC", "LCA", "Saint Lucia"),
+    ("LI", "LIE", "Liechtenstein"),
+    ("LK", "LKA", "Sri Lanka"),
+    ("LR", "LBR", "Liberia"),
+    ("LS", "LSO", "Lesotho"),
+    ("LT", "LTU", "Lithuania"),
+    ("LU", "LUX", "Luxembourg"),
+    ("LV", "LVA", "Latvia"),
+    ("LY", "LBY", "Libya"),
+    ("MA", "MAR", "Morocco"),
+    ("MC", "MCO", "Monaco"),
+    ("MD", "MDA", "Moldova"),
+    ("ME", "MNE", "Montenegro"),
+    ("MF", "MAF", "Saint Martin"),
+    ("MG", "MDG", "Madagascar"),
+    ("MH", "MHL", "Marshall Islands"),
+    ("MK", "MKD", "North Macedonia"),
+    ("ML", "MLI", "Mali"),
+    ("MM", "MMR", "Myanmar"),
+    ("MN", "MNG", "Mongolia"),
+    ("MO", "MAC", "Macau"),
+    ("MP", "MNP", "Northern Mariana Islands"),
+    ("MQ", "MTQ", "Martinique"),
+    ("MR", "MRT", "Mauritania"),
+    ("MS", "MSR", "Montserrat"),
+    ("MT", "MLT", "Malta"),
+    ("MU", "MUS", "Mauritius"),
+    ("MV", "MDV", "Maldives"),
+    ("MW", "MWI", "Malawi"),
+    ("MX", "MEX", "Mexico"),
+    ("MY", "MYS", "Malaysia"),
+    ("MZ", "MOZ", "Mozambique"),
+    ("NA", "NAM", "Namibia"),
+    ("NC", "NCL", "New Caledonia"),
+    ("NE", "NER", "Niger"),
+    ("NF", "NFK", "Norfolk Island"),
+    ("NG", "NGA", "Nigeria"),
+    ("NI", "NIC", "Nicaragua"),
+    ("NL", "NLD", "Netherlands"),
+    ("NO", "NOR", "Norway"),
+    ("NP", "NPL", "Nepal"),
+    ("NR", "NRU", "Nauru"),
+    ("NU", "NIU", "Niue"),
+    ("NZ", "NZL", "New Zealand"),
+    ("OM", "OMN", "Oman"),
+    ("PA", "PAN", "Panama"),
+    ("PE", "PER", "Peru"),
+    ("PF", "PYF", "French Polynesia"),
+    ("PG", "PNG", "Papua New Guinea"),
+    ("PH", "PHL", "Philippines"),
+    ("PK", "PAK", "Pakistan"),
+    ("PL", "POL", "Poland"),
+    ("PM", "SPM", "Saint Pierre and Miquelon"),
+    ("PN", "PCN", "Pitcairn Islands"),
+    ("PR", "PRI", "Puerto Rico"),
+    ("PS", "PSE", "Palestine"),
+    ("PT", "PRT", "Portugal"),
+    ("PW", "PLW", "Palau"),
+    ("PY", "PRY", "Paraguay"),
+    ("QA", "QAT", "Qatar"),
+    ("RE", "REU", "Reunion"),
+    ("RO", "ROU", "Romania"),
+    ("RS", "SRB", "Serbia"),
+    ("RU", "RUS", "Russia"),
+    ("RW", "RWA", "Rwanda"),
+    ("SA", "SAU", "Saudi Arabia"),
+    ("SB", "SLB", "Solomon Islands"),
+    ("SC", "SYC", "Seychelles"),
+    ("SD", "SDN", "Sudan"),
+    ("SE", "SWE", "Sweden"),
+    ("SG", "SGP", "Singapore"),
+    ("SH", "SHN", "Saint Helena"),
+    ("SI", "SVN", "Slovenia"),
+    ("SJ", "SJM", "Svalbard and Jan Mayen"),
+    ("SK", "SVK", "Slovakia"),
+    ("SL", "SLE", "Sierra Leone"),
+    ("SM", "SMR", "San Marino"),
+    ("SN", "SEN", "Senegal"),
+    ("SO", "SOM", "Somalia"),
+    ("SR", "SUR", "Suriname"),
+    ("SS", "SSD", "South Sudan"),
+    ("ST", "STP", "Sao Tome and Principe"),
+    ("SV", "SLV", "El Salvador"),
+    ("SX", "SXM", "Sint Maarten"),
+    ("SY", "SYR", "Syria"),
+    ("SZ", "SWZ", "Eswatini"),
+    ("TC", "TCA", "Turks and Caicos Islands"),
+    ("TD", "TCD", "Chad"),
+    ("TF", "ATF", "French Southern Territories"),
+    ("TG", "TGO", "Togo"),
+    ("TH", "THA", "Thailand"),
+    ("TJ", "TJK", "Tajikistan"),
+    ("TK", "TKL", "Tokelau"),
+    ("TL", "TLS", "East Timor"),
+    ("TM", "TKM", "Turkmenistan"),
+    ("TN", "TUN", "Tunisia"),
+    ("TO", "TON", "Tonga"),
+    ("TR", "TUR", "Turkey"),
+    ("TT", "TTO", "Trinidad and Tobago"),
+    ("TV", "TUV", "Tuvalu"),
+    ("TW", "TWN", "Taiwan"),
+    ("TZ", "TZA", "Tanzania"),
+    ("UA", "UKR", "Ukraine"),
+    ("UG", "UGA", "Uganda"),
+    ("UM", "UMI", "United States Minor Outlying Islands"),
+    ("US", "USA", "United States"),
+    ("UY", "URY", "Uruguay"),
+    ("UZ", "UZB", "Uzbekistan"),
+    ("VA", "VAT", "Vatican City"),
+    ("VC", "VCT", "Saint Vincent and the Grenadines"),
+    ("VE", "VEN", "Venezuela"),
+    ("VG", "VGB", "British Virgin Islands"),
+    ("VI", "VIR", "United States Virgin Islands"),
+    ("VN", "VNM", "Vietnam"),
+    ("VU", "VUT", "Vanuatu"),
+    ("WF", "WLF", "Wallis and Futuna"),
+    ("WS", "WSM", "Samoa"),
+    ("YE", "YEM", "Yemen"),
+    ("YT", "MYT", "Mayotte"),
+    ("ZA", "ZAF", "South Africa"),
+    ("ZM", "ZMB", "Zambia"),
+    ("ZW", "ZWE", "Zimbabwe"),
+];
+
+/// Other common spellings of the country names
+const ALIASES: &[(&str, &str)] = &[
+    ("USA", "US"),
+    ("United States of America", "US"),
+    ("UK", "GB"),
+    ("Great Britain", "GB"),
+    ("Russian Federation", "RU"),
+    ("Korea", "KR"),
+    ("Republic of Korea", "KR"),
+    ("Viet Nam", "VN"),
+    ("Czechia", "CZ"),
+    ("Turkiye", "TR"),
+    ("Cote d'Ivoire", "CI"),
+    ("Macedonia", "MK"),
+    ("Swaziland", "SZ"),
+    ("Burma", "MM"),
+    ("Timor-Leste", "TL"),
+    ("Holland", "NL"),
+    ("Congo", "CG"),
+    ("DR Congo", "CD"),
+    ("Vatican", "VA"),
+];
+
+/// Lowercased alpha-2, alpha-3, names and aliases to the position in COUNTRIES
+static COUNTRY_INDEX: Lazy<HashMap<String, usize>> = Lazy::new(|| {
+    let mut index = HashMap::with_capacity(COUNTRIES.len() * 3 + ALIASES.len());
+    for (i, (alpha2, alpha3, name)) in COUNTRIES.iter().enumerate() {
+        index.insert(alpha2.to_lowercase(), i);
+        index.insert(alpha3.to_lowercase(), i);
+        index.insert(name.to_lowercase(), i);
+    }
+    for (alias, alpha2) in ALIASES {
+        if let Some(i) = COUNTRIES.iter().position(|(code, ..)| code == alpha2) {
+            index.insert(alias.to_lowercase(), i);
+        }
+    }
+    index
+});
+
+/// `country_code(field [, format])` normalizes a country given as an ISO
+/// 3166-1 alpha-2 or alpha-3 code or as an English name to its alpha-2 code,
+/// or to `alpha3` / `name` when the format is given. Unknown countries are
+/// NULL. Grouping by the normalized country rolls up the rows of a
+/// choropleth map.
+///
+/// ```sql
+/// SELECT country_code(country, 'name') AS name, count(*) AS value FROM t GROUP BY name
+/// ```
+#[derive(Debug, Clone)]
+struct CountryCode {
+    signature: Signature,
+}
+
+impl CountryCode {
+    fn new() -> Self {
+        Self {
+            signature: Signature::one_of(
+                vec![
+                    Exact(vec![DataType::Utf8]),
+                    Exact(vec![DataType::Utf8, DataType::Utf8]),
+                ],
+                Volatility::Immutable,
+            ),
+        }
+    }
+}
+
+impl ScalarUDFImpl for CountryCode {
+    fn as_any(&self) -> &dyn std::any::Any {
+        self
+    }
+
+    fn name(&self) -> &str {
+        super::COUNTRY_CODE_UDF_NAME
+    }
+
+    fn signature(&self) -> &Signature {
+        &self.signature
+    }
+
+    fn return_type(&self, _arg_types: &[DataType]) -> Result<DataType> {
+        Ok(DataType::Utf8)
+    }
+
+    fn invoke(&self, args: &[ColumnarValue]) -> Result<ColumnarValue> {
+        if args.is_empty() || args.len() > 2 {
+            return Err(DataFusionError::Execution(
+                "UDF params should be: country_code(field [, format])".to_string(),
+            ));
+        }
+        let format = match args.get(1) {
+            None => 0,
+            Some(ColumnarValue::Scalar(ScalarValue::Utf8(Some(v)))) => {
+                match v.to_lowercase().as_str() {
+                    "alpha2" => 0,
+                    "alpha3" => 1,
+                    "name" => 2,
+                    _ => {
+                        return Err(DataFusionError::Execution(format!(
+                            "Invalid format '{v}' for country_code, expected one of alpha2, \
+                             alpha3 or name"
+                        )));
+                    }
+                }
+            }
+            Some(_) => {
+                return Err(DataFusionError::Execution(
+                    "The format argument for country_code needs to be a string".to_string(),
+                ));
+            }
+        };
+
+        let is_scalar = matches!(args[0], ColumnarValue::Scalar(_));
+        let input = args[0].clone().into_array(1)?;
+        let result = as_string_array(&input)?
+            .iter()
+            .map(|v| normalize(v?, format))
+            .collect::<StringArray>();
+        if is_scalar {
+            Ok(ColumnarValue::Scalar(ScalarValue::Utf8(
+                result.iter().next().flatten().map(|v| v.to_string()),
+            )))
+        } else {
+            Ok(ColumnarValue::Array(Arc::new(result) as ArrayRef))
+        }
+    }
+}
+
+/// Returns the alpha-2 code (0), the alpha-3 code (1) or the name (2) of the
+/// country
+fn normalize(value: &str, format: usize) -> Option<&'static str> {
+    let (alpha2, alpha3, name) = COUNTRIES[*COUNTRY_INDEX.get(&value.trim().to_lowercase())?];
+    Some(match format {
+        0 => alpha2,
+        1 => alpha3,
+        _ => name,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[test]
+    fn test_normalize() {
+        assert_eq!(normalize("us", 0), Some("US"));
+        assert_eq!(normalize("USA", 0), Some("US"));
+        assert_eq!(normalize(" united states of america ", 1), Some("USA"));
+        assert_eq!(normalize("DEU", 2), Some("Germany"));
+        assert_eq!(normalize("Viet Nam", 2), Some("Vietnam"));
+        assert_eq!(normalize("Atlantis", 0), None);
+    }
+
+    #[tokio::test]
+    async fn test_country_code_udf() {
+        let sql = "select country_code(country, 'name') as name, count(*) as cnt from t \
+                   group by name order by name";
+        let expected = vec![
+            "+---------+-----+",
+            "| name    | cnt |",
+            "+---------+-----+",
+            "| Germany | 2   |",
+            "| India   | 1   |",
+            "|         | 1   |",
+            "+---------+-----+",
+        ];
+
+        let schema = Arc::new(Schema::new(vec![Field::new(
+            "country",
+            DataType::Utf8,
+            false,
+        )]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                "DE", "germany", "IND", "n/a",
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(COUNTRY_CODE_UDF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        let df = ctx.sql(sql).await.unwrap();
+        let data = df.collect().await.unwrap();
+        assert_batches_eq!(expected, &data);
+    }
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{collections::HashMap, sync::Arc};
+
+use arrow::array::StringArray;
+use config::meta::stream::StreamType;
+use datafusion::{
+    arrow::{array::ArrayRef, datatypes::DataType},
+    common::cast::as_string_array,
+    error::DataFusionError,
+    logical_expr::{ScalarUDF, Volatility},
+    prelude::create_udf,
+    sql::sqlparser::parser::ParserError,
+};
+use datafusion_expr::ColumnarValue;
+
+use crate::common::infra::config::ENRICHMENT_TABLES;
+
+/// The name of the enrich UDF given to DataFusion.
+pub const ENRICH_UDF_NAME: &str = "enrich";
+
+/// Returns the enrich UDF of the organization. `enrich(table, key, value,
+/// field)` looks up the row of the enrichment table whose `key` column equals
+/// `value` and returns its `field` column, e.g. the team owning a service:
+/// `enrich('services', 'name', service, 'team')`
+pub(crate) fn get_enrich_udf(org_id: &str) -> ScalarUDF {
+    let org_id = org_id.to_string();
+    create_udf(
+        ENRICH_UDF_NAME,
+        // expects four strings - the table, the key column, the key value and the column
+        vec![
+            DataType::Utf8,
+            DataType::Utf8,
+            DataType::Utf8,
+            DataType::Utf8,
+        ],
+        // returns string
+        Arc::new(DataType::Utf8),
+        Volatility::Stable,
+        Arc::new(move |args: &[ColumnarValue]| enrich_impl(&org_id, args)),
+    )
+}
+
+/// enrich function for datafusion
+fn enrich_impl(org_id: &str, args: &[ColumnarValue]) -> datafusion::error::Result<ColumnarValue> {
+    if args.len() != 4 {
+        return Err(DataFusionError::SQL(
+            ParserError::ParserError(
+                "UDF params should be: enrich(table, key, value, field)".to_string(),
+            ),
+            None,
+        ));
+    }
+    let args = ColumnarValue::values_to_arrays(args)?;
+    let table = as_string_array(&args[0]).expect("cast failed");
+    let key = as_string_array(&args[1]).expect("cast failed");
+    let value = as_string_array(&args[2]).expect("cast failed");
+    let field = as_string_array(&args[3]).expect("cast failed");
+
+    // the table, key and field are usually literals, so each lookup index is
+    // built once per batch
+    let mut indexes: HashMap<(&str, &str, &str), HashMap<String, String>> = HashMap::new();
+    let array = (0..value.len())
+        .map(|i| {
+            if table.is_null(i) || key.is_null(i) || value.is_null(i) || field.is_null(i) {
+                return None;
+            }
+            let index = indexes
+                .entry((table.value(i), key.value(i), field.value(i)))
+                .or_insert_with(|| {
+                    build_index(org_id, table.value(i), key.value(i), field.value(i))
+                });
+            index.get(value.value(i)).cloned()
+        })
+        .collect::<StringArray>();
+
+    Ok(ColumnarValue::from(Arc::new(array) as ArrayRef))
+}
+
+/// Maps the `key` column of the rows of the enrichment table to their `field`
+/// column, the first row wins when a key is repeated
+fn build_index(org_id: &str, table: &str, key: &str, field: &str) -> HashMap<String, String> {
+    let mut index = HashMap::new();
+    let table_key = format!("{org_id}/{}/{table}", StreamType::EnrichmentTables);
+    let Some(table) = ENRICHMENT_TABLES.get(&table_key) else {
+        return index;
+    };
+    for row in table.data.iter() {
+        let vrl::value::Value::Object(row) = row else {
+            continue;
+        };
+        let (Some(k), Some(v)) = (row.get(key), row.get(field)) else {
+            continue;
+        };
+        index
+            .entry(value_to_string(k))
+            .or_insert_with(|| value_to_string(v));
+    }
+    index
+}
+
+fn value_to_string(value: &vrl::value::Value) -> String {
+    match value {
+        vrl::value::Value::Bytes(bytes) => String::from_utf8_lossy(bytes).to_string(),
+        value => value.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+    use crate::service::enrichment::StreamTable;
+
+    #[tokio::test]
+    async fn test_enrich_udf() {
+        let data = [("api", "payments"), ("web", "frontend")]
+            .into_iter()
+            .map(|(name, team)| {
+                vrl::value::Value::Object(
+                    [
+                        ("name".into(), vrl::value::Value::from(name)),
+                        ("team".into(), vrl::value::Value::from(team)),
+                    ]
+                    .into_iter()
+                    .collect(),
+                )
+            })
+            .collect();
+        ENRICHMENT_TABLES.insert(
+            "enrich_test/enrichment_tables/services".to_string(),
+            StreamTable {
+                org_id: "enrich_test".to_string(),
+                stream_name: "services".to_string(),
+                data,
+            },
+        );
+
+        let schema = Arc::new(Schema::new(vec![Field::new(
+            "service",
+            DataType::Utf8,
+            true,
+        )]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                Some("api"),
+                Some("db"),
+                None,
+            ]))],
+        )
+        .unwrap();
+        let ctx = SessionContext::new();
+        ctx.register_udf(get_enrich_udf("enrich_test"));
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+        let batches = ctx
+            .sql("select service, enrich('services', 'name', service, 'team') as team from t")
+            .await
+            .unwrap()
+            .collect()
+            .await
+            .unwrap();
+        assert_batches_eq!(
+            vec![
+                "+---------+----------+",
+                "| service | team     |",
+                "+---------+----------+",
+                "| api     | payments |",
+                "| db      |          |",
+                "|         |          |",
+                "+---------+----------+",
+            ],
+            &batches
+        );
+    }
+}
@@ -0,0 +1,344 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use datafusion::{
+    arrow::{
+        array::{ArrayRef, Float64Array, StringArray},
+        datatypes::DataType,
+    },
+    common::cast::{as_float64_array, as_string_array},
+    error::{DataFusionError, Result},
+    logical_expr::{ScalarUDF, ScalarUDFImpl, Signature, Volatility},
+    physical_plan::ColumnarValue,
+    scalar::ScalarValue,
+};
+use datafusion_expr::TypeSignature::Exact;
+use once_cell::sync::Lazy;
+
+/// Alphabet of the geohash cells
+const BASE32: &[u8; 32] = b"0123456789bcdefghjkmnpqrstuvwxyz";
+const DEFAULT_PRECISION: i64 = 5;
+const MAX_PRECISION: i64 = 12;
+
+/// Implementation of geohash
+pub(crate) static GEOHASH_UDF: Lazy<ScalarUDF> = Lazy::new(|| ScalarUDF::from(Geohash::new()));
+
+/// Implementation of geohash_lat
+pub(crate) static GEOHASH_LAT_UDF: Lazy<ScalarUDF> =
+    Lazy::new(|| ScalarUDF::from(GeohashCenter::new(super::GEOHASH_LAT_UDF_NAME)));
+
+/// Implementation of geohash_lon
+pub(crate) static GEOHASH_LON_UDF: Lazy<ScalarUDF> =
+    Lazy::new(|| ScalarUDF::from(GeohashCenter::new(super::GEOHASH_LON_UDF_NAME)));
+
+/// `geohash(lat, lon [, precision])` returns the geohash cell of the point,
+/// 5 characters (about 5km) unless the precision is given. Points out of range
+/// are NULL. Grouping by the cell buckets the points of a map into a grid,
+/// `geohash_lat(cell)` and `geohash_lon(cell)` place the buckets at the center
+/// of their cell.
+///
+/// ```sql
+/// SELECT geohash(lat, lon, 4) AS cell, geohash_lat(geohash(lat, lon, 4)) AS lat,
+///   geohash_lon(geohash(lat, lon, 4)) AS lon, count(*) AS weight
+///   FROM t GROUP BY cell, lat, lon
+/// ```
+#[derive(Debug, Clone)]
+struct Geohash {
+    signature: Signature,
+}
+
+impl Geohash {
+    fn new() -> Self {
+        Self {
+            signature: Signature::one_of(
+                vec![
+                    Exact(vec![DataType::Float64, DataType::Float64]),
+                    Exact(vec![DataType::Float64, DataType::Float64, DataType::Int64]),
+                ],
+                Volatility::Immutable,
+            ),
+        }
+    }
+}
+
+impl ScalarUDFImpl for Geohash {
+    fn as_any(&self) -> &dyn std::any::Any {
+        self
+    }
+
+    fn name(&self) -> &str {
+        super::GEOHASH_UDF_NAME
+    }
+
+    fn signature(&self) -> &Signature {
+        &self.signature
+    }
+
+    fn return_type(&self, _arg_types: &[DataType]) -> Result<DataType> {
+        Ok(DataType::Utf8)
+    }
+
+    fn invoke(&self, args: &[ColumnarValue]) -> Result<ColumnarValue> {
+        if args.len() != 2 && args.len() != 3 {
+            return Err(DataFusionError::Execution(
+                "UDF params should be: geohash(lat, lon [, precision])".to_string(),
+            ));
+        }
+        let precision = match args.get(2) {
+            None => DEFAULT_PRECISION,
+            Some(ColumnarValue::Scalar(ScalarValue::Int64(Some(v))))
+                if (1..=MAX_PRECISION).contains(v) =>
+            {
+                *v
+            }
+            Some(_) => {
+                return Err(DataFusionError::Execution(format!(
+                    "The precision argument for geohash needs to be a number between 1 and \
+                     {MAX_PRECISION}"
+                )));
+            }
+        } as usize;
+
+        let is_scalar = args[..2]
+            .iter()
+            .all(|arg| matches!(arg, ColumnarValue::Scalar(_)));
+        let arrays = ColumnarValue::values_to_arrays(&args[..2])?;
+        let lat = as_float64_array(&arrays[0])?;
+        let lon = as_float64_array(&arrays[1])?;
+        let result = lat
+            .iter()
+            .zip(lon.iter())
+            .map(|(lat, lon)| encode(lat?, lon?, precision))
+            .collect::<StringArray>();
+        if is_scalar {
+            Ok(ColumnarValue::Scalar(ScalarValue::Utf8(
+                result.iter().next().flatten().map(|v| v.to_string()),
+            )))
+        } else {
+            Ok(ColumnarValue::Array(Arc::new(result) as ArrayRef))
+        }
+    }
+}
+
+/// `geohash_lat(cell)` and `geohash_lon(cell)` return the latitude and the
+/// longitude of the center of a geohash cell, NULL for an invalid cell
+#[derive(Debug, Clone)]
+struct GeohashCenter {
+    name: &'static str,
+    signature: Signature,
+}
+
+impl GeohashCenter {
+    fn new(name: &'static str) -> Self {
+        Self {
+            name,
+            signature: Signature::exact(vec![DataType::Utf8], Volatility::Immutable),
+        }
+    }
+}
+
+impl ScalarUDFImpl for GeohashCenter {
+    fn as_any(&self) -> &dyn std::any::Any {
+        self
+    }
+
+    fn name(&self) -> &str {
+        self.name
+    }
+
+    fn signature(&self) -> &Signature {
+        &self.signature
+    }
+
+    fn return_type(&self, _arg_types: &[DataType]) -> Result<DataType> {
+        Ok(DataType::Float64)
+    }
+
+    fn invoke(&self, args: &[ColumnarValue]) -> Result<ColumnarValue> {
+        if args.len() != 1 {
+            return Err(DataFusionError::Execution(format!(
+                "UDF params should be: {}(cell)",
+                self.name
+            )));
+        }
+        let is_lat = self.name == super::GEOHASH_LAT_UDF_NAME;
+        let is_scalar = matches!(args[0], ColumnarValue::Scalar(_));
+        let input = args[0].clone().into_array(1)?;
+        let result = as_string_array(&input)?
+            .iter()
+            .map(|cell| {
+                let (lat, lon) = decode_center(cell?)?;
+                Some(if is_lat { lat } else { lon })
+            })
+            .collect::<Float64Array>();
+        if is_scalar {
+            Ok(ColumnarValue::Scalar(ScalarValue::Float64(
+                result.iter().next().flatten(),
+            )))
+        } else {
+            Ok(ColumnarValue::Array(Arc::new(result) as ArrayRef))
+        }
+    }
+}
+
+/// Encodes the point into a geohash of `precision` characters, alternating the
+/// bits of the longitude and the latitude
+pub(crate) fn encode(lat: f64, lon: f64, precision: usize) -> Option<String> {
+    if !(-90.0..=90.0).contains(&lat) || !(-180.0..=180.0).contains(&lon) {
+        return None;
+    }
+    let mut lat_range = (-90.0, 90.0);
+    let mut lon_range = (-180.0, 180.0);
+    let mut hash = String::with_capacity(precision);
+    let (mut bits, mut bit_count, mut is_lon) = (0usize, 0, true);
+    while hash.len() < precision {
+        let (value, range) = if is_lon {
+            (lon, &mut lon_range)
+        } else {
+            (lat, &mut lat_range)
+        };
+        let mid = (range.0 + range.1) / 2.0;
+        bits <<= 1;
+        if value >= mid {
+            bits |= 1;
+            range.0 = mid;
+        } else {
+            range.1 = mid;
+        }
+        is_lon = !is_lon;
+        bit_count += 1;
+        if bit_count == 5 {
+            hash.push(BASE32[bits] as char);
+            bits = 0;
+            bit_count = 0;
+        }
+    }
+    Some(hash)
+}
+
+/// Returns the latitude and the longitude of the center of the cell
+pub(crate) fn decode_center(hash: &str) -> Option<(f64, f64)> {
+    if hash.is_empty() || hash.len() as i64 > MAX_PRECISION {
+        return None;
+    }
+    let mut lat_range = (-90.0, 90.0);
+    let mut lon_range = (-180.0, 180.0);
+    let mut is_lon = true;
+    for c in hash.bytes() {
+        let index = BASE32.iter().position(|b| *b == c.to_ascii_lowercase())?;
+        for shift in (0..5).rev() {
+            let range = if is_lon {
+                &mut lon_range
+            } else {
+                &mut lat_range
+            };
+            let mid = (range.0 + range.1) / 2.0;
+            if (index >> shift) & 1 == 1 {
+                range.0 = mid;
+            } else {
+                range.1 = mid;
+            }
+            is_lon = !is_lon;
+        }
+    }
+    Some((
+        (lat_range.0 + lat_range.1) / 2.0,
+        (lon_range.0 + lon_range.1) / 2.0,
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[test]
+    fn test_encode_decode() {
+        assert_eq!(
+            encode(57.64911, 10.40744, 11).as_deref(),
+            Some("u4pruydqqvj")
+        );
+        assert_eq!(encode(-25.382708, -49.265506, 4).as_deref(), Some("6gkz"));
+        assert_eq!(encode(91.0, 0.0, 5), None);
+        assert_eq!(encode(f64::NAN, 0.0, 5), None);
+
+        let (lat, lon) = decode_center("u4pruydqqvj").unwrap();
+        assert!((lat - 57.64911).abs() < 1e-5 && (lon - 10.40744).abs() < 1e-5);
+        let (lat, lon) = decode_center("s").unwrap();
+        assert_eq!((lat, lon), (22.5, 22.5));
+        assert_eq!(decode_center("a"), None);
+        assert_eq!(decode_center(""), None);
+    }
+
+    #[tokio::test]
+    async fn test_geohash_udf() {
+        let sql =
+            "select geohash(lat, lon, 3) as cell, geohash_lat(geohash(lat, lon, 1)) as clat, \
+                   count(*) as cnt from t group by cell, clat order by cell";
+        let expected = vec![
+            "+------+-------+-----+",
+            "| cell | clat  | cnt |",
+            "+------+-------+-----+",
+            "| 6gk  | -22.5 | 1   |",
+            "| u4p  | 67.5  | 2   |",
+            "|      |       | 1   |",
+            "+------+-------+-----+",
+        ];
+
+        let schema = Arc::new(Schema::new(vec![
+            Field::new("lat", DataType::Float64, true),
+            Field::new("lon", DataType::Float64, true),
+        ]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![
+                Arc::new(Float64Array::from(vec![
+                    Some(57.64911),
+                    Some(57.6),
+                    Some(-25.38),
+                    None,
+                ])),
+                Arc::new(Float64Array::from(vec![
+                    Some(10.40744),
+                    Some(10.4),
+                    Some(-49.26),
+                    Some(1.0),
+                ])),
+            ],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(GEOHASH_UDF.clone());
+        ctx.register_udf(GEOHASH_LAT_UDF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        let df = ctx.sql(sql).await.unwrap();
+        let data = df.collect().await.unwrap();
+        assert_batches_eq!(expected, &data);
+    }
+}
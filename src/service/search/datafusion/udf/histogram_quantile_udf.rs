@@ -0,0 +1,213 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use datafusion::{
+    arrow::{
+        array::{Array, ArrayRef},
+        datatypes::{DataType, Field},
+    },
+    common::{
+        cast::{as_float64_array, as_list_array, as_string_array},
+        ScalarValue,
+    },
+    error::{DataFusionError, Result},
+    logical_expr::{create_udaf, Accumulator, AggregateUDF, Volatility},
+};
+use hashbrown::HashMap;
+use once_cell::sync::Lazy;
+
+use crate::service::promql::functions::{bucket_quantile, Bucket};
+
+/// The name of the histogram_quantile UDAF given to DataFusion.
+pub const HISTOGRAM_QUANTILE_UDAF_NAME: &str = "histogram_quantile";
+
+/// Implementation of histogram_quantile(phi, le, value).
+///
+/// Aggregates cumulative `_bucket` rows, summing the counts of rows with the
+/// same `le` so that series and (delta) time windows are merged, and
+/// estimates the `phi` quantile the same way the PromQL function does.
+pub(crate) static HISTOGRAM_QUANTILE_UDAF: Lazy<AggregateUDF> = Lazy::new(|| {
+    create_udaf(
+        HISTOGRAM_QUANTILE_UDAF_NAME,
+        // expects the quantile, the bucket upper bound and the bucket count
+        vec![DataType::Float64, DataType::Utf8, DataType::Float64],
+        // returns the estimated value
+        Arc::new(DataType::Float64),
+        Volatility::Immutable,
+        Arc::new(|_| Ok(Box::<HistogramQuantileAccumulator>::default())),
+        Arc::new(vec![
+            DataType::Float64,
+            DataType::List(Arc::new(Field::new("item", DataType::Float64, true))),
+            DataType::List(Arc::new(Field::new("item", DataType::Float64, true))),
+        ]),
+    )
+});
+
+#[derive(Debug, Default)]
+struct HistogramQuantileAccumulator {
+    phi: Option<f64>,
+    // upper bound bits -> summed count
+    buckets: HashMap<u64, f64>,
+}
+
+impl HistogramQuantileAccumulator {
+    fn add(&mut self, upper_bound: f64, count: f64) {
+        if upper_bound.is_nan() || count.is_nan() {
+            return;
+        }
+        *self.buckets.entry(upper_bound.to_bits()).or_default() += count;
+    }
+}
+
+impl Accumulator for HistogramQuantileAccumulator {
+    fn update_batch(&mut self, values: &[ArrayRef]) -> Result<()> {
+        if values.len() != 3 {
+            return Err(DataFusionError::Execution(
+                "UDAF params should be: histogram_quantile(phi, le, value)".to_string(),
+            ));
+        }
+        let phi = as_float64_array(&values[0])?;
+        let le = as_string_array(&values[1])?;
+        let value = as_float64_array(&values[2])?;
+        for i in 0..le.len() {
+            if self.phi.is_none() && phi.is_valid(i) {
+                self.phi = Some(phi.value(i));
+            }
+            if le.is_null(i) || value.is_null(i) {
+                continue;
+            }
+            // rows without a parsable `le` are not buckets, skip them
+            if let Ok(upper_bound) = le.value(i).parse::<f64>() {
+                self.add(upper_bound, value.value(i));
+            }
+        }
+        Ok(())
+    }
+
+    fn merge_batch(&mut self, states: &[ArrayRef]) -> Result<()> {
+        let phi = as_float64_array(&states[0])?;
+        let upper_bounds = as_list_array(&states[1])?;
+        let counts = as_list_array(&states[2])?;
+        for i in 0..upper_bounds.len() {
+            if self.phi.is_none() && phi.is_valid(i) {
+                self.phi = Some(phi.value(i));
+            }
+            if upper_bounds.is_null(i) || counts.is_null(i) {
+                continue;
+            }
+            let upper_bounds = upper_bounds.value(i);
+            let upper_bounds = as_float64_array(&upper_bounds)?;
+            let counts = counts.value(i);
+            let counts = as_float64_array(&counts)?;
+            for (upper_bound, count) in upper_bounds.iter().zip(counts.iter()) {
+                if let (Some(upper_bound), Some(count)) = (upper_bound, count) {
+                    self.add(upper_bound, count);
+                }
+            }
+        }
+        Ok(())
+    }
+
+    fn state(&mut self) -> Result<Vec<ScalarValue>> {
+        let (upper_bounds, counts): (Vec<_>, Vec<_>) = self
+            .buckets
+            .iter()
+            .map(|(k, v)| {
+                (
+                    ScalarValue::Float64(Some(f64::from_bits(*k))),
+                    ScalarValue::Float64(Some(*v)),
+                )
+            })
+            .unzip();
+        Ok(vec![
+            ScalarValue::Float64(self.phi),
+            ScalarValue::List(ScalarValue::new_list(&upper_bounds, &DataType::Float64)),
+            ScalarValue::List(ScalarValue::new_list(&counts, &DataType::Float64)),
+        ])
+    }
+
+    fn evaluate(&mut self) -> Result<ScalarValue> {
+        let Some(phi) = self.phi else {
+            return Ok(ScalarValue::Float64(None));
+        };
+        let buckets = self
+            .buckets
+            .iter()
+            .map(|(k, v)| Bucket::new(f64::from_bits(*k), *v))
+            .collect::<Vec<_>>();
+        let value = bucket_quantile(phi, buckets);
+        Ok(ScalarValue::Float64((!value.is_nan()).then_some(value)))
+    }
+
+    fn size(&self) -> usize {
+        std::mem::size_of_val(self) + self.buckets.capacity() * std::mem::size_of::<(u64, f64)>()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            array::{Float64Array, StringArray},
+            datatypes::Schema,
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[tokio::test]
+    async fn test_histogram_quantile_udaf() {
+        let schema = Arc::new(Schema::new(vec![
+            Field::new("host", DataType::Utf8, false),
+            Field::new("le", DataType::Utf8, false),
+            Field::new("value", DataType::Float64, false),
+        ]));
+        // two series with the same buckets, 8 observations in total
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![
+                Arc::new(StringArray::from(vec!["a", "a", "a", "b", "b", "b"])),
+                Arc::new(StringArray::from(vec!["2", "4", "inf", "2", "4", "+Inf"])),
+                Arc::new(Float64Array::from(vec![2.0, 4.0, 4.0, 2.0, 4.0, 4.0])),
+            ],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udaf(HISTOGRAM_QUANTILE_UDAF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        let df = ctx
+            .sql("SELECT histogram_quantile(0.75, le, value) AS p75 FROM t")
+            .await
+            .unwrap();
+        let data = df.collect().await.unwrap();
+        let expected = [
+            "+-----+", //
+            "| p75 |", //
+            "+-----+", //
+            "| 3.0 |", //
+            "+-----+", //
+        ];
+        assert_batches_eq!(expected, &data);
+    }
+}
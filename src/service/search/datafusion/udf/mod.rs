@@ -17,16 +17,25 @@ use config::utils::json;
 
 use crate::common::meta::functions::ZoFunction;
 
+pub(crate) mod approx_topk_udf;
 pub(crate) mod arr_descending_udf;
+pub(crate) mod arrcontains_udf;
 pub(crate) mod arrcount_udf;
 pub(crate) mod arrindex_udf;
 pub(crate) mod arrjoin_udf;
 pub(crate) mod arrsort_udf;
 pub(crate) mod arrzip_udf;
 pub(crate) mod cast_to_arr_udf;
+pub(crate) mod country_code_udf;
 pub(crate) mod date_format_udf;
+pub(crate) mod enrich_udf;
+pub(crate) mod geohash_udf;
+pub(crate) mod histogram_quantile_udf;
 pub(crate) mod match_udf;
+pub(crate) mod parse_kv_udf;
+pub(crate) mod parse_regex_udf;
 pub(crate) mod regexp_udf;
+pub(crate) mod span_event_attr_udf;
 pub(crate) mod spath_udf;
 pub(crate) mod string_to_array_v2_udf;
 pub(crate) mod time_range_udf;
@@ -41,8 +50,20 @@ pub(crate) const MATCH_UDF_IGNORE_CASE_NAME: &str = "str_match_ignore_case";
 pub(crate) const REGEX_MATCH_UDF_NAME: &str = "re_match";
 /// The name of the not_regex_match UDF given to DataFusion.
 pub(crate) const REGEX_NOT_MATCH_UDF_NAME: &str = "re_not_match";
+/// The name of the parse_regex UDF given to DataFusion.
+pub(crate) const PARSE_REGEX_UDF_NAME: &str = "parse_regex";
+/// The name of the parse_kv UDF given to DataFusion.
+pub(crate) const PARSE_KV_UDF_NAME: &str = "parse_kv";
+/// The name of the geohash UDF given to DataFusion.
+pub(crate) const GEOHASH_UDF_NAME: &str = "geohash";
+/// The name of the geohash_lat UDF given to DataFusion.
+pub(crate) const GEOHASH_LAT_UDF_NAME: &str = "geohash_lat";
+/// The name of the geohash_lon UDF given to DataFusion.
+pub(crate) const GEOHASH_LON_UDF_NAME: &str = "geohash_lon";
+/// The name of the country_code UDF given to DataFusion.
+pub(crate) const COUNTRY_CODE_UDF_NAME: &str = "country_code";
 
-pub(crate) const DEFAULT_FUNCTIONS: [ZoFunction; 7] = [
+pub(crate) const DEFAULT_FUNCTIONS: [ZoFunction; 11] = [
     ZoFunction {
         name: "match_all_raw",
         text: "match_all_raw('v')",
@@ -71,6 +92,22 @@ pub(crate) const DEFAULT_FUNCTIONS: [ZoFunction; 7] = [
         name: REGEX_NOT_MATCH_UDF_NAME,
         text: "re_not_match(field, 'pattern')",
     },
+    ZoFunction {
+        name: PARSE_REGEX_UDF_NAME,
+        text: "parse_regex(field, '(?P<name>pattern)')",
+    },
+    ZoFunction {
+        name: PARSE_KV_UDF_NAME,
+        text: "parse_kv(field, 'key')",
+    },
+    ZoFunction {
+        name: GEOHASH_UDF_NAME,
+        text: "geohash(lat, lon, 5)",
+    },
+    ZoFunction {
+        name: COUNTRY_CODE_UDF_NAME,
+        text: "country_code(field, 'alpha2')",
+    },
 ];
 
 pub fn stringify_json_value(field: &json::Value) -> String {
@@ -0,0 +1,272 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use datafusion::{
+    arrow::{
+        array::{ArrayRef, StringArray},
+        datatypes::DataType,
+    },
+    common::cast::as_string_array,
+    error::{DataFusionError, Result},
+    logical_expr::{ScalarUDF, ScalarUDFImpl, Signature, Volatility},
+    physical_plan::ColumnarValue,
+    scalar::ScalarValue,
+};
+use datafusion_expr::TypeSignature::Exact;
+use once_cell::sync::Lazy;
+
+/// Implementation of parse_kv
+pub(crate) static PARSE_KV_UDF: Lazy<ScalarUDF> = Lazy::new(|| ScalarUDF::from(ParseKv::new()));
+
+/// `parse_kv(field, key [, pair_delimiter, kv_delimiter])` extracts the value
+/// of `key` from `key=value` pairs in the field. Pairs are separated by
+/// whitespace and keys by `=` unless the delimiters are given, values can be
+/// double quoted to contain the pair delimiter.
+///
+/// ```sql
+/// SELECT parse_kv(log, 'status') AS status FROM t
+/// SELECT parse_kv(log, 'user', ';', ':') AS user FROM t
+/// ```
+#[derive(Debug, Clone)]
+struct ParseKv {
+    signature: Signature,
+}
+
+impl ParseKv {
+    fn new() -> Self {
+        Self {
+            signature: Signature::one_of(
+                vec![
+                    Exact(vec![DataType::Utf8, DataType::Utf8]),
+                    Exact(vec![
+                        DataType::Utf8,
+                        DataType::Utf8,
+                        DataType::Utf8,
+                        DataType::Utf8,
+                    ]),
+                ],
+                Volatility::Immutable,
+            ),
+        }
+    }
+}
+
+impl ScalarUDFImpl for ParseKv {
+    fn as_any(&self) -> &dyn std::any::Any {
+        self
+    }
+
+    fn name(&self) -> &str {
+        super::PARSE_KV_UDF_NAME
+    }
+
+    fn signature(&self) -> &Signature {
+        &self.signature
+    }
+
+    fn return_type(&self, _arg_types: &[DataType]) -> Result<DataType> {
+        Ok(DataType::Utf8)
+    }
+
+    fn invoke(&self, args: &[ColumnarValue]) -> Result<ColumnarValue> {
+        if args.len() != 2 && args.len() != 4 {
+            return Err(DataFusionError::Execution(
+                "UDF params should be: parse_kv(field, key [, pair_delimiter, kv_delimiter])"
+                    .to_string(),
+            ));
+        }
+        let key = scalar_arg(&args[1], "key")?;
+        let (pair_delim, kv_delim) = if args.len() == 4 {
+            (
+                Some(scalar_arg(&args[2], "pair_delimiter")?),
+                scalar_arg(&args[3], "kv_delimiter")?,
+            )
+        } else {
+            (None, "=")
+        };
+        if kv_delim.is_empty() || pair_delim.is_some_and(|v| v.is_empty()) {
+            return Err(DataFusionError::Execution(
+                "parse_kv delimiters should not be empty".to_string(),
+            ));
+        }
+
+        let is_scalar = matches!(args[0], ColumnarValue::Scalar(_));
+        let input = args[0].clone().into_array(1)?;
+        let result = as_string_array(&input)?
+            .iter()
+            .map(|row| row.and_then(|v| extract_value(v, key, pair_delim, kv_delim)))
+            .collect::<StringArray>();
+        if is_scalar {
+            Ok(ColumnarValue::Scalar(ScalarValue::Utf8(
+                result.iter().next().flatten().map(|v| v.to_string()),
+            )))
+        } else {
+            Ok(ColumnarValue::Array(Arc::new(result) as ArrayRef))
+        }
+    }
+}
+
+fn scalar_arg<'a>(arg: &'a ColumnarValue, name: &str) -> Result<&'a str> {
+    match arg {
+        ColumnarValue::Scalar(ScalarValue::Utf8(Some(v))) => Ok(v.as_str()),
+        _ => Err(DataFusionError::Execution(format!(
+            "The {name} argument for parse_kv needs to be a string literal"
+        ))),
+    }
+}
+
+/// Splits the text into pairs, keeping the delimiters inside double quotes
+fn split_pairs<'a>(text: &'a str, pair_delim: Option<&str>) -> Vec<&'a str> {
+    let mut pairs = Vec::new();
+    let mut in_quote = false;
+    let mut start = 0;
+    let mut iter = text.char_indices().peekable();
+    while let Some((i, c)) = iter.next() {
+        if c == '"' {
+            in_quote = !in_quote;
+            continue;
+        }
+        if in_quote {
+            continue;
+        }
+        let delim_len = match pair_delim {
+            None if c.is_whitespace() => c.len_utf8(),
+            Some(delim) if text[i..].starts_with(delim) => delim.len(),
+            _ => continue,
+        };
+        if i > start {
+            pairs.push(&text[start..i]);
+        }
+        start = i + delim_len;
+        // skip the rest of a multi char delimiter
+        while iter.peek().is_some_and(|(j, _)| *j < start) {
+            iter.next();
+        }
+    }
+    if start < text.len() {
+        pairs.push(&text[start..]);
+    }
+    pairs
+}
+
+fn extract_value(
+    text: &str,
+    key: &str,
+    pair_delim: Option<&str>,
+    kv_delim: &str,
+) -> Option<String> {
+    split_pairs(text, pair_delim).into_iter().find_map(|pair| {
+        let (k, v) = pair.split_once(kv_delim)?;
+        if k.trim() != key {
+            return None;
+        }
+        let v = v.trim();
+        let v = v
+            .strip_prefix('"')
+            .and_then(|v| v.strip_suffix('"'))
+            .unwrap_or(v);
+        Some(v.to_string())
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[test]
+    fn test_extract_value() {
+        let text = r#"level=info user="john doe" took=12ms"#;
+        assert_eq!(
+            extract_value(text, "user", None, "="),
+            Some("john doe".to_string())
+        );
+        assert_eq!(
+            extract_value(text, "took", None, "="),
+            Some("12ms".to_string())
+        );
+        assert_eq!(extract_value(text, "status", None, "="), None);
+
+        let text = "level: warn; path: /a;b: 1";
+        assert_eq!(
+            extract_value(text, "path", Some(";"), ":"),
+            Some("/a".to_string())
+        );
+        assert_eq!(
+            extract_value(text, "b", Some(";"), ":"),
+            Some("1".to_string())
+        );
+        let text = "a=1 || b=2";
+        assert_eq!(
+            extract_value(text, "b", Some("||"), "="),
+            Some("2".to_string())
+        );
+    }
+
+    #[tokio::test]
+    async fn test_parse_kv_udf() {
+        let sqls = [
+            (
+                "select parse_kv(log, 'status') as ret from t",
+                vec![
+                    "+-----+", "| ret |", "+-----+", "| 200 |", "| 500 |", "+-----+",
+                ],
+            ),
+            (
+                "select parse_kv(log, 'user', ' ', '=') as ret from t",
+                vec![
+                    "+-------+",
+                    "| ret   |",
+                    "+-------+",
+                    "| alice |",
+                    "|       |",
+                    "+-------+",
+                ],
+            ),
+        ];
+
+        let schema = Arc::new(Schema::new(vec![Field::new("log", DataType::Utf8, false)]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                "user=alice status=200",
+                "status=500 error=\"not found\"",
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(PARSE_KV_UDF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        for item in sqls {
+            let df = ctx.sql(item.0).await.unwrap();
+            let data = df.collect().await.unwrap();
+            assert_batches_eq!(item.1, &data);
+        }
+    }
+}
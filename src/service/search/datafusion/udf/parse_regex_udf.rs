@@ -0,0 +1,220 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use arrow_schema::{Field, Fields};
+use datafusion::{
+    arrow::{
+        array::{Array, ArrayRef, StringArray, StructArray},
+        datatypes::DataType,
+    },
+    common::cast::as_string_array,
+    error::{DataFusionError, Result},
+    logical_expr::{ScalarUDF, ScalarUDFImpl, Signature, Volatility},
+    physical_plan::ColumnarValue,
+    prelude::Expr,
+    scalar::ScalarValue,
+};
+use datafusion_expr::TypeSignature::Exact;
+use once_cell::sync::Lazy;
+
+/// Implementation of parse_regex
+pub(crate) static PARSE_REGEX_UDF: Lazy<ScalarUDF> =
+    Lazy::new(|| ScalarUDF::from(ParseRegex::new()));
+
+/// `parse_regex(field, pattern)` extracts the Named Capturing Groups of the
+/// pattern from every row of the field, and returns them as a struct with one
+/// string column per group. Rows which don't match get null values.
+///
+/// ```sql
+/// SELECT parse_regex(log, 'user=(?P<user>\w+) took (?P<took>\d+)ms')['user'] AS user FROM t
+/// ```
+#[derive(Debug, Clone)]
+struct ParseRegex {
+    signature: Signature,
+}
+
+impl ParseRegex {
+    fn new() -> Self {
+        Self {
+            signature: Signature::one_of(
+                vec![Exact(vec![DataType::Utf8, DataType::Utf8])],
+                Volatility::Immutable,
+            ),
+        }
+    }
+}
+
+impl ScalarUDFImpl for ParseRegex {
+    fn as_any(&self) -> &dyn std::any::Any {
+        self
+    }
+
+    fn name(&self) -> &str {
+        super::PARSE_REGEX_UDF_NAME
+    }
+
+    fn signature(&self) -> &Signature {
+        &self.signature
+    }
+
+    fn return_type(&self, _arg_types: &[DataType]) -> Result<DataType> {
+        unreachable!() // since return_type_from_exprs is implemented
+    }
+
+    fn return_type_from_exprs(
+        &self,
+        args: &[Expr],
+        _schema: &dyn datafusion::common::ExprSchema,
+        _args_types: &[DataType],
+    ) -> Result<DataType> {
+        let pattern = match args.get(1) {
+            Some(Expr::Literal(ScalarValue::Utf8(Some(pattern)))) => pattern,
+            _ => {
+                return Err(DataFusionError::Execution(
+                    "The second argument for parse_regex needs to be a string literal".to_string(),
+                ));
+            }
+        };
+        let (_, fields) = compile_pattern(pattern)?;
+        Ok(DataType::Struct(fields))
+    }
+
+    fn invoke(&self, args: &[ColumnarValue]) -> Result<ColumnarValue> {
+        if args.len() != 2 {
+            return Err(DataFusionError::Execution(
+                "UDF params should be: parse_regex(field, pattern)".to_string(),
+            ));
+        }
+        let pattern = match &args[1] {
+            ColumnarValue::Scalar(ScalarValue::Utf8(Some(pattern))) => pattern,
+            _ => {
+                return Err(DataFusionError::Execution(
+                    "The second argument for parse_regex needs to be a string literal".to_string(),
+                ));
+            }
+        };
+        let (re, fields) = compile_pattern(pattern)?;
+
+        let is_scalar = matches!(args[0], ColumnarValue::Scalar(_));
+        let input = args[0].clone().into_array(1)?;
+        let input = as_string_array(&input)?;
+
+        let mut columns: Vec<Vec<Option<String>>> =
+            vec![Vec::with_capacity(input.len()); fields.len()];
+        for row in input.iter() {
+            let caps = row.and_then(|v| re.captures(v));
+            for (i, field) in fields.iter().enumerate() {
+                let val = caps
+                    .as_ref()
+                    .and_then(|caps| caps.name(field.name()))
+                    .map(|m| m.as_str().to_string());
+                columns[i].push(val);
+            }
+        }
+
+        let arrays = fields
+            .iter()
+            .zip(columns)
+            .map(|(field, values)| {
+                (
+                    field.clone(),
+                    Arc::new(StringArray::from(values)) as ArrayRef,
+                )
+            })
+            .collect::<Vec<_>>();
+        let result = StructArray::from(arrays);
+        if is_scalar {
+            Ok(ColumnarValue::Scalar(ScalarValue::Struct(Arc::new(result))))
+        } else {
+            Ok(ColumnarValue::Array(Arc::new(result)))
+        }
+    }
+}
+
+/// Compiles the pattern and returns the struct fields made from its Named
+/// Capturing Groups
+fn compile_pattern(pattern: &str) -> Result<(regex::Regex, Fields)> {
+    let re = regex::Regex::new(pattern)
+        .map_err(|e| DataFusionError::Execution(format!("error compiling regex pattern: {e}")))?;
+    let fields = re
+        .capture_names()
+        .flatten()
+        .map(|name| Field::new(name, DataType::Utf8, true))
+        .collect::<Vec<_>>();
+    if fields.is_empty() {
+        return Err(DataFusionError::Execution(
+            "Named Capturing Groups must be used to assign field names for parse_regex function"
+                .to_string(),
+        ));
+    }
+    Ok((re, Fields::from(fields)))
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{datatypes::Schema, record_batch::RecordBatch},
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+
+    #[tokio::test]
+    async fn test_parse_regex_udf() {
+        let sql = r"select parse_regex(log, 'user=(?P<user>\w+) took=(?P<took>\d+)')['user'] as user from t";
+        let expected = vec![
+            "+-------+",
+            "| user  |",
+            "+-------+",
+            "| alice |",
+            "|       |",
+            "| bob   |",
+            "+-------+",
+        ];
+
+        let schema = Arc::new(Schema::new(vec![Field::new("log", DataType::Utf8, false)]));
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                "GET /api user=alice took=12",
+                "GET /healthz",
+                "user=bob took=3 status=200",
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(PARSE_REGEX_UDF.clone());
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        let df = ctx.sql(sql).await.unwrap();
+        let data = df.collect().await.unwrap();
+        assert_batches_eq!(expected, &data);
+    }
+
+    #[test]
+    fn test_compile_pattern() {
+        assert!(compile_pattern(r"(\w+)").is_err());
+        let (_, fields) = compile_pattern(r"(?P<a>\w+)-(?<b>\d+)").unwrap();
+        assert_eq!(fields.len(), 2);
+        assert_eq!(fields[0].name(), "a");
+        assert_eq!(fields[1].name(), "b");
+    }
+}
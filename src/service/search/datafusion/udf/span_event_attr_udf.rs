@@ -0,0 +1,169 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use arrow::array::StringArray;
+use config::utils::json;
+use datafusion::{
+    arrow::{array::ArrayRef, datatypes::DataType},
+    common::cast::as_string_array,
+    error::DataFusionError,
+    logical_expr::{ScalarUDF, Volatility},
+    prelude::create_udf,
+    sql::sqlparser::parser::ParserError,
+};
+use datafusion_expr::ColumnarValue;
+use once_cell::sync::Lazy;
+
+/// The name of the span_event_attr UDF given to DataFusion.
+pub const SPAN_EVENT_ATTR_UDF_NAME: &str = "span_event_attr";
+
+/// Implementation of span_event_attr
+pub(crate) static SPAN_EVENT_ATTR_UDF: Lazy<ScalarUDF> = Lazy::new(|| {
+    create_udf(
+        SPAN_EVENT_ATTR_UDF_NAME,
+        // expects three strings - the events field, the event name and the attribute key
+        vec![DataType::Utf8, DataType::Utf8, DataType::Utf8],
+        // returns string
+        Arc::new(DataType::Utf8),
+        Volatility::Immutable,
+        Arc::new(span_event_attr_impl),
+    )
+});
+
+/// span_event_attr function for datafusion
+///
+/// Returns a json array with the value of the attribute of every span event with the given
+/// name, e.g. `span_event_attr(events, 'exception', 'exception.type')`. Unlike spath the key
+/// is matched as a whole so dotted attribute names work, and the name and attribute are
+/// matched on the same event. Combine it with arrcontains() to filter spans:
+/// `arrcontains(span_event_attr(events, 'exception', 'exception.type'), 'IOError')`.
+pub fn span_event_attr_impl(args: &[ColumnarValue]) -> datafusion::error::Result<ColumnarValue> {
+    log::debug!("Inside span_event_attr");
+    if args.len() != 3 {
+        return Err(DataFusionError::SQL(
+            ParserError::ParserError(
+                "UDF params should be: span_event_attr(events, name, attribute)".to_string(),
+            ),
+            None,
+        ));
+    }
+    let args = ColumnarValue::values_to_arrays(args)?;
+    log::debug!("Got the args: {:#?}", args);
+
+    // 1. cast the arguments to string. These casts MUST be aligned with the signature or this
+    //    function panics!
+    let events = as_string_array(&args[0]).expect("cast failed");
+    let names = as_string_array(&args[1]).expect("cast failed");
+    let keys = as_string_array(&args[2]).expect("cast failed");
+
+    // 2. perform the computation
+    let array = (0..events.len())
+        .map(|i| {
+            // in arrow, any value can be null.
+            // Here we decide to make our UDF to return null when any argument is null.
+            if events.is_null(i) || names.is_null(i) || keys.is_null(i) {
+                return None;
+            }
+            let events = json::from_str::<json::Value>(events.value(i)).ok()?;
+            json::to_string(&event_attr_values(&events, names.value(i), keys.value(i))).ok()
+        })
+        .collect::<StringArray>();
+
+    // `Ok` because no error occurred during the calculation
+    // `Arc` because arrays are immutable, thread-safe, trait objects.
+    Ok(ColumnarValue::from(Arc::new(array) as ArrayRef))
+}
+
+fn event_attr_values<'a>(events: &'a json::Value, name: &str, key: &str) -> Vec<&'a json::Value> {
+    let Some(events) = events.as_array() else {
+        return vec![];
+    };
+    events
+        .iter()
+        .filter(|event| event.get("name").and_then(|v| v.as_str()) == Some(name))
+        .filter_map(|event| event.get(key))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use datafusion::{
+        arrow::{
+            datatypes::{Field, Schema},
+            record_batch::RecordBatch,
+        },
+        assert_batches_eq,
+        datasource::MemTable,
+        prelude::SessionContext,
+    };
+
+    use super::*;
+    use crate::service::search::datafusion::udf::arrcontains_udf::ARR_CONTAINS_UDF;
+
+    #[tokio::test]
+    async fn test_span_event_attr_udf() {
+        let sqls = [
+            (
+                "select span_event_attr(events, 'exception', 'exception.type') as ret from t",
+                vec![
+                    "+-----------------------+",
+                    "| ret                   |",
+                    "+-----------------------+",
+                    "| [\"IOError\",\"Timeout\"] |",
+                    "+-----------------------+",
+                ],
+            ),
+            (
+                "select span_event_attr(events, 'log', 'exception.type') as ret from t",
+                vec!["+-----+", "| ret |", "+-----+", "| []  |", "+-----+"],
+            ),
+            (
+                "select count(*) as ret from t where arrcontains(span_event_attr(events, 'exception', 'exception.type'), 'Timeout')",
+                vec!["+-----+", "| ret |", "+-----+", "| 1   |", "+-----+"],
+            ),
+        ];
+
+        // define a schema.
+        let schema = Arc::new(Schema::new(vec![Field::new(
+            "events",
+            DataType::Utf8,
+            false,
+        )]));
+
+        // define data.
+        let batch = RecordBatch::try_new(
+            schema.clone(),
+            vec![Arc::new(StringArray::from(vec![
+                "[{\"name\":\"exception\",\"_timestamp\":1,\"exception.type\":\"IOError\"},{\"name\":\"log\",\"_timestamp\":2,\"level\":\"info\"},{\"name\":\"exception\",\"_timestamp\":3,\"exception.type\":\"Timeout\"}]",
+            ]))],
+        )
+        .unwrap();
+
+        let ctx = SessionContext::new();
+        ctx.register_udf(SPAN_EVENT_ATTR_UDF.clone());
+        ctx.register_udf(ARR_CONTAINS_UDF.clone());
+
+        let provider = MemTable::try_new(schema, vec![vec![batch]]).unwrap();
+        ctx.register_table("t", Arc::new(provider)).unwrap();
+
+        for item in sqls {
+            let df = ctx.sql(item.0).await.unwrap();
+            let data = df.collect().await.unwrap();
+            assert_batches_eq!(item.1, &data);
+        }
+    }
+}
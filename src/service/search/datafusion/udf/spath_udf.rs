@@ -13,6 +13,17 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
+//! `spath(field, path)` reads a value out of a nested field. There are no
+//! native nested columns: the ingesters flatten the objects up to the flatten
+//! level of the stream, `flatten_level` of the stream settings or else
+//! `ZO_INGEST_FLATTEN_LEVEL`, and store the arrays and the deeper objects whole
+//! as JSON strings, so a stream with deep objects keeps one column per object
+//! instead of one per nested key. The path accesses of the SQL, like
+//! `field.sub[0].name` or `items[*].id`, are rewritten into spath calls, see
+//! [crate::service::search::datafusion::rewrite::rewrite_path_access].
+//! `spath`, `arrcontains` and `cast_to_arr` parse these strings at query time,
+//! so a filter inside an array reads and parses the whole column.
+
 use std::{iter::zip, sync::Arc};
 
 use arrow::array::StringArray;
@@ -28,10 +39,10 @@ use datafusion::{
 use datafusion_expr::ColumnarValue;
 use once_cell::sync::Lazy;
 
-/// The name of the arrjoin UDF given to DataFusion.
+/// The name of the spath UDF given to DataFusion.
 pub const SPATH_UDF_NAME: &str = "spath";
 
-/// Implementation of arrjoin
+/// Implementation of spath
 pub(crate) static SPATH_UDF: Lazy<ScalarUDF> = Lazy::new(|| {
     create_udf(
         SPATH_UDF_NAME,
@@ -44,7 +55,90 @@ pub(crate) static SPATH_UDF: Lazy<ScalarUDF> = Lazy::new(|| {
     )
 });
 
-/// arrjoin function for datafusion
+/// A single step of a path expression such as `a.b[0].name` or `items[*].id`.
+#[derive(Debug, Clone, PartialEq)]
+pub(crate) enum PathSegment {
+    Key(String),
+    Index(usize),
+    Wildcard,
+}
+
+/// Parses a path expression into segments. Keys are separated by `.`, array elements are
+/// addressed with `[n]` and `[*]` selects every element. Returns `None` for malformed paths.
+pub(crate) fn parse_path(path: &str) -> Option<Vec<PathSegment>> {
+    let mut segments = Vec::new();
+    let mut key = String::new();
+    let mut chars = path.chars();
+    while let Some(c) = chars.next() {
+        match c {
+            '.' => {
+                if !key.is_empty() {
+                    segments.push(PathSegment::Key(std::mem::take(&mut key)));
+                }
+            }
+            '[' => {
+                if !key.is_empty() {
+                    segments.push(PathSegment::Key(std::mem::take(&mut key)));
+                }
+                let mut index = String::new();
+                loop {
+                    match chars.next()? {
+                        ']' => break,
+                        c => index.push(c),
+                    }
+                }
+                let index = index.trim();
+                if index == "*" {
+                    segments.push(PathSegment::Wildcard);
+                } else {
+                    segments.push(PathSegment::Index(index.parse().ok()?));
+                }
+            }
+            c => key.push(c),
+        }
+    }
+    if !key.is_empty() {
+        segments.push(PathSegment::Key(key));
+    }
+    if segments.is_empty() {
+        None
+    } else {
+        Some(segments)
+    }
+}
+
+/// Resolves the path against a json value and returns every matching value.
+pub(crate) fn lookup_path<'a>(
+    value: &'a json::Value,
+    segments: &[PathSegment],
+) -> Vec<&'a json::Value> {
+    let Some((segment, rest)) = segments.split_first() else {
+        return vec![value];
+    };
+    match (segment, value) {
+        (PathSegment::Key(key), json::Value::Object(obj)) => obj
+            .get(key)
+            .map(|v| lookup_path(v, rest))
+            .unwrap_or_default(),
+        // allow `a.b.0` as an alternative to `a.b[0]`
+        (PathSegment::Key(key), json::Value::Array(arr)) => key
+            .parse::<usize>()
+            .ok()
+            .and_then(|i| arr.get(i))
+            .map(|v| lookup_path(v, rest))
+            .unwrap_or_default(),
+        (PathSegment::Index(i), json::Value::Array(arr)) => arr
+            .get(*i)
+            .map(|v| lookup_path(v, rest))
+            .unwrap_or_default(),
+        (PathSegment::Wildcard, json::Value::Array(arr)) => {
+            arr.iter().flat_map(|v| lookup_path(v, rest)).collect()
+        }
+        _ => vec![],
+    }
+}
+
+/// spath function for datafusion
 pub fn spath_impl(args: &[ColumnarValue]) -> datafusion::error::Result<ColumnarValue> {
     log::debug!("Inside spath");
     if args.len() != 2 {
@@ -68,28 +162,16 @@ pub fn spath_impl(args: &[ColumnarValue]) -> datafusion::error::Result<ColumnarV
                 // in arrow, any value can be null.
                 // Here we decide to make our UDF to return null when either argument is null.
                 (Some(field), Some(path)) => {
-                    let mut field: json::Value =
-                        json::from_str(field).expect("Failed to deserialize arrzip field1");
-                    let mut found = true;
-                    let paths = path.split('.').collect::<Vec<&str>>();
-                    for path in paths.into_iter() {
-                        if let json::Value::Object(mut obj) = field.clone() {
-                            if let Some(val) = obj.remove(path) {
-                                field = val;
-                            } else {
-                                found = false;
-                                break;
-                            }
-                        } else {
-                            found = false;
-                            break;
-                        }
-                    }
-                    if found {
-                        let field = super::stringify_json_value(&field);
-                        Some(field)
+                    let field: json::Value = json::from_str(field).ok()?;
+                    let segments = parse_path(path)?;
+                    let values = lookup_path(&field, &segments);
+                    if segments.contains(&PathSegment::Wildcard) {
+                        // wildcard paths collect every match into an array so the result can
+                        // be fed to arrcontains() or unnest(cast_to_arr(..))
+                        let values = values.into_iter().cloned().collect::<Vec<_>>();
+                        Some(json::to_string(&values).unwrap_or_default())
                     } else {
-                        None
+                        values.first().map(|v| super::stringify_json_value(v))
                     }
                 }
                 _ => None,
@@ -139,6 +221,32 @@ mod tests {
                     "+------------+",
                 ],
             ),
+            (
+                "select spath(object, 'array[1]') as ret from t",
+                vec!["+-----+", "| ret |", "+-----+", "| 54  |", "+-----+"],
+            ),
+            (
+                "select spath(object, 'items[0].name') as ret from t",
+                vec!["+-----+", "| ret |", "+-----+", "| foo |", "+-----+"],
+            ),
+            (
+                "select spath(object, 'items.1.name') as ret from t",
+                vec!["+-----+", "| ret |", "+-----+", "| bar |", "+-----+"],
+            ),
+            (
+                "select spath(object, 'items[*].name') as ret from t",
+                vec![
+                    "+---------------+",
+                    "| ret           |",
+                    "+---------------+",
+                    "| [\"foo\",\"bar\"] |",
+                    "+---------------+",
+                ],
+            ),
+            (
+                "select spath(object, 'items[5].name') as ret from t",
+                vec!["+-----+", "| ret |", "+-----+", "|     |", "+-----+"],
+            ),
         ];
 
         // define a schema.
@@ -152,7 +260,7 @@ mod tests {
         let batch = RecordBatch::try_new(
             schema.clone(),
             vec![Arc::new(StringArray::from(vec![
-                "{\"nested\":{\"value\":\"jene\"},\"unnested\":\"doe\",\"array\":[34,54,45]}",
+                "{\"nested\":{\"value\":\"jene\"},\"unnested\":\"doe\",\"array\":[34,54,45],\"items\":[{\"name\":\"foo\"},{\"name\":\"bar\"}]}",
             ]))],
         )
         .unwrap();
@@ -173,4 +281,24 @@ mod tests {
             assert_batches_eq!(item.1, &data);
         }
     }
+
+    #[test]
+    fn test_parse_path() {
+        assert_eq!(
+            parse_path("a.b[0].name").unwrap(),
+            vec![
+                PathSegment::Key("a".to_string()),
+                PathSegment::Key("b".to_string()),
+                PathSegment::Index(0),
+                PathSegment::Key("name".to_string()),
+            ]
+        );
+        assert_eq!(
+            parse_path("items[*]").unwrap(),
+            vec![PathSegment::Key("items".to_string()), PathSegment::Wildcard]
+        );
+        assert!(parse_path("a[x]").is_none());
+        assert!(parse_path("a[0").is_none());
+        assert!(parse_path("").is_none());
+    }
 }
@@ -0,0 +1,443 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Federated search runs one query in several organizations for the platform
+//! teams that watch a whole fleet of them.
+//!
+//! Only the root user and the users whose role in an organization is one of
+//! `ZO_FEDERATED_SEARCH_ROLES` can include that organization. The query runs in
+//! each organization as the user, so the permissions of the user in the
+//! organization still apply, and the hits are tagged with their organization
+//! in the `_org` column. An organization failing doesn't fail the others, the
+//! response is then partial. Organizations pinned to another region are never
+//! queried from here, see [crate::service::residency]. The time range is
+//! shortened to the max query range of the stream in each organization.
+//!
+//! The SQL panels of a dashboard can be run the same way, see
+//! [search_dashboard].
+
+use std::{cmp::Ordering, collections::HashSet};
+
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        sql::Sql,
+        stream::StreamType,
+    },
+    utils::json,
+};
+use futures::future::join_all;
+use infra::schema::STREAM_SCHEMAS_LATEST;
+
+use crate::{
+    common::{
+        infra::config::USERS,
+        meta::{
+            dashboards::v4,
+            search::{
+                FederatedDashboardRequest, FederatedDashboardResponse, FederatedOrgResult,
+                FederatedPanelResult, FederatedSearchResponse,
+            },
+        },
+        utils::auth::is_root_user,
+    },
+    service::{residency, search::access},
+};
+
+/// Column holding the organization of a hit
+pub const ORG_COLUMN: &str = "_org";
+
+/// Max hits of each panel query of a federated dashboard
+const DEFAULT_PANEL_SIZE: i64 = 100;
+
+/// Returns the organizations the user can run federated queries in
+pub async fn federated_orgs(user_id: &str) -> Vec<String> {
+    let mut orgs = HashSet::new();
+    if is_root_user(user_id) {
+        for key in STREAM_SCHEMAS_LATEST.read().await.keys() {
+            if let Some((org_id, _)) = key.split_once('/') {
+                orgs.insert(org_id.to_string());
+            }
+        }
+        for user in USERS.iter() {
+            if let Some((org_id, _)) = user.key().split_once('/') {
+                orgs.insert(org_id.to_string());
+            }
+        }
+    } else {
+        let roles = federated_roles();
+        for user in USERS.iter() {
+            if let Some((org_id, id)) = user.key().split_once('/') {
+                if id == user_id && roles.contains(&user.role.to_string()) {
+                    orgs.insert(org_id.to_string());
+                }
+            }
+        }
+    }
+    let mut orgs = orgs.into_iter().collect::<Vec<_>>();
+    orgs.sort();
+    orgs
+}
+
+/// Returns whether the user can include the organization in federated
+/// queries
+pub fn can_federate(user_id: &str, org_id: &str) -> bool {
+    if is_root_user(user_id) {
+        return true;
+    }
+    match USERS.get(&format!("{org_id}/{user_id}")) {
+        Some(user) => federated_roles().contains(&user.role.to_string()),
+        None => false,
+    }
+}
+
+fn federated_roles() -> HashSet<String> {
+    get_config()
+        .common
+        .federated_search_roles
+        .split(',')
+        .map(|v| v.trim().to_lowercase())
+        .filter(|v| !v.is_empty())
+        .collect()
+}
+
+/// Returns the organizations of the request, checking the user can federate
+/// all of them
+pub async fn resolve_orgs(
+    user_id: &str,
+    req_orgs: &[String],
+) -> Result<Vec<String>, anyhow::Error> {
+    if req_orgs.is_empty() {
+        let orgs = federated_orgs(user_id).await;
+        if orgs.is_empty() {
+            return Err(anyhow::anyhow!(
+                "User is not allowed to run federated queries in any organization"
+            ));
+        }
+        return Ok(orgs);
+    }
+    let mut orgs = Vec::with_capacity(req_orgs.len());
+    for org_id in req_orgs.iter() {
+        if !can_federate(user_id, org_id) {
+            return Err(anyhow::anyhow!(
+                "User is not allowed to run federated queries in organization [{org_id}]"
+            ));
+        }
+        // orgs pinned to another region are rejected upfront, the implicit
+        // list reports them as failed orgs of the response instead
+        if let Err(e) = residency::check_local(org_id) {
+            return Err(anyhow::anyhow!(e));
+        }
+        if !orgs.contains(org_id) {
+            orgs.push(org_id.to_string());
+        }
+    }
+    Ok(orgs)
+}
+
+/// Runs the query in all the organizations, they must have been resolved with
+/// [resolve_orgs]. Each organization returns its first `from + size` hits, the
+/// merged hits are then sorted by the ORDER BY of the query, or by the
+/// timestamp when the query has neither ORDER BY nor GROUP BY, before keeping
+/// the `size` hits after `from`.
+pub async fn search(
+    trace_id: &str,
+    orgs: &[String],
+    stream_type: StreamType,
+    user_id: &str,
+    query: &Query,
+) -> Result<FederatedSearchResponse, anyhow::Error> {
+    let start = std::time::Instant::now();
+    let sql = Sql::new(&query.sql)?;
+    let mut query = query.clone();
+    if query.sql_mode.is_empty() {
+        query.sql_mode = "full".to_string();
+    }
+    let (from, size) = (query.from.max(0) as usize, query.size);
+    if size > 0 {
+        query.size += query.from.max(0);
+        query.from = 0;
+    }
+
+    let tasks = orgs.iter().enumerate().map(|(i, org_id)| {
+        let trace_id = format!("{trace_id}-{i}");
+        let mut query = query.clone();
+        let stream_name = &sql.source;
+        async move {
+            if !access::can_read(org_id, user_id, stream_type, stream_name).await {
+                return (
+                    Err(anyhow::anyhow!(
+                        "Unauthorized Access to stream [{stream_name}]"
+                    )),
+                    None,
+                );
+            }
+            let warning =
+                access::clamp_query_range(org_id, stream_type, stream_name, &mut query).await;
+            let search_req = Request {
+                query,
+                aggs: Default::default(),
+                encoding: RequestEncoding::Empty,
+                regions: vec![],
+                clusters: vec![],
+                timeout: 0,
+                search_type: Some(SearchEventType::Other),
+                search_event_context: None,
+            };
+            let res = super::search(
+                &trace_id,
+                org_id,
+                stream_type,
+                Some(user_id.to_string()),
+                &search_req,
+            )
+            .await
+            .map_err(anyhow::Error::from);
+            (res, warning)
+        }
+    });
+    let results = join_all(tasks).await;
+
+    let mut resp = FederatedSearchResponse::default();
+    let mut errors = Vec::new();
+    for (org_id, (result, warning)) in orgs.iter().zip(results) {
+        match result {
+            Ok(res) => {
+                resp.total += res.total;
+                resp.hits
+                    .extend(res.hits.into_iter().map(|hit| tag_hit(hit, org_id)));
+                resp.orgs.push(FederatedOrgResult {
+                    org_id: org_id.to_string(),
+                    took: res.took,
+                    total: res.total,
+                    error: None,
+                    warning,
+                });
+            }
+            Err(e) => {
+                log::error!("[trace_id {trace_id}] federated search in org {org_id} error: {e}");
+                errors.push(format!("{org_id}: {e}"));
+                resp.orgs.push(FederatedOrgResult {
+                    org_id: org_id.to_string(),
+                    error: Some(e.to_string()),
+                    ..Default::default()
+                });
+            }
+        }
+    }
+    if !orgs.is_empty() && errors.len() == orgs.len() {
+        return Err(anyhow::anyhow!(errors.join("; ")));
+    }
+
+    let order_by = if sql.order_by.is_empty() && sql.group_by.is_empty() {
+        vec![(get_config().common.column_timestamp.clone(), true)]
+    } else {
+        sql.order_by
+    };
+    sort_hits(&mut resp.hits, &order_by);
+    resp.hits = page_hits(resp.hits, from, size);
+    resp.is_partial = !errors.is_empty();
+    resp.took = start.elapsed().as_millis() as usize;
+    Ok(resp)
+}
+
+/// Runs the SQL panels of the dashboard in all the organizations, they must
+/// have been resolved with [resolve_orgs]. The panels using dashboard
+/// variables and the PromQL panels are reported as failed, the variables
+/// can't be resolved the same way in every organization.
+pub async fn search_dashboard(
+    trace_id: &str,
+    orgs: &[String],
+    user_id: &str,
+    dashboard: &v4::Dashboard,
+    req: &FederatedDashboardRequest,
+) -> FederatedDashboardResponse {
+    let mut resp = FederatedDashboardResponse {
+        dashboard_id: dashboard.dashboard_id.clone(),
+        title: dashboard.title.clone(),
+        panels: vec![],
+    };
+    for tab in dashboard.tabs.iter() {
+        for panel in tab.panels.iter() {
+            let mut result = FederatedPanelResult {
+                tab_id: tab.tab_id.clone(),
+                panel_id: panel.id.clone(),
+                title: panel.title.clone(),
+                ..Default::default()
+            };
+            let queries = panel
+                .queries
+                .iter()
+                .filter_map(|q| q.query.as_ref().map(|sql| (sql, q.fields.stream_type)))
+                .filter(|(sql, _)| !sql.trim().is_empty())
+                .collect::<Vec<_>>();
+            if queries.is_empty() {
+                continue;
+            }
+            if panel.query_type.eq_ignore_ascii_case("promql") {
+                result.error = Some("Only the SQL panels can be federated".to_string());
+                resp.panels.push(result);
+                continue;
+            }
+            if queries.iter().any(|(sql, _)| sql.contains('$')) {
+                result.error =
+                    Some("Panels using dashboard variables can't be federated".to_string());
+                resp.panels.push(result);
+                continue;
+            }
+            for (i, (sql, stream_type)) in queries.into_iter().enumerate() {
+                let query = Query {
+                    sql: sql.to_string(),
+                    start_time: req.start_time,
+                    end_time: req.end_time,
+                    size: req.size.unwrap_or(DEFAULT_PANEL_SIZE),
+                    ..Default::default()
+                };
+                let trace_id = format!("{trace_id}-{}-{i}", panel.id);
+                match search(&trace_id, orgs, stream_type, user_id, &query).await {
+                    Ok(res) => result.results.push(res),
+                    Err(e) => {
+                        result.error = Some(e.to_string());
+                        result.results.clear();
+                        break;
+                    }
+                }
+            }
+            resp.panels.push(result);
+        }
+    }
+    resp
+}
+
+/// Sorts the hits by the fields, the hits missing a field last whatever the
+/// direction
+fn sort_hits(hits: &mut [json::Value], order_by: &[(String, bool)]) {
+    if order_by.is_empty() {
+        return;
+    }
+    hits.sort_by(|a, b| {
+        for (field, desc) in order_by.iter() {
+            let a = a.get(field).filter(|v| !v.is_null());
+            let b = b.get(field).filter(|v| !v.is_null());
+            let ord = match (a, b) {
+                (Some(a), Some(b)) => {
+                    let ord = compare_values(a, b);
+                    if *desc {
+                        ord.reverse()
+                    } else {
+                        ord
+                    }
+                }
+                (Some(_), None) => Ordering::Less,
+                (None, Some(_)) => Ordering::Greater,
+                (None, None) => Ordering::Equal,
+            };
+            if ord != Ordering::Equal {
+                return ord;
+            }
+        }
+        Ordering::Equal
+    });
+}
+
+fn compare_values(a: &json::Value, b: &json::Value) -> Ordering {
+    match (a.as_f64(), b.as_f64()) {
+        (Some(a), Some(b)) => a.total_cmp(&b),
+        _ => match (a.as_str(), b.as_str()) {
+            (Some(a), Some(b)) => a.cmp(b),
+            _ => a.to_string().cmp(&b.to_string()),
+        },
+    }
+}
+
+/// Keeps the `size` hits after `from`, all of them after `from` when the size
+/// isn't positive
+fn page_hits(hits: Vec<json::Value>, from: usize, size: i64) -> Vec<json::Value> {
+    let hits = hits.into_iter().skip(from);
+    if size > 0 {
+        hits.take(size as usize).collect()
+    } else {
+        hits.collect()
+    }
+}
+
+/// Adds the organization to the hit
+fn tag_hit(hit: json::Value, org_id: &str) -> json::Value {
+    match hit {
+        json::Value::Object(mut obj) => {
+            obj.insert(
+                ORG_COLUMN.to_string(),
+                json::Value::String(org_id.to_string()),
+            );
+            json::Value::Object(obj)
+        }
+        other => json::json!({ ORG_COLUMN: org_id, "value": other }),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tag_hit() {
+        assert_eq!(
+            tag_hit(json::json!({"status": "up", "count": 3}), "acme"),
+            json::json!({"status": "up", "count": 3, "_org": "acme"})
+        );
+        assert_eq!(
+            tag_hit(json::json!(3), "acme"),
+            json::json!({"_org": "acme", "value": 3})
+        );
+    }
+
+    #[test]
+    fn test_sort_hits() {
+        let mut hits = vec![
+            json::json!({"_org": "a", "count": 3, "name": "x"}),
+            json::json!({"_org": "b", "count": 10, "name": "y"}),
+            json::json!({"_org": "c", "name": "z"}),
+            json::json!({"_org": "d", "count": 3, "name": "w"}),
+        ];
+        sort_hits(
+            &mut hits,
+            &[("count".to_string(), true), ("name".to_string(), false)],
+        );
+        let orgs = hits
+            .iter()
+            .map(|h| h["_org"].as_str().unwrap())
+            .collect::<Vec<_>>();
+        assert_eq!(orgs, vec!["b", "d", "a", "c"]);
+
+        sort_hits(&mut hits, &[("count".to_string(), false)]);
+        let orgs = hits
+            .iter()
+            .map(|h| h["_org"].as_str().unwrap())
+            .collect::<Vec<_>>();
+        assert_eq!(orgs, vec!["d", "a", "b", "c"]);
+    }
+
+    #[test]
+    fn test_page_hits() {
+        let hits = (0..10).map(|i| json::json!(i)).collect::<Vec<_>>();
+        assert_eq!(
+            page_hits(hits.clone(), 2, 3),
+            vec![json::json!(2), json::json!(3), json::json!(4)]
+        );
+        assert_eq!(page_hits(hits.clone(), 8, 5).len(), 2);
+        assert_eq!(page_hits(hits, 0, 0).len(), 10);
+    }
+}
@@ -0,0 +1,336 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Hides the fields of the query results the role of the user can't see,
+//! following the field access rules of the stream settings. The queries
+//! referencing a hidden field are rejected, so it can't be read through an
+//! alias or a filter either. Query functions see the whole record, so they
+//! are rejected on streams with hidden fields, and `match_all` skips the
+//! hidden fields.
+//!
+//! The searches run without a user, the internal ones of the alerts, the
+//! reports, the usage or the enrichment tables, see every field. The rows of
+//! an alert are sent whole to its destinations, so only the users seeing every
+//! field of a stream can save alerts on it, see [check_alert].
+
+use std::collections::HashSet;
+
+use config::{
+    get_config,
+    meta::{
+        search,
+        stream::{FieldAccessRule, StreamType},
+    },
+};
+use infra::{
+    errors::{Error, ErrorCodes},
+    schema::unwrap_stream_settings,
+};
+
+use crate::{common::meta::mcp::wildcard_match, service::users};
+
+/// Fields of a stream hidden from a user
+#[derive(Debug, Default)]
+pub struct HiddenFields(HashSet<String>);
+
+impl HiddenFields {
+    /// Loads the fields of the stream hidden from the user, none for the
+    /// admins and the roles without a rule
+    pub async fn load(
+        org_id: &str,
+        stream_type: StreamType,
+        stream_name: &str,
+        user_id: &str,
+    ) -> Self {
+        let Ok(schema) = infra::schema::get(org_id, stream_name, stream_type).await else {
+            return Self::default();
+        };
+        let rules = unwrap_stream_settings(&schema)
+            .map(|s| s.field_access)
+            .unwrap_or_default();
+        if rules.is_empty() || users::is_admin(org_id, user_id).await {
+            return Self::default();
+        }
+        let Some(user) = users::get_user(Some(org_id), user_id).await else {
+            return Self::default();
+        };
+        let role = user.role.to_string();
+        let Some(rule) = rules.iter().find(|r| r.role == role) else {
+            return Self::default();
+        };
+        Self(
+            schema
+                .fields()
+                .iter()
+                .map(|f| f.name())
+                .filter(|name| !is_visible(rule, name))
+                .cloned()
+                .collect(),
+        )
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.0.is_empty()
+    }
+
+    /// Rejects the requests reading a hidden field. A function doesn't have
+    /// to name a field to copy it, e.g. `.dump = encode_json(.)` or a loop
+    /// over `keys(.)`, so functions are rejected as soon as a field is hidden.
+    pub fn check(&self, req: &search::Request) -> Result<(), Error> {
+        if self.is_empty() {
+            return Ok(());
+        }
+        let mut fields = self.referenced(&req.query.sql);
+        for sql in req.aggs.values() {
+            fields.extend(self.referenced(sql));
+        }
+        if !fields.is_empty() {
+            fields.sort();
+            fields.dedup();
+            return Err(Error::ErrorCode(ErrorCodes::SearchFieldNotAllowed(
+                fields.join(", "),
+            )));
+        }
+        if req
+            .query
+            .query_fn
+            .as_deref()
+            .is_some_and(|f| !f.trim().is_empty())
+        {
+            return Err(Error::ErrorCode(ErrorCodes::SearchFieldNotAllowed(
+                "query functions can't be used on streams with hidden fields".to_string(),
+            )));
+        }
+        Ok(())
+    }
+
+    /// Returns the hidden fields the query references, sorted
+    pub fn referenced(&self, sql: &str) -> Vec<String> {
+        if self.is_empty() {
+            return vec![];
+        }
+        let ids = identifiers(sql);
+        let mut fields = self
+            .0
+            .iter()
+            .filter(|f| ids.contains(*f))
+            .cloned()
+            .collect::<Vec<_>>();
+        fields.sort();
+        fields
+    }
+
+    /// Removes the hidden fields from the full text search fields
+    pub fn remove_from(&self, fields: &mut Vec<String>) {
+        fields.retain(|f| !self.0.iter().any(|h| h.eq_ignore_ascii_case(f)));
+    }
+
+    /// Removes the hidden fields from the hits and the columns of the results
+    pub fn filter(&self, res: &mut search::Response) {
+        if self.is_empty() {
+            return;
+        }
+        for hit in res.hits.iter_mut() {
+            if let Some(hit) = hit.as_object_mut() {
+                hit.retain(|k, _| !self.0.contains(k));
+            }
+        }
+        res.columns.retain(|c| !self.0.contains(c));
+    }
+}
+
+/// Rejects the alerts of a user with hidden fields on the stream, the alert
+/// queries run without a user
+pub async fn check_alert(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    user_id: &str,
+) -> Result<(), Error> {
+    if HiddenFields::load(org_id, stream_type, stream_name, user_id)
+        .await
+        .is_empty()
+    {
+        return Ok(());
+    }
+    Err(Error::ErrorCode(ErrorCodes::SearchFieldNotAllowed(
+        "alerts can't be saved on streams with hidden fields".to_string(),
+    )))
+}
+
+/// Returns true when the rule lets the role see the field, `_timestamp` is
+/// always visible
+pub fn is_visible(rule: &FieldAccessRule, field: &str) -> bool {
+    if field == get_config().common.column_timestamp {
+        return true;
+    }
+    (rule.allow.is_empty() || rule.allow.iter().any(|p| wildcard_match(p, field)))
+        && !rule.deny.iter().any(|p| wildcard_match(p, field))
+}
+
+/// Returns the identifiers of a query, skipping its string literals. The bare
+/// identifiers are returned both as written and lowercased.
+fn identifiers(query: &str) -> HashSet<String> {
+    let mut ids = HashSet::new();
+    let mut chars = query.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '\'' => {
+                // '' escapes a quote inside the literal
+                while let Some(c) = chars.next() {
+                    if c == '\'' && chars.next_if_eq(&'\'').is_none() {
+                        break;
+                    }
+                }
+            }
+            '"' | '`' => {
+                let id = chars.by_ref().take_while(|q| *q != c).collect::<String>();
+                ids.insert(id);
+            }
+            c if c.is_alphanumeric() || c == '_' => {
+                let mut id = c.to_string();
+                while let Some(c) = chars.next_if(|c| c.is_alphanumeric() || *c == '_') {
+                    id.push(c);
+                }
+                ids.insert(id.to_lowercase());
+                ids.insert(id);
+            }
+            _ => {}
+        }
+    }
+    ids
+}
+
+#[cfg(test)]
+mod tests {
+    use config::utils::json;
+
+    use super::*;
+
+    fn rule(allow: &[&str], deny: &[&str]) -> FieldAccessRule {
+        FieldAccessRule {
+            role: "member".to_string(),
+            allow: allow.iter().map(|s| s.to_string()).collect(),
+            deny: deny.iter().map(|s| s.to_string()).collect(),
+        }
+    }
+
+    #[test]
+    fn test_is_visible() {
+        let deny = rule(&[], &["user_*", "ssn"]);
+        assert!(is_visible(&deny, "message"));
+        assert!(!is_visible(&deny, "user_email"));
+        assert!(!is_visible(&deny, "ssn"));
+
+        let allow = rule(&["k8s_*", "message"], &["k8s_secret_*"]);
+        assert!(is_visible(&allow, "message"));
+        assert!(is_visible(&allow, "k8s_pod"));
+        assert!(!is_visible(&allow, "k8s_secret_token"));
+        assert!(!is_visible(&allow, "ssn"));
+        assert!(is_visible(&allow, "_timestamp"));
+    }
+
+    #[test]
+    fn test_referenced() {
+        let hidden = HiddenFields(HashSet::from(["ssn".to_string(), "userName".to_string()]));
+        assert!(hidden
+            .referenced("SELECT * FROM logs WHERE msg = 'ssn'")
+            .is_empty());
+        assert!(hidden
+            .referenced("SELECT * FROM logs WHERE msg = 'it''s ssn'")
+            .is_empty());
+        assert_eq!(hidden.referenced("SELECT SSN AS x FROM logs"), vec!["ssn"]);
+        assert_eq!(
+            hidden.referenced("SELECT \"userName\" FROM logs"),
+            vec!["userName"]
+        );
+    }
+
+    fn request(sql: &str, query_fn: Option<&str>) -> search::Request {
+        search::Request {
+            query: search::Query {
+                sql: sql.to_string(),
+                query_fn: query_fn.map(String::from),
+                ..Default::default()
+            },
+            aggs: Default::default(),
+            encoding: Default::default(),
+            regions: vec![],
+            clusters: vec![],
+            timeout: 0,
+            search_type: None,
+            search_event_context: None,
+        }
+    }
+
+    #[test]
+    fn test_check() {
+        let hidden = HiddenFields(HashSet::from(["ssn".to_string()]));
+        assert!(hidden.check(&request("SELECT * FROM logs", None)).is_ok());
+        assert!(hidden
+            .check(&request("SELECT ssn FROM logs", None))
+            .is_err());
+
+        // functions can copy the hidden fields without naming them
+        for query_fn in [
+            ".dump = encode_json(.)",
+            "for_each(keys(.)) -> |_i, k| { .copy = push(.copy, k) }",
+            ".copy = .ssn",
+        ] {
+            assert!(hidden
+                .check(&request("SELECT * FROM logs", Some(query_fn)))
+                .is_err());
+        }
+        assert!(hidden
+            .check(&request("SELECT * FROM logs", Some(" ")))
+            .is_ok());
+
+        let mut req = request("SELECT * FROM logs", None);
+        req.aggs.insert(
+            "by_ssn".to_string(),
+            "SELECT ssn, COUNT(*) FROM query GROUP BY ssn".to_string(),
+        );
+        assert!(hidden.check(&req).is_err());
+
+        // without hidden fields the functions are fine
+        assert!(HiddenFields::default()
+            .check(&request(
+                "SELECT * FROM logs",
+                Some(".dump = encode_json(.)")
+            ))
+            .is_ok());
+    }
+
+    #[test]
+    fn test_remove_from() {
+        let hidden = HiddenFields(HashSet::from(["userName".to_string()]));
+        let mut fields = vec!["log".to_string(), "username".to_string()];
+        hidden.remove_from(&mut fields);
+        assert_eq!(fields, vec!["log"]);
+    }
+
+    #[test]
+    fn test_filter() {
+        let hidden = HiddenFields(HashSet::from(["ssn".to_string()]));
+        let mut res = search::Response {
+            columns: vec!["ssn".to_string(), "message".to_string()],
+            hits: vec![json::json!({"ssn": "123", "message": "hello", "cnt": 1})],
+            ..Default::default()
+        };
+        hidden.filter(&mut res);
+        assert_eq!(res.columns, vec!["message"]);
+        assert_eq!(res.hits[0], json::json!({"message": "hello", "cnt": 1}));
+    }
+}
@@ -20,7 +20,7 @@ use arrow_schema::{DataType, Field, Schema};
 use config::{
     cluster, get_config,
     meta::{
-        search::ScanStats,
+        search::{QueryPriority, ScanStats},
         stream::{FileKey, StreamType},
     },
     FxIndexSet,
@@ -33,6 +33,7 @@ use tracing::{info_span, Instrument};
 
 use super::{datafusion, sql::Sql};
 use crate::service::db;
+mod priority;
 mod storage;
 mod wal;
 
@@ -63,6 +64,10 @@ pub async fn search(
         ))));
     }
 
+    // wait for a slot of the priority class of the query, held until the end
+    let priority = QueryPriority::from(req.priority.as_str());
+    let _permit = priority::admit(&trace_id, priority, timeout).await?;
+
     log::info!(
         "[trace_id {trace_id}] grpc->search in: part_id: {}, stream: {}/{}/{}, time range: {:?}",
         req.job.as_ref().unwrap().partition,
@@ -142,6 +147,7 @@ pub async fn search(
                     stream_type,
                     &work_group3,
                     timeout,
+                    priority,
                 )
                 .await
             }
@@ -181,8 +187,17 @@ pub async fn search(
 
     // merge all batches
     let (offset, limit) = (0, sql.meta.offset + sql.meta.limit);
+    let primary_key =
+        infra::schema::unwrap_stream_settings(&sql.schema).and_then(|s| s.primary_key);
     let mut merge_results = HashMap::new();
     for (name, batches) in results {
+        // the versions of an upsert stream can come from the wal and several schema versions
+        let batches = match primary_key.as_deref() {
+            Some(key) if name == "query" && sql.meta.group_by.is_empty() => {
+                datafusion::exec::merge_upsert_versions(batches, key).await?
+            }
+            _ => batches,
+        };
         let (merge_sql, select_fields) = if name == "query" {
             (sql.origin_sql.clone(), select_fields.clone())
         } else {
@@ -0,0 +1,124 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::sync::Arc;
+
+use config::{get_config, meta::search::QueryPriority};
+use infra::errors::{Error, Result};
+use once_cell::sync::Lazy;
+use tokio::{
+    sync::{OwnedSemaphorePermit, Semaphore},
+    time::{Duration, Instant},
+};
+
+static POOLS: Lazy<Pools> = Lazy::new(Pools::new);
+
+/// Concurrency pools of the querier, one per priority class
+struct Pools {
+    interactive: Arc<Semaphore>,
+    dashboard: Arc<Semaphore>,
+    background: Arc<Semaphore>,
+}
+
+impl Pools {
+    fn new() -> Self {
+        let cfg = get_config();
+        Self {
+            interactive: Arc::new(Semaphore::new(cfg.limit.query_interactive_concurrency)),
+            dashboard: Arc::new(Semaphore::new(cfg.limit.query_dashboard_concurrency)),
+            background: Arc::new(Semaphore::new(cfg.limit.query_background_concurrency)),
+        }
+    }
+
+    fn get(&self, priority: QueryPriority) -> Arc<Semaphore> {
+        match priority {
+            QueryPriority::Interactive => self.interactive.clone(),
+            QueryPriority::Dashboard => self.dashboard.clone(),
+            QueryPriority::Background => self.background.clone(),
+        }
+    }
+
+    /// All the interactive slots are used, the background queries give way
+    fn interactive_busy(&self) -> bool {
+        self.interactive.available_permits() == 0
+    }
+}
+
+/// Waits for a slot in the pool of the priority class of the query, the slot is
+/// released when the returned permit is dropped
+pub async fn admit(
+    trace_id: &str,
+    priority: QueryPriority,
+    timeout: u64,
+) -> Result<Option<OwnedSemaphorePermit>> {
+    if !get_config().common.feature_query_priority_enabled {
+        return Ok(None);
+    }
+    let start = Instant::now();
+    let pool = POOLS.get(priority);
+    let permit =
+        match tokio::time::timeout(Duration::from_secs(timeout), pool.acquire_owned()).await {
+            Ok(Ok(permit)) => permit,
+            Ok(Err(e)) => return Err(Error::Message(e.to_string())),
+            Err(_) => {
+                return Err(Error::Message(format!(
+                    "[trace_id {trace_id}] search: request timeout in {priority} queue"
+                )));
+            }
+        };
+    log::info!(
+        "[trace_id {trace_id}] search: wait in {priority} queue took: {} ms",
+        start.elapsed().as_millis()
+    );
+    Ok(Some(permit))
+}
+
+/// Pauses a background query while all the interactive slots are used, so the
+/// interactive queries get the resources of the node, until one slot is free or
+/// the deadline passes. The other priority classes never wait here.
+pub async fn give_way(trace_id: &str, priority: QueryPriority, deadline: Instant) {
+    if priority != QueryPriority::Background || !get_config().common.feature_query_priority_enabled
+    {
+        return;
+    }
+    let start = Instant::now();
+    while POOLS.interactive_busy() && Instant::now() < deadline {
+        tokio::time::sleep(Duration::from_millis(100)).await;
+    }
+    let paused = start.elapsed().as_millis();
+    if paused > 0 {
+        log::info!("[trace_id {trace_id}] search: background query paused for {paused} ms");
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_pools() {
+        let pools = Pools {
+            interactive: Arc::new(Semaphore::new(1)),
+            dashboard: Arc::new(Semaphore::new(1)),
+            background: Arc::new(Semaphore::new(1)),
+        };
+        assert!(!pools.interactive_busy());
+        let permit = pools.get(QueryPriority::Interactive).acquire_owned().await;
+        assert!(pools.interactive_busy());
+        assert_eq!(pools.get(QueryPriority::Background).available_permits(), 1);
+        drop(permit);
+        assert!(!pools.interactive_busy());
+    }
+}
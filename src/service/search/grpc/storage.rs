@@ -18,7 +18,7 @@ use std::sync::Arc;
 use config::{
     get_config, is_local_disk_storage,
     meta::{
-        search::{ScanStats, SearchType, StorageType},
+        search::{QueryPriority, ScanStats, SearchType, StorageType},
         stream::{FileKey, PartitionTimeLevel, StreamPartition, StreamType},
     },
     utils::schema_ext::SchemaExt,
@@ -31,7 +31,10 @@ use infra::{
     errors::{Error, ErrorCodes},
     schema::{unwrap_partition_time_level, unwrap_stream_settings},
 };
-use tokio::{sync::Semaphore, time::Duration};
+use tokio::{
+    sync::Semaphore,
+    time::{Duration, Instant},
+};
 use tracing::{info_span, Instrument};
 
 use crate::service::{
@@ -55,8 +58,11 @@ pub async fn search(
     stream_type: StreamType,
     work_group: &str,
     timeout: u64,
+    priority: QueryPriority,
 ) -> super::SearchResult {
     log::info!("[trace_id {trace_id}] search->storage: enter");
+    // a background query pauses at most half of its timeout for the interactive ones
+    let give_way_deadline = Instant::now() + Duration::from_secs(timeout / 2);
     let schema_latest = infra::schema::get(&sql.org_id, &sql.stream_name, stream_type)
         .await
         .map_err(|e| Error::ErrorCode(ErrorCodes::ServerInternalError(e.to_string())))?;
@@ -92,6 +98,8 @@ pub async fn search(
     let stream_settings = unwrap_stream_settings(&schema_latest).unwrap_or_default();
     let partition_time_level =
         unwrap_partition_time_level(stream_settings.partition_time_level, stream_type);
+    let sorted_by_time = stream_settings.clustering_keys.is_empty();
+    let primary_key = stream_settings.primary_key;
     let defined_schema_fields = stream_settings.defined_schema_fields.unwrap_or_default();
 
     // get file list
@@ -102,7 +110,7 @@ pub async fn search(
                 &sql,
                 stream_type,
                 partition_time_level,
-                &stream_settings.partition_keys,
+                &stream_settings.search_partition_keys(stream_type),
             )
             .await?
         }
@@ -181,7 +189,7 @@ pub async fn search(
 
     // load files to local cache
     let (cache_type, deleted_files, (mem_cached_files, disk_cached_files)) =
-        cache_parquet_files(trace_id, &files, &scan_stats).await?;
+        cache_parquet_files(trace_id, &files, &scan_stats, priority, give_way_deadline).await?;
     if !deleted_files.is_empty() {
         // remove deleted files from files_group
         for (_, g_files) in files_group.iter_mut() {
@@ -211,6 +219,7 @@ pub async fn search(
 
     let mut tasks = Vec::new();
     for (ver, files) in files_group {
+        super::priority::give_way(trace_id, priority, give_way_deadline).await;
         let schema = schema_versions[ver].clone();
         let schema_dt = schema
             .metadata()
@@ -233,6 +242,8 @@ pub async fn search(
                 SearchType::Normal
             },
             work_group: Some(work_group.to_string()),
+            sorted_by_time,
+            primary_key: primary_key.clone(),
         };
 
         // cacluate the diff between latest schema and group schema
@@ -411,6 +422,8 @@ async fn cache_parquet_files(
     trace_id: &str,
     files: &[FileKey],
     scan_stats: &ScanStats,
+    priority: QueryPriority,
+    give_way_deadline: Instant,
 ) -> Result<(file_data::CacheType, Vec<String>, CachedFiles), Error> {
     let cfg = get_config();
     let cache_type = if cfg.memory_cache.enabled
@@ -435,6 +448,7 @@ async fn cache_parquet_files(
     let mut tasks = Vec::new();
     let semaphore = std::sync::Arc::new(Semaphore::new(cfg.limit.query_thread_num));
     for file in files.iter() {
+        super::priority::give_way(trace_id, priority, give_way_deadline).await;
         let trace_id = trace_id.to_string();
         let file_name = file.key.clone();
         let permit = semaphore.clone().acquire_owned().await.unwrap();
@@ -77,6 +77,7 @@ pub async fn search_parquet(
     let partition_time_level =
         unwrap_partition_time_level(stream_settings.partition_time_level, stream_type);
     let defined_schema_fields = stream_settings.defined_schema_fields.unwrap_or_default();
+    let primary_key = stream_settings.primary_key;
 
     // get file list
     let files = get_file_list(
@@ -84,7 +85,7 @@ pub async fn search_parquet(
         &sql,
         stream_type,
         &partition_time_level,
-        &stream_settings.partition_keys,
+        &stream_settings.search_partition_keys(stream_type),
     )
     .await?;
     if files.is_empty() {
@@ -264,6 +265,8 @@ pub async fn search_parquet(
                 SearchType::Normal
             },
             work_group: Some(work_group.to_string()),
+            sorted_by_time: true,
+            primary_key: primary_key.clone(),
         };
 
         // cacluate the diff between latest schema and group schema
@@ -398,6 +401,7 @@ pub async fn search_memtable(
         .unwrap_or(Schema::empty());
     let stream_settings = unwrap_stream_settings(&schema_latest).unwrap_or_default();
     let defined_schema_fields = stream_settings.defined_schema_fields.unwrap_or_default();
+    let primary_key = stream_settings.primary_key;
 
     let mut scan_stats = ScanStats::new();
 
@@ -477,6 +481,8 @@ pub async fn search_memtable(
                 SearchType::Normal
             },
             work_group: Some(work_group.to_string()),
+            sorted_by_time: true,
+            primary_key: primary_key.clone(),
         };
 
         // cacluate the diff between latest schema and group schema
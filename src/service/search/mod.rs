@@ -36,9 +36,10 @@ use regex::Regex;
 use tracing_opentelemetry::OpenTelemetrySpanExt;
 #[cfg(feature = "enterprise")]
 use {
+    crate::common::infra::tls,
     hashbrown::HashSet,
     o2_enterprise::enterprise::{common::infra::config::O2_CONFIG, search::TaskStatus},
-    tonic::{codec::CompressionEncoding, metadata::MetadataValue, transport::Channel, Request},
+    tonic::{codec::CompressionEncoding, metadata::MetadataValue, Request},
     tracing::{info_span, Instrument},
 };
 #[cfg(not(feature = "enterprise"))]
@@ -48,14 +49,19 @@ use super::usage::report_request_usage_stats;
 use crate::{
     common::{infra::cluster as infra_cluster, meta::stream::StreamParams},
     handler::grpc::request::search::intra_cluster::Searcher,
-    service::format_partition_key,
+    service::{format_partition_key, residency},
 };
 
+pub mod access;
 pub mod cache;
 pub(crate) mod cluster;
+pub mod cursor;
 pub(crate) mod datafusion;
+pub mod federated;
+pub mod field_access;
 pub(crate) mod grpc;
 pub(crate) mod sql;
+pub mod table;
 
 pub static SEARCH_SERVER: Lazy<Searcher> = Lazy::new(Searcher::new);
 
@@ -88,6 +94,24 @@ pub async fn search(
         trace_id.to_string()
     };
 
+    // the data of an org pinned to another region must not leave it
+    if let Err(e) = residency::check_local(org_id) {
+        return Err(Error::ErrorCode(ErrorCodes::SearchRegionNotAllowed(e)));
+    }
+
+    // the fields the role of the user can't see can't be queried either
+    // the internal searches, run without a user, see every field
+    let hidden_fields = match user_id.as_deref() {
+        Some(user_id) => {
+            let stream_name = config::meta::sql::Sql::new(&in_req.query.sql)
+                .map(|v| v.source)
+                .unwrap_or_default();
+            field_access::HiddenFields::load(org_id, stream_type, &stream_name, user_id).await
+        }
+        None => field_access::HiddenFields::default(),
+    };
+    hidden_fields.check(in_req)?;
+
     #[cfg(feature = "enterprise")]
     {
         let sql = Some(in_req.query.sql.clone());
@@ -112,7 +136,13 @@ pub async fn search(
     }
 
     #[cfg(feature = "enterprise")]
-    let req_regions = in_req.regions.clone();
+    let req_regions = match residency::pin_regions(org_id, &in_req.regions) {
+        Ok(regions) => regions,
+        Err(e) => {
+            SEARCH_SERVER.remove(&trace_id).await;
+            return Err(Error::ErrorCode(ErrorCodes::SearchRegionNotAllowed(e)));
+        }
+    };
     #[cfg(feature = "enterprise")]
     let req_clusters = in_req.clusters.clone();
     #[cfg(feature = "enterprise")]
@@ -148,7 +178,8 @@ pub async fn search(
 
     // do this because of clippy warning
     match res {
-        Ok(res) => {
+        Ok(mut res) => {
+            hidden_fields.filter(&mut res);
             let time = start.elapsed().as_secs_f64();
             let (report_usage, search_type) = match in_req.search_type {
                 Some(search_type) => match search_type {
@@ -163,14 +194,16 @@ pub async fn search(
                 None => (false, None),
             };
 
+            let stream_name = match config::meta::sql::Sql::new(&req_query.sql) {
+                Ok(v) => v.source.to_string(),
+                Err(e) => {
+                    log::error!("search: parse sql error: {:?}", e);
+                    "".to_string()
+                }
+            };
+            super::stream_access::record(org_id, stream_type, &stream_name);
+
             if report_usage {
-                let stream_name = match config::meta::sql::Sql::new(&req_query.sql) {
-                    Ok(v) => v.source.to_string(),
-                    Err(e) => {
-                        log::error!("report_usage: parse sql error: {:?}", e);
-                        "".to_string()
-                    }
-                };
                 let req_stats = RequestStats {
                     records: res.hits.len() as i64,
                     response_time: time,
@@ -181,6 +214,7 @@ pub async fn search(
                     max_ts: Some(req_query.end_time),
                     cached_ratio: Some(res.cached_ratio),
                     search_type,
+                    search_event_context: in_req.search_event_context.clone(),
                     trace_id: Some(trace_id),
                     took_wait_in_queue: if res.took_detail.is_some() {
                         let resp_took = res.took_detail.as_ref().unwrap();
@@ -239,7 +273,7 @@ pub async fn search_partition(
         &meta,
         stream_type,
         partition_time_level,
-        &stream_settings.partition_keys,
+        &stream_settings.search_partition_keys(stream_type),
     )
     .await;
 
@@ -346,7 +380,7 @@ pub async fn query_status() -> Result<search::QueryStatusResponse, Error> {
                 let token: MetadataValue<_> = infra_cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -494,7 +528,7 @@ pub async fn cancel_query(trace_id: &str) -> Result<search::CancelQueryResponse,
                 let token: MetadataValue<_> = infra_cluster::get_internal_grpc_token()
                     .parse()
                     .map_err(|_| Error::Message("invalid token".to_string()))?;
-                let channel = Channel::from_shared(node_addr)
+                let channel = tls::grpc_endpoint(node_addr)
                     .unwrap()
                     .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
                     .connect()
@@ -25,6 +25,7 @@ use config::{
         sql::{Sql as MetaSql, SqlOperator},
         stream::{FileKey, StreamPartition, StreamPartitionType, StreamType},
     },
+    utils::time::query_now_micros,
     QUICK_MODEL_FIELDS,
 };
 use datafusion::arrow::datatypes::{DataType, Schema};
@@ -127,7 +128,7 @@ impl Sql {
 
         let mut req_time_range = (req_query.start_time, req_query.end_time);
         if req_time_range.1 == 0 {
-            req_time_range.1 = chrono::Utc::now().timestamp_micros();
+            req_time_range.1 = query_now_micros();
         }
 
         // parse sql
@@ -140,6 +141,8 @@ impl Sql {
             origin_sql.pop();
         }
         origin_sql = split_sql_token(&origin_sql).join("");
+        // resolve the paths into nested fields, like `field.sub[0].name`
+        origin_sql = search::datafusion::rewrite::rewrite_path_access(&origin_sql);
         let mut meta = match MetaSql::new(&origin_sql) {
             Ok(meta) => meta,
             Err(err) => {
@@ -443,7 +446,13 @@ impl Sql {
 
         // fetch fts fields
         let mut fts_terms = HashSet::new();
-        let fts_fields = get_stream_setting_fts_fields(&schema);
+        let mut fts_fields = get_stream_setting_fts_fields(&schema);
+        // match_all must not find records by the fields hidden from the user
+        if let Some(user_id) = req.user_id.as_deref() {
+            super::field_access::HiddenFields::load(&org_id, stream_type, &meta.source, user_id)
+                .await
+                .remove_from(&mut fts_fields);
+        }
 
         // Hack for quick_mode
         // replace `select *` to `select f1,f2,f3`
@@ -501,7 +510,9 @@ impl Sql {
                     fulltext.push((cap[0].to_string(), cap[1].to_lowercase()));
                 }
                 for cap in RE_MATCH_ALL_INDEXED.captures_iter(token) {
-                    indexed_text.push((cap[0].to_string(), cap[1].to_lowercase())); // since `terms` are indexed in lowercase
+                    indexed_text.push((cap[0].to_string(), cap[1].to_lowercase())); // since `terms`
+                                                                                    // are indexed
+                                                                                    // in lowercase
                 }
             }
         }
@@ -1108,6 +1119,7 @@ mod tests {
             clusters: vec![],
             timeout: 0,
             search_type: None,
+            search_event_context: None,
         };
 
         let mut rpc_req: cluster_rpc::SearchRequest = req.to_owned().into();
@@ -1219,6 +1231,7 @@ mod tests {
                 clusters: vec![],
                 timeout: 0,
                 search_type: None,
+                search_event_context: None,
             };
             let mut rpc_req: cluster_rpc::SearchRequest = req.to_owned().into();
             rpc_req.org_id = org_id.to_string();
@@ -1343,6 +1356,7 @@ mod tests {
                 clusters: vec![],
                 timeout: 0,
                 search_type: None,
+                search_event_context: None,
             };
             let mut rpc_req: cluster_rpc::SearchRequest = req.to_owned().into();
             rpc_req.org_id = org_id.to_string();
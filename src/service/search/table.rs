@@ -0,0 +1,353 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Server side sorting and pagination of table panels.
+//!
+//! The sort of the table replaces the ORDER BY of its query and the pages are
+//! fetched with `from`/`size`, so the browser only ever holds one page. The
+//! cursor carries the offset of the next page with a key of the query it was
+//! issued for, a cursor can't be reused after the sort or the query changed.
+//! The conditional formatting rules of the panel are evaluated on the page.
+
+use config::{
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        stream::StreamType,
+    },
+    utils::{
+        base64,
+        hash::{gxhash, Sum64},
+        json::{self, Value},
+    },
+};
+use once_cell::sync::Lazy;
+use regex::Regex;
+use serde::{Deserialize, Serialize};
+use sqlparser::{ast::Statement, dialect::GenericDialect, parser::Parser};
+
+use crate::common::meta::{
+    dashboards::v4::{TableRule, TableRuleOperator, TableRuleScope},
+    table_query::{
+        CellFormat, RowFormat, TableQueryRequest, TableQueryResponse, TableSort, MAX_PAGE_SIZE,
+    },
+};
+
+/// `${row.field.name}` or `${row.field["name"]}`, `${row.index}`
+static RE_LINK_VARIABLE: Lazy<Regex> =
+    Lazy::new(|| Regex::new(r#"\$\{row\.(?:field\.(\w+)|field\["([^"]+)"\]|(index))\}"#).unwrap());
+
+#[derive(Clone, Debug, Default, PartialEq, Eq, Serialize, Deserialize)]
+pub struct TableCursor {
+    /// offset of the next page
+    pub from: i64,
+    /// key of the query and sort the cursor was issued for
+    pub key: u64,
+}
+
+impl TableCursor {
+    pub fn encode(&self) -> String {
+        base64::encode_url(&json::to_string(self).unwrap())
+    }
+
+    pub fn decode(s: &str) -> Result<Self, String> {
+        base64::decode_url(s)
+            .map_err(|e| e.to_string())
+            .and_then(|v| json::from_str(&v).map_err(|e| e.to_string()))
+            .map_err(|e| format!("invalid cursor: {e}"))
+    }
+}
+
+pub async fn search(
+    trace_id: &str,
+    org_id: &str,
+    stream_type: StreamType,
+    user_id: Option<String>,
+    table: &TableQueryRequest,
+) -> Result<TableQueryResponse, anyhow::Error> {
+    if table.size <= 0 || table.size > MAX_PAGE_SIZE {
+        return Err(anyhow::anyhow!(
+            "size should be between 1 and {MAX_PAGE_SIZE}"
+        ));
+    }
+    let sql = sort_sql(&table.sql, &table.sort)?;
+    let key = query_key(&sql, table.start_time, table.end_time);
+    let from = match table.cursor.as_deref() {
+        None | Some("") => 0,
+        Some(cursor) => {
+            let cursor = TableCursor::decode(cursor).map_err(|e| anyhow::anyhow!(e))?;
+            if cursor.key != key {
+                return Err(anyhow::anyhow!(
+                    "the cursor was issued for another query or sort"
+                ));
+            }
+            cursor.from
+        }
+    };
+
+    // one more row tells if there is a next page
+    let req = Request {
+        query: Query {
+            sql,
+            from,
+            size: table.size + 1,
+            start_time: table.start_time,
+            end_time: table.end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: Default::default(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Dashboards),
+        search_event_context: None,
+    };
+    let res = super::search(trace_id, org_id, stream_type, user_id, &req)
+        .await
+        .map_err(|e| anyhow::anyhow!(e.to_string()))?;
+
+    let mut hits = res.hits;
+    let next_cursor = if hits.len() as i64 > table.size {
+        hits.truncate(table.size as usize);
+        Some(
+            TableCursor {
+                from: from + table.size,
+                key,
+            }
+            .encode(),
+        )
+    } else {
+        None
+    };
+    let formats = format_rows(&hits, from, &table.rules);
+    Ok(TableQueryResponse {
+        took: res.took,
+        hits,
+        formats,
+        from,
+        next_cursor,
+    })
+}
+
+/// Replaces the ORDER BY of the query with the sort of the table
+pub fn sort_sql(sql: &str, sort: &[TableSort]) -> Result<String, anyhow::Error> {
+    if sort.is_empty() {
+        return Ok(sql.to_string());
+    }
+    let mut statements = Parser::parse_sql(&GenericDialect {}, sql)?;
+    let [Statement::Query(query)] = statements.as_mut_slice() else {
+        return Err(anyhow::anyhow!("only a single SELECT query can be sorted"));
+    };
+    if query.limit.is_some() || query.offset.is_some() {
+        return Err(anyhow::anyhow!(
+            "LIMIT/OFFSET in sql can't be paginated, use size"
+        ));
+    }
+    query.order_by.clear();
+    let mut order_by = Vec::with_capacity(sort.len());
+    for item in sort.iter() {
+        let column = item.column.trim();
+        if column.is_empty() {
+            return Err(anyhow::anyhow!("sort column should not be empty"));
+        }
+        let direction = if item.desc { "DESC" } else { "ASC" };
+        order_by.push(format!("\"{}\" {direction}", column.replace('"', "\"\"")));
+    }
+    Ok(format!("{query} ORDER BY {}", order_by.join(", ")))
+}
+
+fn query_key(sql: &str, start_time: i64, end_time: i64) -> u64 {
+    gxhash::new().sum64(&format!("{sql}/{start_time}/{end_time}"))
+}
+
+/// Evaluates the formatting rules on the rows, empty when no row is formatted.
+/// `from` is the offset of the first row, for `${row.index}`.
+pub fn format_rows(hits: &[Value], from: i64, rules: &[TableRule]) -> Vec<RowFormat> {
+    if rules.is_empty() {
+        return vec![];
+    }
+    let formats = hits
+        .iter()
+        .enumerate()
+        .map(|(i, hit)| format_row(hit, from + i as i64, rules))
+        .collect::<Vec<_>>();
+    if formats.iter().all(|f| f.is_empty()) {
+        return vec![];
+    }
+    formats
+}
+
+fn format_row(hit: &Value, index: i64, rules: &[TableRule]) -> RowFormat {
+    let mut format = RowFormat::default();
+    for rule in rules.iter() {
+        let taken = match rule.scope {
+            TableRuleScope::Row => format.row.is_some(),
+            TableRuleScope::Cell => format.cells.contains_key(&rule.column),
+        };
+        if taken {
+            continue;
+        }
+        let Some(cell) = hit.get(&rule.column) else {
+            continue;
+        };
+        if !matches(rule.operator, cell, &rule.value) {
+            continue;
+        }
+        let cell_format = CellFormat {
+            background_color: rule.background_color.clone(),
+            text_color: rule.text_color.clone(),
+            link: rule
+                .link
+                .as_deref()
+                .map(|link| render_link(link, hit, index)),
+        };
+        match rule.scope {
+            TableRuleScope::Row => format.row = Some(cell_format),
+            TableRuleScope::Cell => {
+                format.cells.insert(rule.column.clone(), cell_format);
+            }
+        }
+    }
+    format
+}
+
+/// Compares numbers when both sides are numbers, the text otherwise
+fn matches(operator: TableRuleOperator, cell: &Value, value: &str) -> bool {
+    let text = match cell {
+        Value::Null => return false,
+        Value::String(s) => s.to_string(),
+        v => v.to_string(),
+    };
+    let number = cell.as_f64().or_else(|| text.trim().parse::<f64>().ok());
+    let expected = value.trim().parse::<f64>().ok();
+    match (operator, number, expected) {
+        (TableRuleOperator::Gt, Some(a), Some(b)) => a > b,
+        (TableRuleOperator::Gte, Some(a), Some(b)) => a >= b,
+        (TableRuleOperator::Lt, Some(a), Some(b)) => a < b,
+        (TableRuleOperator::Lte, Some(a), Some(b)) => a <= b,
+        (TableRuleOperator::Eq, Some(a), Some(b)) => a == b,
+        (TableRuleOperator::Ne, Some(a), Some(b)) => a != b,
+        (TableRuleOperator::Eq, ..) => text == value,
+        (TableRuleOperator::Ne, ..) => text != value,
+        (TableRuleOperator::Contains, ..) => text.contains(value),
+        _ => false,
+    }
+}
+
+/// Fills the link with the url encoded values of the row
+fn render_link(link: &str, hit: &Value, index: i64) -> String {
+    RE_LINK_VARIABLE
+        .replace_all(link, |caps: &regex::Captures| {
+            if caps.get(3).is_some() {
+                return index.to_string();
+            }
+            let field = caps.get(1).or_else(|| caps.get(2)).unwrap().as_str();
+            let value = match hit.get(field) {
+                Some(Value::String(s)) => s.to_string(),
+                Some(Value::Null) | None => String::new(),
+                Some(v) => v.to_string(),
+            };
+            url::form_urlencoded::byte_serialize(value.as_bytes()).collect::<String>()
+        })
+        .to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rule(column: &str, operator: TableRuleOperator, value: &str) -> TableRule {
+        TableRule {
+            column: column.to_string(),
+            operator,
+            value: value.to_string(),
+            scope: TableRuleScope::Cell,
+            background_color: Some("red".to_string()),
+            text_color: None,
+            link: None,
+        }
+    }
+
+    #[test]
+    fn test_sort_sql() {
+        let sort = vec![
+            TableSort {
+                column: "cnt".to_string(),
+                desc: true,
+            },
+            TableSort {
+                column: "host".to_string(),
+                desc: false,
+            },
+        ];
+        assert_eq!(
+            sort_sql(
+                "SELECT host, count(*) AS cnt FROM t GROUP BY host ORDER BY host",
+                &sort
+            )
+            .unwrap(),
+            "SELECT host, count(*) AS cnt FROM t GROUP BY host ORDER BY \"cnt\" DESC, \"host\" ASC"
+        );
+        assert_eq!(sort_sql("select * from t", &[]).unwrap(), "select * from t");
+        assert!(sort_sql("select * from t limit 10", &sort).is_err());
+    }
+
+    #[test]
+    fn test_cursor() {
+        let cursor = TableCursor {
+            from: 200,
+            key: query_key("select * from t", 1, 2),
+        };
+        assert_eq!(TableCursor::decode(&cursor.encode()).unwrap(), cursor);
+        assert_ne!(cursor.key, query_key("select * from t", 1, 3));
+        assert!(TableCursor::decode("not a cursor").is_err());
+    }
+
+    #[test]
+    fn test_format_rows() {
+        let hits = vec![
+            json::json!({"host": "a b", "latency": 950, "status": "error"}),
+            json::json!({"host": "c", "latency": "120", "status": "ok"}),
+        ];
+        let mut row_rule = rule("status", TableRuleOperator::Eq, "error");
+        row_rule.scope = TableRuleScope::Row;
+        let mut link_rule = rule("host", TableRuleOperator::Contains, "");
+        link_rule.background_color = None;
+        link_rule.link = Some("/logs?host=${row.field.host}&i=${row.index}".to_string());
+        let rules = vec![
+            rule("latency", TableRuleOperator::Gt, "900"),
+            rule("latency", TableRuleOperator::Gt, "100"),
+            row_rule,
+            link_rule,
+        ];
+        let formats = format_rows(&hits, 10, &rules);
+        assert_eq!(formats.len(), 2);
+        // the first matching rule of a cell applies
+        assert_eq!(
+            formats[0].cells["latency"].background_color.as_deref(),
+            Some("red")
+        );
+        assert!(formats[0].row.is_some());
+        assert_eq!(
+            formats[0].cells["host"].link.as_deref(),
+            Some("/logs?host=a+b&i=10")
+        );
+        assert!(formats[1].row.is_none());
+        assert!(formats[1].cells.contains_key("latency"));
+
+        assert!(format_rows(&hits, 0, &[rule("latency", TableRuleOperator::Lt, "1")]).is_empty());
+    }
+}
@@ -0,0 +1,542 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Search jobs surviving the restart of the querier running them.
+//!
+//! A job is split into partitions of its time range, searched newest first,
+//! and the hits of every partition are saved to the object storage, the meta
+//! store only keeps the progress of the job. The querier running a job is
+//! recorded in it, when that querier leaves the cluster another querier takes
+//! the job over and resumes it from the first partition not saved. The hits
+//! saved so far are returned while the job runs, so a client always gets
+//! partial results.
+//!
+//! The queries with aggregations or not sorted by time can't be split, they
+//! run as one partition and start over when they are taken over.
+//!
+//! A job is only visible to its creator and the admins of the organization,
+//! and the finished jobs are deleted after ZO_SEARCH_JOB_RETENTION hours.
+
+use std::{
+    collections::{HashMap, HashSet},
+    io::Error,
+};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{
+    cluster::{self, LOCAL_NODE_UUID},
+    get_config,
+    meta::{
+        cluster::NodeStatus,
+        search::{Query, Request, RequestEncoding, SearchEventType, SearchPartitionRequest},
+        sql::Sql,
+    },
+    utils::json,
+};
+use infra::dist_lock;
+use once_cell::sync::Lazy;
+use tokio::sync::Mutex;
+
+use super::{
+    db,
+    search::{self as SearchService, access, cache::result_utils::is_aggregate_query},
+    users,
+};
+use crate::common::{
+    infra::cluster as infra_cluster,
+    meta::{
+        http::HttpResponse as MetaHttpResponse,
+        search_jobs::{SearchJob, SearchJobList, SearchJobRequest, SearchJobStatus},
+    },
+};
+
+/// Jobs running on this querier
+static RUNNING: Lazy<Mutex<HashSet<String>>> = Lazy::new(Default::default);
+
+#[tracing::instrument(skip(req))]
+pub async fn create_job(
+    org_id: &str,
+    user_email: &str,
+    req: SearchJobRequest,
+) -> Result<HttpResponse, Error> {
+    if req.start_time <= 0 || req.end_time <= req.start_time {
+        return Ok(MetaHttpResponse::bad_request("Invalid time range"));
+    }
+    if req.size <= 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "size should be greater than 0",
+        ));
+    }
+    let stream_name = match Sql::new(&req.sql) {
+        Ok(sql) => sql.source,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    if infra::schema::get(org_id, &stream_name, req.stream_type)
+        .await
+        .map(|schema| schema.fields().is_empty())
+        .unwrap_or(true)
+    {
+        return Ok(MetaHttpResponse::not_found(format!(
+            "Stream {stream_name} not found"
+        )));
+    }
+    if !access::can_read(org_id, user_email, req.stream_type, &stream_name).await {
+        return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+    }
+
+    let now = Utc::now().timestamp_micros();
+    let job = SearchJob {
+        id: config::ider::uuid(),
+        org_id: org_id.to_string(),
+        stream_type: req.stream_type,
+        sql: req.sql,
+        start_time: req.start_time,
+        end_time: req.end_time,
+        size: std::cmp::min(req.size, get_config().limit.search_job_max_hits),
+        status: SearchJobStatus::Pending,
+        created_by: user_email.to_string(),
+        created_at: now,
+        updated_at: now,
+        ..Default::default()
+    };
+    let ret = match db::search_jobs::set(&job).await {
+        Ok(_) => db::search_jobs::set_active(&job.org_id, &job.id).await,
+        Err(e) => Err(e),
+    };
+    match ret {
+        Ok(_) => {
+            log::info!(
+                "[SEARCH JOB] job {}/{} on stream {} created by {}",
+                job.org_id,
+                job.id,
+                stream_name,
+                user_email
+            );
+            Ok(MetaHttpResponse::json(job))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Lists the jobs of the user, every job of the organization for the admins,
+/// without their hits, which are returned by [`get_job`]
+#[tracing::instrument]
+pub async fn list_jobs(org_id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let is_admin = users::is_admin(org_id, user_id).await;
+    match db::search_jobs::list(org_id).await {
+        Ok(mut list) => {
+            list.retain(|job| is_admin || job.created_by == user_id);
+            Ok(MetaHttpResponse::json(SearchJobList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Returns the job with the hits saved so far
+#[tracing::instrument]
+pub async fn get_job(org_id: &str, id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let mut job = match get_visible(org_id, id, user_id).await {
+        Some(job) => job,
+        None => return Ok(MetaHttpResponse::not_found("Search job not found")),
+    };
+    match load_hits(&job).await {
+        Ok(hits) => job.hits = hits,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    }
+    Ok(MetaHttpResponse::json(job))
+}
+
+/// Deletes the job, a running job stops after its current partition
+#[tracing::instrument]
+pub async fn delete_job(org_id: &str, id: &str, user_id: &str) -> Result<HttpResponse, Error> {
+    let Some(job) = get_visible(org_id, id, user_id).await else {
+        return Ok(MetaHttpResponse::not_found("Search job not found"));
+    };
+    match remove(&job).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Search job deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// The job, when the user created it or is an admin, the jobs of the other
+/// users are not found
+async fn get_visible(org_id: &str, id: &str, user_id: &str) -> Option<SearchJob> {
+    let job = db::search_jobs::get(org_id, id).await.ok()?;
+    if job.created_by == user_id || users::is_admin(org_id, user_id).await {
+        Some(job)
+    } else {
+        None
+    }
+}
+
+/// Deletes the job and its hits
+async fn remove(job: &SearchJob) -> Result<(), anyhow::Error> {
+    let (org_id, id) = (&job.org_id, &job.id);
+    let locker = dist_lock::lock(&lock_key(org_id, id), 0).await?;
+    let ret = async {
+        db::search_jobs::delete(org_id, id).await?;
+        db::search_jobs::delete_active(org_id, id).await?;
+        delete_hits(job).await
+    }
+    .await;
+    if let Err(e) = dist_lock::unlock(&locker).await {
+        log::error!("[SEARCH JOB] unlock job {org_id}/{id} error: {}", e);
+    }
+    ret
+}
+
+/// Deletes the jobs finished for longer than ZO_SEARCH_JOB_RETENTION hours,
+/// returns how many
+pub async fn delete_expired() -> Result<usize, anyhow::Error> {
+    let expired_before =
+        Utc::now().timestamp_micros() - get_config().limit.search_job_retention * 3_600_000_000;
+    let mut deleted = 0;
+    for job in db::search_jobs::list("").await? {
+        if job.is_done() && job.updated_at < expired_before {
+            remove(&job).await?;
+            deleted += 1;
+        }
+    }
+    Ok(deleted)
+}
+
+/// Starts the jobs waiting for a querier, up to ZO_SEARCH_JOB_MAX_RUNNING on
+/// this querier
+pub async fn run() -> Result<(), anyhow::Error> {
+    let max_running = get_config().limit.search_job_max_running;
+    for (org_id, id) in db::search_jobs::list_active().await? {
+        let Ok(job) = db::search_jobs::get(&org_id, &id).await else {
+            // deleted meanwhile
+            continue;
+        };
+        if job.is_done() || !is_orphan(&job).await {
+            continue;
+        }
+        {
+            let running = RUNNING.lock().await;
+            if running.len() >= max_running {
+                break;
+            }
+            if running.contains(&job.id) {
+                continue;
+            }
+        }
+        let Some(job) = claim(&job.org_id, &job.id).await? else {
+            continue;
+        };
+        RUNNING.lock().await.insert(job.id.clone());
+        tokio::task::spawn(async move {
+            let (org_id, id) = (job.org_id.clone(), job.id.clone());
+            if let Err(e) = execute(job).await {
+                log::error!("[SEARCH JOB] job {org_id}/{id} error: {}", e);
+            }
+            RUNNING.lock().await.remove(&id);
+        });
+    }
+    Ok(())
+}
+
+/// Whether the job waits for a querier: it never started, it was released or
+/// its querier left the cluster
+async fn is_orphan(job: &SearchJob) -> bool {
+    if job.node.is_empty() {
+        return true;
+    }
+    if job.node == *LOCAL_NODE_UUID {
+        return !RUNNING.lock().await.contains(&job.id);
+    }
+    !matches!(
+        infra_cluster::get_node_by_uuid(&job.node).await,
+        Some(node) if node.status == NodeStatus::Online
+    )
+}
+
+/// Makes this querier run the job, returns `None` when another querier
+/// claimed it first
+async fn claim(org_id: &str, id: &str) -> Result<Option<SearchJob>, anyhow::Error> {
+    let locker = dist_lock::lock(&lock_key(org_id, id), 0).await?;
+    let ret = async {
+        let Ok(mut job) = db::search_jobs::get(org_id, id).await else {
+            return Ok(None);
+        };
+        if job.is_done() || !is_orphan(&job).await {
+            return Ok(None);
+        }
+        if !job.node.is_empty() && job.node != *LOCAL_NODE_UUID {
+            log::warn!(
+                "[SEARCH JOB] job {org_id}/{id} taken over from querier {} at partition {}/{}",
+                job.node,
+                job.completed_partitions,
+                job.partitions.len()
+            );
+            job.failovers += 1;
+        }
+        job.node = LOCAL_NODE_UUID.clone();
+        job.status = SearchJobStatus::Running;
+        job.updated_at = Utc::now().timestamp_micros();
+        db::search_jobs::set(&job).await?;
+        Ok(Some(job))
+    }
+    .await;
+    dist_lock::unlock(&locker).await?;
+    ret
+}
+
+/// Searches the partitions not saved yet, saving the hits after each one
+async fn execute(mut job: SearchJob) -> Result<(), anyhow::Error> {
+    if job.partitions.is_empty() {
+        match plan_partitions(&job).await {
+            Ok(partitions) => job.partitions = partitions,
+            Err(e) => {
+                job.status = SearchJobStatus::Failed;
+                job.error = e.to_string();
+                save(&mut job).await?;
+                return Ok(());
+            }
+        }
+        job.partial = true;
+        if !save(&mut job).await? {
+            return Ok(());
+        }
+    }
+
+    while job.completed_partitions < job.partitions.len() && (job.hits_count as i64) < job.size {
+        // let another querier resume it instead of waiting for the shutdown
+        if cluster::is_offline() {
+            job.node = "".to_string();
+            job.status = SearchJobStatus::Pending;
+            save(&mut job).await?;
+            return Ok(());
+        }
+        let [start_time, end_time] = job.partitions[job.completed_partitions];
+        let ret = match search(&job, start_time, end_time).await {
+            // the hits are saved before the progress, a querier resuming the
+            // job after a failure in between overwrites them
+            Ok(res) => save_hits(&job, job.completed_partitions, &res.hits)
+                .await
+                .map(|_| res),
+            Err(e) => Err(e),
+        };
+        match ret {
+            Ok(res) => {
+                job.hits_count += res.hits.len();
+                job.total += res.total;
+                job.scan_size += res.scan_size;
+                job.scan_records += res.scan_records;
+                job.completed_partitions += 1;
+            }
+            // the hits of the partitions searched before are kept
+            Err(e) => {
+                job.status = SearchJobStatus::Failed;
+                job.error = e.to_string();
+                save(&mut job).await?;
+                return Ok(());
+            }
+        }
+        if !save(&mut job).await? {
+            return Ok(());
+        }
+    }
+
+    job.hits_count = std::cmp::min(job.hits_count, job.size as usize);
+    job.status = SearchJobStatus::Completed;
+    job.partial = false;
+    save(&mut job).await?;
+    log::info!(
+        "[SEARCH JOB] job {}/{} completed with {} hits",
+        job.org_id,
+        job.id,
+        job.hits_count
+    );
+    Ok(())
+}
+
+/// Saves the progress of the job, returns false when the job was deleted or
+/// taken over by another querier meanwhile, then it must stop. A finished job
+/// leaves the active jobs.
+async fn save(job: &mut SearchJob) -> Result<bool, anyhow::Error> {
+    let locker = dist_lock::lock(&lock_key(&job.org_id, &job.id), 0).await?;
+    let ret = async {
+        match db::search_jobs::get(&job.org_id, &job.id).await {
+            Ok(current) if current.node == *LOCAL_NODE_UUID => {}
+            Ok(current) => {
+                log::warn!(
+                    "[SEARCH JOB] job {}/{} is now run by querier {}, stopping",
+                    job.org_id,
+                    job.id,
+                    current.node
+                );
+                return Ok(false);
+            }
+            Err(_) => {
+                log::info!(
+                    "[SEARCH JOB] job {}/{} was deleted, stopping",
+                    job.org_id,
+                    job.id
+                );
+                return Ok(false);
+            }
+        }
+        job.updated_at = Utc::now().timestamp_micros();
+        db::search_jobs::set(job).await?;
+        if job.is_done() {
+            db::search_jobs::delete_active(&job.org_id, &job.id).await?;
+        }
+        Ok(true)
+    }
+    .await;
+    dist_lock::unlock(&locker).await?;
+    ret
+}
+
+/// Splits the time range like the partitions of the UI searches, the queries
+/// which can't be split are searched as one partition
+async fn plan_partitions(job: &SearchJob) -> Result<Vec<[i64; 2]>, anyhow::Error> {
+    if !is_partitionable(&job.sql)? {
+        return Ok(vec![[job.start_time, job.end_time]]);
+    }
+    let req = SearchPartitionRequest {
+        sql: job.sql.clone(),
+        sql_mode: "full".to_string(),
+        start_time: job.start_time,
+        end_time: job.end_time,
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+    };
+    let trace_id = config::ider::uuid();
+    let resp =
+        SearchService::search_partition(&trace_id, &job.org_id, job.stream_type, &req).await?;
+    Ok(resp.partitions)
+}
+
+/// Whether the hits of the partitions, newest first, are the hits of the
+/// whole time range
+fn is_partitionable(query: &str) -> Result<bool, anyhow::Error> {
+    let sql = Sql::new(query)?;
+    let timestamp = &get_config().common.column_timestamp;
+    Ok(sql.group_by.is_empty()
+        && !sql.having
+        && sql.subquery.is_none()
+        && !query
+            .trim_start()
+            .to_lowercase()
+            .starts_with("select distinct")
+        && !is_aggregate_query(query)?
+        && sql
+            .order_by
+            .iter()
+            .all(|(field, desc)| field == timestamp && *desc))
+}
+
+async fn search(
+    job: &SearchJob,
+    start_time: i64,
+    end_time: i64,
+) -> Result<config::meta::search::Response, anyhow::Error> {
+    // the job searches as its creator, who may have lost the access since
+    let stream_name = Sql::new(&job.sql)?.source;
+    if !access::can_read(&job.org_id, &job.created_by, job.stream_type, &stream_name).await {
+        return Err(anyhow::anyhow!(
+            "{} can no longer read the stream {stream_name}",
+            job.created_by
+        ));
+    }
+    let req = Request {
+        query: Query {
+            sql: job.sql.clone(),
+            from: 0,
+            size: job.size - job.hits_count as i64,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: get_config().limit.query_timeout as i64,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    Ok(SearchService::search(
+        &trace_id,
+        &job.org_id,
+        job.stream_type,
+        Some(job.created_by.clone()),
+        &req,
+    )
+    .await?)
+}
+
+fn lock_key(org_id: &str, id: &str) -> String {
+    format!("/search_jobs/run/{org_id}/{id}")
+}
+
+/// Object storage key of the hits of a partition of the job
+fn hits_key(org_id: &str, id: &str, partition: usize) -> String {
+    format!("search_jobs/{org_id}/{id}/{partition}.json")
+}
+
+async fn save_hits(
+    job: &SearchJob,
+    partition: usize,
+    hits: &[json::Value],
+) -> Result<(), anyhow::Error> {
+    let data = json::to_vec(hits)?;
+    infra::storage::put(&hits_key(&job.org_id, &job.id, partition), data.into()).await
+}
+
+/// Returns the hits of the partitions searched so far, newest first
+async fn load_hits(job: &SearchJob) -> Result<Vec<json::Value>, anyhow::Error> {
+    let mut hits = Vec::with_capacity(job.hits_count);
+    for partition in 0..job.completed_partitions {
+        let data = infra::storage::get(&hits_key(&job.org_id, &job.id, partition)).await?;
+        hits.extend(json::from_slice::<Vec<json::Value>>(&data)?);
+    }
+    hits.truncate(job.size as usize);
+    Ok(hits)
+}
+
+/// Lists the hits instead of relying on the progress, a querier may have saved
+/// the hits of a partition without its progress
+async fn delete_hits(job: &SearchJob) -> Result<(), anyhow::Error> {
+    let keys = infra::storage::list(&format!("search_jobs/{}/{}/", job.org_id, job.id)).await?;
+    infra::storage::del(&keys.iter().map(|v| v.as_str()).collect::<Vec<_>>()).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_partitionable() {
+        let partitionable = |sql: &str| is_partitionable(sql).unwrap();
+        assert!(partitionable("SELECT * FROM default WHERE level = 'error'"));
+        assert!(partitionable(
+            "SELECT * FROM default ORDER BY _timestamp DESC"
+        ));
+        assert!(!partitionable("SELECT * FROM default ORDER BY _timestamp"));
+        assert!(!partitionable("SELECT * FROM default ORDER BY took DESC"));
+        assert!(!partitionable("SELECT count(*) FROM default"));
+        assert!(!partitionable("SELECT DISTINCT level FROM default"));
+        assert!(!partitionable(
+            "SELECT level, count(*) AS c FROM default GROUP BY level"
+        ));
+    }
+}
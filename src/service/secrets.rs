@@ -0,0 +1,333 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Secrets store for the credentials of destinations and integrations. The
+//! definitions reference a secret as `{{secret:<name>}}` and the reference is
+//! resolved every time the definition is used, so rotating a secret does not
+//! need to touch its consumers. Values are sealed with ChaCha20-Poly1305 under
+//! `ZO_SECRETS_KEY` before they reach the metadata store. A secret lists the
+//! kinds of definitions allowed to reference it, so a user who can edit one
+//! kind of definition cannot read a secret meant for another by pointing a
+//! definition they control at it.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use base64::{engine::general_purpose, Engine as _};
+use chacha20poly1305::{
+    aead::{Aead, KeyInit},
+    ChaCha20Poly1305, Key, Nonce,
+};
+use chrono::Utc;
+use config::{get_config, utils::json};
+use rand::RngCore;
+use serde::{de::DeserializeOwned, Serialize};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        secrets::{self, Secret, SecretList, SecretRequest, SECRET_CONSUMERS, SECRET_REF_PREFIX},
+    },
+    service::db,
+};
+
+const NONCE_LEN: usize = 12;
+const SEALED_PREFIX: &str = "v1:";
+
+#[tracing::instrument(skip(req))]
+pub async fn save_secret(
+    org_id: &str,
+    req: SecretRequest,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    let key = match store_key() {
+        Ok(key) => key,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let name = req.name.trim().to_string();
+    if name.is_empty()
+        || !name
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-' || c == '.')
+    {
+        return Ok(MetaHttpResponse::bad_request(
+            "Secret name can only contain letters, digits, '_', '-' and '.'",
+        ));
+    }
+    if req.value.is_empty() {
+        return Ok(MetaHttpResponse::bad_request("Secret value is empty"));
+    }
+    if let Some(consumer) = req
+        .consumers
+        .iter()
+        .find(|c| !SECRET_CONSUMERS.contains(&c.as_str()))
+    {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Unknown secret consumer {consumer}, expected one of {}",
+            SECRET_CONSUMERS.join(", ")
+        )));
+    }
+    let stored = db::secrets::get(org_id, &name).await.ok();
+    let now = Utc::now().timestamp_micros();
+    let mut secret = match stored {
+        Some(_) if create => {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Secret {name} already exists"
+            )));
+        }
+        Some(stored) => Secret {
+            version: stored.version + 1,
+            created_at: stored.created_at,
+            ..Default::default()
+        },
+        None if !create => return Ok(MetaHttpResponse::not_found("Secret not found")),
+        None => Secret {
+            version: 1,
+            created_at: now,
+            ..Default::default()
+        },
+    };
+    secret.name = name;
+    secret.description = req.description;
+    secret.consumers = req.consumers;
+    secret.updated_at = now;
+    secret.sealed_value = match seal(&key, &req.value) {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    match db::secrets::set(org_id, &secret).await {
+        Ok(_) => {
+            secret.sealed_value.clear();
+            Ok(MetaHttpResponse::json(secret))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_secrets(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::secrets::list(org_id).await {
+        Ok(mut list) => {
+            list.iter_mut().for_each(|s| s.sealed_value.clear());
+            Ok(MetaHttpResponse::json(SecretList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_secret(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::secrets::get(org_id, name).await {
+        Ok(mut secret) => {
+            secret.sealed_value.clear();
+            Ok(MetaHttpResponse::json(secret))
+        }
+        Err(_) => Ok(MetaHttpResponse::not_found("Secret not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_secret(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::secrets::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Secret not found"));
+    }
+    match db::secrets::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Secret deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Returns the secret if definitions of the `consumer` kind can reference it.
+async fn get_for(org_id: &str, consumer: &str, name: &str) -> Result<Secret, anyhow::Error> {
+    let secret = db::secrets::get(org_id, name)
+        .await
+        .map_err(|_| anyhow::anyhow!("Secret {name} not found"))?;
+    if !secret.allows(consumer) {
+        return Err(anyhow::anyhow!(
+            "Secret {name} cannot be referenced by {consumer}"
+        ));
+    }
+    Ok(secret)
+}
+
+/// Returns the plaintext value of the secret for a definition of the
+/// `consumer` kind.
+pub async fn get_value(org_id: &str, consumer: &str, name: &str) -> Result<String, anyhow::Error> {
+    let secret = get_for(org_id, consumer, name).await?;
+    unseal(&store_key()?, &secret.sealed_value)
+}
+
+/// Returns a copy of the definition with the secret references replaced by
+/// the current values of the secrets. The secrets are checked against the
+/// `consumer` kind again, so narrowing a secret takes effect on the next use.
+pub async fn resolve<T: Serialize + DeserializeOwned + Clone>(
+    org_id: &str,
+    consumer: &str,
+    item: &T,
+) -> Result<T, anyhow::Error> {
+    let mut value = json::to_value(item)?;
+    let mut names = Vec::new();
+    collect_refs(&value, &mut names);
+    if names.is_empty() {
+        return Ok(item.clone());
+    }
+    let mut values = HashMap::with_capacity(names.len());
+    for name in names {
+        let secret = get_value(org_id, consumer, &name).await?;
+        values.insert(name, secret);
+    }
+    substitute_refs(&mut value, &values)?;
+    Ok(json::from_value(value)?)
+}
+
+/// Checks that every secret referenced by the definition exists and allows
+/// the `consumer` kind, so a typo is reported when the definition is saved
+/// rather than when it is used.
+pub async fn check_refs<T: Serialize>(
+    org_id: &str,
+    consumer: &str,
+    item: &T,
+) -> Result<(), anyhow::Error> {
+    let value = json::to_value(item)?;
+    let mut names = Vec::new();
+    collect_refs(&value, &mut names);
+    for name in names {
+        get_for(org_id, consumer, &name).await?;
+    }
+    Ok(())
+}
+
+fn collect_refs(value: &json::Value, names: &mut Vec<String>) {
+    match value {
+        json::Value::String(s) if s.contains(SECRET_REF_PREFIX) => {
+            for name in secrets::referenced_names(s) {
+                if !names.iter().any(|n| n == name) {
+                    names.push(name.to_string());
+                }
+            }
+        }
+        json::Value::Array(items) => items.iter().for_each(|v| collect_refs(v, names)),
+        json::Value::Object(map) => map.values().for_each(|v| collect_refs(v, names)),
+        _ => {}
+    }
+}
+
+fn substitute_refs(
+    value: &mut json::Value,
+    values: &HashMap<String, String>,
+) -> Result<(), anyhow::Error> {
+    match value {
+        json::Value::String(s) if s.contains(SECRET_REF_PREFIX) => {
+            *s = secrets::substitute(s, |name| {
+                values
+                    .get(name)
+                    .cloned()
+                    .ok_or_else(|| anyhow::anyhow!("Secret {name} not found"))
+            })?;
+        }
+        json::Value::Array(items) => {
+            for v in items.iter_mut() {
+                substitute_refs(v, values)?;
+            }
+        }
+        json::Value::Object(map) => {
+            for v in map.values_mut() {
+                substitute_refs(v, values)?;
+            }
+        }
+        _ => {}
+    }
+    Ok(())
+}
+
+fn store_key() -> Result<[u8; 32], anyhow::Error> {
+    let cfg = get_config();
+    if cfg.auth.secrets_key.is_empty() {
+        return Err(anyhow::anyhow!(
+            "Secrets store is not configured, set ZO_SECRETS_KEY"
+        ));
+    }
+    let key = general_purpose::STANDARD
+        .decode(&cfg.auth.secrets_key)
+        .map_err(|_| anyhow::anyhow!("ZO_SECRETS_KEY is not valid base64"))?;
+    key.try_into()
+        .map_err(|_| anyhow::anyhow!("ZO_SECRETS_KEY must be 32 bytes"))
+}
+
+fn seal(key: &[u8; 32], plaintext: &str) -> Result<String, anyhow::Error> {
+    let cipher = ChaCha20Poly1305::new(Key::from_slice(key));
+    let mut nonce = [0u8; NONCE_LEN];
+    rand::thread_rng().fill_bytes(&mut nonce);
+    let ciphertext = cipher
+        .encrypt(Nonce::from_slice(&nonce), plaintext.as_bytes())
+        .map_err(|_| anyhow::anyhow!("Failed to seal the secret"))?;
+    let mut buf = nonce.to_vec();
+    buf.extend_from_slice(&ciphertext);
+    Ok(format!(
+        "{SEALED_PREFIX}{}",
+        general_purpose::STANDARD.encode(&buf)
+    ))
+}
+
+fn unseal(key: &[u8; 32], sealed: &str) -> Result<String, anyhow::Error> {
+    let buf = sealed
+        .strip_prefix(SEALED_PREFIX)
+        .and_then(|v| general_purpose::STANDARD.decode(v).ok())
+        .filter(|buf| buf.len() > NONCE_LEN)
+        .ok_or_else(|| anyhow::anyhow!("Sealed secret is malformed"))?;
+    let (nonce, ciphertext) = buf.split_at(NONCE_LEN);
+    let cipher = ChaCha20Poly1305::new(Key::from_slice(key));
+    let plaintext = cipher
+        .decrypt(Nonce::from_slice(nonce), ciphertext)
+        .map_err(|_| anyhow::anyhow!("Failed to unseal the secret, was ZO_SECRETS_KEY changed?"))?;
+    Ok(String::from_utf8(plaintext)?)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_seal_round_trip() {
+        let key = [7u8; 32];
+        let sealed = seal(&key, "s3cr3t").unwrap();
+        assert!(sealed.starts_with(SEALED_PREFIX));
+        assert_ne!(sealed, seal(&key, "s3cr3t").unwrap());
+        assert_eq!(unseal(&key, &sealed).unwrap(), "s3cr3t");
+        assert!(unseal(&[8u8; 32], &sealed).is_err());
+        assert!(unseal(&key, "v1:AAAA").is_err());
+    }
+
+    #[test]
+    fn test_substitute_refs() {
+        let mut value = json::json!({
+            "url": "https://hooks.example.com/{{secret:path}}",
+            "headers": {"Authorization": "Bearer {{secret:token}}"},
+            "emails": ["ops@example.com"],
+        });
+        let mut names = Vec::new();
+        collect_refs(&value, &mut names);
+        assert_eq!(names, vec!["path".to_string(), "token".to_string()]);
+
+        let values = HashMap::from([
+            ("path".to_string(), "abc".to_string()),
+            ("token".to_string(), "xyz".to_string()),
+        ]);
+        substitute_refs(&mut value, &values).unwrap();
+        assert_eq!(value["url"], "https://hooks.example.com/abc");
+        assert_eq!(value["headers"]["Authorization"], "Bearer xyz");
+        assert_eq!(value["emails"][0], "ops@example.com");
+    }
+}
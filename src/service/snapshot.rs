@@ -0,0 +1,483 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Snapshots of the state of an organization, restored into another instance
+//! to reset the e2e suites to a known baseline without ingesting their data
+//! again. A snapshot keeps the streams with their settings and a sample of
+//! their latest records, the dashboards, alerts and functions.
+
+use std::{collections::HashMap, io::Error};
+
+use actix_web::{web, HttpResponse};
+use chrono::{Duration, Utc};
+use config::{
+    cluster, get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding},
+        stream::StreamType,
+    },
+    utils::json,
+};
+use infra::schema::unwrap_stream_settings;
+
+use super::{db, logs, metrics, search as SearchService};
+use crate::{
+    common::{
+        meta::{
+            alerts::destinations::Destination,
+            authz::Authz,
+            http::HttpResponse as MetaHttpResponse,
+            ingestion::IngestionRequest,
+            secrets::is_secret_ref,
+            snapshot::{
+                DashboardSnapshot, RestoreResponse, Snapshot, SnapshotRequest, StreamSnapshot,
+                MASKED_VALUE, MAX_SAMPLE_SIZE, SNAPSHOT_VERSION,
+            },
+        },
+        utils::auth::set_ownership,
+    },
+    service::{alerts, dashboards, functions, stream},
+};
+
+/// Stream types kept in the snapshots
+const STREAM_TYPES: [StreamType; 3] = [StreamType::Logs, StreamType::Metrics, StreamType::Traces];
+
+/// Headers of the destinations kept as they are in the snapshots
+const PLAIN_HEADERS: [&str; 3] = ["accept", "content-type", "user-agent"];
+
+/// Takes the snapshot of the organization
+pub async fn take(
+    org_id: &str,
+    user_id: &str,
+    req: SnapshotRequest,
+) -> Result<HttpResponse, Error> {
+    if req.sample_size < 0 || req.sample_size > MAX_SAMPLE_SIZE {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "sample_size must be between 0 and {MAX_SAMPLE_SIZE}"
+        )));
+    }
+    match snapshot(org_id, user_id, &req).await {
+        Ok(snapshot) => Ok(MetaHttpResponse::json(snapshot)),
+        Err(e) => {
+            log::error!("[SNAPSHOT] snapshot of org {org_id} failed: {e}");
+            Ok(MetaHttpResponse::internal_error(e))
+        }
+    }
+}
+
+async fn snapshot(
+    org_id: &str,
+    user_id: &str,
+    req: &SnapshotRequest,
+) -> Result<Snapshot, anyhow::Error> {
+    let mut streams = Vec::new();
+    for stream_type in STREAM_TYPES {
+        for schema in db::schema::list(org_id, Some(stream_type), true).await? {
+            if !req.streams.is_empty() && !req.streams.contains(&schema.stream_name) {
+                continue;
+            }
+            let sample = if stream_type == StreamType::Traces || req.sample_size == 0 {
+                vec![]
+            } else {
+                sample(
+                    org_id,
+                    user_id,
+                    &schema.stream_name,
+                    stream_type,
+                    req.sample_size,
+                )
+                .await?
+            };
+            streams.push(StreamSnapshot {
+                settings: unwrap_stream_settings(&schema.schema),
+                name: schema.stream_name,
+                stream_type,
+                sample,
+            });
+        }
+    }
+
+    let folders = db::dashboards::folders::list(org_id).await?;
+    let mut dashboard_list = Vec::new();
+    for folder in folders.iter() {
+        for dashboard in db::dashboards::list(org_id, &folder.folder_id).await? {
+            let data: json::Value = json::from_slice(&dashboards::to_json(&dashboard))?;
+            let Some(dashboard_id) = data.get("dashboardId").and_then(|v| v.as_str()) else {
+                continue;
+            };
+            dashboard_list.push(DashboardSnapshot {
+                folder_id: folder.folder_id.clone(),
+                dashboard_id: dashboard_id.to_string(),
+                data,
+            });
+        }
+    }
+
+    Ok(Snapshot {
+        version: SNAPSHOT_VERSION,
+        org_id: org_id.to_string(),
+        created_at: Utc::now().timestamp_micros(),
+        streams,
+        folders,
+        dashboards: dashboard_list,
+        templates: db::alerts::templates::list(org_id).await?,
+        destinations: db::alerts::destinations::list(org_id)
+            .await?
+            .into_iter()
+            .map(mask_headers)
+            .collect(),
+        alerts: db::alerts::list(org_id, None, None).await?,
+        functions: db::functions::list(org_id).await?,
+    })
+}
+
+/// Masks the values of the headers of a destination, which often carry its
+/// credentials, but the references to secrets and the headers known to be
+/// harmless
+fn mask_headers(mut destination: Destination) -> Destination {
+    if let Some(headers) = destination.headers.as_mut() {
+        for (name, value) in headers.iter_mut() {
+            if !is_secret_ref(value) && !PLAIN_HEADERS.contains(&name.to_lowercase().as_str()) {
+                *value = MASKED_VALUE.to_string();
+            }
+        }
+    }
+    destination
+}
+
+/// Puts back the values of the masked headers from the destination being
+/// replaced, drops the others and returns their names
+fn unmask_headers(destination: &mut Destination, existing: Option<&Destination>) -> Vec<String> {
+    let Some(headers) = destination.headers.as_mut() else {
+        return vec![];
+    };
+    let existing = existing.and_then(|d| d.headers.as_ref());
+    let mut dropped = vec![];
+    headers.retain(|name, value| {
+        if value != MASKED_VALUE {
+            return true;
+        }
+        match existing.and_then(|h| h.get(name)) {
+            Some(v) => {
+                *value = v.clone();
+                true
+            }
+            None => {
+                dropped.push(name.clone());
+                false
+            }
+        }
+    });
+    dropped.sort();
+    dropped
+}
+
+/// Returns the latest records of the stream, the oldest first
+async fn sample(
+    org_id: &str,
+    user_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    size: i64,
+) -> Result<Vec<json::Value>, anyhow::Error> {
+    let cfg = get_config();
+    let now = Utc::now();
+    let req = Request {
+        query: Query {
+            sql: format!("SELECT * FROM \"{stream_name}\""),
+            from: 0,
+            size,
+            start_time: (now - Duration::days(cfg.compact.data_retention_days.max(1)))
+                .timestamp_micros(),
+            end_time: now.timestamp_micros(),
+            sort_by: Some(format!("{} DESC", cfg.common.column_timestamp)),
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: None,
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    let res = SearchService::search(
+        &trace_id,
+        org_id,
+        stream_type,
+        Some(user_id.to_string()),
+        &req,
+    )
+    .await?;
+    let mut hits = res.hits;
+    hits.reverse();
+    Ok(hits)
+}
+
+/// Restores the snapshot into the organization, the objects of the snapshot
+/// replace the ones of the same name
+pub async fn restore(
+    org_id: &str,
+    user_id: &str,
+    snapshot: Snapshot,
+) -> Result<HttpResponse, Error> {
+    if snapshot.version != SNAPSHOT_VERSION {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Unsupported snapshot version {}, expected {SNAPSHOT_VERSION}",
+            snapshot.version
+        )));
+    }
+    if let Some(s) = snapshot
+        .streams
+        .iter()
+        .find(|s| s.sample.len() as i64 > MAX_SAMPLE_SIZE)
+    {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "The sample of the stream {} has more than {MAX_SAMPLE_SIZE} records",
+            s.name
+        )));
+    }
+    if snapshot.streams.iter().any(|s| !s.sample.is_empty())
+        && !cluster::is_ingester(&cluster::LOCAL_NODE_ROLE)
+    {
+        return Ok(MetaHttpResponse::bad_request(
+            "The restore of the samples needs an ingester node",
+        ));
+    }
+
+    let mut resp = RestoreResponse::default();
+    // the data first, the alerts need the schema of their stream
+    let now = Utc::now().timestamp_micros();
+    for s in snapshot.streams {
+        let id = format!("{}/{}", s.stream_type, s.name);
+        let records = s.sample.len();
+        match restore_stream(org_id, user_id, s, now).await {
+            Ok(()) => {
+                resp.streams += 1;
+                resp.records += records;
+            }
+            Err(e) => resp.errors.push(format!("stream {id}: {e}")),
+        }
+    }
+
+    for func in snapshot.functions {
+        let name = func.name.clone();
+        match functions::put_function(org_id, func).await {
+            Ok(()) => resp.functions += 1,
+            Err(e) => resp.errors.push(format!("function {name}: {e}")),
+        }
+    }
+
+    for folder in snapshot.folders {
+        let created = db::dashboards::folders::get(org_id, &folder.folder_id)
+            .await
+            .is_err();
+        let folder_id = folder.folder_id.clone();
+        match db::dashboards::folders::put(org_id, folder).await {
+            Ok(_) => {
+                if created {
+                    set_ownership(org_id, "folders", Authz::new(&folder_id)).await;
+                }
+                resp.folders += 1;
+            }
+            Err(e) => resp.errors.push(format!("folder {folder_id}: {e}")),
+        }
+    }
+    for d in snapshot.dashboards {
+        let data = json::to_vec(&d.data).unwrap();
+        match dashboards::put_dashboard(
+            org_id,
+            &d.dashboard_id,
+            &d.folder_id,
+            web::Bytes::from(data),
+        )
+        .await
+        {
+            Ok(()) => resp.dashboards += 1,
+            Err(e) => resp
+                .errors
+                .push(format!("dashboard {}/{}: {e}", d.folder_id, d.dashboard_id)),
+        }
+    }
+
+    for template in snapshot.templates {
+        let name = template.name.clone();
+        let exists = db::alerts::templates::get(org_id, &name).await.is_ok();
+        match alerts::templates::save(org_id, "", template, !exists).await {
+            Ok(()) => resp.templates += 1,
+            Err(e) => resp.errors.push(format!("template {name}: {e}")),
+        }
+    }
+    for mut destination in snapshot.destinations {
+        let name = destination.name.clone();
+        let existing = db::alerts::destinations::get(org_id, &name).await.ok();
+        let exists = existing.is_some();
+        for header in unmask_headers(&mut destination, existing.as_ref()) {
+            resp.warnings.push(format!(
+                "destination {name}: the value of the header {header} was masked in the \
+                 snapshot, it needs to be set again"
+            ));
+        }
+        match alerts::destinations::save(org_id, "", destination, !exists).await {
+            Ok(()) => resp.destinations += 1,
+            Err((_, e)) => resp.errors.push(format!("destination {name}: {e}")),
+        }
+    }
+    for alert in snapshot.alerts {
+        let id = format!("{}/{}/{}", alert.stream_type, alert.stream_name, alert.name);
+        let exists = matches!(
+            db::alerts::get(org_id, alert.stream_type, &alert.stream_name, &alert.name).await,
+            Ok(Some(_))
+        );
+        let stream_name = alert.stream_name.clone();
+        match alerts::save(org_id, &stream_name, "", alert, !exists).await {
+            Ok(()) => resp.alerts += 1,
+            Err(e) => resp.errors.push(format!("alert {id}: {e}")),
+        }
+    }
+
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// Ingests the sample of the stream, moved to end at `now`, then stores its
+/// settings
+async fn restore_stream(
+    org_id: &str,
+    user_id: &str,
+    mut s: StreamSnapshot,
+    now: i64,
+) -> Result<(), anyhow::Error> {
+    if !s.sample.is_empty() {
+        rebase(&mut s.sample, &get_config().common.column_timestamp, now);
+        let data = web::Bytes::from(json::to_vec(&s.sample)?);
+        let ret = match s.stream_type {
+            StreamType::Logs => {
+                logs::ingest::ingest(
+                    org_id,
+                    &s.name,
+                    IngestionRequest::JSON(&data),
+                    user_id,
+                    true,
+                )
+                .await?
+            }
+            StreamType::Metrics => metrics::json::ingest(org_id, data).await?,
+            _ => {
+                return Err(anyhow::anyhow!(
+                    "samples of {} are not supported",
+                    s.stream_type
+                ))
+            }
+        };
+        if ret.code != 200 {
+            return Err(anyhow::anyhow!(
+                "ingest error: {}",
+                ret.error.unwrap_or_default()
+            ));
+        }
+    }
+    if let Some(settings) = s.settings {
+        let res = stream::save_stream_settings(org_id, &s.name, s.stream_type, settings).await?;
+        if !res.status().is_success() {
+            return Err(anyhow::anyhow!(
+                "settings rejected with status {}",
+                res.status()
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// Moves the timestamps of the records so the latest one is at `now`, keeping
+/// the time between them, as the ingestion rejects the records too old
+fn rebase(records: &mut [json::Value], column: &str, now: i64) {
+    let Some(latest) = records
+        .iter()
+        .filter_map(|r| r.get(column).and_then(|v| v.as_i64()))
+        .max()
+    else {
+        return;
+    };
+    let offset = now - latest;
+    for record in records.iter_mut() {
+        if let Some(ts) = record.get(column).and_then(|v| v.as_i64()) {
+            record[column] = (ts + offset).into();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rebase() {
+        let mut records = vec![
+            json::json!({"_timestamp": 100, "a": 1}),
+            json::json!({"_timestamp": 250, "a": 2}),
+            json::json!({"a": 3}),
+        ];
+        rebase(&mut records, "_timestamp", 1_000);
+        assert_eq!(records[0]["_timestamp"], 850);
+        assert_eq!(records[1]["_timestamp"], 1_000);
+        assert!(records[2].get("_timestamp").is_none());
+
+        let mut records = vec![json::json!({"a": 1})];
+        rebase(&mut records, "_timestamp", 1_000);
+        assert_eq!(records[0], json::json!({"a": 1}));
+    }
+
+    #[test]
+    fn test_snapshot_defaults() {
+        let snapshot: Snapshot =
+            json::from_str(r#"{"version": 1, "org_id": "default", "created_at": 0}"#).unwrap();
+        assert!(snapshot.streams.is_empty() && snapshot.alerts.is_empty());
+        let req: SnapshotRequest = json::from_str("{}").unwrap();
+        assert_eq!(req.sample_size, 100);
+        assert!(req.streams.is_empty());
+    }
+
+    #[test]
+    fn test_mask_headers() {
+        let destination: Destination = json::from_value(json::json!({
+            "name": "webhook",
+            "url": "https://example.com",
+            "template": "default",
+            "headers": {
+                "Authorization": "Bearer abc",
+                "Content-Type": "application/json",
+                "X-Api-Key": "{{secret:api_key}}",
+                "X-Token": "xyz",
+            },
+        }))
+        .unwrap();
+        let mut masked = mask_headers(destination.clone());
+        let headers = masked.headers.as_ref().unwrap();
+        assert_eq!(headers["Authorization"], MASKED_VALUE);
+        assert_eq!(headers["X-Token"], MASKED_VALUE);
+        assert_eq!(headers["Content-Type"], "application/json");
+        assert_eq!(headers["X-Api-Key"], "{{secret:api_key}}");
+
+        let mut existing = destination.clone();
+        existing.headers.as_mut().unwrap().remove("X-Token");
+        let dropped = unmask_headers(&mut masked, Some(&existing));
+        assert_eq!(dropped, vec!["X-Token".to_string()]);
+        let headers = masked.headers.as_ref().unwrap();
+        assert_eq!(headers["Authorization"], "Bearer abc");
+        assert!(!headers.contains_key("X-Token"));
+    }
+}
@@ -16,6 +16,7 @@
 use std::io::Error;
 
 use actix_web::{http, http::StatusCode, HttpResponse};
+use chrono::{DateTime, NaiveDate, TimeZone, Utc};
 use config::{
     is_local_disk_storage,
     meta::stream::{StreamSettings, StreamStats, StreamType},
@@ -36,7 +37,10 @@ use crate::{
         authz::Authz,
         http::HttpResponse as MetaHttpResponse,
         prom,
-        stream::{Stream, StreamProperty},
+        stream::{
+            ListStreamDeleteJob, ReclusterJob, Stream, StreamDeleteJob, StreamDeleteJobStatus,
+            StreamProperty,
+        },
     },
     service::{db, metrics::get_prom_metadata_from_schema},
 };
@@ -151,6 +155,7 @@ pub fn stream_res(
             metric_family_name: stream_name.to_string(),
             help: stream_name.to_string(),
             unit: "".to_string(),
+            original_unit: "".to_string(),
         });
         if meta.metric_type == prom::MetricType::Empty
             && (stream_name.ends_with("_bucket")
@@ -207,6 +212,94 @@ pub async fn save_stream_settings(
         }
     }
 
+    for rule in settings.sampling_rules.iter() {
+        if rule.name.trim().is_empty() {
+            return Ok(MetaHttpResponse::bad_request(
+                "sampling rule name can't be empty",
+            ));
+        }
+        if !(0.0..=1.0).contains(&rule.rate) {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "sampling rule [{}] rate should be between 0.0 and 1.0",
+                rule.name
+            )));
+        }
+    }
+
+    let cols_limit = config::get_config().limit.req_cols_per_record_limit;
+    if settings.max_columns > cols_limit {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "max_columns can't be greater than the ingestion columns limit {cols_limit}"
+        )));
+    }
+
+    if let Some(parquet) = settings.parquet.as_ref() {
+        if let Err(e) = parquet.validate() {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "invalid parquet settings: {e}"
+            )));
+        }
+    }
+
+    for (i, key) in settings.clustering_keys.iter().enumerate() {
+        let cfg = config::get_config();
+        if key.eq(&cfg.common.column_timestamp) || key.eq(&cfg.common.column_all) {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "field [{key}] can't be used for clustering key"
+            )));
+        }
+        if settings.clustering_keys[..i].contains(key) {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "duplicate clustering key [{key}]"
+            )));
+        }
+    }
+
+    if let Some(dedup) = settings.dedup.as_ref() {
+        if let Err(e) = dedup.validate() {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "invalid dedup settings: {e}"
+            )));
+        }
+    }
+
+    if let Some(key) = settings.primary_key.as_ref() {
+        let cfg = config::get_config();
+        if key.eq(&cfg.common.column_timestamp) || key.eq(&cfg.common.column_all) {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "field [{key}] can't be used for primary key"
+            )));
+        }
+    }
+
+    if settings.resolution_ms < 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "resolution_ms can't be negative",
+        ));
+    }
+    if settings.resolution_ms > 0 && stream_type != StreamType::Metrics {
+        return Ok(MetaHttpResponse::bad_request(
+            "resolution_ms only applies to metrics streams",
+        ));
+    }
+
+    for (i, rule) in settings.field_access.iter().enumerate() {
+        if let Err(e) = rule.validate() {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "invalid field access rule: {e}"
+            )));
+        }
+        if settings.field_access[..i]
+            .iter()
+            .any(|r| r.role == rule.role)
+        {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "duplicate field access rule for the role [{}]",
+                rule.role
+            )));
+        }
+    }
+
     // we need to keep the old partition information, because the hash bucket num can't be changed
     // get old settings and then update partition_keys
     let schema = infra::schema::get(org_id, stream_name, stream_type)
@@ -254,6 +347,50 @@ pub async fn save_stream_settings(
 }
 
 #[tracing::instrument]
+/// Creates the job rewriting the files of the time range by the current
+/// clustering keys, the time range defaults to all the data of the stream
+pub async fn recluster_stream(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    start_time: i64,
+    end_time: i64,
+) -> Result<HttpResponse, Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .unwrap();
+    if schema == Schema::empty() {
+        return Ok(MetaHttpResponse::not_found("stream not found"));
+    }
+
+    let now = config::utils::time::now_micros();
+    let start_time = if start_time > 0 {
+        start_time
+    } else {
+        stats::get_stream_stats(org_id, stream_name, stream_type).doc_time_min
+    };
+    let end_time = if end_time > 0 { end_time } else { now };
+    if start_time <= 0 || start_time >= end_time {
+        return Ok(MetaHttpResponse::bad_request("invalid time range"));
+    }
+
+    let job = ReclusterJob {
+        org_id: org_id.to_string(),
+        stream_type,
+        stream_name: stream_name.to_string(),
+        start_time,
+        end_time,
+        offset: start_time,
+        created_at: now,
+    };
+    if let Err(e) = db::compact::recluster::set(&job).await {
+        return Ok(MetaHttpResponse::internal_error(format!(
+            "failed to create recluster job: {e}"
+        )));
+    }
+    Ok(MetaHttpResponse::json(job))
+}
+
 pub async fn delete_stream(
     org_id: &str,
     stream_name: &str,
@@ -321,6 +458,21 @@ pub async fn delete_stream(
         );
     };
 
+    // delete stream series encoding offset
+    if let Err(e) = db::compact::series::delete(org_id, stream_type, stream_name).await {
+        log::error!("Error deleting the series encoding offset of stream {stream_name}: {e}");
+    }
+
+    // delete stream search access
+    if let Err(e) = db::stream_access::delete(org_id, stream_type, stream_name).await {
+        log::error!("Error deleting the search access of stream {stream_name}: {e}");
+    }
+
+    // delete stream iceberg table
+    if let Err(e) = super::iceberg::drop_table(org_id, stream_type, stream_name).await {
+        log::error!("Error deleting the iceberg table of stream {stream_name}: {e}");
+    }
+
     crate::common::utils::auth::remove_ownership(
         org_id,
         &stream_type.to_string(),
@@ -334,6 +486,181 @@ pub async fn delete_stream(
     )))
 }
 
+#[tracing::instrument]
+/// Creates the job deleting the days of the stream before `end`, starting from
+/// the first day with data when `start` is not set. Only the file list is
+/// changed, the files are removed by the compactor without rewriting any
+pub async fn delete_stream_data(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    start: Option<&str>,
+    end: &str,
+    user_id: &str,
+) -> Result<HttpResponse, Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .unwrap();
+    if schema == Schema::empty() {
+        return Ok(MetaHttpResponse::not_found("stream not found"));
+    }
+
+    let start = match start {
+        Some(start) => start.to_string(),
+        None => {
+            let min_ts = stats::get_stream_stats(org_id, stream_name, stream_type).doc_time_min;
+            if min_ts == 0 {
+                return Ok(MetaHttpResponse::bad_request("stream has no data"));
+            }
+            let created_at: DateTime<Utc> = Utc.timestamp_nanos(min_ts * 1000);
+            created_at.format("%Y-%m-%d").to_string()
+        }
+    };
+    let today = config::utils::time::now().date_naive();
+    let (start, end) = match check_delete_date_range(&start, end, today) {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+
+    log::info!(
+        "[STREAM] {user_id} requested to delete the data of [{org_id}/{stream_type}/{stream_name}] from {start} to {end}"
+    );
+    if let Err(e) = db::compact::retention::delete_stream(
+        org_id,
+        stream_type,
+        stream_name,
+        Some((start.as_str(), end.as_str())),
+    )
+    .await
+    {
+        return Ok(MetaHttpResponse::internal_error(format!(
+            "failed to create delete job: {e}"
+        )));
+    }
+
+    Ok(MetaHttpResponse::json(StreamDeleteJob {
+        org_id: org_id.to_string(),
+        stream_type,
+        stream_name: stream_name.to_string(),
+        start: Some(start),
+        end: Some(end),
+        status: StreamDeleteJobStatus::Pending,
+        node: None,
+    }))
+}
+
+#[tracing::instrument]
+/// Creates the job deleting all the data of the stream, keeping its schema and
+/// settings. The ingestion and the search of the stream are rejected until the
+/// compactor is done with it
+pub async fn truncate_stream(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    user_id: &str,
+) -> Result<HttpResponse, Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .unwrap();
+    if schema == Schema::empty() {
+        return Ok(MetaHttpResponse::not_found("stream not found"));
+    }
+
+    log::info!("[STREAM] {user_id} requested to truncate [{org_id}/{stream_type}/{stream_name}]");
+    if let Err(e) =
+        db::compact::retention::delete_stream(org_id, stream_type, stream_name, None).await
+    {
+        return Ok(MetaHttpResponse::internal_error(format!(
+            "failed to create truncate job: {e}"
+        )));
+    }
+
+    Ok(MetaHttpResponse::json(StreamDeleteJob {
+        org_id: org_id.to_string(),
+        stream_type,
+        stream_name: stream_name.to_string(),
+        start: None,
+        end: None,
+        status: StreamDeleteJobStatus::Pending,
+        node: None,
+    }))
+}
+
+/// Lists the delete and truncate jobs of the stream not done yet, including the
+/// ones of the data retention
+pub async fn list_delete_jobs(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+) -> Result<HttpResponse, Error> {
+    let jobs = match db::compact::retention::list_stream(org_id, stream_type, stream_name).await {
+        Ok(jobs) => jobs,
+        Err(e) => {
+            return Ok(MetaHttpResponse::internal_error(format!(
+                "failed to list delete jobs: {e}"
+            )));
+        }
+    };
+    let mut list = jobs
+        .into_iter()
+        .map(|(range, node)| delete_job_from_range(org_id, stream_type, stream_name, &range, node))
+        .collect::<Vec<_>>();
+    list.sort_by(|a, b| a.start.cmp(&b.start));
+    Ok(MetaHttpResponse::json(ListStreamDeleteJob { list }))
+}
+
+// checks the days are formatted as 2023-01-02 and the range ends by today, the
+// day being ingested can only be deleted by truncating the stream
+fn check_delete_date_range(
+    start: &str,
+    end: &str,
+    today: NaiveDate,
+) -> Result<(String, String), String> {
+    let parse = |v: &str| {
+        NaiveDate::parse_from_str(v, "%Y-%m-%d")
+            .map_err(|_| format!("invalid date [{v}], expected YYYY-MM-DD"))
+    };
+    let start = parse(start)?;
+    let end = parse(end)?;
+    if start >= end {
+        return Err("start should be before end".to_string());
+    }
+    if end > today {
+        return Err("end can't be after today, truncate the stream to delete all data".to_string());
+    }
+    Ok((
+        start.format("%Y-%m-%d").to_string(),
+        end.format("%Y-%m-%d").to_string(),
+    ))
+}
+
+fn delete_job_from_range(
+    org_id: &str,
+    stream_type: StreamType,
+    stream_name: &str,
+    range: &str,
+    node: String,
+) -> StreamDeleteJob {
+    let (start, end) = match range.split_once(',') {
+        Some((start, end)) => (Some(start.to_string()), Some(end.to_string())),
+        None => (None, None),
+    };
+    let (status, node) = if node.is_empty() {
+        (StreamDeleteJobStatus::Pending, None)
+    } else {
+        (StreamDeleteJobStatus::Running, Some(node))
+    };
+    StreamDeleteJob {
+        org_id: org_id.to_string(),
+        stream_type,
+        stream_name: stream_name.to_string(),
+        start,
+        end,
+        status,
+        node,
+    }
+}
+
 fn transform_stats(stats: &mut StreamStats) {
     stats.storage_size /= SIZE_IN_MB;
     stats.compressed_size /= SIZE_IN_MB;
@@ -385,4 +712,41 @@ mod tests {
         let res = stream_res("Test", StreamType::Logs, schema, Some(stats));
         assert_eq!(res.stats, stats);
     }
+
+    #[test]
+    fn test_check_delete_date_range() {
+        let today = NaiveDate::from_ymd_opt(2024, 3, 1).unwrap();
+        assert_eq!(
+            check_delete_date_range("2023-1-2", "2024-01-01", today).unwrap(),
+            ("2023-01-02".to_string(), "2024-01-01".to_string())
+        );
+        assert!(check_delete_date_range("2024-03-01", "2024-03-01", today).is_err());
+        assert!(check_delete_date_range("2024-01-01", "2024-03-02", today).is_err());
+        assert!(check_delete_date_range("2024-01-01", "1704067200", today).is_err());
+    }
+
+    #[test]
+    fn test_delete_job_from_range() {
+        let job = delete_job_from_range(
+            "default",
+            StreamType::Logs,
+            "k8s",
+            "2023-01-02,2024-01-01",
+            "".to_string(),
+        );
+        assert_eq!(job.start.as_deref(), Some("2023-01-02"));
+        assert_eq!(job.end.as_deref(), Some("2024-01-01"));
+        assert_eq!(job.status, StreamDeleteJobStatus::Pending);
+
+        let job = delete_job_from_range(
+            "default",
+            StreamType::Logs,
+            "k8s",
+            "all",
+            "node1".to_string(),
+        );
+        assert_eq!(job.start, None);
+        assert_eq!(job.status, StreamDeleteJobStatus::Running);
+        assert_eq!(job.node.as_deref(), Some("node1"));
+    }
 }
@@ -0,0 +1,234 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Tracks the searches of every stream to suggest archiving the streams
+//! nobody searches anymore. The searches are counted in memory by the node
+//! running them and saved to the meta store periodically, the suggestions
+//! shorten the retention of the idle streams and the operator applies them.
+
+use std::io::Error;
+
+use actix_web::{http::StatusCode, HttpResponse};
+use chrono::Utc;
+use config::{get_config, meta::stream::StreamType, RwHashMap};
+use infra::cache::stats;
+use once_cell::sync::Lazy;
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        stream_access::{
+            suggest, ApplyArchivalRequest, ApplyArchivalResponse, ArchivalSuggestion,
+            ArchivalSuggestions, SkippedStream, StreamAccess, MIN_RETENTION_DAYS,
+        },
+    },
+    service::{db, stream},
+};
+
+const DAY_MICROS: i64 = 24 * 3600 * 1_000_000;
+
+/// Searches run on this node and not saved yet, `{org_id}/{stream_type}/{stream_name}`
+static PENDING: Lazy<RwHashMap<String, StreamAccess>> = Lazy::new(Default::default);
+
+/// Counts a search of the stream
+pub fn record(org_id: &str, stream_type: StreamType, stream_name: &str) {
+    if stream_name.is_empty() {
+        return;
+    }
+    let mut access = PENDING
+        .entry(format!("{org_id}/{stream_type}/{stream_name}"))
+        .or_default();
+    access.last_queried_at = Utc::now().timestamp_micros();
+    access.queries += 1;
+}
+
+/// Adds the searches counted since the last flush to the stored ones
+pub async fn flush() -> Result<(), anyhow::Error> {
+    let keys: Vec<String> = PENDING.iter().map(|v| v.key().clone()).collect();
+    for key in keys {
+        let Some((_, access)) = PENDING.remove(&key) else {
+            continue;
+        };
+        let columns: Vec<&str> = key.splitn(3, '/').collect();
+        if columns.len() != 3 {
+            continue;
+        }
+        let (org_id, stream_type, stream_name) =
+            (columns[0], StreamType::from(columns[1]), columns[2]);
+        let mut stored = db::stream_access::get(org_id, stream_type, stream_name)
+            .await
+            .unwrap_or_default();
+        stored.merge(&access);
+        if let Err(e) = db::stream_access::set(org_id, stream_type, stream_name, &stored).await {
+            // keep the searches for the next flush
+            PENDING.entry(key).or_default().merge(&access);
+            return Err(e);
+        }
+    }
+    Ok(())
+}
+
+/// Lists the streams with data not searched in the last `idle_days` days, the
+/// largest first
+#[tracing::instrument]
+pub async fn suggestions(org_id: &str, idle_days: Option<i64>) -> Result<HttpResponse, Error> {
+    let idle_days = idle_days.unwrap_or(get_config().limit.archival_idle_days);
+    if idle_days <= 0 {
+        return Ok(MetaHttpResponse::bad_request(
+            "days should be at least 1 day",
+        ));
+    }
+    let schemas = match db::schema::list(org_id, None, false).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    let mut access = match db::stream_access::list(org_id).await {
+        Ok(v) => v,
+        Err(e) => return Ok(MetaHttpResponse::internal_error(e)),
+    };
+    // the searches of this node not saved yet
+    let prefix = format!("{org_id}/");
+    for item in PENDING.iter() {
+        if let Some(key) = item.key().strip_prefix(&prefix) {
+            access
+                .entry(key.to_string())
+                .or_default()
+                .merge(item.value());
+        }
+    }
+
+    let now = Utc::now().timestamp_micros();
+    let mut resp = ArchivalSuggestions {
+        idle_days,
+        ..Default::default()
+    };
+    for schema in schemas {
+        let stats = stats::get_stream_stats(org_id, &schema.stream_name, schema.stream_type);
+        if stats.storage_size <= 0.0 {
+            continue;
+        }
+        let access = access
+            .get(&format!("{}/{}", schema.stream_type, schema.stream_name))
+            .filter(|v| v.queries > 0);
+        let since = access.map_or(stats.created_at, |v| v.last_queried_at);
+        let days = (now - since).max(0) / DAY_MICROS;
+        let retention_days = retention(org_id, &schema.stream_name, schema.stream_type).await;
+        let Some((action, suggested_retention_days)) =
+            suggest(days, idle_days, retention_days, access.is_none())
+        else {
+            continue;
+        };
+        resp.reclaimable_size += stats.compressed_size;
+        resp.suggestions.push(ArchivalSuggestion {
+            stream_name: schema.stream_name,
+            stream_type: schema.stream_type,
+            last_queried_at: access.map(|v| v.last_queried_at),
+            queries: access.map_or(0, |v| v.queries),
+            idle_days: days,
+            storage_size: stats.storage_size,
+            compressed_size: stats.compressed_size,
+            retention_days,
+            suggested_retention_days,
+            action,
+        });
+    }
+    resp.suggestions
+        .sort_by(|a, b| b.compressed_size.total_cmp(&a.compressed_size));
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// Sets the retention of the streams, only the streams it shortens the
+/// retention of are changed
+#[tracing::instrument(skip(req))]
+pub async fn apply(
+    org_id: &str,
+    user_id: &str,
+    req: ApplyArchivalRequest,
+) -> Result<HttpResponse, Error> {
+    if req.retention_days < MIN_RETENTION_DAYS {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "retention should be at least {MIN_RETENTION_DAYS} days"
+        )));
+    }
+    let mut resp = ApplyArchivalResponse::default();
+    for item in req.streams {
+        let skip = |reason: String| SkippedStream {
+            stream_name: item.stream_name.clone(),
+            reason,
+        };
+        let Some(mut settings) =
+            infra::schema::get_settings(org_id, &item.stream_name, item.stream_type).await
+        else {
+            resp.skipped.push(skip("stream not found".to_string()));
+            continue;
+        };
+        let current = retention(org_id, &item.stream_name, item.stream_type).await;
+        // retention 0 keeps the data forever
+        if current > 0 && current <= req.retention_days {
+            resp.skipped
+                .push(skip(format!("retention is already {current} days")));
+            continue;
+        }
+        settings.data_retention = req.retention_days;
+        let res =
+            stream::save_stream_settings(org_id, &item.stream_name, item.stream_type, settings)
+                .await?;
+        if res.status() != StatusCode::OK {
+            resp.skipped
+                .push(skip("failed to save the stream settings".to_string()));
+            continue;
+        }
+        log::info!(
+            "[ARCHIVAL] {user_id} set the retention of [{org_id}/{}/{}] to {} days from {current}",
+            item.stream_type,
+            item.stream_name,
+            req.retention_days
+        );
+        resp.updated.push(item.stream_name);
+    }
+    Ok(MetaHttpResponse::json(resp))
+}
+
+/// Returns the retention of the stream in days, the global one when it has none
+async fn retention(org_id: &str, stream_name: &str, stream_type: StreamType) -> i64 {
+    let retention = infra::schema::get_settings(org_id, stream_name, stream_type)
+        .await
+        .map(|s| s.data_retention)
+        .unwrap_or_default();
+    if retention > 0 {
+        retention
+    } else {
+        get_config().compact.data_retention_days
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_record() {
+        record("stream_access_org", StreamType::Logs, "default");
+        record("stream_access_org", StreamType::Logs, "default");
+        record("stream_access_org", StreamType::Logs, "");
+        let access = *PENDING
+            .get("stream_access_org/logs/default")
+            .unwrap()
+            .value();
+        assert_eq!(access.queries, 2);
+        assert!(access.last_queried_at > 0);
+        assert!(PENDING.get("stream_access_org/logs/").is_none());
+    }
+}
@@ -0,0 +1,306 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Partitions of a stream for external query engines. The files come from
+//! the file list, so listing them doesn't touch the storage, only the column
+//! statistics are read from the parquet footers when they are asked for.
+
+use std::{collections::BTreeMap, io::Error};
+
+use actix_web::HttpResponse;
+use arrow_schema::Schema;
+use config::{
+    get_config, is_local_disk_storage,
+    meta::stream::{FileKey, StreamType},
+};
+use futures::{FutureExt, StreamExt};
+use infra::{
+    cache::stats,
+    schema::{unwrap_partition_time_level, unwrap_stream_settings},
+};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        stream_partitions::{
+            parse_partition, ColumnStatistics, DataFile, Partition, PartitionField,
+            StreamPartitions,
+        },
+    },
+    service::{file_list, stream_profile::read_column_stats},
+};
+
+#[tracing::instrument]
+pub async fn get_partitions(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    start_time: i64,
+    end_time: i64,
+    with_stats: bool,
+) -> Result<HttpResponse, Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .unwrap();
+    if schema == Schema::empty() {
+        return Ok(MetaHttpResponse::not_found("stream not found"));
+    }
+
+    let end_time = if end_time > 0 {
+        end_time
+    } else {
+        config::utils::time::now_micros()
+    };
+    let start_time = if start_time > 0 {
+        start_time
+    } else {
+        // from the oldest data
+        stats::get_stream_stats(org_id, stream_name, stream_type)
+            .doc_time_min
+            .max(1)
+    };
+    if start_time >= end_time {
+        return Ok(MetaHttpResponse::bad_request("invalid time range"));
+    }
+
+    match partitions(
+        org_id,
+        stream_name,
+        stream_type,
+        &schema,
+        start_time,
+        end_time,
+        with_stats,
+    )
+    .await
+    {
+        Ok(resp) => Ok(MetaHttpResponse::json(resp)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+async fn partitions(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    schema: &Schema,
+    start_time: i64,
+    end_time: i64,
+    with_stats: bool,
+) -> Result<StreamPartitions, anyhow::Error> {
+    let cfg = get_config();
+    let stream_settings = unwrap_stream_settings(schema).unwrap_or_default();
+    let partition_time_level =
+        unwrap_partition_time_level(stream_settings.partition_time_level, stream_type);
+    let mut files = file_list::query(
+        org_id,
+        stream_name,
+        stream_type,
+        partition_time_level,
+        start_time,
+        end_time,
+        false,
+    )
+    .await?;
+    files.sort_by(|a, b| a.key.cmp(&b.key));
+
+    let location = location();
+    let mut resp = StreamPartitions {
+        stream_name: stream_name.to_string(),
+        stream_type,
+        partition_time_level,
+        partition_keys: stream_settings
+            .partition_keys
+            .iter()
+            .filter(|k| !k.disabled)
+            .map(|k| k.field.clone())
+            .collect(),
+        fields: schema
+            .fields()
+            .iter()
+            .map(|f| PartitionField {
+                name: f.name().to_string(),
+                data_type: f.data_type().to_string(),
+            })
+            .collect(),
+        start_time,
+        end_time,
+        records: files.iter().map(|f| f.meta.records).sum(),
+        files: files.len(),
+        original_size: files.iter().map(|f| f.meta.original_size).sum(),
+        compressed_size: files.iter().map(|f| f.meta.compressed_size).sum(),
+        partitions: group_partitions(&files, &location),
+        location,
+        ..Default::default()
+    };
+
+    if with_stats {
+        let max = cfg.limit.partition_stats_max_files;
+        let tasks = files.iter().take(max).map(|file| {
+            read_column_stats(file).map(move |ret| {
+                ret.map(|stats| (file.key.as_str(), stats))
+                    .map_err(|e| format!("failed to read the metadata of {}: {e}", file.key))
+            })
+        });
+        let mut results = futures::stream::iter(tasks).buffer_unordered(cfg.limit.cpu_num);
+        let mut columns = BTreeMap::new();
+        while let Some(ret) = results.next().await {
+            match ret {
+                Ok((key, stats)) => {
+                    let mut stats: Vec<ColumnStatistics> = stats
+                        .columns
+                        .into_iter()
+                        .map(|(name, c)| ColumnStatistics {
+                            name,
+                            null_count: (c.nulls >= 0).then_some(c.nulls),
+                            min: c.min,
+                            max: c.max,
+                        })
+                        .collect();
+                    stats.sort_by(|a, b| a.name.cmp(&b.name));
+                    columns.insert(key.to_string(), stats);
+                }
+                Err(e) => {
+                    log::warn!("[PARTITIONS] {e}");
+                    resp.warnings.push(e);
+                }
+            }
+        }
+        for file in resp.partitions.iter_mut().flat_map(|p| p.files.iter_mut()) {
+            if let Some(stats) = columns.remove(&file.key) {
+                file.columns = stats;
+            }
+        }
+        if files.len() > max {
+            resp.warnings.push(format!(
+                "the column statistics are only read for the first {max} of the {} files, narrow the time range to get the others",
+                files.len()
+            ));
+        }
+    }
+    Ok(resp)
+}
+
+/// Groups the files by the directory of their key, in key order
+fn group_partitions(files: &[FileKey], location: &str) -> Vec<Partition> {
+    let mut partitions: Vec<Partition> = Vec::new();
+    for file in files {
+        let Some((path, values)) = parse_partition(&file.key) else {
+            log::warn!("[PARTITIONS] unexpected file key {}", file.key);
+            continue;
+        };
+        if partitions.last().map_or(true, |p| p.path != path) {
+            partitions.push(Partition {
+                path,
+                values,
+                min_ts: file.meta.min_ts,
+                max_ts: file.meta.max_ts,
+                ..Default::default()
+            });
+        }
+        let partition = partitions.last_mut().unwrap();
+        partition.min_ts = partition.min_ts.min(file.meta.min_ts);
+        partition.max_ts = partition.max_ts.max(file.meta.max_ts);
+        partition.records += file.meta.records;
+        partition.original_size += file.meta.original_size;
+        partition.compressed_size += file.meta.compressed_size;
+        partition.files.push(DataFile {
+            key: file.key.clone(),
+            url: format!("{location}{}", file.key),
+            min_ts: file.meta.min_ts,
+            max_ts: file.meta.max_ts,
+            records: file.meta.records,
+            original_size: file.meta.original_size,
+            compressed_size: file.meta.compressed_size,
+            columns: vec![],
+        });
+    }
+    partitions
+}
+
+/// Returns the base url of the file keys in the storage, ending with a slash
+pub(crate) fn location() -> String {
+    let cfg = get_config();
+    let mut location = if is_local_disk_storage() {
+        let dir = std::fs::canonicalize(&cfg.common.data_stream_dir)
+            .map(|p| p.display().to_string())
+            .unwrap_or_else(|_| cfg.common.data_stream_dir.clone());
+        format!("file://{dir}")
+    } else {
+        let scheme = match cfg.s3.provider.as_str() {
+            "azure" => "az",
+            "gcs" | "gcp" => "gs",
+            _ => "s3",
+        };
+        format!(
+            "{scheme}://{}/{}",
+            cfg.s3.bucket_name,
+            cfg.s3.bucket_prefix.trim_start_matches('/')
+        )
+    };
+    if !location.ends_with('/') {
+        location.push('/');
+    }
+    location
+}
+
+#[cfg(test)]
+mod tests {
+    use config::meta::stream::FileMeta;
+
+    use super::*;
+
+    fn file(key: &str, min_ts: i64, max_ts: i64, records: i64) -> FileKey {
+        FileKey::new(
+            key,
+            FileMeta {
+                min_ts,
+                max_ts,
+                records,
+                original_size: records * 10,
+                compressed_size: records,
+                flattened: false,
+            },
+            false,
+        )
+    }
+
+    #[test]
+    fn test_group_partitions() {
+        let dir = "files/default/logs/app/2024/05/01/10";
+        let files = vec![
+            file(&format!("{dir}/service=api/1.parquet"), 5, 9, 10),
+            file(&format!("{dir}/service=api/2.parquet"), 1, 7, 20),
+            file(&format!("{dir}/service=web/3.parquet"), 2, 3, 5),
+            file("bad/key.parquet", 0, 0, 0),
+        ];
+        let partitions = group_partitions(&files, "s3://bucket/");
+        assert_eq!(partitions.len(), 2);
+        assert_eq!(
+            partitions[0].path,
+            "files/default/logs/app/2024/05/01/10/service=api/"
+        );
+        assert_eq!(partitions[0].values["service"], "api");
+        assert_eq!(partitions[0].min_ts, 1);
+        assert_eq!(partitions[0].max_ts, 9);
+        assert_eq!(partitions[0].records, 30);
+        assert_eq!(partitions[0].files.len(), 2);
+        assert_eq!(
+            partitions[0].files[0].url,
+            "s3://bucket/files/default/logs/app/2024/05/01/10/service=api/1.parquet"
+        );
+        assert_eq!(partitions[1].values["service"], "web");
+    }
+}
@@ -0,0 +1,617 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Field statistics of a stream over a time window. The record and null
+//! counts, the minimums and the maximums are read from the parquet metadata of
+//! the files, so they are exact for the files read without scanning them.
+//! The distinct counts, the top values and the length distributions come
+//! from a sample of the most recent records of the window.
+
+use std::{cmp::Ordering, collections::HashMap, io::Error};
+
+use actix_web::HttpResponse;
+use arrow_schema::{DataType, Schema};
+use config::{
+    get_config,
+    meta::{
+        search::{Query, Request, RequestEncoding, SearchEventType},
+        stream::{FileKey, StreamType},
+    },
+    utils::json,
+};
+use futures::{FutureExt, StreamExt};
+use infra::schema::{unwrap_partition_time_level, unwrap_stream_settings};
+use parquet::{
+    arrow::async_reader::fetch_parquet_metadata, errors::ParquetError, file::statistics::Statistics,
+};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        stream_profile::{FieldProfile, LengthBucket, LengthStats, StreamProfile, ValueCount},
+    },
+    service::{file_list, search as SearchService},
+};
+
+/// Window profiled when the start time isn't set
+const DEFAULT_WINDOW: i64 = 24 * 3600 * 1_000_000; // microseconds
+const TOP_VALUES: usize = 5;
+/// Values of the top values are cut to this length
+const MAX_VALUE_LEN: usize = 256;
+/// Upper bounds of the length buckets, the last bucket has none
+const LENGTH_BUCKETS: [usize; 4] = [16, 64, 256, 1024];
+
+pub async fn get_stream_profile(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    user_id: &str,
+    start_time: i64,
+    end_time: i64,
+) -> Result<HttpResponse, Error> {
+    let schema = infra::schema::get(org_id, stream_name, stream_type)
+        .await
+        .unwrap();
+    if schema == Schema::empty() {
+        return Ok(MetaHttpResponse::not_found("stream not found"));
+    }
+
+    let end_time = if end_time > 0 {
+        end_time
+    } else {
+        config::utils::time::now_micros()
+    };
+    let start_time = if start_time > 0 {
+        start_time
+    } else {
+        end_time - DEFAULT_WINDOW
+    };
+    if start_time >= end_time {
+        return Ok(MetaHttpResponse::bad_request("invalid time range"));
+    }
+
+    match profile(
+        org_id,
+        stream_name,
+        stream_type,
+        user_id,
+        &schema,
+        start_time,
+        end_time,
+    )
+    .await
+    {
+        Ok(profile) => Ok(MetaHttpResponse::json(profile)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+async fn profile(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    user_id: &str,
+    schema: &Schema,
+    start_time: i64,
+    end_time: i64,
+) -> Result<StreamProfile, anyhow::Error> {
+    let cfg = get_config();
+    let stream_settings = unwrap_stream_settings(schema).unwrap_or_default();
+    let partition_time_level =
+        unwrap_partition_time_level(stream_settings.partition_time_level, stream_type);
+    let files = file_list::query(
+        org_id,
+        stream_name,
+        stream_type,
+        partition_time_level,
+        start_time,
+        end_time,
+        false,
+    )
+    .await?;
+
+    let mut resp = StreamProfile {
+        stream_name: stream_name.to_string(),
+        stream_type,
+        start_time,
+        end_time,
+        records: files.iter().map(|f| f.meta.records).sum(),
+        files: files.len(),
+        ..Default::default()
+    };
+
+    let picked = pick_evenly(&files, cfg.limit.profile_max_files);
+    let tasks = picked.into_iter().map(|file| {
+        read_column_stats(file).map(move |ret| {
+            ret.map_err(|e| format!("failed to read the metadata of {}: {e}", file.key))
+        })
+    });
+    let mut results = futures::stream::iter(tasks).buffer_unordered(cfg.limit.cpu_num);
+    let mut columns = ColumnStats::default();
+    while let Some(ret) = results.next().await {
+        match ret {
+            Ok(stats) => {
+                columns.merge(stats);
+                resp.files_scanned += 1;
+            }
+            Err(e) => {
+                log::warn!("[PROFILE] {e}");
+                resp.warnings.push(e);
+            }
+        }
+    }
+    if resp.files_scanned < resp.files {
+        resp.warnings.push(format!(
+            "the null counts, minimums and maximums come from {} of the {} files",
+            resp.files_scanned, resp.files
+        ));
+    }
+
+    let sample = sample(
+        org_id,
+        stream_name,
+        stream_type,
+        user_id,
+        start_time,
+        end_time,
+    )
+    .await?;
+    resp.sampled = sample.len();
+    resp.fields = profile_fields(schema, &sample, &columns);
+    Ok(resp)
+}
+
+/// Returns at most `max` files spread evenly over the list
+fn pick_evenly(files: &[FileKey], max: usize) -> Vec<&FileKey> {
+    if files.len() <= max {
+        return files.iter().collect();
+    }
+    (0..max).map(|i| &files[i * files.len() / max]).collect()
+}
+
+async fn sample(
+    org_id: &str,
+    stream_name: &str,
+    stream_type: StreamType,
+    user_id: &str,
+    start_time: i64,
+    end_time: i64,
+) -> Result<Vec<json::Value>, anyhow::Error> {
+    let req = Request {
+        query: Query {
+            sql: format!("SELECT * FROM \"{stream_name}\""),
+            from: 0,
+            size: get_config().limit.profile_sample_size,
+            start_time,
+            end_time,
+            sql_mode: "full".to_string(),
+            ..Default::default()
+        },
+        aggs: HashMap::new(),
+        encoding: RequestEncoding::Empty,
+        regions: vec![],
+        clusters: vec![],
+        timeout: 0,
+        search_type: Some(SearchEventType::Other),
+        search_event_context: None,
+    };
+    let trace_id = config::ider::uuid();
+    let resp = SearchService::search(
+        &trace_id,
+        org_id,
+        stream_type,
+        Some(user_id.to_string()),
+        &req,
+    )
+    .await?;
+    Ok(resp.hits)
+}
+
+#[derive(Debug, Default, PartialEq)]
+pub(crate) struct ColumnStat {
+    /// Records of the files holding the column
+    pub(crate) records: i64,
+    /// Negative when a row group of the column has no statistics
+    pub(crate) nulls: i64,
+    pub(crate) min: Option<json::Value>,
+    pub(crate) max: Option<json::Value>,
+}
+
+#[derive(Debug, Default)]
+pub(crate) struct ColumnStats {
+    /// Records of the files read
+    pub(crate) records: i64,
+    pub(crate) columns: HashMap<String, ColumnStat>,
+}
+
+impl ColumnStats {
+    fn merge(&mut self, other: ColumnStats) {
+        self.records += other.records;
+        for (name, stat) in other.columns {
+            let entry = self.columns.entry(name).or_default();
+            entry.records += stat.records;
+            entry.nulls += stat.nulls;
+            entry.min = pick(entry.min.take(), stat.min, Ordering::Less);
+            entry.max = pick(entry.max.take(), stat.max, Ordering::Greater);
+        }
+    }
+}
+
+/// Reads the statistics of the columns from the footer of the parquet file,
+/// fetching only the footer from the storage
+pub(crate) async fn read_column_stats(file: &FileKey) -> Result<ColumnStats, anyhow::Error> {
+    let metadata = fetch_parquet_metadata(
+        |range| async move {
+            infra::storage::get_range(&file.key, range)
+                .await
+                .map_err(|e| ParquetError::External(e.into()))
+        },
+        file.meta.compressed_size as usize,
+        None,
+    )
+    .await?;
+
+    let mut stats = ColumnStats {
+        records: metadata.file_metadata().num_rows(),
+        ..Default::default()
+    };
+    for row_group in metadata.row_groups() {
+        for column in row_group.columns() {
+            let entry = stats
+                .columns
+                .entry(column.column_path().string())
+                .or_default();
+            entry.records += row_group.num_rows();
+            match column.statistics() {
+                Some(s) => {
+                    entry.nulls += s.null_count() as i64;
+                    entry.min = pick(entry.min.take(), stat_value(s, true), Ordering::Less);
+                    entry.max = pick(entry.max.take(), stat_value(s, false), Ordering::Greater);
+                }
+                // without statistics the nulls are unknown, they are taken
+                // from the sample
+                None => entry.nulls = -1,
+            }
+        }
+    }
+    Ok(stats)
+}
+
+fn stat_value(stats: &Statistics, min: bool) -> Option<json::Value> {
+    if !stats.has_min_max_set() {
+        return None;
+    }
+    match stats {
+        Statistics::Boolean(s) => Some(json::Value::Bool(if min { *s.min() } else { *s.max() })),
+        Statistics::Int32(s) => Some((if min { *s.min() } else { *s.max() }).into()),
+        Statistics::Int64(s) => Some((if min { *s.min() } else { *s.max() }).into()),
+        Statistics::Float(s) => {
+            json::Number::from_f64((if min { *s.min() } else { *s.max() }) as f64)
+                .map(json::Value::Number)
+        }
+        Statistics::Double(s) => {
+            json::Number::from_f64(if min { *s.min() } else { *s.max() }).map(json::Value::Number)
+        }
+        Statistics::ByteArray(s) => {
+            let v = if min { s.min() } else { s.max() };
+            Some(String::from_utf8_lossy(v.data()).into())
+        }
+        _ => None,
+    }
+}
+
+fn compare(a: &json::Value, b: &json::Value) -> Option<Ordering> {
+    match (a, b) {
+        (json::Value::Number(a), json::Value::Number(b)) => a.as_f64()?.partial_cmp(&b.as_f64()?),
+        (json::Value::String(a), json::Value::String(b)) => Some(a.cmp(b)),
+        (json::Value::Bool(a), json::Value::Bool(b)) => Some(a.cmp(b)),
+        _ => None,
+    }
+}
+
+/// Returns the value which is `ord` compared to the other, like the smallest
+/// one for `Ordering::Less`
+fn pick(a: Option<json::Value>, b: Option<json::Value>, ord: Ordering) -> Option<json::Value> {
+    match (a, b) {
+        (Some(a), Some(b)) => match compare(&b, &a) {
+            Some(o) if o == ord => Some(b),
+            _ => Some(a),
+        },
+        (a, b) => a.or(b),
+    }
+}
+
+fn profile_fields(
+    schema: &Schema,
+    sample: &[json::Value],
+    columns: &ColumnStats,
+) -> Vec<FieldProfile> {
+    schema
+        .fields()
+        .iter()
+        .map(|field| {
+            let name = field.name();
+            let values = sample
+                .iter()
+                .filter_map(|hit| hit.get(name).filter(|v| !v.is_null()))
+                .collect::<Vec<_>>();
+            let mut profile = FieldProfile {
+                name: name.to_string(),
+                data_type: field.data_type().to_string(),
+                ..Default::default()
+            };
+
+            let column = columns.columns.get(name);
+            profile.null_percent = match column {
+                Some(c) if c.nulls >= 0 && columns.records > 0 => {
+                    // the files without the column have only nulls
+                    let nulls = c.nulls + columns.records - c.records;
+                    percent(nulls as usize, columns.records as usize)
+                }
+                None if columns.records > 0 && sample.is_empty() => 100.0,
+                _ if !sample.is_empty() => percent(sample.len() - values.len(), sample.len()),
+                _ => 0.0,
+            };
+
+            let mut counts: HashMap<String, usize> = HashMap::new();
+            for v in values.iter() {
+                let v = match v {
+                    json::Value::String(s) => s.to_string(),
+                    v => v.to_string(),
+                };
+                *counts.entry(v).or_default() += 1;
+            }
+            profile.distinct_count = counts.len();
+            profile.high_cardinality = values.len() > 1 && counts.len() == values.len();
+            let mut top = counts.into_iter().collect::<Vec<_>>();
+            top.sort_by(|a, b| b.1.cmp(&a.1).then_with(|| a.0.cmp(&b.0)));
+            profile.top_values = top
+                .into_iter()
+                .take(TOP_VALUES)
+                .map(|(value, count)| ValueCount {
+                    value: value.chars().take(MAX_VALUE_LEN).collect(),
+                    count,
+                })
+                .collect();
+
+            match column {
+                Some(c) if c.min.is_some() || c.max.is_some() => {
+                    profile.min = c.min.clone();
+                    profile.max = c.max.clone();
+                }
+                _ => {
+                    for v in values.iter().filter(|v| v.is_number() || v.is_string()) {
+                        profile.min = pick(profile.min.take(), Some((*v).clone()), Ordering::Less);
+                        profile.max =
+                            pick(profile.max.take(), Some((*v).clone()), Ordering::Greater);
+                    }
+                }
+            }
+
+            if matches!(field.data_type(), DataType::Utf8 | DataType::LargeUtf8) {
+                profile.length = length_stats(values.iter().filter_map(|v| v.as_str()));
+            }
+            profile
+        })
+        .collect()
+}
+
+fn percent(part: usize, total: usize) -> f64 {
+    if total == 0 {
+        return 0.0;
+    }
+    (part as f64 * 10000.0 / total as f64).round() / 100.0
+}
+
+fn length_stats<'a>(values: impl Iterator<Item = &'a str>) -> Option<LengthStats> {
+    let mut buckets = LENGTH_BUCKETS
+        .iter()
+        .map(|le| LengthBucket {
+            le: Some(*le),
+            count: 0,
+        })
+        .collect::<Vec<_>>();
+    buckets.push(LengthBucket::default());
+    let (mut min, mut max, mut total, mut count) = (usize::MAX, 0, 0, 0);
+    for v in values {
+        let len = v.chars().count();
+        min = min.min(len);
+        max = max.max(len);
+        total += len;
+        count += 1;
+        let pos = LENGTH_BUCKETS
+            .iter()
+            .position(|le| len <= *le)
+            .unwrap_or(LENGTH_BUCKETS.len());
+        buckets[pos].count += 1;
+    }
+    if count == 0 {
+        return None;
+    }
+    Some(LengthStats {
+        min,
+        max,
+        avg: (total as f64 * 100.0 / count as f64).round() / 100.0,
+        buckets,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use arrow_schema::Field;
+
+    use super::*;
+
+    #[test]
+    fn test_pick() {
+        let (one, two) = (Some(json::json!(1)), Some(json::json!(2.5)));
+        assert_eq!(pick(one.clone(), two.clone(), Ordering::Less), one);
+        assert_eq!(pick(one.clone(), two.clone(), Ordering::Greater), two);
+        assert_eq!(pick(None, two.clone(), Ordering::Less), two);
+        let (a, b) = (Some(json::json!("a")), Some(json::json!("b")));
+        assert_eq!(pick(b.clone(), a.clone(), Ordering::Less), a);
+        // values which can't be compared keep the first one
+        assert_eq!(pick(one.clone(), a.clone(), Ordering::Less), one);
+    }
+
+    #[test]
+    fn test_merge() {
+        let mut stats = ColumnStats::default();
+        for (records, nulls, min, max) in [(10, 2, 5, 9), (20, 0, 1, 7)] {
+            stats.merge(ColumnStats {
+                records,
+                columns: HashMap::from([(
+                    "code".to_string(),
+                    ColumnStat {
+                        records,
+                        nulls,
+                        min: Some(json::json!(min)),
+                        max: Some(json::json!(max)),
+                    },
+                )]),
+            });
+        }
+        assert_eq!(stats.records, 30);
+        assert_eq!(
+            stats.columns["code"],
+            ColumnStat {
+                records: 30,
+                nulls: 2,
+                min: Some(json::json!(1)),
+                max: Some(json::json!(9)),
+            }
+        );
+    }
+
+    #[test]
+    fn test_length_stats() {
+        let long = "x".repeat(2000);
+        let stats =
+            length_stats(["", "abc", "a".repeat(20).as_str(), long.as_str()].into_iter()).unwrap();
+        assert_eq!((stats.min, stats.max), (0, 2000));
+        assert_eq!(stats.avg, 505.75);
+        let counts = stats
+            .buckets
+            .iter()
+            .map(|b| (b.le, b.count))
+            .collect::<Vec<_>>();
+        assert_eq!(
+            counts,
+            vec![
+                (Some(16), 2),
+                (Some(64), 1),
+                (Some(256), 0),
+                (Some(1024), 0),
+                (None, 1)
+            ]
+        );
+        assert_eq!(length_stats(std::iter::empty()), None);
+    }
+
+    #[test]
+    fn test_profile_fields() {
+        let schema = Schema::new(vec![
+            Field::new("_timestamp", DataType::Int64, false),
+            Field::new("level", DataType::Utf8, true),
+            Field::new("code", DataType::Int64, true),
+        ]);
+        let sample = vec![
+            json::json!({"_timestamp": 3, "level": "info", "code": 200}),
+            json::json!({"_timestamp": 2, "level": "info"}),
+            json::json!({"_timestamp": 1, "level": "error", "code": 500}),
+            json::json!({"_timestamp": 0, "level": null, "code": 404}),
+        ];
+        // the second file has no code column
+        let columns = ColumnStats {
+            records: 100,
+            columns: HashMap::from([
+                (
+                    "_timestamp".to_string(),
+                    ColumnStat {
+                        records: 100,
+                        nulls: 0,
+                        min: Some(json::json!(0)),
+                        max: Some(json::json!(9)),
+                    },
+                ),
+                (
+                    "level".to_string(),
+                    ColumnStat {
+                        records: 100,
+                        nulls: -1,
+                        ..Default::default()
+                    },
+                ),
+                (
+                    "code".to_string(),
+                    ColumnStat {
+                        records: 60,
+                        nulls: 15,
+                        min: Some(json::json!(200)),
+                        max: Some(json::json!(503)),
+                    },
+                ),
+            ]),
+        };
+        let fields = profile_fields(&schema, &sample, &columns);
+
+        let ts = &fields[0];
+        assert_eq!(ts.null_percent, 0.0);
+        assert_eq!(ts.distinct_count, 4);
+        assert!(ts.high_cardinality);
+        assert_eq!(
+            (ts.min.clone(), ts.max.clone()),
+            (Some(json::json!(0)), Some(json::json!(9)))
+        );
+        assert_eq!(ts.length, None);
+
+        let level = &fields[1];
+        // no statistics, the nulls and the min/max come from the sample
+        assert_eq!(level.null_percent, 25.0);
+        assert_eq!(level.distinct_count, 2);
+        assert!(!level.high_cardinality);
+        assert_eq!(
+            level.top_values,
+            vec![
+                ValueCount {
+                    value: "info".to_string(),
+                    count: 2
+                },
+                ValueCount {
+                    value: "error".to_string(),
+                    count: 1
+                },
+            ]
+        );
+        assert_eq!(level.min, Some(json::json!("error")));
+        assert_eq!(level.max, Some(json::json!("info")));
+        assert_eq!(level.length.as_ref().unwrap().avg, 4.33);
+
+        let code = &fields[2];
+        assert_eq!(code.null_percent, 55.0);
+        assert_eq!(code.max, Some(json::json!(503)));
+        assert_eq!(code.top_values[0].value, "200");
+    }
+
+    #[test]
+    fn test_pick_evenly() {
+        let files = (0..10)
+            .map(|i| FileKey::new(&i.to_string(), Default::default(), false))
+            .collect::<Vec<_>>();
+        let keys = |files: Vec<&FileKey>| files.iter().map(|f| f.key.clone()).collect::<Vec<_>>();
+        assert_eq!(keys(pick_evenly(&files, 4)), vec!["0", "2", "5", "7"]);
+        assert_eq!(pick_evenly(&files, 20).len(), 10);
+    }
+}
@@ -0,0 +1,618 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Stream subscriptions forward the ingested records matching their
+//! conditions to a webhook, a Kafka topic, another OpenObserve instance, a
+//! ClickHouse table or an Elasticsearch index. A subscription without
+//! conditions and with an expiry dual-writes a stream while migrating from or
+//! to another store. Every ingester matches the records it writes and queues
+//! them per subscription, a sender task per queue batches and delivers them.
+//! The delivery never slows the ingestion down: when a queue is full the
+//! matched records are dropped and counted.
+//!
+//! Subscribing takes an admin or a user who can read the stream, and the
+//! sinks are limited to ZO_SUBSCRIPTION_ALLOWED_HOSTS and to public addresses.
+
+use std::{
+    io::Error,
+    net::{IpAddr, Ipv6Addr},
+    sync::Arc,
+    time::Duration,
+};
+
+use actix_web::HttpResponse;
+use chrono::Utc;
+use config::{get_config, metrics, utils::json, RwHashMap};
+use once_cell::sync::Lazy;
+use reqwest::header;
+use tokio::sync::mpsc;
+
+use crate::{
+    common::{
+        infra::config::STREAM_SUBSCRIPTIONS,
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            subscriptions::{
+                is_allowed_host, StreamSubscription, StreamSubscriptionList, SubscriptionSink,
+            },
+        },
+    },
+    service::{db, search::access, secrets, users},
+};
+
+/// Seconds the sender waits for a batch to fill up before sending it
+const FLUSH_INTERVAL: u64 = 1;
+/// Seconds to wait for the sink
+const HTTP_TIMEOUT: u64 = 30;
+
+/// Queued record with the time it was queued, in microseconds
+type Queued = (i64, Arc<json::Value>);
+
+/// Queues of the subscriptions on this node, `{org_id}/{name}`
+static QUEUES: Lazy<RwHashMap<String, mpsc::Sender<Queued>>> = Lazy::new(Default::default);
+
+static HTTP_CLIENT: Lazy<reqwest::Client> = Lazy::new(|| {
+    reqwest::Client::builder()
+        .timeout(Duration::from_secs(HTTP_TIMEOUT))
+        .build()
+        .expect("http client create failed")
+});
+
+#[tracing::instrument(skip(subscription))]
+pub async fn save_subscription(
+    org_id: &str,
+    user_id: &str,
+    mut subscription: StreamSubscription,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    if !users::is_admin(org_id, user_id).await
+        && !access::can_read(
+            org_id,
+            user_id,
+            subscription.stream_type,
+            &subscription.stream_name,
+        )
+        .await
+    {
+        return Ok(MetaHttpResponse::forbidden("Unauthorized Access"));
+    }
+    subscription.name = subscription.name.trim().to_string();
+    let stored = db::subscriptions::get(org_id, &subscription.name);
+    if create && stored.is_some() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Subscription {} already exists",
+            subscription.name
+        )));
+    }
+    let now = Utc::now().timestamp_micros();
+    match stored {
+        Some(stored) => {
+            subscription.keep_secrets(&stored);
+            subscription.created_at = stored.created_at;
+        }
+        None if !create => return Ok(MetaHttpResponse::not_found("Subscription not found")),
+        None => subscription.created_at = now,
+    }
+    subscription.updated_at = now;
+    if let Err(e) = subscription.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if let Err(e) = check_sink(subscription.sink_url().unwrap_or_default()).await {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if let Err(e) = secrets::check_refs(org_id, "subscriptions", &subscription).await {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match db::subscriptions::set(org_id, &subscription).await {
+        Ok(_) => {
+            subscription.mask_secrets();
+            Ok(MetaHttpResponse::json(subscription))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Accepts the allowed hosts, or any public host when none is configured
+async fn check_sink(url: &str) -> Result<(), String> {
+    let url = url::Url::parse(url).map_err(|e| format!("Invalid sink url: {e}"))?;
+    let host = url.host_str().ok_or("The sink url has no host")?;
+    let allowed_hosts = get_config().limit.subscription_allowed_hosts.clone();
+    let allowed_hosts: Vec<&str> = allowed_hosts
+        .split(',')
+        .filter(|v| !v.trim().is_empty())
+        .collect();
+    if is_allowed_host(host, &allowed_hosts) {
+        return Ok(());
+    }
+    if !allowed_hosts.is_empty() {
+        return Err(format!("The sink host {host} is not allowed"));
+    }
+    let port = url.port_or_known_default().unwrap_or(80);
+    let addrs = tokio::net::lookup_host((host.trim_matches(['[', ']']), port))
+        .await
+        .map_err(|e| format!("Failed to resolve the sink host {host}: {e}"))?;
+    for addr in addrs {
+        if is_internal(addr.ip()) {
+            return Err(format!(
+                "The sink host {host} is an internal address, which must be allowed explicitly"
+            ));
+        }
+    }
+    Ok(())
+}
+
+/// Loopback, private, link local and unspecified addresses
+fn is_internal(ip: IpAddr) -> bool {
+    match ip {
+        IpAddr::V4(ip) => {
+            ip.is_loopback()
+                || ip.is_private()
+                || ip.is_link_local()
+                || ip.is_unspecified()
+                || ip.is_broadcast()
+        }
+        IpAddr::V6(ip) => match ip.to_ipv4_mapped() {
+            Some(ip) => is_internal(IpAddr::V4(ip)),
+            None => {
+                ip.is_loopback()
+                    || ip.is_unspecified()
+                    || is_unique_local(&ip)
+                    || (ip.segments()[0] & 0xffc0) == 0xfe80
+            }
+        },
+    }
+}
+
+fn is_unique_local(ip: &Ipv6Addr) -> bool {
+    (ip.segments()[0] & 0xfe00) == 0xfc00
+}
+
+#[tracing::instrument]
+pub async fn list_subscriptions(org_id: &str) -> Result<HttpResponse, Error> {
+    let mut list = db::subscriptions::list(org_id);
+    list.iter_mut().for_each(|s| s.mask_secrets());
+    Ok(MetaHttpResponse::json(StreamSubscriptionList { list }))
+}
+
+#[tracing::instrument]
+pub async fn get_subscription(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::subscriptions::get(org_id, name) {
+        Some(mut subscription) => {
+            subscription.mask_secrets();
+            Ok(MetaHttpResponse::json(subscription))
+        }
+        None => Ok(MetaHttpResponse::not_found("Subscription not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_subscription(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::subscriptions::get(org_id, name).is_none() {
+        return Ok(MetaHttpResponse::not_found("Subscription not found"));
+    }
+    match db::subscriptions::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Subscription deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Queues the records matching the subscriptions of the stream for delivery.
+pub async fn publish(
+    org_id: &str,
+    stream_type: &str,
+    stream_name: &str,
+    records: &[Arc<json::Value>],
+) {
+    if STREAM_SUBSCRIPTIONS.is_empty() || records.is_empty() {
+        return;
+    }
+    let now = Utc::now().timestamp_micros();
+    let prefix = format!("{org_id}/");
+    let subscriptions: Vec<StreamSubscription> = STREAM_SUBSCRIPTIONS
+        .iter()
+        .filter(|v| {
+            v.key().starts_with(&prefix)
+                && v.is_active(now)
+                && v.stream_name == stream_name
+                && v.stream_type.to_string() == stream_type
+        })
+        .map(|v| v.value().clone())
+        .collect();
+    for subscription in subscriptions {
+        let mut matched = Vec::new();
+        for record in records {
+            if matches(&subscription, record).await {
+                matched.push(record.clone());
+            }
+        }
+        if matched.is_empty() {
+            continue;
+        }
+        let tx = queue(org_id, &subscription.name);
+        let mut dropped = 0;
+        for record in matched {
+            if tx.try_send((now, record)).is_err() {
+                dropped += 1;
+            }
+        }
+        let sink = subscription.sink.to_string();
+        let labels = [org_id, subscription.name.as_str(), sink.as_str()];
+        metrics::SUBSCRIPTION_PENDING_RECORDS
+            .with_label_values(&labels)
+            .set((tx.max_capacity() - tx.capacity()) as i64);
+        if dropped > 0 {
+            metrics::SUBSCRIPTION_DROPPED_RECORDS
+                .with_label_values(&labels)
+                .inc_by(dropped);
+        }
+    }
+}
+
+async fn matches(subscription: &StreamSubscription, record: &json::Value) -> bool {
+    let Some(row) = record.as_object() else {
+        return false;
+    };
+    for condition in subscription.conditions.iter() {
+        if !condition.evaluate(row).await {
+            return false;
+        }
+    }
+    true
+}
+
+/// Returns the queue of the subscription, starting its sender on first use
+fn queue(org_id: &str, name: &str) -> mpsc::Sender<Queued> {
+    let new_queue = || {
+        let (tx, rx) = mpsc::channel(get_config().limit.subscription_queue_size);
+        tokio::task::spawn(run_sender(org_id.to_string(), name.to_string(), rx));
+        tx
+    };
+    let mut tx = QUEUES
+        .entry(format!("{org_id}/{name}"))
+        .or_insert_with(new_queue);
+    if tx.is_closed() {
+        *tx = new_queue();
+    }
+    tx.clone()
+}
+
+/// Sends the queued records in batches until the subscription is disabled,
+/// expired or deleted
+async fn run_sender(org_id: String, name: String, mut rx: mpsc::Receiver<Queued>) {
+    while let Some(record) = rx.recv().await {
+        let Some(subscription) = db::subscriptions::get(&org_id, &name)
+            .filter(|s| s.is_active(Utc::now().timestamp_micros()))
+        else {
+            break;
+        };
+        let mut batch = vec![record];
+        let deadline = tokio::time::Instant::now() + Duration::from_secs(FLUSH_INTERVAL);
+        while batch.len() < subscription.batch_size {
+            match tokio::time::timeout_at(deadline, rx.recv()).await {
+                Ok(Some(record)) => batch.push(record),
+                _ => break,
+            }
+        }
+        deliver(&org_id, &subscription, batch).await;
+        metrics::SUBSCRIPTION_PENDING_RECORDS
+            .with_label_values(&[
+                org_id.as_str(),
+                name.as_str(),
+                &subscription.sink.to_string(),
+            ])
+            .set(rx.len() as i64);
+    }
+    drop(rx);
+    QUEUES.remove_if(&format!("{org_id}/{name}"), |_, tx| tx.is_closed());
+}
+
+/// Sends a batch, retrying the failures before dropping it
+async fn deliver(org_id: &str, subscription: &StreamSubscription, batch: Vec<Queued>) {
+    let sink = subscription.sink.to_string();
+    let labels = [org_id, subscription.name.as_str(), sink.as_str()];
+    let queued_at = batch.iter().map(|(t, _)| *t).min().unwrap_or_default();
+    let batch: Vec<Arc<json::Value>> = batch.into_iter().map(|(_, r)| r).collect();
+    let max_retries = get_config().limit.subscription_max_retries;
+    let mut retries = 0;
+    loop {
+        match send(org_id, subscription, &batch).await {
+            Ok(_) => {
+                metrics::SUBSCRIPTION_RECORDS
+                    .with_label_values(&labels)
+                    .inc_by(batch.len() as u64);
+                let lag = (Utc::now().timestamp_micros() - queued_at) / 1_000_000;
+                metrics::SUBSCRIPTION_LAG_SECONDS
+                    .with_label_values(&labels)
+                    .set(lag.max(0));
+                return;
+            }
+            Err(e) if retries < max_retries => {
+                retries += 1;
+                log::warn!(
+                    "[SUBSCRIPTION] {org_id}/{} send failed, retry {retries}: {e}",
+                    subscription.name
+                );
+                tokio::time::sleep(Duration::from_secs(retries as u64)).await;
+            }
+            Err(e) => {
+                log::error!(
+                    "[SUBSCRIPTION] {org_id}/{} dropped {} records: {e}",
+                    subscription.name,
+                    batch.len()
+                );
+                metrics::SUBSCRIPTION_DROPPED_RECORDS
+                    .with_label_values(&labels)
+                    .inc_by(batch.len() as u64);
+                return;
+            }
+        }
+    }
+}
+
+async fn send(
+    org_id: &str,
+    subscription: &StreamSubscription,
+    batch: &[Arc<json::Value>],
+) -> Result<(), anyhow::Error> {
+    let subscription = secrets::resolve(org_id, "subscriptions", subscription).await?;
+    let missing = || anyhow::anyhow!("Missing {} settings", subscription.sink);
+    let req = match subscription.sink {
+        SubscriptionSink::Webhook => {
+            let s = subscription.webhook.as_ref().ok_or_else(missing)?;
+            let mut req = HTTP_CLIENT
+                .post(&s.url)
+                .header(header::CONTENT_TYPE, "application/json")
+                .body(json::to_vec(batch)?);
+            for (name, value) in s.headers.iter() {
+                req = req.header(name, value);
+            }
+            req
+        }
+        SubscriptionSink::Kafka => {
+            let s = subscription.kafka.as_ref().ok_or_else(missing)?;
+            let records: Vec<json::Value> = batch
+                .iter()
+                .map(|record| kafka_record(record, &s.key_field))
+                .collect();
+            let mut req = HTTP_CLIENT
+                .post(format!(
+                    "{}/topics/{}",
+                    s.url.trim_end_matches('/'),
+                    s.topic
+                ))
+                .header(header::CONTENT_TYPE, "application/vnd.kafka.json.v2+json")
+                .body(json::to_vec(&json::json!({ "records": records }))?);
+            for (name, value) in s.headers.iter() {
+                req = req.header(name, value);
+            }
+            req
+        }
+        SubscriptionSink::Openobserve => {
+            let s = subscription.openobserve.as_ref().ok_or_else(missing)?;
+            HTTP_CLIENT
+                .post(format!(
+                    "{}/api/{}/{}/_json",
+                    s.url.trim_end_matches('/'),
+                    s.org_id,
+                    s.stream_name
+                ))
+                .basic_auth(&s.user, Some(&s.password))
+                .header(header::CONTENT_TYPE, "application/json")
+                .body(json::to_vec(batch)?)
+        }
+        SubscriptionSink::Clickhouse => {
+            let s = subscription.clickhouse.as_ref().ok_or_else(missing)?;
+            HTTP_CLIENT
+                .post(s.url.trim_end_matches('/'))
+                .query(&[
+                    (
+                        "query",
+                        format!("INSERT INTO {}.{} FORMAT JSONEachRow", s.database, s.table),
+                    ),
+                    ("input_format_skip_unknown_fields", "1".to_string()),
+                ])
+                .basic_auth(&s.user, Some(&s.password))
+                .body(json_lines(batch, None)?)
+        }
+        SubscriptionSink::Elasticsearch => {
+            let s = subscription.elasticsearch.as_ref().ok_or_else(missing)?;
+            let mut req = HTTP_CLIENT
+                .post(format!("{}/_bulk", s.url.trim_end_matches('/')))
+                .header(header::CONTENT_TYPE, "application/x-ndjson")
+                .body(json_lines(batch, Some(&s.index))?);
+            if !s.user.is_empty() {
+                req = req.basic_auth(&s.user, Some(&s.password));
+            }
+            for (name, value) in s.headers.iter() {
+                req = req.header(name, value);
+            }
+            req
+        }
+    };
+    let resp = req.send().await?;
+    let status = resp.status();
+    if !status.is_success() {
+        let body = resp.text().await.unwrap_or_default();
+        return Err(anyhow::anyhow!(
+            "{} error: {status} {body}",
+            subscription.sink
+        ));
+    }
+    if subscription.sink == SubscriptionSink::Elasticsearch {
+        // the bulk API answers 200 even when some of the documents failed
+        let body: json::Value = json::from_slice(&resp.bytes().await?)?;
+        if let Some(e) = bulk_error(&body) {
+            return Err(anyhow::anyhow!("elasticsearch error: {e}"));
+        }
+    }
+    Ok(())
+}
+
+/// One JSON record per line, each preceded by the index action of the bulk
+/// API when an index is given
+fn json_lines(batch: &[Arc<json::Value>], index: Option<&str>) -> Result<Vec<u8>, anyhow::Error> {
+    let action = index.map(|index| json::json!({ "index": { "_index": index } }));
+    let mut body = Vec::new();
+    for record in batch {
+        if let Some(action) = action.as_ref() {
+            body.extend(json::to_vec(action)?);
+            body.push(b'\n');
+        }
+        body.extend(json::to_vec(record)?);
+        body.push(b'\n');
+    }
+    Ok(body)
+}
+
+/// Returns the reason of the first failed document of a bulk response
+fn bulk_error(body: &json::Value) -> Option<String> {
+    if !body
+        .get("errors")
+        .and_then(|v| v.as_bool())
+        .unwrap_or(false)
+    {
+        return None;
+    }
+    let error = body
+        .get("items")
+        .and_then(|v| v.as_array())
+        .into_iter()
+        .flatten()
+        .filter_map(|item| item.as_object()?.values().next()?.get("error"))
+        .next();
+    Some(match error {
+        Some(e) => e
+            .get("reason")
+            .and_then(|v| v.as_str())
+            .map(|s| s.to_string())
+            .unwrap_or_else(|| e.to_string()),
+        None => "some documents failed".to_string(),
+    })
+}
+
+/// Message of the Kafka REST Proxy for the record, keyed by `key_field`
+fn kafka_record(record: &json::Value, key_field: &str) -> json::Value {
+    match record.get(key_field).filter(|_| !key_field.is_empty()) {
+        Some(key) => json::json!({ "key": key, "value": record }),
+        None => json::json!({ "value": record }),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::common::meta::alerts::{Condition, Operator};
+
+    #[test]
+    fn test_is_internal() {
+        let internal = |ip: &str| is_internal(ip.parse().unwrap());
+        assert!(internal("127.0.0.1"));
+        assert!(internal("10.1.2.3"));
+        assert!(internal("192.168.0.1"));
+        assert!(internal("169.254.169.254"));
+        assert!(internal("0.0.0.0"));
+        assert!(internal("::1"));
+        assert!(internal("fd00::1"));
+        assert!(internal("fe80::1"));
+        assert!(internal("::ffff:10.0.0.1"));
+        assert!(!internal("8.8.8.8"));
+        assert!(!internal("2001:4860:4860::8888"));
+    }
+
+    #[tokio::test]
+    async fn test_check_sink() {
+        assert!(check_sink("http://127.0.0.1:8080/events").await.is_err());
+        assert!(check_sink("http://[::1]/events").await.is_err());
+        assert!(check_sink("not a url").await.is_err());
+    }
+
+    #[test]
+    fn test_kafka_record() {
+        let record = json::json!({"user": "alice", "action": "login"});
+        assert_eq!(
+            kafka_record(&record, "user"),
+            json::json!({"key": "alice", "value": record})
+        );
+        assert_eq!(kafka_record(&record, ""), json::json!({ "value": record }));
+        assert_eq!(
+            kafka_record(&record, "missing"),
+            json::json!({ "value": record })
+        );
+    }
+
+    #[test]
+    fn test_json_lines() {
+        let batch = vec![
+            Arc::new(json::json!({"a": 1})),
+            Arc::new(json::json!({"a": 2})),
+        ];
+        assert_eq!(
+            String::from_utf8(json_lines(&batch, None).unwrap()).unwrap(),
+            "{\"a\":1}\n{\"a\":2}\n"
+        );
+        assert_eq!(
+            String::from_utf8(json_lines(&batch[..1], Some("logs")).unwrap()).unwrap(),
+            "{\"index\":{\"_index\":\"logs\"}}\n{\"a\":1}\n"
+        );
+    }
+
+    #[test]
+    fn test_bulk_error() {
+        assert_eq!(
+            bulk_error(&json::json!({"errors": false, "items": []})),
+            None
+        );
+        let body = json::json!({
+            "errors": true,
+            "items": [
+                {"index": {"status": 201}},
+                {"index": {"status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [code]"}}}
+            ]
+        });
+        assert_eq!(
+            bulk_error(&body).as_deref(),
+            Some("failed to parse field [code]")
+        );
+    }
+
+    #[tokio::test]
+    async fn test_matches() {
+        let mut subscription = StreamSubscription {
+            name: "failed_logins".to_string(),
+            stream_name: "auth".to_string(),
+            ..Default::default()
+        };
+        let record = json::json!({"action": "login", "status": 401});
+        assert!(matches(&subscription, &record).await);
+        subscription.conditions = vec![
+            Condition {
+                column: "action".to_string(),
+                operator: Operator::EqualTo,
+                value: json::json!("login"),
+                ignore_case: false,
+            },
+            Condition {
+                column: "status".to_string(),
+                operator: Operator::GreaterThanEquals,
+                value: json::json!(400),
+                ignore_case: false,
+            },
+        ];
+        assert!(matches(&subscription, &record).await);
+        let record = json::json!({"action": "login", "status": 200});
+        assert!(!matches(&subscription, &record).await);
+        assert!(!matches(&subscription, &json::json!("login")).await);
+    }
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Browser checks run the Playwright script of the check with node. The
+//! harness below launches chromium, hands the script the page and a `step`
+//! helper, and prints the timings, urls and screenshots of the steps as json.
+
+use std::{process::Stdio, time::Duration};
+
+use config::{get_config, utils::json};
+use serde::Deserialize;
+use tokio::{io::AsyncWriteExt, process::Command, time::timeout};
+
+use crate::common::meta::synthetics::{StepResult, SyntheticCheck};
+
+const HARNESS: &str = r#"
+const { chromium } = require("playwright");
+const input = JSON.parse(require("fs").readFileSync(0, "utf8"));
+const AsyncFunction = Object.getPrototypeOf(async function () {}).constructor;
+(async () => {
+  const steps = [];
+  let error = null;
+  const browser = await chromium.launch();
+  try {
+    const context = await browser.newContext({
+      viewport: { width: input.viewport_width, height: input.viewport_height },
+    });
+    const page = await context.newPage();
+    page.setDefaultTimeout(input.timeout_ms);
+    const step = async (name, fn) => {
+      const result = { name, duration_ms: 0, success: true, url: "" };
+      steps.push(result);
+      const start = performance.now();
+      try {
+        await fn();
+      } catch (e) {
+        result.success = false;
+        result.error = String((e && e.message) || e);
+        throw e;
+      } finally {
+        result.duration_ms = performance.now() - start;
+        result.url = page.url();
+        if (input.screenshots || !result.success) {
+          try {
+            result.screenshot = (await page.screenshot()).toString("base64");
+          } catch (e) {}
+        }
+      }
+    };
+    await new AsyncFunction("page", "context", "step", "target", input.script)(
+      page, context, step, input.target);
+  } catch (e) {
+    error = String((e && e.message) || e);
+  } finally {
+    await browser.close();
+  }
+  process.stdout.write(JSON.stringify({ steps, error }));
+})();
+"#;
+
+/// What the harness prints
+#[derive(Debug, Default, Deserialize)]
+pub(super) struct Journey {
+    #[serde(default)]
+    pub steps: Vec<StepResult>,
+    #[serde(default)]
+    pub error: Option<String>,
+}
+
+/// Runs the script of the check, the steps are returned even when one of them
+/// fails
+pub(super) async fn run(check: &SyntheticCheck, wait: Duration) -> Result<Journey, anyhow::Error> {
+    let settings = check.browser.clone().unwrap_or_default();
+    let input = json::json!({
+        "target": check.target,
+        "script": settings.script,
+        "viewport_width": settings.viewport_width,
+        "viewport_height": settings.viewport_height,
+        "screenshots": settings.screenshots,
+        "timeout_ms": wait.as_millis() as u64,
+    });
+
+    let cfg = get_config();
+    let mut child = Command::new(&cfg.synthetics.browser_command)
+        .args(["-e", HARNESS])
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()
+        .map_err(|e| anyhow::anyhow!("run {}: {e}", cfg.synthetics.browser_command))?;
+    let mut stdin = child.stdin.take().unwrap();
+    stdin.write_all(&json::to_vec(&input)?).await?;
+    drop(stdin);
+
+    // the timeout of the check applies to every action of the script, leave
+    // room for launching the browser
+    let output = timeout(wait * 3 + Duration::from_secs(10), child.wait_with_output())
+        .await
+        .map_err(|_| anyhow::anyhow!("script timeout"))??;
+    parse_output(&output.stdout, &output.stderr)
+}
+
+fn parse_output(stdout: &[u8], stderr: &[u8]) -> Result<Journey, anyhow::Error> {
+    match json::from_slice::<Journey>(stdout) {
+        Ok(journey) => Ok(journey),
+        Err(_) => {
+            let stderr = String::from_utf8_lossy(stderr);
+            let reason = stderr.lines().find(|line| !line.trim().is_empty());
+            Err(anyhow::anyhow!(
+                "browser harness failed: {}",
+                reason.unwrap_or("no output")
+            ))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_output() {
+        let stdout = br#"{"steps":[{"name":"home","duration_ms":812.4,"success":true,"url":"https://example.com/"},{"name":"login","duration_ms":5003.1,"success":false,"url":"https://example.com/login","error":"Timeout 5000ms exceeded.","screenshot":"iVBORw0KGgo="}],"error":"Timeout 5000ms exceeded."}"#;
+        let journey = parse_output(stdout, b"").unwrap();
+        assert_eq!(journey.steps.len(), 2);
+        assert!(journey.steps[0].success);
+        assert_eq!(journey.steps[1].screenshot.as_deref(), Some("iVBORw0KGgo="));
+        assert_eq!(journey.error.as_deref(), Some("Timeout 5000ms exceeded."));
+
+        let err = parse_output(b"", b"\nError: Cannot find module 'playwright'\n").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "browser harness failed: Error: Cannot find module 'playwright'"
+        );
+    }
+}
@@ -0,0 +1,597 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Synthetic monitoring: HTTP, TCP, DNS, ICMP and scripted browser checks run
+//! on a schedule from one or more locations. The ingesters run the checks of
+//! the built-in location, the `synthetics-runner` command runs the checks of
+//! another location and reports the results through the API. Browser checks
+//! run a user script, so only the runners run them. The results are written
+//! to a logs stream and as `synthetics_*` metrics, so the usual alerts apply.
+//! The steps of browser checks are also written to the RUM data stream as
+//! views of a `synthetics` session, their screenshots to the storage.
+
+use std::{
+    collections::{HashMap, HashSet},
+    io::Error,
+};
+
+use actix_web::{web, HttpResponse};
+use chrono::Utc;
+use config::{
+    cluster::{is_single_node, LOCAL_NODE_ROLE, LOCAL_NODE_UUID},
+    get_config, ider,
+    utils::{base64, json},
+};
+use infra::storage;
+use prost::Message;
+use proto::prometheus_rpc::{Label, Sample, TimeSeries, WriteRequest};
+
+use crate::{
+    common::{
+        infra::cluster::get_ingester_by_key,
+        meta::{
+            http::HttpResponse as MetaHttpResponse,
+            ingestion::IngestionRequest,
+            prom::NAME_LABEL,
+            synthetics::{CheckResult, CheckType, SyntheticCheck, SyntheticCheckList},
+        },
+    },
+    service::{db, logs, metrics::prom},
+};
+
+mod browser;
+pub mod probe;
+pub mod runner;
+
+/// Seconds between two reloads of the checks
+const CONFIG_RELOAD_INTERVAL: i64 = 30;
+/// Stream of the data sent by the RUM browser SDK
+const RUM_DATA_STREAM: &str = "_rumdata";
+
+#[tracing::instrument(skip(check))]
+pub async fn save_check(
+    org_id: &str,
+    user_email: &str,
+    mut check: SyntheticCheck,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    check.name = check.name.trim().to_string();
+    if let Err(e) = check
+        .validate()
+        .and_then(|_| check.validate_location(&get_config().synthetics.location))
+    {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match db::synthetics::get(org_id, &check.name).await {
+        Ok(_) if create => {
+            return Ok(MetaHttpResponse::bad_request(format!(
+                "Check {} already exists",
+                check.name
+            )));
+        }
+        Ok(stored) => check.owner = stored.owner,
+        Err(_) if !create => return Ok(MetaHttpResponse::not_found("Check not found")),
+        Err(_) => check.owner = user_email.to_string(),
+    }
+    match db::synthetics::set(org_id, &check).await {
+        Ok(_) => Ok(MetaHttpResponse::json(check)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_checks(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::synthetics::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(SyntheticCheckList { list })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_check(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::synthetics::get(org_id, name).await {
+        Ok(check) => Ok(MetaHttpResponse::json(check)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Check not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_check(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::synthetics::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Check not found"));
+    }
+    match db::synthetics::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Check deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Replaces the script of a browser check
+#[tracing::instrument(skip(script))]
+pub async fn set_script(org_id: &str, name: &str, script: String) -> Result<HttpResponse, Error> {
+    let mut check = match db::synthetics::get(org_id, name).await {
+        Ok(check) => check,
+        Err(_) => return Ok(MetaHttpResponse::not_found("Check not found")),
+    };
+    if check.check_type != CheckType::Browser {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Check {name} is not a browser check"
+        )));
+    }
+    check.browser.get_or_insert_with(Default::default).script = script;
+    if let Err(e) = check
+        .validate()
+        .and_then(|_| check.validate_location(&get_config().synthetics.location))
+    {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match db::synthetics::set(org_id, &check).await {
+        Ok(_) => Ok(MetaHttpResponse::json(check)),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Returns the png taken at the end of a step of a browser check run
+#[tracing::instrument]
+pub async fn get_screenshot(
+    org_id: &str,
+    name: &str,
+    run_id: &str,
+    step: usize,
+) -> Result<HttpResponse, Error> {
+    if run_id.is_empty() || !run_id.chars().all(|c| c.is_ascii_alphanumeric()) {
+        return Ok(MetaHttpResponse::bad_request("Invalid run id"));
+    }
+    match storage::get(&screenshot_key(org_id, name, run_id, step)).await {
+        Ok(data) => Ok(HttpResponse::Ok().content_type("image/png").body(data)),
+        Err(_) => Ok(MetaHttpResponse::not_found("Screenshot not found")),
+    }
+}
+
+/// Runs the check once from this node without saving the result, the browser
+/// checks only run at the locations of a runner
+#[tracing::instrument(skip(check))]
+pub async fn test_check(check: SyntheticCheck) -> Result<HttpResponse, Error> {
+    if let Err(e) = check.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    if check.check_type == CheckType::Browser {
+        return Ok(MetaHttpResponse::bad_request(
+            "Browser checks can only run at the locations of a synthetics-runner",
+        ));
+    }
+    let location = &get_config().synthetics.location;
+    Ok(MetaHttpResponse::json(
+        probe::run_check(&check, location).await,
+    ))
+}
+
+/// Lists the enabled checks a runner of the location should run
+#[tracing::instrument]
+pub async fn list_location_checks(org_id: &str, location: &str) -> Result<HttpResponse, Error> {
+    let default_location = &get_config().synthetics.location;
+    match db::synthetics::list(org_id).await {
+        Ok(list) => Ok(MetaHttpResponse::json(SyntheticCheckList {
+            list: list
+                .into_iter()
+                .filter(|check| check.enabled && check.runs_at(location, default_location))
+                .collect(),
+        })),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Saves the results reported by a runner
+#[tracing::instrument(skip(results))]
+pub async fn report_results(
+    org_id: &str,
+    user_email: &str,
+    results: Vec<CheckResult>,
+) -> Result<HttpResponse, Error> {
+    let count = results.len();
+    match write_results(org_id, user_email, results).await {
+        Ok(_) => Ok(MetaHttpResponse::ok(format!("{count} results saved"))),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Keeps the checks of the built-in location and the time each one is due
+/// next
+#[derive(Default)]
+pub struct Scheduler {
+    checks: Vec<(String, SyntheticCheck)>,
+    reloaded_at: i64,
+    next_runs: HashMap<String, i64>,
+}
+
+impl Scheduler {
+    /// Runs the checks which are due, owned by this node
+    pub async fn run(&mut self) -> Result<(), anyhow::Error> {
+        let now = Utc::now().timestamp();
+        let location = &get_config().synthetics.location;
+        if now - self.reloaded_at >= CONFIG_RELOAD_INTERVAL {
+            self.checks = db::synthetics::list_all()
+                .await?
+                .into_iter()
+                // the checks saved before browser checks were restricted to
+                // the runners never run their script here
+                .filter(|(_, check)| {
+                    check.enabled
+                        && check.check_type != CheckType::Browser
+                        && check.runs_at(location, location)
+                })
+                .collect();
+            self.reloaded_at = now;
+        }
+
+        for (org_id, check) in self.checks.iter() {
+            let key = format!("{org_id}/{}", check.name);
+            if self.next_runs.get(&key).is_some_and(|t| *t > now) {
+                continue;
+            }
+            self.next_runs
+                .insert(key.clone(), now + check.interval as i64);
+            if !is_single_node(&LOCAL_NODE_ROLE)
+                && get_ingester_by_key(&format!("synthetics/{key}")).await
+                    != Some(LOCAL_NODE_UUID.clone())
+            {
+                continue;
+            }
+            let org_id = org_id.clone();
+            let check = check.clone();
+            let location = location.clone();
+            tokio::task::spawn(async move {
+                let result = probe::run_check(&check, &location).await;
+                if let Err(e) = write_results(&org_id, &check.owner, vec![result]).await {
+                    log::error!(
+                        "[SYNTHETICS] {org_id}/{} write result error: {e}",
+                        check.name
+                    );
+                }
+            });
+        }
+        // forget the deleted checks
+        let keys = self
+            .checks
+            .iter()
+            .map(|(org_id, check)| format!("{org_id}/{}", check.name))
+            .collect::<HashSet<_>>();
+        self.next_runs.retain(|key, _| keys.contains(key));
+        Ok(())
+    }
+}
+
+/// Writes the results to the logs stream and as metrics, the steps of browser
+/// checks as RUM views
+async fn write_results(
+    org_id: &str,
+    user_email: &str,
+    mut results: Vec<CheckResult>,
+) -> Result<(), anyhow::Error> {
+    if results.is_empty() {
+        return Ok(());
+    }
+    for result in results.iter_mut() {
+        if result.run_id.is_empty() {
+            result.run_id = ider::generate();
+        }
+        save_screenshots(org_id, result).await;
+    }
+
+    let records = results.iter().map(result_record).collect::<Vec<_>>();
+    let data = web::Bytes::from(json::to_vec(&records)?);
+    logs::ingest::ingest(
+        org_id,
+        &get_config().synthetics.stream,
+        IngestionRequest::JSON(&data),
+        user_email,
+        false,
+    )
+    .await?;
+
+    let views = results.iter().flat_map(step_records).collect::<Vec<_>>();
+    if !views.is_empty() {
+        let data = web::Bytes::from(json::to_vec(&views)?);
+        logs::ingest::ingest(
+            org_id,
+            RUM_DATA_STREAM,
+            IngestionRequest::JSON(&data),
+            user_email,
+            false,
+        )
+        .await?;
+    }
+
+    let timeseries = results.iter().flat_map(result_series).collect();
+    let req = WriteRequest {
+        timeseries,
+        metadata: vec![],
+    }
+    .encode_to_vec();
+    let body = snap::raw::Encoder::new().compress_vec(&req)?;
+    prom::remote_write(org_id, body.into()).await
+}
+
+fn screenshot_key(org_id: &str, check: &str, run_id: &str, step: usize) -> String {
+    format!("synthetics/{org_id}/{check}/{run_id}/{step}.png")
+}
+
+/// Moves the screenshots of the steps to the storage and keeps their
+/// `{run_id}/{step}` path, a screenshot failing to save is dropped rather than
+/// the result
+async fn save_screenshots(org_id: &str, result: &mut CheckResult) {
+    for (index, step) in result.steps.iter_mut().enumerate() {
+        let Some(screenshot) = step.screenshot.take() else {
+            continue;
+        };
+        let data = match base64::decode_raw(&screenshot) {
+            Ok(data) => data,
+            Err(e) => {
+                log::warn!("[SYNTHETICS] {org_id}/{} screenshot: {e}", result.check);
+                continue;
+            }
+        };
+        let key = screenshot_key(org_id, &result.check, &result.run_id, index);
+        match storage::put(&key, data.into()).await {
+            Ok(_) => step.screenshot = Some(format!("{}/{index}", result.run_id)),
+            Err(e) => log::error!("[SYNTHETICS] save screenshot {key} error: {e}"),
+        }
+    }
+}
+
+/// The steps of a browser check as the views of a `synthetics` RUM session,
+/// one after the other from the start of the run
+fn step_records(result: &CheckResult) -> Vec<json::Value> {
+    let mut timestamp = result.timestamp;
+    result
+        .steps
+        .iter()
+        .enumerate()
+        .map(|(index, step)| {
+            let record = json::json!({
+                "_timestamp": timestamp,
+                "date": timestamp / 1000,
+                "type": "view",
+                "source": "synthetics",
+                "service": "synthetics",
+                "session": {
+                    "id": result.run_id,
+                    "type": "synthetics",
+                },
+                "view": {
+                    "id": format!("{}-{index}", result.run_id),
+                    "name": step.name,
+                    "url": step.url,
+                    // nanoseconds, as sent by the SDK
+                    "loading_time": (step.duration_ms * 1_000_000.0) as i64,
+                },
+                "synthetics": {
+                    "check": result.check,
+                    "location": result.location,
+                    "step": step.name,
+                    "step_index": index,
+                    "success": step.success,
+                    "error": step.error,
+                    "screenshot": step.screenshot,
+                    "labels": result.labels,
+                },
+            });
+            timestamp += (step.duration_ms * 1000.0) as i64;
+            record
+        })
+        .collect()
+}
+
+fn result_record(result: &CheckResult) -> json::Value {
+    let mut record = json::to_value(result).unwrap_or_default();
+    if let Some(map) = record.as_object_mut() {
+        map.remove("timestamp");
+        map.insert(
+            "_timestamp".to_string(),
+            json::Value::from(result.timestamp),
+        );
+        if !result.failed_assertions.is_empty() {
+            map.insert(
+                "failed_assertions".to_string(),
+                json::Value::from(result.failed_assertions.join("; ")),
+            );
+        }
+        if !result.resolved_addresses.is_empty() {
+            map.insert(
+                "resolved_addresses".to_string(),
+                json::Value::from(result.resolved_addresses.join(",")),
+            );
+        }
+        // the steps are written as RUM views
+        if map.remove("steps").is_some() {
+            map.insert(
+                "step_count".to_string(),
+                json::Value::from(result.steps.len()),
+            );
+            if let Some(step) = result.steps.iter().find(|step| !step.success) {
+                map.insert(
+                    "failed_step".to_string(),
+                    json::Value::from(step.name.clone()),
+                );
+            }
+        }
+    }
+    record
+}
+
+/// `synthetics_success` and `synthetics_response_time_seconds` of the result,
+/// with a `synthetics_step_duration_seconds` for each step of a browser check
+fn result_series(result: &CheckResult) -> Vec<TimeSeries> {
+    let mut labels = vec![
+        Label {
+            name: "check".to_string(),
+            value: result.check.clone(),
+        },
+        Label {
+            name: "check_type".to_string(),
+            value: result.check_type.to_string(),
+        },
+        Label {
+            name: "location".to_string(),
+            value: result.location.clone(),
+        },
+    ];
+    for (name, value) in result.labels.iter() {
+        if !labels.iter().any(|l| &l.name == name) && name != NAME_LABEL {
+            labels.push(Label {
+                name: name.clone(),
+                value: value.clone(),
+            });
+        }
+    }
+    let timestamp = result.timestamp / 1000;
+    [
+        (
+            "synthetics_success",
+            if result.success { 1.0 } else { 0.0 },
+            None,
+        ),
+        (
+            "synthetics_response_time_seconds",
+            result.response_time_ms / 1000.0,
+            None,
+        ),
+    ]
+    .into_iter()
+    .chain(result.steps.iter().map(|step| {
+        (
+            "synthetics_step_duration_seconds",
+            step.duration_ms / 1000.0,
+            Some(step.name.clone()),
+        )
+    }))
+    .map(|(name, value, step)| {
+        let mut labels = labels.clone();
+        labels.push(Label {
+            name: NAME_LABEL.to_string(),
+            value: name.to_string(),
+        });
+        if let Some(step) = step {
+            labels.retain(|l| l.name != "step");
+            labels.push(Label {
+                name: "step".to_string(),
+                value: step,
+            });
+        }
+        labels.sort_by(|a, b| a.name.cmp(&b.name));
+        TimeSeries {
+            labels,
+            samples: vec![Sample { value, timestamp }],
+            ..Default::default()
+        }
+    })
+    .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::common::meta::synthetics::StepResult;
+
+    fn result() -> CheckResult {
+        CheckResult {
+            timestamp: 1704164645000000,
+            check: "homepage".to_string(),
+            check_type: CheckType::Http,
+            target: "https://example.com".to_string(),
+            location: "eu-west".to_string(),
+            success: false,
+            response_time_ms: 250.0,
+            status_code: Some(503),
+            failed_assertions: vec!["status_code eq 200".to_string()],
+            labels: HashMap::from([("team".to_string(), "web".to_string())]),
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_result_record() {
+        let record = result_record(&result());
+        assert_eq!(record["_timestamp"], 1704164645000000_i64);
+        assert!(record.get("timestamp").is_none());
+        assert_eq!(record["status_code"], 503);
+        assert_eq!(record["failed_assertions"], "status_code eq 200");
+        assert_eq!(record["labels"]["team"], "web");
+    }
+
+    #[test]
+    fn test_browser_result() {
+        let mut result = result();
+        result.check_type = CheckType::Browser;
+        result.run_id = "7167374950286102528".to_string();
+        result.steps = vec![
+            StepResult {
+                name: "home".to_string(),
+                duration_ms: 800.0,
+                success: true,
+                url: "https://example.com/".to_string(),
+                ..Default::default()
+            },
+            StepResult {
+                name: "login".to_string(),
+                duration_ms: 5000.0,
+                success: false,
+                error: Some("Timeout 5000ms exceeded.".to_string()),
+                screenshot: Some("7167374950286102528/1".to_string()),
+                ..Default::default()
+            },
+        ];
+
+        let record = result_record(&result);
+        assert!(record.get("steps").is_none());
+        assert_eq!(record["step_count"], 2);
+        assert_eq!(record["failed_step"], "login");
+
+        let views = step_records(&result);
+        assert_eq!(views.len(), 2);
+        assert_eq!(views[0]["session"]["type"], "synthetics");
+        assert_eq!(views[0]["view"]["loading_time"], 800000000_i64);
+        assert_eq!(views[1]["_timestamp"], 1704164645800000_i64);
+        assert_eq!(views[1]["view"]["id"], "7167374950286102528-1");
+        assert_eq!(
+            views[1]["synthetics"]["screenshot"],
+            "7167374950286102528/1"
+        );
+
+        let series = result_series(&result);
+        assert_eq!(series.len(), 4);
+        let step = series[3].labels.iter().find(|l| l.name == "step").unwrap();
+        assert_eq!(step.value, "login");
+        assert_eq!(series[3].samples[0].value, 5.0);
+    }
+
+    #[test]
+    fn test_result_series() {
+        let series = result_series(&result());
+        assert_eq!(series.len(), 2);
+        let names = series[0]
+            .labels
+            .iter()
+            .map(|l| l.name.as_str())
+            .collect::<Vec<_>>();
+        assert_eq!(
+            names,
+            vec!["__name__", "check", "check_type", "location", "team"]
+        );
+        assert_eq!(series[0].samples[0].value, 0.0);
+        assert_eq!(series[0].samples[0].timestamp, 1704164645000);
+        assert_eq!(series[1].samples[0].value, 0.25);
+    }
+}
@@ -0,0 +1,365 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use std::{
+    collections::HashMap,
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use regex::Regex;
+use tokio::{net::TcpStream, process::Command, time::timeout};
+
+use super::browser;
+use crate::common::meta::synthetics::{
+    Assertion, AssertionOperator, AssertionSource, CheckResult, CheckType, StepResult,
+    SyntheticCheck,
+};
+
+/// Bytes of the body kept for the assertions, the rest is not read
+const MAX_BODY_SIZE: usize = 1024 * 1024;
+const MAX_REDIRECTS: usize = 10;
+
+/// What the target answered
+#[derive(Debug, Default)]
+struct Response {
+    status_code: Option<u16>,
+    headers: HashMap<String, String>,
+    body: String,
+    resolved_addresses: Vec<String>,
+    response_time_ms: f64,
+    steps: Vec<StepResult>,
+    /// Failure of a browser script, which still has the steps run until then
+    error: Option<String>,
+}
+
+/// Runs the check once and evaluates its assertions
+pub async fn run_check(check: &SyntheticCheck, location: &str) -> CheckResult {
+    let mut result = CheckResult {
+        timestamp: Utc::now().timestamp_micros(),
+        check: check.name.clone(),
+        check_type: check.check_type,
+        target: check.target.clone(),
+        location: location.to_string(),
+        labels: check.labels.clone(),
+        ..Default::default()
+    };
+    let start = Instant::now();
+    let wait = Duration::from_secs(check.timeout);
+    let resp = match check.check_type {
+        CheckType::Http => probe_http(check, wait).await,
+        CheckType::Tcp => probe_tcp(&check.target, wait).await,
+        CheckType::Dns => probe_dns(&check.target, wait).await,
+        CheckType::Icmp => probe_icmp(&check.target, wait).await,
+        CheckType::Browser => probe_browser(check, wait).await,
+    };
+    match resp {
+        Ok(resp) => {
+            result.failed_assertions = check
+                .assertions
+                .iter()
+                .filter(|assertion| !evaluate(assertion, &resp))
+                .map(|assertion| assertion.to_string())
+                .collect();
+            if check.check_type == CheckType::Http
+                && check.assertions.is_empty()
+                && resp.status_code.unwrap_or_default() >= 400
+            {
+                result
+                    .failed_assertions
+                    .push("status_code lt 400".to_string());
+            }
+            result.success = result.failed_assertions.is_empty() && resp.error.is_none();
+            result.response_time_ms = resp.response_time_ms;
+            result.status_code = resp.status_code;
+            result.resolved_addresses = resp.resolved_addresses;
+            result.steps = resp.steps;
+            result.error = resp.error;
+        }
+        Err(e) => {
+            result.response_time_ms = start.elapsed().as_secs_f64() * 1000.0;
+            result.error = Some(e.to_string());
+        }
+    }
+    result
+}
+
+async fn probe_http(check: &SyntheticCheck, wait: Duration) -> Result<Response, anyhow::Error> {
+    let settings = check.http.clone().unwrap_or_default();
+    let redirect = if settings.follow_redirects {
+        reqwest::redirect::Policy::limited(MAX_REDIRECTS)
+    } else {
+        reqwest::redirect::Policy::none()
+    };
+    let client = reqwest::Client::builder()
+        .timeout(wait)
+        .redirect(redirect)
+        .build()?;
+    let method = reqwest::Method::from_bytes(settings.method.to_uppercase().as_bytes())?;
+    let mut req = client.request(method, &check.target);
+    for (name, value) in settings.headers.iter() {
+        req = req.header(name, value);
+    }
+    if !settings.body.is_empty() {
+        req = req.body(settings.body.clone());
+    }
+
+    let start = Instant::now();
+    let mut resp = req.send().await?;
+    let status_code = resp.status().as_u16();
+    let headers = resp
+        .headers()
+        .iter()
+        .map(|(name, value)| {
+            (
+                name.as_str().to_lowercase(),
+                String::from_utf8_lossy(value.as_bytes()).to_string(),
+            )
+        })
+        .collect();
+    let mut body = Vec::new();
+    while let Some(chunk) = resp.chunk().await? {
+        body.extend_from_slice(&chunk);
+        if body.len() >= MAX_BODY_SIZE {
+            body.truncate(MAX_BODY_SIZE);
+            break;
+        }
+    }
+    Ok(Response {
+        status_code: Some(status_code),
+        headers,
+        body: String::from_utf8_lossy(&body).to_string(),
+        response_time_ms: start.elapsed().as_secs_f64() * 1000.0,
+        ..Default::default()
+    })
+}
+
+async fn probe_tcp(target: &str, wait: Duration) -> Result<Response, anyhow::Error> {
+    let start = Instant::now();
+    timeout(wait, TcpStream::connect(target))
+        .await
+        .map_err(|_| anyhow::anyhow!("connect timeout"))??;
+    Ok(Response {
+        response_time_ms: start.elapsed().as_secs_f64() * 1000.0,
+        ..Default::default()
+    })
+}
+
+async fn probe_dns(host: &str, wait: Duration) -> Result<Response, anyhow::Error> {
+    let start = Instant::now();
+    let addrs = timeout(wait, tokio::net::lookup_host(format!("{host}:0")))
+        .await
+        .map_err(|_| anyhow::anyhow!("resolve timeout"))??;
+    let mut resolved_addresses = addrs.map(|addr| addr.ip().to_string()).collect::<Vec<_>>();
+    resolved_addresses.sort();
+    resolved_addresses.dedup();
+    if resolved_addresses.is_empty() {
+        return Err(anyhow::anyhow!("no address found for {host}"));
+    }
+    Ok(Response {
+        resolved_addresses,
+        response_time_ms: start.elapsed().as_secs_f64() * 1000.0,
+        ..Default::default()
+    })
+}
+
+/// Sends one echo request with the `ping` command, opening raw sockets needs
+/// privileges the process usually does not have
+async fn probe_icmp(host: &str, wait: Duration) -> Result<Response, anyhow::Error> {
+    let start = Instant::now();
+    let output = timeout(
+        wait + Duration::from_secs(1),
+        Command::new("ping")
+            .args(["-c", "1", "-W", &wait.as_secs().to_string(), host])
+            .kill_on_drop(true)
+            .output(),
+    )
+    .await
+    .map_err(|_| anyhow::anyhow!("ping timeout"))??;
+    if !output.status.success() {
+        return Err(anyhow::anyhow!("no reply from {host}"));
+    }
+    let stdout = String::from_utf8_lossy(&output.stdout);
+    Ok(Response {
+        response_time_ms: parse_ping_time(&stdout)
+            .unwrap_or_else(|| start.elapsed().as_secs_f64() * 1000.0),
+        ..Default::default()
+    })
+}
+
+/// The response time of a journey is the time spent in its steps, without
+/// launching the browser
+async fn probe_browser(check: &SyntheticCheck, wait: Duration) -> Result<Response, anyhow::Error> {
+    let start = Instant::now();
+    let journey = browser::run(check, wait).await?;
+    let response_time_ms = if journey.steps.is_empty() {
+        start.elapsed().as_secs_f64() * 1000.0
+    } else {
+        journey.steps.iter().map(|step| step.duration_ms).sum()
+    };
+    Ok(Response {
+        response_time_ms,
+        steps: journey.steps,
+        error: journey.error,
+        ..Default::default()
+    })
+}
+
+/// Reads the round trip time of `ping` output, e.g. `time=12.3 ms`
+fn parse_ping_time(output: &str) -> Option<f64> {
+    let (_, rest) = output.split_once("time=")?;
+    rest.split(|c: char| !c.is_ascii_digit() && c != '.')
+        .next()?
+        .parse()
+        .ok()
+}
+
+fn evaluate(assertion: &Assertion, resp: &Response) -> bool {
+    let op = assertion.operator;
+    let target = assertion.target.as_str();
+    match assertion.source {
+        AssertionSource::StatusCode => resp
+            .status_code
+            .is_some_and(|code| compare(&code.to_string(), op, target)),
+        AssertionSource::ResponseTime => compare(&resp.response_time_ms.to_string(), op, target),
+        AssertionSource::Body => compare(&resp.body, op, target),
+        AssertionSource::Header => match resp.headers.get(&assertion.property.to_lowercase()) {
+            Some(value) => compare(value, op, target),
+            None => matches!(
+                op,
+                AssertionOperator::NotEq | AssertionOperator::NotContains
+            ),
+        },
+        AssertionSource::ResolvedAddress => match op {
+            // none of the addresses should match
+            AssertionOperator::NotEq | AssertionOperator::NotContains => resp
+                .resolved_addresses
+                .iter()
+                .all(|addr| compare(addr, op, target)),
+            _ => resp
+                .resolved_addresses
+                .iter()
+                .any(|addr| compare(addr, op, target)),
+        },
+    }
+}
+
+/// Compares the values as numbers when both are numbers, as strings otherwise
+fn compare(value: &str, op: AssertionOperator, target: &str) -> bool {
+    let numbers = value
+        .parse::<f64>()
+        .ok()
+        .zip(target.trim().parse::<f64>().ok());
+    match op {
+        AssertionOperator::Eq => match numbers {
+            Some((v, t)) => v == t,
+            None => value == target,
+        },
+        AssertionOperator::NotEq => match numbers {
+            Some((v, t)) => v != t,
+            None => value != target,
+        },
+        AssertionOperator::Lt => numbers.is_some_and(|(v, t)| v < t),
+        AssertionOperator::Gt => numbers.is_some_and(|(v, t)| v > t),
+        AssertionOperator::Contains => value.contains(target),
+        AssertionOperator::NotContains => !value.contains(target),
+        AssertionOperator::Matches => Regex::new(target).is_ok_and(|re| re.is_match(value)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn assertion(source: AssertionSource, op: AssertionOperator, target: &str) -> Assertion {
+        Assertion {
+            source,
+            property: String::new(),
+            operator: op,
+            target: target.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_evaluate() {
+        let resp = Response {
+            status_code: Some(200),
+            headers: HashMap::from([("content-type".to_string(), "application/json".to_string())]),
+            body: r#"{"status":"ok"}"#.to_string(),
+            response_time_ms: 120.5,
+            ..Default::default()
+        };
+        use AssertionOperator::*;
+        use AssertionSource::*;
+        assert!(evaluate(&assertion(StatusCode, Eq, "200"), &resp));
+        assert!(!evaluate(&assertion(StatusCode, Gt, "299"), &resp));
+        assert!(evaluate(&assertion(ResponseTime, Lt, "500"), &resp));
+        assert!(evaluate(&assertion(Body, Contains, "\"ok\""), &resp));
+        assert!(evaluate(
+            &assertion(Body, Matches, r#"status":\s*"ok"#),
+            &resp
+        ));
+        let mut header = assertion(Header, Contains, "json");
+        header.property = "Content-Type".to_string();
+        assert!(evaluate(&header, &resp));
+        header.property = "x-missing".to_string();
+        assert!(!evaluate(&header, &resp));
+        header.operator = NotContains;
+        assert!(evaluate(&header, &resp));
+    }
+
+    #[test]
+    fn test_evaluate_resolved_address() {
+        let resp = Response {
+            resolved_addresses: vec!["10.0.0.1".to_string(), "10.0.0.2".to_string()],
+            ..Default::default()
+        };
+        use AssertionOperator::*;
+        use AssertionSource::*;
+        assert!(evaluate(&assertion(ResolvedAddress, Eq, "10.0.0.2"), &resp));
+        assert!(!evaluate(
+            &assertion(ResolvedAddress, NotEq, "10.0.0.2"),
+            &resp
+        ));
+        assert!(evaluate(
+            &assertion(ResolvedAddress, NotContains, "192.168."),
+            &resp
+        ));
+    }
+
+    #[test]
+    fn test_parse_ping_time() {
+        let output = "PING example.com (93.184.216.34) 56(84) bytes of data.\n\
+                      64 bytes from 93.184.216.34: icmp_seq=1 ttl=56 time=11.8 ms\n";
+        assert_eq!(parse_ping_time(output), Some(11.8));
+        assert_eq!(parse_ping_time("1 packets transmitted, 0 received"), None);
+    }
+
+    #[tokio::test]
+    async fn test_run_check_tcp_refused() {
+        let check = SyntheticCheck {
+            name: "closed".to_string(),
+            check_type: CheckType::Tcp,
+            target: "127.0.0.1:1".to_string(),
+            timeout: 1,
+            interval: 60,
+            ..Default::default()
+        };
+        let result = run_check(&check, "default").await;
+        assert!(!result.success);
+        assert!(result.error.is_some());
+        assert_eq!(result.location, "default");
+    }
+}
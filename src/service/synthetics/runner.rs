@@ -0,0 +1,165 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Lightweight runner executing the checks of one location away from the
+//! OpenObserve cluster, started with `openobserve synthetics-runner`. It
+//! only needs the url of OpenObserve and the credentials of a user of the
+//! organization, see the `ZO_SYNTHETICS_RUNNER_*` settings, and keeps no
+//! state besides the results waiting to be reported. Browser checks also need
+//! node with playwright and its chromium installed, see
+//! `ZO_SYNTHETICS_BROWSER_COMMAND`.
+
+use std::{
+    collections::{HashMap, HashSet},
+    time::{Duration, Instant},
+};
+
+use chrono::Utc;
+use config::{get_config, utils::json};
+use tokio::{sync::mpsc, time};
+
+use super::probe;
+use crate::common::meta::synthetics::{CheckResult, SyntheticCheck, SyntheticCheckList};
+
+/// Seconds between two reloads of the checks of the location
+const CHECKS_RELOAD_INTERVAL: u64 = 30;
+/// Seconds between two reports of the results
+const REPORT_INTERVAL: u64 = 5;
+/// Results kept while OpenObserve can't be reached, the oldest are dropped
+const MAX_PENDING_RESULTS: usize = 10000;
+
+pub async fn run() -> Result<(), anyhow::Error> {
+    let cfg = get_config();
+    let base_url = cfg.synthetics.runner_url.trim_end_matches('/');
+    if base_url.is_empty() {
+        return Err(anyhow::anyhow!(
+            "ZO_SYNTHETICS_RUNNER_URL is required to run the synthetic checks"
+        ));
+    }
+    let org_id = &cfg.synthetics.runner_org;
+    let location = &cfg.synthetics.location;
+    let runner_url = format!("{base_url}/api/{org_id}/synthetics/_runner/{location}");
+    let client = Client {
+        http: reqwest::Client::builder()
+            .timeout(Duration::from_secs(30))
+            .build()?,
+        user: cfg.synthetics.runner_user.clone(),
+        password: cfg.synthetics.runner_password.clone(),
+    };
+    log::info!("[SYNTHETICS] running the checks of location {location} for {base_url}");
+
+    let (tx, mut rx) = mpsc::unbounded_channel::<CheckResult>();
+    let mut checks: Vec<SyntheticCheck> = Vec::new();
+    let mut reloaded_at: Option<Instant> = None;
+    let mut reported_at = Instant::now();
+    let mut next_runs: HashMap<String, i64> = HashMap::new();
+    let mut pending: Vec<CheckResult> = Vec::new();
+    let mut interval = time::interval(Duration::from_secs(1));
+    loop {
+        interval.tick().await;
+        if reloaded_at.map_or(true, |t| t.elapsed().as_secs() >= CHECKS_RELOAD_INTERVAL) {
+            match client.get_checks(&format!("{runner_url}/checks")).await {
+                Ok(list) => checks = list,
+                Err(e) => log::error!("[SYNTHETICS] load checks error: {e}"),
+            }
+            reloaded_at = Some(Instant::now());
+        }
+
+        let now = Utc::now().timestamp();
+        for check in checks.iter() {
+            if next_runs.get(&check.name).is_some_and(|t| *t > now) {
+                continue;
+            }
+            next_runs.insert(check.name.clone(), now + check.interval as i64);
+            let tx = tx.clone();
+            let check = check.clone();
+            let location = location.clone();
+            tokio::task::spawn(async move {
+                let _ = tx.send(probe::run_check(&check, &location).await);
+            });
+        }
+        let names = checks
+            .iter()
+            .map(|c| c.name.as_str())
+            .collect::<HashSet<_>>();
+        next_runs.retain(|name, _| names.contains(name.as_str()));
+
+        while let Ok(result) = rx.try_recv() {
+            pending.push(result);
+        }
+        if pending.is_empty() || reported_at.elapsed().as_secs() < REPORT_INTERVAL {
+            continue;
+        }
+        reported_at = Instant::now();
+        match client
+            .report(&format!("{runner_url}/results"), &pending)
+            .await
+        {
+            Ok(_) => pending.clear(),
+            Err(e) => {
+                log::error!("[SYNTHETICS] report {} results error: {e}", pending.len());
+                if pending.len() > MAX_PENDING_RESULTS {
+                    pending.drain(..pending.len() - MAX_PENDING_RESULTS);
+                }
+            }
+        }
+    }
+}
+
+struct Client {
+    http: reqwest::Client,
+    user: String,
+    password: String,
+}
+
+impl Client {
+    async fn get_checks(&self, url: &str) -> Result<Vec<SyntheticCheck>, anyhow::Error> {
+        let resp = self
+            .http
+            .get(url)
+            .basic_auth(&self.user, Some(&self.password))
+            .send()
+            .await?;
+        let status = resp.status();
+        let body = resp.bytes().await?;
+        if !status.is_success() {
+            return Err(anyhow::anyhow!(
+                "{status} {}",
+                String::from_utf8_lossy(&body)
+            ));
+        }
+        Ok(json::from_slice::<SyntheticCheckList>(&body)?.list)
+    }
+
+    async fn report(&self, url: &str, results: &[CheckResult]) -> Result<(), anyhow::Error> {
+        let resp = self
+            .http
+            .post(url)
+            .basic_auth(&self.user, Some(&self.password))
+            .header("content-type", "application/json")
+            .body(json::to_vec(results)?)
+            .send()
+            .await?;
+        let status = resp.status();
+        if !status.is_success() {
+            let body = resp.bytes().await?;
+            return Err(anyhow::anyhow!(
+                "{status} {}",
+                String::from_utf8_lossy(&body)
+            ));
+        }
+        Ok(())
+    }
+}
@@ -13,7 +13,11 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::{collections::HashMap, io::Error, sync::Arc};
+use std::{
+    collections::{HashMap, HashSet},
+    io::Error,
+    sync::Arc,
+};
 
 use actix_web::{http, HttpResponse};
 use bytes::BytesMut;
@@ -21,7 +25,9 @@ use chrono::{Duration, Utc};
 use config::{
     cluster, get_config,
     meta::{
-        stream::{PartitionTimeLevel, StreamPartition, StreamType},
+        stream::{
+            PartitionTimeLevel, SamplingRule, StreamPartition, StreamType, TRACE_ID_PARTITIONS,
+        },
         usage::{RequestStats, UsageType},
     },
     metrics,
@@ -34,7 +40,7 @@ use opentelemetry_proto::tonic::{
     collector::trace::v1::{
         ExportTracePartialSuccess, ExportTraceServiceRequest, ExportTraceServiceResponse,
     },
-    trace::v1::{status::StatusCode, Status},
+    trace::v1::{span::SpanKind, status::StatusCode, Status},
 };
 use prost::Message;
 
@@ -43,7 +49,7 @@ use crate::{
         alerts::Alert,
         http::HttpResponse as MetaHttpResponse,
         stream::{SchemaRecords, StreamParams},
-        traces::{Event, Span, SpanRefType},
+        traces::{Event, Span, SpanLink, SpanRefType},
     },
     service::{
         db, format_stream_name,
@@ -71,6 +77,7 @@ pub async fn handle_trace_request(
     request: ExportTraceServiceRequest,
     is_grpc: bool,
     in_stream_name: Option<&str>,
+    skip_sampling: bool,
 ) -> Result<HttpResponse, Error> {
     let start = std::time::Instant::now();
     let started_at = Utc::now().timestamp_micros();
@@ -93,8 +100,15 @@ pub async fn handle_trace_request(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -120,13 +134,34 @@ pub async fn handle_trace_request(
     );
     // End Register Transforms for stream
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    if !skip_sampling {
+        crate::service::ingestion::get_stream_sampling_rules(
+            &[StreamParams::new(
+                org_id,
+                &traces_stream_name,
+                StreamType::Traces,
+            )],
+            &mut stream_sampling_map,
+        )
+        .await;
+    }
+    // End get sampling rules
+
+    let schema_contract = crate::service::schema_contracts::get_contract(
+        org_id,
+        StreamType::Traces,
+        &traces_stream_name,
+    );
+
     let mut service_name: String = traces_stream_name.to_string();
     let res_spans = request.resource_spans;
     let mut json_data = Vec::with_capacity(res_spans.len());
     let mut partial_success = ExportTracePartialSuccess::default();
     for res_span in res_spans {
         let mut service_att_map: HashMap<String, json::Value> = HashMap::new();
-        let resource = res_span.resource.unwrap();
+        let resource = res_span.resource.unwrap_or_default();
 
         for res_attr in resource.attributes {
             if res_attr.key.eq(SERVICE_NAME) {
@@ -142,33 +177,43 @@ pub async fn handle_trace_request(
                 );
             }
         }
+        let resource_entities = crate::service::entities::from_attributes(
+            service_att_map.iter().map(|(k, v)| (k.as_str(), v)),
+        );
+        crate::service::entities::observe(
+            org_id,
+            StreamType::Traces,
+            &traces_stream_name,
+            &resource_entities,
+        );
+        let mut peers = HashSet::new();
         let inst_resources = res_span.scope_spans;
         for inst_span in inst_resources {
             let spans = inst_span.spans;
             for span in spans {
-                let span_id: String = SpanId::from_bytes(
-                    span.span_id
-                        .try_into()
-                        .expect("slice with incorrect length"),
-                )
-                .to_string();
-                let trace_id: String = TraceId::from_bytes(
-                    span.trace_id
-                        .try_into()
-                        .expect("slice with incorrect length"),
-                )
-                .to_string();
+                let parent_span_id = if span.parent_span_id.is_empty() {
+                    Ok(None)
+                } else {
+                    <[u8; 8]>::try_from(span.parent_span_id.as_slice()).map(Some)
+                };
+                let (Ok(span_id), Ok(trace_id), Ok(parent_span_id)) = (
+                    <[u8; 8]>::try_from(span.span_id.as_slice()),
+                    <[u8; 16]>::try_from(span.trace_id.as_slice()),
+                    parent_span_id,
+                ) else {
+                    partial_success.rejected_spans += 1;
+                    partial_success.error_message =
+                        "span ids must have 8 bytes and trace ids 16 bytes".to_string();
+                    continue;
+                };
+                let span_id: String = SpanId::from_bytes(span_id).to_string();
+                let trace_id: String = TraceId::from_bytes(trace_id).to_string();
                 let mut span_ref = HashMap::new();
-                if !span.parent_span_id.is_empty() {
+                if let Some(parent_span_id) = parent_span_id {
                     span_ref.insert(PARENT_TRACE_ID.to_string(), trace_id.clone());
                     span_ref.insert(
                         PARENT_SPAN_ID.to_string(),
-                        SpanId::from_bytes(
-                            span.parent_span_id
-                                .try_into()
-                                .expect("slice with incorrect length"),
-                        )
-                        .to_string(),
+                        SpanId::from_bytes(parent_span_id).to_string(),
                     );
                     span_ref.insert(REF_TYPE.to_string(), format!("{:?}", SpanRefType::ChildOf));
                 }
@@ -182,17 +227,41 @@ pub async fn handle_trace_request(
                     }
                     span_att_map.insert(key, get_val(&span_att.value.as_ref()));
                 }
+                if span.kind == SpanKind::Client as i32 || span.kind == SpanKind::Producer as i32 {
+                    if let Some(peer) = crate::service::entities::peer_service(&span_att_map) {
+                        peers.insert(peer);
+                    }
+                }
 
-                let mut events = vec![];
-                let mut event_att_map: HashMap<String, json::Value> = HashMap::new();
+                let mut events = Vec::with_capacity(span.events.len());
                 for event in span.events {
+                    let mut event_att_map: HashMap<String, json::Value> = HashMap::new();
                     for event_att in event.attributes {
                         event_att_map.insert(event_att.key, get_val(&event_att.value.as_ref()));
                     }
                     events.push(Event {
                         name: event.name,
                         _timestamp: event.time_unix_nano,
-                        attributes: event_att_map.clone(),
+                        attributes: event_att_map,
+                    })
+                }
+
+                let mut links = Vec::with_capacity(span.links.len());
+                for link in span.links {
+                    let (Ok(link_trace_id), Ok(link_span_id)) =
+                        (link.trace_id.try_into(), link.span_id.try_into())
+                    else {
+                        continue;
+                    };
+                    let mut link_att_map: HashMap<String, json::Value> = HashMap::new();
+                    for link_att in link.attributes {
+                        link_att_map.insert(link_att.key, get_val(&link_att.value.as_ref()));
+                    }
+                    links.push(SpanLink {
+                        trace_id: TraceId::from_bytes(link_trace_id).to_string(),
+                        span_id: SpanId::from_bytes(link_span_id).to_string(),
+                        trace_state: link.trace_state,
+                        attributes: link_att_map,
                     })
                 }
 
@@ -218,6 +287,7 @@ pub async fn handle_trace_request(
                     flags: 1, // TODO add appropriate value
                     //_timestamp: timestamp,
                     events: json::to_string(&events).unwrap(),
+                    links: json::to_string(&links).unwrap(),
                 };
 
                 let value: json::Value = json::to_value(local_val).unwrap();
@@ -249,6 +319,38 @@ pub async fn handle_trace_request(
                     _ => unreachable!(""),
                 };
 
+                if let Some(rules) = stream_sampling_map.get(&traces_stream_name) {
+                    if let Some(rule) =
+                        crate::service::ingestion::sample_span(rules, &record_val, &trace_id).await
+                    {
+                        metrics::INGEST_SAMPLED_RECORDS
+                            .with_label_values(&[
+                                org_id,
+                                &traces_stream_name,
+                                StreamType::Traces.to_string().as_str(),
+                                rule,
+                            ])
+                            .inc();
+                        continue;
+                    }
+                }
+
+                // the spans are accounted to the service which sent them
+                if let Some(contract) = schema_contract.as_ref() {
+                    if let Some(e) = crate::service::schema_contracts::check_record(
+                        org_id,
+                        StreamType::Traces,
+                        &traces_stream_name,
+                        contract,
+                        &service_name,
+                        &record_val,
+                    ) {
+                        partial_success.rejected_spans += 1;
+                        partial_success.error_message = e;
+                        continue;
+                    }
+                }
+
                 // add timestamp
                 record_val.insert(
                     cfg.common.column_timestamp.clone(),
@@ -257,6 +359,7 @@ pub async fn handle_trace_request(
                 json_data.push((timestamp, record_val));
             }
         }
+        crate::service::entities::observe_dependencies(org_id, &resource_entities, &peers);
     }
 
     // if no data, fast return
@@ -327,9 +430,11 @@ fn get_span_status(status: Option<Status>) -> String {
 fn format_response(mut partial_success: ExportTracePartialSuccess) -> Result<HttpResponse, Error> {
     let res = ExportTraceServiceResponse {
         partial_success: if partial_success.rejected_spans > 0 {
-            partial_success.error_message =
-                "Some spans were rejected due to exceeding the allowed retention period"
-                    .to_string();
+            if partial_success.error_message.is_empty() {
+                partial_success.error_message =
+                    "Some spans were rejected due to exceeding the allowed retention period"
+                        .to_string();
+            }
             Some(partial_success)
         } else {
             None
@@ -374,7 +479,13 @@ async fn write_traces(
             unwrap_partition_time_level(partition_det.partition_time_level, StreamType::Traces);
     }
     if partition_keys.is_empty() {
-        partition_keys.push(StreamPartition::new("service_name"));
+        // hash by trace_id so all spans of a trace land in the same files of a
+        // time bucket, which lets a trace lookup prune everything else
+        partition_keys.push(if cfg.limit.traces_partition_by_trace_id {
+            StreamPartition::new_hash("trace_id", TRACE_ID_PARTITIONS)
+        } else {
+            StreamPartition::new("service_name")
+        });
     }
 
     // Start get stream alerts
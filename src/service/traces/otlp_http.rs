@@ -13,24 +13,36 @@
 // You should have received a copy of the GNU Affero General Public License
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
-use std::{collections::HashMap, io::Error};
+use std::{
+    collections::{HashMap, HashSet},
+    io::Error,
+};
 
 use actix_web::{http, web, HttpResponse};
 use chrono::{Duration, Utc};
 use config::{
     cluster, get_config,
-    meta::{stream::StreamType, usage::UsageType},
+    meta::{
+        stream::{SamplingRule, StreamType},
+        usage::UsageType,
+    },
     metrics,
     utils::{flatten, json},
 };
-use opentelemetry_proto::tonic::collector::trace::v1::ExportTraceServiceRequest;
+use opentelemetry_proto::tonic::{
+    collector::trace::v1::ExportTraceServiceRequest, trace::v1::span::SpanKind,
+};
 use prost::Message;
 
 use super::{BLOCK_FIELDS, PARENT_SPAN_ID, PARENT_TRACE_ID, REF_TYPE, SERVICE, SERVICE_NAME};
 use crate::{
     common::meta::{
         http::HttpResponse as MetaHttpResponse,
-        traces::{Event, ExportTracePartialSuccess, ExportTraceServiceResponse, Span, SpanRefType},
+        stream::StreamParams,
+        traces::{
+            Event, ExportTracePartialSuccess, ExportTraceServiceResponse, Span, SpanLink,
+            SpanRefType,
+        },
     },
     service::{
         db, format_stream_name, ingestion::grpc::get_val_for_attr,
@@ -42,6 +54,7 @@ pub async fn traces_proto(
     org_id: &str,
     body: web::Bytes,
     in_stream_name: Option<&str>,
+    skip_sampling: bool,
 ) -> Result<HttpResponse, Error> {
     let request = match ExportTraceServiceRequest::decode(body) {
         Ok(v) => v,
@@ -52,13 +65,14 @@ pub async fn traces_proto(
             )));
         }
     };
-    super::handle_trace_request(org_id, request, false, in_stream_name).await
+    super::handle_trace_request(org_id, request, false, in_stream_name, skip_sampling).await
 }
 
 pub async fn traces_json(
     org_id: &str,
     body: web::Bytes,
     in_stream_name: Option<&str>,
+    skip_sampling: bool,
 ) -> Result<HttpResponse, Error> {
     let start = std::time::Instant::now();
     let started_at = Utc::now().timestamp_micros();
@@ -81,8 +95,15 @@ pub async fn traces_json(
         )));
     }
 
+    if let Err(e) = crate::service::residency::check_local(org_id) {
+        return Ok(HttpResponse::Forbidden().json(MetaHttpResponse::error(
+            http::StatusCode::FORBIDDEN.into(),
+            e,
+        )));
+    }
+
     // check memtable
-    if let Err(e) = ingester::check_memtable_size() {
+    if let Err(e) = ingester::check_memtable_size(org_id) {
         return Ok(
             HttpResponse::ServiceUnavailable().json(MetaHttpResponse::error(
                 http::StatusCode::SERVICE_UNAVAILABLE.into(),
@@ -127,6 +148,27 @@ pub async fn traces_json(
         }
     };
 
+    // Start get sampling rules
+    let mut stream_sampling_map: HashMap<String, Vec<SamplingRule>> = HashMap::new();
+    if !skip_sampling {
+        crate::service::ingestion::get_stream_sampling_rules(
+            &[StreamParams::new(
+                org_id,
+                &traces_stream_name,
+                StreamType::Traces,
+            )],
+            &mut stream_sampling_map,
+        )
+        .await;
+    }
+    // End get sampling rules
+
+    let schema_contract = crate::service::schema_contracts::get_contract(
+        org_id,
+        StreamType::Traces,
+        &traces_stream_name,
+    );
+
     let mut service_name: String = traces_stream_name.to_string();
     let mut json_data = Vec::with_capacity(64);
     let mut partial_success = ExportTracePartialSuccess::default();
@@ -138,49 +180,61 @@ pub async fn traces_json(
                 let attributes = resource.get("attributes").unwrap().as_array().unwrap();
                 for res_attr in attributes {
                     let local_attr = res_attr.as_object().unwrap();
-                    if local_attr
+                    let key = local_attr
                         .get("key")
-                        .unwrap()
-                        .as_str()
-                        .unwrap()
-                        .eq(SERVICE_NAME)
-                    {
-                        let loc_service_name =
-                            local_attr.get("value").unwrap().as_object().unwrap();
-                        for item in loc_service_name {
-                            service_name = item.1.as_str().unwrap().to_string();
+                        .and_then(|v| v.as_str())
+                        .unwrap_or_default();
+                    // an attribute without value is valid, it is stored as null
+                    let value = local_attr.get("value").cloned().unwrap_or_default();
+                    if key.eq(SERVICE_NAME) {
+                        for item in value.as_object().into_iter().flatten() {
+                            service_name = json::get_string_value(item.1);
                             service_att_map.insert(SERVICE_NAME.to_string(), item.1.clone());
                         }
                     } else {
-                        service_att_map.insert(
-                            format!(
-                                "{}.{}",
-                                SERVICE,
-                                local_attr.get("key").unwrap().as_str().unwrap()
-                            ),
-                            get_val_for_attr(local_attr.get("value").unwrap().clone()),
-                        );
+                        service_att_map
+                            .insert(format!("{}.{}", SERVICE, key), get_val_for_attr(value));
                     }
                 }
             }
         }
+        let resource_entities = crate::service::entities::from_attributes(
+            service_att_map.iter().map(|(k, v)| (k.as_str(), v)),
+        );
+        crate::service::entities::observe(
+            org_id,
+            StreamType::Traces,
+            &traces_stream_name,
+            &resource_entities,
+        );
         let scope_resources = res_span.get("scopeSpans");
         let inst_resources = if let Some(v) = scope_resources {
             v.as_array().unwrap()
         } else {
-            res_span
+            match res_span
                 .get("instrumentationLibrarySpans")
-                .unwrap()
-                .as_array()
-                .unwrap()
+                .and_then(|v| v.as_array())
+            {
+                Some(v) => v,
+                None => continue,
+            }
         };
+        let mut peers = HashSet::new();
         for inst_span in inst_resources {
             if inst_span.get("spans").is_some() {
                 let spans = inst_span.get("spans").unwrap().as_array().unwrap();
                 for span in spans {
-                    let span_id: String = span.get("spanId").unwrap().as_str().unwrap().to_string();
-                    let trace_id: String =
-                        span.get("traceId").unwrap().as_str().unwrap().to_string();
+                    let (Some(span_id), Some(trace_id)) = (
+                        span.get("spanId").and_then(|v| v.as_str()),
+                        span.get("traceId").and_then(|v| v.as_str()),
+                    ) else {
+                        partial_success.rejected_spans += 1;
+                        partial_success.error_message =
+                            "spans need a traceId and a spanId".to_string();
+                        continue;
+                    };
+                    let span_id = span_id.to_string();
+                    let trace_id = trace_id.to_string();
 
                     let mut span_ref = HashMap::new();
                     if span.get("parentSpanId").is_some() {
@@ -197,10 +251,20 @@ pub async fn traces_json(
                             .insert(REF_TYPE.to_string(), format!("{:?}", SpanRefType::ChildOf));
                     }
 
-                    let start_time = json::get_uint_value(span.get("startTimeUnixNano").unwrap());
-                    let end_time = json::get_uint_value(span.get("endTimeUnixNano").unwrap());
+                    let start_time = span
+                        .get("startTimeUnixNano")
+                        .map(json::get_uint_value)
+                        .unwrap_or_default();
+                    let end_time = span
+                        .get("endTimeUnixNano")
+                        .map(json::get_uint_value)
+                        .unwrap_or_default();
                     let mut span_att_map: HashMap<String, json::Value> = HashMap::new();
-                    let attributes = span.get("attributes").unwrap().as_array().unwrap();
+                    let empty_vec = Vec::new();
+                    let attributes = span
+                        .get("attributes")
+                        .and_then(|v| v.as_array())
+                        .unwrap_or(&empty_vec);
                     for span_att in attributes {
                         let mut key = span_att.get("key").unwrap().as_str().unwrap().to_string();
                         if BLOCK_FIELDS.contains(&key.as_str()) {
@@ -208,30 +272,56 @@ pub async fn traces_json(
                         }
                         span_att_map.insert(
                             key,
-                            get_val_for_attr(span_att.get("value").unwrap().clone()),
+                            get_val_for_attr(span_att.get("value").cloned().unwrap_or_default()),
                         );
                     }
+                    if is_outgoing_span(span.get("kind")) {
+                        if let Some(peer) = crate::service::entities::peer_service(&span_att_map) {
+                            peers.insert(peer);
+                        }
+                    }
 
                     let mut events = vec![];
-                    let mut event_att_map: HashMap<String, json::Value> = HashMap::new();
-
-                    let empty_vec = Vec::new();
                     let span_events = match span.get("events") {
-                        Some(v) => v.as_array().unwrap(),
+                        Some(v) => v.as_array().unwrap_or(&empty_vec),
                         None => &empty_vec,
                     };
                     for event in span_events {
-                        let attributes = event.get("attributes").unwrap().as_array().unwrap();
-                        for event_att in attributes {
-                            event_att_map.insert(
-                                event_att.get("key").unwrap().as_str().unwrap().to_string(),
-                                get_val_for_attr(event_att.get("value").unwrap().clone()),
-                            );
-                        }
                         events.push(Event {
-                            name: event.get("name").unwrap().as_str().unwrap().to_string(),
-                            _timestamp: json::get_uint_value(event.get("timeUnixNano").unwrap()),
-                            attributes: event_att_map.clone(),
+                            name: event
+                                .get("name")
+                                .and_then(|v| v.as_str())
+                                .unwrap_or_default()
+                                .to_string(),
+                            _timestamp: event
+                                .get("timeUnixNano")
+                                .map(json::get_uint_value)
+                                .unwrap_or_default(),
+                            attributes: attributes_map(event.get("attributes")),
+                        })
+                    }
+
+                    let mut links = vec![];
+                    let span_links = match span.get("links") {
+                        Some(v) => v.as_array().unwrap_or(&empty_vec),
+                        None => &empty_vec,
+                    };
+                    for link in span_links {
+                        let (Some(link_trace_id), Some(link_span_id)) = (
+                            link.get("traceId").and_then(|v| v.as_str()),
+                            link.get("spanId").and_then(|v| v.as_str()),
+                        ) else {
+                            continue;
+                        };
+                        links.push(SpanLink {
+                            trace_id: link_trace_id.to_string(),
+                            span_id: link_span_id.to_string(),
+                            trace_state: link
+                                .get("traceState")
+                                .and_then(|v| v.as_str())
+                                .unwrap_or_default()
+                                .to_string(),
+                            attributes: attributes_map(link.get("attributes")),
                         })
                     }
 
@@ -244,21 +334,27 @@ pub async fn traces_json(
                     let local_val = Span {
                         trace_id: trace_id.clone(),
                         span_id,
-                        span_kind: span.get("kind").unwrap().to_string(),
+                        // the default values are omitted, 0 is SPAN_KIND_UNSPECIFIED
+                        span_kind: span.get("kind").map_or("0".to_string(), |v| v.to_string()),
                         span_status: json::get_string_value(
                             span.get("status")
                                 .unwrap_or(&json::Value::String("UNSET".to_string())),
                         ),
-                        operation_name: span.get("name").unwrap().as_str().unwrap().to_string(),
+                        operation_name: span
+                            .get("name")
+                            .and_then(|v| v.as_str())
+                            .unwrap_or_default()
+                            .to_string(),
                         start_time,
                         end_time,
-                        duration: (end_time - start_time) / 1000, // microseconds
+                        duration: end_time.saturating_sub(start_time) / 1000, // microseconds
                         reference: span_ref,
                         service_name: service_name.clone(),
                         attributes: span_att_map,
                         service: service_att_map.clone(),
                         flags: 1, // TODO add appropriate value
                         events: json::to_string(&events).unwrap(),
+                        links: json::to_string(&links).unwrap(),
                     };
 
                     let mut value: json::Value = json::to_value(local_val).unwrap();
@@ -290,6 +386,39 @@ pub async fn traces_json(
                         _ => unreachable!(),
                     };
 
+                    if let Some(rules) = stream_sampling_map.get(&traces_stream_name) {
+                        if let Some(rule) =
+                            crate::service::ingestion::sample_span(rules, &record_val, &trace_id)
+                                .await
+                        {
+                            metrics::INGEST_SAMPLED_RECORDS
+                                .with_label_values(&[
+                                    org_id,
+                                    &traces_stream_name,
+                                    StreamType::Traces.to_string().as_str(),
+                                    rule,
+                                ])
+                                .inc();
+                            continue;
+                        }
+                    }
+
+                    // the spans are accounted to the service which sent them
+                    if let Some(contract) = schema_contract.as_ref() {
+                        if let Some(e) = crate::service::schema_contracts::check_record(
+                            org_id,
+                            StreamType::Traces,
+                            &traces_stream_name,
+                            contract,
+                            &service_name,
+                            &record_val,
+                        ) {
+                            partial_success.rejected_spans += 1;
+                            partial_success.error_message = e;
+                            continue;
+                        }
+                    }
+
                     // add timestamp
                     record_val.insert(
                         cfg.common.column_timestamp.clone(),
@@ -299,6 +428,7 @@ pub async fn traces_json(
                 }
             }
         }
+        crate::service::entities::observe_dependencies(org_id, &resource_entities, &peers);
     }
 
     // if no data, fast return
@@ -350,6 +480,35 @@ pub async fn traces_json(
     format_response(partial_success)
 }
 
+/// Whether the span calls another service, the kind is either the enum
+/// number or its name
+fn is_outgoing_span(kind: Option<&json::Value>) -> bool {
+    let kind = match kind {
+        Some(json::Value::Number(v)) => v.as_i64().unwrap_or_default() as i32,
+        Some(json::Value::String(v)) => SpanKind::from_str_name(v).map_or(0, |v| v as i32),
+        _ => return false,
+    };
+    kind == SpanKind::Client as i32 || kind == SpanKind::Producer as i32
+}
+
+/// Converts an OTLP `attributes` list of key/value pairs into a map, used for
+/// both span events and span links
+fn attributes_map(attributes: Option<&json::Value>) -> HashMap<String, json::Value> {
+    let mut map = HashMap::new();
+    let Some(attributes) = attributes.and_then(|v| v.as_array()) else {
+        return map;
+    };
+    for attr in attributes {
+        let (Some(key), Some(value)) =
+            (attr.get("key").and_then(|v| v.as_str()), attr.get("value"))
+        else {
+            continue;
+        };
+        map.insert(key.to_string(), get_val_for_attr(value.clone()));
+    }
+    map
+}
+
 #[cfg(test)]
 mod tests {
     use json::json;
@@ -363,12 +522,36 @@ mod tests {
         let resp = get_val_for_attr(input);
         assert_eq!(resp.as_str().unwrap(), in_val.to_string());
     }
+
+    #[test]
+    fn test_is_outgoing_span() {
+        assert!(is_outgoing_span(Some(&json!(3))));
+        assert!(is_outgoing_span(Some(&json!("SPAN_KIND_PRODUCER"))));
+        assert!(!is_outgoing_span(Some(&json!(2))));
+        assert!(!is_outgoing_span(None));
+    }
+
+    #[test]
+    fn test_attributes_map() {
+        let attrs = json!([
+            {"key": "exception.type", "value": {"stringValue": "IOError"}},
+            {"key": "retry", "value": {"intValue": 3}},
+            {"value": {"stringValue": "no key"}}
+        ]);
+        let map = attributes_map(Some(&attrs));
+        assert_eq!(map.len(), 2);
+        assert_eq!(map.get("exception.type").unwrap(), "IOError");
+        assert!(attributes_map(None).is_empty());
+    }
 }
 
 fn format_response(mut partial_success: ExportTracePartialSuccess) -> Result<HttpResponse, Error> {
     Ok(if partial_success.rejected_spans > 0 {
-        partial_success.error_message =
-            "Some spans were rejected due to exceeding the allowed retention period".to_string();
+        if partial_success.error_message.is_empty() {
+            partial_success.error_message =
+                "Some spans were rejected due to exceeding the allowed retention period"
+                    .to_string();
+        }
         HttpResponse::PartialContent().json(ExportTraceServiceResponse {
             partial_success: Some(partial_success),
         })
@@ -19,15 +19,34 @@ use rand::{rngs::StdRng, seq::SliceRandom, SeedableRng};
 use tonic::{
     codec::CompressionEncoding,
     metadata::{MetadataKey, MetadataValue},
-    transport::Channel,
     Request,
 };
 
-use crate::common::infra::cluster;
+use crate::common::infra::{cluster, tls};
+
+/// Metadata of the usage requests whose records are older than
+/// `ZO_INGEST_ALLOWED_UPTO`, e.g. the records copied by a rehydration
+pub const HISTORICAL_HEADER: &str = "o2-ingest-historical";
 
 pub async fn ingest(
     dest_org_id: &str,
     req: cluster_rpc::UsageRequest,
+) -> Result<cluster_rpc::UsageResponse, Error> {
+    send(dest_org_id, req, false).await
+}
+
+/// Ingests records keeping their timestamps however old they are
+pub async fn ingest_historical(
+    dest_org_id: &str,
+    req: cluster_rpc::UsageRequest,
+) -> Result<cluster_rpc::UsageResponse, Error> {
+    send(dest_org_id, req, true).await
+}
+
+async fn send(
+    dest_org_id: &str,
+    req: cluster_rpc::UsageRequest,
+    historical: bool,
 ) -> Result<cluster_rpc::UsageResponse, Error> {
     let cfg = config::get_config();
     let mut nodes = cluster::get_cached_online_ingester_nodes().await.unwrap();
@@ -60,7 +79,7 @@ pub async fn ingest(
     let token: MetadataValue<_> = cluster::get_internal_grpc_token()
         .parse()
         .map_err(|_| Error::msg("invalid token".to_string()))?;
-    let channel = Channel::from_shared(node_addr)
+    let channel = tls::grpc_endpoint(node_addr)
         .unwrap()
         .connect_timeout(std::time::Duration::from_secs(cfg.grpc.connect_timeout))
         .connect()
@@ -79,6 +98,10 @@ pub async fn ingest(
             req.metadata_mut().insert("authorization", token.clone());
             req.metadata_mut()
                 .insert(org_header_key.clone(), dest_org_id.parse().unwrap());
+            if historical {
+                req.metadata_mut()
+                    .insert(HISTORICAL_HEADER, MetadataValue::from_static("true"));
+            }
             Ok(req)
         },
     );
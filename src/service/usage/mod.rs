@@ -37,6 +37,8 @@ use proto::cluster_rpc;
 use reqwest::Client;
 use tokio::{sync::RwLock, time};
 
+use crate::common::utils::trace_context;
+
 pub mod ingestion_service;
 pub mod stats;
 
@@ -69,6 +71,13 @@ pub async fn report_request_usage_stats(
     let request_body = stats.request_body.unwrap_or(usage_type.to_string());
     let user_email = stats.user_email.unwrap_or("".to_owned());
     let now = Utc::now();
+    // ingestion requests don't track a trace id of their own, attribute them
+    // to the caller's trace when it sent one
+    let trace_ctx = trace_context::current();
+    let trace_id = stats
+        .trace_id
+        .or_else(|| trace_ctx.as_ref().map(|ctx| ctx.trace_id.clone()));
+    let parent_span_id = trace_ctx.map(|ctx| ctx.parent_span_id);
 
     let mut usage = vec![];
 
@@ -101,7 +110,9 @@ pub async fn report_request_usage_stats(
             cached_ratio: None,
             compressed_size: None,
             search_type: stats.search_type,
-            trace_id: None,
+            search_event_context: stats.search_event_context.clone(),
+            trace_id: trace_id.clone(),
+            parent_span_id: parent_span_id.clone(),
             took_wait_in_queue: stats.took_wait_in_queue,
         });
     };
@@ -134,7 +145,9 @@ pub async fn report_request_usage_stats(
         cached_ratio: stats.cached_ratio,
         compressed_size: None,
         search_type: stats.search_type,
-        trace_id: stats.trace_id,
+        search_event_context: stats.search_event_context,
+        trace_id,
+        parent_span_id,
         took_wait_in_queue: stats.took_wait_in_queue,
     });
     if !usage.is_empty() {
@@ -230,8 +243,9 @@ async fn ingest_usages(curr_usages: Vec<UsageData>) {
     let mut groups: HashMap<GroupKey, AggregatedData> = HashMap::new();
     let mut search_events = vec![];
     for usage_data in &curr_usages {
-        // Skip aggregation for usage_data with event "Search"
-        if usage_data.event == UsageEvent::Search {
+        // Skip aggregation for usage_data with event "Search", and for requests
+        // that came with a trace so they can still be found by its trace id
+        if usage_data.event == UsageEvent::Search || usage_data.trace_id.is_some() {
             search_events.push(usage_data.clone());
             continue;
         }
@@ -94,6 +94,7 @@ pub async fn publish_stats() -> Result<(), anyhow::Error> {
             clusters: vec![],
             timeout: 0,
             search_type: None,
+            search_event_context: None,
         };
         // do search
         match SearchService::search("", &cfg.common.usage_org, StreamType::Logs, None, &req).await {
@@ -153,6 +154,7 @@ async fn get_last_stats(
         clusters: vec![],
         timeout: 0,
         search_type: None,
+        search_event_context: None,
     };
     match SearchService::search(
         "",
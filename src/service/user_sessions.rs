@@ -0,0 +1,155 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::{get_config, utils::rand::generate_random_string};
+
+use super::db;
+use crate::common::meta::user::UserSession;
+
+const SESSION_ID_LEN: usize = 48;
+
+/// Creates a native login session for the user. When the user already holds
+/// `ZO_MAX_USER_SESSIONS` sessions the oldest ones are revoked to make room.
+pub async fn create(
+    user_email: &str,
+    ip: &str,
+    user_agent: &str,
+) -> Result<UserSession, anyhow::Error> {
+    let cfg = get_config();
+    let now = chrono::Utc::now().timestamp();
+    let session = UserSession {
+        id: generate_random_string(SESSION_ID_LEN),
+        user_email: user_email.to_string(),
+        created_at: now,
+        expires_at: now + cfg.auth.session_lifetime,
+        ip: ip.to_string(),
+        user_agent: user_agent.to_string(),
+    };
+    db::user_login_session::set(&session).await?;
+
+    // the cache is filled by the watcher, it may not have the new session yet
+    let mut existing = list(user_email);
+    if !existing.iter().any(|s| s.id == session.id) {
+        existing.push(session.clone());
+    }
+    for id in sessions_to_evict(&existing, cfg.auth.max_user_sessions) {
+        if id != session.id {
+            log::info!("[SESSION] revoking oldest session of {user_email}: max sessions reached");
+            let _ = db::user_login_session::delete(&id).await;
+        }
+    }
+    Ok(session)
+}
+
+/// Returns the session if it exists and has not expired yet. Expired sessions
+/// are cleaned up on access.
+pub async fn get_valid(session_id: &str) -> Option<UserSession> {
+    let session = db::user_login_session::get(session_id).await.ok()?;
+    if session.is_expired(chrono::Utc::now().timestamp()) {
+        let _ = db::user_login_session::delete(session_id).await;
+        return None;
+    }
+    Some(session)
+}
+
+/// Lists the active sessions of the user, oldest first.
+pub fn list(user_email: &str) -> Vec<UserSession> {
+    let now = chrono::Utc::now().timestamp();
+    db::user_login_session::list_by_user(user_email)
+        .into_iter()
+        .filter(|s| !s.is_expired(now))
+        .collect()
+}
+
+/// Revokes a single session of the user, returns false if the session does not
+/// belong to the user.
+pub async fn revoke(user_email: &str, session_id: &str) -> Result<bool, anyhow::Error> {
+    match db::user_login_session::get(session_id).await {
+        Ok(session) if session.user_email.eq(user_email) => {
+            db::user_login_session::delete(session_id).await?;
+            Ok(true)
+        }
+        _ => Ok(false),
+    }
+}
+
+/// Revokes all sessions of the user and returns how many were removed.
+pub async fn revoke_all(user_email: &str) -> Result<usize, anyhow::Error> {
+    let sessions = db::user_login_session::list_by_user(user_email);
+    for session in sessions.iter() {
+        db::user_login_session::delete(&session.id).await?;
+    }
+    Ok(sessions.len())
+}
+
+/// Deletes the expired sessions of all users from the meta store and returns
+/// how many were removed, sessions nobody presents again would stay forever.
+pub async fn delete_expired() -> Result<usize, anyhow::Error> {
+    let now = chrono::Utc::now().timestamp();
+    let mut deleted = 0;
+    for session in db::user_login_session::list().await? {
+        if session.is_expired(now) {
+            db::user_login_session::delete(&session.id).await?;
+            deleted += 1;
+        }
+    }
+    Ok(deleted)
+}
+
+/// Picks the ids of the oldest sessions to drop so that at most `max` remain.
+/// `sessions` must be sorted by creation time, `max` of 0 means unlimited.
+fn sessions_to_evict(sessions: &[UserSession], max: usize) -> Vec<String> {
+    if max == 0 || sessions.len() <= max {
+        return vec![];
+    }
+    sessions[..sessions.len() - max]
+        .iter()
+        .map(|s| s.id.clone())
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn session(id: &str, created_at: i64) -> UserSession {
+        UserSession {
+            id: id.to_string(),
+            user_email: "root@example.com".to_string(),
+            created_at,
+            expires_at: created_at + 60,
+            ..Default::default()
+        }
+    }
+
+    #[test]
+    fn test_sessions_to_evict() {
+        let sessions = vec![session("a", 1), session("b", 2), session("c", 3)];
+        assert!(sessions_to_evict(&sessions, 0).is_empty());
+        assert!(sessions_to_evict(&sessions, 3).is_empty());
+        assert_eq!(sessions_to_evict(&sessions, 2), vec!["a".to_string()]);
+        assert_eq!(
+            sessions_to_evict(&sessions, 1),
+            vec!["a".to_string(), "b".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_session_expiry() {
+        let s = session("a", 100);
+        assert!(!s.is_expired(159));
+        assert!(s.is_expired(160));
+    }
+}
@@ -471,6 +471,16 @@ pub async fn get_user(org_id: Option<&str>, name: &str) -> Option<User> {
     }
 }
 
+/// Returns true for the root user and the admins of the organization
+pub async fn is_admin(org_id: &str, user_id: &str) -> bool {
+    if is_root_user(user_id) {
+        return true;
+    }
+    get_user(Some(org_id), user_id)
+        .await
+        .is_some_and(|user| user.role == UserRole::Admin || user.role == UserRole::Root)
+}
+
 pub async fn get_user_by_token(org_id: &str, token: &str) -> Option<User> {
     let root_user = USERS_RUM_TOKEN.get(&format!("{DEFAULT_ORG}/{token}"));
     if let Some(user) = root_user {
@@ -559,6 +569,9 @@ pub async fn remove_user_from_org(
                     let mut orgs = user.clone().organizations;
                     if orgs.len() == 1 {
                         let _ = db::user::delete(email_id).await;
+                        let _ = super::user_sessions::revoke_all(email_id).await;
+                        let _ = super::mcp::revoke_user_tokens(Some(org_id), email_id).await;
+                        let _ = db::user_mfa::delete(email_id).await;
                         #[cfg(feature = "enterprise")]
                         {
                             use o2_enterprise::enterprise::openfga::authorizer::authz::delete_user_from_org;
@@ -596,6 +609,7 @@ pub async fn remove_user_from_org(
                         // special case as we cache flattened user struct
                         if resp.is_ok() {
                             USERS.remove(&format!("{org_id}/{email_id}"));
+                            let _ = super::mcp::revoke_user_tokens(Some(org_id), email_id).await;
                             #[cfg(feature = "enterprise")]
                             {
                                 use o2_enterprise::enterprise::openfga::authorizer::authz::delete_user_from_org;
@@ -641,10 +655,15 @@ pub async fn remove_user_from_org(
 pub async fn delete_user(email_id: &str) -> Result<HttpResponse, Error> {
     let result = db::user::delete(email_id).await;
     match result {
-        Ok(_) => Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
-            http::StatusCode::OK.into(),
-            "User deleted".to_string(),
-        ))),
+        Ok(_) => {
+            let _ = super::user_sessions::revoke_all(email_id).await;
+            let _ = db::user_mfa::delete(email_id).await;
+            let _ = super::mcp::revoke_user_tokens(None, email_id).await;
+            Ok(HttpResponse::Ok().json(MetaHttpResponse::message(
+                http::StatusCode::OK.into(),
+                "User deleted".to_string(),
+            )))
+        }
         Err(e) => Ok(HttpResponse::NotFound().json(MetaHttpResponse::error(
             http::StatusCode::NOT_FOUND.into(),
             e.to_string(),
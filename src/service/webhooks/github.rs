@@ -0,0 +1,257 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+use hmac::{Hmac, Mac};
+use sha2::Sha256;
+
+use super::{finish, normalize_status, parse_time};
+
+pub(super) const SIGNATURE_HEADER: &str = "X-Hub-Signature-256";
+pub(super) const EVENT_HEADER: &str = "X-GitHub-Event";
+pub(super) const DELIVERY_HEADER: &str = "X-GitHub-Delivery";
+
+/// Checks the `sha256=` HMAC of the payload signed with the webhook secret
+pub(super) fn verify(secret: &str, signature: Option<&str>, body: &[u8]) -> bool {
+    let Some(signature) = signature
+        .and_then(|s| s.strip_prefix("sha256="))
+        .and_then(|s| hex::decode(s).ok())
+    else {
+        return false;
+    };
+    let mut mac =
+        Hmac::<Sha256>::new_from_slice(secret.as_bytes()).expect("HMAC accepts keys of any size");
+    mac.update(body);
+    mac.verify_slice(&signature).is_ok()
+}
+
+/// Normalizes the payload of the event, `None` for the events which are not
+/// ingested
+pub(super) fn normalize(event: &str, delivery: &str, payload: &json::Value) -> Option<json::Value> {
+    let repo = &payload["repository"];
+    let mut record = json::json!({
+        "source": "github",
+        "event": event,
+        "action": payload["action"],
+        "repository": repo["full_name"],
+        "repository_url": repo["html_url"],
+        "actor": payload["sender"]["login"],
+        "delivery_id": delivery,
+    });
+    let ts = match event {
+        "ping" => return None,
+        "push" => {
+            let git_ref = payload["ref"].as_str().unwrap_or_default();
+            match git_ref.strip_prefix("refs/tags/") {
+                Some(tag) => {
+                    record["event"] = json::Value::from("tag_push");
+                    record["tag"] = json::Value::from(tag);
+                }
+                None => {
+                    let branch = git_ref.strip_prefix("refs/heads/").unwrap_or(git_ref);
+                    record["branch"] = json::Value::from(branch);
+                }
+            }
+            if payload["deleted"].as_bool() == Some(true) {
+                record["action"] = json::Value::from("deleted");
+            }
+            let head = &payload["head_commit"];
+            record["commit_sha"] = payload["after"].clone();
+            record["commit_message"] = head["message"].clone();
+            record["commit_timestamp"] = json::Value::from(parse_time(&head["timestamp"]));
+            record["commit_count"] = json::Value::from(payload["commits"].as_array().map(Vec::len));
+            record["url"] = payload["compare"].clone();
+            None // pushed now
+        }
+        "deployment" | "deployment_status" => {
+            let deployment = &payload["deployment"];
+            let status = &payload["deployment_status"];
+            record["event"] = json::Value::from("deployment");
+            record["deployment_id"] = deployment["id"].clone();
+            record["commit_sha"] = deployment["sha"].clone();
+            record["branch"] = deployment["ref"].clone();
+            if status.is_object() {
+                record["status"] = json::Value::from(normalize_status(
+                    status["state"].as_str().unwrap_or_default(),
+                ));
+                record["environment"] = status["environment"].clone();
+                record["url"] = match status["environment_url"].as_str() {
+                    Some(url) if !url.is_empty() => status["environment_url"].clone(),
+                    _ => status["target_url"].clone(),
+                };
+                parse_time(&status["updated_at"]).or_else(|| parse_time(&status["created_at"]))
+            } else {
+                record["status"] = json::Value::from("pending");
+                record["environment"] = deployment["environment"].clone();
+                parse_time(&deployment["created_at"])
+            }
+        }
+        "workflow_run" => {
+            let run = &payload["workflow_run"];
+            record["event"] = json::Value::from("pipeline");
+            record["pipeline_id"] = run["id"].clone();
+            record["pipeline_name"] = run["name"].clone();
+            record["branch"] = run["head_branch"].clone();
+            record["commit_sha"] = run["head_sha"].clone();
+            record["commit_message"] = run["head_commit"]["message"].clone();
+            record["commit_timestamp"] =
+                json::Value::from(parse_time(&run["head_commit"]["timestamp"]));
+            record["url"] = run["html_url"].clone();
+            record["status"] = json::Value::from(run_status(run));
+            let updated_at = parse_time(&run["updated_at"]);
+            if run["status"].as_str() == Some("completed") {
+                if let (Some(start), Some(end)) = (parse_time(&run["run_started_at"]), updated_at) {
+                    record["duration_seconds"] = json::Value::from((end - start) / 1_000_000);
+                }
+            }
+            updated_at
+        }
+        "workflow_job" => {
+            let job = &payload["workflow_job"];
+            record["event"] = json::Value::from("job");
+            record["pipeline_id"] = job["run_id"].clone();
+            record["pipeline_name"] = job["workflow_name"].clone();
+            record["job_name"] = job["name"].clone();
+            record["branch"] = job["head_branch"].clone();
+            record["commit_sha"] = job["head_sha"].clone();
+            record["url"] = job["html_url"].clone();
+            record["status"] = json::Value::from(run_status(job));
+            let completed_at = parse_time(&job["completed_at"]);
+            if let (Some(start), Some(end)) = (parse_time(&job["started_at"]), completed_at) {
+                record["duration_seconds"] = json::Value::from((end - start) / 1_000_000);
+            }
+            completed_at.or_else(|| parse_time(&job["started_at"]))
+        }
+        "pull_request" => {
+            let pr = &payload["pull_request"];
+            let merged = pr["merged"].as_bool() == Some(true);
+            if merged && payload["action"].as_str() == Some("closed") {
+                record["action"] = json::Value::from("merged");
+            }
+            record["number"] = pr["number"].clone();
+            record["title"] = pr["title"].clone();
+            record["url"] = pr["html_url"].clone();
+            record["branch"] = pr["head"]["ref"].clone();
+            record["target_branch"] = pr["base"]["ref"].clone();
+            record["commit_sha"] = if merged {
+                pr["merge_commit_sha"].clone()
+            } else {
+                pr["head"]["sha"].clone()
+            };
+            parse_time(&pr["updated_at"])
+        }
+        "release" => {
+            let release = &payload["release"];
+            record["tag"] = release["tag_name"].clone();
+            record["title"] = release["name"].clone();
+            record["url"] = release["html_url"].clone();
+            parse_time(&release["published_at"]).or_else(|| parse_time(&release["created_at"]))
+        }
+        _ => None,
+    };
+    Some(finish(record, ts))
+}
+
+/// Status of a workflow run or job, the conclusion once completed
+fn run_status(run: &json::Value) -> String {
+    let status = run["status"].as_str().unwrap_or_default();
+    if status == "completed" {
+        normalize_status(run["conclusion"].as_str().unwrap_or_default())
+    } else {
+        normalize_status(status)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_verify() {
+        let body = br#"{"zen":"Keep it logically awesome."}"#;
+        let signature = "sha256=bcf4aa51133ef9ab7d02f9da6e30ed7529389095a3c94d76a76608dfd05e2c49";
+        assert!(verify("s3cret", Some(signature), body));
+        assert!(!verify("other", Some(signature), body));
+        assert!(!verify("s3cret", None, body));
+        assert!(!verify("s3cret", Some("sha1=abc"), body));
+    }
+
+    #[test]
+    fn test_normalize_push() {
+        let payload = json::json!({
+            "ref": "refs/heads/main",
+            "after": "6113728f27ae82c7b1a177c8d03f9e96e0adf246",
+            "compare": "https://github.com/o/api/compare/a...b",
+            "commits": [{}, {}],
+            "head_commit": {
+                "message": "fix login",
+                "timestamp": "2024-01-02T03:04:05Z",
+            },
+            "repository": {"full_name": "o/api", "html_url": "https://github.com/o/api"},
+            "sender": {"login": "dev"},
+        });
+        let record = normalize("push", "d1", &payload).unwrap();
+        assert_eq!(record["event"], "push");
+        assert_eq!(record["branch"], "main");
+        assert_eq!(record["commit_count"], 2);
+        assert_eq!(record["commit_timestamp"], 1704164645000000_i64);
+        assert_eq!(record["repository"], "o/api");
+        assert!(record.get("action").is_none());
+        assert!(normalize("ping", "d2", &payload).is_none());
+    }
+
+    #[test]
+    fn test_normalize_deployment_status() {
+        let payload = json::json!({
+            "action": "created",
+            "deployment_status": {
+                "state": "success",
+                "environment": "production",
+                "target_url": "https://ci/1",
+                "updated_at": "2024-01-02T03:04:05Z",
+            },
+            "deployment": {"id": 42, "sha": "abc", "ref": "main"},
+            "repository": {"full_name": "o/api"},
+        });
+        let record = normalize("deployment_status", "d1", &payload).unwrap();
+        assert_eq!(record["event"], "deployment");
+        assert_eq!(record["status"], "success");
+        assert_eq!(record["environment"], "production");
+        assert_eq!(record["deployment_id"], 42);
+        assert_eq!(record["url"], "https://ci/1");
+        assert_eq!(record["_timestamp"], 1704164645000000_i64);
+    }
+
+    #[test]
+    fn test_normalize_workflow_run() {
+        let payload = json::json!({
+            "action": "completed",
+            "workflow_run": {
+                "id": 7,
+                "name": "CI",
+                "head_branch": "main",
+                "head_sha": "abc",
+                "status": "completed",
+                "conclusion": "failure",
+                "run_started_at": "2024-01-02T03:00:00Z",
+                "updated_at": "2024-01-02T03:04:05Z",
+            },
+        });
+        let record = normalize("workflow_run", "d1", &payload).unwrap();
+        assert_eq!(record["event"], "pipeline");
+        assert_eq!(record["status"], "failure");
+        assert_eq!(record["duration_seconds"], 245);
+    }
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+use config::utils::json;
+
+use super::{finish, normalize_status, parse_time};
+
+pub(super) const TOKEN_HEADER: &str = "X-Gitlab-Token";
+pub(super) const DELIVERY_HEADER: &str = "X-Gitlab-Event-UUID";
+
+const DELETED_SHA: &str = "0000000000000000000000000000000000000000";
+
+/// Compares the secret token of the delivery in constant time
+pub(super) fn verify(secret: &str, token: Option<&str>) -> bool {
+    let Some(token) = token else {
+        return false;
+    };
+    token.len() == secret.len()
+        && token
+            .bytes()
+            .zip(secret.bytes())
+            .fold(0u8, |acc, (a, b)| acc | (a ^ b))
+            == 0
+}
+
+/// Normalizes the payload according to its `object_kind`
+pub(super) fn normalize(delivery: &str, payload: &json::Value) -> Option<json::Value> {
+    let kind = payload["object_kind"].as_str()?;
+    let project = &payload["project"];
+    let attrs = &payload["object_attributes"];
+    let actor = match payload["user_username"].as_str() {
+        Some(_) => payload["user_username"].clone(),
+        None => payload["user"]["username"].clone(),
+    };
+    let mut record = json::json!({
+        "source": "gitlab",
+        "event": kind,
+        "repository": project["path_with_namespace"],
+        "repository_url": project["web_url"],
+        "actor": actor,
+        "delivery_id": delivery,
+    });
+    let ts = match kind {
+        "push" | "tag_push" => {
+            let git_ref = payload["ref"].as_str().unwrap_or_default();
+            match git_ref.strip_prefix("refs/tags/") {
+                Some(tag) => record["tag"] = json::Value::from(tag),
+                None => {
+                    let branch = git_ref.strip_prefix("refs/heads/").unwrap_or(git_ref);
+                    record["branch"] = json::Value::from(branch);
+                }
+            }
+            let sha = payload["checkout_sha"]
+                .as_str()
+                .or_else(|| payload["after"].as_str())
+                .unwrap_or_default();
+            if payload["after"].as_str() == Some(DELETED_SHA) {
+                record["action"] = json::Value::from("deleted");
+            }
+            let commits = payload["commits"].as_array();
+            if let Some(head) =
+                commits.and_then(|c| c.iter().find(|c| c["id"].as_str() == Some(sha)))
+            {
+                record["commit_message"] = head["message"].clone();
+                record["commit_timestamp"] = json::Value::from(parse_time(&head["timestamp"]));
+                record["url"] = head["url"].clone();
+            }
+            record["commit_sha"] = json::Value::from(sha);
+            record["commit_count"] = payload["total_commits_count"].clone();
+            None // pushed now
+        }
+        "pipeline" => {
+            let git_ref = attrs["ref"].clone();
+            if attrs["tag"].as_bool() == Some(true) {
+                record["tag"] = git_ref;
+            } else {
+                record["branch"] = git_ref;
+            }
+            record["pipeline_id"] = attrs["id"].clone();
+            record["pipeline_name"] = attrs["name"].clone();
+            record["commit_sha"] = attrs["sha"].clone();
+            record["commit_message"] = payload["commit"]["message"].clone();
+            record["commit_timestamp"] =
+                json::Value::from(parse_time(&payload["commit"]["timestamp"]));
+            record["status"] = json::Value::from(normalize_status(
+                attrs["status"].as_str().unwrap_or_default(),
+            ));
+            record["duration_seconds"] = attrs["duration"].clone();
+            record["url"] = attrs["url"].clone();
+            parse_time(&attrs["finished_at"]).or_else(|| parse_time(&attrs["created_at"]))
+        }
+        "build" => {
+            record["event"] = json::Value::from("job");
+            record["pipeline_id"] = payload["pipeline_id"].clone();
+            record["job_name"] = payload["build_name"].clone();
+            record["branch"] = payload["ref"].clone();
+            record["commit_sha"] = payload["sha"].clone();
+            record["status"] = json::Value::from(normalize_status(
+                payload["build_status"].as_str().unwrap_or_default(),
+            ));
+            if let Some(duration) = payload["build_duration"].as_f64() {
+                record["duration_seconds"] = json::Value::from(duration as i64);
+            }
+            parse_time(&payload["build_finished_at"])
+                .or_else(|| parse_time(&payload["build_started_at"]))
+                .or_else(|| parse_time(&payload["build_created_at"]))
+        }
+        "deployment" => {
+            record["deployment_id"] = payload["deployment_id"].clone();
+            record["status"] = json::Value::from(normalize_status(
+                payload["status"].as_str().unwrap_or_default(),
+            ));
+            record["environment"] = payload["environment"].clone();
+            record["branch"] = payload["ref"].clone();
+            // only the short sha is sent, the full one ends the commit url
+            record["commit_sha"] = match payload["commit_url"]
+                .as_str()
+                .and_then(|url| url.rsplit('/').next())
+            {
+                Some(sha) => json::Value::from(sha),
+                None => payload["short_sha"].clone(),
+            };
+            record["commit_message"] = payload["commit_title"].clone();
+            record["url"] = payload["deployable_url"].clone();
+            parse_time(&payload["status_changed_at"])
+        }
+        "merge_request" => {
+            record["event"] = json::Value::from("pull_request");
+            record["action"] = match attrs["action"].as_str() {
+                Some("open") => json::Value::from("opened"),
+                Some("close") => json::Value::from("closed"),
+                Some("reopen") => json::Value::from("reopened"),
+                Some("merge") => json::Value::from("merged"),
+                Some("update") => json::Value::from("updated"),
+                _ => attrs["action"].clone(),
+            };
+            record["number"] = attrs["iid"].clone();
+            record["title"] = attrs["title"].clone();
+            record["url"] = attrs["url"].clone();
+            record["branch"] = attrs["source_branch"].clone();
+            record["target_branch"] = attrs["target_branch"].clone();
+            record["commit_sha"] = match attrs["merge_commit_sha"].as_str() {
+                Some(_) => attrs["merge_commit_sha"].clone(),
+                None => attrs["last_commit"]["id"].clone(),
+            };
+            parse_time(&attrs["updated_at"])
+        }
+        "release" => {
+            record["action"] = payload["action"].clone();
+            record["tag"] = payload["tag"].clone();
+            record["title"] = payload["name"].clone();
+            record["url"] = payload["url"].clone();
+            parse_time(&payload["released_at"]).or_else(|| parse_time(&payload["created_at"]))
+        }
+        _ => None,
+    };
+    Some(finish(record, ts))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_verify() {
+        assert!(verify("s3cret", Some("s3cret")));
+        assert!(!verify("s3cret", Some("s3cres")));
+        assert!(!verify("s3cret", Some("s3cret2")));
+        assert!(!verify("s3cret", None));
+    }
+
+    #[test]
+    fn test_normalize_pipeline() {
+        let payload = json::json!({
+            "object_kind": "pipeline",
+            "object_attributes": {
+                "id": 31,
+                "ref": "main",
+                "tag": false,
+                "sha": "bcbb5ec396a2c0f828686f14fac9b80b780504f2",
+                "status": "failed",
+                "duration": 63,
+                "created_at": "2024-01-02 03:03:02 UTC",
+                "finished_at": "2024-01-02 03:04:05 UTC",
+            },
+            "user": {"username": "dev"},
+            "project": {"path_with_namespace": "o/api", "web_url": "https://gitlab.com/o/api"},
+            "commit": {"message": "fix login", "timestamp": "2024-01-02T03:00:00+00:00"},
+        });
+        let record = normalize("u1", &payload).unwrap();
+        assert_eq!(record["event"], "pipeline");
+        assert_eq!(record["status"], "failure");
+        assert_eq!(record["branch"], "main");
+        assert_eq!(record["actor"], "dev");
+        assert_eq!(record["duration_seconds"], 63);
+        assert_eq!(record["_timestamp"], 1704164645000000_i64);
+        assert_eq!(record["commit_timestamp"], 1704164400000000_i64);
+    }
+
+    #[test]
+    fn test_normalize_deployment() {
+        let payload = json::json!({
+            "object_kind": "deployment",
+            "status": "success",
+            "status_changed_at": "2024-01-02 03:04:05 +0000",
+            "deployment_id": 15,
+            "environment": "production",
+            "short_sha": "279484c0",
+            "commit_url": "https://gitlab.com/o/api/-/commit/279484c09fbe69ededfced8c1bb6e6d24616b468",
+            "commit_title": "Add README",
+            "ref": "main",
+            "user": {"username": "dev"},
+            "project": {"path_with_namespace": "o/api"},
+        });
+        let record = normalize("u1", &payload).unwrap();
+        assert_eq!(record["event"], "deployment");
+        assert_eq!(record["status"], "success");
+        assert_eq!(
+            record["commit_sha"],
+            "279484c09fbe69ededfced8c1bb6e6d24616b468"
+        );
+        assert_eq!(record["_timestamp"], 1704164645000000_i64);
+        assert!(normalize("u1", &json::json!({})).is_none());
+    }
+
+    #[test]
+    fn test_normalize_merge_request() {
+        let payload = json::json!({
+            "object_kind": "merge_request",
+            "user": {"username": "dev"},
+            "object_attributes": {
+                "iid": 1,
+                "title": "Fix login",
+                "action": "merge",
+                "source_branch": "fix",
+                "target_branch": "main",
+                "merge_commit_sha": "abc",
+                "updated_at": "2024-01-02 03:04:05 UTC",
+            },
+        });
+        let record = normalize("u1", &payload).unwrap();
+        assert_eq!(record["event"], "pull_request");
+        assert_eq!(record["action"], "merged");
+        assert_eq!(record["commit_sha"], "abc");
+        assert_eq!(record["number"], 1);
+    }
+}
@@ -0,0 +1,283 @@
+// Copyright 2024 Zinc Labs Inc.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//! Ingestion of the repository and CI/CD events sent by the webhooks of GitHub
+//! and GitLab. The events are normalized into the same fields for both
+//! providers, so the deployment markers and the DORA metrics dashboards only
+//! look at:
+//!
+//! - `event`: push, tag_push, pull_request, pipeline, job, deployment, release or the event name of
+//!   the provider
+//! - `status`: success, failure, canceled, running, pending or skipped
+//! - `repository`, `branch`, `tag`, `commit_sha`, `commit_timestamp`, `environment`, `pipeline_id`,
+//!   `deployment_id`, `duration_seconds`...
+
+use std::io::Error;
+
+use actix_web::{
+    http::{self, header::HeaderMap},
+    web, HttpResponse,
+};
+use chrono::{DateTime, Utc};
+use config::utils::{
+    json,
+    time::{parse_str_to_timestamp_micros, parse_timestamp_micro_from_value},
+};
+
+use crate::{
+    common::meta::{
+        http::HttpResponse as MetaHttpResponse,
+        ingestion::IngestionRequest,
+        webhook::{WebhookProvider, WebhookSource, WebhookSourceList, SECRET_MASK},
+    },
+    service::{db, logs},
+};
+
+mod github;
+mod gitlab;
+
+#[tracing::instrument(skip(source))]
+pub async fn save_source(
+    org_id: &str,
+    user_email: &str,
+    mut source: WebhookSource,
+    create: bool,
+) -> Result<HttpResponse, Error> {
+    source.name = source.name.trim().to_string();
+    let stored = db::webhook::get(org_id, &source.name).await.ok();
+    if create && stored.is_some() {
+        return Ok(MetaHttpResponse::bad_request(format!(
+            "Webhook {} already exists",
+            source.name
+        )));
+    }
+    let now = Utc::now().timestamp_micros();
+    match stored {
+        Some(stored) => {
+            if source.secret == SECRET_MASK {
+                source.secret = stored.secret;
+            }
+            source.owner = stored.owner;
+            source.created_at = stored.created_at;
+        }
+        None if !create => return Ok(MetaHttpResponse::not_found("Webhook not found")),
+        None => {
+            source.owner = user_email.to_string();
+            source.created_at = now;
+        }
+    }
+    source.updated_at = now;
+    if let Err(e) = source.validate() {
+        return Ok(MetaHttpResponse::bad_request(e));
+    }
+    match db::webhook::set(org_id, &source).await {
+        Ok(_) => {
+            source.secret = SECRET_MASK.to_string();
+            Ok(MetaHttpResponse::json(source))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn list_sources(org_id: &str) -> Result<HttpResponse, Error> {
+    match db::webhook::list(org_id).await {
+        Ok(mut list) => {
+            list.iter_mut()
+                .for_each(|source| source.secret = SECRET_MASK.to_string());
+            Ok(MetaHttpResponse::json(WebhookSourceList { list }))
+        }
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+#[tracing::instrument]
+pub async fn get_source(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    match db::webhook::get(org_id, name).await {
+        Ok(mut source) => {
+            source.secret = SECRET_MASK.to_string();
+            Ok(MetaHttpResponse::json(source))
+        }
+        Err(_) => Ok(MetaHttpResponse::not_found("Webhook not found")),
+    }
+}
+
+#[tracing::instrument]
+pub async fn delete_source(org_id: &str, name: &str) -> Result<HttpResponse, Error> {
+    if db::webhook::get(org_id, name).await.is_err() {
+        return Ok(MetaHttpResponse::not_found("Webhook not found"));
+    }
+    match db::webhook::delete(org_id, name).await {
+        Ok(_) => Ok(MetaHttpResponse::ok("Webhook deleted")),
+        Err(e) => Ok(MetaHttpResponse::internal_error(e)),
+    }
+}
+
+/// Validates the signature of the delivery and writes the normalized event to
+/// the stream of the webhook
+#[tracing::instrument(skip(headers, body))]
+pub async fn handle_event(
+    org_id: &str,
+    name: &str,
+    headers: &HeaderMap,
+    body: web::Bytes,
+) -> Result<HttpResponse, Error> {
+    let source = match db::webhook::get(org_id, name).await {
+        Ok(source) => source,
+        Err(_) => return Ok(MetaHttpResponse::not_found("Webhook not found")),
+    };
+    if !source.enabled {
+        return Ok(MetaHttpResponse::forbidden("Webhook is disabled"));
+    }
+    let header = |name: &str| headers.get(name).and_then(|v| v.to_str().ok());
+    let verified = match source.provider {
+        WebhookProvider::Github => {
+            github::verify(&source.secret, header(github::SIGNATURE_HEADER), &body)
+        }
+        WebhookProvider::Gitlab => gitlab::verify(&source.secret, header(gitlab::TOKEN_HEADER)),
+    };
+    if !verified {
+        return Ok(HttpResponse::Unauthorized().json(MetaHttpResponse::error(
+            http::StatusCode::UNAUTHORIZED.into(),
+            "Invalid webhook signature".to_string(),
+        )));
+    }
+    let payload: json::Value = match json::from_slice(&body) {
+        Ok(payload) => payload,
+        Err(e) => return Ok(MetaHttpResponse::bad_request(e)),
+    };
+    let event = match source.provider {
+        WebhookProvider::Github => github::normalize(
+            header(github::EVENT_HEADER).unwrap_or_default(),
+            header(github::DELIVERY_HEADER).unwrap_or_default(),
+            &payload,
+        ),
+        WebhookProvider::Gitlab => gitlab::normalize(
+            header(gitlab::DELIVERY_HEADER).unwrap_or_default(),
+            &payload,
+        ),
+    };
+    let Some(mut record) = event else {
+        return Ok(MetaHttpResponse::ok("Event ignored"));
+    };
+    if source.keep_payload {
+        record["payload"] = json::Value::from(String::from_utf8_lossy(&body));
+    }
+
+    let data = web::Bytes::from(json::to_vec(&[record]).unwrap());
+    match logs::ingest::ingest(
+        org_id,
+        &source.stream_name,
+        IngestionRequest::JSON(&data),
+        &source.owner,
+        false,
+    )
+    .await
+    {
+        Ok(v) => Ok(MetaHttpResponse::json(v)),
+        Err(e) => {
+            log::error!("[WEBHOOK] {org_id}/{name} write event error: {e}");
+            Ok(MetaHttpResponse::bad_request(e))
+        }
+    }
+}
+
+/// Maps the states of the runs, jobs and deployments of the providers to the
+/// same values
+fn normalize_status(status: &str) -> String {
+    let status = status.to_lowercase();
+    match status.as_str() {
+        "success" | "succeeded" => "success",
+        "failure" | "failed" | "error" | "timed_out" | "startup_failure" => "failure",
+        "cancelled" | "canceled" => "canceled",
+        "in_progress" | "running" => "running",
+        "queued"
+        | "pending"
+        | "created"
+        | "requested"
+        | "waiting"
+        | "waiting_for_resource"
+        | "preparing"
+        | "scheduled" => "pending",
+        "skipped" | "neutral" | "stale" => "skipped",
+        _ => return status,
+    }
+    .to_string()
+}
+
+/// Parses the times of the payloads in microseconds, GitLab sends them either
+/// as RFC 3339 or as `2024-01-02 03:04:05 UTC`
+fn parse_time(value: &json::Value) -> Option<i64> {
+    match value {
+        json::Value::String(s) => {
+            let s = s.trim_end_matches(" UTC");
+            parse_str_to_timestamp_micros(s).ok().or_else(|| {
+                DateTime::parse_from_str(s, "%Y-%m-%d %H:%M:%S %z")
+                    .ok()
+                    .map(|t| t.timestamp_micros())
+            })
+        }
+        json::Value::Number(_) => parse_timestamp_micro_from_value(value).ok(),
+        _ => None,
+    }
+}
+
+/// Sets the timestamp of the record and drops the missing fields
+fn finish(mut record: json::Value, ts: Option<i64>) -> json::Value {
+    if let Some(map) = record.as_object_mut() {
+        map.retain(|_, v| !v.is_null() && v.as_str() != Some(""));
+        map.insert(
+            "_timestamp".to_string(),
+            json::Value::from(ts.unwrap_or_else(|| Utc::now().timestamp_micros())),
+        );
+    }
+    record
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_status() {
+        assert_eq!(normalize_status("SUCCESS"), "success");
+        assert_eq!(normalize_status("timed_out"), "failure");
+        assert_eq!(normalize_status("cancelled"), "canceled");
+        assert_eq!(normalize_status("waiting_for_resource"), "pending");
+        assert_eq!(normalize_status("manual"), "manual");
+    }
+
+    #[test]
+    fn test_parse_time() {
+        let ts = 1704164645000000;
+        assert_eq!(parse_time(&json::json!("2024-01-02T03:04:05Z")), Some(ts));
+        assert_eq!(
+            parse_time(&json::json!("2024-01-02 03:04:05 UTC")),
+            Some(ts)
+        );
+        assert_eq!(
+            parse_time(&json::json!("2024-01-02 04:04:05 +0100")),
+            Some(ts)
+        );
+        assert_eq!(parse_time(&json::json!(1704164645)), Some(ts));
+        assert_eq!(parse_time(&json::Value::Null), None);
+    }
+
+    #[test]
+    fn test_finish() {
+        let record = finish(json::json!({"a": "x", "b": "", "c": null}), Some(1));
+        assert_eq!(record, json::json!({"a": "x", "_timestamp": 1}));
+    }
+}
@@ -24,6 +24,7 @@ import {
 } from "vue";
 import queryService from "../../services/search";
 import { useStore } from "vuex";
+import { useRoute } from "vue-router";
 import { addLabelToPromQlQuery } from "@/utils/query/promQLUtils";
 import { addLabelsToSQlQuery } from "@/utils/query/sqlUtils";
 import { getStreamFromQuery } from "@/utils/query/sqlUtils";
@@ -47,6 +48,8 @@ export const usePanelDataLoader = (
     // }
   };
 
+  const route = useRoute();
+
   const state = reactive({
     data: [] as any,
     loading: false,
@@ -314,6 +317,11 @@ export const usePanelDataLoader = (
                       end_time: endISOTimestamp,
                       size: -1,
                     },
+                    // attributes the cost of the query to the panel in usage
+                    search_event_context: {
+                      dashboard_id: route?.query?.dashboard,
+                      panel_id: panelSchema.value.id,
+                    },
                   },
                   page_type: pageType,
                 },
@@ -14,6 +14,14 @@
 // along with this program.  If not, see <http://www.gnu.org/licenses/>.
 
 import { date } from "quasar";
+import store from "@/stores";
+
+// Returns the now of the relative time ranges, the time pinned by the mock
+// clock of the server when the UI tests set one, see ZO_MOCK_CLOCK_ENABLED
+export const getQueryNow = () => {
+  const mockNow = store.state.zoConfig?.mock_now;
+  return mockNow ? new Date(mockNow / 1000) : new Date();
+};
 
 // Parses the duration string and returns the number is seconds
 export const parseDuration = (durationString: string) => {
@@ -211,7 +219,7 @@ export const getConsumableRelativeTime = (period: string) => {
       periodValue +
       "}";
 
-    const endTimeStamp = new Date();
+    const endTimeStamp = getQueryNow();
 
     const startTimeStamp = date.subtractFromDate(
       endTimeStamp,